@@ -0,0 +1,34 @@
+"""Python client for the aul HTTP API.
+
+    import aul_client
+
+    client = aul_client.connect("http://localhost:8080", token="...")
+    orders = client.call_procedure("dbo.GetOrders", CustomerID=42).to_pandas()
+
+    with client.stream_procedure("dbo.ExportOrders", Year=2024) as stream:
+        for rs in stream:
+            for df in rs.iter_pandas(chunk_size=50000):
+                ...
+"""
+
+from .client import (
+    AulError,
+    Client,
+    Result,
+    ResultSet,
+    Stream,
+    StreamedResultSet,
+    connect,
+)
+
+__all__ = [
+    "AulError",
+    "Client",
+    "Result",
+    "ResultSet",
+    "Stream",
+    "StreamedResultSet",
+    "connect",
+]
+
+__version__ = "0.6.4"
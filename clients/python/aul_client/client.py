@@ -0,0 +1,331 @@
+"""A thin client for the aul HTTP API.
+
+Only the standard library is needed; pandas is used when it is installed
+and a result is asked for as a DataFrame.
+"""
+
+import json
+import urllib.error
+import urllib.parse
+import urllib.request
+
+from . import _api
+
+NDJSON = "application/x-ndjson"
+
+
+class AulError(Exception):
+    """An error reported by the server.
+
+    status is the HTTP status, request_id the ID the server logged the
+    request under, and retry_after the seconds to wait before retrying a
+    request refused because the server was busy (else None).
+    """
+
+    def __init__(self, message, status=None, request_id=None, retry_after=None):
+        super().__init__(message)
+        self.status = status
+        self.request_id = request_id
+        self.retry_after = retry_after
+
+    def __str__(self):
+        text = super().__str__()
+        if self.request_id:
+            text += " (request ID %s)" % self.request_id
+        return text
+
+
+class ResultSet:
+    """The rows of one result set.
+
+    Iterating gives each row as a list. pandas.DataFrame(rs.records()) and
+    rs.to_pandas() both give a DataFrame.
+    """
+
+    def __init__(self, columns, rows, types=None):
+        self.columns = list(columns)
+        self.rows = rows
+        self.types = types
+
+    def __iter__(self):
+        return iter(self.rows)
+
+    def __len__(self):
+        return len(self.rows)
+
+    def __repr__(self):
+        return "<ResultSet columns=%r rows=%d>" % (self.columns, len(self.rows))
+
+    def records(self):
+        """Return the rows as a list of dicts keyed by column name."""
+        return [dict(zip(self.columns, row)) for row in self.rows]
+
+    def to_pandas(self):
+        """Return the rows as a pandas DataFrame."""
+        import pandas
+
+        return pandas.DataFrame.from_records(self.rows, columns=self.columns)
+
+
+class Result:
+    """The outcome of a request: its result sets, in order, the values of
+    OUTPUT parameters and the server's messages."""
+
+    def __init__(self, response, request_id=None):
+        self.result_sets = [
+            ResultSet(rs.get("columns") or [], rs.get("rows") or [])
+            for rs in response.get("results") or []
+        ]
+        self.output_params = response.get("output_params") or {}
+        self.rows_affected = response.get("rows_affected", 0)
+        self.message = response.get("message", "")
+        self.warnings = response.get("warnings") or []
+        self.request_id = request_id
+
+    def __iter__(self):
+        return iter(self.result_sets)
+
+    def __len__(self):
+        return len(self.result_sets)
+
+    def __getitem__(self, i):
+        return self.result_sets[i]
+
+    def __repr__(self):
+        return "<Result result_sets=%d rows_affected=%d>" % (
+            len(self.result_sets),
+            self.rows_affected,
+        )
+
+    def to_pandas(self, index=0):
+        """Return result set index (the first by default) as a DataFrame."""
+        return self.result_sets[index].to_pandas()
+
+
+class StreamedResultSet:
+    """A result set whose rows are read from the server as they are
+    iterated. The rows can be iterated once, before the next result set."""
+
+    def __init__(self, stream, columns, types):
+        self._stream = stream
+        self.columns = columns
+        self.types = types
+        self._done = False
+
+    def __iter__(self):
+        while not self._done:
+            doc = self._stream._next()
+            if "row" in doc:
+                yield doc["row"]
+                continue
+            self._done = True
+            self._stream._pending = doc
+
+    def records(self):
+        """Yield each row as a dict keyed by column name."""
+        for row in self:
+            yield dict(zip(self.columns, row))
+
+    def iter_pandas(self, chunk_size=10000):
+        """Yield the rows as DataFrames of up to chunk_size rows."""
+        import pandas
+
+        chunk = []
+        for row in self:
+            chunk.append(row)
+            if len(chunk) == chunk_size:
+                yield pandas.DataFrame.from_records(chunk, columns=self.columns)
+                chunk = []
+        if chunk:
+            yield pandas.DataFrame.from_records(chunk, columns=self.columns)
+
+    def _drain(self):
+        for _ in self:
+            pass
+
+
+class Stream:
+    """A streamed result. Iterating gives each StreamedResultSet; once
+    they are read, output_params, rows_affected, message and warnings are
+    set. Close the stream, or use it in a with statement, to release the
+    connection early."""
+
+    def __init__(self, response, request_id):
+        self._response = response
+        self._pending = None
+        self._current = None
+        self.request_id = request_id
+        self.output_params = {}
+        self.rows_affected = 0
+        self.message = ""
+        self.warnings = []
+        self.done = False
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        self.close()
+
+    def close(self):
+        self._response.close()
+
+    def __iter__(self):
+        while not self.done:
+            if self._current is not None:
+                self._current._drain()
+            doc = self._pending or self._next()
+            self._pending = None
+            if "columns" in doc:
+                self._current = StreamedResultSet(self, doc["columns"], doc.get("types"))
+                yield self._current
+                continue
+            self._current = None
+            self._finish(doc)
+
+    def _next(self):
+        line = self._response.readline()
+        while line and not line.strip():
+            line = self._response.readline()
+        if not line:
+            raise AulError("stream ended early", request_id=self.request_id)
+        return json.loads(line)
+
+    def _finish(self, doc):
+        self.done = True
+        self.close()
+        if not doc.get("success", False):
+            raise AulError(
+                doc.get("error", "request failed"),
+                request_id=doc.get("request_id") or self.request_id,
+            )
+        self.output_params = doc.get("output_params") or {}
+        self.rows_affected = doc.get("rows_affected", 0)
+        self.message = doc.get("message", "")
+        self.warnings = doc.get("warnings") or []
+
+
+class Client:
+    """A connection to an aul server's HTTP API.
+
+    token is sent as a bearer token, for servers run with
+    --http-token-file. timeout is the socket timeout in seconds.
+    """
+
+    def __init__(self, url="http://localhost:8080", token=None, timeout=60):
+        self.url = url.rstrip("/")
+        self.token = token
+        self.timeout = timeout
+
+    def call_procedure(self, name, timeout=None, **params):
+        """Call procedure name with params and return its Result.
+
+        Parameter names may be given without their @. timeout, in
+        seconds, is the server's execution limit.
+        """
+        return self._execute("exec", _body(name, None, params, timeout))
+
+    def query(self, sql, timeout=None, **params):
+        """Run a batch of SQL and return its Result."""
+        return self._execute("query", _body(None, sql, params, timeout))
+
+    def stream_procedure(self, name, timeout=None, **params):
+        """Call procedure name, returning a Stream of its rows as they
+        are read instead of waiting for the whole result."""
+        return self._stream("exec", _body(name, None, params, timeout))
+
+    def stream_query(self, sql, timeout=None, **params):
+        """Run a batch of SQL, returning a Stream of its rows."""
+        return self._stream("query", _body(None, sql, params, timeout))
+
+    def procedures(self):
+        """Return the names of the registered procedures."""
+        return self._request("listProcedures")[0].get("procedures") or []
+
+    def health(self):
+        """Return the server's health report."""
+        return self._request("health")[0]
+
+    def _execute(self, operation, body):
+        response, request_id = self._request(operation, body)
+        return Result(response, request_id)
+
+    def _stream(self, operation, body):
+        response = self._open(operation, body, accept=NDJSON)
+        return Stream(response, response.headers.get("X-Request-ID"))
+
+    def _request(self, operation, body=None):
+        with self._open(operation, body) as response:
+            return json.load(response), response.headers.get("X-Request-ID")
+
+    def _open(self, operation, body=None, accept="application/json"):
+        method, path = _api.OPERATIONS[operation]
+        headers = {"Accept": accept}
+        data = None
+        if body is not None:
+            data = json.dumps(body, default=_encode).encode("utf-8")
+            headers["Content-Type"] = "application/json"
+        if self.token:
+            headers["Authorization"] = "Bearer " + self.token
+        req = urllib.request.Request(self.url + path, data=data, headers=headers, method=method)
+        try:
+            return urllib.request.urlopen(req, timeout=self.timeout)
+        except urllib.error.HTTPError as e:
+            raise _http_error(e) from None
+
+
+def connect(url="http://localhost:8080", token=None, timeout=60):
+    """Return a Client for the server at url."""
+    return Client(url, token=token, timeout=timeout)
+
+
+def _body(procedure, sql, params, timeout):
+    body = {}
+    if procedure is not None:
+        body["procedure"] = procedure
+    if sql is not None:
+        body["sql"] = sql
+    if params:
+        body["parameters"] = {
+            (k if k.startswith("@") else "@" + k): v for k, v in params.items()
+        }
+    if timeout is not None:
+        body["timeout"] = "%gs" % timeout
+    return body
+
+
+def _encode(value):
+    """Encode the values json cannot: dates, decimals, UUIDs and bytes."""
+    if hasattr(value, "isoformat"):
+        return value.isoformat()
+    if isinstance(value, (bytes, bytearray)):
+        return "0x" + bytes(value).hex()
+    return str(value)
+
+
+def _http_error(e):
+    """Turn an HTTP error response into an AulError."""
+    request_id = e.headers.get("X-Request-ID")
+    retry_after = e.headers.get("Retry-After")
+    message = "HTTP %d" % e.code
+    try:
+        text = e.read().decode("utf-8", "replace")
+    except OSError:
+        text = ""
+    if text:
+        message = text.strip()
+        for line in reversed(text.strip().splitlines()):
+            try:
+                doc = json.loads(line)
+            except ValueError:
+                break
+            if isinstance(doc, dict) and "error" in doc:
+                message = doc["error"]
+                request_id = doc.get("request_id") or request_id
+                break
+    return AulError(
+        message,
+        status=e.code,
+        request_id=request_id,
+        retry_after=int(retry_after) if retry_after and retry_after.isdigit() else None,
+    )
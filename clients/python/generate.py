@@ -0,0 +1,91 @@
+#!/usr/bin/env python3
+"""Generate aul_client/_api.py from the server's OpenAPI description.
+
+The generated module holds the API's operations and a TypedDict per
+schema; the hand-written client in client.py is built on it. Run it after
+changing pkg/protocol/http/openapi.json:
+
+    python3 clients/python/generate.py
+"""
+
+import json
+import keyword
+import os
+import sys
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+SPEC = os.path.join(HERE, "..", "..", "pkg", "protocol", "http", "openapi.json")
+OUT = os.path.join(HERE, "aul_client", "_api.py")
+
+TYPES = {
+    "string": "str",
+    "integer": "int",
+    "number": "float",
+    "boolean": "bool",
+}
+
+
+def py_type(schema):
+    """Return the Python annotation for a JSON schema."""
+    if "$ref" in schema:
+        return '"%s"' % schema["$ref"].rsplit("/", 1)[1]
+    kind = schema.get("type")
+    if kind == "array":
+        return "List[%s]" % py_type(schema.get("items", {}))
+    if kind == "object":
+        extra = schema.get("additionalProperties")
+        if isinstance(extra, dict) and extra:
+            return "Dict[str, %s]" % py_type(extra)
+        return "Dict[str, Any]"
+    return TYPES.get(kind, "Any")
+
+
+def generate(spec):
+    lines = [
+        "# Code generated by generate.py from openapi.json. DO NOT EDIT.",
+        "",
+        '"""Operations and schemas of the aul HTTP API (spec version %s)."""'
+        % spec["info"]["version"],
+        "",
+        "from typing import Any, Dict, List",
+        "",
+        "try:",
+        "    from typing import TypedDict",
+        "except ImportError:  # Python < 3.8",
+        "    TypedDict = dict",
+        "",
+        "# operationId -> (method, path)",
+        "OPERATIONS = {",
+    ]
+    for path, item in spec["paths"].items():
+        for method, op in item.items():
+            lines.append('    "%s": ("%s", "%s"),' % (op["operationId"], method.upper(), path))
+    lines += ["}", ""]
+
+    for name, schema in spec["components"]["schemas"].items():
+        lines += ["", 'class %s(TypedDict, total=False):' % name]
+        doc = schema.get("description")
+        if doc:
+            lines.append('    """%s"""' % doc)
+        props = schema.get("properties", {})
+        if not props:
+            lines.append("    pass")
+        for prop, sub in props.items():
+            if keyword.iskeyword(prop) or not prop.isidentifier():
+                continue
+            lines.append("    %s: %s" % (prop, py_type(sub)))
+        lines.append("")
+    return "\n".join(lines)
+
+
+def main():
+    with open(SPEC) as f:
+        spec = json.load(f)
+    with open(OUT, "w") as f:
+        f.write(generate(spec))
+    print("wrote", os.path.relpath(OUT))
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -0,0 +1,133 @@
+import json
+import threading
+import unittest
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+import aul_client
+
+TOKEN = "s3cret"
+
+
+class FakeServer(BaseHTTPRequestHandler):
+    """Answers as aul's HTTP API does, recording the last request body."""
+
+    last_body = None
+
+    def log_message(self, *args):
+        pass
+
+    def do_GET(self):
+        if self.path == "/procedures":
+            self.reply(200, {"procedures": ["dbo.List"]})
+        else:
+            self.reply(200, {"status": "ok", "server": "aul"})
+
+    def do_POST(self):
+        if self.headers.get("Authorization") != "Bearer " + TOKEN:
+            self.send_response(401)
+            self.end_headers()
+            return
+        body = json.loads(self.rfile.read(int(self.headers["Content-Length"])))
+        FakeServer.last_body = body
+        if body.get("procedure") == "dbo.Fail":
+            self.reply(500, {"success": False, "error": "boom", "request_id": "r-1"})
+            return
+        if body.get("procedure") == "dbo.Busy":
+            self.reply(503, {"success": False, "error": "busy"}, {"Retry-After": "1"})
+            return
+        columns = ["id", "name"]
+        rows = [[1, "a"], [2, "b"], [3, "c"]]
+        if "application/x-ndjson" in self.headers.get("Accept", ""):
+            lines = [{"columns": columns, "types": ["int", "nvarchar"]}]
+            lines += [{"row": r} for r in rows]
+            lines += [{"columns": ["n"]}, {"row": [7]}]
+            lines += [{"success": True, "output_params": {"@Count": 3}}]
+            data = "".join(json.dumps(l) + "\n" for l in lines).encode()
+            self.send_response(200)
+            self.send_header("Content-Type", "application/x-ndjson")
+            self.send_header("Content-Length", str(len(data)))
+            self.end_headers()
+            self.wfile.write(data)
+            return
+        self.reply(200, {
+            "success": True,
+            "results": [{"columns": columns, "rows": rows}],
+            "output_params": {"@Count": 3},
+        }, {"X-Request-ID": "r-2"})
+
+    def reply(self, status, doc, headers=None):
+        data = json.dumps(doc).encode()
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(data)))
+        for k, v in (headers or {}).items():
+            self.send_header(k, v)
+        self.end_headers()
+        self.wfile.write(data)
+
+
+class ClientTest(unittest.TestCase):
+    @classmethod
+    def setUpClass(cls):
+        cls.server = HTTPServer(("127.0.0.1", 0), FakeServer)
+        threading.Thread(target=cls.server.serve_forever, daemon=True).start()
+        url = "http://127.0.0.1:%d" % cls.server.server_port
+        cls.client = aul_client.connect(url, token=TOKEN)
+        cls.anonymous = aul_client.connect(url)
+
+    @classmethod
+    def tearDownClass(cls):
+        cls.server.shutdown()
+
+    def test_call_procedure(self):
+        result = self.client.call_procedure("dbo.List", Region="EU", timeout=5)
+        self.assertEqual(
+            FakeServer.last_body,
+            {"procedure": "dbo.List", "parameters": {"@Region": "EU"}, "timeout": "5s"},
+        )
+        self.assertEqual(len(result), 1)
+        self.assertEqual(result[0].columns, ["id", "name"])
+        self.assertEqual(result[0].records()[1], {"id": 2, "name": "b"})
+        self.assertEqual(result.output_params, {"@Count": 3})
+        self.assertEqual(result.request_id, "r-2")
+
+    def test_query(self):
+        self.client.query("SELECT @n", n=1)
+        self.assertEqual(FakeServer.last_body, {"sql": "SELECT @n", "parameters": {"@n": 1}})
+
+    def test_errors(self):
+        with self.assertRaises(aul_client.AulError) as cm:
+            self.client.call_procedure("dbo.Fail")
+        self.assertEqual((str(cm.exception), cm.exception.status), ("boom (request ID r-1)", 500))
+
+        with self.assertRaises(aul_client.AulError) as cm:
+            self.client.call_procedure("dbo.Busy")
+        self.assertEqual(cm.exception.retry_after, 1)
+
+        with self.assertRaises(aul_client.AulError) as cm:
+            self.anonymous.call_procedure("dbo.List")
+        self.assertEqual(cm.exception.status, 401)
+
+    def test_stream(self):
+        with self.client.stream_procedure("dbo.List") as stream:
+            sets = []
+            for rs in stream:
+                sets.append((rs.columns, list(rs)))
+        self.assertEqual(sets, [
+            (["id", "name"], [[1, "a"], [2, "b"], [3, "c"]]),
+            (["n"], [[7]]),
+        ])
+        self.assertEqual(stream.output_params, {"@Count": 3})
+
+    def test_stream_skips_unread_rows(self):
+        stream = self.client.stream_query("SELECT 1")
+        columns = [rs.columns for rs in stream]
+        self.assertEqual(columns, [["id", "name"], ["n"]])
+        self.assertTrue(stream.done)
+
+    def test_procedures(self):
+        self.assertEqual(self.client.procedures(), ["dbo.List"])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/server"
+)
+
+// fileFlags sets flags from a configuration file, except those given on
+// the command line.
+type fileFlags struct {
+	fs    *flag.FlagSet
+	given map[string]bool
+	err   error
+}
+
+// setFlag sets the first of names, the flag whose variable is read, to
+// value unless value is nil or any of names was given on the command line.
+func setFlag[T any](f *fileFlags, value *T, names ...string) {
+	if value == nil || f.err != nil {
+		return
+	}
+	for _, name := range names {
+		if f.given[name] {
+			return
+		}
+	}
+	if err := f.fs.Set(names[0], fmt.Sprint(*value)); err != nil {
+		f.err = fmt.Errorf("%s: %w", names[0], err)
+	}
+}
+
+// loadConfigFile reads a configuration file into cfg and fs. Settings that
+// have a flag stand in for the flag's default, so that flags given on the
+// command line override them; listeners, storage options and tenants go
+// straight into cfg. Listeners enabled by port flags are added to the
+// file's, and replace the port of one with the same name.
+func loadConfigFile(path string, fs *flag.FlagSet, cfg *server.Config) error {
+	file, err := server.LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	f := &fileFlags{fs: fs, given: make(map[string]bool)}
+	fs.Visit(func(fl *flag.Flag) { f.given[fl.Name] = true })
+
+	if s := file.Server; s != nil {
+		setFlag(f, s.Name, "server-name")
+		setFlag(f, s.ProcDir, "d", "proc-dir")
+		setFlag(f, s.WatchChanges, "w", "watch")
+		setFlag(f, s.ProcLoadWorkers, "proc-load-workers")
+		setFlag(f, s.Strict, "strict")
+		setFlag(f, s.InitDir, "init-dir")
+	}
+	if r := file.Runtime; r != nil {
+		setFlag(f, r.Dialect, "dialect")
+		setFlag(f, r.MaxConcurrency, "max-conns")
+		setFlag(f, r.ExecTimeout, "exec-timeout")
+		setFlag(f, r.MaxNestingLevel, "max-nesting-level")
+		setFlag(f, r.NumbersSize, "numbers-size")
+		setFlag(f, r.AutoParameterize, "auto-parameterize")
+		setFlag(f, r.ResultContracts, "result-contracts")
+		setFlag(f, r.StatementSummary, "statement-summary")
+		setFlag(f, r.StreamResults, "stream-results")
+		setFlag(f, r.ReadOnly, "read-only")
+		if len(r.PinTables) > 0 {
+			tables := strings.Join(r.PinTables, ",")
+			setFlag(f, &tables, "pin-tables")
+		}
+		setFlag(f, r.PinMaxRows, "pin-max-rows")
+		setFlag(f, r.PinRefresh, "pin-refresh")
+	}
+	if j := file.JIT; j != nil {
+		setFlag(f, j.Enabled, "jit")
+		setFlag(f, j.Threshold, "jit-threshold")
+	}
+	if s := file.Storage; s != nil {
+		setFlag(f, s.Type, "storage")
+		setFlag(f, s.Path, "storage-path")
+		if len(s.Plugins) > 0 {
+			plugins := strings.Join(s.Plugins, ",")
+			setFlag(f, &plugins, "storage-plugin")
+		}
+		if cfg.StorageConfig.Options == nil {
+			cfg.StorageConfig.Options = make(map[string]string)
+		}
+		for name, value := range s.Options {
+			cfg.StorageConfig.Options[name] = value
+		}
+		if s.JournalMode != "" {
+			cfg.StorageConfig.Options["journal_mode"] = s.JournalMode
+		}
+		if s.Synchronous != "" {
+			cfg.StorageConfig.Options["synchronous"] = s.Synchronous
+		}
+		cfg.StorageConfig.Host = s.Host
+		cfg.StorageConfig.Port = s.Port
+		cfg.StorageConfig.Database = s.Database
+		cfg.StorageConfig.Username = s.Username
+		cfg.StorageConfig.Password = s.Password
+		cfg.StorageConfig.MaxOpenConns = s.MaxOpenConns
+		cfg.StorageConfig.MaxIdleConns = s.MaxIdleConns
+	}
+	if a := file.Archive; a != nil {
+		if len(a.Tables) > 0 {
+			tables := a.TablesFlag()
+			setFlag(f, &tables, "archive-tables")
+		}
+		setFlag(f, a.Store, "archive-store")
+		setFlag(f, a.Endpoint, "archive-endpoint")
+		setFlag(f, a.Region, "archive-region")
+		setFlag(f, a.Interval, "archive-interval")
+		setFlag(f, a.CacheRows, "archive-cache-rows")
+	}
+	if l := file.Logging; l != nil {
+		setFlag(f, l.Level, "log-level")
+		setFlag(f, l.Format, "log-format")
+		setFlag(f, l.Queries, "log-queries")
+		setFlag(f, l.QueriesRewritten, "log-queries-rewritten")
+		if len(l.Levels) > 0 {
+			levels := l.LogLevelsFlag()
+			setFlag(f, &levels, "log-levels")
+		}
+		setFlag(f, l.File, "log-file")
+		setFlag(f, l.FileMaxSize, "log-file-max-size")
+		setFlag(f, l.FileMaxBackups, "log-file-max-backups")
+		setFlag(f, l.Syslog, "log-syslog")
+		setFlag(f, l.OTLPEndpoint, "log-otlp-endpoint")
+	}
+	if a := file.Auth; a != nil {
+		setFlag(f, a.TokenFile, "http-token-file")
+		setFlag(f, a.PeerMap, "peer-map")
+		cfg.Logins = a.Logins
+		setFlag(f, a.EnforcePermissions, "enforce-permissions")
+		if len(a.Administrators) > 0 {
+			admins := strings.Join(a.Administrators, ",")
+			setFlag(f, &admins, "admin-logins")
+		}
+	}
+	if f.err != nil {
+		return fmt.Errorf("%s: %w", path, f.err)
+	}
+
+	// The file's listeners replace the default HTTP listener
+	if len(file.Listeners) > 0 {
+		cfg.Listeners = file.ListenerConfigs()
+		if !f.given["http-port"] {
+			fs.Set("http-port", "0")
+		}
+	}
+	if file.Tenants != nil {
+		cfg.TenantConfig = *file.Tenants
+	}
+	return nil
+}
+
+// addListener adds a listener enabled by a flag, or sets the address of
+// the configuration file's listener of the same name.
+func addListener(cfg *server.Config, l protocol.ListenerConfig) {
+	for i := range cfg.Listeners {
+		if strings.EqualFold(cfg.Listeners[i].Name, l.Name) {
+			cfg.Listeners[i].Port = l.Port
+			cfg.Listeners[i].Socket = l.Socket
+			return
+		}
+	}
+	cfg.Listeners = append(cfg.Listeners, l)
+}
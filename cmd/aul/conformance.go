@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	_ "github.com/microsoft/go-mssqldb"
+
+	"github.com/ha1tch/aul/pkg/conformance"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// runConformance implements the "aul conformance" subcommand.
+func runConformance(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("aul conformance", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		target     = fs.String("server", "", "DSN of the aul server to test (default: start one in-process)")
+		reference  = fs.String("reference", "", "DSN of a reference SQL Server to diff against")
+		categories = fs.String("category", "", "Comma-separated categories to run (default: all)")
+		format     = fs.String("format", "text", "Output format: text, json")
+		verbose    = fs.Bool("verbose", false, "List every case, not just failures")
+		minScore   = fs.Float64("min-score", 0, "Exit with status 1 if the total score is below this percentage")
+		timeout    = fs.Duration("timeout", conformance.DefaultTimeout, "Per-case timeout")
+	)
+
+	fs.Usage = func() {
+		printConformanceUsage(stderr)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "error: unknown format %q\n", *format)
+		return 2
+	}
+
+	// Start an in-process server unless one was given
+	targetDSN := *target
+	if targetDSN == "" {
+		emb, err := startEmbeddedServer("", protocol.ProtocolTDS)
+		if err != nil {
+			fmt.Fprintf(stderr, "error starting server: %v\n", err)
+			return 1
+		}
+		defer emb.Stop()
+		targetDSN = emb.TDSDSN()
+	}
+
+	targetDB, err := sql.Open("sqlserver", targetDSN)
+	if err != nil {
+		fmt.Fprintf(stderr, "error opening server: %v\n", err)
+		return 1
+	}
+	defer targetDB.Close()
+
+	runner := &conformance.Runner{
+		Target:  targetDB,
+		Timeout: *timeout,
+	}
+
+	if *reference != "" {
+		refDB, err := sql.Open("sqlserver", *reference)
+		if err != nil {
+			fmt.Fprintf(stderr, "error opening reference: %v\n", err)
+			return 1
+		}
+		defer refDB.Close()
+		runner.Reference = refDB
+	}
+
+	var cats []string
+	if *categories != "" {
+		for _, c := range strings.Split(*categories, ",") {
+			cats = append(cats, strings.TrimSpace(c))
+		}
+	}
+	cases := conformance.Filter(conformance.Corpus, cats)
+	if len(cases) == 0 {
+		fmt.Fprintf(stderr, "error: no cases match category %q\n", *categories)
+		return 2
+	}
+
+	card := runner.Run(context.Background(), cases)
+
+	if *format == "json" {
+		if err := card.WriteJSON(stdout); err != nil {
+			fmt.Fprintf(stderr, "error writing scorecard: %v\n", err)
+			return 1
+		}
+	} else {
+		card.WriteText(stdout, *verbose)
+	}
+
+	if score := card.Total().Score(); score < *minScore {
+		fmt.Fprintf(stderr, "score %.1f%% is below minimum %.1f%%\n", score, *minScore)
+		return 1
+	}
+	return 0
+}
+
+func printConformanceUsage(w io.Writer) {
+	fmt.Fprint(w, `aul conformance - Run the T-SQL compatibility corpus and print a scorecard
+
+Usage:
+  aul conformance [options]
+
+Options:
+  --server <dsn>           aul server to test (default: start one in-process)
+  --reference <dsn>        Reference SQL Server; its results replace the
+                           built-in expectations
+  --category <list>        Comma-separated categories: control-flow, functions,
+                           types, error-handling (default: all)
+  --format <format>        Output format: text, json (default: text)
+  --verbose                List every case, not just failures
+  --min-score <pct>        Exit with status 1 if the total score is below pct
+  --timeout <dur>          Per-case timeout (default: 10s)
+
+Examples:
+  # Score the built-in corpus against an in-process server
+  aul conformance
+
+  # Diff a running aul against SQL Server
+  aul conformance --server "sqlserver://sa:pw@localhost:1433?encrypt=disable" \
+      --reference "sqlserver://sa:pw@mssql:1433?encrypt=disable"
+
+  # Fail CI if compatibility regresses
+  aul conformance --min-score 90 --format json > scorecard.json
+`)
+}
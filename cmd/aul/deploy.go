@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// runDeploy implements the "aul deploy" subcommand, a client of a running
+// server's /admin/deploy route.
+func runDeploy(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("aul deploy", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		serverURL = fs.String("server", "http://localhost:8080", "HTTP API of the server")
+		tokenFile = fs.String("token-file", "", "File holding the API token")
+		dir       = fs.String("dir", "", "Directory on the server holding the new procedure set")
+		timeout   = fs.Duration("timeout", 5*time.Minute, "How long to wait for the server")
+	)
+
+	fs.Usage = func() {
+		printDeployUsage(stderr)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	action := "deploy"
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		action = strings.ToLower(fs.Arg(0))
+	default:
+		printDeployUsage(stderr)
+		return 2
+	}
+	switch action {
+	case "deploy", "stage", "switch", "rollback", "discard", "status":
+	default:
+		fmt.Fprintf(stderr, "error: unknown action %q\n", fs.Arg(0))
+		return 2
+	}
+
+	client := &deployClient{
+		url:  strings.TrimSuffix(*serverURL, "/") + "/admin/deploy",
+		http: &http.Client{Timeout: *timeout},
+	}
+	if *tokenFile != "" {
+		tokens, err := readTokenFile(*tokenFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+		client.token = tokens[0]
+	}
+
+	var (
+		d   protocol.Deployment
+		err error
+	)
+	switch action {
+	case "status":
+		d, err = client.do(http.MethodGet, nil)
+	case "deploy", "stage":
+		d, err = client.do(http.MethodPost, map[string]string{"action": "stage", "dir": *dir})
+		if err != nil {
+			break
+		}
+		printStaged(stdout, d.Staged)
+		if d.Staged != nil && len(d.Staged.Problems) > 0 {
+			fmt.Fprintln(stderr, "error: the procedure set failed validation and was not switched to")
+			return 1
+		}
+		if action == "stage" {
+			return 0
+		}
+		d, err = client.do(http.MethodPost, map[string]string{"action": "switch"})
+	default:
+		d, err = client.do(http.MethodPost, map[string]string{"action": action})
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+
+	switch action {
+	case "deploy", "switch", "rollback":
+		fmt.Fprintf(stdout, "active: %s (%d procedures)\n", d.Active.Source, d.Active.Procedures)
+	case "discard":
+		fmt.Fprintln(stdout, "staged procedure set discarded")
+	case "status":
+		printProcedureSet(stdout, "active", d.Active)
+		printProcedureSet(stdout, "staged", d.Staged)
+		printProcedureSet(stdout, "previous", d.Previous)
+	}
+	return 0
+}
+
+// deployClient calls a server's /admin/deploy route.
+type deployClient struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func (c *deployClient) do(method string, body interface{}) (protocol.Deployment, error) {
+	var d protocol.Deployment
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return d, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.url, reader)
+	if err != nil {
+		return d, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return d, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return d, fmt.Errorf("%s not found: is the server running with --http-admin?", c.url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return d, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	err = json.NewDecoder(resp.Body).Decode(&d)
+	return d, err
+}
+
+func printStaged(w io.Writer, set *protocol.ProcedureSet) {
+	if set == nil {
+		return
+	}
+	fmt.Fprintf(w, "staged %d procedures from %s: %d added, %d changed, %d removed\n",
+		set.Procedures, set.Source, len(set.Added), len(set.Changed), len(set.Removed))
+	for _, name := range set.Added {
+		fmt.Fprintf(w, "  + %s\n", name)
+	}
+	for _, name := range set.Changed {
+		fmt.Fprintf(w, "  ~ %s\n", name)
+	}
+	for _, name := range set.Removed {
+		fmt.Fprintf(w, "  - %s\n", name)
+	}
+	for _, p := range set.Problems {
+		fmt.Fprintf(w, "  problem: %s\n", p)
+	}
+}
+
+func printProcedureSet(w io.Writer, label string, set *protocol.ProcedureSet) {
+	if set == nil {
+		fmt.Fprintf(w, "%-9s none\n", label+":")
+		return
+	}
+	fmt.Fprintf(w, "%-9s %s (%d procedures, loaded %s", label+":", set.Source, set.Procedures,
+		set.LoadedAt.Format(time.RFC3339))
+	if set.ActivatedAt != nil {
+		fmt.Fprintf(w, ", activated %s", set.ActivatedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintln(w, ")")
+	if label == "staged" {
+		fmt.Fprintf(w, "          %d added, %d changed, %d removed, %d problems\n",
+			len(set.Added), len(set.Changed), len(set.Removed), len(set.Problems))
+	}
+}
+
+func printDeployUsage(w io.Writer) {
+	fmt.Fprint(w, `aul deploy - Switch a running server to a new procedure set
+
+Usage:
+  aul deploy [options] [action]
+
+Actions:
+  (none)     Stage the procedure set, then switch to it if it is valid
+  stage      Load and validate the set beside the active one, without
+             switching to it
+  switch     Make the staged set active
+  rollback   Make the set active before the last switch active again
+  discard    Drop the staged set
+  status     Show the active, staged and previous sets
+
+Options:
+  --server <url>           HTTP API of the server (default: http://localhost:8080)
+  --token-file <file>      File holding the API token, when the server runs
+                           with --http-token-file
+  --dir <path>             Directory holding the new procedures, as the
+                           server sees it (default: the server's --proc-dir)
+  --timeout <duration>     How long to wait for the server (default: 5m)
+
+The server must run with --http-admin. Staging loads every procedure in
+the directory into a registry of its own and validates the set: each file
+must load, no procedure may be defined twice and each must meet its result
+contract. Switching replaces the active procedures in one step; executions
+already running finish with the procedures they started with. The set it
+replaced is kept, so a rollback is as quick. With --watch, the server keeps
+reloading files changed in its --proc-dir whichever set is active.
+
+Examples:
+  # Copy the release to the server, then deploy it
+  rsync -a procedures/ db1:/srv/aul/release-42/
+  aul deploy --server http://db1:8080 --dir /srv/aul/release-42
+
+  # Deploy in two steps, checking what changes in between
+  aul deploy --server http://db1:8080 --dir /srv/aul/release-42 stage
+  aul deploy --server http://db1:8080 switch
+
+  aul deploy --server http://db1:8080 rollback
+`)
+}
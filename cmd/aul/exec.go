@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/sqlcmd"
+)
+
+// variableFlags collects repeated -v name=value flags.
+type variableFlags map[string]string
+
+func (v variableFlags) String() string { return "" }
+
+func (v variableFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("want name=value, got %q", s)
+	}
+	v[strings.TrimSpace(name)] = value
+	return nil
+}
+
+// runExec implements the "aul exec" subcommand.
+func runExec(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("aul exec", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	vars := variableFlags{}
+	var (
+		file        = fs.String("f", "", "sqlcmd script to run (- for stdin)")
+		exitOnError = fs.Bool("b", false, "Stop at the first batch that fails (:on error exit)")
+		target      = fs.String("server", "", "DSN of the server to run the script on")
+		procDir     = fs.String("proc-dir", "", "Procedure directory for the in-process server")
+	)
+	fs.Var(vars, "v", "Scripting variable name=value (repeatable)")
+
+	fs.Usage = func() {
+		printExecUsage(stderr)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		fmt.Fprintln(stderr, "error: -f is required")
+		return 2
+	}
+
+	var (
+		script []byte
+		err    error
+	)
+	source := *file
+	if *file == "-" {
+		script, err = io.ReadAll(stdin)
+		source = "stdin"
+	} else {
+		script, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+
+	// Without a target, run the script on an in-process server
+	if *target == "" {
+		emb, err := startEmbeddedServer(*procDir, protocol.ProtocolTDS)
+		if err != nil {
+			fmt.Fprintf(stderr, "error starting server: %v\n", err)
+			return 1
+		}
+		defer emb.Stop()
+		*target = emb.TDSDSN()
+	}
+
+	db, err := sql.Open("sqlserver", *target)
+	if err != nil {
+		fmt.Fprintf(stderr, "error opening server: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	// One connection, so that the batches share a session
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "error connecting: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	p := sqlcmd.New(sqlcmd.Options{
+		Variables:   vars,
+		Lookup:      os.LookupEnv,
+		ExitOnError: *exitOnError,
+		OnError: func(err error) {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+		},
+	})
+	err = p.Run(string(script), filepath.Base(source), func(batch string) error {
+		return execBatch(ctx, conn, batch, stdout)
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// execBatch runs one batch, printing its result sets.
+func execBatch(ctx context.Context, conn *sql.Conn, batch string, w io.Writer) error {
+	rows, err := conn.QueryContext(ctx, batch)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for {
+		if err := printRows(rows, w); err != nil {
+			return err
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+// printRows prints the current result set of rows as an aligned table.
+func printRows(rows *sql.Rows, w io.Writer) error {
+	cols, err := rows.Columns()
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	rules := make([]string, len(cols))
+	for i, c := range cols {
+		rules[i] = strings.Repeat("-", max(len(c), 1))
+	}
+	fmt.Fprintln(tw, strings.Join(rules, "\t"))
+
+	values := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			fields[i] = formatValue(v)
+		}
+		fmt.Fprintln(tw, strings.Join(fields, "\t"))
+		count++
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n(%d rows affected)\n\n", count)
+	return rows.Err()
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.000")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func printExecUsage(w io.Writer) {
+	fmt.Fprint(w, `aul exec - Run a sqlcmd script
+
+Usage:
+  aul exec -f <file> [options]
+
+Options:
+  -f <file>                Script to run (- reads stdin)
+  -v <name>=<value>        Set a scripting variable (repeatable)
+  -b                       Stop at the first batch that fails, exiting 1
+  --server <dsn>           Run on a server (default: an in-process server)
+  --proc-dir <path>        Procedures for the in-process server
+
+The script is read as sqlcmd reads it: GO ends a batch, $(name) is
+replaced by a scripting variable (set with -v, :setvar or the environment),
+and these commands are understood:
+
+  :setvar name "value"     Set a variable; :setvar name unsets it
+  :r file                  Include another script (relative to the
+                           working directory)
+  :on error exit|ignore    Stop at, or report and skip, a failed batch
+  :exit, :exit(), :quit    Stop, optionally running the current batch
+  :reset                   Discard the current batch
+  GO [n]                   Run the current batch, n times
+
+Result sets are printed to stdout and errors to stderr. Without -b or
+:on error exit, a failed batch is reported and the script goes on.
+
+Examples:
+  aul exec -f deploy.sql -v Env=staging -v Schema=sales -b \
+      --server "sqlserver://sa:pw@localhost:1433?encrypt=disable"
+`)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ha1tch/aul/pkg/clientgen"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/seed"
+)
+
+// runGen implements the "aul gen" subcommand.
+func runGen(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "ts" {
+		printGenUsage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("aul gen ts", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		procDir    = fs.String("proc-dir", "./procedures", "Procedure directory")
+		schemaPath = fs.String("schema", "", "T-SQL script defining the tables, to type the columns procedures read")
+		outPath    = fs.String("out", "", "Write the module to this file instead of stdout")
+	)
+
+	fs.Usage = func() {
+		printGenUsage(stderr)
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	tables := procedure.TableColumns(nil)
+	if *schemaPath != "" {
+		script, err := os.ReadFile(*schemaPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+		schema, err := seed.ParseSchema(string(script))
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %s: %v\n", *schemaPath, err)
+			return 1
+		}
+		tables = schemaColumns(schema)
+	}
+
+	loader := procedure.NewLoader("tsql", log.New(log.Config{
+		DefaultLevel: log.LevelWarn,
+		Output:       stderr,
+		Format:       log.FormatText,
+	}))
+	procs, err := loader.LoadDir(*procDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	for _, proc := range procs {
+		if proc.IsFunction {
+			continue
+		}
+		if proc.Contract != nil {
+			proc.ResultSets = proc.Contract // Declared: trust it over inference
+			continue
+		}
+		sets, err := procedure.InferResultSets(proc, tables)
+		if err != nil {
+			fmt.Fprintf(stderr, "warning: %s: result rows left untyped: %v\n", proc.QualifiedName(), err)
+			continue
+		}
+		proc.ResultSets = sets
+	}
+
+	var w io.Writer = stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		bw := bufio.NewWriter(f)
+		defer bw.Flush()
+		w = bw
+	}
+	if err := clientgen.TypeScript(w, procs); err != nil {
+		fmt.Fprintf(stderr, "error writing module: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// schemaColumns looks tables up in a schema script's definitions.
+func schemaColumns(schema *seed.Schema) procedure.TableColumns {
+	return func(name string) []procedure.ColumnDef {
+		t := schema.Table(name)
+		if t == nil {
+			return nil
+		}
+		cols := make([]procedure.ColumnDef, len(t.Columns))
+		for i, c := range t.Columns {
+			cols[i] = procedure.ColumnDef{Name: c.Name, SQLType: c.Type, Nullable: c.Nullable, Ordinal: i}
+		}
+		return cols
+	}
+}
+
+func printGenUsage(w io.Writer) {
+	fmt.Fprint(w, `aul gen - Generate client code for the procedures
+
+Usage:
+  aul gen ts [options]
+
+Options:
+  --proc-dir <path>        Procedure directory (default: ./procedures)
+  --schema <file>          CREATE TABLE script used to type the columns
+                           procedures select from tables
+  --out <file>             Write to a file instead of stdout
+
+"aul gen ts" writes a TypeScript module with a client for the HTTP API and
+one typed function per procedure: an interface for its parameters, one for
+the rows of each result set it returns and one for its OUTPUT parameters.
+Result sets are worked out from the procedure's SELECT statements; column
+types come from literals, CASTs, parameters, functions and, with --schema,
+table columns, and are unknown otherwise. Result sets returned only under
+IF, WHILE or CATCH are optional. A procedure's result contract, when it
+declares one, is used instead. The module needs fetch (Node 18+ or a
+browser) and nothing else.
+
+Examples:
+  aul gen ts --proc-dir ./procedures --schema schema.sql --out src/aul.ts
+
+  import { AulClient, getCustomer } from "./aul";
+  const client = new AulClient({ url: "http://localhost:8080", token });
+  const { resultSets: [customers] } = await getCustomer(client, { CustomerID: 42 });
+`)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/dacpac"
+	"github.com/ha1tch/aul/pkg/mssqlimport"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/sqlcmd"
+)
+
+// runImport implements the "aul import" subcommand.
+func runImport(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || (args[0] != "mssql" && args[0] != "dacpac") {
+		printImportUsage(stderr)
+		return 2
+	}
+	kind := args[0]
+
+	fs := flag.NewFlagSet("aul import "+kind, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	vars := variableFlags{}
+	var (
+		dsn         = fs.String("dsn", "", "DSN of the SQL Server database to import")
+		file        = fs.String("file", "", "The .dacpac or .bacpac to deploy")
+		storagePath = fs.String("storage-path", "", "SQLite file to import into")
+		target      = fs.String("target", "", "DSN of a running aul server to import into")
+		procDir     = fs.String("proc-dir", "./procedures", "Directory procedures and functions are written into")
+		database    = fs.String("database", "", "Database directory for procedures (default: the source database)")
+		schemas     = fs.String("schemas", "", "Comma-separated schemas to import (default: all)")
+		schemaOnly  = fs.Bool("schema-only", false, "Create tables without copying their rows")
+		batchSize   = fs.Int("batch-size", mssqlimport.DefaultBatchSize, "Rows per INSERT")
+		reportPath  = fs.String("report", "", "Write the conversion report to this file")
+		jsonOut     = fs.Bool("json", false, "Write the report as JSON")
+		verbose     = fs.Bool("v", false, "List every object in the report and show progress")
+	)
+	fs.Var(vars, "var", "SQLCMD variable name=value for a package's deployment scripts (repeatable)")
+
+	fs.Usage = func() {
+		printImportUsage(stderr)
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if kind == "mssql" && *dsn == "" {
+		fmt.Fprintln(stderr, "error: --dsn is required")
+		return 2
+	}
+	if kind == "dacpac" && *file == "" {
+		fmt.Fprintln(stderr, "error: --file is required")
+		return 2
+	}
+	if (*storagePath == "") == (*target == "") {
+		fmt.Fprintln(stderr, "error: exactly one of --storage-path and --target is required")
+		return 2
+	}
+	if *batchSize < 1 {
+		fmt.Fprintln(stderr, "error: --batch-size must be at least 1")
+		return 2
+	}
+
+	var (
+		source mssqlimport.Source
+		pkg    *dacpac.Package
+	)
+	if kind == "mssql" {
+		db, err := sql.Open("sqlserver", *dsn)
+		if err != nil {
+			fmt.Fprintf(stderr, "error opening source: %v\n", err)
+			return 1
+		}
+		defer db.Close()
+		source = mssqlimport.NewSource(db)
+	} else {
+		var err error
+		if pkg, err = dacpac.Open(*file); err != nil {
+			fmt.Fprintf(stderr, "error reading %s: %v\n", *file, err)
+			return 1
+		}
+		source = pkg.Source()
+		// A package has a schema; a bacpac's rows are not readable
+		*schemaOnly = true
+	}
+
+	// Without a target, load through an in-process server on the file
+	if *target == "" {
+		emb, err := startEmbeddedServerOn("", runtime.StorageConfig{
+			Type:    "sqlite",
+			Options: map[string]string{"path": *storagePath},
+		}, protocol.ProtocolTDS)
+		if err != nil {
+			fmt.Fprintf(stderr, "error starting server: %v\n", err)
+			return 1
+		}
+		defer emb.Stop()
+		*target = emb.TDSDSN()
+	}
+
+	db, err := sql.Open("sqlserver", *target)
+	if err != nil {
+		fmt.Fprintf(stderr, "error opening target: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	// One connection, so that IDENTITY_INSERT holds for its batch
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "error connecting to target: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	im := &mssqlimport.Importer{
+		Source:     source,
+		Target:     mssqlimport.NewTarget(conn),
+		ProcDir:    *procDir,
+		Database:   *database,
+		SchemaOnly: *schemaOnly,
+		BatchSize:  *batchSize,
+	}
+	if *schemas != "" {
+		for _, s := range strings.Split(*schemas, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				im.Schemas = append(im.Schemas, s)
+			}
+		}
+	}
+	if *verbose {
+		im.Progress = func(message string) {
+			fmt.Fprintln(stderr, message)
+		}
+	}
+
+	var scripts []*mssqlimport.Object
+	if pkg != nil && pkg.PreDeploy != "" {
+		scripts = append(scripts, deployScript(ctx, conn, "predeploy.sql", pkg.PreDeploy, pkg.Name, vars))
+	}
+
+	report, err := im.Run(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "error reading source: %v\n", err)
+		return 1
+	}
+
+	if pkg != nil {
+		if pkg.PostDeploy != "" {
+			scripts = append(scripts, deployScript(ctx, conn, "postdeploy.sql", pkg.PostDeploy, pkg.Name, vars))
+		}
+		report.Objects = append(report.Objects, scripts...)
+		report.Objects = append(report.Objects, pkg.Incompatible...)
+		for _, t := range pkg.DataTables {
+			report.Objects = append(report.Objects, &mssqlimport.Object{
+				Kind:   "data",
+				Name:   t,
+				Status: mssqlimport.StatusUnsupported,
+				Error:  "bacpac data is in BCP native format, which is not read",
+			})
+		}
+	}
+
+	out := stdout
+	if *reportPath != "" {
+		f, err := os.Create(*reportPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	if *jsonOut {
+		if err := report.WriteJSON(out); err != nil {
+			fmt.Fprintf(stderr, "error writing report: %v\n", err)
+			return 1
+		}
+	} else {
+		report.WriteText(out, *verbose)
+	}
+
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
+
+// deployScript runs a package's pre- or post-deployment script as sqlcmd
+// would, stopping at the first failed batch. $(DatabaseName) defaults to
+// the package's name.
+func deployScript(ctx context.Context, conn *sql.Conn, name, script, database string, vars map[string]string) *mssqlimport.Object {
+	variables := map[string]string{"DatabaseName": database}
+	for k, v := range vars {
+		variables[k] = v
+	}
+	o := &mssqlimport.Object{Kind: "script", Name: name, Status: mssqlimport.StatusImported}
+	p := sqlcmd.New(sqlcmd.Options{
+		Variables:       variables,
+		Lookup:          os.LookupEnv,
+		ExitOnError:     true,
+		DisableIncludes: true, // The build has already read in :r files
+	})
+	err := p.Run(script, "", func(batch string) error {
+		_, err := conn.ExecContext(ctx, batch)
+		return err
+	})
+	if err != nil {
+		o.Status, o.Error = mssqlimport.StatusFailed, err.Error()
+	}
+	return o
+}
+
+func printImportUsage(w io.Writer) {
+	fmt.Fprint(w, `aul import - Migrate a database into aul
+
+Usage:
+  aul import mssql --dsn <dsn> (--storage-path <file> | --target <dsn>) [options]
+  aul import dacpac --file <package> (--storage-path <file> | --target <dsn>) [options]
+
+Options:
+  --dsn <dsn>              SQL Server database to import
+  --file <package>         .dacpac or .bacpac to deploy
+  --storage-path <file>    SQLite file to load tables and rows into
+  --target <dsn>           Running aul server to load into instead
+  --proc-dir <path>        Where procedures and functions are written
+                           (default: ./procedures)
+  --database <name>        Database directory under --proc-dir
+                           (default: the source database's name)
+  --schemas <list>         Comma-separated schemas to import (default: all)
+  --schema-only            Create tables without copying their rows
+  --batch-size <n>         Rows per INSERT (default: 100)
+  --report <file>          Write the conversion report to a file
+  --json                   Write the report as JSON
+  -v                       List every object in the report and show progress
+  --var <name>=<value>     SQLCMD variable for a package's pre- and
+                           post-deployment scripts (repeatable)
+
+Tables are created in dependency order of their foreign keys, then their
+rows are copied. Procedures and functions are checked with aul's parser
+and written as <proc-dir>/<database>/<schema>/<name>.sql; views are
+created on the target. The report lists what was imported unchanged,
+what was converted and how, what failed, and what aul does not support
+(triggers, sequences, synonyms, CLR objects, some column types).
+
+A package is deployed from its model: the same tables, procedures and
+functions, with its pre-deployment script run first and its
+post-deployment script last ($(DatabaseName) is the package's name). Users,
+roles and other elements aul has no equivalent for are reported as
+unsupported, as is a bacpac's data.
+
+Exits 1 if any object failed to import.
+
+Examples:
+  aul import mssql --dsn "sqlserver://sa:pw@mssql:1433?database=Shop" \
+      --storage-path shop.db --proc-dir ./procedures --report import.txt
+  aul import dacpac --file bin/Release/Shop.dacpac --var Env=staging \
+      --target "sqlserver://sa:pw@localhost:1433?encrypt=disable"
+`)
+}
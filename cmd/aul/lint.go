@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/lint"
+)
+
+// runLint implements the "aul lint" subcommand.
+func runLint(args []string, stdout, stderr io.Writer) int {
+	fset := flag.NewFlagSet("aul lint", flag.ContinueOnError)
+	fset.SetOutput(stderr)
+
+	rules := variableFlags{}
+	var (
+		procDir = fset.String("proc-dir", "./procedures", "Procedure directory")
+		format  = fset.String("format", "text", "Output format: text, json, github")
+		failOn  = fset.String("fail-on", "error", "Exit with status 1 on findings of this severity or worse: info, warning, error")
+	)
+	fset.Var(rules, "rule", "Severity of a rule, name=off|info|warning|error (repeatable)")
+
+	fset.Usage = func() {
+		printLintUsage(stderr)
+	}
+
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" && *format != "github" {
+		fmt.Fprintf(stderr, "error: unknown format %q\n", *format)
+		return 2
+	}
+	threshold, err := lint.ParseSeverity(*failOn)
+	if err != nil || threshold == lint.Off {
+		fmt.Fprintf(stderr, "error: --fail-on must be info, warning or error\n")
+		return 2
+	}
+	cfg := lint.Config{}
+	for name, level := range rules {
+		s, err := lint.ParseSeverity(level)
+		if err == nil {
+			err = cfg.Set(name, s)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "error: --rule %s=%s: %v\n", name, level, err)
+			return 2
+		}
+	}
+
+	var paths []string
+	err = filepath.WalkDir(*procDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".sql") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	sort.Strings(paths)
+
+	report := lintReport{Findings: []lint.Finding{}, Errors: []lintError{}, Files: len(paths)}
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err == nil {
+			var findings []lint.Finding
+			findings, err = lint.Lint(string(source), path, cfg)
+			report.Findings = append(report.Findings, findings...)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, lintError{File: path, Message: err.Error()})
+		}
+	}
+
+	failed := len(report.Errors) > 0
+	counts := make(map[lint.Severity]int)
+	for _, f := range report.Findings {
+		counts[f.Severity]++
+		if f.Severity >= threshold {
+			failed = true
+		}
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	case "github":
+		// Workflow commands, which GitHub Actions shows as annotations
+		for _, e := range report.Errors {
+			fmt.Fprintf(stdout, "::error file=%s,title=aul lint::%s\n", e.File, githubEscape(e.Message))
+		}
+		for _, f := range report.Findings {
+			level := "warning"
+			switch f.Severity {
+			case lint.Error:
+				level = "error"
+			case lint.Info:
+				level = "notice"
+			}
+			fmt.Fprintf(stdout, "::%s file=%s,line=%d,col=%d,title=aul lint %s::%s\n",
+				level, f.File, f.Line, f.Column, f.Rule, githubEscape(f.Message))
+		}
+	default:
+		for _, e := range report.Errors {
+			fmt.Fprintf(stdout, "%s: %s\n", e.File, e.Message)
+		}
+		for _, f := range report.Findings {
+			fmt.Fprintln(stdout, f)
+		}
+		fmt.Fprintf(stdout, "\n%d files, %d errors, %d warnings, %d info", len(paths),
+			counts[lint.Error], counts[lint.Warning], counts[lint.Info])
+		if len(report.Errors) > 0 {
+			fmt.Fprintf(stdout, ", %d not parsed", len(report.Errors))
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// lintReport is the output of "aul lint --format json".
+type lintReport struct {
+	Files    int            `json:"files"`
+	Findings []lint.Finding `json:"findings"`
+	Errors   []lintError    `json:"errors"` // Files that could not be read or parsed
+}
+
+type lintError struct {
+	File    string `json:"file"`
+	Message string `json:"message"`
+}
+
+// githubEscape escapes the characters a workflow command's message
+// cannot hold.
+func githubEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func printLintUsage(w io.Writer) {
+	fmt.Fprint(w, `aul lint - Check procedures for likely mistakes and slow queries
+
+Usage:
+  aul lint [options]
+
+Options:
+  --proc-dir <path>        Procedure directory (default: ./procedures)
+  --rule <name>=<level>    Set the severity of a rule: off, info, warning or
+                           error (repeatable)
+  --fail-on <level>        Exit with status 1 on a finding of this severity
+                           or worse: info, warning or error (default: error)
+  --format <format>        Output format: text, json or github (default: text)
+
+Rules (all warnings unless --rule says otherwise):
+`)
+	for _, r := range lint.Rules {
+		fmt.Fprintf(w, "  %-20s %s\n", r.Name, r.Description)
+	}
+	fmt.Fprint(w, `
+"aul lint" reads every .sql file under the procedure directory without
+running anything. Each finding is printed as file:line:column: severity:
+message [rule]. --format json prints the findings as one JSON document,
+and --format github as workflow commands that GitHub Actions shows as
+annotations on the lines they concern. A file that does not parse fails
+the run, whatever --fail-on says.
+
+Examples:
+  aul lint --proc-dir ./procedures
+  aul lint --rule select-star=error --rule three-part-name=off --fail-on warning
+  aul lint --format github
+`)
+}
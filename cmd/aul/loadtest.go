@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	goruntime "runtime"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/loadtest"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// runLoadtest implements the "aul loadtest" subcommand.
+func runLoadtest(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("aul loadtest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		tdsDSN      = fs.String("tds", "", "DSN of the server's TDS listener")
+		httpURL     = fs.String("http", "", "Base URL of the server's HTTP API")
+		procDir     = fs.String("proc-dir", "", "Procedure directory for the in-process server")
+		workload    = fs.String("workload", "", "Workload file (JSON list of weighted operations)")
+		sqlText     = fs.String("sql", "SELECT 1", "SQL batch to run when no workload file is given")
+		tdsClients  = fs.Int("tds-clients", 4, "Number of concurrent TDS clients")
+		httpClients = fs.Int("http-clients", 0, "Number of concurrent HTTP clients")
+		duration    = fs.Duration("duration", 30*time.Second, "Length of the run")
+		interval    = fs.Duration("interval", 5*time.Second, "Progress and sampling interval")
+		format      = fs.String("format", "text", "Output format: text, json")
+		maxErrRate  = fs.Float64("max-error-rate", -1, "Exit with status 1 if the error percentage exceeds this (-1 = never)")
+	)
+
+	fs.Usage = func() {
+		printLoadtestUsage(stderr)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "error: unknown format %q\n", *format)
+		return 2
+	}
+	if *tdsClients+*httpClients <= 0 {
+		fmt.Fprintln(stderr, "error: at least one client is required")
+		return 2
+	}
+
+	wl := loadtest.SQLWorkload(*sqlText)
+	if *workload != "" {
+		var err error
+		wl, err = loadtest.LoadWorkload(*workload)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+	}
+
+	cfg := loadtest.Config{
+		Workload:       wl,
+		Duration:       *duration,
+		SampleInterval: *interval,
+		Progress: func(elapsed time.Duration, ops, errs int64) {
+			fmt.Fprintf(stderr, "  %5.0fs  %9d ops  %6d errors  %9.1f ops/s\n",
+				elapsed.Seconds(), ops, errs, float64(ops)/elapsed.Seconds())
+		},
+	}
+
+	// Start an in-process server unless a target was given; only then
+	// can server-side resource usage be sampled
+	if *tdsDSN == "" && *httpURL == "" {
+		var protos []protocol.ProtocolType
+		if *tdsClients > 0 {
+			protos = append(protos, protocol.ProtocolTDS)
+		}
+		if *httpClients > 0 {
+			protos = append(protos, protocol.ProtocolHTTP)
+		}
+		emb, err := startEmbeddedServer(*procDir, protos...)
+		if err != nil {
+			fmt.Fprintf(stderr, "error starting server: %v\n", err)
+			return 1
+		}
+		defer emb.Stop()
+		*tdsDSN = emb.TDSDSN()
+		*httpURL = emb.HTTPURL()
+		cfg.Sampler = embeddedSampler(emb)
+	}
+
+	if *tdsClients > 0 {
+		if *tdsDSN == "" {
+			fmt.Fprintln(stderr, "error: --tds is required for TDS clients")
+			return 2
+		}
+		db, err := sql.Open("sqlserver", *tdsDSN)
+		if err != nil {
+			fmt.Fprintf(stderr, "error opening server: %v\n", err)
+			return 1
+		}
+		defer db.Close()
+		db.SetMaxOpenConns(*tdsClients)
+		db.SetMaxIdleConns(*tdsClients)
+		cfg.Groups = append(cfg.Groups, loadtest.ClientGroup{Count: *tdsClients, Factory: loadtest.TDSClients(db)})
+	}
+	if *httpClients > 0 {
+		if *httpURL == "" {
+			fmt.Fprintln(stderr, "error: --http is required for HTTP clients")
+			return 2
+		}
+		cfg.Groups = append(cfg.Groups, loadtest.ClientGroup{Count: *httpClients, Factory: loadtest.HTTPClients(*httpURL)})
+	}
+
+	// Stop early on Ctrl-C but still print the report
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Fprintf(stderr, "Running %d TDS and %d HTTP clients for %s...\n", *tdsClients, *httpClients, *duration)
+	report, err := loadtest.Run(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if *format == "json" {
+		if err := report.WriteJSON(stdout); err != nil {
+			fmt.Fprintf(stderr, "error writing report: %v\n", err)
+			return 1
+		}
+	} else {
+		report.WriteText(stdout)
+	}
+
+	if *maxErrRate >= 0 && 100*report.Total.ErrorRate > *maxErrRate {
+		fmt.Fprintf(stderr, "error rate %.2f%% exceeds maximum %.2f%%\n", 100*report.Total.ErrorRate, *maxErrRate)
+		return 1
+	}
+	return 0
+}
+
+// embeddedSampler reads resource usage from an in-process server.
+func embeddedSampler(emb *embeddedServer) func() loadtest.ServerSample {
+	return func() loadtest.ServerSample {
+		var mem goruntime.MemStats
+		goruntime.ReadMemStats(&mem)
+		rt := emb.Runtime().Stats()
+
+		conns := 0
+		for _, ls := range emb.Stats().ListenerStats {
+			conns += ls.Connections
+		}
+		return loadtest.ServerSample{
+			Time:             time.Now(),
+			Goroutines:       goruntime.NumGoroutine(),
+			HeapAllocBytes:   mem.HeapAlloc,
+			ActiveExecutions: rt.ActiveExecutions,
+			TotalExecutions:  rt.TotalExecutions,
+			Connections:      conns,
+		}
+	}
+}
+
+func printLoadtestUsage(w io.Writer) {
+	fmt.Fprint(w, `aul loadtest - Drive concurrent clients against a server and report latencies
+
+Usage:
+  aul loadtest [options]
+
+Target:
+  --tds <dsn>              TDS listener to test
+  --http <url>             HTTP API to test (e.g. http://localhost:8080)
+  --proc-dir <path>        Procedures for the in-process server
+                           (used when neither --tds nor --http is given)
+
+Workload:
+  --workload <file>        JSON file of weighted operations
+  --sql <batch>            Single SQL batch to run (default: SELECT 1)
+  --tds-clients <n>        Concurrent TDS clients (default: 4)
+  --http-clients <n>       Concurrent HTTP clients (default: 0)
+  --duration <dur>         Length of the run (default: 30s)
+
+Output:
+  --interval <dur>         Progress and server sampling interval (default: 5s)
+  --format <format>        Output format: text, json (default: text)
+  --max-error-rate <pct>   Exit with status 1 if the error rate exceeds pct
+
+Server-side resource usage (goroutines, heap, active executions) is only
+reported for the in-process server.
+
+Workload file:
+  {
+    "ops": [
+      {"name": "lookup", "weight": 8, "procedure": "dbo.GetCustomer",
+       "parameters": {"CustomerID": 42}},
+      {"name": "order", "weight": 2, "procedure": "dbo.PlaceOrder",
+       "parameters": {"CustomerID": 42, "Amount": 9.99}},
+      {"name": "report", "weight": 1, "sql": "SELECT COUNT(*) FROM Orders"}
+    ]
+  }
+
+Examples:
+  # Soak an in-process server with a procedure mix
+  aul loadtest -proc-dir ./procedures -workload mix.json -duration 10m
+
+  # 16 TDS and 4 HTTP clients against a running server
+  aul loadtest --tds "sqlserver://sa:pw@localhost:1433?encrypt=disable" \
+      --http http://localhost:8080 --tds-clients 16 --http-clients 4
+`)
+}
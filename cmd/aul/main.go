@@ -1,22 +1,41 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/ha1tch/aul/pkg/archive"
+	"github.com/ha1tch/aul/pkg/auth"
+	"github.com/ha1tch/aul/pkg/cmdshell"
+	"github.com/ha1tch/aul/pkg/erasure"
+	"github.com/ha1tch/aul/pkg/extensions"
+	"github.com/ha1tch/aul/pkg/features"
+	"github.com/ha1tch/aul/pkg/firewall"
+	"github.com/ha1tch/aul/pkg/hooks"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/mail"
+	"github.com/ha1tch/aul/pkg/notify"
 	"github.com/ha1tch/aul/pkg/version"
 	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/sandbox"
 	"github.com/ha1tch/aul/pkg/server"
+	"github.com/ha1tch/aul/pkg/tds"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
 
 	// Protocol implementations (register via init())
-	_ "github.com/ha1tch/aul/pkg/protocol/http"
+	_ "github.com/ha1tch/aul/pkg/protocol/grpc"
+	aulhttp "github.com/ha1tch/aul/pkg/protocol/http"
 	_ "github.com/ha1tch/aul/pkg/protocol/postgres"
-	_ "github.com/ha1tch/aul/pkg/protocol/tds"
+	aultds "github.com/ha1tch/aul/pkg/protocol/tds"
 )
 
 func main() {
@@ -24,6 +43,44 @@ func main() {
 }
 
 func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	// Subcommands
+	if len(args) > 0 && args[0] == "conformance" {
+		return runConformance(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "loadtest" {
+		return runLoadtest(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "seed" {
+		return runSeed(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "exec" {
+		return runExec(args[1:], stdin, stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "import" {
+		return runImport(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		return runMigrate(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "gen" {
+		return runGen(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "validate" {
+		return runValidate(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "lint" {
+		return runLint(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "deploy" {
+		return runDeploy(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "trace" {
+		return runTrace(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "test" {
+		return runTest(args[1:], stdout, stderr)
+	}
+
 	fs := flag.NewFlagSet("aul", flag.ContinueOnError)
 	fs.SetOutput(stderr)
 
@@ -35,6 +92,10 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 		procDirL    = fs.String("proc-dir", "./procedures", "Directory containing stored procedures")
 		watchFiles  = fs.Bool("w", false, "Watch for file changes and hot-reload")
 		watchFilesL = fs.Bool("watch", false, "Watch for file changes and hot-reload")
+		loadWorkers = fs.Int("proc-load-workers", 0, "Procedure files loaded at once at startup (0 = one per CPU)")
+		strict      = fs.Bool("strict", false, "Refuse procedures using T-SQL the interpreter cannot run as they load")
+		initDir     = fs.String("init-dir", "", "Directory of .sql scripts run once each against the storage backend at startup")
+		serverName  = fs.String("server-name", "aul", "Name procedures see in @@SERVERNAME, also used in logs and notifications")
 
 		// Protocol listeners
 		tdsPort      = fs.Int("tds-port", 0, "TDS protocol port (0 = disabled)")
@@ -42,6 +103,30 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 		mysqlPort    = fs.Int("mysql-port", 0, "MySQL protocol port (0 = disabled)")
 		httpPort     = fs.Int("http-port", 8080, "HTTP API port (0 = disabled)")
 		grpcPort     = fs.Int("grpc-port", 0, "gRPC port (0 = disabled)")
+		pgSocket     = fs.String("pg-socket", "", "Unix socket for PostgreSQL clients, authenticated by their OS user")
+		httpSocket   = fs.String("http-socket", "", "Unix socket for the HTTP API, authenticated by the client's OS user")
+		peerMapFile  = fs.String("peer-map", "", "File mapping OS users to the principals they connect as over Unix sockets")
+		bind         = fs.String("bind", "", "Comma-separated addresses listeners bind, each optionally for one listener as name=address (default: all interfaces, IPv4 and IPv6)")
+		codePage     = fs.Int("code-page", 1252, "Code page of VARCHAR data for TDS clients whose login reports no locale")
+
+		// SQL logins
+		loginListeners     = fs.String("login-listeners", "", "Comma-separated listeners whose clients must sign in with a SQL login: tds, postgres, http")
+		enforcePermissions = fs.Bool("enforce-permissions", false, "Check what SQL logins do against the permissions GRANT, DENY and REVOKE give them")
+		adminLogins        = fs.String("admin-logins", "", "Comma-separated SQL logins whose permissions are not checked")
+
+		// Read-only serving
+		readOnly          = fs.Bool("read-only", false, "Reject statements that change the database on every listener")
+		readOnlyListeners = fs.String("read-only-listeners", "", "Comma-separated listeners that reject statements changing the database: tds, postgres, mysql, http, grpc")
+
+		// SQL firewall
+		firewallRules          = fs.String("firewall", "", "JSON file of rules allowing or denying requests by listener, user and SQL")
+		parameterizedListeners = fs.String("parameterized-listeners", "", "Comma-separated listeners that reject ad-hoc SQL with string literals unless sent with parameters")
+
+		// Sandbox
+		sandboxPolicies = fs.String("sandbox", "", "JSON file of policies denying procedures dynamic SQL, externals, DDL or other databases")
+
+		// Hooks
+		hookFile = fs.String("hooks", "", "JSON file of hook plugins and the hooks run at login and around statements")
 
 		// Runtime options
 		dialect      = fs.String("dialect", "tsql", "Default SQL dialect (tsql, postgres, mysql)")
@@ -49,16 +134,130 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 		jitThreshold = fs.Int("jit-threshold", 100, "Execution count before JIT compilation")
 		maxConns     = fs.Int("max-conns", 1000, "Maximum concurrent connections")
 		execTimeout  = fs.Duration("exec-timeout", 30*time.Second, "Default execution timeout")
+		maxNesting   = fs.Int("max-nesting-level", 32, "Maximum depth of nested procedure calls and dynamic SQL")
+		numbersSize  = fs.Int("numbers-size", 100000, "Rows of the built-in dbo.Numbers and dbo.Tally tables on SQLite")
+		legacyNorm   = fs.Bool("legacy-sql-normalizer", false, "Also run the deprecated regex SQL normaliser on rewritten queries")
+		autoParam    = fs.Bool("auto-parameterize", false, "Send the literals queries compare with to the backend as parameters")
+		contracts    = fs.String("result-contracts", "warn", "Results breaking a procedure's result contract: off, warn or enforce")
+		stmtSummary  = fs.Bool("statement-summary", false, "Report what each statement did with every execution")
+		streamResults = fs.Bool("stream-results", false, "Send a single SELECT's rows as they are read over TDS, PostgreSQL and streamed HTTP")
+		deprecationWarnings = fs.Bool("deprecation-warnings", false, "Warn clients that call a deprecated procedure")
+
+		// Admission control
+		queueInteractive = fs.Int("queue-interactive", 1000, "Interactive executions allowed to wait for a slot")
+		queueBatch       = fs.Int("queue-batch", 100, "Batch executions allowed to wait for a slot")
+		queueTimeout     = fs.Duration("queue-timeout", 10*time.Second, "Longest wait for a slot before a server busy error")
+		batchApps        = fs.String("batch-apps", "", "Comma-separated application name patterns that use the batch lane")
+
+		// Circuit breakers
+		breakerEnabled   = fs.Bool("breaker", false, "Quarantine procedures that keep failing or running slowly")
+		breakerErrorRate = fs.Float64("breaker-error-rate", 0.5, "Share of failed calls that trips a procedure's breaker")
+		breakerSlowCall  = fs.Duration("breaker-slow-call", 0, "Calls slower than this count towards tripping (0 = off)")
+		breakerCooldown  = fs.Duration("breaker-cooldown", 30*time.Second, "Time a tripped procedure is quarantined before a trial call")
+		breakerSeverity  = fs.Int("breaker-severity", 16, "Severity of the error returned by a quarantined procedure")
+
+		// Shadow execution
+		shadowEnabled      = fs.Bool("shadow", false, "Run procedures changed by hot reload in shadow before they replace the current version")
+		shadowSampleRate   = fs.Float64("shadow-sample-rate", 0.1, "Share of calls that also run a procedure's shadow candidate")
+		shadowPromoteAfter = fs.Int("shadow-promote-after", 0, "Matching shadow runs that promote a candidate with no divergences (0 = promote by hand)")
+
+		// Memory budgets
+		memoryLimit        = fs.String("memory-limit", "0", "Memory all executions may hold in result sets and temp objects, e.g. 4GB (0 = unlimited)")
+		sessionMemoryLimit = fs.String("session-memory-limit", "0", "Memory one session may hold in result sets and temp objects (0 = unlimited)")
+
+		// Statement journal
+		journalPath   = fs.String("journal", "", "Journal procedure executions to this file for crash recovery")
+		journalSync   = fs.Bool("journal-sync", true, "Flush each journal record to disk before continuing")
+		journalReplay = fs.Bool("journal-replay", false, "Re-run executions interrupted by a crash on startup (idempotent procedures only)")
+
+		// Notifications
+		notifyConfig = fs.String("notify-config", "", "JSON file of webhooks told of server events")
+
+		// Feature flags
+		featuresConfig = fs.String("features-config", "", "JSON file of feature flags read by FEATURE()")
+
+		// Data subject erasure
+		erasureMap = fs.String("erasure-map", "", "JSON file of the tables holding personal data, for /admin/erasure")
+		erasureKey = fs.String("erasure-key-file", "", "File of the secret key erasure certificates fingerprint subjects with")
+
+		// Database Mail
+		mailConfig = fs.String("mail-config", "", "JSON file of mail profiles sp_send_dbmail sends through")
+
+		// HTTP callouts
+		restAllow       = fs.String("rest-allow-hosts", "", "Comma-separated hosts sp_invoke_external_rest_endpoint may call (default: none)")
+		restTimeout     = fs.Duration("rest-timeout", tsqlruntime.DefaultRESTTimeout, "Longest sp_invoke_external_rest_endpoint call")
+		restMaxResponse = fs.Int64("rest-max-response", tsqlruntime.DefaultRESTMaxResponseBytes, "Largest response body sp_invoke_external_rest_endpoint accepts, in bytes")
+
+		// WASM extensions
+		extensionsDir    = fs.String("extensions-dir", "", "Directory of .wasm modules whose functions T-SQL may call")
+		extensionMemory  = fs.String("extension-memory", "16MB", "Memory each instance of an extension module may use")
+		extensionTimeout = fs.Duration("extension-timeout", extensions.DefaultTimeout, "Longest a call to an extension function may run")
+
+		// Scripting
+		scripts       = fs.Bool("scripts", false, "Let sp_execute_script run sandboxed Lua scripts")
+		scriptTimeout = fs.Duration("script-timeout", tsqlruntime.DefaultScriptTimeout, "Longest a sp_execute_script script may run")
+		scriptMemory  = fs.String("script-memory", "16MB", "Memory each sp_execute_script script may hold")
+
+		// Operating system commands
+		cmdShellConfig = fs.String("cmdshell", "", "JSON file of the command templates xp_cmdshell may run (default: xp_cmdshell off)")
+
+		// sqlcmd scripts
+		sqlcmdMode       = fs.Bool("sqlcmd", false, "Process sqlcmd commands and $(var) variables in TDS batches")
+		sqlcmdIncludeDir = fs.String("sqlcmd-include-dir", "", "Directory :r may include scripts from (default: :r disabled)")
 
 		// Storage options
-		storageType = fs.String("storage", "sqlite", "Storage backend: memory, sqlite")
-		storagePath = fs.String("storage-path", ":memory:", "Storage path (for sqlite: file path or :memory:)")
+		storageType    = fs.String("storage", "sqlite", "Storage backend: memory, sqlite, or one a --storage-plugin registers")
+		storagePath    = fs.String("storage-path", ":memory:", "Storage path (for sqlite: file path or :memory:)")
+		storagePlugins = fs.String("storage-plugin", "", "Comma-separated Go plugins (.so) registering storage backends")
+
+		// Statistics
+		statsInterval  = fs.Duration("stats-interval", time.Hour, "Time between statistics refreshes on sqlite (0 = never)")
+		statsThreshold = fs.Float64("stats-threshold", tsqlruntime.DefaultStatisticsThreshold, "Share of a table's rows that must change before its statistics are refreshed")
+
+		// Index advisor
+		advisorMode     = fs.String("index-advisor", "recommend", "Missing index recommendations from the workload: off, recommend or auto")
+		advisorMinSeeks = fs.Int64("index-advisor-min-seeks", 100, "Queries that must want an index before auto mode creates it")
+		advisorInterval = fs.Duration("index-advisor-interval", 10*time.Minute, "Time between auto mode's index creation passes")
+
+		// Pinned tables
+		pinTables  = fs.String("pin-tables", "", "Comma-separated small reference tables served from memory")
+		pinMaxRows = fs.Int("pin-max-rows", tsqlruntime.DefaultTableCacheMaxRows, "Rows beyond which a pinned table is read from the backend")
+		pinRefresh = fs.Duration("pin-refresh", 0, "Age at which a pinned table is reloaded (0 = only after writes)")
+
+		// Archival tables
+		archiveTables    = fs.String("archive-tables", "", "Comma-separated archival tables, as table:column:partition:keep")
+		archiveStore     = fs.String("archive-store", "", "Where archived partitions are kept: s3://bucket/prefix or a directory")
+		archiveEndpoint  = fs.String("archive-endpoint", "", "S3 endpoint of a service other than AWS, such as MinIO")
+		archiveRegion    = fs.String("archive-region", "", "S3 region (default: AWS_REGION, or "+archive.DefaultRegion+")")
+		archiveInterval  = fs.Duration("archive-interval", archive.DefaultInterval, "Time between archiving runs")
+		archiveCacheRows = fs.Int("archive-cache-rows", archive.DefaultCacheRows, "Restored archived rows kept for queries")
+
+		// Cluster
+		clusterLocks = fs.String("cluster-locks", "", "Lock provider shared with the other servers on the storage backend: table (default: not clustered)")
+		nodeID       = fs.String("node-id", "", "Name of this server in the cluster (default: host name and process ID)")
+		clusterLease = fs.Duration("cluster-lease", runtime.DefaultClusterLease, "How long a stopped node's cluster locks are kept")
 
 		// Logging
 		logLevel   = fs.String("log-level", "info", "Log level (debug, info, warn, error)")
 		logFormat  = fs.String("log-format", "text", "Log format (text, json)")
 		logQueries = fs.Bool("log-queries", false, "Log all SQL queries received")
 		logQueriesRewritten = fs.Bool("log-queries-rewritten", false, "Log queries after rewriting (before backend execution)")
+		logLevels   = fs.String("log-levels", "", "Per-category log levels, e.g. protocol=debug,storage=warn")
+		logFile     = fs.String("log-file", "", "Also write logs to this file")
+		logFileSize = fs.String("log-file-max-size", "100MB", "Size at which the log file is rotated (0 = never)")
+		logFileKeep = fs.Int("log-file-max-backups", 5, "Rotated log files kept")
+		logSyslog   = fs.String("log-syslog", "", "Also send logs to syslog: local, udp://host:port or tcp://host:port")
+		logOTLP     = fs.String("log-otlp-endpoint", "", "Also export logs to this OTLP/HTTP collector, e.g. http://localhost:4318")
+		httpAdmin   = fs.Bool("http-admin", false, "Serve admin routes (/admin/log-levels, /admin/shadow, /admin/deploy, /admin/features, /admin/compatibility, /admin/traces, /admin/deprecations) on the HTTP API")
+		httpTokenFile = fs.String("http-token-file", "", "File of bearer tokens accepted by the HTTP API, one per line")
+		httpAccessLog = fs.Bool("http-access-log", false, "Log each HTTP API request")
+		httpLogRedact = fs.String("http-log-redact", strings.Join(aulhttp.DefaultRedactedParams, ","), "Comma-separated parameter names masked in the HTTP access log")
+		httpLogSample = fs.Float64("http-log-sample", 1, "Share of successful HTTP requests logged (failures are always logged)")
+		httpLogSkip   = fs.String("http-log-skip", "", "Comma-separated HTTP routes never logged, e.g. /health")
+		httpMaxPage   = fs.Int("http-max-page-size", 0, "Most rows in a page of an HTTP API result; larger results are paged (0 = no limit)")
+
+		// Diagnostics
+		captureDir = fs.String("capture-dir", "", "Record TDS/PostgreSQL wire traffic to this directory")
 
 		// Help and version
 		showHelp     = fs.Bool("h", false, "Show help")
@@ -66,6 +265,7 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 		showVersion  = fs.Bool("v", false, "Show version")
 		showVersionL = fs.Bool("version", false, "Show version")
 		noBanner     = fs.Bool("no-banner", false, "Suppress startup banner")
+		printConfig  = fs.Bool("print-config", false, "Print the effective configuration and exit")
 	)
 
 	fs.Usage = func() {
@@ -103,73 +303,558 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 		return 0
 	}
 
-	// Build configuration
+	// Build configuration, starting from the config file if there is one
 	cfg := server.DefaultConfig()
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile, fs, &cfg); err != nil {
+			fmt.Fprintf(stderr, "error loading config: %v\n", err)
+			return 1
+		}
+	}
+	cfg.Name = *serverName
 	cfg.Version = version.Version
 	cfg.ProcedureDir = *procDir
 	cfg.WatchChanges = *watchFiles
+	cfg.ProcLoadWorkers = *loadWorkers
+	cfg.StrictCompatibility = *strict
+	cfg.InitDir = *initDir
 	cfg.DefaultDialect = *dialect
+	cfg.LegacySQLNormalizer = *legacyNorm
+	cfg.AutoParameterize = *autoParam
 	cfg.JITEnabled = *jitEnabled
 	cfg.JITThreshold = *jitThreshold
 	cfg.MaxConcurrency = *maxConns
 	cfg.ExecTimeout = *execTimeout
+	cfg.MaxNestingLevel = *maxNesting
+	cfg.NumbersSize = *numbersSize
+	contractMode, err := runtime.ParseContractMode(*contracts)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: --result-contracts: %v\n", err)
+		return 2
+	}
+	cfg.Contracts = contractMode
+	cfg.StatementSummary = *stmtSummary
+	cfg.StreamResults = *streamResults
+	cfg.DeprecationWarnings = *deprecationWarnings
+	cfg.Admission.InteractiveQueue = queueSize(*queueInteractive)
+	cfg.Admission.BatchQueue = queueSize(*queueBatch)
+	cfg.Admission.QueueTimeout = *queueTimeout
+	cfg.BatchApps = append(cfg.BatchApps, splitList(*batchApps)...)
+	cfg.Breaker.Enabled = *breakerEnabled
+	cfg.Breaker.ErrorRate = *breakerErrorRate
+	cfg.Breaker.SlowCall = *breakerSlowCall
+	cfg.Breaker.Cooldown = *breakerCooldown
+	if *breakerSeverity < 11 || *breakerSeverity > 25 {
+		fmt.Fprintln(stderr, "error: --breaker-severity must be between 11 and 25")
+		return 2
+	}
+	cfg.Breaker.Severity = uint8(*breakerSeverity)
+	cfg.Shadow.Enabled = *shadowEnabled
+	if *shadowSampleRate <= 0 || *shadowSampleRate > 1 {
+		fmt.Fprintln(stderr, "error: --shadow-sample-rate must be above 0 and at most 1")
+		return 2
+	}
+	cfg.Shadow.SampleRate = *shadowSampleRate
+	cfg.Shadow.PromoteAfter = *shadowPromoteAfter
+	if *shadowEnabled && !*watchFiles {
+		fmt.Fprintln(stderr, "warning: --shadow has no effect without --watch")
+	}
+	for _, limit := range []struct {
+		flag  string
+		value string
+		dest  *int64
+	}{
+		{"--memory-limit", *memoryLimit, &cfg.Memory.Limit},
+		{"--session-memory-limit", *sessionMemoryLimit, &cfg.Memory.SessionLimit},
+	} {
+		n, err := parseByteSize(limit.value)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %s: %v\n", limit.flag, err)
+			return 2
+		}
+		*limit.dest = n
+	}
+	cfg.Journal.Path = *journalPath
+	cfg.Journal.Sync = *journalSync
+	cfg.Journal.Replay = *journalReplay
+	if cfg.Journal.Replay && cfg.Journal.Path == "" {
+		fmt.Fprintln(stderr, "error: --journal-replay requires --journal")
+		return 2
+	}
+	if *notifyConfig != "" {
+		notifyCfg, err := notify.LoadConfig(*notifyConfig)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: --notify-config: %v\n", err)
+			return 1
+		}
+		cfg.Notify = notifyCfg
+	}
+	if *featuresConfig != "" {
+		featuresCfg, err := features.LoadConfig(*featuresConfig)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: --features-config: %v\n", err)
+			return 1
+		}
+		cfg.Features = featuresCfg
+	}
+	if *erasureMap != "" {
+		erasureCfg, err := erasure.LoadConfig(*erasureMap)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: --erasure-map: %v\n", err)
+			return 1
+		}
+		if *erasureKey == "" {
+			fmt.Fprintf(stderr, "error: --erasure-map needs --erasure-key-file\n")
+			return 1
+		}
+		if erasureCfg.Key, err = erasure.LoadKey(*erasureKey); err != nil {
+			fmt.Fprintf(stderr, "error: --erasure-key-file: %v\n", err)
+			return 1
+		}
+		cfg.Erasure = erasureCfg
+	}
+	if *mailConfig != "" {
+		mailCfg, err := mail.LoadConfig(*mailConfig)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: --mail-config: %v\n", err)
+			return 1
+		}
+		cfg.Mail = mailCfg
+	}
+	if *cmdShellConfig != "" {
+		shellCfg, err := cmdshell.LoadConfig(*cmdShellConfig)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: --cmdshell: %v\n", err)
+			return 1
+		}
+		cfg.CmdShell = shellCfg
+	}
+	cfg.REST.AllowedHosts = splitList(*restAllow)
+	cfg.REST.Timeout = *restTimeout
+	cfg.REST.MaxResponseBytes = *restMaxResponse
+	cfg.Extensions.Dir = *extensionsDir
+	cfg.Extensions.Timeout = *extensionTimeout
+	if cfg.Extensions.MemoryLimit, err = parseByteSize(*extensionMemory); err != nil {
+		fmt.Fprintf(stderr, "error: --extension-memory: %v\n", err)
+		return 2
+	}
+	cfg.Scripts.Enabled = *scripts
+	cfg.Scripts.Timeout = *scriptTimeout
+	if cfg.Scripts.MemoryLimit, err = parseByteSize(*scriptMemory); err != nil {
+		fmt.Fprintf(stderr, "error: --script-memory: %v\n", err)
+		return 2
+	}
+	cfg.SQLCmdMode = *sqlcmdMode
+	cfg.SQLCmdIncludeDir = *sqlcmdIncludeDir
+	if cfg.SQLCmdIncludeDir != "" && !cfg.SQLCmdMode {
+		fmt.Fprintln(stderr, "error: --sqlcmd-include-dir requires --sqlcmd")
+		return 2
+	}
 	cfg.LogLevel = *logLevel
 	cfg.LogFormat = *logFormat
 	cfg.LogQueries = *logQueries
 	cfg.LogQueriesRewritten = *logQueriesRewritten
+	for _, item := range splitList(*logLevels) {
+		name, level, ok := strings.Cut(item, "=")
+		if !ok {
+			fmt.Fprintf(stderr, "error: --log-levels: %q is not category=level\n", item)
+			return 2
+		}
+		if cfg.LogLevels == nil {
+			cfg.LogLevels = make(map[string]string)
+		}
+		cfg.LogLevels[strings.TrimSpace(name)] = strings.TrimSpace(level)
+	}
+	logFileMaxSize, err := parseByteSize(*logFileSize)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: --log-file-max-size: %v\n", err)
+		return 2
+	}
+	cfg.LogSinks = log.SinkConfig{
+		File:           *logFile,
+		FileMaxSize:    logFileMaxSize,
+		FileMaxBackups: *logFileKeep,
+		Syslog:         *logSyslog,
+		OTLPEndpoint:   *logOTLP,
+		ServiceName:    cfg.Name,
+	}
 
-	// Configure storage backend
+	// Configure storage backend, loading the plugins that register
+	// backends first
+	for _, path := range splitList(*storagePlugins) {
+		if err := runtime.LoadStoragePlugin(path); err != nil {
+			fmt.Fprintf(stderr, "error: --storage-plugin: %v\n", err)
+			return 1
+		}
+	}
 	cfg.StorageConfig.Type = *storageType
 	if cfg.StorageConfig.Options == nil {
 		cfg.StorageConfig.Options = make(map[string]string)
 	}
 	cfg.StorageConfig.Options["path"] = *storagePath
-
-	// Load config file if specified
-	if *configFile != "" {
-		if err := loadConfigFile(*configFile, &cfg); err != nil {
-			fmt.Fprintf(stderr, "error loading config: %v\n", err)
-			return 1
-		}
+	cfg.Statistics = runtime.StatisticsConfig{Interval: *statsInterval, Threshold: *statsThreshold}
+	advisor, err := runtime.ParseIndexAdvisorMode(*advisorMode)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: --index-advisor: %v\n", err)
+		return 2
+	}
+	if *advisorMinSeeks < 1 || *advisorInterval <= 0 {
+		fmt.Fprintln(stderr, "error: --index-advisor-min-seeks and --index-advisor-interval must be positive")
+		return 2
+	}
+	cfg.IndexAdvisor.Mode = advisor
+	cfg.IndexAdvisor.MinSeeks = *advisorMinSeeks
+	cfg.IndexAdvisor.Interval = *advisorInterval
+	if *pinMaxRows < 1 || *pinRefresh < 0 {
+		fmt.Fprintln(stderr, "error: --pin-max-rows must be positive and --pin-refresh not negative")
+		return 2
+	}
+	cfg.TableCache = tsqlruntime.TableCacheConfig{
+		Tables:  splitList(*pinTables),
+		MaxRows: *pinMaxRows,
+		Refresh: *pinRefresh,
+	}
+	archival, err := archive.ParseTables(*archiveTables)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: --archive-tables: %v\n", err)
+		return 2
+	}
+	if len(archival) > 0 && *archiveStore == "" {
+		fmt.Fprintln(stderr, "error: --archive-tables needs --archive-store")
+		return 2
 	}
+	if *archiveInterval <= 0 || *archiveCacheRows < 1 {
+		fmt.Fprintln(stderr, "error: --archive-interval and --archive-cache-rows must be positive")
+		return 2
+	}
+	cfg.Archive = archive.Config{
+		Store:     *archiveStore,
+		Endpoint:  *archiveEndpoint,
+		Region:    *archiveRegion,
+		Interval:  *archiveInterval,
+		CacheRows: *archiveCacheRows,
+		Tables:    archival,
+	}
+	if *clusterLease <= 0 {
+		fmt.Fprintln(stderr, "error: --cluster-lease must be positive")
+		return 2
+	}
+	cfg.Cluster = runtime.ClusterConfig{Provider: *clusterLocks, Node: *nodeID, Lease: *clusterLease}
 
 	// Configure protocol listeners
 	if *tdsPort > 0 {
-		cfg.Listeners = append(cfg.Listeners, protocol.ListenerConfig{
+		addListener(&cfg, protocol.ListenerConfig{
 			Name:     "tds",
 			Protocol: protocol.ProtocolTDS,
 			Port:     *tdsPort,
 		})
 	}
 	if *postgresPort > 0 {
-		cfg.Listeners = append(cfg.Listeners, protocol.ListenerConfig{
+		addListener(&cfg, protocol.ListenerConfig{
 			Name:     "postgres",
 			Protocol: protocol.ProtocolPostgres,
 			Port:     *postgresPort,
 		})
 	}
 	if *mysqlPort > 0 {
-		cfg.Listeners = append(cfg.Listeners, protocol.ListenerConfig{
+		addListener(&cfg, protocol.ListenerConfig{
 			Name:     "mysql",
 			Protocol: protocol.ProtocolMySQL,
 			Port:     *mysqlPort,
 		})
 	}
 	if *httpPort > 0 {
-		cfg.Listeners = append(cfg.Listeners, protocol.ListenerConfig{
+		addListener(&cfg, protocol.ListenerConfig{
 			Name:     "http",
 			Protocol: protocol.ProtocolHTTP,
 			Port:     *httpPort,
 		})
 	}
 	if *grpcPort > 0 {
-		cfg.Listeners = append(cfg.Listeners, protocol.ListenerConfig{
+		addListener(&cfg, protocol.ListenerConfig{
 			Name:     "grpc",
 			Protocol: protocol.ProtocolGRPC,
 			Port:     *grpcPort,
 		})
 	}
 
+	// Unix socket listeners, whose clients are who their OS user is
+	if *pgSocket != "" {
+		addListener(&cfg, protocol.ListenerConfig{
+			Name:     "pg-socket",
+			Protocol: protocol.ProtocolPostgres,
+			Socket:   *pgSocket,
+		})
+	}
+	if *httpSocket != "" {
+		addListener(&cfg, protocol.ListenerConfig{
+			Name:     "http-socket",
+			Protocol: protocol.ProtocolHTTP,
+			Socket:   *httpSocket,
+		})
+	}
+	var peers *protocol.PeerMap
+	for i := range cfg.Listeners {
+		if cfg.Listeners[i].Socket == "" {
+			continue
+		}
+		if peers == nil {
+			peers = &protocol.PeerMap{}
+			if *peerMapFile != "" {
+				var err error
+				if peers, err = protocol.ReadPeerMap(*peerMapFile); err != nil {
+					fmt.Fprintf(stderr, "error: --peer-map: %v\n", err)
+					return 1
+				}
+			}
+		}
+		cfg.Listeners[i].PeerAuth = peers
+	}
+
+	// Addresses to bind: name=address entries for one listener, the
+	// others for every listener without any
+	shared, byListener := []string{}, map[string][]string{}
+	for _, entry := range splitList(*bind) {
+		name, addr, ok := strings.Cut(entry, "=")
+		if !ok {
+			shared = append(shared, entry)
+			continue
+		}
+		found := false
+		for _, l := range cfg.Listeners {
+			found = found || strings.EqualFold(l.Name, name)
+		}
+		if !found {
+			fmt.Fprintf(stderr, "error: --bind names %q, which is not enabled\n", name)
+			return 2
+		}
+		byListener[strings.ToLower(name)] = append(byListener[strings.ToLower(name)], addr)
+	}
+	for i := range cfg.Listeners {
+		if hosts, ok := byListener[strings.ToLower(cfg.Listeners[i].Name)]; ok {
+			cfg.Listeners[i].Hosts = hosts
+		} else if len(shared) > 0 {
+			cfg.Listeners[i].Hosts = shared
+		}
+	}
+
+	// Listeners that may only read
+	cfg.ReadOnly = *readOnly
+	for _, name := range splitList(*readOnlyListeners) {
+		found := false
+		for i := range cfg.Listeners {
+			if strings.EqualFold(cfg.Listeners[i].Name, name) {
+				cfg.Listeners[i].ReadOnly = true
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintf(stderr, "error: --read-only-listeners names %q, which is not enabled\n", name)
+			return 2
+		}
+	}
+
+	// Listeners whose clients sign in with SQL logins
+	for _, name := range splitList(*loginListeners) {
+		found := false
+		for i := range cfg.Listeners {
+			if strings.EqualFold(cfg.Listeners[i].Name, name) {
+				cfg.Listeners[i].RequireLogin = true
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintf(stderr, "error: --login-listeners names %q, which is not enabled\n", name)
+			return 2
+		}
+	}
+	cfg.Permissions = auth.PermissionConfig{
+		Enforce:        *enforcePermissions,
+		Administrators: splitList(*adminLogins),
+	}
+
+	// Listeners that take values only as parameters
+	for _, name := range splitList(*parameterizedListeners) {
+		found := false
+		for i := range cfg.Listeners {
+			if strings.EqualFold(cfg.Listeners[i].Name, name) {
+				cfg.Listeners[i].ParameterizedOnly = true
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintf(stderr, "error: --parameterized-listeners names %q, which is not enabled\n", name)
+			return 2
+		}
+	}
+
+	// Rules requests must pass, whose listeners must be enabled
+	if *firewallRules != "" {
+		fwCfg, err := firewall.LoadConfig(*firewallRules)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: --firewall: %v\n", err)
+			return 1
+		}
+		for _, rule := range fwCfg.Rules {
+			for _, name := range rule.Listeners {
+				found := false
+				for _, l := range cfg.Listeners {
+					found = found || strings.EqualFold(l.Name, name)
+				}
+				if !found {
+					fmt.Fprintf(stderr, "error: --firewall rule %s names listener %q, which is not enabled\n", rule.Name, name)
+					return 2
+				}
+			}
+		}
+		cfg.Firewall = fwCfg
+	}
+
+	// Policies denying capabilities to procedures' statements
+	if *sandboxPolicies != "" {
+		sbCfg, err := sandbox.LoadConfig(*sandboxPolicies)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: --sandbox: %v\n", err)
+			return 1
+		}
+		cfg.Sandbox = sbCfg
+	}
+
+	// Hooks, whose plugins are loaded when the server starts
+	if *hookFile != "" {
+		hooksCfg, err := hooks.LoadConfig(*hookFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: --hooks: %v\n", err)
+			return 1
+		}
+		cfg.Hooks = hooksCfg
+	}
+
+	// Serve the admin routes
+	if *httpAdmin {
+		for i := range cfg.Listeners {
+			if cfg.Listeners[i].Protocol != protocol.ProtocolHTTP {
+				continue
+			}
+			if cfg.Listeners[i].Options == nil {
+				cfg.Listeners[i].Options = make(map[string]interface{})
+			}
+			cfg.Listeners[i].Options[aulhttp.OptionAdmin] = true
+		}
+	}
+
+	// Require a token on the HTTP API
+	if *httpTokenFile != "" {
+		tokens, err := readTokenFile(*httpTokenFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "error reading HTTP tokens: %v\n", err)
+			return 1
+		}
+		for i := range cfg.Listeners {
+			if cfg.Listeners[i].Protocol != protocol.ProtocolHTTP {
+				continue
+			}
+			if cfg.Listeners[i].Options == nil {
+				cfg.Listeners[i].Options = make(map[string]interface{})
+			}
+			cfg.Listeners[i].Options[aulhttp.OptionTokens] = tokens
+		}
+	}
+
+	// Configure the HTTP access log
+	if *httpLogSample < 0 || *httpLogSample > 1 {
+		fmt.Fprintln(stderr, "error: --http-log-sample must be between 0 and 1")
+		return 2
+	}
+	if *httpAccessLog {
+		for i := range cfg.Listeners {
+			if cfg.Listeners[i].Protocol != protocol.ProtocolHTTP {
+				continue
+			}
+			if cfg.Listeners[i].Options == nil {
+				cfg.Listeners[i].Options = make(map[string]interface{})
+			}
+			cfg.Listeners[i].Options[aulhttp.OptionAccessLog] = true
+			cfg.Listeners[i].Options[aulhttp.OptionAccessLogRedact] = splitList(*httpLogRedact)
+			cfg.Listeners[i].Options[aulhttp.OptionAccessLogSample] = *httpLogSample
+			cfg.Listeners[i].Options[aulhttp.OptionAccessLogSkip] = splitList(*httpLogSkip)
+		}
+	}
+
+	// Cap the pages of HTTP API results
+	if *httpMaxPage < 0 {
+		fmt.Fprintln(stderr, "error: --http-max-page-size must not be negative")
+		return 2
+	}
+	if *httpMaxPage > 0 {
+		for i := range cfg.Listeners {
+			if cfg.Listeners[i].Protocol != protocol.ProtocolHTTP {
+				continue
+			}
+			if cfg.Listeners[i].Options == nil {
+				cfg.Listeners[i].Options = make(map[string]interface{})
+			}
+			cfg.Listeners[i].Options[aulhttp.OptionMaxPageSize] = *httpMaxPage
+		}
+	}
+
+	// Set the code page of TDS clients that report no locale
+	if _, err := tds.CharsetForCodePage(*codePage); err != nil {
+		fmt.Fprintf(stderr, "error: --code-page: %v\n", err)
+		return 2
+	}
+	for i := range cfg.Listeners {
+		if cfg.Listeners[i].Protocol != protocol.ProtocolTDS {
+			continue
+		}
+		if cfg.Listeners[i].Options == nil {
+			cfg.Listeners[i].Options = make(map[string]interface{})
+		}
+		cfg.Listeners[i].Options[aultds.OptionCodePage] = *codePage
+	}
+
+	// Enable wire capture on the listeners that support it
+	if *captureDir != "" {
+		if err := os.MkdirAll(*captureDir, 0o755); err != nil {
+			fmt.Fprintf(stderr, "error creating capture directory: %v\n", err)
+			return 1
+		}
+		for i := range cfg.Listeners {
+			switch cfg.Listeners[i].Protocol {
+			case protocol.ProtocolTDS, protocol.ProtocolPostgres:
+				if cfg.Listeners[i].Options == nil {
+					cfg.Listeners[i].Options = make(map[string]interface{})
+				}
+				cfg.Listeners[i].Options["capture_dir"] = *captureDir
+			}
+		}
+	}
+
+	// Print the configuration in the config file's format, which -c reads
+	if *printConfig {
+		file := server.ConfigFileOf(cfg)
+		if *httpTokenFile != "" || *peerMapFile != "" || len(cfg.Logins) > 0 || *enforcePermissions || *adminLogins != "" {
+			file.Auth = &server.FileAuthConfig{TokenFile: httpTokenFile, PeerMap: peerMapFile,
+				Administrators: cfg.Permissions.Administrators}
+			if *enforcePermissions {
+				file.Auth.EnforcePermissions = enforcePermissions
+			}
+			for _, login := range cfg.Logins {
+				if login.Password != "" {
+					login.Password = "********" // Not printed
+				}
+				file.Auth.Logins = append(file.Auth.Logins, login)
+			}
+		}
+		data, err := json.MarshalIndent(file, "", "  ")
+		if err != nil {
+			fmt.Fprintf(stderr, "error printing config: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, string(data))
+		return 0
+	}
+
 	// Create server
 	srv, err := server.New(cfg)
 	if err != nil {
@@ -198,8 +883,12 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 	fmt.Fprintf(stdout, "  Storage: %s (%s)\n", *storageType, *storagePath)
 	fmt.Fprintf(stdout, "  Procedures loaded: %d\n", srv.Registry().Count())
 	fmt.Fprintf(stdout, "  JIT enabled: %v (threshold: %d)\n", cfg.JITEnabled, cfg.JITThreshold)
-	for _, l := range cfg.Listeners {
-		fmt.Fprintf(stdout, "  Listening: %s on port %d\n", l.Protocol, l.Port)
+	for _, l := range srv.Listeners() {
+		if l.ReadOnly {
+			fmt.Fprintf(stdout, "  Listening: %s on %s (read-only)\n", l.Protocol, strings.Join(l.Addresses, ", "))
+		} else {
+			fmt.Fprintf(stdout, "  Listening: %s on %s\n", l.Protocol, strings.Join(l.Addresses, ", "))
+		}
 	}
 
 	// Wait for shutdown signal
@@ -220,10 +909,70 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 	return 0
 }
 
-// loadConfigFile loads configuration from a file.
-func loadConfigFile(path string, cfg *server.Config) error {
-	// TODO: Implement YAML/JSON config file loading
-	return fmt.Errorf("config file loading not yet implemented")
+// readTokenFile reads API tokens, one per line. Blank lines and lines
+// starting with # are skipped.
+func readTokenFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s has no tokens", path)
+	}
+	return tokens, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// queueSize maps the CLI convention of 0 meaning "no queue" onto
+// runtime.AdmissionConfig, where 0 means the default.
+func queueSize(n int) int {
+	if n == 0 {
+		return -1
+	}
+	return n
+}
+
+// parseByteSize parses a size such as "512MB", "4GB" or "1048576". Units
+// are binary (1KB = 1024 bytes).
+func parseByteSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(n * float64(mult)), nil
 }
 
 func printUsage(w io.Writer) {
@@ -231,11 +980,42 @@ func printUsage(w io.Writer) {
 
 Usage:
   aul [options]
+  aul conformance [options]   Run the T-SQL compatibility suite (see aul conformance -h)
+  aul loadtest [options]      Run a load or soak test (see aul loadtest -h)
+  aul seed [options]          Generate demo data for a schema (see aul seed -h)
+  aul exec -f <file> [opts]   Run a sqlcmd script (see aul exec -h)
+  aul import mssql [options]  Migrate a SQL Server database (see aul import -h)
+  aul migrate [options] <dir> Apply Flyway or Liquibase migrations (see aul migrate -h)
+  aul gen ts [options]        Generate a typed TypeScript client (see aul gen -h)
+  aul validate [options]      Check procedures against their result contracts
+                              (see aul validate -h)
+  aul lint [options]          Check procedures for likely mistakes and slow
+                              queries (see aul lint -h)
+  aul deploy [options] [action]
+                              Switch a running server to a new procedure set
+                              (see aul deploy -h)
+  aul trace [options] [action]
+                              Show how procedures ran, step by step
+                              (see aul trace -h)
+  aul test [options]          Run procedure tests and report their coverage
+                              (see aul test -h)
 
 Server Options:
-  -c, --config <file>      Configuration file path
+  -c, --config <file>      Configuration file (YAML or JSON); flags override it
+  --print-config           Print the effective configuration as JSON and exit
   -d, --proc-dir <path>    Directory containing stored procedures (default: ./procedures)
   -w, --watch              Watch for file changes and hot-reload
+  --proc-load-workers <n>  Procedure files loaded at once at startup
+                           (default: 0, one per CPU)
+  --strict                 Refuse procedures that use T-SQL the interpreter
+                           cannot run (MERGE, BREAK, CREATE VIEW, ...) as
+                           they load, logging each construct with its file,
+                           line and a workaround
+  --init-dir <path>        Scripts run once each, in name order, against
+                           the storage backend at startup
+  --server-name <name>     Name procedures see in @@SERVERNAME and
+                           SERVERPROPERTY('ServerName'), also used in logs
+                           and notifications (default: aul)
 
 Protocol Listeners:
   --tds-port <port>        TDS protocol port (SQL Server compatible, 0 = disabled)
@@ -243,6 +1023,53 @@ Protocol Listeners:
   --mysql-port <port>      MySQL wire protocol port (0 = disabled)
   --http-port <port>       HTTP REST API port (default: 8080, 0 = disabled)
   --grpc-port <port>       gRPC port (0 = disabled)
+  --pg-socket <path>       Unix socket for PostgreSQL clients; each client
+                           is authenticated by its OS user (SO_PEERCRED,
+                           Linux only) and connects as the principal of
+                           the same name, or one --peer-map allows
+  --http-socket <path>     Unix socket for the HTTP API, authenticated the
+                           same way, in place of --http-token-file
+  --peer-map <file>        Map OS users to database principals, one
+                           "os-user principal..." line each; the first
+                           principal is used when the client names none
+  --login-listeners <list> Comma-separated listeners whose clients must
+                           sign in with a SQL login, from the config
+                           file's auth.logins: tds (LOGIN7), postgres
+                           (password) or http (Basic, besides tokens)
+  --enforce-permissions    Check what clients of those listeners do against
+                           the permissions GRANT, DENY and REVOKE give
+                           their logins (error 229 when denied); they are
+                           recorded either way
+  --admin-logins <list>    Comma-separated SQL logins whose permissions are
+                           not checked
+  --bind <list>            Comma-separated addresses every listener binds:
+                           IPv4 or IPv6 addresses ([::1] or ::1) or names,
+                           bound on each address they resolve to; an entry
+                           name=address binds one listener only, e.g.
+                           http=127.0.0.1,http=::1 (default: all
+                           interfaces, IPv4 and IPv6)
+  --code-page <n>          Code page of VARCHAR data for TDS clients whose
+                           login reports no locale; others use their
+                           locale's (default: 1252; 65001 for UTF-8)
+  --read-only              Reject statements that change the database, on
+                           every listener, with error 3906
+  --read-only-listeners <list>
+                           Comma-separated listeners that reject them: tds,
+                           postgres, mysql, http, grpc
+  --firewall <file>        JSON file of rules allowing or denying requests,
+                           before they run, by listener, user or role,
+                           procedure, statement kind, pattern or query
+                           fingerprint; denials go to the audit log
+  --sandbox <file>         JSON file of policies denying dynamic SQL,
+                           external calls, DDL or other databases to
+                           procedures, schemas, users or roles (error 50414)
+  --hooks <file>           JSON file of Go plugins and the hooks they register,
+                           run in order at login, before and after each
+                           statement and on each result set
+  --parameterized-listeners <list>
+                           Comma-separated listeners that reject ad-hoc SQL
+                           holding string literals unless it is sent with
+                           parameters (sp_executesql, HTTP parameters)
 
 Runtime Options:
   --dialect <name>         Default SQL dialect: tsql, postgres, mysql (default: tsql)
@@ -250,16 +1077,254 @@ Runtime Options:
   --jit-threshold <n>      Execution count before JIT compilation (default: 100)
   --max-conns <n>          Maximum concurrent connections (default: 1000)
   --exec-timeout <dur>     Default execution timeout (default: 30s)
+  --max-nesting-level <n>  Maximum depth of nested procedure calls and
+                           dynamic SQL (default: 32)
+  --numbers-size <n>       Rows of the built-in dbo.Numbers and dbo.Tally
+                           tables on SQLite (default: 100000)
+  --legacy-sql-normalizer  Also run the deprecated regex SQL normaliser after
+                           the AST rewriters; a stopgap for queries that relied
+                           on it, to be removed in a later release
+  --auto-parameterize      Send the literals queries compare with to the
+                           backend as parameters, so that queries differing
+                           only in their values share the backend's plans
+  --result-contracts <mode>
+                           What to do when a procedure's results break the
+                           columns it declares with -- @aul:result or a
+                           .contract.json file: off, warn (log them) or
+                           enforce (fail the call) (default: warn)
+  --statement-summary      Report each statement's type, table, rows and time
+                           with every execution: in a last result set over
+                           TDS and PostgreSQL, as statements over HTTP
+  --stream-results         Send the rows of a batch of one SELECT as they are
+                           read, rather than reading them all first, over
+                           TDS, PostgreSQL and streamed HTTP responses
+  --deprecation-warnings   Warn clients that call a procedure deprecated by
+                           -- @aul:deprecated or /admin/deprecations, as an
+                           info message or notice; calls are logged and
+                           counted either way
+
+Admission Control:
+  --queue-interactive <n>  Interactive executions that may wait once --max-conns
+                           are running (default: 1000, 0 = none)
+  --queue-batch <n>        Batch executions that may wait (default: 100, 0 = none)
+  --queue-timeout <dur>    Longest wait before a server busy error (default: 10s)
+  --batch-apps <list>      Application name patterns, e.g. "sqlcmd,etl-*", whose
+                           connections queue behind interactive work
+
+Circuit Breakers:
+  --breaker                Quarantine procedures that keep failing or running
+                           slowly; state is in sys.dm_aul_circuit_breakers
+  --breaker-error-rate <f> Share of the last 20 calls that must fail to trip
+                           (default: 0.5)
+  --breaker-slow-call <dur>
+                           Calls slower than this count towards tripping
+                           (default: 0, off)
+  --breaker-cooldown <dur> Quarantine time before a trial call (default: 30s)
+  --breaker-severity <n>   Severity of the error raised while quarantined,
+                           11-25 (default: 16)
+
+Shadow Execution:
+  --shadow                 Stage procedures changed by hot reload (-w) as
+                           candidates instead of replacing the current version;
+                           a sample of calls run the candidate as well and its
+                           results and latency are compared, in
+                           sys.dm_aul_shadow_procedures and /admin/shadow.
+                           Procedures that may write are replaced at once
+  --shadow-sample-rate <f> Share of calls that also run the candidate
+                           (default: 0.1)
+  --shadow-promote-after <n>
+                           Promote a candidate after this many matching runs
+                           with no divergence (default: 0, promote by hand)
+
+Memory Budgets:
+  --memory-limit <size>    Memory all executions may hold in result sets, temp
+                           tables and cursors, e.g. 4GB (default: 0, unlimited)
+  --session-memory-limit <size>
+                           Memory one session may hold (default: 0, unlimited);
+                           usage is in sys.dm_aul_memory_usage
+
+Statement Journal:
+  --journal <path>         Journal procedure executions and their writes;
+                           executions interrupted by a crash are reported at
+                           startup and in sys.dm_aul_recovery
+  --journal-sync           Flush each record to disk (default: true)
+  --journal-replay         Re-run interrupted executions on startup; only safe
+                           for idempotent procedures
+
+Notifications:
+  --notify-config <file>   JSON file of webhooks to POST server events to:
+                           procedure.reload_failed, breaker.opened,
+                           shadow.diverged, deployment.switched and
+                           deadlock.detected
+
+Feature Flags:
+  --features-config <file> JSON file of feature flags procedures read with
+                           FEATURE('name'), on for everyone, for tenants or
+                           for a share of sessions; /admin/features changes
+                           them while running
+
+Data Subject Erasure:
+  --erasure-map <file>     JSON file of the tables holding personal data, the
+                           columns identifying a subject in each and whether
+                           rows are deleted or overwritten; POST
+                           /admin/erasure erases a subject and writes a
+                           certificate to the audit log
+  --erasure-key-file <file>
+                           File of the secret key, of at least 32 bytes,
+                           the certificates' subject fingerprints are keyed
+                           with; required with --erasure-map
+
+Database Mail:
+  --mail-config <file>     JSON file of the SMTP servers and webhooks
+                           sp_send_dbmail sends through; progress shows in
+                           msdb.dbo.sysmail_allitems and sysmail_event_log
+
+HTTP Callouts:
+  --rest-allow-hosts <list>
+                           Hosts sp_invoke_external_rest_endpoint may call,
+                           comma-separated: api.example.com, host:8443 or
+                           *.example.com (default: none, calls refused)
+  --rest-timeout <dur>     Longest call; a larger @timeout is cut to it
+                           (default: 30s)
+  --rest-max-response <n>  Largest response body accepted, in bytes
+                           (default: 1048576)
+
+WASM Extensions:
+  --extensions-dir <dir>   Directory of .wasm modules whose exported functions
+                           T-SQL calls as name(...) or dbo.name(...), typed by
+                           their signatures or a manifest beside each module
+  --extension-memory <size>
+                           Memory each module instance may use (default: 16MB)
+  --extension-timeout <dur>
+                           Longest a call may run before it is stopped
+                           (default: 1s)
+
+Scripting:
+  --scripts                Let sp_execute_script run Lua scripts, sandboxed
+                           without files, OS access or module loading
+                           (default: off, calls refused)
+  --script-timeout <dur>   Longest a script may run before it is stopped
+                           (default: 5s)
+  --script-memory <size>   Memory a script may hold before it is stopped
+                           (default: 16MB)
+
+Operating System Commands:
+  --cmdshell <file>        JSON file of the command templates xp_cmdshell may
+                           run, each a program and its arguments with
+                           {parameters} checked by pattern; run without a
+                           shell and written to the audit log (default:
+                           none, xp_cmdshell turned off)
+
+sqlcmd Scripts:
+  --sqlcmd                 Process sqlcmd scripts sent as TDS batches: GO,
+                           :setvar, $(var), :on error and :exit; variables
+                           last for the session
+  --sqlcmd-include-dir <path>
+                           Directory :r may include scripts from
+                           (default: none, :r disabled)
 
 Storage Options:
-  --storage <type>         Storage backend: memory, sqlite (default: sqlite)
+  --storage <type>         Storage backend: memory, sqlite, or a plugin's (default: sqlite)
   --storage-path <path>    Storage path for sqlite (default: :memory:)
+  --storage-plugin <files> Comma-separated Go plugins registering storage backends
+  --stats-interval <dur>   Time between refreshes of sqlite's planner
+                           statistics (default: 1h, 0 = never)
+  --stats-threshold <f>    Share of a table's rows that must be added or
+                           removed before it is analyzed again (default: 0.2)
+
+Index Advisor:
+  --index-advisor <mode>   Missing indexes from the workload: off, recommend
+                           (sys.dm_db_missing_index_details) or auto, which
+                           also creates them on sqlite (default: recommend)
+  --index-advisor-min-seeks <n>
+                           Queries that must want an index before auto mode
+                           creates it (default: 100)
+  --index-advisor-interval <dur>
+                           Time between auto mode's passes (default: 10m)
+
+Pinned Tables:
+  --pin-tables <list>      Small reference tables served from memory, for
+                           lookups and joins among them
+  --pin-max-rows <n>       Rows beyond which a pinned table is read from the
+                           backend (default: 10000)
+  --pin-refresh <dur>      Age at which a pinned table is reloaded, for writes
+                           made other than through aul (default: 0, only
+                           after writes through aul)
+
+Archival Tables (sqlite):
+  --archive-tables <list>  Tables whose old rows are archived, each as
+                           table:column:partition:keep, such as
+                           dbo.Orders:OrderDate:month:2160h; partitions of
+                           the date column (day, month or year) older than
+                           keep are moved to the archive store, and queries
+                           over their range read them back
+  --archive-store <url>    Where archived partitions are kept as Parquet:
+                           s3://bucket/prefix, with credentials from
+                           AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or a
+                           directory
+  --archive-endpoint <url> S3 endpoint of a service other than AWS, such as
+                           MinIO
+  --archive-region <name>  S3 region (default: AWS_REGION, or us-east-1)
+  --archive-interval <dur> Time between archiving runs (default: 1h)
+  --archive-cache-rows <n> Restored archived rows kept for queries, the least
+                           recently read dropped first (default: 1000000)
+
+Cluster:
+  --cluster-locks <provider>
+                           Share application locks with the other servers on
+                           the storage backend, and run scheduled jobs on
+                           one of them only: table (default: not clustered)
+  --node-id <name>         Name of this server in the cluster
+                           (default: host name and process ID)
+  --cluster-lease <dur>    How long the locks of a server that stops renewing
+                           them are kept (default: 30s)
 
 Logging:
   --log-level <level>      Log level: debug, info, warn, error (default: info)
   --log-format <format>    Log format: text, json (default: text)
   --log-queries            Log all SQL queries received
   --log-queries-rewritten  Log queries after rewriting (before backend execution)
+  --log-levels <list>      Per-category levels, e.g. protocol=debug,storage=warn;
+                           categories: system, execution, application, audit,
+                           performance, protocol, interpreter, storage
+  --log-file <path>        Also write logs to a file, rotated by size
+  --log-file-max-size <size>
+                           Size at which the file is rotated (default: 100MB,
+                           0 = never)
+  --log-file-max-backups <n>
+                           Rotated files kept as <path>.1 to <path>.n (default: 5)
+  --log-syslog <addr>      Also send logs to syslog: local (read by journald),
+                           udp://host:port or tcp://host:port
+  --log-otlp-endpoint <url>
+                           Also export logs to an OTLP/HTTP collector
+  --http-admin             Serve GET/PUT /admin/log-levels on the HTTP API to
+                           read and change levels while running,
+                           /admin/shadow to promote shadow candidates,
+                           /admin/deploy for aul deploy, /admin/features
+                           to change feature flags, /admin/compatibility
+                           to list unsupported constructs, /admin/traces
+                           for aul trace and /admin/deprecations to list
+                           and deprecate procedures; without
+                           --http-token-file it has no authentication, so
+                           bind it to a trusted network
+  --http-token-file <file> Require one of the bearer tokens in this file (one
+                           per line) on the HTTP API; /health and
+                           /openapi.json stay open
+  --http-access-log        Log each HTTP API request: method, route, status,
+                           duration, bytes, user, API key (masked), trace id
+                           and parameters
+  --http-log-redact <list> Parameter names whose values are masked (default:
+                           password,pwd,secret,token,api_key,apikey)
+  --http-log-sample <f>    Share of successful requests logged, 0-1 (default:
+                           1); failed requests are always logged
+  --http-log-skip <list>   Routes never logged, e.g. /health
+  --http-max-page-size <n> Most rows in a page of a result (default: 0, no
+                           limit); a larger result of a single result set is
+                           paged, the rest following with next_page_token
+
+Diagnostics:
+  --capture-dir <dir>      Record TDS/PostgreSQL wire traffic (credentials masked)
+                           to JSON files for replay in regression tests
 
 General:
   -h, --help               Show help
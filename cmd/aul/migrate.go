@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/migrate"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/runtime"
+)
+
+// runMigrate implements the "aul migrate" subcommand.
+func runMigrate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("aul migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	placeholders := variableFlags{}
+	var (
+		storagePath = fs.String("storage-path", "", "SQLite file to migrate")
+		target      = fs.String("target", "", "DSN of a running aul server to migrate")
+		contexts    = fs.String("contexts", "", "Comma-separated Liquibase contexts to run (default: all)")
+		user        = fs.String("user", "", "Recorded as the one who applied Flyway migrations (default: aul)")
+		outOfOrder  = fs.Bool("out-of-order", false, "Apply Flyway migrations older than the newest applied one")
+		dryRun      = fs.Bool("dry-run", false, "List pending migrations without applying them")
+		verbose     = fs.Bool("v", false, "Show progress")
+	)
+	fs.Var(placeholders, "placeholder", "Placeholder name=value for ${name} (repeatable)")
+
+	fs.Usage = func() {
+		printMigrateUsage(stderr)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "error: give one migration directory or changelog")
+		return 2
+	}
+	if (*storagePath == "") == (*target == "") {
+		fmt.Fprintln(stderr, "error: exactly one of --storage-path and --target is required")
+		return 2
+	}
+
+	// Without a target, migrate through an in-process server on the file
+	if *target == "" {
+		emb, err := startEmbeddedServerOn("", runtime.StorageConfig{
+			Type:    "sqlite",
+			Options: map[string]string{"path": *storagePath},
+		}, protocol.ProtocolTDS)
+		if err != nil {
+			fmt.Fprintf(stderr, "error starting server: %v\n", err)
+			return 1
+		}
+		defer emb.Stop()
+		*target = emb.TDSDSN()
+	}
+
+	db, err := sql.Open("sqlserver", *target)
+	if err != nil {
+		fmt.Fprintf(stderr, "error opening target: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	// One connection, so that the migrations share a session
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "error connecting to target: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	opts := migrate.Options{
+		Placeholders: placeholders,
+		User:         *user,
+		OutOfOrder:   *outOfOrder,
+		DryRun:       *dryRun,
+	}
+	if *contexts != "" {
+		for _, c := range strings.Split(*contexts, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				opts.Contexts = append(opts.Contexts, c)
+			}
+		}
+	}
+	if *verbose {
+		opts.Progress = func(message string) {
+			fmt.Fprintln(stderr, message)
+		}
+	}
+
+	report, err := migrate.Run(ctx, conn, fs.Arg(0), opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	report.WriteText(stdout)
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
+
+func printMigrateUsage(w io.Writer) {
+	fmt.Fprint(w, `aul migrate - Apply Flyway or Liquibase migrations
+
+Usage:
+  aul migrate (--storage-path <file> | --target <dsn>) [options] <dir|changelog>
+
+Options:
+  --storage-path <file>    SQLite file to migrate
+  --target <dsn>           Running aul server to migrate instead
+  --placeholder <k>=<v>    Value for ${k} in migrations (repeatable)
+  --contexts <list>        Liquibase contexts to run (default: all)
+  --user <name>            installed_by for Flyway migrations (default: aul)
+  --out-of-order           Apply Flyway migrations older than the newest
+                           applied one instead of refusing
+  --dry-run                List pending migrations without applying them
+  -v                       Show progress
+
+A directory is read as Flyway migrations: V<version>__<description>.sql
+scripts applied in version order, then R__<description>.sql scripts whose
+checksum changed, recorded in flyway_schema_history. A file is read as a
+Liquibase changelog, XML or formatted SQL (--liquibase formatted sql),
+with its includes; changesets are recorded in DATABASECHANGELOG.
+
+The history tables are the ones Flyway and Liquibase keep, so a database
+moved onto aul with them carries on where the tool left off. Flyway
+checksums are computed as Flyway does and checked; Liquibase MD5SUMs are
+left empty for Liquibase to fill in. Liquibase changesets must be SQL:
+<sql>, <sqlFile> or <createProcedure>. Undo migrations, rollbacks and
+preconditions are not supported.
+
+Exits 1 if a migration failed.
+
+Examples:
+  aul migrate --storage-path app.db --placeholder env=prod db/migration
+  aul migrate --target "sqlserver://sa:pw@localhost:1433?encrypt=disable" \
+      --contexts prod db/changelog/db.changelog-master.xml
+`)
+}
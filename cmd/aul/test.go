@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/coverage"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/sqlcmd"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// runTest implements the "aul test" subcommand.
+func runTest(args []string, stdout, stderr io.Writer) int {
+	fset := flag.NewFlagSet("aul test", flag.ContinueOnError)
+	fset.SetOutput(stderr)
+
+	var (
+		procDir   = fset.String("proc-dir", "./procedures", "Procedure directory")
+		testDir   = fset.String("test-dir", "./tests", "Directory of test scripts")
+		pattern   = fset.String("run", "", "Run only the tests whose names match this regular expression")
+		verbose   = fset.Bool("verbose", false, "List every test, not just failures")
+		timeout   = fset.Duration("timeout", 30*time.Second, "Per-batch timeout")
+		cover     = fset.Bool("cover", false, "Print the procedures' coverage")
+		coverHTML = fset.String("cover-html", "", "Write the coverage as an HTML page to this file")
+		coverLCOV = fset.String("cover-lcov", "", "Write the coverage as an lcov tracefile to this file")
+		minCover  = fset.Float64("min-coverage", 0, "Exit with status 1 if line coverage is below this percentage")
+	)
+
+	fset.Usage = func() {
+		printTestUsage(stderr)
+	}
+
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	var match *regexp.Regexp
+	if *pattern != "" {
+		var err error
+		if match, err = regexp.Compile(*pattern); err != nil {
+			fmt.Fprintf(stderr, "error: --run: %v\n", err)
+			return 2
+		}
+	}
+
+	var tests []string
+	err := filepath.WalkDir(*testDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".sql") {
+			return nil
+		}
+		name, err := filepath.Rel(*testDir, path)
+		if err != nil {
+			return err
+		}
+		if match == nil || match.MatchString(filepath.ToSlash(name)) {
+			tests = append(tests, path)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	if len(tests) == 0 {
+		fmt.Fprintf(stderr, "error: no tests in %s\n", *testDir)
+		return 2
+	}
+	sort.Strings(tests)
+
+	// Coverage is counted across every test
+	var counts *tsqlruntime.Coverage
+	if *cover || *coverHTML != "" || *coverLCOV != "" || *minCover > 0 {
+		counts = tsqlruntime.NewCoverage()
+	}
+
+	var (
+		procs  []*procedure.Procedure
+		failed int
+	)
+	for _, path := range tests {
+		name, _ := filepath.Rel(*testDir, path)
+		name = filepath.ToSlash(name)
+		start := time.Now()
+		loaded, err := runTestScript(path, *procDir, *timeout, counts)
+		if procs == nil {
+			procs = loaded
+		}
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			failed++
+			fmt.Fprintf(stdout, "FAIL  %s (%s)\n      %v\n", name, elapsed, err)
+		} else if *verbose {
+			fmt.Fprintf(stdout, "PASS  %s (%s)\n", name, elapsed)
+		}
+	}
+	fmt.Fprintf(stdout, "%d tests, %d passed, %d failed\n", len(tests), len(tests)-failed, failed)
+
+	status := 0
+	if failed > 0 {
+		status = 1
+	}
+	if counts == nil {
+		return status
+	}
+
+	report := coverage.NewReport(procs, counts)
+	if *cover {
+		fmt.Fprintln(stdout)
+		report.WriteText(stdout)
+	}
+	for _, out := range []struct {
+		path  string
+		write func(io.Writer) error
+	}{
+		{*coverHTML, report.WriteHTML},
+		{*coverLCOV, report.WriteLCOV},
+	} {
+		if out.path == "" {
+			continue
+		}
+		if err := writeReport(out.path, out.write); err != nil {
+			fmt.Fprintf(stderr, "error writing coverage: %v\n", err)
+			return 1
+		}
+	}
+	if pct := report.LinePercent(); pct < *minCover {
+		fmt.Fprintf(stderr, "line coverage %.1f%% is below minimum %.1f%%\n", pct, *minCover)
+		return 1
+	}
+	return status
+}
+
+// runTestScript runs the test script at path on an in-process server of
+// its own, with the procedures of procDir, counting what of them runs in
+// counts. It fails with the first batch that does. The procedures the
+// server loaded are returned either way.
+func runTestScript(path, procDir string, timeout time.Duration, counts *tsqlruntime.Coverage) ([]*procedure.Procedure, error) {
+	script, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	emb, err := startEmbeddedServer(procDir)
+	if err != nil {
+		return nil, fmt.Errorf("starting server: %w", err)
+	}
+	defer emb.Stop()
+
+	ctx := context.Background()
+	rt := emb.Runtime()
+	execCtx := &runtime.ExecContext{
+		SessionID: "aul-test",
+		Database:  "master",
+		Timeout:   timeout,
+		Coverage:  counts,
+	}
+	p := sqlcmd.New(sqlcmd.Options{
+		Lookup:      os.LookupEnv,
+		ExitOnError: true,
+		IncludeDir:  filepath.Dir(path),
+	})
+	err = p.Run(string(script), filepath.Base(path), func(batch string) error {
+		_, err := rt.ExecuteSQL(ctx, batch, execCtx)
+		return err
+	})
+	return emb.Registry().List(), err
+}
+
+// writeReport writes a coverage report to the file at path.
+func writeReport(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printTestUsage(w io.Writer) {
+	fmt.Fprint(w, `aul test - Run procedure tests and report their coverage
+
+Usage:
+  aul test [options]
+
+Options:
+  --proc-dir <path>        Procedure directory (default: ./procedures)
+  --test-dir <path>        Directory of test scripts (default: ./tests)
+  --run <regexp>           Run only the tests whose names match
+  --verbose                List every test, not just failures
+  --timeout <dur>          Per-batch timeout (default: 30s)
+  --cover                  Print each procedure's line and branch coverage
+  --cover-html <file>      Write the coverage as an HTML page, with each
+                           procedure's source marked
+  --cover-lcov <file>      Write the coverage as an lcov tracefile
+  --min-coverage <pct>     Exit with status 1 if line coverage is below pct
+
+Each .sql file under the test directory is a test: a sqlcmd script run on
+an in-process server of its own, with an empty database and the
+procedures loaded. It passes if every batch runs, so a test asserts with
+THROW or RAISERROR:
+
+  EXEC dbo.PlaceOrder @CustomerID = 42, @Qty = 3;
+  IF (SELECT qty FROM stock WHERE id = 7) <> 97
+      THROW 50000, 'stock not reduced', 1;
+
+Coverage counts, across every test, the statements of each procedure that
+ran, by line, and the ways each IF and WHILE went. Dynamic SQL and the
+test scripts themselves are not counted.
+
+Examples:
+  aul test --verbose
+
+  # Gate CI on coverage, keeping a report for the build
+  aul test --cover --cover-lcov coverage.lcov --min-coverage 80
+`)
+}
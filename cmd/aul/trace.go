@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// runTrace implements the "aul trace" subcommand, a client of a running
+// server's /admin/traces route.
+func runTrace(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("aul trace", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		serverURL = fs.String("server", "http://localhost:8080", "HTTP API of the server")
+		tokenFile = fs.String("token-file", "", "File holding the API token")
+		asJSON    = fs.Bool("json", false, "Print the trace as JSON")
+		timeout   = fs.Duration("timeout", 30*time.Second, "How long to wait for the server")
+	)
+
+	fs.Usage = func() {
+		printTraceUsage(stderr)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	action := "list"
+	if fs.NArg() > 0 {
+		action = strings.ToLower(fs.Arg(0))
+	}
+	want := 1
+	switch action {
+	case "list":
+	case "show", "enable", "disable":
+		want = 2
+	default:
+		fmt.Fprintf(stderr, "error: unknown action %q\n", fs.Arg(0))
+		return 2
+	}
+	if fs.NArg() > want || (fs.NArg() < want && action != "list") {
+		printTraceUsage(stderr)
+		return 2
+	}
+
+	client := &traceClient{
+		url:  strings.TrimSuffix(*serverURL, "/") + "/admin/traces",
+		http: &http.Client{Timeout: *timeout},
+	}
+	if *tokenFile != "" {
+		tokens, err := readTokenFile(*tokenFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+		client.token = tokens[0]
+	}
+
+	var err error
+	switch action {
+	case "show":
+		var trace protocol.Trace
+		if err = client.do(http.MethodGet, "/"+url.PathEscape(fs.Arg(1)), nil, &trace); err != nil {
+			break
+		}
+		if *asJSON {
+			enc := json.NewEncoder(stdout)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(trace)
+			break
+		}
+		printTrace(stdout, &trace)
+	case "enable", "disable":
+		var list traceList
+		err = client.do(http.MethodPost, "", map[string]string{"procedure": fs.Arg(1), "action": action}, &list)
+		if err == nil {
+			fmt.Fprintf(stdout, "tracing of %s %sd\n", fs.Arg(1), action)
+		}
+	default:
+		var list traceList
+		if err = client.do(http.MethodGet, "", nil, &list); err == nil {
+			printTraceList(stdout, list)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// traceList is what /admin/traces returns.
+type traceList struct {
+	Traces     []protocol.TraceInfo `json:"traces"`
+	Procedures []string             `json:"procedures"`
+}
+
+// traceClient calls a server's /admin/traces route.
+type traceClient struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func (c *traceClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusNotFound && strings.HasPrefix(string(msg), "404 page not found") {
+			return fmt.Errorf("%s not found: is the server running with --http-admin?", c.url)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func printTraceList(w io.Writer, list traceList) {
+	if len(list.Procedures) > 0 {
+		fmt.Fprintf(w, "traced on every call: %s\n\n", strings.Join(list.Procedures, ", "))
+	}
+	if len(list.Traces) == 0 {
+		fmt.Fprintln(w, "no traces")
+		return
+	}
+	fmt.Fprintf(w, "%-32s  %-19s  %10s  %6s  %s\n", "ID", "STARTED", "DURATION", "STEPS", "PROCEDURE")
+	for _, t := range list.Traces {
+		proc := t.Procedure
+		if proc == "" {
+			proc = "(batch)"
+		}
+		if t.Error != "" {
+			proc += "  failed"
+		}
+		fmt.Fprintf(w, "%-32s  %-19s  %8.3fms  %6d  %s\n",
+			t.ID, t.StartedAt.Local().Format("2006-01-02 15:04:05"), t.DurationMs, t.Events, proc)
+	}
+}
+
+// printTrace prints each step of a trace on a line: when it happened,
+// where, and what it did, indented by how deeply the code ran nested.
+func printTrace(w io.Writer, t *protocol.Trace) {
+	name := t.Procedure
+	if name == "" {
+		name = "batch"
+	}
+	fmt.Fprintf(w, "trace %s: %s, started %s, %.3fms, %d steps\n",
+		t.ID, name, t.StartedAt.Local().Format(time.RFC3339), t.DurationMs, t.Events)
+	if t.Dropped > 0 {
+		fmt.Fprintf(w, "%d steps past the %d a trace holds were not recorded\n", t.Dropped, tsqlruntime.MaxTraceEvents)
+	}
+	if t.Error != "" {
+		fmt.Fprintf(w, "failed: %s\n", t.Error)
+	}
+	fmt.Fprintln(w)
+
+	for _, e := range t.Steps {
+		where := e.Procedure
+		if e.Line > 0 {
+			where = fmt.Sprintf("%s:%d", where, e.Line)
+		}
+		fmt.Fprintf(w, "%5d %10.3fms  %-24s %s%s\n", e.Seq, float64(e.Offset.Microseconds())/1000,
+			where, strings.Repeat("  ", e.Depth), traceStep(e))
+	}
+}
+
+// traceStep describes what one step of a trace did.
+func traceStep(e tsqlruntime.TraceEvent) string {
+	var s string
+	switch e.Kind {
+	case tsqlruntime.TraceAssign:
+		s = e.Variable + " = " + e.Value
+	case tsqlruntime.TraceBranch:
+		s = e.Statement + "  -> " + e.Branch
+	case tsqlruntime.TraceLoop:
+		s = e.Statement + "  -> " + e.Branch
+		if e.Iteration > 0 {
+			s += fmt.Sprintf(" %d", e.Iteration)
+		}
+	case tsqlruntime.TraceCatch:
+		s = e.Statement + "  <- " + e.Error
+	default:
+		s = e.Statement
+		if e.Rows != nil {
+			s += fmt.Sprintf("  [%d rows]", *e.Rows)
+		}
+		s += fmt.Sprintf("  (%.3fms)", float64(e.Duration.Microseconds())/1000)
+		if e.Error != "" {
+			s += "  FAILED: " + e.Error
+		}
+	}
+	return s
+}
+
+func printTraceUsage(w io.Writer) {
+	fmt.Fprint(w, `aul trace - Show how procedures ran, step by step
+
+Usage:
+  aul trace [options] [list]
+  aul trace [options] show <id>
+  aul trace [options] enable <procedure>
+  aul trace [options] disable <procedure>
+
+Actions:
+  list                 List the traces the server keeps, newest last, and
+                       the procedures traced on every call
+  show <id>            Print a trace: each statement run, with its line,
+                       rows and time, each value assigned to a variable,
+                       and each branch IF, WHILE and TRY...CATCH took
+  enable <procedure>   Trace every call of a procedure until disabled or
+                       the server restarts
+  disable <procedure>  Stop tracing every call of a procedure
+
+Options:
+  --server <url>           HTTP API of the server (default: http://localhost:8080)
+  --token-file <file>      File holding the API token, when the server runs
+                           with --http-token-file
+  --json                   Print the trace shown as JSON
+  --timeout <duration>     How long to wait for the server (default: 30s)
+
+The server must run with --http-admin. Besides the procedures enabled
+here, a request is traced when it asks to be, with ?trace=true on the HTTP
+API, and a call is when the T-SQL making it says EXEC ... WITH TRACE. The
+ID of a trace is that of the request that made it: the HTTP API returns it
+as trace_id, and TDS and PostgreSQL clients get it in a message. The server
+keeps the last 100 traces.
+
+Examples:
+  aul trace enable dbo.PlaceOrder
+  aul trace list
+  aul trace show 4bf92f3577b34da6a3ce929d0e0e4736
+`)
+}
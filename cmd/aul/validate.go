@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/seed"
+)
+
+// runValidate implements the "aul validate" subcommand.
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	fset := flag.NewFlagSet("aul validate", flag.ContinueOnError)
+	fset.SetOutput(stderr)
+
+	var (
+		procDir    = fset.String("proc-dir", "./procedures", "Procedure directory")
+		schemaPath = fset.String("schema", "", "T-SQL script defining the tables, to type the columns procedures read")
+	)
+
+	fset.Usage = func() {
+		printValidateUsage(stderr)
+	}
+
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+
+	tables := procedure.TableColumns(nil)
+	if *schemaPath != "" {
+		script, err := os.ReadFile(*schemaPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+		schema, err := seed.ParseSchema(string(script))
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %s: %v\n", *schemaPath, err)
+			return 1
+		}
+		tables = schemaColumns(schema)
+	}
+
+	var paths []string
+	err := filepath.WalkDir(*procDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".sql") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	sort.Strings(paths)
+
+	loader := procedure.NewLoader("tsql", log.New(log.Config{
+		DefaultLevel: log.LevelWarn,
+		Output:       stderr,
+		Format:       log.FormatText,
+	}))
+	failed, checked := 0, 0
+	for _, path := range paths {
+		proc, err := loader.LoadFile(path)
+		if err != nil {
+			fmt.Fprintf(stdout, "FAIL %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		if proc.Contract == nil || proc.IsFunction {
+			continue
+		}
+		checked++
+		sets, err := procedure.InferResultSets(proc, tables)
+		if err != nil {
+			fmt.Fprintf(stdout, "FAIL %s: %v\n", proc.QualifiedName(), err)
+			failed++
+			continue
+		}
+		problems := procedure.CheckContract(proc.Contract, sets)
+		if len(problems) == 0 {
+			fmt.Fprintf(stdout, "ok   %s\n", proc.QualifiedName())
+			continue
+		}
+		failed++
+		fmt.Fprintf(stdout, "FAIL %s\n", proc.QualifiedName())
+		for _, p := range problems {
+			fmt.Fprintf(stdout, "       %s\n", p)
+		}
+	}
+
+	fmt.Fprintf(stdout, "\n%d procedures, %d with result contracts, %d failed\n", len(paths), checked, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printValidateUsage(w io.Writer) {
+	fmt.Fprint(w, `aul validate - Check procedures against their result contracts
+
+Usage:
+  aul validate [options]
+
+Options:
+  --proc-dir <path>        Procedure directory (default: ./procedures)
+  --schema <file>          CREATE TABLE script used to type the columns
+                           procedures select from tables
+
+A procedure declares the result sets it returns with annotations:
+
+  -- @aul:result=OrderID int NOT NULL, Total decimal(10,2), PlacedAt datetime
+  -- @aul:result.2=LineNo int NOT NULL, Sku varchar(20) NOT NULL
+  CREATE PROCEDURE dbo.usp_GetOrder ...
+
+or in a .contract.json file beside its .sql file. "aul validate" loads
+every procedure, works out the result sets each one's SELECT statements
+return and reports those that no longer match their contract: result sets
+added or missing, columns renamed, added or dropped, types changed, or
+NULLs possible where the contract says NOT NULL. Types are checked where
+they can be worked out; give --schema for columns read from tables.
+
+It exits with status 1 when a procedure fails to load or breaks its
+contract, so it can gate a CI pipeline. The server checks results against
+contracts as procedures run, too (see --result-contracts).
+
+Examples:
+  aul validate --proc-dir ./procedures --schema schema.sql
+`)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// spanKind classifies a lexical span of the input line
+type spanKind int
+
+const (
+	spanOther        spanKind = iota // Whitespace, operators, punctuation
+	spanWord                         // Identifier that is not a keyword
+	spanKeyword                      // SQL keyword
+	spanString                       // 'string' or N'string'
+	spanNumber                       // Numeric literal
+	spanComment                      // -- line or /* block */ comment
+	spanVariable                     // @var or @@sysvar
+	spanQuotedIdent                  // [ident] or "ident"
+	spanUnterminated                 // String, comment or quoted identifier without a closing delimiter
+)
+
+// sqlSpan is a half-open [start, end) range of runes with a kind
+type sqlSpan struct {
+	kind       spanKind
+	start, end int
+}
+
+// keywordSet is the uppercase set of keywords coloured by the painter
+var keywordSet = func() map[string]bool {
+	set := make(map[string]bool, len(sqlKeywords))
+	for _, kw := range sqlKeywords {
+		if !strings.Contains(kw, ".") {
+			set[strings.ToUpper(kw)] = true
+		}
+	}
+	for _, kw := range []string{
+		"PROCEDURE", "PROC", "FUNCTION", "TRIGGER", "RETURNS", "OUTPUT", "GO",
+		"TRAN", "SAVE", "USE", "IS", "MERGE", "USING", "MATCHED", "OVER",
+		"PARTITION", "ROWS", "FETCH", "NEXT", "ONLY", "CURSOR", "OPEN", "CLOSE",
+		"DEALLOCATE", "BREAK", "CONTINUE", "GOTO", "WAITFOR", "TRUNCATE",
+	} {
+		set[kw] = true
+	}
+	return set
+}()
+
+// lexSQL splits a line into coloured spans. It is deliberately forgiving:
+// it never fails, and anything it does not recognise is spanOther.
+func lexSQL(rs []rune) []sqlSpan {
+	var spans []sqlSpan
+	n := len(rs)
+	i := 0
+
+	// closeQuoted scans to the closing delimiter, honouring doubled escapes
+	closeQuoted := func(from int, delim rune) (int, bool) {
+		for j := from; j < n; j++ {
+			if rs[j] == delim {
+				if j+1 < n && rs[j+1] == delim {
+					j++
+					continue
+				}
+				return j + 1, true
+			}
+		}
+		return n, false
+	}
+
+	for i < n {
+		start := i
+		c := rs[i]
+
+		switch {
+		case c == '-' && i+1 < n && rs[i+1] == '-':
+			for i < n && rs[i] != '\n' {
+				i++
+			}
+			spans = append(spans, sqlSpan{spanComment, start, i})
+
+		case c == '/' && i+1 < n && rs[i+1] == '*':
+			kind := spanUnterminated
+			for i += 2; i < n; i++ {
+				if rs[i] == '*' && i+1 < n && rs[i+1] == '/' {
+					i += 2
+					kind = spanComment
+					break
+				}
+			}
+			spans = append(spans, sqlSpan{kind, start, i})
+
+		case c == '\'' || ((c == 'N' || c == 'n') && i+1 < n && rs[i+1] == '\''):
+			from := i + 1
+			if c != '\'' {
+				from = i + 2
+			}
+			end, ok := closeQuoted(from, '\'')
+			kind := spanString
+			if !ok {
+				kind = spanUnterminated
+			}
+			spans = append(spans, sqlSpan{kind, start, end})
+			i = end
+
+		case c == '[' || c == '"':
+			delim := c
+			if c == '[' {
+				delim = ']'
+			}
+			end, ok := closeQuoted(i+1, delim)
+			kind := spanQuotedIdent
+			if !ok {
+				kind = spanUnterminated
+			}
+			spans = append(spans, sqlSpan{kind, start, end})
+			i = end
+
+		case c == '@':
+			i++
+			for i < n && (isIdentRune(rs[i]) || rs[i] == '@') {
+				i++
+			}
+			spans = append(spans, sqlSpan{spanVariable, start, i})
+
+		case unicode.IsDigit(c) || (c == '.' && i+1 < n && unicode.IsDigit(rs[i+1])):
+			for i < n && (unicode.IsDigit(rs[i]) || rs[i] == '.' || rs[i] == 'e' || rs[i] == 'E' || rs[i] == 'x' || rs[i] == 'X' ||
+				(rs[i] >= 'a' && rs[i] <= 'f') || (rs[i] >= 'A' && rs[i] <= 'F')) {
+				i++
+			}
+			spans = append(spans, sqlSpan{spanNumber, start, i})
+
+		case isIdentRune(c):
+			for i < n && isIdentRune(rs[i]) {
+				i++
+			}
+			kind := spanWord
+			if keywordSet[strings.ToUpper(string(rs[start:i]))] {
+				kind = spanKeyword
+			}
+			spans = append(spans, sqlSpan{kind, start, i})
+
+		default:
+			i++
+			spans = append(spans, sqlSpan{spanOther, start, i})
+		}
+	}
+	return spans
+}
+
+// isIdentRune reports whether r can appear in an unquoted identifier
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '#' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// parenPairs matches parentheses outside strings and comments.
+// It returns a map from each paren index to its partner (-1 if unbalanced).
+func parenPairs(rs []rune, spans []sqlSpan) map[int]int {
+	pairs := make(map[int]int)
+	var stack []int
+	for _, sp := range spans {
+		if sp.kind != spanOther {
+			continue
+		}
+		switch rs[sp.start] {
+		case '(':
+			stack = append(stack, sp.start)
+		case ')':
+			if len(stack) == 0 {
+				pairs[sp.start] = -1
+				continue
+			}
+			open := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			pairs[open] = sp.start
+			pairs[sp.start] = open
+		}
+	}
+	for _, open := range stack {
+		pairs[open] = -1
+	}
+	return pairs
+}
+
+// sqlPainter implements readline.Painter, colouring SQL as the user types
+type sqlPainter struct{}
+
+func (p *sqlPainter) Paint(line []rune, pos int) []rune {
+	if !useColour || len(line) == 0 {
+		return line
+	}
+
+	// Commands are not SQL; leave them alone
+	if isCommand(strings.TrimSpace(string(line))) {
+		return line
+	}
+
+	spans := lexSQL(line)
+	pairs := parenPairs(line, spans)
+
+	// Highlight the paren under (or just before) the cursor and its partner
+	active := map[int]bool{}
+	for _, at := range []int{pos, pos - 1} {
+		if partner, ok := pairs[at]; ok && partner >= 0 {
+			active[at] = true
+			active[partner] = true
+			break
+		}
+	}
+
+	var out strings.Builder
+	for _, sp := range spans {
+		text := string(line[sp.start:sp.end])
+		switch sp.kind {
+		case spanKeyword:
+			out.WriteString(colCyan + text + colReset)
+		case spanString:
+			out.WriteString(colGreen + text + colReset)
+		case spanNumber:
+			out.WriteString(colYellow + text + colReset)
+		case spanComment:
+			out.WriteString(colDim + text + colReset)
+		case spanUnterminated:
+			out.WriteString(colRed + text + colReset)
+		case spanOther:
+			if partner, ok := pairs[sp.start]; ok {
+				switch {
+				case partner < 0:
+					out.WriteString(colRed + colBold + text + colReset)
+				case active[sp.start]:
+					out.WriteString(colBold + colYellow + text + colReset)
+				default:
+					out.WriteString(text)
+				}
+				continue
+			}
+			out.WriteString(text)
+		default:
+			out.WriteString(text)
+		}
+	}
+	return []rune(out.String())
+}
+
+// checkBalance reports unbalanced quotes, brackets, comments or parentheses
+// in sqlStr. Lines are scanned together so multi-line batches are handled.
+func checkBalance(sqlStr string) error {
+	rs := []rune(sqlStr)
+	spans := lexSQL(rs)
+	for _, sp := range spans {
+		if sp.kind != spanUnterminated {
+			continue
+		}
+		line, col := runePosition(rs, sp.start)
+		what := "string literal"
+		switch rs[sp.start] {
+		case '[':
+			what = "bracketed identifier"
+		case '"':
+			what = "quoted identifier"
+		case '/':
+			what = "block comment"
+		}
+		return fmt.Errorf("unterminated %s starting at line %d, col %d", what, line, col)
+	}
+
+	pairs := parenPairs(rs, spans)
+	first := -1
+	for at, partner := range pairs {
+		if partner < 0 && (first < 0 || at < first) {
+			first = at
+		}
+	}
+	if first >= 0 {
+		line, col := runePosition(rs, first)
+		if rs[first] == '(' {
+			return fmt.Errorf("unclosed '(' at line %d, col %d", line, col)
+		}
+		return fmt.Errorf("unmatched ')' at line %d, col %d", line, col)
+	}
+	return nil
+}
+
+// runePosition converts a rune offset to a 1-based line and column
+func runePosition(rs []rune, at int) (line, col int) {
+	line, col = 1, 1
+	for i := 0; i < at && i < len(rs); i++ {
+		if rs[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// errorPositionRe matches the position prefix used by aul's T-SQL parser
+var errorPositionRe = regexp.MustCompile(`line (\d+), col (\d+)`)
+
+// errorPosition extracts the line and column of an error from the server.
+// The column is 0 when only a line number is known.
+func errorPosition(err error) (line, col int) {
+	if m := errorPositionRe.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+		col, _ = strconv.Atoi(m[2])
+		return line, col
+	}
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) && mssqlErr.LineNo > 0 {
+		return int(mssqlErr.LineNo), 0
+	}
+	return 0, 0
+}
+
+// printQueryError prints a server error and, when the server reported a
+// position, the offending source line with the character highlighted
+func printQueryError(w io.Writer, sqlStr string, err error) {
+	fmt.Fprintf(w, "%sError: %v%s\n", colRed, err, colReset)
+
+	line, col := errorPosition(err)
+	lines := strings.Split(sqlStr, "\n")
+	if line < 1 || line > len(lines) {
+		return
+	}
+	src := []rune(strings.TrimRight(lines[line-1], "\r"))
+
+	gutter := fmt.Sprintf("%4d | ", line)
+	if col < 1 || col > len(src) {
+		fmt.Fprintf(w, "%s%s%s%s\n", colDim, gutter, colReset, string(src))
+		return
+	}
+
+	fmt.Fprintf(w, "%s%s%s%s%s%s%s%s\n", colDim, gutter, colReset,
+		string(src[:col-1]), colRed+colBold, string(src[col-1]), colReset, string(src[col:]))
+	fmt.Fprintf(w, "%s%s%s^%s\n", strings.Repeat(" ", len(gutter)), strings.Repeat(" ", col-1), colRed, colReset)
+}
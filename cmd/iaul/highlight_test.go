@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// spanNames names the span kinds in test output.
+var spanNames = map[spanKind]string{
+	spanOther:        "other",
+	spanWord:         "word",
+	spanKeyword:      "keyword",
+	spanString:       "string",
+	spanNumber:       "number",
+	spanComment:      "comment",
+	spanVariable:     "variable",
+	spanQuotedIdent:  "ident",
+	spanUnterminated: "unterminated",
+}
+
+// lexed lexes sql, returning each span but whitespace as its kind and
+// text.
+func lexed(sql string) []string {
+	rs := []rune(sql)
+	var out []string
+	for _, sp := range lexSQL(rs) {
+		text := string(rs[sp.start:sp.end])
+		if sp.kind == spanOther && strings.TrimSpace(text) == "" {
+			continue
+		}
+		out = append(out, spanNames[sp.kind]+" "+text)
+	}
+	return out
+}
+
+func TestLexSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"keywords and words", "select Name from t",
+			[]string{"keyword select", "word Name", "keyword from", "word t"}},
+		{"doubled quote", "SELECT 'it''s', ''''",
+			[]string{"keyword SELECT", "string 'it''s'", "other ,", "string ''''"}},
+		{"unicode string", "N'héllo' + n'it''s'",
+			[]string{"string N'héllo'", "other +", "string n'it''s'"}},
+		{"N as a word", "N + 1",
+			[]string{"word N", "other +", "number 1"}},
+		{"line comment with brackets", "x -- ( [ ' \" /*\ny",
+			[]string{"word x", "comment -- ( [ ' \" /*", "word y"}},
+		{"block comment with brackets", "/* ) ] ' -- */(1)",
+			[]string{"comment /* ) ] ' -- */", "other (", "number 1", "other )"}},
+		{"bracketed identifier", "[order (details]] x] [a]",
+			[]string{"ident [order (details]] x]", "ident [a]"}},
+		{"quoted identifier", `"col ""a"""`,
+			[]string{`ident "col ""a"""`}},
+		{"variables", "@v = @@ROWCOUNT",
+			[]string{"variable @v", "other =", "variable @@ROWCOUNT"}},
+		{"numbers", "12.5e3 0x1F .5",
+			[]string{"number 12.5e3", "number 0x1F", "number .5"}},
+		{"unterminated string", "SELECT 'abc",
+			[]string{"keyword SELECT", "unterminated 'abc"}},
+		{"unterminated doubled quote", "'it''",
+			[]string{"unterminated 'it''"}},
+		{"unterminated unicode string", "N'abc\ndef",
+			[]string{"unterminated N'abc\ndef"}},
+		{"unterminated bracket", "[a]] b",
+			[]string{"unterminated [a]] b"}},
+		{"unterminated quoted identifier", `"abc`,
+			[]string{`unterminated "abc`}},
+		{"unterminated comment", "1 /* abc *",
+			[]string{"number 1", "unterminated /* abc *"}},
+		{"empty", "", nil},
+	}
+	for _, tc := range tests {
+		if got := lexed(tc.sql); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParenPairs(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want map[int]int
+	}{
+		{"nested", "f(a, (b))", map[int]int{1: 8, 8: 1, 5: 7, 7: 5}},
+		{"unclosed", "((1)", map[int]int{0: -1, 1: 3, 3: 1}},
+		{"unmatched", "(1))", map[int]int{0: 2, 2: 0, 3: -1}},
+		{"in strings and comments", "'(' N')' [(] /* ) */ -- (\n()", map[int]int{26: 27, 27: 26}},
+		{"none", "SELECT 1", map[int]int{}},
+	}
+	for _, tc := range tests {
+		rs := []rune(tc.sql)
+		if got := parenPairs(rs, lexSQL(rs)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCheckBalance(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string // "" for balanced
+	}{
+		{"balanced", "SELECT 'it''s', N'(x', [a)b] -- )\n/* ( */ (1)", ""},
+		{"brackets in comments", "SELECT 1 -- '[(\n/* \" ] ) */", ""},
+		{"string", "SELECT 'abc", "unterminated string literal starting at line 1, col 8"},
+		{"doubled quote", "SELECT 'it''s", "unterminated string literal starting at line 1, col 8"},
+		{"unicode string", "SELECT 1,\n  N'abc", "unterminated string literal starting at line 2, col 3"},
+		{"bracketed identifier", "SELECT\n  [abc", "unterminated bracketed identifier starting at line 2, col 3"},
+		{"quoted identifier", `SELECT "abc`, "unterminated quoted identifier starting at line 1, col 8"},
+		{"block comment", "SELECT 1 /* x", "unterminated block comment starting at line 1, col 10"},
+		{"unclosed paren", "SELECT (1", "unclosed '(' at line 1, col 8"},
+		{"unmatched paren", "SELECT\n(1))", "unmatched ')' at line 2, col 4"},
+		{"first unbalanced paren", "SELECT ) (", "unmatched ')' at line 1, col 8"},
+		{"unterminated before parens", "SELECT ( '", "unterminated string literal starting at line 1, col 10"},
+	}
+	for _, tc := range tests {
+		got := ""
+		if err := checkBalance(tc.sql); err != nil {
+			got = err.Error()
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPrintQueryError(t *testing.T) {
+	const sql = "SELECT 1\r\nSELECT x FROM\r\n"
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"line and column", errors.New("parse error at line 2, col 8: unexpected FROM"),
+			"Error: parse error at line 2, col 8: unexpected FROM\n" +
+				"   2 | SELECT x FROM\n" +
+				"       " + "       ^\n"},
+		{"line only", mssql.Error{Message: "Invalid column name 'x'.", LineNo: 2},
+			"Error: mssql: Invalid column name 'x'.\n" +
+				"   2 | SELECT x FROM\n"},
+		{"column past the line", errors.New("line 1, col 20"),
+			"Error: line 1, col 20\n" +
+				"   1 | SELECT 1\n"},
+		{"line past the batch", errors.New("line 9, col 1"),
+			"Error: line 9, col 1\n"},
+		{"no position", errors.New("connection reset"),
+			"Error: connection reset\n"},
+	}
+	for _, tc := range tests {
+		var out strings.Builder
+		printQueryError(&out, sql, tc.err)
+		if got := out.String(); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
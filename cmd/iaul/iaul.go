@@ -374,7 +374,7 @@ func executeAndPrintBatch(db *sql.DB, sqlStr string, timeoutSec int) bool {
 	elapsed := time.Since(start)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%sError: %v%s\n", colRed, err, colReset)
+		printQueryError(os.Stderr, sqlStr, err)
 		return false
 	}
 	defer rows.Close()
@@ -421,14 +421,18 @@ func executeAndPrintBatch(db *sql.DB, sqlStr string, timeoutSec int) bool {
 
 	// Output based on format (skip if silent with no results)
 	if verbosity > VerbositySilent || len(allRows) > 0 {
-		switch displayFormat {
-		case FormatASCII:
+		switch {
+		case isPlanResult(cols) && displayFormat == FormatJSON:
+			printPlansJSONTo(os.Stdout, allRows)
+		case isPlanResult(cols) && displayFormat != FormatCSV:
+			printPlansTo(os.Stdout, allRows)
+		case displayFormat == FormatASCII:
 			printASCIITable(cols, allRows)
-		case FormatUnicode:
+		case displayFormat == FormatUnicode:
 			printUnicodeTable(cols, allRows)
-		case FormatCSV:
+		case displayFormat == FormatCSV:
 			printCSV(cols, allRows)
-		case FormatJSON:
+		case displayFormat == FormatJSON:
 			printJSON(cols, allRows)
 		default:
 			printDefaultTable(cols, allRows)
@@ -561,6 +565,8 @@ func runCLI(db *sql.DB, queryTimeout int) {
 		),
 
 		HistorySearchFold: true, // Case-insensitive history search
+
+		Painter: &sqlPainter{}, // Syntax highlighting and paren matching
 	}
 
 	rl, err := readline.NewEx(config)
@@ -612,6 +618,11 @@ func runCLI(db *sql.DB, queryTimeout int) {
 			if strings.ToUpper(input) == "GO" || input == ";" {
 				// Execute the accumulated SQL
 				sql := strings.TrimSpace(multiLine.String())
+				if err := checkBalance(sql); err != nil {
+					// Keep the buffer so the user can finish the statement
+					fmt.Fprintf(os.Stderr, "%sNot submitted: %v%s\n", colYellow, err, colReset)
+					continue
+				}
 				multiLine.Reset()
 				inMultiLine = false
 				if sql != "" {
@@ -871,6 +882,11 @@ func runCLI(db *sql.DB, queryTimeout int) {
 			continue
 		}
 
+		if err := checkBalance(sql); err != nil {
+			fmt.Fprintf(os.Stderr, "%sNot submitted: %v%s\n", colYellow, err, colReset)
+			continue
+		}
+
 		executeAndPrint(db, sql, queryTimeout)
 	}
 }
@@ -1091,6 +1107,12 @@ Keyboard shortcuts:
   Ctrl+U         Clear line
   Ctrl+L         Clear screen
 
+Highlighting:
+  Keywords, strings, numbers and comments are coloured as you type.
+  The parenthesis matching the one at the cursor is highlighted, and
+  unbalanced quotes or parentheses are shown in red and not submitted.
+  Server errors that carry a position show the offending line.
+
 Batch execution (non-interactive):
   -e "SQL"       Execute SQL statement(s) and exit
   -f file.sql    Execute SQL file and exit
@@ -1267,7 +1289,7 @@ func executeAndPrint(db *sql.DB, sqlStr string, timeoutSec int) {
 	out := getOutput()
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%sError: %v%s\n", colRed, err, colReset)
+		printQueryError(os.Stderr, sqlStr, err)
 		return
 	}
 	defer rows.Close()
@@ -1316,14 +1338,18 @@ func executeAndPrint(db *sql.DB, sqlStr string, timeoutSec int) {
 
 	// Output based on format (using pager for large results if enabled)
 	outputFunc := func(w io.Writer) {
-		switch displayFormat {
-		case FormatASCII:
+		switch {
+		case isPlanResult(cols) && displayFormat == FormatJSON:
+			printPlansJSONTo(w, allRows)
+		case isPlanResult(cols) && displayFormat != FormatCSV:
+			printPlansTo(w, allRows)
+		case displayFormat == FormatASCII:
 			printASCIITableTo(w, cols, allRows)
-		case FormatUnicode:
+		case displayFormat == FormatUnicode:
 			printUnicodeTableTo(w, cols, allRows)
-		case FormatCSV:
+		case displayFormat == FormatCSV:
 			printCSVTo(w, cols, allRows)
-		case FormatJSON:
+		case displayFormat == FormatJSON:
 			printJSONTo(w, cols, allRows)
 		default:
 			printDefaultTableTo(w, cols, allRows)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// planColumn names the column of the result sets aul returns for an
+// EXPLAIN batch, each row a query plan as JSON.
+const planColumn = "QUERY PLAN"
+
+// queryPlan is the plan of one statement, as aul returns it.
+type queryPlan struct {
+	Statement string `json:"statement"`
+	Rewrites  []struct {
+		Step string `json:"step"`
+		SQL  string `json:"sql"`
+	} `json:"rewrites"`
+	SQL      string      `json:"sql"`
+	Backend  string      `json:"backend"`
+	Analyzed bool        `json:"analyzed"`
+	Plan     []*planNode `json:"plan"`
+}
+
+type planNode struct {
+	Detail     string                 `json:"detail"`
+	Properties map[string]interface{} `json:"properties"`
+	Children   []*planNode            `json:"children"`
+}
+
+// isPlanResult reports whether a result set holds query plans.
+func isPlanResult(cols []string) bool {
+	return len(cols) == 1 && cols[0] == planColumn
+}
+
+// printPlansTo draws each plan as a tree: the statement, the steps that
+// rewrote it, then the backend's plan.
+func printPlansTo(w io.Writer, rows [][]string) {
+	for n, row := range rows {
+		var plan queryPlan
+		if err := json.Unmarshal([]byte(row[0]), &plan); err != nil {
+			fmt.Fprintln(w, row[0])
+			continue
+		}
+		if n > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s%s%s\n", colBold, oneLine(plan.Statement), colReset)
+		for _, step := range plan.Rewrites {
+			fmt.Fprintf(w, "|-- %s%s:%s %s\n", colCyan, step.Step, colReset, oneLine(step.SQL))
+		}
+		title := plan.Backend + " plan"
+		if plan.Analyzed {
+			title = plan.Backend + " plan, analyzed"
+		}
+		fmt.Fprintf(w, "`-- %s%s%s\n", colCyan, title, colReset)
+		for j, node := range plan.Plan {
+			printPlanNode(w, node, "    ", j == len(plan.Plan)-1)
+		}
+	}
+}
+
+// printPlanNode draws node and its children below a line starting with
+// indent.
+func printPlanNode(w io.Writer, node *planNode, indent string, last bool) {
+	branch, next := "|-- ", "|   "
+	if last {
+		branch, next = "`-- ", "    "
+	}
+	fmt.Fprintf(w, "%s%s%s", indent, branch, node.Detail)
+	if len(node.Properties) > 0 {
+		keys := make([]string, 0, len(node.Properties))
+		for k := range node.Properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		props := make([]string, len(keys))
+		for j, k := range keys {
+			props[j] = fmt.Sprintf("%s=%v", k, node.Properties[k])
+		}
+		fmt.Fprintf(w, " %s(%s)%s", colDim, strings.Join(props, ", "), colReset)
+	}
+	fmt.Fprintln(w)
+	for j, child := range node.Children {
+		printPlanNode(w, child, indent+next, j == len(node.Children)-1)
+	}
+}
+
+// printPlansJSONTo prints the plans as one indented JSON array.
+func printPlansJSONTo(w io.Writer, rows [][]string) {
+	docs := make([]json.RawMessage, 0, len(rows))
+	for _, row := range rows {
+		if json.Valid([]byte(row[0])) {
+			docs = append(docs, json.RawMessage(row[0]))
+		}
+	}
+	b, _ := json.Marshal(docs)
+	var out bytes.Buffer
+	json.Indent(&out, b, "", "  ")
+	fmt.Fprintln(w, out.String())
+}
+
+// oneLine collapses the whitespace of sql, so it fits on one line.
+func oneLine(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
@@ -250,11 +250,14 @@ func ParseSingle(annotations string) AnnotationSet {
 var (
 	// Procedure annotations
 	ProcAnnotations = map[string]string{
-		"jit-threshold": "int: Override default JIT threshold",
-		"no-jit":        "bool: Disable JIT for this procedure",
-		"timeout":       "duration: Execution timeout override",
-		"log-params":    "bool: Log parameter values",
-		"deprecated":    "bool: Log warning when called",
+		"jit-threshold":  "int: Override default JIT threshold",
+		"no-jit":         "bool: Disable JIT for this procedure",
+		"timeout":        "duration: Execution timeout override",
+		"log-params":     "bool: Log parameter values",
+		"deprecated":     "bool: Log warning when called",
+		"result":         "columns: Expected columns of the first result set (result.N for the Nth)",
+		"max-concurrent": "int: Calls that may run at once; the rest fail, or wait with queue",
+		"queue":          "bool: Calls past max-concurrent wait for a slot",
 	}
 
 	// Table annotations
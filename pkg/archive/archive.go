@@ -0,0 +1,669 @@
+// Package archive moves the old rows of archival tables to object storage.
+//
+// An archival table is partitioned by a date column, holding ISO dates or
+// times, into days, months or years. Every Interval the partitions whose
+// rows are all older than the table's Keep are written as Parquet files to
+// the store, an S3 bucket or a directory, recorded in the
+// aul_archive_partitions table and deleted from the table, which keeps the
+// primary database small. Rows arriving later for an archived partition
+// are archived as a further part of it.
+//
+// Queries read archived rows back transparently (see
+// tsqlruntime/archive.go): the archived partitions a query's range
+// reaches are restored into a table of the cache database attached beside
+// the primary one as aul_archive, and read together with the table's own
+// rows. Restored partitions stay in the cache, the least recently read
+// dropped first once it holds more than CacheRows rows.
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archiving defaults
+const (
+	DefaultInterval  = time.Hour
+	DefaultCacheRows = 1000000
+	DefaultRegion    = "us-east-1"
+)
+
+// CacheSchema is the name the cache database restored partitions are kept
+// in is attached as.
+const CacheSchema = "aul_archive"
+
+// Partitions of an archival table
+const (
+	PartitionDay   = "day"
+	PartitionMonth = "month"
+	PartitionYear  = "year"
+)
+
+// insertBatchRows bounds the rows of each INSERT restoring a partition.
+const insertBatchRows = 200
+
+// Config configures archiving.
+type Config struct {
+	Store     string        // s3://bucket/prefix, or a directory
+	Endpoint  string        // S3 endpoint ("" = AWS in Region), such as a MinIO server
+	Region    string        // S3 region ("" = AWS_REGION, or DefaultRegion)
+	Interval  time.Duration // Between archiving runs (0 = DefaultInterval)
+	CacheRows int           // Restored rows kept in the cache database (0 = DefaultCacheRows)
+	Tables    []Table
+}
+
+// Table is an archival table.
+type Table struct {
+	Name      string        // e.g. dbo.Orders
+	Column    string        // The date column partitioned by
+	Partition string        // day, month or year ("" = month)
+	Keep      time.Duration // Age of the rows kept in the table
+}
+
+// String formats t as ParseTables takes it.
+func (t Table) String() string {
+	return t.Name + ":" + t.Column + ":" + t.Partition + ":" + t.Keep.String()
+}
+
+// ParseTables parses a comma-separated list of archival tables, each as
+// table:column:partition:keep, such as dbo.Orders:OrderDate:month:2160h.
+func ParseTables(s string) ([]Table, error) {
+	var tables []Table
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("archival table %q: want table:column:partition:keep", item)
+		}
+		keep, err := time.ParseDuration(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("archival table %q: %w", item, err)
+		}
+		tables = append(tables, Table{Name: fields[0], Column: fields[1], Partition: fields[2], Keep: keep})
+	}
+	return tables, nil
+}
+
+// Executor runs statements, on a database or in a transaction.
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Archiver archives the partitions of a database's archival tables, and
+// restores them for queries.
+type Archiver struct {
+	config Config
+	store  Store
+	db     *sql.DB
+	tables []*table
+	now    func() time.Time
+}
+
+// table is an archival table of the database.
+type table struct {
+	Table
+	name     string // In the database, without a schema
+	restored string // The table of the cache database partitions are restored into
+	width    int    // Characters of the column's values naming their partition
+	layout   string // Of a partition's name
+}
+
+// New returns an Archiver of the tables config names in db, a SQLite
+// database with the cache database attached, keeping partitions in store.
+func New(ctx context.Context, db *sql.DB, store Store, config Config) (*Archiver, error) {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.CacheRows <= 0 {
+		config.CacheRows = DefaultCacheRows
+	}
+	a := &Archiver{config: config, store: store, db: db, now: time.Now}
+	for _, t := range config.Tables {
+		if t.Partition == "" {
+			t.Partition = PartitionMonth
+		}
+		tt := &table{Table: t}
+		switch strings.ToLower(t.Partition) {
+		case PartitionDay:
+			tt.width, tt.layout = 10, "2006-01-02"
+		case PartitionMonth:
+			tt.width, tt.layout = 7, "2006-01"
+		case PartitionYear:
+			tt.width, tt.layout = 4, "2006"
+		default:
+			return nil, fmt.Errorf("archival table %s: partition %q is not day, month or year", t.Name, t.Partition)
+		}
+		if t.Name == "" || t.Column == "" {
+			return nil, fmt.Errorf("archival table %q: a table and a column are required", t.Name)
+		}
+		if t.Keep <= 0 {
+			return nil, fmt.Errorf("archival table %s: keep must be positive", t.Name)
+		}
+		parts := strings.Split(t.Name, ".")
+		tt.name = strings.Trim(parts[len(parts)-1], "[]\"")
+		tt.restored = "aul_archived_" + tt.name
+		if a.table(tt.name) != nil {
+			return nil, fmt.Errorf("archival table %s is listed twice", t.Name)
+		}
+		a.tables = append(a.tables, tt)
+	}
+
+	var attached int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_database_list WHERE name = ?", CacheSchema).Scan(&attached); err != nil {
+		return nil, err
+	}
+	if attached == 0 {
+		return nil, fmt.Errorf("the database has no archive cache attached")
+	}
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS aul_archive_partitions (
+		table_name    TEXT NOT NULL,
+		partition_key TEXT NOT NULL,
+		part          INTEGER NOT NULL,
+		object_key    TEXT NOT NULL,
+		min_value     TEXT,
+		max_value     TEXT,
+		row_count     INTEGER NOT NULL,
+		bytes         INTEGER NOT NULL,
+		archived_at   TEXT NOT NULL,
+		PRIMARY KEY (table_name, partition_key, part)
+	)`); err != nil {
+		return nil, fmt.Errorf("archive catalog unavailable: %w", err)
+	}
+	return a, nil
+}
+
+// Config returns the archiver's configuration, with defaults filled in.
+func (a *Archiver) Config() Config {
+	return a.config
+}
+
+// table returns the archival table named name, with or without a schema,
+// or nil.
+func (a *Archiver) table(name string) *table {
+	parts := strings.Split(name, ".")
+	name = strings.Trim(parts[len(parts)-1], "[]\"")
+	for _, t := range a.tables {
+		if strings.EqualFold(t.name, name) {
+			return t
+		}
+	}
+	return nil
+}
+
+// Column returns the date column the archival table name is partitioned
+// by; ok is false for other tables.
+func (a *Archiver) Column(name string) (column string, ok bool) {
+	if t := a.table(name); t != nil {
+		return t.Column, true
+	}
+	return "", false
+}
+
+// quote delimits a SQLite identifier.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// partitionExpr is the SQL naming the partition of a row of t.
+func (t *table) partitionExpr() string {
+	return fmt.Sprintf("substr(%s, 1, %d)", quote(t.Column), t.width)
+}
+
+// Run archives the partitions of each archival table older than its Keep,
+// returning those archived as table/partition.
+func (a *Archiver) Run(ctx context.Context) ([]string, error) {
+	var archived []string
+	for _, t := range a.tables {
+		cutoff := a.now().Add(-t.Keep).UTC().Format(t.layout)
+		rows, err := a.db.QueryContext(ctx, fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s < ? ORDER BY 1",
+			t.partitionExpr(), quote(t.name), quote(t.Column)), cutoff)
+		if err != nil {
+			return archived, fmt.Errorf("archiving %s: %w", t.Name, err)
+		}
+		var keys []string
+		for rows.Next() {
+			var key sql.NullString
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return archived, err
+			}
+			// Values that are not dates stay in the table
+			if _, err := time.Parse(t.layout, key.String); err == nil {
+				keys = append(keys, key.String)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return archived, err
+		}
+		for _, key := range keys {
+			if err := a.archive(ctx, t, key); err != nil {
+				return archived, fmt.Errorf("archiving %s partition %s: %w", t.Name, key, err)
+			}
+			archived = append(archived, t.name+"/"+key)
+		}
+	}
+	if len(archived) > 0 {
+		// Frees the pages of the deleted rows, with auto_vacuum=INCREMENTAL
+		a.db.ExecContext(ctx, "PRAGMA incremental_vacuum")
+	}
+	return archived, nil
+}
+
+// archive moves partition key of t to the store.
+func (a *Archiver) archive(ctx context.Context, t *table, key string) error {
+	rows, err := a.db.QueryContext(ctx, fmt.Sprintf("SELECT rowid, * FROM %s WHERE %s = ?",
+		quote(t.name), t.partitionExpr()), key)
+	if err != nil {
+		return err
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return err
+	}
+	columns = columns[1:]
+	column := -1
+	for c, name := range columns {
+		if strings.EqualFold(name, t.Column) {
+			column = c
+		}
+	}
+	var (
+		data       [][]interface{}
+		last       int64
+		minV, maxV string
+	)
+	for rows.Next() {
+		values := make([]interface{}, len(columns)+1)
+		ptrs := make([]interface{}, len(values))
+		for j := range values {
+			ptrs[j] = &values[j]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			rows.Close()
+			return err
+		}
+		if id, _ := values[0].(int64); id > last {
+			last = id
+		}
+		row := values[1:]
+		if column >= 0 {
+			v := string(toBytes(row[column]))
+			if minV == "" || v < minV {
+				minV = v
+			}
+			if v > maxV {
+				maxV = v
+			}
+		}
+		data = append(data, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(data) == 0 {
+		return err
+	}
+
+	file, err := writeParquet(columns, data)
+	if err != nil {
+		return err
+	}
+	var part int
+	if err := a.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(part), 0) + 1 FROM aul_archive_partitions
+		WHERE table_name = ? AND partition_key = ?`, t.name, key).Scan(&part); err != nil {
+		return err
+	}
+	object := t.name + "/" + key + ".parquet"
+	if part > 1 {
+		object = t.name + "/" + key + "." + strconv.Itoa(part) + ".parquet"
+	}
+	if err := a.store.Put(ctx, object, file); err != nil {
+		return err
+	}
+
+	// The rows archived, not those arriving since, are deleted, and a
+	// restored copy of the partition discarded
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO aul_archive_partitions
+		(table_name, partition_key, part, object_key, min_value, max_value, row_count, bytes, archived_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.name, key, part, object, minV, maxV, len(data), len(file), a.now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND rowid <= ?",
+		quote(t.name), t.partitionExpr()), key, last); err != nil {
+		return err
+	}
+	if err := a.evict(ctx, tx, t, key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureCache creates the cache database's record of restored partitions.
+func ensureCache(ctx context.Context, db Executor) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS aul_archive.aul_restored (
+		table_name    TEXT NOT NULL,
+		partition_key TEXT NOT NULL,
+		row_count     INTEGER NOT NULL,
+		used_at       INTEGER NOT NULL,
+		PRIMARY KEY (table_name, partition_key)
+	)`)
+	return err
+}
+
+// evict drops the restored copy of partition key of t, if there is one.
+func (a *Archiver) evict(ctx context.Context, db Executor, t *table, key string) error {
+	if err := ensureCache(ctx, db); err != nil {
+		return err
+	}
+	res, err := db.ExecContext(ctx, "DELETE FROM aul_archive.aul_restored WHERE table_name = ? AND partition_key = ?", t.name, key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("DELETE FROM aul_archive.%s WHERE %s = ?",
+		quote(t.restored), t.partitionExpr()), key)
+	return err
+}
+
+// overlaps reports whether partition key of t holds values between from
+// and to, either of which may be "" for no bound.
+func (t *table) overlaps(key, from, to string) bool {
+	if len(from) > t.width {
+		from = from[:t.width]
+	}
+	return (from == "" || key >= from) && (to == "" || key <= to)
+}
+
+// Restore restores, through db, the archived partitions of the table name
+// holding values of its column between from and to, either of which may
+// be "" for no bound. It returns the cache database table the archived
+// rows are read from, or "" if none are in that range.
+func (a *Archiver) Restore(ctx context.Context, db Executor, name, from, to string) (string, error) {
+	t := a.table(name)
+	if t == nil {
+		return "", nil
+	}
+	objects := make(map[string][]string)
+	var keys []string
+	rows, err := db.QueryContext(ctx, `SELECT partition_key, object_key FROM aul_archive_partitions
+		WHERE table_name = ? ORDER BY partition_key, part`, t.name)
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+		var key, object string
+		if err := rows.Scan(&key, &object); err != nil {
+			rows.Close()
+			return "", err
+		}
+		if t.overlaps(key, from, to) {
+			if objects[key] == nil {
+				keys = append(keys, key)
+			}
+			objects[key] = append(objects[key], object)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(keys) == 0 {
+		return "", err
+	}
+
+	// Outside a transaction, a partition is restored whole or not at all
+	var own *sql.Tx
+	if d, ok := db.(*sql.DB); ok {
+		if own, err = d.BeginTx(ctx, nil); err != nil {
+			return "", err
+		}
+		defer own.Rollback()
+		db = own
+	}
+	if err := ensureCache(ctx, db); err != nil {
+		return "", err
+	}
+	if err := a.ensureRestoredTable(ctx, db, t); err != nil {
+		return "", err
+	}
+	restored := make(map[string]bool)
+	rows, err = db.QueryContext(ctx, "SELECT partition_key FROM aul_archive.aul_restored WHERE table_name = ?", t.name)
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return "", err
+		}
+		restored[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	used := a.now().UnixNano()
+	for _, key := range keys {
+		if restored[key] {
+			if _, err := db.ExecContext(ctx, "UPDATE aul_archive.aul_restored SET used_at = ? WHERE table_name = ? AND partition_key = ?",
+				used, t.name, key); err != nil {
+				return "", err
+			}
+			continue
+		}
+		n := 0
+		for _, object := range objects[key] {
+			count, err := a.restoreObject(ctx, db, t, object)
+			if err != nil {
+				return "", fmt.Errorf("restoring %s: %w", object, err)
+			}
+			n += count
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO aul_archive.aul_restored (table_name, partition_key, row_count, used_at) VALUES (?, ?, ?, ?)",
+			t.name, key, n, used); err != nil {
+			return "", err
+		}
+	}
+	if err := a.trimCache(ctx, db, used); err != nil {
+		return "", err
+	}
+	if own != nil {
+		if err := own.Commit(); err != nil {
+			return "", err
+		}
+	}
+	return t.restored, nil
+}
+
+// ensureRestoredTable creates the table of the cache database the
+// partitions of t are restored into, with the columns of t, and recreates
+// it, discarding the partitions restored, if those have changed.
+func (a *Archiver) ensureRestoredTable(ctx context.Context, db Executor, t *table) error {
+	hot, err := tableColumns(ctx, db, "main", t.name)
+	if err != nil {
+		return err
+	}
+	if hot == "" {
+		return fmt.Errorf("no such table: %s", t.name)
+	}
+	restored, err := tableColumns(ctx, db, CacheSchema, t.restored)
+	if err != nil || restored == hot {
+		return err
+	}
+	for _, stmt := range []string{
+		"DROP TABLE IF EXISTS aul_archive." + quote(t.restored),
+		"CREATE TABLE aul_archive." + quote(t.restored) + " AS SELECT * FROM main." + quote(t.name) + " WHERE 0",
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	_, err = db.ExecContext(ctx, "DELETE FROM aul_archive.aul_restored WHERE table_name = ?", t.name)
+	return err
+}
+
+// tableColumns describes the columns of table in schema, or returns "" if
+// there is no such table.
+func tableColumns(ctx context.Context, db Executor, schema, table string) (string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, type FROM pragma_table_info(?, ?) ORDER BY cid", table, schema)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return "", err
+		}
+		columns = append(columns, strings.ToLower(name)+" "+strings.ToUpper(typ))
+	}
+	return strings.Join(columns, ", "), rows.Err()
+}
+
+// restoreObject inserts the rows of an archived object into the restored
+// table of t, returning how many there were. Columns the table no longer
+// has are left out, and those it has gained are NULL.
+func (a *Archiver) restoreObject(ctx context.Context, db Executor, t *table, object string) (int, error) {
+	data, err := a.store.Get(ctx, object)
+	if err != nil {
+		return 0, err
+	}
+	columns, rows, err := readParquet(data)
+	if err != nil {
+		return 0, err
+	}
+	described, err := tableColumns(ctx, db, CacheSchema, t.restored)
+	if err != nil {
+		return 0, err
+	}
+	have := make(map[string]bool)
+	for _, c := range strings.Split(described, ", ") {
+		name, _, _ := strings.Cut(c, " ")
+		have[name] = true
+	}
+	var keep []int
+	var names []string
+	for c, name := range columns {
+		if have[strings.ToLower(name)] {
+			keep = append(keep, c)
+			names = append(names, quote(name))
+		}
+	}
+	if len(keep) == 0 {
+		return len(rows), nil
+	}
+
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(keep)), ", ") + ")"
+	for start := 0; start < len(rows); start += insertBatchRows {
+		batch := rows[start:min(start+insertBatchRows, len(rows))]
+		values := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*len(keep))
+		for r, row := range batch {
+			values[r] = placeholders
+			for _, c := range keep {
+				args = append(args, row[c])
+			}
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("INSERT INTO aul_archive.%s (%s) VALUES %s",
+			quote(t.restored), strings.Join(names, ", "), strings.Join(values, ", ")), args...); err != nil {
+			return 0, err
+		}
+	}
+	return len(rows), nil
+}
+
+// trimCache drops the least recently read partitions restored before
+// used, until the cache holds no more than CacheRows rows.
+func (a *Archiver) trimCache(ctx context.Context, db Executor, used int64) error {
+	rows, err := db.QueryContext(ctx, "SELECT table_name, partition_key, row_count, used_at FROM aul_archive.aul_restored ORDER BY used_at DESC")
+	if err != nil {
+		return err
+	}
+	type restored struct {
+		table, key string
+	}
+	var drop []restored
+	total := 0
+	for rows.Next() {
+		var r restored
+		var count int
+		var at int64
+		if err := rows.Scan(&r.table, &r.key, &count, &at); err != nil {
+			rows.Close()
+			return err
+		}
+		total += count
+		if total > a.config.CacheRows && at < used {
+			drop = append(drop, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, r := range drop {
+		t := a.table(r.table)
+		if t == nil {
+			// No longer archival
+			if _, err := db.ExecContext(ctx, "DELETE FROM aul_archive.aul_restored WHERE table_name = ?", r.table); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := a.evict(ctx, db, t, r.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Partition is an archived partition, or part of one.
+type Partition struct {
+	Table      string
+	Key        string // The partition, such as 2024-01
+	Part       int    // 1, and 2 on for rows archived after the first part
+	Object     string // Key of the Parquet file in the store
+	Min, Max   string // Of the partitioning column
+	Rows       int64
+	Bytes      int64
+	ArchivedAt time.Time
+}
+
+// Partitions returns the archived partitions, by table and partition.
+func (a *Archiver) Partitions(ctx context.Context) ([]Partition, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT table_name, partition_key, part, object_key,
+		COALESCE(min_value, ''), COALESCE(max_value, ''), row_count, bytes, archived_at
+		FROM aul_archive_partitions ORDER BY table_name, partition_key, part`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var partitions []Partition
+	for rows.Next() {
+		var p Partition
+		var at string
+		if err := rows.Scan(&p.Table, &p.Key, &p.Part, &p.Object, &p.Min, &p.Max, &p.Rows, &p.Bytes, &at); err != nil {
+			return nil, err
+		}
+		p.ArchivedAt, _ = time.Parse(time.RFC3339, at)
+		partitions = append(partitions, p)
+	}
+	return partitions, rows.Err()
+}
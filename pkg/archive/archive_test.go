@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testArchiver returns an Archiver of the orders table, partitioned by
+// month and keeping 60 days of rows, at 2024-04-15, over a database
+// holding orders from January to April.
+func testArchiver(t *testing.T, cacheRows int) (*Archiver, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range []string{
+		"ATTACH DATABASE ':memory:' AS aul_archive",
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, placed TEXT, amount REAL, note TEXT)",
+		`INSERT INTO orders (placed, amount, note) VALUES
+			('2024-01-05 10:00:00', 10, 'a'), ('2024-01-20', 20, NULL),
+			('2024-02-03', 30, 'b'), ('2024-03-30', 40, 'c'), ('2024-04-02', 50, 'd'), ('pending', 60, 'e')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := OpenStore(Config{Store: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(context.Background(), db, store, Config{
+		CacheRows: cacheRows,
+		Tables:    []Table{{Name: "dbo.Orders", Column: "placed", Keep: 60 * 24 * time.Hour}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return time.Date(2024, 4, 15, 12, 0, 0, 0, time.UTC) }
+	return a, db
+}
+
+func count(t *testing.T, db Executor, query string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRowContext(context.Background(), query).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestArchive(t *testing.T) {
+	a, db := testArchiver(t, 0)
+	ctx := context.Background()
+
+	archived, err := a.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// February 15 is the cutoff: January goes, February stays whole
+	if len(archived) != 1 || archived[0] != "Orders/2024-01" {
+		t.Fatalf("archived %v", archived)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM orders"); n != 4 {
+		t.Errorf("%d rows left", n)
+	}
+	partitions, err := a.Partitions(ctx)
+	if err != nil || len(partitions) != 1 {
+		t.Fatalf("partitions %+v, %v", partitions, err)
+	}
+	if p := partitions[0]; p.Object != "Orders/2024-01.parquet" || p.Rows != 2 || p.Min != "2024-01-05 10:00:00" || p.Max != "2024-01-20" {
+		t.Errorf("partition %+v", p)
+	}
+
+	// A late row for January is archived as a second part
+	if _, err := db.Exec("INSERT INTO orders (placed, amount) VALUES ('2024-01-31', 5)"); err != nil {
+		t.Fatal(err)
+	}
+	if archived, err = a.Run(ctx); err != nil || len(archived) != 1 {
+		t.Fatalf("archived %v, %v", archived, err)
+	}
+
+	// Ranges that miss the archive restore nothing
+	for _, r := range [][2]string{{"2024-02-01", ""}, {"2024-02", "2024-03-01"}} {
+		table, err := a.Restore(ctx, db, "Orders", r[0], r[1])
+		if err != nil || table != "" {
+			t.Errorf("restore %v: %q, %v", r, table, err)
+		}
+	}
+	table, err := a.Restore(ctx, db, "dbo.orders", "2024-01-10", "")
+	if err != nil || table != "aul_archived_Orders" {
+		t.Fatalf("restore: %q, %v", table, err)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM aul_archived_Orders WHERE note IS NULL AND amount < 25"); n != 2 {
+		t.Errorf("%d restored rows", n)
+	}
+	var total float64
+	if err := db.QueryRow("SELECT SUM(amount) FROM (SELECT * FROM orders UNION ALL SELECT * FROM aul_archived_Orders)").Scan(&total); err != nil || total != 215 {
+		t.Errorf("total %v, %v", total, err)
+	}
+
+	// Restoring again reads the cache
+	if table, err = a.Restore(ctx, db, "orders", "", "2024-01-31"); err != nil || table == "" {
+		t.Fatalf("restore: %q, %v", table, err)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM aul_archived_Orders"); n != 3 {
+		t.Errorf("%d restored rows", n)
+	}
+
+	// Columns added to the table are NULL in archived rows
+	if _, err := db.Exec("ALTER TABLE orders ADD COLUMN region TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Restore(ctx, db, "orders", "2024-01-01", ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM aul_archived_Orders WHERE region IS NULL"); n != 3 {
+		t.Errorf("%d restored rows", n)
+	}
+
+	if table, err := a.Restore(ctx, db, "customers", "", ""); err != nil || table != "" {
+		t.Errorf("not archival: %q, %v", table, err)
+	}
+}
+
+func TestArchiveCache(t *testing.T) {
+	a, db := testArchiver(t, 2)
+	a.config.Tables[0].Keep = 7 * 24 * time.Hour
+	a.tables[0].Keep = 7 * 24 * time.Hour
+	ctx := context.Background()
+	if archived, err := a.Run(ctx); err != nil || len(archived) != 3 {
+		t.Fatalf("archived %v, %v", archived, err)
+	}
+
+	// The cache holds 2 rows: January's, then February's and March's
+	for _, r := range [][2]string{{"2024-01-01", "2024-01-31"}, {"2024-02-01", "2024-03-31"}} {
+		if _, err := a.Restore(ctx, db, "orders", r[0], r[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM aul_archived_Orders WHERE placed >= '2024-02'"); n != 2 {
+		t.Errorf("%d rows of February and March", n)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM aul_archive.aul_restored"); n != 2 {
+		t.Errorf("%d partitions restored", n)
+	}
+
+	// A restore in a transaction that rolls back leaves the cache as it was
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Restore(ctx, tx, "orders", "", "2024-01-31"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, tx, "SELECT COUNT(*) FROM aul_archived_Orders WHERE placed < '2024-02'"); n != 2 {
+		t.Errorf("%d rows of January in the transaction", n)
+	}
+	tx.Rollback()
+	if n := count(t, db, "SELECT COUNT(*) FROM aul_archived_Orders"); n != 2 {
+		t.Errorf("%d rows after rollback", n)
+	}
+}
+
+func TestParseTables(t *testing.T) {
+	tables, err := ParseTables("dbo.Orders:OrderDate:month:2160h, Events:At:day:24h")
+	if err != nil || len(tables) != 2 || tables[1] != (Table{Name: "Events", Column: "At", Partition: "day", Keep: 24 * time.Hour}) {
+		t.Errorf("%+v, %v", tables, err)
+	}
+	if tables[0].String() != "dbo.Orders:OrderDate:month:2160h0m0s" {
+		t.Errorf("%s", tables[0])
+	}
+	for _, bad := range []string{"Orders:OrderDate:month", "Orders:OrderDate:month:90d"} {
+		if _, err := ParseTables(bad); err == nil {
+			t.Errorf("%s: want an error", bad)
+		}
+	}
+}
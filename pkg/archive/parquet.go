@@ -0,0 +1,478 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Partitions are archived as Parquet files of one row group, holding a
+// data page per column: PLAIN encoded, uncompressed, every column
+// OPTIONAL so that it can hold NULLs. A column is INT64 if its values are
+// integers, DOUBLE if they are numbers, and otherwise BYTE_ARRAY,
+// annotated as UTF8 unless it holds a BLOB. Such files are read by any
+// Parquet tool. The reader takes the same subset written by others:
+// flat schemas of uncompressed, PLAIN encoded data pages.
+
+const parquetMagic = "PAR1"
+
+// Parquet physical types
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetInt96     = 3
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+	parquetFixedLen  = 7
+)
+
+// Parquet enumerations the writer uses
+const (
+	parquetOptional     = 1
+	parquetUTF8         = 0 // Converted type
+	parquetPlain        = 0 // Encoding
+	parquetRLE          = 3 // Encoding
+	parquetUncompressed = 0 // Codec
+	parquetDataPage     = 0 // Page type
+)
+
+// writeParquet encodes rows, whose values are those database/sql scans
+// into interface{}, as a Parquet file with the given columns.
+func writeParquet(columns []string, rows [][]interface{}) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString(parquetMagic)
+
+	meta := newThriftWriter()
+	types := make([]int32, len(columns))
+	utf8 := make([]bool, len(columns))
+	type chunk struct {
+		offset, size int64
+	}
+	chunks := make([]chunk, len(columns))
+	for c := range columns {
+		types[c], utf8[c] = columnType(rows, c)
+		page, err := dataPage(rows, c, types[c])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", columns[c], err)
+		}
+		header := newThriftWriter()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.begin(5)
+		header.i32(1, int32(len(rows)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunks[c] = chunk{offset: int64(out.Len()), size: int64(header.buf.Len() + len(page))}
+		out.Write(header.buf.Bytes())
+		out.Write(page)
+	}
+
+	// FileMetaData
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.begin(0)
+	meta.binary(4, []byte("schema"))
+	meta.i32(5, int32(len(columns)))
+	meta.end()
+	for c, name := range columns {
+		meta.begin(0)
+		meta.i32(1, types[c])
+		meta.i32(3, parquetOptional)
+		meta.binary(4, []byte(name))
+		if utf8[c] {
+			meta.i32(6, parquetUTF8)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(len(rows)))
+	meta.list(4, thriftStruct, 1)
+	meta.begin(0)
+	meta.list(1, thriftStruct, len(columns))
+	var total int64
+	for c, name := range columns {
+		meta.begin(0)
+		meta.i64(2, chunks[c].offset)
+		meta.begin(3)
+		meta.i32(1, types[c])
+		meta.list(2, thriftI32, 2)
+		meta.zigzag(parquetPlain)
+		meta.zigzag(parquetRLE)
+		meta.list(3, thriftBinary, 1)
+		meta.varint(uint64(len(name)))
+		meta.buf.WriteString(name)
+		meta.i32(4, parquetUncompressed)
+		meta.i64(5, int64(len(rows)))
+		meta.i64(6, chunks[c].size)
+		meta.i64(7, chunks[c].size)
+		meta.i64(9, chunks[c].offset)
+		meta.end()
+		meta.end()
+		total += chunks[c].size
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(len(rows)))
+	meta.end()
+	meta.binary(6, []byte("aul"))
+	meta.end()
+
+	out.Write(meta.buf.Bytes())
+	binary.Write(&out, binary.LittleEndian, uint32(meta.buf.Len()))
+	out.WriteString(parquetMagic)
+	return out.Bytes(), nil
+}
+
+// columnType returns the physical type of column c of rows, and whether
+// it holds text.
+func columnType(rows [][]interface{}, c int) (int32, bool) {
+	ints, floats, blobs, other := 0, 0, 0, 0
+	for _, row := range rows {
+		switch row[c].(type) {
+		case nil:
+		case int64, int, bool:
+			ints++
+		case float64:
+			floats++
+		case []byte:
+			blobs++
+		default:
+			other++
+		}
+	}
+	switch {
+	case blobs > 0:
+		return parquetByteArray, false
+	case other > 0 || ints+floats == 0:
+		return parquetByteArray, true
+	case floats > 0:
+		return parquetDouble, false
+	}
+	return parquetInt64, false
+}
+
+// dataPage encodes column c of rows as a data page of typ: its definition
+// levels, then its values that are not NULL.
+func dataPage(rows [][]interface{}, c int, typ int32) ([]byte, error) {
+	defined := make([]bool, len(rows))
+	var values bytes.Buffer
+	for r, row := range rows {
+		v := row[c]
+		if v == nil {
+			continue
+		}
+		defined[r] = true
+		switch typ {
+		case parquetInt64:
+			binary.Write(&values, binary.LittleEndian, toInt64(v))
+		case parquetDouble:
+			f, ok := v.(float64)
+			if !ok {
+				f = float64(toInt64(v))
+			}
+			binary.Write(&values, binary.LittleEndian, math.Float64bits(f))
+		default:
+			b := toBytes(v)
+			binary.Write(&values, binary.LittleEndian, uint32(len(b)))
+			values.Write(b)
+		}
+	}
+	levels := encodeLevels(defined)
+	page := make([]byte, 4, 4+len(levels)+values.Len())
+	binary.LittleEndian.PutUint32(page, uint32(len(levels)))
+	page = append(page, levels...)
+	return append(page, values.Bytes()...), nil
+}
+
+func toInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+func toBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case time.Time:
+		return []byte(v.Format("2006-01-02 15:04:05.999999999"))
+	case int64:
+		return strconv.AppendInt(nil, v, 10)
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64)
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+// encodeLevels encodes definition levels of bit width 1 as runs of the
+// RLE/bit-packed hybrid encoding.
+func encodeLevels(defined []bool) []byte {
+	var out []byte
+	for j := 0; j < len(defined); {
+		run := 1
+		for j+run < len(defined) && defined[j+run] == defined[j] {
+			run++
+		}
+		out = binary.AppendUvarint(out, uint64(run)<<1)
+		if defined[j] {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		j += run
+	}
+	return out
+}
+
+// decodeLevels decodes n levels of bit width 1 in the RLE/bit-packed
+// hybrid encoding.
+func decodeLevels(data []byte, n int) ([]bool, error) {
+	levels := make([]bool, 0, n)
+	for len(levels) < n {
+		header, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, fmt.Errorf("truncated definition levels")
+		}
+		data = data[k:]
+		if header&1 == 0 {
+			if len(data) < 1 {
+				return nil, fmt.Errorf("truncated definition levels")
+			}
+			for run := header >> 1; run > 0 && len(levels) < n; run-- {
+				levels = append(levels, data[0] != 0)
+			}
+			data = data[1:]
+			continue
+		}
+		groups := int(header >> 1)
+		if len(data) < groups {
+			return nil, fmt.Errorf("truncated definition levels")
+		}
+		for _, b := range data[:groups] {
+			for bit := 0; bit < 8 && len(levels) < n; bit++ {
+				levels = append(levels, b>>bit&1 != 0)
+			}
+		}
+		data = data[groups:]
+	}
+	return levels, nil
+}
+
+// readParquet decodes a Parquet file into its column names and rows, whose
+// values are int64, float64, string, []byte, bool or nil.
+func readParquet(data []byte) ([]string, [][]interface{}, error) {
+	n := len(data)
+	if n < 12 || string(data[:4]) != parquetMagic || string(data[n-4:]) != parquetMagic {
+		return nil, nil, fmt.Errorf("not a Parquet file")
+	}
+	size := int(binary.LittleEndian.Uint32(data[n-8:]))
+	if size > n-12 {
+		return nil, nil, fmt.Errorf("truncated Parquet footer")
+	}
+	meta, err := (&thriftReader{data: data[n-8-size : n-8]}).readStruct()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	schema := meta.list(2)
+	if len(schema) == 0 {
+		return nil, nil, fmt.Errorf("Parquet file without a schema")
+	}
+	type leaf struct {
+		name     string
+		typ      int64
+		length   int
+		optional bool
+		text     bool
+	}
+	var leaves []leaf
+	for _, e := range schema[1:] {
+		el, _ := e.(thriftFields)
+		if el.int(5) > 0 || el.int(3) > parquetOptional {
+			return nil, nil, fmt.Errorf("column %s: nested and repeated columns are not supported", el.str(4))
+		}
+		_, text := el.strct(10)[1]
+		if _, ok := el[6]; ok && el.int(6) == parquetUTF8 {
+			text = true
+		}
+		leaves = append(leaves, leaf{
+			name:     el.str(4),
+			typ:      el.int(1),
+			length:   int(el.int(2)),
+			optional: el.int(3) == parquetOptional,
+			text:     text,
+		})
+	}
+
+	columns := make([]string, len(leaves))
+	for c, l := range leaves {
+		columns[c] = l.name
+	}
+	var rows [][]interface{}
+	for _, g := range meta.list(4) {
+		group, _ := g.(thriftFields)
+		chunks := group.list(1)
+		if len(chunks) != len(leaves) {
+			return nil, nil, fmt.Errorf("row group has %d columns, schema %d", len(chunks), len(leaves))
+		}
+		count := int(group.int(3))
+		base := len(rows)
+		for j := 0; j < count; j++ {
+			rows = append(rows, make([]interface{}, len(leaves)))
+		}
+		for c, ch := range chunks {
+			l := leaves[c]
+			cm := ch.(thriftFields).strct(3)
+			if cm.int(4) != parquetUncompressed {
+				return nil, nil, fmt.Errorf("column %s: compressed columns are not supported", l.name)
+			}
+			if _, ok := cm[11]; ok {
+				return nil, nil, fmt.Errorf("column %s: dictionary encoding is not supported", l.name)
+			}
+			pos := int(cm.int(9))
+			for r := 0; r < count; {
+				if pos < 0 || pos >= n {
+					return nil, nil, fmt.Errorf("column %s: page offset out of range", l.name)
+				}
+				hr := &thriftReader{data: data[:n-8-size], pos: pos}
+				header, err := hr.readStruct()
+				if err != nil {
+					return nil, nil, err
+				}
+				body, err := hr.bytes(int(header.int(3)))
+				if err != nil {
+					return nil, nil, err
+				}
+				pos = hr.pos
+				if header.int(1) != parquetDataPage {
+					return nil, nil, fmt.Errorf("column %s: only version 1 data pages are supported", l.name)
+				}
+				dp := header.strct(5)
+				if dp.int(2) != parquetPlain {
+					return nil, nil, fmt.Errorf("column %s: only PLAIN encoding is supported", l.name)
+				}
+				values := int(dp.int(1))
+				if values <= 0 || r+values > count {
+					return nil, nil, fmt.Errorf("column %s: page of %d values in a row group of %d", l.name, values, count)
+				}
+				defined := make([]bool, values)
+				if l.optional {
+					if len(body) < 4 {
+						return nil, nil, fmt.Errorf("column %s: truncated page", l.name)
+					}
+					k := int(binary.LittleEndian.Uint32(body))
+					if k > len(body)-4 {
+						return nil, nil, fmt.Errorf("column %s: truncated page", l.name)
+					}
+					if defined, err = decodeLevels(body[4:4+k], values); err != nil {
+						return nil, nil, fmt.Errorf("column %s: %w", l.name, err)
+					}
+					body = body[4+k:]
+				} else {
+					for j := range defined {
+						defined[j] = true
+					}
+				}
+				dec := plainDecoder{data: body}
+				for j := 0; j < values; j++ {
+					if defined[j] {
+						if rows[base+r+j][c], err = dec.value(l.typ, l.length, l.text); err != nil {
+							return nil, nil, fmt.Errorf("column %s: %w", l.name, err)
+						}
+					}
+				}
+				r += values
+			}
+		}
+	}
+	return columns, rows, nil
+}
+
+// plainDecoder reads PLAIN encoded values.
+type plainDecoder struct {
+	data []byte
+	bit  int // Position in the current byte of BOOLEAN values
+}
+
+func (d *plainDecoder) take(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data) {
+		return nil, fmt.Errorf("truncated values")
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *plainDecoder) value(typ int64, length int, text bool) (interface{}, error) {
+	switch typ {
+	case parquetBoolean:
+		if len(d.data) == 0 {
+			return nil, fmt.Errorf("truncated values")
+		}
+		v := d.data[0]>>d.bit&1 != 0
+		if d.bit++; d.bit == 8 {
+			d.bit, d.data = 0, d.data[1:]
+		}
+		return v, nil
+	case parquetInt32:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int32(binary.LittleEndian.Uint32(b))), nil
+	case parquetInt64:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.LittleEndian.Uint64(b)), nil
+	case parquetFloat:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case parquetDouble:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case parquetByteArray, parquetFixedLen:
+		n := length
+		if typ == parquetByteArray {
+			b, err := d.take(4)
+			if err != nil {
+				return nil, err
+			}
+			n = int(binary.LittleEndian.Uint32(b))
+		}
+		b, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		if text {
+			return string(b), nil
+		}
+		return append([]byte{}, b...), nil
+	}
+	return nil, fmt.Errorf("unsupported physical type %d", typ)
+}
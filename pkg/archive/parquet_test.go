@@ -0,0 +1,54 @@
+package archive
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	columns := []string{"id", "amount", "placed", "note", "photo"}
+	rows := [][]interface{}{
+		{int64(1), 9.5, "2024-01-05 10:00:00", "first", []byte{0, 1, 2}},
+		{int64(2), int64(3), "2024-01-06", nil, nil},
+		{int64(3), nil, "2024-01-07", "", []byte{}},
+	}
+	for j := 0; j < 20; j++ {
+		rows = append(rows, []interface{}{int64(4 + j), nil, "2024-01-08", nil, nil})
+	}
+	data, err := writeParquet(columns, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotColumns, gotRows, err := readParquet(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotColumns, columns) {
+		t.Errorf("columns = %v", gotColumns)
+	}
+	// Integers in a column of numbers are read back as numbers
+	rows[1][1] = 3.0
+	if !reflect.DeepEqual(gotRows, rows) {
+		t.Errorf("rows = %v\nwant %v", gotRows, rows)
+	}
+
+	for _, bad := range [][]byte{nil, []byte("PAR1PAR1"), data[:len(data)-1], append([]byte("PAR1\x05\x00\x00\x00"), data[4:]...)[:len(data)]} {
+		if _, _, err := readParquet(bad); err == nil {
+			t.Errorf("read %d bytes: want an error", len(bad))
+		}
+	}
+}
+
+func TestDecodeLevels(t *testing.T) {
+	// A bit-packed run of 8 levels, as other writers produce, then an RLE
+	// run of 3
+	levels, err := decodeLevels([]byte{0x03, 0xa5, 0x06, 0x01}, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, false, true, false, false, true, false, true, true, true, true}
+	if !reflect.DeepEqual(levels, want) {
+		t.Errorf("levels = %v", levels)
+	}
+}
@@ -0,0 +1,213 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Timeout bounds each request to the S3 store.
+const s3Timeout = 5 * time.Minute
+
+// s3Store is a store in an S3 bucket, or a bucket of any service with the
+// S3 API, such as MinIO. Objects are addressed path-style, as
+// endpoint/bucket/key, and requests signed with AWS Signature Version 4
+// using the credentials of the environment: AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and, for temporary ones, AWS_SESSION_TOKEN.
+type s3Store struct {
+	client   *http.Client
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	now func() time.Time
+}
+
+func newS3Store(bucket, prefix, endpoint, region string) (*s3Store, error) {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = DefaultRegion
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	} else if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	s := &s3Store{
+		client:       &http.Client{Timeout: s3Timeout},
+		endpoint:     u,
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		now:          time.Now,
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("S3 credentials missing: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.do(ctx, http.MethodPut, key, data)
+	return err
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.do(ctx, http.MethodGet, key, nil)
+}
+
+// do sends a signed request for the object key, returning the response
+// body.
+func (s *s3Store) do(ctx context.Context, method, key string, body []byte) ([]byte, error) {
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	path := strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + "/" + s3Escape(s.bucket) + "/" + s3Escape(key)
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint.Scheme+"://"+s.endpoint.Host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, path, body, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("S3 %s %s: %s: %s", method, key, resp.Status, s3ErrorCode(data))
+	}
+	return data, nil
+}
+
+// s3ErrorCode returns the Code of an S3 error document.
+func s3ErrorCode(body []byte) string {
+	text := string(body)
+	start := strings.Index(text, "<Code>")
+	end := strings.Index(text, "</Code>")
+	if start < 0 || end < start {
+		return strings.TrimSpace(text)
+	}
+	return text[start+len("<Code>") : end]
+}
+
+// sign adds the headers of AWS Signature Version 4 to req, whose escaped
+// path is path, for a request made at now.
+func (s *s3Store) sign(req *http.Request, path string, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	// Host, Range and the x-amz- headers are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonical.String(),
+		signed,
+		payload,
+	}, "\n")
+	requestSum := sha256.Sum256([]byte(request))
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(requestSum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery sorts and escapes the parameters of a query string.
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, queryEscape(name)+"="+queryEscape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func queryEscape(s string) string {
+	return strings.ReplaceAll(s3Escape(s), "/", "%2F")
+}
+
+// s3Escape escapes a path as Signature Version 4 does: everything but
+// unreserved characters and slashes.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
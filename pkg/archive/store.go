@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store keeps archived partitions as objects, under keys such as
+// Orders/2024-01.parquet.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// OpenStore opens the store config names: an S3 bucket as
+// s3://bucket/prefix, or a directory, as a path or a file:// URL.
+func OpenStore(config Config) (Store, error) {
+	location := config.Store
+	switch {
+	case location == "":
+		return nil, fmt.Errorf("no archive store configured")
+	case strings.HasPrefix(location, "s3://"):
+		u, err := url.Parse(location)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid archive store %q: want s3://bucket/prefix", location)
+		}
+		return newS3Store(u.Host, strings.Trim(u.Path, "/"), config.Endpoint, config.Region)
+	case strings.HasPrefix(location, "file://"):
+		location = strings.TrimPrefix(location, "file://")
+	case strings.Contains(location, "://"):
+		return nil, fmt.Errorf("unsupported archive store %q", location)
+	}
+	if err := os.MkdirAll(location, 0o755); err != nil {
+		return nil, err
+	}
+	return dirStore(location), nil
+}
+
+// dirStore is a store in a local directory, or one mounted from object
+// storage.
+type dirStore string
+
+func (d dirStore) path(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+func (d dirStore) Put(ctx context.Context, key string, data []byte) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d dirStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(d.path(key))
+}
@@ -0,0 +1,268 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Parquet's metadata is Thrift structures in the compact protocol. The
+// writer emits the few fields the files here need; the reader decodes any
+// structure generically, into a map of field ids to values, so that fields
+// written by other tools are skipped rather than misread.
+
+// Compact protocol field types
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+)
+
+// thriftWriter encodes a structure in the compact protocol.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // Last field id written, per open structure
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) binary(id int16, b []byte) {
+	w.field(id, thriftBinary)
+	w.varint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+// list starts a list field of n elements of typ, which follow.
+func (w *thriftWriter) list(id int16, typ byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | typ)
+	} else {
+		w.buf.WriteByte(0xf0 | typ)
+		w.varint(uint64(n))
+	}
+}
+
+// begin starts a structure, as field id or, for id 0, as a list element.
+func (w *thriftWriter) begin(id int16) {
+	if id != 0 {
+		w.field(id, thriftStruct)
+	}
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+// thriftFields is a decoded structure. Integers decode as int64, binary
+// as []byte, lists and sets as []interface{} and maps as [][2]interface{}.
+type thriftFields map[int16]interface{}
+
+func (s thriftFields) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s thriftFields) str(id int16) string {
+	v, _ := s[id].([]byte)
+	return string(v)
+}
+
+func (s thriftFields) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+func (s thriftFields) strct(id int16) thriftFields {
+	v, _ := s[id].(thriftFields)
+	return v
+}
+
+// thriftReader decodes compact protocol structures from a byte slice.
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+var errThriftTruncated = fmt.Errorf("truncated metadata")
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errThriftTruncated
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) zigzag() (int64, error) {
+	v, err := r.varint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errThriftTruncated
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *thriftReader) readStruct() (thriftFields, error) {
+	s := make(thriftFields)
+	var last int16
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+		typ := b & 0x0f
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			v, err := r.zigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		last = id
+		switch typ {
+		case thriftBoolTrue:
+			s[id] = true
+		case thriftBoolFalse:
+			s[id] = false
+		default:
+			if s[id], err = r.value(typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (r *thriftReader) value(typ byte) (interface{}, error) {
+	switch typ {
+	case thriftBoolTrue, thriftBoolFalse:
+		b, err := r.byte()
+		return b == thriftBoolTrue, err
+	case thriftByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftDouble:
+		b, err := r.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case thriftBinary:
+		n, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		return r.bytes(int(n))
+	case thriftList, thriftSet:
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(h >> 4)
+		if n == 15 {
+			if n, err = r.varint(); err != nil {
+				return nil, err
+			}
+		}
+		if n > uint64(len(r.data)) {
+			return nil, errThriftTruncated
+		}
+		items := make([]interface{}, n)
+		for j := range items {
+			if items[j], err = r.value(h & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case thriftMap:
+		n, err := r.varint()
+		if err != nil || n == 0 {
+			return [][2]interface{}(nil), err
+		}
+		if n > uint64(len(r.data)) {
+			return nil, errThriftTruncated
+		}
+		kv, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		items := make([][2]interface{}, n)
+		for j := range items {
+			if items[j][0], err = r.value(kv >> 4); err != nil {
+				return nil, err
+			}
+			if items[j][1], err = r.value(kv & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case thriftStruct:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("unknown metadata field type %d", typ)
+}
@@ -0,0 +1,218 @@
+// Package auth authenticates clients by SQL logins: names and passwords
+// kept in a table of the storage backend, as SQL Server keeps them in
+// sys.sql_logins. Listeners that require logins check the credentials
+// their clients send against a Store; the others accept any client. What
+// a login may do once signed in is kept by Permissions (see
+// permissions.go).
+//
+// Passwords are stored as bcrypt hashes. Checking one takes tens of
+// milliseconds by design, which suits a connection's login but not an
+// HTTP API checking the same credentials on every request, so a Store
+// remembers the credentials it last accepted for each login, keyed by
+// an HMAC under a key that lives only as long as the process.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoginTable is the table logins are kept in. It is
+// tsqlruntime.CredentialTable, which no client's SQL may name.
+const LoginTable = "aul_logins"
+
+// ErrLoginFailed is returned for credentials that are not those of an
+// enabled login. It does not say which, as SQL Server does not tell
+// clients why a login failed.
+var ErrLoginFailed = errors.New("incorrect login name or password")
+
+// Login is a SQL login, as configuration provisions it: with a password,
+// hashed before it is stored, or the bcrypt hash of one, so that the
+// password itself need not be written down.
+type Login struct {
+	Name         string `json:"name"`
+	Password     string `json:"password,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	Disabled     bool   `json:"disabled,omitempty"`
+}
+
+// Store keeps SQL logins in LoginTable of a database.
+type Store struct {
+	db *sql.DB
+
+	key      []byte // HMAC key of accepted credentials
+	mu       sync.Mutex
+	accepted map[string]acceptedLogin // By lower-cased login name
+}
+
+// acceptedLogin is the credentials last accepted for a login, and the
+// hash they were checked against.
+type acceptedLogin struct {
+	hash string
+	mac  []byte
+}
+
+// dummyHash is checked against for logins that do not exist, so that
+// response times do not tell which do.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("aul"), bcrypt.DefaultCost)
+	return hash
+})
+
+// NewStore returns a Store over db, creating LoginTable if it is
+// missing.
+func NewStore(ctx context.Context, db *sql.DB) (*Store, error) {
+	if db == nil {
+		return nil, fmt.Errorf("the storage backend has no database to keep logins in")
+	}
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+LoginTable+
+		" (name VARCHAR(128) NOT NULL PRIMARY KEY, password_hash VARCHAR(100) NOT NULL,"+
+		" disabled INTEGER NOT NULL DEFAULT 0, created_at BIGINT NOT NULL, modified_at BIGINT NOT NULL)")
+	if err != nil {
+		return nil, fmt.Errorf("logins unavailable: %w", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &Store{db: db, key: key, accepted: make(map[string]acceptedLogin)}, nil
+}
+
+// HashPassword returns the bcrypt hash a login's password is stored as.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Authenticate returns ErrLoginFailed unless password is that of the
+// enabled login name. Login names are case-insensitive, as they are in
+// SQL Server; passwords are not.
+func (s *Store) Authenticate(ctx context.Context, name, password string) error {
+	var hash string
+	var disabled int
+	err := s.db.QueryRowContext(ctx, "SELECT password_hash, disabled FROM "+LoginTable+
+		" WHERE LOWER(name) = LOWER(?)", name).Scan(&hash, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return ErrLoginFailed
+	}
+	if err != nil {
+		return err
+	}
+	if !s.check(name, hash, password) || disabled != 0 {
+		return ErrLoginFailed
+	}
+	return nil
+}
+
+// check reports whether password matches hash, the stored hash of the
+// login name's password.
+func (s *Store) check(name, hash, password string) bool {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(password))
+	sum := mac.Sum(nil)
+	key := strings.ToLower(name)
+
+	s.mu.Lock()
+	last, ok := s.accepted[key]
+	s.mu.Unlock()
+	if ok && last.hash == hash && hmac.Equal(last.mac, sum) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	s.mu.Lock()
+	s.accepted[key] = acceptedLogin{hash: hash, mac: sum}
+	s.mu.Unlock()
+	return true
+}
+
+// SetLogin creates login, or updates the login of the same name. A
+// password matching the stored hash keeps it, so that provisioning the
+// same logins at every startup changes nothing.
+func (s *Store) SetLogin(ctx context.Context, login Login) error {
+	if login.Name == "" {
+		return fmt.Errorf("a login needs a name")
+	}
+	if (login.Password == "") == (login.PasswordHash == "") {
+		return fmt.Errorf("login %s: exactly one of password and password_hash is required", login.Name)
+	}
+	if login.PasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(login.PasswordHash)); err != nil {
+			return fmt.Errorf("login %s: password_hash is not a bcrypt hash: %w", login.Name, err)
+		}
+	}
+	disabled := 0
+	if login.Disabled {
+		disabled = 1
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stored string
+	err = tx.QueryRowContext(ctx, "SELECT password_hash FROM "+LoginTable+
+		" WHERE LOWER(name) = LOWER(?)", login.Name).Scan(&stored)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	hash := login.PasswordHash
+	if hash == "" {
+		if exists && bcrypt.CompareHashAndPassword([]byte(stored), []byte(login.Password)) == nil {
+			hash = stored
+		} else if hash, err = HashPassword(login.Password); err != nil {
+			return fmt.Errorf("login %s: %w", login.Name, err)
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	if exists {
+		_, err = tx.ExecContext(ctx, "UPDATE "+LoginTable+
+			" SET modified_at = CASE WHEN password_hash = ? AND disabled = ? THEN modified_at ELSE ? END,"+
+			" password_hash = ?, disabled = ? WHERE LOWER(name) = LOWER(?)", hash, disabled, now, hash, disabled, login.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "INSERT INTO "+LoginTable+
+			" (name, password_hash, disabled, created_at, modified_at) VALUES (?, ?, ?, ?, ?)",
+			login.Name, hash, disabled, now, now)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Provision creates or updates logins, as a configuration file lists
+// them. Logins it does not list are left as they are.
+func (s *Store) Provision(ctx context.Context, logins []Login) error {
+	for _, login := range logins {
+		if err := s.SetLogin(ctx, login); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropLogin removes the login name.
+func (s *Store) DropLogin(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM "+LoginTable+" WHERE LOWER(name) = LOWER(?)", name)
+	return err
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testStore returns a Store over a fresh database.
+func testStore(t *testing.T) (*Store, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "logins.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store, err := NewStore(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, db
+}
+
+func TestAuthenticate(t *testing.T) {
+	store, db := testStore(t)
+	ctx := context.Background()
+	hash, err := HashPassword("hashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Provision(ctx, []Login{
+		{Name: "app", Password: "s3cret"},
+		{Name: "Reporting", PasswordHash: hash},
+		{Name: "old", Password: "old", Disabled: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Passwords are not stored as given
+	var stored string
+	if err := db.QueryRow("SELECT password_hash FROM " + LoginTable + " WHERE name = 'app'").Scan(&stored); err != nil || stored == "s3cret" {
+		t.Errorf("stored hash = %q, %v", stored, err)
+	}
+
+	for _, tc := range []struct {
+		name, password string
+		ok             bool
+	}{
+		{"app", "s3cret", true},
+		{"APP", "s3cret", true}, // Names are case-insensitive
+		{"app", "s3cret", true}, // Accepted again without bcrypt
+		{"app", "S3CRET", false},
+		{"app", "", false},
+		{"reporting", "hashed", true},
+		{"old", "old", false},
+		{"missing", "s3cret", false},
+	} {
+		err := store.Authenticate(ctx, tc.name, tc.password)
+		if tc.ok && err != nil || !tc.ok && !errors.Is(err, ErrLoginFailed) {
+			t.Errorf("%s/%q: %v", tc.name, tc.password, err)
+		}
+	}
+
+	// Changing a password stops the old one working, even where it was
+	// accepted before
+	if err := store.SetLogin(ctx, Login{Name: "app", Password: "n3w"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Authenticate(ctx, "app", "s3cret"); !errors.Is(err, ErrLoginFailed) {
+		t.Errorf("old password after change: %v", err)
+	}
+	if err := store.Authenticate(ctx, "app", "n3w"); err != nil {
+		t.Errorf("new password: %v", err)
+	}
+
+	if err := store.DropLogin(ctx, "App"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Authenticate(ctx, "app", "n3w"); !errors.Is(err, ErrLoginFailed) {
+		t.Errorf("dropped login: %v", err)
+	}
+}
+
+func TestProvision(t *testing.T) {
+	store, db := testStore(t)
+	ctx := context.Background()
+	logins := []Login{{Name: "app", Password: "s3cret"}}
+	if err := store.Provision(ctx, logins); err != nil {
+		t.Fatal(err)
+	}
+	read := func() (string, int64) {
+		t.Helper()
+		var h string
+		var m int64
+		if err := db.QueryRow("SELECT password_hash, modified_at FROM "+LoginTable).Scan(&h, &m); err != nil {
+			t.Fatal(err)
+		}
+		return h, m
+	}
+	hash, modified := read()
+
+	// Provisioning the same logins again, as each startup does, changes
+	// nothing
+	if err := store.Provision(ctx, logins); err != nil {
+		t.Fatal(err)
+	}
+	if h, m := read(); h != hash || m != modified {
+		t.Errorf("reprovisioning changed the login: %q at %d, was %q at %d", h, m, hash, modified)
+	}
+
+	for _, login := range []Login{
+		{Password: "x"},
+		{Name: "both", Password: "x", PasswordHash: hash},
+		{Name: "neither"},
+		{Name: "plain", PasswordHash: "not-a-hash"},
+	} {
+		if err := store.SetLogin(ctx, login); err == nil {
+			t.Errorf("%+v: want an error", login)
+		}
+	}
+}
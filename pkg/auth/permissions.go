@@ -0,0 +1,571 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Permissions are kept as SQL Server keeps them in sys.database_permissions
+// and sys.database_role_members: a GRANT or DENY of a permission on a
+// securable to a principal, a login or a role, and the members of each
+// role. Every login is a member of the public role.
+//
+// Besides the roles CREATE ROLE creates there are SQL Server's fixed
+// roles, which cannot be dropped: the server role sysadmin, whose members
+// may do anything, as may those of db_owner; db_datareader, whose members
+// may SELECT from every user table and view, and db_datawriter, whose
+// members may INSERT, UPDATE and DELETE in them; and db_denydatareader and
+// db_denydatawriter, which deny the same. aul's own tables, named with
+// ReservedPrefix, are not user objects: no role or grant gives a login
+// that is not an administrator a permission on one.
+//
+// A login may take a permission on an object if it, or a role it is a
+// member of, is granted the permission on the object, its schema or the
+// database, and none of them is denied it at any of those. A DENY wins
+// over any GRANT.
+//
+// Checks read the permissions from memory, and never wait for the
+// database, which a session's transaction may be holding. Changes are
+// made in the session's transaction, if it has one, and apply to checks
+// at once; one rolled back is undone in memory when the permissions are
+// next read.
+
+// Tables permissions and roles are kept in.
+const (
+	RoleTable       = "aul_roles"
+	RoleMemberTable = "aul_role_members"
+	PermissionTable = "aul_permissions"
+)
+
+// ReservedPrefix begins the names of the tables above, LoginTable's and
+// those of aul's other own tables.
+const ReservedPrefix = "aul_"
+
+// Public is the role every login is a member of.
+const Public = "public"
+
+// Fixed roles.
+const (
+	Sysadmin         = "sysadmin" // A server role
+	DBOwner          = "db_owner"
+	DBDataReader     = "db_datareader"
+	DBDataWriter     = "db_datawriter"
+	DBDenyDataReader = "db_denydatareader"
+	DBDenyDataWriter = "db_denydatawriter"
+)
+
+// FixedDatabaseRoles are the fixed database roles.
+var FixedDatabaseRoles = []string{DBOwner, DBDataReader, DBDataWriter, DBDenyDataReader, DBDenyDataWriter}
+
+// fixedPermissions are the permissions the fixed database roles hold on
+// every user object.
+var fixedPermissions = map[string]map[string]string{
+	DBDataReader:     {"SELECT": Grant},
+	DBDataWriter:     {"INSERT": Grant, "UPDATE": Grant, "DELETE": Grant},
+	DBDenyDataReader: {"SELECT": Deny},
+	DBDenyDataWriter: {"INSERT": Deny, "UPDATE": Deny, "DELETE": Deny},
+}
+
+// fixedRole reports whether name, lower-cased, is a fixed database role.
+func fixedRole(name string) bool {
+	for _, role := range FixedDatabaseRoles {
+		if name == role {
+			return true
+		}
+	}
+	return false
+}
+
+// States of a permission, and REVOKE, which removes either.
+const (
+	Grant  = "GRANT"
+	Deny   = "DENY"
+	Revoke = "REVOKE"
+)
+
+// Scopes of the securables permissions are granted on.
+const (
+	ScopeObject   = "OBJECT"
+	ScopeSchema   = "SCHEMA"
+	ScopeDatabase = "DATABASE"
+)
+
+// Object permissions that can be granted.
+var objectPermissions = map[string]bool{
+	"SELECT":  true,
+	"INSERT":  true,
+	"UPDATE":  true,
+	"DELETE":  true,
+	"EXECUTE": true,
+}
+
+// permissionsTTL is how long permissions are read from memory before
+// being read again in the background, so that those changed by other
+// servers sharing the backend apply.
+const permissionsTTL = 5 * time.Second
+
+// Errors of a PrincipalError.
+var (
+	ErrUnknownPrincipal = errors.New("no such login or role")
+	ErrPrincipalExists  = errors.New("a login or role of that name exists")
+	ErrRoleHasMembers   = errors.New("the role has members")
+	ErrFixedRole        = errors.New("fixed roles cannot be dropped")
+)
+
+// PrincipalError is returned for a login or role that does not exist, or
+// for a role that cannot be created or dropped.
+type PrincipalError struct {
+	Name string
+	Err  error // ErrUnknownPrincipal, ErrPrincipalExists, ErrRoleHasMembers or ErrFixedRole
+}
+
+func (e *PrincipalError) Error() string { return e.Name + ": " + e.Err.Error() }
+func (e *PrincipalError) Unwrap() error { return e.Err }
+
+// PermissionConfig configures the permissions of sessions signed in with
+// SQL logins.
+type PermissionConfig struct {
+	// Check statements against the login's permissions. Off, GRANT, DENY
+	// and REVOKE are recorded but every login may do anything
+	Enforce bool `json:"enforce_permissions,omitempty"`
+
+	// Logins that may do anything, and manage permissions and roles
+	Administrators []string `json:"administrators,omitempty"`
+}
+
+// Change is a GRANT, DENY or REVOKE of permissions on a securable.
+type Change struct {
+	State       string   // Grant, Deny or Revoke
+	Permissions []string // SELECT, INSERT, UPDATE, DELETE or EXECUTE
+	Scope       string   // ScopeObject, ScopeSchema or ScopeDatabase
+	Name        string   // The object, as schema.name, or the schema; "" for the database
+	Principals  []string // Logins and roles
+}
+
+// Executor runs statements: a database, or a session's transaction in
+// one.
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Permissions keeps the permissions of a Store's logins, and their roles,
+// in tables of the same database.
+type Permissions struct {
+	db     *sql.DB
+	admins map[string]bool // By lower-cased login name
+
+	mu         sync.Mutex
+	snap       *permissionSnapshot
+	refreshing bool // snap is being read again in the background
+}
+
+// permissionSnapshot is the permissions and role memberships, as last
+// read.
+type permissionSnapshot struct {
+	read    time.Time
+	roles   map[string][]string      // Roles by member
+	granted map[permissionKey]string // Grant or Deny
+}
+
+// permissionKey is a permission on a securable held by a principal.
+type permissionKey struct {
+	securable, permission, principal string
+}
+
+// NewPermissions returns the permissions of the logins in logins, creating
+// their tables if they are missing. The logins administrators may do
+// anything.
+func NewPermissions(ctx context.Context, logins *Store, administrators []string) (*Permissions, error) {
+	for _, ddl := range []string{
+		"CREATE TABLE IF NOT EXISTS " + RoleTable +
+			" (name VARCHAR(128) NOT NULL PRIMARY KEY, created_at BIGINT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS " + RoleMemberTable +
+			" (role VARCHAR(128) NOT NULL, member VARCHAR(128) NOT NULL, PRIMARY KEY (role, member))",
+		"CREATE TABLE IF NOT EXISTS " + PermissionTable +
+			" (securable VARCHAR(300) NOT NULL, permission VARCHAR(32) NOT NULL, principal VARCHAR(128) NOT NULL," +
+			" state VARCHAR(8) NOT NULL, modified_at BIGINT NOT NULL, PRIMARY KEY (securable, permission, principal))",
+	} {
+		if _, err := logins.db.ExecContext(ctx, ddl); err != nil {
+			return nil, fmt.Errorf("permissions unavailable: %w", err)
+		}
+	}
+	admins := make(map[string]bool, len(administrators))
+	for _, name := range administrators {
+		admins[strings.ToLower(name)] = true
+	}
+	snap, err := readPermissions(ctx, logins.db)
+	if err != nil {
+		return nil, fmt.Errorf("permissions unavailable: %w", err)
+	}
+	return &Permissions{db: logins.db, admins: admins, snap: snap}, nil
+}
+
+// Administrator reports whether login may do anything: whether it is one
+// of the administrators configured, or a member of sysadmin or db_owner.
+func (p *Permissions) Administrator(login string) bool {
+	if p.admins[strings.ToLower(login)] {
+		return true
+	}
+	for _, principal := range p.current().principals(login) {
+		if principal == Sysadmin || principal == DBOwner {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether login may take permission on object, a
+// schema-qualified name; names without a schema are in dbo. Only
+// administrators may take one on a table named with ReservedPrefix.
+func (p *Permissions) Allowed(login, permission, object string) bool {
+	if p.Administrator(login) {
+		return true
+	}
+	object = objectName(object)
+	schema, name, _ := strings.Cut(object, ".")
+	if strings.HasPrefix(name, ReservedPrefix) {
+		return false
+	}
+	snap := p.current()
+	securables := []string{ScopeObject + "::" + object, ScopeSchema + "::" + schema, ScopeDatabase}
+	permission = strings.ToUpper(permission)
+
+	granted := false
+	for _, principal := range snap.principals(login) {
+		states := []string{fixedPermissions[principal][permission]}
+		for _, securable := range securables {
+			states = append(states, snap.granted[permissionKey{securable, permission, principal}])
+		}
+		for _, state := range states {
+			switch state {
+			case Deny:
+				return false
+			case Grant:
+				granted = true
+			}
+		}
+	}
+	return granted
+}
+
+// principals returns login and the roles it is a member of, directly or
+// through other roles, public among them.
+func (s *permissionSnapshot) principals(login string) []string {
+	principals := []string{strings.ToLower(login), Public}
+	seen := map[string]bool{principals[0]: true, Public: true}
+	for j := 0; j < len(principals); j++ {
+		for _, role := range s.roles[principals[j]] {
+			if !seen[role] {
+				seen[role] = true
+				principals = append(principals, role)
+			}
+		}
+	}
+	return principals
+}
+
+// current returns the permissions as last read, reading them again in
+// the background if they are older than permissionsTTL.
+func (p *Permissions) current() *permissionSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.snap.read) >= permissionsTTL && !p.refreshing {
+		p.refreshing = true
+		go func() {
+			snap, err := readPermissions(context.Background(), p.db)
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.refreshing = false
+			if err == nil && snap.read.After(p.snap.read) {
+				p.snap = snap
+			}
+		}()
+	}
+	return p.snap
+}
+
+// reload reads the permissions again through db, after a change made in
+// it.
+func (p *Permissions) reload(ctx context.Context, db Executor) error {
+	snap, err := readPermissions(ctx, db)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.snap = snap
+	p.mu.Unlock()
+	return nil
+}
+
+// readPermissions reads the permissions and role memberships.
+func readPermissions(ctx context.Context, db Executor) (*permissionSnapshot, error) {
+	snap := &permissionSnapshot{
+		read:    time.Now(),
+		roles:   make(map[string][]string),
+		granted: make(map[permissionKey]string),
+	}
+	rows, err := db.QueryContext(ctx, "SELECT role, member FROM "+RoleMemberTable)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var role, member string
+		if err := rows.Scan(&role, &member); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snap.roles[member] = append(snap.roles[member], role)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, "SELECT securable, permission, principal, state FROM "+PermissionTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key permissionKey
+		var state string
+		if err := rows.Scan(&key.securable, &key.permission, &key.principal, &state); err != nil {
+			return nil, err
+		}
+		snap.granted[key] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Apply grants, denies or revokes the permissions change names, through
+// db. A GRANT replaces a DENY of the same permission to the same
+// principal, and the other way round.
+func (p *Permissions) Apply(ctx context.Context, db Executor, change Change) error {
+	var securable string
+	switch change.Scope {
+	case ScopeObject:
+		securable = ScopeObject + "::" + objectName(change.Name)
+	case ScopeSchema:
+		securable = ScopeSchema + "::" + strings.ToLower(unquote(change.Name))
+	case ScopeDatabase:
+		securable = ScopeDatabase
+	default:
+		return fmt.Errorf("permissions cannot be granted on a %s", change.Scope)
+	}
+	if change.State != Grant && change.State != Deny && change.State != Revoke {
+		return fmt.Errorf("%q is not GRANT, DENY or REVOKE", change.State)
+	}
+	for _, permission := range change.Permissions {
+		if !objectPermissions[strings.ToUpper(permission)] {
+			return fmt.Errorf("the %s permission is not supported", permission)
+		}
+	}
+	principals := make([]string, len(change.Principals))
+	for j, name := range change.Principals {
+		principals[j] = strings.ToLower(unquote(name))
+		if err := principalExists(ctx, db, principals[j]); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	for _, principal := range principals {
+		for _, permission := range change.Permissions {
+			permission = strings.ToUpper(permission)
+			if _, err := db.ExecContext(ctx, "DELETE FROM "+PermissionTable+
+				" WHERE securable = ? AND permission = ? AND principal = ?", securable, permission, principal); err != nil {
+				return err
+			}
+			if change.State == Revoke {
+				continue
+			}
+			if _, err := db.ExecContext(ctx, "INSERT INTO "+PermissionTable+
+				" (securable, permission, principal, state, modified_at) VALUES (?, ?, ?, ?, ?)",
+				securable, permission, principal, change.State, now); err != nil {
+				return err
+			}
+		}
+	}
+	return p.reload(ctx, db)
+}
+
+// CreateRole creates the role name, through db.
+func (p *Permissions) CreateRole(ctx context.Context, db Executor, name string) error {
+	name = strings.ToLower(unquote(name))
+	if name == Public || name == Sysadmin || fixedRole(name) {
+		return &PrincipalError{Name: name, Err: ErrPrincipalExists}
+	}
+	if err := principalExists(ctx, db, name); err == nil {
+		return &PrincipalError{Name: name, Err: ErrPrincipalExists}
+	} else if !errors.Is(err, ErrUnknownPrincipal) {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO "+RoleTable+" (name, created_at) VALUES (?, ?)",
+		name, time.Now().UnixMilli())
+	return err
+}
+
+// DropRole drops the role name, which must have no members, with its
+// permissions, through db.
+func (p *Permissions) DropRole(ctx context.Context, db Executor, name string) error {
+	name = strings.ToLower(unquote(name))
+	if fixedRole(name) {
+		return &PrincipalError{Name: name, Err: ErrFixedRole}
+	}
+	if err := roleExists(ctx, db, name); err != nil {
+		return err
+	}
+	var members int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+RoleMemberTable+" WHERE role = ?", name).Scan(&members); err != nil {
+		return err
+	}
+	if members > 0 {
+		return &PrincipalError{Name: name, Err: ErrRoleHasMembers}
+	}
+	for _, stmt := range []string{
+		"DELETE FROM " + RoleMemberTable + " WHERE member = ?",
+		"DELETE FROM " + PermissionTable + " WHERE principal = ?",
+		"DELETE FROM " + RoleTable + " WHERE name = ?",
+	} {
+		if _, err := db.ExecContext(ctx, stmt, name); err != nil {
+			return err
+		}
+	}
+	return p.reload(ctx, db)
+}
+
+// AddRoleMember makes member, a login or a role other than a fixed one,
+// a member of role, through db.
+func (p *Permissions) AddRoleMember(ctx context.Context, db Executor, role, member string) error {
+	role, member = strings.ToLower(unquote(role)), strings.ToLower(unquote(member))
+	if err := roleExists(ctx, db, role); err != nil {
+		return err
+	}
+	if member == Public || member == role {
+		return &PrincipalError{Name: member, Err: ErrUnknownPrincipal}
+	}
+	if err := principalExists(ctx, db, member); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM "+RoleMemberTable+" WHERE role = ? AND member = ?", role, member); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO "+RoleMemberTable+" (role, member) VALUES (?, ?)", role, member); err != nil {
+		return err
+	}
+	return p.reload(ctx, db)
+}
+
+// DropRoleMember removes member from role, through db.
+func (p *Permissions) DropRoleMember(ctx context.Context, db Executor, role, member string) error {
+	role, member = strings.ToLower(unquote(role)), strings.ToLower(unquote(member))
+	if err := roleExists(ctx, db, role); err != nil {
+		return err
+	}
+	return p.dropMember(ctx, db, role, member)
+}
+
+// AddServerRoleMember makes member, a login, a member of role, the server
+// role sysadmin, through db.
+func (p *Permissions) AddServerRoleMember(ctx context.Context, db Executor, role, member string) error {
+	role, member = strings.ToLower(unquote(role)), strings.ToLower(unquote(member))
+	if role != Sysadmin {
+		return &PrincipalError{Name: role, Err: ErrUnknownPrincipal}
+	}
+	var found int
+	err := db.QueryRowContext(ctx, "SELECT 1 FROM "+LoginTable+" WHERE LOWER(name) = ?", member).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &PrincipalError{Name: member, Err: ErrUnknownPrincipal}
+	} else if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM "+RoleMemberTable+" WHERE role = ? AND member = ?", role, member); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO "+RoleMemberTable+" (role, member) VALUES (?, ?)", role, member); err != nil {
+		return err
+	}
+	return p.reload(ctx, db)
+}
+
+// DropServerRoleMember removes member from role, the server role
+// sysadmin, through db.
+func (p *Permissions) DropServerRoleMember(ctx context.Context, db Executor, role, member string) error {
+	role, member = strings.ToLower(unquote(role)), strings.ToLower(unquote(member))
+	if role != Sysadmin {
+		return &PrincipalError{Name: role, Err: ErrUnknownPrincipal}
+	}
+	return p.dropMember(ctx, db, role, member)
+}
+
+// dropMember removes member from role, through db.
+func (p *Permissions) dropMember(ctx context.Context, db Executor, role, member string) error {
+	if _, err := db.ExecContext(ctx, "DELETE FROM "+RoleMemberTable+" WHERE role = ? AND member = ?", role, member); err != nil {
+		return err
+	}
+	return p.reload(ctx, db)
+}
+
+// roleExists returns a PrincipalError unless name, lower-cased, is a
+// database role other than public.
+func roleExists(ctx context.Context, q Executor, name string) error {
+	if fixedRole(name) {
+		return nil
+	}
+	var found int
+	err := q.QueryRowContext(ctx, "SELECT 1 FROM "+RoleTable+" WHERE name = ?", name).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &PrincipalError{Name: name, Err: ErrUnknownPrincipal}
+	}
+	return err
+}
+
+// principalExists returns a PrincipalError unless name, lower-cased, is
+// a login or a role.
+func principalExists(ctx context.Context, q Executor, name string) error {
+	if name == Public {
+		return nil
+	}
+	var found int
+	err := q.QueryRowContext(ctx, "SELECT 1 FROM "+RoleTable+" WHERE name = ? UNION ALL "+
+		"SELECT 1 FROM "+LoginTable+" WHERE LOWER(name) = ?", name, name).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &PrincipalError{Name: name, Err: ErrUnknownPrincipal}
+	}
+	return err
+}
+
+// objectName returns the lower-cased schema.name of an object, in dbo
+// if name has no schema. Database and server names are dropped.
+func objectName(name string) string {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		parts = parts[len(parts)-2:]
+	}
+	for j, part := range parts {
+		parts[j] = strings.ToLower(unquote(part))
+	}
+	if len(parts) == 1 || parts[0] == "" {
+		return "dbo." + parts[len(parts)-1]
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// unquote strips the brackets or quotes from a name.
+func unquote(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) >= 2 && (name[0] == '[' && name[len(name)-1] == ']' || name[0] == '"' && name[len(name)-1] == '"') {
+		return name[1 : len(name)-1]
+	}
+	return name
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// testPermissions returns Permissions over a fresh database with the
+// logins app and reports, and the administrator sa.
+func testPermissions(t *testing.T) (*Permissions, *sql.DB) {
+	t.Helper()
+	store, db := testStore(t)
+	ctx := context.Background()
+	if err := store.Provision(ctx, []Login{
+		{Name: "app", Password: "s3cret"},
+		{Name: "reports", Password: "s3cret"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPermissions(ctx, store, []string{"SA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, db
+}
+
+func TestAllowed(t *testing.T) {
+	p, db := testPermissions(t)
+	ctx := context.Background()
+	apply := func(state, scope, name, permission string, principals ...string) {
+		t.Helper()
+		if err := p.Apply(ctx, db, Change{State: state, Permissions: []string{permission},
+			Scope: scope, Name: name, Principals: principals}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(login, permission, object string, want bool) {
+		t.Helper()
+		if ok := p.Allowed(login, permission, object); ok != want {
+			t.Errorf("%s %s on %s = %v, want %v", login, permission, object, ok, want)
+		}
+	}
+
+	// Nothing is allowed until granted, except to administrators
+	check("app", "SELECT", "dbo.Orders", false)
+	check("sa", "DELETE", "dbo.Orders", true)
+
+	apply(Grant, ScopeObject, "[dbo].[Orders]", "select", "App")
+	check("app", "SELECT", "Orders", true)
+	check("APP", "select", "mydb.dbo.orders", true)
+	check("app", "INSERT", "dbo.Orders", false)
+	check("reports", "SELECT", "dbo.Orders", false)
+
+	// Schema and database grants cover their objects
+	apply(Grant, ScopeSchema, "sales", "EXECUTE", "reports")
+	check("reports", "EXECUTE", "sales.usp_Totals", true)
+	check("reports", "EXECUTE", "dbo.usp_Totals", false)
+	apply(Grant, ScopeDatabase, "", "SELECT", Public)
+	check("reports", "SELECT", "dbo.Customers", true)
+
+	// A DENY at any level wins over a GRANT
+	apply(Deny, ScopeObject, "dbo.Orders", "SELECT", "reports")
+	check("reports", "SELECT", "dbo.Orders", false)
+	check("reports", "SELECT", "dbo.Customers", true)
+	check("sa", "SELECT", "dbo.Orders", true)
+
+	// REVOKE removes a DENY as well as a GRANT
+	apply(Revoke, ScopeObject, "dbo.Orders", "SELECT", "reports")
+	check("reports", "SELECT", "dbo.Orders", true)
+	apply(Revoke, ScopeDatabase, "", "SELECT", Public)
+	check("reports", "SELECT", "dbo.Orders", false)
+
+	for _, change := range []Change{
+		{State: Grant, Permissions: []string{"ALTER"}, Scope: ScopeObject, Name: "dbo.Orders", Principals: []string{"app"}},
+		{State: Grant, Permissions: []string{"SELECT"}, Scope: "SERVER", Principals: []string{"app"}},
+		{State: "WITHHOLD", Permissions: []string{"SELECT"}, Scope: ScopeDatabase, Principals: []string{"app"}},
+	} {
+		if err := p.Apply(ctx, db, change); err == nil {
+			t.Errorf("%+v: want an error", change)
+		}
+	}
+	err := p.Apply(ctx, db, Change{State: Grant, Permissions: []string{"SELECT"}, Scope: ScopeDatabase, Principals: []string{"nobody"}})
+	var pe *PrincipalError
+	if !errors.As(err, &pe) || pe.Name != "nobody" || !errors.Is(err, ErrUnknownPrincipal) {
+		t.Errorf("unknown principal: %v", err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	p, db := testPermissions(t)
+	ctx := context.Background()
+	for _, step := range []func() error{
+		func() error { return p.CreateRole(ctx, db, "readers") },
+		func() error { return p.CreateRole(ctx, db, "[Analysts]") },
+		func() error { return p.AddRoleMember(ctx, db, "readers", "analysts") },
+		func() error { return p.AddRoleMember(ctx, db, "analysts", "reports") },
+		func() error {
+			return p.Apply(ctx, db, Change{State: Grant, Permissions: []string{"SELECT"},
+				Scope: ScopeSchema, Name: "dbo", Principals: []string{"readers"}})
+		},
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Membership is followed through roles
+	if !p.Allowed("reports", "SELECT", "dbo.Orders") {
+		t.Error("member of a member not allowed")
+	}
+	if p.Allowed("app", "SELECT", "dbo.Orders") {
+		t.Error("non-member allowed")
+	}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"create existing role", p.CreateRole(ctx, db, "READERS"), ErrPrincipalExists},
+		{"create role named as a login", p.CreateRole(ctx, db, "app"), ErrPrincipalExists},
+		{"add to missing role", p.AddRoleMember(ctx, db, "writers", "app"), ErrUnknownPrincipal},
+		{"add missing member", p.AddRoleMember(ctx, db, "readers", "nobody"), ErrUnknownPrincipal},
+		{"add public", p.AddRoleMember(ctx, db, "readers", Public), ErrUnknownPrincipal},
+		{"drop role with members", p.DropRole(ctx, db, "readers"), ErrRoleHasMembers},
+		{"drop missing role", p.DropRole(ctx, db, "writers"), ErrUnknownPrincipal},
+	} {
+		if !errors.Is(tc.err, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, tc.err, tc.want)
+		}
+	}
+
+	if err := p.DropRoleMember(ctx, db, "readers", "analysts"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Allowed("reports", "SELECT", "dbo.Orders") {
+		t.Error("allowed after leaving the role")
+	}
+	if err := p.DropRole(ctx, db, "readers"); err != nil {
+		t.Fatal(err)
+	}
+	// The role's permissions go with it
+	if err := p.CreateRole(ctx, db, "readers"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddRoleMember(ctx, db, "readers", "app"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Allowed("app", "SELECT", "dbo.Orders") {
+		t.Error("permissions of a dropped role kept")
+	}
+}
+
+func TestFixedRoles(t *testing.T) {
+	p, db := testPermissions(t)
+	ctx := context.Background()
+	for _, step := range []func() error{
+		func() error { return p.CreateRole(ctx, db, "analysts") },
+		func() error { return p.AddRoleMember(ctx, db, DBDataReader, "analysts") },
+		func() error { return p.AddRoleMember(ctx, db, "analysts", "reports") },
+		func() error { return p.AddRoleMember(ctx, db, "[db_datawriter]", "app") },
+		func() error { return p.AddRoleMember(ctx, db, DBDataReader, "app") },
+		func() error {
+			return p.Apply(ctx, db, Change{State: Deny, Permissions: []string{"SELECT"},
+				Scope: ScopeObject, Name: "dbo.Salaries", Principals: []string{"app"}})
+		},
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		login, permission, object string
+		want                      bool
+	}{
+		{"reports", "SELECT", "sales.Orders", true},
+		{"reports", "INSERT", "dbo.Orders", false},
+		{"reports", "EXECUTE", "dbo.usp_Totals", false},
+		{"app", "DELETE", "dbo.Orders", true},
+		{"app", "SELECT", "dbo.Orders", true},
+		{"app", "SELECT", "dbo.Salaries", false},       // DENY wins
+		{"reports", "SELECT", "dbo.aul_logins", false}, // Not a user object
+		{"app", "SELECT", "aul_permissions", false},
+		{"app", "INSERT", "dbo.[AUL_ROLE_MEMBERS]", false},
+		{"app", "UPDATE", "master.dbo.aul_roles", false},
+	} {
+		if ok := p.Allowed(tc.login, tc.permission, tc.object); ok != tc.want {
+			t.Errorf("%s %s on %s = %v, want %v", tc.login, tc.permission, tc.object, ok, tc.want)
+		}
+	}
+
+	// The deny roles win over the others
+	if err := p.AddRoleMember(ctx, db, DBDenyDataWriter, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Allowed("app", "UPDATE", "dbo.Orders") {
+		t.Error("member of db_denydatawriter allowed to write")
+	}
+
+	// Members of sysadmin and db_owner are administrators
+	if p.Administrator("reports") {
+		t.Error("reports is an administrator")
+	}
+	if err := p.AddServerRoleMember(ctx, db, "sysadmin", "Reports"); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Administrator("reports") {
+		t.Error("member of sysadmin not an administrator")
+	}
+	if err := p.DropServerRoleMember(ctx, db, Sysadmin, "reports"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddRoleMember(ctx, db, DBOwner, "analysts"); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Administrator("reports") {
+		t.Error("member of db_owner through a role not an administrator")
+	}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"create fixed role", p.CreateRole(ctx, db, "DB_OWNER"), ErrPrincipalExists},
+		{"create sysadmin", p.CreateRole(ctx, db, Sysadmin), ErrPrincipalExists},
+		{"drop fixed role", p.DropRole(ctx, db, DBDataReader), ErrFixedRole},
+		{"add fixed role as a member", p.AddRoleMember(ctx, db, "analysts", DBDataWriter), ErrUnknownPrincipal},
+		{"add to sysadmin as a database role", p.AddRoleMember(ctx, db, Sysadmin, "app"), ErrUnknownPrincipal},
+		{"add role to sysadmin", p.AddServerRoleMember(ctx, db, Sysadmin, "analysts"), ErrUnknownPrincipal},
+		{"add to unknown server role", p.AddServerRoleMember(ctx, db, "serveradmin", "app"), ErrUnknownPrincipal},
+	} {
+		if !errors.Is(tc.err, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, tc.err, tc.want)
+		}
+	}
+}
+
+// TestInternalTables checks the tables logins and permissions are kept in
+// are listed as internal, so that the catalog views leave them out.
+func TestInternalTables(t *testing.T) {
+	for _, table := range []string{LoginTable, RoleTable, RoleMemberTable, PermissionTable} {
+		if !tsqlruntime.IsInternalTable(table) {
+			t.Errorf("%s is not in tsqlruntime.InternalTables", table)
+		}
+		if !strings.HasPrefix(table, ReservedPrefix) {
+			t.Errorf("%s is not named with ReservedPrefix", table)
+		}
+	}
+	if LoginTable != tsqlruntime.CredentialTable {
+		t.Errorf("LoginTable %q, tsqlruntime.CredentialTable %q", LoginTable, tsqlruntime.CredentialTable)
+	}
+	if ReservedPrefix != tsqlruntime.ReservedPrefix {
+		t.Errorf("ReservedPrefix %q, tsqlruntime's %q", ReservedPrefix, tsqlruntime.ReservedPrefix)
+	}
+}
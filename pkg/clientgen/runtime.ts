@@ -0,0 +1,223 @@
+export interface ClientOptions {
+  /** Base URL of the server's HTTP API (default: http://localhost:8080). */
+  url?: string;
+  /** Bearer token, for servers run with --http-token-file. */
+  token?: string;
+  /** fetch implementation; defaults to the global one (Node 18+, browsers). */
+  fetch?: typeof fetch;
+  /** Headers sent with every request. */
+  headers?: Record<string, string>;
+}
+
+export interface CallOptions {
+  /** Execution time limit on the server, in seconds. */
+  timeoutSeconds?: number;
+  signal?: AbortSignal;
+}
+
+/** A row of a result set, keyed by column name. */
+export type Row = Record<string, unknown>;
+
+/** The outcome of a call: its result sets, in order, and OUTPUT parameters. */
+export interface Result<Sets extends unknown[] = Row[][], Output = Record<string, unknown>> {
+  resultSets: Sets;
+  outputParams: Output;
+  rowsAffected: number;
+  message: string;
+  warnings: string[];
+  requestId?: string;
+}
+
+/** One row of a streamed result, with the index of its result set. */
+export interface StreamedRow<T = Row> {
+  resultSet: number;
+  columns: string[];
+  row: T;
+}
+
+/** An error reported by the server. */
+export class AulError extends Error {
+  readonly status: number;
+  readonly requestId?: string;
+  /** Seconds to wait before retrying, when the server was busy. */
+  readonly retryAfter?: number;
+
+  constructor(message: string, status: number, requestId?: string, retryAfter?: number) {
+    super(requestId ? `${message} (request ID ${requestId})` : message);
+    this.name = "AulError";
+    this.status = status;
+    this.requestId = requestId;
+    this.retryAfter = retryAfter;
+  }
+}
+
+interface ResponseBody {
+  success: boolean;
+  error?: string;
+  message?: string;
+  rows_affected?: number;
+  results?: { columns: string[]; rows: unknown[][] }[];
+  output_params?: Record<string, unknown>;
+  warnings?: string[];
+  request_id?: string;
+}
+
+/** A client for an aul server's HTTP API. */
+export class AulClient {
+  readonly url: string;
+  private readonly token?: string;
+  private readonly fetchFn: typeof fetch;
+  private readonly headers: Record<string, string>;
+
+  constructor(options: ClientOptions = {}) {
+    this.url = (options.url ?? "http://localhost:8080").replace(/\/+$/, "");
+    this.token = options.token;
+    this.fetchFn = options.fetch ?? fetch;
+    this.headers = options.headers ?? {};
+  }
+
+  /** Calls a procedure. Parameter names may be given without their @. */
+  async call<R extends Result<unknown[], unknown> = Result>(
+    procedure: string,
+    params: object = {},
+    options: CallOptions = {},
+  ): Promise<R> {
+    return this.execute<R>({ procedure, parameters: withAt(params) }, options);
+  }
+
+  /** Runs a batch of SQL. */
+  async query(sql: string, params: object = {}, options: CallOptions = {}): Promise<Result> {
+    return this.execute<Result>({ sql, parameters: withAt(params) }, options);
+  }
+
+  /**
+   * Calls a procedure, yielding its rows as the server writes them. The
+   * generator's return value holds the OUTPUT parameters.
+   */
+  async *stream<T = Row>(
+    procedure: string,
+    params: object = {},
+    options: CallOptions = {},
+  ): AsyncGenerator<StreamedRow<T>, Result<[]>> {
+    const response = await this.post(
+      { procedure, parameters: withAt(params) },
+      options,
+      "application/x-ndjson",
+    );
+    const requestId = response.headers.get("X-Request-ID") ?? undefined;
+    if (!response.body) {
+      throw new AulError("empty response", response.status, requestId);
+    }
+    const reader = response.body.getReader();
+    const decoder = new TextDecoder();
+    let buffered = "";
+    let resultSet = -1;
+    let columns: string[] = [];
+    for (;;) {
+      const { done, value } = await reader.read();
+      buffered += done ? decoder.decode() : decoder.decode(value, { stream: true });
+      let nl: number;
+      while ((nl = buffered.indexOf("\n")) >= 0) {
+        const line = buffered.slice(0, nl).trim();
+        buffered = buffered.slice(nl + 1);
+        if (!line) {
+          continue;
+        }
+        const doc = JSON.parse(line);
+        if (Array.isArray(doc.row)) {
+          yield { resultSet, columns, row: toRow(columns, doc.row) as T };
+        } else if (Array.isArray(doc.columns)) {
+          resultSet++;
+          columns = doc.columns;
+        } else {
+          return toResult(doc as ResponseBody, requestId, response.status) as Result<[]>;
+        }
+      }
+      if (done) {
+        throw new AulError("stream ended early", response.status, requestId);
+      }
+    }
+  }
+
+  private async execute<R>(body: object, options: CallOptions): Promise<R> {
+    const response = await this.post(body, options, "application/json");
+    const requestId = response.headers.get("X-Request-ID") ?? undefined;
+    return toResult((await response.json()) as ResponseBody, requestId, response.status) as unknown as R;
+  }
+
+  private async post(body: object, options: CallOptions, accept: string): Promise<Response> {
+    const headers: Record<string, string> = {
+      ...this.headers,
+      "Content-Type": "application/json",
+      Accept: accept,
+    };
+    if (this.token) {
+      headers.Authorization = `Bearer ${this.token}`;
+    }
+    const payload: Record<string, unknown> = { ...body };
+    if (options.timeoutSeconds !== undefined) {
+      payload.timeout = `${options.timeoutSeconds}s`;
+    }
+    const response = await this.fetchFn(`${this.url}/exec`, {
+      method: "POST",
+      headers,
+      body: JSON.stringify(payload),
+      signal: options.signal,
+    });
+    if (!response.ok) {
+      throw await httpError(response);
+    }
+    return response;
+  }
+}
+
+function withAt(params: object): Record<string, unknown> {
+  const out: Record<string, unknown> = {};
+  for (const [name, value] of Object.entries(params)) {
+    if (value !== undefined) {
+      out[name.startsWith("@") ? name : `@${name}`] = value;
+    }
+  }
+  return out;
+}
+
+function toRow(columns: string[], values: unknown[]): Row {
+  const row: Row = {};
+  columns.forEach((name, i) => {
+    row[name] = values[i];
+  });
+  return row;
+}
+
+function toResult(body: ResponseBody, requestId: string | undefined, status: number): Result {
+  if (!body.success) {
+    throw new AulError(body.error ?? "request failed", status, body.request_id ?? requestId);
+  }
+  return {
+    resultSets: (body.results ?? []).map((rs) => rs.rows.map((r) => toRow(rs.columns, r))),
+    outputParams: body.output_params ?? {},
+    rowsAffected: body.rows_affected ?? 0,
+    message: body.message ?? "",
+    warnings: body.warnings ?? [],
+    requestId,
+  };
+}
+
+async function httpError(response: Response): Promise<AulError> {
+  const requestId = response.headers.get("X-Request-ID") ?? undefined;
+  const retry = Number(response.headers.get("Retry-After"));
+  const text = (await response.text()).trim();
+  let message = text || `HTTP ${response.status}`;
+  let id = requestId;
+  const last = text.split("\n").pop() ?? "";
+  try {
+    const doc = JSON.parse(last) as ResponseBody;
+    if (doc.error) {
+      message = doc.error;
+      id = doc.request_id ?? requestId;
+    }
+  } catch {
+    // Not JSON: keep the text
+  }
+  return new AulError(message, response.status, id, Number.isFinite(retry) && retry > 0 ? retry : undefined);
+}
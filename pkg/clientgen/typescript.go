@@ -0,0 +1,280 @@
+// Package clientgen generates client code for the procedures a server
+// serves, so that callers get typed functions instead of building
+// requests by hand.
+package clientgen
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+// tsRuntime is the part of a generated TypeScript module that does not
+// depend on the procedures: the HTTP client the stubs call.
+//
+//go:embed runtime.ts
+var tsRuntime string
+
+// TypeScript writes a TypeScript module holding a client for the HTTP API
+// and, for each procedure, interfaces for its parameters, result rows and
+// OUTPUT parameters and a function calling it. Result row types come from
+// each procedure's ResultSets, which the caller fills in (see
+// procedure.InferResultSets); without them rows are untyped.
+//
+// Functions are named after the procedure in lower camel case, qualified
+// by schema and then database when two procedures share a name.
+func TypeScript(w io.Writer, procs []*procedure.Procedure) error {
+	procs = append([]*procedure.Procedure(nil), procs...)
+	sort.Slice(procs, func(i, j int) bool {
+		return procs[i].QualifiedName() < procs[j].QualifiedName()
+	})
+	names := functionNames(procs)
+
+	var b strings.Builder
+	b.WriteString("// Code generated by aul gen ts. DO NOT EDIT.\n\n")
+	b.WriteString(tsRuntime)
+	for _, proc := range procs {
+		if proc.IsFunction {
+			continue
+		}
+		writeTSProcedure(&b, proc, names[proc])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// functionNames picks each procedure's function name: its own name, or
+// more of its qualified name when that is taken.
+func functionNames(procs []*procedure.Procedure) map[*procedure.Procedure]string {
+	qualified := func(p *procedure.Procedure, parts int) string {
+		all := []string{p.Database, p.Schema, p.Name}
+		var used []string
+		for _, s := range all[3-parts:] {
+			if s != "" {
+				used = append(used, s)
+			}
+		}
+		return lowerCamel(strings.Join(used, "_"))
+	}
+
+	names := make(map[*procedure.Procedure]string, len(procs))
+	taken := make(map[string]bool)
+	for parts := 1; parts <= 3; parts++ {
+		count := make(map[string]int)
+		for _, p := range procs {
+			if _, done := names[p]; !done {
+				count[qualified(p, parts)]++
+			}
+		}
+		for _, p := range procs {
+			if _, done := names[p]; done {
+				continue
+			}
+			name := qualified(p, parts)
+			if tsReserved[name] {
+				name += "_"
+			}
+			if parts < 3 && (count[qualified(p, parts)] > 1 || taken[name]) {
+				continue
+			}
+			for n := 2; taken[name]; n++ {
+				name = fmt.Sprintf("%s%d", qualified(p, parts), n)
+			}
+			names[p] = name
+			taken[name] = true
+		}
+	}
+	return names
+}
+
+func writeTSProcedure(b *strings.Builder, proc *procedure.Procedure, fn string) {
+	typ := upperFirst(fn)
+
+	fmt.Fprintf(b, "\n// %s\n\n", proc.QualifiedName())
+
+	// Parameters: OUTPUT and defaulted ones may be left out
+	fmt.Fprintf(b, "export interface %sParams {", typ)
+	if len(proc.Parameters) > 0 {
+		b.WriteString("\n")
+	}
+	required := false
+	for _, p := range proc.Parameters {
+		optional := "?"
+		if p.Direction == procedure.ParamIn && !p.HasDefault {
+			optional = ""
+			required = true
+		}
+		fmt.Fprintf(b, "  %s%s: %s;\n", tsProperty(p.Name), optional, orNull(tsParamType(p.SQLType)))
+	}
+	b.WriteString("}\n")
+
+	var sets []string
+	optional := false
+	for i, rs := range proc.ResultSets {
+		row := fmt.Sprintf("%sRow%d", typ, i+1)
+		fmt.Fprintf(b, "\nexport interface %s {\n", row)
+		open := rs.Open
+		for _, c := range rs.Columns {
+			if c.Name == "" {
+				open = true // Named by the server, not by the query
+				continue
+			}
+			t := tsType(c.SQLType)
+			if c.Nullable {
+				t = orNull(t)
+			}
+			fmt.Fprintf(b, "  %s: %s;\n", tsProperty(c.Name), t)
+		}
+		if open || len(rs.Columns) == 0 {
+			b.WriteString("  [column: string]: unknown;\n")
+		}
+		b.WriteString("}\n")
+
+		// Once a result set may be missing, the later ones may not be where
+		// they are expected
+		optional = optional || rs.Conditional
+		if optional {
+			sets = append(sets, row+"[]?")
+		} else {
+			sets = append(sets, row+"[]")
+		}
+	}
+	setsType := "[" + strings.Join(sets, ", ") + "]"
+	if len(proc.ResultSets) == 0 {
+		setsType = "Row[][]"
+	}
+
+	fmt.Fprintf(b, "\nexport interface %sOutput {", typ)
+	sep := ""
+	for _, p := range proc.Parameters {
+		if p.Direction != procedure.ParamIn {
+			fmt.Fprintf(b, "\n  %s?: %s;", tsProperty("@"+p.Name), orNull(tsType(p.SQLType)))
+			sep = "\n"
+		}
+	}
+	b.WriteString(sep + "}\n")
+
+	fmt.Fprintf(b, "\nexport type %sResult = Result<%s, %sOutput>;\n", typ, setsType, typ)
+
+	params := "params: " + typ + "Params"
+	if !required {
+		params += " = {}"
+	}
+	fmt.Fprintf(b, "\n/** Calls %s. */\n", proc.QualifiedName())
+	fmt.Fprintf(b, "export function %s(client: AulClient, %s, options?: CallOptions): Promise<%sResult> {\n", fn, params, typ)
+	fmt.Fprintf(b, "  return client.call<%sResult>(%q, params, options);\n", typ, proc.QualifiedName())
+	b.WriteString("}\n")
+}
+
+// tsType returns the TypeScript type of values of a SQL type as the HTTP
+// API encodes them. Decimals come as strings when read from storage, to
+// keep their precision, and as numbers when computed.
+func tsType(sqlType string) string {
+	switch baseType(sqlType) {
+	case "bit":
+		return "boolean"
+	case "tinyint", "smallint", "int", "integer", "bigint", "float", "real":
+		return "number"
+	case "decimal", "numeric", "money", "smallmoney":
+		return "number | string"
+	case "char", "varchar", "nchar", "nvarchar", "text", "ntext", "xml", "sysname",
+		"uniqueidentifier", "date", "time", "datetime", "datetime2", "smalldatetime", "datetimeoffset",
+		"binary", "varbinary", "image":
+		return "string"
+	}
+	return "unknown"
+}
+
+// orNull adds null to a type, which unknown already includes.
+func orNull(t string) string {
+	if t == "unknown" {
+		return t
+	}
+	return t + " | null"
+}
+
+// tsParamType is tsType for a parameter, which also accepts what the
+// server converts: 0 and 1 for BIT, Dates for date and time types.
+func tsParamType(sqlType string) string {
+	switch baseType(sqlType) {
+	case "bit":
+		return "boolean | number"
+	case "date", "time", "datetime", "datetime2", "smalldatetime", "datetimeoffset":
+		return "string | Date"
+	}
+	return tsType(sqlType)
+}
+
+// baseType returns a SQL type's lower-case name without its length.
+func baseType(sqlType string) string {
+	if i := strings.IndexByte(sqlType, '('); i >= 0 {
+		sqlType = sqlType[:i]
+	}
+	return strings.ToLower(strings.Trim(strings.TrimSpace(sqlType), "[]"))
+}
+
+// tsProperty returns a property name, quoted unless it is an identifier.
+func tsProperty(name string) string {
+	for i, r := range name {
+		if !(r == '_' || r == '$' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return fmt.Sprintf("%q", name)
+		}
+	}
+	if name == "" {
+		return `""`
+	}
+	return name
+}
+
+// lowerCamel turns a SQL name such as usp_Get_Orders or GetOrders into
+// uspGetOrders or getOrders.
+func lowerCamel(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_' || r == ' ' || r == '-' || r == '.':
+			upper = b.Len() > 0
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+		case b.Len() == 0:
+			if unicode.IsDigit(r) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "procedure"
+	}
+	return b.String()
+}
+
+func upperFirst(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
+
+// tsReserved are the names a function cannot take: reserved words and
+// the runtime's own functions.
+var tsReserved = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true, "const": true, "continue": true,
+	"debugger": true, "default": true, "delete": true, "do": true, "else": true, "enum": true,
+	"export": true, "extends": true, "false": true, "finally": true, "for": true, "function": true,
+	"if": true, "import": true, "in": true, "instanceof": true, "new": true, "null": true,
+	"return": true, "super": true, "switch": true, "this": true, "throw": true, "true": true,
+	"try": true, "typeof": true, "var": true, "void": true, "while": true, "with": true,
+	"let": true, "static": true, "yield": true, "await": true,
+	"withAt": true, "toRow": true, "toResult": true, "httpError": true,
+}
@@ -0,0 +1,78 @@
+package clientgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+func TestTypeScript(t *testing.T) {
+	procs := []*procedure.Procedure{
+		{
+			Name: "GetOrders", Schema: "dbo",
+			Parameters: []procedure.Parameter{
+				{Name: "CustomerID", SQLType: "INT", Direction: procedure.ParamIn},
+				{Name: "Since", SQLType: "DATE", Direction: procedure.ParamIn, HasDefault: true},
+				{Name: "Count", SQLType: "INT", Direction: procedure.ParamOut},
+			},
+			ResultSets: []procedure.ResultSetDef{
+				{Columns: []procedure.ColumnDef{
+					{Name: "OrderID", SQLType: "int"},
+					{Name: "Total", SQLType: "decimal", Nullable: true},
+					{Name: "Placed At", SQLType: "datetime"},
+				}},
+				{Conditional: true, Columns: []procedure.ColumnDef{{Name: "Note", SQLType: "nvarchar"}, {SQLType: "int"}}},
+				{Columns: []procedure.ColumnDef{{Name: "Flag", SQLType: "bit"}}},
+			},
+		},
+		{Name: "GetOrders", Schema: "sales"},
+		{Name: "delete", Schema: "dbo"},
+		{Name: "fn_Total", Schema: "dbo", IsFunction: true},
+	}
+
+	var b strings.Builder
+	if err := TypeScript(&b, procs); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	for _, want := range []string{
+		"// Code generated by aul gen ts. DO NOT EDIT.",
+		"export class AulClient",
+		// Names shared by two procedures are qualified by schema
+		"export function dboGetOrders(client: AulClient, params: DboGetOrdersParams, options?: CallOptions): Promise<DboGetOrdersResult> {",
+		`return client.call<DboGetOrdersResult>("dbo.GetOrders", params, options);`,
+		"export function salesGetOrders(client: AulClient, params: SalesGetOrdersParams = {}, options?: CallOptions)",
+		"export function delete_(client: AulClient",
+		"  CustomerID: number | null;\n  Since?: string | Date | null;\n  Count?: number | null;\n",
+		"export interface DboGetOrdersRow1 {\n  OrderID: number;\n  Total: number | string | null;\n  \"Placed At\": string;\n}",
+		// Unnamed columns leave the row open
+		"export interface DboGetOrdersRow2 {\n  Note: string;\n  [column: string]: unknown;\n}",
+		"export interface DboGetOrdersOutput {\n  \"@Count\"?: number | null;\n}",
+		// After a conditional result set, the rest are optional
+		"export type DboGetOrdersResult = Result<[DboGetOrdersRow1[], DboGetOrdersRow2[]?, DboGetOrdersRow3[]?], DboGetOrdersOutput>;",
+		"export type SalesGetOrdersResult = Result<Row[][], SalesGetOrdersOutput>;",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q", want)
+		}
+	}
+	if strings.Contains(out, "fnTotal") {
+		t.Error("functions should not get stubs")
+	}
+}
+
+func TestLowerCamel(t *testing.T) {
+	for in, want := range map[string]string{
+		"GetOrders":      "getOrders",
+		"usp_Get_Orders": "uspGetOrders",
+		"salesdb_dbo_X":  "salesdbDboX",
+		"2Fast":          "_2Fast",
+		"!!":             "procedure",
+	} {
+		if got := lowerCamel(in); got != want {
+			t.Errorf("lowerCamel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
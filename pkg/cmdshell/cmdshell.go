@@ -0,0 +1,351 @@
+// Package cmdshell runs the operating system commands procedures call
+// with xp_cmdshell, for legacy procedures that shell out to move or copy
+// files.
+//
+// Nothing runs that a command template of the configuration does not
+// allow. A template is a command line such as
+//
+//	move {src} {dst}
+//
+// whose words a command must match: literally, without regard to case, or,
+// for a {parameter}, by the parameter's pattern. A word with spaces is
+// written in double quotes. The command runs as the template's program and
+// arguments, with the parameters' values substituted, and never through a
+// shell, so that no quoting, redirection or chaining in a value reaches
+// one. Every command, run or refused, is written to the audit log with the
+// user who sent it.
+package cmdshell
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/notify"
+)
+
+// Defaults
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultMaxOutput = 1 << 20 // Bytes of output kept
+)
+
+// Config lists the commands xp_cmdshell may run.
+type Config struct {
+	Commands  []CommandConfig `json:"commands"`
+	Timeout   notify.Duration `json:"timeout"`    // Per command, unless it gives its own (0 = DefaultTimeout)
+	MaxOutput int             `json:"max_output"` // Bytes of output kept; the rest is dropped (0 = DefaultMaxOutput)
+}
+
+// CommandConfig is a command template and the program it runs.
+type CommandConfig struct {
+	Name     string            `json:"name"`
+	Template string            `json:"template"`          // Command line matched, e.g. "move {src} {dst}"
+	Program  string            `json:"program"`           // Absolute path of the program run
+	Args     []string          `json:"args"`              // Its arguments; {param} is replaced by the parameter's value
+	Params   map[string]string `json:"params"`            // Regular expression each parameter's value must match in full
+	Dir      string            `json:"dir,omitempty"`     // Working directory (default: aul's)
+	Env      []string          `json:"env,omitempty"`     // Environment, NAME=value (default: none, so that aul's is not passed on)
+	Timeout  notify.Duration   `json:"timeout,omitempty"` // Overrides Config.Timeout
+	template []string          // Words of Template
+	patterns map[string]*regexp.Regexp
+}
+
+// LoadConfig reads a command configuration from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigMissing,
+			"failed to read xp_cmdshell commands").
+			WithOp("cmdshell.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigParse,
+			"failed to parse xp_cmdshell commands").
+			WithOp("cmdshell.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	return cfg, nil
+}
+
+// Result is what a command printed and returned.
+type Result struct {
+	Command  string   // Name of the template that allowed it
+	Output   []string // Lines of standard output and standard error
+	ExitCode int      // -1 when it did not start or timed out
+}
+
+// Shell runs the commands its templates allow.
+type Shell struct {
+	commands  []CommandConfig
+	timeout   time.Duration
+	maxOutput int
+	logger    *log.Logger
+}
+
+// New returns the shell of cfg.
+func New(cfg Config, logger *log.Logger) (*Shell, error) {
+	s := &Shell{
+		timeout:   time.Duration(cfg.Timeout),
+		maxOutput: cfg.MaxOutput,
+		logger:    logger,
+	}
+	if s.timeout <= 0 {
+		s.timeout = DefaultTimeout
+	}
+	if s.maxOutput <= 0 {
+		s.maxOutput = DefaultMaxOutput
+	}
+	for _, c := range cfg.Commands {
+		if err := compile(&c); err != nil {
+			return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid, "invalid xp_cmdshell commands").
+				WithOp("cmdshell.New").
+				Err()
+		}
+		s.commands = append(s.commands, c)
+	}
+	return s, nil
+}
+
+// placeholder matches a {parameter} of a template or argument.
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// compile checks c and compiles its template and patterns.
+func compile(c *CommandConfig) error {
+	if c.Name == "" {
+		return fmt.Errorf("a command has no name")
+	}
+	if !filepath.IsAbs(c.Program) {
+		return fmt.Errorf("command %s: program %q is not an absolute path", c.Name, c.Program)
+	}
+	words, ok := splitWords(c.Template)
+	if !ok || len(words) == 0 {
+		return fmt.Errorf("command %s: template %q is not a command line", c.Name, c.Template)
+	}
+	c.template = words
+	c.patterns = make(map[string]*regexp.Regexp)
+	for _, word := range words {
+		m := placeholder.FindStringSubmatch(word)
+		if m == nil {
+			if strings.ContainsAny(word, "{}") {
+				return fmt.Errorf("command %s: %q in the template is not a {parameter}", c.Name, word)
+			}
+			continue
+		}
+		if m[0] != word {
+			return fmt.Errorf("command %s: parameter %s is not a word of the template of its own", c.Name, m[0])
+		}
+		pattern, ok := c.Params[m[1]]
+		if !ok {
+			return fmt.Errorf("command %s: parameter %s has no pattern", c.Name, m[1])
+		}
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("command %s: pattern of %s: %w", c.Name, m[1], err)
+		}
+		c.patterns[m[1]] = re
+	}
+	for name := range c.Params {
+		if c.patterns[name] == nil {
+			return fmt.Errorf("command %s: parameter %s is not in the template", c.Name, name)
+		}
+	}
+	for _, arg := range c.Args {
+		for _, m := range placeholder.FindAllStringSubmatch(arg, -1) {
+			if c.patterns[m[1]] == nil {
+				return fmt.Errorf("command %s: argument %q names parameter %s, which is not in the template", c.Name, arg, m[1])
+			}
+		}
+	}
+	return nil
+}
+
+// Run runs command for user if a template allows it, and audits it. A
+// command no template allows fails with ErrCodeExecDenied; one that fails
+// to start or times out returns exit code -1 with the reason as output.
+func (s *Shell) Run(ctx context.Context, user, command string) (Result, error) {
+	audit := s.logger.Audit().WithContext(ctx)
+	c, args, ok := s.match(command)
+	if !ok {
+		audit.Warn("xp_cmdshell command denied", "user", user, "command", command)
+		return Result{}, aulerrors.Newf(aulerrors.ErrCodeExecDenied,
+			"No xp_cmdshell command template allows the command '%s'.", command).
+			WithOp("Shell.Run").
+			WithField("user", user).
+			Err()
+	}
+
+	timeout := s.timeout
+	if c.Timeout > 0 {
+		timeout = time.Duration(c.Timeout)
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, c.Program, args...)
+	cmd.Dir = c.Dir
+	cmd.Env = append([]string{}, c.Env...)
+	out := &limitedBuffer{max: s.maxOutput}
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	res := Result{Command: c.Name, Output: splitLines(out.String())}
+	var exitErr *exec.ExitError
+	cancelled := false
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		err, cancelled = ctx.Err(), true
+		res.ExitCode = -1
+	case runCtx.Err() != nil:
+		res.ExitCode = -1
+		res.Output = append(res.Output, fmt.Sprintf("The command timed out after %s.", timeout))
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	default:
+		res.ExitCode = -1
+		res.Output = append(res.Output, err.Error())
+	}
+
+	fields := []interface{}{
+		"user", user, "command", command, "template", c.Name,
+		"program", c.Program, "args", args, "exit_code", res.ExitCode,
+		"duration_ms", time.Since(start).Milliseconds(),
+	}
+	if res.ExitCode == -1 {
+		audit.Warn("xp_cmdshell command failed", append(fields, "error", err.Error())...)
+	} else {
+		audit.Info("xp_cmdshell command run", fields...)
+	}
+	if cancelled {
+		return res, err
+	}
+	return res, nil
+}
+
+// match returns the first template command matches and the arguments it
+// runs with.
+func (s *Shell) match(command string) (*CommandConfig, []string, bool) {
+	words, ok := splitWords(command)
+	if !ok {
+		return nil, nil, false
+	}
+	for j := range s.commands {
+		c := &s.commands[j]
+		if values, ok := c.bind(words); ok {
+			args := make([]string, len(c.Args))
+			for k, arg := range c.Args {
+				args[k] = placeholder.ReplaceAllStringFunc(arg, func(p string) string {
+					return values[p[1:len(p)-1]]
+				})
+			}
+			return c, args, true
+		}
+	}
+	return nil, nil, false
+}
+
+// bind returns the values of c's parameters in words, if words match its
+// template.
+func (c *CommandConfig) bind(words []string) (map[string]string, bool) {
+	if len(words) != len(c.template) {
+		return nil, false
+	}
+	values := make(map[string]string)
+	for j, word := range c.template {
+		if m := placeholder.FindStringSubmatch(word); m != nil {
+			if !c.patterns[m[1]].MatchString(words[j]) {
+				return nil, false
+			}
+			values[m[1]] = words[j]
+		} else if !strings.EqualFold(word, words[j]) {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// splitWords splits a command line into words at spaces and tabs, taking
+// a double-quoted word whole without its quotes. It fails on a quote
+// inside a word or one left open, and on a line break.
+func splitWords(line string) ([]string, bool) {
+	if strings.ContainsAny(line, "\r\n\x00") {
+		return nil, false
+	}
+	var words []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return words, true
+		}
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, false
+			}
+			word, rest := line[1:end+1], line[end+2:]
+			if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+				return nil, false
+			}
+			words, line = append(words, word), rest
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		word := line[:end]
+		if strings.ContainsRune(word, '"') {
+			return nil, false
+		}
+		words, line = append(words, word), line[end:]
+	}
+}
+
+// splitLines splits a program's output into lines.
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\r\n")
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	for j, line := range lines {
+		lines[j] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// limitedBuffer keeps the first max bytes written to it and drops the
+// rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
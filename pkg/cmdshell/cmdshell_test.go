@@ -0,0 +1,149 @@
+package cmdshell
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	pkglog "github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/notify"
+)
+
+func testLogger() *pkglog.Logger {
+	return pkglog.New(pkglog.Config{DefaultLevel: pkglog.LevelError})
+}
+
+func TestRun(t *testing.T) {
+	mv, err := exec.LookPath("mv")
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "done"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "orders 1.csv"), []byte("id\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{Commands: []CommandConfig{{
+		Name:     "move",
+		Template: "move {src} {dst}",
+		Program:  mv,
+		Args:     []string{"--", "{src}", "done/{dst}"},
+		Params:   map[string]string{"src": `[\w .-]+\.csv`, "dst": `[\w.-]+\.csv`},
+		Dir:      dir,
+	}}}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	res, err := s.Run(ctx, "etl", `MOVE "orders 1.csv" orders1.csv`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Command != "move" || res.ExitCode != 0 || len(res.Output) != 0 {
+		t.Errorf("result %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "done", "orders1.csv")); err != nil {
+		t.Errorf("not moved: %v", err)
+	}
+
+	// A failing command returns its exit code and output
+	res, err = s.Run(ctx, "etl", "move missing.csv missing.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode == 0 || len(res.Output) == 0 {
+		t.Errorf("result %+v", res)
+	}
+
+	// Commands no template allows
+	for _, command := range []string{
+		"del orders1.csv",
+		"move orders1.csv",
+		"move orders1.csv ../orders1.csv",
+		"move orders1.csv x.csv; rm -rf /",
+		`move "orders1.csv x.csv`,
+		"move orders1.csv x.csv\nrm x.csv",
+	} {
+		_, err := s.Run(ctx, "etl", command)
+		if aulerrors.GetCode(err) != aulerrors.ErrCodeExecDenied {
+			t.Errorf("%q: got %v, want denied", command, err)
+		}
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip(err)
+	}
+	s, err := New(Config{Commands: []CommandConfig{{
+		Name:     "sleep",
+		Template: "sleep {seconds}",
+		Program:  sleep,
+		Args:     []string{"{seconds}"},
+		Params:   map[string]string{"seconds": `\d+`},
+		Timeout:  notify.Duration(50 * time.Millisecond),
+	}}}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Run(context.Background(), "etl", "sleep 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != -1 || len(res.Output) != 1 || !strings.Contains(res.Output[0], "timed out after 50ms") {
+		t.Errorf("result %+v", res)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	valid := CommandConfig{Name: "copy", Template: "copy {src}", Program: "/bin/cp", Args: []string{"{src}", "/backup"},
+		Params: map[string]string{"src": `\w+`}}
+	for _, tc := range []struct {
+		edit func(c *CommandConfig)
+		want string
+	}{
+		{func(c *CommandConfig) { c.Name = "" }, "has no name"},
+		{func(c *CommandConfig) { c.Program = "cp" }, "not an absolute path"},
+		{func(c *CommandConfig) { c.Template = "" }, "is not a command line"},
+		{func(c *CommandConfig) { c.Template = "copy {src}.csv" }, "not a word of the template of its own"},
+		{func(c *CommandConfig) { c.Template = "copy {src} {dst}" }, "parameter dst has no pattern"},
+		{func(c *CommandConfig) { c.Params = map[string]string{"src": `\w+`, "dst": `\w+`} }, "parameter dst is not in the template"},
+		{func(c *CommandConfig) { c.Params = map[string]string{"src": `(`} }, "pattern of src"},
+		{func(c *CommandConfig) { c.Args = []string{"{dst}"} }, "names parameter dst"},
+	} {
+		c := valid
+		tc.edit(&c)
+		_, err := New(Config{Commands: []CommandConfig{c}}, testLogger())
+		if aulerrors.GetCode(err) != aulerrors.ErrCodeConfigInvalid || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: got %v, want %q", c, err, tc.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	for _, tc := range []struct {
+		line  string
+		words []string
+		ok    bool
+	}{
+		{`move  a.csv	"D:\My Files\"`, []string{"move", "a.csv", `D:\My Files\`}, true},
+		{`copy "" x`, []string{"copy", "", "x"}, true},
+		{`copy a"b x`, nil, false},
+		{`copy "a"b x`, nil, false},
+		{`copy "a x`, nil, false},
+	} {
+		words, ok := splitWords(tc.line)
+		if ok != tc.ok || strings.Join(words, "|") != strings.Join(tc.words, "|") {
+			t.Errorf("%q: got %q, %v", tc.line, words, ok)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalise(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, "NULL"},
+		{true, "1"},
+		{false, "0"},
+		{int64(42), "42"},
+		{float64(2.5), "2.5"},
+		{[]byte("2.50000"), "2.5"},
+		{"10.00", "10"},
+		{"1.2.3", "1.2.3"},
+		{"abc.", "abc."},
+		{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), "2024-02-29"},
+		{time.Date(2024, 2, 29, 13, 5, 0, 0, time.UTC), "2024-02-29 13:05:00"},
+	}
+	for _, tt := range tests {
+		if got := Normalise(tt.in); got != tt.want {
+			t.Errorf("Normalise(%#v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	rows := [][]string{{"1", "a"}}
+	tests := []struct {
+		name      string
+		want, got Outcome
+		match     bool
+	}{
+		{"equal rows", Outcome{Rows: rows}, Outcome{Rows: rows}, true},
+		{"different value", Outcome{Rows: rows}, Outcome{Rows: [][]string{{"1", "b"}}}, false},
+		{"different count", Outcome{Rows: rows}, Outcome{}, false},
+		{"both errors", Outcome{Err: "x"}, Outcome{Err: "y"}, true},
+		{"missing error", Outcome{Err: "x"}, Outcome{Rows: rows}, false},
+		{"unexpected error", Outcome{Rows: rows}, Outcome{Err: "x"}, false},
+	}
+	for _, tt := range tests {
+		detail := compare(tt.want, tt.got)
+		if (detail == "") != tt.match {
+			t.Errorf("%s: compare = %q, match want %v", tt.name, detail, tt.match)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	all := Filter(Corpus, nil)
+	if len(all) != len(Corpus) {
+		t.Fatalf("Filter(nil) returned %d cases, want %d", len(all), len(Corpus))
+	}
+	funcs := Filter(Corpus, []string{CategoryFunctions})
+	if len(funcs) == 0 {
+		t.Fatal("no function cases")
+	}
+	for _, c := range funcs {
+		if c.Category != CategoryFunctions {
+			t.Errorf("case %s has category %s", c.Name, c.Category)
+		}
+	}
+}
+
+func TestCorpusWellFormed(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, c := range Corpus {
+		key := c.Category + "/" + c.Name
+		if seen[key] {
+			t.Errorf("duplicate case %s", key)
+		}
+		seen[key] = true
+		if c.SQL == "" {
+			t.Errorf("%s has no SQL", key)
+		}
+		if !c.WantError && len(c.Want) == 0 {
+			t.Errorf("%s has no expectation", key)
+		}
+	}
+}
+
+func TestScorecard(t *testing.T) {
+	card := &Scorecard{Results: []Result{
+		{Name: "a", Category: CategoryTypes, Status: StatusPass},
+		{Name: "b", Category: CategoryTypes, Status: StatusFail, Detail: "row 1: mismatch"},
+		{Name: "c", Category: CategoryFunctions, Status: StatusPass},
+		{Name: "d", Category: CategoryFunctions, Status: StatusSkip},
+	}}
+
+	total := card.Total()
+	if total.Pass != 2 || total.Fail != 1 || total.Skip != 1 {
+		t.Errorf("Total = %+v", total)
+	}
+	if score := total.Score(); score < 66.6 || score > 66.7 {
+		t.Errorf("Score = %f, want 66.7", score)
+	}
+	if got := card.ByCategory()[CategoryFunctions].Score(); got != 100 {
+		t.Errorf("functions score = %f, want 100", got)
+	}
+
+	var text bytes.Buffer
+	card.WriteText(&text, false)
+	if !strings.Contains(text.String(), "[fail] types/b: row 1: mismatch") {
+		t.Errorf("text scorecard missing failure:\n%s", text.String())
+	}
+	if strings.Contains(text.String(), "types/a") {
+		t.Errorf("non-verbose scorecard lists passing case:\n%s", text.String())
+	}
+
+	var js bytes.Buffer
+	if err := card.WriteJSON(&js); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded struct {
+		Score      float64          `json:"score"`
+		Categories map[string]Tally `json:"categories"`
+	}
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("decoding JSON: %v", err)
+	}
+	if decoded.Categories[CategoryTypes].Fail != 1 {
+		t.Errorf("JSON categories = %+v", decoded.Categories)
+	}
+}
@@ -0,0 +1,324 @@
+// Package conformance runs a curated corpus of T-SQL cases against a server
+// and scores how closely its behaviour matches SQL Server.
+//
+// Each Case carries the result SQL Server produces. When a reference server
+// is available the runner executes the case there too and compares against
+// its live output instead, so the corpus can be extended without hand-writing
+// expected values.
+package conformance
+
+// Categories in the order they appear in a scorecard.
+const (
+	CategoryControlFlow   = "control-flow"
+	CategoryFunctions     = "functions"
+	CategoryTypes         = "types"
+	CategoryErrorHandling = "error-handling"
+)
+
+// Case is a single conformance check.
+type Case struct {
+	Name     string
+	Category string
+	SQL      string
+
+	// Want is the expected rows across all result sets, with values
+	// normalised as described by Normalise. Ignored when WantError is set.
+	Want [][]string
+
+	// WantError is true when SQL Server rejects the batch.
+	WantError bool
+}
+
+// row is shorthand for building expected results.
+func row(values ...string) []string {
+	return values
+}
+
+// Corpus is the built-in set of cases. Expected values were taken from
+// SQL Server 2019 with default session settings.
+var Corpus = []Case{
+	// ==================== Control flow ====================
+	{
+		Name:     "IF_ELSE",
+		Category: CategoryControlFlow,
+		SQL:      "DECLARE @x INT = 5; IF @x > 3 SELECT 'big' ELSE SELECT 'small'",
+		Want:     [][]string{row("big")},
+	},
+	{
+		Name:     "IF_nested_BEGIN_END",
+		Category: CategoryControlFlow,
+		SQL:      "DECLARE @r VARCHAR(10); IF 1 = 1 BEGIN IF 2 = 3 SET @r = 'x' ELSE SET @r = 'y' END; SELECT @r",
+		Want:     [][]string{row("y")},
+	},
+	{
+		Name:     "WHILE_loop",
+		Category: CategoryControlFlow,
+		SQL:      "DECLARE @i INT = 0, @s INT = 0; WHILE @i < 5 BEGIN SET @i = @i + 1; SET @s = @s + @i END; SELECT @s",
+		Want:     [][]string{row("15")},
+	},
+	{
+		Name:     "WHILE_BREAK",
+		Category: CategoryControlFlow,
+		SQL:      "DECLARE @i INT = 0; WHILE 1 = 1 BEGIN SET @i = @i + 1; IF @i >= 3 BREAK END; SELECT @i",
+		Want:     [][]string{row("3")},
+	},
+	{
+		Name:     "WHILE_CONTINUE",
+		Category: CategoryControlFlow,
+		SQL:      "DECLARE @i INT = 0, @s INT = 0; WHILE @i < 10 BEGIN SET @i = @i + 1; IF @i % 2 = 0 CONTINUE; SET @s = @s + @i END; SELECT @s",
+		Want:     [][]string{row("25")},
+	},
+	{
+		Name:     "CASE_searched",
+		Category: CategoryControlFlow,
+		SQL:      "SELECT CASE WHEN 1 > 2 THEN 'a' WHEN 2 > 1 THEN 'b' ELSE 'c' END",
+		Want:     [][]string{row("b")},
+	},
+	{
+		Name:     "CASE_simple",
+		Category: CategoryControlFlow,
+		SQL:      "SELECT CASE 2 WHEN 1 THEN 'one' WHEN 2 THEN 'two' END",
+		Want:     [][]string{row("two")},
+	},
+	{
+		Name:     "CASE_no_match_NULL",
+		Category: CategoryControlFlow,
+		SQL:      "SELECT CASE 3 WHEN 1 THEN 'one' END",
+		Want:     [][]string{row("NULL")},
+	},
+	{
+		Name:     "RETURN_ends_batch",
+		Category: CategoryControlFlow,
+		SQL:      "DECLARE @x INT = 1; IF @x = 1 BEGIN SELECT 'first'; RETURN; END; SELECT 'second'",
+		Want:     [][]string{row("first")},
+	},
+
+	// ==================== Functions ====================
+	{
+		Name:     "LEN_trailing_spaces",
+		Category: CategoryFunctions,
+		SQL:      "SELECT LEN('hello  ')",
+		Want:     [][]string{row("5")},
+	},
+	{
+		Name:     "UPPER_LOWER",
+		Category: CategoryFunctions,
+		SQL:      "SELECT UPPER('abc'), LOWER('XyZ')",
+		Want:     [][]string{row("ABC", "xyz")},
+	},
+	{
+		Name:     "SUBSTRING",
+		Category: CategoryFunctions,
+		SQL:      "SELECT SUBSTRING('abcdef', 2, 3)",
+		Want:     [][]string{row("bcd")},
+	},
+	{
+		Name:     "CHARINDEX",
+		Category: CategoryFunctions,
+		SQL:      "SELECT CHARINDEX('c', 'abcabc')",
+		Want:     [][]string{row("3")},
+	},
+	{
+		Name:     "REPLACE",
+		Category: CategoryFunctions,
+		SQL:      "SELECT REPLACE('aXbXc', 'X', '-')",
+		Want:     [][]string{row("a-b-c")},
+	},
+	{
+		Name:     "LTRIM_RTRIM",
+		Category: CategoryFunctions,
+		SQL:      "SELECT LTRIM('  a') + RTRIM('b  ') + '|'",
+		Want:     [][]string{row("ab|")},
+	},
+	{
+		Name:     "LEFT_RIGHT",
+		Category: CategoryFunctions,
+		SQL:      "SELECT LEFT('abcdef', 2), RIGHT('abcdef', 2)",
+		Want:     [][]string{row("ab", "ef")},
+	},
+	{
+		Name:     "REVERSE_REPLICATE",
+		Category: CategoryFunctions,
+		SQL:      "SELECT REVERSE('abc'), REPLICATE('ab', 3)",
+		Want:     [][]string{row("cba", "ababab")},
+	},
+	{
+		Name:     "ISNULL_COALESCE",
+		Category: CategoryFunctions,
+		SQL:      "SELECT ISNULL(NULL, 'x'), COALESCE(NULL, NULL, 3)",
+		Want:     [][]string{row("x", "3")},
+	},
+	{
+		Name:     "ABS_CEILING_FLOOR",
+		Category: CategoryFunctions,
+		SQL:      "SELECT ABS(-7), CEILING(1.2), FLOOR(1.8)",
+		Want:     [][]string{row("7", "2", "1")},
+	},
+	{
+		Name:     "ROUND",
+		Category: CategoryFunctions,
+		SQL:      "SELECT ROUND(123.456, 2)",
+		Want:     [][]string{row("123.46")},
+	},
+	{
+		Name:     "POWER",
+		Category: CategoryFunctions,
+		SQL:      "SELECT POWER(2, 10)",
+		Want:     [][]string{row("1024")},
+	},
+	{
+		Name:     "DATEDIFF_day",
+		Category: CategoryFunctions,
+		SQL:      "SELECT DATEDIFF(day, '2024-01-01', '2024-03-01')",
+		Want:     [][]string{row("60")},
+	},
+	{
+		Name:     "YEAR_MONTH_DAY",
+		Category: CategoryFunctions,
+		SQL:      "SELECT YEAR('2024-05-17'), MONTH('2024-05-17'), DAY('2024-05-17')",
+		Want:     [][]string{row("2024", "5", "17")},
+	},
+	{
+		Name:     "CAST_string_to_int",
+		Category: CategoryFunctions,
+		SQL:      "SELECT CAST('42' AS INT) + 1",
+		Want:     [][]string{row("43")},
+	},
+	{
+		Name:     "CONVERT_int_to_varchar",
+		Category: CategoryFunctions,
+		SQL:      "SELECT CONVERT(VARCHAR(10), 123) + 'x'",
+		Want:     [][]string{row("123x")},
+	},
+
+	// ==================== Types ====================
+	{
+		Name:     "INT_division",
+		Category: CategoryTypes,
+		SQL:      "SELECT 7 / 2, 7 % 2",
+		Want:     [][]string{row("3", "1")},
+	},
+	{
+		Name:     "DECIMAL_division",
+		Category: CategoryTypes,
+		SQL:      "SELECT CAST(10 AS DECIMAL(10,2)) / 4",
+		Want:     [][]string{row("2.5")},
+	},
+	{
+		Name:     "VARCHAR_concat",
+		Category: CategoryTypes,
+		SQL:      "SELECT 'a' + 'b'",
+		Want:     [][]string{row("ab")},
+	},
+	{
+		Name:     "NULL_concat",
+		Category: CategoryTypes,
+		SQL:      "SELECT 'a' + NULL",
+		Want:     [][]string{row("NULL")},
+	},
+	{
+		Name:     "BIT_variable",
+		Category: CategoryTypes,
+		SQL:      "DECLARE @b BIT = 1; SELECT @b",
+		Want:     [][]string{row("1")},
+	},
+	{
+		Name:     "implicit_string_to_int",
+		Category: CategoryTypes,
+		SQL:      "SELECT CASE WHEN '10' = 10 THEN 'eq' ELSE 'ne' END",
+		Want:     [][]string{row("eq")},
+	},
+	{
+		Name:     "NVARCHAR_length",
+		Category: CategoryTypes,
+		SQL:      "SELECT LEN(N'héllo')",
+		Want:     [][]string{row("5")},
+	},
+	{
+		Name:     "table_variable",
+		Category: CategoryTypes,
+		SQL:      "DECLARE @t TABLE (id INT, v VARCHAR(10)); INSERT INTO @t VALUES (1, 'a'); INSERT INTO @t VALUES (2, 'b'); SELECT COUNT(*) FROM @t",
+		Want:     [][]string{row("2")},
+	},
+	{
+		Name:     "temp_table",
+		Category: CategoryTypes,
+		SQL:      "CREATE TABLE #conf (id INT); INSERT INTO #conf VALUES (1); INSERT INTO #conf VALUES (2); SELECT SUM(id) FROM #conf; DROP TABLE #conf",
+		Want:     [][]string{row("3")},
+	},
+
+	// ==================== Error handling ====================
+	{
+		Name:     "TRY_CATCH_divide_by_zero",
+		Category: CategoryErrorHandling,
+		SQL:      "DECLARE @x INT; BEGIN TRY SET @x = 1 / 0; END TRY BEGIN CATCH SELECT ERROR_NUMBER() END CATCH",
+		Want:     [][]string{row("8134")},
+	},
+	{
+		Name:     "TRY_CATCH_RAISERROR",
+		Category: CategoryErrorHandling,
+		SQL:      "BEGIN TRY RAISERROR('boom', 16, 1) END TRY BEGIN CATCH SELECT ERROR_MESSAGE() END CATCH",
+		Want:     [][]string{row("boom")},
+	},
+	{
+		Name:     "TRY_CATCH_THROW",
+		Category: CategoryErrorHandling,
+		SQL:      "BEGIN TRY THROW 50001, 'custom', 1 END TRY BEGIN CATCH SELECT ERROR_NUMBER(), ERROR_MESSAGE() END CATCH",
+		Want:     [][]string{row("50001", "custom")},
+	},
+	{
+		Name:     "ERROR_SEVERITY",
+		Category: CategoryErrorHandling,
+		SQL:      "BEGIN TRY RAISERROR('x', 15, 1) END TRY BEGIN CATCH SELECT ERROR_SEVERITY() END CATCH",
+		Want:     [][]string{row("15")},
+	},
+	{
+		Name:     "nested_TRY_rethrow",
+		Category: CategoryErrorHandling,
+		SQL:      "BEGIN TRY BEGIN TRY THROW 50002, 'inner', 1 END TRY BEGIN CATCH THROW END CATCH END TRY BEGIN CATCH SELECT ERROR_MESSAGE() END CATCH",
+		Want:     [][]string{row("inner")},
+	},
+	{
+		Name:      "RAISERROR_uncaught",
+		Category:  CategoryErrorHandling,
+		SQL:       "RAISERROR('fatal', 16, 1)",
+		WantError: true,
+	},
+	{
+		Name:      "THROW_uncaught",
+		Category:  CategoryErrorHandling,
+		SQL:       "THROW 50003, 'fatal', 1",
+		WantError: true,
+	},
+	{
+		Name:     "ROWCOUNT_after_insert",
+		Category: CategoryErrorHandling,
+		SQL:      "DECLARE @t TABLE (i INT); INSERT INTO @t VALUES (1), (2), (3); SELECT @@ROWCOUNT",
+		Want:     [][]string{row("3")},
+	},
+	{
+		Name:     "ERROR_after_success",
+		Category: CategoryErrorHandling,
+		SQL:      "DECLARE @x INT = 1; SELECT @@ERROR",
+		Want:     [][]string{row("0")},
+	},
+}
+
+// Filter returns the cases whose category is in categories. An empty list
+// returns all cases.
+func Filter(cases []Case, categories []string) []Case {
+	if len(categories) == 0 {
+		return cases
+	}
+	want := make(map[string]bool, len(categories))
+	for _, c := range categories {
+		want[c] = true
+	}
+	var out []Case
+	for _, c := range cases {
+		if want[c.Category] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,225 @@
+package conformance
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status is the outcome of a single case.
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip" // Reference server could not run the case
+)
+
+// Outcome is what a server returned for a case.
+type Outcome struct {
+	Rows [][]string `json:"rows,omitempty"`
+	Err  string     `json:"error,omitempty"`
+}
+
+// Result records how a case fared.
+type Result struct {
+	Case     Case          `json:"-"`
+	Name     string        `json:"name"`
+	Category string        `json:"category"`
+	Status   Status        `json:"status"`
+	Got      Outcome       `json:"got"`
+	Want     Outcome       `json:"want"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Runner executes cases against a target server and, optionally, a
+// reference SQL Server.
+type Runner struct {
+	Target    *sql.DB
+	Reference *sql.DB // Optional; nil compares against Case.Want
+
+	// Timeout bounds each case on each server.
+	Timeout time.Duration
+}
+
+// DefaultTimeout is used when Runner.Timeout is zero.
+const DefaultTimeout = 10 * time.Second
+
+// Run executes cases in order and returns a scorecard.
+func (r *Runner) Run(ctx context.Context, cases []Case) *Scorecard {
+	card := &Scorecard{
+		StartedAt: time.Now(),
+		Reference: r.Reference != nil,
+	}
+	for _, c := range cases {
+		card.Results = append(card.Results, r.runCase(ctx, c))
+	}
+	card.Duration = time.Since(card.StartedAt)
+	return card
+}
+
+// runCase executes a single case and classifies the outcome.
+func (r *Runner) runCase(ctx context.Context, c Case) Result {
+	res := Result{Case: c, Name: c.Name, Category: c.Category}
+
+	if r.Reference != nil {
+		want, err := r.execute(ctx, r.Reference, c.SQL)
+		if err != nil {
+			res.Status = StatusSkip
+			res.Detail = fmt.Sprintf("reference: %v", err)
+			return res
+		}
+		res.Want = want
+	} else if c.WantError {
+		res.Want = Outcome{Err: "error"}
+	} else {
+		res.Want = Outcome{Rows: c.Want}
+	}
+
+	start := time.Now()
+	got, err := r.execute(ctx, r.Target, c.SQL)
+	res.Duration = time.Since(start)
+	if err != nil {
+		res.Status = StatusFail
+		res.Detail = err.Error()
+		return res
+	}
+	res.Got = got
+
+	if detail := compare(res.Want, got); detail != "" {
+		res.Status = StatusFail
+		res.Detail = detail
+		return res
+	}
+	res.Status = StatusPass
+	return res
+}
+
+// execute runs sqlStr on a dedicated connection and collects every row of
+// every result set. A server error is returned in the Outcome; the error
+// return is reserved for failures to reach the server at all.
+func (r *Runner) execute(ctx context.Context, db *sql.DB, sqlStr string) (Outcome, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// A dedicated connection keeps session state (temp tables, SET
+	// options) from leaking between cases. Reporting it as bad when done
+	// stops the pool from handing it to the next case.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return Outcome{}, err
+	}
+	defer conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+
+	rows, err := conn.QueryContext(ctx, sqlStr)
+	if err != nil {
+		return Outcome{Err: err.Error()}, nil
+	}
+	defer rows.Close()
+
+	var out Outcome
+	for {
+		cols, err := rows.Columns()
+		if err != nil {
+			return Outcome{Rows: out.Rows, Err: err.Error()}, nil
+		}
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				return Outcome{Rows: out.Rows, Err: err.Error()}, nil
+			}
+			row := make([]string, len(values))
+			for i, v := range values {
+				row[i] = Normalise(v)
+			}
+			out.Rows = append(out.Rows, row)
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		out.Err = err.Error()
+	}
+	return out, nil
+}
+
+// compare reports how got differs from want, or "" when they match.
+// Error text is not compared: servers word messages differently, so only
+// the presence of an error matters.
+func compare(want, got Outcome) string {
+	switch {
+	case want.Err != "" && got.Err == "":
+		return "expected an error, got none"
+	case want.Err == "" && got.Err != "":
+		return "unexpected error: " + got.Err
+	case want.Err != "":
+		return ""
+	}
+
+	if len(want.Rows) != len(got.Rows) {
+		return fmt.Sprintf("expected %d rows, got %d", len(want.Rows), len(got.Rows))
+	}
+	for i := range want.Rows {
+		if strings.Join(want.Rows[i], "\x00") != strings.Join(got.Rows[i], "\x00") {
+			return fmt.Sprintf("row %d: expected %v, got %v", i+1, want.Rows[i], got.Rows[i])
+		}
+	}
+	return ""
+}
+
+// Normalise converts a scanned value to a comparable string. NULL becomes
+// "NULL", booleans become "1"/"0", numbers lose insignificant trailing
+// zeros, and dates without a time component are formatted as YYYY-MM-DD.
+func Normalise(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if x {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return normaliseNumber(string(x))
+	case string:
+		return normaliseNumber(x)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case time.Time:
+		if x.Hour() == 0 && x.Minute() == 0 && x.Second() == 0 && x.Nanosecond() == 0 {
+			return x.Format("2006-01-02")
+		}
+		return x.Format("2006-01-02 15:04:05.999999999")
+	}
+	return fmt.Sprint(v)
+}
+
+// normaliseNumber strips trailing fractional zeros from decimal strings so
+// that DECIMAL(10,2) 2.50 and FLOAT 2.5 compare equal. Other strings are
+// returned unchanged.
+func normaliseNumber(s string) string {
+	if !strings.Contains(s, ".") {
+		return s
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return s
+	}
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,131 @@
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Scorecard summarises a conformance run.
+type Scorecard struct {
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+	Reference bool          `json:"reference"` // Expectations came from a live SQL Server
+	Results   []Result      `json:"results"`
+}
+
+// Tally counts results by status.
+type Tally struct {
+	Pass int `json:"pass"`
+	Fail int `json:"fail"`
+	Skip int `json:"skip"`
+}
+
+// Score returns the pass percentage, ignoring skipped cases.
+func (t Tally) Score() float64 {
+	if t.Pass+t.Fail == 0 {
+		return 0
+	}
+	return 100 * float64(t.Pass) / float64(t.Pass+t.Fail)
+}
+
+func (t *Tally) add(s Status) {
+	switch s {
+	case StatusPass:
+		t.Pass++
+	case StatusFail:
+		t.Fail++
+	case StatusSkip:
+		t.Skip++
+	}
+}
+
+// Total tallies every result.
+func (s *Scorecard) Total() Tally {
+	var t Tally
+	for _, r := range s.Results {
+		t.add(r.Status)
+	}
+	return t
+}
+
+// ByCategory tallies results per category.
+func (s *Scorecard) ByCategory() map[string]Tally {
+	out := make(map[string]Tally)
+	for _, r := range s.Results {
+		t := out[r.Category]
+		t.add(r.Status)
+		out[r.Category] = t
+	}
+	return out
+}
+
+// categories returns category names in first-seen order.
+func (s *Scorecard) categories() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, r := range s.Results {
+		if !seen[r.Category] {
+			seen[r.Category] = true
+			out = append(out, r.Category)
+		}
+	}
+	return out
+}
+
+// WriteText writes a human-readable scorecard. With verbose set every case
+// is listed; otherwise only failures are.
+func (s *Scorecard) WriteText(w io.Writer, verbose bool) {
+	source := "built-in expectations"
+	if s.Reference {
+		source = "reference server"
+	}
+	fmt.Fprintf(w, "T-SQL conformance (%d cases, compared against %s)\n\n", len(s.Results), source)
+
+	byCat := s.ByCategory()
+	fmt.Fprintf(w, "  %-16s %6s %6s %6s %8s\n", "Category", "Pass", "Fail", "Skip", "Score")
+	for _, cat := range s.categories() {
+		t := byCat[cat]
+		fmt.Fprintf(w, "  %-16s %6d %6d %6d %7.1f%%\n", cat, t.Pass, t.Fail, t.Skip, t.Score())
+	}
+	total := s.Total()
+	fmt.Fprintf(w, "  %-16s %6d %6d %6d %7.1f%%\n", "TOTAL", total.Pass, total.Fail, total.Skip, total.Score())
+
+	var listed []Result
+	for _, r := range s.Results {
+		if verbose || r.Status != StatusPass {
+			listed = append(listed, r)
+		}
+	}
+	if len(listed) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	for _, r := range listed {
+		fmt.Fprintf(w, "  [%s] %s/%s", r.Status, r.Category, r.Name)
+		if r.Detail != "" {
+			fmt.Fprintf(w, ": %s", r.Detail)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// WriteJSON writes the scorecard with per-category tallies as JSON.
+func (s *Scorecard) WriteJSON(w io.Writer) error {
+	out := struct {
+		*Scorecard
+		Total      Tally            `json:"total"`
+		Score      float64          `json:"score"`
+		Categories map[string]Tally `json:"categories"`
+	}{
+		Scorecard:  s,
+		Total:      s.Total(),
+		Score:      s.Total().Score(),
+		Categories: s.ByCategory(),
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
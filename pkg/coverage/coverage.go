@@ -0,0 +1,223 @@
+// Package coverage reports how much of each stored procedure a run of
+// aul test executed: which of its statements ran, by line, and which ways
+// its IFs and WHILEs went. Reports are written as text, as a standalone
+// HTML page showing each procedure's source, and in the lcov tracefile
+// format read by genhtml and CI coverage services.
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// Line is a line a statement of a procedure begins on.
+type Line struct {
+	Number int
+	Hits   int64 // Executions of the statements beginning on it
+}
+
+// Branch is one way an IF or WHILE of a procedure can go.
+type Branch struct {
+	Line   int
+	Branch string // tsqlruntime.BranchThen, BranchElse, ...
+	Taken  int64
+}
+
+// Procedure is the coverage of one procedure.
+type Procedure struct {
+	Name     string // schema.name
+	File     string // SourceFile, or "" for a procedure not loaded from one
+	Source   string
+	Line     int // Where CREATE PROCEDURE is, for lcov
+	Lines    []Line
+	Branches []Branch
+	Error    string // Why the source could not be read for coverage
+}
+
+// Report is the coverage of a set of procedures.
+type Report struct {
+	Procedures []Procedure
+}
+
+// NewReport returns the coverage of procs counted in c, sorted by name.
+// Functions are left out: coverage counts procedures only.
+func NewReport(procs []*procedure.Procedure, c *tsqlruntime.Coverage) *Report {
+	r := &Report{}
+	for _, proc := range procs {
+		if proc.IsFunction {
+			continue
+		}
+		name := proc.Name
+		if proc.Schema != "" {
+			name = proc.Schema + "." + proc.Name
+		}
+		p := Procedure{Name: name, File: proc.SourceFile, Source: proc.Source, Line: createLine(proc.Source)}
+		lines, branches, err := tsqlruntime.CoverablePoints(proc.Source)
+		if err != nil {
+			p.Error = err.Error()
+			r.Procedures = append(r.Procedures, p)
+			continue
+		}
+		ran := c.Procedure(name)
+		for _, n := range lines {
+			p.Lines = append(p.Lines, Line{Number: n, Hits: ran.Lines[n]})
+		}
+		for _, b := range branches {
+			p.Branches = append(p.Branches, Branch{Line: b.Line, Branch: b.Branch, Taken: ran.Branches[b]})
+		}
+		r.Procedures = append(r.Procedures, p)
+	}
+	sort.Slice(r.Procedures, func(a, b int) bool {
+		return strings.ToLower(r.Procedures[a].Name) < strings.ToLower(r.Procedures[b].Name)
+	})
+	return r
+}
+
+// createStatement finds CREATE PROCEDURE, or CREATE OR ALTER PROC.
+var createStatement = regexp.MustCompile(`(?i)\bCREATE\s+(OR\s+ALTER\s+)?PROC`)
+
+// createLine returns the line of source CREATE PROCEDURE is on, or 1.
+func createLine(source string) int {
+	loc := createStatement.FindStringIndex(source)
+	if loc == nil {
+		return 1
+	}
+	return strings.Count(source[:loc[0]], "\n") + 1
+}
+
+// LinesHit returns the lines of p that ran and those it has.
+func (p *Procedure) LinesHit() (hit, total int) {
+	for _, l := range p.Lines {
+		if l.Hits > 0 {
+			hit++
+		}
+	}
+	return hit, len(p.Lines)
+}
+
+// BranchesHit returns the branches of p taken and those it has.
+func (p *Procedure) BranchesHit() (hit, total int) {
+	for _, b := range p.Branches {
+		if b.Taken > 0 {
+			hit++
+		}
+	}
+	return hit, len(p.Branches)
+}
+
+// Missed returns the lines of p that did not run, as ranges such as
+// "12, 15-16".
+func (p *Procedure) Missed() string {
+	var ranges []string
+	for j := 0; j < len(p.Lines); j++ {
+		if p.Lines[j].Hits > 0 {
+			continue
+		}
+		first, last := p.Lines[j].Number, p.Lines[j].Number
+		for j+1 < len(p.Lines) && p.Lines[j+1].Hits == 0 {
+			j++
+			last = p.Lines[j].Number
+		}
+		if first == last {
+			ranges = append(ranges, fmt.Sprint(first))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", first, last))
+		}
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// Totals returns the lines and branches of every procedure of r that ran
+// or were taken, and those there are.
+func (r *Report) Totals() (linesHit, lines, branchesHit, branches int) {
+	for j := range r.Procedures {
+		h, n := r.Procedures[j].LinesHit()
+		linesHit, lines = linesHit+h, lines+n
+		h, n = r.Procedures[j].BranchesHit()
+		branchesHit, branches = branchesHit+h, branches+n
+	}
+	return linesHit, lines, branchesHit, branches
+}
+
+// LinePercent returns the share of the lines of every procedure that ran,
+// as a percentage; 100 if there are none.
+func (r *Report) LinePercent() float64 {
+	hit, total, _, _ := r.Totals()
+	return percent(hit, total)
+}
+
+func percent(hit, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(hit) / float64(total)
+}
+
+// ratio formats hit of total as a count and percentage.
+func ratio(hit, total int) string {
+	return fmt.Sprintf("%d/%d (%.1f%%)", hit, total, percent(hit, total))
+}
+
+// WriteText writes a table of each procedure's line and branch coverage,
+// with the lines that did not run, and the totals.
+func (r *Report) WriteText(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROCEDURE\tLINES\tBRANCHES\tMISSED LINES")
+	for j := range r.Procedures {
+		p := &r.Procedures[j]
+		if p.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\t-\t%s\n", p.Name, p.Error)
+			continue
+		}
+		lh, ln := p.LinesHit()
+		bh, bn := p.BranchesHit()
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Name, ratio(lh, ln), ratio(bh, bn), p.Missed())
+	}
+	lh, ln, bh, bn := r.Totals()
+	fmt.Fprintf(tw, "TOTAL\t%s\t%s\t\n", ratio(lh, ln), ratio(bh, bn))
+	tw.Flush()
+}
+
+// WriteLCOV writes r as an lcov tracefile: a record per procedure loaded
+// from a file, with its lines, its branches, each IF or WHILE a block of
+// two, and the procedure as a function called as often as its first
+// statement ran.
+func (r *Report) WriteLCOV(w io.Writer) error {
+	var b strings.Builder
+	for j := range r.Procedures {
+		p := &r.Procedures[j]
+		if p.File == "" || p.Error != "" {
+			continue
+		}
+		b.WriteString("TN:\n")
+		fmt.Fprintf(&b, "SF:%s\n", p.File)
+		calls := int64(0)
+		if len(p.Lines) > 0 {
+			calls = p.Lines[0].Hits
+		}
+		fmt.Fprintf(&b, "FN:%d,%s\nFNDA:%d,%s\nFNF:1\nFNH:%d\n", p.Line, p.Name, calls, p.Name, min(calls, 1))
+		for k, br := range p.Branches {
+			taken := "-"
+			if br.Taken > 0 || p.Branches[k^1].Taken > 0 {
+				taken = fmt.Sprint(br.Taken)
+			}
+			fmt.Fprintf(&b, "BRDA:%d,%d,%d,%s\n", br.Line, k/2, k%2, taken)
+		}
+		bh, bn := p.BranchesHit()
+		fmt.Fprintf(&b, "BRF:%d\nBRH:%d\n", bn, bh)
+		for _, l := range p.Lines {
+			fmt.Fprintf(&b, "DA:%d,%d\n", l.Number, l.Hits)
+		}
+		lh, ln := p.LinesHit()
+		fmt.Fprintf(&b, "LF:%d\nLH:%d\nend_of_record\n", ln, lh)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
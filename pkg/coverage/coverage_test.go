@@ -0,0 +1,167 @@
+package coverage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// restockSource is a procedure whose statements begin on known lines.
+const restockSource = `-- Restocks an order
+CREATE PROCEDURE dbo.Restock @qty INT
+AS
+BEGIN
+	DECLARE @n INT = 0
+	WHILE @n < @qty
+		SET @n = @n + 1
+	IF @qty > 0
+		SET @n = 0
+	ELSE
+	BEGIN
+		SET @n = -1
+		RETURN 1
+	END
+END`
+
+// resolver maps the coverage names of procedures to their sources.
+type resolver map[string]string
+
+func (r resolver) Resolve(ctx context.Context, name, database string) (string, []tsqlruntime.ProcedureParam, error) {
+	return r[tsqlruntime.CoverageName(name)], nil, nil
+}
+
+// testReport runs batch, which calls dbo.Restock, and returns the coverage
+// report of dbo.Restock and dbo.Unused.
+func testReport(t *testing.T, batch string) *Report {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	procs := []*procedure.Procedure{
+		{Name: "Restock", Schema: "dbo", Source: restockSource, SourceFile: "procedures/Restock.sql"},
+		{Name: "Unused", Schema: "dbo", Source: "CREATE PROCEDURE dbo.Unused AS SELECT 1", SourceFile: "procedures/Unused.sql"},
+		{Name: "Total", Schema: "dbo", Source: "CREATE FUNCTION dbo.Total() RETURNS INT AS BEGIN RETURN 1 END", IsFunction: true},
+	}
+	interp := tsqlruntime.NewInterpreter(db, tsqlruntime.DialectSQLite)
+	interp.SetResolver(resolver{"dbo.restock": restockSource})
+	c := tsqlruntime.NewCoverage()
+	interp.SetCoverage(c)
+	if _, err := interp.Execute(context.Background(), batch, nil); err != nil {
+		t.Fatal(err)
+	}
+	return NewReport(procs, c)
+}
+
+func TestReport(t *testing.T) {
+	r := testReport(t, "EXEC dbo.Restock 2")
+	if len(r.Procedures) != 2 || r.Procedures[0].Name != "dbo.Restock" || r.Procedures[1].Name != "dbo.Unused" {
+		t.Fatalf("procedures %+v", r.Procedures)
+	}
+	p := &r.Procedures[0]
+	if p.Line != 2 {
+		t.Errorf("CREATE PROCEDURE on line %d, want 2", p.Line)
+	}
+	if hit, total := p.LinesHit(); hit != 5 || total != 7 {
+		t.Errorf("lines %d/%d, want 5/7", hit, total)
+	}
+	if hit, total := p.BranchesHit(); hit != 3 || total != 4 {
+		t.Errorf("branches %d/%d, want 3/4", hit, total)
+	}
+	if missed := p.Missed(); missed != "12-13" {
+		t.Errorf("missed %q", missed)
+	}
+	if pct := r.LinePercent(); pct != 62.5 {
+		t.Errorf("line coverage %.1f%%, want 62.5%%", pct)
+	}
+
+	var text bytes.Buffer
+	r.WriteText(&text)
+	for _, want := range []string{
+		"dbo.Restock  5/7 (71.4%)  3/4 (75.0%)   12-13\n",
+		"dbo.Unused   0/1 (0.0%)   0/0 (100.0%)  1\n",
+		"TOTAL        5/8 (62.5%)  3/4 (75.0%)",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report lacks %q:\n%s", want, text.String())
+		}
+	}
+
+	var lcov bytes.Buffer
+	if err := r.WriteLCOV(&lcov); err != nil {
+		t.Fatal(err)
+	}
+	want := `TN:
+SF:procedures/Restock.sql
+FN:2,dbo.Restock
+FNDA:1,dbo.Restock
+FNF:1
+FNH:1
+BRDA:6,0,0,2
+BRDA:6,0,1,1
+BRDA:8,1,0,1
+BRDA:8,1,1,0
+BRF:4
+BRH:3
+DA:5,1
+DA:6,1
+DA:7,2
+DA:8,1
+DA:9,1
+DA:12,0
+DA:13,0
+LF:7
+LH:5
+end_of_record
+TN:
+SF:procedures/Unused.sql
+FN:1,dbo.Unused
+FNDA:0,dbo.Unused
+FNF:1
+FNH:0
+BRF:0
+BRH:0
+DA:1,0
+LF:1
+LH:0
+end_of_record
+`
+	if lcov.String() != want {
+		t.Errorf("lcov:\n%s\nwant:\n%s", lcov.String(), want)
+	}
+
+	var html bytes.Buffer
+	if err := r.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<tr class="hit"><td class="num">7</td><td class="hits">2</td><td>		SET @n = @n &#43; 1</td>`,
+		`<tr class="miss"><td class="num">12</td><td class="hits">0</td><td>		SET @n = -1</td>`,
+		`<td class="branches">THEN 1, ELSE 0</td>`,
+		`<tr class=""><td class="num">1</td><td class="hits"></td><td>-- Restocks an order</td>`,
+	} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("HTML report lacks %q", want)
+		}
+	}
+}
+
+func TestReportUnparsed(t *testing.T) {
+	procs := []*procedure.Procedure{{Name: "Broken", Schema: "dbo", Source: "CREATE PROCEDURE dbo.Broken AS SELECT FROM"}}
+	r := NewReport(procs, tsqlruntime.NewCoverage())
+	if len(r.Procedures) != 1 || r.Procedures[0].Error == "" {
+		t.Fatalf("procedures %+v", r.Procedures)
+	}
+	var lcov bytes.Buffer
+	if err := r.WriteLCOV(&lcov); err != nil || lcov.Len() != 0 {
+		t.Errorf("lcov of an unparsed procedure: %q, %v", lcov.String(), err)
+	}
+}
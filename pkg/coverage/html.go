@@ -0,0 +1,100 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// htmlLine is a line of a procedure's source as the HTML report shows it.
+type htmlLine struct {
+	Number   int
+	Class    string // "hit", "miss", or "" for a line no statement begins on
+	Hits     string
+	Code     string
+	Branches string // How the IF or WHILE on the line went
+}
+
+// htmlProcedure is a procedure as the HTML report shows it.
+type htmlProcedure struct {
+	Name, File, Error string
+	Lines, Branches   string // "8/10 (80.0%)"
+	Source            []htmlLine
+}
+
+// WriteHTML writes r as a standalone HTML page: a table of the
+// procedures' coverage, then the source of each with the lines that ran
+// and those that did not marked, and how each IF and WHILE went.
+func (r *Report) WriteHTML(w io.Writer) error {
+	var data struct {
+		Lines, Branches string
+		Procedures      []htmlProcedure
+	}
+	lh, ln, bh, bn := r.Totals()
+	data.Lines, data.Branches = ratio(lh, ln), ratio(bh, bn)
+	for j := range r.Procedures {
+		p := &r.Procedures[j]
+		hp := htmlProcedure{Name: p.Name, File: p.File, Error: p.Error}
+		lh, ln := p.LinesHit()
+		bh, bn := p.BranchesHit()
+		hp.Lines, hp.Branches = ratio(lh, ln), ratio(bh, bn)
+
+		hits := make(map[int]int64)
+		for _, l := range p.Lines {
+			hits[l.Number] = l.Hits
+		}
+		taken := make(map[int][]string)
+		for _, b := range p.Branches {
+			taken[b.Line] = append(taken[b.Line], fmt.Sprintf("%s %d", b.Branch, b.Taken))
+		}
+		for k, code := range strings.Split(strings.ReplaceAll(p.Source, "\r\n", "\n"), "\n") {
+			line := htmlLine{Number: k + 1, Code: code, Branches: strings.Join(taken[k+1], ", ")}
+			if n, ok := hits[k+1]; ok {
+				line.Class, line.Hits = "miss", fmt.Sprint(n)
+				if n > 0 {
+					line.Class = "hit"
+				}
+			}
+			hp.Source = append(hp.Source, line)
+		}
+		data.Procedures = append(data.Procedures, hp)
+	}
+	return htmlReport.Execute(w, data)
+}
+
+var htmlReport = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>aul test coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.8em; text-align: left; }
+.summary td, .summary th { border-bottom: 1px solid #ddd; }
+.source { font-family: monospace; white-space: pre; }
+.source td { padding: 0 0.5em; }
+.num, .hits { color: #888; text-align: right; }
+.hit { background: #ddffdd; }
+.miss { background: #ffdddd; }
+.branches { color: #666; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>aul test coverage</h1>
+<table class="summary">
+<tr><th>Procedure</th><th>Lines</th><th>Branches</th></tr>
+{{range .Procedures}}<tr><td><a href="#{{.Name}}">{{.Name}}</a></td>{{if .Error}}<td colspan="2">{{.Error}}</td>{{else}}<td>{{.Lines}}</td><td>{{.Branches}}</td>{{end}}</tr>
+{{end}}<tr><th>Total</th><th>{{.Lines}}</th><th>{{.Branches}}</th></tr>
+</table>
+{{range .Procedures}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+{{if .File}}<p>{{.File}}</p>{{end}}
+{{if .Error}}<p>{{.Error}}</p>{{else}}<table class="source">
+{{range .Source}}<tr class="{{.Class}}"><td class="num">{{.Number}}</td><td class="hits">{{.Hits}}</td><td>{{.Code}}</td><td class="branches">{{.Branches}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,451 @@
+// Package dacpac reads the packages SQL Server database projects build.
+//
+// A .dacpac is a zip archive holding model.xml, the database's schema as a
+// list of elements (tables, constraints, procedures and so on), with
+// optional pre- and post-deployment scripts. A .bacpac is the same with
+// the tables' data added under Data/. This package reads the model into an
+// mssqlimport.Catalog, so that a package deploys to aul through the same
+// conversion as a live SQL Server database, and lists the elements aul has
+// no equivalent for.
+package dacpac
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/mssqlimport"
+)
+
+// Package is a .dacpac or .bacpac.
+type Package struct {
+	Name    string // From DacMetadata.xml
+	Version string
+
+	Catalog *mssqlimport.Catalog
+
+	PreDeploy  string   // Pre-deployment script ("" = none)
+	PostDeploy string   // Post-deployment script ("" = none)
+	Variables  []string // SQLCMD variables the deployment scripts declare
+
+	// Incompatible lists the model elements aul has nothing for, such as
+	// users, roles and assemblies, as unsupported report objects.
+	Incompatible []*mssqlimport.Object
+
+	// DataTables are the tables a bacpac has data for, as schema.name.
+	DataTables []string
+}
+
+// Open reads the package at path.
+func Open(path string) (*Package, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return read(&zr.Reader)
+}
+
+// Read reads a package of size bytes from r.
+func Read(r io.ReaderAt, size int64) (*Package, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return read(zr)
+}
+
+func read(zr *zip.Reader) (*Package, error) {
+	p := &Package{}
+	var m *model
+	tables := make(map[string]bool)
+
+	for _, f := range zr.File {
+		name := strings.ToLower(f.Name)
+		switch {
+		case name == "model.xml":
+			m = &model{}
+			if err := decode(f, m); err != nil {
+				return nil, fmt.Errorf("reading model.xml: %w", err)
+			}
+		case name == "dacmetadata.xml":
+			var meta struct {
+				Name    string `xml:"Name"`
+				Version string `xml:"Version"`
+			}
+			if err := decode(f, &meta); err != nil {
+				return nil, fmt.Errorf("reading DacMetadata.xml: %w", err)
+			}
+			p.Name, p.Version = meta.Name, meta.Version
+		case name == "predeploy.sql":
+			script, err := readFile(f)
+			if err != nil {
+				return nil, err
+			}
+			p.PreDeploy = script
+		case name == "postdeploy.sql":
+			script, err := readFile(f)
+			if err != nil {
+				return nil, err
+			}
+			p.PostDeploy = script
+		case strings.HasPrefix(name, "data/"):
+			// Data/<schema>.<table>/TableData-*.BCP
+			if dir := path.Dir(f.Name); dir != "Data" && dir != "data" {
+				tables[path.Base(dir)] = true
+			}
+		}
+	}
+	if m == nil {
+		return nil, fmt.Errorf("not a dacpac: model.xml missing")
+	}
+
+	for _, cd := range m.Header.CustomData {
+		if cd.Category == "SqlCmdVariables" {
+			for _, md := range cd.Metadata {
+				p.Variables = append(p.Variables, md.Name)
+			}
+		}
+	}
+	for t := range tables {
+		p.DataTables = append(p.DataTables, t)
+	}
+	sort.Strings(p.DataTables)
+
+	p.Catalog, p.Incompatible = convert(m)
+	p.Catalog.Database = p.Name
+	return p, nil
+}
+
+func decode(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+func readFile(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", f.Name, err)
+	}
+	// Visual Studio writes scripts with a byte order mark
+	return strings.TrimPrefix(string(data), "\ufeff"), nil
+}
+
+// Source returns the package as an import source. It has the schema but
+// no rows: a bacpac's data is in BCP native format, which is not read.
+func (p *Package) Source() mssqlimport.Source {
+	return source{p}
+}
+
+type source struct {
+	p *Package
+}
+
+func (s source) Catalog(ctx context.Context) (*mssqlimport.Catalog, error) {
+	return s.p.Catalog, nil
+}
+
+func (s source) Rows(ctx context.Context, t *mssqlimport.Table, columns []*mssqlimport.Column, fn func([]interface{}) error) error {
+	return nil
+}
+
+// moduleTypes maps the element types of programmable objects to their
+// sys.objects type codes.
+var moduleTypes = map[string]string{
+	"SqlProcedure":                         "P",
+	"SqlScalarFunction":                    "FN",
+	"SqlInlineTableValuedFunction":         "IF",
+	"SqlMultiStatementTableValuedFunction": "TF",
+	"SqlView":                              "V",
+	"SqlDmlTrigger":                        "TR",
+	"SqlSequence":                          "SO",
+	"SqlSynonym":                           "SN",
+}
+
+// ignoredTypes are elements with nothing to deploy to aul: schemas are
+// flattened (each table reports it) and options do not apply.
+var ignoredTypes = map[string]bool{
+	"SqlDatabaseOptions":  true,
+	"SqlSchema":           true,
+	"SqlExtendedProperty": true,
+}
+
+// convert turns the model into a catalogue, and the elements that have no
+// place in one into unsupported objects.
+func convert(m *model) (*mssqlimport.Catalog, []*mssqlimport.Object) {
+	c := &mssqlimport.Catalog{}
+	var incompatible []*mssqlimport.Object
+	tables := make(map[string]*mssqlimport.Table)
+	columns := make(map[string]*mssqlimport.Column)
+
+	// Tables first, as constraints refer to them
+	for i := range m.Elements {
+		e := &m.Elements[i]
+		if e.Type != "SqlTable" {
+			continue
+		}
+		parts := nameParts(e.Name)
+		if len(parts) != 2 {
+			continue
+		}
+		t := &mssqlimport.Table{Schema: parts[0], Name: parts[1]}
+		for _, ce := range e.elements("Columns") {
+			col := column(ce)
+			t.Columns = append(t.Columns, col)
+			columns[ce.Name] = col
+		}
+		tables[e.Name] = t
+		c.Tables = append(c.Tables, t)
+	}
+
+	counter := make(map[string]int)
+	constraintName := func(e *element, prefix string, t *mssqlimport.Table) string {
+		if parts := nameParts(e.Name); len(parts) > 0 {
+			return parts[len(parts)-1]
+		}
+		// Unnamed constraints get the kind of name SQL Server makes up
+		key := prefix + t.Name
+		counter[key]++
+		return fmt.Sprintf("%s_%s_%d", prefix, t.Name, counter[key])
+	}
+
+	for i := range m.Elements {
+		e := &m.Elements[i]
+		switch e.Type {
+		case "SqlTable":
+			continue
+
+		case "SqlPrimaryKeyConstraint", "SqlUniqueConstraint":
+			t := tables[e.reference("DefiningTable")]
+			if t == nil {
+				continue
+			}
+			ix := &mssqlimport.Index{Unique: true, Constraint: true, Columns: e.columnSpecs()}
+			if e.Type == "SqlPrimaryKeyConstraint" {
+				ix.Name = constraintName(e, "PK", t)
+				t.PrimaryKey = ix
+			} else {
+				ix.Name = constraintName(e, "UQ", t)
+				t.Indexes = append(t.Indexes, ix)
+			}
+
+		case "SqlIndex":
+			t := tables[e.reference("IndexedObject")]
+			if t == nil {
+				continue
+			}
+			t.Indexes = append(t.Indexes, &mssqlimport.Index{
+				Name:     constraintName(e, "IX", t),
+				Columns:  e.columnSpecs(),
+				Unique:   e.property("IsUnique") == "True",
+				Filter:   e.property("FilterPredicate"),
+				Included: lastParts(e.references("IncludedColumns")),
+			})
+
+		case "SqlForeignKeyConstraint":
+			t := tables[e.reference("DefiningTable")]
+			if t == nil {
+				continue
+			}
+			fk := &mssqlimport.ForeignKey{
+				Name:       constraintName(e, "FK", t),
+				Columns:    lastParts(e.references("Columns")),
+				RefTable:   tables[e.reference("ForeignTable")],
+				RefColumns: lastParts(e.references("ForeignColumns")),
+				OnDelete:   referentialAction(e.property("DeleteAction")),
+				OnUpdate:   referentialAction(e.property("UpdateAction")),
+			}
+			t.ForeignKeys = append(t.ForeignKeys, fk)
+
+		case "SqlDefaultConstraint":
+			if col := columns[e.reference("ForColumn")]; col != nil {
+				col.Default = e.property("DefaultExpressionScript")
+			}
+
+		case "SqlCheckConstraint":
+			if t := tables[e.reference("DefiningTable")]; t != nil {
+				t.Checks = append(t.Checks, constraintName(e, "CK", t))
+			}
+
+		default:
+			if typ, ok := moduleTypes[e.Type]; ok {
+				if mod := module(e, typ); mod != nil {
+					c.Modules = append(c.Modules, mod)
+				}
+				continue
+			}
+			if ignoredTypes[e.Type] {
+				continue
+			}
+			incompatible = append(incompatible, &mssqlimport.Object{
+				Kind:   strings.ToLower(strings.TrimPrefix(e.Type, "Sql")),
+				Name:   strings.Join(nameParts(e.Name), "."),
+				Status: mssqlimport.StatusUnsupported,
+				Error:  "aul has no equivalent",
+			})
+		}
+	}
+	return c, incompatible
+}
+
+// column converts a SqlSimpleColumn or SqlComputedColumn element.
+func column(e *element) *mssqlimport.Column {
+	parts := nameParts(e.Name)
+	col := &mssqlimport.Column{
+		Name:     parts[len(parts)-1],
+		Nullable: e.property("IsNullable") != "False",
+		Computed: e.property("ExpressionScript"),
+	}
+	if e.property("IsIdentity") == "True" {
+		col.Identity = true
+		col.IdentitySeed, col.IdentityIncrement = 1, 1
+		spec := e
+		if specs := e.elements("IdentitySpecification"); len(specs) > 0 {
+			spec = specs[0]
+		}
+		if n, err := strconv.ParseInt(spec.property("IdentitySeed"), 10, 64); err == nil {
+			col.IdentitySeed = n
+		}
+		if n, err := strconv.ParseInt(spec.property("IdentityIncrement"), 10, 64); err == nil {
+			col.IdentityIncrement = n
+		}
+	}
+
+	specs := e.elements("TypeSpecifier")
+	if len(specs) == 0 {
+		// A computed column's type follows from its expression
+		return col
+	}
+	spec := specs[0]
+	refs := spec.relationship("Type")
+	if refs == nil || len(refs.Entries) == 0 || len(refs.Entries[0].References) == 0 {
+		return col
+	}
+	ref := refs.Entries[0].References[0]
+	typeParts := nameParts(ref.Name)
+	col.Type = strings.ToLower(strings.Join(typeParts, "."))
+	if ref.ExternalSource != "BuiltIns" && len(typeParts) == 1 {
+		col.Type = "dbo." + col.Type
+	}
+
+	atoi := func(s string, def int) int {
+		if n, err := strconv.Atoi(s); err == nil {
+			return n
+		}
+		return def
+	}
+	switch col.Type {
+	case "varchar", "char", "varbinary", "binary", "nvarchar", "nchar":
+		// MaxLength is in bytes, as in sys.columns
+		col.MaxLength = atoi(spec.property("Length"), 1)
+		if spec.property("IsMax") == "True" {
+			col.MaxLength = -1
+		} else if col.Type == "nvarchar" || col.Type == "nchar" {
+			col.MaxLength *= 2
+		}
+	case "decimal", "numeric":
+		col.Precision = atoi(spec.property("Precision"), 18)
+		col.Scale = atoi(spec.property("Scale"), 0)
+	case "datetime2", "time", "datetimeoffset":
+		col.Scale = atoi(spec.property("Scale"), 7)
+	}
+	return col
+}
+
+// module converts a programmable object. Its definition is put together
+// from the header and footer the model keeps beside the body script.
+func module(e *element, typ string) *mssqlimport.Module {
+	parts := nameParts(e.Name)
+	if len(parts) != 2 {
+		return nil
+	}
+	m := &mssqlimport.Module{Schema: parts[0], Name: parts[1], Type: typ}
+	for _, a := range e.Annotations {
+		if a.Type != "SysCommentsObjectAnnotation" {
+			continue
+		}
+		body := e.property("BodyScript")
+		if body == "" {
+			body = e.property("QueryScript")
+		}
+		m.Definition = a.property("HeaderContents") + body + a.property("FooterContents")
+	}
+	return m
+}
+
+// referentialAction turns a model DeleteAction or UpdateAction into T-SQL,
+// "" for NO ACTION.
+func referentialAction(action string) string {
+	switch action {
+	case "1":
+		return "CASCADE"
+	case "2":
+		return "SET NULL"
+	case "3":
+		return "SET DEFAULT"
+	}
+	return ""
+}
+
+// nameParts splits a model name such as [dbo].[Orders].[Id] into its
+// unquoted parts.
+func nameParts(name string) []string {
+	var parts []string
+	for len(name) > 0 {
+		if name[0] == '.' {
+			name = name[1:]
+			continue
+		}
+		if name[0] != '[' {
+			end := strings.IndexByte(name, '.')
+			if end < 0 {
+				end = len(name)
+			}
+			parts = append(parts, name[:end])
+			name = name[end:]
+			continue
+		}
+		var b strings.Builder
+		i := 1
+		for ; i < len(name); i++ {
+			if name[i] == ']' {
+				if i+1 < len(name) && name[i+1] == ']' {
+					b.WriteByte(']')
+					i++
+					continue
+				}
+				break
+			}
+			b.WriteByte(name[i])
+		}
+		parts = append(parts, b.String())
+		name = name[min(i+1, len(name)):]
+	}
+	return parts
+}
+
+// lastParts returns the unqualified names of references.
+func lastParts(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		if parts := nameParts(n); len(parts) > 0 {
+			out = append(out, parts[len(parts)-1])
+		}
+	}
+	return out
+}
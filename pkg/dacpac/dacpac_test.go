@@ -0,0 +1,353 @@
+package dacpac
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+const testModel = `<?xml version="1.0" encoding="utf-8"?>
+<DataSchemaModel FileFormatVersion="1.2" SchemaVersion="2.9" DspName="Microsoft.Data.Tools.Schema.Sql.Sql160DatabaseSchemaProvider" xmlns="http://schemas.microsoft.com/sqlserver/dac/Serialization/2012/02">
+  <Header>
+    <CustomData Category="SqlCmdVariables" Type="SqlCmdVariable">
+      <Metadata Name="Env" Value="" />
+    </CustomData>
+  </Header>
+  <Model>
+    <Element Type="SqlDatabaseOptions">
+      <Property Name="Collation" Value="SQL_Latin1_General_CP1_CI_AS" />
+    </Element>
+    <Element Type="SqlTable" Name="[dbo].[Customers]">
+      <Relationship Name="Columns">
+        <Entry>
+          <Element Type="SqlSimpleColumn" Name="[dbo].[Customers].[Id]">
+            <Property Name="IsNullable" Value="False" />
+            <Property Name="IsIdentity" Value="True" />
+            <Relationship Name="IdentitySpecification">
+              <Entry>
+                <Element Type="SqlColumnIdentitySpecification">
+                  <Property Name="IdentitySeed" Value="100" />
+                  <Property Name="IdentityIncrement" Value="5" />
+                </Element>
+              </Entry>
+            </Relationship>
+            <Relationship Name="TypeSpecifier">
+              <Entry>
+                <Element Type="SqlTypeSpecifier">
+                  <Relationship Name="Type">
+                    <Entry><References ExternalSource="BuiltIns" Name="[int]" /></Entry>
+                  </Relationship>
+                </Element>
+              </Entry>
+            </Relationship>
+          </Element>
+        </Entry>
+        <Entry>
+          <Element Type="SqlSimpleColumn" Name="[dbo].[Customers].[Name]">
+            <Property Name="IsNullable" Value="False" />
+            <Relationship Name="TypeSpecifier">
+              <Entry>
+                <Element Type="SqlTypeSpecifier">
+                  <Property Name="Length" Value="100" />
+                  <Relationship Name="Type">
+                    <Entry><References ExternalSource="BuiltIns" Name="[nvarchar]" /></Entry>
+                  </Relationship>
+                </Element>
+              </Entry>
+            </Relationship>
+          </Element>
+        </Entry>
+        <Entry>
+          <Element Type="SqlSimpleColumn" Name="[dbo].[Customers].[Phone]">
+            <Relationship Name="TypeSpecifier">
+              <Entry>
+                <Element Type="SqlTypeSpecifier">
+                  <Relationship Name="Type">
+                    <Entry><References Name="[dbo].[PhoneNumber]" /></Entry>
+                  </Relationship>
+                </Element>
+              </Entry>
+            </Relationship>
+          </Element>
+        </Entry>
+        <Entry>
+          <Element Type="SqlComputedColumn" Name="[dbo].[Customers].[Upper]">
+            <Property Name="ExpressionScript">
+              <Value><![CDATA[UPPER([Name])]]></Value>
+            </Property>
+          </Element>
+        </Entry>
+      </Relationship>
+      <Relationship Name="Schema">
+        <Entry><References ExternalSource="BuiltIns" Name="[dbo]" /></Entry>
+      </Relationship>
+    </Element>
+    <Element Type="SqlTable" Name="[sales].[Orders]">
+      <Relationship Name="Columns">
+        <Entry>
+          <Element Type="SqlSimpleColumn" Name="[sales].[Orders].[CustomerId]">
+            <Relationship Name="TypeSpecifier">
+              <Entry>
+                <Element Type="SqlTypeSpecifier">
+                  <Relationship Name="Type">
+                    <Entry><References ExternalSource="BuiltIns" Name="[int]" /></Entry>
+                  </Relationship>
+                </Element>
+              </Entry>
+            </Relationship>
+          </Element>
+        </Entry>
+        <Entry>
+          <Element Type="SqlSimpleColumn" Name="[sales].[Orders].[Total]">
+            <Relationship Name="TypeSpecifier">
+              <Entry>
+                <Element Type="SqlTypeSpecifier">
+                  <Property Name="Precision" Value="10" />
+                  <Property Name="Scale" Value="2" />
+                  <Relationship Name="Type">
+                    <Entry><References ExternalSource="BuiltIns" Name="[decimal]" /></Entry>
+                  </Relationship>
+                </Element>
+              </Entry>
+            </Relationship>
+          </Element>
+        </Entry>
+      </Relationship>
+    </Element>
+    <Element Type="SqlPrimaryKeyConstraint" Name="[dbo].[PK_Customers]">
+      <Relationship Name="ColumnSpecifications">
+        <Entry>
+          <Element Type="SqlIndexedColumnSpecification">
+            <Relationship Name="Column">
+              <Entry><References Name="[dbo].[Customers].[Id]" /></Entry>
+            </Relationship>
+          </Element>
+        </Entry>
+      </Relationship>
+      <Relationship Name="DefiningTable">
+        <Entry><References Name="[dbo].[Customers]" /></Entry>
+      </Relationship>
+    </Element>
+    <Element Type="SqlUniqueConstraint">
+      <Relationship Name="ColumnSpecifications">
+        <Entry>
+          <Element Type="SqlIndexedColumnSpecification">
+            <Relationship Name="Column">
+              <Entry><References Name="[dbo].[Customers].[Name]" /></Entry>
+            </Relationship>
+          </Element>
+        </Entry>
+      </Relationship>
+      <Relationship Name="DefiningTable">
+        <Entry><References Name="[dbo].[Customers]" /></Entry>
+      </Relationship>
+    </Element>
+    <Element Type="SqlIndex" Name="[sales].[Orders].[IX_Orders_Customer]">
+      <Property Name="FilterPredicate">
+        <Value><![CDATA[[Total] > 0]]></Value>
+      </Property>
+      <Relationship Name="ColumnSpecifications">
+        <Entry>
+          <Element Type="SqlIndexedColumnSpecification">
+            <Relationship Name="Column">
+              <Entry><References Name="[sales].[Orders].[CustomerId]" /></Entry>
+            </Relationship>
+          </Element>
+        </Entry>
+      </Relationship>
+      <Relationship Name="IndexedObject">
+        <Entry><References Name="[sales].[Orders]" /></Entry>
+      </Relationship>
+    </Element>
+    <Element Type="SqlForeignKeyConstraint" Name="[sales].[FK_Orders_Customers]">
+      <Property Name="DeleteAction" Value="1" />
+      <Relationship Name="Columns">
+        <Entry><References Name="[sales].[Orders].[CustomerId]" /></Entry>
+      </Relationship>
+      <Relationship Name="DefiningTable">
+        <Entry><References Name="[sales].[Orders]" /></Entry>
+      </Relationship>
+      <Relationship Name="ForeignColumns">
+        <Entry><References Name="[dbo].[Customers].[Id]" /></Entry>
+      </Relationship>
+      <Relationship Name="ForeignTable">
+        <Entry><References Name="[dbo].[Customers]" /></Entry>
+      </Relationship>
+    </Element>
+    <Element Type="SqlDefaultConstraint" Name="[dbo].[DF_Customers_Name]">
+      <Property Name="DefaultExpressionScript">
+        <Value><![CDATA[(N'none')]]></Value>
+      </Property>
+      <Relationship Name="DefiningTable">
+        <Entry><References Name="[dbo].[Customers]" /></Entry>
+      </Relationship>
+      <Relationship Name="ForColumn">
+        <Entry><References Name="[dbo].[Customers].[Name]" /></Entry>
+      </Relationship>
+    </Element>
+    <Element Type="SqlCheckConstraint" Name="[sales].[CK_Total]">
+      <Relationship Name="DefiningTable">
+        <Entry><References Name="[sales].[Orders]" /></Entry>
+      </Relationship>
+    </Element>
+    <Element Type="SqlProcedure" Name="[dbo].[GetCustomer]">
+      <Property Name="BodyScript">
+        <Value><![CDATA[
+BEGIN
+  SELECT [Name] FROM [dbo].[Customers] WHERE [Id] = @Id
+END]]></Value>
+      </Property>
+      <Annotation Type="SysCommentsObjectAnnotation">
+        <Property Name="HeaderContents" Value="CREATE PROCEDURE [dbo].[GetCustomer]&#xD;&#xA;  @Id INT&#xD;&#xA;AS" />
+      </Annotation>
+    </Element>
+    <Element Type="SqlDmlTrigger" Name="[dbo].[trg_Audit]" />
+    <Element Type="SqlUser" Name="[app]" />
+    <Element Type="SqlSchema" Name="[sales]" />
+  </Model>
+</DataSchemaModel>`
+
+const testMetadata = `<?xml version="1.0" encoding="utf-8"?>
+<DacType xmlns="http://schemas.microsoft.com/sqlserver/dac/Serialization/2012/02">
+  <Name>Shop</Name>
+  <Version>1.2.0.0</Version>
+</DacType>`
+
+func testPackage(t *testing.T, files map[string]string) *Package {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRead(t *testing.T) {
+	p := testPackage(t, map[string]string{
+		"model.xml":       testModel,
+		"DacMetadata.xml": testMetadata,
+		"postdeploy.sql":  "\ufeffINSERT INTO Customers (Name) VALUES ('$(Env)')\n",
+	})
+
+	if p.Name != "Shop" || p.Version != "1.2.0.0" || p.Catalog.Database != "Shop" {
+		t.Errorf("metadata = %s %s, database %s", p.Name, p.Version, p.Catalog.Database)
+	}
+	if p.PostDeploy != "INSERT INTO Customers (Name) VALUES ('$(Env)')\n" {
+		t.Errorf("PostDeploy = %q", p.PostDeploy)
+	}
+	if len(p.Variables) != 1 || p.Variables[0] != "Env" {
+		t.Errorf("Variables = %v", p.Variables)
+	}
+
+	if len(p.Catalog.Tables) != 2 {
+		t.Fatalf("got %d tables", len(p.Catalog.Tables))
+	}
+	customers, orders := p.Catalog.Tables[0], p.Catalog.Tables[1]
+
+	id, name, phone, upper := customers.Columns[0], customers.Columns[1], customers.Columns[2], customers.Columns[3]
+	if !id.Identity || id.IdentitySeed != 100 || id.IdentityIncrement != 5 || id.Nullable {
+		t.Errorf("Id = %+v", id)
+	}
+	if name.Type != "nvarchar" || name.MaxLength != 200 || name.Default != "(N'none')" {
+		t.Errorf("Name = %+v", name)
+	}
+	if phone.Type != "dbo.phonenumber" || !phone.Nullable {
+		t.Errorf("Phone = %+v", phone)
+	}
+	if upper.Type != "" || upper.Computed != "UPPER([Name])" {
+		t.Errorf("Upper = %+v", upper)
+	}
+	if pk := customers.PrimaryKey; pk == nil || pk.Name != "PK_Customers" || pk.Columns[0] != "Id" {
+		t.Errorf("PrimaryKey = %+v", pk)
+	}
+	if len(customers.Indexes) != 1 || customers.Indexes[0].Name != "UQ_Customers_1" || !customers.Indexes[0].Constraint {
+		t.Errorf("Indexes = %+v", customers.Indexes)
+	}
+
+	if total := orders.Columns[1]; total.Precision != 10 || total.Scale != 2 {
+		t.Errorf("Total = %+v", total)
+	}
+	if len(orders.Indexes) != 1 || orders.Indexes[0].Filter != "[Total] > 0" {
+		t.Errorf("Orders indexes = %+v", orders.Indexes)
+	}
+	if len(orders.ForeignKeys) != 1 {
+		t.Fatalf("Orders foreign keys = %+v", orders.ForeignKeys)
+	}
+	if fk := orders.ForeignKeys[0]; fk.RefTable != customers || fk.RefColumns[0] != "Id" || fk.OnDelete != "CASCADE" {
+		t.Errorf("foreign key = %+v", fk)
+	}
+	if len(orders.Checks) != 1 || orders.Checks[0] != "CK_Total" {
+		t.Errorf("Checks = %v", orders.Checks)
+	}
+
+	if len(p.Catalog.Modules) != 2 {
+		t.Fatalf("Modules = %+v", p.Catalog.Modules)
+	}
+	proc := p.Catalog.Modules[0]
+	want := "CREATE PROCEDURE [dbo].[GetCustomer]\r\n  @Id INT\r\nAS\nBEGIN\n  SELECT [Name] FROM [dbo].[Customers] WHERE [Id] = @Id\nEND"
+	if proc.Type != "P" || proc.Definition != want {
+		t.Errorf("procedure = %s %q", proc.Type, proc.Definition)
+	}
+	if trg := p.Catalog.Modules[1]; trg.Type != "TR" {
+		t.Errorf("trigger = %+v", trg)
+	}
+
+	if len(p.Incompatible) != 1 || p.Incompatible[0].Kind != "user" || p.Incompatible[0].Name != "app" {
+		t.Errorf("Incompatible = %+v", p.Incompatible)
+	}
+}
+
+func TestRead_Bacpac(t *testing.T) {
+	p := testPackage(t, map[string]string{
+		"model.xml": testModel,
+		"Data/dbo.Customers/TableData-000-00000.BCP": "",
+		"Data/sales.Orders/TableData-000-00000.BCP":  "",
+	})
+	if len(p.DataTables) != 2 || p.DataTables[0] != "dbo.Customers" {
+		t.Errorf("DataTables = %v", p.DataTables)
+	}
+}
+
+func TestRead_NotAPackage(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.Create("readme.txt")
+	zw.Close()
+	if _, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+		t.Error("expected an error for a zip without model.xml")
+	}
+}
+
+func TestNameParts(t *testing.T) {
+	tests := map[string]string{
+		"[dbo].[Orders].[Id]": "dbo|Orders|Id",
+		"[a]]b].[c.d]":        "a]b|c.d",
+		"dbo.Orders":          "dbo|Orders",
+		"":                    "",
+	}
+	for in, want := range tests {
+		parts := nameParts(in)
+		got := ""
+		for i, p := range parts {
+			if i > 0 {
+				got += "|"
+			}
+			got += p
+		}
+		if got != want {
+			t.Errorf("nameParts(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
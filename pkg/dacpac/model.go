@@ -0,0 +1,130 @@
+package dacpac
+
+// The structure of model.xml. Every element has the same shape: its
+// scalar properties, and relationships whose entries either nest further
+// elements (a table's columns) or refer to elements elsewhere by name (a
+// constraint's table).
+
+type model struct {
+	Header struct {
+		CustomData []struct {
+			Category string `xml:"Category,attr"`
+			Metadata []struct {
+				Name  string `xml:"Name,attr"`
+				Value string `xml:"Value,attr"`
+			} `xml:"Metadata"`
+		} `xml:"CustomData"`
+	} `xml:"Header"`
+	Elements []element `xml:"Model>Element"`
+}
+
+type element struct {
+	Type          string         `xml:"Type,attr"`
+	Name          string         `xml:"Name,attr"`
+	Properties    []property     `xml:"Property"`
+	Relationships []relationship `xml:"Relationship"`
+	Annotations   []annotation   `xml:"Annotation"`
+}
+
+// property holds its value in an attribute, or for scripts in a child
+// element.
+type property struct {
+	Name   string `xml:"Name,attr"`
+	Value  string `xml:"Value,attr"`
+	Script string `xml:"Value"`
+}
+
+type relationship struct {
+	Name    string  `xml:"Name,attr"`
+	Entries []entry `xml:"Entry"`
+}
+
+type entry struct {
+	Elements   []*element  `xml:"Element"`
+	References []reference `xml:"References"`
+}
+
+type reference struct {
+	Name           string `xml:"Name,attr"`
+	ExternalSource string `xml:"ExternalSource,attr"`
+}
+
+type annotation struct {
+	Type       string     `xml:"Type,attr"`
+	Properties []property `xml:"Property"`
+}
+
+func propertyValue(props []property, name string) string {
+	for _, p := range props {
+		if p.Name == name {
+			if p.Value != "" {
+				return p.Value
+			}
+			return p.Script
+		}
+	}
+	return ""
+}
+
+func (e *element) property(name string) string {
+	return propertyValue(e.Properties, name)
+}
+
+func (a *annotation) property(name string) string {
+	return propertyValue(a.Properties, name)
+}
+
+func (e *element) relationship(name string) *relationship {
+	for i := range e.Relationships {
+		if e.Relationships[i].Name == name {
+			return &e.Relationships[i]
+		}
+	}
+	return nil
+}
+
+// elements returns the elements nested in a relationship.
+func (e *element) elements(rel string) []*element {
+	r := e.relationship(rel)
+	if r == nil {
+		return nil
+	}
+	var out []*element
+	for _, en := range r.Entries {
+		out = append(out, en.Elements...)
+	}
+	return out
+}
+
+// references returns the names a relationship refers to.
+func (e *element) references(rel string) []string {
+	r := e.relationship(rel)
+	if r == nil {
+		return nil
+	}
+	var out []string
+	for _, en := range r.Entries {
+		for _, ref := range en.References {
+			out = append(out, ref.Name)
+		}
+	}
+	return out
+}
+
+// reference returns the single name a relationship refers to.
+func (e *element) reference(rel string) string {
+	if refs := e.references(rel); len(refs) > 0 {
+		return refs[0]
+	}
+	return ""
+}
+
+// columnSpecs returns the column names of a key or index, which nest one
+// SqlIndexedColumnSpecification per column.
+func (e *element) columnSpecs() []string {
+	var names []string
+	for _, spec := range e.elements("ColumnSpecifications") {
+		names = append(names, lastParts(spec.references("Column"))...)
+	}
+	return names
+}
@@ -0,0 +1,395 @@
+// Package erasure plans the erasure of a data subject's personal data, as
+// the GDPR's right to erasure asks.
+//
+// A map, read from a JSON file at startup, lists the tables holding
+// personal data, the columns that identify a subject in each, and whether
+// a subject's rows are deleted or have their personal columns
+// overwritten. Given the keys that identify one subject, such as a
+// customer ID and an email address, a plan holds the DELETE and UPDATE
+// statements that erase them, in the map's order, so that rows referring
+// to others are erased before those they refer to, with the keys bound to
+// them as parameters. The server runs a plan in one transaction, or as a
+// dry run, and records a certificate of what it erased in the audit log.
+package erasure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// Actions a table entry takes on a subject's rows.
+const (
+	ActionDelete = "delete" // Delete the rows
+	ActionUpdate = "update" // Overwrite the columns of Set
+)
+
+// Config is the erasure map, and the key subjects are fingerprinted with.
+type Config struct {
+	Tables []Table `json:"tables"`
+	Key    []byte  `json:"-"` // Read from a file of its own (see LoadKey)
+}
+
+// MinKeyLength is the fewest bytes a fingerprint key may have.
+const MinKeyLength = 32
+
+// Table is where a table holds personal data. Keys maps the columns that
+// identify a subject's rows to the names of the subject keys they hold:
+// {"CustomerID": "customer_id"} matches the rows whose CustomerID is the
+// subject's customer_id. Set gives an update's columns their new values.
+type Table struct {
+	Table  string                 `json:"table"` // [schema.]table
+	Keys   map[string]string      `json:"keys"`
+	Action string                 `json:"action"`
+	Set    map[string]interface{} `json:"set,omitempty"`
+}
+
+// Request asks for one subject's erasure.
+type Request struct {
+	Keys      map[string]interface{} `json:"keys"`                // Subject keys, by the names the map uses
+	Database  string                 `json:"database,omitempty"`  // Database to erase from ("" = the default)
+	Tenant    string                 `json:"tenant,omitempty"`    // Tenant to erase from, when multi-tenant
+	Reference string                 `json:"reference,omitempty"` // The request's case or ticket, for the certificate
+	DryRun    bool                   `json:"dry_run,omitempty"`   // Count the rows, then roll back
+}
+
+// Step is one statement of a plan, naming the subject keys by the
+// parameters of the plan they are bound to. A table whose keys the
+// request does not all give is skipped.
+type Step struct {
+	Table     string
+	Action    string
+	Statement string
+	Skipped   string // Why the table is skipped ("" = it is not)
+}
+
+// Plan is what erases one subject.
+type Plan struct {
+	Subject    string // Fingerprint of the subject keys
+	Steps      []Step
+	Parameters map[string]interface{} // The subject keys, as @key1, @key2, ...
+}
+
+// Result is the outcome of an erasure: its certificate, and the
+// statements it ran.
+type Result struct {
+	Certificate Certificate `json:"certificate"`
+	Statements  []string    `json:"statements"`
+}
+
+// Certificate records an erasure. The subject is identified by a
+// fingerprint of its keys, keyed with the server's secret key, rather
+// than by the keys themselves: without the secret, the fingerprint of a
+// guessed key, such as an email address or customer number, cannot be
+// computed to find the subject's certificates, but the same keys always
+// give the same fingerprint. Digest seals the rest, so that a copy can be
+// checked against the audit log.
+type Certificate struct {
+	ID        string        `json:"id"`
+	IssuedAt  time.Time     `json:"issued_at"`
+	Subject   string        `json:"subject"`
+	Reference string        `json:"reference,omitempty"`
+	Database  string        `json:"database,omitempty"`
+	Tenant    string        `json:"tenant,omitempty"`
+	DryRun    bool          `json:"dry_run"`
+	Tables    []TableResult `json:"tables"`
+	TotalRows int64         `json:"total_rows"`
+	Digest    string        `json:"digest"`
+}
+
+// TableResult is what an erasure did to one table.
+type TableResult struct {
+	Table   string `json:"table"`
+	Action  string `json:"action"`
+	Rows    int64  `json:"rows"`
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// LoadConfig reads an erasure map.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigMissing,
+			"failed to read erasure map").
+			WithOp("erasure.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigParse,
+			"failed to parse erasure map").
+			WithOp("erasure.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	return cfg, nil
+}
+
+// LoadKey reads the secret key fingerprints are keyed with: the contents
+// of a file, less surrounding white space, at least MinKeyLength bytes.
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigMissing,
+			"failed to read erasure key").
+			WithOp("erasure.LoadKey").
+			WithField("path", path).
+			Err()
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < MinKeyLength {
+		return nil, aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+			"erasure key has %d bytes, want at least %d", len(key), MinKeyLength).
+			WithOp("erasure.LoadKey").
+			WithField("path", path).
+			Err()
+	}
+	return key, nil
+}
+
+// Validate checks the map's tables, columns and actions, and that a map
+// has a key.
+func (c Config) Validate() error {
+	for _, t := range c.Tables {
+		if err := t.validate(); err != nil {
+			return aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid, "invalid erasure map").
+				WithOp("erasure.Config.Validate").
+				Err()
+		}
+	}
+	if len(c.Tables) > 0 && len(c.Key) < MinKeyLength {
+		return aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+			"erasure map needs a fingerprint key of at least %d bytes", MinKeyLength).
+			WithOp("erasure.Config.Validate").
+			Err()
+	}
+	return nil
+}
+
+func (t Table) validate() error {
+	parts := strings.Split(t.Table, ".")
+	if len(parts) > 2 {
+		return fmt.Errorf("table %q: want [schema.]table", t.Table)
+	}
+	for _, part := range parts {
+		if !validName(part) {
+			return fmt.Errorf("invalid table name %q", t.Table)
+		}
+	}
+	if len(t.Keys) == 0 {
+		return fmt.Errorf("table %s: no keys", t.Table)
+	}
+	for column, key := range t.Keys {
+		if !validName(column) || key == "" {
+			return fmt.Errorf("table %s: invalid key %q: %q", t.Table, column, key)
+		}
+	}
+	switch t.Action {
+	case ActionDelete:
+		if len(t.Set) > 0 {
+			return fmt.Errorf("table %s: set is only for %q", t.Table, ActionUpdate)
+		}
+	case ActionUpdate:
+		if len(t.Set) == 0 {
+			return fmt.Errorf("table %s: %q needs set", t.Table, ActionUpdate)
+		}
+		for column, value := range t.Set {
+			if !validName(column) {
+				return fmt.Errorf("table %s: invalid column %q", t.Table, column)
+			}
+			if _, err := literal(value); err != nil {
+				return fmt.Errorf("table %s: column %s: %w", t.Table, column, err)
+			}
+		}
+	default:
+		return fmt.Errorf("table %s: action must be %q or %q, not %q", t.Table, ActionDelete, ActionUpdate, t.Action)
+	}
+	return nil
+}
+
+// validName reports whether s can be a bracketed identifier.
+func validName(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "[]")
+}
+
+// Plan returns the statements that erase the subject keys identify, and
+// the keys as the parameters they name. Every key must be one the map
+// uses.
+func (c Config) Plan(keys map[string]interface{}) (*Plan, error) {
+	if len(c.Tables) == 0 {
+		return nil, aulerrors.New(aulerrors.ErrCodeConfigMissing, "no erasure map is configured").
+			WithOp("erasure.Config.Plan").
+			Err()
+	}
+	if len(keys) == 0 {
+		return nil, invalidRequest("keys are required")
+	}
+	used := make(map[string]bool)
+	for _, t := range c.Tables {
+		for _, key := range t.Keys {
+			used[key] = true
+		}
+	}
+	plan := &Plan{Subject: Fingerprint(c.Key, keys), Parameters: make(map[string]interface{}, len(keys))}
+	params := make(map[string]string, len(keys)) // Maps keys to the parameters bound to them
+	for _, key := range sortedKeys(keys) {
+		if !used[key] {
+			return nil, invalidRequest(fmt.Sprintf("key %q is not in the erasure map", key))
+		}
+		if keys[key] == nil {
+			return nil, invalidRequest(fmt.Sprintf("key %q is null", key))
+		}
+		value, err := parameter(keys[key])
+		if err != nil {
+			return nil, invalidRequest(fmt.Sprintf("key %q: %v", key, err))
+		}
+		params[key] = fmt.Sprintf("@key%d", len(params)+1)
+		plan.Parameters[params[key]] = value
+	}
+
+	for _, t := range c.Tables {
+		step := Step{Table: t.Table, Action: t.Action}
+		var where, missing []string
+		for _, column := range sortedKeys(t.Keys) {
+			param, ok := params[t.Keys[column]]
+			if !ok {
+				missing = append(missing, t.Keys[column])
+				continue
+			}
+			where = append(where, quoteName(column)+" = "+param)
+		}
+		if len(missing) > 0 {
+			step.Skipped = "no " + strings.Join(missing, ", ")
+			plan.Steps = append(plan.Steps, step)
+			continue
+		}
+		if t.Action == ActionDelete {
+			step.Statement = "DELETE FROM " + quoteName(t.Table) + " WHERE " + strings.Join(where, " AND ")
+		} else {
+			var set []string
+			for _, column := range sortedKeys(t.Set) {
+				lit, _ := literal(t.Set[column])
+				set = append(set, quoteName(column)+" = "+lit)
+			}
+			step.Statement = "UPDATE " + quoteName(t.Table) + " SET " + strings.Join(set, ", ") +
+				" WHERE " + strings.Join(where, " AND ")
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+// Statements returns the statements of the steps not skipped.
+func (p *Plan) Statements() []string {
+	var stmts []string
+	for _, step := range p.Steps {
+		if step.Skipped == "" {
+			stmts = append(stmts, step.Statement)
+		}
+	}
+	return stmts
+}
+
+// Fingerprint identifies a subject by its keys: the hex HMAC-SHA256,
+// keyed with key, of the keys and their values, sorted by key.
+func Fingerprint(key []byte, keys map[string]interface{}) string {
+	h := hmac.New(sha256.New, key)
+	for _, key := range sortedKeys(keys) {
+		fmt.Fprintf(h, "%s=%v\n", key, keys[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Seal sets c's digest: the hex SHA-256 of c as JSON without it.
+func (c *Certificate) Seal() {
+	c.Digest = ""
+	b, _ := json.Marshal(c)
+	sum := sha256.Sum256(b)
+	c.Digest = hex.EncodeToString(sum[:])
+}
+
+// parameter converts a JSON value to the Go value a parameter is bound to:
+// a number to an integer if it is one, and a float if not.
+func parameter(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string, float64, int, int64, bool:
+		return v, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", v)
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v (want a string, number or boolean)", v)
+}
+
+// literal renders a JSON value as a T-SQL literal, for the values of an
+// update's Set, which come from the map rather than a request. Strings
+// are plain quoted literals, without N, which every backend reads.
+func literal(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	case json.Number:
+		if _, err := strconv.ParseFloat(string(v), 64); err != nil {
+			return "", fmt.Errorf("invalid number %s", v)
+		}
+		return string(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	}
+	return "", fmt.Errorf("unsupported value %v (want a string, number, boolean or null)", v)
+}
+
+// quoteName brackets each part of a [schema.]name.
+func quoteName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = "[" + part + "]"
+	}
+	return strings.Join(parts, ".")
+}
+
+func invalidRequest(msg string) error {
+	return aulerrors.New(aulerrors.ErrCodeProcInvalidParam, msg).
+		WithOp("erasure.Config.Plan").
+		Err()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,157 @@
+package erasure
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// testKey is a fingerprint key.
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// testConfig overwrites where a customer's orders were shipped, deletes
+// the customer, and deletes a newsletter subscription by email.
+var testConfig = Config{Tables: []Table{
+	{Table: "dbo.Orders", Keys: map[string]string{"CustomerID": "customer_id"}, Action: ActionUpdate,
+		Set: map[string]interface{}{"ShipName": "erased", "ShipAddress": nil}},
+	{Table: "Customers", Keys: map[string]string{"ID": "customer_id"}, Action: ActionDelete},
+	{Table: "Subscribers", Keys: map[string]string{"Email": "email"}, Action: ActionDelete},
+}, Key: testKey}
+
+func TestPlan(t *testing.T) {
+	plan, err := testConfig.Plan(map[string]interface{}{"customer_id": json.Number("42")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Step{
+		{Table: "dbo.Orders", Action: ActionUpdate,
+			Statement: "UPDATE [dbo].[Orders] SET [ShipAddress] = NULL, [ShipName] = 'erased' WHERE [CustomerID] = @key1"},
+		{Table: "Customers", Action: ActionDelete, Statement: "DELETE FROM [Customers] WHERE [ID] = @key1"},
+		{Table: "Subscribers", Action: ActionDelete, Skipped: "no email"},
+	}
+	if !reflect.DeepEqual(plan.Steps, want) {
+		t.Errorf("steps\n%+v\nwant\n%+v", plan.Steps, want)
+	}
+	if got := plan.Statements(); len(got) != 2 {
+		t.Errorf("statements %q", got)
+	}
+	if want := map[string]interface{}{"@key1": int64(42)}; !reflect.DeepEqual(plan.Parameters, want) {
+		t.Errorf("parameters %v, want %v", plan.Parameters, want)
+	}
+
+	// Values are bound, never written into the statements
+	email := "o'brien@example.com'; DROP TABLE x; --"
+	plan, err = testConfig.Plan(map[string]interface{}{"email": email, "customer_id": json.Number("4.5")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.Steps[2].Statement; got != "DELETE FROM [Subscribers] WHERE [Email] = @key2" {
+		t.Errorf("statement %s", got)
+	}
+	if want := map[string]interface{}{"@key1": 4.5, "@key2": email}; !reflect.DeepEqual(plan.Parameters, want) {
+		t.Errorf("parameters %v, want %v", plan.Parameters, want)
+	}
+
+	// The fingerprint depends on the keys and the secret key alone
+	keys := map[string]interface{}{"customer_id": 42, "email": "a@example.com"}
+	a := Fingerprint(testKey, keys)
+	b := Fingerprint(testKey, map[string]interface{}{"email": "a@example.com", "customer_id": 42})
+	c := Fingerprint(testKey, map[string]interface{}{"customer_id": 43, "email": "a@example.com"})
+	d := Fingerprint([]byte("another key of thirty-two bytes!"), keys)
+	if a != b || a == c || a == d || len(a) != 64 {
+		t.Errorf("fingerprints %s %s %s %s", a, b, c, d)
+	}
+	if plan, _ := testConfig.Plan(keys); plan.Subject != a {
+		t.Errorf("subject %s, want %s", plan.Subject, a)
+	}
+
+	for _, tc := range []struct {
+		keys map[string]interface{}
+		want string
+	}{
+		{nil, "keys are required"},
+		{map[string]interface{}{"customer": 42}, `key "customer" is not in the erasure map`},
+		{map[string]interface{}{"customer_id": nil}, `key "customer_id" is null`},
+		{map[string]interface{}{"customer_id": []interface{}{1}}, "unsupported value"},
+	} {
+		_, err := testConfig.Plan(tc.keys)
+		if aulerrors.GetCode(err) != aulerrors.ErrCodeProcInvalidParam || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: got %v, want %q", tc.keys, err, tc.want)
+		}
+	}
+	if _, err := (Config{}).Plan(map[string]interface{}{"customer_id": 1}); aulerrors.GetCode(err) != aulerrors.ErrCodeConfigMissing {
+		t.Errorf("no map: got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testConfig.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]string{"ID": "customer_id"}
+	for _, tc := range []struct {
+		table Table
+		want  string
+	}{
+		{Table{Table: "a.b.c", Keys: keys, Action: ActionDelete}, "want [schema.]table"},
+		{Table{Table: "[Customers]", Keys: keys, Action: ActionDelete}, "invalid table name"},
+		{Table{Table: "Customers", Action: ActionDelete}, "no keys"},
+		{Table{Table: "Customers", Keys: keys, Action: "purge"}, "action must be"},
+		{Table{Table: "Customers", Keys: keys, Action: ActionUpdate}, "needs set"},
+		{Table{Table: "Customers", Keys: keys, Action: ActionDelete, Set: map[string]interface{}{"Name": ""}}, "set is only for"},
+		{Table{Table: "Customers", Keys: keys, Action: ActionUpdate, Set: map[string]interface{}{"Name": map[string]interface{}{}}}, "unsupported value"},
+	} {
+		err := Config{Tables: []Table{tc.table}, Key: testKey}.Validate()
+		if aulerrors.GetCode(err) != aulerrors.ErrCodeConfigInvalid || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: got %v, want %q", tc.table, err, tc.want)
+		}
+	}
+	err := Config{Tables: testConfig.Tables, Key: []byte("short")}.Validate()
+	if aulerrors.GetCode(err) != aulerrors.ErrCodeConfigInvalid || !strings.Contains(err.Error(), "fingerprint key") {
+		t.Errorf("short key: got %v", err)
+	}
+	if err := (Config{}).Validate(); err != nil {
+		t.Errorf("no map: %v", err)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key")
+	if err := os.WriteFile(path, append(testKey, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadKey(path)
+	if err != nil || string(key) != string(testKey) {
+		t.Errorf("key %q, %v", key, err)
+	}
+	if err := os.WriteFile(path, []byte("short\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKey(path); aulerrors.GetCode(err) != aulerrors.ErrCodeConfigInvalid {
+		t.Errorf("short key: got %v", err)
+	}
+	if _, err := LoadKey(filepath.Join(dir, "missing")); aulerrors.GetCode(err) != aulerrors.ErrCodeConfigMissing {
+		t.Errorf("missing file: got %v", err)
+	}
+}
+
+func TestCertificateSeal(t *testing.T) {
+	cert := Certificate{ID: "x", Subject: "s", Tables: []TableResult{{Table: "Customers", Action: ActionDelete, Rows: 1}}, TotalRows: 1}
+	cert.Seal()
+	sealed := cert.Digest
+	cert.Seal()
+	if cert.Digest != sealed || len(sealed) != 64 {
+		t.Errorf("digest %q, then %q", sealed, cert.Digest)
+	}
+	cert.Tables[0].Rows = 2
+	cert.Seal()
+	if cert.Digest == sealed {
+		t.Error("digest unchanged by a changed count")
+	}
+}
@@ -64,6 +64,10 @@ const (
 	ErrCodeExecSQLError      Code = 4006
 	ErrCodeExecInvalidState  Code = 4007
 	ErrCodeExecNoTransaction Code = 4008
+	ErrCodeExecCircuitOpen   Code = 4009
+	ErrCodeExecDeadlock      Code = 4010
+	ErrCodeExecContractViolation Code = 4011
+	ErrCodeExecDenied        Code = 4012
 
 	// Storage errors (5xxx)
 	ErrCodeStorageConnect    Code = 5001
@@ -495,6 +499,31 @@ func GetFields(err error) map[string]interface{} {
 	return nil
 }
 
+// Fields read by the wire protocols when reporting an error to a client.
+// Errors without them are reported as user error 50000, severity 16.
+const (
+	FieldSQLErrorNumber = "sql_error_number" // int32
+	FieldSQLSeverity    = "sql_severity"     // uint8
+	FieldSQLState       = "sql_state"        // string, PostgreSQL SQLSTATE
+)
+
+// FindSQLError returns the first error in err's chain that carries an SQL
+// error number or SQLSTATE, or nil. Wrapping errors add context for logs,
+// but the client should see the error that chose the number.
+func FindSQLError(err error) *Error {
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			_, hasNumber := e.Fields[FieldSQLErrorNumber]
+			_, hasState := e.Fields[FieldSQLState]
+			if hasNumber || hasState {
+				return e
+			}
+		}
+		err = errors.Unwrap(err)
+	}
+	return nil
+}
+
 // IsCode checks if an error has a specific code.
 func IsCode(err error, code Code) bool {
 	return GetCode(err) == code
@@ -0,0 +1,522 @@
+// Package extensions runs scalar functions compiled to WebAssembly, so
+// that T-SQL can call custom logic without native plugins.
+//
+// Each .wasm module in the extensions directory is compiled at startup and
+// its exported functions are registered as external functions of
+// tsqlruntime, callable as name(...) or dbo.name(...). Modules run in a
+// sandbox: they see no files, environment or network (WASI is provided,
+// with none of them), their memory is limited, and a call that runs past
+// its time limit is stopped.
+//
+// A module's functions take and return numbers, typed by their WebAssembly
+// signature: i32 is INT, i64 BIGINT, f32 REAL and f64 FLOAT. A manifest
+// beside the module, with the same name and the extension .json, declares
+// the functions instead, and may use strings and binary data:
+//
+//	{"functions": {"slugify": {"params": ["nvarchar"], "returns": "nvarchar"}}}
+//
+// Such a module exports alloc(size i32) i32, returning memory for the
+// server to write an argument to. A string or binary argument is passed as
+// two i32s, its address and length, and a string or binary result is
+// returned as an i64, the address shifted left 32 bits or'd with the
+// length. Strings are UTF-8. A call with a NULL argument returns NULL
+// without calling the function.
+package extensions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// Defaults of Config.
+const (
+	DefaultMemoryLimit = 16 << 20
+	DefaultTimeout     = time.Second
+)
+
+// wasmPageSize is the size of a page of WebAssembly memory.
+const wasmPageSize = 64 << 10
+
+// Config is where extensions are loaded from and the limits they run
+// under.
+type Config struct {
+	Dir         string        // Directory of .wasm modules
+	MemoryLimit int64         // Memory of each module instance, in bytes (0 = DefaultMemoryLimit)
+	Timeout     time.Duration // Longest a call may run (0 = DefaultTimeout)
+}
+
+// Function is a function an extension provides.
+type Function struct {
+	Module  string   // File name of the module, without .wasm
+	Name    string   // Name T-SQL calls it by
+	Params  []string // SQL types of the arguments
+	Returns string   // SQL type of the result
+}
+
+// Extensions are the loaded modules, whose functions are registered until
+// Close.
+type Extensions struct {
+	runtime   wazero.Runtime
+	functions []Function
+}
+
+// manifest is the declaration of a module's functions.
+type manifest struct {
+	Functions map[string]signature `json:"functions"`
+}
+
+type signature struct {
+	Params  []string `json:"params"`
+	Returns string   `json:"returns"`
+}
+
+// sqlTypes are the SQL types of arguments and results, and the values of
+// the WebAssembly parameters each is passed as.
+var sqlTypes = map[string][]api.ValueType{
+	"int":       {api.ValueTypeI32},
+	"bigint":    {api.ValueTypeI64},
+	"real":      {api.ValueTypeF32},
+	"float":     {api.ValueTypeF64},
+	"bit":       {api.ValueTypeI32},
+	"varchar":   {api.ValueTypeI32, api.ValueTypeI32},
+	"nvarchar":  {api.ValueTypeI32, api.ValueTypeI32},
+	"varbinary": {api.ValueTypeI32, api.ValueTypeI32},
+}
+
+// resultType is the WebAssembly result of a SQL type.
+func resultType(sqlType string) api.ValueType {
+	switch sqlType {
+	case "varchar", "nvarchar", "varbinary":
+		return api.ValueTypeI64
+	}
+	return sqlTypes[sqlType][0]
+}
+
+// isData reports whether values of sqlType are passed in memory.
+func isData(sqlType string) bool {
+	return len(sqlTypes[sqlType]) == 2
+}
+
+// Load compiles the modules in cfg.Dir and registers their functions. It
+// fails if a module does not compile, declares a function it does not
+// export, or provides a function whose name is taken.
+func Load(ctx context.Context, cfg Config) (*Extensions, error) {
+	if cfg.MemoryLimit <= 0 {
+		cfg.MemoryLimit = DefaultMemoryLimit
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	paths, err := filepath.Glob(filepath.Join(cfg.Dir, "*.wasm"))
+	if err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid,
+			"failed to list extensions").
+			WithOp("extensions.Load").
+			WithField("dir", cfg.Dir).
+			Err()
+	}
+	sort.Strings(paths)
+
+	pages := uint32(cfg.MemoryLimit / wasmPageSize)
+	if pages == 0 {
+		pages = 1
+	}
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		rt.Close(ctx)
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeInternal,
+			"failed to instantiate WASI").
+			WithOp("extensions.Load").
+			Err()
+	}
+
+	e := &Extensions{runtime: rt}
+	for _, path := range paths {
+		if err := e.load(ctx, path, cfg.Timeout); err != nil {
+			e.Close(ctx)
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// load compiles the module at path and registers its functions.
+func (e *Extensions) load(ctx context.Context, path string, timeout time.Duration) error {
+	name := strings.TrimSuffix(filepath.Base(path), ".wasm")
+	fail := func(err error, format string, args ...interface{}) error {
+		return aulerrors.Wrapf(err, aulerrors.ErrCodeConfigInvalid, format, args...).
+			WithOp("extensions.Load").
+			WithField("module", name).
+			Err()
+	}
+
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return fail(err, "failed to read extension %s", name)
+	}
+	compiled, err := e.runtime.CompileModule(ctx, bin)
+	if err != nil {
+		return fail(err, "failed to compile extension %s", name)
+	}
+	exports := compiled.ExportedFunctions()
+
+	sigs, err := readManifest(strings.TrimSuffix(path, ".wasm") + ".json")
+	if err != nil {
+		return fail(err, "failed to read the manifest of extension %s", name)
+	}
+	if sigs == nil {
+		sigs = inferSignatures(exports)
+	}
+
+	m := &module{name: name, runtime: e.runtime, compiled: compiled, timeout: timeout}
+	names := make([]string, 0, len(sigs))
+	for fn := range sigs {
+		names = append(names, fn)
+	}
+	sort.Strings(names)
+	for _, fn := range names {
+		sig := sigs[fn]
+		if err := checkSignature(fn, sig, exports); err != nil {
+			return fail(err, "extension %s: function %s", name, fn)
+		}
+		f := &function{module: m, name: fn, sig: sig}
+		if err := tsqlruntime.RegisterExternalFunction(fn, f.call); err != nil {
+			return fail(err, "extension %s", name)
+		}
+		e.functions = append(e.functions, Function{Module: name, Name: fn, Params: sig.Params, Returns: sig.Returns})
+	}
+	return nil
+}
+
+// readManifest reads the manifest at path; nil when there is none.
+func readManifest(path string) (map[string]signature, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	for name, sig := range m.Functions {
+		for i, p := range sig.Params {
+			sig.Params[i] = strings.ToLower(p)
+		}
+		sig.Returns = strings.ToLower(sig.Returns)
+		m.Functions[name] = sig
+	}
+	if m.Functions == nil {
+		m.Functions = map[string]signature{}
+	}
+	return m.Functions, nil
+}
+
+// inferSignatures returns the signatures of the exported functions that
+// take and return numbers, passing over alloc and those whose names begin
+// with an underscore, such as _initialize.
+func inferSignatures(exports map[string]api.FunctionDefinition) map[string]signature {
+	numeric := map[api.ValueType]string{
+		api.ValueTypeI32: "int",
+		api.ValueTypeI64: "bigint",
+		api.ValueTypeF32: "real",
+		api.ValueTypeF64: "float",
+	}
+	sigs := map[string]signature{}
+exports:
+	for name, def := range exports {
+		if name == "alloc" || strings.HasPrefix(name, "_") || len(def.ResultTypes()) != 1 {
+			continue
+		}
+		sig := signature{Returns: numeric[def.ResultTypes()[0]]}
+		if sig.Returns == "" {
+			continue
+		}
+		for _, t := range def.ParamTypes() {
+			p := numeric[t]
+			if p == "" {
+				continue exports
+			}
+			sig.Params = append(sig.Params, p)
+		}
+		sigs[name] = sig
+	}
+	return sigs
+}
+
+// checkSignature reports how the export of a declared function differs
+// from its declaration, if it does.
+func checkSignature(name string, sig signature, exports map[string]api.FunctionDefinition) error {
+	def, ok := exports[name]
+	if !ok {
+		return fmt.Errorf("not exported")
+	}
+	var params []api.ValueType
+	data := false
+	for _, p := range sig.Params {
+		types, ok := sqlTypes[p]
+		if !ok {
+			return fmt.Errorf("unknown type %q", p)
+		}
+		params = append(params, types...)
+		data = data || isData(p)
+	}
+	if _, ok := sqlTypes[sig.Returns]; !ok {
+		return fmt.Errorf("unknown type %q", sig.Returns)
+	}
+	if !equalTypes(def.ParamTypes(), params) || !equalTypes(def.ResultTypes(), []api.ValueType{resultType(sig.Returns)}) {
+		return fmt.Errorf("exported as (%s) -> (%s), not as declared",
+			typeNames(def.ParamTypes()), typeNames(def.ResultTypes()))
+	}
+	if data {
+		alloc, ok := exports["alloc"]
+		if !ok || !equalTypes(alloc.ParamTypes(), []api.ValueType{api.ValueTypeI32}) ||
+			!equalTypes(alloc.ResultTypes(), []api.ValueType{api.ValueTypeI32}) {
+			return fmt.Errorf("takes strings or binary data, but alloc(i32) i32 is not exported")
+		}
+	}
+	return nil
+}
+
+func equalTypes(a, b []api.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func typeNames(types []api.ValueType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = api.ValueTypeName(t)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Functions returns the functions the extensions provide.
+func (e *Extensions) Functions() []Function {
+	return e.functions
+}
+
+// Close unregisters the extensions' functions and frees their modules.
+func (e *Extensions) Close(ctx context.Context) error {
+	for _, f := range e.functions {
+		tsqlruntime.UnregisterExternalFunction(f.Name)
+	}
+	e.functions = nil
+	return e.runtime.Close(ctx)
+}
+
+// module is a compiled module and its idle instances. An instance runs
+// one call at a time, so concurrent calls each take their own.
+type module struct {
+	name     string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	idle []api.Module
+}
+
+// acquire returns an idle instance, or a new one.
+func (m *module) acquire(ctx context.Context) (api.Module, error) {
+	m.mu.Lock()
+	if n := len(m.idle); n > 0 {
+		inst := m.idle[n-1]
+		m.idle = m.idle[:n-1]
+		m.mu.Unlock()
+		return inst, nil
+	}
+	m.mu.Unlock()
+	// Anonymous, so that the module can be instantiated more than once;
+	// reactor modules (TinyGo, Rust) initialise in _initialize
+	return m.runtime.InstantiateModule(ctx, m.compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+}
+
+// release returns an instance a call succeeded on to the idle ones.
+func (m *module) release(inst api.Module) {
+	m.mu.Lock()
+	m.idle = append(m.idle, inst)
+	m.mu.Unlock()
+}
+
+// function is a function of a module, as tsqlruntime calls it.
+type function struct {
+	module *module
+	name   string
+	sig    signature
+}
+
+// call calls the function for a statement running under parent, stopping
+// it when parent is cancelled or the call runs past the module's timeout.
+func (f *function) call(parent context.Context, args []tsqlruntime.Value) (tsqlruntime.Value, error) {
+	if len(args) != len(f.sig.Params) {
+		return tsqlruntime.Value{}, fmt.Errorf("function %s takes %d arguments, not %d", f.name, len(f.sig.Params), len(args))
+	}
+	for _, arg := range args {
+		if arg.IsNull {
+			return tsqlruntime.Null(nullType(f.sig.Returns)), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(parent, f.module.timeout)
+	defer cancel()
+	inst, err := f.module.acquire(ctx)
+	if err != nil {
+		return tsqlruntime.Value{}, f.failed(parent, ctx, err)
+	}
+	result, err := f.invoke(ctx, inst, args)
+	if err != nil {
+		// A trap or a stop may leave the instance's memory inconsistent
+		inst.Close(context.Background())
+		return tsqlruntime.Value{}, f.failed(parent, ctx, err)
+	}
+	f.module.release(inst)
+	return result, nil
+}
+
+// invoke calls the function on inst.
+func (f *function) invoke(ctx context.Context, inst api.Module, args []tsqlruntime.Value) (tsqlruntime.Value, error) {
+	params := make([]uint64, 0, 2*len(args))
+	for i, arg := range args {
+		switch f.sig.Params[i] {
+		case "int", "bit":
+			params = append(params, api.EncodeI32(int32(arg.AsInt())))
+		case "bigint":
+			params = append(params, api.EncodeI64(arg.AsInt()))
+		case "real":
+			params = append(params, api.EncodeF32(float32(arg.AsFloat())))
+		case "float":
+			params = append(params, api.EncodeF64(arg.AsFloat()))
+		default:
+			ptr, err := f.write(ctx, inst, argBytes(arg))
+			if err != nil {
+				return tsqlruntime.Value{}, err
+			}
+			params = append(params, uint64(ptr), uint64(len(argBytes(arg))))
+		}
+	}
+
+	results, err := inst.ExportedFunction(f.name).Call(ctx, params...)
+	if err != nil {
+		return tsqlruntime.Value{}, err
+	}
+	r := results[0]
+	switch f.sig.Returns {
+	case "int":
+		return tsqlruntime.NewInt(int64(api.DecodeI32(r))), nil
+	case "bit":
+		return tsqlruntime.NewBit(api.DecodeI32(r) != 0), nil
+	case "bigint":
+		return tsqlruntime.NewBigInt(int64(r)), nil
+	case "real":
+		return tsqlruntime.NewReal(api.DecodeF32(r)), nil
+	case "float":
+		return tsqlruntime.NewFloat(api.DecodeF64(r)), nil
+	}
+	ptr, n := uint32(r>>32), uint32(r)
+	data, ok := inst.Memory().Read(ptr, n)
+	if !ok {
+		return tsqlruntime.Value{}, fmt.Errorf("result out of memory bounds")
+	}
+	data = bytes.Clone(data)
+	switch f.sig.Returns {
+	case "varchar":
+		return tsqlruntime.NewVarChar(string(data), -1), nil
+	case "nvarchar":
+		return tsqlruntime.NewNVarChar(string(data), -1), nil
+	}
+	return tsqlruntime.NewVarBinary(data, -1), nil
+}
+
+// write copies data into memory the module allocates, returning its
+// address.
+func (f *function) write(ctx context.Context, inst api.Module, data []byte) (uint32, error) {
+	results, err := inst.ExportedFunction("alloc").Call(ctx, api.EncodeI32(int32(len(data))))
+	if err != nil {
+		return 0, err
+	}
+	ptr := api.DecodeU32(results[0])
+	if !inst.Memory().Write(ptr, data) {
+		return 0, fmt.Errorf("alloc returned memory out of bounds")
+	}
+	return ptr, nil
+}
+
+// failed returns the error a call under ctx, derived from parent, failed
+// with, as T-SQL reports it. When parent was cancelled, that is its error.
+func (f *function) failed(parent, ctx context.Context, err error) error {
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return aulerrors.Newf(aulerrors.ErrCodeExecTimeout,
+			"extension function %s ran past its %s limit", f.name, f.module.timeout).
+			WithOp("extensions.Call").
+			WithField("module", f.module.name).
+			Err()
+	}
+	return aulerrors.Wrapf(err, aulerrors.ErrCodeExecFailed,
+		"extension function %s failed", f.name).
+		WithOp("extensions.Call").
+		WithField("module", f.module.name).
+		Err()
+}
+
+// argBytes returns the bytes of a string or binary argument.
+func argBytes(v tsqlruntime.Value) []byte {
+	if b, ok := v.ToInterface().([]byte); ok {
+		return b
+	}
+	return []byte(v.AsString())
+}
+
+// nullType is the runtime type of a NULL result of sqlType.
+func nullType(sqlType string) tsqlruntime.DataType {
+	switch sqlType {
+	case "int":
+		return tsqlruntime.TypeInt
+	case "bigint":
+		return tsqlruntime.TypeBigInt
+	case "real":
+		return tsqlruntime.TypeReal
+	case "float":
+		return tsqlruntime.TypeFloat
+	case "bit":
+		return tsqlruntime.TypeBit
+	case "varchar":
+		return tsqlruntime.TypeVarChar
+	case "nvarchar":
+		return tsqlruntime.TypeNVarChar
+	}
+	return tsqlruntime.TypeVarBinary
+}
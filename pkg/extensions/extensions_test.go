@@ -0,0 +1,277 @@
+package extensions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/storage"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// testModule is a WebAssembly module, assembled by hand, exporting:
+//
+//	ext_add(i64, i64) i64           the sum
+//	ext_hypot(f64, f64) f64         the hypotenuse
+//	ext_spin() i32                  loops forever
+//	alloc(i32) i32                  a bump allocator
+//	ext_shout(i32, i32) i64         upper-cases an ASCII string in place
+//	ext_grow(i32) i32               memory.grow
+func testModule() []byte {
+	leb := func(n int) []byte {
+		var b []byte
+		for {
+			c := byte(n & 0x7f)
+			n >>= 7
+			if n != 0 {
+				c |= 0x80
+			}
+			b = append(b, c)
+			if n == 0 {
+				return b
+			}
+		}
+	}
+	vec := func(items ...[]byte) []byte {
+		b := leb(len(items))
+		for _, item := range items {
+			b = append(b, item...)
+		}
+		return b
+	}
+	section := func(id byte, payload []byte) []byte {
+		return append(append([]byte{id}, leb(len(payload))...), payload...)
+	}
+	name := func(s string) []byte {
+		return append(leb(len(s)), s...)
+	}
+	export := func(s string, kind, index byte) []byte {
+		return append(name(s), kind, index)
+	}
+	body := func(code ...byte) []byte {
+		return append(leb(len(code)), code...)
+	}
+
+	m := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	m = append(m, section(1, vec(
+		[]byte{0x60, 2, 0x7e, 0x7e, 1, 0x7e}, // 0: (i64, i64) i64
+		[]byte{0x60, 2, 0x7c, 0x7c, 1, 0x7c}, // 1: (f64, f64) f64
+		[]byte{0x60, 0, 1, 0x7f},             // 2: () i32
+		[]byte{0x60, 1, 0x7f, 1, 0x7f},       // 3: (i32) i32
+		[]byte{0x60, 2, 0x7f, 0x7f, 1, 0x7e}, // 4: (i32, i32) i64
+	))...)
+	m = append(m, section(3, vec([]byte{0}, []byte{1}, []byte{2}, []byte{3}, []byte{4}, []byte{3}))...)
+	m = append(m, section(5, vec([]byte{0x00, 1}))...)                         // One page
+	m = append(m, section(6, vec([]byte{0x7f, 1, 0x41, 0x80, 0x08, 0x0b}))...) // Heap from 1024
+	m = append(m, section(7, vec(
+		export("memory", 2, 0),
+		export("ext_add", 0, 0),
+		export("ext_hypot", 0, 1),
+		export("ext_spin", 0, 2),
+		export("alloc", 0, 3),
+		export("ext_shout", 0, 4),
+		export("ext_grow", 0, 5),
+	))...)
+	m = append(m, section(10, vec(
+		body(0, 0x20, 0, 0x20, 1, 0x7c, 0x0b),
+		body(0, 0x20, 0, 0x20, 0, 0xa2, 0x20, 1, 0x20, 1, 0xa2, 0xa0, 0x9f, 0x0b),
+		body(0, 0x03, 0x40, 0x0c, 0, 0x0b, 0x41, 0, 0x0b),
+		body(0, 0x23, 0, 0x23, 0, 0x20, 0, 0x6a, 0x24, 0, 0x0b),
+		body(1, 2, 0x7f, // Locals: i, c
+			0x02, 0x40, 0x03, 0x40, // block, loop
+			0x20, 2, 0x20, 1, 0x4f, 0x0d, 1, // i >= len: break
+			0x20, 0, 0x20, 2, 0x6a, // ptr + i
+			0x20, 0, 0x20, 2, 0x6a, 0x2d, 0, 0, 0x22, 3, // c = mem[ptr + i]
+			0x41, 0xe1, 0, 0x6b, 0x41, 26, 0x49, // c - 'a' < 26
+			0x04, 0x7f, 0x20, 3, 0x41, 32, 0x6b, 0x05, 0x20, 3, 0x0b,
+			0x3a, 0, 0, // mem[ptr + i] = c
+			0x20, 2, 0x41, 1, 0x6a, 0x21, 2, 0x0c, 0, // i++
+			0x0b, 0x0b,
+			0x20, 0, 0xad, 0x42, 32, 0x86, 0x20, 1, 0xad, 0x84, 0x0b), // ptr << 32 | len
+		body(0, 0x20, 0, 0x40, 0, 0x0b),
+	))...)
+	return m
+}
+
+// writeModule writes the test module to dir as name.wasm, with manifest
+// beside it when given.
+func writeModule(t *testing.T, dir, name, manifest string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name+".wasm"), testModule(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if manifest != "" {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(manifest), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func call(t *testing.T, name string, args ...tsqlruntime.Value) (tsqlruntime.Value, error) {
+	t.Helper()
+	return tsqlruntime.NewFunctionRegistry().Call(name, args)
+}
+
+func TestNumericFunctions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeModule(t, dir, "math", "")
+	e, err := Load(ctx, Config{Dir: dir, MemoryLimit: 2 * wasmPageSize, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close(ctx)
+
+	var names []string
+	for _, fn := range e.Functions() {
+		names = append(names, fn.Name+"("+strings.Join(fn.Params, ", ")+") "+fn.Returns)
+	}
+	want := "ext_add(bigint, bigint) bigint; ext_grow(int) int; ext_hypot(float, float) float; ext_shout(int, int) bigint; ext_spin() int"
+	if got := strings.Join(names, "; "); got != want {
+		t.Errorf("functions:\n got %s\nwant %s", got, want)
+	}
+
+	if v, err := call(t, "dbo.ext_add", tsqlruntime.NewBigInt(40), tsqlruntime.NewInt(2)); err != nil || v.AsInt() != 42 {
+		t.Errorf("dbo.ext_add(40, 2) = %v, %v", v.AsString(), err)
+	}
+	if v, err := call(t, "EXT_HYPOT", tsqlruntime.NewFloat(3), tsqlruntime.NewFloat(4)); err != nil || v.AsFloat() != 5 {
+		t.Errorf("EXT_HYPOT(3, 4) = %v, %v", v.AsString(), err)
+	}
+	if v, err := call(t, "ext_add", tsqlruntime.Null(tsqlruntime.TypeInt), tsqlruntime.NewInt(2)); err != nil || !v.IsNull {
+		t.Errorf("ext_add(NULL, 2) = %v, %v", v.AsString(), err)
+	}
+	if _, err := call(t, "ext_add", tsqlruntime.NewInt(2)); err == nil {
+		t.Error("ext_add(2) succeeded")
+	}
+
+	// Memory grows to the limit of two pages, and no further
+	if v, err := call(t, "ext_grow", tsqlruntime.NewInt(1)); err != nil || v.AsInt() != 1 {
+		t.Errorf("ext_grow(1) = %v, %v", v.AsString(), err)
+	}
+	if v, err := call(t, "ext_grow", tsqlruntime.NewInt(1)); err != nil || v.AsInt() != -1 {
+		t.Errorf("ext_grow(1) past the limit = %v, %v", v.AsString(), err)
+	}
+
+	// A call that runs too long is stopped, and the next call runs
+	start := time.Now()
+	if _, err := call(t, "ext_spin"); aulerrors.GetCode(err) != aulerrors.ErrCodeExecTimeout {
+		t.Errorf("ext_spin() = %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("ext_spin() stopped after %s", d)
+	}
+	if v, err := call(t, "ext_add", tsqlruntime.NewInt(1), tsqlruntime.NewInt(1)); err != nil || v.AsInt() != 2 {
+		t.Errorf("ext_add(1, 1) after a timeout = %v, %v", v.AsString(), err)
+	}
+
+	// Queries SQLite runs call them too
+	backend, err := storage.NewSQLiteStorage(storage.DefaultSQLiteConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	var sum int64
+	if err := backend.GetDB().QueryRow("SELECT SUM(ext_add(value, 1)) FROM (SELECT 1 AS value UNION ALL SELECT 2)").Scan(&sum); err != nil || sum != 5 {
+		t.Errorf("SQLite: %d, %v", sum, err)
+	}
+
+	e.Close(ctx)
+	if tsqlruntime.NewFunctionRegistry().Has("ext_add") {
+		t.Error("ext_add registered after Close")
+	}
+}
+
+func TestCancelledCall(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeModule(t, dir, "math", "")
+	e, err := Load(ctx, Config{Dir: dir, Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close(ctx)
+
+	// A call stops when its statement is cancelled, well before its own
+	// timeout, and the next call runs
+	stmt, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := tsqlruntime.NewFunctionRegistry().CallContext(stmt, "ext_spin", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ext_spin() in a cancelled statement = %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("ext_spin() stopped after %s", d)
+	}
+	if v, err := tsqlruntime.NewFunctionRegistry().CallContext(ctx, "ext_add", []tsqlruntime.Value{tsqlruntime.NewInt(1), tsqlruntime.NewInt(1)}); err != nil || v.AsInt() != 2 {
+		t.Errorf("ext_add(1, 1) after a cancellation = %v, %v", v.AsString(), err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeModule(t, dir, "text", `{"functions": {"ext_shout": {"params": ["NVARCHAR"], "returns": "nvarchar"}}}`)
+	e, err := Load(ctx, Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close(ctx)
+
+	if fns := e.Functions(); len(fns) != 1 || fns[0].Module != "text" {
+		t.Errorf("functions: %+v", fns)
+	}
+	if v, err := call(t, "ext_shout", tsqlruntime.NewNVarChar("hello, world", -1)); err != nil || v.AsString() != "HELLO, WORLD" || v.Type != tsqlruntime.TypeNVarChar {
+		t.Errorf("ext_shout('hello, world') = %q, %v", v.AsString(), err)
+	}
+	// Each call writes its argument afresh
+	if v, err := call(t, "ext_shout", tsqlruntime.NewNVarChar("again", -1)); err != nil || v.AsString() != "AGAIN" {
+		t.Errorf("ext_shout('again') = %q, %v", v.AsString(), err)
+	}
+	if tsqlruntime.NewFunctionRegistry().Has("ext_add") {
+		t.Error("ext_add registered, but not declared")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	ctx := context.Background()
+	// The module, exporting ext_add as replace
+	shadowing := bytes.Replace(testModule(), []byte("\x07ext_add"), []byte("\x07replace"), 1)
+	for _, tc := range []struct {
+		name     string
+		module   []byte
+		manifest string
+		want     string
+	}{
+		{"missing", nil, `{"functions": {"ext_missing": {"params": [], "returns": "int"}}}`, "not exported"},
+		{"mismatch", nil, `{"functions": {"ext_add": {"params": ["int", "int"], "returns": "int"}}}`, "not as declared"},
+		{"type", nil, `{"functions": {"ext_add": {"params": ["money", "money"], "returns": "money"}}}`, `unknown type "money"`},
+		{"field", nil, `{"functions": {"ext_add": {"params": ["bigint", "bigint"], "returns": "bigint"}}, "version": 2}`, "unknown field"},
+		{"builtin", shadowing, "", "function replace is a built-in function"},
+		{"compile", []byte("\x00asm\x01\x00\x00\x00\x0a"), "", "failed to compile"},
+	} {
+		dir := t.TempDir()
+		writeModule(t, dir, "bad", tc.manifest)
+		if tc.module != nil {
+			if err := os.WriteFile(filepath.Join(dir, "bad.wasm"), tc.module, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := Load(ctx, Config{Dir: dir})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.want)
+		}
+		if aulerrors.GetCode(err) != aulerrors.ErrCodeConfigInvalid {
+			t.Errorf("%s: code %v", tc.name, aulerrors.GetCode(err))
+		}
+		// A failed load leaves no function registered
+		if tsqlruntime.NewFunctionRegistry().Has("ext_hypot") {
+			t.Fatalf("%s: ext_hypot left registered", tc.name)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Package features holds the feature flags procedures read with FEATURE().
+//
+// A flag lets a team ship a procedure that does both the old and the new
+// thing, branching on FEATURE('new_pricing'), and turn the new path on
+// for some tenants or a share of sessions before everyone, or off again
+// without a deployment. Flags come from a JSON file at startup and can be
+// changed through the admin API while the server runs; such changes last
+// until it restarts.
+package features
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// Config lists the flags defined at startup.
+type Config struct {
+	Flags []Flag `json:"flags"`
+}
+
+// Flag is a feature flag. It is on for the tenants it lists; for others
+// it is on when Enabled, or for a Percent share of sessions (or tenants,
+// with By "tenant") picked by hashing their ID with the flag's name, so
+// that each keeps its answer and each flag picks its own share.
+type Flag struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Enabled     bool     `json:"enabled"`
+	Percent     float64  `json:"percent,omitempty"` // 0-100, when not Enabled
+	By          string   `json:"by,omitempty"`      // What Percent divides: "session" (default) or "tenant"
+	Tenants     []string `json:"tenants,omitempty"` // Tenants it is always on for
+}
+
+// Subject is who a flag is evaluated for.
+type Subject struct {
+	Session string
+	Tenant  string
+}
+
+var flagName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.:-]*$`)
+
+// Validate checks a flag's name and rollout.
+func (f Flag) Validate() error {
+	if !flagName.MatchString(f.Name) {
+		return fmt.Errorf("invalid flag name %q (want a letter followed by letters, digits, _ . : or -)", f.Name)
+	}
+	if f.Percent < 0 || f.Percent > 100 {
+		return fmt.Errorf("flag %s: percent %g is not between 0 and 100", f.Name, f.Percent)
+	}
+	switch strings.ToLower(f.By) {
+	case "", "session", "tenant":
+	default:
+		return fmt.Errorf("flag %s: by must be \"session\" or \"tenant\", not %q", f.Name, f.By)
+	}
+	return nil
+}
+
+// On reports whether the flag is on for s.
+func (f Flag) On(s Subject) bool {
+	if s.Tenant != "" && slices.ContainsFunc(f.Tenants, func(t string) bool { return strings.EqualFold(t, s.Tenant) }) {
+		return true
+	}
+	if f.Enabled {
+		return true
+	}
+	if f.Percent <= 0 {
+		return false
+	}
+	key := s.Session
+	if strings.EqualFold(f.By, "tenant") {
+		key = strings.ToLower(s.Tenant)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(f.Name) + "/" + key))
+	return float64(h.Sum32()%10000) < f.Percent*100
+}
+
+// LoadConfig reads a flag file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigMissing,
+			"failed to read feature flags").
+			WithOp("features.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigParse,
+			"failed to parse feature flags").
+			WithOp("features.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	return cfg, nil
+}
+
+// Flags is the set of feature flags of a server. Names are compared
+// without regard to case. A flag that is not defined is off.
+type Flags struct {
+	mu    sync.RWMutex
+	flags map[string]Flag
+}
+
+// New returns the flags of cfg.
+func New(cfg Config) (*Flags, error) {
+	f := &Flags{flags: make(map[string]Flag)}
+	for _, flag := range cfg.Flags {
+		if err := flag.Validate(); err != nil {
+			return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid, "invalid feature flag").
+				WithOp("features.New").
+				Err()
+		}
+		key := strings.ToLower(flag.Name)
+		if _, dup := f.flags[key]; dup {
+			return nil, aulerrors.Newf(aulerrors.ErrCodeConfigInvalid, "feature flag %s defined twice", flag.Name).
+				WithOp("features.New").
+				Err()
+		}
+		f.flags[key] = flag
+	}
+	return f, nil
+}
+
+// On reports whether the named flag is on for s.
+func (f *Flags) On(name string, s Subject) bool {
+	f.mu.RLock()
+	flag, ok := f.flags[strings.ToLower(name)]
+	f.mu.RUnlock()
+	return ok && flag.On(s)
+}
+
+// Set defines a flag or replaces the one of the same name.
+func (f *Flags) Set(flag Flag) error {
+	if err := flag.Validate(); err != nil {
+		return aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid, "invalid feature flag").
+			WithOp("Flags.Set").
+			Err()
+	}
+	f.mu.Lock()
+	f.flags[strings.ToLower(flag.Name)] = flag
+	f.mu.Unlock()
+	return nil
+}
+
+// Delete removes a flag, which turns it off.
+func (f *Flags) Delete(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.ToLower(name)
+	if _, ok := f.flags[key]; !ok {
+		return aulerrors.NotFound("feature flag", name).
+			WithOp("Flags.Delete").
+			Err()
+	}
+	delete(f.flags, key)
+	return nil
+}
+
+// List returns the flags ordered by name.
+func (f *Flags) List() []Flag {
+	f.mu.RLock()
+	out := make([]Flag, 0, len(f.flags))
+	for _, flag := range f.flags {
+		out = append(out, flag)
+	}
+	f.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name) })
+	return out
+}
@@ -0,0 +1,98 @@
+package features
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlagOn(t *testing.T) {
+	tests := []struct {
+		flag Flag
+		s    Subject
+		want bool
+	}{
+		{Flag{Name: "f"}, Subject{Session: "1"}, false},
+		{Flag{Name: "f", Enabled: true}, Subject{Session: "1"}, true},
+		{Flag{Name: "f", Tenants: []string{"Acme"}}, Subject{Session: "1", Tenant: "acme"}, true},
+		{Flag{Name: "f", Tenants: []string{"acme"}}, Subject{Session: "1", Tenant: "globex"}, false},
+		{Flag{Name: "f", Percent: 100}, Subject{Session: "1"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.flag.On(tt.s); got != tt.want {
+			t.Errorf("%+v.On(%+v) = %v, want %v", tt.flag, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestFlagPercent(t *testing.T) {
+	ten := Flag{Name: "rollout", Percent: 10}
+	fifty := Flag{Name: "rollout", Percent: 50}
+	on := 0
+	for i := 0; i < 10000; i++ {
+		s := Subject{Session: fmt.Sprint(i)}
+		if ten.On(s) {
+			on++
+			if !fifty.On(s) {
+				t.Fatalf("session %d lost the flag when the rollout grew", i)
+			}
+		}
+		if ten.On(s) != ten.On(s) {
+			t.Fatalf("session %d got two answers", i)
+		}
+	}
+	if on < 800 || on > 1200 {
+		t.Errorf("10%% rollout on for %d of 10000 sessions", on)
+	}
+
+	byTenant := Flag{Name: "rollout", Percent: 50, By: "tenant"}
+	first := byTenant.On(Subject{Session: "1", Tenant: "acme"})
+	for i := 2; i < 50; i++ {
+		if byTenant.On(Subject{Session: fmt.Sprint(i), Tenant: "acme"}) != first {
+			t.Fatal("a tenant's sessions disagree")
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.json")
+	os.WriteFile(path, []byte(`{"flags": [{"name": "a", "enabled": true}, {"name": "b", "percent": 0}]}`), 0644)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.On("A", Subject{}) || flags.On("b", Subject{}) || flags.On("missing", Subject{}) {
+		t.Error("wrong answers from the loaded flags")
+	}
+
+	if err := flags.Set(Flag{Name: "b", Enabled: true}); err != nil || !flags.On("b", Subject{}) {
+		t.Errorf("Set: %v", err)
+	}
+	if err := flags.Delete("a"); err != nil || flags.On("a", Subject{}) {
+		t.Errorf("Delete: %v", err)
+	}
+	if err := flags.Delete("a"); err == nil {
+		t.Error("deleted a missing flag")
+	}
+	if list := flags.List(); len(list) != 1 || list[0].Name != "b" {
+		t.Errorf("List = %+v", list)
+	}
+
+	for _, bad := range []Flag{{Name: "1st"}, {Name: "x", Percent: 101}, {Name: "x", By: "user"}} {
+		if err := flags.Set(bad); err == nil {
+			t.Errorf("Set(%+v) accepted", bad)
+		}
+	}
+	if _, err := New(Config{Flags: []Flag{{Name: "a"}, {Name: "A"}}}); err == nil {
+		t.Error("duplicate flags accepted")
+	}
+	os.WriteFile(path, []byte(`{"flags": [{"name": "a", "enabld": true}]}`), 0644)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
@@ -0,0 +1,482 @@
+// Package firewall decides which requests a server runs, before it runs
+// them.
+//
+// Rules, read from a JSON file at startup, allow or deny requests by the
+// listener they arrive on, the user or role making them, whether they
+// call a procedure or send SQL, the kinds of statement the SQL holds, the
+// procedures it calls, a regular expression on its text, or its
+// fingerprint: a hash of the SQL with its literals, comments and layout
+// taken out, which the same query with other values shares. The first
+// rule a request matches decides it; one that matches none gets the
+// default. A firewall in audit mode logs the requests it would deny and
+// runs them anyway, for trying rules out before enforcing them.
+package firewall
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+	"github.com/ha1tch/aul/pkg/tsqlparser/token"
+)
+
+// Actions a rule takes on the requests it matches.
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Modes of a firewall.
+const (
+	ModeEnforce = "enforce" // Denied requests fail
+	ModeAudit   = "audit"   // Denied requests are logged, and run
+)
+
+// Kinds of request.
+const (
+	RequestProcedure = "procedure" // A procedure call
+	RequestQuery     = "query"     // Ad-hoc SQL
+)
+
+// Kinds of statement, for a rule's Statements.
+const (
+	StatementSelect = "select" // SELECT, without INTO
+	StatementDML    = "dml"    // INSERT, UPDATE, DELETE, MERGE and SELECT INTO
+	StatementDDL    = "ddl"    // CREATE, ALTER, DROP, TRUNCATE, GRANT, REVOKE and DENY
+	StatementExec   = "exec"   // EXEC of a procedure or of dynamic SQL
+	StatementOther  = "other"  // Anything else: DECLARE, SET, PRINT, transactions...
+)
+
+// ErrorNumber is the SQL error number of a denied request: SQL Server's
+// permission denied.
+const ErrorNumber = 229
+
+// SQLState is the PostgreSQL SQLSTATE of a denied request
+// (insufficient_privilege).
+const SQLState = "42501"
+
+// Config is the firewall's rules.
+type Config struct {
+	Mode    string              `json:"mode,omitempty"`    // ModeEnforce (default) or ModeAudit
+	Default string              `json:"default,omitempty"` // Action for requests no rule matches ("" = allow)
+	Roles   map[string][]string `json:"roles,omitempty"`   // Users in each role, for rules' Roles
+	Rules   []Rule              `json:"rules"`
+}
+
+// Rule allows or denies the requests it matches. A request matches when
+// it meets every condition the rule gives; a rule with none matches
+// every request. Names are compared without regard to case.
+type Rule struct {
+	Name         string   `json:"name"`
+	Action       string   `json:"action"`
+	Message      string   `json:"message,omitempty"`      // Told to the clients it denies
+	Listeners    []string `json:"listeners,omitempty"`    // Listener names, such as "http" or "postgres"
+	Users        []string `json:"users,omitempty"`        // Users; with Roles, a request from either matches
+	Roles        []string `json:"roles,omitempty"`        // Roles of Config.Roles
+	Requests     []string `json:"requests,omitempty"`     // RequestProcedure or RequestQuery
+	Statements   []string `json:"statements,omitempty"`   // Statement kinds, any of which the SQL holds
+	Procedures   []string `json:"procedures,omitempty"`   // Patterns, as in path.Match, of procedures called
+	Pattern      string   `json:"pattern,omitempty"`      // Regular expression the SQL matches
+	Fingerprints []string `json:"fingerprints,omitempty"` // Fingerprints, one of which the SQL has
+}
+
+// Request is what the firewall decides on.
+type Request struct {
+	Listener  string
+	User      string
+	Procedure string // For a procedure call
+	SQL       string // For ad-hoc SQL
+}
+
+// Decision is the firewall's answer to a request.
+type Decision struct {
+	Allowed     bool
+	Audited     bool   // Denied, but allowed in audit mode
+	Rule        string // Name of the deciding rule ("" = the default)
+	Message     string
+	Fingerprint string // Of ad-hoc SQL
+	Query       string // The SQL the fingerprint hashes, without literals
+}
+
+// LoadConfig reads a rule file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigMissing,
+			"failed to read firewall rules").
+			WithOp("firewall.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigParse,
+			"failed to parse firewall rules").
+			WithOp("firewall.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	return cfg, nil
+}
+
+// Firewall decides on requests by its rules.
+type Firewall struct {
+	audit  bool
+	deny   bool                       // Whether the default is to deny
+	roles  map[string]map[string]bool // Lower-cased users by lower-cased role
+	rules  []rule
+	parsed bool // Whether a rule needs the SQL's statements
+}
+
+type rule struct {
+	Rule
+	pattern *regexp.Regexp
+}
+
+// New returns the firewall of cfg.
+func New(cfg Config) (*Firewall, error) {
+	f := &Firewall{roles: make(map[string]map[string]bool)}
+	switch strings.ToLower(cfg.Mode) {
+	case "", ModeEnforce:
+	case ModeAudit:
+		f.audit = true
+	default:
+		return nil, invalidConfig(fmt.Errorf("mode must be %q or %q, not %q", ModeEnforce, ModeAudit, cfg.Mode))
+	}
+	switch strings.ToLower(cfg.Default) {
+	case "", ActionAllow:
+	case ActionDeny:
+		f.deny = true
+	default:
+		return nil, invalidConfig(fmt.Errorf("default must be %q or %q, not %q", ActionAllow, ActionDeny, cfg.Default))
+	}
+	for role, users := range cfg.Roles {
+		members := make(map[string]bool, len(users))
+		for _, user := range users {
+			members[strings.ToLower(user)] = true
+		}
+		f.roles[strings.ToLower(role)] = members
+	}
+	for _, r := range cfg.Rules {
+		compiled, err := f.compile(r)
+		if err != nil {
+			return nil, invalidConfig(err)
+		}
+		f.rules = append(f.rules, compiled)
+		f.parsed = f.parsed || len(r.Statements) > 0 || len(r.Procedures) > 0
+	}
+	return f, nil
+}
+
+func (f *Firewall) compile(r Rule) (rule, error) {
+	compiled := rule{Rule: r}
+	if r.Name == "" {
+		return compiled, fmt.Errorf("a rule has no name")
+	}
+	switch strings.ToLower(r.Action) {
+	case ActionAllow, ActionDeny:
+	default:
+		return compiled, fmt.Errorf("rule %s: action must be %q or %q, not %q", r.Name, ActionAllow, ActionDeny, r.Action)
+	}
+	for _, role := range r.Roles {
+		if _, ok := f.roles[strings.ToLower(role)]; !ok {
+			return compiled, fmt.Errorf("rule %s: role %q is not defined", r.Name, role)
+		}
+	}
+	for _, kind := range r.Requests {
+		switch strings.ToLower(kind) {
+		case RequestProcedure, RequestQuery:
+		default:
+			return compiled, fmt.Errorf("rule %s: request must be %q or %q, not %q", r.Name, RequestProcedure, RequestQuery, kind)
+		}
+	}
+	for _, kind := range r.Statements {
+		switch strings.ToLower(kind) {
+		case StatementSelect, StatementDML, StatementDDL, StatementExec, StatementOther:
+		default:
+			return compiled, fmt.Errorf("rule %s: unknown statement kind %q", r.Name, kind)
+		}
+	}
+	for _, pattern := range r.Procedures {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return compiled, fmt.Errorf("rule %s: procedure pattern %q: %w", r.Name, pattern, err)
+		}
+	}
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return compiled, fmt.Errorf("rule %s: pattern: %w", r.Name, err)
+		}
+		compiled.pattern = re
+	}
+	return compiled, nil
+}
+
+func invalidConfig(err error) error {
+	return aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid, "invalid firewall rules").
+		WithOp("firewall.New").
+		Err()
+}
+
+// Check decides on req.
+func (f *Firewall) Check(req Request) Decision {
+	r := f.request(req)
+	d := Decision{Allowed: !f.deny, Fingerprint: r.fingerprint, Query: r.query}
+	for _, rule := range f.rules {
+		if f.matches(rule, r) {
+			d.Allowed = strings.EqualFold(rule.Action, ActionAllow)
+			d.Rule = rule.Name
+			d.Message = rule.Message
+			break
+		}
+	}
+	if !d.Allowed && f.audit {
+		d.Allowed, d.Audited = true, true
+	}
+	return d
+}
+
+// Err returns the error a denied request fails with, or nil.
+func (d Decision) Err() error {
+	if d.Allowed {
+		return nil
+	}
+	msg := "The request was denied by the firewall"
+	if d.Rule != "" {
+		msg += " (rule " + d.Rule + ")"
+	}
+	if d.Message != "" {
+		msg += ": " + d.Message
+	}
+	return aulerrors.New(aulerrors.ErrCodeExecDenied, msg).
+		WithOp("Firewall.Check").
+		WithField("rule", d.Rule).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(ErrorNumber)).
+		WithField(aulerrors.FieldSQLSeverity, uint8(14)).
+		WithField(aulerrors.FieldSQLState, SQLState).
+		Err()
+}
+
+// request is a Request with what rules match on worked out.
+type request struct {
+	Request
+	kind        string
+	statements  []string // Kinds of statement the SQL holds
+	procedures  []string // Lower-cased names of the procedures called
+	unparsed    bool     // The SQL does not parse, so its statements are not known
+	fingerprint string
+	query       string
+}
+
+func (f *Firewall) request(req Request) *request {
+	r := &request{Request: req, kind: RequestQuery}
+	if req.SQL == "" {
+		r.kind = RequestProcedure
+		r.statements = []string{StatementExec}
+		r.procedures = []string{procedureName(req.Procedure)}
+		return r
+	}
+	r.query = Normalize(req.SQL)
+	r.fingerprint = fingerprint(r.query)
+	if f.parsed {
+		p := parser.New(lexer.New(req.SQL))
+		program := p.ParseProgram()
+		r.unparsed = len(p.Errors()) > 0
+		for _, stmt := range program.Statements {
+			r.classify(stmt)
+		}
+	}
+	return r
+}
+
+// classify adds the kind of stmt, and of the statements in its blocks, to
+// r's statements, and the procedures it calls to r's procedures.
+func (r *request) classify(stmt ast.Statement) {
+	var kind string
+	switch s := stmt.(type) {
+	case nil:
+		return
+	case *ast.BeginEndBlock:
+		for _, inner := range s.Statements {
+			r.classify(inner)
+		}
+		return
+	case *ast.IfStatement:
+		r.classify(s.Consequence)
+		r.classify(s.Alternative)
+		return
+	case *ast.WhileStatement:
+		r.classify(s.Body)
+		return
+	case *ast.TryCatchStatement:
+		if s.TryBlock != nil {
+			r.classify(s.TryBlock)
+		}
+		if s.CatchBlock != nil {
+			r.classify(s.CatchBlock)
+		}
+		return
+	case *ast.WithStatement:
+		r.classify(s.Query)
+		return
+	case *ast.SelectStatement:
+		kind = StatementSelect
+		if s.Into != nil {
+			kind = StatementDML
+		}
+	case *ast.ExecStatement:
+		kind = StatementExec
+		if s.Procedure != nil {
+			r.procedures = append(r.procedures, procedureName(s.Procedure.String()))
+		}
+	case *ast.InsertStatement, *ast.UpdateStatement, *ast.DeleteStatement, *ast.MergeStatement, *ast.BulkInsertStatement:
+		kind = StatementDML
+	default:
+		// The parser has a statement type for each object it creates,
+		// alters or drops
+		kind = StatementOther
+		name := strings.TrimPrefix(fmt.Sprintf("%T", stmt), "*ast.")
+		for _, prefix := range ddlPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				kind = StatementDDL
+			}
+		}
+	}
+	if !slices.Contains(r.statements, kind) {
+		r.statements = append(r.statements, kind)
+	}
+}
+
+// ddlPrefixes begin the names of the parser's DDL statement types.
+var ddlPrefixes = []string{"Create", "Alter", "Drop", "Truncate", "Grant", "Revoke", "Deny", "AddSensitivity", "UpdateStatistics"}
+
+func (f *Firewall) matches(rule rule, r *request) bool {
+	if len(rule.Listeners) > 0 && !containsFold(rule.Listeners, r.Listener) {
+		return false
+	}
+	if len(rule.Users) > 0 || len(rule.Roles) > 0 {
+		if r.User == "" || !(containsFold(rule.Users, r.User) || f.inRole(rule.Roles, r.User)) {
+			return false
+		}
+	}
+	if len(rule.Requests) > 0 && !containsFold(rule.Requests, r.kind) {
+		return false
+	}
+	// SQL that does not parse might hold any statement: it matches the
+	// rules that deny by statement, and none that allow by them
+	deny := strings.EqualFold(rule.Action, ActionDeny)
+	if len(rule.Statements) > 0 && !(r.unparsed && deny) && !slices.ContainsFunc(r.statements, func(kind string) bool {
+		return containsFold(rule.Statements, kind)
+	}) {
+		return false
+	}
+	if len(rule.Procedures) > 0 && !(r.unparsed && deny) && !slices.ContainsFunc(r.procedures, func(proc string) bool {
+		return matchProcedure(rule.Procedures, proc)
+	}) {
+		return false
+	}
+	if rule.pattern != nil && (r.SQL == "" || !rule.pattern.MatchString(r.SQL)) {
+		return false
+	}
+	if len(rule.Fingerprints) > 0 && (r.fingerprint == "" || !containsFold(rule.Fingerprints, r.fingerprint)) {
+		return false
+	}
+	return true
+}
+
+// inRole reports whether user is in one of roles.
+func (f *Firewall) inRole(roles []string, user string) bool {
+	for _, role := range roles {
+		if f.roles[strings.ToLower(role)][strings.ToLower(user)] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchProcedure reports whether proc, a lower-cased [schema.]name, is
+// matched by one of patterns. A pattern without a schema matches the name
+// in any schema.
+func matchProcedure(patterns []string, proc string) bool {
+	_, name, _ := strings.Cut(proc, ".")
+	if !strings.Contains(proc, ".") {
+		name = proc
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		target := proc
+		if !strings.Contains(pattern, ".") {
+			target = name
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// procedureName lower-cases a procedure's name and takes out its
+// brackets and any database.
+func procedureName(name string) string {
+	name = strings.ToLower(strings.NewReplacer("[", "", "]", "", `"`, "").Replace(name))
+	if parts := strings.Split(name, "."); len(parts) > 2 {
+		name = strings.Join(parts[len(parts)-2:], ".")
+	}
+	return name
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(item string) bool { return strings.EqualFold(item, s) })
+}
+
+// Normalize returns sql without its comments, with its literals replaced
+// by ?, a list of literals by one, its keywords in upper case, its
+// identifiers in lower case and its tokens separated by single spaces.
+func Normalize(sql string) string {
+	l := lexer.New(sql)
+	var tokens []string
+	for {
+		tok := l.NextToken()
+		switch tok.Type {
+		case token.EOF:
+			return strings.Join(tokens, " ")
+		case token.COMMENT:
+			continue
+		case token.INT, token.FLOAT, token.MONEY_LIT, token.STRING, token.NSTRING, token.BINARY:
+			// A literal in a list (IN (1, 2, 3)) joins the one before it
+			if n := len(tokens); n >= 2 && tokens[n-1] == "," && tokens[n-2] == "?" {
+				tokens = tokens[:n-1]
+				continue
+			}
+			tokens = append(tokens, "?")
+		case token.IDENT, token.VARIABLE:
+			tokens = append(tokens, strings.ToLower(tok.Literal))
+		default:
+			tokens = append(tokens, strings.ToUpper(tok.Literal))
+		}
+	}
+}
+
+// Fingerprint returns the fingerprint of sql: the first 16 hex digits of
+// the SHA-256 of Normalize(sql).
+func Fingerprint(sql string) string {
+	return fingerprint(Normalize(sql))
+}
+
+func fingerprint(normalized string) string {
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,131 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct{ sql, want string }{
+		{"select  Name from [dbo].[Customers] -- all\nwhere ID = 42", "SELECT name FROM dbo . customers WHERE id = ?"},
+		{"SELECT * FROM t WHERE c IN (1, 2, 3) AND d = N'x' /* note */", "SELECT * FROM t WHERE c IN ( ? ) AND d = ?"},
+		{"EXEC dbo.usp_Get @ID = 7", "EXEC dbo . usp_get @id = ?"},
+	} {
+		if got := Normalize(tc.sql); got != tc.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tc.sql, got, tc.want)
+		}
+	}
+	a := Fingerprint("SELECT * FROM Orders WHERE ID = 1")
+	b := Fingerprint("select *\n  from orders where id = 2 -- again")
+	c := Fingerprint("SELECT * FROM Orders WHERE CustomerID = 1")
+	if a != b || a == c || len(a) != 16 {
+		t.Errorf("fingerprints %s %s %s", a, b, c)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	f, err := New(Config{
+		Roles: map[string][]string{"analysts": {"Ada", "grace"}},
+		Rules: []Rule{
+			{Name: "no-cmdshell", Action: ActionDeny, Pattern: `(?i)xp_cmdshell`},
+			{Name: "analysts-read", Action: ActionDeny, Listeners: []string{"postgres"}, Roles: []string{"analysts"},
+				Statements: []string{StatementDML, StatementDDL}, Message: "analysts may only read"},
+			{Name: "http-reports", Action: ActionAllow, Listeners: []string{"http"}, Procedures: []string{"reports.*", "usp_Report*"}},
+			{Name: "http-known", Action: ActionAllow, Listeners: []string{"http"},
+				Fingerprints: []string{Fingerprint("SELECT COUNT(*) FROM Orders WHERE CustomerID = 1")}},
+			{Name: "http-procedures", Action: ActionDeny, Listeners: []string{"http"}, Requests: []string{RequestQuery}},
+			{Name: "http-other", Action: ActionDeny, Listeners: []string{"http"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		req  Request
+		rule string // Deciding rule, "" for the default
+		ok   bool
+	}{
+		{Request{Listener: "tds", SQL: "EXEC master..xp_cmdshell 'dir'"}, "no-cmdshell", false},
+		{Request{Listener: "postgres", User: "ada", SQL: "SELECT * FROM Orders"}, "", true},
+		{Request{Listener: "postgres", User: "ada", SQL: "IF 1 = 1 BEGIN UPDATE Orders SET Total = 0 END"}, "analysts-read", false},
+		{Request{Listener: "postgres", User: "ada", SQL: "WITH o AS (SELECT 1 AS x) DELETE FROM Orders"}, "analysts-read", false},
+		{Request{Listener: "postgres", User: "ada", SQL: "SELECT * INTO Copy FROM Orders"}, "analysts-read", false},
+		{Request{Listener: "postgres", User: "ada", SQL: "DROP TABLE Orders"}, "analysts-read", false},
+		{Request{Listener: "postgres", User: "ada", SQL: "UPDATE Orders SET"}, "analysts-read", false}, // Does not parse
+		{Request{Listener: "postgres", User: "linus", SQL: "DELETE FROM Orders"}, "", true},
+		{Request{Listener: "tds", User: "ada", SQL: "DELETE FROM Orders"}, "", true},
+		{Request{Listener: "http", Procedure: "[reports].[Daily]"}, "http-reports", true},
+		{Request{Listener: "http", Procedure: "dbo.usp_ReportSales"}, "http-reports", true},
+		{Request{Listener: "http", SQL: "exec usp_ReportSales @Year = 2024"}, "http-reports", true},
+		{Request{Listener: "http", SQL: "select count(*) from orders where customerid = 99"}, "http-known", true},
+		{Request{Listener: "http", SQL: "SELECT * FROM Orders"}, "http-procedures", false},
+		{Request{Listener: "http", Procedure: "dbo.usp_DeleteAll"}, "http-other", false},
+	} {
+		d := f.Check(tc.req)
+		if d.Allowed != tc.ok || d.Rule != tc.rule {
+			t.Errorf("%+v: got allowed %v by %q, want %v by %q", tc.req, d.Allowed, d.Rule, tc.ok, tc.rule)
+		}
+	}
+
+	d := f.Check(Request{Listener: "postgres", User: "grace", SQL: "TRUNCATE TABLE Orders"})
+	err = d.Err()
+	if aulerrors.GetCode(err) != aulerrors.ErrCodeExecDenied || !strings.Contains(err.Error(), "analysts may only read") {
+		t.Errorf("got %v", err)
+	}
+	if sqlErr := aulerrors.FindSQLError(err); sqlErr == nil || sqlErr.Fields[aulerrors.FieldSQLErrorNumber] != int32(ErrorNumber) {
+		t.Errorf("no SQL error number in %v", err)
+	}
+	if d.Fingerprint != Fingerprint("TRUNCATE TABLE Orders") || d.Query != "TRUNCATE TABLE orders" {
+		t.Errorf("fingerprint %s, query %q", d.Fingerprint, d.Query)
+	}
+}
+
+func TestCheckDefaultAndAudit(t *testing.T) {
+	// An allowlist: what no rule allows is denied
+	cfg := Config{
+		Default: ActionDeny,
+		Rules:   []Rule{{Name: "procedures", Action: ActionAllow, Requests: []string{RequestProcedure}}},
+	}
+	f, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := f.Check(Request{Procedure: "usp_Get"}); !d.Allowed {
+		t.Errorf("procedure denied: %+v", d)
+	}
+	if d := f.Check(Request{SQL: "SELECT 1"}); d.Allowed || d.Rule != "" {
+		t.Errorf("query allowed: %+v", d)
+	}
+
+	cfg.Mode = ModeAudit
+	if f, err = New(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if d := f.Check(Request{SQL: "SELECT 1"}); !d.Allowed || !d.Audited || d.Err() != nil {
+		t.Errorf("audit mode: %+v", d)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Mode: "log"}, "mode must be"},
+		{Config{Default: "block"}, "default must be"},
+		{Config{Rules: []Rule{{Action: ActionDeny}}}, "has no name"},
+		{Config{Rules: []Rule{{Name: "r", Action: "block"}}}, "action must be"},
+		{Config{Rules: []Rule{{Name: "r", Action: ActionDeny, Roles: []string{"ops"}}}}, `role "ops" is not defined`},
+		{Config{Rules: []Rule{{Name: "r", Action: ActionDeny, Requests: []string{"batch"}}}}, "request must be"},
+		{Config{Rules: []Rule{{Name: "r", Action: ActionDeny, Statements: []string{"write"}}}}, "unknown statement kind"},
+		{Config{Rules: []Rule{{Name: "r", Action: ActionDeny, Procedures: []string{"usp_["}}}}, "procedure pattern"},
+		{Config{Rules: []Rule{{Name: "r", Action: ActionDeny, Pattern: "("}}}, "pattern"},
+	} {
+		_, err := New(tc.cfg)
+		if aulerrors.GetCode(err) != aulerrors.ErrCodeConfigInvalid || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: got %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+}
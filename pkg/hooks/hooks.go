@@ -0,0 +1,343 @@
+// Package hooks runs code of the operator's own at fixed points of a
+// session's life, for policy, rewriting or enrichment the server does not
+// have, without forking it.
+//
+// A hook is a value of a registered type that implements one or more of
+// LoginHook, BeforeStatementHook, AfterStatementHook and ResultSetHook.
+// Types are registered with Register, by a build of the server that links
+// them in or from the init function of a Go plugin. A JSON file read at
+// startup names the plugins to load and the hooks to run, in order, each
+// with its options and what a failure of it does: fail the login or
+// request (abort, the default) or be logged and passed over (continue).
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"plugin"
+	"slices"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// Failure policies of a hook.
+const (
+	FailureAbort    = "abort"    // The login or request fails with the hook's error
+	FailureContinue = "continue" // The error is reported to Chain.OnIgnored, and the next hook runs
+)
+
+// Points at which hooks run, as reported to Chain.OnIgnored.
+const (
+	PointLogin           = "login"
+	PointBeforeStatement = "before_statement"
+	PointAfterStatement  = "after_statement"
+	PointResultSet       = "result_set"
+)
+
+// Login is a session that has just connected.
+type Login struct {
+	SessionID string
+	Listener  string // Name of the listener the client connected to
+	Protocol  string // tds, postgres, mysql, http or grpc
+	User      string // Principal the client connected as, when known
+	App       string
+	Host      string
+	Address   string
+	Tenant    string
+	Database  string
+}
+
+// Statement is a request to run SQL or call a procedure. BeforeStatement
+// hooks may change its SQL and Parameters; what they leave is what runs.
+type Statement struct {
+	Login      *Login
+	RequestID  string
+	Procedure  string // For a procedure call
+	SQL        string // For ad-hoc SQL
+	Parameters map[string]interface{}
+}
+
+// Outcome is what running a statement did.
+type Outcome struct {
+	RowsAffected int64
+	Err          error // Nil when the statement succeeded
+	Duration     time.Duration
+}
+
+// LoginHook is called when a session connects; an error refuses it.
+type LoginHook interface {
+	OnLogin(ctx context.Context, login *Login) error
+}
+
+// BeforeStatementHook is called before a statement runs; an error stops
+// it from running.
+type BeforeStatementHook interface {
+	BeforeStatement(ctx context.Context, stmt *Statement) error
+}
+
+// AfterStatementHook is called after a statement has run, or failed. An
+// error fails the request, but does not undo what the statement did.
+type AfterStatementHook interface {
+	AfterStatement(ctx context.Context, stmt *Statement, outcome *Outcome) error
+}
+
+// ResultSetHook is called with each result set of a statement before it
+// is sent, and may change its columns and rows.
+type ResultSetHook interface {
+	OnResultSet(ctx context.Context, stmt *Statement, rs *protocol.ResultSet) error
+}
+
+// Factory returns a hook of a registered type, configured by options.
+type Factory func(options map[string]string) (interface{}, error)
+
+var (
+	factoriesMu sync.Mutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a hook type available to HookConfig.Type.
+func Register(typ string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[typ] = factory
+}
+
+// Types returns the names of the registered hook types.
+func Types() []string {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// LoadPlugin loads a Go plugin (built with go build -buildmode=plugin, by
+// the Go version and with the module versions the server was built with)
+// whose init functions register hook types with Register. It fails if the
+// plugin registers none.
+func LoadPlugin(path string) error {
+	before := len(Types())
+	if _, err := plugin.Open(path); err != nil {
+		return aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid,
+			"failed to load hook plugin").
+			WithOp("hooks.LoadPlugin").
+			WithField("path", path).
+			Err()
+	}
+	if len(Types()) == before {
+		return aulerrors.New(aulerrors.ErrCodeConfigInvalid,
+			"hook plugin registered no hook type").
+			WithOp("hooks.LoadPlugin").
+			WithField("path", path).
+			Err()
+	}
+	return nil
+}
+
+// Config is the plugins to load and the hooks to run.
+type Config struct {
+	Plugins []string     `json:"plugins,omitempty"` // Go plugins registering hook types
+	Hooks   []HookConfig `json:"hooks"`             // Run in this order at each point
+}
+
+// HookConfig is one hook.
+type HookConfig struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`              // A registered type
+	Failure string            `json:"failure,omitempty"` // FailureAbort (default) or FailureContinue
+	Options map[string]string `json:"options,omitempty"` // Given to the type's Factory
+}
+
+// LoadConfig reads a hook file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigMissing,
+			"failed to read hooks").
+			WithOp("hooks.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigParse,
+			"failed to parse hooks").
+			WithOp("hooks.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	return cfg, nil
+}
+
+// Chain runs the configured hooks in order.
+type Chain struct {
+	hooks []hook
+
+	// OnIgnored, when set, is told of the errors of hooks whose failure
+	// policy is to continue.
+	OnIgnored func(hook, point string, err error)
+}
+
+type hook struct {
+	name  string
+	abort bool
+	impl  interface{}
+}
+
+// New loads cfg's plugins and returns the chain of its hooks.
+func New(cfg Config) (*Chain, error) {
+	for _, path := range cfg.Plugins {
+		if err := LoadPlugin(path); err != nil {
+			return nil, err
+		}
+	}
+	c := &Chain{}
+	seen := map[string]bool{}
+	for i, hc := range cfg.Hooks {
+		if hc.Name == "" {
+			return nil, aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+				"hook %d has no name", i+1).
+				WithOp("hooks.New").
+				Err()
+		}
+		if seen[hc.Name] {
+			return nil, aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+				"hook %s is given twice", hc.Name).
+				WithOp("hooks.New").
+				Err()
+		}
+		seen[hc.Name] = true
+		if hc.Failure != "" && hc.Failure != FailureAbort && hc.Failure != FailureContinue {
+			return nil, aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+				"hook %s: failure must be %s or %s, not %q", hc.Name, FailureAbort, FailureContinue, hc.Failure).
+				WithOp("hooks.New").
+				Err()
+		}
+		factoriesMu.Lock()
+		factory := factories[hc.Type]
+		factoriesMu.Unlock()
+		if factory == nil {
+			return nil, aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+				"hook %s: unknown hook type %q", hc.Name, hc.Type).
+				WithOp("hooks.New").
+				WithField("registered", Types()).
+				Err()
+		}
+		impl, err := factory(hc.Options)
+		if err != nil {
+			return nil, aulerrors.Wrapf(err, aulerrors.ErrCodeConfigInvalid,
+				"hook %s", hc.Name).
+				WithOp("hooks.New").
+				Err()
+		}
+		switch impl.(type) {
+		case LoginHook, BeforeStatementHook, AfterStatementHook, ResultSetHook:
+		default:
+			return nil, aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+				"hook %s: type %s implements no hook point", hc.Name, hc.Type).
+				WithOp("hooks.New").
+				Err()
+		}
+		c.hooks = append(c.hooks, hook{name: hc.Name, abort: hc.Failure != FailureContinue, impl: impl})
+	}
+	return c, nil
+}
+
+// Len returns the number of hooks in the chain.
+func (c *Chain) Len() int {
+	return len(c.hooks)
+}
+
+// Login runs the LoginHooks; an error refuses the session.
+func (c *Chain) Login(ctx context.Context, login *Login) error {
+	return c.run(PointLogin, func(h hook) error {
+		lh, ok := h.impl.(LoginHook)
+		if !ok {
+			return nil
+		}
+		return lh.OnLogin(ctx, login)
+	})
+}
+
+// BeforeStatement runs the BeforeStatementHooks; an error stops stmt from
+// running.
+func (c *Chain) BeforeStatement(ctx context.Context, stmt *Statement) error {
+	return c.run(PointBeforeStatement, func(h hook) error {
+		bh, ok := h.impl.(BeforeStatementHook)
+		if !ok {
+			return nil
+		}
+		return bh.BeforeStatement(ctx, stmt)
+	})
+}
+
+// AfterStatement runs the AfterStatementHooks; an error fails the request.
+func (c *Chain) AfterStatement(ctx context.Context, stmt *Statement, outcome *Outcome) error {
+	return c.run(PointAfterStatement, func(h hook) error {
+		ah, ok := h.impl.(AfterStatementHook)
+		if !ok {
+			return nil
+		}
+		return ah.AfterStatement(ctx, stmt, outcome)
+	})
+}
+
+// ResultSet runs the ResultSetHooks on rs; an error fails the request.
+func (c *Chain) ResultSet(ctx context.Context, stmt *Statement, rs *protocol.ResultSet) error {
+	return c.run(PointResultSet, func(h hook) error {
+		rh, ok := h.impl.(ResultSetHook)
+		if !ok {
+			return nil
+		}
+		return rh.OnResultSet(ctx, stmt, rs)
+	})
+}
+
+// run calls each hook in turn with call, which passes over those that do
+// not run at this point, until one whose failure aborts fails. A hook
+// that panics has failed.
+func (c *Chain) run(point string, call func(h hook) error) error {
+	for _, h := range c.hooks {
+		err := callHook(h, call)
+		if err == nil {
+			continue
+		}
+		if !h.abort {
+			if c.OnIgnored != nil {
+				c.OnIgnored(h.name, point, err)
+			}
+			continue
+		}
+		// A hook choosing its own SQL error number is reported with it
+		if aulerrors.FindSQLError(err) != nil {
+			return err
+		}
+		return aulerrors.Wrapf(err, aulerrors.ErrCodeExecDenied,
+			"rejected by hook %s", h.name).
+			WithOp("hooks.Chain").
+			WithField("hook", h.name).
+			WithField("point", point).
+			Err()
+	}
+	return nil
+}
+
+func callHook(h hook, call func(h hook) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("hook panicked: %v", v)
+		}
+	}()
+	return call(h)
+}
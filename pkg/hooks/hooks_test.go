@@ -0,0 +1,153 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// recorder appends its name to a shared trace at every point, and fails
+// with err when it has one.
+type recorder struct {
+	name  string
+	trace *[]string
+	err   error
+	panic bool
+}
+
+func (r *recorder) record(point string) error {
+	*r.trace = append(*r.trace, r.name+":"+point)
+	if r.panic {
+		panic("boom")
+	}
+	return r.err
+}
+
+func (r *recorder) OnLogin(ctx context.Context, login *Login) error { return r.record(PointLogin) }
+
+func (r *recorder) BeforeStatement(ctx context.Context, stmt *Statement) error {
+	stmt.SQL += " /* " + r.name + " */"
+	return r.record(PointBeforeStatement)
+}
+
+// loginOnly runs only at login.
+type loginOnly struct{ trace *[]string }
+
+func (l loginOnly) OnLogin(ctx context.Context, login *Login) error {
+	*l.trace = append(*l.trace, "login-only:"+PointLogin)
+	return nil
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+	Register("test-recorder", func(options map[string]string) (interface{}, error) {
+		r := &recorder{name: options["name"], trace: &trace, panic: options["panic"] == "true"}
+		if msg := options["error"]; msg != "" {
+			r.err = errors.New(msg)
+		}
+		return r, nil
+	})
+	Register("test-login-only", func(options map[string]string) (interface{}, error) {
+		return loginOnly{trace: &trace}, nil
+	})
+	hook := func(name, failure string, options map[string]string) HookConfig {
+		if options == nil {
+			options = map[string]string{}
+		}
+		options["name"] = name
+		return HookConfig{Name: name, Type: "test-recorder", Failure: failure, Options: options}
+	}
+	ctx := context.Background()
+
+	// Hooks run in order; a failing hook that continues is reported and
+	// passed over, as is one that panics
+	var ignored []string
+	c, err := New(Config{Hooks: []HookConfig{
+		hook("a", "", nil),
+		hook("b", FailureContinue, map[string]string{"error": "flaky"}),
+		hook("c", FailureContinue, map[string]string{"panic": "true"}),
+		{Name: "d", Type: "test-login-only"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.OnIgnored = func(hook, point string, err error) {
+		ignored = append(ignored, hook+":"+point+":"+err.Error())
+	}
+	if err := c.Login(ctx, &Login{}); err != nil {
+		t.Fatal(err)
+	}
+	stmt := &Statement{SQL: "SELECT 1"}
+	if err := c.BeforeStatement(ctx, stmt); err != nil {
+		t.Fatal(err)
+	}
+	want := "a:login b:login c:login login-only:login a:before_statement b:before_statement c:before_statement"
+	if got := strings.Join(trace, " "); got != want {
+		t.Errorf("trace = %s, want %s", got, want)
+	}
+	if stmt.SQL != "SELECT 1 /* a */ /* b */ /* c */" {
+		t.Errorf("SQL = %q", stmt.SQL)
+	}
+	if got := strings.Join(ignored, " "); got != "b:login:flaky c:login:hook panicked: boom b:before_statement:flaky c:before_statement:hook panicked: boom" {
+		t.Errorf("ignored = %s", got)
+	}
+	if err := c.ResultSet(ctx, stmt, &protocol.ResultSet{}); err != nil {
+		t.Errorf("no result set hooks: %v", err)
+	}
+
+	// A failing hook that aborts stops the chain
+	trace = nil
+	c, err = New(Config{Hooks: []HookConfig{hook("a", FailureAbort, map[string]string{"error": "suspended"}), hook("b", "", nil)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Login(ctx, &Login{})
+	if !aulerrors.IsCode(err, aulerrors.ErrCodeExecDenied) || !strings.Contains(err.Error(), "rejected by hook a: suspended") {
+		t.Errorf("err = %v", err)
+	}
+	if got := strings.Join(trace, " "); got != "a:login" {
+		t.Errorf("trace = %s", got)
+	}
+
+	// An error with an SQL error number is passed on as it is
+	sqlErr := aulerrors.New(aulerrors.ErrCodeExecFailed, "quota exceeded").
+		WithField(aulerrors.FieldSQLErrorNumber, int32(50001)).Err()
+	c.hooks[0].impl.(*recorder).err = sqlErr
+	if err := c.Login(ctx, &Login{}); err != sqlErr {
+		t.Errorf("err = %v, want the hook's own", err)
+	}
+
+	for _, bad := range []Config{
+		{Hooks: []HookConfig{{Type: "test-recorder"}}},
+		{Hooks: []HookConfig{hook("a", "", nil), hook("a", "", nil)}},
+		{Hooks: []HookConfig{hook("a", "retry", nil)}},
+		{Hooks: []HookConfig{{Name: "a", Type: "missing"}}},
+		{Plugins: []string{filepath.Join(t.TempDir(), "missing.so")}},
+	} {
+		if _, err := New(bad); !aulerrors.IsCode(err, aulerrors.ErrCodeConfigInvalid) {
+			t.Errorf("%+v: err = %v", bad, err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hooks.json")
+	os.WriteFile(path, []byte(`{"hooks": [{"name": "tag", "type": "tagger", "failure": "continue", "options": {"tag": "x"}}]}`), 0o644)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Hooks) != 1 || cfg.Hooks[0].Failure != FailureContinue || cfg.Hooks[0].Options["tag"] != "x" {
+		t.Errorf("config = %+v", cfg)
+	}
+	os.WriteFile(path, []byte(`{"hooks": [], "order": "reverse"}`), 0o644)
+	if _, err := LoadConfig(path); !aulerrors.IsCode(err, aulerrors.ErrCodeConfigParse) {
+		t.Errorf("unknown field: err = %v", err)
+	}
+}
@@ -359,7 +359,12 @@ func (m *Manager) Execute(ctx context.Context, proc *procedure.Procedure, params
 	elapsed := time.Since(start)
 	atomic.AddInt64(&m.stats.TotalExecTimeNs, elapsed.Nanoseconds())
 
-	m.logger.Performance().Debug("JIT execution completed",
+	if err != nil {
+		m.logger.Execution().WithContext(ctx).Error("JIT execution failed", err,
+			"procedure", name,
+		)
+	}
+	m.logger.Performance().WithContext(ctx).Debug("JIT execution completed",
 		"procedure", name,
 		"duration_ns", elapsed.Nanoseconds(),
 	)
@@ -0,0 +1,443 @@
+// Package lint checks T-SQL procedures for constructs that run but are
+// likely to be slow, fragile or mistaken: SELECT *, a missing SET
+// NOCOUNT ON, predicates no index can serve, variables never used and
+// names tied to another database or server.
+//
+// Each finding names the rule that made it and has the rule's severity,
+// which a Config can change or turn off, so that a CI pipeline can fail
+// on the rules a team cares about and merely report the others.
+package lint
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+	"github.com/ha1tch/aul/pkg/tsqlparser/token"
+)
+
+// Severity is how much a finding matters.
+type Severity int
+
+const (
+	Off Severity = iota
+	Info
+	Warning
+	Error
+)
+
+var severityNames = []string{"off", "info", "warning", "error"}
+
+func (s Severity) String() string {
+	if s < Off || s > Error {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// ParseSeverity reads a severity by its name: off, info, warning or error.
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if strings.EqualFold(name, n) {
+			return Severity(i), nil
+		}
+	}
+	return Off, fmt.Errorf("unknown severity %q: must be off, info, warning or error", name)
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(b []byte) error {
+	v, err := ParseSeverity(string(b))
+	*s = v
+	return err
+}
+
+// Rule is a check the linter makes.
+type Rule struct {
+	Name        string
+	Description string
+	Severity    Severity // Unless a Config says otherwise
+}
+
+// Rules are the checks the linter makes.
+var Rules = []Rule{
+	{"select-star", "SELECT * in a query, whose columns change with the table", Warning},
+	{"missing-nocount", "Procedure not beginning with SET NOCOUNT ON", Warning},
+	{"non-sargable", "Column compared through a function or arithmetic, or LIKE '%...'", Warning},
+	{"unused-variable", "Variable declared but never read", Warning},
+	{"three-part-name", "Object named with its database or linked server", Warning},
+}
+
+// Config overrides the severity of rules, by name.
+type Config map[string]Severity
+
+// Set sets the severity of a rule, checking that it exists.
+func (c Config) Set(rule string, s Severity) error {
+	for _, r := range Rules {
+		if r.Name == rule {
+			c[rule] = s
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown rule %q", rule)
+}
+
+func (c Config) severity(rule string) Severity {
+	if s, ok := c[rule]; ok {
+		return s
+	}
+	for _, r := range Rules {
+		if r.Name == rule {
+			return r.Severity
+		}
+	}
+	return Off
+}
+
+// Finding is a problem the linter found.
+type Finding struct {
+	Rule      string   `json:"rule"`
+	Severity  Severity `json:"severity"`
+	File      string   `json:"file,omitempty"`
+	Procedure string   `json:"procedure,omitempty"`
+	Line      int      `json:"line"`
+	Column    int      `json:"column"`
+	Message   string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	s := fmt.Sprintf("%d:%d: %s: %s [%s]", f.Line, f.Column, f.Severity, f.Message, f.Rule)
+	if f.File != "" {
+		s = f.File + ":" + s
+	}
+	return s
+}
+
+// Lint checks source, the T-SQL of one or more procedures read from
+// file, returning what it finds in the order it appears. It fails only if
+// source does not parse.
+func Lint(source, file string, cfg Config) ([]Finding, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, fmt.Errorf("parse error: %s", errs[0])
+	}
+	l := &linter{file: file, cfg: cfg}
+	for _, stmt := range program.Statements {
+		l.procedure = ""
+		if cp, ok := stmt.(*ast.CreateProcedureStatement); ok {
+			l.procedure = cp.Name.String()
+			l.checkNoCount(cp)
+		}
+		l.checkStatement(stmt)
+		l.checkVariables(stmt)
+	}
+	sort.SliceStable(l.findings, func(a, b int) bool {
+		fa, fb := l.findings[a], l.findings[b]
+		return fa.Line < fb.Line || fa.Line == fb.Line && fa.Column < fb.Column
+	})
+	return l.findings, nil
+}
+
+type linter struct {
+	file      string
+	cfg       Config
+	procedure string
+	findings  []Finding
+}
+
+// report adds a finding of rule at tok, unless the rule is off.
+func (l *linter) report(rule string, tok token.Token, format string, args ...interface{}) {
+	s := l.cfg.severity(rule)
+	if s == Off {
+		return
+	}
+	l.findings = append(l.findings, Finding{
+		Rule:      rule,
+		Severity:  s,
+		File:      l.file,
+		Procedure: l.procedure,
+		Line:      tok.Line,
+		Column:    tok.Column,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+// checkNoCount reports a procedure whose body does not start by turning
+// off row counts, before any statement that would send one.
+func (l *linter) checkNoCount(cp *ast.CreateProcedureStatement) {
+	if cp.Body == nil {
+		return
+	}
+	for _, stmt := range cp.Body.Statements {
+		s, ok := stmt.(*ast.SetStatement)
+		if !ok {
+			if _, ok := stmt.(*ast.DeclareStatement); ok {
+				continue
+			}
+			break
+		}
+		for _, option := range strings.Split(s.Option, ",") {
+			if strings.TrimSpace(option) == "NOCOUNT" && s.OnOff == "ON" {
+				return
+			}
+		}
+	}
+	l.report("missing-nocount", cp.Token, "procedure %s does not begin with SET NOCOUNT ON", cp.Name)
+}
+
+// checkStatement makes the checks of single statements and expressions
+// over stmt and everything in it.
+func (l *linter) checkStatement(stmt ast.Statement) {
+	exists := make(map[*ast.SelectStatement]bool)
+	inspect(stmt, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ExistsExpression:
+			// SELECT * in EXISTS returns no columns
+			exists[n.Subquery] = true
+		case *ast.SelectStatement:
+			if !exists[n] {
+				l.checkSelectStar(n)
+			}
+			l.checkPredicate(n.Where)
+		case *ast.UpdateStatement:
+			l.checkName(n.Table)
+			l.checkPredicate(n.Where)
+		case *ast.DeleteStatement:
+			l.checkName(n.Table)
+			l.checkPredicate(n.Where)
+		case *ast.InsertStatement:
+			l.checkName(n.Table)
+		case *ast.MergeStatement:
+			l.checkName(n.Target)
+		case *ast.JoinClause:
+			l.checkPredicate(n.Condition)
+		case *ast.TableName:
+			l.checkName(n.Name)
+		case *ast.ExecStatement:
+			l.checkName(n.Procedure)
+		}
+		return true
+	})
+}
+
+func (l *linter) checkSelectStar(s *ast.SelectStatement) {
+	for _, col := range s.Columns {
+		if col.AllColumns {
+			l.report("select-star", s.Token, "SELECT * returns every column; list the columns the caller needs")
+			continue
+		}
+		if q, ok := col.Expression.(*ast.QualifiedIdentifier); ok && len(q.Parts) > 1 && q.Parts[len(q.Parts)-1].Value == "*" {
+			l.report("select-star", q.Parts[0].Token, "SELECT %s returns every column; list the columns the caller needs", q)
+		}
+	}
+}
+
+// checkName reports a database- or server-qualified object name.
+func (l *linter) checkName(name *ast.QualifiedIdentifier) {
+	if name == nil || len(name.Parts) < 3 || strings.HasPrefix(name.Parts[len(name.Parts)-1].Value, "#") {
+		return
+	}
+	if len(name.Parts) > 3 {
+		l.report("three-part-name", name.Parts[0].Token, "%s names linked server %s", name, name.Parts[0])
+		return
+	}
+	l.report("three-part-name", name.Parts[0].Token, "%s names database %s; leave it out so the procedure works in any database", name, name.Parts[0])
+}
+
+// checkPredicate reports the comparisons of a WHERE or ON condition that
+// no index can serve.
+func (l *linter) checkPredicate(e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		switch strings.ToUpper(e.Operator) {
+		case "AND", "OR":
+			l.checkPredicate(e.Left)
+			l.checkPredicate(e.Right)
+		case "=", "<>", "!=", "<", ">", "<=", ">=":
+			l.checkOperand(e.Left, e.Token)
+			l.checkOperand(e.Right, e.Token)
+		}
+	case *ast.PrefixExpression:
+		l.checkPredicate(e.Right)
+	case *ast.BetweenExpression:
+		l.checkOperand(e.Expr, e.Token)
+	case *ast.InExpression:
+		l.checkOperand(e.Expr, e.Token)
+	case *ast.LikeExpression:
+		l.checkOperand(e.Expr, e.Token)
+		if s, ok := e.Pattern.(*ast.StringLiteral); ok && !e.Not && (strings.HasPrefix(s.Value, "%") || strings.HasPrefix(s.Value, "_")) {
+			if column(e.Expr) != "" {
+				l.report("non-sargable", e.Token, "LIKE '%s' starts with a wildcard, so %s is scanned, not sought", s.Value, e.Expr)
+			}
+		}
+	}
+}
+
+// checkOperand reports a column compared through a function or
+// arithmetic.
+func (l *linter) checkOperand(e ast.Expression, tok token.Token) {
+	switch e := e.(type) {
+	case *ast.FunctionCall:
+		if col := firstColumn(e); col != "" {
+			l.report("non-sargable", e.Token, "%s wraps column %s in a function; compare the column itself", e, col)
+		}
+	case *ast.CastExpression, *ast.ConvertExpression:
+		if col := firstColumn(e); col != "" {
+			l.report("non-sargable", tok, "%s converts column %s; convert the value it is compared with instead", e, col)
+		}
+	case *ast.InfixExpression:
+		switch e.Operator {
+		case "+", "-", "*", "/", "%":
+			if col := firstColumn(e); col != "" {
+				l.report("non-sargable", e.Token, "%s computes on column %s; move the arithmetic to the other side", e, col)
+			}
+		}
+	}
+}
+
+// datePartFunctions take a date part, which parses as an identifier, as
+// their first argument.
+var datePartFunctions = map[string]bool{
+	"DATEADD": true, "DATEDIFF": true, "DATEDIFF_BIG": true, "DATEPART": true, "DATENAME": true, "DATETRUNC": true,
+}
+
+// firstColumn returns the first column e refers to outside subqueries,
+// or "" if it refers to none.
+func firstColumn(e ast.Expression) string {
+	found := ""
+	inspect(e, func(n ast.Node) bool {
+		if found != "" {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.SubqueryExpression, *ast.ExistsExpression, *ast.SelectStatement:
+			return false
+		case *ast.FunctionCall:
+			args := n.Arguments
+			if name, ok := n.Function.(*ast.Identifier); ok && datePartFunctions[strings.ToUpper(name.Value)] && len(args) > 0 {
+				args = args[1:]
+			}
+			for _, arg := range args {
+				if found == "" {
+					found = firstColumn(arg)
+				}
+			}
+			return false
+		case ast.Expression:
+			found = column(n)
+		}
+		return true
+	})
+	return found
+}
+
+// column returns the name of the column e is, or "".
+func column(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		if !strings.HasPrefix(e.Value, "@") {
+			return e.String()
+		}
+	case *ast.QualifiedIdentifier:
+		if len(e.Parts) > 0 && !strings.HasPrefix(e.Parts[0].Value, "@") {
+			return e.String()
+		}
+	}
+	return ""
+}
+
+// checkVariables reports the variables declared in stmt that are never
+// read: that are not referred to at all, or only assigned to.
+func (l *linter) checkVariables(stmt ast.Statement) {
+	type declared struct {
+		name string
+		tok  token.Token
+	}
+	var decls []declared
+	assigned := make(map[*ast.Variable]bool)
+	read := make(map[string]bool)
+	inspect(stmt, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.DeclareStatement:
+			for _, v := range n.Variables {
+				decls = append(decls, declared{v.Name, n.Token})
+			}
+		case *ast.SetStatement:
+			if v, ok := n.Variable.(*ast.Variable); ok && n.Value != nil {
+				assigned[v] = true
+			}
+		case *ast.SelectStatement:
+			for _, col := range n.Columns {
+				if col.Variable != nil {
+					assigned[col.Variable] = true
+				}
+			}
+		case *ast.FetchStatement:
+			for _, v := range n.IntoVars {
+				assigned[v] = true
+			}
+		case *ast.Variable:
+			if !assigned[n] {
+				read[strings.ToLower(n.Name)] = true
+			}
+		case *ast.Identifier:
+			// Table variables and cursor variables in FROM, OPEN, ...
+			if strings.HasPrefix(n.Value, "@") {
+				read[strings.ToLower(n.Value)] = true
+			}
+		}
+		return true
+	})
+	for _, d := range decls {
+		if !read[strings.ToLower(d.name)] {
+			l.report("unused-variable", d.tok, "%s is declared but never read", d.name)
+		}
+	}
+}
+
+var tokenType = reflect.TypeOf(token.Token{})
+
+// inspect calls fn for node and each node within it, depth first, in the
+// order of their fields. It does not descend into a node for which fn
+// returns false.
+func inspect(node ast.Node, fn func(ast.Node) bool) {
+	inspectValue(reflect.ValueOf(node), fn)
+}
+
+func inspectValue(v reflect.Value, fn func(ast.Node) bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			inspectValue(v.Elem(), fn)
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if n, ok := v.Interface().(ast.Node); ok && !fn(n) {
+			return
+		}
+		inspectValue(v.Elem(), fn)
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				inspectValue(v.Field(i), fn)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			inspectValue(v.Index(i), fn)
+		}
+	}
+}
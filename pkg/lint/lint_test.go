@@ -0,0 +1,91 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	source := `CREATE PROCEDURE dbo.GetOrders @CustomerID int AS
+BEGIN
+	DECLARE @unused int, @count int, @total money, @t TABLE (id int)
+	SELECT * FROM orders WHERE YEAR(placed_at) = 2024
+	SELECT o.* FROM orders o JOIN Sales.dbo.customers c ON c.id + 0 = o.customer_id
+	SELECT @count = COUNT(*) FROM orders WHERE placed_at > DATEADD(day, -30, GETDATE()) AND name LIKE '%smith'
+	SET @total = 1
+	INSERT INTO @t (id) SELECT id FROM orders WHERE customer_id = @CustomerID
+	IF EXISTS (SELECT * FROM @t) PRINT @count
+	EXEC Remote.Sales.dbo.Sync
+END`
+	findings, err := Lint(source, "orders.sql", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.Rule+" "+f.Message)
+		if f.File != "orders.sql" || f.Procedure != "dbo.GetOrders" || f.Line == 0 || f.Severity != Warning {
+			t.Errorf("finding %+v", f)
+		}
+	}
+	want := []string{
+		"missing-nocount procedure dbo.GetOrders does not begin with SET NOCOUNT ON",
+		"unused-variable @unused is declared but never read",
+		"unused-variable @total is declared but never read",
+		"select-star SELECT * returns every column",
+		"non-sargable YEAR(placed_at) wraps column placed_at",
+		"select-star SELECT o.* returns every column",
+		"three-part-name Sales.dbo.customers names database Sales",
+		"non-sargable (c.id + 0) computes on column c.id",
+		"non-sargable LIKE '%smith' starts with a wildcard",
+		"three-part-name Remote.Sales.dbo.Sync names linked server Remote",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("finding %d = %q, want %q...", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	source := `CREATE PROCEDURE dbo.P AS
+SET XACT_ABORT, NOCOUNT ON
+SELECT * FROM t`
+	cfg := Config{}
+	if err := cfg.Set("select-star", Error); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("no-such-rule", Error); err == nil {
+		t.Error("unknown rule accepted")
+	}
+	findings, err := Lint(source, "", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Severity != Error || findings[0].String() != "3:1: error: SELECT * returns every column; list the columns the caller needs [select-star]" {
+		t.Fatalf("findings = %v", findings)
+	}
+
+	cfg["select-star"] = Off
+	if findings, _ := Lint(source, "", cfg); len(findings) != 0 {
+		t.Errorf("rule turned off: %v", findings)
+	}
+
+	if _, err := Lint("SELECT FROM WHERE", "", nil); err == nil {
+		t.Error("parse error not reported")
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for _, s := range []Severity{Off, Info, Warning, Error} {
+		if got, err := ParseSeverity(strings.ToUpper(s.String())); err != nil || got != s {
+			t.Errorf("ParseSeverity(%q) = %v, %v", s, got, err)
+		}
+	}
+	if _, err := ParseSeverity("fatal"); err == nil {
+		t.Error("fatal accepted")
+	}
+}
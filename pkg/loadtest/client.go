@@ -0,0 +1,156 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Client executes operations over one protocol connection. Clients are
+// used by a single goroutine.
+type Client interface {
+	Protocol() string
+	Do(ctx context.Context, op *Op) error
+	Close() error
+}
+
+// ClientFactory creates a client; id distinguishes clients in errors.
+type ClientFactory func(ctx context.Context, id int) (Client, error)
+
+// TDSClients returns a factory for clients that each hold one dedicated
+// connection from db.
+func TDSClients(db *sql.DB) ClientFactory {
+	return func(ctx context.Context, id int) (Client, error) {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("tds client %d: %w", id, err)
+		}
+		return &tdsClient{conn: conn}, nil
+	}
+}
+
+type tdsClient struct {
+	conn *sql.Conn
+}
+
+func (c *tdsClient) Protocol() string { return "tds" }
+
+func (c *tdsClient) Do(ctx context.Context, op *Op) error {
+	query := op.SQL
+	var args []interface{}
+	if op.Procedure != "" {
+		query, args = execStatement(op.Procedure, op.Parameters)
+	}
+
+	rows, err := c.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Drain every result set so the server does the full amount of work
+	for {
+		for rows.Next() {
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+func (c *tdsClient) Close() error {
+	return c.conn.Close()
+}
+
+// execStatement builds an EXEC batch passing params by name.
+func execStatement(proc string, params map[string]interface{}) (string, []interface{}) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, strings.TrimPrefix(name, "@"))
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("EXEC ")
+	b.WriteString(proc)
+	args := make([]interface{}, 0, len(names))
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, " @%s = @%s", name, name)
+		v, ok := params[name]
+		if !ok {
+			v = params["@"+name]
+		}
+		args = append(args, sql.Named(name, v))
+	}
+	return b.String(), args
+}
+
+// HTTPClients returns a factory for clients of the HTTP API at baseURL.
+func HTTPClients(baseURL string) ClientFactory {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return func(ctx context.Context, id int) (Client, error) {
+		return &httpClient{
+			url:    baseURL + "/exec",
+			client: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+}
+
+type httpClient struct {
+	url    string
+	client *http.Client
+}
+
+func (c *httpClient) Protocol() string { return "http" }
+
+func (c *httpClient) Do(ctx context.Context, op *Op) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"procedure":  op.Procedure,
+		"sql":        op.SQL,
+		"parameters": op.Parameters,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if !result.Success {
+		return fmt.Errorf("%s", result.Error)
+	}
+	return nil
+}
+
+func (c *httpClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
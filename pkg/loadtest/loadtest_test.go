@@ -0,0 +1,203 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkloadValidate(t *testing.T) {
+	w := &Workload{Ops: []Op{
+		{Procedure: "dbo.GetCustomer"},
+		{SQL: "SELECT 1", Weight: 3},
+	}}
+	if err := w.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if w.Ops[0].Name != "dbo.GetCustomer" || w.Ops[0].Weight != 1 {
+		t.Errorf("op 0 defaults = %q/%d", w.Ops[0].Name, w.Ops[0].Weight)
+	}
+	if w.Ops[1].Name != "op2" {
+		t.Errorf("op 1 name = %q, want op2", w.Ops[1].Name)
+	}
+
+	bad := []*Workload{
+		{},
+		{Ops: []Op{{}}},
+		{Ops: []Op{{Procedure: "p", SQL: "SELECT 1"}}},
+		{Ops: []Op{{SQL: "SELECT 1", Weight: -1}}},
+	}
+	for i, w := range bad {
+		if err := w.Validate(); err == nil {
+			t.Errorf("workload %d: expected error", i)
+		}
+	}
+}
+
+func TestWorkloadPick(t *testing.T) {
+	w := &Workload{Ops: []Op{
+		{Name: "a", SQL: "SELECT 1", Weight: 9},
+		{Name: "b", SQL: "SELECT 2", Weight: 1},
+	}}
+	if err := w.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[w.Pick(rng).Name]++
+	}
+	if counts["a"] < 8500 || counts["a"] > 9500 {
+		t.Errorf("a picked %d/10000 times, want about 9000", counts["a"])
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var lat []time.Duration
+	for i := 100; i >= 1; i-- {
+		lat = append(lat, time.Duration(i)*time.Millisecond)
+	}
+	s := newOpStats("x", "tds", lat, 5, 10*time.Second)
+
+	tests := []struct {
+		name string
+		got  time.Duration
+		want time.Duration
+	}{
+		{"min", s.Min, 1 * time.Millisecond},
+		{"p50", s.P50, 50 * time.Millisecond},
+		{"p90", s.P90, 90 * time.Millisecond},
+		{"p99", s.P99, 99 * time.Millisecond},
+		{"max", s.Max, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if s.ErrorRate != 0.05 {
+		t.Errorf("ErrorRate = %v, want 0.05", s.ErrorRate)
+	}
+	if s.Throughput != 10 {
+		t.Errorf("Throughput = %v, want 10", s.Throughput)
+	}
+}
+
+func TestExecStatement(t *testing.T) {
+	query, args := execStatement("dbo.PlaceOrder", map[string]interface{}{
+		"Qty":         2,
+		"@CustomerID": 42,
+	})
+	if want := "EXEC dbo.PlaceOrder @CustomerID = @CustomerID, @Qty = @Qty"; query != want {
+		t.Errorf("query = %q, want %q", query, want)
+	}
+	if len(args) != 2 {
+		t.Fatalf("got %d args, want 2", len(args))
+	}
+	if a := args[0].(sql.NamedArg); a.Name != "CustomerID" || a.Value != 42 {
+		t.Errorf("args[0] = %+v", a)
+	}
+}
+
+// fakeClient fails every operation named "bad".
+type fakeClient struct {
+	closed *int32
+}
+
+func (c *fakeClient) Protocol() string { return "fake" }
+
+func (c *fakeClient) Do(ctx context.Context, op *Op) error {
+	time.Sleep(time.Millisecond)
+	if op.Name == "bad" {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func (c *fakeClient) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	var closed int32
+	factory := func(ctx context.Context, id int) (Client, error) {
+		if id == 3 {
+			return nil, errors.New("refused")
+		}
+		return &fakeClient{closed: &closed}, nil
+	}
+
+	var sampled int32
+	cfg := Config{
+		Workload: &Workload{Ops: []Op{
+			{Name: "good", SQL: "SELECT 1", Weight: 3},
+			{Name: "bad", SQL: "SELECT 2", Weight: 1},
+		}},
+		Groups:         []ClientGroup{{Count: 3, Factory: factory}},
+		Duration:       200 * time.Millisecond,
+		SampleInterval: 50 * time.Millisecond,
+		Sampler: func() ServerSample {
+			n := atomic.AddInt32(&sampled, 1)
+			return ServerSample{Goroutines: int(n), TotalExecutions: int64(n) * 10}
+		},
+	}
+
+	report, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Clients != 2 || len(report.SetupErrors) != 1 {
+		t.Errorf("clients = %d, setup errors = %v", report.Clients, report.SetupErrors)
+	}
+	if closed != 2 {
+		t.Errorf("closed %d clients, want 2", closed)
+	}
+	if len(report.Ops) != 2 {
+		t.Fatalf("got %d op stats, want 2", len(report.Ops))
+	}
+	bad := report.Ops[0]
+	if bad.Name != "bad" || bad.Errors != bad.Count || bad.ErrorMessages["boom"] != bad.Count {
+		t.Errorf("bad stats = %+v", bad)
+	}
+	if report.Ops[1].Errors != 0 {
+		t.Errorf("good op has %d errors", report.Ops[1].Errors)
+	}
+	if report.Total.Count != bad.Count+report.Ops[1].Count {
+		t.Errorf("total count %d does not match ops", report.Total.Count)
+	}
+
+	sum, ok := report.ServerSummary()
+	if !ok || sum.PeakGoroutines != len(report.Samples) || sum.Executions != int64(len(report.Samples)-1)*10 {
+		t.Errorf("server summary = %+v over %d samples", sum, len(report.Samples))
+	}
+
+	var buf bytes.Buffer
+	report.WriteText(&buf)
+	for _, want := range []string{"TOTAL", "Peak goroutines", "setup: refused", "boom"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text report missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestRunNoClients(t *testing.T) {
+	factory := func(ctx context.Context, id int) (Client, error) {
+		return nil, errors.New("refused")
+	}
+	_, err := Run(context.Background(), Config{
+		Workload: SQLWorkload("SELECT 1"),
+		Groups:   []ClientGroup{{Count: 2, Factory: factory}},
+		Duration: time.Second,
+	})
+	if err == nil {
+		t.Fatal("expected error when no client connects")
+	}
+}
@@ -0,0 +1,175 @@
+package loadtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// OpStats summarises the measurements for one operation and protocol.
+type OpStats struct {
+	Name          string           `json:"name"`
+	Protocol      string           `json:"protocol,omitempty"`
+	Count         int64            `json:"count"`
+	Errors        int64            `json:"errors"`
+	ErrorRate     float64          `json:"error_rate"`
+	Throughput    float64          `json:"ops_per_sec"`
+	Min           time.Duration    `json:"min_ns"`
+	Mean          time.Duration    `json:"mean_ns"`
+	P50           time.Duration    `json:"p50_ns"`
+	P90           time.Duration    `json:"p90_ns"`
+	P99           time.Duration    `json:"p99_ns"`
+	Max           time.Duration    `json:"max_ns"`
+	ErrorMessages map[string]int64 `json:"error_messages,omitempty"`
+}
+
+// newOpStats computes statistics from raw latencies. latencies is sorted
+// in place.
+func newOpStats(name, proto string, latencies []time.Duration, errors int64, elapsed time.Duration) OpStats {
+	s := OpStats{
+		Name:     name,
+		Protocol: proto,
+		Count:    int64(len(latencies)),
+		Errors:   errors,
+	}
+	if len(latencies) == 0 {
+		return s
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, d := range latencies {
+		sum += d
+	}
+	s.ErrorRate = float64(errors) / float64(len(latencies))
+	if elapsed > 0 {
+		s.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	}
+	s.Min = latencies[0]
+	s.Max = latencies[len(latencies)-1]
+	s.Mean = sum / time.Duration(len(latencies))
+	s.P50 = percentile(latencies, 50)
+	s.P90 = percentile(latencies, 90)
+	s.P99 = percentile(latencies, 99)
+	return s
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Report is the outcome of a load test.
+type Report struct {
+	Duration    time.Duration  `json:"duration_ns"`
+	Clients     int            `json:"clients"`
+	Total       OpStats        `json:"total"`
+	Ops         []OpStats      `json:"ops"`
+	Samples     []ServerSample `json:"server_samples,omitempty"`
+	SetupErrors []string       `json:"setup_errors,omitempty"`
+}
+
+// ServerSummary condenses the server samples.
+type ServerSummary struct {
+	PeakGoroutines       int
+	PeakHeapAllocBytes   uint64
+	PeakActiveExecutions int64
+	PeakConnections      int
+	Executions           int64 // Executions completed during the run
+}
+
+// ServerSummary returns peak readings across samples; ok is false when no
+// samples were taken.
+func (r *Report) ServerSummary() (s ServerSummary, ok bool) {
+	if len(r.Samples) == 0 {
+		return s, false
+	}
+	for _, smp := range r.Samples {
+		if smp.Goroutines > s.PeakGoroutines {
+			s.PeakGoroutines = smp.Goroutines
+		}
+		if smp.HeapAllocBytes > s.PeakHeapAllocBytes {
+			s.PeakHeapAllocBytes = smp.HeapAllocBytes
+		}
+		if smp.ActiveExecutions > s.PeakActiveExecutions {
+			s.PeakActiveExecutions = smp.ActiveExecutions
+		}
+		if smp.Connections > s.PeakConnections {
+			s.PeakConnections = smp.Connections
+		}
+	}
+	s.Executions = r.Samples[len(r.Samples)-1].TotalExecutions - r.Samples[0].TotalExecutions
+	return s, true
+}
+
+// WriteText writes a human-readable report.
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Load test: %d clients for %s\n\n", r.Clients, r.Duration.Round(time.Millisecond))
+
+	fmt.Fprintf(w, "  %-24s %-5s %9s %7s %9s %9s %9s %9s %9s\n",
+		"Operation", "Proto", "Count", "Err%", "Ops/s", "p50", "p90", "p99", "Max")
+	row := func(s OpStats) {
+		fmt.Fprintf(w, "  %-24s %-5s %9d %6.2f%% %9.1f %9s %9s %9s %9s\n",
+			s.Name, s.Protocol, s.Count, 100*s.ErrorRate, s.Throughput,
+			fmtLatency(s.P50), fmtLatency(s.P90), fmtLatency(s.P99), fmtLatency(s.Max))
+	}
+	for _, s := range r.Ops {
+		row(s)
+	}
+	row(r.Total)
+
+	if sum, ok := r.ServerSummary(); ok {
+		fmt.Fprintf(w, "\nServer:\n")
+		fmt.Fprintf(w, "  Executions:          %d\n", sum.Executions)
+		fmt.Fprintf(w, "  Peak active execs:   %d\n", sum.PeakActiveExecutions)
+		fmt.Fprintf(w, "  Peak connections:    %d\n", sum.PeakConnections)
+		fmt.Fprintf(w, "  Peak goroutines:     %d\n", sum.PeakGoroutines)
+		fmt.Fprintf(w, "  Peak heap:           %.1f MiB\n", float64(sum.PeakHeapAllocBytes)/(1<<20))
+	}
+
+	var errored []OpStats
+	for _, s := range r.Ops {
+		if len(s.ErrorMessages) > 0 {
+			errored = append(errored, s)
+		}
+	}
+	if len(errored) > 0 || len(r.SetupErrors) > 0 {
+		fmt.Fprintf(w, "\nErrors:\n")
+		for _, e := range r.SetupErrors {
+			fmt.Fprintf(w, "  setup: %s\n", e)
+		}
+		for _, s := range errored {
+			for msg, n := range s.ErrorMessages {
+				fmt.Fprintf(w, "  %s/%s (%d): %s\n", s.Name, s.Protocol, n, msg)
+			}
+		}
+	}
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// fmtLatency formats a latency with a precision suited to its magnitude.
+func fmtLatency(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	case d >= time.Millisecond:
+		return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	}
+}
@@ -0,0 +1,245 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClientGroup is a number of clients created by one factory.
+type ClientGroup struct {
+	Count   int
+	Factory ClientFactory
+}
+
+// ServerSample is a point-in-time reading of server resource usage.
+type ServerSample struct {
+	Time             time.Time `json:"time"`
+	Goroutines       int       `json:"goroutines"`
+	HeapAllocBytes   uint64    `json:"heap_alloc_bytes"`
+	ActiveExecutions int64     `json:"active_executions"`
+	TotalExecutions  int64     `json:"total_executions"`
+	Connections      int       `json:"connections"`
+}
+
+// Config controls a load test run.
+type Config struct {
+	Workload *Workload
+	Groups   []ClientGroup
+	Duration time.Duration
+
+	// Sampler reads server resource usage. It is optional; without it the
+	// report contains client-side measurements only.
+	Sampler        func() ServerSample
+	SampleInterval time.Duration
+
+	// Progress, if set, is called every SampleInterval with the
+	// operations completed so far.
+	Progress func(elapsed time.Duration, ops, errors int64)
+}
+
+// DefaultSampleInterval is used when Config.SampleInterval is zero.
+const DefaultSampleInterval = time.Second
+
+// maxErrorSamples bounds the distinct error messages kept per operation.
+const maxErrorSamples = 5
+
+// recorder accumulates the measurements of one client.
+type recorder struct {
+	latencies map[statKey][]time.Duration
+	errors    map[statKey]int64
+	messages  map[statKey]map[string]int64
+}
+
+type statKey struct {
+	op       string
+	protocol string
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		latencies: make(map[statKey][]time.Duration),
+		errors:    make(map[statKey]int64),
+		messages:  make(map[statKey]map[string]int64),
+	}
+}
+
+func (r *recorder) record(key statKey, d time.Duration, err error) {
+	r.latencies[key] = append(r.latencies[key], d)
+	if err == nil {
+		return
+	}
+	r.errors[key]++
+	msgs := r.messages[key]
+	if msgs == nil {
+		msgs = make(map[string]int64)
+		r.messages[key] = msgs
+	}
+	if _, ok := msgs[err.Error()]; ok || len(msgs) < maxErrorSamples {
+		msgs[err.Error()]++
+	}
+}
+
+// Run executes the load test and blocks until Duration has elapsed or ctx
+// is cancelled. Clients that fail to connect are reported as setup errors.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Workload == nil {
+		return nil, errors.New("no workload")
+	}
+	if err := cfg.Workload.Validate(); err != nil {
+		return nil, err
+	}
+	interval := cfg.SampleInterval
+	if interval <= 0 {
+		interval = DefaultSampleInterval
+	}
+
+	// Connect every client before starting the clock
+	var clients []Client
+	var setupErrors []string
+	id := 0
+	for _, g := range cfg.Groups {
+		for i := 0; i < g.Count; i++ {
+			id++
+			c, err := g.Factory(ctx, id)
+			if err != nil {
+				setupErrors = append(setupErrors, err.Error())
+				continue
+			}
+			clients = append(clients, c)
+		}
+	}
+	defer func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+	if len(clients) == 0 {
+		return nil, errors.New("no clients could connect")
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		opCount   int64
+		errCount  int64
+		recorders = make([]*recorder, len(clients))
+		wg        sync.WaitGroup
+	)
+
+	start := time.Now()
+	for i, c := range clients {
+		rec := newRecorder()
+		recorders[i] = rec
+		wg.Add(1)
+		go func(seed int64, c Client) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for runCtx.Err() == nil {
+				op := cfg.Workload.Pick(rng)
+				opStart := time.Now()
+				err := c.Do(runCtx, op)
+				elapsed := time.Since(opStart)
+
+				// An operation cut short by the end of the run is not a result
+				if runCtx.Err() != nil {
+					return
+				}
+				rec.record(statKey{op.Name, c.Protocol()}, elapsed, err)
+
+				mu.Lock()
+				opCount++
+				if err != nil {
+					errCount++
+				}
+				mu.Unlock()
+			}
+		}(start.UnixNano()+int64(i), c)
+	}
+
+	// Sample the server and report progress until the run ends
+	var samples []ServerSample
+	ticker := time.NewTicker(interval)
+	if cfg.Sampler != nil {
+		samples = append(samples, cfg.Sampler())
+	}
+sampling:
+	for {
+		select {
+		case <-runCtx.Done():
+			break sampling
+		case <-ticker.C:
+			if cfg.Sampler != nil {
+				samples = append(samples, cfg.Sampler())
+			}
+			if cfg.Progress != nil {
+				mu.Lock()
+				ops, errs := opCount, errCount
+				mu.Unlock()
+				cfg.Progress(time.Since(start), ops, errs)
+			}
+		}
+	}
+	ticker.Stop()
+	wg.Wait()
+	elapsed := time.Since(start)
+	if cfg.Sampler != nil {
+		samples = append(samples, cfg.Sampler())
+	}
+
+	report := buildReport(recorders, elapsed, samples)
+	report.Clients = len(clients)
+	report.SetupErrors = setupErrors
+	return report, nil
+}
+
+// buildReport merges client recorders into per-operation statistics.
+func buildReport(recorders []*recorder, elapsed time.Duration, samples []ServerSample) *Report {
+	merged := newRecorder()
+	for _, r := range recorders {
+		for k, v := range r.latencies {
+			merged.latencies[k] = append(merged.latencies[k], v...)
+		}
+		for k, v := range r.errors {
+			merged.errors[k] += v
+		}
+		for k, msgs := range r.messages {
+			for msg, n := range msgs {
+				if merged.messages[k] == nil {
+					merged.messages[k] = make(map[string]int64)
+				}
+				merged.messages[k][msg] += n
+			}
+		}
+	}
+
+	report := &Report{
+		Duration: elapsed,
+		Samples:  samples,
+	}
+	var all []time.Duration
+	for k, lat := range merged.latencies {
+		stats := newOpStats(k.op, k.protocol, lat, merged.errors[k], elapsed)
+		stats.ErrorMessages = merged.messages[k]
+		report.Ops = append(report.Ops, stats)
+		all = append(all, lat...)
+	}
+	sort.Slice(report.Ops, func(i, j int) bool {
+		if report.Ops[i].Name != report.Ops[j].Name {
+			return report.Ops[i].Name < report.Ops[j].Name
+		}
+		return report.Ops[i].Protocol < report.Ops[j].Protocol
+	})
+
+	var totalErrors int64
+	for _, n := range merged.errors {
+		totalErrors += n
+	}
+	report.Total = newOpStats("TOTAL", "", all, totalErrors, elapsed)
+	return report
+}
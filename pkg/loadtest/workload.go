@@ -0,0 +1,96 @@
+// Package loadtest drives concurrent protocol clients against an aul
+// server and reports latency distributions, error rates and server-side
+// resource usage.
+//
+// A Workload is a weighted set of operations, each either a stored
+// procedure call or an ad-hoc SQL batch. Every client repeatedly picks an
+// operation at random in proportion to its weight until the run ends.
+package loadtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+)
+
+// Op is a single weighted operation.
+type Op struct {
+	Name       string                 `json:"name"`
+	Weight     int                    `json:"weight"`
+	Procedure  string                 `json:"procedure,omitempty"`
+	SQL        string                 `json:"sql,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Workload is the set of operations clients choose from.
+type Workload struct {
+	Ops []Op `json:"ops"`
+
+	total int // Sum of weights, set by Validate
+}
+
+// LoadWorkload reads a workload from a JSON file.
+func LoadWorkload(path string) (*Workload, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var w Workload
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("parsing workload %s: %w", path, err)
+	}
+	if err := w.Validate(); err != nil {
+		return nil, fmt.Errorf("workload %s: %w", path, err)
+	}
+	return &w, nil
+}
+
+// SQLWorkload returns a workload that runs a single SQL batch.
+func SQLWorkload(sql string) *Workload {
+	w := &Workload{Ops: []Op{{Name: "sql", Weight: 1, SQL: sql}}}
+	w.Validate()
+	return w
+}
+
+// Validate checks the workload and fills in defaults. Ops without a name
+// are named after their procedure or position; a zero weight means 1.
+func (w *Workload) Validate() error {
+	if len(w.Ops) == 0 {
+		return fmt.Errorf("no operations defined")
+	}
+	w.total = 0
+	for i := range w.Ops {
+		op := &w.Ops[i]
+		if (op.Procedure == "") == (op.SQL == "") {
+			return fmt.Errorf("op %d: exactly one of procedure or sql is required", i)
+		}
+		if op.Weight < 0 {
+			return fmt.Errorf("op %d: negative weight", i)
+		}
+		if op.Weight == 0 {
+			op.Weight = 1
+		}
+		if op.Name == "" {
+			if op.Procedure != "" {
+				op.Name = op.Procedure
+			} else {
+				op.Name = fmt.Sprintf("op%d", i+1)
+			}
+		}
+		w.total += op.Weight
+	}
+	return nil
+}
+
+// Pick chooses an operation in proportion to its weight.
+func (w *Workload) Pick(rng *rand.Rand) *Op {
+	n := rng.Intn(w.total)
+	for i := range w.Ops {
+		n -= w.Ops[i].Weight
+		if n < 0 {
+			return &w.Ops[i]
+		}
+	}
+	return &w.Ops[len(w.Ops)-1]
+}
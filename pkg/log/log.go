@@ -7,12 +7,20 @@
 //   - Audit: Security-relevant events (authentication, authorisation)
 //   - Performance: Timing, throughput, resource utilisation
 //
-// Each category can be configured independently with its own level and output.
+// Three more categories follow a subsystem rather than a kind of event, so
+// that one part of the server can be debugged without the rest:
+//   - Protocol: Wire protocol listeners and connections (TDS, HTTP)
+//   - Interpreter: Procedures and SQL run by the interpreter
+//   - Storage: Storage backends
+//
+// Each category can be configured independently with its own level and output,
+// and levels can be changed while the server runs. Besides its outputs, a
+// logger hands every entry to its sinks: a rotating file, syslog or an OTLP
+// collector (see sink.go).
 package log
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -83,8 +91,34 @@ const (
 	CategoryApplication Category = "application" // Business logic, protocol handling
 	CategoryAudit       Category = "audit"       // Security events
 	CategoryPerformance Category = "performance" // Timing and metrics
+	CategoryProtocol    Category = "protocol"    // Wire protocol listeners and connections
+	CategoryInterpreter Category = "interpreter" // Interpreted procedures and SQL
+	CategoryStorage     Category = "storage"     // Storage backends
 )
 
+// Categories lists every category.
+var Categories = []Category{
+	CategorySystem,
+	CategoryExecution,
+	CategoryApplication,
+	CategoryAudit,
+	CategoryPerformance,
+	CategoryProtocol,
+	CategoryInterpreter,
+	CategoryStorage,
+}
+
+// ParseCategory parses a category name.
+func ParseCategory(s string) (Category, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	for _, cat := range Categories {
+		if string(cat) == name {
+			return cat, nil
+		}
+	}
+	return "", fmt.Errorf("unknown log category: %s", s)
+}
+
 // Format specifies the output format.
 type Format int
 
@@ -114,6 +148,7 @@ type Logger struct {
 	// Per-category configuration
 	levels  map[Category]Level
 	outputs map[Category]io.Writer
+	sinks   []Sink // receive every entry that passes its category's level
 
 	// Global settings
 	format       Format
@@ -146,6 +181,9 @@ type Config struct {
 	// Optional features
 	IncludeCaller bool // Include file:line in log entries
 	AsyncBuffer   int  // Async buffer size (0 = sync logging)
+
+	// Sinks receiving entries besides the outputs; closed with the logger
+	Sinks []Sink
 }
 
 // DefaultConfig returns a sensible default configuration.
@@ -171,17 +209,11 @@ func New(cfg Config) *Logger {
 		format:        cfg.Format,
 		includeCaller: cfg.IncludeCaller,
 		minLevel:      cfg.DefaultLevel,
+		sinks:         cfg.Sinks,
 	}
 
 	// Set default level for all categories
-	categories := []Category{
-		CategorySystem,
-		CategoryExecution,
-		CategoryApplication,
-		CategoryAudit,
-		CategoryPerformance,
-	}
-	for _, cat := range categories {
+	for _, cat := range Categories {
 		l.levels[cat] = cfg.DefaultLevel
 		l.outputs[cat] = cfg.Output
 	}
@@ -209,6 +241,24 @@ func (l *Logger) SetLevel(cat Category, level Level) {
 	l.levels[cat] = level
 }
 
+// Levels returns the level of each category.
+func (l *Logger) Levels() map[Category]Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	levels := make(map[Category]Level, len(l.levels))
+	for cat, level := range l.levels {
+		levels[cat] = level
+	}
+	return levels
+}
+
+// AddSink adds a sink, which the logger closes when it is closed.
+func (l *Logger) AddSink(sink Sink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sinks = append(l.sinks, sink)
+}
+
 // SetOutput sets the output writer for a category.
 func (l *Logger) SetOutput(cat Category, w io.Writer) {
 	l.mu.Lock()
@@ -223,19 +273,30 @@ func (l *Logger) SetFormat(f Format) {
 	l.format = f
 }
 
-// Close shuts down the logger, flushing any buffered entries.
+// Close shuts down the logger, flushing any buffered entries and closing
+// its sinks.
 func (l *Logger) Close() error {
-	if !l.asyncEnabled {
-		return nil
-	}
-
 	if !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
 		return nil // Already closed
 	}
 
-	close(l.entryChan)
-	l.wg.Wait()
-	return nil
+	if l.asyncEnabled {
+		close(l.entryChan)
+		l.wg.Wait()
+	}
+
+	l.mu.Lock()
+	sinks := l.sinks
+	l.sinks = nil
+	l.mu.Unlock()
+
+	var firstErr error
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // Stats returns logging statistics.
@@ -302,12 +363,25 @@ func (l *Logger) Performance() *CategoryLogger {
 	return &CategoryLogger{logger: l, category: CategoryPerformance}
 }
 
+// Protocol returns a category logger for wire protocol events.
+func (l *Logger) Protocol() *CategoryLogger {
+	return &CategoryLogger{logger: l, category: CategoryProtocol}
+}
+
+// Interpreter returns a category logger for interpreter events.
+func (l *Logger) Interpreter() *CategoryLogger {
+	return &CategoryLogger{logger: l, category: CategoryInterpreter}
+}
+
+// Storage returns a category logger for storage events.
+func (l *Logger) Storage() *CategoryLogger {
+	return &CategoryLogger{logger: l, category: CategoryStorage}
+}
+
 // log is the internal logging implementation.
 func (l *Logger) log(level Level, cat Category, msg string, err error, fields ...interface{}) {
 	l.mu.RLock()
 	catLevel := l.levels[cat]
-	output := l.outputs[cat]
-	format := l.format
 	includeCaller := l.includeCaller
 	l.mu.RUnlock()
 
@@ -328,14 +402,27 @@ func (l *Logger) log(level Level, cat Category, msg string, err error, fields ..
 		entry.ErrorStr = err.Error()
 	}
 
-	// Parse fields (key-value pairs)
+	// Parse fields (key-value pairs); the request and session ids have
+	// entry fields of their own
 	if len(fields) > 0 {
 		entry.Fields = make(map[string]interface{})
 		for i := 0; i < len(fields)-1; i += 2 {
-			if key, ok := fields[i].(string); ok {
+			key, ok := fields[i].(string)
+			if !ok {
+				continue
+			}
+			switch id, isString := fields[i+1].(string); {
+			case key == "request_id" && isString:
+				entry.RequestID = id
+			case key == "session_id" && isString:
+				entry.SessionID = id
+			default:
 				entry.Fields[key] = fields[i+1]
 			}
 		}
+		if len(entry.Fields) == 0 {
+			entry.Fields = nil
+		}
 	}
 
 	// Add caller information if enabled
@@ -358,28 +445,33 @@ func (l *Logger) log(level Level, cat Category, msg string, err error, fields ..
 			atomic.AddInt64(&l.entriesDropped, 1)
 		}
 	} else {
-		l.writeEntry(output, format, entry)
+		l.dispatch(entry)
 		atomic.AddInt64(&l.entriesLogged, 1)
 	}
 }
 
-// writeEntry formats and writes an entry.
-func (l *Logger) writeEntry(w io.Writer, format Format, entry *Entry) {
-	var line string
+// dispatch writes an entry to its category's output and to the sinks.
+func (l *Logger) dispatch(entry *Entry) {
+	l.mu.RLock()
+	output := l.outputs[entry.Category]
+	format := l.format
+	sinks := l.sinks
+	l.mu.RUnlock()
 
-	switch format {
-	case FormatJSON:
-		data, _ := json.Marshal(entry)
-		line = string(data) + "\n"
-	default:
-		line = l.formatText(entry)
+	l.writeEntry(output, format, entry)
+	for _, sink := range sinks {
+		// A failing sink must not stop logging; the outputs still have it
+		sink.WriteEntry(entry)
 	}
+}
 
-	w.Write([]byte(line))
+// writeEntry formats and writes an entry.
+func (l *Logger) writeEntry(w io.Writer, format Format, entry *Entry) {
+	w.Write(formatEntry(format, entry))
 }
 
 // formatText formats an entry as human-readable text.
-func (l *Logger) formatText(entry *Entry) string {
+func formatText(entry *Entry) string {
 	var buf strings.Builder
 
 	// Timestamp
@@ -390,6 +482,16 @@ func (l *Logger) formatText(entry *Entry) string {
 	buf.WriteString(fmt.Sprintf("%-5s", entry.Level.String()))
 	buf.WriteString(" ")
 
+	buf.WriteString(formatMessage(entry))
+	buf.WriteString("\n")
+	return buf.String()
+}
+
+// formatMessage formats an entry's category, caller, message, error and
+// fields, the text a line has after its timestamp and level.
+func formatMessage(entry *Entry) string {
+	var buf strings.Builder
+
 	// Category
 	buf.WriteString("[")
 	buf.WriteString(string(entry.Category))
@@ -411,6 +513,16 @@ func (l *Logger) formatText(entry *Entry) string {
 		buf.WriteString("\"")
 	}
 
+	// Request and session
+	if entry.RequestID != "" {
+		buf.WriteString(" request_id=")
+		buf.WriteString(entry.RequestID)
+	}
+	if entry.SessionID != "" {
+		buf.WriteString(" session_id=")
+		buf.WriteString(entry.SessionID)
+	}
+
 	// Fields
 	if len(entry.Fields) > 0 {
 		for k, v := range entry.Fields {
@@ -421,7 +533,6 @@ func (l *Logger) formatText(entry *Entry) string {
 		}
 	}
 
-	buf.WriteString("\n")
 	return buf.String()
 }
 
@@ -430,12 +541,7 @@ func (l *Logger) asyncWriter() {
 	defer l.wg.Done()
 
 	for entry := range l.entryChan {
-		l.mu.RLock()
-		output := l.outputs[entry.Category]
-		format := l.format
-		l.mu.RUnlock()
-
-		l.writeEntry(output, format, entry)
+		l.dispatch(entry)
 	}
 }
 
@@ -473,6 +579,20 @@ func (cl *CategoryLogger) WithFields(fields ...interface{}) *FieldLogger {
 	}
 }
 
+// WithContext returns a FieldLogger carrying the request and session ids
+// of ctx, so that entries logged while serving a request can be found by
+// the id the client was given.
+func (cl *CategoryLogger) WithContext(ctx context.Context) *FieldLogger {
+	var fields []interface{}
+	if id := RequestIDFromContext(ctx); id != "" {
+		fields = append(fields, "request_id", id)
+	}
+	if id := SessionIDFromContext(ctx); id != "" {
+		fields = append(fields, "session_id", id)
+	}
+	return cl.WithFields(fields...)
+}
+
 // FieldLogger is a category logger with preset fields.
 type FieldLogger struct {
 	categoryLogger *CategoryLogger
@@ -0,0 +1,36 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWithContext(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(Config{Output: &buf, Format: FormatJSON})
+	ctx := WithSessionID(WithRequestID(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736"), "sess_1")
+	l.Interpreter().WithContext(ctx).Info("executing", "procedure", "dbo.p")
+
+	var entry Entry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("%s: %v", buf.String(), err)
+	}
+	if entry.RequestID != "4bf92f3577b34da6a3ce929d0e0e4736" || entry.SessionID != "sess_1" {
+		t.Errorf("request_id = %q, session_id = %q", entry.RequestID, entry.SessionID)
+	}
+	if len(entry.Fields) != 1 || entry.Fields["procedure"] != "dbo.p" {
+		t.Errorf("fields = %v, want only procedure", entry.Fields)
+	}
+
+	buf.Reset()
+	l.SetFormat(FormatText)
+	l.Storage().WithContext(context.Background()).Info("no request")
+	l.Storage().Info("explicit", "request_id", "abc")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], "request_id") || !strings.HasSuffix(lines[1], "explicit request_id=abc") {
+		t.Errorf("got %q", lines)
+	}
+}
@@ -0,0 +1,248 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OTLP export settings
+const (
+	otlpBatchSize     = 100             // Entries sent in one request
+	otlpFlushInterval = time.Second     // Longest an entry waits to be sent
+	otlpQueueSize     = 4096            // Entries waiting; more are dropped
+	otlpTimeout       = 5 * time.Second // Per request
+)
+
+// otlpSink exports entries to an OpenTelemetry collector as OTLP/HTTP
+// JSON, batching them in the background so that logging never waits on
+// the network.
+type otlpSink struct {
+	endpoint string
+	service  string
+	client   *http.Client
+
+	queue chan *Entry
+	done  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// NewOTLPSink returns a sink exporting to the collector at endpoint, e.g.
+// http://localhost:4318; /v1/logs is added when the URL has no path.
+func NewOTLPSink(endpoint, service string) (Sink, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("otlp: invalid endpoint %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/logs"
+	}
+	if service == "" {
+		service = "aul"
+	}
+	s := &otlpSink{
+		endpoint: u.String(),
+		service:  service,
+		client:   &http.Client{Timeout: otlpTimeout},
+		queue:    make(chan *Entry, otlpQueueSize),
+		done:     make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+func (s *otlpSink) WriteEntry(entry *Entry) error {
+	select {
+	case <-s.done:
+		return fmt.Errorf("otlp: sink closed")
+	default:
+	}
+	select {
+	case s.queue <- entry:
+		return nil
+	default:
+		return fmt.Errorf("otlp: queue full")
+	}
+}
+
+// Close sends the entries still queued and stops the exporter.
+func (s *otlpSink) Close() error {
+	s.once.Do(func() { close(s.done) })
+	s.wg.Wait()
+	return nil
+}
+
+// run batches queued entries until the sink is closed.
+func (s *otlpSink) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*Entry
+	flush := func() {
+		if len(batch) > 0 {
+			// An unreachable collector loses the batch; the other outputs
+			// still have the entries
+			s.send(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case entry := <-s.queue:
+			batch = append(batch, entry)
+			if len(batch) >= otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.done:
+			for {
+				select {
+				case entry := <-s.queue:
+					batch = append(batch, entry)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts a batch of entries to the collector.
+func (s *otlpSink) send(batch []*Entry) error {
+	body, err := json.Marshal(s.request(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding of a logs export request
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 as a decimal string
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string          `json:"timeUnixNano"`
+	SeverityNumber int             `json:"severityNumber"`
+	SeverityText   string          `json:"severityText"`
+	Body           otlpValue       `json:"body"`
+	Attributes     []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScopeLogs struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpResourceLogs struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpExportRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+// otlpSeverity maps levels onto OTLP severity numbers.
+var otlpSeverity = map[Level]int{
+	LevelDebug: 5,
+	LevelInfo:  9,
+	LevelWarn:  13,
+	LevelError: 17,
+	LevelFatal: 21,
+}
+
+// request encodes a batch of entries.
+func (s *otlpSink) request(batch []*Entry) *otlpExportRequest {
+	var sl otlpScopeLogs
+	sl.Scope.Name = "github.com/ha1tch/aul/pkg/log"
+	for _, entry := range batch {
+		record := otlpLogRecord{
+			TimeUnixNano:   strconv.FormatInt(entry.Time.UnixNano(), 10),
+			SeverityNumber: otlpSeverity[entry.Level],
+			SeverityText:   entry.Level.String(),
+			Body:           otlpAttributeValue(entry.Message),
+			Attributes:     []otlpAttribute{{Key: "category", Value: otlpAttributeValue(string(entry.Category))}},
+		}
+		if entry.ErrorStr != "" {
+			record.Attributes = append(record.Attributes, otlpAttribute{Key: "error", Value: otlpAttributeValue(entry.ErrorStr)})
+		}
+		if entry.RequestID != "" {
+			record.Attributes = append(record.Attributes, otlpAttribute{Key: "request_id", Value: otlpAttributeValue(entry.RequestID)})
+		}
+		if entry.SessionID != "" {
+			record.Attributes = append(record.Attributes, otlpAttribute{Key: "session_id", Value: otlpAttributeValue(entry.SessionID)})
+		}
+		if entry.Caller != "" {
+			record.Attributes = append(record.Attributes, otlpAttribute{Key: "caller", Value: otlpAttributeValue(entry.Caller)})
+		}
+		for k, v := range entry.Fields {
+			record.Attributes = append(record.Attributes, otlpAttribute{Key: k, Value: otlpAttributeValue(v)})
+		}
+		sl.LogRecords = append(sl.LogRecords, record)
+	}
+
+	var rl otlpResourceLogs
+	rl.Resource.Attributes = []otlpAttribute{{Key: "service.name", Value: otlpAttributeValue(s.service)}}
+	rl.ScopeLogs = []otlpScopeLogs{sl}
+	return &otlpExportRequest{ResourceLogs: []otlpResourceLogs{rl}}
+}
+
+// otlpAttributeValue encodes a field value, as text for any type OTLP has
+// no scalar for.
+func otlpAttributeValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int32:
+		s := strconv.FormatInt(int64(v), 10)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	case time.Duration:
+		s := v.String()
+		return otlpValue{StringValue: &s}
+	default:
+		s := fmt.Sprintf("%v", v)
+		return otlpValue{StringValue: &s}
+	}
+}
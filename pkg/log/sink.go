@@ -0,0 +1,195 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Sink receives the entries a logger writes, in addition to its outputs.
+// WriteEntry may be called from several goroutines at once.
+type Sink interface {
+	WriteEntry(entry *Entry) error
+	Close() error
+}
+
+// SinkConfig selects the sinks OpenSinks creates.
+type SinkConfig struct {
+	// File to append entries to, in the logger's format; empty for none
+	File           string
+	FileMaxSize    int64 // Bytes before the file is rotated (0 = never)
+	FileMaxBackups int   // Rotated files kept, file.1 being the newest
+
+	// Syslog address: "local" for the local daemon (journald reads it
+	// too), or "udp://host:514" / "tcp://host:514"; empty for none
+	Syslog string
+
+	// OTLP/HTTP collector the entries are exported to, e.g.
+	// http://localhost:4318; empty for none
+	OTLPEndpoint string
+	ServiceName  string // service.name of the exported entries
+}
+
+// OpenSinks creates the sinks cfg selects, closing any already opened if
+// one of them fails.
+func OpenSinks(cfg SinkConfig, format Format) ([]Sink, error) {
+	var sinks []Sink
+	fail := func(err error) ([]Sink, error) {
+		for _, sink := range sinks {
+			sink.Close()
+		}
+		return nil, err
+	}
+
+	if cfg.File != "" {
+		file, err := OpenRotatingFile(cfg.File, cfg.FileMaxSize, cfg.FileMaxBackups)
+		if err != nil {
+			return fail(err)
+		}
+		sinks = append(sinks, NewWriterSink(file, format))
+	}
+	if cfg.Syslog != "" {
+		sink, err := NewSyslogSink(cfg.Syslog, "aul")
+		if err != nil {
+			return fail(err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.OTLPEndpoint != "" {
+		sink, err := NewOTLPSink(cfg.OTLPEndpoint, cfg.ServiceName)
+		if err != nil {
+			return fail(err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// formatEntry formats an entry as a line of text or JSON.
+func formatEntry(format Format, entry *Entry) []byte {
+	if format == FormatJSON {
+		data, _ := json.Marshal(entry)
+		return append(data, '\n')
+	}
+	return []byte(formatText(entry))
+}
+
+// writerSink writes formatted entries to a writer.
+type writerSink struct {
+	mu     sync.Mutex
+	w      io.WriteCloser
+	format Format
+}
+
+// NewWriterSink returns a sink writing entries to w in format, closing w
+// when it is closed.
+func NewWriterSink(w io.WriteCloser, format Format) Sink {
+	return &writerSink{w: w, format: format}
+}
+
+func (s *writerSink) WriteEntry(entry *Entry) error {
+	line := formatEntry(s.format, entry)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(line)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Close()
+}
+
+// RotatingFile is a log file that is renamed to file.1 once it reaches
+// its maximum size, shifting older files up to file.N.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// OpenRotatingFile opens path for appending. A maxSize of 0 never rotates.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("log file: %w", err)
+		}
+	}
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past its
+// maximum size. An entry is never split across files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts file.N-1 to file.N and so on down to file to file.1,
+// dropping what falls beyond maxBackups, and starts a new file.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+	f.file = nil
+	if f.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for n := f.maxBackups - 1; n >= 1; n-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, n), fmt.Sprintf("%s.%d", f.path, n+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return fmt.Errorf("log file: %w", err)
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+	return f.open()
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
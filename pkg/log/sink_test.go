@@ -0,0 +1,137 @@
+package log
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "aul.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		got, err := os.ReadFile(name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", filepath.Base(name), got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("want only 2 backups kept, stat .3: %v", err)
+	}
+}
+
+func TestSinkLevels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aul.log")
+	sinks, err := OpenSinks(SinkConfig{File: path}, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(Config{
+		DefaultLevel:   LevelInfo,
+		CategoryLevels: map[Category]Level{CategoryProtocol: LevelDebug},
+		Output:         io.Discard,
+		Sinks:          sinks,
+	})
+	l.Protocol().Debug("packet received")
+	l.Storage().Debug("page read")
+	l.SetLevel(CategoryStorage, LevelDebug)
+	l.Storage().Debug("page written")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		messages = append(messages, string(entry.Category)+": "+entry.Message)
+	}
+	if got, want := strings.Join(messages, "|"), "protocol: packet received|storage: page written"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestOTLPSink(t *testing.T) {
+	var mu sync.Mutex
+	var requests []otlpExportRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpExportRequest
+		if r.URL.Path != "/v1/logs" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	sink, err := NewOTLPSink(collector.URL, "aul-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(Config{DefaultLevel: LevelInfo, Output: io.Discard, Sinks: []Sink{sink}})
+	l.Execution().Warn("slow procedure", "procedure", "dbo.Report", "duration_ms", int64(1500))
+	l.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	rl := requests[0].ResourceLogs[0]
+	if v := rl.Resource.Attributes[0].Value.StringValue; v == nil || *v != "aul-test" {
+		t.Errorf("service.name = %v", v)
+	}
+	record := rl.ScopeLogs[0].LogRecords[0]
+	if record.SeverityNumber != 13 || *record.Body.StringValue != "slow procedure" {
+		t.Errorf("record = %+v", record)
+	}
+	attrs := make(map[string]otlpValue)
+	for _, a := range record.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if v := attrs["duration_ms"].IntValue; v == nil || *v != "1500" {
+		t.Errorf("duration_ms = %v", v)
+	}
+	if v := attrs["category"].StringValue; v == nil || *v != "execution" {
+		t.Errorf("category = %v", v)
+	}
+}
+
+func TestOpenSinksErrors(t *testing.T) {
+	for _, cfg := range []SinkConfig{
+		{Syslog: "ftp://example.com"},
+		{OTLPEndpoint: "localhost:4318"},
+	} {
+		if _, err := OpenSinks(cfg, FormatText); err == nil {
+			t.Errorf("%+v: want an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !windows && !plan9
+
+package log
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+// syslogSink sends entries to a syslog daemon, at the priority matching
+// their level.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the syslog daemon at addr: "local" for the
+// local one, else "udp://host:port" or "tcp://host:port".
+func NewSyslogSink(addr, tag string) (Sink, error) {
+	network, raddr := "", ""
+	if addr != "local" {
+		var ok bool
+		network, raddr, ok = strings.Cut(addr, "://")
+		if !ok || (network != "udp" && network != "tcp") {
+			return nil, fmt.Errorf("syslog: invalid address %q (want local, udp://host:port or tcp://host:port)", addr)
+		}
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("syslog: %w", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) WriteEntry(entry *Entry) error {
+	// The daemon adds its own timestamp, and the priority is the level
+	msg := formatMessage(entry)
+	switch entry.Level {
+	case LevelDebug:
+		return s.w.Debug(msg)
+	case LevelInfo:
+		return s.w.Info(msg)
+	case LevelWarn:
+		return s.w.Warning(msg)
+	case LevelError:
+		return s.w.Err(msg)
+	default:
+		return s.w.Crit(msg)
+	}
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
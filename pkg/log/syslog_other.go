@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package log
+
+import "fmt"
+
+// NewSyslogSink reports that syslog is not available on this platform.
+func NewSyslogSink(addr, tag string) (Sink, error) {
+	return nil, fmt.Errorf("syslog: not supported on this platform")
+}
@@ -0,0 +1,469 @@
+// Package mail sends the email that procedures queue with sp_send_dbmail.
+//
+// Mail goes out through profiles, each an SMTP server or a webhook that
+// receives the message as JSON, for mail APIs and chat bridges. Queuing a
+// message never waits on the network: a background sender delivers it,
+// retrying a failed attempt, and the Mailer keeps each message's progress
+// and an event log for the sysmail views (msdb.dbo.sysmail_allitems and
+// msdb.dbo.sysmail_event_log), as Database Mail does.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/notify"
+)
+
+// Delivery defaults
+const (
+	DefaultQueueSize  = 100
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 10 * time.Second
+	DefaultTimeout    = 30 * time.Second
+	DefaultHistory    = 1000 // Messages and events kept for the sysmail views
+
+	closeTimeout = 5 * time.Second // Longest Close waits for queued mail
+)
+
+// Message statuses, as in sysmail_allitems.sent_status
+const (
+	StatusUnsent   = "unsent"   // Queued, not yet tried
+	StatusRetrying = "retrying" // Failed at least once, to be tried again
+	StatusSent     = "sent"     // Accepted by the SMTP server or webhook
+	StatusFailed   = "failed"   // Given up on
+)
+
+// Event types, as in sysmail_event_log.event_type
+const (
+	EventInformation = "information"
+	EventWarning     = "warning"
+	EventError       = "error"
+)
+
+// Config lists the profiles mail is sent through.
+type Config struct {
+	Profiles       []ProfileConfig `json:"profiles"`
+	DefaultProfile string          `json:"default_profile"` // Used when no profile is named (default: the first)
+
+	MaxRetries int             `json:"max_retries"` // Retries after a failed attempt (0 = DefaultMaxRetries, -1 = none)
+	RetryDelay notify.Duration `json:"retry_delay"` // Wait between attempts (0 = DefaultRetryDelay)
+	Timeout    notify.Duration `json:"timeout"`     // Per attempt (0 = DefaultTimeout)
+	QueueSize  int             `json:"queue_size"`  // Messages waiting to be sent; more are refused (0 = DefaultQueueSize)
+	History    int             `json:"history"`     // Messages and events kept (0 = DefaultHistory)
+}
+
+// ProfileConfig configures one profile: an SMTP server or a webhook.
+type ProfileConfig struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	From        string         `json:"from"`     // Sender address, unless the message gives one
+	ReplyTo     string         `json:"reply_to"` // Reply-To address, unless the message gives one
+	SMTP        *SMTPConfig    `json:"smtp"`
+	Webhook     *WebhookConfig `json:"webhook"`
+}
+
+// SMTPConfig configures an SMTP server. STARTTLS is used when the server
+// offers it.
+type SMTPConfig struct {
+	Host        string `json:"host"`
+	Port        int    `json:"port"`     // Default 25, or 465 with TLS
+	Username    string `json:"username"` // Authenticates with PLAIN when set
+	Password    string `json:"password"`
+	PasswordEnv string `json:"password_env"` // Environment variable holding the password
+	TLS         bool   `json:"tls"`          // Connect over TLS rather than upgrading with STARTTLS
+}
+
+// WebhookConfig configures a webhook, which receives each Message as a
+// JSON POST.
+type WebhookConfig struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"` // Added to each request, e.g. Authorization
+}
+
+// LoadConfig reads a mail configuration from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigMissing,
+			"failed to read mail config").
+			WithOp("mail.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigParse,
+			"failed to parse mail config").
+			WithOp("mail.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	return cfg, nil
+}
+
+// Message is an email to send.
+type Message struct {
+	Profile     string       `json:"profile"`
+	From        string       `json:"from"`
+	ReplyTo     string       `json:"reply_to,omitempty"`
+	To          []string     `json:"to,omitempty"`
+	CC          []string     `json:"cc,omitempty"`
+	BCC         []string     `json:"bcc,omitempty"`
+	Subject     string       `json:"subject"`
+	Body        string       `json:"body"`
+	BodyFormat  string       `json:"body_format"` // TEXT or HTML
+	Importance  string       `json:"importance"`  // Low, Normal or High
+	Sensitivity string       `json:"sensitivity"` // Normal, Personal, Private or Confidential
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	Query string `json:"-"` // Query whose results the message carries
+	User  string `json:"-"` // Who sent it
+}
+
+// Attachment is a file attached to a message. Data is base64 in JSON.
+type Attachment struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// Item is a queued message and its progress, a row of
+// sysmail_allitems.
+type Item struct {
+	ID          int64
+	ProfileID   int
+	Message     Message
+	Status      string
+	RequestedAt time.Time
+	SentAt      time.Time // Zero until sent
+	ModifiedAt  time.Time
+}
+
+// Event is an entry of the mail event log, a row of sysmail_event_log.
+type Event struct {
+	ID          int64
+	Type        string
+	Time        time.Time
+	Description string
+	MailItemID  int64 // 0 for events about no message
+}
+
+// Profile describes a configured profile, a row of sysmail_profile.
+type Profile struct {
+	ID          int
+	Name        string
+	Description string
+	Kind        string // smtp or webhook
+}
+
+// Mailer queues and sends mail. A nil Mailer has no profiles.
+type Mailer struct {
+	logger     *log.Logger
+	profiles   []*profile
+	byName     map[string]*profile
+	fallback   *profile
+	maxRetries int
+	retryDelay time.Duration
+	history    int
+	queue      chan *Item
+
+	mu     sync.Mutex // Guards the fields below
+	items  []*Item    // Oldest first
+	events []Event    // Oldest first
+	nextID int64
+	nextEv int64
+	closed bool
+
+	ctx    context.Context // Cancelled to abandon sending on Close
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New starts sending mail through the profiles of cfg.
+func New(cfg Config, logger *log.Logger) (*Mailer, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return aulerrors.Newf(aulerrors.ErrCodeConfigInvalid, format, args...).
+			WithOp("mail.New").
+			Err()
+	}
+	if len(cfg.Profiles) == 0 {
+		return nil, invalid("no mail profiles")
+	}
+
+	m := &Mailer{
+		logger:     logger,
+		byName:     make(map[string]*profile),
+		maxRetries: cfg.MaxRetries,
+		retryDelay: time.Duration(cfg.RetryDelay),
+		history:    cfg.History,
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	for i, pc := range cfg.Profiles {
+		p, err := newProfile(i+1, pc, timeout)
+		if err != nil {
+			return nil, invalid("profile %d: %v", i+1, err)
+		}
+		key := strings.ToLower(p.name)
+		if _, dup := m.byName[key]; dup {
+			return nil, invalid("profile %q defined twice", p.name)
+		}
+		m.byName[key] = p
+		m.profiles = append(m.profiles, p)
+	}
+	m.fallback = m.profiles[0]
+	if cfg.DefaultProfile != "" {
+		p, ok := m.byName[strings.ToLower(cfg.DefaultProfile)]
+		if !ok {
+			return nil, invalid("default profile %q is not defined", cfg.DefaultProfile)
+		}
+		m.fallback = p
+	}
+
+	switch {
+	case m.maxRetries == 0:
+		m.maxRetries = DefaultMaxRetries
+	case m.maxRetries < 0:
+		m.maxRetries = 0
+	}
+	if m.retryDelay <= 0 {
+		m.retryDelay = DefaultRetryDelay
+	}
+	if m.history <= 0 {
+		m.history = DefaultHistory
+	}
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	m.queue = make(chan *Item, size)
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	m.wg.Add(1)
+	go m.run()
+	return m, nil
+}
+
+// HasProfile reports whether a profile is defined; "" names the default.
+func (m *Mailer) HasProfile(name string) bool {
+	return m.profile(name) != nil
+}
+
+// profile returns the profile called name, or the default for "".
+func (m *Mailer) profile(name string) *profile {
+	if m == nil {
+		return nil
+	}
+	if name == "" {
+		return m.fallback
+	}
+	return m.byName[strings.ToLower(name)]
+}
+
+// Send queues msg and returns its mail item ID. The message is sent
+// through its profile, or the default profile when it names none.
+func (m *Mailer) Send(msg Message) (int64, error) {
+	p := m.profile(msg.Profile)
+	if p == nil {
+		return 0, aulerrors.NotFound("mail profile", msg.Profile).WithOp("Mailer.Send").Err()
+	}
+	if len(msg.To)+len(msg.CC)+len(msg.BCC) == 0 {
+		return 0, aulerrors.InvalidInput("recipients", "at least one recipient is required").
+			WithOp("Mailer.Send").Err()
+	}
+	msg.Profile = p.name
+	if msg.From == "" {
+		msg.From = p.from
+	}
+	if msg.ReplyTo == "" {
+		msg.ReplyTo = p.replyTo
+	}
+	if msg.From == "" {
+		return 0, aulerrors.InvalidInput("from", "the profile has no sender address").
+			WithOp("Mailer.Send").
+			WithField("profile", p.name).
+			Err()
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, aulerrors.New(aulerrors.ErrCodeExecInvalidState, "mail is stopped").
+			WithOp("Mailer.Send").Err()
+	}
+	m.nextID++
+	item := &Item{
+		ID:          m.nextID,
+		ProfileID:   p.id,
+		Message:     msg,
+		Status:      StatusUnsent,
+		RequestedAt: now,
+		ModifiedAt:  now,
+	}
+	select {
+	case m.queue <- item:
+	default:
+		m.nextID--
+		return 0, aulerrors.New(aulerrors.ErrCodeResourceExhausted, "mail queue is full").
+			WithOp("Mailer.Send").Err()
+	}
+	m.items = append(m.items, item)
+	if len(m.items) > m.history {
+		m.items = m.items[len(m.items)-m.history:]
+	}
+	return item.ID, nil
+}
+
+// Items returns the messages kept, oldest first.
+func (m *Mailer) Items() []Item {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	items := make([]Item, len(m.items))
+	for i, item := range m.items {
+		items[i] = *item
+	}
+	return items
+}
+
+// Events returns the event log, oldest first.
+func (m *Mailer) Events() []Event {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Event(nil), m.events...)
+}
+
+// Profiles describes the configured profiles.
+func (m *Mailer) Profiles() []Profile {
+	if m == nil {
+		return nil
+	}
+	profiles := make([]Profile, len(m.profiles))
+	for i, p := range m.profiles {
+		profiles[i] = Profile{ID: p.id, Name: p.name, Description: p.description, Kind: p.sender.kind()}
+	}
+	return profiles
+}
+
+// Close sends the mail still queued, waiting at most a few seconds before
+// abandoning it, and stops the mailer. Mail abandoned stays unsent.
+func (m *Mailer) Close() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.queue)
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+		m.cancel()
+		<-done
+	}
+	m.cancel()
+}
+
+// run sends queued mail until the mailer is closed.
+func (m *Mailer) run() {
+	defer m.wg.Done()
+	for item := range m.queue {
+		m.deliver(item)
+	}
+}
+
+// deliver sends item, retrying a failed attempt up to maxRetries times.
+func (m *Mailer) deliver(item *Item) {
+	p := m.profile(item.Message.Profile)
+	for attempt := 0; ; attempt++ {
+		err := p.sender.send(m.ctx, item.Message)
+		if err == nil {
+			m.setStatus(item, StatusSent, "")
+			return
+		}
+		if attempt >= m.maxRetries || m.ctx.Err() != nil {
+			m.setStatus(item, StatusFailed, fmt.Sprintf(
+				"The mail could not be sent to the recipients because of the mail server failure. (Profile %s: %v)", p.name, err))
+			return
+		}
+		m.setStatus(item, StatusRetrying, fmt.Sprintf(
+			"Mail item %d could not be sent on attempt %d, retrying. (Profile %s: %v)", item.ID, attempt+1, p.name, err))
+		select {
+		case <-time.After(m.retryDelay):
+		case <-m.ctx.Done():
+			m.setStatus(item, StatusFailed, "Mail was stopped before the message was sent.")
+			return
+		}
+	}
+}
+
+// setStatus records item's progress and logs an event describing it.
+func (m *Mailer) setStatus(item *Item, status, description string) {
+	now := time.Now()
+	eventType := EventInformation
+	switch status {
+	case StatusRetrying:
+		eventType = EventWarning
+	case StatusFailed:
+		eventType = EventError
+	case StatusSent:
+		description = fmt.Sprintf("Mail item %d sent through profile %s.", item.ID, item.Message.Profile)
+	}
+
+	m.mu.Lock()
+	item.Status = status
+	item.ModifiedAt = now
+	if status == StatusSent {
+		item.SentAt = now
+	}
+	m.nextEv++
+	m.events = append(m.events, Event{
+		ID:          m.nextEv,
+		Type:        eventType,
+		Time:        now,
+		Description: description,
+		MailItemID:  item.ID,
+	})
+	if len(m.events) > m.history {
+		m.events = m.events[len(m.events)-m.history:]
+	}
+	m.mu.Unlock()
+
+	if m.logger == nil || status == StatusSent {
+		return
+	}
+	m.logger.System().Warn("mail not sent",
+		"mail_item", item.ID,
+		"profile", item.Message.Profile,
+		"status", status,
+		"reason", description,
+	)
+}
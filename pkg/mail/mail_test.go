@@ -0,0 +1,368 @@
+package mail
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/notify"
+)
+
+// waitFor waits until the item with id reaches a final status.
+func waitFor(t *testing.T, m *Mailer, id int64) Item {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		for _, item := range m.Items() {
+			if item.ID == id && (item.Status == StatusSent || item.Status == StatusFailed) {
+				return item
+			}
+		}
+	}
+	t.Fatalf("mail item %d never finished", id)
+	return Item{}
+}
+
+func TestMailer_Webhook(t *testing.T) {
+	var mu sync.Mutex
+	var got []Message
+	fail := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var msg Message
+		json.NewDecoder(r.Body).Decode(&msg)
+		got = append(got, msg)
+	}))
+	defer srv.Close()
+
+	m, err := New(Config{
+		Profiles: []ProfileConfig{{
+			Name:    "alerts",
+			From:    "aul@example.com",
+			Webhook: &WebhookConfig{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer k"}},
+		}},
+		RetryDelay: notify.Duration(time.Millisecond),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	id, err := m.Send(Message{
+		To:          []string{"dba@example.com"},
+		Subject:     "Load failed",
+		Body:        "see log",
+		Attachments: []Attachment{{Name: "errors.txt", Data: []byte("row 3")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := waitFor(t, m, id)
+	if item.Status != StatusSent || item.SentAt.IsZero() || item.Message.Profile != "alerts" {
+		t.Errorf("item: %+v", item)
+	}
+	mu.Lock()
+	if len(got) != 1 || got[0].From != "aul@example.com" || got[0].Subject != "Load failed" ||
+		len(got[0].Attachments) != 1 || string(got[0].Attachments[0].Data) != "row 3" {
+		t.Errorf("webhook received %+v", got)
+	}
+	mu.Unlock()
+
+	events := m.Events()
+	if len(events) != 2 || events[0].Type != EventWarning || events[1].Type != EventInformation || events[1].MailItemID != id {
+		t.Errorf("events: %+v", events)
+	}
+}
+
+func TestMailer_Failed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	m, err := New(Config{
+		Profiles:   []ProfileConfig{{Name: "p", From: "aul@example.com", Webhook: &WebhookConfig{URL: srv.URL}}},
+		MaxRetries: -1,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	id, err := m.Send(Message{To: []string{"a@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := waitFor(t, m, id); item.Status != StatusFailed {
+		t.Errorf("status %s", item.Status)
+	}
+	events := m.Events()
+	if len(events) != 1 || events[0].Type != EventError || !strings.Contains(events[0].Description, "500") {
+		t.Errorf("events: %+v", events)
+	}
+}
+
+func TestMailer_Send(t *testing.T) {
+	m, err := New(Config{
+		Profiles: []ProfileConfig{
+			{Name: "first", Webhook: &WebhookConfig{URL: "http://127.0.0.1:1"}},
+			{Name: "Ops", From: "ops@example.com", Webhook: &WebhookConfig{URL: "http://127.0.0.1:1"}},
+		},
+		DefaultProfile: "ops",
+		MaxRetries:     -1,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if !m.HasProfile("") || !m.HasProfile("OPS") || m.HasProfile("missing") {
+		t.Error("HasProfile")
+	}
+	if _, err := m.Send(Message{Profile: "missing", To: []string{"a@example.com"}}); !aulerrors.IsCode(err, aulerrors.ErrCodeProcNotFound) {
+		t.Errorf("unknown profile: %v", err)
+	}
+	if _, err := m.Send(Message{}); !aulerrors.IsCode(err, aulerrors.ErrCodeProcInvalidParam) {
+		t.Errorf("no recipients: %v", err)
+	}
+	if _, err := m.Send(Message{Profile: "first", To: []string{"a@example.com"}}); err == nil {
+		t.Error("a profile without a sender address sent mail")
+	}
+	id, err := m.Send(Message{To: []string{"a@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := waitFor(t, m, id); item.Message.Profile != "Ops" || item.Message.From != "ops@example.com" || item.ProfileID != 2 {
+		t.Errorf("default profile: %+v", item)
+	}
+
+	m.Close()
+	if _, err := m.Send(Message{To: []string{"a@example.com"}}); err == nil {
+		t.Error("sent after Close")
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	hook := &WebhookConfig{URL: "https://hooks.example.com/mail"}
+	tests := map[string]Config{
+		"no profiles":        {},
+		"no name":            {Profiles: []ProfileConfig{{Webhook: hook}}},
+		"no transport":       {Profiles: []ProfileConfig{{Name: "p"}}},
+		"both transports":    {Profiles: []ProfileConfig{{Name: "p", Webhook: hook, SMTP: &SMTPConfig{Host: "mx"}}}},
+		"bad url":            {Profiles: []ProfileConfig{{Name: "p", Webhook: &WebhookConfig{URL: "ftp://x"}}}},
+		"no smtp host":       {Profiles: []ProfileConfig{{Name: "p", SMTP: &SMTPConfig{}}}},
+		"bad from":           {Profiles: []ProfileConfig{{Name: "p", From: "not an address", Webhook: hook}}},
+		"duplicate":          {Profiles: []ProfileConfig{{Name: "p", Webhook: hook}, {Name: "P", Webhook: hook}}},
+		"unknown default":    {Profiles: []ProfileConfig{{Name: "p", Webhook: hook}}, DefaultProfile: "q"},
+		"empty password env": {Profiles: []ProfileConfig{{Name: "p", SMTP: &SMTPConfig{Host: "mx", PasswordEnv: "AUL_TEST_UNSET_PASSWORD"}}}},
+	}
+	for name, cfg := range tests {
+		if _, err := New(cfg, nil); !aulerrors.IsCode(err, aulerrors.ErrCodeConfigInvalid) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mail.json")
+	os.WriteFile(path, []byte(`{
+		"profiles": [{"name": "alerts", "from": "aul@example.com", "smtp": {"host": "mx.example.com", "port": 587, "username": "aul"}}],
+		"retry_delay": "30s"
+	}`), 0o600)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Profiles) != 1 || cfg.Profiles[0].SMTP.Port != 587 || time.Duration(cfg.RetryDelay) != 30*time.Second {
+		t.Errorf("got %+v", cfg)
+	}
+
+	os.WriteFile(path, []byte(`{"profiles": [], "typo": 1}`), 0o600)
+	if _, err := LoadConfig(path); !aulerrors.IsCode(err, aulerrors.ErrCodeConfigParse) {
+		t.Errorf("unknown field: %v", err)
+	}
+	if _, err := LoadConfig(filepath.Join(dir, "missing.json")); !aulerrors.IsCode(err, aulerrors.ErrCodeConfigMissing) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+// fakeSMTP accepts one message per connection and records the envelope
+// and data.
+type fakeSMTP struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	from string
+	rcpt []string
+	data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSMTP{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			f.mu.Lock()
+			f.from = strings.TrimSpace(line[len("MAIL FROM:"):])
+			f.mu.Unlock()
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			f.mu.Lock()
+			f.rcpt = append(f.rcpt, strings.TrimSpace(line[len("RCPT TO:"):]))
+			f.mu.Unlock()
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			f.mu.Lock()
+			f.data = b.String()
+			f.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown")
+		}
+	}
+}
+
+func TestMailer_SMTP(t *testing.T) {
+	f := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(f.ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	m, err := New(Config{Profiles: []ProfileConfig{{
+		Name: "smtp",
+		From: "AUL <aul@example.com>",
+		SMTP: &SMTPConfig{Host: host, Port: portNum},
+	}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	id, err := m.Send(Message{
+		To:         []string{"dba@example.com"},
+		BCC:        []string{"audit@example.com"},
+		Subject:    "Nightly load",
+		Body:       "<b>done</b>",
+		BodyFormat: "HTML",
+		Importance: "High",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := waitFor(t, m, id); item.Status != StatusSent {
+		t.Fatalf("status %s, events %+v", item.Status, m.Events())
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.from != "<aul@example.com>" || strings.Join(f.rcpt, ",") != "<dba@example.com>,<audit@example.com>" {
+		t.Errorf("envelope from %s to %v", f.from, f.rcpt)
+	}
+	for _, want := range []string{"To: <dba@example.com>", "Subject: Nightly load", "Content-Type: text/html", "Importance: high", "<b>done</b>"} {
+		if !strings.Contains(f.data, want) {
+			t.Errorf("message lacks %q:\n%s", want, f.data)
+		}
+	}
+	if strings.Contains(f.data, "audit@example.com") {
+		t.Errorf("Bcc recipient in the headers:\n%s", f.data)
+	}
+}
+
+func TestBuildMessage(t *testing.T) {
+	data, err := buildMessage(Message{
+		From:        "aul@example.com",
+		To:          []string{"a@example.com", "B <b@example.com>"},
+		CC:          []string{"c@example.com"},
+		ReplyTo:     "noreply@example.com",
+		Subject:     "Résumé\r\nBcc: evil@example.com",
+		Body:        "line one",
+		Sensitivity: "Confidential",
+		Attachments: []Attachment{{Name: "results.txt", Data: []byte("id qty")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(data)
+	for _, want := range []string{
+		"To: <a@example.com>, \"B\" <b@example.com>",
+		"Cc: <c@example.com>",
+		"Reply-To: <noreply@example.com>",
+		"Subject: =?utf-8?q?",
+		"Sensitivity: Company-Confidential",
+		"multipart/mixed",
+		"filename=results.txt",
+		"aWQgcXR5", // base64 of the attachment
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "\r\nBcc:") {
+		t.Errorf("header injected:\n%s", msg)
+	}
+	if _, err := buildMessage(Message{From: "aul@example.com", To: []string{"not an address"}}); err == nil {
+		t.Error("invalid recipient accepted")
+	}
+}
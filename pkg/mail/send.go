@@ -0,0 +1,362 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profile is a configured profile and the sender it uses.
+type profile struct {
+	id          int
+	name        string
+	description string
+	from        string
+	replyTo     string
+	sender      sender
+}
+
+// sender delivers a message.
+type sender interface {
+	send(ctx context.Context, msg Message) error
+	kind() string
+}
+
+func newProfile(id int, cfg ProfileConfig, timeout time.Duration) (*profile, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("no name")
+	}
+	p := &profile{id: id, name: cfg.Name, description: cfg.Description}
+	for _, addr := range []*string{&cfg.From, &cfg.ReplyTo} {
+		if *addr == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(*addr); err != nil {
+			return nil, fmt.Errorf("invalid address %q: %v", *addr, err)
+		}
+	}
+	p.from, p.replyTo = cfg.From, cfg.ReplyTo
+
+	switch {
+	case cfg.SMTP != nil && cfg.Webhook != nil:
+		return nil, fmt.Errorf("profile %s has both smtp and webhook", cfg.Name)
+	case cfg.SMTP != nil:
+		s, err := newSMTPSender(*cfg.SMTP, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", cfg.Name, err)
+		}
+		p.sender = s
+	case cfg.Webhook != nil:
+		u, err := url.Parse(cfg.Webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("profile %s: invalid url %q", cfg.Name, cfg.Webhook.URL)
+		}
+		p.sender = &webhookSender{
+			url:     u.String(),
+			headers: cfg.Webhook.Headers,
+			client:  &http.Client{Timeout: timeout},
+		}
+	default:
+		return nil, fmt.Errorf("profile %s has neither smtp nor webhook", cfg.Name)
+	}
+	return p, nil
+}
+
+// -----------------------------------------------------------------------------
+// SMTP
+// -----------------------------------------------------------------------------
+
+type smtpSender struct {
+	addr     string
+	host     string
+	username string
+	password string
+	tls      bool
+	timeout  time.Duration
+}
+
+func newSMTPSender(cfg SMTPConfig, timeout time.Duration) (*smtpSender, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("smtp host is required")
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+		if cfg.TLS {
+			port = 465
+		}
+	}
+	password := cfg.Password
+	if cfg.PasswordEnv != "" {
+		password = os.Getenv(cfg.PasswordEnv)
+		if password == "" {
+			return nil, fmt.Errorf("environment variable %s is empty", cfg.PasswordEnv)
+		}
+	}
+	return &smtpSender{
+		addr:     net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		host:     cfg.Host,
+		username: cfg.Username,
+		password: password,
+		tls:      cfg.TLS,
+		timeout:  timeout,
+	}, nil
+}
+
+func (s *smtpSender) kind() string { return "smtp" }
+
+func (s *smtpSender) send(ctx context.Context, msg Message) error {
+	data, err := buildMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	if s.tls {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: s.host}}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !s.tls {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, list := range [][]string{msg.To, msg.CC, msg.BCC} {
+		for _, rcpt := range list {
+			addr, err := mail.ParseAddress(rcpt)
+			if err != nil {
+				return err
+			}
+			if err := c.Rcpt(addr.Address); err != nil {
+				return err
+			}
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMessage renders msg as an RFC 5322 message: quoted-printable text
+// or HTML, with any attachments in a multipart/mixed body. Bcc is left
+// out of the headers.
+func buildMessage(msg Message) ([]byte, error) {
+	var b bytes.Buffer
+	header := func(name, value string) {
+		value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	addresses := func(list []string) (string, error) {
+		out := make([]string, len(list))
+		for i, a := range list {
+			addr, err := mail.ParseAddress(a)
+			if err != nil {
+				return "", fmt.Errorf("invalid address %q: %v", a, err)
+			}
+			out[i] = addr.String()
+		}
+		return strings.Join(out, ", "), nil
+	}
+
+	from, err := addresses([]string{msg.From})
+	if err != nil {
+		return nil, err
+	}
+	header("From", from)
+	for _, h := range []struct {
+		name string
+		list []string
+	}{{"To", msg.To}, {"Cc", msg.CC}, {"Reply-To", nonEmpty(msg.ReplyTo)}} {
+		if len(h.list) == 0 {
+			continue
+		}
+		value, err := addresses(h.list)
+		if err != nil {
+			return nil, err
+		}
+		header(h.name, value)
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(msg.From))
+	header("MIME-Version", "1.0")
+	if imp := strings.ToLower(msg.Importance); imp == "high" || imp == "low" {
+		header("Importance", imp)
+		header("X-Priority", map[string]string{"high": "1", "low": "5"}[imp])
+	}
+	sensitivity := map[string]string{
+		"personal": "Personal", "private": "Private", "confidential": "Company-Confidential",
+	}[strings.ToLower(msg.Sensitivity)]
+	if sensitivity != "" {
+		header("Sensitivity", sensitivity)
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if strings.EqualFold(msg.BodyFormat, "HTML") {
+		contentType = "text/html; charset=utf-8"
+	}
+	if len(msg.Attachments) == 0 {
+		header("Content-Type", contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		if err := writeQuotedPrintable(&b, msg.Body); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&b)
+	header("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	b.WriteString("\r\n")
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(part, msg.Body); err != nil {
+		return nil, err
+	}
+	for _, a := range msg.Attachments {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType("application/octet-stream", map[string]string{"name": a.Name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			io.WriteString(part, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		io.WriteString(part, encoded+"\r\n")
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, s); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// nonEmpty returns a list of s, or nil when s is empty.
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// messageID returns a unique Message-ID in the sender's domain.
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndexByte(addr.Address, '@'); at >= 0 {
+			domain = addr.Address[at+1:]
+		}
+	}
+	var buf [12]byte
+	rand.Read(buf[:])
+	return fmt.Sprintf("<%x.%d@%s>", buf, time.Now().UnixNano(), domain)
+}
+
+// -----------------------------------------------------------------------------
+// Webhook
+// -----------------------------------------------------------------------------
+
+type webhookSender struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (w *webhookSender) kind() string { return "webhook" }
+
+// send posts msg as JSON. Any 2xx response counts as sent.
+func (w *webhookSender) send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
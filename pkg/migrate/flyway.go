@@ -0,0 +1,412 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// flywayTable is Flyway's history table.
+const flywayTable = "flyway_schema_history"
+
+const createFlywayTable = `CREATE TABLE flyway_schema_history (
+  installed_rank INT NOT NULL PRIMARY KEY,
+  version NVARCHAR(50) NULL,
+  description NVARCHAR(200) NULL,
+  type NVARCHAR(20) NOT NULL,
+  script NVARCHAR(1000) NOT NULL,
+  checksum INT NULL,
+  installed_by NVARCHAR(100) NOT NULL,
+  installed_on DATETIME NOT NULL,
+  execution_time INT NOT NULL,
+  success BIT NOT NULL
+)`
+
+// flywayMigration is a Flyway script: versioned (V<version>__<description>.sql)
+// or repeatable (R__<description>.sql).
+type flywayMigration struct {
+	Version     string // "" for a repeatable migration
+	Description string
+	Script      string // Path relative to the migration directory
+	Checksum    int32
+	SQL         string
+}
+
+func (m *flywayMigration) name() string {
+	if m.Version == "" {
+		return "repeatable " + m.Description
+	}
+	return m.Version + " " + m.Description
+}
+
+// flywayVersion is a valid version, after underscores become dots.
+var flywayVersion = regexp.MustCompile(`^\d+(\.\d+)*$`)
+
+// parseFlywayName parses a script's file name. ok is false for names that
+// are not Flyway migrations, which Flyway ignores; undo migrations (U) are
+// ignored too, as aul only migrates forward.
+func parseFlywayName(base string) (version, description string, repeatable, ok bool, err error) {
+	if !strings.HasSuffix(base, ".sql") || len(base) < 2 {
+		return "", "", false, false, nil
+	}
+	prefix := base[0]
+	if prefix != 'V' && prefix != 'R' {
+		return "", "", false, false, nil
+	}
+	rest := strings.TrimSuffix(base[1:], ".sql")
+	version, description, found := strings.Cut(rest, "__")
+	if !found {
+		return "", "", false, false, nil
+	}
+	description = strings.ReplaceAll(description, "_", " ")
+	if prefix == 'R' {
+		if version != "" {
+			return "", "", false, false, fmt.Errorf("%s: a repeatable migration has no version", base)
+		}
+		return "", description, true, true, nil
+	}
+	version = strings.ReplaceAll(version, "_", ".")
+	if !flywayVersion.MatchString(version) {
+		return "", "", false, false, fmt.Errorf("%s: invalid version %q", base, version)
+	}
+	return version, description, false, true, nil
+}
+
+// compareVersions orders Flyway versions numerically part by part, with
+// missing parts counting as zero, so 1.0 and 1 are the same version.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := "", ""
+		if i < len(as) {
+			x = strings.TrimLeft(as[i], "0")
+		}
+		if i < len(bs) {
+			y = strings.TrimLeft(bs[i], "0")
+		}
+		if len(x) != len(y) {
+			if len(x) < len(y) {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// flywayChecksum computes a script's checksum as Flyway does: the CRC-32
+// of its lines without their line breaks or byte order mark.
+func flywayChecksum(data []byte) int32 {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	crc := crc32.NewIEEE()
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), len(data)+1)
+	sc.Split(scanJavaLines)
+	for sc.Scan() {
+		crc.Write(sc.Bytes())
+	}
+	return int32(crc.Sum32())
+}
+
+// scanJavaLines splits lines as Java's BufferedReader.readLine does: at
+// \n, \r or \r\n.
+func scanJavaLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\r' {
+			if i+1 < len(data) {
+				if data[i+1] == '\n' {
+					return i + 2, data[:i], nil
+				}
+				return i + 1, data[:i], nil
+			}
+			if !atEOF {
+				return 0, nil, nil
+			}
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// loadFlyway reads the migrations in dir and its subdirectories.
+func loadFlyway(dir string) ([]*flywayMigration, error) {
+	var migrations []*flywayMigration
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		version, description, _, ok, err := parseFlywayName(d.Name())
+		if err != nil || !ok {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		migrations = append(migrations, &flywayMigration{
+			Version:     version,
+			Description: description,
+			Script:      filepath.ToSlash(rel),
+			Checksum:    flywayChecksum(data),
+			SQL:         strings.TrimPrefix(string(data), "\ufeff"),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("%s: no Flyway migrations (V<version>__<description>.sql or R__<description>.sql)", dir)
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		a, b := migrations[i], migrations[j]
+		switch {
+		case a.Version != "" && b.Version != "":
+			return compareVersions(a.Version, b.Version) < 0
+		case a.Version != "" || b.Version != "":
+			return a.Version != "" // Repeatable migrations last
+		}
+		return a.Description < b.Description
+	})
+	for i := 1; i < len(migrations); i++ {
+		a, b := migrations[i-1], migrations[i]
+		if a.Version != "" && b.Version != "" && compareVersions(a.Version, b.Version) == 0 {
+			return nil, fmt.Errorf("version %s is used by both %s and %s", b.Version, a.Script, b.Script)
+		}
+	}
+	return migrations, nil
+}
+
+// flywayRecord is a row of flyway_schema_history.
+type flywayRecord struct {
+	Rank        int
+	Version     string
+	Description string
+	Type        string
+	Script      string
+	Checksum    *int32
+	Success     bool
+}
+
+func readFlywayHistory(ctx context.Context, db DB) ([]*flywayRecord, error) {
+	rows, err := db.QueryContext(ctx, `SELECT installed_rank, version, description, type, script, checksum, success
+FROM flyway_schema_history ORDER BY installed_rank`)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", flywayTable, err)
+	}
+	defer rows.Close()
+
+	var records []*flywayRecord
+	for rows.Next() {
+		var (
+			r                    flywayRecord
+			version, description *string
+			checksum             *int64
+		)
+		if err := rows.Scan(&r.Rank, &version, &description, &r.Type, &r.Script, &checksum, &r.Success); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", flywayTable, err)
+		}
+		if version != nil {
+			r.Version = *version
+		}
+		if description != nil {
+			r.Description = *description
+		}
+		if checksum != nil {
+			c := int32(*checksum)
+			r.Checksum = &c
+		}
+		records = append(records, &r)
+	}
+	return records, rows.Err()
+}
+
+// runFlyway applies the migrations in dir as Flyway's migrate does.
+func runFlyway(ctx context.Context, db DB, dir string, opts *Options) (*Report, error) {
+	migrations, err := loadFlyway(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []*flywayRecord
+	if tableExists(ctx, db, flywayTable) {
+		if history, err = readFlywayHistory(ctx, db); err != nil {
+			return nil, err
+		}
+	} else if !opts.DryRun {
+		opts.progress("creating %s", flywayTable)
+		if _, err := db.ExecContext(ctx, createFlywayTable); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", flywayTable, err)
+		}
+	}
+
+	pending, err := flywayPending(migrations, history, opts.OutOfOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Tool: "flyway"}
+	rank := 0
+	for _, r := range history {
+		rank = max(rank, r.Rank)
+	}
+	for _, m := range pending {
+		res := &Result{Name: m.name(), Script: m.Script, Status: StatusPending}
+		report.Results = append(report.Results, res)
+		if opts.DryRun {
+			continue
+		}
+
+		opts.progress("migrating to %s", m.name())
+		start := opts.time()
+		err := applyFlyway(ctx, db, m, opts)
+		res.Duration = opts.time().Sub(start)
+		rank++
+		if recErr := recordFlyway(ctx, db, m, rank, start, res.Duration, err == nil, opts); recErr != nil && err == nil {
+			err = fmt.Errorf("recording in %s: %w", flywayTable, recErr)
+		}
+		if err != nil {
+			res.Status, res.Error = StatusFailed, err.Error()
+			// Flyway stops at the first failure
+			break
+		}
+		res.Status = StatusApplied
+	}
+	return report, nil
+}
+
+// flywayPending validates the history against the migrations on disk
+// and returns those to apply, in order.
+func flywayPending(migrations []*flywayMigration, history []*flywayRecord, outOfOrder bool) ([]*flywayMigration, error) {
+	byVersion := make(map[string]*flywayMigration)
+	for _, m := range migrations {
+		if m.Version != "" {
+			byVersion[canonicalVersion(m.Version)] = m
+		}
+	}
+
+	applied := make(map[string]bool)
+	latestRepeatable := make(map[string]*flywayRecord)
+	newest := ""
+	for _, r := range history {
+		if !r.Success {
+			return nil, fmt.Errorf("migration %s (%s) failed earlier: clean up what it changed and delete its row from %s",
+				r.Version, r.Script, flywayTable)
+		}
+		if r.Version == "" {
+			latestRepeatable[r.Description] = r
+			continue
+		}
+		if newest == "" || compareVersions(r.Version, newest) > 0 {
+			newest = r.Version
+		}
+		applied[canonicalVersion(r.Version)] = true
+
+		// Only SQL migrations can be checked; Java ones and baselines
+		// have nothing on disk to compare with
+		if r.Type != "SQL" {
+			continue
+		}
+		m := byVersion[canonicalVersion(r.Version)]
+		if m == nil {
+			return nil, fmt.Errorf("applied migration %s (%s) is missing from the migration directory", r.Version, r.Script)
+		}
+		if r.Checksum != nil && *r.Checksum != m.Checksum {
+			return nil, fmt.Errorf("checksum mismatch for migration %s: applied %d, resolved locally %d", r.Version, *r.Checksum, m.Checksum)
+		}
+	}
+	baseline := ""
+	for _, r := range history {
+		if r.Type == "BASELINE" {
+			baseline = r.Version
+		}
+	}
+
+	var pending []*flywayMigration
+	for _, m := range migrations {
+		if m.Version == "" {
+			if r := latestRepeatable[m.Description]; r == nil || r.Checksum == nil || *r.Checksum != m.Checksum {
+				pending = append(pending, m)
+			}
+			continue
+		}
+		if applied[canonicalVersion(m.Version)] {
+			continue
+		}
+		if baseline != "" && compareVersions(m.Version, baseline) <= 0 {
+			continue
+		}
+		if newest != "" && compareVersions(m.Version, newest) < 0 && !outOfOrder {
+			return nil, fmt.Errorf("migration %s (%s) is older than the newest applied version %s; apply it out of order to run it",
+				m.Version, m.Script, newest)
+		}
+		pending = append(pending, m)
+	}
+	return pending, nil
+}
+
+// canonicalVersion drops trailing zero parts and leading zeros, so that
+// equal versions have equal keys.
+func canonicalVersion(v string) string {
+	parts := strings.Split(v, ".")
+	for i, p := range parts {
+		if parts[i] = strings.TrimLeft(p, "0"); parts[i] == "" {
+			parts[i] = "0"
+		}
+	}
+	for len(parts) > 1 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+func applyFlyway(ctx context.Context, db DB, m *flywayMigration, opts *Options) error {
+	values := map[string]string{
+		"flyway:defaultSchema": "dbo",
+		"flyway:user":          opts.user(),
+		"flyway:table":         flywayTable,
+		"flyway:filename":      filepath.Base(m.Script),
+		"flyway:timestamp":     opts.time().Format("2006-01-02 15:04:05"),
+	}
+	for k, v := range opts.Placeholders {
+		values[k] = v
+	}
+	script, err := substitute(m.SQL, values, true)
+	if err != nil {
+		return err
+	}
+	return execScript(ctx, db, script, "")
+}
+
+func recordFlyway(ctx context.Context, db DB, m *flywayMigration, rank int, on time.Time, took time.Duration, success bool, opts *Options) error {
+	ok := 0
+	if success {
+		ok = 1
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO flyway_schema_history
+  (installed_rank, version, description, type, script, checksum, installed_by, installed_on, execution_time, success)
+VALUES (%d, %s, %s, 'SQL', %s, %d, %s, %s, %d, %d)`,
+		rank, quote(m.Version, true), quote(m.Description, false), quote(m.Script, false), m.Checksum,
+		quote(opts.user(), false), timestamp(on), took.Milliseconds(), ok))
+	return err
+}
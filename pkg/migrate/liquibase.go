@@ -0,0 +1,567 @@
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// liquibaseTable is Liquibase's history table.
+const liquibaseTable = "DATABASECHANGELOG"
+
+const createLiquibaseTable = `CREATE TABLE DATABASECHANGELOG (
+  ID NVARCHAR(255) NOT NULL,
+  AUTHOR NVARCHAR(255) NOT NULL,
+  FILENAME NVARCHAR(255) NOT NULL,
+  DATEEXECUTED DATETIME NOT NULL,
+  ORDEREXECUTED INT NOT NULL,
+  EXECTYPE NVARCHAR(10) NOT NULL,
+  MD5SUM NVARCHAR(35) NULL,
+  DESCRIPTION NVARCHAR(255) NULL,
+  COMMENTS NVARCHAR(255) NULL,
+  TAG NVARCHAR(255) NULL,
+  LIQUIBASE NVARCHAR(20) NULL,
+  CONTEXTS NVARCHAR(255) NULL,
+  LABELS NVARCHAR(255) NULL,
+  DEPLOYMENT_ID NVARCHAR(10) NULL
+)`
+
+// changeSet is a Liquibase changeset.
+type changeSet struct {
+	ID          string
+	Author      string
+	File        string // Changelog path, as Liquibase records it
+	RunAlways   bool
+	FailOnError bool
+	Contexts    string
+	Labels      string
+	DBMS        string
+	Comment     string
+	Tag         string
+	Changes     []change
+}
+
+// change is one SQL change of a changeset.
+type change struct {
+	Kind      string // sql, sqlFile or createProcedure
+	SQL       string
+	Delimiter string
+}
+
+func (cs *changeSet) name() string {
+	return cs.ID + "::" + cs.Author
+}
+
+func (cs *changeSet) description() string {
+	kinds := make([]string, 0, len(cs.Changes))
+	for _, c := range cs.Changes {
+		kinds = append(kinds, c.Kind)
+	}
+	if cs.Tag != "" {
+		kinds = append(kinds, "tagDatabase")
+	}
+	if len(kinds) == 0 {
+		return "empty"
+	}
+	return strings.Join(kinds, "; ")
+}
+
+// changelog is a changelog read with its includes.
+type changelog struct {
+	ChangeSets []*changeSet
+	Properties map[string]string
+}
+
+// loadChangelog reads the changelog at file, XML or formatted SQL.
+func loadChangelog(file string) (*changelog, error) {
+	cl := &changelog{Properties: make(map[string]string)}
+	if err := cl.load(logicalPath(file), 0); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// logicalPath is a file path in the form Liquibase records it.
+func logicalPath(file string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(file)), "./")
+}
+
+// maxIncludeDepth bounds changelog includes.
+const maxIncludeDepth = 32
+
+func (cl *changelog) load(file string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: changelogs included more than %d deep", file, maxIncludeDepth)
+	}
+	data, err := os.ReadFile(filepath.FromSlash(file))
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(path.Ext(file), ".xml") {
+		return cl.loadXML(file, data, depth)
+	}
+	return cl.loadFormattedSQL(file, string(data))
+}
+
+// node is an element of an XML changelog.
+type node struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []node     `xml:",any"`
+	Text     string     `xml:",chardata"`
+}
+
+func (n *node) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *node) boolAttr(name string, def bool) bool {
+	switch strings.ToLower(n.attr(name)) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return def
+}
+
+// resolve returns the logical path of a file a changelog refers to.
+func resolve(from string, n *node, attr string) string {
+	file := strings.TrimPrefix(n.attr(attr), "classpath:")
+	if n.boolAttr("relativeToChangelogFile", false) {
+		return logicalPath(path.Join(path.Dir(from), file))
+	}
+	return logicalPath(file)
+}
+
+func (cl *changelog) loadXML(file string, data []byte, depth int) error {
+	var root node
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if root.XMLName.Local != "databaseChangeLog" {
+		return fmt.Errorf("%s: not a Liquibase changelog", file)
+	}
+
+	for i := range root.Children {
+		n := &root.Children[i]
+		switch n.XMLName.Local {
+		case "property":
+			if _, ok := cl.Properties[n.attr("name")]; !ok {
+				cl.Properties[n.attr("name")] = n.attr("value")
+			}
+		case "include":
+			if err := cl.load(resolve(file, n, "file"), depth+1); err != nil {
+				return err
+			}
+		case "includeAll":
+			dir := resolve(file, n, "path")
+			entries, err := os.ReadDir(filepath.FromSlash(dir))
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			var names []string
+			for _, e := range entries {
+				ext := strings.ToLower(filepath.Ext(e.Name()))
+				if !e.IsDir() && (ext == ".xml" || ext == ".sql") {
+					names = append(names, e.Name())
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if err := cl.load(path.Join(dir, name), depth+1); err != nil {
+					return err
+				}
+			}
+		case "changeSet":
+			cs, err := xmlChangeSet(file, n)
+			if err != nil {
+				return err
+			}
+			cl.ChangeSets = append(cl.ChangeSets, cs)
+		case "preConditions":
+			return fmt.Errorf("%s: preconditions are not supported", file)
+		}
+	}
+	return nil
+}
+
+func xmlChangeSet(file string, n *node) (*changeSet, error) {
+	cs := &changeSet{
+		ID:          n.attr("id"),
+		Author:      n.attr("author"),
+		File:        file,
+		RunAlways:   n.boolAttr("runAlways", false),
+		FailOnError: n.boolAttr("failOnError", true),
+		Contexts:    n.attr("context"),
+		Labels:      n.attr("labels"),
+		DBMS:        n.attr("dbms"),
+	}
+	if cs.Contexts == "" {
+		cs.Contexts = n.attr("contextFilter")
+	}
+	if cs.ID == "" || cs.Author == "" {
+		return nil, fmt.Errorf("%s: changeset without an id and author", file)
+	}
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s: changeset %s: %s", file, cs.name(), fmt.Sprintf(format, args...))
+	}
+
+	for i := range n.Children {
+		c := &n.Children[i]
+		switch kind := c.XMLName.Local; kind {
+		case "comment":
+			cs.Comment = strings.TrimSpace(c.Text)
+		case "sql", "createProcedure":
+			if !dbmsMatches(c.attr("dbms")) {
+				continue
+			}
+			text := c.Text
+			if p := c.attr("path"); p != "" {
+				data, err := os.ReadFile(filepath.FromSlash(resolve(file, c, "path")))
+				if err != nil {
+					return nil, fail("%v", err)
+				}
+				text = string(data)
+			}
+			cs.Changes = append(cs.Changes, change{Kind: kind, SQL: text, Delimiter: delimiter(c.attr("endDelimiter"))})
+		case "sqlFile":
+			if !dbmsMatches(c.attr("dbms")) {
+				continue
+			}
+			data, err := os.ReadFile(filepath.FromSlash(resolve(file, c, "path")))
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			cs.Changes = append(cs.Changes, change{Kind: kind, SQL: string(data), Delimiter: delimiter(c.attr("endDelimiter"))})
+		case "tagDatabase":
+			cs.Tag = c.attr("tag")
+		case "rollback", "validCheckSum":
+			// aul only migrates forward, and does not check sums
+		case "preConditions":
+			return nil, fail("preconditions are not supported")
+		default:
+			return nil, fail("change type %s is not supported; write it as <sql>", kind)
+		}
+	}
+	return cs, nil
+}
+
+// delimiter returns the statement delimiter a change splits at besides
+// GO. A semicolon is left to aul, which runs several statements a batch.
+func delimiter(d string) string {
+	if d = strings.TrimSpace(d); d == ";" {
+		return ""
+	}
+	return d
+}
+
+var (
+	formattedHeader    = regexp.MustCompile(`(?i)^--\s*liquibase\s+formatted\s+sql`)
+	formattedChangeSet = regexp.MustCompile(`(?i)^--\s*changeset\s+("[^"]+"|[^:\s]+):\s*("[^"]+"|\S+)(.*)$`)
+	formattedAttr      = regexp.MustCompile(`(\w+):("[^"]*"|\S+)`)
+	formattedComment   = regexp.MustCompile(`(?i)^--\s*comment:\s*(.*)$`)
+	formattedRollback  = regexp.MustCompile(`(?i)^--\s*rollback\b`)
+	formattedPrecond   = regexp.MustCompile(`(?i)^--\s*precondition`)
+	formattedProperty  = regexp.MustCompile(`(?i)^--\s*property\s+(.*)$`)
+)
+
+func (cl *changelog) loadFormattedSQL(file, text string) error {
+	text = strings.TrimPrefix(text, "\ufeff")
+	sc := bufio.NewScanner(strings.NewReader(text))
+	sc.Buffer(make([]byte, 64*1024), len(text)+1)
+
+	var (
+		cs      *changeSet
+		body    strings.Builder
+		delim   string
+		lineNo  int
+		started bool
+	)
+	finish := func() {
+		if cs != nil {
+			cs.Changes = append(cs.Changes, change{Kind: "sql", SQL: body.String(), Delimiter: delim})
+			cl.ChangeSets = append(cl.ChangeSets, cs)
+		}
+		body.Reset()
+	}
+
+	for sc.Scan() {
+		line := sc.Text()
+		lineNo++
+		trimmed := strings.TrimSpace(line)
+		if !started {
+			if trimmed == "" {
+				continue
+			}
+			if !formattedHeader.MatchString(trimmed) {
+				return fmt.Errorf("%s: not a Liquibase changelog (formatted SQL starts with --liquibase formatted sql)", file)
+			}
+			started = true
+			continue
+		}
+
+		if m := formattedChangeSet.FindStringSubmatch(trimmed); m != nil {
+			finish()
+			cs = &changeSet{
+				Author:      strings.Trim(m[1], `"`),
+				ID:          strings.Trim(m[2], `"`),
+				File:        file,
+				FailOnError: true,
+			}
+			delim = ""
+			for _, a := range formattedAttr.FindAllStringSubmatch(m[3], -1) {
+				value := strings.Trim(a[2], `"`)
+				switch strings.ToLower(a[1]) {
+				case "runalways":
+					cs.RunAlways = strings.EqualFold(value, "true")
+				case "failonerror":
+					cs.FailOnError = !strings.EqualFold(value, "false")
+				case "context", "contexts", "contextfilter":
+					cs.Contexts = value
+				case "labels":
+					cs.Labels = value
+				case "dbms":
+					cs.DBMS = value
+				case "enddelimiter":
+					delim = delimiter(value)
+				}
+			}
+			continue
+		}
+		if m := formattedProperty.FindStringSubmatch(trimmed); m != nil {
+			var name, value string
+			for _, a := range formattedAttr.FindAllStringSubmatch(m[1], -1) {
+				switch strings.ToLower(a[1]) {
+				case "name":
+					name = strings.Trim(a[2], `"`)
+				case "value":
+					value = strings.Trim(a[2], `"`)
+				}
+			}
+			if _, ok := cl.Properties[name]; name != "" && !ok {
+				cl.Properties[name] = value
+			}
+			continue
+		}
+		if cs == nil {
+			continue
+		}
+		switch {
+		case formattedRollback.MatchString(trimmed):
+			continue
+		case formattedPrecond.MatchString(trimmed):
+			return fmt.Errorf("%s:%d: changeset %s: preconditions are not supported", file, lineNo, cs.name())
+		}
+		if m := formattedComment.FindStringSubmatch(trimmed); m != nil {
+			cs.Comment = m[1]
+			continue
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	finish()
+	return nil
+}
+
+// dbmsMatches reports whether a dbms attribute lets a change run on aul,
+// which takes SQL Server's part.
+func dbmsMatches(dbms string) bool {
+	if strings.TrimSpace(dbms) == "" {
+		return true
+	}
+	positive := false
+	for _, d := range strings.Split(dbms, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		switch {
+		case d == "!mssql":
+			return false
+		case d == "mssql" || d == "all":
+			return true
+		case !strings.HasPrefix(d, "!"):
+			positive = true
+		}
+	}
+	return !positive
+}
+
+// contextsMatch reports whether a changeset's contexts select it.
+func contextsMatch(contexts string, selected []string) bool {
+	if len(selected) == 0 || strings.TrimSpace(contexts) == "" {
+		return true
+	}
+	has := func(c string) bool {
+		for _, s := range selected {
+			if strings.EqualFold(strings.TrimSpace(s), c) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range strings.FieldsFunc(contexts, func(r rune) bool { return r == ',' }) {
+		c = strings.TrimSpace(c)
+		if strings.HasPrefix(c, "!") {
+			if !has(c[1:]) {
+				return true
+			}
+			continue
+		}
+		if has(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// liquibaseRecord is a row of DATABASECHANGELOG.
+type liquibaseRecord struct {
+	ID, Author, File string
+}
+
+func (r liquibaseRecord) key() string {
+	return r.ID + "\x00" + r.Author + "\x00" + logicalPath(strings.TrimPrefix(r.File, "classpath:"))
+}
+
+func readLiquibaseHistory(ctx context.Context, db DB) (map[string]liquibaseRecord, int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT ID, AUTHOR, FILENAME, ORDEREXECUTED FROM DATABASECHANGELOG")
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading %s: %w", liquibaseTable, err)
+	}
+	defer rows.Close()
+
+	records := make(map[string]liquibaseRecord)
+	order := 0
+	for rows.Next() {
+		var r liquibaseRecord
+		var n int
+		if err := rows.Scan(&r.ID, &r.Author, &r.File, &n); err != nil {
+			return nil, 0, fmt.Errorf("reading %s: %w", liquibaseTable, err)
+		}
+		records[r.key()] = r
+		order = max(order, n)
+	}
+	return records, order, rows.Err()
+}
+
+// runLiquibase applies the changelog at file as Liquibase's update does.
+// The MD5SUM of the changesets it records is left NULL, which Liquibase
+// fills in the next time it runs.
+func runLiquibase(ctx context.Context, db DB, file string, opts *Options) (*Report, error) {
+	cl, err := loadChangelog(file)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]string)
+	for _, cs := range cl.ChangeSets {
+		key := liquibaseRecord{cs.ID, cs.Author, cs.File}.key()
+		if _, dup := seen[key]; dup {
+			return nil, fmt.Errorf("%s: changeset %s appears twice", cs.File, cs.name())
+		}
+		seen[key] = cs.name()
+	}
+
+	history := make(map[string]liquibaseRecord)
+	order := 0
+	if tableExists(ctx, db, liquibaseTable) {
+		if history, order, err = readLiquibaseHistory(ctx, db); err != nil {
+			return nil, err
+		}
+	} else if !opts.DryRun {
+		opts.progress("creating %s", liquibaseTable)
+		if _, err := db.ExecContext(ctx, createLiquibaseTable); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", liquibaseTable, err)
+		}
+	}
+
+	values := make(map[string]string)
+	for k, v := range cl.Properties {
+		values[k] = v
+	}
+	for k, v := range opts.Placeholders {
+		values[k] = v
+	}
+
+	report := &Report{Tool: "liquibase"}
+	deployment := fmt.Sprintf("%010d", opts.time().UnixMilli()%10000000000)
+	for _, cs := range cl.ChangeSets {
+		prev, ran := history[liquibaseRecord{cs.ID, cs.Author, cs.File}.key()]
+		if ran && !cs.RunAlways {
+			continue
+		}
+		res := &Result{Name: cs.name(), Script: cs.File, Status: StatusPending}
+		if !dbmsMatches(cs.DBMS) || !contextsMatch(cs.Contexts, opts.Contexts) {
+			res.Status = StatusSkipped
+			report.Results = append(report.Results, res)
+			continue
+		}
+		report.Results = append(report.Results, res)
+		if opts.DryRun {
+			continue
+		}
+
+		opts.progress("running changeset %s", cs.name())
+		start := opts.time()
+		err := applyChangeSet(ctx, db, cs, values)
+		res.Duration = opts.time().Sub(start)
+		if err == nil {
+			order++
+			err = recordChangeSet(ctx, db, cs, prev, ran, start, order, deployment)
+		}
+		if err != nil {
+			res.Status, res.Error = StatusFailed, err.Error()
+			if cs.FailOnError {
+				break
+			}
+			continue
+		}
+		res.Status = StatusApplied
+	}
+	return report, nil
+}
+
+func applyChangeSet(ctx context.Context, db DB, cs *changeSet, values map[string]string) error {
+	for _, c := range cs.Changes {
+		// Liquibase leaves unknown ${...} references alone
+		script, _ := substitute(c.SQL, values, false)
+		if err := execScript(ctx, db, script, c.Delimiter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordChangeSet(ctx context.Context, db DB, cs *changeSet, prev liquibaseRecord, ran bool, on time.Time, order int, deployment string) error {
+	var stmt string
+	if ran {
+		stmt = fmt.Sprintf(`UPDATE DATABASECHANGELOG
+SET DATEEXECUTED = %s, ORDEREXECUTED = %d, EXECTYPE = 'RERAN', DEPLOYMENT_ID = %s
+WHERE ID = %s AND AUTHOR = %s AND FILENAME = %s`,
+			timestamp(on), order, quote(deployment, false),
+			quote(prev.ID, false), quote(prev.Author, false), quote(prev.File, false))
+	} else {
+		stmt = fmt.Sprintf(`INSERT INTO DATABASECHANGELOG
+  (ID, AUTHOR, FILENAME, DATEEXECUTED, ORDEREXECUTED, EXECTYPE, MD5SUM, DESCRIPTION, COMMENTS, TAG, LIQUIBASE, CONTEXTS, LABELS, DEPLOYMENT_ID)
+VALUES (%s, %s, %s, %s, %d, 'EXECUTED', NULL, %s, %s, %s, 'aul', %s, %s, %s)`,
+			quote(cs.ID, false), quote(cs.Author, false), quote(cs.File, false), timestamp(on), order,
+			quote(cs.description(), false), quote(cs.Comment, true), quote(cs.Tag, true),
+			quote(cs.Contexts, true), quote(cs.Labels, true), quote(deployment, false))
+	}
+	_, err := db.ExecContext(ctx, stmt)
+	return err
+}
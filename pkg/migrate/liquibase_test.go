@@ -0,0 +1,178 @@
+package migrate
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const testChangelog = `<?xml version="1.0" encoding="UTF-8"?>
+<databaseChangeLog
+    xmlns="http://www.liquibase.org/xml/ns/dbchangelog"
+    xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+    xsi:schemaLocation="http://www.liquibase.org/xml/ns/dbchangelog http://www.liquibase.org/xml/ns/dbchangelog/dbchangelog-4.20.xsd">
+  <property name="owner" value="first"/>
+  <changeSet id="1" author="ann">
+    <comment>Accounts</comment>
+    <sql>CREATE TABLE accounts (id INT, name NVARCHAR(50))</sql>
+    <rollback>DROP TABLE accounts</rollback>
+  </changeSet>
+  <changeSet id="2" author="ann">
+    <sql><![CDATA[INSERT INTO accounts VALUES (1, '${owner}')]]></sql>
+    <tagDatabase tag="v1"/>
+  </changeSet>
+  <changeSet id="3" author="bob" context="test">
+    <sql>INSERT INTO accounts VALUES (99, 'test only')</sql>
+  </changeSet>
+  <changeSet id="4" author="bob" dbms="postgresql">
+    <sql>CREATE EXTENSION pgcrypto</sql>
+  </changeSet>
+  <include file="more/orders.sql" relativeToChangelogFile="true"/>
+  <includeAll path="more/all" relativeToChangelogFile="true"/>
+  <changeSet id="stats" author="ann" runAlways="true">
+    <sqlFile path="more/stats.sql" relativeToChangelogFile="true"/>
+  </changeSet>
+</databaseChangeLog>`
+
+const testFormatted = `--liquibase formatted sql
+
+--changeset carol:orders-1
+--comment: Orders table
+CREATE TABLE orders (id INT, account_id INT)
+--rollback DROP TABLE orders
+
+--changeset carol:orders-2 failOnError:false
+INSERT INTO no_such_table VALUES (1)
+
+--changeset carol:orders-3
+INSERT INTO orders VALUES (1, 1)
+GO
+INSERT INTO orders VALUES (2, 1)
+`
+
+func TestLiquibase(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"changelog.xml":             testChangelog,
+		"more/orders.sql":           testFormatted,
+		"more/all/b.sql":            "--liquibase formatted sql\n--changeset dan:b\nCREATE TABLE b (x INT)\n",
+		"more/all/a.sql":            "--liquibase formatted sql\n--changeset dan:a\nCREATE TABLE a (x INT)\n",
+		"more/all/notes.txt":        "ignored",
+		"more/stats.sql":            "CREATE TABLE IF NOT EXISTS stats (n INT)\nGO\nINSERT INTO stats SELECT COUNT(*) FROM accounts\n",
+		"other/unsupported.xml":     `<databaseChangeLog><changeSet id="1" author="x"><createTable tableName="t"/></changeSet></databaseChangeLog>`,
+		"other/not-a-changelog.sql": "CREATE TABLE t (x INT)\n",
+	})
+	changelog := dir + "/changelog.xml"
+
+	report, err := Run(ctx, db, changelog, Options{Contexts: []string{"prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1::ann=applied, 2::ann=applied, 3::bob=skipped, 4::bob=skipped, orders-1::carol=applied, " +
+		"orders-2::carol=failed, orders-3::carol=applied, a::dan=applied, b::dan=applied, stats::ann=applied"
+	if got := statuses(report); got != want {
+		t.Fatalf("first run =\n%s\nwant\n%s", got, want)
+	}
+
+	if n := count(t, db, "SELECT COUNT(*) FROM DATABASECHANGELOG"); n != 7 {
+		t.Errorf("history has %d rows, want 7", n)
+	}
+	var file, tag, comments string
+	db.QueryRow("SELECT FILENAME, TAG FROM DATABASECHANGELOG WHERE ID = '2'").Scan(&file, &tag)
+	if file != logicalPath(changelog) || tag != "v1" {
+		t.Errorf("changeset 2 recorded as %q tagged %q", file, tag)
+	}
+	db.QueryRow("SELECT FILENAME, COMMENTS FROM DATABASECHANGELOG WHERE ID = 'orders-1'").Scan(&file, &comments)
+	if file != logicalPath(dir+"/more/orders.sql") || comments != "Orders table" {
+		t.Errorf("orders-1 recorded as %q with comments %q", file, comments)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM orders"); n != 2 {
+		t.Errorf("orders has %d rows, want 2", n)
+	}
+	var name string
+	db.QueryRow("SELECT name FROM accounts WHERE id = 1").Scan(&name)
+	if name != "first" {
+		t.Errorf("property not substituted: %q", name)
+	}
+
+	// Only the runAlways changeset and the one that failed run again
+	report, err = Run(ctx, db, changelog, Options{Contexts: []string{"test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "3::bob=applied, 4::bob=skipped, orders-2::carol=failed, stats::ann=applied"
+	if got := statuses(report); got != want {
+		t.Errorf("second run = %s, want %s", got, want)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM DATABASECHANGELOG WHERE ID = 'stats' AND EXECTYPE = 'RERAN'"); n != 1 {
+		t.Error("the runAlways changeset should be recorded as RERAN")
+	}
+
+	for file, msg := range map[string]string{
+		"/other/unsupported.xml":     "change type createTable is not supported",
+		"/other/not-a-changelog.sql": "not a Liquibase changelog",
+	} {
+		if _, err := Run(ctx, db, dir+file, Options{}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+}
+
+func TestLiquibase_ExistingHistory(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"db/changelog.sql": "--liquibase formatted sql\n--changeset ann:1\nCREATE TABLE never_run (x INT)\n--changeset ann:2\nCREATE TABLE t2 (x INT)\n",
+	})
+
+	// Liquibase recorded changeset 1, with the path as it was on the classpath
+	if _, err := db.Exec(createLiquibaseTable); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO DATABASECHANGELOG (ID, AUTHOR, FILENAME, DATEEXECUTED, ORDEREXECUTED, EXECTYPE, MD5SUM)
+		VALUES ('1', 'ann', 'classpath:` + logicalPath(dir+"/db/changelog.sql") + `', '2024-01-01', 7, 'EXECUTED', '9:0123456789abcdef0123456789abcdef')`); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Run(ctx, db, dir+"/db/changelog.sql", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(report); got != "2::ann=applied" {
+		t.Fatalf("run = %s", got)
+	}
+	if tableExists(ctx, db, "never_run") {
+		t.Error("a changeset in the history ran again")
+	}
+	if n := count(t, db, "SELECT ORDEREXECUTED FROM DATABASECHANGELOG WHERE ID = '2'"); n != 8 {
+		t.Errorf("ORDEREXECUTED = %d, want 8", n)
+	}
+}
+
+func TestDBMSAndContexts(t *testing.T) {
+	for dbms, want := range map[string]bool{"": true, "mssql": true, "all": true, "postgresql, mssql": true, "postgresql": false, "!mssql": false, "!postgresql": true} {
+		if got := dbmsMatches(dbms); got != want {
+			t.Errorf("dbmsMatches(%q) = %v", dbms, got)
+		}
+	}
+	tests := []struct {
+		contexts string
+		selected []string
+		want     bool
+	}{
+		{"", []string{"prod"}, true},
+		{"test", nil, true},
+		{"test", []string{"prod"}, false},
+		{"test, prod", []string{"prod"}, true},
+		{"!test", []string{"prod"}, true},
+		{"!test", []string{"test"}, false},
+	}
+	for _, tt := range tests {
+		if got := contextsMatch(tt.contexts, tt.selected); got != tt.want {
+			t.Errorf("contextsMatch(%q, %v) = %v", tt.contexts, tt.selected, got)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Package migrate runs database migrations written for Flyway or
+// Liquibase against aul, keeping their history in the tools' own tables.
+//
+// A directory of Flyway-named scripts (V1__init.sql, V1.1__orders.sql,
+// R__views.sql) is applied in version order and recorded in
+// flyway_schema_history. A Liquibase changelog, XML or formatted SQL, is
+// applied changeset by changeset and recorded in DATABASECHANGELOG. Because
+// the history tables are the ones the tools themselves keep, a service
+// moved onto aul together with its history carries on where it left off,
+// and can go back to the original tool later.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DB is the database migrations run on; *sql.DB and *sql.Conn both are.
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Options configures a run.
+type Options struct {
+	// Placeholders replace ${name} in migrations. Liquibase changelog
+	// properties are added to them.
+	Placeholders map[string]string
+
+	// Contexts selects the Liquibase changesets to run; changesets without
+	// a context always run (empty = all).
+	Contexts []string
+
+	// User is recorded as the one who applied Flyway migrations.
+	User string
+
+	// OutOfOrder applies Flyway migrations older than the newest applied
+	// one, instead of refusing to run.
+	OutOfOrder bool
+
+	// DryRun reports what would be applied without applying it.
+	DryRun bool
+
+	// Progress is told what the run is doing.
+	Progress func(message string)
+
+	now func() time.Time
+}
+
+func (o *Options) progress(format string, args ...interface{}) {
+	if o.Progress != nil {
+		o.Progress(fmt.Sprintf(format, args...))
+	}
+}
+
+func (o *Options) time() time.Time {
+	if o.now != nil {
+		return o.now()
+	}
+	return time.Now()
+}
+
+func (o *Options) user() string {
+	if o.User != "" {
+		return o.User
+	}
+	return "aul"
+}
+
+// Status is what became of a migration in a run.
+type Status string
+
+const (
+	StatusApplied Status = "applied" // Run and recorded
+	StatusPending Status = "pending" // Would run (dry run)
+	StatusSkipped Status = "skipped" // Not for the selected contexts or database
+	StatusFailed  Status = "failed"
+)
+
+// Result is the outcome for one migration or changeset.
+type Result struct {
+	Name     string // Version and description, or changeset id::author
+	Script   string // File it comes from
+	Status   Status
+	Duration time.Duration
+	Error    string
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Tool    string // "flyway" or "liquibase"
+	Results []*Result
+}
+
+// Failed reports whether a migration failed.
+func (r *Report) Failed() bool {
+	for _, res := range r.Results {
+		if res.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes the report for people.
+func (r *Report) WriteText(w io.Writer) {
+	counts := make(map[Status]int)
+	for _, res := range r.Results {
+		counts[res.Status]++
+	}
+	if len(r.Results) == 0 {
+		fmt.Fprintf(w, "%s: schema is up to date\n", r.Tool)
+		return
+	}
+	fmt.Fprintf(w, "%s: %d applied, %d pending, %d skipped, %d failed\n", r.Tool,
+		counts[StatusApplied], counts[StatusPending], counts[StatusSkipped], counts[StatusFailed])
+	for _, res := range r.Results {
+		fmt.Fprintf(w, "  [%s] %s (%s", res.Status, res.Name, res.Script)
+		if res.Status == StatusApplied || res.Status == StatusFailed {
+			fmt.Fprintf(w, ", %s", res.Duration.Round(time.Millisecond))
+		}
+		fmt.Fprint(w, ")")
+		if res.Error != "" {
+			fmt.Fprintf(w, ": %s", res.Error)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// Run applies the migrations at path: a directory of Flyway scripts, or a
+// Liquibase changelog. The error return is for migrations that cannot be
+// run at all, such as a changed checksum; a migration that fails is in the
+// report.
+func Run(ctx context.Context, db DB, path string, opts Options) (*Report, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return runFlyway(ctx, db, path, &opts)
+	}
+	return runLiquibase(ctx, db, path, &opts)
+}
+
+// goLine matches the line ending a batch.
+var goLine = regexp.MustCompile(`(?i)^\s*GO\s*;?\s*$`)
+
+// splitBatches splits a script into batches at GO lines, and at lines
+// holding only delimiter when it is set.
+func splitBatches(script, delimiter string) []string {
+	var batches []string
+	var b strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(b.String()); s != "" {
+			batches = append(batches, s)
+		}
+		b.Reset()
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(script, "\r\n", "\n"), "\n") {
+		if goLine.MatchString(line) || (delimiter != "" && strings.TrimSpace(line) == delimiter) {
+			flush()
+			continue
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	flush()
+	return batches
+}
+
+// execScript runs the batches of script.
+func execScript(ctx context.Context, db DB, script, delimiter string) error {
+	for _, batch := range splitBatches(script, delimiter) {
+		if _, err := db.ExecContext(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableExists reports whether table can be read.
+func tableExists(ctx context.Context, db DB, table string) bool {
+	rows, err := db.QueryContext(ctx, "SELECT COUNT(*) FROM "+table)
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// quote renders s as a string literal, or NULL when it is empty and
+// nullable says so.
+func quote(s string, nullable bool) string {
+	if s == "" && nullable {
+		return "NULL"
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// timestamp renders t as a datetime literal.
+func timestamp(t time.Time) string {
+	return "'" + t.Format("2006-01-02 15:04:05.000") + "'"
+}
+
+var placeholderRef = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// substitute replaces ${name} references with values. With strict set an
+// unknown name is an error; otherwise the reference is left alone.
+func substitute(script string, values map[string]string, strict bool) (string, error) {
+	if !strings.Contains(script, "${") {
+		return script, nil
+	}
+	var err error
+	script = placeholderRef.ReplaceAllStringFunc(script, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		for k, v := range values {
+			if strings.EqualFold(k, name) {
+				return v
+			}
+		}
+		if strict && err == nil {
+			err = fmt.Errorf("no value provided for placeholder %s", ref)
+		}
+		return ref
+	})
+	return script, err
+}
@@ -0,0 +1,247 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One connection, so that every query sees the same in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func count(t *testing.T, db *sql.DB, query string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func statuses(r *Report) string {
+	var s []string
+	for _, res := range r.Results {
+		s = append(s, res.Name+"="+string(res.Status))
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestSplitBatches(t *testing.T) {
+	got := splitBatches("CREATE TABLE a (x INT)\nGO\n\nINSERT INTO a VALUES (1)\r\ngo;\n$$\nSELECT 1\n", "$$")
+	want := []string{"CREATE TABLE a (x INT)", "INSERT INTO a VALUES (1)", "SELECT 1"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("splitBatches = %q, want %q", got, want)
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	got, err := substitute("SELECT '${Env}', '${other}'", map[string]string{"env": "prod", "other": "x"}, true)
+	if err != nil || got != "SELECT 'prod', 'x'" {
+		t.Errorf("substitute = %q, %v", got, err)
+	}
+	if _, err := substitute("${missing}", nil, true); err == nil {
+		t.Error("expected an error for an unknown placeholder")
+	}
+	if got, err := substitute("${missing}", nil, false); err != nil || got != "${missing}" {
+		t.Errorf("lenient substitute = %q, %v", got, err)
+	}
+}
+
+func TestParseFlywayName(t *testing.T) {
+	tests := []struct {
+		name, version, description string
+		ok, err                    bool
+	}{
+		{"V1__init.sql", "1", "init", true, false},
+		{"V1_2__add_orders.sql", "1.2", "add orders", true, false},
+		{"V2.0.1__fix.sql", "2.0.1", "fix", true, false},
+		{"R__refresh_views.sql", "", "refresh views", true, false},
+		{"U1__undo.sql", "", "", false, false},
+		{"notes.sql", "", "", false, false},
+		{"V1__init.txt", "", "", false, false},
+		{"Vx__bad.sql", "", "", false, true},
+	}
+	for _, tt := range tests {
+		version, description, _, ok, err := parseFlywayName(tt.name)
+		if version != tt.version || description != tt.description || ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("parseFlywayName(%s) = %q %q %v %v", tt.name, version, description, ok, err)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1", "1.0", 0},
+		{"1.2", "1.10", -1},
+		{"2", "1.9.9", 1},
+		{"01.1", "1.1", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFlywayChecksum(t *testing.T) {
+	want := int32(crc32.ChecksumIEEE([]byte("CREATE TABLE a (x INT);INSERT INTO a VALUES (1);")))
+	for _, script := range []string{
+		"CREATE TABLE a (x INT);\nINSERT INTO a VALUES (1);\n",
+		"\ufeffCREATE TABLE a (x INT);\r\nINSERT INTO a VALUES (1);",
+		"CREATE TABLE a (x INT);\rINSERT INTO a VALUES (1);\r\n",
+	} {
+		if got := flywayChecksum([]byte(script)); got != want {
+			t.Errorf("flywayChecksum(%q) = %d, want %d", script, got, want)
+		}
+	}
+}
+
+func TestFlyway(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"V1__init.sql":           "CREATE TABLE accounts (id INT, name NVARCHAR(50))\nGO\nINSERT INTO accounts VALUES (1, '${owner}')\n",
+		"V1_1__more.sql":         "INSERT INTO accounts VALUES (2, 'second')\n",
+		"sub/V2__orders.sql":     "CREATE TABLE orders (id INT)\n",
+		"R__counts.sql":          "DELETE FROM counts_log\nGO\nINSERT INTO counts_log SELECT COUNT(*) FROM accounts\n",
+		"V0_9__counts_table.sql": "CREATE TABLE counts_log (n INT)\n",
+		"README.md":              "not a migration",
+	})
+	opts := Options{Placeholders: map[string]string{"owner": "first"}}
+
+	dry, err := Run(ctx, db, dir, Options{DryRun: true, Placeholders: opts.Placeholders})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(dry); got != "0.9 counts table=pending, 1 init=pending, 1.1 more=pending, 2 orders=pending, repeatable counts=pending" {
+		t.Errorf("dry run = %s", got)
+	}
+	if tableExists(ctx, db, flywayTable) {
+		t.Error("a dry run should not create the history table")
+	}
+
+	report, err := Run(ctx, db, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed() || len(report.Results) != 5 {
+		t.Fatalf("first run = %s", statuses(report))
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM flyway_schema_history WHERE success = 1 AND type = 'SQL'"); n != 5 {
+		t.Errorf("history has %d rows, want 5", n)
+	}
+	var script string
+	db.QueryRow("SELECT script FROM flyway_schema_history WHERE version = '2'").Scan(&script)
+	if script != "sub/V2__orders.sql" {
+		t.Errorf("script = %q", script)
+	}
+
+	// Nothing to do the second time
+	report, err = Run(ctx, db, dir, opts)
+	if err != nil || len(report.Results) != 0 {
+		t.Fatalf("second run = %s, %v", statuses(report), err)
+	}
+
+	// A changed repeatable migration runs again
+	writeFiles(t, dir, map[string]string{"R__counts.sql": "DELETE FROM counts_log\n"})
+	report, err = Run(ctx, db, dir, opts)
+	if err != nil || statuses(report) != "repeatable counts=applied" {
+		t.Fatalf("third run = %s, %v", statuses(report), err)
+	}
+
+	// A changed versioned migration is refused
+	writeFiles(t, dir, map[string]string{"V1__init.sql": "CREATE TABLE accounts (id INT)\n"})
+	if _, err := Run(ctx, db, dir, opts); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("changed migration: %v", err)
+	}
+}
+
+func TestFlyway_History(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"V1__baseline_era.sql": "CREATE TABLE never_run (x INT)\n",
+		"V2__java_era.sql":     "CREATE TABLE also_never_run (x INT)\n",
+		"V3__next.sql":         "CREATE TABLE next (x INT)\n",
+		"V4__broken.sql":       "INSERT INTO missing_table VALUES (1)\n",
+		"V5__after.sql":        "CREATE TABLE after (x INT)\n",
+	})
+
+	// A history Flyway left: a baseline at 1 and a Java migration at 2
+	if _, err := db.Exec(createFlywayTable); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO flyway_schema_history VALUES
+		(1, '1', '<< Flyway Baseline >>', 'BASELINE', '<< Flyway Baseline >>', NULL, 'sa', '2024-01-01', 0, 1),
+		(2, '2', 'java era', 'JDBC', 'db.migration.V2__java_era', NULL, 'sa', '2024-01-02', 3, 1)`); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Run(ctx, db, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(report); got != "3 next=applied, 4 broken=failed" {
+		t.Fatalf("run = %s", got)
+	}
+	if tableExists(ctx, db, "never_run") || !tableExists(ctx, db, "next") {
+		t.Error("only the migrations after the history should run")
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM flyway_schema_history WHERE version = '4' AND success = 0"); n != 1 {
+		t.Error("the failed migration should be recorded as failed")
+	}
+
+	// The failure blocks further runs until it is cleared
+	if _, err := Run(ctx, db, dir, Options{}); err == nil || !strings.Contains(err.Error(), "failed earlier") {
+		t.Errorf("after a failure: %v", err)
+	}
+	db.Exec("DELETE FROM flyway_schema_history WHERE success = 0")
+	writeFiles(t, dir, map[string]string{"V4__broken.sql": "CREATE TABLE fixed (x INT)\n"})
+	report, err = Run(ctx, db, dir, Options{})
+	if err != nil || statuses(report) != "4 broken=applied, 5 after=applied" {
+		t.Fatalf("after the fix = %s, %v", statuses(report), err)
+	}
+
+	// An older migration added later needs out-of-order
+	writeFiles(t, dir, map[string]string{"V4_5__late.sql": "CREATE TABLE late (x INT)\n"})
+	if _, err := Run(ctx, db, dir, Options{}); err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Errorf("late migration: %v", err)
+	}
+	report, err = Run(ctx, db, dir, Options{OutOfOrder: true})
+	if err != nil || statuses(report) != "4.5 late=applied" {
+		t.Errorf("out of order = %s, %v", statuses(report), err)
+	}
+}
@@ -0,0 +1,372 @@
+// Package mssqlimport copies a SQL Server database into aul.
+//
+// The tables, their rows and the database's programmable objects are read
+// from a live SQL Server's catalogue. Tables are scripted as T-SQL that
+// aul accepts and created on an aul server, so that they pass through the
+// same dialect pipeline as any other DDL, and their rows follow as batched
+// INSERTs. Procedures and functions are checked with aul's T-SQL parser
+// and written into a procedure directory. Everything that could not be
+// carried over, or was carried over with changes, is listed in a Report.
+package mssqlimport
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Catalog is what an import reads from the source database.
+type Catalog struct {
+	Database string
+	Tables   []*Table
+	Modules  []*Module
+}
+
+// Table is a user table.
+type Table struct {
+	Schema  string
+	Name    string
+	Columns []*Column
+
+	PrimaryKey  *Index
+	Indexes     []*Index // Unique constraints and indexes other than the primary key
+	ForeignKeys []*ForeignKey
+	Checks      []string // Names of CHECK constraints
+
+	objectID int64
+}
+
+// QualifiedName returns schema.name.
+func (t *Table) QualifiedName() string {
+	return t.Schema + "." + t.Name
+}
+
+// Column is a table column.
+type Column struct {
+	Name      string
+	Type      string // Lower-case base type name, e.g. "nvarchar"
+	MaxLength int    // In bytes, as in sys.columns; -1 for MAX
+	Precision int
+	Scale     int
+	Nullable  bool
+
+	Identity          bool
+	IdentitySeed      int64
+	IdentityIncrement int64
+
+	Default  string // Default constraint definition, e.g. "((0))"
+	Computed string // Computed column definition; "" for a stored column
+}
+
+// Index is a primary key, unique constraint or index.
+type Index struct {
+	Name       string
+	Columns    []string
+	Unique     bool
+	Constraint bool   // A PRIMARY KEY or UNIQUE constraint rather than an index
+	Filter     string // Filtered index predicate
+	Included   []string
+}
+
+// ForeignKey is a foreign key constraint.
+type ForeignKey struct {
+	Name       string
+	Columns    []string
+	RefTable   *Table // nil for a table outside the catalogue
+	RefColumns []string
+	OnDelete   string // "" for NO ACTION
+	OnUpdate   string
+}
+
+// Module is a programmable object, or another object an import reports
+// on: a sequence, a synonym or a CLR object.
+type Module struct {
+	Schema     string
+	Name       string
+	Type       string // sys.objects type code, e.g. "P", "FN", "V", "TR"
+	Definition string // "" for objects without T-SQL source
+}
+
+// QualifiedName returns schema.name.
+func (m *Module) QualifiedName() string {
+	return m.Schema + "." + m.Name
+}
+
+// Source is a database to import.
+type Source interface {
+	// Catalog reads the tables and objects of the database.
+	Catalog(ctx context.Context) (*Catalog, error)
+
+	// Rows calls fn with the values of columns for each row of t.
+	Rows(ctx context.Context, t *Table, columns []*Column, fn func(values []interface{}) error) error
+}
+
+// sqlServer reads a SQL Server database.
+type sqlServer struct {
+	db *sql.DB
+}
+
+// NewSource returns a Source reading the SQL Server database db is
+// connected to.
+func NewSource(db *sql.DB) Source {
+	return &sqlServer{db: db}
+}
+
+// Catalogue queries. Alias types are reported by their base type.
+const (
+	tablesQuery = `
+SELECT t.object_id, s.name, t.name
+FROM sys.tables t
+JOIN sys.schemas s ON s.schema_id = t.schema_id
+WHERE t.is_ms_shipped = 0
+ORDER BY s.name, t.name`
+
+	columnsQuery = `
+SELECT c.object_id, c.name, TYPE_NAME(c.system_type_id), c.max_length,
+       CAST(c.precision AS int), CAST(c.scale AS int), c.is_nullable, c.is_identity,
+       CAST(ISNULL(ic.seed_value, 0) AS bigint), CAST(ISNULL(ic.increment_value, 0) AS bigint),
+       ISNULL(dc.definition, ''), ISNULL(cc.definition, '')
+FROM sys.columns c
+JOIN sys.tables t ON t.object_id = c.object_id
+LEFT JOIN sys.identity_columns ic ON ic.object_id = c.object_id AND ic.column_id = c.column_id
+LEFT JOIN sys.default_constraints dc ON dc.parent_object_id = c.object_id AND dc.parent_column_id = c.column_id
+LEFT JOIN sys.computed_columns cc ON cc.object_id = c.object_id AND cc.column_id = c.column_id
+WHERE t.is_ms_shipped = 0
+ORDER BY c.object_id, c.column_id`
+
+	indexesQuery = `
+SELECT i.object_id, i.name, i.is_primary_key, i.is_unique_constraint, i.is_unique,
+       ISNULL(i.filter_definition, ''), COL_NAME(ic.object_id, ic.column_id), ic.is_included_column
+FROM sys.indexes i
+JOIN sys.tables t ON t.object_id = i.object_id
+JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+WHERE t.is_ms_shipped = 0 AND i.type IN (1, 2) AND i.is_hypothetical = 0
+ORDER BY i.object_id, i.index_id, ic.is_included_column, ic.key_ordinal, ic.index_column_id`
+
+	foreignKeysQuery = `
+SELECT fk.parent_object_id, fk.name, fk.referenced_object_id,
+       COL_NAME(fkc.parent_object_id, fkc.parent_column_id),
+       COL_NAME(fkc.referenced_object_id, fkc.referenced_column_id),
+       fk.delete_referential_action_desc, fk.update_referential_action_desc
+FROM sys.foreign_keys fk
+JOIN sys.foreign_key_columns fkc ON fkc.constraint_object_id = fk.object_id
+WHERE fk.is_ms_shipped = 0
+ORDER BY fk.parent_object_id, fk.name, fkc.constraint_column_id`
+
+	checksQuery = `
+SELECT parent_object_id, name
+FROM sys.check_constraints
+WHERE is_ms_shipped = 0
+ORDER BY parent_object_id, name`
+
+	modulesQuery = `
+SELECT s.name, o.name, RTRIM(o.type), ISNULL(m.definition, '')
+FROM sys.objects o
+JOIN sys.schemas s ON s.schema_id = o.schema_id
+LEFT JOIN sys.sql_modules m ON m.object_id = o.object_id
+WHERE o.is_ms_shipped = 0 AND o.type IN ('P', 'FN', 'IF', 'TF', 'V', 'TR', 'PC', 'FS', 'FT', 'TA', 'SO', 'SN')
+ORDER BY s.name, o.name`
+)
+
+func (s *sqlServer) Catalog(ctx context.Context) (*Catalog, error) {
+	c := &Catalog{}
+	if err := s.db.QueryRowContext(ctx, "SELECT DB_NAME()").Scan(&c.Database); err != nil {
+		return nil, fmt.Errorf("reading database name: %w", err)
+	}
+
+	tables := make(map[int64]*Table)
+	err := s.query(ctx, tablesQuery, func(rows *sql.Rows) error {
+		t := &Table{}
+		if err := rows.Scan(&t.objectID, &t.Schema, &t.Name); err != nil {
+			return err
+		}
+		tables[t.objectID] = t
+		c.Tables = append(c.Tables, t)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading tables: %w", err)
+	}
+
+	err = s.query(ctx, columnsQuery, func(rows *sql.Rows) error {
+		var id int64
+		col := &Column{}
+		err := rows.Scan(&id, &col.Name, &col.Type, &col.MaxLength, &col.Precision, &col.Scale,
+			&col.Nullable, &col.Identity, &col.IdentitySeed, &col.IdentityIncrement, &col.Default, &col.Computed)
+		if err != nil {
+			return err
+		}
+		if t := tables[id]; t != nil {
+			col.Type = strings.ToLower(col.Type)
+			t.Columns = append(t.Columns, col)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading columns: %w", err)
+	}
+
+	var last *Index
+	var lastID int64
+	err = s.query(ctx, indexesQuery, func(rows *sql.Rows) error {
+		var (
+			id                       int64
+			name, filter, column     string
+			pk, uq, unique, included bool
+		)
+		if err := rows.Scan(&id, &name, &pk, &uq, &unique, &filter, &column, &included); err != nil {
+			return err
+		}
+		t := tables[id]
+		if t == nil {
+			return nil
+		}
+		if last == nil || lastID != id || last.Name != name {
+			last, lastID = &Index{Name: name, Unique: unique, Constraint: pk || uq, Filter: filter}, id
+			if pk {
+				t.PrimaryKey = last
+			} else {
+				t.Indexes = append(t.Indexes, last)
+			}
+		}
+		if included {
+			last.Included = append(last.Included, column)
+		} else {
+			last.Columns = append(last.Columns, column)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading indexes: %w", err)
+	}
+
+	var lastFK *ForeignKey
+	err = s.query(ctx, foreignKeysQuery, func(rows *sql.Rows) error {
+		var (
+			id, refID                      int64
+			name, col, refCol, onDel, onUp string
+		)
+		if err := rows.Scan(&id, &name, &refID, &col, &refCol, &onDel, &onUp); err != nil {
+			return err
+		}
+		t := tables[id]
+		if t == nil {
+			return nil
+		}
+		if lastFK == nil || lastID != id || lastFK.Name != name {
+			lastFK, lastID = &ForeignKey{
+				Name:     name,
+				RefTable: tables[refID],
+				OnDelete: referentialAction(onDel),
+				OnUpdate: referentialAction(onUp),
+			}, id
+			t.ForeignKeys = append(t.ForeignKeys, lastFK)
+		}
+		lastFK.Columns = append(lastFK.Columns, col)
+		lastFK.RefColumns = append(lastFK.RefColumns, refCol)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading foreign keys: %w", err)
+	}
+
+	err = s.query(ctx, checksQuery, func(rows *sql.Rows) error {
+		var (
+			id   int64
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		if t := tables[id]; t != nil {
+			t.Checks = append(t.Checks, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading check constraints: %w", err)
+	}
+
+	err = s.query(ctx, modulesQuery, func(rows *sql.Rows) error {
+		m := &Module{}
+		if err := rows.Scan(&m.Schema, &m.Name, &m.Type, &m.Definition); err != nil {
+			return err
+		}
+		c.Modules = append(c.Modules, m)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading programmable objects: %w", err)
+	}
+	return c, nil
+}
+
+// referentialAction turns a sys.foreign_keys action description into
+// T-SQL, "" for NO_ACTION.
+func referentialAction(desc string) string {
+	if desc == "NO_ACTION" {
+		return ""
+	}
+	return strings.ReplaceAll(desc, "_", " ")
+}
+
+func (s *sqlServer) query(ctx context.Context, query string, fn func(*sql.Rows) error) error {
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlServer) Rows(ctx context.Context, t *Table, columns []*Column, fn func(values []interface{}) error) error {
+	exprs := make([]string, len(columns))
+	for i, c := range columns {
+		exprs[i] = selectExpr(c)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s.%s", strings.Join(exprs, ", "), quoteName(t.Schema), quoteName(t.Name))
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// selectExpr reads column c in a form Literal can render: the driver
+// returns uniqueidentifiers as raw bytes and xml as a type of its own.
+func selectExpr(c *Column) string {
+	switch c.Type {
+	case "uniqueidentifier":
+		return "CONVERT(char(36), " + quoteName(c.Name) + ")"
+	case "xml":
+		return "CONVERT(nvarchar(max), " + quoteName(c.Name) + ")"
+	}
+	return quoteName(c.Name)
+}
+
+// quoteName brackets an identifier.
+func quoteName(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
@@ -0,0 +1,375 @@
+package mssqlimport
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// DefaultBatchSize is the number of rows per INSERT statement.
+const DefaultBatchSize = 100
+
+// maxParseIssues bounds the parse errors reported for one object.
+const maxParseIssues = 5
+
+// Target is the aul server an import loads into.
+type Target interface {
+	Exec(ctx context.Context, batch string) error
+}
+
+type connTarget struct {
+	conn *sql.Conn
+}
+
+// NewTarget returns a Target executing batches on conn.
+func NewTarget(conn *sql.Conn) Target {
+	return connTarget{conn: conn}
+}
+
+func (t connTarget) Exec(ctx context.Context, batch string) error {
+	_, err := t.conn.ExecContext(ctx, batch)
+	return err
+}
+
+// Importer copies a database from a Source into a Target.
+type Importer struct {
+	Source Source
+	Target Target
+
+	// ProcDir is the procedure directory procedures and functions are
+	// written into, under Database/schema/name.sql ("" = not written).
+	ProcDir  string
+	Database string // Default: the source database's name
+
+	Schemas    []string // Schemas imported (empty = all)
+	SchemaOnly bool     // Create the tables without copying their rows
+	BatchSize  int      // Rows per INSERT (0 = DefaultBatchSize)
+
+	// Progress is told what the import is doing.
+	Progress func(message string)
+}
+
+// Run imports the database. The error return is reserved for failing to
+// read the source's catalogue; everything else is in the report.
+func (im *Importer) Run(ctx context.Context) (*Report, error) {
+	report := &Report{StartedAt: time.Now()}
+	cat, err := im.Source.Catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Database = cat.Database
+	database := im.Database
+	if database == "" {
+		database = cat.Database
+	}
+
+	var tables []*Table
+	for _, t := range cat.Tables {
+		if im.included(t.Schema) {
+			tables = append(tables, t)
+		}
+	}
+	tables, cyclic := order(tables)
+
+	// Tables first, so that views can refer to them
+	type created struct {
+		table  *Table
+		script *tableScript
+		object *Object
+	}
+	var loaded []created
+	for _, t := range tables {
+		im.progress("creating table %s", t.QualifiedName())
+		o := &Object{Kind: "table", Name: t.QualifiedName()}
+		report.Objects = append(report.Objects, o)
+
+		s := scriptTable(t, func(fk *ForeignKey) bool {
+			return fk.RefTable != nil && slices.Contains(tables, fk.RefTable) && !cyclic[fk]
+		})
+		for _, fk := range t.ForeignKeys {
+			switch {
+			case fk.RefTable == nil || !slices.Contains(tables, fk.RefTable):
+				s.issue("foreign key %s not imported: the table it references is not imported", fk.Name)
+			case cyclic[fk]:
+				s.issue("foreign key %s not imported: it closes a cycle of foreign keys", fk.Name)
+			}
+		}
+
+		if err := im.Target.Exec(ctx, s.Create); err != nil {
+			o.Status, o.Error, o.Issues = StatusFailed, err.Error(), s.Issues
+			continue
+		}
+		for _, ix := range s.Indexes {
+			if err := im.Target.Exec(ctx, ix); err != nil {
+				s.issue("%s failed: %v", ix, err)
+			}
+		}
+		o.Status, o.Issues = StatusImported, s.Issues
+		loaded = append(loaded, created{t, s, o})
+	}
+
+	if !im.SchemaOnly {
+		for _, c := range loaded {
+			im.progress("copying rows of %s", c.table.QualifiedName())
+			if err := im.copyRows(ctx, c.table, c.script, c.object); err != nil {
+				c.object.Status, c.object.Error = StatusFailed, "copying rows: "+err.Error()
+			}
+		}
+	}
+	for _, c := range loaded {
+		if c.object.Status == StatusImported && len(c.object.Issues) > 0 {
+			c.object.Status = StatusConverted
+		}
+	}
+
+	for _, m := range cat.Modules {
+		if !im.included(m.Schema) {
+			continue
+		}
+		im.progress("converting %s %s", moduleKind(m), m.QualifiedName())
+		report.Objects = append(report.Objects, im.importModule(ctx, m, database))
+	}
+
+	report.Duration = time.Since(report.StartedAt)
+	return report, nil
+}
+
+func (im *Importer) included(schema string) bool {
+	if len(im.Schemas) == 0 {
+		return true
+	}
+	for _, s := range im.Schemas {
+		if strings.EqualFold(s, schema) {
+			return true
+		}
+	}
+	return false
+}
+
+func (im *Importer) progress(format string, args ...interface{}) {
+	if im.Progress != nil {
+		im.Progress(fmt.Sprintf(format, args...))
+	}
+}
+
+// order returns tables with each after the tables its foreign keys
+// reference, and the foreign keys that would close a cycle; a table
+// referring to itself is no cycle.
+func order(tables []*Table) ([]*Table, map[*ForeignKey]bool) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[*Table]int)
+	cyclic := make(map[*ForeignKey]bool)
+	ordered := make([]*Table, 0, len(tables))
+
+	var visit func(t *Table)
+	visit = func(t *Table) {
+		state[t] = visiting
+		for _, fk := range t.ForeignKeys {
+			ref := fk.RefTable
+			if ref == nil || ref == t || !slices.Contains(tables, ref) {
+				continue
+			}
+			switch state[ref] {
+			case 0:
+				visit(ref)
+			case visiting:
+				cyclic[fk] = true
+			}
+		}
+		state[t] = done
+		ordered = append(ordered, t)
+	}
+	for _, t := range tables {
+		if state[t] == 0 {
+			visit(t)
+		}
+	}
+	return ordered, cyclic
+}
+
+// copyRows copies the rows of t in INSERTs of up to BatchSize rows,
+// counting them in o.
+func (im *Importer) copyRows(ctx context.Context, t *Table, s *tableScript, o *Object) error {
+	if len(s.Columns) == 0 {
+		return nil
+	}
+	size := im.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+
+	names := make([]string, len(s.Columns))
+	identity := false
+	for i, c := range s.Columns {
+		names[i] = c.Name
+		identity = identity || c.Identity
+	}
+	table := quoteName(t.Name)
+	head := fmt.Sprintf("INSERT INTO %s (%s) VALUES\n", table, columnList(names))
+
+	var rows []string
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		stmt := head + strings.Join(rows, ",\n")
+		if identity {
+			stmt = fmt.Sprintf("SET IDENTITY_INSERT %s ON\n%s\nSET IDENTITY_INSERT %s OFF", table, stmt, table)
+		}
+		if err := im.Target.Exec(ctx, stmt); err != nil {
+			return err
+		}
+		o.Rows += int64(len(rows))
+		rows = rows[:0]
+		return nil
+	}
+
+	err := im.Source.Rows(ctx, t, s.Columns, func(values []interface{}) error {
+		literals := make([]string, len(values))
+		for i, v := range values {
+			literals[i] = literal(s.Columns[i], v)
+		}
+		rows = append(rows, "  ("+strings.Join(literals, ", ")+")")
+		if len(rows) >= size {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// moduleKinds names the kinds of sys.objects type codes.
+var moduleKinds = map[string]string{
+	"P":  "procedure",
+	"FN": "function",
+	"IF": "function",
+	"TF": "function",
+	"V":  "view",
+	"TR": "trigger",
+	"PC": "clr",
+	"FS": "clr",
+	"FT": "clr",
+	"TA": "clr",
+	"SO": "sequence",
+	"SN": "synonym",
+}
+
+// unsupportedKinds explains the kinds aul has no equivalent for.
+var unsupportedKinds = map[string]string{
+	"trigger":  "aul does not run triggers",
+	"clr":      "CLR objects cannot be imported",
+	"sequence": "aul has no sequences",
+	"synonym":  "aul has no synonyms",
+}
+
+func moduleKind(m *Module) string {
+	if kind, ok := moduleKinds[m.Type]; ok {
+		return kind
+	}
+	return m.Type
+}
+
+// importModule carries over a programmable object: procedures and
+// functions into the procedure directory, views onto the target.
+func (im *Importer) importModule(ctx context.Context, m *Module, database string) *Object {
+	o := &Object{Kind: moduleKind(m), Name: m.QualifiedName()}
+	if reason, ok := unsupportedKinds[o.Kind]; ok {
+		o.Status, o.Error = StatusUnsupported, reason
+		return o
+	}
+	if m.Definition == "" {
+		o.Status, o.Error = StatusUnsupported, "the definition is encrypted or unavailable"
+		return o
+	}
+
+	if o.Kind == "view" {
+		if err := im.Target.Exec(ctx, m.Definition); err != nil {
+			o.Status, o.Error = StatusFailed, err.Error()
+			return o
+		}
+		o.Status = StatusImported
+		return o
+	}
+
+	source, changed := normaliseHeader(m.Definition)
+	if changed {
+		o.Issues = append(o.Issues, "CREATE header rewritten as aul's procedure loader expects")
+	}
+
+	// What aul's parser cannot read is what aul cannot run
+	p := parser.New(lexer.New(source))
+	p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		o.Status = StatusUnsupported
+		o.Error = fmt.Sprintf("%d constructs aul cannot parse", len(errs))
+		o.Issues = append(o.Issues, errs[:min(len(errs), maxParseIssues)]...)
+		return o
+	}
+	if _, err := procedure.NewParser(procedure.DialectTSQL).Parse(source); err != nil {
+		o.Status, o.Error = StatusFailed, err.Error()
+		return o
+	}
+
+	if im.ProcDir != "" {
+		dir := filepath.Join(im.ProcDir, fileName(database), fileName(m.Schema))
+		path := filepath.Join(dir, fileName(m.Name)+".sql")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			o.Status, o.Error = StatusFailed, err.Error()
+			return o
+		}
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			o.Status, o.Error = StatusFailed, err.Error()
+			return o
+		}
+		o.File = path
+	}
+
+	o.Status = StatusImported
+	if len(o.Issues) > 0 {
+		o.Status = StatusConverted
+	}
+	return o
+}
+
+// createHeader matches the CREATE line of a procedure or function.
+var createHeader = regexp.MustCompile(`(?im)^[ \t]*CREATE\s+(OR\s+ALTER\s+)?(PROCEDURE|PROC|FUNCTION)\s+`)
+
+// normaliseHeader rewrites the CREATE header of a procedure or function
+// to the form the procedure loader finds the name in: CREATE PROCEDURE or
+// CREATE FUNCTION followed by the name on the same line.
+func normaliseHeader(source string) (string, bool) {
+	loc := createHeader.FindStringSubmatchIndex(source)
+	if loc == nil {
+		return source, false
+	}
+	keyword := "PROCEDURE"
+	if strings.EqualFold(source[loc[4]:loc[5]], "FUNCTION") {
+		keyword = "FUNCTION"
+	}
+	header := "CREATE " + keyword + " "
+	if source[loc[0]:loc[1]] == header {
+		return source, false
+	}
+	return source[:loc[0]] + header + source[loc[1]:], true
+}
+
+// fileName makes an identifier safe as a file or directory name.
+func fileName(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(name)
+}
@@ -0,0 +1,299 @@
+package mssqlimport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// fakeSource serves a fixed catalogue and rows.
+type fakeSource struct {
+	catalog *Catalog
+	rows    map[string][][]interface{}
+}
+
+func (s *fakeSource) Catalog(ctx context.Context) (*Catalog, error) {
+	return s.catalog, nil
+}
+
+func (s *fakeSource) Rows(ctx context.Context, t *Table, columns []*Column, fn func([]interface{}) error) error {
+	for _, row := range s.rows[t.Name] {
+		if err := fn(row[:len(columns)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordingTarget records the batches it is given, failing those that
+// contain a string in fail.
+type recordingTarget struct {
+	batches []string
+	fail    []string
+}
+
+func (t *recordingTarget) Exec(ctx context.Context, batch string) error {
+	for _, f := range t.fail {
+		if strings.Contains(batch, f) {
+			return errors.New("rejected")
+		}
+	}
+	t.batches = append(t.batches, batch)
+	return nil
+}
+
+func parses(t *testing.T, sql string) {
+	t.Helper()
+	p := parser.New(lexer.New(sql))
+	p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Errorf("%s\ndoes not parse: %v", sql, errs)
+	}
+}
+
+func testCatalog() *Catalog {
+	customers := &Table{
+		Schema: "dbo",
+		Name:   "Customers",
+		Columns: []*Column{
+			{Name: "Id", Type: "int", Identity: true, IdentitySeed: 1, IdentityIncrement: 1},
+			{Name: "Name", Type: "nvarchar", MaxLength: 200, Default: "(N'none')"},
+			{Name: "Shape", Type: "geometry", Nullable: true},
+		},
+		PrimaryKey: &Index{Name: "PK_Customers", Columns: []string{"Id"}, Unique: true, Constraint: true},
+		Indexes: []*Index{
+			{Name: "IX_Name", Columns: []string{"Name"}},
+		},
+		Checks: []string{"CK_Name"},
+	}
+	orders := &Table{
+		Schema: "sales",
+		Name:   "Orders",
+		Columns: []*Column{
+			{Name: "OrderId", Type: "int"},
+			{Name: "CustomerId", Type: "int"},
+			{Name: "Total", Type: "decimal", Precision: 10, Scale: 2},
+		},
+		PrimaryKey: &Index{Name: "PK_Orders", Columns: []string{"OrderId"}, Unique: true, Constraint: true},
+	}
+	orders.ForeignKeys = []*ForeignKey{{
+		Name: "FK_Orders_Customers", Columns: []string{"CustomerId"},
+		RefTable: customers, RefColumns: []string{"Id"}, OnDelete: "CASCADE",
+	}}
+	return &Catalog{
+		Database: "Shop",
+		// Orders before Customers, so that the import has to reorder them
+		Tables: []*Table{orders, customers},
+		Modules: []*Module{
+			{Schema: "dbo", Name: "GetCustomer", Type: "P", Definition: "CREATE OR ALTER PROC dbo.GetCustomer\n  @Id INT\nAS\nBEGIN\n  SELECT Name FROM Customers WHERE Id = @Id\nEND"},
+			{Schema: "dbo", Name: "Audit", Type: "TR", Definition: "CREATE TRIGGER Audit ON Customers AFTER INSERT AS SELECT 1"},
+			{Schema: "dbo", Name: "OrderSeq", Type: "SO"},
+		},
+	}
+}
+
+func TestScriptTable(t *testing.T) {
+	cat := testCatalog()
+	customers := cat.Tables[1]
+
+	s := scriptTable(customers, func(*ForeignKey) bool { return true })
+	parses(t, s.Create)
+	for _, want := range []string{"[Id] INT IDENTITY(1, 1) NOT NULL", "[Name] NVARCHAR(100) NOT NULL DEFAULT ('none')"} {
+		if !strings.Contains(s.Create, want) {
+			t.Errorf("CREATE TABLE lacks %q:\n%s", want, s.Create)
+		}
+	}
+	if strings.Contains(s.Create, "Shape") || strings.Contains(s.Create, "PRIMARY KEY") {
+		t.Errorf("CREATE TABLE should leave out Shape and the identity primary key:\n%s", s.Create)
+	}
+	if len(s.Columns) != 2 {
+		t.Errorf("got %d columns, want 2", len(s.Columns))
+	}
+	if len(s.Indexes) != 1 || s.Indexes[0] != "CREATE INDEX [IX_Name] ON [Customers] ([Name])" {
+		t.Errorf("Indexes = %q", s.Indexes)
+	}
+	if len(s.Issues) != 2 {
+		t.Errorf("Issues = %q, want the geometry column and the CHECK constraint", s.Issues)
+	}
+
+	orders := cat.Tables[0]
+	s = scriptTable(orders, func(*ForeignKey) bool { return true })
+	parses(t, s.Create)
+	if !strings.Contains(s.Create, "REFERENCES [Customers] ([Id]) ON DELETE CASCADE") {
+		t.Errorf("CREATE TABLE lacks the foreign key:\n%s", s.Create)
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	when := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		typ  string
+		v    interface{}
+		want string
+	}{
+		{"int", nil, "NULL"},
+		{"bit", true, "1"},
+		{"bigint", int64(-42), "-42"},
+		{"float", 1.5, "1.5"},
+		{"nvarchar", "O'Brien", "'O''Brien'"},
+		{"varbinary", []byte{0x01, 0xff}, "0x01FF"},
+		{"decimal", []byte("12.50"), "12.50"},
+		{"varchar", []byte("abc"), "'abc'"},
+		{"date", when, "'2024-03-01'"},
+		{"datetime2", when, "'2024-03-01T12:30:00'"},
+	}
+	for _, tt := range tests {
+		if got := literal(&Column{Type: tt.typ}, tt.v); got != tt.want {
+			t.Errorf("literal(%s, %v) = %s, want %s", tt.typ, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	a := &Table{Name: "A"}
+	b := &Table{Name: "B"}
+	c := &Table{Name: "C"}
+	// A -> B -> C -> A, and C refers to itself
+	a.ForeignKeys = []*ForeignKey{{Name: "a_b", RefTable: b}}
+	b.ForeignKeys = []*ForeignKey{{Name: "b_c", RefTable: c}}
+	c.ForeignKeys = []*ForeignKey{{Name: "c_a", RefTable: a}, {Name: "c_c", RefTable: c}}
+
+	ordered, cyclic := order([]*Table{a, b, c})
+	var names []string
+	for _, t := range ordered {
+		names = append(names, t.Name)
+	}
+	if got := strings.Join(names, ","); got != "C,B,A" {
+		t.Errorf("order = %s, want C,B,A", got)
+	}
+	if len(cyclic) != 1 || !cyclic[c.ForeignKeys[0]] {
+		t.Errorf("cyclic = %v, want only c_a", cyclic)
+	}
+}
+
+func TestImporter_Run(t *testing.T) {
+	procDir := t.TempDir()
+	target := &recordingTarget{}
+	im := &Importer{
+		Source: &fakeSource{
+			catalog: testCatalog(),
+			rows: map[string][][]interface{}{
+				"Customers": {{int64(1), "Ann", nil}, {int64(2), "Bob", nil}, {int64(3), "Cy", nil}},
+				"Orders":    {{int64(10), int64(1), []byte("9.99")}},
+			},
+		},
+		Target:    target,
+		ProcDir:   procDir,
+		BatchSize: 2,
+	}
+
+	report, err := im.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed() {
+		var buf bytes.Buffer
+		report.WriteText(&buf, true)
+		t.Fatalf("import failed:\n%s", buf.String())
+	}
+
+	// Customers is created first, as Orders refers to it
+	if !strings.HasPrefix(target.batches[0], "CREATE TABLE [Customers]") {
+		t.Errorf("first batch:\n%s", target.batches[0])
+	}
+	var inserts []string
+	for _, b := range target.batches {
+		if strings.Contains(b, "INSERT INTO") {
+			parses(t, b)
+			inserts = append(inserts, b)
+		}
+	}
+	if len(inserts) != 3 {
+		t.Fatalf("got %d INSERT batches, want 3 (two for Customers, one for Orders)", len(inserts))
+	}
+	if !strings.HasPrefix(inserts[0], "SET IDENTITY_INSERT [Customers] ON") {
+		t.Errorf("identity insert not enabled:\n%s", inserts[0])
+	}
+
+	byName := make(map[string]*Object)
+	for _, o := range report.Objects {
+		byName[o.Name] = o
+	}
+	if o := byName["dbo.Customers"]; o.Status != StatusConverted || o.Rows != 3 {
+		t.Errorf("dbo.Customers = %s with %d rows", o.Status, o.Rows)
+	}
+	if o := byName["sales.Orders"]; o.Status != StatusConverted || o.Rows != 1 {
+		t.Errorf("sales.Orders = %s with %d rows", o.Status, o.Rows)
+	}
+	if o := byName["dbo.Audit"]; o.Status != StatusUnsupported {
+		t.Errorf("trigger = %s, want unsupported", o.Status)
+	}
+	if o := byName["dbo.OrderSeq"]; o.Status != StatusUnsupported {
+		t.Errorf("sequence = %s, want unsupported", o.Status)
+	}
+
+	proc := byName["dbo.GetCustomer"]
+	if proc.Status != StatusConverted {
+		t.Fatalf("procedure = %s (%s)", proc.Status, proc.Error)
+	}
+	want := filepath.Join(procDir, "Shop", "dbo", "GetCustomer.sql")
+	if proc.File != want {
+		t.Errorf("procedure written to %s, want %s", proc.File, want)
+	}
+	data, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "CREATE PROCEDURE dbo.GetCustomer\n") {
+		t.Errorf("procedure file:\n%s", data)
+	}
+}
+
+func TestImporter_Failures(t *testing.T) {
+	target := &recordingTarget{fail: []string{"CREATE TABLE [Orders]"}}
+	cat := testCatalog()
+	cat.Modules = []*Module{
+		{Schema: "dbo", Name: "Broken", Type: "P", Definition: "CREATE PROCEDURE Broken AS SELECT FROM WHERE ("},
+	}
+	im := &Importer{
+		Source:     &fakeSource{catalog: cat},
+		Target:     target,
+		Schemas:    []string{"SALES", "dbo"},
+		SchemaOnly: true,
+	}
+
+	report, err := im.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Failed() {
+		t.Error("report should record the failed table")
+	}
+	counts := report.Counts()
+	if counts[StatusFailed] != 1 || counts[StatusUnsupported] != 1 || counts[StatusConverted] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+
+	var buf bytes.Buffer
+	report.WriteText(&buf, false)
+	for _, want := range []string{"[failed] table sales.Orders: rejected", "[unsupported] procedure dbo.Broken"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, buf.String())
+		}
+	}
+	buf.Reset()
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"counts"`) {
+		t.Errorf("JSON report lacks counts:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,109 @@
+package mssqlimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Status is how an object fared in an import.
+type Status string
+
+const (
+	StatusImported    Status = "imported"    // Carried over as it was
+	StatusConverted   Status = "converted"   // Carried over with the changes listed in Issues
+	StatusFailed      Status = "failed"      // aul rejected it
+	StatusUnsupported Status = "unsupported" // Not carried over: aul has no equivalent
+)
+
+// statuses lists the statuses in the order they are reported.
+var statuses = []Status{StatusImported, StatusConverted, StatusFailed, StatusUnsupported}
+
+// Object is the outcome for one table or programmable object.
+type Object struct {
+	Kind   string   `json:"kind"` // table, procedure, function, view, trigger, sequence, synonym, clr
+	Name   string   `json:"name"` // schema.name in the source
+	Status Status   `json:"status"`
+	Rows   int64    `json:"rows,omitempty"` // Rows copied
+	File   string   `json:"file,omitempty"` // Procedure file written
+	Issues []string `json:"issues,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Report is the conversion report of an import.
+type Report struct {
+	Database  string        `json:"database"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+	Objects   []*Object     `json:"objects"`
+}
+
+// Counts tallies objects by status.
+func (r *Report) Counts() map[Status]int {
+	counts := make(map[Status]int)
+	for _, o := range r.Objects {
+		counts[o.Status]++
+	}
+	return counts
+}
+
+// Failed reports whether any object failed to import.
+func (r *Report) Failed() bool {
+	return r.Counts()[StatusFailed] > 0
+}
+
+// WriteText writes a human-readable report. With verbose set every
+// object is listed; otherwise only those that did not import unchanged.
+func (r *Report) WriteText(w io.Writer, verbose bool) {
+	var rows int64
+	for _, o := range r.Objects {
+		rows += o.Rows
+	}
+	fmt.Fprintf(w, "Import of %s (%d objects, %d rows, %s)\n\n",
+		r.Database, len(r.Objects), rows, r.Duration.Round(time.Millisecond))
+
+	counts := r.Counts()
+	for _, s := range statuses {
+		fmt.Fprintf(w, "  %-12s %6d\n", s, counts[s])
+	}
+
+	var listed []*Object
+	for _, o := range r.Objects {
+		if verbose || o.Status != StatusImported {
+			listed = append(listed, o)
+		}
+	}
+	if len(listed) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	for _, o := range listed {
+		fmt.Fprintf(w, "  [%s] %s %s", o.Status, o.Kind, o.Name)
+		if o.Rows > 0 {
+			fmt.Fprintf(w, " (%d rows)", o.Rows)
+		}
+		if o.Error != "" {
+			fmt.Fprintf(w, ": %s", o.Error)
+		}
+		fmt.Fprintln(w)
+		for _, issue := range o.Issues {
+			fmt.Fprintf(w, "      - %s\n", issue)
+		}
+	}
+}
+
+// WriteJSON writes the report with its tallies as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	out := struct {
+		*Report
+		Counts map[Status]int `json:"counts"`
+	}{
+		Report: r,
+		Counts: r.Counts(),
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
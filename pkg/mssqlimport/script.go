@@ -0,0 +1,219 @@
+package mssqlimport
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unsupportedTypes are column types aul has no way to hold. Such columns
+// are left out of the table, as are those of user-defined types, whose
+// names are schema-qualified.
+var unsupportedTypes = []string{"sql_variant", "hierarchyid", "geography", "geometry", "timestamp"}
+
+// tableScript is how a table is created in aul.
+type tableScript struct {
+	Create  string   // CREATE TABLE statement
+	Indexes []string // CREATE INDEX statements to run after it
+	Columns []*Column
+	Issues  []string // What differs from the source table
+}
+
+// scriptTable scripts t as T-SQL that aul accepts. Foreign keys are only
+// kept when keep says so for them.
+func scriptTable(t *Table, keep func(*ForeignKey) bool) *tableScript {
+	s := &tableScript{}
+	if !strings.EqualFold(t.Schema, "dbo") {
+		s.issue("created as %s: aul tables are not schema-qualified", quoteName(t.Name))
+	}
+
+	var defs []string
+	var identity *Column
+	for _, c := range t.Columns {
+		switch {
+		case c.Type == "" && c.Computed != "":
+			s.issue("computed column %s left out: its type is not known (AS %s)", c.Name, c.Computed)
+			continue
+		case c.Type == "":
+			s.issue("column %s left out: its type is not known", c.Name)
+			continue
+		case slices.Contains(unsupportedTypes, c.Type) || strings.Contains(c.Type, "."):
+			s.issue("column %s left out: type %s is not supported", c.Name, c.Type)
+			continue
+		}
+		s.Columns = append(s.Columns, c)
+		defs = append(defs, s.column(c))
+		if c.Identity {
+			identity = c
+		}
+	}
+
+	if pk := t.PrimaryKey; pk != nil {
+		switch {
+		case identity == nil:
+			defs = append(defs, fmt.Sprintf("CONSTRAINT %s PRIMARY KEY (%s)", quoteName(pk.Name), columnList(pk.Columns)))
+		case len(pk.Columns) == 1 && strings.EqualFold(pk.Columns[0], identity.Name):
+			// The identity column is aul's key already
+		default:
+			defs = append(defs, fmt.Sprintf("CONSTRAINT %s UNIQUE (%s)", quoteName(pk.Name), columnList(pk.Columns)))
+			s.issue("primary key %s kept as a UNIQUE constraint: aul makes the identity column %s the key",
+				pk.Name, identity.Name)
+		}
+	}
+
+	for _, ix := range t.Indexes {
+		switch {
+		case ix.Constraint:
+			defs = append(defs, fmt.Sprintf("CONSTRAINT %s UNIQUE (%s)", quoteName(ix.Name), columnList(ix.Columns)))
+			continue
+		case ix.Filter != "" && ix.Unique:
+			s.issue("unique index %s left out: filtered indexes are not supported", ix.Name)
+			continue
+		case ix.Filter != "":
+			s.issue("index %s created without its filter %s", ix.Name, ix.Filter)
+		}
+		if len(ix.Included) > 0 {
+			s.issue("index %s created without its included columns", ix.Name)
+		}
+		unique := ""
+		if ix.Unique {
+			unique = "UNIQUE "
+		}
+		s.Indexes = append(s.Indexes, fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)",
+			unique, quoteName(ix.Name), quoteName(t.Name), columnList(ix.Columns)))
+	}
+
+	for _, fk := range t.ForeignKeys {
+		if !keep(fk) {
+			continue
+		}
+		def := fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+			quoteName(fk.Name), columnList(fk.Columns), quoteName(fk.RefTable.Name), columnList(fk.RefColumns))
+		if fk.OnDelete != "" {
+			def += " ON DELETE " + fk.OnDelete
+		}
+		if fk.OnUpdate != "" {
+			def += " ON UPDATE " + fk.OnUpdate
+		}
+		defs = append(defs, def)
+	}
+
+	for _, name := range t.Checks {
+		s.issue("CHECK constraint %s not imported", name)
+	}
+
+	s.Create = fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", quoteName(t.Name), strings.Join(defs, ",\n  "))
+	return s
+}
+
+func (s *tableScript) issue(format string, args ...interface{}) {
+	s.Issues = append(s.Issues, fmt.Sprintf(format, args...))
+}
+
+// column scripts a column definition.
+func (s *tableScript) column(c *Column) string {
+	def := quoteName(c.Name) + " " + typeName(c)
+	if c.Type == "xml" {
+		s.issue("xml column %s imported as NVARCHAR(MAX)", c.Name)
+	}
+	if c.Computed != "" {
+		s.issue("computed column %s imported as a stored column holding its values (AS %s)", c.Name, c.Computed)
+	}
+	if c.Identity {
+		def += fmt.Sprintf(" IDENTITY(%d, %d)", c.IdentitySeed, c.IdentityIncrement)
+	}
+	if c.Nullable {
+		def += " NULL"
+	} else {
+		def += " NOT NULL"
+	}
+	if c.Default != "" && c.Computed == "" {
+		def += " DEFAULT " + unicodePrefix.ReplaceAllString(c.Default, "$1'")
+	}
+	return def
+}
+
+// unicodePrefix matches the N of an N'...' literal, which aul's DDL does
+// not accept; aul's text is Unicode either way.
+var unicodePrefix = regexp.MustCompile(`(^|[^A-Za-z0-9_'])[Nn]'`)
+
+// typeName renders the declared type of c.
+func typeName(c *Column) string {
+	name := strings.ToUpper(c.Type)
+	length := func(n int) string {
+		if n < 0 {
+			return name + "(MAX)"
+		}
+		return fmt.Sprintf("%s(%d)", name, n)
+	}
+	switch c.Type {
+	case "varchar", "char", "varbinary", "binary":
+		return length(c.MaxLength)
+	case "nvarchar", "nchar":
+		if c.MaxLength < 0 {
+			return length(-1)
+		}
+		return length(c.MaxLength / 2)
+	case "decimal", "numeric":
+		return fmt.Sprintf("%s(%d, %d)", name, c.Precision, c.Scale)
+	case "datetime2", "time", "datetimeoffset":
+		return fmt.Sprintf("%s(%d)", name, c.Scale)
+	case "xml":
+		return "NVARCHAR(MAX)"
+	}
+	return name
+}
+
+func columnList(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quoteName(c)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// literal renders a value read from column c as a T-SQL literal.
+func literal(c *Column, v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		switch c.Type {
+		case "binary", "varbinary", "image":
+			return "0x" + strings.ToUpper(hex.EncodeToString(v))
+		case "decimal", "numeric", "money", "smallmoney":
+			return string(v)
+		}
+		return quote(string(v))
+	case string:
+		return quote(v)
+	case time.Time:
+		switch c.Type {
+		case "date":
+			return quote(v.Format("2006-01-02"))
+		case "time":
+			return quote(v.Format("15:04:05.9999999"))
+		case "datetimeoffset":
+			return quote(v.Format("2006-01-02T15:04:05.9999999-07:00"))
+		}
+		return quote(v.Format("2006-01-02T15:04:05.9999999"))
+	}
+	return quote(fmt.Sprint(v))
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
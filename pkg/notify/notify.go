@@ -0,0 +1,394 @@
+// Package notify delivers server events to webhooks.
+//
+// Events are things an operator wants to hear about without watching the
+// log: a procedure file that no longer loads, a procedure quarantined by
+// its circuit breaker, a deadlock. Each webhook receives the events it
+// subscribes to as an HTTP POST, with a JSON body that is either the event
+// itself or rendered from a template. Delivery happens in the background,
+// so that raising an event never waits on the network, and a failed POST
+// is retried with exponential backoff.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+// Event types
+const (
+	EventProcedureReloadFailed = "procedure.reload_failed" // A changed procedure file failed to load
+	EventBreakerOpened         = "breaker.opened"          // A procedure was quarantined
+	EventDeadlock              = "deadlock.detected"       // A transaction was chosen as a deadlock victim
+	EventShadowDiverged        = "shadow.diverged"         // A procedure's shadow candidate first differed from the current version
+	EventDeploymentSwitched    = "deployment.switched"     // Another procedure set went live, by deployment or rollback
+)
+
+// EventTypes lists the events that can be subscribed to.
+var EventTypes = []string{
+	EventProcedureReloadFailed,
+	EventBreakerOpened,
+	EventDeadlock,
+	EventShadowDiverged,
+	EventDeploymentSwitched,
+}
+
+// Delivery defaults
+const (
+	DefaultQueueSize  = 100
+	DefaultMaxRetries = 5
+	DefaultBackoff    = time.Second
+	DefaultTimeout    = 5 * time.Second
+
+	maxBackoff   = time.Minute     // Longest wait between retries
+	closeTimeout = 5 * time.Second // Longest Close waits for queued events
+)
+
+// Event is something that happened in the server.
+type Event struct {
+	Type    string                 `json:"event"`
+	Time    time.Time              `json:"time"`
+	Server  string                 `json:"server"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Config lists the webhooks events are delivered to.
+type Config struct {
+	Webhooks []WebhookConfig `json:"webhooks"`
+}
+
+// WebhookConfig configures one webhook.
+type WebhookConfig struct {
+	Name    string            `json:"name"`    // Identifies the webhook in the log (default: the URL's host)
+	URL     string            `json:"url"`     // http or https endpoint
+	Events  []string          `json:"events"`  // Event types delivered (empty = all)
+	Headers map[string]string `json:"headers"` // Added to each request, e.g. Authorization
+
+	// Template renders the request body from the Event, as a Go
+	// text/template whose json function encodes a value as JSON, e.g.
+	// {"text": {{json .Message}}}. Empty sends the event itself.
+	Template string `json:"template"`
+
+	MaxRetries int      `json:"max_retries"` // Retries after a failed POST (0 = DefaultMaxRetries, -1 = none)
+	Backoff    Duration `json:"backoff"`     // Wait before the first retry, doubling each time (0 = DefaultBackoff)
+	Timeout    Duration `json:"timeout"`     // Per request (0 = DefaultTimeout)
+	QueueSize  int      `json:"queue_size"`  // Events waiting for delivery; more are dropped (0 = DefaultQueueSize)
+}
+
+// Duration is a time.Duration written in JSON as a string such as "30s".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig reads a webhook configuration from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigMissing,
+			"failed to read notification config").
+			WithOp("notify.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigParse,
+			"failed to parse notification config").
+			WithOp("notify.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	return cfg, nil
+}
+
+// Notifier delivers events to webhooks. A nil Notifier discards events.
+type Notifier struct {
+	server   string
+	logger   *log.Logger
+	webhooks []*webhook
+
+	ctx    context.Context // Cancelled to abandon deliveries on Close
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.RWMutex // Guards closed against Notify
+	closed bool
+}
+
+// New starts delivering events to the webhooks of cfg. server names the
+// server in each event.
+func New(cfg Config, server string, logger *log.Logger) (*Notifier, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{server: server, logger: logger, ctx: ctx, cancel: cancel}
+	for i, wc := range cfg.Webhooks {
+		w, err := newWebhook(wc)
+		if err != nil {
+			cancel()
+			return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid,
+				"invalid webhook").
+				WithOp("notify.New").
+				WithField("webhook", i+1).
+				Err()
+		}
+		n.webhooks = append(n.webhooks, w)
+	}
+	for _, w := range n.webhooks {
+		n.wg.Add(1)
+		go n.run(w)
+	}
+	return n, nil
+}
+
+// Notify queues an event of type eventType for the webhooks subscribed to
+// it. fields are alternating keys and values, as for the logger. An event
+// is dropped, with a warning, when a webhook's queue is full.
+func (n *Notifier) Notify(eventType, message string, fields ...interface{}) {
+	if n == nil || len(n.webhooks) == 0 {
+		return
+	}
+	e := Event{
+		Type:    eventType,
+		Time:    time.Now().UTC(),
+		Server:  n.server,
+		Message: message,
+	}
+	if len(fields) > 0 {
+		e.Fields = make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			e.Fields[fmt.Sprint(fields[i])] = fields[i+1]
+		}
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	for _, w := range n.webhooks {
+		if !w.subscribed(eventType) {
+			continue
+		}
+		select {
+		case w.queue <- e:
+		default:
+			n.warn("webhook queue full; event dropped", w, e, nil)
+		}
+	}
+}
+
+// Close delivers the events still queued, waiting at most a few seconds
+// before abandoning them, and stops the notifier.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	for _, w := range n.webhooks {
+		close(w.queue)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+		n.cancel()
+		<-done
+	}
+	n.cancel()
+}
+
+// run delivers the events queued for w until the notifier is closed.
+func (n *Notifier) run(w *webhook) {
+	defer n.wg.Done()
+	for e := range w.queue {
+		if err := n.deliver(w, e); err != nil {
+			n.warn("webhook delivery failed; event dropped", w, e, err)
+		}
+	}
+}
+
+// deliver posts e to w, retrying with exponential backoff while the
+// failure may be temporary.
+func (n *Notifier) deliver(w *webhook, e Event) error {
+	body, err := w.render(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(n.ctx, body)
+		if err == nil || !retry || attempt >= w.maxRetries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-n.ctx.Done():
+			return err
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (n *Notifier) warn(msg string, w *webhook, e Event, err error) {
+	if n.logger == nil {
+		return
+	}
+	fields := []interface{}{"webhook", w.name, "event", e.Type}
+	if err != nil {
+		fields = append(fields, "error", err.Error())
+	}
+	n.logger.System().Warn(msg, fields...)
+}
+
+// webhook is a configured endpoint and its queue of events.
+type webhook struct {
+	name       string
+	url        string
+	events     []string
+	headers    map[string]string
+	template   *template.Template
+	maxRetries int
+	backoff    time.Duration
+	client     *http.Client
+	queue      chan Event
+}
+
+func newWebhook(cfg WebhookConfig) (*webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", cfg.URL)
+	}
+	for _, e := range cfg.Events {
+		if !slices.Contains(EventTypes, e) {
+			return nil, fmt.Errorf("unknown event %q (known: %s)", e, strings.Join(EventTypes, ", "))
+		}
+	}
+
+	w := &webhook{
+		name:       cfg.Name,
+		url:        u.String(),
+		events:     cfg.Events,
+		headers:    cfg.Headers,
+		maxRetries: cfg.MaxRetries,
+		backoff:    time.Duration(cfg.Backoff),
+		client:     &http.Client{Timeout: time.Duration(cfg.Timeout)},
+	}
+	if w.name == "" {
+		w.name = u.Host
+	}
+	switch {
+	case w.maxRetries == 0:
+		w.maxRetries = DefaultMaxRetries
+	case w.maxRetries < 0:
+		w.maxRetries = 0
+	}
+	if w.backoff <= 0 {
+		w.backoff = DefaultBackoff
+	}
+	if w.client.Timeout <= 0 {
+		w.client.Timeout = DefaultTimeout
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	w.queue = make(chan Event, queueSize)
+	if cfg.Template != "" {
+		w.template, err = template.New(w.name).Funcs(template.FuncMap{"json": jsonValue}).Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// subscribed reports whether w receives events of eventType.
+func (w *webhook) subscribed(eventType string) bool {
+	return len(w.events) == 0 || slices.Contains(w.events, eventType)
+}
+
+// render returns the request body for e.
+func (w *webhook) render(e Event) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(e)
+	}
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, e); err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template did not produce valid JSON: %s", buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends one request, reporting whether a failure is worth retrying:
+// network errors, 429 and 5xx are; other responses are not.
+func (w *webhook) post(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+			fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
+
+// jsonValue is the template function encoding v as JSON.
+func jsonValue(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
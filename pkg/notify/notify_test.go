@@ -0,0 +1,213 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a webhook endpoint recording the requests it accepts.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	bodies   []string
+	headers  []http.Header
+	attempts int
+	failures int // Requests to fail with status before accepting
+	status   int
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{status: http.StatusServiceUnavailable}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.attempts++
+		if r.failures > 0 {
+			r.failures--
+			w.WriteHeader(r.status)
+			return
+		}
+		r.bodies = append(r.bodies, string(body))
+		r.headers = append(r.headers, req.Header)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() ([]string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...), r.attempts
+}
+
+func TestNotifier_Deliver(t *testing.T) {
+	all := newReceiver(t)
+	breakers := newReceiver(t)
+	n, err := New(Config{Webhooks: []WebhookConfig{
+		{URL: all.URL},
+		{URL: breakers.URL, Events: []string{EventBreakerOpened}},
+	}}, "aul-test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(EventDeadlock, "deadlock detected", "victim_session_id", "s2")
+	n.Notify(EventBreakerOpened, "circuit breaker opened", "procedure", "dbo.Pay")
+	n.Close()
+
+	bodies, _ := all.received()
+	if len(bodies) != 2 {
+		t.Fatalf("got %d events, want 2: %q", len(bodies), bodies)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(bodies[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventDeadlock || e.Server != "aul-test" || e.Message != "deadlock detected" ||
+		e.Fields["victim_session_id"] != "s2" || e.Time.IsZero() {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	if bodies, _ := breakers.received(); len(bodies) != 1 || !strings.Contains(bodies[0], `"breaker.opened"`) {
+		t.Errorf("breaker webhook got %q, want only the breaker event", bodies)
+	}
+}
+
+func TestNotifier_Template(t *testing.T) {
+	r := newReceiver(t)
+	n, err := New(Config{Webhooks: []WebhookConfig{{
+		URL:      r.URL,
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+		Template: `{"text": {{json (printf "%s: %s" .Server .Message)}}, "procedure": {{json .Fields.procedure}}}`,
+	}}}, "aul", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(EventBreakerOpened, `breaker "opened"`, "procedure", "dbo.Pay")
+	n.Close()
+
+	bodies, _ := r.received()
+	want := `{"text": "aul: breaker \"opened\"", "procedure": "dbo.Pay"}`
+	if len(bodies) != 1 || bodies[0] != want {
+		t.Fatalf("got %q, want %q", bodies, want)
+	}
+	if got := r.headers[0].Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := r.headers[0].Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
+
+func TestNotifier_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		failures     int
+		maxRetries   int
+		wantDelivery bool
+		wantAttempts int
+	}{
+		{"recovers", http.StatusServiceUnavailable, 2, 0, true, 3},
+		{"rate limited", http.StatusTooManyRequests, 1, 0, true, 2},
+		{"gives up", http.StatusBadGateway, 10, 2, false, 3},
+		{"client error", http.StatusBadRequest, 1, 0, false, 1},
+		{"no retries", http.StatusServiceUnavailable, 1, -1, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t)
+			r.status, r.failures = tt.status, tt.failures
+			n, err := New(Config{Webhooks: []WebhookConfig{{
+				URL:        r.URL,
+				MaxRetries: tt.maxRetries,
+				Backoff:    Duration(time.Millisecond),
+			}}}, "aul", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n.Notify(EventDeadlock, "deadlock detected")
+			n.Close()
+
+			bodies, attempts := r.received()
+			if (len(bodies) == 1) != tt.wantDelivery || attempts != tt.wantAttempts {
+				t.Errorf("delivered %d after %d attempts, want delivered=%v after %d",
+					len(bodies), attempts, tt.wantDelivery, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestNotifier_Invalid(t *testing.T) {
+	for name, cfg := range map[string]WebhookConfig{
+		"no url":        {},
+		"bad scheme":    {URL: "ftp://example.com/hook"},
+		"unknown event": {URL: "http://example.com", Events: []string{"replication.lag"}},
+		"bad template":  {URL: "http://example.com", Template: "{{.Message"},
+	} {
+		if _, err := New(Config{Webhooks: []WebhookConfig{cfg}}, "aul", nil); err == nil {
+			t.Errorf("%s: New succeeded", name)
+		}
+	}
+
+	// A template that renders invalid JSON drops the event
+	r := newReceiver(t)
+	n, err := New(Config{Webhooks: []WebhookConfig{{URL: r.URL, Template: `{"text": {{.Message}}}`}}}, "aul", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(EventDeadlock, "not quoted")
+	n.Close()
+	if _, attempts := r.received(); attempts != 0 {
+		t.Errorf("invalid JSON was posted")
+	}
+}
+
+func TestNotifier_Nil(t *testing.T) {
+	var n *Notifier
+	n.Notify(EventDeadlock, "ignored")
+	n.Close()
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.json")
+	os.WriteFile(path, []byte(`{
+		"webhooks": [{
+			"name": "ops",
+			"url": "https://hooks.example.com/aul",
+			"events": ["breaker.opened", "procedure.reload_failed"],
+			"max_retries": 3,
+			"backoff": "2s",
+			"timeout": "10s"
+		}]
+	}`), 0600)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := cfg.Webhooks[0]
+	if w.Name != "ops" || len(w.Events) != 2 || w.MaxRetries != 3 ||
+		time.Duration(w.Backoff) != 2*time.Second || time.Duration(w.Timeout) != 10*time.Second {
+		t.Errorf("unexpected config: %+v", w)
+	}
+
+	for name, content := range map[string]string{
+		"unknown field": `{"webhooks": [{"url": "http://x", "retries": 3}]}`,
+		"bad duration":  `{"webhooks": [{"url": "http://x", "backoff": 5}]}`,
+	} {
+		os.WriteFile(path, []byte(content), 0600)
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("%s: LoadConfig succeeded", name)
+		}
+	}
+}
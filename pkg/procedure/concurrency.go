@@ -0,0 +1,57 @@
+package procedure
+
+import (
+	"strconv"
+	"strings"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// Annotations limiting how many calls of a procedure run at once.
+// -- @aul:max-concurrent=1 makes a procedure a singleton, so that
+// maintenance code that is not reentrant never runs beside itself. Calls
+// past the limit fail, or wait for a running call to finish with
+// -- @aul:queue.
+const (
+	MaxConcurrentAnnotation = "max-concurrent"
+	QueueAnnotation         = "queue"
+)
+
+// ParseConcurrency reads the concurrency limit a procedure declares in its
+// annotations. limit is 0 when there is none.
+func ParseConcurrency(annotations map[string]string) (limit int, queue bool, err error) {
+	if value, ok := annotations[MaxConcurrentAnnotation]; ok {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return 0, false, concurrencyError("%s=%q: expected a number of calls from 1", MaxConcurrentAnnotation, value)
+		}
+	}
+	if value, ok := annotations[QueueAnnotation]; ok {
+		switch strings.ToLower(value) {
+		case "", "true", "1", "yes", "on":
+			queue = true
+		case "false", "0", "no", "off":
+		default:
+			return 0, false, concurrencyError("%s=%q: expected true or false", QueueAnnotation, value)
+		}
+		if queue && limit == 0 {
+			return 0, false, concurrencyError("%s without %s", QueueAnnotation, MaxConcurrentAnnotation)
+		}
+	}
+	return limit, queue, nil
+}
+
+// loadConcurrency sets proc's concurrency limit from its annotations.
+func loadConcurrency(proc *Procedure) error {
+	limit, queue, err := ParseConcurrency(proc.Annotations)
+	if err != nil {
+		return err
+	}
+	proc.MaxConcurrent = limit
+	proc.QueueWhenBusy = queue
+	return nil
+}
+
+func concurrencyError(format string, args ...interface{}) error {
+	return aulerrors.Newf(aulerrors.ErrCodeProcValidationError, "concurrency limit: "+format, args...).Err()
+}
@@ -0,0 +1,51 @@
+package procedure
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+func TestParseConcurrency(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		limit       int
+		queue       bool
+		ok          bool
+	}{
+		{nil, 0, false, true},
+		{map[string]string{"max-concurrent": "1"}, 1, false, true},
+		{map[string]string{"max-concurrent": "4", "queue": ""}, 4, true, true},
+		{map[string]string{"max-concurrent": "1", "queue": "false"}, 1, false, true},
+		{map[string]string{"max-concurrent": "0"}, 0, false, false},
+		{map[string]string{"max-concurrent": "one"}, 0, false, false},
+		{map[string]string{"max-concurrent": "1", "queue": "later"}, 0, false, false},
+		{map[string]string{"queue": "true"}, 0, false, false},
+	}
+	for _, tt := range tests {
+		limit, queue, err := ParseConcurrency(tt.annotations)
+		if (err == nil) != tt.ok || limit != tt.limit || queue != tt.queue {
+			t.Errorf("ParseConcurrency(%v) = %d, %v, %v", tt.annotations, limit, queue, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "Nightly.sql")
+	src := "-- @aul:max-concurrent=1\n-- @aul:queue\nCREATE PROCEDURE dbo.Nightly AS SELECT 1"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loader := NewLoader("tsql", log.New(log.Config{DefaultLevel: log.LevelError}))
+	proc, err := loader.LoadFile(path)
+	if err != nil || proc.MaxConcurrent != 1 || !proc.QueueWhenBusy {
+		t.Fatalf("LoadFile = %+v, %v", proc, err)
+	}
+	if err := os.WriteFile(path, []byte("-- @aul:queue\n"+src[strings.Index(src, "CREATE"):]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loader.LoadFile(path); err == nil {
+		t.Error("LoadFile with queue but no limit succeeded")
+	}
+}
@@ -0,0 +1,259 @@
+package procedure
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// ContractFileSuffix names the file beside a procedure's .sql file that
+// may declare its result sets instead of annotations:
+// usp_GetOrders.sql has its contract in usp_GetOrders.contract.json.
+const ContractFileSuffix = ".contract.json"
+
+// contractFile is the layout of a contract file.
+type contractFile struct {
+	ResultSets []struct {
+		Optional bool `json:"optional"`
+		Columns  []struct {
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			Nullable bool   `json:"nullable"`
+		} `json:"columns"`
+	} `json:"result_sets"`
+}
+
+// ParseContract reads the result sets a procedure declares in its
+// annotations: result= for the first and result.N= for the Nth, each a
+// comma-separated list of "Name [type] [NULL | NOT NULL]" columns.
+// Columns are nullable unless declared NOT NULL, as in CREATE TABLE, and
+// a column without a type may hold any. It returns nil when there are no
+// result annotations.
+func ParseContract(annotations map[string]string) ([]ResultSetDef, error) {
+	specs := make(map[int]string)
+	for key, value := range annotations {
+		n := 1
+		switch {
+		case key == "result":
+		case strings.HasPrefix(key, "result."):
+			i, err := strconv.Atoi(key[len("result."):])
+			if err != nil || i < 1 {
+				return nil, contractError("annotation %s: expected result.N with N from 1", key)
+			}
+			n = i
+		default:
+			continue
+		}
+		if _, dup := specs[n]; dup {
+			return nil, contractError("result set %d declared twice", n)
+		}
+		specs[n] = value
+	}
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	ns := make([]int, 0, len(specs))
+	for n := range specs {
+		ns = append(ns, n)
+	}
+	sort.Ints(ns)
+	sets := make([]ResultSetDef, len(ns))
+	for i, n := range ns {
+		if n != i+1 {
+			return nil, contractError("result set %d declared without result set %d", n, i+1)
+		}
+		cols, err := parseContractColumns(specs[n])
+		if err != nil {
+			return nil, contractError("result set %d: %v", n, err)
+		}
+		sets[i] = ResultSetDef{Columns: cols, Index: i}
+	}
+	return sets, nil
+}
+
+// parseContractColumns parses "OrderID int NOT NULL, Total decimal(10,2)".
+func parseContractColumns(spec string) ([]ColumnDef, error) {
+	var cols []ColumnDef
+	for _, item := range splitTopLevel(spec) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("empty column in %q", spec)
+		}
+
+		var name, rest string
+		if strings.HasPrefix(item, "[") {
+			end := strings.IndexByte(item, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in %q", item)
+			}
+			name, rest = item[1:end], item[end+1:]
+		} else if i := strings.IndexAny(item, " \t"); i >= 0 {
+			name, rest = item[:i], item[i:]
+		} else {
+			name = item
+		}
+
+		col := ColumnDef{Name: name, Nullable: true, Ordinal: len(cols)}
+		rest = strings.TrimSpace(rest)
+		upper := strings.ToUpper(rest)
+		switch {
+		case strings.HasSuffix(upper, "NOT NULL"):
+			col.Nullable = false
+			rest = rest[:len(rest)-len("NOT NULL")]
+		case strings.HasSuffix(upper, "NULL"):
+			rest = rest[:len(rest)-len("NULL")]
+		}
+		col.SQLType = strings.TrimSpace(rest)
+		if strings.ContainsAny(col.SQLType, " \t") && !strings.Contains(col.SQLType, "(") {
+			return nil, fmt.Errorf("column %s: cannot read type %q", name, col.SQLType)
+		}
+		if col.SQLType != "" {
+			col.GoType = mapSQLTypeToGo(col.SQLType)
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// splitTopLevel splits on commas outside parentheses, so that
+// decimal(10,2) stays whole.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// ReadContractFile reads a contract file (see ContractFileSuffix).
+func ReadContractFile(path string) ([]ResultSetDef, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file contractFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, contractError("%s: %v", path, err)
+	}
+	sets := make([]ResultSetDef, len(file.ResultSets))
+	for i, rs := range file.ResultSets {
+		sets[i] = ResultSetDef{Index: i, Conditional: rs.Optional}
+		for j, c := range rs.Columns {
+			if c.Name == "" {
+				return nil, contractError("%s: result set %d column %d has no name", path, i+1, j+1)
+			}
+			col := ColumnDef{Name: c.Name, SQLType: c.Type, Nullable: c.Nullable, Ordinal: j}
+			if c.Type != "" {
+				col.GoType = mapSQLTypeToGo(c.Type)
+			}
+			sets[i].Columns = append(sets[i].Columns, col)
+		}
+	}
+	return sets, nil
+}
+
+// loadContract sets proc.Contract from its contract file, if there is
+// one, or its result annotations. Declaring both is an error.
+func loadContract(proc *Procedure, path string) error {
+	sets, err := ParseContract(proc.Annotations)
+	if err != nil {
+		return err
+	}
+	sidecar := strings.TrimSuffix(path, ".sql") + ContractFileSuffix
+	if _, statErr := os.Stat(sidecar); statErr == nil {
+		if sets != nil {
+			return contractError("result sets declared both in annotations and in %s", sidecar)
+		}
+		if sets, err = ReadContractFile(sidecar); err != nil {
+			return err
+		}
+	}
+	proc.Contract = sets
+	return nil
+}
+
+// CheckContract compares result sets against the contract they should
+// meet and describes each difference. Names are compared without regard
+// to case; types only where both sides have one, and nullability only
+// where the result set allows NULL and the contract does not. Optional
+// result sets in the contract may be missing, and columns past the known
+// ones of an Open result set are not checked.
+func CheckContract(contract, sets []ResultSetDef) []string {
+	var problems []string
+	required := 0
+	for i, rs := range contract {
+		if !rs.Conditional {
+			required = i + 1
+		}
+	}
+	if len(sets) > len(contract) {
+		problems = append(problems, fmt.Sprintf("%d result sets returned, the contract declares %d", len(sets), len(contract)))
+	} else if len(sets) < required {
+		problems = append(problems, fmt.Sprintf("%d result sets returned, the contract requires %d", len(sets), required))
+	}
+
+	for i, rs := range sets {
+		if i >= len(contract) {
+			break
+		}
+		want := contract[i].Columns
+		if len(rs.Columns) != len(want) && !(rs.Open && len(rs.Columns) < len(want)) {
+			problems = append(problems, fmt.Sprintf("result set %d: %d columns, the contract declares %d", i+1, len(rs.Columns), len(want)))
+		}
+		for j, col := range rs.Columns {
+			if j >= len(want) {
+				break
+			}
+			w := want[j]
+			if col.Name != "" && !strings.EqualFold(col.Name, w.Name) {
+				problems = append(problems, fmt.Sprintf("result set %d column %d: named %s, the contract says %s", i+1, j+1, col.Name, w.Name))
+			}
+			if col.SQLType != "" && w.SQLType != "" && contractType(col.SQLType) != contractType(w.SQLType) {
+				problems = append(problems, fmt.Sprintf("result set %d column %s: type %s, the contract says %s", i+1, w.Name, col.SQLType, w.SQLType))
+			}
+			if col.Nullable && !w.Nullable {
+				problems = append(problems, fmt.Sprintf("result set %d column %s: may be NULL, the contract says NOT NULL", i+1, w.Name))
+			}
+		}
+	}
+	return problems
+}
+
+// contractType returns the lower-case name of a type without its length,
+// with synonyms folded together.
+func contractType(sqlType string) string {
+	if i := strings.IndexByte(sqlType, '('); i >= 0 {
+		sqlType = sqlType[:i]
+	}
+	t := strings.ToLower(strings.Trim(strings.TrimSpace(sqlType), "[]"))
+	switch t {
+	case "integer":
+		return "int"
+	case "numeric":
+		return "decimal"
+	case "double precision":
+		return "float"
+	}
+	return t
+}
+
+func contractError(format string, args ...interface{}) error {
+	return aulerrors.Newf(aulerrors.ErrCodeProcValidationError, "result contract: "+format, args...).Err()
+}
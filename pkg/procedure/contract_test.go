@@ -0,0 +1,131 @@
+package procedure
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+func TestParseContract(t *testing.T) {
+	sets, err := ParseContract(map[string]string{
+		"timeout":  "30s",
+		"result":   "OrderID int NOT NULL, Total decimal(10,2) null, [Placed At] datetime, Extra",
+		"result.2": "Sku varchar(20) NOT NULL",
+	})
+	if err != nil {
+		t.Fatalf("ParseContract: %v", err)
+	}
+	if len(sets) != 2 {
+		t.Fatalf("got %d result sets, want 2", len(sets))
+	}
+	want := []ColumnDef{
+		{Name: "OrderID", SQLType: "int", Nullable: false},
+		{Name: "Total", SQLType: "decimal(10,2)", Nullable: true},
+		{Name: "Placed At", SQLType: "datetime", Nullable: true},
+		{Name: "Extra", SQLType: "", Nullable: true},
+	}
+	for i, w := range want {
+		got := sets[0].Columns[i]
+		if got.Name != w.Name || got.SQLType != w.SQLType || got.Nullable != w.Nullable || got.Ordinal != i {
+			t.Errorf("column %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if c := sets[1].Columns; len(c) != 1 || c[0].Name != "Sku" || c[0].Nullable {
+		t.Errorf("second result set = %+v", c)
+	}
+
+	if sets, err := ParseContract(map[string]string{"timeout": "30s"}); err != nil || sets != nil {
+		t.Errorf("no result annotations: got %v, %v", sets, err)
+	}
+	for _, bad := range []map[string]string{
+		{"result.2": "A int"},
+		{"result.x": "A int"},
+		{"result": "A int,"},
+		{"result": "A big int"},
+	} {
+		if _, err := ParseContract(bad); err == nil {
+			t.Errorf("ParseContract(%v) succeeded", bad)
+		}
+	}
+}
+
+func TestLoadContractFile(t *testing.T) {
+	dir := t.TempDir()
+	src := "CREATE PROCEDURE dbo.GetA\nAS\nSELECT 1 AS A\n"
+	path := filepath.Join(dir, "GetA.sql")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	contract := `{"result_sets": [
+		{"columns": [{"name": "A", "type": "int"}]},
+		{"optional": true, "columns": [{"name": "B", "nullable": true}]}
+	]}`
+	if err := os.WriteFile(filepath.Join(dir, "GetA"+ContractFileSuffix), []byte(contract), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := NewLoader("tsql", log.New(log.Config{DefaultLevel: log.LevelError}))
+	proc, err := loader.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if len(proc.Contract) != 2 || !proc.Contract[1].Conditional || proc.Contract[0].Columns[0].SQLType != "int" {
+		t.Fatalf("contract = %+v", proc.Contract)
+	}
+
+	// Annotations and a contract file may not both declare result sets
+	src = "-- @aul:result=A int\n" + src
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loader.LoadFile(path); err == nil || !strings.Contains(err.Error(), "both") {
+		t.Errorf("LoadFile with two contracts: err = %v", err)
+	}
+}
+
+func TestCheckContract(t *testing.T) {
+	contract := []ResultSetDef{
+		{Columns: []ColumnDef{{Name: "ID", SQLType: "int"}, {Name: "Name", SQLType: "varchar(20)"}}},
+		{Columns: []ColumnDef{{Name: "Total", SQLType: "decimal"}}, Conditional: true},
+	}
+	src := `CREATE PROCEDURE dbo.P
+AS
+BEGIN
+    SELECT CAST(1 AS INTEGER) AS id, 'x' AS Name
+END`
+	sets, err := InferResultSets(&Procedure{Source: src}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := CheckContract(contract, sets); len(problems) != 0 {
+		t.Errorf("matching result sets: %v", problems)
+	}
+
+	tests := []struct {
+		name string
+		sets []ResultSetDef
+		want string
+	}{
+		{"missing", nil, "contract requires 1"},
+		{"extra", []ResultSetDef{{}, {}, {}}, "contract declares 2"},
+		{"renamed", []ResultSetDef{{Columns: []ColumnDef{{Name: "ID"}, {Name: "FullName"}}}}, "named FullName"},
+		{"dropped", []ResultSetDef{{Columns: []ColumnDef{{Name: "ID"}}}}, "1 columns, the contract declares 2"},
+		{"retyped", []ResultSetDef{{Columns: []ColumnDef{{Name: "ID", SQLType: "bigint"}, {Name: "Name"}}}}, "type bigint"},
+		{"nullable", []ResultSetDef{{Columns: []ColumnDef{{Name: "ID"}, {Name: "Name", Nullable: true}}}}, "may be NULL"},
+	}
+	for _, tt := range tests {
+		problems := CheckContract(contract, tt.sets)
+		if !strings.Contains(strings.Join(problems, "; "), tt.want) {
+			t.Errorf("%s: problems %v, want one containing %q", tt.name, problems, tt.want)
+		}
+	}
+
+	// Columns beyond the known ones of an open result set are not checked
+	open := []ResultSetDef{{Columns: []ColumnDef{{Name: "ID"}}, Open: true}}
+	if problems := CheckContract(contract, open); len(problems) != 0 {
+		t.Errorf("open result set: %v", problems)
+	}
+}
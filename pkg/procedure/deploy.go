@@ -0,0 +1,90 @@
+package procedure
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// ProcedureSet is a complete set of procedures loaded into a registry of
+// its own, so that it can replace the procedures of the active registry
+// in one step (see Registry.Swap).
+type ProcedureSet struct {
+	Dir      string
+	LoadedAt time.Time
+	Registry *Registry
+	Problems []string // Why the set should not go live; empty when it may
+}
+
+// LoadSet loads every procedure in dir into a new registry and validates
+// the set as a whole: every file must load, no two may define the same
+// procedure, each procedure must meet its result contract and the set
+// must not be empty. Problems are reported in the set; the error is for
+// a directory that cannot be read.
+func (l *Loader) LoadSet(dir string) (*ProcedureSet, error) {
+	paths, err := findSQLFiles(dir)
+	if err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeProcLoadError,
+			"failed to walk directory").
+			WithOp("Loader.LoadSet").
+			WithField("directory", dir).
+			Err()
+	}
+
+	set := &ProcedureSet{Dir: dir, LoadedAt: time.Now(), Registry: NewRegistry()}
+	loaded, errs := loadAll(len(paths), l.Workers, func(i int) (*Procedure, error) {
+		return l.LoadFile(paths[i])
+	})
+
+	defined := make(map[string]string)
+	var procs []*Procedure
+	for i, path := range paths {
+		if errs[i] != nil {
+			set.Problems = append(set.Problems, fmt.Sprintf("%s: %v", path, errs[i]))
+			continue
+		}
+		proc := loaded[i]
+		key := strings.ToLower(proc.Tenant + "/" + proc.QualifiedName())
+		if first, dup := defined[key]; dup {
+			set.Problems = append(set.Problems, fmt.Sprintf("%s: %s is also defined in %s", path, proc.QualifiedName(), first))
+			continue
+		}
+		defined[key] = path
+		procs = append(procs, proc)
+
+		if len(proc.Contract) == 0 || proc.IsFunction {
+			continue
+		}
+		sets, err := InferResultSets(proc, nil)
+		if err != nil {
+			set.Problems = append(set.Problems, fmt.Sprintf("%s: %v", proc.QualifiedName(), err))
+			continue
+		}
+		for _, p := range CheckContract(proc.Contract, sets) {
+			set.Problems = append(set.Problems, fmt.Sprintf("%s: %s", proc.QualifiedName(), p))
+		}
+	}
+	if len(paths) == 0 {
+		set.Problems = append(set.Problems, fmt.Sprintf("no procedures in %s", dir))
+	}
+
+	if err := set.Registry.RegisterAll(procs); err != nil {
+		set.Problems = append(set.Problems, err.Error())
+	}
+	return set, nil
+}
+
+// Swap replaces every procedure in r with those of staged in one step and
+// returns a registry holding the procedures it replaced, so that swapping
+// that back in rolls the change back. Executions already running finish
+// with the procedures they resolved. staged must not be used afterwards.
+func (r *Registry) Swap(staged *Registry) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := &Registry{}
+	previous.state.Store(r.state.Swap(staged.state.Load()))
+	return previous
+}
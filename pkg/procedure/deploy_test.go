@@ -0,0 +1,75 @@
+package procedure
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+func TestLoader_LoadSet(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.sql":    "CREATE PROCEDURE dbo.GetA AS BEGIN SELECT 1 AS A END",
+		"dup.sql":  "CREATE PROCEDURE dbo.GetA AS BEGIN SELECT 2 AS A END",
+		"junk.sql": "SELECT 'no procedure here'",
+		"b.sql":    "-- @aul:result=B int NOT NULL\nCREATE PROCEDURE dbo.GetB AS BEGIN SELECT 1 AS C END",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loader := NewLoader("tsql", log.New(log.Config{DefaultLevel: log.LevelError}))
+	set, err := loader.LoadSet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Registry.Count() != 2 {
+		t.Errorf("registered %d procedures, want 2", set.Registry.Count())
+	}
+	want := []string{"is also defined in", "junk.sql", "named C, the contract says B"}
+	if len(set.Problems) != len(want) {
+		t.Fatalf("problems = %q", set.Problems)
+	}
+	for _, w := range want {
+		found := false
+		for _, p := range set.Problems {
+			found = found || strings.Contains(p, w)
+		}
+		if !found {
+			t.Errorf("no problem mentions %q in %q", w, set.Problems)
+		}
+	}
+
+	empty, err := loader.LoadSet(t.TempDir())
+	if err != nil || len(empty.Problems) != 1 {
+		t.Errorf("empty directory: %v, %q", err, empty.Problems)
+	}
+	if _, err := loader.LoadSet(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing directory loaded")
+	}
+}
+
+func TestRegistry_Swap(t *testing.T) {
+	active := NewRegistry()
+	active.Register(&Procedure{Name: "Old", Schema: "dbo", SourceHash: "1"})
+	staged := NewRegistry()
+	staged.Register(&Procedure{Name: "New", Schema: "dbo", SourceHash: "2"})
+
+	previous := active.Swap(staged)
+	if _, err := active.Lookup("dbo.New"); err != nil {
+		t.Errorf("after swap: %v", err)
+	}
+	if _, err := active.Lookup("dbo.Old"); err == nil {
+		t.Error("replaced procedure still registered")
+	}
+
+	active.Swap(previous)
+	if _, err := active.Lookup("dbo.Old"); err != nil {
+		t.Errorf("after swapping back: %v", err)
+	}
+}
@@ -30,13 +30,19 @@ import (
 //
 // Procedures in _global are available to all databases.
 // The directory structure determines database.schema.name qualification.
+//
+// The directories are read first and the files then loaded concurrently.
+// Loading only reads a file and extracts its name, parameters and
+// annotations; the full T-SQL parse happens when the procedure executes.
 type HierarchicalLoader struct {
 	dialect Dialect
 	parser  Parser
 	logger  *log.Logger
 
 	// Options
-	validateSchema bool // Verify declared schema matches directory
+	validateSchema bool                        // Verify declared schema matches directory
+	workers        int                         // Files loaded at once (0 = GOMAXPROCS)
+	check          func(proc *Procedure) error // Vets each procedure loaded
 }
 
 // HierarchicalLoaderOption configures the loader.
@@ -49,6 +55,22 @@ func WithSchemaValidation(enable bool) HierarchicalLoaderOption {
 	}
 }
 
+// WithWorkers sets how many files are loaded at once; 0 or less means
+// GOMAXPROCS.
+func WithWorkers(n int) HierarchicalLoaderOption {
+	return func(l *HierarchicalLoader) {
+		l.workers = n
+	}
+}
+
+// WithCheck sets a function that vets each procedure loaded. A procedure
+// it returns an error for fails to load with that error.
+func WithCheck(fn func(proc *Procedure) error) HierarchicalLoaderOption {
+	return func(l *HierarchicalLoader) {
+		l.check = fn
+	}
+}
+
 // NewHierarchicalLoader creates a new hierarchical procedure loader.
 func NewHierarchicalLoader(dialect string, logger *log.Logger, opts ...HierarchicalLoaderOption) *HierarchicalLoader {
 	l := &HierarchicalLoader{
@@ -84,6 +106,7 @@ type LoadError struct {
 
 // LoadDirectory loads all procedures from a hierarchical directory structure.
 func (l *HierarchicalLoader) LoadDirectory(root string) (*LoadResult, error) {
+	start := time.Now()
 	result := &LoadResult{
 		Procedures:  make([]*Procedure, 0),
 		GlobalProcs: make([]*Procedure, 0),
@@ -118,6 +141,8 @@ func (l *HierarchicalLoader) LoadDirectory(root string) (*LoadResult, error) {
 			Err()
 	}
 
+	// Find every file first, then load them together
+	var files []procFile
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
@@ -127,36 +152,51 @@ func (l *HierarchicalLoader) LoadDirectory(root string) (*LoadResult, error) {
 		dbPath := filepath.Join(root, dbName)
 
 		if dbName == "_global" {
-			// Load global procedures
-			procs, errs := l.loadDatabase(dbPath, "", true, "")
-			result.GlobalProcs = append(result.GlobalProcs, procs...)
-			result.Procedures = append(result.Procedures, procs...)
+			// Global procedures
+			dbFiles, errs := l.findDatabase(dbPath, "", true, "")
+			files = append(files, dbFiles...)
 			result.Errors = append(result.Errors, errs...)
-			result.SuccessCount += len(procs)
-			result.FailCount += len(errs)
 		} else if dbName == "_tenant" {
-			// Load tenant-specific procedures
-			tenantProcs, tenantErrs := l.loadTenantDirectory(dbPath)
-			for tenant, procs := range tenantProcs {
-				result.ByTenant[tenant] = append(result.ByTenant[tenant], procs...)
-				result.Procedures = append(result.Procedures, procs...)
-			}
-			result.Errors = append(result.Errors, tenantErrs...)
-			for _, procs := range tenantProcs {
-				result.SuccessCount += len(procs)
-			}
-			result.FailCount += len(tenantErrs)
+			// Tenant-specific procedures
+			tenantFiles, errs := l.findTenantDirectory(dbPath)
+			files = append(files, tenantFiles...)
+			result.Errors = append(result.Errors, errs...)
 		} else if !strings.HasPrefix(dbName, "_") && !strings.HasPrefix(dbName, ".") {
-			// Load database procedures (skip hidden/special dirs)
-			procs, errs := l.loadDatabase(dbPath, dbName, false, "")
-			result.ByDatabase[dbName] = procs
-			result.Procedures = append(result.Procedures, procs...)
+			// Database procedures (skip hidden/special dirs)
+			dbFiles, errs := l.findDatabase(dbPath, dbName, false, "")
+			result.ByDatabase[dbName] = nil
+			files = append(files, dbFiles...)
 			result.Errors = append(result.Errors, errs...)
-			result.SuccessCount += len(procs)
-			result.FailCount += len(errs)
 		}
 	}
 
+	procs, errs := loadAll(len(files), l.workers, func(i int) (*Procedure, error) {
+		f := files[i]
+		return l.loadFile(f.path, f.dbName, f.schemaName, f.isGlobal, f.tenant)
+	})
+	for i, f := range files {
+		if errs[i] != nil {
+			result.Errors = append(result.Errors, LoadError{
+				Path:    f.path,
+				Error:   errs[i],
+				Message: "failed to load procedure",
+			})
+			continue
+		}
+		proc := procs[i]
+		switch {
+		case f.isGlobal:
+			result.GlobalProcs = append(result.GlobalProcs, proc)
+		case f.tenant != "":
+			result.ByTenant[f.tenant] = append(result.ByTenant[f.tenant], proc)
+		default:
+			result.ByDatabase[f.dbName] = append(result.ByDatabase[f.dbName], proc)
+		}
+		result.Procedures = append(result.Procedures, proc)
+		result.SuccessCount++
+	}
+	result.FailCount = len(result.Errors)
+
 	result.TotalFiles = result.SuccessCount + result.FailCount
 
 	l.logger.Application().Info("hierarchical load complete",
@@ -166,14 +206,26 @@ func (l *HierarchicalLoader) LoadDirectory(root string) (*LoadResult, error) {
 		"global_procs", len(result.GlobalProcs),
 		"total_procs", len(result.Procedures),
 		"errors", len(result.Errors),
+		"duration_ms", time.Since(start).Milliseconds(),
 	)
 
 	return result, nil
 }
 
-// loadTenantDirectory loads procedures from all tenant subdirectories.
-func (l *HierarchicalLoader) loadTenantDirectory(tenantRoot string) (map[string][]*Procedure, []LoadError) {
-	result := make(map[string][]*Procedure)
+// procFile is a procedure file found under the root, with the database,
+// schema and tenant its location gives it.
+type procFile struct {
+	path       string
+	dbName     string
+	schemaName string
+	isGlobal   bool
+	tenant     string
+}
+
+// findTenantDirectory finds the procedure files of all tenant
+// subdirectories.
+func (l *HierarchicalLoader) findTenantDirectory(tenantRoot string) ([]procFile, []LoadError) {
+	var files []procFile
 	var allErrors []LoadError
 
 	entries, err := os.ReadDir(tenantRoot)
@@ -183,7 +235,7 @@ func (l *HierarchicalLoader) loadTenantDirectory(tenantRoot string) (map[string]
 			Error:   err,
 			Message: "failed to read tenant directory",
 		})
-		return result, allErrors
+		return files, allErrors
 	}
 
 	for _, entry := range entries {
@@ -198,7 +250,7 @@ func (l *HierarchicalLoader) loadTenantDirectory(tenantRoot string) (map[string]
 
 		tenantPath := filepath.Join(tenantRoot, tenantName)
 
-		// Load tenant's procedures (structure mirrors main: database/schema/proc.sql)
+		// Tenant's procedures (structure mirrors main: database/schema/proc.sql)
 		tenantEntries, err := os.ReadDir(tenantPath)
 		if err != nil {
 			allErrors = append(allErrors, LoadError{
@@ -220,18 +272,18 @@ func (l *HierarchicalLoader) loadTenantDirectory(tenantRoot string) (map[string]
 			}
 
 			dbPath := filepath.Join(tenantPath, dbName)
-			procs, errs := l.loadDatabase(dbPath, dbName, false, tenantName)
-			result[tenantName] = append(result[tenantName], procs...)
+			dbFiles, errs := l.findDatabase(dbPath, dbName, false, tenantName)
+			files = append(files, dbFiles...)
 			allErrors = append(allErrors, errs...)
 		}
 	}
 
-	return result, allErrors
+	return files, allErrors
 }
 
-// loadDatabase loads all procedures from a database directory.
-func (l *HierarchicalLoader) loadDatabase(dbPath, dbName string, isGlobal bool, tenant string) ([]*Procedure, []LoadError) {
-	var procs []*Procedure
+// findDatabase finds the procedure files of a database directory.
+func (l *HierarchicalLoader) findDatabase(dbPath, dbName string, isGlobal bool, tenant string) ([]procFile, []LoadError) {
+	var files []procFile
 	var errs []LoadError
 
 	// List schema directories
@@ -242,23 +294,20 @@ func (l *HierarchicalLoader) loadDatabase(dbPath, dbName string, isGlobal bool,
 			Error:   err,
 			Message: "failed to read database directory",
 		})
-		return procs, errs
+		return files, errs
 	}
 
 	for _, entry := range entries {
 		if !entry.IsDir() {
-			// Also check for .sql files directly in database dir (assume dbo schema)
+			// Also take .sql files directly in database dir (assume dbo schema)
 			if strings.HasSuffix(strings.ToLower(entry.Name()), ".sql") {
-				proc, err := l.loadFile(filepath.Join(dbPath, entry.Name()), dbName, "dbo", isGlobal, tenant)
-				if err != nil {
-					errs = append(errs, LoadError{
-						Path:    filepath.Join(dbPath, entry.Name()),
-						Error:   err,
-						Message: "failed to load procedure",
-					})
-				} else {
-					procs = append(procs, proc)
-				}
+				files = append(files, procFile{
+					path:       filepath.Join(dbPath, entry.Name()),
+					dbName:     dbName,
+					schemaName: "dbo",
+					isGlobal:   isGlobal,
+					tenant:     tenant,
+				})
 			}
 			continue
 		}
@@ -269,17 +318,17 @@ func (l *HierarchicalLoader) loadDatabase(dbPath, dbName string, isGlobal bool,
 		}
 
 		schemaPath := filepath.Join(dbPath, schemaName)
-		schemaProcs, schemaErrs := l.loadSchema(schemaPath, dbName, schemaName, isGlobal, tenant)
-		procs = append(procs, schemaProcs...)
+		schemaFiles, schemaErrs := l.findSchema(schemaPath, dbName, schemaName, isGlobal, tenant)
+		files = append(files, schemaFiles...)
 		errs = append(errs, schemaErrs...)
 	}
 
-	return procs, errs
+	return files, errs
 }
 
-// loadSchema loads all procedures from a schema directory.
-func (l *HierarchicalLoader) loadSchema(schemaPath, dbName, schemaName string, isGlobal bool, tenant string) ([]*Procedure, []LoadError) {
-	var procs []*Procedure
+// findSchema finds the procedure files of a schema directory.
+func (l *HierarchicalLoader) findSchema(schemaPath, dbName, schemaName string, isGlobal bool, tenant string) ([]procFile, []LoadError) {
+	var files []procFile
 	var errs []LoadError
 
 	entries, err := os.ReadDir(schemaPath)
@@ -289,7 +338,7 @@ func (l *HierarchicalLoader) loadSchema(schemaPath, dbName, schemaName string, i
 			Error:   err,
 			Message: "failed to read schema directory",
 		})
-		return procs, errs
+		return files, errs
 	}
 
 	for _, entry := range entries {
@@ -301,24 +350,21 @@ func (l *HierarchicalLoader) loadSchema(schemaPath, dbName, schemaName string, i
 			continue
 		}
 
-		filePath := filepath.Join(schemaPath, entry.Name())
-		proc, err := l.loadFile(filePath, dbName, schemaName, isGlobal, tenant)
-		if err != nil {
-			errs = append(errs, LoadError{
-				Path:    filePath,
-				Error:   err,
-				Message: "failed to load procedure",
-			})
-		} else {
-			procs = append(procs, proc)
-		}
+		files = append(files, procFile{
+			path:       filepath.Join(schemaPath, entry.Name()),
+			dbName:     dbName,
+			schemaName: schemaName,
+			isGlobal:   isGlobal,
+			tenant:     tenant,
+		})
 	}
 
-	return procs, errs
+	return files, errs
 }
 
 // loadFile loads a single procedure file.
 func (l *HierarchicalLoader) loadFile(path, dbName, schemaName string, isGlobal bool, tenant string) (*Procedure, error) {
+	start := time.Now()
 	source, err := os.ReadFile(path)
 	if err != nil {
 		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeProcLoadError,
@@ -337,6 +383,21 @@ func (l *HierarchicalLoader) loadFile(path, dbName, schemaName string, isGlobal
 			Err()
 	}
 
+	if err := loadContract(proc, path); err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeProcValidationError,
+			"invalid result contract").
+			WithOp("HierarchicalLoader.loadFile").
+			WithField("path", path).
+			Err()
+	}
+	if err := loadConcurrency(proc); err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeProcValidationError,
+			"invalid concurrency limit").
+			WithOp("HierarchicalLoader.loadFile").
+			WithField("path", path).
+			Err()
+	}
+
 	// Set database from directory structure
 	proc.Database = dbName
 	proc.IsGlobal = isGlobal
@@ -363,6 +424,11 @@ func (l *HierarchicalLoader) loadFile(path, dbName, schemaName string, isGlobal
 
 	proc.SourceFile = path
 	proc.LoadedAt = time.Now()
+	if l.check != nil {
+		if err := l.check(proc); err != nil {
+			return nil, err
+		}
+	}
 
 	// Get file modification time
 	if info, err := os.Stat(path); err == nil {
@@ -376,6 +442,7 @@ func (l *HierarchicalLoader) loadFile(path, dbName, schemaName string, isGlobal
 		"schema", schemaName,
 		"global", isGlobal,
 		"tenant", tenant,
+		"duration_ms", float64(time.Since(start).Microseconds())/1000,
 	)
 
 	return proc, nil
@@ -384,33 +451,7 @@ func (l *HierarchicalLoader) loadFile(path, dbName, schemaName string, isGlobal
 // LoadFlat loads procedures from a flat directory (backward compatible).
 // All procedures are assigned to the specified database and dbo schema.
 func (l *HierarchicalLoader) LoadFlat(dir, defaultDB string) ([]*Procedure, error) {
-	var procs []*Procedure
-	var loadErrors []error
-
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories and non-SQL files
-		if info.IsDir() || !strings.HasSuffix(strings.ToLower(path), ".sql") {
-			return nil
-		}
-
-		proc, err := l.loadFile(path, defaultDB, "dbo", false, "")
-		if err != nil {
-			l.logger.Application().Warn("failed to load procedure file",
-				"path", path,
-				"error", err.Error(),
-			)
-			loadErrors = append(loadErrors, err)
-			return nil
-		}
-
-		procs = append(procs, proc)
-		return nil
-	})
-
+	paths, err := findSQLFiles(dir)
 	if err != nil {
 		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeProcLoadError,
 			"failed to walk directory").
@@ -419,6 +460,11 @@ func (l *HierarchicalLoader) LoadFlat(dir, defaultDB string) ([]*Procedure, erro
 			Err()
 	}
 
+	loaded, errs := loadAll(len(paths), l.workers, func(i int) (*Procedure, error) {
+		return l.loadFile(paths[i], defaultDB, "dbo", false, "")
+	})
+	procs, loadErrors := collectLoaded(l.logger, paths, loaded, errs)
+
 	if len(loadErrors) > 0 {
 		l.logger.Application().Warn("some procedures failed to load",
 			"successful", len(procs),
@@ -428,3 +474,22 @@ func (l *HierarchicalLoader) LoadFlat(dir, defaultDB string) ([]*Procedure, erro
 
 	return procs, nil
 }
+
+// findSQLFiles returns the paths of the .sql files under dir.
+func findSQLFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories and non-SQL files
+		if info.IsDir() || !strings.HasSuffix(strings.ToLower(path), ".sql") {
+			return nil
+		}
+
+		paths = append(paths, path)
+		return nil
+	})
+	return paths, err
+}
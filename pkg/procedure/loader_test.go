@@ -1,8 +1,12 @@
 package procedure
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/ha1tch/aul/pkg/log"
@@ -287,6 +291,47 @@ func TestRegistry_LookupInDatabase(t *testing.T) {
 	}
 }
 
+func TestRegistry_Rename(t *testing.T) {
+	registry := NewRegistry()
+	for _, proc := range []*Procedure{
+		{Name: "GetCustomer", Schema: "dbo", Database: "salesdb"},
+		{Name: "GetOrder", Schema: "dbo", Database: "salesdb"},
+		{Name: "GlobalHelper", Schema: "dbo", IsGlobal: true},
+	} {
+		if err := registry.Register(proc); err != nil {
+			t.Fatalf("failed to register %s: %v", proc.QualifiedName(), err)
+		}
+	}
+
+	if err := registry.Rename("GetCustomer", "salesdb", "FetchCustomer"); err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	proc, err := registry.LookupInDatabase("dbo.FetchCustomer", "salesdb")
+	if err != nil {
+		t.Fatalf("renamed procedure not found: %v", err)
+	}
+	if proc.FullName != "dbo.FetchCustomer" {
+		t.Errorf("FullName = %s, want dbo.FetchCustomer", proc.FullName)
+	}
+	if _, err := registry.LookupInDatabase("GetCustomer", "salesdb"); err == nil {
+		t.Error("old name still resolves")
+	}
+
+	if err := registry.Rename("GlobalHelper", "", "SharedHelper"); err != nil {
+		t.Fatalf("rename of global failed: %v", err)
+	}
+	if _, err := registry.LookupInDatabase("SharedHelper", "salesdb"); err != nil {
+		t.Errorf("renamed global not found: %v", err)
+	}
+
+	if err := registry.Rename("GetOrder", "salesdb", "FetchCustomer"); err == nil {
+		t.Error("expected an error renaming onto an existing procedure")
+	}
+	if err := registry.Rename("Missing", "salesdb", "Other"); err == nil {
+		t.Error("expected an error renaming a missing procedure")
+	}
+}
+
 func TestHierarchicalLoader_TenantProcedures(t *testing.T) {
 	// Create temp directory
 	tmpDir, err := os.MkdirTemp("", "aul-test-*")
@@ -463,3 +508,227 @@ func TestRegistry_LookupForTenant(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadAll(t *testing.T) {
+	for _, workers := range []int{0, 1, 3, 100} {
+		var calls atomic.Int32
+		procs, errs := loadAll(50, workers, func(i int) (*Procedure, error) {
+			calls.Add(1)
+			if i%7 == 0 {
+				return nil, fmt.Errorf("file %d", i)
+			}
+			return &Procedure{Name: fmt.Sprint(i)}, nil
+		})
+		if calls.Load() != 50 {
+			t.Fatalf("workers=%d: %d calls, want 50", workers, calls.Load())
+		}
+		for i := range procs {
+			if i%7 == 0 {
+				if errs[i] == nil || errs[i].Error() != fmt.Sprintf("file %d", i) {
+					t.Errorf("workers=%d: errs[%d] = %v", workers, i, errs[i])
+				}
+			} else if procs[i] == nil || procs[i].Name != fmt.Sprint(i) {
+				t.Errorf("workers=%d: procs[%d] = %v", workers, i, procs[i])
+			}
+		}
+	}
+
+	if procs, errs := loadAll(0, 4, nil); len(procs) != 0 || len(errs) != 0 {
+		t.Errorf("no files: got %d procedures and %d errors", len(procs), len(errs))
+	}
+}
+
+func TestLoaderCheck(t *testing.T) {
+	tmpDir := t.TempDir()
+	dir := filepath.Join(tmpDir, "salesdb", "dbo")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Kept", "Refused"} {
+		source := fmt.Sprintf("CREATE PROCEDURE dbo.%s\nAS\nSELECT 1\n", name)
+		if err := os.WriteFile(filepath.Join(dir, name+".sql"), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(proc *Procedure) error {
+		if proc.SourceFile == "" {
+			t.Errorf("%s checked before SourceFile was set", proc.Name)
+		}
+		if proc.Name == "Refused" {
+			return fmt.Errorf("refused")
+		}
+		return nil
+	}
+
+	logger := log.New(log.Config{DefaultLevel: log.LevelError})
+	result, err := NewHierarchicalLoader("tsql", logger, WithCheck(check)).LoadDirectory(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessCount != 1 || len(result.Errors) != 1 || result.Procedures[0].Name != "Kept" {
+		t.Errorf("hierarchical: got %d loaded, errors %v", result.SuccessCount, result.Errors)
+	}
+
+	loader := NewLoader("tsql", logger)
+	loader.Check = check
+	procs, err := loader.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(procs) != 1 || procs[0].Name != "Kept" {
+		t.Errorf("flat: got %d procedures", len(procs))
+	}
+}
+
+func TestHierarchicalLoader_Concurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, schema := range []string{"dbo", "reporting"} {
+		dir := filepath.Join(tmpDir, "salesdb", schema)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < 40; n++ {
+			source := fmt.Sprintf("CREATE PROCEDURE %s.Proc%02d\nAS\nSELECT %d\n", schema, n, n)
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("Proc%02d.sql", n)), []byte(source), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// A broken file fails on its own
+	if err := os.WriteFile(filepath.Join(tmpDir, "salesdb", "dbo", "Broken.sql"), []byte("SELECT 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New(log.Config{DefaultLevel: log.LevelError})
+	serial, err := NewHierarchicalLoader("tsql", logger, WithWorkers(1)).LoadDirectory(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parallel, err := NewHierarchicalLoader("tsql", logger, WithWorkers(8)).LoadDirectory(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parallel.SuccessCount != 80 || parallel.FailCount != 1 || len(parallel.Errors) != 1 {
+		t.Fatalf("got %d loaded and %d failed, want 80 and 1", parallel.SuccessCount, parallel.FailCount)
+	}
+	if !strings.HasSuffix(parallel.Errors[0].Path, "Broken.sql") {
+		t.Errorf("error for %s, want Broken.sql", parallel.Errors[0].Path)
+	}
+	// Loading concurrently keeps the serial order
+	for i, proc := range parallel.Procedures {
+		if got, want := proc.QualifiedName(), serial.Procedures[i].QualifiedName(); got != want {
+			t.Fatalf("procedure %d is %s, want %s", i, got, want)
+		}
+	}
+	if len(parallel.ByDatabase["salesdb"]) != 80 {
+		t.Errorf("got %d salesdb procedures, want 80", len(parallel.ByDatabase["salesdb"]))
+	}
+}
+
+func TestRegistry_RegisterAll(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Register(&Procedure{Name: "Existing", Schema: "dbo", Database: "salesdb"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := registry.RegisterAll([]*Procedure{
+		{Name: "First", Schema: "dbo", Database: "salesdb"},
+		{Name: "Existing", Schema: "dbo", Database: "salesdb"},
+	})
+	if err == nil {
+		t.Fatal("expected an error registering a duplicate")
+	}
+	if registry.Count() != 1 {
+		t.Errorf("a failed RegisterAll left %d procedures, want 1", registry.Count())
+	}
+
+	err = registry.RegisterAll([]*Procedure{
+		{Name: "First", Schema: "dbo", Database: "salesdb"},
+		{Name: "Second", Schema: "dbo", Database: "salesdb", Tenant: "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.LookupForTenant("Second", "salesdb", "acme"); err != nil {
+		t.Errorf("tenant procedure not found: %v", err)
+	}
+	if registry.Count() != 2 {
+		t.Errorf("got %d procedures, want 2", registry.Count())
+	}
+}
+
+func TestRegistry_ConcurrentLookup(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Register(&Procedure{Name: "Stable", Schema: "dbo", Database: "salesdb"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lookups run while other procedures come and go
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 0; n < 200; n++ {
+			name := fmt.Sprintf("Proc%d", n)
+			registry.Register(&Procedure{Name: name, Schema: "dbo", Database: "salesdb", Tenant: "acme"})
+			registry.Register(&Procedure{Name: name, Schema: "dbo", Database: "salesdb"})
+			registry.Unregister("salesdb.dbo." + name)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if _, err := registry.LookupForTenant("Stable", "salesdb", "acme"); err != nil {
+			t.Fatalf("lookup failed during updates: %v", err)
+		}
+	}
+}
+
+// lockedRegistry resolves names under a read lock, as the registry did
+// before its reads were made lock-free.
+type lockedRegistry struct {
+	mu    sync.RWMutex
+	state *registryState
+}
+
+func (r *lockedRegistry) LookupInDatabase(name, database string) (*Procedure, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.lookup(name, database, "", "lockedRegistry.LookupInDatabase")
+}
+
+// BenchmarkRegistryLookup resolves procedure names from every CPU at
+// once, as nested EXECs do. Run with -cpu 1,8 to see the read lock's
+// cache-line contention grow with the CPUs while lock-free lookups scale.
+func BenchmarkRegistryLookup(b *testing.B) {
+	registry := NewRegistry()
+	for n := 0; n < 1000; n++ {
+		registry.Register(&Procedure{Name: fmt.Sprintf("Proc%d", n), Schema: "dbo", Database: "salesdb"})
+	}
+	names := make([]string, 64)
+	for n := range names {
+		names[n] = fmt.Sprintf("dbo.Proc%d", n*13)
+	}
+
+	lookups := map[string]func(name, database string) (*Procedure, error){
+		"lock-free": registry.LookupInDatabase,
+		"rwmutex":   (&lockedRegistry{state: registry.state.Load()}).LookupInDatabase,
+	}
+	for _, impl := range []string{"lock-free", "rwmutex"} {
+		lookup := lookups[impl]
+		b.Run(impl, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				n := 0
+				for pb.Next() {
+					if _, err := lookup(names[n%len(names)], "salesdb"); err != nil {
+						b.Fatal(err)
+					}
+					n++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package procedure
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+// loadAll calls load for each of n files on up to workers goroutines, or
+// GOMAXPROCS of them when workers is not positive. Reading and scanning a
+// file is independent of every other, so thousands of procedure files
+// load in about the serial time divided by the workers.
+// The results are indexed like the files, keeping the load order (and so
+// which of two duplicates registers first) the same as a serial load.
+func loadAll(n, workers int, load func(i int) (*Procedure, error)) ([]*Procedure, []error) {
+	procs := make([]*Procedure, n)
+	errs := make([]error, n)
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > n {
+		workers = n
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				procs[i], errs[i] = load(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return procs, errs
+}
+
+// collectLoaded returns the procedures loadAll loaded from paths, logging
+// and returning the errors of those that failed.
+func collectLoaded(logger *log.Logger, paths []string, procs []*Procedure, errs []error) ([]*Procedure, []error) {
+	var loaded []*Procedure
+	var loadErrors []error
+	for i, path := range paths {
+		if errs[i] != nil {
+			logger.Application().Warn("failed to load procedure file",
+				"path", path,
+				"error", errs[i].Error(),
+			)
+			loadErrors = append(loadErrors, errs[i])
+			continue
+		}
+		loaded = append(loaded, procs[i])
+	}
+	return loaded, loadErrors
+}
@@ -8,10 +8,11 @@ package procedure
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"maps"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ha1tch/aul/pkg/annotations"
@@ -51,11 +52,14 @@ type Procedure struct {
 	Tenant   string // Tenant ID if this is a tenant-specific override
 
 	// Metadata
-	Parameters  []Parameter
-	ResultSets  []ResultSetDef
-	ReturnType  string // Return type for scalar functions
-	IsFunction  bool   // True if this is a function, not procedure
-	IsTVF       bool   // True if table-valued function
+	Parameters    []Parameter
+	ResultSets    []ResultSetDef
+	Contract      []ResultSetDef // Result sets declared by -- @aul:result or a contract file
+	MaxConcurrent int            // Calls that may run at once, from -- @aul:max-concurrent (0 = no limit)
+	QueueWhenBusy bool           // Calls past MaxConcurrent wait instead of failing
+	ReturnType    string         // Return type for scalar functions
+	IsFunction    bool           // True if this is a function, not procedure
+	IsTVF         bool           // True if table-valued function
 
 	// Annotations from -- @aul: directives
 	Annotations map[string]string
@@ -142,6 +146,9 @@ func (d ParamDirection) String() string {
 type ResultSetDef struct {
 	Columns []ColumnDef
 	Index   int // Result set index (0-based)
+
+	Conditional bool // Returned on some paths only
+	Open        bool // Columns not all known (SELECT * from an unknown table)
 }
 
 // ColumnDef describes a column in a result set.
@@ -154,38 +161,95 @@ type ColumnDef struct {
 }
 
 // Registry maintains a collection of stored procedures.
+//
+// Every EXEC resolves its procedure here, nested ones included, so
+// lookups take no lock. The maps are never modified once published:
+// a change copies them, applies itself to the copy and swaps it in, with
+// writers serialised by mu. Procedures change rarely (at startup, on a
+// file reload or sp_rename), so the copying costs far less than readers
+// contending for a lock would.
 type Registry struct {
-	mu         sync.RWMutex
-	procedures map[string]*Procedure // key: lowercase qualified name (db.schema.name)
-	byFile     map[string]*Procedure // key: source file path
-	globals    map[string]*Procedure // key: lowercase schema.name (global procedures)
+	mu    sync.Mutex // Serialises writers
+	state atomic.Pointer[registryState]
+}
+
+// registryState is one version of the registry. It is read concurrently
+// and must not be modified once stored in Registry.state.
+type registryState struct {
+	procedures map[string]*Procedure            // key: lowercase qualified name (db.schema.name)
+	byFile     map[string]*Procedure            // key: source file path
+	globals    map[string]*Procedure            // key: lowercase schema.name (global procedures)
 	tenants    map[string]map[string]*Procedure // key: tenant -> qualified name -> procedure
 }
 
 // NewRegistry creates a new procedure registry.
 func NewRegistry() *Registry {
-	return &Registry{
+	r := &Registry{}
+	r.state.Store(&registryState{
 		procedures: make(map[string]*Procedure),
 		byFile:     make(map[string]*Procedure),
 		globals:    make(map[string]*Procedure),
 		tenants:    make(map[string]map[string]*Procedure),
+	})
+	return r
+}
+
+// clone returns a copy of s that can be modified. The maps of individual
+// tenants are shared until register copies the one it changes.
+func (s *registryState) clone() *registryState {
+	return &registryState{
+		procedures: maps.Clone(s.procedures),
+		byFile:     maps.Clone(s.byFile),
+		globals:    maps.Clone(s.globals),
+		tenants:    maps.Clone(s.tenants),
 	}
 }
 
-// Register adds a procedure to the registry.
-func (r *Registry) Register(proc *Procedure) error {
+// update applies fn to a copy of the current state and publishes the
+// copy if fn succeeds.
+func (r *Registry) update(fn func(s *registryState) error) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	s := r.state.Load().clone()
+	if err := fn(s); err != nil {
+		return err
+	}
+	r.state.Store(s)
+	return nil
+}
+
+// Register adds a procedure to the registry.
+func (r *Registry) Register(proc *Procedure) error {
+	return r.update(func(s *registryState) error {
+		return s.register(proc)
+	})
+}
+
+// RegisterAll adds procedures to the registry in order, copying its maps
+// once rather than once per procedure. If one fails none are registered.
+func (r *Registry) RegisterAll(procs []*Procedure) error {
+	return r.update(func(s *registryState) error {
+		for _, proc := range procs {
+			if err := s.register(proc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// register adds a procedure to s.
+func (s *registryState) register(proc *Procedure) error {
 	key := strings.ToLower(proc.QualifiedName())
 
 	// Handle tenant-specific procedures
 	if proc.Tenant != "" {
-		return r.registerTenantProcedure(proc, key)
+		return s.registerTenantProcedure(proc, key)
 	}
 
 	// Check for duplicate in main registry
-	if existing, ok := r.procedures[key]; ok {
+	if existing, ok := s.procedures[key]; ok {
 		// Allow re-registration if source changed
 		if existing.SourceHash == proc.SourceHash {
 			return aulerrors.Newf(aulerrors.ErrCodeProcAlreadyExists,
@@ -196,32 +260,26 @@ func (r *Registry) Register(proc *Procedure) error {
 		}
 	}
 
-	r.procedures[key] = proc
+	s.procedures[key] = proc
 	if proc.SourceFile != "" {
-		r.byFile[proc.SourceFile] = proc
+		s.byFile[proc.SourceFile] = proc
 	}
 
 	// Also register globals by short name for fallback lookup
 	if proc.IsGlobal {
 		shortKey := strings.ToLower(proc.ShortName())
-		r.globals[shortKey] = proc
+		s.globals[shortKey] = proc
 	}
 
 	return nil
 }
 
 // registerTenantProcedure registers a tenant-specific procedure override.
-// Must be called with lock held.
-func (r *Registry) registerTenantProcedure(proc *Procedure, key string) error {
+func (s *registryState) registerTenantProcedure(proc *Procedure, key string) error {
 	tenant := strings.ToLower(proc.Tenant)
 
-	// Ensure tenant map exists
-	if r.tenants[tenant] == nil {
-		r.tenants[tenant] = make(map[string]*Procedure)
-	}
-
 	// Check for duplicate
-	if existing, ok := r.tenants[tenant][key]; ok {
+	if existing, ok := s.tenants[tenant][key]; ok {
 		if existing.SourceHash == proc.SourceHash {
 			return aulerrors.Newf(aulerrors.ErrCodeProcAlreadyExists,
 				"tenant procedure already registered: %s (tenant: %s)", proc.QualifiedName(), tenant).
@@ -232,9 +290,15 @@ func (r *Registry) registerTenantProcedure(proc *Procedure, key string) error {
 		}
 	}
 
-	r.tenants[tenant][key] = proc
+	// The tenant's map may be shared with published states
+	procs := maps.Clone(s.tenants[tenant])
+	if procs == nil {
+		procs = make(map[string]*Procedure)
+	}
+	procs[key] = proc
+	s.tenants[tenant] = procs
 	if proc.SourceFile != "" {
-		r.byFile[proc.SourceFile] = proc
+		s.byFile[proc.SourceFile] = proc
 	}
 
 	return nil
@@ -242,27 +306,62 @@ func (r *Registry) registerTenantProcedure(proc *Procedure, key string) error {
 
 // Unregister removes a procedure from the registry.
 func (r *Registry) Unregister(name string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	return r.update(func(s *registryState) error {
+		key := strings.ToLower(name)
+		proc, ok := s.procedures[key]
+		if !ok {
+			return aulerrors.NotFound("procedure", name).
+				WithOp("Registry.Unregister").
+				Err()
+		}
 
-	key := strings.ToLower(name)
-	proc, ok := r.procedures[key]
-	if !ok {
-		return aulerrors.NotFound("procedure", name).
-			WithOp("Registry.Unregister").
-			Err()
-	}
+		delete(s.procedures, key)
+		if proc.SourceFile != "" {
+			delete(s.byFile, proc.SourceFile)
+		}
+		if proc.IsGlobal {
+			shortKey := strings.ToLower(proc.ShortName())
+			delete(s.globals, shortKey)
+		}
 
-	delete(r.procedures, key)
-	if proc.SourceFile != "" {
-		delete(r.byFile, proc.SourceFile)
-	}
-	if proc.IsGlobal {
-		shortKey := strings.ToLower(proc.ShortName())
-		delete(r.globals, shortKey)
-	}
+		return nil
+	})
+}
 
-	return nil
+// Rename gives the procedure that name resolves to in database a new
+// name within its schema, as sp_rename does. Like SQL Server, it leaves
+// the source alone, so its CREATE PROCEDURE still shows the old name.
+// The renamed procedure is a copy; executions already holding the old
+// one finish under the old name.
+func (r *Registry) Rename(name, database, newName string) error {
+	return r.update(func(s *registryState) error {
+		proc, err := s.lookup(name, database, "", "Registry.Rename")
+		if err != nil {
+			return err
+		}
+
+		renamed := *proc
+		renamed.Name = newName
+		renamed.FullName = renamed.ShortName()
+		newKey := strings.ToLower(renamed.QualifiedName())
+		if _, ok := s.procedures[newKey]; ok {
+			return aulerrors.AlreadyExists("procedure", renamed.QualifiedName()).
+				WithOp("Registry.Rename").
+				Err()
+		}
+
+		delete(s.procedures, strings.ToLower(proc.QualifiedName()))
+		s.procedures[newKey] = &renamed
+		if proc.IsGlobal {
+			delete(s.globals, strings.ToLower(proc.ShortName()))
+			s.globals[strings.ToLower(renamed.ShortName())] = &renamed
+		}
+		if proc.SourceFile != "" {
+			s.byFile[proc.SourceFile] = &renamed
+		}
+
+		return nil
+	})
 }
 
 // Lookup finds a procedure by name.
@@ -281,40 +380,43 @@ func (r *Registry) Lookup(name string) (*Procedure, error) {
 //  2. Database-specific procedure
 //  3. Global procedures
 func (r *Registry) LookupForTenant(name, database, tenant string) (*Procedure, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	return r.state.Load().lookup(name, database, tenant, "Registry.LookupForTenant")
+}
 
+// lookup resolves a procedure name in s; op names the caller in the
+// error when there is none.
+func (s *registryState) lookup(name, database, tenant, op string) (*Procedure, error) {
 	key := strings.ToLower(name)
-	parts := strings.Split(key, ".")
+	parts := strings.Count(key, ".") + 1
 
 	// 1. Try tenant-specific override first
 	if tenant != "" {
 		tenantLower := strings.ToLower(tenant)
-		if tenantProcs, ok := r.tenants[tenantLower]; ok {
-			if proc := r.lookupInMap(tenantProcs, key, parts, database); proc != nil {
+		if tenantProcs, ok := s.tenants[tenantLower]; ok {
+			if proc := lookupInMap(tenantProcs, key, parts, database); proc != nil {
 				return proc, nil
 			}
 		}
 	}
 
 	// 2. Try main procedures
-	if proc := r.lookupInMap(r.procedures, key, parts, database); proc != nil {
+	if proc := lookupInMap(s.procedures, key, parts, database); proc != nil {
 		return proc, nil
 	}
 
 	// 3. Try global procedures
-	if proc := r.lookupGlobal(key, parts); proc != nil {
+	if proc := s.lookupGlobal(key, parts); proc != nil {
 		return proc, nil
 	}
 
 	return nil, aulerrors.NotFound("procedure", name).
-		WithOp("Registry.LookupForTenant").
+		WithOp(op).
 		Err()
 }
 
 // lookupInMap searches for a procedure in a map with database context.
-// Must be called with lock held.
-func (r *Registry) lookupInMap(procs map[string]*Procedure, key string, parts []string, database string) *Procedure {
+// key has the given number of dot-separated parts.
+func lookupInMap(procs map[string]*Procedure, key string, parts int, database string) *Procedure {
 	// Exact match
 	if proc, ok := procs[key]; ok {
 		return proc
@@ -324,7 +426,7 @@ func (r *Registry) lookupInMap(procs map[string]*Procedure, key string, parts []
 	if database != "" {
 		dbLower := strings.ToLower(database)
 
-		switch len(parts) {
+		switch parts {
 		case 1:
 			// name only -> try db.dbo.name
 			if proc, ok := procs[dbLower+".dbo."+key]; ok {
@@ -342,23 +444,22 @@ func (r *Registry) lookupInMap(procs map[string]*Procedure, key string, parts []
 }
 
 // lookupGlobal searches for a procedure in globals.
-// Must be called with lock held.
-func (r *Registry) lookupGlobal(key string, parts []string) *Procedure {
-	switch len(parts) {
+func (s *registryState) lookupGlobal(key string, parts int) *Procedure {
+	switch parts {
 	case 1:
 		// name only -> try dbo.name in globals
-		if proc, ok := r.globals["dbo."+key]; ok {
+		if proc, ok := s.globals["dbo."+key]; ok {
 			return proc
 		}
 	case 2:
 		// schema.name -> try in globals
-		if proc, ok := r.globals[key]; ok {
+		if proc, ok := s.globals[key]; ok {
 			return proc
 		}
 	case 3:
 		// db.schema.name -> strip db, try schema.name in globals
-		shortKey := parts[1] + "." + parts[2]
-		if proc, ok := r.globals[shortKey]; ok {
+		shortKey := key[strings.IndexByte(key, '.')+1:]
+		if proc, ok := s.globals[shortKey]; ok {
 			return proc
 		}
 	}
@@ -379,10 +480,7 @@ func (r *Registry) LookupInDatabase(name, database string) (*Procedure, error) {
 
 // LookupByFile finds a procedure by its source file.
 func (r *Registry) LookupByFile(path string) (*Procedure, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	if proc, ok := r.byFile[path]; ok {
+	if proc, ok := r.state.Load().byFile[path]; ok {
 		return proc, nil
 	}
 
@@ -395,11 +493,9 @@ func (r *Registry) LookupByFile(path string) (*Procedure, error) {
 
 // List returns all registered procedures.
 func (r *Registry) List() []*Procedure {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	procs := make([]*Procedure, 0, len(r.procedures))
-	for _, proc := range r.procedures {
+	s := r.state.Load()
+	procs := make([]*Procedure, 0, len(s.procedures))
+	for _, proc := range s.procedures {
 		procs = append(procs, proc)
 	}
 	return procs
@@ -407,9 +503,7 @@ func (r *Registry) List() []*Procedure {
 
 // Count returns the number of registered procedures.
 func (r *Registry) Count() int {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	return len(r.procedures)
+	return len(r.state.Load().procedures)
 }
 
 // Loader loads procedures from files.
@@ -417,6 +511,13 @@ type Loader struct {
 	dialect Dialect
 	parser  Parser
 	logger  *log.Logger
+
+	// Workers is how many files LoadDir loads at once (0 = GOMAXPROCS)
+	Workers int
+
+	// Check, when set, vets each procedure loaded; one it returns an
+	// error for fails to load with that error
+	Check func(proc *Procedure) error
 }
 
 // NewLoader creates a new procedure loader.
@@ -431,6 +532,7 @@ func NewLoader(dialect string, logger *log.Logger) *Loader {
 
 // LoadFile loads a procedure from a SQL file.
 func (l *Loader) LoadFile(path string) (*Procedure, error) {
+	start := time.Now()
 	source, err := os.ReadFile(path)
 	if err != nil {
 		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeProcLoadError,
@@ -448,9 +550,28 @@ func (l *Loader) LoadFile(path string) (*Procedure, error) {
 			WithField("path", path).
 			Err()
 	}
+	if err := loadContract(proc, path); err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeProcValidationError,
+			"invalid result contract").
+			WithOp("Loader.LoadFile").
+			WithField("path", path).
+			Err()
+	}
+	if err := loadConcurrency(proc); err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeProcValidationError,
+			"invalid concurrency limit").
+			WithOp("Loader.LoadFile").
+			WithField("path", path).
+			Err()
+	}
 
 	proc.SourceFile = path
 	proc.LoadedAt = time.Now()
+	if l.Check != nil {
+		if err := l.Check(proc); err != nil {
+			return nil, err
+		}
+	}
 
 	// Get file modification time
 	if info, err := os.Stat(path); err == nil {
@@ -460,40 +581,17 @@ func (l *Loader) LoadFile(path string) (*Procedure, error) {
 	l.logger.Application().Debug("procedure file loaded",
 		"path", path,
 		"procedure", proc.QualifiedName(),
+		"duration_ms", float64(time.Since(start).Microseconds())/1000,
 	)
 
 	return proc, nil
 }
 
-// LoadDir loads all procedures from a directory.
+// LoadDir loads all procedures from a directory, up to Workers files at
+// a time. Loading only reads a file and extracts its name, parameters and
+// annotations; the full T-SQL parse happens when the procedure executes.
 func (l *Loader) LoadDir(dir string) ([]*Procedure, error) {
-	var procs []*Procedure
-	var loadErrors []error
-
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories and non-SQL files
-		if info.IsDir() || !strings.HasSuffix(strings.ToLower(path), ".sql") {
-			return nil
-		}
-
-		proc, err := l.LoadFile(path)
-		if err != nil {
-			l.logger.Application().Warn("failed to load procedure file",
-				"path", path,
-				"error", err.Error(),
-			)
-			loadErrors = append(loadErrors, err)
-			return nil
-		}
-
-		procs = append(procs, proc)
-		return nil
-	})
-
+	paths, err := findSQLFiles(dir)
 	if err != nil {
 		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeProcLoadError,
 			"failed to walk directory").
@@ -502,6 +600,11 @@ func (l *Loader) LoadDir(dir string) ([]*Procedure, error) {
 			Err()
 	}
 
+	loaded, errs := loadAll(len(paths), l.Workers, func(i int) (*Procedure, error) {
+		return l.LoadFile(paths[i])
+	})
+	procs, loadErrors := collectLoaded(l.logger, paths, loaded, errs)
+
 	if len(loadErrors) > 0 {
 		l.logger.Application().Warn("some procedures failed to load",
 			"successful", len(procs),
@@ -0,0 +1,103 @@
+package procedure
+
+import (
+	"strings"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// ReadOnly reports whether a T-SQL procedure leaves the database as it
+// found it, so that running it an extra time is harmless. Writes to temp
+// tables and table variables do not count. The check is conservative:
+// EXEC, dynamic SQL and statements it does not recognise count as writes.
+func ReadOnly(proc *Procedure) (bool, error) {
+	p := parser.New(lexer.New(proc.Source))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return false, aulerrors.Newf(aulerrors.ErrCodeProcParseError, "%s", errs[0]).
+			WithOp("ReadOnly").
+			WithField("procedure", proc.QualifiedName()).
+			Err()
+	}
+	found := false
+	for _, stmt := range program.Statements {
+		cp, ok := stmt.(*ast.CreateProcedureStatement)
+		if !ok {
+			continue
+		}
+		found = true
+		if cp.Body != nil && !readOnlyStatements(cp.Body.Statements) {
+			return false, nil
+		}
+	}
+	return found, nil
+}
+
+func readOnlyStatements(stmts []ast.Statement) bool {
+	for _, stmt := range stmts {
+		if !readOnlyStatement(stmt) {
+			return false
+		}
+	}
+	return true
+}
+
+func readOnlyStatement(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.SelectStatement:
+		return s.Into == nil || localTable(s.Into)
+	case *ast.WithStatement:
+		return readOnlyStatement(s.Query)
+	case *ast.InsertStatement:
+		return s.Exec == nil && localTable(s.Table)
+	case *ast.UpdateStatement:
+		return localTable(s.Table)
+	case *ast.DeleteStatement:
+		return localTable(s.Table)
+	case *ast.MergeStatement:
+		return localTable(s.Target)
+	case *ast.TruncateTableStatement:
+		return localTable(s.Table)
+	case *ast.CreateTableStatement:
+		return s.IsTemporary
+	case *ast.DropTableStatement:
+		for _, t := range s.Tables {
+			if !localTable(t) {
+				return false
+			}
+		}
+		return true
+	case *ast.BeginEndBlock:
+		return readOnlyStatements(s.Statements)
+	case *ast.IfStatement:
+		return readOnlyStatement(s.Consequence) && (s.Alternative == nil || readOnlyStatement(s.Alternative))
+	case *ast.WhileStatement:
+		return readOnlyStatement(s.Body)
+	case *ast.TryCatchStatement:
+		return (s.TryBlock == nil || readOnlyStatements(s.TryBlock.Statements)) &&
+			(s.CatchBlock == nil || readOnlyStatements(s.CatchBlock.Statements))
+	case *ast.DeclareStatement, *ast.SetStatement, *ast.SetOptionStatement,
+		*ast.SetTransactionIsolationStatement, *ast.ReturnStatement, *ast.BreakStatement,
+		*ast.ContinueStatement, *ast.PrintStatement, *ast.ThrowStatement, *ast.RaiserrorStatement,
+		*ast.BeginTransactionStatement, *ast.CommitTransactionStatement,
+		*ast.RollbackTransactionStatement, *ast.SaveTransactionStatement,
+		*ast.DeclareCursorStatement, *ast.OpenCursorStatement, *ast.FetchStatement,
+		*ast.CloseCursorStatement, *ast.DeallocateCursorStatement,
+		*ast.GotoStatement, *ast.LabelStatement, *ast.WaitforStatement:
+		return true
+	}
+	return false
+}
+
+// localTable reports whether a table belongs to the execution: a temp
+// table or a table variable.
+func localTable(name *ast.QualifiedIdentifier) bool {
+	if name == nil || len(name.Parts) == 0 {
+		return false
+	}
+	last := name.Parts[len(name.Parts)-1].Value
+	return strings.HasPrefix(last, "#") || strings.HasPrefix(last, "@")
+}
@@ -0,0 +1,45 @@
+package procedure
+
+import "testing"
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"select", "SELECT * FROM Orders WHERE CustomerID = @ID", true},
+		{"locals", `DECLARE @T TABLE (ID INT)
+    CREATE TABLE #Work (ID INT)
+    INSERT INTO @T SELECT OrderID FROM Orders
+    INSERT INTO #Work VALUES (1)
+    UPDATE #Work SET ID = 2
+    DELETE FROM @T WHERE ID = 1
+    SELECT o.* INTO #Copy FROM Orders o
+    IF @ID > 0
+        SELECT * FROM #Work
+    ELSE
+        PRINT 'none'
+    DROP TABLE #Work`, true},
+		{"insert", "INSERT INTO Orders (ID) VALUES (@ID)", false},
+		{"update in catch", `BEGIN TRY
+        SELECT 1
+    END TRY
+    BEGIN CATCH
+        UPDATE Audit SET Failed = 1
+    END CATCH`, false},
+		{"select into", "SELECT * INTO OrdersCopy FROM Orders", false},
+		{"exec", "EXEC dbo.Other @ID", false},
+		{"truncate", "TRUNCATE TABLE Orders", false},
+	}
+	for _, tt := range tests {
+		src := "CREATE PROCEDURE dbo.P\n    @ID INT\nAS\nBEGIN\n    " + tt.body + "\nEND"
+		got, err := ReadOnly(&Procedure{Source: src})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: ReadOnly = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
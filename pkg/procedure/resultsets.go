@@ -0,0 +1,476 @@
+package procedure
+
+import (
+	"strings"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// TableColumns returns the columns of the named table, or nil when the
+// table is unknown. It lets InferResultSets type the columns a procedure
+// reads from tables.
+type TableColumns func(table string) []ColumnDef
+
+// InferResultSets works out the result sets a T-SQL procedure returns
+// from the SELECT statements in its body, in the order they appear.
+//
+// Column names come from aliases and column references. Types come from
+// literals, CAST and CONVERT, parameters and variables, well-known
+// functions and, through tables, the columns of the tables read; a type
+// that cannot be worked out is left empty. Result sets returned only on
+// some paths (inside IF, WHILE or CATCH) are marked Conditional, and those
+// selecting * from a table tables does not know are marked Open.
+// Procedures called with EXEC contribute nothing.
+func InferResultSets(proc *Procedure, tables TableColumns) ([]ResultSetDef, error) {
+	p := parser.New(lexer.New(proc.Source))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, aulerrors.Newf(aulerrors.ErrCodeProcParseError, "%s", errs[0]).
+			WithOp("InferResultSets").
+			WithField("procedure", proc.QualifiedName()).
+			Err()
+	}
+	if tables == nil {
+		tables = func(string) []ColumnDef { return nil }
+	}
+
+	inf := &inference{tables: tables, vars: make(map[string]string), local: make(map[string][]ColumnDef)}
+	for _, stmt := range program.Statements {
+		cp, ok := stmt.(*ast.CreateProcedureStatement)
+		if !ok {
+			continue
+		}
+		for _, param := range cp.Parameters {
+			inf.vars[strings.ToLower(param.Name)] = dataTypeName(param.DataType)
+		}
+		if cp.Body != nil {
+			inf.statements(cp.Body.Statements, false)
+		}
+	}
+	for i := range inf.sets {
+		inf.sets[i].Index = i
+	}
+	return inf.sets, nil
+}
+
+// inference is the state of InferResultSets while it walks a body.
+type inference struct {
+	tables TableColumns
+	vars   map[string]string      // variable (lower case, with @) -> type
+	local  map[string][]ColumnDef // temp tables, table variables and CTEs
+	sets   []ResultSetDef
+}
+
+func (inf *inference) statements(stmts []ast.Statement, conditional bool) {
+	for _, stmt := range stmts {
+		inf.statement(stmt, conditional)
+	}
+}
+
+func (inf *inference) statement(stmt ast.Statement, conditional bool) {
+	switch s := stmt.(type) {
+	case *ast.SelectStatement:
+		if s.Into != nil {
+			return
+		}
+		for _, col := range s.Columns {
+			if col.Variable != nil {
+				return // Assigns variables instead of returning rows
+			}
+		}
+		cols, open := inf.selectColumns(s)
+		inf.sets = append(inf.sets, ResultSetDef{Columns: cols, Conditional: conditional, Open: open})
+	case *ast.WithStatement:
+		for _, cte := range s.CTEs {
+			cols, _ := inf.selectColumns(cte.Query)
+			for i, name := range cte.Columns {
+				if i < len(cols) {
+					cols[i].Name = name.Value
+				}
+			}
+			inf.local[strings.ToLower(cte.Name.Value)] = cols
+		}
+		inf.statement(s.Query, conditional)
+	case *ast.BeginEndBlock:
+		inf.statements(s.Statements, conditional)
+	case *ast.IfStatement:
+		inf.statement(s.Consequence, true)
+		if s.Alternative != nil {
+			inf.statement(s.Alternative, true)
+		}
+	case *ast.WhileStatement:
+		inf.statement(s.Body, true)
+	case *ast.TryCatchStatement:
+		if s.TryBlock != nil {
+			inf.statements(s.TryBlock.Statements, conditional)
+		}
+		if s.CatchBlock != nil {
+			inf.statements(s.CatchBlock.Statements, true)
+		}
+	case *ast.DeclareStatement:
+		for _, v := range s.Variables {
+			name := strings.ToLower(v.Name)
+			if v.TableType != nil {
+				inf.local[name] = tableTypeColumns(v.TableType.Columns)
+				continue
+			}
+			inf.vars[name] = dataTypeName(v.DataType)
+		}
+	case *ast.CreateTableStatement:
+		if s.IsTemporary && s.Name != nil && len(s.Name.Parts) > 0 {
+			inf.local[strings.ToLower(s.Name.Parts[len(s.Name.Parts)-1].Value)] = tableTypeColumns(s.Columns)
+		}
+	}
+}
+
+// scopeTable is a table read by a SELECT, under its alias.
+type scopeTable struct {
+	alias    string // Lower case
+	columns  []ColumnDef
+	nullable bool // On the outer side of an outer join
+	known    bool
+}
+
+// selectColumns returns the columns of a SELECT's result, and whether a *
+// could not be expanded.
+func (inf *inference) selectColumns(s *ast.SelectStatement) ([]ColumnDef, bool) {
+	var scope []scopeTable
+	if s.From != nil {
+		for _, ref := range s.From.Tables {
+			scope = inf.addTables(scope, ref, false)
+		}
+	}
+
+	var cols []ColumnDef
+	open := false
+	for _, col := range s.Columns {
+		if prefix, ok := starPrefix(col); ok {
+			for _, t := range scope {
+				if prefix != "" && t.alias != prefix {
+					continue
+				}
+				if !t.known {
+					open = true
+				}
+				for _, c := range t.columns {
+					c.Nullable = c.Nullable || t.nullable
+					cols = append(cols, c)
+				}
+			}
+			if len(scope) == 0 {
+				open = true
+			}
+			continue
+		}
+		c := ColumnDef{Name: columnName(col)}
+		c.SQLType, c.Nullable = inf.exprType(col.Expression, scope)
+		cols = append(cols, c)
+	}
+	for i := range cols {
+		cols[i].Ordinal = i
+		cols[i].GoType = mapSQLTypeToGo(cols[i].SQLType)
+	}
+	return cols, open
+}
+
+// addTables adds the tables of a FROM item to scope.
+func (inf *inference) addTables(scope []scopeTable, ref ast.TableReference, nullable bool) []scopeTable {
+	switch r := ref.(type) {
+	case *ast.TableName:
+		name := r.Name.Parts[len(r.Name.Parts)-1].Value
+		t := scopeTable{alias: strings.ToLower(name), nullable: nullable}
+		if r.Alias != nil {
+			t.alias = strings.ToLower(r.Alias.Value)
+		}
+		if cols, ok := inf.local[strings.ToLower(name)]; ok {
+			t.columns, t.known = cols, true
+		} else if cols := inf.tables(r.Name.String()); cols != nil {
+			t.columns, t.known = cols, true
+		}
+		return append(scope, t)
+	case *ast.DerivedTable:
+		cols, open := inf.selectColumns(r.Subquery)
+		for i, name := range r.ColumnAliases {
+			if i < len(cols) {
+				cols[i].Name = name.Value
+			}
+		}
+		t := scopeTable{columns: cols, nullable: nullable, known: !open}
+		if r.Alias != nil {
+			t.alias = strings.ToLower(r.Alias.Value)
+		}
+		return append(scope, t)
+	case *ast.JoinClause:
+		kind := strings.ToUpper(r.Type)
+		scope = inf.addTables(scope, r.Left, nullable || strings.Contains(kind, "RIGHT") || strings.Contains(kind, "FULL"))
+		return inf.addTables(scope, r.Right, nullable || strings.Contains(kind, "LEFT") || strings.Contains(kind, "FULL"))
+	case *ast.ParenthesizedTableRef:
+		return inf.addTables(scope, r.Inner, nullable)
+	}
+	// Table-valued functions and the like: columns unknown
+	return append(scope, scopeTable{nullable: nullable})
+}
+
+// starPrefix reports whether col is * or alias.*, returning the alias in
+// lower case.
+func starPrefix(col ast.SelectColumn) (string, bool) {
+	q, _ := col.Expression.(*ast.QualifiedIdentifier)
+	if q != nil && len(q.Parts) > 1 && q.Parts[len(q.Parts)-1].Value == "*" {
+		return strings.ToLower(q.Parts[len(q.Parts)-2].Value), true
+	}
+	return "", col.AllColumns
+}
+
+// columnName returns the name a select column is returned under, or ""
+// when SQL Server would leave it unnamed.
+func columnName(col ast.SelectColumn) string {
+	if col.Alias != nil {
+		return col.Alias.Value
+	}
+	switch e := col.Expression.(type) {
+	case *ast.Identifier:
+		return e.Value
+	case *ast.QualifiedIdentifier:
+		return e.Parts[len(e.Parts)-1].Value
+	}
+	return ""
+}
+
+// exprType returns the type of an expression (lower-case base type name,
+// "" if unknown) and whether it may be NULL.
+func (inf *inference) exprType(expr ast.Expression, scope []scopeTable) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.IntegerLiteral:
+		if e.Value > 2147483647 || e.Value < -2147483648 {
+			return "bigint", false
+		}
+		return "int", false
+	case *ast.FloatLiteral:
+		if strings.ContainsAny(e.Token.Literal, "eE") {
+			return "float", false
+		}
+		return "decimal", false
+	case *ast.MoneyLiteral:
+		return "money", false
+	case *ast.StringLiteral:
+		if e.Unicode {
+			return "nvarchar", false
+		}
+		return "varchar", false
+	case *ast.NullLiteral:
+		return "", true
+	case *ast.Variable:
+		if strings.HasPrefix(e.Name, "@@") {
+			switch strings.ToUpper(e.Name) {
+			case "@@VERSION", "@@SERVERNAME", "@@SERVICENAME", "@@LANGUAGE":
+				return "nvarchar", false
+			case "@@IDENTITY":
+				return "numeric", true
+			}
+			return "int", false
+		}
+		return inf.vars[strings.ToLower(e.Name)], true
+	case *ast.Identifier:
+		return lookupColumn(scope, "", e.Value)
+	case *ast.QualifiedIdentifier:
+		prefix := ""
+		if len(e.Parts) > 1 {
+			prefix = e.Parts[len(e.Parts)-2].Value
+		}
+		return lookupColumn(scope, prefix, e.Parts[len(e.Parts)-1].Value)
+	case *ast.CastExpression:
+		_, nullable := inf.exprType(e.Expression, scope)
+		return dataTypeName(e.TargetType), nullable || e.IsTry
+	case *ast.ConvertExpression:
+		_, nullable := inf.exprType(e.Expression, scope)
+		return dataTypeName(e.TargetType), nullable || e.IsTry
+	case *ast.PrefixExpression:
+		if strings.EqualFold(e.Operator, "NOT") {
+			return "bit", false
+		}
+		return inf.exprType(e.Right, scope)
+	case *ast.InfixExpression:
+		left, ln := inf.exprType(e.Left, scope)
+		right, rn := inf.exprType(e.Right, scope)
+		switch e.Operator {
+		case "+", "-", "*", "/", "%", "&", "|", "^":
+			return widerType(left, right), ln || rn
+		}
+		return "bit", ln || rn
+	case *ast.CaseExpression:
+		typ, nullable := "", e.ElseClause == nil
+		for _, w := range e.WhenClauses {
+			t, n := inf.exprType(w.Result, scope)
+			typ, nullable = widerType(typ, t), nullable || n
+		}
+		if e.ElseClause != nil {
+			t, n := inf.exprType(e.ElseClause, scope)
+			typ, nullable = widerType(typ, t), nullable || n
+		}
+		return typ, nullable
+	case *ast.SubqueryExpression:
+		if cols, _ := inf.selectColumns(e.Subquery); len(cols) > 0 {
+			return cols[0].SQLType, true
+		}
+		return "", true
+	case *ast.FunctionCall:
+		return inf.functionType(e, scope)
+	}
+	return "", true
+}
+
+// functionType returns the type of a function call's result.
+func (inf *inference) functionType(f *ast.FunctionCall, scope []scopeTable) (string, bool) {
+	arg := func(i int) (string, bool) {
+		if i < len(f.Arguments) {
+			return inf.exprType(f.Arguments[i], scope)
+		}
+		return "", true
+	}
+	name := strings.ToUpper(f.Function.String())
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	switch name {
+	case "COUNT":
+		return "int", false
+	case "COUNT_BIG", "ROW_NUMBER":
+		return "bigint", false
+	case "RANK", "DENSE_RANK", "NTILE":
+		return "bigint", false
+	case "SUM", "MIN", "MAX", "AVG":
+		t, _ := arg(0)
+		if name == "SUM" || name == "AVG" {
+			switch t {
+			case "tinyint", "smallint":
+				t = "int"
+			}
+		}
+		return t, true
+	case "GETDATE", "GETUTCDATE", "CURRENT_TIMESTAMP":
+		return "datetime", false
+	case "SYSDATETIME", "SYSUTCDATETIME":
+		return "datetime2", false
+	case "SYSDATETIMEOFFSET", "TODATETIMEOFFSET":
+		return "datetimeoffset", false
+	case "NEWID", "NEWSEQUENTIALID":
+		return "uniqueidentifier", false
+	case "LEN", "DATALENGTH", "CHARINDEX", "PATINDEX", "DATEDIFF", "DATEPART",
+		"YEAR", "MONTH", "DAY", "ASCII", "UNICODE", "SIGN", "ISNUMERIC", "ISDATE":
+		_, n := arg(0)
+		return "int", n
+	case "DATEDIFF_BIG":
+		return "bigint", true
+	case "DATENAME", "FORMAT", "CONCAT", "CONCAT_WS", "STRING_AGG", "NCHAR", "QUOTENAME":
+		return "nvarchar", name != "CONCAT" && name != "CONCAT_WS"
+	case "CHAR", "STR", "SPACE":
+		return "varchar", true
+	case "UPPER", "LOWER", "LTRIM", "RTRIM", "TRIM", "SUBSTRING", "LEFT", "RIGHT",
+		"REPLACE", "REPLICATE", "REVERSE", "STUFF", "ABS", "ROUND", "CEILING", "FLOOR":
+		return arg(0)
+	case "DATEADD":
+		t, n := arg(2)
+		return t, n
+	case "EOMONTH", "DATEFROMPARTS":
+		return "date", true
+	case "SQRT", "POWER", "EXP", "LOG", "LOG10", "RAND", "PI", "SIN", "COS", "TAN":
+		return "float", true
+	case "ISNULL":
+		t, _ := arg(0)
+		if t == "" {
+			t, _ = arg(1)
+		}
+		_, n := arg(1)
+		return t, n
+	case "COALESCE":
+		typ, nullable := "", true
+		for i := range f.Arguments {
+			t, n := arg(i)
+			typ = widerType(typ, t)
+			if !n {
+				nullable = false
+			}
+		}
+		return typ, nullable
+	case "NULLIF":
+		t, _ := arg(0)
+		return t, true
+	case "IIF":
+		t1, n1 := arg(1)
+		t2, n2 := arg(2)
+		return widerType(t1, t2), n1 || n2
+	case "JSON_VALUE", "JSON_QUERY":
+		return "nvarchar", true
+	case "OBJECT_ID", "SCOPE_IDENTITY", "ERROR_NUMBER", "ERROR_SEVERITY", "ERROR_STATE", "ERROR_LINE":
+		return "int", true
+	case "ERROR_MESSAGE", "ERROR_PROCEDURE", "OBJECT_NAME", "DB_NAME", "SCHEMA_NAME", "SUSER_SNAME", "USER_NAME":
+		return "nvarchar", true
+	}
+	return "", true
+}
+
+// lookupColumn finds a column of the tables in scope, in the table with
+// the given alias when prefix is set.
+func lookupColumn(scope []scopeTable, prefix, name string) (string, bool) {
+	prefix = strings.ToLower(prefix)
+	for _, t := range scope {
+		if prefix != "" && t.alias != prefix {
+			continue
+		}
+		for _, c := range t.columns {
+			if strings.EqualFold(c.Name, name) {
+				return c.SQLType, c.Nullable || t.nullable
+			}
+		}
+	}
+	return "", true
+}
+
+// typePrecedence orders types as SQL Server converts them in an
+// expression mixing them: the higher wins.
+var typePrecedence = map[string]int{
+	"char": 1, "varchar": 2, "nchar": 3, "nvarchar": 4,
+	"uniqueidentifier": 5, "bit": 6, "tinyint": 7, "smallint": 8, "int": 9, "bigint": 10,
+	"smallmoney": 11, "money": 12, "decimal": 13, "numeric": 13, "real": 14, "float": 15,
+	"date": 16, "time": 16, "smalldatetime": 17, "datetime": 18, "datetime2": 19, "datetimeoffset": 20,
+}
+
+// widerType returns the type of an expression combining a and b: the
+// one of higher precedence, or the known one.
+func widerType(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	case typePrecedence[b] > typePrecedence[a]:
+		return b
+	}
+	return a
+}
+
+// dataTypeName returns the lower-case base name of a data type.
+func dataTypeName(dt *ast.DataType) string {
+	if dt == nil {
+		return ""
+	}
+	return strings.ToLower(dt.Name)
+}
+
+// tableTypeColumns returns the columns of a temp table or table variable
+// definition.
+func tableTypeColumns(defs []*ast.ColumnDefinition) []ColumnDef {
+	cols := make([]ColumnDef, 0, len(defs))
+	for i, d := range defs {
+		c := ColumnDef{Name: d.Name.Value, SQLType: dataTypeName(d.DataType), Nullable: true, Ordinal: i}
+		if d.Nullable != nil {
+			c.Nullable = *d.Nullable
+		}
+		c.GoType = mapSQLTypeToGo(c.SQLType)
+		cols = append(cols, c)
+	}
+	return cols
+}
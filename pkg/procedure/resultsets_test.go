@@ -0,0 +1,96 @@
+package procedure
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestInferResultSets(t *testing.T) {
+	src := `CREATE PROCEDURE dbo.OrderReport
+    @CustomerID INT,
+    @Since DATE = NULL
+AS
+BEGIN
+    DECLARE @Total DECIMAL(10,2)
+    DECLARE @Lines TABLE (OrderID INT NOT NULL, Amount MONEY)
+    CREATE TABLE #Recent (OrderID INT NOT NULL, PlacedAt DATETIME2)
+
+    SELECT @Total = SUM(Amount) FROM Orders WHERE CustomerID = @CustomerID
+
+    SELECT c.Name, o.OrderID AS ID, o.Total * 2 AS Doubled, COUNT(*) AS N,
+           N'x' + c.Name AS Label, CAST(o.Total AS VARCHAR(20)) AS Text, @Total
+    FROM Customers c
+    LEFT JOIN Orders o ON o.CustomerID = c.CustomerID
+    GROUP BY c.Name, o.OrderID, o.Total
+
+    IF @Since IS NOT NULL
+        SELECT * FROM #Recent
+
+    INSERT INTO #Recent SELECT OrderID, GETDATE() FROM Orders
+
+    SELECT l.*, r.PlacedAt FROM @Lines l JOIN #Recent r ON r.OrderID = l.OrderID
+
+    BEGIN TRY
+        SELECT * FROM Unknown
+    END TRY
+    BEGIN CATCH
+        SELECT ERROR_MESSAGE() AS Error
+    END CATCH
+END`
+	tables := func(name string) []ColumnDef {
+		switch strings.ToLower(name) {
+		case "customers":
+			return []ColumnDef{{Name: "CustomerID", SQLType: "int"}, {Name: "Name", SQLType: "nvarchar"}}
+		case "orders", "dbo.orders":
+			return []ColumnDef{{Name: "OrderID", SQLType: "int"}, {Name: "CustomerID", SQLType: "int"}, {Name: "Total", SQLType: "decimal"}}
+		}
+		return nil
+	}
+
+	sets, err := InferResultSets(&Procedure{Name: "OrderReport", Source: src}, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Name nvarchar, ID int null, Doubled decimal null, N int, Label nvarchar, Text varchar null,  decimal null",
+		"OrderID int, PlacedAt datetime2 null; conditional",
+		"OrderID int, Amount money null, PlacedAt datetime2 null",
+		"; open",
+		"Error nvarchar null; conditional",
+	}
+	if len(sets) != len(want) {
+		t.Fatalf("got %d result sets, want %d: %+v", len(sets), len(want), sets)
+	}
+	for i, rs := range sets {
+		if got := describeResultSet(rs); got != want[i] || rs.Index != i {
+			t.Errorf("result set %d: got %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func describeResultSet(rs ResultSetDef) string {
+	var cols []string
+	for _, c := range rs.Columns {
+		s := c.Name + " " + c.SQLType
+		if c.Nullable {
+			s += " null"
+		}
+		cols = append(cols, s)
+	}
+	s := strings.Join(cols, ", ")
+	if rs.Conditional {
+		s += "; conditional"
+	}
+	if rs.Open {
+		s += "; open"
+	}
+	return fmt.Sprint(s)
+}
+
+func TestInferResultSetsParseError(t *testing.T) {
+	if _, err := InferResultSets(&Procedure{Name: "Bad", Source: "CREATE PROCEDURE dbo.Bad AS SELECT FROM WHERE"}, nil); err == nil {
+		t.Error("expected a parse error")
+	}
+}
@@ -41,6 +41,7 @@ type Watcher struct {
 	// Callbacks
 	onReload func(proc *Procedure, event string) // Called when a procedure is reloaded
 	onError  func(err error)                     // Called on errors
+	stage    func(proc *Procedure) bool          // Takes a changed procedure instead of registering it
 }
 
 // WatcherOption configures the watcher.
@@ -68,6 +69,24 @@ func WithOnError(fn func(err error)) WatcherOption {
 	}
 }
 
+// WithStage sets a function offered each changed procedure before it
+// replaces the registered version. When it returns true it has taken the
+// procedure, to register later or not at all, and the watcher leaves the
+// registered version in place.
+func WithStage(fn func(proc *Procedure) bool) WatcherOption {
+	return func(w *Watcher) {
+		w.stage = fn
+	}
+}
+
+// WithReloadCheck sets a function that vets each changed procedure. One
+// it returns an error for fails to reload, keeping the registered version.
+func WithReloadCheck(fn func(proc *Procedure) error) WatcherOption {
+	return func(w *Watcher) {
+		w.loader.check = fn
+	}
+}
+
 // NewWatcher creates a new procedure watcher.
 func NewWatcher(root, dialect string, registry *Registry, logger *log.Logger, opts ...WatcherOption) (*Watcher, error) {
 	fsw, err := fsnotify.NewWatcher()
@@ -348,6 +367,17 @@ func (w *Watcher) handleFileChanged(path string) {
 		// Clear JIT state (will need recompilation)
 		proc.JITCompiled = false
 		proc.JITCode = nil
+
+		if w.stage != nil && w.stage(proc) {
+			w.logger.Application().Info("procedure change staged",
+				"procedure", proc.QualifiedName(),
+				"path", path,
+			)
+			if w.onReload != nil {
+				w.onReload(proc, "staged")
+			}
+			return
+		}
 	}
 
 	// Register (will overwrite if exists)
@@ -0,0 +1,208 @@
+// Package capture records and replays raw client/server byte streams.
+//
+// A Recorder wraps the net.Conn accepted by a protocol listener and keeps
+// every chunk read from or written to it. When the connection closes the
+// session is sanitised (credentials removed) and written to a JSON file.
+//
+// Replay feeds the client side of a recorded session to a live server and
+// collects the server's responses, so captures taken from real drivers
+// (go-mssqldb, pyodbc, JDBC, psql) can be turned into regression tests.
+//
+// Captures are only useful for unencrypted sessions: once TLS is negotiated
+// the recorded bytes are ciphertext. Connect with encryption disabled
+// (e.g. encrypt=disable for go-mssqldb, sslmode=disable for libpq) when
+// recording.
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// Direction identifies which peer sent a frame.
+type Direction string
+
+const (
+	ClientToServer Direction = "c2s"
+	ServerToClient Direction = "s2c"
+)
+
+// Frame is a contiguous run of bytes sent in one direction.
+type Frame struct {
+	Dir    Direction     `json:"dir"`
+	Offset time.Duration `json:"offset"` // Time since session start
+	Data   []byte        `json:"data"`
+}
+
+// Session is a recorded connection.
+type Session struct {
+	Protocol  protocol.ProtocolType `json:"protocol"`
+	StartedAt time.Time             `json:"started_at"`
+	Sanitised bool                  `json:"sanitised"`
+	Frames    []Frame               `json:"frames"`
+}
+
+// Load reads a recorded session from a file.
+func Load(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing capture %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Save writes the session to a file as indented JSON.
+func (s *Session) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Sanitise removes credentials from the client side of the session using
+// the sanitiser registered for its protocol. It is idempotent.
+func (s *Session) Sanitise() {
+	if s.Sanitised {
+		return
+	}
+	fn := sanitisers[s.Protocol]
+	if fn != nil {
+		for i := range s.Frames {
+			if s.Frames[i].Dir == ClientToServer {
+				s.Frames[i].Data = fn(s.Frames[i].Data)
+			}
+		}
+	}
+	s.Sanitised = true
+}
+
+// ClientBytes returns the concatenated client-to-server stream.
+func (s *Session) ClientBytes() []byte {
+	var out []byte
+	for _, f := range s.Frames {
+		if f.Dir == ClientToServer {
+			out = append(out, f.Data...)
+		}
+	}
+	return out
+}
+
+// Recorder is a net.Conn that records all traffic passing through it.
+type Recorder struct {
+	net.Conn
+
+	mu      sync.Mutex
+	session Session
+	start   time.Time
+	path    string
+	closed  bool
+}
+
+// fileSeq disambiguates capture files created within the same nanosecond.
+var fileSeq uint64
+
+// NewRecorder wraps conn. The session is written to a new file in dir when
+// the connection is closed.
+func NewRecorder(conn net.Conn, proto protocol.ProtocolType, dir string) *Recorder {
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s-%04d.json", proto, now.Format("20060102T150405.000000000"),
+		atomic.AddUint64(&fileSeq, 1))
+	return &Recorder{
+		Conn:  conn,
+		start: now,
+		path:  filepath.Join(dir, name),
+		session: Session{
+			Protocol:  proto,
+			StartedAt: now,
+		},
+	}
+}
+
+// Path returns the file the session will be written to.
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Read implements net.Conn, recording client bytes.
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	if n > 0 {
+		r.record(ClientToServer, b[:n])
+	}
+	return n, err
+}
+
+// Write implements net.Conn, recording server bytes.
+func (r *Recorder) Write(b []byte) (int, error) {
+	n, err := r.Conn.Write(b)
+	if n > 0 {
+		r.record(ServerToClient, b[:n])
+	}
+	return n, err
+}
+
+// record appends data, coalescing with the previous frame when the
+// direction has not changed so that protocol messages stay contiguous.
+func (r *Recorder) record(dir Direction, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	if n := len(r.session.Frames); n > 0 && r.session.Frames[n-1].Dir == dir {
+		r.session.Frames[n-1].Data = append(r.session.Frames[n-1].Data, data...)
+		return
+	}
+	r.session.Frames = append(r.session.Frames, Frame{
+		Dir:    dir,
+		Offset: time.Since(r.start),
+		Data:   append([]byte(nil), data...),
+	})
+}
+
+// Close closes the underlying connection and writes the sanitised session.
+func (r *Recorder) Close() error {
+	err := r.Conn.Close()
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return err
+	}
+	r.closed = true
+	sess := r.session
+	r.mu.Unlock()
+
+	if len(sess.Frames) == 0 {
+		return err
+	}
+	sess.Sanitise()
+	if saveErr := sess.Save(r.path); saveErr != nil && err == nil {
+		err = fmt.Errorf("writing capture: %w", saveErr)
+	}
+	return err
+}
+
+// Ensure Recorder implements net.Conn
+var _ net.Conn = (*Recorder)(nil)
+
+// Enabled reports the capture directory configured for a listener, if any.
+// Listeners wrap accepted connections in a Recorder when it is non-empty.
+func Enabled(cfg protocol.ListenerConfig) (string, bool) {
+	dir, ok := cfg.Options["capture_dir"].(string)
+	return dir, ok && dir != ""
+}
@@ -0,0 +1,131 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tds"
+)
+
+// tdsPacket wraps payload in a single EOM packet.
+func tdsPacket(pktType tds.PacketType, payload []byte) []byte {
+	pkt := make([]byte, tds.HeaderSize+len(payload))
+	pkt[0] = byte(pktType)
+	pkt[1] = byte(tds.StatusEOM)
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	copy(pkt[tds.HeaderSize:], payload)
+	return pkt
+}
+
+// login7WithPassword builds a LOGIN7 payload containing only a password.
+func login7WithPassword(pass string) []byte {
+	login := make([]byte, tds.Login7HeaderSize+len(pass)*2)
+	binary.LittleEndian.PutUint16(login[44:46], uint16(tds.Login7HeaderSize))
+	binary.LittleEndian.PutUint16(login[46:48], uint16(len(pass)))
+	for i, c := range []byte(pass) {
+		login[tds.Login7HeaderSize+i*2] = obfuscate(c)
+		login[tds.Login7HeaderSize+i*2+1] = obfuscate(0)
+	}
+	return login
+}
+
+func TestSanitiseTDSMasksPassword(t *testing.T) {
+	pkt := tdsPacket(tds.PacketLogin7, login7WithPassword("s3cret"))
+	sess := &Session{
+		Protocol: protocol.ProtocolTDS,
+		Frames:   []Frame{{Dir: ClientToServer, Data: pkt}},
+	}
+	sess.Sanitise()
+
+	got := sess.Frames[0].Data
+	if len(got) != len(pkt) {
+		t.Fatalf("sanitised length = %d, want %d", len(got), len(pkt))
+	}
+	want := tdsPacket(tds.PacketLogin7, login7WithPassword("******"))
+	if !bytes.Equal(got, want) {
+		t.Errorf("password not masked:\n got %x\nwant %x", got, want)
+	}
+	if !sess.Sanitised {
+		t.Error("session not marked sanitised")
+	}
+}
+
+func TestSanitisePostgresMasksPasswordMessage(t *testing.T) {
+	var data []byte
+	// StartupMessage: length, protocol 3.0, user=bob
+	startup := append([]byte{0, 0, 0, 0, 0, 3, 0, 0}, []byte("user\x00bob\x00\x00")...)
+	binary.BigEndian.PutUint32(startup[0:4], uint32(len(startup)))
+	data = append(data, startup...)
+	// PasswordMessage
+	pw := []byte("hunter2\x00")
+	msg := []byte{'p', 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[1:5], uint32(4+len(pw)))
+	data = append(data, append(msg, pw...)...)
+
+	got := sanitisePostgres(data)
+	if bytes.Contains(got, []byte("hunter2")) {
+		t.Error("password still present after sanitising")
+	}
+	if !bytes.Contains(got, []byte("bob")) {
+		t.Error("startup parameters should be preserved")
+	}
+	if len(got) != len(data) {
+		t.Errorf("sanitised length = %d, want %d", len(got), len(data))
+	}
+}
+
+func TestExchangesGroupsByDirection(t *testing.T) {
+	sess := &Session{Frames: []Frame{
+		{Dir: ClientToServer, Data: []byte("a")},
+		{Dir: ClientToServer, Data: []byte("b")},
+		{Dir: ServerToClient, Data: []byte("1")},
+		{Dir: ClientToServer, Data: []byte("c")},
+		{Dir: ServerToClient, Data: []byte("2")},
+		{Dir: ServerToClient, Data: []byte("3")},
+	}}
+	ex := sess.exchanges()
+	if len(ex) != 2 {
+		t.Fatalf("got %d exchanges, want 2", len(ex))
+	}
+	if string(ex[0].Request) != "ab" || string(ex[0].Recorded) != "1" {
+		t.Errorf("exchange 0 = %q/%q", ex[0].Request, ex[0].Recorded)
+	}
+	if string(ex[1].Request) != "c" || string(ex[1].Recorded) != "23" {
+		t.Errorf("exchange 1 = %q/%q", ex[1].Request, ex[1].Recorded)
+	}
+}
+
+func TestShapeAndCompleteTDS(t *testing.T) {
+	resp := tdsPacket(tds.PacketReply, []byte{byte(tds.TokenColMetadata), 0, 0})
+	if !tdsComplete(resp) {
+		t.Error("single EOM packet should be complete")
+	}
+	if tdsComplete(resp[:len(resp)-1]) {
+		t.Error("truncated packet should be incomplete")
+	}
+	shape := Shape(protocol.ProtocolTDS, resp)
+	if len(shape) != 1 || shape[0] != tds.PacketReply.String()+":0x81" {
+		t.Errorf("shape = %v", shape)
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.json")
+	sess := &Session{
+		Protocol: protocol.ProtocolPostgres,
+		Frames:   []Frame{{Dir: ClientToServer, Data: []byte{0, 1, 2}}},
+	}
+	if err := sess.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.Protocol != sess.Protocol || !bytes.Equal(loaded.ClientBytes(), []byte{0, 1, 2}) {
+		t.Errorf("round trip mismatch: %+v", loaded)
+	}
+}
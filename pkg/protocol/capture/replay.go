@@ -0,0 +1,241 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tds"
+)
+
+// ReplayOptions controls how a session is replayed.
+type ReplayOptions struct {
+	// ResponseTimeout bounds the wait for the first byte of each response.
+	ResponseTimeout time.Duration
+
+	// IdleTimeout ends a response when no more bytes arrive and the
+	// protocol framing cannot tell whether the response is complete.
+	IdleTimeout time.Duration
+}
+
+// DefaultReplayOptions returns options suitable for tests.
+func DefaultReplayOptions() ReplayOptions {
+	return ReplayOptions{
+		ResponseTimeout: 5 * time.Second,
+		IdleTimeout:     200 * time.Millisecond,
+	}
+}
+
+// Exchange is one client request and the server's responses to it.
+type Exchange struct {
+	Request  []byte
+	Recorded []byte // Response in the capture
+	Replayed []byte // Response from the server under test
+}
+
+// ReplayResult holds the outcome of a replay.
+type ReplayResult struct {
+	Protocol  protocol.ProtocolType
+	Exchanges []Exchange
+}
+
+// exchanges groups a session's frames into request/response pairs.
+func (s *Session) exchanges() []Exchange {
+	var out []Exchange
+	for _, f := range s.Frames {
+		switch f.Dir {
+		case ClientToServer:
+			if len(out) == 0 || len(out[len(out)-1].Recorded) > 0 {
+				out = append(out, Exchange{})
+			}
+			out[len(out)-1].Request = append(out[len(out)-1].Request, f.Data...)
+		case ServerToClient:
+			if len(out) == 0 {
+				// Server spoke first (e.g. a greeting); nothing to send
+				out = append(out, Exchange{})
+			}
+			out[len(out)-1].Recorded = append(out[len(out)-1].Recorded, f.Data...)
+		}
+	}
+	return out
+}
+
+// Replay sends the client side of sess over conn and records the server's
+// responses. The caller owns conn and must close it.
+func Replay(conn net.Conn, sess *Session, opts ReplayOptions) (*ReplayResult, error) {
+	if opts.ResponseTimeout <= 0 {
+		opts.ResponseTimeout = DefaultReplayOptions().ResponseTimeout
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultReplayOptions().IdleTimeout
+	}
+
+	result := &ReplayResult{Protocol: sess.Protocol}
+	complete := completers[sess.Protocol]
+
+	for i, ex := range sess.exchanges() {
+		if len(ex.Request) > 0 {
+			conn.SetWriteDeadline(time.Now().Add(opts.ResponseTimeout))
+			if _, err := conn.Write(ex.Request); err != nil {
+				return result, fmt.Errorf("exchange %d: writing request: %w", i, err)
+			}
+		}
+
+		if len(ex.Recorded) > 0 {
+			resp, err := readResponse(conn, complete, opts)
+			ex.Replayed = resp
+			if err != nil {
+				result.Exchanges = append(result.Exchanges, ex)
+				return result, fmt.Errorf("exchange %d: reading response: %w", i, err)
+			}
+		}
+		result.Exchanges = append(result.Exchanges, ex)
+	}
+
+	return result, nil
+}
+
+// readResponse reads until the protocol reports a complete response, or
+// until the connection goes quiet for IdleTimeout after the first byte.
+func readResponse(conn net.Conn, complete func([]byte) bool, opts ReplayOptions) ([]byte, error) {
+	var resp []byte
+	buf := make([]byte, 32*1024)
+	deadline := opts.ResponseTimeout
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(deadline))
+		n, err := conn.Read(buf)
+		resp = append(resp, buf[:n]...)
+
+		if complete != nil && len(resp) > 0 && complete(resp) {
+			return resp, nil
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) && len(resp) > 0 {
+				return resp, nil
+			}
+			if err == io.EOF && len(resp) > 0 {
+				return resp, nil
+			}
+			return resp, err
+		}
+		deadline = opts.IdleTimeout
+	}
+}
+
+// completers report whether a buffered server response is complete.
+var completers = map[protocol.ProtocolType]func([]byte) bool{
+	protocol.ProtocolTDS:      tdsComplete,
+	protocol.ProtocolPostgres: postgresComplete,
+}
+
+// tdsComplete reports whether data ends with a packet carrying EOM.
+func tdsComplete(data []byte) bool {
+	pos := 0
+	last := byte(0)
+	for pos+tds.HeaderSize <= len(data) {
+		pktLen := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if pktLen < tds.HeaderSize {
+			return false
+		}
+		last = data[pos+1]
+		pos += pktLen
+	}
+	return pos == len(data) && tds.PacketStatus(last)&tds.StatusEOM != 0
+}
+
+// postgresComplete reports whether data ends with ReadyForQuery, an
+// authentication request that needs a client reply, or an SSL response.
+func postgresComplete(data []byte) bool {
+	if len(data) == 1 && (data[0] == 'N' || data[0] == 'S') {
+		return true
+	}
+	pos := 0
+	var lastType byte
+	var lastBody []byte
+	for pos+5 <= len(data) {
+		msgLen := int(binary.BigEndian.Uint32(data[pos+1 : pos+5]))
+		if msgLen < 4 || pos+1+msgLen > len(data) {
+			return false
+		}
+		lastType = data[pos]
+		lastBody = data[pos+5 : pos+1+msgLen]
+		pos += 1 + msgLen
+	}
+	if pos != len(data) {
+		return false
+	}
+	switch lastType {
+	case 'Z':
+		return true
+	case 'R':
+		// AuthenticationOk (0) is followed by more messages
+		return len(lastBody) >= 4 && binary.BigEndian.Uint32(lastBody[:4]) != 0
+	}
+	return false
+}
+
+// Shape summarises a response so that recorded and replayed responses can
+// be compared without tripping over timestamps, SPIDs or row values.
+//
+// For TDS it lists each packet type followed by the first token of the
+// message (e.g. "REPLY:0x81" for COLMETADATA, "REPLY:0xaa" for ERROR).
+// For PostgreSQL it lists the message type letters.
+func Shape(proto protocol.ProtocolType, data []byte) []string {
+	var shape []string
+	switch proto {
+	case protocol.ProtocolTDS:
+		pos := 0
+		startOfMessage := true
+		for pos+tds.HeaderSize <= len(data) {
+			pktType := tds.PacketType(data[pos])
+			status := tds.PacketStatus(data[pos+1])
+			pktLen := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+			if pktLen < tds.HeaderSize || pos+pktLen > len(data) {
+				break
+			}
+			if startOfMessage {
+				entry := pktType.String()
+				if pktLen > tds.HeaderSize && pktType == tds.PacketReply {
+					entry += fmt.Sprintf(":0x%02x", data[pos+tds.HeaderSize])
+				}
+				shape = append(shape, entry)
+			}
+			startOfMessage = status&tds.StatusEOM != 0
+			pos += pktLen
+		}
+	case protocol.ProtocolPostgres:
+		pos := 0
+		if len(data) == 1 {
+			return []string{string(data)}
+		}
+		for pos+5 <= len(data) {
+			msgLen := int(binary.BigEndian.Uint32(data[pos+1 : pos+5]))
+			if msgLen < 4 || pos+1+msgLen > len(data) {
+				break
+			}
+			shape = append(shape, string(data[pos]))
+			pos += 1 + msgLen
+		}
+	}
+	return shape
+}
+
+// Mismatches compares the shape of recorded and replayed responses and
+// returns a description of each exchange that differs.
+func (r *ReplayResult) Mismatches() []string {
+	var out []string
+	for i, ex := range r.Exchanges {
+		want := Shape(r.Protocol, ex.Recorded)
+		got := Shape(r.Protocol, ex.Replayed)
+		if fmt.Sprint(want) != fmt.Sprint(got) {
+			out = append(out, fmt.Sprintf("exchange %d: recorded %v, replayed %v", i, want, got))
+		}
+	}
+	return out
+}
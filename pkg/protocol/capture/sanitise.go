@@ -0,0 +1,97 @@
+package capture
+
+import (
+	"encoding/binary"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tds"
+)
+
+// sanitiser rewrites a client frame with credentials masked. It must not
+// change the length of the frame so that replayed framing stays valid.
+type sanitiser func(data []byte) []byte
+
+var sanitisers = map[protocol.ProtocolType]sanitiser{
+	protocol.ProtocolTDS:      sanitiseTDS,
+	protocol.ProtocolPostgres: sanitisePostgres,
+}
+
+// maskChar replaces each character of a secret.
+const maskChar = '*'
+
+// sanitiseTDS masks the password in LOGIN7 packets. The masked password is
+// re-obfuscated so the login still parses on replay.
+func sanitiseTDS(data []byte) []byte {
+	out := append([]byte(nil), data...)
+	for pos := 0; pos+tds.HeaderSize <= len(out); {
+		pktLen := int(binary.BigEndian.Uint16(out[pos+2 : pos+4]))
+		if pktLen < tds.HeaderSize || pos+pktLen > len(out) {
+			break
+		}
+		if tds.PacketType(out[pos]) == tds.PacketLogin7 {
+			maskLogin7Password(out[pos+tds.HeaderSize : pos+pktLen])
+		}
+		pos += pktLen
+	}
+	return out
+}
+
+// maskLogin7Password overwrites the obfuscated password in a LOGIN7 payload.
+func maskLogin7Password(login []byte) {
+	if len(login) < tds.Login7HeaderSize {
+		return
+	}
+	offset := int(binary.LittleEndian.Uint16(login[44:46]))
+	length := int(binary.LittleEndian.Uint16(login[46:48])) * 2
+	if length == 0 || offset+length > len(login) {
+		return
+	}
+	for i := 0; i < length; i += 2 {
+		login[offset+i] = obfuscate(maskChar)
+		login[offset+i+1] = obfuscate(0)
+	}
+}
+
+// obfuscate applies the LOGIN7 password mangling (nibble swap, XOR 0xA5).
+func obfuscate(b byte) byte {
+	return ((b >> 4) | (b << 4)) ^ 0xA5
+}
+
+// sanitisePostgres masks PasswordMessage ('p') bodies, which carry
+// cleartext or MD5 passwords and SASL responses.
+func sanitisePostgres(data []byte) []byte {
+	out := append([]byte(nil), data...)
+	pos := 0
+
+	// Untyped startup packets (StartupMessage, SSLRequest, CancelRequest)
+	// begin with a length and contain no secrets; skip them.
+	for pos+8 <= len(out) {
+		code := binary.BigEndian.Uint32(out[pos+4 : pos+8])
+		if code != 196608 && code != 80877103 && code != 80877102 && code != 80877104 {
+			break
+		}
+		msgLen := int(binary.BigEndian.Uint32(out[pos : pos+4]))
+		if msgLen < 8 || pos+msgLen > len(out) {
+			return out
+		}
+		pos += msgLen
+	}
+
+	for pos+5 <= len(out) {
+		msgType := out[pos]
+		msgLen := int(binary.BigEndian.Uint32(out[pos+1 : pos+5]))
+		if msgLen < 4 || pos+1+msgLen > len(out) {
+			break
+		}
+		if msgType == 'p' {
+			body := out[pos+5 : pos+1+msgLen]
+			for i := range body {
+				if body[i] != 0 {
+					body[i] = maskChar
+				}
+			}
+		}
+		pos += 1 + msgLen
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// peerIdentity is who a client on a Unix socket with peer authentication
+// is: its OS user and the principal that user connects as, "" when it
+// may not connect.
+type peerIdentity struct {
+	peer      protocol.Peer
+	principal string
+}
+
+// peerAuthInfo carries a connection's peerIdentity to its calls.
+type peerAuthInfo struct {
+	credentials.CommonAuthInfo
+	id peerIdentity
+}
+
+func (peerAuthInfo) AuthType() string { return "peer" }
+
+// peerCredentials are the transport credentials of a Unix socket under
+// peer authentication: they read the credentials of each connection's
+// peer once, as the connection opens.
+type peerCredentials struct {
+	peers *protocol.PeerMap
+}
+
+func (c peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	info := peerAuthInfo{CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}}
+	if p, err := protocol.PeerOf(conn); err == nil {
+		principal, _ := c.peers.Principal(p.User, "")
+		info.id = peerIdentity{peer: p, principal: principal}
+	}
+	return conn, info, nil
+}
+
+func (c peerCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("peer credentials are for servers only")
+}
+
+func (c peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peer"}
+}
+
+func (c peerCredentials) Clone() credentials.TransportCredentials { return c }
+
+func (c peerCredentials) OverrideServerName(string) error { return nil }
+
+// callPeer returns the identity of a call's client under peer
+// authentication.
+func callPeer(ctx context.Context) (peerIdentity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return peerIdentity{}, false
+	}
+	info, ok := p.AuthInfo.(peerAuthInfo)
+	return info.id, ok
+}
+
+// requirePeer refuses calls of the Aul service from clients whose OS user
+// may not connect. The health and reflection services stay open, so that
+// their callers need not be database users.
+func requirePeer(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if strings.HasPrefix(info.FullMethod, "/"+serviceName+"/") {
+		if id, ok := callPeer(ss.Context()); !ok || id.principal == "" {
+			return status.Error(codes.PermissionDenied, "peer authentication failed")
+		}
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,366 @@
+// Package grpc implements a gRPC API for aul, the Aul service of
+// aulpb/aul.proto, with the standard health and reflection services so
+// that tools such as grpcurl work without the proto file.
+//
+// Each call is a pseudo-connection running one request, as with the HTTP
+// API. Result sets are streamed back a row per message.
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/protocol/grpc/aulpb"
+)
+
+// serviceName is the name the Aul service reports its health under.
+const serviceName = "aul.v1.Aul"
+
+// Listener implements protocol.Listener for the gRPC API.
+type Listener struct {
+	mu sync.Mutex
+
+	cfg      protocol.ListenerConfig
+	logger   *log.Logger
+	server   *grpc.Server
+	health   *health.Server
+	listener net.Listener
+
+	// Calls waiting for the server to run them
+	reqChan chan *grpcRequest
+
+	// Connection tracking
+	connCount int64
+
+	// Lifecycle
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+}
+
+// grpcRequest is a call waiting for its result.
+type grpcRequest struct {
+	ctx      context.Context // Of the call, done when the client cancels it
+	req      protocol.Request
+	respChan chan protocol.Result
+	done     chan struct{}
+}
+
+// NewListener creates a new gRPC protocol listener.
+func NewListener(cfg protocol.ListenerConfig, logger *log.Logger) (*Listener, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	l := &Listener{
+		cfg:     cfg,
+		logger:  logger,
+		reqChan: make(chan *grpcRequest, 100),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	var opts []grpc.ServerOption
+	switch {
+	case cfg.PeerAuth != nil:
+		opts = append(opts, grpc.Creds(peerCredentials{peers: cfg.PeerAuth}),
+			grpc.StreamInterceptor(requirePeer))
+	case cfg.TLSEnabled:
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+	}
+
+	l.server = grpc.NewServer(opts...)
+	aulpb.RegisterAulServer(l.server, &service{l: l})
+	l.health = health.NewServer()
+	l.health.SetServingStatus(serviceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(l.server, l.health)
+	reflection.Register(l.server)
+
+	return l, nil
+}
+
+// Protocol returns the protocol type.
+func (l *Listener) Protocol() protocol.ProtocolType {
+	return protocol.ProtocolGRPC
+}
+
+// Listen starts listening on the configured address.
+func (l *Listener) Listen() error {
+	var err error
+	l.listener, err = protocol.Listen(l.cfg)
+	if err != nil {
+		return err
+	}
+
+	l.logger.Protocol().Info("gRPC listener started",
+		"address", protocol.FormatAddrs(l.Addrs()),
+	)
+
+	go func() {
+		if err := l.server.Serve(l.listener); err != nil && err != grpc.ErrServerStopped {
+			l.logger.Protocol().Error("gRPC server error", err)
+		}
+	}()
+
+	return nil
+}
+
+// Accept waits for and returns the next connection.
+// For gRPC, this returns a pseudo-connection for each call.
+func (l *Listener) Accept() (protocol.Connection, error) {
+	select {
+	case <-l.ctx.Done():
+		return nil, io.EOF
+	case req := <-l.reqChan:
+		atomic.AddInt64(&l.connCount, 1)
+		return &grpcConn{req: req, listener: l}, nil
+	}
+}
+
+// Close stops the listener, letting running calls finish for up to five
+// seconds.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	l.cancel()
+	l.health.Shutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		l.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		l.server.Stop()
+	}
+	return nil
+}
+
+// Addrs returns every address the listener is bound to.
+func (l *Listener) Addrs() []net.Addr {
+	return protocol.Addrs(l.listener)
+}
+
+// Addr returns the listener's network address.
+func (l *Listener) Addr() net.Addr {
+	if l.listener == nil {
+		return nil
+	}
+	return l.listener.Addr()
+}
+
+// ConnectionCount returns the number of active connections.
+func (l *Listener) ConnectionCount() int {
+	return int(atomic.LoadInt64(&l.connCount))
+}
+
+// service implements the Aul service.
+type service struct {
+	aulpb.UnimplementedAulServer
+	l *Listener
+}
+
+func (s *service) ExecuteQuery(in *aulpb.QueryRequest, stream aulpb.Aul_ExecuteQueryServer) error {
+	if strings.TrimSpace(in.GetSql()) == "" {
+		return status.Error(codes.InvalidArgument, "sql is required")
+	}
+	params, err := parameters(in.GetParameters())
+	if err != nil {
+		return err
+	}
+	return s.l.execute(stream, protocol.Request{
+		Type:       protocol.RequestQuery,
+		SQL:        in.GetSql(),
+		Parameters: params,
+		Options:    requestOptions(in.GetOptions(), len(params) > 0),
+	})
+}
+
+func (s *service) ExecuteProcedure(in *aulpb.ProcedureRequest, stream aulpb.Aul_ExecuteProcedureServer) error {
+	if strings.TrimSpace(in.GetProcedure()) == "" {
+		return status.Error(codes.InvalidArgument, "procedure is required")
+	}
+	params, err := parameters(in.GetParameters())
+	if err != nil {
+		return err
+	}
+	return s.l.execute(stream, protocol.Request{
+		Type:          protocol.RequestExec,
+		ProcedureName: in.GetProcedure(),
+		Parameters:    params,
+		Options:       requestOptions(in.GetOptions(), false),
+	})
+}
+
+// requestOptions returns the options of a request.
+func requestOptions(opts *aulpb.ExecuteOptions, parameterized bool) protocol.RequestOptions {
+	return protocol.RequestOptions{
+		DryRun:        opts.GetDryRun(),
+		Summary:       opts.GetSummary(),
+		Trace:         opts.GetTrace(),
+		Parameterized: parameterized,
+	}
+}
+
+// execute hands req to the server and streams its result back. The
+// call's deadline becomes the request's timeout.
+func (l *Listener) execute(stream grpc.ServerStreamingServer[aulpb.ExecuteResponse], req protocol.Request) error {
+	ctx := stream.Context()
+	req.ID = requestID(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Options.Timeout = time.Until(deadline)
+	}
+	stream.SetHeader(metadata.Pairs("x-request-id", req.ID))
+
+	call := &grpcRequest{
+		ctx:      ctx,
+		req:      req,
+		respChan: make(chan protocol.Result, 1),
+		done:     make(chan struct{}),
+	}
+	defer close(call.done)
+
+	select {
+	case l.reqChan <- call:
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-l.ctx.Done():
+		return status.Error(codes.Unavailable, "server shutting down")
+	case <-time.After(5 * time.Second):
+		return status.Error(codes.Unavailable, "server busy")
+	}
+
+	select {
+	case result := <-call.respChan:
+		return writeResult(stream, result)
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// requestID returns the trace id of a W3C traceparent the client sent,
+// else its x-request-id, else a new id.
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("traceparent"); len(v) > 0 {
+		if parts := strings.Split(v[0], "-"); len(parts) == 4 && len(parts[1]) == 32 {
+			return parts[1]
+		}
+	}
+	if v := md.Get("x-request-id"); len(v) > 0 && v[0] != "" {
+		return v[0]
+	}
+	return protocol.NewRequestID()
+}
+
+// grpcConn implements protocol.Connection for a call.
+type grpcConn struct {
+	mu       sync.Mutex
+	req      *grpcRequest
+	listener *Listener
+	closed   bool
+	gotReq   bool
+}
+
+// ReadRequest returns the call's request, then io.EOF.
+func (c *grpcConn) ReadRequest() (protocol.Request, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.gotReq {
+		return protocol.Request{}, io.EOF
+	}
+	c.gotReq = true
+	return c.req.req, nil
+}
+
+// SendResult sends a result to the client.
+func (c *grpcConn) SendResult(result protocol.Result) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return io.EOF
+	}
+	select {
+	case c.req.respChan <- result:
+		return nil
+	case <-c.req.done:
+		return io.EOF
+	}
+}
+
+// Close closes the connection.
+func (c *grpcConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	atomic.AddInt64(&c.listener.connCount, -1)
+	return nil
+}
+
+// WatchCancel calls cancel when the client cancels the call or its
+// deadline passes.
+func (c *grpcConn) WatchCancel(cancel func()) (stop func()) {
+	stopWatch := context.AfterFunc(c.req.ctx, cancel)
+	return func() { stopWatch() }
+}
+
+// RemoteAddr returns the remote address.
+func (c *grpcConn) RemoteAddr() net.Addr {
+	if p, ok := peer.FromContext(c.req.ctx); ok && p.Addr != nil {
+		return p.Addr
+	}
+	return &net.TCPAddr{}
+}
+
+// SetDeadline sets the read/write deadline.
+func (c *grpcConn) SetDeadline(t time.Time) error {
+	// A call's deadline is set by the client
+	return nil
+}
+
+// Properties returns connection properties: the user of a client
+// authenticated by its OS user.
+func (c *grpcConn) Properties() map[string]string {
+	props := make(map[string]string)
+	if id, ok := callPeer(c.req.ctx); ok {
+		props["user"] = id.principal
+		props["os_user"] = id.peer.User
+	}
+	return props
+}
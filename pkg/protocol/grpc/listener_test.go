@@ -0,0 +1,219 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/protocol/grpc/aulpb"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// serve starts a listener whose requests are answered by respond, as the
+// server would, and returns a client of it.
+func serve(t *testing.T, respond func(protocol.Request) protocol.Result) *grpc.ClientConn {
+	t.Helper()
+	cfg := protocol.DefaultListenerConfig(protocol.ProtocolGRPC)
+	cfg.Host, cfg.Port = "127.0.0.1", 0
+	l, err := NewListener(cfg, log.New(log.Config{Output: io.Discard}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, _ := conn.ReadRequest()
+			conn.SendResult(respond(req))
+			conn.Close()
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+
+	cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+// receive reads a call's stream to its end.
+func receive(stream grpc.ServerStreamingClient[aulpb.ExecuteResponse]) ([]*aulpb.ExecuteResponse, error) {
+	var msgs []*aulpb.ExecuteResponse
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestExecuteQuery(t *testing.T) {
+	got := make(chan protocol.Request, 1)
+	cc := serve(t, func(req protocol.Request) protocol.Result {
+		got <- req
+		return protocol.Result{
+			Type: protocol.ResultRows,
+			ResultSets: []protocol.ResultSet{{
+				Columns: []protocol.ColumnInfo{{Name: "id", Type: "INT"}, {Name: "name"}},
+				Rows:    [][]interface{}{{int32(1), "a"}, {int64(2), nil}},
+			}},
+			RowsAffected: 2,
+			Statements:   []protocol.StatementSummary{{Type: "SELECT", Statement: "SELECT id, name FROM t", Executions: 1}},
+		}
+	})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "client-42")
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var header metadata.MD
+	stream, err := aulpb.NewAulClient(cc).ExecuteQuery(ctx, &aulpb.QueryRequest{
+		Sql: "SELECT id, name FROM t WHERE id <= @max AND price < @price",
+		Parameters: map[string]*aulpb.Value{
+			"max":   {Kind: &aulpb.Value_IntValue{IntValue: 2}},
+			"price": {Kind: &aulpb.Value_DecimalValue{DecimalValue: "9.95"}},
+			"none":  {},
+		},
+		Options: &aulpb.ExecuteOptions{Summary: true},
+	}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := receive(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-got
+	if req.Type != protocol.RequestQuery || req.ID != "client-42" || !req.Options.Summary || !req.Options.Parameterized {
+		t.Errorf("request = %+v", req)
+	}
+	if req.Options.Timeout <= 0 || req.Options.Timeout > 10*time.Second {
+		t.Errorf("timeout = %v, want the call's deadline", req.Options.Timeout)
+	}
+	price, _ := req.Parameters["price"].(tsqlruntime.Value)
+	if req.Parameters["max"] != int64(2) || price.Type != tsqlruntime.TypeDecimal || price.AsString() != "9.95" || req.Parameters["none"] != nil {
+		t.Errorf("parameters = %v", req.Parameters)
+	}
+	if ids := header.Get("x-request-id"); len(ids) != 1 || ids[0] != "client-42" {
+		t.Errorf("x-request-id = %v", ids)
+	}
+
+	if len(msgs) != 4 {
+		t.Fatalf("got %d messages, want columns, two rows and done: %v", len(msgs), msgs)
+	}
+	if cols := msgs[0].GetColumns().GetColumns(); len(cols) != 2 || cols[0].Name != "id" || cols[0].Type != "int" {
+		t.Errorf("columns = %v", cols)
+	}
+	if row := msgs[1].GetRow().GetValues(); len(row) != 2 || row[0].GetIntValue() != 1 || row[1].GetStringValue() != "a" {
+		t.Errorf("row 1 = %v", row)
+	}
+	if row := msgs[2].GetRow().GetValues(); len(row) != 2 || row[0].GetIntValue() != 2 || row[1].GetKind() != nil {
+		t.Errorf("row 2 = %v, want a NULL name", row)
+	}
+	done := msgs[3].GetDone()
+	if done.GetRowsAffected() != 2 || len(done.GetStatements()) != 1 || done.GetStatements()[0].Type != "SELECT" {
+		t.Errorf("done = %v", done)
+	}
+}
+
+func TestExecuteProcedureError(t *testing.T) {
+	cc := serve(t, func(req protocol.Request) protocol.Result {
+		if req.ProcedureName == "dbo.Missing" {
+			return protocol.Result{Type: protocol.ResultError, Error: aulerrors.New(aulerrors.ErrCodeProcNotFound, "procedure not found").Err(), RequestID: req.ID}
+		}
+		// Result sets returned before the error still reach the client
+		return protocol.Result{
+			Type:       protocol.ResultError,
+			Error:      errors.New("boom"),
+			RequestID:  req.ID,
+			ResultSets: []protocol.ResultSet{{Columns: []protocol.ColumnInfo{{Name: "n"}}, Rows: [][]interface{}{{1}}}},
+		}
+	})
+	client := aulpb.NewAulClient(cc)
+
+	for _, tc := range []struct {
+		procedure string
+		code      codes.Code
+		messages  int
+	}{
+		{"dbo.Missing", codes.NotFound, 0},
+		{"dbo.Fails", codes.Unknown, 2},
+	} {
+		var trailer metadata.MD
+		stream, err := client.ExecuteProcedure(context.Background(), &aulpb.ProcedureRequest{Procedure: tc.procedure}, grpc.Trailer(&trailer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs, err := receive(stream)
+		if status.Code(err) != tc.code || len(msgs) != tc.messages {
+			t.Errorf("%s: %d messages, %v; want %d, %v", tc.procedure, len(msgs), err, tc.messages, tc.code)
+		}
+		if ids := trailer.Get("x-request-id"); len(ids) != 1 || len(ids[0]) != 32 {
+			t.Errorf("%s: x-request-id = %v", tc.procedure, ids)
+		}
+	}
+
+	// Requests missing what they run are refused before reaching the server
+	stream, err := client.ExecuteQuery(context.Background(), &aulpb.QueryRequest{})
+	if err == nil {
+		_, err = receive(stream)
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("empty query: %v, want InvalidArgument", err)
+	}
+}
+
+func TestHealthAndReflection(t *testing.T) {
+	cc := serve(t, func(req protocol.Request) protocol.Result { return protocol.Result{} })
+	ctx := context.Background()
+
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: serviceName})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health = %v, %v", resp, err)
+	}
+
+	stream, err := reflectionpb.NewServerReflectionClient(cc).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, s := range reply.GetListServicesResponse().GetService() {
+		found = found || s.Name == serviceName
+	}
+	if !found {
+		t.Errorf("services = %v, want %s", reply.GetListServicesResponse().GetService(), serviceName)
+	}
+}
@@ -0,0 +1,209 @@
+package grpc
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/protocol/grpc/aulpb"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// writeResult streams result to the client: a Columns message and a Row
+// per row for each result set, then a Done message, or an error status if
+// the execution failed.
+func writeResult(stream grpc.ServerStreamingServer[aulpb.ExecuteResponse], result protocol.Result) error {
+	for _, rs := range result.ResultSets {
+		head := &aulpb.Columns{Columns: make([]*aulpb.Column, len(rs.Columns))}
+		for j, col := range rs.Columns {
+			head.Columns[j] = &aulpb.Column{Name: col.Name, Type: strings.ToLower(col.Type)}
+			if s := col.Sensitivity; s != nil {
+				head.Columns[j].Sensitivity = &aulpb.Sensitivity{
+					Label:             s.Label,
+					LabelId:           s.LabelID,
+					InformationType:   s.InformationType,
+					InformationTypeId: s.InformationTypeID,
+					Rank:              s.Rank,
+				}
+			}
+		}
+		if err := stream.Send(&aulpb.ExecuteResponse{Kind: &aulpb.ExecuteResponse_Columns{Columns: head}}); err != nil {
+			return err
+		}
+		for _, row := range rs.Rows {
+			values := make([]*aulpb.Value, len(row))
+			for j, v := range row {
+				values[j] = toValue(v)
+			}
+			if err := stream.Send(&aulpb.ExecuteResponse{Kind: &aulpb.ExecuteResponse_Row{Row: &aulpb.Row{Values: values}}}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if result.Error != nil || result.Type == protocol.ResultError || result.Type == protocol.ResultCancel {
+		if result.RequestID != "" {
+			stream.SetTrailer(metadata.Pairs("x-request-id", result.RequestID))
+		}
+		return errorStatus(result)
+	}
+
+	done := &aulpb.Done{
+		RowsAffected: result.RowsAffected,
+		Message:      result.Message,
+		Warnings:     result.Warnings,
+		TraceId:      result.TraceID,
+	}
+	if result.ReturnValue != nil {
+		done.ReturnValue = toValue(result.ReturnValue)
+	}
+	if len(result.OutputParams) > 0 {
+		done.OutputParameters = make(map[string]*aulpb.Value, len(result.OutputParams))
+		for name, v := range result.OutputParams {
+			done.OutputParameters[name] = toValue(v)
+		}
+	}
+	for _, s := range result.Statements {
+		done.Statements = append(done.Statements, &aulpb.StatementSummary{
+			Type:         s.Type,
+			Target:       s.Target,
+			Statement:    s.Statement,
+			Executions:   s.Executions,
+			RowsAffected: s.RowsAffected,
+			DurationMs:   float64(s.Duration.Microseconds()) / 1000,
+		})
+	}
+	return stream.Send(&aulpb.ExecuteResponse{Kind: &aulpb.ExecuteResponse_Done{Done: done}})
+}
+
+// errorStatus returns the status a failed result ends its call with, its
+// code chosen as the HTTP API chooses a status.
+func errorStatus(result protocol.Result) error {
+	if result.Type == protocol.ResultCancel {
+		return status.Error(codes.Canceled, "query cancelled")
+	}
+	err := result.Error
+	if err == nil {
+		return status.Error(codes.Unknown, result.Message)
+	}
+	code := aulerrors.GetCode(err)
+	if sqlErr := aulerrors.FindSQLError(err); sqlErr != nil {
+		code = sqlErr.Code
+	}
+	switch code {
+	case aulerrors.ErrCodeExecConcurrency, aulerrors.ErrCodeExecCircuitOpen:
+		// Transient: the client should back off and retry
+		return status.Error(codes.Unavailable, err.Error())
+	case aulerrors.ErrCodeExecDenied:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case aulerrors.ErrCodeExecTimeout:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case aulerrors.ErrCodeExecCancelled:
+		return status.Error(codes.Canceled, err.Error())
+	case aulerrors.ErrCodeProcNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case aulerrors.ErrCodeProcInvalidParam, aulerrors.ErrCodeProcMissingParam:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case aulerrors.ErrCodeResourceExhausted:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// parameters returns the values of a request's parameters.
+func parameters(in map[string]*aulpb.Value) (map[string]interface{}, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	params := make(map[string]interface{}, len(in))
+	for name, v := range in {
+		value, err := fromValue(v)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %s: %v", name, err)
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+// toValue converts a value of a result to a Value.
+func toValue(v interface{}) *aulpb.Value {
+	switch x := v.(type) {
+	case nil:
+		return &aulpb.Value{}
+	case bool:
+		return &aulpb.Value{Kind: &aulpb.Value_BoolValue{BoolValue: x}}
+	case int:
+		return &aulpb.Value{Kind: &aulpb.Value_IntValue{IntValue: int64(x)}}
+	case uint8:
+		return &aulpb.Value{Kind: &aulpb.Value_IntValue{IntValue: int64(x)}}
+	case int16:
+		return &aulpb.Value{Kind: &aulpb.Value_IntValue{IntValue: int64(x)}}
+	case int32:
+		return &aulpb.Value{Kind: &aulpb.Value_IntValue{IntValue: int64(x)}}
+	case int64:
+		return &aulpb.Value{Kind: &aulpb.Value_IntValue{IntValue: x}}
+	case float32:
+		return &aulpb.Value{Kind: &aulpb.Value_DoubleValue{DoubleValue: float64(x)}}
+	case float64:
+		return &aulpb.Value{Kind: &aulpb.Value_DoubleValue{DoubleValue: x}}
+	case decimal.Decimal:
+		return &aulpb.Value{Kind: &aulpb.Value_DecimalValue{DecimalValue: x.String()}}
+	case string:
+		return &aulpb.Value{Kind: &aulpb.Value_StringValue{StringValue: x}}
+	case []byte:
+		return &aulpb.Value{Kind: &aulpb.Value_BytesValue{BytesValue: x}}
+	case time.Time:
+		return &aulpb.Value{Kind: &aulpb.Value_TimeValue{TimeValue: timestamppb.New(x)}}
+	}
+	return &aulpb.Value{Kind: &aulpb.Value_StringValue{StringValue: fmt.Sprint(v)}}
+}
+
+// fromValue converts a Value a client sent to the Go value of a
+// parameter.
+func fromValue(v *aulpb.Value) (interface{}, error) {
+	switch k := v.GetKind().(type) {
+	case nil:
+		return nil, nil
+	case *aulpb.Value_BoolValue:
+		return k.BoolValue, nil
+	case *aulpb.Value_IntValue:
+		return k.IntValue, nil
+	case *aulpb.Value_DoubleValue:
+		if math.IsNaN(k.DoubleValue) || math.IsInf(k.DoubleValue, 0) {
+			return nil, fmt.Errorf("%v is not a SQL number", k.DoubleValue)
+		}
+		return k.DoubleValue, nil
+	case *aulpb.Value_DecimalValue:
+		d, err := decimal.NewFromString(k.DecimalValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid decimal %q", k.DecimalValue)
+		}
+		// A decimal.Decimal would be taken for text
+		scale := 0
+		if exp := d.Exponent(); exp < 0 {
+			scale = int(-exp)
+		}
+		return tsqlruntime.NewDecimal(d, 38, min(scale, 38)), nil
+	case *aulpb.Value_StringValue:
+		return k.StringValue, nil
+	case *aulpb.Value_BytesValue:
+		return k.BytesValue, nil
+	case *aulpb.Value_TimeValue:
+		if err := k.TimeValue.CheckValid(); err != nil {
+			return nil, err
+		}
+		return k.TimeValue.AsTime(), nil
+	}
+	return nil, fmt.Errorf("unsupported value %T", v.GetKind())
+}
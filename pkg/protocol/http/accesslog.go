@@ -0,0 +1,198 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// Listener options configuring the access log. The lists are []string.
+const (
+	OptionAccessLog       = "access_log"        // bool: log each request
+	OptionAccessLogRedact = "access_log_redact" // parameter names whose values are masked
+	OptionAccessLogSample = "access_log_sample" // float64: share of successful requests logged
+	OptionAccessLogSkip   = "access_log_skip"   // routes never logged, e.g. /health
+)
+
+// DefaultRedactedParams are masked in access logs unless the listener
+// configures its own list.
+var DefaultRedactedParams = []string{"password", "pwd", "secret", "token", "api_key", "apikey"}
+
+// redactedValue replaces the value of a redacted parameter.
+const redactedValue = "****"
+
+// maxLoggedBody is the most of a request body read to find its parameters.
+const maxLoggedBody = 1 << 20
+
+// accessLogConfig is the access log configuration of a listener.
+type accessLogConfig struct {
+	redact map[string]bool // lower case, without a leading @
+	sample float64
+	skip   map[string]bool
+}
+
+// newAccessLogConfig reads the access log options of cfg, returning nil
+// when the access log is off.
+func newAccessLogConfig(cfg protocol.ListenerConfig) *accessLogConfig {
+	if on, _ := cfg.Options[OptionAccessLog].(bool); !on {
+		return nil
+	}
+	c := &accessLogConfig{redact: make(map[string]bool), sample: 1, skip: make(map[string]bool)}
+	redact, ok := cfg.Options[OptionAccessLogRedact].([]string)
+	if !ok {
+		redact = DefaultRedactedParams
+	}
+	for _, name := range redact {
+		c.redact[paramKey(name)] = true
+	}
+	if rate, ok := cfg.Options[OptionAccessLogSample].(float64); ok && rate >= 0 && rate < 1 {
+		c.sample = rate
+	}
+	skip, _ := cfg.Options[OptionAccessLogSkip].([]string)
+	for _, route := range skip {
+		c.skip[route] = true
+	}
+	return c
+}
+
+// paramKey is the name parameters are redacted by: @Password, password
+// and PASSWORD are the same parameter.
+func paramKey(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "@"))
+}
+
+// responseRecorder captures the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush passes a streamed response's rows on to the client.
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// accessLog wraps next, the listener's routes in mux, logging one entry
+// per request. Failed requests (status 400 and above) are always logged;
+// successful ones are sampled.
+func (l *Listener) accessLog(mux *http.ServeMux, next http.Handler, cfg *accessLogConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if cfg.skip[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		traceID := requestTraceID(r)
+		w.Header().Set("X-Request-ID", traceID)
+		params := cfg.params(r)
+
+		rec := &responseRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		duration := time.Since(start)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status < 400 && cfg.sample < 1 && mathrand.Float64() >= cfg.sample {
+			return
+		}
+		fields := []interface{}{
+			"method", r.Method,
+			"route", route,
+			"status", rec.status,
+			"duration_ms", float64(duration.Microseconds()) / 1000,
+			"bytes", rec.bytes,
+			"remote", r.RemoteAddr,
+			"trace_id", traceID,
+		}
+		if user, _, ok := r.BasicAuth(); ok {
+			fields = append(fields, "user", user)
+		} else if id, ok := requestPeer(r); ok {
+			fields = append(fields, "user", id.principal, "os_user", id.peer.User)
+		}
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			fields = append(fields, "api_key", maskKey(key))
+		}
+		if len(params) > 0 {
+			fields = append(fields, "params", params)
+		}
+		l.logger.Protocol().Info("HTTP request", fields...)
+	})
+}
+
+// requestTraceID returns the trace id of a W3C traceparent header, else
+// the client's X-Request-ID, else a new id.
+func requestTraceID(r *http.Request) string {
+	if parts := strings.Split(r.Header.Get("traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 {
+		return parts[1]
+	}
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	return protocol.NewRequestID()
+}
+
+// params returns the parameters of a request, from its query string and
+// the parameters of a JSON body, with redacted values masked. The body is
+// left for the handler to read.
+func (c *accessLogConfig) params(r *http.Request) map[string]interface{} {
+	params := make(map[string]interface{})
+	for name, values := range r.URL.Query() {
+		params[name] = strings.Join(values, ",")
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxLoggedBody))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		var req APIRequest
+		if err == nil && json.Unmarshal(body, &req) == nil {
+			for name, value := range req.Parameters {
+				params[name] = value
+			}
+		}
+	}
+	for name := range params {
+		if c.redact[paramKey(name)] {
+			params[name] = redactedValue
+		}
+	}
+	return params
+}
+
+// maskKey keeps the last four characters of an API key, enough to tell
+// keys apart without logging them.
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return redactedValue
+	}
+	return redactedValue + key[len(key)-4:]
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// accessLogListener returns a listener logging requests as JSON to buf.
+func accessLogListener(t *testing.T, buf *bytes.Buffer, options map[string]interface{}) *Listener {
+	t.Helper()
+	cfg := protocol.DefaultListenerConfig(protocol.ProtocolHTTP)
+	cfg.Options = options
+	l, err := NewListener(cfg, log.New(log.Config{Output: buf, Format: log.FormatJSON}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// accessLogEntries decodes the access log entries written to buf.
+func accessLogEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry struct {
+			Message string                 `json:"message"`
+			Fields  map[string]interface{} `json:"fields"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		if entry.Message == "HTTP request" {
+			entries = append(entries, entry.Fields)
+		}
+	}
+	return entries
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, map[string]interface{}{
+		OptionAccessLog:     true,
+		OptionAccessLogSkip: []string{"/health"},
+	})
+
+	// Answer /exec, checking the handler still sees the whole body
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		req, err := conn.ReadRequest()
+		result := protocol.Result{Type: protocol.ResultOK, Message: req.ProcedureName}
+		if err != nil || req.Parameters["@Password"] != "hunter2" {
+			result = protocol.Result{Type: protocol.ResultError, Error: err}
+		}
+		conn.SendResult(result)
+		conn.Close()
+	}()
+
+	body := `{"procedure": "dbo.Login", "parameters": {"@User": "ada", "@Password": "hunter2"}}`
+	r := httptest.NewRequest(http.MethodPost, "/exec?tenant=acme&token=abc", strings.NewReader(body))
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set("X-API-Key", "sk-live-0123456789abcd")
+	r.SetBasicAuth("ada", "secret")
+	w := httptest.NewRecorder()
+	l.httpServer.Handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "dbo.Login") {
+		t.Fatalf("exec answered %d %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Request-ID"); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("X-Request-ID = %q", got)
+	}
+
+	for _, path := range []string{"/health", "/missing"} {
+		l.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	entries := accessLogEntries(t, &buf)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2 (/health skipped): %s", len(entries), buf.String())
+	}
+	exec := entries[0]
+	for field, want := range map[string]interface{}{
+		"method":   "POST",
+		"route":    "/exec",
+		"status":   float64(200),
+		"user":     "ada",
+		"api_key":  "****abcd",
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+	} {
+		if exec[field] != want {
+			t.Errorf("%s = %v, want %v", field, exec[field], want)
+		}
+	}
+	if exec["bytes"].(float64) != float64(w.Body.Len()) {
+		t.Errorf("bytes = %v, want %d", exec["bytes"], w.Body.Len())
+	}
+	params, _ := exec["params"].(map[string]interface{})
+	for name, want := range map[string]interface{}{
+		"@User": "ada", "@Password": "****", "tenant": "acme", "token": "****",
+	} {
+		if params[name] != want {
+			t.Errorf("params[%s] = %v, want %v", name, params[name], want)
+		}
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("password logged: %s", buf.String())
+	}
+
+	missing := entries[1]
+	if missing["route"] != "/" || missing["status"] != float64(404) || len(missing["trace_id"].(string)) != 32 {
+		t.Errorf("unknown path logged as %v", missing)
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, map[string]interface{}{
+		OptionAccessLog:       true,
+		OptionAccessLogSample: 0.0,
+		OptionAccessLogRedact: []string{"Tenant"},
+	})
+	for _, path := range []string{"/health", "/missing?tenant=acme"} {
+		l.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	entries := accessLogEntries(t, &buf)
+	if len(entries) != 1 || entries[0]["status"] != float64(404) {
+		t.Fatalf("want only the failed request logged, got %v", entries)
+	}
+	if params := entries[0]["params"].(map[string]interface{}); params["tenant"] != "****" {
+		t.Errorf("tenant = %v, want it redacted", params["tenant"])
+	}
+}
+
+func TestAccessLogOff(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, nil)
+	w := httptest.NewRecorder()
+	l.httpServer.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if entries := accessLogEntries(t, &buf); len(entries) != 0 || w.Header().Get("X-Request-ID") != "" {
+		t.Errorf("access log off but got %v", entries)
+	}
+}
@@ -0,0 +1,410 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/erasure"
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/features"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// OptionAdmin enables the admin routes (bool). They have no
+// authentication of their own, so unless OptionTokens is set only enable
+// them on a listener bound to a trusted interface.
+const OptionAdmin = "admin"
+
+// handleLogLevels reports the level of each log category on GET, and on
+// PUT or POST sets the levels of the categories in a JSON object such as
+// {"protocol": "debug", "storage": "warn"}. The change lasts until the
+// server restarts.
+func (l *Listener) handleLogLevels(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var changes map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Check every change before applying any
+		levels := make(map[log.Category]log.Level, len(changes))
+		for name, value := range changes {
+			cat, err := log.ParseCategory(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if levels[cat], err = log.ParseLevel(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		for cat, level := range levels {
+			l.logger.SetLevel(cat, level)
+			l.logger.System().Info("log level changed", "category", cat, "level", level.String())
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	levels := make(map[string]string)
+	for cat, level := range l.logger.Levels() {
+		levels[string(cat)] = strings.ToLower(level.String())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"levels": levels})
+}
+
+// handleShadow lists the procedures running in shadow on GET. On POST a
+// JSON object such as {"procedure": "dbo.GetOrders", "action": "promote"}
+// promotes a procedure's candidate to replace it or, with "discard",
+// drops the candidate.
+func (l *Listener) handleShadow(w http.ResponseWriter, r *http.Request) {
+	admin := l.cfg.Admin
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Procedure string `json:"procedure"`
+			Action    string `json:"action"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Procedure == "" {
+			http.Error(w, "procedure is required", http.StatusBadRequest)
+			return
+		}
+		var err error
+		switch strings.ToLower(req.Action) {
+		case "promote":
+			err = admin.PromoteShadow(req.Procedure)
+		case "discard":
+			err = admin.DiscardShadow(req.Procedure)
+		default:
+			http.Error(w, `action must be "promote" or "discard"`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			status := http.StatusConflict
+			if aulerrors.GetCode(err) == aulerrors.ErrCodeProcNotFound {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		l.logger.System().Info("shadow candidate changed by admin request",
+			"procedure", req.Procedure,
+			"action", strings.ToLower(req.Action),
+		)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	candidates := admin.ShadowCandidates()
+	if candidates == nil {
+		candidates = []protocol.ShadowCandidate{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"candidates": candidates})
+}
+
+// handleDeploy reports the procedure sets of a blue/green deployment on
+// GET. On POST a JSON object such as {"action": "stage", "dir": "/srv/release-42"}
+// loads and validates a new set beside the active one; "switch" makes it
+// active, "rollback" restores the set it replaced and "discard" drops it.
+func (l *Listener) handleDeploy(w http.ResponseWriter, r *http.Request) {
+	admin := l.cfg.Admin
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Action string `json:"action"`
+			Dir    string `json:"dir"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		switch strings.ToLower(req.Action) {
+		case "stage":
+			err = admin.StageDeployment(req.Dir)
+		case "switch":
+			err = admin.SwitchDeployment()
+		case "rollback":
+			err = admin.RollbackDeployment()
+		case "discard":
+			err = admin.DiscardDeployment()
+		default:
+			http.Error(w, `action must be "stage", "switch", "rollback" or "discard"`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			status := http.StatusConflict
+			switch aulerrors.GetCode(err) {
+			case aulerrors.ErrCodeProcValidationError:
+				status = http.StatusUnprocessableEntity
+			case aulerrors.ErrCodeProcLoadError:
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		l.logger.System().Info("deployment changed by admin request",
+			"action", strings.ToLower(req.Action),
+		)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(admin.Deployment())
+}
+
+// handleFeatures lists the feature flags on GET. PUT or POST defines a
+// flag, or replaces the one of the same name, from a JSON object such as
+// {"name": "new_pricing", "percent": 10, "tenants": ["acme"]}, and
+// DELETE with ?name= removes one. Changes last until the server restarts.
+func (l *Listener) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	admin := l.cfg.Admin
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var flag features.Flag
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&flag); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := admin.SetFeatureFlag(flag); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		if err := admin.DeleteFeatureFlag(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flags := admin.FeatureFlags()
+	if flags == nil {
+		flags = []features.Flag{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"flags": flags})
+}
+
+// handleCompatibility reports, on GET, the constructs in each procedure
+// that the interpreter cannot run, with where they are and a workaround;
+// ?procedure= limits the report to one procedure.
+func (l *Listener) handleCompatibility(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reports, err := l.cfg.Admin.Compatibility(r.URL.Query().Get("procedure"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if aulerrors.GetCode(err) == aulerrors.ErrCodeProcNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if reports == nil {
+		reports = []protocol.CompatibilityReport{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"procedures": reports})
+}
+
+// handleTraces lists the traces kept of recent executions, and the
+// procedures traced on every call, on GET. On POST a JSON object such as
+// {"procedure": "dbo.GetOrders", "action": "enable"} traces every call of
+// a procedure until "disable" or the server restarts.
+func (l *Listener) handleTraces(w http.ResponseWriter, r *http.Request) {
+	admin := l.cfg.Admin
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Procedure string `json:"procedure"`
+			Action    string `json:"action"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Procedure == "" {
+			http.Error(w, "procedure is required", http.StatusBadRequest)
+			return
+		}
+		var on bool
+		switch strings.ToLower(req.Action) {
+		case "enable":
+			on = true
+		case "disable":
+		default:
+			http.Error(w, `action must be "enable" or "disable"`, http.StatusBadRequest)
+			return
+		}
+		if err := admin.SetProcedureTrace(req.Procedure, on); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"traces":     admin.Traces(),
+		"procedures": admin.TracedProcedures(),
+	})
+}
+
+// handleTrace returns the trace /admin/traces/{id} names, with its steps,
+// on GET.
+func (l *Listener) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	trace, err := l.cfg.Admin.Trace(strings.TrimPrefix(r.URL.Path, "/admin/traces/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trace)
+}
+
+// handleDeprecations lists the deprecated procedures, with who has called
+// each, on GET. On POST a JSON object such as {"procedure": "dbo.GetOrders",
+// "action": "deprecate", "message": "use dbo.GetOrdersV2"} deprecates a
+// procedure until "undeprecate" or the server restarts.
+func (l *Listener) handleDeprecations(w http.ResponseWriter, r *http.Request) {
+	admin := l.cfg.Admin
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Procedure string `json:"procedure"`
+			Action    string `json:"action"`
+			Message   string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Procedure == "" {
+			http.Error(w, "procedure is required", http.StatusBadRequest)
+			return
+		}
+		var on bool
+		switch strings.ToLower(req.Action) {
+		case "deprecate":
+			on = true
+		case "undeprecate":
+		default:
+			http.Error(w, `action must be "deprecate" or "undeprecate"`, http.StatusBadRequest)
+			return
+		}
+		if err := admin.SetDeprecated(req.Procedure, req.Message, on); err != nil {
+			status := http.StatusConflict
+			if aulerrors.GetCode(err) == aulerrors.ErrCodeProcNotFound {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"procedures": admin.Deprecations()})
+}
+
+// handleErasure erases a data subject on POST, given a JSON object such
+// as {"keys": {"customer_id": 42}, "reference": "DSR-1138"}, returning
+// the certificate recorded in the audit log and the statements run. With
+// "dry_run": true the rows are counted and the changes rolled back.
+func (l *Listener) handleErasure(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req erasure.Request
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := l.cfg.Admin.EraseSubject(req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch aulerrors.GetCode(err) {
+		case aulerrors.ErrCodeProcInvalidParam:
+			status = http.StatusBadRequest
+		case aulerrors.ErrCodeConfigMissing:
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handlePurge purges a table on POST, given a JSON object such as
+// {"table": "dbo.AuditLog", "where": "LoggedAt < '2024-01-01'",
+// "batch_size": 5000, "delay": "200ms"}, returning the batches run and the
+// rows deleted. The purge stops, between batches, if the client goes away.
+func (l *Listener) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req protocol.PurgeRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := l.cfg.Admin.PurgeTable(r.Context(), req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if aulerrors.GetCode(err) == aulerrors.ErrCodeProcInvalidParam {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+func TestAdminLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, map[string]interface{}{OptionAdmin: true})
+
+	tests := []struct {
+		method, body string
+		status       int
+		contains     string
+	}{
+		{http.MethodGet, "", http.StatusOK, `"protocol":"debug"`},
+		{http.MethodPut, `{"protocol": "info", "Storage": "warn"}`, http.StatusOK, `"storage":"warn"`},
+		{http.MethodPut, `{"protocol": "debug", "network": "debug"}`, http.StatusBadRequest, "unknown log category"},
+		{http.MethodPost, `{"storage": "loud"}`, http.StatusBadRequest, "unknown log level"},
+		{http.MethodDelete, "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		l.httpServer.Handler.ServeHTTP(w, httptest.NewRequest(tc.method, "/admin/log-levels", strings.NewReader(tc.body)))
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.contains) {
+			t.Errorf("%s %s: got %d %s, want %d containing %q", tc.method, tc.body, w.Code, w.Body.String(), tc.status, tc.contains)
+		}
+	}
+	// The rejected request changed nothing
+	if level := l.logger.Levels()[log.CategoryProtocol]; level != log.LevelInfo {
+		t.Errorf("protocol level = %s, want INFO", level)
+	}
+
+	off := accessLogListener(t, &buf, nil)
+	w := httptest.NewRecorder()
+	off.httpServer.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/log-levels", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("admin routes off: got %d, want 404", w.Code)
+	}
+}
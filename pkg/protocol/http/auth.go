@@ -0,0 +1,121 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// OptionTokens requires API clients to present one of these bearer tokens
+// ([]string). Without it the API is open.
+const OptionTokens = "tokens"
+
+// publicRoutes are served without a token, so that load balancers can
+// check health and clients can fetch the spec before authenticating.
+var publicRoutes = map[string]bool{
+	"/":             true,
+	"/health":       true,
+	"/openapi.json": true,
+}
+
+// requireToken wraps next, answering 401 to requests for non-public routes
+// that carry no accepted token. The token is read from an
+// "Authorization: Bearer" header, or else from X-API-Key.
+func requireToken(next http.Handler, tokens []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicRoutes[r.URL.Path] || validToken(requestToken(r), tokens) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="aul"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// requireLogin wraps next, answering 401 to requests for non-public
+// routes that carry neither the HTTP Basic credentials of a SQL login nor
+// one of tokens, if any.
+func requireLogin(next http.Handler, logins protocol.Authenticator, tokens []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicRoutes[r.URL.Path] || (len(tokens) > 0 && validToken(requestToken(r), tokens)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); ok && logins.Authenticate(r.Context(), user, password) == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="aul", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// requestToken returns the token presented by r, or "".
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// validToken reports whether token is one of tokens, comparing in constant
+// time so that response times say nothing about the tokens.
+func validToken(token string, tokens []string) bool {
+	if token == "" {
+		return false
+	}
+	ok := 0
+	for _, t := range tokens {
+		ok |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+	}
+	return ok == 1
+}
+
+// peerKey is the context key of a connection's peerIdentity.
+type peerKey struct{}
+
+// peerIdentity is who a client on a Unix socket with peer authentication
+// is: its OS user and the principal that user connects as, "" when it
+// may not connect.
+type peerIdentity struct {
+	peer      protocol.Peer
+	principal string
+}
+
+// peerContext is the listener's http.Server ConnContext under peer
+// authentication: it reads the credentials of each connection's peer
+// once, as the connection opens.
+func peerContext(peers *protocol.PeerMap) func(ctx context.Context, c net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		peer, err := protocol.PeerOf(c)
+		if err != nil {
+			return ctx
+		}
+		principal, _ := peers.Principal(peer.User, "")
+		return context.WithValue(ctx, peerKey{}, peerIdentity{peer: peer, principal: principal})
+	}
+}
+
+// requestPeer returns the identity of r's client under peer
+// authentication.
+func requestPeer(r *http.Request) (peerIdentity, bool) {
+	id, ok := r.Context().Value(peerKey{}).(peerIdentity)
+	return id, ok
+}
+
+// requirePeer wraps next, answering 403 to requests for non-public routes
+// from clients whose OS user may not connect. It takes the place of
+// tokens: the socket's peer is who the request is from.
+func requirePeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := requestPeer(r); publicRoutes[r.URL.Path] || (ok && id.principal != "") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "Forbidden: peer authentication failed", http.StatusForbidden)
+	})
+}
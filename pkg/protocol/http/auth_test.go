@@ -0,0 +1,180 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+func TestRequireToken(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, map[string]interface{}{OptionTokens: []string{"s3cret", "other"}})
+
+	tests := []struct {
+		path, header, value string
+		status              int
+	}{
+		{"/procedures", "", "", http.StatusUnauthorized},
+		{"/procedures", "Authorization", "Bearer wrong", http.StatusUnauthorized},
+		{"/procedures", "Authorization", "Bearer s3cret", http.StatusOK},
+		{"/procedures", "Authorization", "bearer other", http.StatusOK},
+		{"/procedures", "X-API-Key", "s3cret", http.StatusOK},
+		{"/health", "", "", http.StatusOK},
+		{"/openapi.json", "", "", http.StatusOK},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		l.httpServer.Handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s %s=%q: got %d, want %d", tc.path, tc.header, tc.value, w.Code, tc.status)
+		}
+	}
+}
+
+// staticLogins accepts the logins it maps to their passwords.
+type staticLogins map[string]string
+
+func (l staticLogins) Authenticate(ctx context.Context, user, password string) error {
+	if p, ok := l[user]; !ok || p != password {
+		return errors.New("login failed")
+	}
+	return nil
+}
+
+func TestRequireLogin(t *testing.T) {
+	cfg := protocol.DefaultListenerConfig(protocol.ProtocolHTTP)
+	cfg.Options = map[string]interface{}{OptionTokens: []string{"s3cret"}}
+	cfg.Logins = staticLogins{"app": "pw"}
+	l, err := NewListener(cfg, log.New(log.Config{Output: io.Discard}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, user, password, token string
+		status                      int
+	}{
+		{"/procedures", "", "", "", http.StatusUnauthorized},
+		{"/procedures", "app", "wrong", "", http.StatusUnauthorized},
+		{"/procedures", "other", "pw", "", http.StatusUnauthorized},
+		{"/procedures", "app", "pw", "", http.StatusOK},
+		{"/procedures", "", "", "s3cret", http.StatusOK},
+		{"/health", "", "", "", http.StatusOK},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.user != "" {
+			r.SetBasicAuth(tc.user, tc.password)
+		}
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		l.httpServer.Handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s as %q/%q token %q: got %d, want %d", tc.path, tc.user, tc.password, tc.token, w.Code, tc.status)
+		}
+		if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+			t.Errorf("WWW-Authenticate = %q, want a Basic challenge", w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	var spec struct {
+		Info  struct{ Version string } `json:"info"`
+		Paths map[string]interface{}   `json:"paths"`
+	}
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, map[string]interface{}{OptionAdmin: true})
+	w := httptest.NewRecorder()
+	l.httpServer.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Info.Version == openAPIVersionPlaceholder {
+		t.Errorf("version placeholder not replaced")
+	}
+
+	// Every route the listener serves is described
+	for _, route := range []string{"/health", "/exec", "/query", "/procedures", "/openapi.json", "/admin/log-levels"} {
+		if spec.Paths[route] == nil {
+			t.Errorf("route %s missing from the spec", route)
+		}
+	}
+}
+
+func TestRequirePeer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are read on Linux only")
+	}
+	me, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "allowed")
+	denied := filepath.Join(dir, "denied")
+	os.WriteFile(allowed, []byte(me.Username+" svc_reports\n"), 0644)
+	os.WriteFile(denied, []byte("someone_else svc_reports\n"), 0644)
+
+	for _, tc := range []struct {
+		peerMap string
+		status  int
+	}{{allowed, http.StatusOK}, {denied, http.StatusForbidden}} {
+		peers, err := protocol.ReadPeerMap(tc.peerMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		cfg := protocol.DefaultListenerConfig(protocol.ProtocolHTTP)
+		cfg.Socket = filepath.Join(dir, "aul.sock")
+		cfg.PeerAuth = peers
+		// Tokens do not apply to a peer-authenticated socket
+		cfg.Options = map[string]interface{}{OptionTokens: []string{"s3cret"}, OptionAccessLog: true}
+		l, err := NewListener(cfg, log.New(log.Config{Output: &buf, Format: log.FormatJSON}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Listen(); err != nil {
+			t.Fatal(err)
+		}
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", cfg.Socket)
+			},
+		}}
+		resp, err := client.Get("http://aul/procedures")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		l.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: got %d, want %d", filepath.Base(tc.peerMap), resp.StatusCode, tc.status)
+		}
+		if tc.status == http.StatusOK && !strings.Contains(buf.String(), `"user":"svc_reports"`) {
+			t.Errorf("access log does not name the principal: %s", buf.String())
+		}
+	}
+}
@@ -16,8 +16,10 @@ import (
 	"sync/atomic"
 	"time"
 
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
 	"github.com/ha1tch/aul/pkg/log"
 	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
 )
 
 // Listener implements protocol.Listener for HTTP REST API.
@@ -43,9 +45,12 @@ type Listener struct {
 
 // httpRequest wraps an HTTP request/response for the Accept pattern.
 type httpRequest struct {
-	req      *http.Request
-	respChan chan protocol.Result
-	done     chan struct{}
+	id          string // Request ID, echoed in the X-Request-ID header
+	fingerprint string // Of the procedure or SQL and parameters; see page.go
+	req         *http.Request
+	respChan    chan protocol.Result
+	done        chan struct{}
+	stream      bool // The response is streamed, so may take a result set as a RowStream
 }
 
 // NewListener creates a new HTTP protocol listener.
@@ -66,13 +71,44 @@ func NewListener(cfg protocol.ListenerConfig, logger *log.Logger) (*Listener, er
 	mux.HandleFunc("/exec", l.handleExec)
 	mux.HandleFunc("/query", l.handleQuery)
 	mux.HandleFunc("/procedures", l.handleProcedures)
+	mux.HandleFunc("/openapi.json", handleOpenAPI)
+	if admin, _ := cfg.Options[OptionAdmin].(bool); admin {
+		mux.HandleFunc("/admin/log-levels", l.handleLogLevels)
+		if cfg.Admin != nil {
+			mux.HandleFunc("/admin/shadow", l.handleShadow)
+			mux.HandleFunc("/admin/deploy", l.handleDeploy)
+			mux.HandleFunc("/admin/features", l.handleFeatures)
+			mux.HandleFunc("/admin/compatibility", l.handleCompatibility)
+			mux.HandleFunc("/admin/traces", l.handleTraces)
+			mux.HandleFunc("/admin/traces/", l.handleTrace)
+			mux.HandleFunc("/admin/deprecations", l.handleDeprecations)
+			mux.HandleFunc("/admin/erasure", l.handleErasure)
+			mux.HandleFunc("/admin/purge", l.handlePurge)
+		}
+	}
+
+	var handler http.Handler = mux
+	if cfg.PeerAuth != nil {
+		handler = requirePeer(handler)
+	} else if cfg.Logins != nil {
+		tokens, _ := cfg.Options[OptionTokens].([]string)
+		handler = requireLogin(handler, cfg.Logins, tokens)
+	} else if tokens, _ := cfg.Options[OptionTokens].([]string); len(tokens) > 0 {
+		handler = requireToken(handler, tokens)
+	}
+	if accessLog := newAccessLogConfig(cfg); accessLog != nil {
+		handler = l.accessLog(mux, handler, accessLog)
+	}
 
 	l.httpServer = &http.Server{
-		Handler:      mux,
+		Handler:      handler,
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 		IdleTimeout:  cfg.IdleTimeout,
 	}
+	if cfg.PeerAuth != nil {
+		l.httpServer.ConnContext = peerContext(cfg.PeerAuth)
+	}
 
 	return l, nil
 }
@@ -84,22 +120,20 @@ func (l *Listener) Protocol() protocol.ProtocolType {
 
 // Listen starts listening on the configured address.
 func (l *Listener) Listen() error {
-	addr := l.cfg.Address()
-
 	var err error
-	l.listener, err = net.Listen("tcp", addr)
+	l.listener, err = protocol.Listen(l.cfg)
 	if err != nil {
-		return fmt.Errorf("listen on %s: %w", addr, err)
+		return err
 	}
 
-	l.logger.System().Info("HTTP listener started",
-		"address", addr,
+	l.logger.Protocol().Info("HTTP listener started",
+		"address", protocol.FormatAddrs(l.Addrs()),
 	)
 
 	// Start HTTP server in background
 	go func() {
 		if err := l.httpServer.Serve(l.listener); err != nil && err != http.ErrServerClosed {
-			l.logger.System().Error("HTTP server error", err)
+			l.logger.Protocol().Error("HTTP server error", err)
 		}
 	}()
 
@@ -140,6 +174,11 @@ func (l *Listener) Close() error {
 	return l.httpServer.Shutdown(ctx)
 }
 
+// Addrs returns every address the listener is bound to.
+func (l *Listener) Addrs() []net.Addr {
+	return protocol.Addrs(l.listener)
+}
+
 // Addr returns the listener's network address.
 func (l *Listener) Addr() net.Addr {
 	if l.listener == nil {
@@ -156,10 +195,21 @@ func (l *Listener) ConnectionCount() int {
 // HTTP handlers
 
 func (l *Listener) handleHealth(w http.ResponseWriter, r *http.Request) {
+	var listeners []protocol.ListenerInfo
+	if l.cfg.Admin != nil {
+		listeners = l.cfg.Admin.Listeners()
+	} else {
+		info := protocol.ListenerInfo{Name: l.cfg.Name, Protocol: string(protocol.ProtocolHTTP), ReadOnly: l.cfg.ReadOnly}
+		for _, addr := range l.Addrs() {
+			info.Addresses = append(info.Addresses, addr.String())
+		}
+		listeners = []protocol.ListenerInfo{info}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
-		"server": "aul",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "ok",
+		"server":    "aul",
+		"listeners": listeners,
 	})
 }
 
@@ -177,11 +227,26 @@ func (l *Listener) handleExec(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The access log has already given the request its ID
+	id := w.Header().Get("X-Request-ID")
+	if id == "" {
+		id = requestTraceID(r)
+		w.Header().Set("X-Request-ID", id)
+	}
+
+	page, err := l.newPageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Create request and wait for response
 	req := &httpRequest{
+		id:       id,
 		req:      r,
 		respChan: make(chan protocol.Result, 1),
 		done:     make(chan struct{}),
+		stream:   wantsStream(r) && page == nil,
 	}
 
 	select {
@@ -189,7 +254,21 @@ func (l *Listener) handleExec(w http.ResponseWriter, r *http.Request) {
 		// Wait for response
 		select {
 		case result := <-req.respChan:
-			l.writeResult(w, result)
+			if page != nil {
+				next, err := page.apply(&result, req.fingerprint, wantsDryRun(r))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					break
+				}
+				if next != "" {
+					w.Header().Set(nextPageHeader, next)
+				}
+			}
+			if wantsStream(r) {
+				l.writeStream(w, result)
+			} else {
+				l.writeResult(w, result, wantsDryRun(r))
+			}
 		case <-time.After(30 * time.Second):
 			http.Error(w, "Timeout", http.StatusGatewayTimeout)
 		}
@@ -213,16 +292,96 @@ func (l *Listener) handleProcedures(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (l *Listener) writeResult(w http.ResponseWriter, result protocol.Result) {
+func (l *Listener) writeResult(w http.ResponseWriter, result protocol.Result, dryRun bool) {
 	w.Header().Set("Content-Type", "application/json")
 
+	resp := resultSummary(w, result)
+
+	resultSets := result.ResultSets
+	if dryRun && len(resultSets) > 0 {
+		// The last result set lists the writes rolled back
+		resp.DryRun = true
+		resp.Modifications = []Modification{}
+		for _, row := range resultSets[len(resultSets)-1].Rows {
+			if len(row) == 5 {
+				op, _ := row[0].(string)
+				target, _ := row[1].(string)
+				statement, _ := row[3].(string)
+				skipped, _ := row[4].(bool)
+				resp.Modifications = append(resp.Modifications, Modification{
+					Operation: op, Target: target, RowsAffected: row[2], Statement: statement, Skipped: skipped,
+				})
+			}
+		}
+		resultSets = resultSets[:len(resultSets)-1]
+	}
+
+	for _, rs := range resultSets {
+		if isPlan(rs) {
+			// Plans are JSON already
+			for _, row := range rs.Rows {
+				if doc, ok := row[0].(string); ok {
+					resp.Plans = append(resp.Plans, json.RawMessage(doc))
+				}
+			}
+			continue
+		}
+		rsJSON := ResultSetJSON{
+			Columns:     make([]string, len(rs.Columns)),
+			Rows:        rs.Rows,
+			Sensitivity: resultSensitivity(rs),
+		}
+		for j, col := range rs.Columns {
+			rsJSON.Columns[j] = col.Name
+		}
+		resp.Results = append(resp.Results, rsJSON)
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// resultSensitivity returns the classifications of the columns of rs, or
+// nil if none is classified.
+func resultSensitivity(rs protocol.ResultSet) []*tsqlruntime.Sensitivity {
+	var labels []*tsqlruntime.Sensitivity
+	for j, col := range rs.Columns {
+		if col.Sensitivity != nil {
+			if labels == nil {
+				labels = make([]*tsqlruntime.Sensitivity, len(rs.Columns))
+			}
+			labels[j] = col.Sensitivity
+		}
+	}
+	return labels
+}
+
+// resultSummary returns the response for result without its result sets.
+// For a failed result it also writes the error status, so the
+// Content-Type must already be set.
+func resultSummary(w http.ResponseWriter, result protocol.Result) APIResponse {
 	resp := APIResponse{
 		Success: result.Type != protocol.ResultError,
 	}
 
 	if result.Error != nil {
 		resp.Error = result.Error.Error()
-		w.WriteHeader(http.StatusInternalServerError)
+		resp.RequestID = result.RequestID
+		code := aulerrors.GetCode(result.Error)
+		if sqlErr := aulerrors.FindSQLError(result.Error); sqlErr != nil {
+			code = sqlErr.Code
+		}
+		switch code {
+		case aulerrors.ErrCodeExecConcurrency, aulerrors.ErrCodeExecCircuitOpen:
+			// Transient: the client should back off and retry
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case aulerrors.ErrCodeExecDenied:
+			w.WriteHeader(http.StatusForbidden)
+		case aulerrors.ErrCodeExecTimeout:
+			w.WriteHeader(http.StatusGatewayTimeout)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
 	}
 
 	if result.Message != "" {
@@ -231,40 +390,76 @@ func (l *Listener) writeResult(w http.ResponseWriter, result protocol.Result) {
 
 	resp.RowsAffected = result.RowsAffected
 
-	if len(result.ResultSets) > 0 {
-		resp.Results = make([]ResultSetJSON, len(result.ResultSets))
-		for i, rs := range result.ResultSets {
-			resp.Results[i] = ResultSetJSON{
-				Columns: make([]string, len(rs.Columns)),
-				Rows:    rs.Rows,
-			}
-			for j, col := range rs.Columns {
-				resp.Results[i].Columns[j] = col.Name
-			}
-		}
-	}
-
 	if result.OutputParams != nil {
 		resp.OutputParams = result.OutputParams
 	}
-
-	json.NewEncoder(w).Encode(resp)
+	resp.Warnings = result.Warnings
+	resp.TraceID = result.TraceID
+	resp.NextPageToken = w.Header().Get(nextPageHeader)
+
+	for _, s := range result.Statements {
+		resp.Statements = append(resp.Statements, StatementSummary{
+			Type:         s.Type,
+			Target:       s.Target,
+			Statement:    s.Statement,
+			Executions:   s.Executions,
+			RowsAffected: s.RowsAffected,
+			DurationMs:   float64(s.Duration.Microseconds()) / 1000,
+		})
+	}
+	return resp
 }
 
 // APIResponse is the JSON response structure.
 type APIResponse struct {
-	Success      bool                   `json:"success"`
-	Error        string                 `json:"error,omitempty"`
-	Message      string                 `json:"message,omitempty"`
-	RowsAffected int64                  `json:"rows_affected,omitempty"`
-	Results      []ResultSetJSON        `json:"results,omitempty"`
-	OutputParams map[string]interface{} `json:"output_params,omitempty"`
+	Success       bool                   `json:"success"`
+	Error         string                 `json:"error,omitempty"`
+	Message       string                 `json:"message,omitempty"`
+	RowsAffected  int64                  `json:"rows_affected,omitempty"`
+	Results       []ResultSetJSON        `json:"results,omitempty"`
+	Plans         []json.RawMessage      `json:"plans,omitempty"` // Of an EXPLAIN
+	DryRun        bool                   `json:"dry_run,omitempty"`
+	Modifications []Modification         `json:"modifications,omitempty"` // Rolled back by a dry run
+	OutputParams  map[string]interface{} `json:"output_params,omitempty"`
+	Warnings      []string               `json:"warnings,omitempty"`
+	Statements    []StatementSummary     `json:"statements,omitempty"`      // With ?summary=true
+	TraceID       string                 `json:"trace_id,omitempty"`        // With ?trace=true; see /admin/traces
+	RequestID     string                 `json:"request_id,omitempty"`      // Set on errors
+	NextPageToken string                 `json:"next_page_token,omitempty"` // When the result is paged; see page.go
+}
+
+// StatementSummary is what one statement of the execution did, with its
+// totals if it ran more than once.
+type StatementSummary struct {
+	Type         string  `json:"type"` // SELECT, INSERT, UPDATE, ...
+	Target       string  `json:"target,omitempty"`
+	Statement    string  `json:"statement"`
+	Executions   int64   `json:"executions"`
+	RowsAffected int64   `json:"rows_affected"`
+	DurationMs   float64 `json:"duration_ms"`
+}
+
+// Modification is a write a dry run made and rolled back.
+type Modification struct {
+	Operation    string      `json:"operation"` // INSERT, UPDATE, ...; EXEC for a system procedure
+	Target       string      `json:"target"`
+	RowsAffected interface{} `json:"rows_affected"` // null for a system procedure
+	Statement    string      `json:"statement"`
+	Skipped      bool        `json:"skipped,omitempty"` // Not run: it acts outside the database
 }
 
+// planColumn names the column of the result sets an EXPLAIN returns, each
+// row a query plan as JSON.
+const planColumn = "QUERY PLAN"
+
 // ResultSetJSON is a JSON-serializable result set.
 type ResultSetJSON struct {
 	Columns []string        `json:"columns"`
 	Rows    [][]interface{} `json:"rows"`
+
+	// Sensitivity classifications of the columns, null for those not
+	// classified, when any is
+	Sensitivity []*tsqlruntime.Sensitivity `json:"sensitivity,omitempty"`
 }
 
 // APIRequest is the JSON request structure.
@@ -321,17 +516,72 @@ func (c *httpConn) ReadRequest() (protocol.Request, error) {
 		}
 	}
 
+	c.req.fingerprint = requestFingerprint(apiReq)
+
+	sql := apiReq.SQL
+	if prefix := explainPrefix(c.req.req); prefix != "" && reqType == protocol.RequestQuery {
+		sql = prefix + sql
+	}
+
 	return protocol.Request{
+		ID:            c.req.id,
 		Type:          reqType,
-		SQL:           apiReq.SQL,
+		SQL:           sql,
 		ProcedureName: apiReq.Procedure,
 		Parameters:    apiReq.Parameters,
 		Options: protocol.RequestOptions{
-			Timeout: timeout,
+			Timeout:       timeout,
+			DryRun:        wantsDryRun(c.req.req),
+			Summary:       wantsSummary(c.req.req),
+			Trace:         wantsTrace(c.req.req),
+			Parameterized: apiReq.Parameters != nil, // Even {}: the SQL takes its values from parameters
+			Stream:        c.req.stream,
 		},
 	}, nil
 }
 
+// explainPrefix returns the prefix that makes a query return its plans,
+// when the client asked for them with ?explain=true or ?explain=analyze.
+func explainPrefix(r *http.Request) string {
+	switch strings.ToLower(r.URL.Query().Get("explain")) {
+	case "1", "true", "yes":
+		return "EXPLAIN "
+	case "analyze":
+		return "EXPLAIN ANALYZE "
+	}
+	return ""
+}
+
+// wantsDryRun reports whether the client asked for a dry run with
+// ?dry_run=true.
+func wantsDryRun(r *http.Request) bool {
+	switch strings.ToLower(r.URL.Query().Get("dry_run")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// wantsSummary reports whether the client asked for a summary of the
+// statements run with ?summary=true.
+func wantsSummary(r *http.Request) bool {
+	switch strings.ToLower(r.URL.Query().Get("summary")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// wantsTrace reports whether the client asked for the execution to be
+// traced with ?trace=true.
+func wantsTrace(r *http.Request) bool {
+	switch strings.ToLower(r.URL.Query().Get("trace")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
 // SendResult sends a result to the client.
 func (c *httpConn) SendResult(result protocol.Result) error {
 	c.mu.Lock()
@@ -349,22 +599,33 @@ func (c *httpConn) SendResult(result protocol.Result) error {
 		RowsAffected: result.RowsAffected,
 		ReturnValue:  result.ReturnValue,
 		OutputParams: result.OutputParams,
+		RequestID:    result.RequestID,
+		Statements:   result.Statements,
+		TraceID:      result.TraceID,
 	}
 
 	// Convert result sets
+	streamed := false
 	for _, rs := range result.ResultSets {
 		convertedResult.ResultSets = append(convertedResult.ResultSets, protocol.ResultSet{
 			Columns: rs.Columns,
 			Rows:    rs.Rows,
+			Stream:  rs.Stream,
 		})
+		streamed = streamed || rs.Stream != nil
 	}
 
 	select {
 	case c.req.respChan <- convertedResult:
-		return nil
 	case <-c.req.done:
 		return io.EOF
 	}
+	// A stream's rows are read as the response is written, which must be
+	// over before the session closes the stream
+	if streamed {
+		<-c.req.done
+	}
+	return nil
 }
 
 // Close closes the connection.
@@ -381,6 +642,13 @@ func (c *httpConn) Close() error {
 	return nil
 }
 
+// WatchCancel calls cancel when the client goes away before its result
+// is written, or the listener stops waiting for it.
+func (c *httpConn) WatchCancel(cancel func()) (stop func()) {
+	stopWatch := context.AfterFunc(c.req.req.Context(), cancel)
+	return func() { stopWatch() }
+}
+
 // RemoteAddr returns the remote address.
 func (c *httpConn) RemoteAddr() net.Addr {
 	// Parse from X-Forwarded-For or RemoteAddr
@@ -406,6 +674,12 @@ func (c *httpConn) Properties() map[string]string {
 	props := make(map[string]string)
 	// HTTP connections can use headers for tenant identification
 	// The server will use TenantSources to extract from headers directly
+	if id, ok := requestPeer(c.req.req); ok {
+		props["user"] = id.principal
+		props["os_user"] = id.peer.User
+	} else if user, _, ok := c.req.req.BasicAuth(); ok && c.listener.cfg.Logins != nil {
+		props["user"] = user
+	}
 	return props
 }
 
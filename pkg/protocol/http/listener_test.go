@@ -0,0 +1,276 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, nil)
+
+	// Fail every request, as the server would, with the request's ID
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, _ := conn.ReadRequest()
+			conn.SendResult(protocol.Result{Type: protocol.ResultError, Error: errors.New("boom"), RequestID: req.ID})
+			conn.Close()
+		}
+	}()
+	defer l.Close()
+
+	for _, tc := range []struct {
+		header, value string
+		want          string
+	}{
+		{"X-Request-ID", "client-42", "client-42"},
+		{"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"", "", ""},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"sql": "SELECT 1"}`))
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		l.httpServer.Handler.ServeHTTP(w, r)
+
+		var resp APIResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", w.Body.String(), err)
+		}
+		id := w.Header().Get("X-Request-ID")
+		if (tc.want != "" && id != tc.want) || len(id) == 0 || resp.RequestID != id {
+			t.Errorf("%s: header %q, body %q, want %q", tc.header, id, resp.RequestID, tc.want)
+		}
+	}
+}
+
+func TestExplainQuery(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, nil)
+
+	// Return the SQL received as a plan, beside an ordinary result set
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, _ := conn.ReadRequest()
+			plan, _ := json.Marshal(map[string]string{"sql": req.SQL})
+			conn.SendResult(protocol.Result{
+				Type: protocol.ResultRows,
+				ResultSets: []protocol.ResultSet{
+					{Columns: []protocol.ColumnInfo{{Name: "n"}}, Rows: [][]interface{}{{1}}},
+					{Columns: []protocol.ColumnInfo{{Name: planColumn}}, Rows: [][]interface{}{{string(plan)}}},
+				},
+			})
+			conn.Close()
+		}
+	}()
+	defer l.Close()
+
+	for _, tc := range []struct {
+		query, want string
+	}{
+		{"?explain=true", "EXPLAIN SELECT n FROM t"},
+		{"?explain=analyze", "EXPLAIN ANALYZE SELECT n FROM t"},
+		{"", "SELECT n FROM t"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/query"+tc.query, strings.NewReader(`{"sql": "SELECT n FROM t"}`))
+		w := httptest.NewRecorder()
+		l.httpServer.Handler.ServeHTTP(w, r)
+
+		var resp APIResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", w.Body.String(), err)
+		}
+		if len(resp.Results) != 1 || len(resp.Plans) != 1 {
+			t.Fatalf("%s: got %s", tc.query, w.Body.String())
+		}
+		var plan struct{ SQL string }
+		if err := json.Unmarshal(resp.Plans[0], &plan); err != nil || plan.SQL != tc.want {
+			t.Errorf("%s: plan %s: %v", tc.query, resp.Plans[0], err)
+		}
+	}
+}
+
+func TestDryRunRequest(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, nil)
+
+	// Return a result set and, for a dry run, the writes made
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, _ := conn.ReadRequest()
+			result := protocol.Result{
+				Type:       protocol.ResultRows,
+				ResultSets: []protocol.ResultSet{{Columns: []protocol.ColumnInfo{{Name: "n"}}, Rows: [][]interface{}{{1}}}},
+			}
+			if req.Options.DryRun {
+				result.ResultSets = append(result.ResultSets, protocol.ResultSet{
+					Columns: []protocol.ColumnInfo{{Name: "operation"}, {Name: "target"}, {Name: "rows_affected"}, {Name: "statement"}, {Name: "skipped"}},
+					Rows: [][]interface{}{
+						{"DELETE", "orders", int64(3), "DELETE FROM orders", false},
+						{"EXEC", "sp_send_dbmail", nil, "EXEC sp_send_dbmail", true},
+					},
+				})
+			}
+			conn.SendResult(result)
+			conn.Close()
+		}
+	}()
+	defer l.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/exec?dry_run=true", strings.NewReader(`{"procedure": "dbo.Purge"}`))
+	w := httptest.NewRecorder()
+	l.httpServer.Handler.ServeHTTP(w, r)
+	var resp APIResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: %v", w.Body.String(), err)
+	}
+	if !resp.DryRun || len(resp.Results) != 1 || len(resp.Modifications) != 2 {
+		t.Fatalf("got %s", w.Body.String())
+	}
+	if m := resp.Modifications[0]; m.Operation != "DELETE" || m.Target != "orders" || m.RowsAffected != float64(3) {
+		t.Errorf("got %+v", m)
+	}
+	if m := resp.Modifications[1]; m.RowsAffected != nil || !m.Skipped {
+		t.Errorf("skipped call %+v", resp.Modifications[1])
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/exec", strings.NewReader(`{"procedure": "dbo.Purge"}`))
+	w = httptest.NewRecorder()
+	l.httpServer.Handler.ServeHTTP(w, r)
+	if strings.Contains(w.Body.String(), "dry_run") || strings.Contains(w.Body.String(), "modifications") {
+		t.Errorf("got %s", w.Body.String())
+	}
+}
+
+func TestSummaryRequest(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, nil)
+
+	// Summarise the statements run when asked
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, _ := conn.ReadRequest()
+			result := protocol.Result{Type: protocol.ResultOK, RowsAffected: 3}
+			if req.Options.Summary {
+				result.Statements = []protocol.StatementSummary{{
+					Type: "DELETE", Target: "orders", Statement: "DELETE FROM orders",
+					Executions: 1, RowsAffected: 3, Duration: 1500 * time.Microsecond,
+				}}
+			}
+			conn.SendResult(result)
+			conn.Close()
+		}
+	}()
+	defer l.Close()
+
+	for _, stream := range []bool{false, true} {
+		target := "/query?summary=true"
+		if stream {
+			target += "&stream=true"
+		}
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"sql": "DELETE FROM orders"}`))
+		w := httptest.NewRecorder()
+		l.httpServer.Handler.ServeHTTP(w, r)
+		var resp APIResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", w.Body.String(), err)
+		}
+		if len(resp.Statements) != 1 {
+			t.Fatalf("got %s", w.Body.String())
+		}
+		if s := resp.Statements[0]; s.Type != "DELETE" || s.Target != "orders" || s.RowsAffected != 3 || s.DurationMs != 1.5 {
+			t.Errorf("got %+v", s)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"sql": "DELETE FROM orders"}`))
+	w := httptest.NewRecorder()
+	l.httpServer.Handler.ServeHTTP(w, r)
+	if strings.Contains(w.Body.String(), "statements") {
+		t.Errorf("got %s", w.Body.String())
+	}
+}
+
+func TestSensitivityMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, nil)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, _ := conn.ReadRequest()
+			email := protocol.ColumnInfo{Name: "email", Sensitivity: &tsqlruntime.Sensitivity{
+				Column: "dbo.Customers.Email", Label: "Confidential", Rank: "MEDIUM"}}
+			if req.SQL == "SELECT id FROM customers" {
+				email = protocol.ColumnInfo{Name: "total"}
+			}
+			conn.SendResult(protocol.Result{
+				Type: protocol.ResultRows,
+				ResultSets: []protocol.ResultSet{{
+					Columns: []protocol.ColumnInfo{{Name: "id"}, email},
+					Rows:    [][]interface{}{{1, "ada@example.com"}},
+				}},
+			})
+			conn.Close()
+		}
+	}()
+	defer l.Close()
+
+	query := func(target, sql string) string {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"sql": "`+sql+`"}`))
+		w := httptest.NewRecorder()
+		l.httpServer.Handler.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	var resp APIResponse
+	if err := json.Unmarshal([]byte(query("/query", "SELECT id, email FROM customers")), &resp); err != nil {
+		t.Fatal(err)
+	}
+	labels := resp.Results[0].Sensitivity
+	if len(labels) != 2 || labels[0] != nil || labels[1] == nil || labels[1].Label != "Confidential" || labels[1].Column != "dbo.Customers.Email" {
+		t.Errorf("sensitivity %+v", labels)
+	}
+
+	var head StreamColumns
+	body := query("/query?stream=true", "SELECT id, email FROM customers")
+	if err := json.Unmarshal([]byte(strings.SplitN(body, "\n", 2)[0]), &head); err != nil {
+		t.Fatal(err)
+	}
+	if len(head.Sensitivity) != 2 || head.Sensitivity[1] == nil || head.Sensitivity[1].Rank != "MEDIUM" {
+		t.Errorf("streamed sensitivity %+v", head.Sensitivity)
+	}
+
+	// Left out when no column is classified
+	if body := query("/query", "SELECT id FROM customers"); strings.Contains(body, "sensitivity") {
+		t.Errorf("got %s", body)
+	}
+}
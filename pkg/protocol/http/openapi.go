@@ -0,0 +1,28 @@
+package http
+
+import (
+	_ "embed"
+	"net/http"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/version"
+)
+
+// OpenAPISpec is the OpenAPI 3 description of the HTTP API, served at
+// /openapi.json. Client SDKs are generated from it.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// openAPIVersionPlaceholder is replaced by the server version when the
+// spec is served.
+const openAPIVersionPlaceholder = "0.0.0-dev"
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(strings.Replace(string(OpenAPISpec), openAPIVersionPlaceholder, version.Version, 1)))
+}
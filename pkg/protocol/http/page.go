@@ -0,0 +1,174 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// OptionMaxPageSize caps the rows of a paged response (int). A request
+// whose single result set has more rows than this is paged even if the
+// client did not ask, so clients that may meet large results should
+// follow next_page_token.
+const OptionMaxPageSize = "max_page_size"
+
+// nextPageHeader carries the token of the next page, as next_page_token
+// does in the body.
+const nextPageHeader = "X-Next-Page-Token"
+
+// pageRequest is the page a client asked for, with ?page_size=,
+// ?page_token= and ?page_key=.
+type pageRequest struct {
+	size     int
+	key      string // Column identifying rows, for keyset paging
+	token    *pageToken
+	explicit bool // The client asked, rather than the maximum imposing it
+}
+
+// pageToken is where the previous page ended. It is opaque to clients:
+// base64url-encoded JSON.
+type pageToken struct {
+	Request string          `json:"r"` // Fingerprint of the request paged
+	Offset  int             `json:"o"` // Rows sent so far
+	Size    int             `json:"n"`
+	Key     json.RawMessage `json:"k,omitempty"`
+}
+
+// newPageRequest reads the page r asks for, which is nil if the client
+// asked for none and the listener sets no maximum.
+func (l *Listener) newPageRequest(r *http.Request) (*pageRequest, error) {
+	q := r.URL.Query()
+	limit, _ := l.cfg.Options[OptionMaxPageSize].(int)
+	p := &pageRequest{key: q.Get("page_key")}
+	if s := q.Get("page_token"); s != "" {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		p.token = &pageToken{}
+		if err != nil || json.Unmarshal(b, p.token) != nil || p.token.Offset < 0 || p.token.Size < 1 {
+			return nil, fmt.Errorf("invalid page_token")
+		}
+		// Later pages keep the size of the first
+		p.size = p.token.Size
+		p.explicit = true
+	}
+	if s := q.Get("page_size"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("page_size must be a positive integer")
+		}
+		p.size = size
+		p.explicit = true
+	}
+	if limit > 0 && (p.size <= 0 || p.size > limit) {
+		p.size = limit
+	}
+	if p.size <= 0 {
+		if p.key != "" {
+			return nil, fmt.Errorf("page_key needs page_size")
+		}
+		return nil, nil
+	}
+	return p, nil
+}
+
+// requestFingerprint identifies a request, so that a page token is only
+// used to page the request it came from.
+func requestFingerprint(req APIRequest) string {
+	b, _ := json.Marshal(struct {
+		Procedure  string                 `json:"procedure"`
+		SQL        string                 `json:"sql"`
+		Parameters map[string]interface{} `json:"parameters"`
+	}{req.Procedure, req.SQL, req.Parameters})
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:8])
+}
+
+// apply cuts result down to the page, returning the token of the next
+// page, or "" if this is the last. The request is run again for each
+// page, so a page starts after the row that ended the last: found by
+// its page_key column, or else by its position. Results that are not a
+// single result set are only paged when the client asked, and then are
+// an error. dryRun leaves out the result set of a dry run's writes.
+func (p *pageRequest) apply(result *protocol.Result, fingerprint string, dryRun bool) (string, error) {
+	if result.Type == protocol.ResultError {
+		return "", nil
+	}
+	sets := len(result.ResultSets)
+	if dryRun && sets > 0 {
+		sets--
+	}
+	if sets != 1 || isPlan(result.ResultSets[0]) {
+		if p.explicit {
+			return "", fmt.Errorf("only a single result set can be paged")
+		}
+		return "", nil
+	}
+	rs := &result.ResultSets[0]
+
+	keyCol := -1
+	if p.key != "" {
+		for i, col := range rs.Columns {
+			if col.Name == p.key {
+				keyCol = i
+			}
+		}
+		if keyCol < 0 {
+			return "", fmt.Errorf("page_key %q is not a column of the result", p.key)
+		}
+	}
+
+	start := 0
+	if p.token != nil {
+		if p.token.Request != fingerprint {
+			return "", fmt.Errorf("page_token is for another request")
+		}
+		start = min(p.token.Offset, len(rs.Rows))
+		if keyCol >= 0 && p.token.Key != nil {
+			start = keysetStart(rs.Rows, keyCol, p.token.Key, start)
+		}
+	}
+	end := min(start+p.size, len(rs.Rows))
+	rows := rs.Rows[start:end]
+
+	next := ""
+	if end < len(rs.Rows) {
+		token := pageToken{Request: fingerprint, Offset: end, Size: p.size}
+		if keyCol >= 0 && len(rows) > 0 {
+			token.Key, _ = json.Marshal(rows[len(rows)-1][keyCol])
+		}
+		b, _ := json.Marshal(token)
+		next = base64.RawURLEncoding.EncodeToString(b)
+	}
+	rs.Rows = rows
+	return next, nil
+}
+
+// keysetStart returns the index after the row whose key column is key,
+// preferring the row just before offset when several match, or offset if
+// none does, as when that row has since been deleted.
+func keysetStart(rows [][]interface{}, col int, key json.RawMessage, offset int) int {
+	matches := func(i int) bool {
+		b, err := json.Marshal(rows[i][col])
+		return err == nil && bytes.Equal(b, key)
+	}
+	if offset > 0 && matches(offset-1) {
+		return offset
+	}
+	for i := range rows {
+		if matches(i) {
+			return i + 1
+		}
+	}
+	return offset
+}
+
+// isPlan reports whether rs holds the query plans of an EXPLAIN.
+func isPlan(rs protocol.ResultSet) bool {
+	return len(rs.Columns) == 1 && rs.Columns[0].Name == planColumn
+}
@@ -0,0 +1,157 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// pagedListener answers every request with the rows rows returns, as one
+// result set of id and name, or two result sets for dbo.Two.
+func pagedListener(t *testing.T, options map[string]interface{}, rows func() [][]interface{}) *Listener {
+	t.Helper()
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, options)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, _ := conn.ReadRequest()
+			rs := protocol.ResultSet{
+				Columns: []protocol.ColumnInfo{{Name: "id"}, {Name: "name"}},
+				Rows:    rows(),
+			}
+			result := protocol.Result{Type: protocol.ResultRows, ResultSets: []protocol.ResultSet{rs}}
+			if req.ProcedureName == "dbo.Two" {
+				result.ResultSets = append(result.ResultSets, rs)
+			}
+			conn.SendResult(result)
+			conn.Close()
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// getPage runs procedure with the query string query.
+func getPage(t *testing.T, l *Listener, procedure, query string) (*httptest.ResponseRecorder, APIResponse) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/exec?"+query, strings.NewReader(`{"procedure": "`+procedure+`"}`))
+	w := httptest.NewRecorder()
+	l.httpServer.Handler.ServeHTTP(w, r)
+	var resp APIResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", w.Body.String(), err)
+		}
+	}
+	return w, resp
+}
+
+// pageIDs returns the ids of the rows of resp.
+func pageIDs(resp APIResponse) []interface{} {
+	var ids []interface{}
+	for _, rs := range resp.Results {
+		for _, row := range rs.Rows {
+			ids = append(ids, row[0])
+		}
+	}
+	return ids
+}
+
+func TestPagedResult(t *testing.T) {
+	var mu sync.Mutex
+	table := [][]interface{}{{1, "a"}, {2, "b"}, {3, "c"}, {4, "d"}, {5, "e"}}
+	l := pagedListener(t, nil, func() [][]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]interface{}(nil), table...)
+	})
+
+	// Follow the tokens to the end
+	var pages [][]interface{}
+	query := "page_size=2"
+	for {
+		w, resp := getPage(t, l, "dbo.List", query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", query, w.Code, w.Body.String())
+		}
+		if w.Header().Get(nextPageHeader) != resp.NextPageToken {
+			t.Errorf("header %q, body %q", w.Header().Get(nextPageHeader), resp.NextPageToken)
+		}
+		pages = append(pages, pageIDs(resp))
+		if resp.NextPageToken == "" {
+			break
+		}
+		query = "page_token=" + url.QueryEscape(resp.NextPageToken)
+	}
+	if got, _ := json.Marshal(pages); string(got) != "[[1,2],[3,4],[5]]" {
+		t.Errorf("pages %s", got)
+	}
+
+	// A row deleted before the page boundary: offsets skip a row, keys do not
+	_, first := getPage(t, l, "dbo.List", "page_size=2")
+	_, keyed := getPage(t, l, "dbo.List", "page_size=2&page_key=id")
+	mu.Lock()
+	table = table[1:]
+	mu.Unlock()
+	_, resp := getPage(t, l, "dbo.List", "page_token="+url.QueryEscape(first.NextPageToken))
+	if got, _ := json.Marshal(pageIDs(resp)); string(got) != "[4,5]" {
+		t.Errorf("offset page %s, want [4,5]", got)
+	}
+	_, resp = getPage(t, l, "dbo.List", "page_key=id&page_token="+url.QueryEscape(keyed.NextPageToken))
+	if got, _ := json.Marshal(pageIDs(resp)); string(got) != "[3,4]" {
+		t.Errorf("keyset page %s, want [3,4]", got)
+	}
+
+	for _, tc := range []struct {
+		procedure, query string
+		want             string
+	}{
+		{"dbo.List", "page_size=0", "page_size must be a positive integer"},
+		{"dbo.List", "page_token=xyz", "invalid page_token"},
+		{"dbo.Other", "page_token=" + url.QueryEscape(first.NextPageToken), "page_token is for another request"},
+		{"dbo.List", "page_size=2&page_key=missing", `page_key "missing" is not a column of the result`},
+		{"dbo.Two", "page_size=2", "only a single result set can be paged"},
+	} {
+		w, _ := getPage(t, l, tc.procedure, tc.query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s?%s: %d %s, want %q", tc.procedure, tc.query, w.Code, w.Body.String(), tc.want)
+		}
+	}
+}
+
+func TestMaxPageSize(t *testing.T) {
+	l := pagedListener(t, map[string]interface{}{OptionMaxPageSize: 3}, func() [][]interface{} {
+		return [][]interface{}{{1, "a"}, {2, "b"}, {3, "c"}, {4, "d"}}
+	})
+
+	for _, tc := range []struct {
+		procedure, query string
+		rows             int
+		more             bool
+	}{
+		{"dbo.List", "", 3, true},
+		{"dbo.List", "page_size=10", 3, true},
+		{"dbo.List", "page_size=2", 2, true},
+		// Not paged unless asked
+		{"dbo.Two", "", 8, false},
+	} {
+		w, resp := getPage(t, l, tc.procedure, tc.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s?%s: %d %s", tc.procedure, tc.query, w.Code, w.Body.String())
+		}
+		if got := len(pageIDs(resp)); got != tc.rows || (resp.NextPageToken != "") != tc.more {
+			t.Errorf("%s?%s: %d rows, token %q", tc.procedure, tc.query, got, resp.NextPageToken)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// ContentTypeNDJSON is the media type of streamed results, one JSON
+// document per line.
+const ContentTypeNDJSON = "application/x-ndjson"
+
+// streamFlushRows is how many rows are written between flushes.
+const streamFlushRows = 500
+
+// StreamColumns starts a result set in a streamed response. Each of its
+// rows follows as a StreamRow, and a final APIResponse, without results,
+// ends the stream.
+type StreamColumns struct {
+	Columns     []string                   `json:"columns"`
+	Types       []string                   `json:"types,omitempty"`
+	Sensitivity []*tsqlruntime.Sensitivity `json:"sensitivity,omitempty"`
+}
+
+// StreamRow is one row of a streamed result set.
+type StreamRow struct {
+	Row []interface{} `json:"row"`
+}
+
+// wantsStream reports whether the client asked for a streamed response,
+// with an Accept header or ?stream=true.
+func wantsStream(r *http.Request) bool {
+	if strings.Contains(r.Header.Get("Accept"), ContentTypeNDJSON) {
+		return true
+	}
+	switch strings.ToLower(r.URL.Query().Get("stream")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// writeStream writes result as newline-delimited JSON, so that clients
+// can handle rows as they arrive instead of decoding one large document.
+func (l *Listener) writeStream(w http.ResponseWriter, result protocol.Result) {
+	w.Header().Set("Content-Type", ContentTypeNDJSON)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	summary := resultSummary(w, result)
+	for _, rs := range result.ResultSets {
+		head := StreamColumns{Columns: make([]string, len(rs.Columns)), Sensitivity: resultSensitivity(rs)}
+		for j, col := range rs.Columns {
+			head.Columns[j] = col.Name
+			if col.Type != "" {
+				if head.Types == nil {
+					head.Types = make([]string, len(rs.Columns))
+				}
+				head.Types[j] = strings.ToLower(col.Type)
+			}
+		}
+		if err := enc.Encode(head); err != nil {
+			return
+		}
+		if rs.Stream != nil {
+			if !writeRowStream(enc, flusher, rs.Stream, &summary) {
+				return
+			}
+			continue
+		}
+		for i, row := range rs.Rows {
+			if err := enc.Encode(StreamRow{Row: row}); err != nil {
+				return
+			}
+			if flusher != nil && (i+1)%streamFlushRows == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+	enc.Encode(summary)
+}
+
+// writeRowStream writes the rows of stream as they are read, counting
+// them in summary, or the error its query fails with; it reports false
+// when the client has gone.
+func writeRowStream(enc *json.Encoder, flusher http.Flusher, stream protocol.RowStream, summary *APIResponse) bool {
+	var n int64
+	for stream.Next() {
+		if err := enc.Encode(StreamRow{Row: stream.Row()}); err != nil {
+			return false
+		}
+		n++
+		if flusher != nil && n%streamFlushRows == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := stream.Err(); err != nil {
+		summary.Success = false
+		summary.Error = err.Error()
+		return true
+	}
+	summary.RowsAffected += n
+	summary.Message = fmt.Sprintf("(%d rows affected)", summary.RowsAffected)
+	return true
+}
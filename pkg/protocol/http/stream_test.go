@@ -0,0 +1,168 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+func TestStreamedResult(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, nil)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.ReadRequest()
+			conn.SendResult(protocol.Result{
+				Type: protocol.ResultRows,
+				ResultSets: []protocol.ResultSet{{
+					Columns: []protocol.ColumnInfo{{Name: "id", Type: "INT"}, {Name: "name", Type: "NVARCHAR"}},
+					Rows:    [][]interface{}{{1, "a"}, {2, "b"}},
+				}},
+				OutputParams: map[string]interface{}{"@Count": 2},
+			})
+			conn.Close()
+		}
+	}()
+	defer l.Close()
+
+	for _, tc := range []struct {
+		path, accept string
+	}{
+		{"/exec", ContentTypeNDJSON},
+		{"/exec?stream=true", ""},
+	} {
+		r := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(`{"procedure": "dbo.List"}`))
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		l.httpServer.Handler.ServeHTTP(w, r)
+		if ct := w.Header().Get("Content-Type"); ct != ContentTypeNDJSON {
+			t.Fatalf("%s: Content-Type %q", tc.path, ct)
+		}
+
+		var lines []string
+		sc := bufio.NewScanner(w.Body)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		if len(lines) != 4 {
+			t.Fatalf("%s: got %d lines: %q", tc.path, len(lines), lines)
+		}
+		var head StreamColumns
+		var row StreamRow
+		var end APIResponse
+		json.Unmarshal([]byte(lines[0]), &head)
+		json.Unmarshal([]byte(lines[2]), &row)
+		json.Unmarshal([]byte(lines[3]), &end)
+		if strings.Join(head.Columns, ",") != "id,name" || strings.Join(head.Types, ",") != "int,nvarchar" {
+			t.Errorf("%s: header %+v", tc.path, head)
+		}
+		if len(row.Row) != 2 || row.Row[1] != "b" {
+			t.Errorf("%s: row %+v", tc.path, row)
+		}
+		if !end.Success || end.Results != nil || end.OutputParams["@Count"] != float64(2) {
+			t.Errorf("%s: summary %+v", tc.path, end)
+		}
+	}
+}
+
+// sliceStream is a protocol.RowStream over rows, failing with err at the
+// end.
+type sliceStream struct {
+	rows   [][]interface{}
+	row    []interface{}
+	err    error
+	closed bool
+}
+
+func (s *sliceStream) Next() bool {
+	if len(s.rows) == 0 {
+		return false
+	}
+	s.row, s.rows = s.rows[0], s.rows[1:]
+	return true
+}
+
+func (s *sliceStream) Row() []interface{} { return s.row }
+func (s *sliceStream) Err() error         { return s.err }
+func (s *sliceStream) Close() error       { s.closed = true; return nil }
+
+func TestStreamedRowStream(t *testing.T) {
+	var buf bytes.Buffer
+	l := accessLogListener(t, &buf, nil)
+	streams := make(chan *sliceStream, 1)
+	options := make(chan protocol.RequestOptions, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, _ := conn.ReadRequest()
+			options <- req.Options
+			stream := <-streams
+			conn.SendResult(protocol.Result{
+				Type: protocol.ResultRows,
+				ResultSets: []protocol.ResultSet{{
+					Columns: []protocol.ColumnInfo{{Name: "id", Type: "varchar"}},
+					Stream:  stream,
+				}},
+			})
+			// The response has been written by the time SendResult returns
+			if len(stream.rows) != 0 {
+				t.Errorf("SendResult returned with %d rows unread", len(stream.rows))
+			}
+			conn.Close()
+		}
+	}()
+	defer l.Close()
+
+	for _, tc := range []struct {
+		name string
+		err  error
+	}{
+		{"complete", nil},
+		{"failed", fmt.Errorf("disk I/O error")},
+	} {
+		streams <- &sliceStream{rows: [][]interface{}{{1}, {2}, {3}}, err: tc.err}
+		r := httptest.NewRequest(http.MethodPost, "/query?stream=true", strings.NewReader(`{"sql": "SELECT id FROM t"}`))
+		w := httptest.NewRecorder()
+		l.httpServer.Handler.ServeHTTP(w, r)
+		if opts := <-options; !opts.Stream {
+			t.Errorf("%s: request options %+v, want Stream", tc.name, opts)
+		}
+
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(lines) != 5 {
+			t.Fatalf("%s: got %d lines: %q", tc.name, len(lines), lines)
+		}
+		var end APIResponse
+		json.Unmarshal([]byte(lines[4]), &end)
+		if tc.err == nil && (!end.Success || end.RowsAffected != 3 || end.Message != "(3 rows affected)") {
+			t.Errorf("%s: summary %+v", tc.name, end)
+		}
+		if tc.err != nil && (end.Success || end.Error != "disk I/O error") {
+			t.Errorf("%s: summary %+v", tc.name, end)
+		}
+	}
+
+	// A response that is not streamed does not take a stream
+	streams <- &sliceStream{}
+	r := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"sql": "SELECT id FROM t"}`))
+	l.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), r)
+	if opts := <-options; opts.Stream {
+		t.Errorf("request options %+v, want no Stream", opts)
+	}
+}
@@ -0,0 +1,232 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A listener binds Host, or each of Hosts, on Port. A host may be an
+// IPv4 or IPv6 address, bare (::1) or bracketed ([::1]), or a name, which
+// is bound on every address it resolves to, so that "localhost" listens
+// on 127.0.0.1 and ::1 alike. An empty host, "::" or "[::]" binds every
+// interface over both IPv4 and IPv6 where the system allows dual-stack
+// sockets; "0.0.0.0" binds IPv4 only. An address a name resolves to that
+// cannot be bound, such as ::1 on a host without IPv6, is skipped so long
+// as another can.
+//
+// A listener with a Socket binds that Unix socket instead. A socket file
+// left behind by a server that has gone is replaced; one that still
+// accepts connections is in use.
+
+// Listen binds the addresses of cfg and returns one listener accepting
+// connections on all of them. Addrs reports the addresses bound.
+func Listen(cfg ListenerConfig) (net.Listener, error) {
+	if cfg.Socket != "" {
+		return listenUnix(cfg.Socket)
+	}
+
+	addrs, err := cfg.bindAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []net.Listener
+	var skipped error
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr.addr)
+		if err != nil && addr.resolved {
+			skipped = fmt.Errorf("listen on %s: %w", addr.addr, err)
+			continue
+		}
+		if err != nil {
+			for _, bound := range listeners {
+				bound.Close()
+			}
+			return nil, fmt.Errorf("listen on %s: %w", addr.addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	switch len(listeners) {
+	case 0:
+		return nil, skipped
+	case 1:
+		return listeners[0], nil
+	}
+	return newMultiListener(listeners), nil
+}
+
+// Addrs returns the addresses ln is bound to: several for a listener
+// returned by Listen for more than one address.
+func Addrs(ln net.Listener) []net.Addr {
+	if ln == nil {
+		return nil
+	}
+	if m, ok := ln.(*multiListener); ok {
+		addrs := make([]net.Addr, len(m.listeners))
+		for i, l := range m.listeners {
+			addrs[i] = l.Addr()
+		}
+		return addrs
+	}
+	return []net.Addr{ln.Addr()}
+}
+
+// listenUnix binds the Unix socket at path, open to every local user:
+// restrict it with the permissions of its directory, or peer
+// authentication.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("listen on %s: socket in use", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o666); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("listen on %s: %w", path, err)
+	}
+	return ln, nil
+}
+
+// FormatAddrs joins addresses for logs and messages.
+func FormatAddrs(addrs []net.Addr) string {
+	s := make([]string, len(addrs))
+	for i, addr := range addrs {
+		s[i] = addr.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// bindAddress is a host:port address to bind, resolved when a name
+// gave it.
+type bindAddress struct {
+	addr     string
+	resolved bool
+}
+
+// bindAddresses returns the addresses to bind, with names resolved and
+// duplicates dropped.
+func (c ListenerConfig) bindAddresses() ([]bindAddress, error) {
+	hosts := c.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{c.Host}
+	}
+
+	port := strconv.Itoa(c.Port)
+	seen := make(map[string]bool)
+	var addrs []bindAddress
+	for _, host := range hosts {
+		host = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(host), "["), "]")
+		ips := []string{host}
+		resolved := host != "" && net.ParseIP(stripZone(host)) == nil
+		if resolved {
+			var err error
+			if ips, err = net.DefaultResolver.LookupHost(context.Background(), host); err != nil {
+				return nil, fmt.Errorf("resolve listen address %s: %w", host, err)
+			}
+		}
+		for _, ip := range ips {
+			addr := net.JoinHostPort(ip, port)
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, bindAddress{addr: addr, resolved: resolved})
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// stripZone drops the zone of a link-local IPv6 address, fe80::1%eth0.
+func stripZone(host string) string {
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		return host[:i]
+	}
+	return host
+}
+
+// multiListener accepts connections from several listeners as one.
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		conns:     make(chan net.Conn),
+		errs:      make(chan error),
+		done:      make(chan struct{}),
+	}
+	for _, ln := range listeners {
+		go m.serve(ln)
+	}
+	return m
+}
+
+// serve hands what ln accepts, connections and errors, to Accept until
+// it closes.
+func (m *multiListener) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case m.errs <- err:
+			case <-m.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		select {
+		case m.conns <- conn:
+		case <-m.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-m.conns:
+		return conn, nil
+	case err := <-m.errs:
+		return nil, err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.done)
+		for _, ln := range m.listeners {
+			if cerr := ln.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the first address bound; Addrs returns them all.
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenerConfigAddress(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"", ":1433"},
+		{"0.0.0.0", "0.0.0.0:1433"},
+		{"::1", "[::1]:1433"},
+		{"[::1]", "[::1]:1433"},
+		{"fe80::1%eth0", "[fe80::1%eth0]:1433"},
+	}
+	for _, tt := range tests {
+		if got := (ListenerConfig{Host: tt.host, Port: 1433}).Address(); got != tt.want {
+			t.Errorf("Address(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestListenMultipleAddresses(t *testing.T) {
+	probe, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	probe.Close()
+
+	ln, err := Listen(ListenerConfig{Hosts: []string{"127.0.0.1", "[::1]", "127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addrs := Addrs(ln)
+	if len(addrs) != 2 {
+		t.Fatalf("bound %v, want 127.0.0.1 and ::1 once each", addrs)
+	}
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accepted.LocalAddr().String() != addr.String() {
+			t.Errorf("accepted on %s, want %s", accepted.LocalAddr(), addr)
+		}
+		accepted.Close()
+		conn.Close()
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ln.Accept(); err == nil {
+		t.Error("Accept succeeded after Close")
+	}
+}
+
+func TestListenFailsOnAddressInUse(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	if ln, err := Listen(ListenerConfig{Hosts: []string{"127.0.0.1"}, Port: port}); err == nil {
+		ln.Close()
+		t.Error("bound an address already in use")
+	}
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// A listener on a Unix socket can authenticate its clients by the OS
+// user running them, which the kernel reports for the socket's peer, so
+// that local tools and sidecars connect without passwords or tokens. The
+// OS user connects as a database principal given by a PeerMap: by
+// default the principal of the same name, as with PostgreSQL's peer
+// authentication.
+
+// ErrPeerCredentials is returned where the platform cannot report the
+// credentials of a socket's peer.
+var ErrPeerCredentials = errors.New("peer credentials are not supported on this platform")
+
+// Peer identifies the process at the other end of a Unix socket.
+type Peer struct {
+	UID  int
+	GID  int
+	PID  int
+	User string // OS user name, or the UID when it has none
+}
+
+// PeerOf returns the credentials of conn's peer, which must be a Unix
+// socket connection, possibly under TLS.
+func PeerOf(conn net.Conn) (Peer, error) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, fmt.Errorf("peer credentials: %s is not a Unix socket", conn.RemoteAddr().Network())
+	}
+	peer, err := peerCredentials(uc)
+	if err != nil {
+		return Peer{}, err
+	}
+	peer.User = strconv.Itoa(peer.UID)
+	if u, err := user.LookupId(peer.User); err == nil {
+		peer.User = u.Username
+	}
+	return peer, nil
+}
+
+// PeerMap maps OS users to the database principals they may connect as,
+// like PostgreSQL's pg_ident.conf. An empty map lets each OS user connect
+// as the principal of the same name only.
+type PeerMap struct {
+	principals map[string][]string
+}
+
+// ReadPeerMap reads a peer map file: one "os-user principal..." line per
+// OS user, the first principal the one used when a client names none.
+// Blank lines and lines starting with # are skipped.
+func ReadPeerMap(path string) (*PeerMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &PeerMap{principals: make(map[string][]string)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: want an OS user and at least one principal", path, n)
+		}
+		m.principals[fields[0]] = append(m.principals[fields[0]], fields[1:]...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Principal returns the principal the OS user osUser connects as, given
+// the one the client asked for, if any. It fails when the OS user may
+// not connect as that principal, or, with none asked for, at all.
+func (m *PeerMap) Principal(osUser, requested string) (string, bool) {
+	allowed := []string{osUser}
+	if m != nil && len(m.principals) > 0 {
+		allowed = m.principals[osUser]
+	}
+	if requested == "" {
+		if len(allowed) == 0 {
+			return "", false
+		}
+		return allowed[0], true
+	}
+	for _, p := range allowed {
+		if strings.EqualFold(p, requested) {
+			return p, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package protocol
+
+import "net"
+
+// peerCredentials reports that peer credentials are not available on
+// this platform.
+func peerCredentials(conn *net.UnixConn) (Peer, error) {
+	return Peer{}, ErrPeerCredentials
+}
@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPeerMapPrincipal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	if err := os.WriteFile(path, []byte("# OS user, then principals\nbackup  backup_operator reader\n\ndeploy  deployer\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadPeerMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		peers             *PeerMap
+		osUser, requested string
+		want              string
+		ok                bool
+	}{
+		{m, "backup", "", "backup_operator", true},
+		{m, "backup", "READER", "reader", true},
+		{m, "backup", "deployer", "", false},
+		{m, "backup", "backup", "", false},
+		{m, "postgres", "", "", false},
+		{&PeerMap{}, "alice", "", "alice", true},
+		{&PeerMap{}, "alice", "alice", "alice", true},
+		{&PeerMap{}, "alice", "sa", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.peers.Principal(tt.osUser, tt.requested)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Principal(%q, %q) = %q, %v; want %q, %v", tt.osUser, tt.requested, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("lonely\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPeerMap(path); err == nil {
+		t.Error("no error for a line without a principal")
+	}
+}
+
+func TestPeerOf(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are read on Linux only")
+	}
+	path := filepath.Join(t.TempDir(), "aul.sock")
+	ln, err := Listen(ListenerConfig{Socket: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// A socket file that still accepts connections is not replaced
+	if _, err := Listen(ListenerConfig{Socket: path}); err == nil {
+		t.Error("bound a socket in use")
+	}
+
+	done := make(chan Peer, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			close(done)
+			return
+		}
+		defer conn.Close()
+		peer, err := PeerOf(conn)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- peer
+	}()
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	peer := <-done
+	if peer.UID != os.Getuid() || peer.PID != os.Getpid() || peer.User == "" {
+		t.Errorf("got %+v, want uid %d pid %d", peer, os.Getuid(), os.Getpid())
+	}
+}
@@ -7,8 +7,12 @@
 package postgres
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
@@ -18,8 +22,10 @@ import (
 
 	"github.com/jackc/pgx/v5/pgproto3"
 
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
 	"github.com/ha1tch/aul/pkg/log"
 	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/protocol/capture"
 )
 
 // Listener implements protocol.Listener for the PostgreSQL wire protocol.
@@ -29,6 +35,7 @@ type Listener struct {
 	cfg      protocol.ListenerConfig
 	logger   *log.Logger
 	listener net.Listener
+	addrs    []net.Addr // Bound, below any TLS layer
 
 	// Connection tracking
 	connections map[*Conn]struct{}
@@ -58,28 +65,29 @@ func (l *Listener) Protocol() protocol.ProtocolType {
 	return protocol.ProtocolPostgres
 }
 
-// Listen starts listening on the configured address.
+// Listen starts listening on the configured addresses.
 func (l *Listener) Listen() error {
-	addr := l.cfg.Address()
-
-	var err error
+	var tlsCfg *tls.Config
 	if l.cfg.TLSEnabled {
 		cert, err := tls.LoadX509KeyPair(l.cfg.TLSCertFile, l.cfg.TLSKeyFile)
 		if err != nil {
 			return fmt.Errorf("loading TLS certificate: %w", err)
 		}
-		tlsCfg := &tls.Config{
+		tlsCfg = &tls.Config{
 			Certificates: []tls.Certificate{cert},
 			MinVersion:   tls.VersionTLS12,
 		}
-		l.listener, err = tls.Listen("tcp", addr, tlsCfg)
-	} else {
-		l.listener, err = net.Listen("tcp", addr)
 	}
 
+	ln, err := protocol.Listen(l.cfg)
 	if err != nil {
-		return fmt.Errorf("listen on %s: %w", addr, err)
+		return err
+	}
+	l.addrs = protocol.Addrs(ln)
+	if tlsCfg != nil {
+		ln = tls.NewListener(ln, tlsCfg)
 	}
+	l.listener = ln
 
 	return nil
 }
@@ -95,7 +103,26 @@ func (l *Listener) Accept() (protocol.Connection, error) {
 		return nil, err
 	}
 
+	// Read the peer's credentials before anything wraps the socket
+	var peer *protocol.Peer
+	if l.cfg.PeerAuth != nil {
+		p, err := protocol.PeerOf(netConn)
+		if err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("peer authentication: %w", err)
+		}
+		peer = &p
+	}
+
+	// Record the raw client byte stream if capture is enabled. The
+	// recorder wraps the socket below any TLS negotiated by SSLRequest, so
+	// an encrypted session is captured as ciphertext.
+	if dir, ok := capture.Enabled(l.cfg); ok {
+		netConn = capture.NewRecorder(netConn, protocol.ProtocolPostgres, dir)
+	}
+
 	conn := newConn(netConn, l.cfg)
+	conn.peer = peer
 
 	// Perform PostgreSQL handshake
 	if err := conn.handshake(l.ctx); err != nil {
@@ -103,6 +130,14 @@ func (l *Listener) Accept() (protocol.Connection, error) {
 		return nil, fmt.Errorf("handshake failed: %w", err)
 	}
 
+	// A cancel request comes on a connection of its own, closed once the
+	// request it names is cancelled
+	if conn.cancelRequest != nil {
+		l.cancelRequest(*conn.cancelRequest)
+		netConn.Close()
+		return l.Accept()
+	}
+
 	l.mu.Lock()
 	l.connections[conn] = struct{}{}
 	atomic.AddInt64(&l.connCount, 1)
@@ -133,6 +168,11 @@ func (l *Listener) Close() error {
 	return nil
 }
 
+// Addrs returns every address the listener is bound to.
+func (l *Listener) Addrs() []net.Addr {
+	return l.addrs
+}
+
 // Addr returns the listener's network address.
 func (l *Listener) Addr() net.Addr {
 	if l.listener == nil {
@@ -146,6 +186,24 @@ func (l *Listener) ConnectionCount() int {
 	return int(atomic.LoadInt64(&l.connCount))
 }
 
+// cancelRequest cancels the request running on the connection a cancel
+// request names by its key. A request naming no connection is ignored,
+// as PostgreSQL ignores it.
+func (l *Listener) cancelRequest(req pgproto3.CancelRequest) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for conn := range l.connections {
+		if conn.key.ProcessID == req.ProcessID && conn.key.SecretKey == req.SecretKey {
+			conn.cancelMu.Lock()
+			if conn.cancel != nil {
+				conn.cancel()
+			}
+			conn.cancelMu.Unlock()
+			return
+		}
+	}
+}
+
 // removeConnection removes a connection from tracking.
 func (l *Listener) removeConnection(conn *Conn) {
 	l.mu.Lock()
@@ -167,6 +225,17 @@ type Conn struct {
 	user     string
 	database string
 	params   map[string]string
+	peer     *protocol.Peer // Client's credentials, with peer authentication
+
+	// Cancel requests name the connection by the key it is sent at
+	// startup. cancel stops the request running, under cancelMu.
+	key      pgproto3.BackendKeyData
+	cancelMu sync.Mutex
+	cancel   func()
+
+	// The cancel request the connection was opened for, instead of a
+	// session
+	cancelRequest *pgproto3.CancelRequest
 
 	// State
 	closed bool
@@ -204,8 +273,26 @@ func (c *Conn) handshake(ctx context.Context) error {
 			c.params[k] = v
 		}
 
-		// Send AuthenticationOk (no authentication for now)
-		// In production, implement proper authentication here
+		// With peer authentication the OS user decides who the client
+		// is; otherwise any user is accepted
+		if c.peer != nil {
+			principal, ok := c.cfg.PeerAuth.Principal(c.peer.User, c.user)
+			if !ok {
+				buf := (&pgproto3.ErrorResponse{
+					Severity: "FATAL",
+					Code:     "28000",
+					Message:  fmt.Sprintf("peer authentication failed for user %q", c.user),
+				}).Encode(nil)
+				c.netConn.Write(buf)
+				return fmt.Errorf("peer authentication failed: OS user %s may not connect as %q", c.peer.User, c.user)
+			}
+			c.user = principal
+		} else if c.cfg.Logins != nil {
+			if err := c.authenticate(ctx); err != nil {
+				return err
+			}
+		}
+
 		buf := (&pgproto3.AuthenticationOk{}).Encode(nil)
 
 		// Send parameter status messages
@@ -216,7 +303,12 @@ func (c *Conn) handshake(ctx context.Context) error {
 		buf = (&pgproto3.ParameterStatus{Name: "TimeZone", Value: "UTC"}).Encode(buf)
 
 		// Send BackendKeyData (process ID and secret key for cancel requests)
-		buf = (&pgproto3.BackendKeyData{ProcessID: uint32(time.Now().UnixNano() & 0xFFFFFFFF), SecretKey: 0}).Encode(buf)
+		var key [8]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return fmt.Errorf("generating cancel key: %w", err)
+		}
+		c.key = pgproto3.BackendKeyData{ProcessID: binary.BigEndian.Uint32(key[:4]), SecretKey: binary.BigEndian.Uint32(key[4:])}
+		buf = c.key.Encode(buf)
 
 		// Send ReadyForQuery
 		buf = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf) // 'I' = idle (not in transaction)
@@ -235,14 +327,45 @@ func (c *Conn) handshake(ctx context.Context) error {
 		return c.handshake(ctx)
 
 	case *pgproto3.CancelRequest:
-		// Cancel request - handle separately
-		return fmt.Errorf("cancel request not supported")
+		// Cancel request - the listener handles it
+		c.cancelRequest = msg
+		return nil
 
 	default:
 		return fmt.Errorf("unexpected startup message type: %T", msg)
 	}
 }
 
+// authenticate asks the client for the password of its user and checks
+// it against the listener's SQL logins. The password is sent in clear,
+// which is why the listener should be on a trusted network or socket.
+func (c *Conn) authenticate(ctx context.Context) error {
+	if _, err := c.netConn.Write((&pgproto3.AuthenticationCleartextPassword{}).Encode(nil)); err != nil {
+		return err
+	}
+	if err := c.backend.SetAuthType(pgproto3.AuthTypeCleartextPassword); err != nil {
+		return err
+	}
+	msg, err := c.backend.Receive()
+	if err != nil {
+		return fmt.Errorf("receiving password: %w", err)
+	}
+	password, ok := msg.(*pgproto3.PasswordMessage)
+	if !ok {
+		return fmt.Errorf("expected a password, got %T", msg)
+	}
+	if err := c.cfg.Logins.Authenticate(ctx, c.user, password.Password); err != nil {
+		buf := (&pgproto3.ErrorResponse{
+			Severity: "FATAL",
+			Code:     "28P01",
+			Message:  fmt.Sprintf("password authentication failed for user %q", c.user),
+		}).Encode(nil)
+		c.netConn.Write(buf)
+		return fmt.Errorf("password authentication failed for user %q: %w", c.user, err)
+	}
+	return nil
+}
+
 // ReadRequest reads the next request from the client.
 func (c *Conn) ReadRequest() (protocol.Request, error) {
 	c.mu.Lock()
@@ -281,7 +404,14 @@ func (c *Conn) ReadRequest() (protocol.Request, error) {
 		// Convert parameters
 		params := make(map[string]interface{})
 		for i, p := range m.Parameters {
-			params[fmt.Sprintf("$%d", i+1)] = string(p)
+			if p == nil {
+				params[fmt.Sprintf("$%d", i+1)] = nil
+			} else if paramFormat(m.ParameterFormatCodes, i) == 1 {
+				// Binary format: pass the bytes on, as for a bytea
+				params[fmt.Sprintf("$%d", i+1)] = bytes.Clone(p)
+			} else {
+				params[fmt.Sprintf("$%d", i+1)] = string(p)
+			}
 		}
 		return protocol.Request{
 			Type:       protocol.RequestExec,
@@ -381,8 +511,9 @@ func (c *Conn) parseQuery(sql string) (protocol.Request, error) {
 	default:
 		// Treat as regular query
 		return protocol.Request{
-			Type: protocol.RequestQuery,
-			SQL:  sql,
+			Type:    protocol.RequestQuery,
+			SQL:     sql,
+			Options: protocol.RequestOptions{Stream: true},
 		}, nil
 	}
 }
@@ -398,18 +529,18 @@ func (c *Conn) SendResult(result protocol.Result) error {
 
 	var buf []byte
 
+	// Warnings precede the results as notices
+	for _, w := range result.Warnings {
+		buf = (&pgproto3.NoticeResponse{
+			Severity: "WARNING",
+			Code:     "01000",
+			Message:  w,
+		}).Encode(buf)
+	}
+
 	switch result.Type {
 	case protocol.ResultError:
-		// Send ErrorResponse
-		errMsg := "ERROR"
-		if result.Error != nil {
-			errMsg = result.Error.Error()
-		}
-		buf = (&pgproto3.ErrorResponse{
-			Severity: "ERROR",
-			Code:     "XX000", // internal error
-			Message:  errMsg,
-		}).Encode(buf)
+		buf = errorResponse(buf, result.Error)
 
 	case protocol.ResultOK:
 		// Send CommandComplete
@@ -441,16 +572,24 @@ func (c *Conn) SendResult(result protocol.Result) error {
 			buf = (&pgproto3.RowDescription{Fields: fields}).Encode(buf)
 
 			// DataRows
-			for _, row := range rs.Rows {
-				values := make([][]byte, len(row))
-				for i, val := range row {
-					if val == nil {
-						values[i] = nil
-					} else {
-						values[i] = []byte(fmt.Sprintf("%v", val))
-					}
+			if rs.Stream != nil {
+				var n int
+				var err error
+				if buf, n, err = c.writeRowStream(buf, rs.Stream); err != nil {
+					return err
 				}
-				buf = (&pgproto3.DataRow{Values: values}).Encode(buf)
+				if err := rs.Stream.Err(); err != nil {
+					// The query failed part way: the error ends the rows
+					buf = errorResponse(buf, err)
+					break
+				}
+				buf = (&pgproto3.CommandComplete{
+					CommandTag: []byte(fmt.Sprintf("SELECT %d", n)),
+				}).Encode(buf)
+				continue
+			}
+			for _, row := range rs.Rows {
+				buf = dataRow(buf, row)
 			}
 
 			// CommandComplete
@@ -479,6 +618,61 @@ func (c *Conn) SendResult(result protocol.Result) error {
 	return err
 }
 
+// errorResponse appends an ErrorResponse for err to buf.
+func errorResponse(buf []byte, err error) []byte {
+	errMsg := "ERROR"
+	code := "XX000" // internal error
+	if err != nil {
+		errMsg = err.Error()
+		if sqlErr := aulerrors.FindSQLError(err); sqlErr != nil {
+			if state, ok := sqlErr.Fields[aulerrors.FieldSQLState].(string); ok {
+				errMsg = sqlErr.Message
+				code = state
+			}
+		}
+	}
+	return (&pgproto3.ErrorResponse{
+		Severity: "ERROR",
+		Code:     code,
+		Message:  errMsg,
+	}).Encode(buf)
+}
+
+// dataRow appends a DataRow of row, in text format, to buf.
+func dataRow(buf []byte, row []interface{}) []byte {
+	values := make([][]byte, len(row))
+	for i, val := range row {
+		if val == nil {
+			values[i] = nil
+		} else {
+			values[i] = textValue(val)
+		}
+	}
+	return (&pgproto3.DataRow{Values: values}).Encode(buf)
+}
+
+// streamFlushBytes is how much of a streamed result set is buffered
+// before it is written.
+const streamFlushBytes = 64 << 10
+
+// writeRowStream appends a DataRow for each row of stream to buf as it is
+// read, writing buf whenever it has grown past streamFlushBytes. It
+// returns what is left to write and the number of rows.
+func (c *Conn) writeRowStream(buf []byte, stream protocol.RowStream) ([]byte, int, error) {
+	n := 0
+	for stream.Next() {
+		buf = dataRow(buf, stream.Row())
+		n++
+		if len(buf) >= streamFlushBytes {
+			if _, err := c.netConn.Write(buf); err != nil {
+				return nil, n, err
+			}
+			buf = buf[:0]
+		}
+	}
+	return buf, n, nil
+}
+
 // Close closes the connection.
 func (c *Conn) Close() error {
 	c.mu.Lock()
@@ -492,6 +686,19 @@ func (c *Conn) Close() error {
 	return c.netConn.Close()
 }
 
+// WatchCancel calls cancel when a cancel request names the connection
+// while a request runs.
+func (c *Conn) WatchCancel(cancel func()) (stop func()) {
+	c.cancelMu.Lock()
+	c.cancel = cancel
+	c.cancelMu.Unlock()
+	return func() {
+		c.cancelMu.Lock()
+		c.cancel = nil
+		c.cancelMu.Unlock()
+	}
+}
+
 // RemoteAddr returns the remote address.
 func (c *Conn) RemoteAddr() net.Addr {
 	return c.netConn.RemoteAddr()
@@ -508,6 +715,9 @@ func (c *Conn) Properties() map[string]string {
 	if c.user != "" {
 		props["user"] = c.user
 	}
+	if c.peer != nil {
+		props["os_user"] = c.peer.User
+	}
 	if c.database != "" {
 		props["database"] = c.database
 	}
@@ -552,6 +762,27 @@ func splitWords(s string) []string {
 	return words
 }
 
+// paramFormat returns the format code of parameter i of a Bind: one code
+// applies to every parameter, none means text.
+func paramFormat(codes []int16, i int) int16 {
+	switch {
+	case len(codes) == 1:
+		return codes[0]
+	case i < len(codes):
+		return codes[i]
+	}
+	return 0
+}
+
+// textValue encodes a column value in text format. Binary values use the
+// hex format of bytea.
+func textValue(val interface{}) []byte {
+	if b, ok := val.([]byte); ok {
+		return []byte(`\x` + hex.EncodeToString(b))
+	}
+	return []byte(fmt.Sprintf("%v", val))
+}
+
 // pgTypeOID returns the PostgreSQL type OID for a given SQL type.
 func pgTypeOID(sqlType string) uint32 {
 	// Common PostgreSQL type OIDs
@@ -6,11 +6,20 @@
 package protocol
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ha1tch/aul/pkg/erasure"
+	"github.com/ha1tch/aul/pkg/features"
 	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
 )
 
 // ProtocolType identifies a wire protocol.
@@ -63,6 +72,9 @@ type Listener interface {
 	// Addr returns the listener's network address.
 	Addr() net.Addr
 
+	// Addrs returns every address the listener is bound to.
+	Addrs() []net.Addr
+
 	// ConnectionCount returns the number of active connections.
 	ConnectionCount() int
 }
@@ -89,6 +101,15 @@ type Connection interface {
 	Properties() map[string]string
 }
 
+// CancelWatcher is implemented by connections whose clients can cancel the
+// request being run: with a TDS attention, a PostgreSQL cancel request or
+// by an HTTP client going away.
+type CancelWatcher interface {
+	// WatchCancel calls cancel if the client cancels the request last
+	// read before stop is called.
+	WatchCancel(cancel func()) (stop func())
+}
+
 // ListenerConfig configures a protocol listener.
 type ListenerConfig struct {
 	// Listener identification
@@ -96,8 +117,13 @@ type ListenerConfig struct {
 	Protocol ProtocolType
 
 	// Network configuration
-	Host string
-	Port int
+	Host  string
+	Hosts []string // Bound instead of Host, for more than one address
+	Port  int
+
+	// Unix socket, bound instead of the TCP addresses
+	Socket   string
+	PeerAuth *PeerMap // Authenticate socket clients by their OS user (nil = off)
 
 	// TLS configuration
 	TLSEnabled  bool
@@ -110,8 +136,216 @@ type ListenerConfig struct {
 	WriteTimeout   time.Duration
 	IdleTimeout    time.Duration
 
+	// Reject statements that change the database
+	ReadOnly bool
+
+	// Reject ad-hoc SQL holding string literals unless it was sent with
+	// parameters
+	ParameterizedOnly bool
+
+	// Require clients to sign in with a SQL login. Logins is set by the
+	// server for listeners that do.
+	RequireLogin bool
+	Logins       Authenticator
+
 	// Protocol-specific options
 	Options map[string]interface{}
+
+	// Admin is set by the server for listeners that serve admin routes
+	Admin Admin
+}
+
+// Authenticator checks the credentials clients sign in with.
+type Authenticator interface {
+	// Authenticate returns an error unless password is that of the
+	// enabled login user.
+	Authenticate(ctx context.Context, user, password string) error
+}
+
+// Admin is what the server offers listeners' admin routes.
+type Admin interface {
+	// ShadowCandidates describes the changed procedures running in shadow.
+	ShadowCandidates() []ShadowCandidate
+	// PromoteShadow replaces a procedure with its shadow candidate.
+	PromoteShadow(procedure string) error
+	// DiscardShadow drops a procedure's shadow candidate.
+	DiscardShadow(procedure string) error
+
+	// Deployment describes the active, staged and previous procedure sets.
+	Deployment() Deployment
+	// StageDeployment loads the procedures in dir, or the procedure
+	// directory when dir is empty, as the staged set and validates them.
+	StageDeployment(dir string) error
+	// SwitchDeployment makes the staged set active.
+	SwitchDeployment() error
+	// RollbackDeployment makes the previous set active again.
+	RollbackDeployment() error
+	// DiscardDeployment drops the staged set.
+	DiscardDeployment() error
+
+	// FeatureFlags lists the feature flags procedures read with FEATURE().
+	FeatureFlags() []features.Flag
+	// SetFeatureFlag defines a feature flag or replaces it.
+	SetFeatureFlag(flag features.Flag) error
+	// DeleteFeatureFlag removes a feature flag, turning it off.
+	DeleteFeatureFlag(name string) error
+
+	// Compatibility reports the constructs in the named procedure, or in
+	// every procedure when procedure is empty, that the interpreter
+	// cannot run.
+	Compatibility(procedure string) ([]CompatibilityReport, error)
+
+	// Listeners describes the running listeners and the addresses they
+	// are bound to.
+	Listeners() []ListenerInfo
+
+	// Traces describes the traces kept of recent executions, oldest
+	// first.
+	Traces() []TraceInfo
+	// Trace returns a kept trace by the ID of the request that made it.
+	Trace(id string) (*Trace, error)
+	// TracedProcedures lists the procedures traced on every call.
+	TracedProcedures() []string
+	// SetProcedureTrace sets whether every call of a procedure is traced.
+	SetProcedureTrace(procedure string, on bool) error
+
+	// Deprecations describes the deprecated procedures and who still
+	// calls them.
+	Deprecations() []Deprecation
+	// SetDeprecated deprecates a procedure until the server restarts, or
+	// takes back such a deprecation. message tells callers what to use
+	// instead.
+	SetDeprecated(procedure, message string, on bool) error
+
+	// EraseSubject deletes or overwrites a data subject's personal data
+	// as the erasure map directs, or counts what it would with
+	// req.DryRun, returning the certificate it records in the audit log.
+	EraseSubject(req erasure.Request) (*erasure.Result, error)
+
+	// PurgeTable deletes the rows of a table matching a condition in
+	// batches, each its own transaction, until none are left, req
+	// MaxBatches have run or ctx is done.
+	PurgeTable(ctx context.Context, req PurgeRequest) (*PurgeResult, error)
+}
+
+// ListenerInfo describes a running listener.
+type ListenerInfo struct {
+	Name      string   `json:"name"`
+	Protocol  string   `json:"protocol"`
+	Addresses []string `json:"addresses"` // Resolved host:port of each address bound
+	ReadOnly  bool     `json:"read_only,omitempty"`
+}
+
+// ShadowCandidate is a changed procedure running in shadow, with how its
+// results and latency compare with the version serving calls.
+type ShadowCandidate struct {
+	Procedure        string     `json:"procedure"`
+	StagedAt         time.Time  `json:"staged_at"`
+	Runs             int64      `json:"runs"`
+	Matches          int64      `json:"matches"`
+	Divergences      int64      `json:"divergences"`
+	CurrentAvgMs     float64    `json:"current_avg_ms"`
+	CandidateAvgMs   float64    `json:"candidate_avg_ms"`
+	LastDivergence   string     `json:"last_divergence,omitempty"`
+	LastDivergenceAt *time.Time `json:"last_divergence_at,omitempty"`
+}
+
+// Deployment describes the procedure sets of a blue/green deployment: the
+// one serving calls, the one staged to replace it and the one it
+// replaced, kept for rollback.
+type Deployment struct {
+	Active   *ProcedureSet `json:"active"`
+	Staged   *ProcedureSet `json:"staged,omitempty"`
+	Previous *ProcedureSet `json:"previous,omitempty"`
+}
+
+// ProcedureSet describes one procedure set of a deployment. Added,
+// Changed and Removed compare a staged set with the active one.
+type ProcedureSet struct {
+	Source      string     `json:"source"`
+	Procedures  int        `json:"procedures"`
+	LoadedAt    time.Time  `json:"loaded_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+	Added       []string   `json:"added,omitempty"`
+	Changed     []string   `json:"changed,omitempty"`
+	Removed     []string   `json:"removed,omitempty"`
+	Problems    []string   `json:"problems,omitempty"`
+}
+
+// CompatibilityReport lists the constructs in a procedure that the
+// interpreter cannot run, each with where it is and a workaround.
+type CompatibilityReport struct {
+	Procedure   string                   `json:"procedure"`
+	File        string                   `json:"file,omitempty"`
+	Compatible  bool                     `json:"compatible"`
+	Error       string                   `json:"error,omitempty"` // Why the procedure could not be checked
+	Diagnostics []tsqlruntime.Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// TraceInfo describes the trace of an execution.
+type TraceInfo struct {
+	ID         string    `json:"id"`                  // Of the request traced
+	Procedure  string    `json:"procedure,omitempty"` // "" for a batch
+	SessionID  string    `json:"session_id"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs float64   `json:"duration_ms"`
+	Events     int       `json:"events"`
+	Dropped    int       `json:"dropped,omitempty"` // Events past the limit a trace holds
+	Error      string    `json:"error,omitempty"`   // The execution failed
+}
+
+// Trace is the record of each statement an execution ran, each value it
+// assigned to a variable and each branch it took.
+type Trace struct {
+	TraceInfo
+	Steps []tsqlruntime.TraceEvent `json:"steps"`
+}
+
+// Deprecation describes a deprecated procedure and who has called it
+// since the server started.
+type Deprecation struct {
+	Procedure  string             `json:"procedure"`
+	Message    string             `json:"message,omitempty"`
+	Source     string             `json:"source"` // "annotation" or "admin"
+	Calls      int64              `json:"calls"`
+	LastCallAt *time.Time         `json:"last_call_at,omitempty"`
+	Callers    []DeprecatedCaller `json:"callers"` // Most calls first
+}
+
+// DeprecatedCaller counts the calls one user, application and protocol
+// made to a deprecated procedure.
+type DeprecatedCaller struct {
+	User        string    `json:"user"`
+	App         string    `json:"app,omitempty"`
+	Protocol    string    `json:"protocol"`
+	Calls       int64     `json:"calls"`
+	FirstCallAt time.Time `json:"first_call_at"`
+	LastCallAt  time.Time `json:"last_call_at"`
+}
+
+// PurgeRequest asks for the rows of Table matching Where, a search
+// condition such as "CreatedAt < '2024-01-01'", to be deleted BatchSize at
+// a time, waiting Delay between batches so that other sessions can take
+// the locks each batch holds.
+type PurgeRequest struct {
+	Table      string `json:"table"`                 // [schema.]table
+	Where      string `json:"where"`                 // Required; "1 = 1" purges every row
+	BatchSize  int    `json:"batch_size,omitempty"`  // Rows per batch (0 = 1000)
+	Delay      string `json:"delay,omitempty"`       // Go duration between batches, e.g. 100ms
+	MaxBatches int    `json:"max_batches,omitempty"` // Batches to run at most (0 = no limit)
+	Database   string `json:"database,omitempty"`    // Database to purge ("" = the default)
+	Tenant     string `json:"tenant,omitempty"`      // Tenant to purge, when multi-tenant
+}
+
+// PurgeResult is what a purge deleted. Complete is false if it stopped,
+// at MaxBatches or because it was cancelled, before a batch found fewer
+// rows than BatchSize left.
+type PurgeResult struct {
+	Statement  string  `json:"statement"` // Run for each batch
+	Batches    int     `json:"batches"`
+	Rows       int64   `json:"rows"`
+	Complete   bool    `json:"complete"`
+	DurationMs float64 `json:"duration_ms"`
 }
 
 // DefaultListenerConfig returns a ListenerConfig with sensible defaults.
@@ -129,9 +363,11 @@ func DefaultListenerConfig(proto ProtocolType) ListenerConfig {
 	}
 }
 
-// Address returns the full listen address.
+// Address returns the full listen address of Host, bracketing an IPv6
+// address.
 func (c ListenerConfig) Address() string {
-	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+	host := strings.TrimSuffix(strings.TrimPrefix(c.Host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(c.Port))
 }
 
 // RequestType identifies the type of client request.
@@ -180,10 +416,12 @@ func (r RequestType) String() string {
 
 // Request represents a client request.
 type Request struct {
+	ID            string // Correlates the request's log entries and errors
 	Type          RequestType
 	SQL           string                 // For ad-hoc queries
 	ProcedureName string                 // For EXEC/CALL
 	Parameters    map[string]interface{} // Named parameters
+	Outputs       []string               // Parameters of a query to return in Result.OutputParams
 	Options       RequestOptions
 }
 
@@ -194,6 +432,11 @@ type RequestOptions struct {
 	RowsToFetch   int    // Limit rows returned
 	CursorType    string // Cursor type for scrollable results
 	StatementID   string // For prepared statements
+	DryRun        bool   // Roll back, listing the writes made in a last result set
+	Summary       bool   // Report what each statement did in Result.Statements
+	Trace         bool   // Record the execution's steps in a trace, named by Result.TraceID
+	Parameterized bool   // The SQL was sent through a parameterized API, such as sp_executesql
+	Stream        bool   // The listener can take a result set as a RowStream
 }
 
 // ResultType identifies the type of result.
@@ -236,23 +479,90 @@ type Result struct {
 	ResultSets   []ResultSet
 	ReturnValue  interface{}
 	OutputParams map[string]interface{}
+	Warnings     []string           // Informational messages sent ahead of the results
+	RequestID    string             // ID of the request, reported with errors
+	Statements   []StatementSummary // What each statement did, when summarised
+	TraceID      string             // ID of the trace recorded, when traced
+}
+
+// StatementSummary is what one statement of an execution did. A statement
+// run more than once, as in a loop, has its totals.
+type StatementSummary struct {
+	Type         string // SELECT, INSERT, UPDATE, ...
+	Target       string // Table read or written ("" = none)
+	Statement    string
+	Executions   int64
+	RowsAffected int64
+	Duration     time.Duration
+}
+
+// PanicError is returned by a listener or connection that recovered from a
+// panic while handling what a client sent. Only that client's connection is
+// lost; the server counts and logs these.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Recover, deferred by a function returning err, turns a panic into a
+// PanicError.
+func Recover(err *error) {
+	if v := recover(); v != nil {
+		*err = &PanicError{Value: v, Stack: debug.Stack()}
+	}
+}
+
+// NewRequestID returns a random request ID: 32 hex digits, the form of a
+// W3C trace id, so that an id a client sends can be used as it is.
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
 }
 
 // ResultSet represents a tabular result set.
 type ResultSet struct {
 	Columns []ColumnInfo
 	Rows    [][]interface{}
+	Stream  RowStream // The rows, read as they are produced, in place of Rows
+}
+
+// RowStream is the rows of a result set read one at a time as a query
+// produces them, for results too large to hold in memory. It is sent only
+// to requests with RequestOptions.Stream set, whose listener writes each
+// row as it is read. Next reports false at the end of the rows or when the
+// query fails, which Err then tells.
+type RowStream interface {
+	Next() bool
+	Row() []interface{}
+	Err() error
+	Close() error
+}
+
+// CloseStreams closes the row streams of result's result sets, stopping
+// their queries if they have not been read to the end.
+func CloseStreams(result Result) {
+	for _, rs := range result.ResultSets {
+		if rs.Stream != nil {
+			rs.Stream.Close()
+		}
+	}
 }
 
 // ColumnInfo describes a column in a result set.
 type ColumnInfo struct {
-	Name     string
-	Type     string // SQL type name
-	GoType   string // Go type name
-	Nullable bool
-	Length   int
-	Scale    int
-	Ordinal  int
+	Name        string
+	Type        string // SQL type name
+	GoType      string // Go type name
+	Nullable    bool
+	Length      int
+	Scale       int
+	Ordinal     int
+	Sensitivity *tsqlruntime.Sensitivity // Classification of the column, if any
 }
 
 // NewListener creates a listener for the specified protocol.
@@ -303,7 +613,11 @@ func newHTTPListener(cfg ListenerConfig, logger *log.Logger) (Listener, error) {
 }
 
 func newGRPCListener(cfg ListenerConfig, logger *log.Logger) (Listener, error) {
-	return nil, fmt.Errorf("gRPC protocol not yet implemented")
+	// Import cycle prevention: use a factory function set by grpc package
+	if grpcListenerFactory == nil {
+		return nil, fmt.Errorf("gRPC protocol not registered")
+	}
+	return grpcListenerFactory(cfg, logger)
 }
 
 // ListenerFactory is a function that creates a new listener.
@@ -313,6 +627,7 @@ var (
 	tdsListenerFactory      ListenerFactory
 	postgresListenerFactory ListenerFactory
 	httpListenerFactory     ListenerFactory
+	grpcListenerFactory     ListenerFactory
 )
 
 // RegisterTDSFactory registers the TDS listener factory.
@@ -329,3 +644,8 @@ func RegisterPostgresFactory(f ListenerFactory) {
 func RegisterHTTPFactory(f ListenerFactory) {
 	httpListenerFactory = f
 }
+
+// RegisterGRPCFactory registers the gRPC listener factory.
+func RegisterGRPCFactory(f ListenerFactory) {
+	grpcListenerFactory = f
+}
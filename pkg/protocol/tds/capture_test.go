@@ -0,0 +1,122 @@
+package tds
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/protocol/capture"
+	"github.com/ha1tch/aul/pkg/tds"
+)
+
+// startTestListener starts a listener and accepts a single connection in
+// the background.
+func startTestListener(t *testing.T, options map[string]interface{}) (protocol.Listener, <-chan protocol.Connection) {
+	t.Helper()
+	cfg := protocol.ListenerConfig{
+		Name:     "test-tds",
+		Protocol: protocol.ProtocolTDS,
+		Host:     "127.0.0.1",
+		Port:     0,
+		Options:  options,
+	}
+	listener, err := New(cfg, log.New(log.DefaultConfig()))
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	if err := listener.Listen(); err != nil {
+		t.Fatalf("Failed to start listener: %v", err)
+	}
+
+	accepted := make(chan protocol.Connection, 1)
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+	return listener, accepted
+}
+
+func TestCaptureRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	// Record a PRELOGIN/LOGIN7 exchange
+	listener, accepted := startTestListener(t, map[string]interface{}{"capture_dir": dir})
+	defer listener.Close()
+
+	conn, err := net.DialTimeout("tcp", listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	buf := make([]byte, 4096)
+	for _, pkt := range [][]byte{
+		buildTDSPacket(tds.PacketPrelogin, buildPreloginRequest()),
+		buildTDSPacket(tds.PacketLogin7, buildLogin7Request("testuser", "testpass", "testdb", "testapp")),
+	} {
+		if _, err := conn.Write(pkt); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+	}
+	conn.Close()
+
+	select {
+	case serverConn, ok := <-accepted:
+		if !ok {
+			t.Fatal("Accept failed")
+		}
+		serverConn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for accept")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "tds-*.json"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 capture file, got %d", len(files))
+	}
+	sess, err := capture.Load(files[0])
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !sess.Sanitised {
+		t.Error("capture should be sanitised")
+	}
+	if bytes.Contains(sess.ClientBytes(), manglePassword("testpass")) {
+		t.Error("capture contains the LOGIN7 password")
+	}
+
+	// Replay against a fresh listener without capture
+	replayListener, replayAccepted := startTestListener(t, nil)
+	defer replayListener.Close()
+
+	replayConn, err := net.DialTimeout("tcp", replayListener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer replayConn.Close()
+
+	result, err := capture.Replay(replayConn, sess, capture.DefaultReplayOptions())
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(result.Exchanges) != 2 {
+		t.Errorf("expected 2 exchanges, got %d", len(result.Exchanges))
+	}
+	for _, m := range result.Mismatches() {
+		t.Error(m)
+	}
+
+	if c, ok := <-replayAccepted; ok {
+		c.Close()
+	}
+}
@@ -1,11 +1,17 @@
 package tds
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
 	"github.com/ha1tch/aul/pkg/log"
 	"github.com/ha1tch/aul/pkg/protocol"
 	"github.com/ha1tch/aul/pkg/tds"
@@ -27,6 +33,10 @@ type Connection struct {
 	tdsVersion uint32
 	packetSize int
 
+	// Code page of VARCHAR data: that of the client's locale, else the
+	// listener's default
+	charset *tds.Charset
+
 	// TLS configuration (nil means no TLS support)
 	tlsConfig *tls.Config
 
@@ -40,9 +50,28 @@ type Connection struct {
 	phase3      *Phase3Handlers
 	phase3State *ConnectionPhase3State
 
+	// Set when WatchCancel sees an attention, until ReadRequest reads it:
+	// the client discards the cancelled request's response, so what is
+	// left of it is not sent
+	attention atomic.Bool
+
+	// Set while an RPC is answered, which ends with its return status and
+	// the values of the OUTPUT parameters it was sent
+	rpc     bool
+	outputs []rpcOutput
+
 	closed bool
 }
 
+// rpcOutput is an OUTPUT parameter of an RPC, returned to the client in a
+// RETURNVALUE token.
+type rpcOutput struct {
+	ordinal uint16
+	name    string // Without the @ some clients leave off
+	typ     tds.TypeInfo
+	value   interface{} // Set by the connection, as for sp_prepare's handle
+}
+
 // Authenticator validates login credentials.
 type Authenticator interface {
 	// Authenticate validates the username and password.
@@ -57,19 +86,29 @@ func (d DefaultAuthenticator) Authenticate(username, password, database string)
 	return nil
 }
 
+// loginAuthenticator checks LOGIN7 credentials against the SQL logins of
+// a listener that requires them.
+type loginAuthenticator struct {
+	logins protocol.Authenticator
+}
+
+func (a loginAuthenticator) Authenticate(username, password, database string) error {
+	return a.logins.Authenticate(context.Background(), username, password)
+}
+
 // handshake performs the TDS connection handshake.
 // Flow for TDS 7.x: PRELOGIN → (optional TLS wrapped in TDS) → LOGIN7 → LOGINACK
 // Flow for TDS 8.0 strict: (TLS already done) → PRELOGIN → LOGIN7 → LOGINACK
 func (c *Connection) handshake() error {
 	// Step 1: Read PRELOGIN
-	c.logger.Application().Debug("waiting for PRELOGIN", "spid", c.spid, "tds8_strict", c.isTDS8Strict)
+	c.logger.Protocol().Debug("waiting for PRELOGIN", "spid", c.spid, "tds8_strict", c.isTDS8Strict)
 	
 	pktType, data, err := c.tdsConn.ReadPacket()
 	if err != nil {
 		return fmt.Errorf("reading prelogin: %w", err)
 	}
 	
-	c.logger.Application().Debug("received packet", "spid", c.spid, "type", pktType.String(), "len", len(data))
+	c.logger.Protocol().Debug("received packet", "spid", c.spid, "type", pktType.String(), "len", len(data))
 	
 	if pktType != tds.PacketPrelogin {
 		return fmt.Errorf("expected PRELOGIN packet, got %s", pktType)
@@ -80,7 +119,7 @@ func (c *Connection) handshake() error {
 		return fmt.Errorf("parsing prelogin: %w", err)
 	}
 
-	c.logger.Application().Debug("PRELOGIN received",
+	c.logger.Protocol().Debug("PRELOGIN received",
 		"spid", c.spid,
 		"encryption", prelogin.Encryption,
 		"mars", prelogin.MARS,
@@ -98,7 +137,7 @@ func (c *Connection) handshake() error {
 		encryptResp = c.negotiateEncryption(prelogin.Encryption)
 	}
 	
-	c.logger.Application().Debug("sending PRELOGIN response", "spid", c.spid, "encrypt_resp", encryptResp)
+	c.logger.Protocol().Debug("sending PRELOGIN response", "spid", c.spid, "encrypt_resp", encryptResp)
 
 	preloginResp := &tds.PreloginResponse{
 		Version:    c.listener.serverVersion,
@@ -115,35 +154,35 @@ func (c *Connection) handshake() error {
 	// Step 3: Handle TLS handshake if needed
 	if c.isTDS8Strict {
 		// TDS 8.0 strict mode - TLS already done before PRELOGIN
-		c.logger.Application().Debug("TDS 8.0 strict mode, TLS already complete", "spid", c.spid)
+		c.logger.Protocol().Debug("TDS 8.0 strict mode, TLS already complete", "spid", c.spid)
 	} else if encryptResp == tds.EncryptOn || encryptResp == tds.EncryptReq {
 		// Standard TDS 7.x TLS handshake (may be wrapped in TDS or raw)
-		c.logger.Application().Debug("starting TDS 7.x TLS handshake", "spid", c.spid)
+		c.logger.Protocol().Debug("starting TDS 7.x TLS handshake", "spid", c.spid)
 		if err := c.performTLSHandshake(); err != nil {
 			return fmt.Errorf("TLS handshake: %w", err)
 		}
-		c.logger.Application().Debug("TLS handshake completed", "spid", c.spid)
+		c.logger.Protocol().Debug("TLS handshake completed", "spid", c.spid)
 	}
 
 	// Step 4: Read LOGIN7 (or detect login-only TLS)
-	c.logger.Application().Debug("waiting for LOGIN7", "spid", c.spid)
+	c.logger.Protocol().Debug("waiting for LOGIN7", "spid", c.spid)
 	
 	pktType, data, err = c.tdsConn.ReadPacket()
 	if err != nil {
 		return fmt.Errorf("reading login: %w", err)
 	}
 	
-	c.logger.Application().Debug("received packet after TLS", "spid", c.spid, "type", pktType.String(), "len", len(data))
+	c.logger.Protocol().Debug("received packet after TLS", "spid", c.spid, "type", pktType.String(), "len", len(data))
 	
 	// Check for login-only encryption: client sends TLS in PRELOGIN even though we said EncryptOff
 	if pktType == tds.PacketPrelogin && len(data) > 0 && data[0] == 0x16 && c.tlsConfig != nil {
-		c.logger.Application().Debug("detected login-only TLS (ClientHello in PRELOGIN after EncryptOff)", "spid", c.spid)
+		c.logger.Protocol().Debug("detected login-only TLS (ClientHello in PRELOGIN after EncryptOff)", "spid", c.spid)
 		
 		// Do TLS handshake with this data as the first ClientHello
 		if err := c.performTLSHandshakeWithInitialData(data); err != nil {
 			return fmt.Errorf("login-only TLS handshake: %w", err)
 		}
-		c.logger.Application().Debug("login-only TLS handshake completed", "spid", c.spid)
+		c.logger.Protocol().Debug("login-only TLS handshake completed", "spid", c.spid)
 		
 		// Mark this as login-only TLS so we revert to plaintext after login
 		c.tdsConn.SetLoginOnlyTLS(true)
@@ -153,17 +192,17 @@ func (c *Connection) handshake() error {
 		if err != nil {
 			return fmt.Errorf("reading login after TLS: %w", err)
 		}
-		c.logger.Application().Debug("received packet after login-only TLS", "spid", c.spid, "type", pktType.String(), "len", len(data))
+		c.logger.Protocol().Debug("received packet after login-only TLS", "spid", c.spid, "type", pktType.String(), "len", len(data))
 		
 		// Per MS-TDS spec: "If login-only encryption was negotiated... then the first TDS packet 
 		// of the Login message MUST be encrypted using TLS/SSL... All other TDS packets sent or 
 		// received MUST be in plaintext."
 		// So we switch to plaintext IMMEDIATELY after reading LOGIN7, BEFORE sending LOGINACK.
-		c.logger.Application().Debug("login-only TLS: switching to plaintext after receiving LOGIN7", "spid", c.spid)
+		c.logger.Protocol().Debug("login-only TLS: switching to plaintext after receiving LOGIN7", "spid", c.spid)
 		if err := c.tdsConn.RevertToPlaintext(); err != nil {
 			return fmt.Errorf("reverting to plaintext after login: %w", err)
 		}
-		c.logger.Application().Debug("login-only TLS: now in plaintext mode", "spid", c.spid)
+		c.logger.Protocol().Debug("login-only TLS: now in plaintext mode", "spid", c.spid)
 	}
 	
 	if pktType != tds.PacketLogin7 {
@@ -175,13 +214,14 @@ func (c *Connection) handshake() error {
 		return fmt.Errorf("parsing login: %w", err)
 	}
 
-	c.logger.Application().Debug("LOGIN7 received",
+	c.logger.Protocol().Debug("LOGIN7 received",
 		"spid", c.spid,
 		"user", login.UserName,
 		"database", login.Database,
 		"app", login.AppName,
 		"host", login.HostName,
 		"tds_version", fmt.Sprintf("0x%08X", login.Header.TDSVersion),
+		"lcid", fmt.Sprintf("0x%04X", login.Header.ClientLCID),
 	)
 
 	// Step 5: Authenticate
@@ -191,9 +231,10 @@ func (c *Connection) handshake() error {
 	}
 
 	if err := auth.Authenticate(login.UserName, login.Password, login.Database); err != nil {
-		// Send login failed error
+		// Send login failed error, naming the user it was for
+		c.user = login.UserName
 		if sendErr := c.sendLoginError(err.Error()); sendErr != nil {
-			c.logger.Application().Error("failed to send login error", sendErr, "original_error", err)
+			c.logger.Protocol().Error("failed to send login error", sendErr, "original_error", err)
 		}
 		return fmt.Errorf("authentication failed: %w", err)
 	}
@@ -211,6 +252,12 @@ func (c *Connection) handshake() error {
 	if c.packetSize < tds.MinPacketSize {
 		c.packetSize = tds.DefaultPacketSize
 	}
+	if charset := tds.CharsetForLCID(login.Header.ClientLCID); charset != nil {
+		c.charset = charset
+	}
+	if c.charset == nil {
+		c.charset = tds.DefaultCharset
+	}
 
 	// Update TDS connection state
 	c.tdsConn.SetUser(c.user)
@@ -228,9 +275,17 @@ func (c *Connection) handshake() error {
 	return nil
 }
 
+// guardedHandshake performs the handshake, turning a panic while parsing
+// the client's PRELOGIN or LOGIN7 into an error that loses only this
+// connection.
+func (c *Connection) guardedHandshake() (err error) {
+	defer protocol.Recover(&err)
+	return c.handshake()
+}
+
 // negotiateEncryption determines the encryption level based on client request and server config.
 func (c *Connection) negotiateEncryption(clientEncrypt uint8) uint8 {
-	c.logger.Application().Debug("negotiating encryption",
+	c.logger.Protocol().Debug("negotiating encryption",
 		"spid", c.spid,
 		"client_requested", clientEncrypt,
 		"tls_configured", c.tlsConfig != nil,
@@ -250,7 +305,7 @@ func (c *Connection) negotiateEncryption(clientEncrypt uint8) uint8 {
 		default:
 			resp = tds.EncryptNotSup
 		}
-		c.logger.Application().Debug("encryption negotiated (TLS available)",
+		c.logger.Protocol().Debug("encryption negotiated (TLS available)",
 			"spid", c.spid,
 			"response", resp,
 		)
@@ -268,7 +323,7 @@ func (c *Connection) negotiateEncryption(clientEncrypt uint8) uint8 {
 	default:
 		resp = tds.EncryptNotSup
 	}
-	c.logger.Application().Debug("encryption negotiated (no TLS)",
+	c.logger.Protocol().Debug("encryption negotiated (no TLS)",
 		"spid", c.spid,
 		"response", resp,
 	)
@@ -281,15 +336,15 @@ func (c *Connection) performTLSHandshake() error {
 		return fmt.Errorf("TLS not configured")
 	}
 
-	c.logger.Application().Debug("starting TDS-wrapped TLS handshake", "spid", c.spid)
+	c.logger.Protocol().Debug("starting TDS-wrapped TLS handshake", "spid", c.spid)
 	
 	// Perform TDS-wrapped TLS handshake
 	if err := c.tdsConn.UpgradeToTLS(c.tlsConfig); err != nil {
-		c.logger.Application().Warn("TLS handshake failed", "spid", c.spid, "err", err)
+		c.logger.Protocol().Warn("TLS handshake failed", "spid", c.spid, "err", err)
 		return err
 	}
 
-	c.logger.Application().Debug("TDS-wrapped TLS handshake succeeded", "spid", c.spid)
+	c.logger.Protocol().Debug("TDS-wrapped TLS handshake succeeded", "spid", c.spid)
 	return nil
 }
 
@@ -300,18 +355,18 @@ func (c *Connection) performTLSHandshakeWithInitialData(initialData []byte) erro
 		return fmt.Errorf("TLS not configured")
 	}
 
-	c.logger.Application().Debug("starting TLS handshake with initial data",
+	c.logger.Protocol().Debug("starting TLS handshake with initial data",
 		"spid", c.spid,
 		"initial_len", len(initialData),
 	)
 	
 	// Perform TDS-wrapped TLS handshake with pre-read data
 	if err := c.tdsConn.UpgradeToTLSWithInitialData(c.tlsConfig, initialData); err != nil {
-		c.logger.Application().Warn("TLS handshake with initial data failed", "spid", c.spid, "err", err)
+		c.logger.Protocol().Warn("TLS handshake with initial data failed", "spid", c.spid, "err", err)
 		return err
 	}
 
-	c.logger.Application().Debug("TLS handshake with initial data succeeded", "spid", c.spid)
+	c.logger.Protocol().Debug("TLS handshake with initial data succeeded", "spid", c.spid)
 	return nil
 }
 
@@ -331,8 +386,8 @@ func (c *Connection) sendLoginAck() error {
 	// Send ENVCHANGE for packet size
 	tw.WriteEnvChange(tds.EnvPacketSize, fmt.Sprintf("%d", c.packetSize), fmt.Sprintf("%d", tds.DefaultPacketSize))
 
-	// Send ENVCHANGE for collation
-	tw.WriteEnvChangeCollation(tds.DefaultCollation, []byte{})
+	// Send ENVCHANGE for collation, whose code page VARCHAR data is in
+	tw.WriteEnvChangeCollation(c.charset.Collation(), []byte{})
 
 	// Send INFO message (optional welcome message)
 	tw.WriteInfo(
@@ -400,26 +455,49 @@ func (c *Connection) sendLoginError(message string) error {
 
 // ReadRequest reads the next request from the client.
 func (c *Connection) ReadRequest() (protocol.Request, error) {
-	pktType, status, data, err := c.tdsConn.ReadPacketWithStatus()
-	if err != nil {
-		return protocol.Request{}, err
-	}
+	for {
+		pktType, status, data, err := c.tdsConn.ReadPacketWithStatus()
+		if err != nil {
+			return protocol.Request{}, err
+		}
+
+		// Check for connection reset request
+		if status.IsResetConnection() {
+			c.resetSession(status.IsResetConnectionSkipTran())
+		}
 
-	// Check for connection reset request
-	if status.IsResetConnection() {
-		c.resetSession(status.IsResetConnectionSkipTran())
+		c.rpc, c.outputs = false, nil
+		switch pktType {
+		case tds.PacketSQLBatch:
+			req, err := c.parseSQLBatch(data)
+			req.ID = c.requestID(data)
+			return req, err
+		case tds.PacketRPCRequest:
+			req, answered, err := c.parseRPCRequest(data)
+			if answered && err == nil {
+				// The connection answered it, as it does sp_prepare
+				continue
+			}
+			req.ID = c.requestID(data)
+			return req, err
+		case tds.PacketAttention:
+			c.attention.Store(false)
+			return protocol.Request{Type: protocol.RequestCancel}, nil
+		default:
+			return protocol.Request{}, fmt.Errorf("unsupported packet type: %s", pktType)
+		}
 	}
+}
 
-	switch pktType {
-	case tds.PacketSQLBatch:
-		return c.parseSQLBatch(data)
-	case tds.PacketRPCRequest:
-		return c.parseRPCRequest(data)
-	case tds.PacketAttention:
-		return protocol.Request{Type: protocol.RequestCancel}, nil
-	default:
-		return protocol.Request{}, fmt.Errorf("unsupported packet type: %s", pktType)
+// requestID returns the ID a request is logged and reported by: the
+// client's trace activity ID when it sends one, else a new ID.
+func (c *Connection) requestID(data []byte) string {
+	if c.tdsVersion >= tds.VerTDS72 {
+		if id, ok := tds.TraceActivityID(data); ok {
+			return hex.EncodeToString(id)
+		}
 	}
+	return protocol.NewRequestID()
 }
 
 // resetSession resets the connection state (called on StatusResetConnection).
@@ -451,19 +529,39 @@ func (c *Connection) parseSQLBatch(data []byte) (protocol.Request, error) {
 	}
 
 	// Remaining data is the SQL text (UTF-16LE)
-	sqlText := ucs2ToString(data[offset:])
+	sqlText := tds.DecodeUCS2(data[offset:])
 
 	return protocol.Request{
-		Type: protocol.RequestQuery,
-		SQL:  sqlText,
+		Type:    protocol.RequestQuery,
+		SQL:     sqlText,
+		Options: protocol.RequestOptions{Stream: true},
 	}, nil
 }
 
-// parseRPCRequest parses an RPC_REQUEST packet.
-func (c *Connection) parseRPCRequest(data []byte) (protocol.Request, error) {
-	rpcReq, err := tds.ParseRPCRequest(data, c.tdsVersion)
+// parseRPCRequest parses an RPC_REQUEST packet. It returns answered true
+// for an RPC the connection answered itself, with the error of sending
+// the answer.
+func (c *Connection) parseRPCRequest(data []byte) (protocol.Request, bool, error) {
+	rpcReq, err := tds.ParseRPCRequestCharset(data, c.tdsVersion, c.charset)
 	if err != nil {
-		return protocol.Request{}, fmt.Errorf("parsing RPC request: %w", err)
+		return protocol.Request{}, false, fmt.Errorf("parsing RPC request: %w", err)
+	}
+
+	c.rpc = true
+	procID := rpcReq.ProcID
+	if procID == 0 {
+		// Called by name, as go-mssqldb calls them
+		procID = tds.ProcIDOf(rpcReq.ProcName)
+	}
+	switch procID {
+	case tds.ProcIDPrepare, tds.ProcIDPrepExec, tds.ProcIDExecute, tds.ProcIDUnprepare:
+		rpcReq.ProcID, rpcReq.ProcName = procID, tds.ProcIDName(procID)
+		return c.preparedRPC(rpcReq)
+	}
+	for i, p := range rpcReq.Parameters {
+		if p.IsOutput && p.Name != "" {
+			c.outputs = append(c.outputs, rpcOutput{ordinal: uint16(i), name: strings.TrimPrefix(p.Name, "@"), typ: p.Type})
+		}
 	}
 
 	// Convert parameters to map
@@ -482,10 +580,13 @@ func (c *Connection) parseRPCRequest(data []byte) (protocol.Request, error) {
 	// Determine request type
 	reqType := protocol.RequestExec
 	sql := ""
+	parameterized := false
+	var outputs []string
 
 	// Handle sp_executesql specially - extract SQL and params
 	if rpcReq.ProcID == tds.ProcIDExecuteSQL {
 		reqType = protocol.RequestQuery
+		parameterized = true
 		// First parameter is the SQL statement
 		if len(rpcReq.Parameters) > 0 && !rpcReq.Parameters[0].IsNull {
 			if s, ok := rpcReq.Parameters[0].Value.(string); ok {
@@ -501,8 +602,11 @@ func (c *Connection) parseRPCRequest(data []byte) (protocol.Request, error) {
 			if name == "" {
 				name = fmt.Sprintf("p%d", i-1) // Adjust numbering
 			}
-			if !p.IsNull {
-				actualParams[name] = p.Value
+			// A NULL is passed too: the batch has the variables of all
+			// the parameters declared, as OUTPUT ones are set
+			actualParams[name] = p.Value
+			if p.IsOutput && p.Name != "" {
+				outputs = append(outputs, p.Name)
 			}
 		}
 		params = actualParams
@@ -516,43 +620,36 @@ func (c *Connection) parseRPCRequest(data []byte) (protocol.Request, error) {
 		SQL:           sql,
 		ProcedureName: rpcReq.ProcName,
 		Parameters:    params,
-	}, nil
+		Outputs:       outputs,
+		Options:       protocol.RequestOptions{Parameterized: parameterized, Stream: true},
+	}, false, nil
 }
 
 // SendResult sends a result to the client.
 func (c *Connection) SendResult(result protocol.Result) error {
-	tw := tds.NewTokenWriter()
+	// WriteTokens has flushed the stream by the time it returns, so the
+	// buffer can go back to the pool for the next result.
+	tw := tds.AcquireTokenWriter()
+	defer tw.Release()
+
+	if c.attention.Load() && result.Type != protocol.ResultCancel {
+		return nil
+	}
 
 	switch result.Type {
 	case protocol.ResultError:
-		// Send ERROR token
-		errMsg := "An error occurred"
-		if result.Error != nil {
-			errMsg = result.Error.Error()
-		}
-		tw.WriteError(
-			50000, // User-defined error
-			1,
-			16, // Severity 16 = general error
-			errMsg,
-			c.serverName,
-			"",
-			1,
-		)
+		c.writeError(tw, result.Error, result.RequestID)
 		tw.WriteDone(tds.DoneError|tds.DoneFinal, 0, 0)
 
 	case protocol.ResultOK:
-		// Send output parameters if present
-		if len(result.OutputParams) > 0 {
-			c.writeOutputParams(tw, result.OutputParams)
-		}
-		
+		c.writeWarnings(tw, result.Warnings)
+
 		// Send DONE with row count
 		status := tds.DoneFinal
 		if result.RowsAffected > 0 {
 			status |= tds.DoneCount
 		}
-		tw.WriteDone(status, 0, uint64(result.RowsAffected))
+		c.writeDone(tw, result, status)
 
 	case protocol.ResultInfo:
 		// Send INFO message
@@ -568,19 +665,30 @@ func (c *Connection) SendResult(result protocol.Result) error {
 		tw.WriteDone(tds.DoneFinal, 0, 0)
 
 	case protocol.ResultRows:
+		c.writeWarnings(tw, result.Warnings)
+
 		// Send result sets
 		for _, rs := range result.ResultSets {
 			if err := c.writeResultSet(tw, rs); err != nil {
-				return err
+				// A response cut short by an attention ends with what it
+				// has written
+				if errors.Is(err, errAttention) {
+					tw.WriteDone(tds.DoneFinal, 0, 0)
+					return c.tdsConn.WriteTokens(tw)
+				}
+				// A stream whose query failed ends the response with
+				// its error
+				var failed *streamError
+				if !errors.As(err, &failed) {
+					return err
+				}
+				c.writeError(tw, failed.err, result.RequestID)
+				tw.WriteDone(tds.DoneError|tds.DoneFinal, 0, 0)
+				return c.tdsConn.WriteTokens(tw)
 			}
 		}
-		
-		// Send output parameters if present
-		if len(result.OutputParams) > 0 {
-			c.writeOutputParams(tw, result.OutputParams)
-		}
-		
-		tw.WriteDone(tds.DoneFinal, 0, uint64(result.RowsAffected))
+
+		c.writeDone(tw, result, tds.DoneFinal)
 
 	case protocol.ResultCancel:
 		// Acknowledge attention/cancellation
@@ -593,83 +701,108 @@ func (c *Connection) SendResult(result protocol.Result) error {
 	return c.tdsConn.WriteTokens(tw)
 }
 
-// writeOutputParams writes RETURNVALUE tokens for output parameters.
-func (c *Connection) writeOutputParams(tw *tds.TokenWriter, params map[string]interface{}) {
-	ordinal := uint16(0)
-	for name, value := range params {
-		col := inferColumnFromValue(value)
-		col.Name = name
-		
-		// Status: 0x01 = output parameter
-		tw.WriteReturnValue(ordinal, "@"+name, 0x01, 0, col, value)
-		ordinal++
+// writeError writes an ERROR token for err.
+func (c *Connection) writeError(tw *tds.TokenWriter, err error, requestID string) {
+	errMsg := "An error occurred"
+	var number int32 = 50000 // User-defined error
+	var class uint8 = 16     // Severity 16 = general error
+	if err != nil {
+		errMsg = err.Error()
+		if sqlErr := aulerrors.FindSQLError(err); sqlErr != nil {
+			errMsg = sqlErr.Message
+			if n, ok := sqlErr.Fields[aulerrors.FieldSQLErrorNumber].(int32); ok {
+				number = n
+			}
+			if sev, ok := sqlErr.Fields[aulerrors.FieldSQLSeverity].(uint8); ok {
+				class = sev
+			}
+		}
+	}
+	// The request ID trails the message, which is all of an error a
+	// client is sure to show, so that it can be quoted to support
+	if requestID != "" {
+		errMsg += " (request ID " + requestID + ")"
 	}
+	tw.WriteError(
+		number,
+		1,
+		class,
+		errMsg,
+		c.serverName,
+		"",
+		1,
+	)
 }
 
-// inferColumnFromValue creates a tds.Column based on the Go value type.
-func inferColumnFromValue(val interface{}) tds.Column {
-	col := tds.Column{
-		Nullable: true,
-		Flags:    tds.ColFlagNullable,
-	}
-	
-	if val == nil {
-		col.Type = tds.TypeIntN
-		col.Length = 4
-		return col
+// writeDone ends a response: a SQL batch's with a DONE, and an RPC's with
+// its return status, its OUTPUT parameters in the order they were sent,
+// and a DONEPROC.
+func (c *Connection) writeDone(tw *tds.TokenWriter, result protocol.Result, status uint16) {
+	if !c.rpc {
+		tw.WriteDone(status, 0, uint64(result.RowsAffected))
+		return
 	}
-	
-	switch v := val.(type) {
-	case int, int32:
-		col.Type = tds.TypeIntN
-		col.Length = 4
+
+	var returnStatus int32
+	switch v := result.ReturnValue.(type) {
 	case int64:
+		returnStatus = int32(v)
+	case int32:
+		returnStatus = v
+	case int:
+		returnStatus = int32(v)
+	}
+	tw.WriteReturnStatus(returnStatus)
+
+	for _, out := range c.outputs {
+		value := out.value
+		for name, v := range result.OutputParams {
+			if value != nil {
+				break
+			}
+			if strings.EqualFold(strings.TrimPrefix(name, "@"), out.name) {
+				value = v
+				break
+			}
+		}
+		// Status 0x01: the value of an OUTPUT parameter
+		tw.WriteReturnValue(out.ordinal, "@"+out.name, 0x01, 0, outputColumn(out.typ), value)
+	}
+
+	tw.WriteDoneProc(status, 0, uint64(result.RowsAffected))
+}
+
+// outputColumn returns the column an OUTPUT parameter of type ti is
+// returned as: a nullable form of that type, or NVARCHAR(MAX) text for the
+// types whose values are sent as text in result sets too.
+func outputColumn(ti tds.TypeInfo) tds.Column {
+	col := tds.Column{
+		Type:      ti.TypeID,
+		Length:    ti.Size,
+		Precision: ti.Precision,
+		Scale:     ti.Scale,
+		Collation: ti.Collation,
+		Nullable:  true,
+	}
+	switch ti.TypeID {
+	case tds.TypeIntN, tds.TypeBitN, tds.TypeFloatN, tds.TypeDecimalN, tds.TypeNumericN,
+		tds.TypeGUID, tds.TypeNVarChar, tds.TypeNChar, tds.TypeBigVarChar, tds.TypeBigChar,
+		tds.TypeBigVarBin, tds.TypeBigBinary:
+		// Nullable already
+	case tds.TypeInt1, tds.TypeInt2, tds.TypeInt4, tds.TypeInt8:
 		col.Type = tds.TypeIntN
-		col.Length = 8
-	case int16:
-		col.Type = tds.TypeIntN
-		col.Length = 2
-	case int8:
-		col.Type = tds.TypeIntN
-		col.Length = 1
-	case bool:
+	case tds.TypeBit:
 		col.Type = tds.TypeBitN
-		col.Length = 1
-	case float32:
-		col.Type = tds.TypeFloatN
-		col.Length = 4
-	case float64:
+	case tds.TypeFloat4, tds.TypeFloat8:
 		col.Type = tds.TypeFloatN
-		col.Length = 8
-	case string:
-		col.Type = tds.TypeNVarChar
-		length := len(v) * 2
-		if length < 2 {
-			length = 2
-		}
-		if length > 8000 {
-			length = 8000
-		}
-		col.Length = uint32(length)
-		col.Collation = tds.DefaultCollation
-	case []byte:
-		col.Type = tds.TypeBigVarBin
-		length := len(v)
-		if length < 1 {
-			length = 1
-		}
-		if length > 8000 {
-			length = 8000
-		}
-		col.Length = uint32(length)
 	default:
-		// Default to NVARCHAR for unknown types
-		col.Type = tds.TypeNVarChar
-		col.Length = 8000
-		col.Collation = tds.DefaultCollation
-		_ = v // silence unused warning
+		col = tds.Column{
+			Type:      tds.TypeNVarChar,
+			Length:    tds.MaxTypeLength,
+			Collation: tds.DefaultCollation,
+			Nullable:  true,
+		}
 	}
-	
 	return col
 }
 
@@ -678,7 +811,7 @@ func (c *Connection) writeResultSet(tw *tds.TokenWriter, rs protocol.ResultSet)
 	// Convert protocol columns to TDS columns
 	columns := make([]tds.Column, len(rs.Columns))
 	for i, col := range rs.Columns {
-		columns[i] = convertColumn(col)
+		columns[i] = convertColumn(col, c.charset.Collation())
 	}
 
 	// Create result set writer
@@ -687,8 +820,15 @@ func (c *Connection) writeResultSet(tw *tds.TokenWriter, rs protocol.ResultSet)
 	// Write column metadata
 	rsw.WriteColMetadata()
 
+	if rs.Stream != nil {
+		return c.writeRowStream(tw, rsw, rs.Stream)
+	}
+
 	// Write rows
 	for _, row := range rs.Rows {
+		if c.attention.Load() {
+			return errAttention
+		}
 		if err := rsw.WriteRow(row); err != nil {
 			return err
 		}
@@ -700,14 +840,65 @@ func (c *Connection) writeResultSet(tw *tds.TokenWriter, rs protocol.ResultSet)
 	return nil
 }
 
-// convertColumn converts a protocol.ColumnInfo to tds.Column.
-func convertColumn(col protocol.ColumnInfo) tds.Column {
+// streamFlushBytes is how much of a streamed result set is written
+// before the packets it fills are sent.
+const streamFlushBytes = 64 << 10
+
+// streamError is the error of a streamed result set's query.
+type streamError struct {
+	err error
+}
+
+func (e *streamError) Error() string { return e.err.Error() }
+
+// errAttention stops the writing of a response the client has cancelled.
+var errAttention = errors.New("cancelled by an attention")
+
+// writeRowStream writes the rows of a stream as they are read, sending
+// the packets they fill as it goes.
+func (c *Connection) writeRowStream(tw *tds.TokenWriter, rsw *tds.ResultSetWriter, stream protocol.RowStream) error {
+	var n uint64
+	for stream.Next() {
+		if c.attention.Load() {
+			return errAttention
+		}
+		if err := rsw.WriteRow(stream.Row()); err != nil {
+			return err
+		}
+		n++
+		if len(tw.Bytes()) >= streamFlushBytes {
+			if err := c.tdsConn.WritePartialTokens(tw); err != nil {
+				return err
+			}
+		}
+	}
+	if c.attention.Load() {
+		return errAttention // Not the error of the query it stopped
+	}
+	if err := stream.Err(); err != nil {
+		return &streamError{err: err}
+	}
+	rsw.WriteDoneInProc(n)
+	return nil
+}
+
+// writeWarnings sends each warning as an informational message
+// (severity 10), which clients show like PRINT output.
+func (c *Connection) writeWarnings(tw *tds.TokenWriter, warnings []string) {
+	for _, w := range warnings {
+		tw.WriteInfo(0, 1, 10, w, c.serverName, "", 1)
+	}
+}
+
+// convertColumn converts a protocol.ColumnInfo to tds.Column, with
+// character data in collation.
+func convertColumn(col protocol.ColumnInfo, collation []byte) tds.Column {
 	tdsCol := tds.Column{
 		Name:      col.Name,
 		Nullable:  col.Nullable,
 		Length:    uint32(col.Length),
 		Scale:     uint8(col.Scale),
-		Collation: tds.DefaultCollation,
+		Collation: collation,
 	}
 
 	// Map SQL type name to TDS type
@@ -810,6 +1001,30 @@ func (c *Connection) SetDeadline(t time.Time) error {
 	return nil
 }
 
+// WatchCancel calls cancel when the client sends an attention while a
+// request runs. The attention stays unread: ReadRequest returns it next,
+// as a cancel request, which the server acknowledges. Until then the
+// cancelled request's response is cut short: rows are no longer written,
+// and a response not yet begun is not sent, so that the client, which
+// discards everything up to the acknowledgement, is answered at once.
+func (c *Connection) WatchCancel(cancel func()) (stop func()) {
+	netConn := c.tdsConn.NetConn()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if pktType, err := c.tdsConn.PeekPacketType(); err == nil && pktType == tds.PacketAttention {
+			c.attention.Store(true)
+			cancel()
+		}
+	}()
+	return func() {
+		// A deadline in the past ends the wait
+		netConn.SetReadDeadline(time.Now())
+		<-done
+		netConn.SetReadDeadline(time.Time{})
+	}
+}
+
 // Properties returns connection properties for tenant identification.
 func (c *Connection) Properties() map[string]string {
 	props := make(map[string]string)
@@ -828,18 +1043,3 @@ func (c *Connection) Properties() map[string]string {
 	return props
 }
 
-// ucs2ToString converts UTF-16LE bytes to a Go string.
-func ucs2ToString(b []byte) string {
-	if len(b) == 0 {
-		return ""
-	}
-	if len(b)%2 != 0 {
-		b = b[:len(b)-1]
-	}
-
-	runes := make([]rune, len(b)/2)
-	for i := 0; i < len(runes); i++ {
-		runes[i] = rune(uint16(b[i*2]) | uint16(b[i*2+1])<<8)
-	}
-	return string(runes)
-}
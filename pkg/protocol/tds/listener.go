@@ -12,9 +12,15 @@ import (
 	"github.com/ha1tch/aul/pkg/log"
 	"github.com/ha1tch/aul/pkg/tlsutil"
 	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/protocol/capture"
 	"github.com/ha1tch/aul/pkg/tds"
 )
 
+// OptionCodePage is the listener option holding the code page, an int,
+// of VARCHAR data for clients whose login reports no locale. It defaults
+// to Windows-1252.
+const OptionCodePage = "code_page"
+
 // Listener implements protocol.Listener for the TDS protocol.
 type Listener struct {
 	cfg         protocol.ListenerConfig
@@ -32,6 +38,9 @@ type Listener struct {
 
 	// TLS configuration (nil means no TLS support)
 	tlsConfig *tls.Config
+
+	// Code page of clients whose login reports no locale
+	charset *tds.Charset
 }
 
 // New creates a new TDS listener.
@@ -47,6 +56,15 @@ func New(cfg protocol.ListenerConfig, logger *log.Logger) (protocol.Listener, er
 		nextSPID:      51, // SPIDs 1-50 are reserved for system
 		serverName:    serverName,
 		serverVersion: tds.DefaultServerVersion(),
+		charset:       tds.DefaultCharset,
+	}
+
+	if codePage, ok := cfg.Options[OptionCodePage].(int); ok && codePage != 0 {
+		charset, err := tds.CharsetForCodePage(codePage)
+		if err != nil {
+			return nil, err
+		}
+		l.charset = charset
 	}
 
 	// Load TLS configuration if enabled
@@ -56,16 +74,16 @@ func New(cfg protocol.ListenerConfig, logger *log.Logger) (protocol.Listener, er
 			return nil, fmt.Errorf("loading TLS config: %w", err)
 		}
 		l.tlsConfig = tlsConfig
-		logger.Application().Info("TLS enabled for TDS listener")
+		logger.Protocol().Info("TLS enabled for TDS listener")
 	} else {
 		// Auto-generate TLS certificate for development use
 		// This allows JDBC and other clients that require TLS to connect
 		tlsConfig, err := tlsutil.GenerateSelfSignedCert()
 		if err != nil {
-			logger.Application().Warn("failed to auto-generate TLS certificate", "error", err)
+			logger.Protocol().Warn("failed to auto-generate TLS certificate", "error", err)
 		} else {
 			l.tlsConfig = tlsConfig
-			logger.Application().Info("auto-generated self-signed TLS certificate for development")
+			logger.Protocol().Info("auto-generated self-signed TLS certificate for development")
 		}
 	}
 
@@ -88,7 +106,7 @@ func loadTLSConfig(cfg protocol.ListenerConfig, logger *log.Logger) (*tls.Config
 	}
 
 	// Auto-generate a self-signed certificate
-	logger.Application().Info("no TLS certificate specified, generating self-signed certificate")
+	logger.Protocol().Info("no TLS certificate specified, generating self-signed certificate")
 	return tlsutil.GenerateSelfSignedCert()
 }
 
@@ -99,14 +117,13 @@ func (l *Listener) Protocol() protocol.ProtocolType {
 
 // Listen starts the TDS listener.
 func (l *Listener) Listen() error {
-	addr := l.cfg.Address()
-	ln, err := net.Listen("tcp", addr)
+	ln, err := protocol.Listen(l.cfg)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		return err
 	}
 
 	l.listener = ln
-	l.logger.Application().Info("TDS listener started", "address", addr)
+	l.logger.Protocol().Info("TDS listener started", "address", protocol.FormatAddrs(l.Addrs()))
 	return nil
 }
 
@@ -127,6 +144,11 @@ func (l *Listener) Accept() (protocol.Connection, error) {
 		return nil, fmt.Errorf("maximum connections (%d) reached", l.cfg.MaxConnections)
 	}
 
+	// Record the raw client byte stream if capture is enabled
+	if dir, ok := capture.Enabled(l.cfg); ok {
+		netConn = capture.NewRecorder(netConn, protocol.ProtocolTDS, dir)
+	}
+
 	// Detect connection type by peeking at first byte:
 	// - 0x16 = TLS ClientHello (TDS 8.0 strict mode or direct TLS)
 	// - 0x12 = TDS PRELOGIN (TDS 7.x mode, TLS negotiated later)
@@ -150,11 +172,11 @@ func (l *Listener) Accept() (protocol.Connection, error) {
 			return nil, fmt.Errorf("peeking first byte: %w", err)
 		}
 
-		l.logger.Application().Debug("connection first byte", "byte", fmt.Sprintf("0x%02X", firstByte[0]))
+		l.logger.Protocol().Debug("connection first byte", "byte", fmt.Sprintf("0x%02X", firstByte[0]))
 
 		if firstByte[0] == 0x16 { // TLS record type: Handshake
 			// TDS 8.0 strict mode - client initiates TLS immediately
-			l.logger.Application().Debug("detected TDS 8.0 strict mode (TLS-first)")
+			l.logger.Protocol().Debug("detected TDS 8.0 strict mode (TLS-first)")
 			isTDS8Strict = true
 
 			tlsConn := tls.Server(peekConn, l.tlsConfig)
@@ -163,13 +185,13 @@ func (l *Listener) Accept() (protocol.Connection, error) {
 				return nil, fmt.Errorf("TDS 8.0 TLS handshake: %w", err)
 			}
 			actualConn = tlsConn
-			l.logger.Application().Debug("TDS 8.0 TLS handshake completed")
+			l.logger.Protocol().Debug("TDS 8.0 TLS handshake completed")
 		} else if firstByte[0] == 0x12 { // TDS PRELOGIN
 			// TDS 7.x mode - PRELOGIN first, TLS wrapped in TDS packets later
-			l.logger.Application().Debug("detected TDS 7.x mode (PRELOGIN-first)")
+			l.logger.Protocol().Debug("detected TDS 7.x mode (PRELOGIN-first)")
 			actualConn = peekConn
 		} else {
-			l.logger.Application().Warn("unexpected first byte", "byte", fmt.Sprintf("0x%02X", firstByte[0]))
+			l.logger.Protocol().Warn("unexpected first byte", "byte", fmt.Sprintf("0x%02X", firstByte[0]))
 			actualConn = peekConn
 		}
 	}
@@ -191,14 +213,18 @@ func (l *Listener) Accept() (protocol.Connection, error) {
 		spid:         spid,
 		serverName:   l.serverName,
 		tlsConfig:    l.tlsConfig,
+		charset:      l.charset,
 		isTDS8Strict: isTDS8Strict,
 		phase3:       DefaultPhase3Handlers(),
 		phase3State:  NewConnectionPhase3State(),
 	}
+	if l.cfg.Logins != nil {
+		conn.Authenticator = loginAuthenticator{logins: l.cfg.Logins}
+	}
 
 	// Perform TDS handshake (PRELOGIN/LOGIN7)
 	// In TDS 8.0 strict mode, TLS is already done, so handshake skips TLS negotiation
-	if err := conn.handshake(); err != nil {
+	if err := conn.guardedHandshake(); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("TDS handshake failed: %w", err)
 	}
@@ -207,7 +233,7 @@ func (l *Listener) Accept() (protocol.Connection, error) {
 	l.connections.Store(spid, conn)
 	atomic.AddInt32(&l.connCount, 1)
 
-	l.logger.Application().Debug("TDS connection established",
+	l.logger.Protocol().Debug("TDS connection established",
 		"spid", spid,
 		"remote", netConn.RemoteAddr(),
 		"user", conn.user,
@@ -239,6 +265,11 @@ func (l *Listener) Close() error {
 	return nil
 }
 
+// Addrs returns every address the listener is bound to.
+func (l *Listener) Addrs() []net.Addr {
+	return protocol.Addrs(l.listener)
+}
+
 // Addr returns the listener's network address.
 func (l *Listener) Addr() net.Addr {
 	if l.listener != nil {
@@ -3,6 +3,8 @@ package tds
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -193,6 +195,107 @@ func TestFullLoginHandshake(t *testing.T) {
 	}
 }
 
+// TestAttentionAcknowledged checks that an attention sent while a request
+// runs cancels it, and that the client is answered with nothing but the
+// DONE token acknowledging it: the cancelled request's response, which
+// the client would discard, is not sent.
+func TestAttentionAcknowledged(t *testing.T) {
+	cfg := protocol.ListenerConfig{
+		Name:     "test-tds",
+		Protocol: protocol.ProtocolTDS,
+		Host:     "127.0.0.1",
+		Port:     0,
+	}
+	listener, err := New(cfg, log.New(log.DefaultConfig()))
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	if err := listener.Listen(); err != nil {
+		t.Fatalf("Failed to start listener: %v", err)
+	}
+	defer listener.Close()
+
+	acceptConn := make(chan protocol.Connection, 1)
+	go func() {
+		if c, err := listener.Accept(); err == nil {
+			acceptConn <- c
+		}
+	}()
+
+	conn, err := net.DialTimeout("tcp", listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// readMessage reads a response message of one packet
+	readMessage := func() (tds.PacketType, []byte) {
+		t.Helper()
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			t.Fatalf("Failed to read packet header: %v", err)
+		}
+		payload := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-8)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			t.Fatalf("Failed to read packet: %v", err)
+		}
+		if header[1]&byte(tds.StatusEOM) == 0 {
+			t.Fatal("Response spans packets")
+		}
+		return tds.PacketType(header[0]), payload
+	}
+	conn.Write(buildTDSPacket(tds.PacketPrelogin, buildPreloginRequest()))
+	readMessage()
+	conn.Write(buildTDSPacket(tds.PacketLogin7, buildLogin7Request("testuser", "testpass", "testdb", "testapp")))
+	readMessage()
+
+	var serverConn protocol.Connection
+	select {
+	case serverConn = <-acceptConn:
+		defer serverConn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for accept")
+	}
+
+	// The request runs until the attention cancels it, and fails
+	cancelled := make(chan struct{})
+	stop := serverConn.(protocol.CancelWatcher).WatchCancel(func() { close(cancelled) })
+	conn.Write(buildTDSPacket(tds.PacketAttention, nil))
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The attention did not cancel the request")
+	}
+	stop()
+	if err := serverConn.SendResult(protocol.Result{Type: protocol.ResultError, Error: errors.New("cancelled")}); err != nil {
+		t.Fatal(err)
+	}
+	req, err := serverConn.ReadRequest()
+	if err != nil || req.Type != protocol.RequestCancel {
+		t.Fatalf("ReadRequest() = %+v, %v", req, err)
+	}
+	if err := serverConn.SendResult(protocol.Result{Type: protocol.ResultCancel}); err != nil {
+		t.Fatal(err)
+	}
+
+	pktType, payload := readMessage()
+	if pktType != tds.PacketReply || len(payload) != 13 || payload[0] != byte(tds.TokenDone) {
+		t.Fatalf("response: %d % x", pktType, payload)
+	}
+	if status := binary.LittleEndian.Uint16(payload[1:3]); status != tds.DoneAttn {
+		t.Errorf("DONE status %#x, want DONE_ATTN", status)
+	}
+
+	// A response after the acknowledgement is sent again
+	if err := serverConn.SendResult(protocol.Result{Type: protocol.ResultOK}); err != nil {
+		t.Fatal(err)
+	}
+	if _, payload := readMessage(); len(payload) != 13 || binary.LittleEndian.Uint16(payload[1:3])&tds.DoneAttn != 0 {
+		t.Errorf("response after the acknowledgement: % x", payload)
+	}
+}
+
 // buildTDSPacket wraps data in a TDS packet with header.
 func buildTDSPacket(pktType tds.PacketType, data []byte) []byte {
 	pkt := make([]byte, 8+len(data))
@@ -492,6 +595,9 @@ func TestSpExecuteSQLParsing(t *testing.T) {
 	if req.ProcedureName != "sp_executesql" {
 		t.Errorf("ProcedureName = %q, want %q", req.ProcedureName, "sp_executesql")
 	}
+	if !req.Options.Parameterized {
+		t.Error("sp_executesql request not marked parameterized")
+	}
 
 	// Check parameters
 	if v, ok := req.Parameters["id"].(int64); !ok || v != 42 {
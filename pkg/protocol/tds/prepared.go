@@ -0,0 +1,160 @@
+package tds
+
+import (
+	"strings"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tds"
+)
+
+// Prepared statements: sp_prepare and sp_prepexec parse a statement and its
+// parameter definitions and keep them in the connection's table under a
+// new handle, sp_execute runs the statement of a handle, binding the values
+// it is sent by name or by position to the parameters declared, and
+// sp_unprepare frees the handle. A prepared statement runs as one sent with
+// sp_executesql does, as a parameterized query; preparing and unpreparing,
+// which run nothing, the connection answers itself.
+
+// SQL Server's errors for the prepared statement procedures.
+const (
+	errSyntax           = 102  // Incorrect syntax
+	errMissingParameter = 201  // Expects a parameter that was not supplied
+	errTooManyArguments = 8144 // Too many arguments specified
+	errPreparedHandle   = 8179 // Could not find prepared statement with handle
+)
+
+// preparedRPC handles an RPC of sp_prepare, sp_prepexec, sp_execute or
+// sp_unprepare, returning the query it runs, or answered true when it
+// answered the RPC itself.
+func (c *Connection) preparedRPC(rpcReq *tds.RPCRequest) (req protocol.Request, answered bool, err error) {
+	state := c.phase3State
+	switch rpcReq.ProcID {
+	case tds.ProcIDPrepare, tds.ProcIDPrepExec:
+		stmt := rpcArg(rpcReq, 2, "stmt")
+		if stmt == nil || stmt.IsNull {
+			return req, true, c.answerError(rpcReq, errMissingParameter, "Procedure or function '%s' expects parameter '@stmt', which was not supplied.", rpcReq.ProcName)
+		}
+		var paramDefs string
+		if p := rpcArg(rpcReq, 1, "params"); p != nil {
+			paramDefs, _ = p.Value.(string)
+		}
+		sql, _ := stmt.Value.(string)
+		ps, perr := tds.ParsePrepared(sql, paramDefs)
+		if perr != nil {
+			return req, true, c.answerError(rpcReq, errSyntax, "Incorrect syntax in the statement prepared by %s: %v", rpcReq.ProcName, perr)
+		}
+		ps.Handle = state.stmtHandlePool.Acquire()
+		state.PreparedStatements[ps.Handle] = ps
+
+		// The handle goes back in @handle, the first parameter
+		if h := rpcArg(rpcReq, 0, "handle"); h != nil && h.IsOutput {
+			c.outputs = append(c.outputs, rpcOutput{ordinal: 0, name: "handle", typ: h.Type, value: ps.Handle})
+		}
+		if rpcReq.ProcID == tds.ProcIDPrepare {
+			return req, true, c.SendResult(protocol.Result{Type: protocol.ResultOK})
+		}
+		return c.executePrepared(rpcReq, ps, 3)
+
+	case tds.ProcIDExecute:
+		ps, ok := c.preparedStatement(rpcReq)
+		if !ok {
+			return req, true, c.answerError(rpcReq, errPreparedHandle, "Could not find prepared statement with handle %d.", handleArg(rpcReq))
+		}
+		return c.executePrepared(rpcReq, ps, 1)
+
+	default: // sp_unprepare
+		ps, ok := c.preparedStatement(rpcReq)
+		if !ok {
+			return req, true, c.answerError(rpcReq, errPreparedHandle, "Could not find prepared statement with handle %d.", handleArg(rpcReq))
+		}
+		delete(state.PreparedStatements, ps.Handle)
+		state.stmtHandlePool.Release(ps.Handle)
+		return req, true, c.SendResult(protocol.Result{Type: protocol.ResultOK})
+	}
+}
+
+// executePrepared returns the query running ps with the values of
+// rpcReq's parameters from first on. A parameter declared but not sent is
+// NULL, as every declared parameter is a variable of the statement.
+func (c *Connection) executePrepared(rpcReq *tds.RPCRequest, ps *tds.PreparedStatement, first int) (protocol.Request, bool, error) {
+	params := make(map[string]interface{}, len(ps.Params))
+	for _, p := range ps.Params {
+		params[p.Name] = nil
+	}
+	var outputs []string
+	for i := first; i < len(rpcReq.Parameters); i++ {
+		p := rpcReq.Parameters[i]
+		name := strings.TrimPrefix(p.Name, "@")
+		if name == "" {
+			if i-first >= len(ps.Params) {
+				return protocol.Request{}, true, c.answerError(rpcReq, errTooManyArguments,
+					"Procedure or function %s has too many arguments specified.", rpcReq.ProcName)
+			}
+			name = ps.Params[i-first].Name
+		}
+		params[name] = p.Value
+		if p.IsOutput {
+			outputs = append(outputs, name)
+			c.outputs = append(c.outputs, rpcOutput{ordinal: uint16(i), name: name, typ: p.Type})
+		}
+	}
+	ps.ExecCount++
+
+	return protocol.Request{
+		Type:          protocol.RequestQuery,
+		SQL:           ps.SQL,
+		ProcedureName: rpcReq.ProcName,
+		Parameters:    params,
+		Outputs:       outputs,
+		Options:       protocol.RequestOptions{Parameterized: true, Stream: true},
+	}, false, nil
+}
+
+// preparedStatement returns the statement of the handle rpcReq names.
+func (c *Connection) preparedStatement(rpcReq *tds.RPCRequest) (*tds.PreparedStatement, bool) {
+	ps, ok := c.phase3State.PreparedStatements[handleArg(rpcReq)]
+	return ps, ok
+}
+
+// answerError answers the RPC being read with a SQL Server error.
+func (c *Connection) answerError(rpcReq *tds.RPCRequest, number int32, format string, args ...interface{}) error {
+	err := aulerrors.Newf(aulerrors.ErrCodeExecSQLError, format, args...).
+		WithOp("Connection.preparedRPC").
+		WithField("procedure", rpcReq.ProcName).
+		WithField(aulerrors.FieldSQLErrorNumber, number).
+		WithField(aulerrors.FieldSQLSeverity, uint8(16)).
+		Err()
+	return c.SendResult(protocol.Result{Type: protocol.ResultError, Error: err, Message: err.Error()})
+}
+
+// handleArg returns the handle rpcReq names in its first parameter, or 0,
+// which no statement has.
+func handleArg(rpcReq *tds.RPCRequest) int32 {
+	if p := rpcArg(rpcReq, 0, "handle"); p != nil {
+		switch v := p.Value.(type) {
+		case int64:
+			return int32(v)
+		case int32:
+			return v
+		}
+	}
+	return 0
+}
+
+// rpcArg returns the parameter of rpcReq at ordinal, if it has no name or
+// is named name, or else the one named name, or nil.
+func rpcArg(rpcReq *tds.RPCRequest, ordinal int, name string) *tds.RPCParam {
+	named := func(p tds.RPCParam) bool { return strings.EqualFold(strings.TrimPrefix(p.Name, "@"), name) }
+	if ordinal < len(rpcReq.Parameters) {
+		if p := &rpcReq.Parameters[ordinal]; p.Name == "" || named(*p) {
+			return p
+		}
+	}
+	for i := range rpcReq.Parameters {
+		if named(rpcReq.Parameters[i]) {
+			return &rpcReq.Parameters[i]
+		}
+	}
+	return nil
+}
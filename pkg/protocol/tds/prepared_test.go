@@ -0,0 +1,50 @@
+package tds
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tds"
+)
+
+// TestExecutePreparedPositional binds the values of sp_execute sent by
+// position, as JDBC and ODBC drivers send them, to the parameters the
+// statement declares.
+func TestExecutePreparedPositional(t *testing.T) {
+	c := &Connection{phase3State: NewConnectionPhase3State()}
+	ps, err := tds.ParsePrepared("SELECT @total = SUM(qty) FROM orders WHERE id = @id AND price > @min",
+		"@id INT, @min DECIMAL(10, 2), @total INT OUTPUT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.Handle = 5
+	c.phase3State.PreparedStatements[ps.Handle] = ps
+
+	req, answered, err := c.preparedRPC(&tds.RPCRequest{
+		ProcID:   tds.ProcIDExecute,
+		ProcName: "sp_execute",
+		Parameters: []tds.RPCParam{
+			{Value: int64(5)},
+			{Value: int64(7)},
+			{Value: nil, IsNull: true},
+			{Value: nil, IsNull: true, IsOutput: true, Type: tds.TypeInfo{TypeID: tds.TypeIntN, Size: 4}},
+		},
+	})
+	if err != nil || answered {
+		t.Fatalf("answered %v, %v", answered, err)
+	}
+	if req.Type != protocol.RequestQuery || req.SQL != ps.SQL || !req.Options.Parameterized {
+		t.Errorf("request %+v", req)
+	}
+	want := map[string]interface{}{"id": int64(7), "min": nil, "total": nil}
+	if !reflect.DeepEqual(req.Parameters, want) {
+		t.Errorf("parameters %v, want %v", req.Parameters, want)
+	}
+	if !reflect.DeepEqual(req.Outputs, []string{"total"}) || len(c.outputs) != 1 || c.outputs[0].ordinal != 3 || c.outputs[0].name != "total" {
+		t.Errorf("outputs %v, %+v", req.Outputs, c.outputs)
+	}
+	if ps.ExecCount != 1 {
+		t.Errorf("ExecCount %d", ps.ExecCount)
+	}
+}
@@ -0,0 +1,266 @@
+package runtime
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// Priority selects the admission lane of an execution.
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota // Latency-sensitive application calls
+	PriorityBatch                       // Reports, ETL and other bulk work
+)
+
+// String returns the lane name.
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityBatch:
+		return "batch"
+	default:
+		return "unknown"
+	}
+}
+
+// priorities lists the lanes in the order waiters are served.
+var priorities = []Priority{PriorityInteractive, PriorityBatch}
+
+// AdmissionConfig bounds the queue of executions waiting for a slot once
+// MaxConcurrency executions are running.
+//
+// Waiting executions are admitted interactive lane first, oldest first.
+// An execution that finds its lane full, or waits longer than
+// QueueTimeout, fails with a "server busy" error instead.
+type AdmissionConfig struct {
+	InteractiveQueue int           // Interactive executions allowed to wait
+	BatchQueue       int           // Batch executions allowed to wait
+	QueueTimeout     time.Duration // Longest wait before giving up
+}
+
+// DefaultAdmissionConfig returns the admission defaults.
+func DefaultAdmissionConfig() AdmissionConfig {
+	return AdmissionConfig{
+		InteractiveQueue: 1000,
+		BatchQueue:       100,
+		QueueTimeout:     10 * time.Second,
+	}
+}
+
+// BusyErrorNumber is the SQL error number reported when an execution is
+// refused admission. It matches the resource limit error of Azure SQL, so
+// clients with transient-fault retry logic back off and retry.
+const BusyErrorNumber = 10928
+
+// BusySQLState is the PostgreSQL SQLSTATE for a refused execution
+// (insufficient_resources).
+const BusySQLState = "53000"
+
+// Admission limits concurrent executions and queues the excess.
+type Admission struct {
+	limit   int
+	config  AdmissionConfig
+	mu      sync.Mutex
+	running int
+	lanes   [2]*list.List // Of *waiter, indexed by Priority
+	stats   [2]LaneStats
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// LaneStats are the admission counters of one lane.
+type LaneStats struct {
+	Priority    Priority
+	Waiting     int   // Executions currently queued
+	Admitted    int64 // Executions admitted, with or without waiting
+	Queued      int64 // Executions that had to wait
+	Rejected    int64 // Executions refused because the lane was full
+	TimedOut    int64 // Executions that gave up after QueueTimeout
+	TotalWaitNs int64 // Queue time of executions admitted after waiting
+	MaxWaitNs   int64
+
+	waits int64 // Executions admitted after waiting
+}
+
+// AvgWaitMs returns the mean queue time of executions admitted after
+// waiting.
+func (s LaneStats) AvgWaitMs() float64 {
+	if s.waits == 0 {
+		return 0
+	}
+	return float64(s.TotalWaitNs) / float64(s.waits) / 1_000_000
+}
+
+// AdmissionStats is a snapshot of the admission controller.
+type AdmissionStats struct {
+	Limit   int
+	Running int
+	Lanes   []LaneStats
+}
+
+// NewAdmission creates an admission controller for limit concurrent
+// executions. Zero fields of cfg take their defaults.
+func NewAdmission(limit int, cfg AdmissionConfig) *Admission {
+	def := DefaultAdmissionConfig()
+	if limit <= 0 {
+		limit = 1
+	}
+	if cfg.InteractiveQueue < 0 {
+		cfg.InteractiveQueue = 0
+	} else if cfg.InteractiveQueue == 0 {
+		cfg.InteractiveQueue = def.InteractiveQueue
+	}
+	if cfg.BatchQueue < 0 {
+		cfg.BatchQueue = 0
+	} else if cfg.BatchQueue == 0 {
+		cfg.BatchQueue = def.BatchQueue
+	}
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = def.QueueTimeout
+	}
+	a := &Admission{limit: limit, config: cfg}
+	for _, p := range priorities {
+		a.lanes[p] = list.New()
+		a.stats[p].Priority = p
+	}
+	return a
+}
+
+// Acquire waits for an execution slot. The returned release function must
+// be called once the execution finishes.
+func (a *Admission) Acquire(ctx context.Context, p Priority) (release func(), err error) {
+	if p != PriorityBatch {
+		p = PriorityInteractive
+	}
+
+	a.mu.Lock()
+	if a.running < a.limit && a.aheadOf(p) == 0 {
+		a.running++
+		a.stats[p].Admitted++
+		a.mu.Unlock()
+		return a.release, nil
+	}
+	if a.lanes[p].Len() >= a.capacity(p) {
+		a.stats[p].Rejected++
+		a.mu.Unlock()
+		return nil, a.busyError(p, fmt.Sprintf("the %s queue is full", p))
+	}
+	w := &waiter{ready: make(chan struct{})}
+	elem := a.lanes[p].PushBack(w)
+	a.stats[p].Queued++
+	a.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(a.config.QueueTimeout)
+	defer timer.Stop()
+
+	timedOut := false
+	select {
+	case <-w.ready:
+		a.recordWait(p, time.Since(start))
+		return a.release, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		timedOut = true
+		err = a.busyError(p, fmt.Sprintf("waited %v in the %s queue", a.config.QueueTimeout, p))
+	}
+
+	a.mu.Lock()
+	if w.granted {
+		// The slot arrived as we gave up; pass it on
+		a.mu.Unlock()
+		a.release()
+	} else {
+		a.lanes[p].Remove(elem)
+		if timedOut {
+			a.stats[p].TimedOut++
+		}
+		a.mu.Unlock()
+	}
+	return nil, err
+}
+
+// aheadOf returns the number of waiters served before a new arrival in
+// lane p. Caller holds a.mu.
+func (a *Admission) aheadOf(p Priority) int {
+	n := 0
+	for _, q := range priorities {
+		n += a.lanes[q].Len()
+		if q == p {
+			break
+		}
+	}
+	return n
+}
+
+func (a *Admission) capacity(p Priority) int {
+	if p == PriorityBatch {
+		return a.config.BatchQueue
+	}
+	return a.config.InteractiveQueue
+}
+
+// release frees a slot, handing it straight to the next waiter.
+func (a *Admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.running--
+	for _, p := range priorities {
+		if front := a.lanes[p].Front(); front != nil {
+			w := a.lanes[p].Remove(front).(*waiter)
+			w.granted = true
+			a.running++
+			a.stats[p].Admitted++
+			close(w.ready)
+			return
+		}
+	}
+}
+
+func (a *Admission) recordWait(p Priority, wait time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats[p].waits++
+	a.stats[p].TotalWaitNs += wait.Nanoseconds()
+	if wait.Nanoseconds() > a.stats[p].MaxWaitNs {
+		a.stats[p].MaxWaitNs = wait.Nanoseconds()
+	}
+}
+
+// Stats returns a snapshot of the admission counters.
+func (a *Admission) Stats() AdmissionStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := AdmissionStats{Limit: a.limit, Running: a.running}
+	for _, p := range priorities {
+		ls := a.stats[p]
+		ls.Waiting = a.lanes[p].Len()
+		stats.Lanes = append(stats.Lanes, ls)
+	}
+	return stats
+}
+
+func (a *Admission) busyError(p Priority, reason string) error {
+	return aulerrors.Newf(aulerrors.ErrCodeExecConcurrency,
+		"Resource ID : 1. The request limit for the server is %d and has been reached (%s). Retry the request later.",
+		a.limit, reason).
+		WithOp("Admission.Acquire").
+		WithField("priority", p.String()).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(BusyErrorNumber)).
+		WithField(aulerrors.FieldSQLSeverity, uint8(16)).
+		WithField(aulerrors.FieldSQLState, BusySQLState).
+		Err()
+}
@@ -0,0 +1,221 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// acquireAsync starts an Acquire in the background and returns a channel
+// that receives its outcome. An admitted call holds its slot until hold is
+// closed, or releases it at once when hold is nil.
+func acquireAsync(a *Admission, p Priority, hold <-chan struct{}) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		release, err := a.Acquire(context.Background(), p)
+		done <- err
+		if err == nil {
+			if hold != nil {
+				<-hold
+			}
+			release()
+		}
+	}()
+	return done
+}
+
+// waitForWaiting polls until n executions are queued in lane p.
+func waitForWaiting(t *testing.T, a *Admission, p Priority, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if a.Stats().Lanes[p].Waiting == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s lane never reached %d waiting", p, n)
+}
+
+// waitForRunning polls until n executions hold a slot.
+func waitForRunning(t *testing.T, a *Admission, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if a.Stats().Running == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("running never reached %d", n)
+}
+
+func TestAdmissionAdmitsUpToLimit(t *testing.T) {
+	a := NewAdmission(2, AdmissionConfig{})
+	ctx := context.Background()
+
+	r1, err := a.Acquire(ctx, PriorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := a.Acquire(ctx, PriorityBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := a.Stats(); st.Running != 2 {
+		t.Errorf("running = %d, want 2", st.Running)
+	}
+	r1()
+	r2()
+	if st := a.Stats(); st.Running != 0 || st.Lanes[PriorityInteractive].Admitted != 1 || st.Lanes[PriorityBatch].Admitted != 1 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestAdmissionServesInteractiveFirst(t *testing.T) {
+	a := NewAdmission(1, AdmissionConfig{})
+	release, err := a.Acquire(context.Background(), PriorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hold := make(chan struct{})
+	batch := acquireAsync(a, PriorityBatch, hold)
+	waitForWaiting(t, a, PriorityBatch, 1)
+	interactive := acquireAsync(a, PriorityInteractive, hold)
+	waitForWaiting(t, a, PriorityInteractive, 1)
+
+	// The slot goes to the later interactive arrival
+	release()
+	if err := <-interactive; err != nil {
+		t.Fatalf("interactive: %v", err)
+	}
+	if st := a.Stats(); st.Lanes[PriorityBatch].Waiting != 1 {
+		t.Fatalf("batch admitted ahead of interactive: %+v", st)
+	}
+	close(hold)
+	if err := <-batch; err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	waitForRunning(t, a, 0)
+
+	st := a.Stats()
+	for _, l := range st.Lanes {
+		if l.Queued != 1 || l.Waiting != 0 {
+			t.Errorf("%s lane: %+v", l.Priority, l)
+		}
+	}
+}
+
+func TestAdmissionRejectsWhenQueueFull(t *testing.T) {
+	a := NewAdmission(1, AdmissionConfig{BatchQueue: 1})
+	release, err := a.Acquire(context.Background(), PriorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := acquireAsync(a, PriorityBatch, nil)
+	waitForWaiting(t, a, PriorityBatch, 1)
+
+	_, err = a.Acquire(context.Background(), PriorityBatch)
+	if !aulerrors.IsCode(err, aulerrors.ErrCodeExecConcurrency) {
+		t.Fatalf("err = %v, want server busy", err)
+	}
+	fields := aulerrors.GetFields(err)
+	if fields[aulerrors.FieldSQLErrorNumber] != int32(BusyErrorNumber) || fields[aulerrors.FieldSQLState] != BusySQLState {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if a.Stats().Lanes[PriorityBatch].Rejected != 1 {
+		t.Error("rejection not counted")
+	}
+
+	release()
+	if err := <-waiting; err != nil {
+		t.Errorf("queued batch call: %v", err)
+	}
+}
+
+func TestAdmissionNoQueue(t *testing.T) {
+	a := NewAdmission(1, AdmissionConfig{InteractiveQueue: -1})
+	release, err := a.Acquire(context.Background(), PriorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if _, err := a.Acquire(context.Background(), PriorityInteractive); !aulerrors.IsCode(err, aulerrors.ErrCodeExecConcurrency) {
+		t.Errorf("err = %v, want immediate server busy", err)
+	}
+}
+
+func TestAdmissionQueueTimeout(t *testing.T) {
+	a := NewAdmission(1, AdmissionConfig{QueueTimeout: 20 * time.Millisecond})
+	release, err := a.Acquire(context.Background(), PriorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	_, err = a.Acquire(context.Background(), PriorityInteractive)
+	if !aulerrors.IsCode(err, aulerrors.ErrCodeExecConcurrency) {
+		t.Fatalf("err = %v, want server busy", err)
+	}
+	st := a.Stats().Lanes[PriorityInteractive]
+	if st.TimedOut != 1 || st.Waiting != 0 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestAdmissionCancelledWaiter(t *testing.T) {
+	a := NewAdmission(1, AdmissionConfig{})
+	release, err := a.Acquire(context.Background(), PriorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Acquire(ctx, PriorityInteractive)
+		done <- err
+	}()
+	waitForWaiting(t, a, PriorityInteractive, 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	// The abandoned place in the queue does not swallow the slot
+	release()
+	if st := a.Stats(); st.Running != 0 || st.Lanes[PriorityInteractive].TimedOut != 0 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+	r, err := a.Acquire(context.Background(), PriorityInteractive)
+	if err != nil {
+		t.Fatalf("slot lost after cancellation: %v", err)
+	}
+	r()
+}
+
+func TestAdmissionRecordsWait(t *testing.T) {
+	a := NewAdmission(1, AdmissionConfig{})
+	release, err := a.Acquire(context.Background(), PriorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiting := acquireAsync(a, PriorityInteractive, nil)
+	waitForWaiting(t, a, PriorityInteractive, 1)
+	time.Sleep(10 * time.Millisecond)
+	release()
+	if err := <-waiting; err != nil {
+		t.Fatal(err)
+	}
+
+	st := a.Stats().Lanes[PriorityInteractive]
+	if st.MaxWaitNs < int64(10*time.Millisecond) || st.AvgWaitMs() < 10 {
+		t.Errorf("wait not recorded: %+v avg=%.1fms", st, st.AvgWaitMs())
+	}
+}
@@ -0,0 +1,304 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// IndexAdvisorMode says what the index advisor does with the workload it
+// records.
+type IndexAdvisorMode string
+
+const (
+	IndexAdvisorOff       IndexAdvisorMode = "off"       // Queries are not recorded
+	IndexAdvisorRecommend IndexAdvisorMode = "recommend" // Missing indexes are reported
+	IndexAdvisorAuto      IndexAdvisorMode = "auto"      // and created once sought often enough
+)
+
+// ParseIndexAdvisorMode parses off, recommend or auto.
+func ParseIndexAdvisorMode(s string) (IndexAdvisorMode, error) {
+	switch m := IndexAdvisorMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case IndexAdvisorOff, IndexAdvisorRecommend, IndexAdvisorAuto:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown index advisor mode %q (want off, recommend or auto)", s)
+}
+
+// IndexAdvisorConfig controls the index advisor.
+//
+// The advisor records the shape of every query the interpreter runs (see
+// tsqlruntime.QueryShape) and recommends an index for each distinct set
+// of compared columns, as SQL Server's missing index DMVs do. In auto
+// mode, the server creates every Interval the recommended indexes that
+// MinSeeks queries would have used.
+type IndexAdvisorConfig struct {
+	Mode       IndexAdvisorMode
+	MinSeeks   int64         // Queries that must want an index before auto mode creates it
+	Interval   time.Duration // Between auto mode's passes
+	MaxEntries int           // Recommendations kept; shapes beyond are not recorded
+}
+
+// DefaultIndexAdvisorConfig returns the advisor defaults: recommendations
+// only.
+func DefaultIndexAdvisorConfig() IndexAdvisorConfig {
+	return IndexAdvisorConfig{
+		Mode:       IndexAdvisorRecommend,
+		MinSeeks:   100,
+		Interval:   10 * time.Minute,
+		MaxEntries: 600,
+	}
+}
+
+// maxAdvisorStatements bounds the distinct statements counted per
+// recommendation.
+const maxAdvisorStatements = 1000
+
+// MissingIndex is an index that queries would have used, with how often
+// they ran.
+type MissingIndex struct {
+	tsqlruntime.QueryShape
+
+	Handle         int64
+	Seeks          int64 // Queries that would have used it
+	UniqueCompiles int64 // Distinct statements among them
+	LastSeek       time.Time
+	AvgElapsed     time.Duration // Of those queries, without the index
+}
+
+// Columns returns the columns of the index: the equality columns, the
+// inequality columns and the included columns.
+func (m MissingIndex) Columns() []string {
+	cols := append(append([]string(nil), m.Equality...), m.Inequality...)
+	return append(cols, m.Included...)
+}
+
+// Name returns the name auto mode creates the index under.
+func (m MissingIndex) Name() string {
+	var b strings.Builder
+	b.WriteString("IX_aul_" + m.Table)
+	for _, col := range append(append([]string(nil), m.Equality...), m.Inequality...) {
+		b.WriteString("_" + col)
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, b.String())
+	if len(name) > 128 {
+		name = name[:128]
+	}
+	return name
+}
+
+// IndexAdvisor turns recorded query shapes into missing index
+// recommendations.
+type IndexAdvisor struct {
+	config IndexAdvisorConfig
+	now    func() time.Time
+
+	mu         sync.Mutex
+	entries    map[string]*advisorEntry // by QueryShape.Fingerprint
+	nextHandle int64
+}
+
+type advisorEntry struct {
+	MissingIndex
+	statements map[uint64]struct{}
+	elapsed    time.Duration
+}
+
+// NewIndexAdvisor creates an index advisor. Zero fields of cfg take their
+// defaults.
+func NewIndexAdvisor(cfg IndexAdvisorConfig) *IndexAdvisor {
+	def := DefaultIndexAdvisorConfig()
+	if cfg.Mode == "" {
+		cfg.Mode = def.Mode
+	}
+	if cfg.MinSeeks <= 0 {
+		cfg.MinSeeks = def.MinSeeks
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = def.MaxEntries
+	}
+	return &IndexAdvisor{
+		config:  cfg,
+		now:     time.Now,
+		entries: make(map[string]*advisorEntry),
+	}
+}
+
+// Config returns the effective configuration.
+func (a *IndexAdvisor) Config() IndexAdvisorConfig {
+	return a.config
+}
+
+// RecordQuery records that statement, which ran in elapsed, asked shape
+// of a table. Shapes with the same key columns share a recommendation,
+// whose included columns are those of them all.
+func (a *IndexAdvisor) RecordQuery(shape tsqlruntime.QueryShape, statement string, elapsed time.Duration) {
+	key := shape.Fingerprint()
+	h := fnv.New64a()
+	h.Write([]byte(statement))
+	sum := h.Sum64()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.entries[key]
+	if !ok {
+		if len(a.entries) >= a.config.MaxEntries {
+			return
+		}
+		a.nextHandle++
+		e = &advisorEntry{statements: make(map[uint64]struct{})}
+		e.Handle = a.nextHandle
+		e.Table = shape.Table
+		e.Equality = append([]string(nil), shape.Equality...)
+		e.Inequality = append([]string(nil), shape.Inequality...)
+		a.entries[key] = e
+	}
+	for _, col := range shape.Included {
+		if !slices.Contains(e.Included, col) {
+			e.Included = append(e.Included, col)
+		}
+	}
+	e.Seeks++
+	e.LastSeek = a.now()
+	e.elapsed += elapsed
+	if _, seen := e.statements[sum]; !seen && len(e.statements) < maxAdvisorStatements {
+		e.statements[sum] = struct{}{}
+		e.UniqueCompiles++
+	}
+}
+
+// Recommendations returns the missing indexes recorded, most sought
+// first.
+func (a *IndexAdvisor) Recommendations() []MissingIndex {
+	a.mu.Lock()
+	out := make([]MissingIndex, 0, len(a.entries))
+	for _, e := range a.entries {
+		m := e.MissingIndex
+		m.Equality = append([]string(nil), m.Equality...)
+		m.Inequality = append([]string(nil), m.Inequality...)
+		m.Included = append([]string(nil), m.Included...)
+		m.AvgElapsed = e.elapsed / time.Duration(e.Seeks)
+		out = append(out, m)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Seeks != out[j].Seeks {
+			return out[i].Seeks > out[j].Seeks
+		}
+		return out[i].Handle < out[j].Handle
+	})
+	return out
+}
+
+// forget drops a recommendation, as SQL Server does once an index serves
+// it.
+func (a *IndexAdvisor) forget(m MissingIndex) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.entries, m.Fingerprint())
+}
+
+// IndexAdvisor returns the index advisor, or nil when it is off.
+func (r *Runtime) IndexAdvisor() *IndexAdvisor {
+	return r.advisor
+}
+
+// MissingIndexes returns the index advisor's recommendations, most sought
+// first. When the storage backend lists its indexes, recommendations an
+// index already serves, and those for tables that no longer exist, are
+// dropped.
+func (r *Runtime) MissingIndexes(ctx context.Context) ([]MissingIndex, error) {
+	if r.advisor == nil {
+		return nil, nil
+	}
+	recs := r.advisor.Recommendations()
+	r.mu.RLock()
+	backend, ok := r.storage.(IndexBackend)
+	r.mu.RUnlock()
+	if !ok {
+		return recs, nil
+	}
+
+	indexes := make(map[string][][]string)
+	missing := make(map[string]bool)
+	out := recs[:0]
+	for _, m := range recs {
+		if _, seen := indexes[m.Table]; !seen && !missing[m.Table] {
+			idx, err := backend.TableIndexes(ctx, m.Table)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				missing[m.Table] = true
+			}
+			indexes[m.Table] = idx
+		}
+		served := missing[m.Table]
+		for _, idx := range indexes[m.Table] {
+			served = served || m.CoveredBy(idx)
+		}
+		if served {
+			r.advisor.forget(m)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// CreateMissingIndexes creates the recommended indexes that at least
+// IndexAdvisorConfig.MinSeeks queries would have used, and returns their
+// names. It does nothing unless the advisor is in auto mode and the
+// storage backend can create indexes.
+func (r *Runtime) CreateMissingIndexes(ctx context.Context) ([]string, error) {
+	if r.advisor == nil || r.advisor.config.Mode != IndexAdvisorAuto {
+		return nil, nil
+	}
+	r.mu.RLock()
+	backend, ok := r.storage.(IndexBackend)
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	recs, err := r.MissingIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var created []string
+	made := make(map[string][][]string) // columns of the indexes created, by table
+	for _, m := range recs {
+		if m.Seeks < r.advisor.config.MinSeeks {
+			continue
+		}
+		served := false
+		for _, idx := range made[m.Table] {
+			served = served || m.CoveredBy(idx)
+		}
+		if !served {
+			if err := backend.CreateIndex(ctx, m.Name(), m.Table, m.Columns()); err != nil {
+				return created, fmt.Errorf("creating %s: %w", m.Name(), err)
+			}
+			made[m.Table] = append(made[m.Table], m.Columns())
+			created = append(created, m.Name())
+		}
+		r.advisor.forget(m)
+	}
+	return created, nil
+}
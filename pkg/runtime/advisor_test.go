@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// indexStorage is a MemoryStorage with tables whose indexes it lists and
+// creates.
+type indexStorage struct {
+	*MemoryStorage
+	indexes map[string][][]string
+	created []string
+}
+
+func (s *indexStorage) TableIndexes(ctx context.Context, table string) ([][]string, error) {
+	idx, ok := s.indexes[table]
+	if !ok {
+		return nil, fmt.Errorf("no such table: %s", table)
+	}
+	return idx, nil
+}
+
+func (s *indexStorage) CreateIndex(ctx context.Context, name, table string, columns []string) error {
+	s.indexes[table] = append(s.indexes[table], columns)
+	s.created = append(s.created, name)
+	return nil
+}
+
+func TestIndexAdvisorRecommendations(t *testing.T) {
+	a := NewIndexAdvisor(IndexAdvisorConfig{MaxEntries: 2})
+	byCustomer := tsqlruntime.QueryShape{Table: "orders", Equality: []string{"customer", "status"}, Included: []string{"id"}}
+	a.RecordQuery(byCustomer, "SELECT id FROM orders WHERE customer = @c AND status = 'open'", 10*time.Millisecond)
+	// The same key columns in another order, reading another column
+	a.RecordQuery(tsqlruntime.QueryShape{Table: "orders", Equality: []string{"status", "customer"}, Included: []string{"qty"}},
+		"SELECT qty FROM orders WHERE status = 'open' AND customer = 1", 30*time.Millisecond)
+	a.RecordQuery(byCustomer, "SELECT id FROM orders WHERE customer = @c AND status = 'open'", 20*time.Millisecond)
+	a.RecordQuery(tsqlruntime.QueryShape{Table: "orders", Inequality: []string{"placed"}}, "q", 0)
+	// Beyond MaxEntries
+	a.RecordQuery(tsqlruntime.QueryShape{Table: "notes", Equality: []string{"id"}}, "q", 0)
+
+	recs := a.Recommendations()
+	if len(recs) != 2 {
+		t.Fatalf("got %+v", recs)
+	}
+	m := recs[0]
+	if m.Handle != 1 || m.Seeks != 3 || m.UniqueCompiles != 2 || m.AvgElapsed != 20*time.Millisecond ||
+		!reflect.DeepEqual(m.Columns(), []string{"customer", "status", "id", "qty"}) {
+		t.Errorf("got %+v", m)
+	}
+	if m.Name() != "IX_aul_orders_customer_status" {
+		t.Errorf("name %q", m.Name())
+	}
+	if recs[1].Seeks != 1 || recs[1].Inequality[0] != "placed" {
+		t.Errorf("got %+v", recs[1])
+	}
+}
+
+func TestCreateMissingIndexes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.JITEnabled = false
+	cfg.IndexAdvisor = IndexAdvisorConfig{Mode: IndexAdvisorAuto, MinSeeks: 2}
+	r := New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	storage := &indexStorage{
+		MemoryStorage: NewMemoryStorage(),
+		indexes: map[string][][]string{
+			"orders":    {{"id"}},
+			"customers": {{"id"}, {"region", "name"}},
+		},
+	}
+	r.SetStorage(storage)
+
+	a := r.IndexAdvisor()
+	for n := 0; n < 3; n++ {
+		a.RecordQuery(tsqlruntime.QueryShape{Table: "orders", Equality: []string{"customer"}, Included: []string{"qty"}}, "q1", 0)
+		// An index serves these
+		a.RecordQuery(tsqlruntime.QueryShape{Table: "orders", Equality: []string{"id"}}, "q2", 0)
+		a.RecordQuery(tsqlruntime.QueryShape{Table: "customers", Equality: []string{"region"}, Inequality: []string{"name"}}, "q3", 0)
+		// No longer a table
+		a.RecordQuery(tsqlruntime.QueryShape{Table: "dropped", Equality: []string{"id"}}, "q4", 0)
+	}
+	a.RecordQuery(tsqlruntime.QueryShape{Table: "orders", Inequality: []string{"placed"}}, "q5", 0)
+
+	ctx := context.Background()
+	recs, err := r.MissingIndexes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].Equality[0] != "customer" || recs[1].Inequality[0] != "placed" {
+		t.Fatalf("got %+v", recs)
+	}
+
+	created, err := r.CreateMissingIndexes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The index on placed is not sought often enough yet
+	if !reflect.DeepEqual(created, []string{"IX_aul_orders_customer"}) ||
+		!reflect.DeepEqual(storage.indexes["orders"][1], []string{"customer", "qty"}) {
+		t.Errorf("created %v: %v", created, storage.indexes)
+	}
+	if recs, _ = r.MissingIndexes(ctx); len(recs) != 1 {
+		t.Errorf("after creating: %+v", recs)
+	}
+
+	// Recommend mode leaves creating indexes to people
+	cfg.IndexAdvisor.Mode = IndexAdvisorRecommend
+	r = New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	r.SetStorage(storage)
+	for n := 0; n < 3; n++ {
+		r.IndexAdvisor().RecordQuery(tsqlruntime.QueryShape{Table: "orders", Equality: []string{"status"}}, "q", 0)
+	}
+	if created, err := r.CreateMissingIndexes(ctx); err != nil || len(created) != 0 {
+		t.Errorf("recommend mode created %v, %v", created, err)
+	}
+}
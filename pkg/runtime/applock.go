@@ -0,0 +1,431 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// Application locks are the named locks of sp_getapplock. Unlike table
+// locks they have modes, so a lock may be held by several owners at once,
+// and they may be owned by a session rather than a transaction, so that
+// they outlive the execution that took them. Waiters are granted the lock
+// in the order they asked for it, and are part of the same waits-for
+// graph as table locks, so a deadlock across both kinds is broken too.
+// In a cluster, each grant is also taken from the cluster's provider
+// once the node has granted it (see cluster.go).
+
+// appLockMode is a mode of sp_getapplock, in the order of
+// tsqlruntime.AppLockModes.
+type appLockMode int
+
+const (
+	appLockIntentShared appLockMode = iota
+	appLockShared
+	appLockUpdate
+	appLockIntentExclusive
+	appLockExclusive
+)
+
+// String returns the mode as sys.dm_tran_locks shows it.
+func (m appLockMode) String() string {
+	return [...]string{"IS", "S", "U", "IX", "X"}[m]
+}
+
+// appLockCompatible tells whether a mode, the first index, may be granted
+// while another session holds the second.
+var appLockCompatible = [5][5]bool{
+	//                        IS     S      U      IX     X
+	appLockIntentShared:    {true, true, true, true, false},
+	appLockShared:          {true, true, true, false, false},
+	appLockUpdate:          {true, true, false, false, false},
+	appLockIntentExclusive: {true, false, false, true, false},
+	appLockExclusive:       {false, false, false, false, false},
+}
+
+// appLock is an application lock held or waited for.
+type appLock struct {
+	key       string
+	database  string
+	principal string
+	resource  string
+	granted   []*appGrant
+	waiters   []*appWaiter // In arrival order
+}
+
+type appGrant struct {
+	owner *LockOwner
+	mode  appLockMode
+	count int // Times granted and not yet released
+	since time.Time
+
+	cluster ClusterLock // Taken from the cluster's provider; zero outside a cluster
+}
+
+type appWaiter struct {
+	owner  *LockOwner // The lock's owner once granted: the session's or the transaction's
+	waiter *LockOwner // The execution waiting
+	mode   appLockMode
+}
+
+// String describes the lock as sys.dm_tran_locks' resource_description.
+func (l *appLock) String() string {
+	return fmt.Sprintf("%s:[%s]", l.principal, l.resource)
+}
+
+// compatible reports whether mode may be granted to owner beside the
+// other sessions' grants.
+func (l *appLock) compatible(owner *LockOwner, mode appLockMode) bool {
+	for _, g := range l.granted {
+		if g.owner.sessionID != owner.sessionID && !appLockCompatible[mode][g.mode] {
+			return false
+		}
+	}
+	return true
+}
+
+// grantable reports whether mode may be granted to owner at once: it is
+// compatible and no one waits ahead, unless the session holds the lock
+// already, in which case waiting behind those who wait for it would be a
+// deadlock.
+func (l *appLock) grantable(owner *LockOwner, mode appLockMode) bool {
+	if !l.compatible(owner, mode) {
+		return false
+	}
+	if len(l.waiters) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(l.granted, func(g *appGrant) bool { return g.owner.sessionID == owner.sessionID })
+}
+
+// grantOf returns the grant of mode to owner, or nil.
+func (l *appLock) grantOf(owner *LockOwner, mode appLockMode) *appGrant {
+	for _, g := range l.granted {
+		if g.owner == owner && g.mode == mode {
+			return g
+		}
+	}
+	return nil
+}
+
+// grantsOf returns the grants held by owner.
+func (l *appLock) grantsOf(owner *LockOwner) []*appGrant {
+	var grants []*appGrant
+	for _, g := range l.granted {
+		if g.owner == owner {
+			grants = append(grants, g)
+		}
+	}
+	return grants
+}
+
+// waiterOf returns the wait of o, which waits for l.
+func (l *appLock) waiterOf(o *LockOwner) *appWaiter {
+	for _, w := range l.waiters {
+		if w.waiter == o {
+			return w
+		}
+	}
+	return nil
+}
+
+// modeOf returns the strongest mode owner holds, or else waits for.
+func (l *appLock) modeOf(owner *LockOwner) appLockMode {
+	mode, found := appLockIntentShared, false
+	for _, g := range l.grantsOf(owner) {
+		mode, found = max(mode, g.mode), true
+	}
+	if !found {
+		if w := l.waiterOf(owner); w != nil {
+			mode = w.mode
+		}
+	}
+	return mode
+}
+
+// blockers returns the owners o, which waits for l, waits for: those of
+// other sessions holding an incompatible mode, and those queued ahead.
+func (l *appLock) blockers(o *LockOwner) []*LockOwner {
+	var blockers []*LockOwner
+	var mode appLockMode
+	for _, w := range l.waiters {
+		if w.waiter == o {
+			mode = w.mode
+			break
+		}
+		if w.waiter.sessionID != o.sessionID {
+			blockers = append(blockers, w.waiter)
+		}
+	}
+	for _, g := range l.granted {
+		if g.owner.sessionID != o.sessionID && !appLockCompatible[mode][g.mode] && !slices.Contains(blockers, g.owner) {
+			blockers = append(blockers, g.owner)
+		}
+	}
+	return blockers
+}
+
+// GetAppLock implements tsqlruntime.AppLocker.
+func (o *LockOwner) GetAppLock(ctx context.Context, lock tsqlruntime.AppLock, timeout time.Duration) (int, error) {
+	mode := appLockMode(slices.Index(tsqlruntime.AppLockModes, lock.Mode))
+	if mode < 0 {
+		return tsqlruntime.AppLockInvalid, fmt.Errorf("unknown application lock mode %q", lock.Mode)
+	}
+	m := o.manager
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	m.mu.Lock()
+	owner := o
+	if lock.Session {
+		owner = m.sessionOwner(o.sessionID)
+	}
+	l := m.appLock(o.database, lock)
+	if l.grantable(owner, mode) {
+		m.grantApp(l, owner, mode)
+		m.mu.Unlock()
+		return m.clusterLock(ctx, l, owner, mode, deadline, tsqlruntime.AppLockGranted)
+	}
+	if timeout == 0 {
+		m.dropIfUnused(l)
+		m.mu.Unlock()
+		return tsqlruntime.AppLockTimeout, nil
+	}
+
+	if o.txn == 0 {
+		m.txns++
+		o.txn = m.txns
+		o.txnStart = time.Now()
+	}
+	o.waitingApp = l
+	o.waitStart = time.Now()
+	granted := make(chan error, 1)
+	o.granted = granted
+	l.waiters = append(l.waiters, &appWaiter{owner: owner, waiter: o, mode: mode})
+	m.waiters[o.sessionID] = o
+	if cycle := m.cycle(o); cycle != nil {
+		m.breakDeadlock(cycle)
+	}
+	m.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	rc := tsqlruntime.AppLockTimeout
+	select {
+	case err := <-granted:
+		if err != nil {
+			return tsqlruntime.AppLockDeadlock, nil
+		}
+		return m.clusterLock(ctx, l, owner, mode, deadline, tsqlruntime.AppLockGrantedAfterWait)
+	case <-ctx.Done():
+		rc = tsqlruntime.AppLockCancelled
+	case <-expired:
+	}
+
+	m.mu.Lock()
+	if o.waitingApp == l {
+		m.stopWaiting(o)
+		m.mu.Unlock()
+		if rc == tsqlruntime.AppLockCancelled {
+			return rc, ctx.Err()
+		}
+		return rc, nil
+	}
+	m.mu.Unlock()
+	// Granted or chosen as a victim meanwhile
+	if err := <-granted; err != nil {
+		return tsqlruntime.AppLockDeadlock, nil
+	}
+	return m.clusterLock(ctx, l, owner, mode, deadline, tsqlruntime.AppLockGrantedAfterWait)
+}
+
+// clusterLock takes a grant of l the node has just made to owner from the
+// cluster, if the grant is new and the node is in one, and returns the
+// return code of sp_getapplock: rc, or worse. If the cluster does not
+// grant it by deadline, the node's grant is taken back.
+func (m *LockManager) clusterLock(ctx context.Context, l *appLock, owner *LockOwner, mode appLockMode, deadline time.Time, rc int) (int, error) {
+	m.mu.Lock()
+	c := m.cluster
+	g := l.grantOf(owner, mode)
+	if c == nil || g == nil || g.cluster.Holder != "" {
+		m.mu.Unlock()
+		return rc, nil
+	}
+	g.cluster = c.appLock(l, mode)
+	lock := g.cluster
+	m.mu.Unlock()
+
+	waited, err := c.lock(ctx, lock, deadline)
+	if err == nil {
+		if waited {
+			rc = tsqlruntime.AppLockGrantedAfterWait
+		}
+		return rc, nil
+	}
+	m.mu.Lock()
+	m.releaseGrant(l, owner, g)
+	m.mu.Unlock()
+	switch {
+	case errors.Is(err, errClusterTimeout):
+		return tsqlruntime.AppLockTimeout, nil
+	case ctx.Err() != nil:
+		return tsqlruntime.AppLockCancelled, ctx.Err()
+	}
+	return tsqlruntime.AppLockInvalid, err
+}
+
+// ReleaseAppLock implements tsqlruntime.AppLocker. Of the modes the owner
+// holds the lock in, the one granted last is released.
+func (o *LockOwner) ReleaseAppLock(lock tsqlruntime.AppLock) bool {
+	m := o.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	owner := o
+	if lock.Session {
+		if owner = m.sessions[o.sessionID]; owner == nil {
+			return false
+		}
+	}
+	l := m.apps[appLockKey(o.database, lock)]
+	if l == nil {
+		return false
+	}
+	for i := len(l.granted) - 1; i >= 0; i-- {
+		if g := l.granted[i]; g.owner == owner {
+			m.releaseGrant(l, owner, g)
+			return true
+		}
+	}
+	return false
+}
+
+// releaseGrant releases g, a grant of l to owner, once. Caller holds
+// m.mu.
+func (m *LockManager) releaseGrant(l *appLock, owner *LockOwner, g *appGrant) {
+	if g.count--; g.count == 0 {
+		l.granted = slices.DeleteFunc(l.granted, func(other *appGrant) bool { return other == g })
+		if len(l.grantsOf(owner)) == 0 {
+			owner.apps = slices.DeleteFunc(owner.apps, func(a *appLock) bool { return a == l })
+		}
+		m.clusterUnlock(g)
+	}
+	m.grantWaiting(l)
+	m.dropIfUnused(l)
+}
+
+// clusterUnlock releases g, which the node no longer grants, in the
+// cluster. Caller holds m.mu.
+func (m *LockManager) clusterUnlock(g *appGrant) {
+	if m.cluster != nil && g.cluster.Holder != "" {
+		m.cluster.unlock(g.cluster)
+	}
+}
+
+// ReleaseSession releases the application locks a session owns, as it
+// closes.
+func (m *LockManager) ReleaseSession(sessionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if owner := m.sessions[sessionID]; owner != nil {
+		m.releaseApps(owner)
+		delete(m.sessions, sessionID)
+	}
+}
+
+// sessionOwner returns the owner of a session's application locks.
+// Caller holds m.mu.
+func (m *LockManager) sessionOwner(sessionID string) *LockOwner {
+	owner := m.sessions[sessionID]
+	if owner == nil {
+		owner = &LockOwner{manager: m, sessionID: sessionID, session: true}
+		m.sessions[sessionID] = owner
+	}
+	return owner
+}
+
+func appLockKey(database string, lock tsqlruntime.AppLock) string {
+	return database + "\x00" + lock.Principal + "\x00" + lock.Resource
+}
+
+// appLock returns the application lock lock names in database, creating
+// it. Caller holds m.mu.
+func (m *LockManager) appLock(database string, lock tsqlruntime.AppLock) *appLock {
+	key := appLockKey(database, lock)
+	l := m.apps[key]
+	if l == nil {
+		l = &appLock{key: key, database: database, principal: lock.Principal, resource: lock.Resource}
+		m.apps[key] = l
+	}
+	return l
+}
+
+// grantApp grants l to owner in mode. Caller holds m.mu.
+func (m *LockManager) grantApp(l *appLock, owner *LockOwner, mode appLockMode) {
+	if g := l.grantOf(owner, mode); g != nil {
+		g.count++
+		return
+	}
+	if owner.txn == 0 {
+		m.txns++
+		owner.txn = m.txns
+		owner.txnStart = time.Now()
+	}
+	l.granted = append(l.granted, &appGrant{owner: owner, mode: mode, count: 1, since: time.Now()})
+	if !slices.Contains(owner.apps, l) {
+		owner.apps = append(owner.apps, l)
+	}
+}
+
+// grantWaiting grants l to those waiting for it, in order, until one must
+// wait on. Caller holds m.mu.
+func (m *LockManager) grantWaiting(l *appLock) {
+	for len(l.waiters) > 0 {
+		w := l.waiters[0]
+		if !l.compatible(w.owner, w.mode) {
+			return
+		}
+		l.waiters = l.waiters[1:]
+		m.grantApp(l, w.owner, w.mode)
+		m.endWait(w.waiter)
+		w.waiter.granted <- nil
+	}
+}
+
+// releaseApps releases every application lock owner holds. Caller holds
+// m.mu.
+func (m *LockManager) releaseApps(owner *LockOwner) {
+	for _, l := range owner.apps {
+		l.granted = slices.DeleteFunc(l.granted, func(g *appGrant) bool {
+			if g.owner != owner {
+				return false
+			}
+			m.clusterUnlock(g)
+			return true
+		})
+		m.grantWaiting(l)
+		m.dropIfUnused(l)
+	}
+	owner.apps = nil
+	if owner.session {
+		owner.txn = 0
+	}
+}
+
+// dropIfUnused forgets l once no one holds or waits for it. Caller holds
+// m.mu.
+func (m *LockManager) dropIfUnused(l *appLock) {
+	if len(l.granted) == 0 && len(l.waiters) == 0 {
+		delete(m.apps, l.key)
+	}
+}
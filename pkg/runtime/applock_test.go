@@ -0,0 +1,217 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+type appLockResult struct {
+	rc  int
+	err error
+}
+
+// appLockAsync asks for lock for o on another goroutine, returning the
+// outcome once o is waiting for it or has already got it.
+func appLockAsync(t *testing.T, ctx context.Context, o *LockOwner, lock tsqlruntime.AppLock, timeout time.Duration) <-chan appLockResult {
+	t.Helper()
+	done := make(chan appLockResult, 1)
+	go func() {
+		rc, err := o.GetAppLock(ctx, lock, timeout)
+		done <- appLockResult{rc, err}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		o.manager.mu.Lock()
+		waiting := o.waitingApp != nil
+		o.manager.mu.Unlock()
+		if waiting || len(done) > 0 {
+			return done
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never waited for %s", o.sessionID, lock.Resource)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func namedLock(resource, mode string) tsqlruntime.AppLock {
+	return tsqlruntime.AppLock{Resource: resource, Principal: "public", Mode: mode}
+}
+
+func TestAppLock_Modes(t *testing.T) {
+	ctx := context.Background()
+	m := NewLockManager(nil)
+	a := m.Owner("s1", "db", "")
+	b := m.Owner("s2", "db", "")
+
+	if rc, _ := a.GetAppLock(ctx, namedLock("job", "Shared"), 0); rc != tsqlruntime.AppLockGranted {
+		t.Fatalf("S: rc %d", rc)
+	}
+	if rc, _ := b.GetAppLock(ctx, namedLock("job", "Update"), 0); rc != tsqlruntime.AppLockGranted {
+		t.Fatalf("U beside S: rc %d", rc)
+	}
+	if rc, _ := b.GetAppLock(ctx, namedLock("job", "Exclusive"), 0); rc != tsqlruntime.AppLockTimeout {
+		t.Fatalf("X beside S: rc %d", rc)
+	}
+	// The session's own locks never block it
+	if rc, _ := a.GetAppLock(ctx, namedLock("job", "IntentShared"), 0); rc != tsqlruntime.AppLockGranted {
+		t.Fatalf("IS beside own S: rc %d", rc)
+	}
+	// Names are case-sensitive
+	if rc, _ := b.GetAppLock(ctx, namedLock("JOB", "Exclusive"), 0); rc != tsqlruntime.AppLockGranted {
+		t.Fatalf("X on JOB: rc %d", rc)
+	}
+
+	done := appLockAsync(t, ctx, b, namedLock("job", "Exclusive"), -1)
+	a.ReleaseAll()
+	if r := <-done; r.rc != tsqlruntime.AppLockGrantedAfterWait || r.err != nil {
+		t.Fatalf("after wait: rc %d, %v", r.rc, r.err)
+	}
+	if !b.ReleaseAppLock(namedLock("job", "")) || !b.ReleaseAppLock(namedLock("job", "")) {
+		t.Fatal("X and U not both released")
+	}
+	if b.ReleaseAppLock(namedLock("job", "")) {
+		t.Error("released a lock not held")
+	}
+	b.ReleaseAll()
+	if len(m.apps) != 0 {
+		t.Errorf("%d locks left", len(m.apps))
+	}
+}
+
+func TestAppLock_Timeout(t *testing.T) {
+	ctx := context.Background()
+	m := NewLockManager(nil)
+	a := m.Owner("s1", "db", "")
+	b := m.Owner("s2", "db", "")
+
+	if rc, _ := a.GetAppLock(ctx, namedLock("job", "Exclusive"), 0); rc != tsqlruntime.AppLockGranted {
+		t.Fatalf("rc %d", rc)
+	}
+	if rc, err := b.GetAppLock(ctx, namedLock("job", "Shared"), 20*time.Millisecond); rc != tsqlruntime.AppLockTimeout || err != nil {
+		t.Fatalf("timed out: rc %d, %v", rc, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	done := appLockAsync(t, cancelled, b, namedLock("job", "Shared"), -1)
+	cancel()
+	if r := <-done; r.rc != tsqlruntime.AppLockCancelled || !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("cancelled: rc %d, %v", r.rc, r.err)
+	}
+	if b.waitingApp != nil || len(m.apps["db\x00public\x00job"].waiters) != 0 {
+		t.Error("cancelled wait left queued")
+	}
+}
+
+func TestAppLock_Session(t *testing.T) {
+	ctx := context.Background()
+	m := NewLockManager(nil)
+	lock := namedLock("job", "Exclusive")
+	lock.Session = true
+
+	a := m.Owner("s1", "db", "")
+	if rc, _ := a.GetAppLock(ctx, lock, 0); rc != tsqlruntime.AppLockGranted {
+		t.Fatalf("rc %d", rc)
+	}
+	a.ReleaseAll()
+
+	// The lock outlives the transaction, but not the session
+	b := m.Owner("s2", "db", "")
+	if rc, _ := b.GetAppLock(ctx, lock, 0); rc != tsqlruntime.AppLockTimeout {
+		t.Fatalf("taken from a session: rc %d", rc)
+	}
+	requests := m.Requests()
+	if len(requests) != 1 || requests[0].ResourceType != "APPLICATION" || requests[0].OwnerType != "SESSION" ||
+		requests[0].Resource != "public:[job]" || requests[0].Mode != "X" || requests[0].SessionID != "s1" {
+		t.Fatalf("requests: %+v", requests)
+	}
+
+	done := appLockAsync(t, ctx, b, lock, -1)
+	m.ReleaseSession("s1")
+	if r := <-done; r.rc != tsqlruntime.AppLockGrantedAfterWait {
+		t.Fatalf("rc %d", r.rc)
+	}
+	if !b.ReleaseAppLock(lock) {
+		t.Error("session lock not released")
+	}
+}
+
+func TestAppLock_Deadlock(t *testing.T) {
+	ctx := context.Background()
+	m := NewLockManager(nil)
+	a := m.Owner("s1", "db", "")
+	b := m.Owner("s2", "db", "")
+
+	if rc, _ := a.GetAppLock(ctx, namedLock("job", "Exclusive"), 0); rc != tsqlruntime.AppLockGranted {
+		t.Fatalf("rc %d", rc)
+	}
+	if err := b.Lock(ctx, "dbo.t1"); err != nil {
+		t.Fatal(err)
+	}
+	aDone := lockAsync(t, ctx, a, "dbo.t1")
+
+	blocking := m.Blocking()
+	if len(blocking) != 2 || blocking[1].SessionID != "s2" || blocking[1].BlockedCount != 1 {
+		t.Fatalf("blocking: %+v", blocking)
+	}
+
+	// b, younger with as many locks, closes the cycle and is the victim
+	if rc, err := b.GetAppLock(ctx, namedLock("job", "Shared"), -1); rc != tsqlruntime.AppLockDeadlock || err != nil {
+		t.Fatalf("rc %d, %v", rc, err)
+	}
+	b.ReleaseAll()
+	if err := <-aDone; err != nil {
+		t.Fatalf("survivor failed: %v", err)
+	}
+	a.ReleaseAll()
+
+	if reports := m.Deadlocks(); len(reports) != 1 || reports[0].VictimSessionID != "s2" {
+		t.Fatalf("reports: %+v", reports)
+	}
+}
+
+func TestAppLock_Blocking(t *testing.T) {
+	ctx := context.Background()
+	m := NewLockManager(nil)
+	a := m.Owner("s1", "db", "")
+	b := m.Owner("s2", "db", "")
+	c := m.Owner("s3", "db", "")
+
+	if rc, _ := a.GetAppLock(ctx, namedLock("job", "Shared"), 0); rc != tsqlruntime.AppLockGranted {
+		t.Fatalf("rc %d", rc)
+	}
+	bDone := appLockAsync(t, ctx, b, namedLock("job", "Exclusive"), -1)
+	// c's S is compatible with a's, but queues behind b's X
+	cDone := appLockAsync(t, ctx, c, namedLock("job", "Shared"), -1)
+
+	blocking := m.Blocking()
+	if len(blocking) != 3 {
+		t.Fatalf("blocking: %+v", blocking)
+	}
+	for i, want := range []BlockedSession{
+		{SessionID: "s1", HeadBlockerID: "s1", BlockedCount: 1},
+		{SessionID: "s2", BlockingSessionID: "s1", HeadBlockerID: "s1", WaitType: "LCK_M_X", BlockedCount: 1},
+		{SessionID: "s3", BlockingSessionID: "s2", HeadBlockerID: "s1", WaitType: "LCK_M_S"},
+	} {
+		got := blocking[i]
+		if got.SessionID != want.SessionID || got.BlockingSessionID != want.BlockingSessionID ||
+			got.HeadBlockerID != want.HeadBlockerID || got.WaitType != want.WaitType || got.BlockedCount != want.BlockedCount {
+			t.Errorf("row %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	a.ReleaseAll()
+	if r := <-bDone; r.rc != tsqlruntime.AppLockGrantedAfterWait {
+		t.Fatalf("b: rc %d", r.rc)
+	}
+	b.ReleaseAll()
+	if r := <-cDone; r.rc != tsqlruntime.AppLockGrantedAfterWait {
+		t.Fatalf("c: rc %d", r.rc)
+	}
+	c.ReleaseAll()
+}
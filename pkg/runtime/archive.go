@@ -0,0 +1,22 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/ha1tch/aul/pkg/archive"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// archiveTables restores the archived partitions queries read with an
+// Archiver.
+type archiveTables struct {
+	archiver *archive.Archiver
+}
+
+func (a archiveTables) Column(table string) (string, bool) {
+	return a.archiver.Column(table)
+}
+
+func (a archiveTables) Restore(ctx context.Context, db tsqlruntime.QueryExecutor, table, from, to string) (string, error) {
+	return a.archiver.Restore(ctx, db, table, from, to)
+}
@@ -0,0 +1,187 @@
+package runtime
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// LockRequest is a table or application lock that a transaction or
+// session holds or waits for.
+type LockRequest struct {
+	Database     string
+	ResourceType string // "OBJECT" for a table, "APPLICATION" for an application lock
+	Table        string // "" for an application lock
+	Resource     string // Principal and resource of an application lock
+	Mode         string // "X" for every table lock
+	Status       string // "GRANT" or "WAIT"
+	OwnerType    string // "TRANSACTION" or "SESSION"
+	SessionID    string
+	Transaction  int64     // Age order of the owning transaction
+	Since        time.Time // When the transaction began, or the wait began
+}
+
+// BlockedSession is a transaction in a blocking chain: one that waits for
+// a lock, or one that holds a lock others wait for.
+type BlockedSession struct {
+	SessionID         string
+	BlockingSessionID string // "" for a head blocker, which waits for nothing
+	HeadBlockerID     string // The transaction at the end of the chain
+	WaitResource      string // "" for a head blocker
+	ResourceType      string // "OBJECT" or "APPLICATION", "" for a head blocker
+	WaitType          string // "LCK_M_" and the mode waited for, "" for a head blocker
+	WaitTime          time.Duration
+	BlockedCount      int // Transactions waiting for this one's locks
+	LockCount         int
+	TransactionTime   time.Duration
+	InputBuf          string
+}
+
+// Requests returns the table locks held and waited for, by table, the
+// holder of each before its waiters in the order they arrived, then the
+// application locks, likewise.
+func (m *LockManager) Requests() []LockRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	resources := make([]string, 0, len(m.tables))
+	for resource := range m.tables {
+		resources = append(resources, resource)
+	}
+	slices.Sort(resources)
+
+	var requests []LockRequest
+	for _, resource := range resources {
+		l := m.tables[resource]
+		request := func(o *LockOwner, status string, since time.Time) LockRequest {
+			return LockRequest{
+				Database:     l.database,
+				ResourceType: "OBJECT",
+				Table:        l.table,
+				Mode:         "X",
+				Status:       status,
+				OwnerType:    "TRANSACTION",
+				SessionID:    o.sessionID,
+				Transaction:  o.txn,
+				Since:        since,
+			}
+		}
+		requests = append(requests, request(l.holder, "GRANT", l.holder.txnStart))
+		for _, w := range l.waiters {
+			requests = append(requests, request(w, "WAIT", w.waitStart))
+		}
+	}
+
+	keys := make([]string, 0, len(m.apps))
+	for key := range m.apps {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		l := m.apps[key]
+		request := func(o *LockOwner, mode appLockMode, status string, since time.Time) LockRequest {
+			r := LockRequest{
+				Database:     l.database,
+				ResourceType: "APPLICATION",
+				Resource:     l.String(),
+				Mode:         mode.String(),
+				Status:       status,
+				OwnerType:    "TRANSACTION",
+				SessionID:    o.sessionID,
+				Transaction:  o.txn,
+				Since:        since,
+			}
+			if o.session {
+				r.OwnerType = "SESSION"
+			}
+			return r
+		}
+		for _, g := range l.granted {
+			requests = append(requests, request(g.owner, g.mode, "GRANT", g.since))
+		}
+		for _, w := range l.waiters {
+			requests = append(requests, request(w.owner, w.mode, "WAIT", w.waiter.waitStart))
+		}
+	}
+	return requests
+}
+
+// Blocking returns the transactions in blocking chains, oldest first. A
+// transaction that runs unhindered and blocks no one is left out. A
+// session's application locks count as the locks of its own transaction.
+func (m *LockManager) Blocking() []BlockedSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A session is shown as the owner it waits as, if it waits, so that
+	// the owner of its session's application locks is not shown apart
+	blocked := make(map[*LockOwner]int)
+	var owners []*LockOwner
+	add := func(o *LockOwner, blocks int) {
+		if w := m.waiters[o.sessionID]; w != nil {
+			o = w
+		}
+		if _, ok := blocked[o]; !ok {
+			owners = append(owners, o)
+		}
+		blocked[o] += blocks
+	}
+	for _, w := range m.waiters {
+		add(w, 0)
+		for _, b := range m.blockers(w) {
+			add(b, 1)
+		}
+	}
+	slices.SortFunc(owners, func(a, b *LockOwner) int {
+		return cmp.Or(cmp.Compare(a.txn, b.txn), cmp.Compare(a.sessionID, b.sessionID))
+	})
+
+	now := time.Now()
+	sessions := make([]BlockedSession, 0, len(owners))
+	for _, o := range owners {
+		s := BlockedSession{
+			SessionID:       o.sessionID,
+			HeadBlockerID:   m.headBlocker(o).sessionID,
+			BlockedCount:    blocked[o],
+			LockCount:       o.lockCount(),
+			TransactionTime: now.Sub(o.txnStart),
+			InputBuf:        o.inputBuf,
+		}
+		if blockers := m.blockers(o); len(blockers) > 0 {
+			s.BlockingSessionID = blockers[0].sessionID
+			s.WaitResource = o.waiting
+			s.ResourceType = "OBJECT"
+			s.WaitType = "LCK_M_X"
+			if l := o.waitingApp; l != nil {
+				s.WaitResource = l.String()
+				s.ResourceType = "APPLICATION"
+				s.WaitType = "LCK_M_" + l.waiterOf(o).mode.String()
+			}
+			s.WaitTime = now.Sub(o.waitStart)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// headBlocker follows the chain of waits from o to the transaction that
+// waits for nothing, through the first of those each waits for. Caller
+// holds m.mu.
+func (m *LockManager) headBlocker(o *LockOwner) *LockOwner {
+	seen := []*LockOwner{o}
+	for {
+		blockers := m.blockers(o)
+		if len(blockers) == 0 {
+			return o
+		}
+		next := blockers[0]
+		if w := m.waiters[next.sessionID]; w != nil {
+			next = w
+		}
+		if slices.Contains(seen, next) {
+			return next // A deadlock about to be broken
+		}
+		seen = append(seen, next)
+		o = next
+	}
+}
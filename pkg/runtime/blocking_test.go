@@ -0,0 +1,98 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+)
+
+// blockingChain sets up c waiting for b, which holds t2 and waits for a,
+// which holds t1.
+func blockingChain(t *testing.T) (m *LockManager, a, b, c *LockOwner, done []<-chan error) {
+	t.Helper()
+	ctx := context.Background()
+	m = NewLockManager(nil)
+	a = m.Owner("a", "db", "dbo.head")
+	b = m.Owner("b", "db", "dbo.middle")
+	c = m.Owner("c", "db", "dbo.tail")
+	if err := a.Lock(ctx, "dbo.t1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Lock(ctx, "dbo.t2"); err != nil {
+		t.Fatal(err)
+	}
+	done = append(done, lockAsync(t, ctx, b, "dbo.t1"), lockAsync(t, ctx, c, "dbo.t2"))
+	return m, a, b, c, done
+}
+
+func TestLockManager_Requests(t *testing.T) {
+	m, a, b, c, done := blockingChain(t)
+
+	type request struct{ table, status, session string }
+	var got []request
+	for _, r := range m.Requests() {
+		if r.Database != "db" || r.Mode != "X" {
+			t.Errorf("unexpected request: %+v", r)
+		}
+		got = append(got, request{r.Table, r.Status, r.SessionID})
+	}
+	want := []request{
+		{"dbo.t1", "GRANT", "a"},
+		{"dbo.t1", "WAIT", "b"},
+		{"dbo.t2", "GRANT", "b"},
+		{"dbo.t2", "WAIT", "c"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	a.ReleaseAll()
+	<-done[0]
+	b.ReleaseAll()
+	<-done[1]
+	c.ReleaseAll()
+	if r := m.Requests(); len(r) != 0 {
+		t.Errorf("requests left: %v", r)
+	}
+}
+
+func TestLockManager_Blocking(t *testing.T) {
+	m, a, b, c, done := blockingChain(t)
+
+	got := m.Blocking()
+	if len(got) != 3 {
+		t.Fatalf("got %d sessions, want 3: %+v", len(got), got)
+	}
+	tests := []struct {
+		session, blocking, resource, inputBuf string
+		blocked, locks                        int
+	}{
+		{"a", "", "", "dbo.head", 1, 1},
+		{"b", "a", "db.dbo.t1", "dbo.middle", 1, 1},
+		{"c", "b", "db.dbo.t2", "dbo.tail", 0, 0},
+	}
+	for i, tt := range tests {
+		s := got[i]
+		if s.SessionID != tt.session || s.BlockingSessionID != tt.blocking || s.HeadBlockerID != "a" ||
+			s.WaitResource != tt.resource || s.InputBuf != tt.inputBuf ||
+			s.BlockedCount != tt.blocked || s.LockCount != tt.locks {
+			t.Errorf("session %d = %+v", i, s)
+		}
+	}
+
+	a.ReleaseAll()
+	<-done[0]
+	if got := m.Blocking(); len(got) != 2 || got[0].SessionID != "b" || got[0].BlockingSessionID != "" {
+		t.Errorf("after a finished: %+v", got)
+	}
+	b.ReleaseAll()
+	<-done[1]
+	if got := m.Blocking(); len(got) != 0 {
+		t.Errorf("c still blocked: %+v", got)
+	}
+	c.ReleaseAll()
+}
@@ -0,0 +1,390 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/notify"
+)
+
+// BreakerConfig controls the per-procedure circuit breakers.
+//
+// Each procedure's breaker watches its most recent Window calls. Once at
+// least MinCalls have been seen, the breaker trips when the share of
+// failed calls reaches ErrorRate, or the share of calls slower than
+// SlowCall reaches SlowCallRate. A tripped (open) breaker rejects calls
+// immediately for Cooldown, then lets HalfOpenCalls trial calls through:
+// if they all succeed the breaker closes, otherwise it opens again.
+type BreakerConfig struct {
+	Enabled bool
+
+	Window   int // Calls considered when deciding to trip
+	MinCalls int // Calls needed before the breaker may trip
+
+	ErrorRate float64 // Failed share of the window that trips the breaker (0-1)
+
+	SlowCall     time.Duration // Calls slower than this count as slow (0 = off)
+	SlowCallRate float64       // Slow share of the window that trips the breaker (0-1)
+
+	Cooldown      time.Duration // Time spent open before trial calls
+	HalfOpenCalls int           // Trial calls allowed while half-open
+
+	// Severity is the SQL Server severity class of the error returned to
+	// clients while the breaker is open.
+	Severity uint8
+}
+
+// DefaultBreakerConfig returns the breaker defaults. Breakers are disabled
+// unless Enabled is set.
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		Window:        20,
+		MinCalls:      10,
+		ErrorRate:     0.5,
+		SlowCallRate:  0.5,
+		Cooldown:      30 * time.Second,
+		HalfOpenCalls: 1,
+		Severity:      16,
+	}
+}
+
+// BreakerErrorNumber is the SQL error number reported for calls rejected
+// by an open breaker.
+const BreakerErrorNumber = 50410
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Calls pass through
+	BreakerOpen                         // Calls are rejected
+	BreakerHalfOpen                     // Trial calls decide whether to close
+)
+
+// String returns the state name.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "CLOSED"
+	case BreakerOpen:
+		return "OPEN"
+	case BreakerHalfOpen:
+		return "HALF_OPEN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// BreakerStatus is a snapshot of one procedure's breaker.
+type BreakerStatus struct {
+	Procedure  string
+	State      BreakerState
+	Calls      int // Calls in the current window
+	Failures   int
+	SlowCalls  int
+	Trips      int64 // Times the breaker has opened
+	Rejected   int64 // Calls rejected while open
+	LastTripAt time.Time
+	LastReason string
+	RetryAfter time.Time // When an open breaker next allows a trial call
+}
+
+// BreakerSet holds the circuit breakers of all procedures.
+type BreakerSet struct {
+	config BreakerConfig
+	logger *log.Logger
+	now    func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	notifier *notify.Notifier // Told when a breaker opens (nil = nobody)
+}
+
+// NewBreakerSet creates a breaker set. Zero fields of cfg take their
+// defaults.
+func NewBreakerSet(cfg BreakerConfig, logger *log.Logger) *BreakerSet {
+	def := DefaultBreakerConfig()
+	if cfg.Window <= 0 {
+		cfg.Window = def.Window
+	}
+	if cfg.MinCalls <= 0 || cfg.MinCalls > cfg.Window {
+		cfg.MinCalls = min(def.MinCalls, cfg.Window)
+	}
+	if cfg.ErrorRate <= 0 {
+		cfg.ErrorRate = def.ErrorRate
+	}
+	if cfg.SlowCallRate <= 0 {
+		cfg.SlowCallRate = def.SlowCallRate
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = def.Cooldown
+	}
+	if cfg.HalfOpenCalls <= 0 {
+		cfg.HalfOpenCalls = def.HalfOpenCalls
+	}
+	if cfg.Severity == 0 {
+		cfg.Severity = def.Severity
+	}
+	return &BreakerSet{
+		config:   cfg,
+		logger:   logger,
+		now:      time.Now,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// SetNotifier sets the notifier told when a procedure is quarantined.
+func (s *BreakerSet) SetNotifier(n *notify.Notifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifier = n
+}
+
+// Config returns the effective configuration.
+func (s *BreakerSet) Config() BreakerConfig {
+	return s.config
+}
+
+// breaker is the state of one procedure's circuit.
+type breaker struct {
+	name  string
+	state BreakerState
+
+	// Ring buffer of the most recent outcomes
+	outcomes []outcome
+	next     int
+	filled   int
+
+	openedAt   time.Time
+	trials     int // Trial calls admitted while half-open
+	trialOK    int // Trial calls that succeeded
+	trips      int64
+	rejected   int64
+	lastReason string
+}
+
+type outcome struct {
+	failed bool
+	slow   bool
+}
+
+func (s *BreakerSet) get(name string) *breaker {
+	key := strings.ToLower(name)
+	b, ok := s.breakers[key]
+	if !ok {
+		b = &breaker{name: name, outcomes: make([]outcome, s.config.Window)}
+		s.breakers[key] = b
+	}
+	return b
+}
+
+// Allow reports whether a call to the procedure may proceed. It returns
+// an error carrying the configured severity while the breaker is open.
+func (s *BreakerSet) Allow(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(name)
+	switch b.state {
+	case BreakerOpen:
+		retryAt := b.openedAt.Add(s.config.Cooldown)
+		if s.now().Before(retryAt) {
+			b.rejected++
+			return s.openError(b, retryAt)
+		}
+		s.transition(b, BreakerHalfOpen, "cooldown elapsed")
+		b.trials, b.trialOK = 0, 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.trials >= s.config.HalfOpenCalls {
+			b.rejected++
+			return s.openError(b, time.Time{})
+		}
+		b.trials++
+	}
+	return nil
+}
+
+// Record reports the outcome of a call admitted by Allow.
+func (s *BreakerSet) Record(name string, elapsed time.Duration, err error) {
+	slow := s.config.SlowCall > 0 && elapsed > s.config.SlowCall
+	failed := err != nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(name)
+
+	// A caller giving up says nothing about the procedure's health; free
+	// the trial slot so another call can decide
+	if errors.Is(err, context.Canceled) {
+		if b.state == BreakerHalfOpen && b.trials > 0 {
+			b.trials--
+		}
+		return
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed || slow {
+			reason := "trial call failed"
+			if !failed {
+				reason = fmt.Sprintf("trial call took %v", elapsed.Round(time.Millisecond))
+			}
+			s.trip(b, reason)
+			return
+		}
+		b.trialOK++
+		if b.trialOK >= s.config.HalfOpenCalls {
+			b.reset()
+			s.transition(b, BreakerClosed, "trial calls succeeded")
+		}
+		return
+	case BreakerOpen:
+		// A call admitted before the breaker opened; its outcome is moot
+		return
+	}
+
+	b.outcomes[b.next] = outcome{failed: failed, slow: slow}
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.filled < len(b.outcomes) {
+		b.filled++
+	}
+	if b.filled < s.config.MinCalls {
+		return
+	}
+
+	failures, slowCalls := b.counts()
+	switch {
+	case float64(failures)/float64(b.filled) >= s.config.ErrorRate:
+		s.trip(b, fmt.Sprintf("%d of the last %d calls failed", failures, b.filled))
+	case s.config.SlowCall > 0 && float64(slowCalls)/float64(b.filled) >= s.config.SlowCallRate:
+		s.trip(b, fmt.Sprintf("%d of the last %d calls took longer than %v", slowCalls, b.filled, s.config.SlowCall))
+	}
+}
+
+// Reset closes a procedure's breaker and clears its history. It reports
+// whether the procedure had a breaker.
+func (s *BreakerSet) Reset(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[strings.ToLower(name)]
+	if !ok {
+		return false
+	}
+	if b.state != BreakerClosed {
+		s.transition(b, BreakerClosed, "reset")
+	}
+	b.reset()
+	return true
+}
+
+// Status returns a snapshot of every breaker, ordered by procedure name.
+func (s *BreakerSet) Status() []BreakerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		failures, slowCalls := b.counts()
+		st := BreakerStatus{
+			Procedure:  b.name,
+			State:      b.state,
+			Calls:      b.filled,
+			Failures:   failures,
+			SlowCalls:  slowCalls,
+			Trips:      b.trips,
+			Rejected:   b.rejected,
+			LastReason: b.lastReason,
+		}
+		if b.trips > 0 {
+			st.LastTripAt = b.openedAt
+		}
+		if b.state == BreakerOpen {
+			st.RetryAfter = b.openedAt.Add(s.config.Cooldown)
+		}
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Procedure < statuses[j].Procedure })
+	return statuses
+}
+
+func (b *breaker) counts() (failures, slow int) {
+	for _, o := range b.outcomes[:b.filled] {
+		if o.failed {
+			failures++
+		}
+		if o.slow {
+			slow++
+		}
+	}
+	return failures, slow
+}
+
+func (b *breaker) reset() {
+	for i := range b.outcomes {
+		b.outcomes[i] = outcome{}
+	}
+	b.next, b.filled = 0, 0
+}
+
+func (s *BreakerSet) trip(b *breaker, reason string) {
+	b.openedAt = s.now()
+	b.trips++
+	b.lastReason = reason
+	b.reset()
+	s.transition(b, BreakerOpen, reason)
+}
+
+func (s *BreakerSet) transition(b *breaker, to BreakerState, reason string) {
+	from := b.state
+	b.state = to
+	if to == BreakerOpen {
+		s.notifier.Notify(notify.EventBreakerOpened, "circuit breaker opened",
+			"procedure", b.name,
+			"from", from.String(),
+			"reason", reason,
+			"cooldown", s.config.Cooldown.String(),
+		)
+	}
+	if s.logger == nil {
+		return
+	}
+	if to == BreakerOpen {
+		s.logger.Execution().Warn("circuit breaker opened",
+			"procedure", b.name,
+			"from", from.String(),
+			"reason", reason,
+			"cooldown", s.config.Cooldown,
+		)
+		return
+	}
+	s.logger.Execution().Info("circuit breaker state changed",
+		"procedure", b.name,
+		"from", from.String(),
+		"to", to.String(),
+		"reason", reason,
+	)
+}
+
+func (s *BreakerSet) openError(b *breaker, retryAt time.Time) error {
+	msg := fmt.Sprintf("procedure %s is quarantined by its circuit breaker (%s)", b.name, b.lastReason)
+	if !retryAt.IsZero() {
+		msg += fmt.Sprintf("; retry after %s", retryAt.Format(time.RFC3339))
+	}
+	return aulerrors.New(aulerrors.ErrCodeExecCircuitOpen, msg).
+		WithOp("BreakerSet.Allow").
+		WithField("procedure", b.name).
+		WithField("state", b.state.String()).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(BreakerErrorNumber)).
+		WithField(aulerrors.FieldSQLSeverity, s.config.Severity).
+		Err()
+}
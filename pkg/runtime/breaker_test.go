@@ -0,0 +1,228 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/notify"
+)
+
+// newTestBreakers returns a breaker set with a controllable clock.
+func newTestBreakers(cfg BreakerConfig) (*BreakerSet, *time.Time) {
+	cfg.Enabled = true
+	s := NewBreakerSet(cfg, nil)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+var errBoom = errors.New("boom")
+
+func TestBreakerTripsOnErrorRate(t *testing.T) {
+	s, _ := newTestBreakers(BreakerConfig{Window: 10, MinCalls: 4, ErrorRate: 0.5})
+
+	// Below MinCalls the breaker never trips
+	for i := 0; i < 3; i++ {
+		if err := s.Allow("dbo.Flaky"); err != nil {
+			t.Fatalf("call %d rejected: %v", i, err)
+		}
+		s.Record("dbo.Flaky", time.Millisecond, errBoom)
+	}
+	if err := s.Allow("dbo.Flaky"); err != nil {
+		t.Fatalf("breaker tripped before MinCalls: %v", err)
+	}
+	s.Record("dbo.Flaky", time.Millisecond, errBoom)
+
+	err := s.Allow("dbo.Flaky")
+	if err == nil {
+		t.Fatal("expected breaker to be open")
+	}
+	if !aulerrors.IsCode(err, aulerrors.ErrCodeExecCircuitOpen) {
+		t.Errorf("code = %v, want %v", aulerrors.GetCode(err), aulerrors.ErrCodeExecCircuitOpen)
+	}
+	if !strings.Contains(err.Error(), "dbo.Flaky") || !strings.Contains(err.Error(), "4 of the last 4 calls failed") {
+		t.Errorf("unexpected message: %v", err)
+	}
+
+	// Other procedures are unaffected
+	if err := s.Allow("dbo.Healthy"); err != nil {
+		t.Errorf("unrelated procedure rejected: %v", err)
+	}
+}
+
+func TestBreakerIgnoresOccasionalFailures(t *testing.T) {
+	s, _ := newTestBreakers(BreakerConfig{Window: 10, MinCalls: 5, ErrorRate: 0.5})
+
+	for i := 0; i < 50; i++ {
+		if err := s.Allow("p"); err != nil {
+			t.Fatalf("call %d rejected: %v", i, err)
+		}
+		var err error
+		if i%3 == 0 {
+			err = errBoom
+		}
+		s.Record("p", time.Millisecond, err)
+	}
+}
+
+func TestBreakerTripsOnSlowCalls(t *testing.T) {
+	s, _ := newTestBreakers(BreakerConfig{Window: 4, MinCalls: 4, SlowCall: 100 * time.Millisecond, SlowCallRate: 0.75})
+
+	for i := 0; i < 4; i++ {
+		if err := s.Allow("p"); err != nil {
+			t.Fatalf("call %d rejected: %v", i, err)
+		}
+		s.Record("p", time.Second, nil)
+	}
+	err := s.Allow("p")
+	if err == nil {
+		t.Fatal("expected breaker to be open")
+	}
+	if !strings.Contains(err.Error(), "took longer than 100ms") {
+		t.Errorf("unexpected message: %v", err)
+	}
+}
+
+func TestBreakerHalfOpenRecovery(t *testing.T) {
+	s, now := newTestBreakers(BreakerConfig{Window: 2, MinCalls: 2, Cooldown: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		s.Allow("p")
+		s.Record("p", 0, errBoom)
+	}
+	if s.Allow("p") == nil {
+		t.Fatal("expected breaker to be open")
+	}
+
+	// After the cooldown one trial call is admitted; a second waits
+	*now = now.Add(time.Minute)
+	if err := s.Allow("p"); err != nil {
+		t.Fatalf("trial call rejected: %v", err)
+	}
+	if s.Allow("p") == nil {
+		t.Fatal("second call admitted while trial in flight")
+	}
+	if st := s.Status()[0]; st.State != BreakerHalfOpen {
+		t.Fatalf("state = %v, want HALF_OPEN", st.State)
+	}
+
+	// A failed trial reopens the breaker for another cooldown
+	s.Record("p", 0, errBoom)
+	if st := s.Status()[0]; st.State != BreakerOpen || st.Trips != 2 {
+		t.Fatalf("state = %v trips = %d, want OPEN after 2 trips", st.State, st.Trips)
+	}
+
+	// A successful trial closes it
+	*now = now.Add(time.Minute)
+	if err := s.Allow("p"); err != nil {
+		t.Fatalf("trial call rejected: %v", err)
+	}
+	s.Record("p", 0, nil)
+	if st := s.Status()[0]; st.State != BreakerClosed || st.Calls != 0 {
+		t.Fatalf("state = %v calls = %d, want CLOSED with a fresh window", st.State, st.Calls)
+	}
+	if err := s.Allow("p"); err != nil {
+		t.Errorf("call rejected after recovery: %v", err)
+	}
+}
+
+func TestBreakerCancelledTrialFreesSlot(t *testing.T) {
+	s, now := newTestBreakers(BreakerConfig{Window: 1, MinCalls: 1, Cooldown: time.Second})
+
+	s.Allow("p")
+	s.Record("p", 0, errBoom)
+	*now = now.Add(time.Second)
+
+	if err := s.Allow("p"); err != nil {
+		t.Fatalf("trial call rejected: %v", err)
+	}
+	s.Record("p", 0, context.Canceled)
+	if st := s.Status()[0]; st.State != BreakerHalfOpen {
+		t.Fatalf("state = %v, want HALF_OPEN", st.State)
+	}
+	if err := s.Allow("p"); err != nil {
+		t.Errorf("trial slot not released: %v", err)
+	}
+}
+
+func TestBreakerErrorCarriesSQLFields(t *testing.T) {
+	s, _ := newTestBreakers(BreakerConfig{Window: 1, MinCalls: 1, Severity: 20})
+
+	s.Allow("p")
+	s.Record("p", 0, errBoom)
+	err := s.Allow("p")
+	fields := aulerrors.GetFields(err)
+	if n, _ := fields[aulerrors.FieldSQLErrorNumber].(int32); n != BreakerErrorNumber {
+		t.Errorf("error number = %v, want %d", fields[aulerrors.FieldSQLErrorNumber], BreakerErrorNumber)
+	}
+	if sev, _ := fields[aulerrors.FieldSQLSeverity].(uint8); sev != 20 {
+		t.Errorf("severity = %v, want 20", fields[aulerrors.FieldSQLSeverity])
+	}
+}
+
+func TestBreakerResetAndStatus(t *testing.T) {
+	s, _ := newTestBreakers(BreakerConfig{Window: 1, MinCalls: 1})
+
+	s.Allow("dbo.B")
+	s.Record("dbo.B", 0, nil)
+	s.Allow("dbo.A")
+	s.Record("dbo.A", 0, errBoom)
+	s.Allow("dbo.A")
+
+	statuses := s.Status()
+	if len(statuses) != 2 || statuses[0].Procedure != "dbo.A" || statuses[1].Procedure != "dbo.B" {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+	a := statuses[0]
+	if a.State != BreakerOpen || a.Rejected != 1 || a.RetryAfter.IsZero() || a.LastReason == "" {
+		t.Errorf("unexpected status for open breaker: %+v", a)
+	}
+
+	if !s.Reset("DBO.A") {
+		t.Fatal("Reset did not find breaker")
+	}
+	if err := s.Allow("dbo.A"); err != nil {
+		t.Errorf("call rejected after reset: %v", err)
+	}
+	if s.Reset("dbo.Missing") {
+		t.Error("Reset found a breaker for an unknown procedure")
+	}
+}
+
+func TestBreakerNotifiesWhenOpened(t *testing.T) {
+	events := make(chan notify.Event, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e notify.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer hook.Close()
+	n, err := notify.New(notify.Config{Webhooks: []notify.WebhookConfig{{URL: hook.URL}}}, "aul", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	s, _ := newTestBreakers(BreakerConfig{Window: 2, MinCalls: 2})
+	s.SetNotifier(n)
+	for i := 0; i < 2; i++ {
+		s.Allow("dbo.Flaky")
+		s.Record("dbo.Flaky", 0, errBoom)
+	}
+
+	select {
+	case e := <-events:
+		if e.Type != notify.EventBreakerOpened || e.Fields["procedure"] != "dbo.Flaky" || e.Fields["from"] != "CLOSED" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event delivered")
+	}
+}
@@ -0,0 +1,369 @@
+package runtime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+// Several servers sharing a storage backend form a cluster, whose nodes
+// coordinate through a ClusterLockProvider. Each application lock a node
+// grants is also taken in the provider, so that sp_getapplock excludes
+// sessions of other nodes too, and the node that holds the provider's
+// leader lock is the one that runs scheduled jobs, such as statistics
+// refreshes, so that they do not run once per node.
+//
+// A node's locks are leases, renewed while it runs; those of a node that
+// dies lapse after ClusterConfig.Lease. Deadlocks between sessions of
+// different nodes are not detected: the lock timeout ends them.
+
+// ClusterConfig configures the cluster a server is a node of.
+type ClusterConfig struct {
+	Provider string        // Registered provider name ("" = not clustered)
+	Node     string        // Unique name of this node ("" = host name and process ID)
+	Lease    time.Duration // How long a node's locks outlive its last renewal (0 = DefaultClusterLease)
+}
+
+// DefaultClusterLease is how long the locks of a node that stops
+// renewing them are kept.
+const DefaultClusterLease = 30 * time.Second
+
+// leaderLock is the name of the lock held by the node that runs
+// scheduled jobs.
+const leaderLock = "aul:leader"
+
+// ClusterLock is a lock in a ClusterLockProvider.
+type ClusterLock struct {
+	Name   string // Same on every node for the same lock
+	Node   string // Node holding the lock
+	Holder string // Unique across the cluster: the node, and the grant on the node
+	Mode   string // IS, S, U, IX or X
+}
+
+// ClusterLockProvider holds locks shared by the nodes of a cluster. Locks
+// held by the same node never conflict: the node arbitrates among its
+// own sessions.
+type ClusterLockProvider interface {
+	// TryLock takes lock, or renews it if its holder has it, unless
+	// another node holds the lock in a mode incompatible with lock.Mode.
+	// It reports whether the lock was taken.
+	TryLock(ctx context.Context, lock ClusterLock, lease time.Duration) (bool, error)
+	// Unlock releases lock.
+	Unlock(ctx context.Context, lock ClusterLock) error
+	// Renew extends the leases of the locks node holds.
+	Renew(ctx context.Context, node string, lease time.Duration) error
+	// UnlockNode releases the locks node holds.
+	UnlockNode(ctx context.Context, node string) error
+}
+
+// ClusterLockProviderFunc opens a provider over the storage backend.
+type ClusterLockProviderFunc func(cfg ClusterConfig, storage StorageBackend) (ClusterLockProvider, error)
+
+var (
+	clusterProvidersMu sync.Mutex
+	clusterProviders   = map[string]ClusterLockProviderFunc{
+		"table": func(cfg ClusterConfig, storage StorageBackend) (ClusterLockProvider, error) {
+			return NewTableLockProvider(context.Background(), storage.GetDB())
+		},
+	}
+)
+
+// RegisterClusterLockProvider makes a provider available by name, for
+// builds of the server that link in a provider of their own, over Redis
+// or etcd for instance.
+func RegisterClusterLockProvider(name string, open ClusterLockProviderFunc) {
+	clusterProvidersMu.Lock()
+	defer clusterProvidersMu.Unlock()
+	clusterProviders[name] = open
+}
+
+// ClusterLockProviders returns the names of the registered providers.
+func ClusterLockProviders() []string {
+	clusterProvidersMu.Lock()
+	defer clusterProvidersMu.Unlock()
+	names := make([]string, 0, len(clusterProviders))
+	for name := range clusterProviders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Cluster is this node's view of the cluster.
+type Cluster struct {
+	provider ClusterLockProvider
+	node     string
+	lease    time.Duration
+	logger   *log.Logger
+
+	grants  atomic.Int64 // Application lock grants, to name their holders
+	leading atomic.Bool
+}
+
+// OpenCluster joins the cluster cfg describes, through the provider it
+// names, over storage.
+func OpenCluster(cfg ClusterConfig, storage StorageBackend, logger *log.Logger) (*Cluster, error) {
+	clusterProvidersMu.Lock()
+	open := clusterProviders[cfg.Provider]
+	clusterProvidersMu.Unlock()
+	if open == nil {
+		return nil, aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+			"unknown cluster lock provider %q", cfg.Provider).
+			WithOp("OpenCluster").
+			WithField("providers", ClusterLockProviders()).
+			Err()
+	}
+	provider, err := open(cfg, storage)
+	if err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid,
+			"failed to open cluster lock provider").
+			WithOp("OpenCluster").
+			WithField("provider", cfg.Provider).
+			Err()
+	}
+	return NewCluster(provider, cfg, logger), nil
+}
+
+// NewCluster joins a cluster through provider.
+func NewCluster(provider ClusterLockProvider, cfg ClusterConfig, logger *log.Logger) *Cluster {
+	if cfg.Node == "" {
+		host, _ := os.Hostname()
+		cfg.Node = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = DefaultClusterLease
+	}
+	return &Cluster{provider: provider, node: cfg.Node, lease: cfg.Lease, logger: logger}
+}
+
+// Node returns the name of this node.
+func (c *Cluster) Node() string {
+	return c.node
+}
+
+// Lease returns how long the node's locks outlive their last renewal.
+func (c *Cluster) Lease() time.Duration {
+	return c.lease
+}
+
+// Lead reports whether this node is the leader, which runs scheduled
+// jobs, becoming it if there is none. A node outside a cluster always
+// leads.
+func (c *Cluster) Lead(ctx context.Context) bool {
+	if c == nil {
+		return true
+	}
+	ok, err := c.provider.TryLock(ctx, ClusterLock{Name: leaderLock, Node: c.node, Holder: c.node, Mode: "X"}, c.lease)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.logger.System().Error("cluster leader election failed", err, "node", c.node)
+		}
+		ok = false
+	}
+	if c.leading.Swap(ok) != ok {
+		if ok {
+			c.logger.System().Info("node became cluster leader", "node", c.node)
+		} else {
+			c.logger.System().Info("node is no longer cluster leader", "node", c.node)
+		}
+	}
+	return ok
+}
+
+// Renew extends the leases of the node's locks.
+func (c *Cluster) Renew(ctx context.Context) error {
+	return c.provider.Renew(ctx, c.node, c.lease)
+}
+
+// Run renews the node's leases, a third of the way through each, until
+// ctx ends.
+func (c *Cluster) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.Renew(ctx); err != nil && ctx.Err() == nil {
+			c.logger.System().Error("failed to renew cluster locks", err, "node", c.node)
+		}
+	}
+}
+
+// Close releases the node's locks, as it leaves the cluster.
+func (c *Cluster) Close() error {
+	if c == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.leading.Store(false)
+	return c.provider.UnlockNode(ctx, c.node)
+}
+
+// appLock returns the cluster lock of a grant of l in mode.
+func (c *Cluster) appLock(l *appLock, mode appLockMode) ClusterLock {
+	return ClusterLock{
+		Name:   fmt.Sprintf("applock:%s:%s", l.database, l.String()),
+		Node:   c.node,
+		Holder: c.node + "/" + strconv.FormatInt(c.grants.Add(1), 10),
+		Mode:   mode.String(),
+	}
+}
+
+// lock takes lock, trying again with growing pauses until it is taken,
+// deadline passes or ctx ends. A zero deadline means no limit. It reports
+// whether the lock had to be waited for.
+func (c *Cluster) lock(ctx context.Context, lock ClusterLock, deadline time.Time) (waited bool, err error) {
+	pause := 10 * time.Millisecond
+	for {
+		ok, err := c.provider.TryLock(ctx, lock, c.lease)
+		if err != nil || ok {
+			return waited, err
+		}
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return waited, errClusterTimeout
+			}
+			pause = min(pause, left)
+		}
+		waited = true
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		case <-timer.C:
+		}
+		pause = min(2*pause, time.Second)
+	}
+}
+
+// errClusterTimeout is returned by Cluster.lock when the deadline passes.
+var errClusterTimeout = fmt.Errorf("cluster lock not granted in time")
+
+// unlock releases lock in the background, so that a node's lock manager
+// need not wait for the provider while it holds its own mutex.
+func (c *Cluster) unlock(lock ClusterLock) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.lease)
+		defer cancel()
+		if err := c.provider.Unlock(ctx, lock); err != nil {
+			c.logger.System().Error("failed to release cluster lock", err,
+				"lock", lock.Name,
+				"holder", lock.Holder,
+			)
+		}
+	}()
+}
+
+// ClusterLockTable is the table the table provider keeps locks in. It is
+// one of tsqlruntime.InternalTables, left out of the catalog views.
+const ClusterLockTable = "aul_cluster_locks"
+
+// tableLockProvider keeps the cluster's locks in a table of the storage
+// backend the nodes share.
+type tableLockProvider struct {
+	db *sql.DB
+}
+
+// NewTableLockProvider returns a provider keeping locks in
+// ClusterLockTable of db, creating the table if it is missing.
+func NewTableLockProvider(ctx context.Context, db *sql.DB) (ClusterLockProvider, error) {
+	if db == nil {
+		return nil, fmt.Errorf("the storage backend has no database to keep cluster locks in")
+	}
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+ClusterLockTable+
+		" (name VARCHAR(600) NOT NULL, holder VARCHAR(300) NOT NULL, node VARCHAR(255) NOT NULL,"+
+		" mode VARCHAR(2) NOT NULL, expires_at BIGINT NOT NULL, PRIMARY KEY (name, holder))")
+	if err != nil {
+		return nil, fmt.Errorf("cluster locks unavailable: %w", err)
+	}
+	return &tableLockProvider{db: db}, nil
+}
+
+// TryLock implements ClusterLockProvider. Its first statement is a
+// write, so that attempts on the same backend run one at a time.
+func (p *tableLockProvider) TryLock(ctx context.Context, lock ClusterLock, lease time.Duration) (bool, error) {
+	now := time.Now()
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+ClusterLockTable+" WHERE name = ? AND expires_at < ?",
+		lock.Name, now.UnixMilli()); err != nil {
+		return false, err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT node, mode FROM "+ClusterLockTable+" WHERE name = ?", lock.Name)
+	if err != nil {
+		return false, err
+	}
+	compatible := true
+	for rows.Next() {
+		var node, mode string
+		if err := rows.Scan(&node, &mode); err != nil {
+			rows.Close()
+			return false, err
+		}
+		if node != lock.Node && !clusterModesCompatible(lock.Mode, mode) {
+			compatible = false
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || !compatible {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+ClusterLockTable+" WHERE name = ? AND holder = ?",
+		lock.Name, lock.Holder); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO "+ClusterLockTable+
+		" (name, holder, node, mode, expires_at) VALUES (?, ?, ?, ?, ?)",
+		lock.Name, lock.Holder, lock.Node, lock.Mode, now.Add(lease).UnixMilli()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Unlock implements ClusterLockProvider.
+func (p *tableLockProvider) Unlock(ctx context.Context, lock ClusterLock) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM "+ClusterLockTable+" WHERE name = ? AND holder = ?",
+		lock.Name, lock.Holder)
+	return err
+}
+
+// Renew implements ClusterLockProvider.
+func (p *tableLockProvider) Renew(ctx context.Context, node string, lease time.Duration) error {
+	_, err := p.db.ExecContext(ctx, "UPDATE "+ClusterLockTable+" SET expires_at = ? WHERE node = ?",
+		time.Now().Add(lease).UnixMilli(), node)
+	return err
+}
+
+// UnlockNode implements ClusterLockProvider.
+func (p *tableLockProvider) UnlockNode(ctx context.Context, node string) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM "+ClusterLockTable+" WHERE node = ?", node)
+	return err
+}
+
+// clusterModesCompatible reports whether a lock in mode a may be taken
+// while another node holds it in mode b.
+func clusterModesCompatible(a, b string) bool {
+	modes := []string{"IS", "S", "U", "IX", "X"}
+	i, j := slices.Index(modes, a), slices.Index(modes, b)
+	return i >= 0 && j >= 0 && appLockCompatible[i][j]
+}
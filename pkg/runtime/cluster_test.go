@@ -0,0 +1,111 @@
+package runtime
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// clusterNodes returns the lock managers of two nodes sharing a table
+// lock provider, with the given lease.
+func clusterNodes(t *testing.T, lease time.Duration) (a, b *LockManager) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "cluster.db")+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	provider, err := NewTableLockProvider(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(log.Config{DefaultLevel: log.LevelError})
+	node := func(name string) *LockManager {
+		m := NewLockManager(logger)
+		m.SetCluster(NewCluster(provider, ClusterConfig{Node: name, Lease: lease}, logger))
+		return m
+	}
+	return node("n1"), node("n2")
+}
+
+func TestCluster_Lead(t *testing.T) {
+	ctx := context.Background()
+	a, b := clusterNodes(t, time.Minute)
+	if !a.cluster.Lead(ctx) || !a.cluster.Lead(ctx) {
+		t.Fatal("first node does not lead")
+	}
+	if b.cluster.Lead(ctx) {
+		t.Fatal("two leaders")
+	}
+	if err := a.cluster.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.cluster.Lead(ctx) || a.cluster.Lead(ctx) {
+		t.Fatal("leadership not handed over")
+	}
+	var outside *Cluster
+	if !outside.Lead(ctx) {
+		t.Error("a server outside a cluster does not lead")
+	}
+}
+
+func TestCluster_AppLock(t *testing.T) {
+	ctx := context.Background()
+	a, b := clusterNodes(t, time.Minute)
+	oa := a.Owner("s1", "db", "")
+	ob := b.Owner("s1", "db", "") // Session IDs are only unique on their node
+
+	if rc, err := oa.GetAppLock(ctx, namedLock("job", "Shared"), 0); rc != tsqlruntime.AppLockGranted || err != nil {
+		t.Fatalf("S: rc %d, %v", rc, err)
+	}
+	if rc, err := ob.GetAppLock(ctx, namedLock("job", "IntentShared"), 0); rc != tsqlruntime.AppLockGranted || err != nil {
+		t.Fatalf("IS on the other node: rc %d, %v", rc, err)
+	}
+	if rc, err := ob.GetAppLock(ctx, namedLock("job", "Exclusive"), 20*time.Millisecond); rc != tsqlruntime.AppLockTimeout || err != nil {
+		t.Fatalf("X on the other node: rc %d, %v", rc, err)
+	}
+	// The node took its own grant back
+	if grants := b.apps["db\x00public\x00job"].grantsOf(ob); len(grants) != 1 || grants[0].mode != appLockIntentShared {
+		t.Fatalf("grants left: %+v", grants)
+	}
+
+	done := make(chan int, 1)
+	go func() {
+		rc, _ := ob.GetAppLock(ctx, namedLock("job", "Exclusive"), 5*time.Second)
+		done <- rc
+	}()
+	time.Sleep(30 * time.Millisecond)
+	oa.ReleaseAll()
+	if rc := <-done; rc != tsqlruntime.AppLockGrantedAfterWait {
+		t.Fatalf("X after the other node released: rc %d", rc)
+	}
+	ob.ReleaseAll()
+}
+
+func TestCluster_LeaseLapses(t *testing.T) {
+	ctx := context.Background()
+	a, b := clusterNodes(t, 50*time.Millisecond)
+	// a stops renewing its locks, as a node that has died would
+	if rc, _ := a.Owner("s1", "db", "").GetAppLock(ctx, namedLock("job", "Exclusive"), 0); rc != tsqlruntime.AppLockGranted {
+		t.Fatalf("rc %d", rc)
+	}
+	rc, err := b.Owner("s1", "db", "").GetAppLock(ctx, namedLock("job", "Exclusive"), 5*time.Second)
+	if rc != tsqlruntime.AppLockGrantedAfterWait || err != nil {
+		t.Fatalf("rc %d, %v", rc, err)
+	}
+}
+
+// TestClusterLockTableInternal checks the lock table is left out of the
+// catalog views and statistics.
+func TestClusterLockTableInternal(t *testing.T) {
+	if !tsqlruntime.IsInternalTable(ClusterLockTable) {
+		t.Errorf("%s is not in tsqlruntime.InternalTables", ClusterLockTable)
+	}
+}
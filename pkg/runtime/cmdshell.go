@@ -0,0 +1,39 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ha1tch/aul/pkg/cmdshell"
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// commandShell runs xp_cmdshell's commands with a Shell, auditing them
+// under the user whose execution sent them. Unless the user is an
+// administrator, or holds EXECUTE on xp_cmdshell, no command runs, even
+// from a procedure the user may execute: ownership chaining does not
+// reach the operating system.
+type commandShell struct {
+	shell       *cmdshell.Shell
+	user        string
+	permissions tsqlruntime.Permissions // nil when no logins are kept
+}
+
+// cmdShellObject is the securable EXECUTE on xp_cmdshell is granted on.
+const cmdShellObject = "sys.xp_cmdshell"
+
+func (s commandShell) RunCommand(ctx context.Context, command string) (tsqlruntime.CommandResult, error) {
+	if p := s.permissions; p != nil && !p.Administrator() && !p.Allowed("EXECUTE", cmdShellObject) {
+		return tsqlruntime.CommandResult{}, tsqlruntime.PermissionDenied("EXECUTE", cmdShellObject, "master")
+	}
+	res, err := s.shell.Run(ctx, s.user, command)
+	var denied *aulerrors.Error
+	if errors.As(err, &denied) && denied.Code == aulerrors.ErrCodeExecDenied {
+		return tsqlruntime.CommandResult{}, tsqlruntime.NewSQLError(tsqlruntime.ErrCommandDenied, denied.Message)
+	}
+	if err != nil {
+		return tsqlruntime.CommandResult{}, err
+	}
+	return tsqlruntime.CommandResult{Output: res.Output, ExitCode: res.ExitCode}, nil
+}
@@ -0,0 +1,123 @@
+package runtime_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/cmdshell"
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	pkglog "github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/storage"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// echoShell returns a shell allowing echo of one word.
+func echoShell(t *testing.T, logger *pkglog.Logger) *cmdshell.Shell {
+	t.Helper()
+	echo, err := exec.LookPath("echo")
+	if err != nil {
+		t.Skip(err)
+	}
+	shell, err := cmdshell.New(cmdshell.Config{Commands: []cmdshell.CommandConfig{{
+		Name:     "echo",
+		Template: "echo {word}",
+		Program:  echo,
+		Args:     []string{"{word}"},
+		Params:   map[string]string{"word": `\w+`},
+	}}}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return shell
+}
+
+func TestCommandShell(t *testing.T) {
+	logger := pkglog.New(pkglog.Config{DefaultLevel: pkglog.LevelError})
+	shell := echoShell(t, logger)
+	ctx := context.Background()
+	backend, err := storage.NewSQLiteStorage(storage.DefaultSQLiteConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	rt.SetStorage(backend)
+	execCtx := &runtime.ExecContext{SessionID: "etl", User: "etl"}
+
+	// Turned off until a shell is set
+	_, err = rt.ExecuteSQL(ctx, "EXEC xp_cmdshell 'echo hello'", execCtx)
+	var off *tsqlruntime.SQLError
+	if !errors.As(err, &off) || off.Number != tsqlruntime.ErrCmdShellDisabled {
+		t.Errorf("without a shell: got %v, want error 15281", err)
+	}
+
+	rt.SetCommandShell(shell)
+	result, err := rt.ExecuteSQL(ctx, "EXEC xp_cmdshell 'echo hello'", execCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.ResultSets) != 1 || len(result.ResultSets[0].Rows) != 2 || result.ResultSets[0].Rows[0][0] != "hello" {
+		t.Errorf("result sets: %+v", result.ResultSets)
+	}
+
+	_, err = rt.ExecuteSQL(ctx, "EXEC xp_cmdshell 'echo hello; rm -rf /'", execCtx)
+	sqlErr := aulerrors.FindSQLError(err)
+	if sqlErr == nil || sqlErr.Code != aulerrors.ErrCodeExecDenied || sqlErr.Fields[aulerrors.FieldSQLErrorNumber] != int32(50415) {
+		t.Errorf("denied: got %v, want error 50415", err)
+	}
+}
+
+func TestCommandShellPermissions(t *testing.T) {
+	ctx := context.Background()
+	rt, _ := internalTablesRuntime(t, &procedure.Procedure{
+		Name:   "Archive",
+		Schema: "dbo",
+		Source: "CREATE PROCEDURE dbo.Archive AS EXEC xp_cmdshell 'echo archived'",
+	})
+	rt.SetCommandShell(echoShell(t, pkglog.New(pkglog.Config{DefaultLevel: pkglog.LevelError})))
+	app := &runtime.ExecContext{SessionID: "app", User: "app", EnforcePermissions: true}
+	admin := &runtime.ExecContext{SessionID: "sa", User: "sa", EnforcePermissions: true}
+
+	// A login with no grant runs no command, directly, in dynamic SQL or
+	// from a procedure it may execute
+	if _, err := rt.ExecuteSQL(ctx, "GRANT EXECUTE ON dbo.Archive TO app", admin); err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		"EXEC xp_cmdshell 'echo pwned'",
+		"EXEC master..xp_cmdshell 'echo pwned', no_output",
+		"EXEC ('EXEC xp_cmdshell ''echo pwned''')",
+		"EXEC dbo.Archive",
+	} {
+		result, err := rt.ExecuteSQL(ctx, sql, app)
+		wantError229(t, sql, err)
+		if result != nil && len(result.ResultSets) > 0 {
+			t.Errorf("%s: returned %+v", sql, result.ResultSets)
+		}
+	}
+
+	// An administrator runs commands, as does a login granted EXECUTE
+	if _, err := rt.ExecuteSQL(ctx, "EXEC xp_cmdshell 'echo hello'", admin); err != nil {
+		t.Errorf("as sa: %v", err)
+	}
+	if _, err := rt.ExecuteSQL(ctx, "GRANT EXECUTE ON sys.xp_cmdshell TO app", admin); err != nil {
+		t.Fatal(err)
+	}
+	result, err := rt.ExecuteSQL(ctx, "EXEC xp_cmdshell 'echo hello'", app)
+	if err != nil {
+		t.Fatalf("granted EXECUTE: %v", err)
+	}
+	if len(result.ResultSets) != 1 || result.ResultSets[0].Rows[0][0] != "hello" {
+		t.Errorf("result sets: %+v", result.ResultSets)
+	}
+	if _, err := rt.ExecuteSQL(ctx, "EXEC dbo.Archive", app); err != nil {
+		t.Errorf("dbo.Archive with EXECUTE granted: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+// ConcurrencyErrorNumber is the SQL error number reported when a call of
+// a procedure at its -- @aul:max-concurrent limit is refused.
+const ConcurrencyErrorNumber = 50412
+
+// ConcurrencySQLState is the PostgreSQL SQLSTATE for such a call
+// (lock_not_available).
+const ConcurrencySQLState = "55P03"
+
+// ProcedureLimits holds the running calls of each procedure with a
+// concurrency limit. Calls from other procedures count like calls from
+// clients, so a singleton that calls itself fails, or waits for itself
+// until the queue timeout.
+type ProcedureLimits struct {
+	timeout time.Duration // Longest wait of a queued call
+
+	mu    sync.Mutex
+	procs map[string]*procedureSlots // By tenant and lower-cased qualified name
+}
+
+type procedureSlots struct {
+	running int
+	waiters *list.List // Of chan struct{}, closed when the call may run
+}
+
+// NewProcedureLimits creates the limits of procedures whose queued calls
+// wait at most timeout.
+func NewProcedureLimits(timeout time.Duration) *ProcedureLimits {
+	return &ProcedureLimits{timeout: timeout, procs: make(map[string]*procedureSlots)}
+}
+
+// Acquire takes a slot to run proc, waiting for one if proc is at its
+// limit and queues its calls. The returned release function must be
+// called once the call finishes.
+func (l *ProcedureLimits) Acquire(ctx context.Context, proc *procedure.Procedure) (release func(), err error) {
+	if proc.MaxConcurrent <= 0 {
+		return func() {}, nil
+	}
+	key := proc.Tenant + "\x00" + strings.ToLower(proc.QualifiedName())
+
+	l.mu.Lock()
+	slots := l.procs[key]
+	if slots == nil {
+		slots = &procedureSlots{waiters: list.New()}
+		l.procs[key] = slots
+	}
+	release = func() { l.release(key) }
+	if slots.running < proc.MaxConcurrent && slots.waiters.Len() == 0 {
+		slots.running++
+		l.mu.Unlock()
+		return release, nil
+	}
+	if !proc.QueueWhenBusy {
+		l.mu.Unlock()
+		return nil, l.busyError(proc, "")
+	}
+	ready := make(chan struct{})
+	elem := slots.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return release, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = l.busyError(proc, fmt.Sprintf(" and the call waited %v", l.timeout))
+	}
+
+	l.mu.Lock()
+	select {
+	case <-ready:
+		// The slot arrived as we gave up; pass it on
+		l.mu.Unlock()
+		release()
+	default:
+		slots.waiters.Remove(elem)
+		l.mu.Unlock()
+	}
+	return nil, err
+}
+
+// release frees a slot of the procedure key names, handing it straight
+// to the next waiter.
+func (l *ProcedureLimits) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots := l.procs[key]
+	if front := slots.waiters.Front(); front != nil {
+		close(slots.waiters.Remove(front).(chan struct{}))
+		return
+	}
+	slots.running--
+	if slots.running == 0 {
+		delete(l.procs, key)
+	}
+}
+
+func (l *ProcedureLimits) busyError(proc *procedure.Procedure, reason string) error {
+	return aulerrors.Newf(aulerrors.ErrCodeExecConcurrency,
+		"Procedure %s has reached its limit of %d concurrent call(s)%s. Retry the request later.",
+		proc.QualifiedName(), proc.MaxConcurrent, reason).
+		WithOp("ProcedureLimits.Acquire").
+		WithField("procedure", proc.QualifiedName()).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(ConcurrencyErrorNumber)).
+		WithField(aulerrors.FieldSQLSeverity, uint8(16)).
+		WithField(aulerrors.FieldSQLState, ConcurrencySQLState).
+		Err()
+}
@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+// waitForQueued polls until n calls of proc wait for a slot.
+func waitForQueued(t *testing.T, l *ProcedureLimits, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		waiting := 0
+		for _, slots := range l.procs {
+			waiting += slots.waiters.Len()
+		}
+		l.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("never reached %d queued calls", n)
+}
+
+func TestProcedureLimits(t *testing.T) {
+	l := NewProcedureLimits(time.Second)
+	single := &procedure.Procedure{Schema: "dbo", Name: "Nightly", MaxConcurrent: 1}
+
+	release, err := l.Acquire(context.Background(), single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(context.Background(), single); aulerrors.GetCode(err) != aulerrors.ErrCodeExecConcurrency {
+		t.Fatalf("second call: %v", err)
+	}
+	// Other tenants' versions and unlimited procedures are not held up
+	other := &procedure.Procedure{Schema: "dbo", Name: "Nightly", Tenant: "acme", MaxConcurrent: 1}
+	if r, err := l.Acquire(context.Background(), other); err != nil {
+		t.Errorf("other tenant: %v", err)
+	} else {
+		r()
+	}
+	if _, err := l.Acquire(context.Background(), &procedure.Procedure{Schema: "dbo", Name: "Free"}); err != nil {
+		t.Errorf("unlimited: %v", err)
+	}
+	release()
+	release, err = l.Acquire(context.Background(), single)
+	if err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+
+	// Queued calls run in turn
+	queued := &procedure.Procedure{Schema: "dbo", Name: "nightly", MaxConcurrent: 1, QueueWhenBusy: true}
+	done := make(chan error, 1)
+	go func() {
+		r, err := l.Acquire(context.Background(), queued)
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+	waitForQueued(t, l, 1)
+	release()
+	if err := <-done; err != nil {
+		t.Fatalf("queued call: %v", err)
+	}
+
+	// ... until the queue timeout, or the caller gives up
+	release, _ = l.Acquire(context.Background(), single)
+	defer release()
+	l.timeout = 10 * time.Millisecond
+	if _, err := l.Acquire(context.Background(), queued); aulerrors.GetCode(err) != aulerrors.ErrCodeExecConcurrency {
+		t.Errorf("timed out call: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx, queued); err != context.Canceled {
+		t.Errorf("cancelled call: %v", err)
+	}
+	waitForQueued(t, l, 0)
+}
@@ -0,0 +1,175 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+// ContractMode says what happens when a procedure's results break the
+// result contract it declares (see procedure.ParseContract).
+type ContractMode string
+
+const (
+	ContractsOff     ContractMode = "off"     // Results are not checked
+	ContractsWarn    ContractMode = "warn"    // Breaches are logged and the results returned
+	ContractsEnforce ContractMode = "enforce" // Breaches fail the execution
+)
+
+// ParseContractMode parses off, warn or enforce.
+func ParseContractMode(s string) (ContractMode, error) {
+	switch m := ContractMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case ContractsOff, ContractsWarn, ContractsEnforce:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown result contract mode %q (want off, warn or enforce)", s)
+}
+
+// ContractErrorNumber is the SQL error number reported when an execution
+// fails for breaking its procedure's result contract.
+const ContractErrorNumber = 50411
+
+// ContractViolations describes how result sets differ from a procedure's
+// contract. The runtime does not know the SQL types of result columns, so
+// types are checked against the values: a column declared int must hold
+// integers, a varchar column strings, a NOT NULL column no NULLs.
+func ContractViolations(contract []procedure.ResultSetDef, sets []ResultSet) []string {
+	actual := make([]procedure.ResultSetDef, len(sets))
+	for i, rs := range sets {
+		actual[i].Index = i
+		for _, c := range rs.Columns {
+			actual[i].Columns = append(actual[i].Columns, procedure.ColumnDef{Name: c.Name, Ordinal: c.Ordinal})
+		}
+	}
+	problems := procedure.CheckContract(contract, actual)
+
+	for i, rs := range sets {
+		if i >= len(contract) {
+			break
+		}
+		for j, want := range contract[i].Columns {
+			if j >= len(rs.Columns) {
+				break
+			}
+			var sawNull, sawWrong bool
+			for _, row := range rs.Rows {
+				if j >= len(row) {
+					continue
+				}
+				v := row[j]
+				if v == nil {
+					if !want.Nullable && !sawNull {
+						sawNull = true
+						problems = append(problems, fmt.Sprintf("result set %d column %s: NULL, the contract says NOT NULL", i+1, want.Name))
+					}
+					continue
+				}
+				if !sawWrong && !valueFits(want.SQLType, v) {
+					sawWrong = true
+					problems = append(problems, fmt.Sprintf("result set %d column %s: %T value %v, the contract says %s", i+1, want.Name, v, v, want.SQLType))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// valueFits reports whether a non-NULL value can be of a SQL type. It
+// accepts what storage returns for the type as well as what the
+// interpreter computes: decimals may come as strings, bits as 0 and 1,
+// dates as strings.
+func valueFits(sqlType string, v interface{}) bool {
+	t := sqlType
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = t[:i]
+	}
+	switch strings.ToLower(strings.Trim(strings.TrimSpace(t), "[]")) {
+	case "":
+		return true
+	case "bit":
+		switch x := v.(type) {
+		case bool:
+			return true
+		case int64:
+			return x == 0 || x == 1
+		case int:
+			return x == 0 || x == 1
+		}
+		return false
+	case "tinyint", "smallint", "int", "integer", "bigint":
+		switch x := v.(type) {
+		case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+			return true
+		case float64:
+			return x == float64(int64(x))
+		}
+		return false
+	case "float", "real":
+		switch v.(type) {
+		case float64, float32, int, int32, int64:
+			return true
+		}
+		return false
+	case "decimal", "numeric", "money", "smallmoney":
+		switch x := v.(type) {
+		case float64, float32, int, int32, int64:
+			return true
+		case string:
+			_, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+			return err == nil
+		}
+		return false
+	case "char", "varchar", "nchar", "nvarchar", "text", "ntext", "xml", "sysname", "uniqueidentifier":
+		switch v.(type) {
+		case string, []byte:
+			return true
+		}
+		return false
+	case "date", "time", "datetime", "datetime2", "smalldatetime", "datetimeoffset":
+		switch v.(type) {
+		case time.Time, string:
+			return true
+		}
+		return false
+	case "binary", "varbinary", "image":
+		switch v.(type) {
+		case []byte, string:
+			return true
+		}
+		return false
+	}
+	return true // Types the runtime cannot tell apart by value
+}
+
+// checkContract applies the configured ContractMode to a procedure's
+// results. Procedures without a contract are not checked.
+func (r *Runtime) checkContract(ctx context.Context, proc *procedure.Procedure, result *ExecResult) error {
+	if len(proc.Contract) == 0 || r.config.Contracts == "" || r.config.Contracts == ContractsOff {
+		return nil
+	}
+	problems := ContractViolations(proc.Contract, result.ResultSets)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	name := proc.QualifiedName()
+	if r.config.Contracts == ContractsWarn {
+		r.logger.Execution().WithContext(ctx).Warn("procedure results break their contract",
+			"procedure", name,
+			"problems", strings.Join(problems, "; "),
+		)
+		return nil
+	}
+	return aulerrors.Newf(aulerrors.ErrCodeExecContractViolation,
+		"procedure %s broke its result contract: %s", name, strings.Join(problems, "; ")).
+		WithOp("Runtime.Execute").
+		WithField("procedure", name).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(ContractErrorNumber)).
+		WithField(aulerrors.FieldSQLSeverity, uint8(16)).
+		Err()
+}
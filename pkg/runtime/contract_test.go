@@ -0,0 +1,65 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+func TestContractViolations(t *testing.T) {
+	contract := []procedure.ResultSetDef{{Columns: []procedure.ColumnDef{
+		{Name: "ID", SQLType: "int"},
+		{Name: "Total", SQLType: "decimal(10,2)", Nullable: true},
+		{Name: "Active", SQLType: "bit"},
+		{Name: "PlacedAt", SQLType: "datetime"},
+		{Name: "Note", SQLType: "varchar(50)", Nullable: true},
+	}}}
+	columns := []ColumnInfo{{Name: "ID"}, {Name: "Total"}, {Name: "Active"}, {Name: "PlacedAt"}, {Name: "Note"}}
+
+	// Values as storage and the interpreter produce them
+	ok := []ResultSet{{Columns: columns, Rows: [][]interface{}{
+		{int64(1), "9.50", int64(1), "2025-01-01 10:00:00", nil},
+		{int64(2), 3.5, true, time.Now(), "x"},
+		{float64(3), nil, false, time.Now(), []byte("y")},
+	}}}
+	if problems := ContractViolations(contract, ok); len(problems) != 0 {
+		t.Errorf("conforming results: %v", problems)
+	}
+
+	bad := []ResultSet{{Columns: columns, Rows: [][]interface{}{
+		{"A1", "lots", int64(2), int64(5), int64(7)},
+		{nil, nil, true, time.Now(), "x"},
+	}}}
+	got := strings.Join(ContractViolations(contract, bad), "\n")
+	for _, want := range []string{
+		"column ID: string value A1",
+		"column Total: string value lots",
+		"column Active: int64 value 2",
+		"column PlacedAt: int64 value 5",
+		"column Note: int64 value 7",
+		"column ID: NULL, the contract says NOT NULL",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("problems missing %q:\n%s", want, got)
+		}
+	}
+
+	renamed := []ResultSet{{Columns: []ColumnInfo{{Name: "OrderID"}}}}
+	got = strings.Join(ContractViolations(contract, renamed), "\n")
+	if !strings.Contains(got, "named OrderID") || !strings.Contains(got, "1 columns") {
+		t.Errorf("renamed and dropped columns: %s", got)
+	}
+}
+
+func TestParseContractMode(t *testing.T) {
+	for in, want := range map[string]ContractMode{"off": ContractsOff, "Warn": ContractsWarn, " enforce ": ContractsEnforce} {
+		if got, err := ParseContractMode(in); err != nil || got != want {
+			t.Errorf("ParseContractMode(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseContractMode("strict"); err == nil {
+		t.Error("ParseContractMode(strict) succeeded")
+	}
+}
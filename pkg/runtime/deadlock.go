@@ -0,0 +1,468 @@
+package runtime
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/notify"
+)
+
+// DeadlockErrorNumber is the SQL error number reported to the victim of a
+// deadlock, as SQL Server reports it.
+const DeadlockErrorNumber = 1205
+
+// DeadlockSQLState is the PostgreSQL SQLSTATE for a deadlock victim
+// (deadlock_detected).
+const DeadlockSQLState = "40P01"
+
+const (
+	maxDeadlockReports = 100 // Deadlocks kept for sys.dm_aul_deadlocks
+	maxInputBuf        = 200 // Runes of batch text kept in a deadlock graph
+)
+
+// LockManager holds the table locks of the transactions of all sessions,
+// and their application locks (see applock.go). It implements the
+// waits-for graph of SQL Server's lock monitor: each session waits for at
+// most one lock, held by other sessions, so a deadlock is a chain of
+// waits that leads back to where it started. The chain is followed
+// whenever a transaction starts to wait, which finds every deadlock the
+// moment it forms.
+//
+// Of the transactions in a deadlock, the victim is the one with the
+// lowest DEADLOCK_PRIORITY, then the one holding fewest locks and so the
+// cheapest to roll back, then the youngest. Its wait fails with error
+// 1205 and the deadlock is recorded, with its graph as XML, in the log and
+// in sys.dm_aul_deadlocks.
+//
+// Locks are only taken in front of the backend, which has locks of its
+// own: a wait inside the backend is not in the graph, and is bounded by
+// the backend's busy timeout instead.
+type LockManager struct {
+	logger   *log.Logger
+	notifier *notify.Notifier // Told of each deadlock (nil = nobody); guarded by mu
+
+	mu        sync.Mutex
+	tables    map[string]*tableLock
+	apps      map[string]*appLock   // By database, principal and resource
+	sessions  map[string]*LockOwner // Owners of sessions' application locks
+	waiters   map[string]*LockOwner // The owner each waiting session waits as
+	cluster   *Cluster              // Takes application locks across nodes; nil outside a cluster
+	txns      int64                 // Transactions that have taken a lock, to order them by age
+	deadlocks []DeadlockReport
+	detected  int64
+}
+
+type tableLock struct {
+	database string
+	table    string
+	holder   *LockOwner
+	waiters  []*LockOwner // In arrival order
+}
+
+// DeadlockReport describes a deadlock the lock manager broke.
+type DeadlockReport struct {
+	ID              int64
+	DetectedAt      time.Time
+	VictimSessionID string
+	Processes       int
+	Graph           string // The deadlock graph as XML
+}
+
+// NewLockManager creates a lock manager.
+func NewLockManager(logger *log.Logger) *LockManager {
+	return &LockManager{
+		logger:   logger,
+		tables:   make(map[string]*tableLock),
+		apps:     make(map[string]*appLock),
+		sessions: make(map[string]*LockOwner),
+		waiters:  make(map[string]*LockOwner),
+	}
+}
+
+// Owner returns the lock owner of an execution in sessionID against
+// database. inputBuf, the procedure name or batch text, identifies the
+// execution in deadlock graphs. The owner must be released once the
+// execution finishes.
+func (m *LockManager) Owner(sessionID, database, inputBuf string) *LockOwner {
+	if r := []rune(inputBuf); len(r) > maxInputBuf {
+		inputBuf = string(r[:maxInputBuf])
+	}
+	return &LockOwner{manager: m, sessionID: sessionID, database: database, inputBuf: inputBuf}
+}
+
+// SetNotifier sets the notifier told of each deadlock.
+func (m *LockManager) SetNotifier(n *notify.Notifier) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifier = n
+}
+
+// SetCluster makes the manager take the application locks it grants from
+// the cluster too.
+func (m *LockManager) SetCluster(c *Cluster) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cluster = c
+}
+
+// Deadlocks returns the most recent deadlocks, oldest first.
+func (m *LockManager) Deadlocks() []DeadlockReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.deadlocks)
+}
+
+// DeadlockCount returns the number of deadlocks broken since startup.
+func (m *LockManager) DeadlockCount() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.detected
+}
+
+// LockOwner holds the table locks of one execution's transaction, and its
+// application locks, or the application locks of a session. It implements
+// tsqlruntime.TableLocker and tsqlruntime.AppLocker.
+type LockOwner struct {
+	manager   *LockManager
+	sessionID string
+	database  string
+	inputBuf  string
+	session   bool // Owns the session's application locks
+
+	// Guarded by manager.mu
+	priority   int
+	txn        int64      // Age order of the transaction, 0 while it holds no locks
+	txnStart   time.Time  // When the transaction took its first lock
+	held       []string   // Resources held, in the order taken
+	apps       []*appLock // Application locks held
+	waiting    string     // Resource waited for, "" when not waiting
+	waitingApp *appLock   // Application lock waited for, nil when not waiting
+	waitStart  time.Time  // When the current wait began
+	granted    chan error // Outcome of the current wait
+}
+
+// Lock waits until the owner holds an exclusive lock on table, failing
+// with error 1205 if the owner is chosen as a deadlock victim.
+func (o *LockOwner) Lock(ctx context.Context, table string) error {
+	m := o.manager
+	resource := table
+	if o.database != "" {
+		resource = o.database + "." + table
+	}
+
+	m.mu.Lock()
+	l := m.tables[resource]
+	if l == nil {
+		l = &tableLock{database: o.database, table: table}
+		m.tables[resource] = l
+	}
+	if l.holder == o {
+		m.mu.Unlock()
+		return nil
+	}
+	if o.txn == 0 {
+		m.txns++
+		o.txn = m.txns
+		o.txnStart = time.Now()
+	}
+	if l.holder == nil {
+		l.holder = o
+		o.held = append(o.held, resource)
+		m.mu.Unlock()
+		return nil
+	}
+
+	o.waiting = resource
+	o.waitStart = time.Now()
+	granted := make(chan error, 1)
+	o.granted = granted
+	l.waiters = append(l.waiters, o)
+	m.waiters[o.sessionID] = o
+	if cycle := m.cycle(o); cycle != nil {
+		m.breakDeadlock(cycle)
+	}
+	m.mu.Unlock()
+
+	select {
+	case err := <-granted:
+		return err
+	case <-ctx.Done():
+		m.mu.Lock()
+		if o.waiting == resource {
+			m.stopWaiting(o)
+			m.mu.Unlock()
+			return ctx.Err()
+		}
+		m.mu.Unlock()
+		// Granted or chosen as a victim meanwhile
+		return <-granted
+	}
+}
+
+// SetPriority sets the owner's DEADLOCK_PRIORITY.
+func (o *LockOwner) SetPriority(priority int) {
+	o.manager.mu.Lock()
+	defer o.manager.mu.Unlock()
+	o.priority = priority
+}
+
+// ReleaseAll releases the owner's locks, granting each to the transaction
+// that has waited longest for it, as the transaction ends.
+func (o *LockOwner) ReleaseAll() {
+	m := o.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, resource := range o.held {
+		l := m.tables[resource]
+		if len(l.waiters) == 0 {
+			delete(m.tables, resource)
+			continue
+		}
+		next := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.holder = next
+		next.held = append(next.held, resource)
+		m.endWait(next)
+		next.granted <- nil
+	}
+	o.held = nil
+	m.releaseApps(o)
+	o.txn = 0
+}
+
+// lockCount returns the number of locks o holds. Caller holds
+// o.manager.mu.
+func (o *LockOwner) lockCount() int {
+	n := len(o.held)
+	for _, l := range o.apps {
+		n += len(l.grantsOf(o))
+	}
+	return n
+}
+
+// blockers returns the owners holding, or queued ahead for, the lock o
+// waits for. Caller holds m.mu.
+func (m *LockManager) blockers(o *LockOwner) []*LockOwner {
+	switch {
+	case o.waitingApp != nil:
+		return o.waitingApp.blockers(o)
+	case o.waiting != "":
+		return []*LockOwner{m.tables[o.waiting].holder}
+	}
+	return nil
+}
+
+// cycle returns the owners of a chain of waits from start back to start,
+// or nil if there is none. A lock held by a session that is itself
+// waiting leads on to the owner it waits as. Caller holds m.mu.
+func (m *LockManager) cycle(start *LockOwner) []*LockOwner {
+	var chain []*LockOwner
+	visited := make(map[*LockOwner]bool)
+	var follow func(o *LockOwner) bool
+	follow = func(o *LockOwner) bool {
+		chain = append(chain, o)
+		visited[o] = true
+		for _, b := range m.blockers(o) {
+			next := m.waiters[b.sessionID]
+			if next == start {
+				return true
+			}
+			if next != nil && !visited[next] && follow(next) {
+				return true
+			}
+		}
+		chain = chain[:len(chain)-1]
+		return false
+	}
+	if follow(start) {
+		return chain
+	}
+	return nil
+}
+
+// breakDeadlock fails the wait of the victim of cycle and records the
+// deadlock. Caller holds m.mu.
+func (m *LockManager) breakDeadlock(cycle []*LockOwner) {
+	victim := slices.MinFunc(cycle, func(a, b *LockOwner) int {
+		if a.priority != b.priority {
+			return a.priority - b.priority
+		}
+		if a.lockCount() != b.lockCount() {
+			return a.lockCount() - b.lockCount()
+		}
+		return int(b.txn - a.txn) // younger first
+	})
+
+	m.detected++
+	report := DeadlockReport{
+		ID:              m.detected,
+		DetectedAt:      time.Now(),
+		VictimSessionID: victim.sessionID,
+		Processes:       len(cycle),
+		Graph:           m.graph(cycle, victim),
+	}
+	m.deadlocks = append(m.deadlocks, report)
+	if len(m.deadlocks) > maxDeadlockReports {
+		m.deadlocks = slices.Delete(m.deadlocks, 0, len(m.deadlocks)-maxDeadlockReports)
+	}
+	m.notifier.Notify(notify.EventDeadlock, "deadlock detected",
+		"deadlock_id", report.ID,
+		"victim_session_id", victim.sessionID,
+		"processes", report.Processes,
+		"deadlock_graph", report.Graph,
+	)
+	if m.logger != nil {
+		m.logger.Execution().Warn("deadlock detected",
+			"deadlock_id", report.ID,
+			"victim_session_id", victim.sessionID,
+			"processes", report.Processes,
+			"deadlock_graph", report.Graph,
+		)
+	}
+
+	resource := victim.waitResource()
+	m.stopWaiting(victim)
+	victim.granted <- aulerrors.Newf(aulerrors.ErrCodeExecDeadlock,
+		"Transaction (Process ID %s) was deadlocked on lock resources with another process and has been chosen as the deadlock victim. Rerun the transaction.",
+		victim.sessionID).
+		WithOp("LockOwner.Lock").
+		WithField("session_id", victim.sessionID).
+		WithField("resource", resource).
+		WithField("deadlock_id", report.ID).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(DeadlockErrorNumber)).
+		WithField(aulerrors.FieldSQLSeverity, uint8(13)).
+		WithField(aulerrors.FieldSQLState, DeadlockSQLState).
+		Err()
+}
+
+// stopWaiting takes o off the queue of the lock it waits for. Caller
+// holds m.mu.
+func (m *LockManager) stopWaiting(o *LockOwner) {
+	if l := o.waitingApp; l != nil {
+		l.waiters = slices.DeleteFunc(l.waiters, func(w *appWaiter) bool { return w.waiter == o })
+		m.endWait(o)
+		// Those queued behind o may go ahead now
+		m.grantWaiting(l)
+		m.dropIfUnused(l)
+		return
+	}
+	l := m.tables[o.waiting]
+	l.waiters = slices.DeleteFunc(l.waiters, func(w *LockOwner) bool { return w == o })
+	m.endWait(o)
+}
+
+// endWait records that o no longer waits. Caller holds m.mu.
+func (m *LockManager) endWait(o *LockOwner) {
+	o.waiting = ""
+	o.waitingApp = nil
+	if m.waiters[o.sessionID] == o {
+		delete(m.waiters, o.sessionID)
+	}
+}
+
+// waitResource describes the lock o waits for, as SQL Server's
+// wait_resource does. Caller holds o.manager.mu.
+func (o *LockOwner) waitResource() string {
+	if o.waitingApp != nil {
+		return "APPLICATION: " + o.waitingApp.String()
+	}
+	return "TABLE: " + o.waiting
+}
+
+// The deadlock graph follows the layout of SQL Server's
+// xml_deadlock_report, with table and application locks as the only kinds
+// of resource.
+type deadlockXML struct {
+	XMLName      xml.Name        `xml:"deadlock"`
+	Victims      []processRefXML `xml:"victim-list>victimProcess"`
+	Processes    []processXML    `xml:"process-list>process"`
+	Resources    []tableLockXML  `xml:"resource-list>tablelock"`
+	AppResources []appLockXML    `xml:"resource-list>applicationlock"`
+}
+
+type processRefXML struct {
+	ID   string `xml:"id,attr"`
+	Mode string `xml:"mode,attr,omitempty"`
+}
+
+type processXML struct {
+	ID           string `xml:"id,attr"`
+	SessionID    string `xml:"spid,attr"`
+	Priority     int    `xml:"priority,attr"`
+	LockCount    int    `xml:"lockCount,attr"`
+	WaitResource string `xml:"waitresource,attr"`
+	LockMode     string `xml:"lockMode,attr"`
+	InputBuf     string `xml:"inputbuf"`
+}
+
+type tableLockXML struct {
+	ObjectName string          `xml:"objectname,attr"`
+	Mode       string          `xml:"mode,attr"`
+	Owners     []processRefXML `xml:"owner-list>owner"`
+	Waiters    []processRefXML `xml:"waiter-list>waiter"`
+}
+
+type appLockXML struct {
+	Database string          `xml:"dbname,attr"`
+	Resource string          `xml:"resource,attr"`
+	Owners   []processRefXML `xml:"owner-list>owner"`
+	Waiters  []processRefXML `xml:"waiter-list>waiter"`
+}
+
+// graph renders the deadlock of cycle as XML. Caller holds m.mu.
+func (m *LockManager) graph(cycle []*LockOwner, victim *LockOwner) string {
+	id := func(o *LockOwner) string {
+		return fmt.Sprintf("process%d", slices.Index(cycle, o)+1)
+	}
+
+	var d deadlockXML
+	d.Victims = []processRefXML{{ID: id(victim)}}
+	for _, o := range cycle {
+		process := processXML{
+			ID:           id(o),
+			SessionID:    o.sessionID,
+			Priority:     o.priority,
+			LockCount:    o.lockCount(),
+			WaitResource: o.waitResource(),
+			LockMode:     "X",
+			InputBuf:     o.inputBuf,
+		}
+		if l := o.waitingApp; l != nil {
+			mode := l.waiterOf(o).mode.String()
+			process.LockMode = mode
+			resource := appLockXML{
+				Database: l.database,
+				Resource: l.String(),
+				Waiters:  []processRefXML{{ID: id(o), Mode: mode}},
+			}
+			for _, b := range l.blockers(o) {
+				if next := m.waiters[b.sessionID]; slices.Contains(cycle, next) {
+					resource.Owners = append(resource.Owners, processRefXML{ID: id(next), Mode: l.modeOf(b).String()})
+				}
+			}
+			d.Processes = append(d.Processes, process)
+			d.AppResources = append(d.AppResources, resource)
+			continue
+		}
+		d.Processes = append(d.Processes, process)
+		d.Resources = append(d.Resources, tableLockXML{
+			ObjectName: o.waiting,
+			Mode:       "X",
+			Owners:     []processRefXML{{ID: id(m.tables[o.waiting].holder), Mode: "X"}},
+			Waiters:    []processRefXML{{ID: id(o), Mode: "X"}},
+		})
+	}
+
+	out, err := xml.Marshal(d)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
@@ -0,0 +1,164 @@
+package runtime
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// lockAsync takes table for o on another goroutine, returning the
+// outcome once o is waiting for it or has already failed.
+func lockAsync(t *testing.T, ctx context.Context, o *LockOwner, table string) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- o.Lock(ctx, table) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		o.manager.mu.Lock()
+		waiting := o.waiting != ""
+		o.manager.mu.Unlock()
+		if waiting || len(done) > 0 {
+			return done
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never waited for %s", o.sessionID, table)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// isDeadlockVictim reports whether err is the error of a deadlock victim.
+func isDeadlockVictim(err error) bool {
+	sqlErr := aulerrors.FindSQLError(err)
+	return sqlErr != nil && sqlErr.Fields[aulerrors.FieldSQLErrorNumber] == int32(DeadlockErrorNumber)
+}
+
+func TestLockManager_Deadlock(t *testing.T) {
+	ctx := context.Background()
+	m := NewLockManager(nil)
+	a := m.Owner("s1", "db", "dbo.transfer_ab")
+	b := m.Owner("s2", "db", "dbo.transfer_ba")
+
+	if err := a.Lock(ctx, "dbo.t1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Lock(ctx, "dbo.t2"); err != nil {
+		t.Fatal(err)
+	}
+	aDone := lockAsync(t, ctx, a, "dbo.t2")
+
+	// b closes the cycle and, being younger with as many locks, is the victim
+	if err := b.Lock(ctx, "dbo.t1"); !isDeadlockVictim(err) {
+		t.Fatalf("got %v, want error %d", err, DeadlockErrorNumber)
+	}
+	b.ReleaseAll()
+	if err := <-aDone; err != nil {
+		t.Fatalf("survivor failed: %v", err)
+	}
+	a.ReleaseAll()
+
+	reports := m.Deadlocks()
+	if len(reports) != 1 || m.DeadlockCount() != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+	r := reports[0]
+	if r.VictimSessionID != "s2" || r.Processes != 2 {
+		t.Errorf("unexpected report: %+v", r)
+	}
+
+	var graph deadlockXML
+	if err := xml.Unmarshal([]byte(r.Graph), &graph); err != nil {
+		t.Fatalf("graph is not XML: %v\n%s", err, r.Graph)
+	}
+	if len(graph.Processes) != 2 || len(graph.Resources) != 2 {
+		t.Fatalf("unexpected graph: %s", r.Graph)
+	}
+	victim := graph.Victims[0].ID
+	for _, p := range graph.Processes {
+		if p.ID == victim && (p.SessionID != "s2" || p.InputBuf != "dbo.transfer_ba" || p.WaitResource != "TABLE: db.dbo.t1") {
+			t.Errorf("unexpected victim process: %+v", p)
+		}
+	}
+	if len(m.tables) != 0 {
+		t.Errorf("locks left behind: %v", m.tables)
+	}
+}
+
+func TestLockManager_Victim(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(a, b *LockOwner)
+		wantVictim string
+	}{
+		{"youngest", func(a, b *LockOwner) {}, "b"},
+		{"priority", func(a, b *LockOwner) { a.SetPriority(-5) }, "a"},
+		{"fewest locks", func(a, b *LockOwner) {
+			if err := b.Lock(context.Background(), "dbo.t3"); err != nil {
+				t.Fatal(err)
+			}
+		}, "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := NewLockManager(nil)
+			a := m.Owner("a", "", "")
+			b := m.Owner("b", "", "")
+			if err := a.Lock(ctx, "dbo.t1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Lock(ctx, "dbo.t2"); err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(a, b)
+
+			aDone := lockAsync(t, ctx, a, "dbo.t2")
+			bDone := lockAsync(t, ctx, b, "dbo.t1")
+
+			victim, survivor := a, b
+			victimDone, survivorDone := aDone, bDone
+			if tt.wantVictim == "b" {
+				victim, survivor = b, a
+				victimDone, survivorDone = bDone, aDone
+			}
+			if err := <-victimDone; !isDeadlockVictim(err) {
+				t.Fatalf("%s got %v, want error %d", victim.sessionID, err, DeadlockErrorNumber)
+			}
+			victim.ReleaseAll()
+			if err := <-survivorDone; err != nil {
+				t.Fatalf("%s failed: %v", survivor.sessionID, err)
+			}
+			survivor.ReleaseAll()
+		})
+	}
+}
+
+func TestLockManager_WaitCancelled(t *testing.T) {
+	m := NewLockManager(nil)
+	a := m.Owner("s1", "", "")
+	b := m.Owner("s2", "", "")
+	if err := a.Lock(context.Background(), "dbo.t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := lockAsync(t, ctx, b, "dbo.t1")
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	a.ReleaseAll()
+	if len(m.tables) != 0 {
+		t.Errorf("cancelled waiter was granted the lock: %v", m.tables)
+	}
+	if m.DeadlockCount() != 0 {
+		t.Errorf("recorded a deadlock")
+	}
+}
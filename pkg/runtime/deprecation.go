@@ -0,0 +1,191 @@
+package runtime
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+// A procedure is deprecated by a -- @aul:deprecated annotation, whose
+// value, if any, tells callers what to use instead, or by an admin until
+// the server restarts. Calls to a deprecated procedure still run, but each
+// is logged and counted by who made it, the user, application and
+// protocol, so that a team can tell who still depends on the procedure
+// before retiring it. Calls from other procedures and from batches count
+// as calls of the session that made them.
+
+// DeprecationAnnotation is the annotation deprecating a procedure.
+const DeprecationAnnotation = "deprecated"
+
+// DeprecationStatus describes a deprecated procedure and its callers.
+type DeprecationStatus struct {
+	Procedure  string
+	Message    string
+	Source     string // "annotation" or "admin"
+	Calls      int64
+	LastCallAt time.Time
+	Callers    []DeprecatedCaller // Most calls first
+}
+
+// DeprecatedCaller counts the calls one kind of caller made to a
+// deprecated procedure.
+type DeprecatedCaller struct {
+	User        string
+	App         string
+	Protocol    string
+	Calls       int64
+	FirstCallAt time.Time
+	LastCallAt  time.Time
+}
+
+// Deprecations holds the procedures deprecated by admins and the calls
+// made to every deprecated procedure.
+type Deprecations struct {
+	logger *log.Logger
+	warn   bool // Tell callers with a warning
+
+	mu     sync.Mutex
+	marked map[string]deprecationMark                 // Lower-cased qualified name
+	usage  map[string]map[callerKey]*DeprecatedCaller // Lower-cased qualified name
+}
+
+type deprecationMark struct {
+	procedure string
+	message   string
+}
+
+type callerKey struct {
+	user, app, protocol string
+}
+
+// NewDeprecations creates an empty set of deprecations. With warn, each
+// call to a deprecated procedure returns a warning to the caller.
+func NewDeprecations(warn bool, logger *log.Logger) *Deprecations {
+	return &Deprecations{
+		logger: logger,
+		warn:   warn,
+		marked: make(map[string]deprecationMark),
+		usage:  make(map[string]map[callerKey]*DeprecatedCaller),
+	}
+}
+
+// Deprecated reports whether proc is deprecated, and the message callers
+// are given.
+func (d *Deprecations) Deprecated(proc *procedure.Procedure) (message string, ok bool) {
+	if message, ok := proc.Annotations[DeprecationAnnotation]; ok {
+		return message, true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	mark, ok := d.marked[strings.ToLower(proc.QualifiedName())]
+	return mark.message, ok
+}
+
+// Deprecate deprecates a procedure until the server restarts. message
+// tells callers what to use instead.
+func (d *Deprecations) Deprecate(proc *procedure.Procedure, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.marked[strings.ToLower(proc.QualifiedName())] = deprecationMark{proc.QualifiedName(), message}
+}
+
+// Undeprecate takes back an admin's deprecation of a procedure, reporting
+// whether there was one. The calls counted are kept.
+func (d *Deprecations) Undeprecate(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := strings.ToLower(name)
+	_, ok := d.marked[key]
+	delete(d.marked, key)
+	return ok
+}
+
+// called records a call to proc, if it is deprecated, returning the
+// warning the caller is given, or "".
+func (d *Deprecations) called(ctx context.Context, proc *procedure.Procedure, execCtx *ExecContext) string {
+	message, ok := d.Deprecated(proc)
+	if !ok || strings.HasSuffix(execCtx.SessionID, shadowSessionSuffix) {
+		return ""
+	}
+	name := proc.QualifiedName()
+	key := callerKey{execCtx.User, execCtx.App, execCtx.Protocol}
+	now := time.Now()
+
+	d.mu.Lock()
+	callers := d.usage[strings.ToLower(name)]
+	if callers == nil {
+		callers = make(map[callerKey]*DeprecatedCaller)
+		d.usage[strings.ToLower(name)] = callers
+	}
+	c := callers[key]
+	if c == nil {
+		c = &DeprecatedCaller{User: key.user, App: key.app, Protocol: key.protocol, FirstCallAt: now}
+		callers[key] = c
+	}
+	c.Calls++
+	c.LastCallAt = now
+	d.mu.Unlock()
+
+	d.logger.Execution().WithContext(ctx).Warn("deprecated procedure called",
+		"procedure", name,
+		"user", execCtx.User,
+		"app", execCtx.App,
+		"protocol", execCtx.Protocol,
+		"session_id", execCtx.SessionID,
+	)
+	if !d.warn {
+		return ""
+	}
+	warning := "Procedure " + name + " is deprecated"
+	if message != "" {
+		warning += ": " + message
+	}
+	return warning
+}
+
+// Status describes the deprecated procedures, by name: those among procs
+// with the annotation, and those an admin deprecated.
+func (d *Deprecations) Status(procs []*procedure.Procedure) []DeprecationStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	byName := make(map[string]*DeprecationStatus)
+	for _, proc := range procs {
+		if message, ok := proc.Annotations[DeprecationAnnotation]; ok {
+			byName[strings.ToLower(proc.QualifiedName())] = &DeprecationStatus{
+				Procedure: proc.QualifiedName(), Message: message, Source: "annotation",
+			}
+		}
+	}
+	for key, mark := range d.marked {
+		if byName[key] == nil {
+			byName[key] = &DeprecationStatus{Procedure: mark.procedure, Message: mark.message, Source: "admin"}
+		}
+	}
+
+	out := make([]DeprecationStatus, 0, len(byName))
+	for key, st := range byName {
+		for _, c := range d.usage[key] {
+			st.Calls += c.Calls
+			if c.LastCallAt.After(st.LastCallAt) {
+				st.LastCallAt = c.LastCallAt
+			}
+			st.Callers = append(st.Callers, *c)
+		}
+		sort.Slice(st.Callers, func(i, j int) bool {
+			a, b := st.Callers[i], st.Callers[j]
+			if a.Calls != b.Calls {
+				return a.Calls > b.Calls
+			}
+			return a.User+"\x00"+a.App+"\x00"+a.Protocol < b.User+"\x00"+b.App+"\x00"+b.Protocol
+		})
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Procedure < out[j].Procedure })
+	return out
+}
@@ -4,20 +4,44 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/ha1tch/aul/pkg/archive"
+	"github.com/ha1tch/aul/pkg/auth"
+	"github.com/ha1tch/aul/pkg/cmdshell"
 	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/features"
 	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/mail"
 	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/sandbox"
 	"github.com/ha1tch/aul/pkg/tsqlruntime"
 )
 
 // interpreter wraps tsqlruntime.Interpreter for procedure execution.
 type interpreter struct {
-	config   Config
-	logger   *log.Logger
-	db       *sql.DB
-	registry *procedure.Registry // For nested EXEC resolution
+	config       Config
+	logger       *log.Logger
+	db           *sql.DB
+	registry     *procedure.Registry     // For nested EXEC resolution
+	breakers     *BreakerSet             // Guards nested EXEC calls (nil when disabled)
+	deprecations *Deprecations           // Records nested EXEC calls of deprecated procedures
+	limits       *ProcedureLimits        // Holds nested EXEC calls to procedures' concurrency limits
+	memory       *MemoryTracker          // Account of the current execution
+	journal      *JournalEntry           // Journal of the current execution (nil = off)
+	locks        *LockOwner              // Table locks of the current execution
+	request      *RunningRequest         // The current execution's request
+	features     *features.Flags         // Read by FEATURE() (nil = all off)
+	mail         *mail.Mailer            // Queues sp_send_dbmail's email (nil = stopped)
+	shell        *cmdshell.Shell         // Runs xp_cmdshell's commands (nil = turned off)
+	permissions  *auth.Permissions       // Of SQL logins (nil = no permission model)
+	sandbox      *sandbox.Sandbox        // Capabilities denied to statements (nil = none)
+	advisor      *IndexAdvisor           // Records query shapes (nil = off)
+	tableCache   *tsqlruntime.TableCache // Pinned tables (nil = none)
+	archive      *archive.Archiver       // Of archival tables (nil = none)
 }
 
 // newInterpreter creates a new interpreter instance.
@@ -34,6 +58,43 @@ func (i *interpreter) SetDB(db *sql.DB) {
 	i.db = db
 }
 
+// featureFunc answers FEATURE() for the session and tenant of execCtx. A
+// shadow run answers as the call it shadows, so that the two take the
+// same path.
+func (i *interpreter) featureFunc(execCtx *ExecContext) tsqlruntime.FeatureFunc {
+	flags := i.features
+	subject := features.Subject{
+		Session: strings.TrimSuffix(execCtx.SessionID, shadowSessionSuffix),
+		Tenant:  execCtx.Tenant,
+	}
+	return func(name string) bool {
+		return flags.On(name, subject)
+	}
+}
+
+// session describes the server and execCtx's connection to the
+// interpreter, for @@SERVERNAME, @@SPID, HOST_NAME() and the like.
+func (i *interpreter) session(execCtx *ExecContext) *tsqlruntime.SessionInfo {
+	return &tsqlruntime.SessionInfo{
+		ServerName:    i.config.ServerName,
+		MachineName:   machineName(),
+		ProcessID:     os.Getpid(),
+		SPID:          execCtx.SPID,
+		Login:         execCtx.User,
+		HostName:      execCtx.Host,
+		ClientAddress: execCtx.Address,
+		AppName:       execCtx.App,
+		Protocol:      execCtx.Protocol,
+		Database:      execCtx.Database,
+	}
+}
+
+// machineName is the name of the host the server runs on.
+var machineName = sync.OnceValue(func() string {
+	name, _ := os.Hostname()
+	return name
+})
+
 // mapDialect converts aul dialect string to tsqlruntime.Dialect
 func mapDialect(dialect string) tsqlruntime.Dialect {
 	switch dialect {
@@ -77,7 +138,7 @@ func (i *interpreter) Execute(ctx context.Context, proc *procedure.Procedure, ex
 			Err()
 	}
 
-	i.logger.Execution().Debug("executing procedure (interpreted)",
+	i.logger.Interpreter().WithContext(ctx).Debug("executing procedure (interpreted)",
 		"procedure", proc.QualifiedName(),
 		"session_id", execCtx.SessionID,
 		"tenant", execCtx.Tenant,
@@ -91,7 +152,7 @@ func (i *interpreter) Execute(ctx context.Context, proc *procedure.Procedure, ex
 		var err error
 		db, err = tenantStorage.GetDBForTenant(execCtx.Tenant, execCtx.Database)
 		if err != nil {
-			i.logger.Execution().Warn("failed to get tenant database, falling back to default",
+			i.logger.Interpreter().WithContext(ctx).Warn("failed to get tenant database, falling back to default",
 				"tenant", execCtx.Tenant,
 				"database", execCtx.Database,
 				"error", err.Error(),
@@ -104,7 +165,7 @@ func (i *interpreter) Execute(ctx context.Context, proc *procedure.Procedure, ex
 
 	if db == nil {
 		// Fall back to in-memory execution (no actual DB queries)
-		i.logger.Execution().Debug("no database connection, using in-memory execution",
+		i.logger.Interpreter().WithContext(ctx).Debug("no database connection, using in-memory execution",
 			"procedure", proc.QualifiedName(),
 		)
 	}
@@ -117,14 +178,66 @@ func (i *interpreter) Execute(ctx context.Context, proc *procedure.Procedure, ex
 		dialect = mapDialect(i.config.DefaultDialect)
 	}
 	interp := tsqlruntime.NewInterpreter(db, dialect)
+	interp.LegacyNormalizer = i.config.LegacySQLNormalizer
+	interp.AutoParameterize = i.config.AutoParameterize
 	interp.Debug = i.logger != nil && i.config.DefaultDialect == "debug"
+	if i.memory != nil {
+		interp.SetMemoryBudget(i.memory)
+	}
+	if i.journal != nil {
+		interp.SetJournal(i.journal)
+	}
+	if i.locks != nil {
+		interp.SetLocker(i.locks)
+	}
+	if i.request != nil {
+		interp.SetProgress(i.request)
+	}
+	if i.features != nil {
+		interp.SetFeatures(i.featureFunc(execCtx))
+	}
+	interp.SetRESTPolicy(&i.config.REST)
+	interp.SetScriptPolicy(&i.config.Scripts)
+	if i.mail != nil {
+		interp.SetMailQueue(mailQueue{mailer: i.mail, user: execCtx.User})
+	}
+	var permissions tsqlruntime.Permissions
+	if i.permissions != nil {
+		permissions = sessionPermissions{permissions: i.permissions, user: execCtx.User, enforced: execCtx.EnforcePermissions}
+		interp.SetPermissions(permissions)
+	}
+	if i.shell != nil {
+		interp.SetCommandShell(commandShell{shell: i.shell, user: execCtx.User, permissions: permissions})
+	}
+	if i.sandbox != nil {
+		interp.SetSandbox(sessionSandbox{sandbox: i.sandbox, user: execCtx.User})
+	}
+	if i.advisor != nil {
+		interp.SetWorkload(i.advisor)
+	}
+	if i.tableCache != nil {
+		interp.SetTableCache(i.tableCache)
+	}
+	if i.archive != nil {
+		interp.SetArchive(archiveTables{archiver: i.archive})
+	}
+	interp.SetSession(i.session(execCtx))
+	interp.SetReadOnly(execCtx.ReadOnly)
+	interp.SetSummary(execCtx.Summary)
+	interp.SetTrace(execCtx.Trace)
+	interp.SetCoverage(execCtx.Coverage)
 
 	// Set up nested EXEC support with tenant context
+	var notices []string
 	if i.registry != nil {
-		interp.SetResolver(newTenantAwareResolver(i.registry, execCtx.Tenant))
+		interp.SetResolver(i.guard(newTenantAwareResolver(i.registry, execCtx.Tenant), execCtx, &notices))
 	}
 	interp.SetDatabase(execCtx.Database)
 	interp.SetNestingLevel(execCtx.NestingLevel)
+	interp.SetMaxNestingLevel(i.config.MaxNestingLevel)
+	interp.SetNumbersSize(i.config.NumbersSize)
+	interp.SetCallChain(proc.QualifiedName())
+	interp.SetOwnershipChain(true) // Runtime.Execute checked EXECUTE
 
 	// Set parameters as variables
 	params := make(map[string]interface{})
@@ -141,14 +254,21 @@ func (i *interpreter) Execute(ctx context.Context, proc *procedure.Procedure, ex
 	if execCtx.InTxn && execCtx.TxnContext != nil {
 		// If we have a transaction, we'd need to pass it to the interpreter
 		// For now, the interpreter will manage its own transactions
-		i.logger.Execution().Debug("executing within transaction context",
+		i.logger.Interpreter().WithContext(ctx).Debug("executing within transaction context",
 			"txn_id", execCtx.TxnContext.ID,
 		)
 	}
 
 	// Execute the procedure source
-	result, err := interp.Execute(ctx, proc.Source, params)
+	execute := interp.Execute
+	if execCtx.DryRun {
+		execute = interp.ExecuteDryRun
+	}
+	result, err := execute(ctx, proc.Source, params)
 	if err != nil {
+		if denied := deniedError(err); denied != nil {
+			return nil, denied
+		}
 		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeExecFailed,
 			"procedure execution failed").
 			WithOp("interpreter.Execute").
@@ -160,6 +280,9 @@ func (i *interpreter) Execute(ctx context.Context, proc *procedure.Procedure, ex
 	execResult := &ExecResult{
 		RowsAffected: result.RowsAffected,
 		OutputParams: make(map[string]interface{}),
+		Warnings:     append(result.Warnings, notices...),
+		Statements:   result.Statements,
+		Trace:        result.Trace,
 	}
 
 	// Convert return value
@@ -177,19 +300,15 @@ func (i *interpreter) Execute(ctx context.Context, proc *procedure.Procedure, ex
 		// Set column info
 		for j, col := range rs.Columns {
 			resultSet.Columns[j] = ColumnInfo{
-				Name:    col,
-				Type:    "varchar", // tsqlruntime doesn't expose type info in ResultSet
-				Ordinal: j,
+				Name:        col,
+				Type:        columnType(rs.Rows, j), // tsqlruntime doesn't expose type info in ResultSet
+				Ordinal:     j,
+				Sensitivity: columnSensitivity(rs, j),
 			}
 		}
 
 		// Convert rows (tsqlruntime.Value to interface{})
-		for j, row := range rs.Rows {
-			resultSet.Rows[j] = make([]interface{}, len(row))
-			for k, val := range row {
-				resultSet.Rows[j][k] = tsqlruntime.FromValue(val)
-			}
-		}
+		convertRows(resultSet.Rows, rs.Rows)
 
 		execResult.ResultSets = append(execResult.ResultSets, resultSet)
 	}
@@ -205,7 +324,7 @@ func (i *interpreter) Execute(ctx context.Context, proc *procedure.Procedure, ex
 		}
 	}
 
-	i.logger.Execution().Debug("procedure execution completed",
+	i.logger.Interpreter().WithContext(ctx).Debug("procedure execution completed",
 		"procedure", proc.QualifiedName(),
 		"rows_affected", execResult.RowsAffected,
 		"result_sets", len(execResult.ResultSets),
@@ -216,26 +335,50 @@ func (i *interpreter) Execute(ctx context.Context, proc *procedure.Procedure, ex
 
 // ExecuteSQL runs ad-hoc SQL using the tsqlruntime interpreter.
 func (i *interpreter) ExecuteSQL(ctx context.Context, sqlStr string, execCtx *ExecContext, storage StorageBackend) (*ExecResult, error) {
+	explain, sqlStr := tsqlruntime.ParseExplain(sqlStr)
 	if sqlStr == "" {
 		return nil, aulerrors.New(aulerrors.ErrCodeExecSQLError, "empty SQL").
 			WithOp("interpreter.ExecuteSQL").
 			Err()
 	}
 
-	i.logger.Execution().Debug("executing ad-hoc SQL",
+	i.logger.Interpreter().WithContext(ctx).Debug("executing ad-hoc SQL",
 		"session_id", execCtx.SessionID,
 		"tenant", execCtx.Tenant,
 		"sql_length", len(sqlStr),
 	)
 
 	// Check for system catalog queries - these are handled by the storage layer
-	// which intercepts sys.* queries and returns SQL Server-compatible metadata
+	// which intercepts sys.* queries and returns SQL Server-compatible metadata.
+	// A tally over system tables reads no metadata and is left to the interpreter.
+	// Only SELECTs go to the storage layer, which checks no permissions: the
+	// tables they read beside the catalog are checked here, and a batch naming
+	// the login table, or with other statements, runs in the interpreter
 	normalizedSQL := strings.ToLower(strings.TrimSpace(sqlStr))
-	if strings.Contains(normalizedSQL, "sys.") ||
-		strings.Contains(normalizedSQL, "information_schema.") {
+	catalog := (strings.Contains(normalizedSQL, "sys.") ||
+		strings.Contains(normalizedSQL, "sysmail_") ||
+		strings.Contains(normalizedSQL, "information_schema.")) &&
+		!strings.Contains(normalizedSQL, tsqlruntime.CredentialTable) && !tsqlruntime.IsTallyQuery(sqlStr)
+	var catalogReads []string
+	if catalog {
+		catalogReads, catalog = tsqlruntime.CatalogReads(sqlStr)
+	}
+	if catalog {
+		if err := i.checkCatalogReads(catalogReads, execCtx); err != nil {
+			return nil, err
+		}
+		if explain != tsqlruntime.ExplainOff {
+			return nil, aulerrors.New(aulerrors.ErrCodeExecSQLError,
+				"EXPLAIN of system catalog queries is not supported").
+				WithOp("interpreter.ExecuteSQL").
+				Err()
+		}
 		// Route through storage layer which handles system catalog
 		results, err := storage.Query(ctx, sqlStr)
 		if err != nil {
+			i.logger.Storage().WithContext(ctx).Error("catalog query failed", err,
+				"tenant", execCtx.Tenant,
+			)
 			return nil, aulerrors.Wrap(err, aulerrors.ErrCodeExecSQLError,
 				"SQL execution failed").
 				WithOp("interpreter.ExecuteSQL").
@@ -246,6 +389,14 @@ func (i *interpreter) ExecuteSQL(ctx context.Context, sqlStr string, execCtx *Ex
 		for _, rs := range results {
 			execResult.ResultSets = append(execResult.ResultSets, rs)
 		}
+		if execCtx.DryRun {
+			// Catalog queries write nothing
+			writes := ResultSet{Rows: [][]interface{}{}}
+			for j, col := range tsqlruntime.DryRunColumns {
+				writes.Columns = append(writes.Columns, ColumnInfo{Name: col, Type: "varchar", Ordinal: j})
+			}
+			execResult.ResultSets = append(execResult.ResultSets, writes)
+		}
 		return execResult, nil
 	}
 
@@ -256,7 +407,7 @@ func (i *interpreter) ExecuteSQL(ctx context.Context, sqlStr string, execCtx *Ex
 		var err error
 		db, err = tenantStorage.GetDBForTenant(execCtx.Tenant, execCtx.Database)
 		if err != nil {
-			i.logger.Execution().Warn("failed to get tenant database for SQL, falling back to default",
+			i.logger.Interpreter().WithContext(ctx).Warn("failed to get tenant database for SQL, falling back to default",
 				"tenant", execCtx.Tenant,
 				"database", execCtx.Database,
 				"error", err.Error(),
@@ -274,12 +425,65 @@ func (i *interpreter) ExecuteSQL(ctx context.Context, sqlStr string, execCtx *Ex
 		dialect = mapDialect(i.config.DefaultDialect)
 	}
 	interp := tsqlruntime.NewInterpreter(db, dialect)
+	interp.LegacyNormalizer = i.config.LegacySQLNormalizer
+	interp.AutoParameterize = i.config.AutoParameterize
+	interp.SetMaxNestingLevel(i.config.MaxNestingLevel)
+	interp.SetNumbersSize(i.config.NumbersSize)
+	if i.memory != nil {
+		interp.SetMemoryBudget(i.memory)
+	}
+	if i.journal != nil {
+		interp.SetJournal(i.journal)
+	}
+	if i.locks != nil {
+		interp.SetLocker(i.locks)
+	}
+	if i.request != nil {
+		interp.SetProgress(i.request)
+	}
+	if i.features != nil {
+		interp.SetFeatures(i.featureFunc(execCtx))
+	}
+	interp.SetRESTPolicy(&i.config.REST)
+	interp.SetScriptPolicy(&i.config.Scripts)
+	if i.mail != nil {
+		interp.SetMailQueue(mailQueue{mailer: i.mail, user: execCtx.User})
+	}
+	var permissions tsqlruntime.Permissions
+	if i.permissions != nil {
+		permissions = sessionPermissions{permissions: i.permissions, user: execCtx.User, enforced: execCtx.EnforcePermissions}
+		interp.SetPermissions(permissions)
+	}
+	if i.shell != nil {
+		interp.SetCommandShell(commandShell{shell: i.shell, user: execCtx.User, permissions: permissions})
+	}
+	if i.sandbox != nil {
+		interp.SetSandbox(sessionSandbox{sandbox: i.sandbox, user: execCtx.User})
+	}
+	if i.advisor != nil {
+		interp.SetWorkload(i.advisor)
+	}
+	if i.tableCache != nil {
+		interp.SetTableCache(i.tableCache)
+	}
+	if i.archive != nil {
+		interp.SetArchive(archiveTables{archiver: i.archive})
+	}
+	interp.SetSession(i.session(execCtx))
+	interp.SetReadOnly(execCtx.ReadOnly)
+	interp.SetSummary(execCtx.Summary)
+	interp.SetTrace(execCtx.Trace)
+	interp.SetCoverage(execCtx.Coverage)
+	interp.SetExplain(explain)
+	if execCtx.rowSink != nil {
+		interp.SetRowSink(execCtx.rowSink)
+	}
 
 	// Configure rewritten query logging
 	if i.config.LogQueriesRewritten && i.logger != nil {
 		interp.LogRewritten = true
 		interp.LogFunc = func(format string, args ...interface{}) {
-			i.logger.Execution().Info(fmt.Sprintf(format, args...),
+			i.logger.Interpreter().WithContext(ctx).Info(fmt.Sprintf(format, args...),
 				"session_id", execCtx.SessionID,
 			)
 		}
@@ -291,11 +495,12 @@ func (i *interpreter) ExecuteSQL(ctx context.Context, sqlStr string, execCtx *Ex
 	}
 
 	// Set resolver for nested EXEC support
+	var notices []string
 	if i.registry != nil {
 		if execCtx.Tenant != "" {
-			interp.SetResolver(newTenantAwareResolver(i.registry, execCtx.Tenant))
+			interp.SetResolver(i.guard(newTenantAwareResolver(i.registry, execCtx.Tenant), execCtx, &notices))
 		} else {
-			interp.SetResolver(newRegistryResolver(i.registry))
+			interp.SetResolver(i.guard(newRegistryResolver(i.registry), execCtx, &notices))
 		}
 	}
 
@@ -310,8 +515,15 @@ func (i *interpreter) ExecuteSQL(ctx context.Context, sqlStr string, execCtx *Ex
 	}
 
 	// Execute
-	result, err := interp.Execute(ctx, sqlStr, params)
+	execute := interp.Execute
+	if execCtx.DryRun {
+		execute = interp.ExecuteDryRun
+	}
+	result, err := execute(ctx, sqlStr, params)
 	if err != nil {
+		if denied := deniedError(err); denied != nil {
+			return nil, denied
+		}
 		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeExecSQLError,
 			"SQL execution failed").
 			WithOp("interpreter.ExecuteSQL").
@@ -321,6 +533,9 @@ func (i *interpreter) ExecuteSQL(ctx context.Context, sqlStr string, execCtx *Ex
 	// Convert result
 	execResult := &ExecResult{
 		RowsAffected: result.RowsAffected,
+		Warnings:     append(result.Warnings, notices...),
+		Statements:   result.Statements,
+		Trace:        result.Trace,
 	}
 
 	// Convert result sets
@@ -332,25 +547,75 @@ func (i *interpreter) ExecuteSQL(ctx context.Context, sqlStr string, execCtx *Ex
 
 		for j, col := range rs.Columns {
 			resultSet.Columns[j] = ColumnInfo{
-				Name:    col,
-				Type:    "varchar",
-				Ordinal: j,
+				Name:        col,
+				Type:        columnType(rs.Rows, j),
+				Ordinal:     j,
+				Sensitivity: columnSensitivity(rs, j),
 			}
 		}
 
-		for j, row := range rs.Rows {
-			resultSet.Rows[j] = make([]interface{}, len(row))
-			for k, val := range row {
-				resultSet.Rows[j][k] = tsqlruntime.FromValue(val)
-			}
-		}
+		convertRows(resultSet.Rows, rs.Rows)
 
 		execResult.ResultSets = append(execResult.ResultSets, resultSet)
 	}
 
+	if len(execCtx.Outputs) > 0 {
+		execResult.OutputParams = make(map[string]interface{}, len(execCtx.Outputs))
+		for _, name := range execCtx.Outputs {
+			name = strings.TrimPrefix(name, "@")
+			if val, ok := interp.GetVariable("@" + name); ok {
+				execResult.OutputParams[name] = val
+			}
+		}
+	}
+
 	return execResult, nil
 }
 
+// convertRows fills dst with the interface{} form of src. Every row is
+// cut from one backing slice, so a result costs two allocations rather
+// than one per row.
+// columnType names the type of column j for the protocols: varbinary
+// when its values are binary, so that clients get them as bytes rather
+// than as text, otherwise varchar.
+func columnType(rows [][]tsqlruntime.Value, j int) string {
+	for _, row := range rows {
+		if j >= len(row) || row[j].IsNull {
+			continue
+		}
+		if t := row[j].Type; t == tsqlruntime.TypeBinary || t == tsqlruntime.TypeVarBinary {
+			return "varbinary"
+		}
+		return "varchar"
+	}
+	return "varchar"
+}
+
+func convertRows(dst [][]interface{}, src [][]tsqlruntime.Value) {
+	n := 0
+	for _, row := range src {
+		n += len(row)
+	}
+	backing := make([]interface{}, n)
+	for j, row := range src {
+		out := backing[:len(row):len(row)]
+		backing = backing[len(row):]
+		for k, val := range row {
+			out[k] = tsqlruntime.FromValue(val)
+		}
+		dst[j] = out
+	}
+}
+
+// columnSensitivity returns the classification of column j of rs, nil if
+// it has none.
+func columnSensitivity(rs tsqlruntime.ResultSet, j int) *tsqlruntime.Sensitivity {
+	if j < len(rs.Sensitivity) {
+		return rs.Sensitivity[j]
+	}
+	return nil
+}
+
 // Reset clears the interpreter state for reuse.
 func (i *interpreter) Reset() {
 	// The interpreter is recreated for each execution, so nothing to reset
@@ -384,6 +649,11 @@ func (r *registryResolver) Resolve(ctx context.Context, name string, database st
 	return proc.Source, params, nil
 }
 
+// RenameProcedure implements tsqlruntime.ProcedureRenamer.
+func (r *registryResolver) RenameProcedure(ctx context.Context, name string, database string, newName string) error {
+	return r.registry.Rename(name, database, newName)
+}
+
 // newRegistryResolver creates a resolver that uses the procedure registry.
 func newRegistryResolver(registry *procedure.Registry) tsqlruntime.ProcedureResolver {
 	if registry == nil {
@@ -421,6 +691,12 @@ func (r *tenantAwareResolver) Resolve(ctx context.Context, name string, database
 	return proc.Source, params, nil
 }
 
+// RenameProcedure implements tsqlruntime.ProcedureRenamer. It renames the
+// shared procedure; tenant overrides keep their names.
+func (r *tenantAwareResolver) RenameProcedure(ctx context.Context, name string, database string, newName string) error {
+	return r.registry.Rename(name, database, newName)
+}
+
 // newTenantAwareResolver creates a resolver that uses the procedure registry with tenant context.
 func newTenantAwareResolver(registry *procedure.Registry, tenant string) tsqlruntime.ProcedureResolver {
 	if registry == nil {
@@ -428,3 +704,70 @@ func newTenantAwareResolver(registry *procedure.Registry, tenant string) tsqlrun
 	}
 	return &tenantAwareResolver{registry: registry, tenant: tenant}
 }
+
+// guard wraps a resolver so that nested EXEC calls pass through the
+// circuit breakers and procedures' concurrency limits, and calls of
+// deprecated procedures are recorded, with the warnings for the caller
+// added to warnings. It returns the resolver unchanged when none is on.
+func (i *interpreter) guard(resolver tsqlruntime.ProcedureResolver, execCtx *ExecContext, warnings *[]string) tsqlruntime.ProcedureResolver {
+	if resolver == nil || i.breakers == nil && i.deprecations == nil && i.limits == nil {
+		return resolver
+	}
+	return &guardedResolver{
+		ProcedureResolver: resolver,
+		registry:          i.registry,
+		execCtx:           execCtx,
+		breakers:          i.breakers,
+		deprecations:      i.deprecations,
+		limits:            i.limits,
+		warnings:          warnings,
+	}
+}
+
+// guardedResolver adds tsqlruntime.ProcedureGuard to a resolver.
+type guardedResolver struct {
+	tsqlruntime.ProcedureResolver
+	registry     *procedure.Registry
+	execCtx      *ExecContext
+	breakers     *BreakerSet      // nil when disabled
+	deprecations *Deprecations    // nil when not recorded
+	limits       *ProcedureLimits // nil when not held
+	warnings     *[]string
+}
+
+// Admit implements tsqlruntime.ProcedureGuard.
+func (r *guardedResolver) Admit(ctx context.Context, name string, database string) (func(error), error) {
+	proc, err := r.registry.LookupForTenant(name, database, r.execCtx.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	if r.deprecations != nil {
+		if warning := r.deprecations.called(ctx, proc, r.execCtx); warning != "" {
+			*r.warnings = append(*r.warnings, warning)
+		}
+	}
+	release := func() {}
+	if r.limits != nil {
+		if release, err = r.limits.Acquire(ctx, proc); err != nil {
+			return nil, err
+		}
+	}
+	if r.breakers == nil {
+		return func(error) { release() }, nil
+	}
+	qualified := proc.QualifiedName()
+	if err := r.breakers.Allow(qualified); err != nil {
+		release()
+		return nil, err
+	}
+	start := time.Now()
+	return func(err error) {
+		release()
+		r.breakers.Record(qualified, time.Since(start), err)
+	}, nil
+}
+
+// RenameProcedure implements tsqlruntime.ProcedureRenamer.
+func (r *guardedResolver) RenameProcedure(ctx context.Context, name string, database string, newName string) error {
+	return r.registry.Rename(name, database, newName)
+}
@@ -0,0 +1,38 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// InterruptSQLState is the PostgreSQL SQLSTATE for an execution that timed
+// out or was cancelled (query_canceled).
+const InterruptSQLState = "57014"
+
+// interrupted returns the error of an execution whose context ended while
+// it ran, in place of the one it failed with: the backend, interrupted in
+// the query it was running, reports only that it was interrupted. It
+// returns nil while ctx is live.
+func interrupted(ctx context.Context, op string, timeout time.Duration) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	var b *aulerrors.Builder
+	if errors.Is(err, context.DeadlineExceeded) {
+		msg := "Execution timeout expired"
+		if timeout > 0 {
+			msg += " after " + timeout.String()
+		}
+		b = aulerrors.Wrap(err, aulerrors.ErrCodeExecTimeout, msg).
+			WithField("timeout", timeout)
+	} else {
+		b = aulerrors.Wrap(err, aulerrors.ErrCodeExecCancelled, "Operation cancelled by user")
+	}
+	return b.WithOp(op).
+		WithField(aulerrors.FieldSQLState, InterruptSQLState).
+		Err()
+}
@@ -0,0 +1,617 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+// JournalConfig configures the statement journal.
+//
+// The journal is an append-only file recording the writes made by
+// procedure executions and ad-hoc batches. Each write is recorded before
+// it runs and again when it finishes, and each execution that wrote
+// anything is marked when it ends. An execution with no end record was
+// interrupted; on startup those are reported, with the writes they had
+// applied, in the log and in sys.dm_aul_recovery.
+//
+// Read-only executions are not journalled.
+type JournalConfig struct {
+	Path    string // Journal file ("" = disabled)
+	Sync    bool   // Flush each record to disk before continuing
+	Replay  bool   // Re-run interrupted executions once storage is ready
+	MaxSize int64  // Size at which an idle journal is truncated
+}
+
+// DefaultJournalMaxSize is the journal size at which it is truncated when
+// no executions are in flight.
+const DefaultJournalMaxSize = 64 << 20
+
+// Journal record types.
+const (
+	journalBegin       = "begin"
+	journalWrite       = "write"
+	journalWriteDone   = "write_done"
+	journalTransaction = "transaction"
+	journalEnd         = "end"
+)
+
+// journalRecord is one line of the journal file.
+type journalRecord struct {
+	Type string    `json:"type"`
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+
+	// begin
+	Procedure  string                 `json:"procedure,omitempty"`
+	SQL        string                 `json:"sql,omitempty"` // Ad-hoc batch text
+	SessionID  string                 `json:"session_id,omitempty"`
+	Database   string                 `json:"database,omitempty"`
+	Tenant     string                 `json:"tenant,omitempty"`
+	Parameters map[string]interface{} `json:"params,omitempty"`
+
+	// write, write_done
+	Seq           int    `json:"seq,omitempty"`
+	Kind          string `json:"kind,omitempty"`
+	Target        string `json:"target,omitempty"`
+	Text          string `json:"text,omitempty"`
+	InTransaction bool   `json:"in_transaction,omitempty"`
+	Rows          int64  `json:"rows,omitempty"`
+
+	// transaction
+	Committed bool `json:"committed,omitempty"`
+
+	// write_done, end
+	Error string `json:"error,omitempty"`
+}
+
+// WriteOutcome is what became of a journalled write after a crash.
+type WriteOutcome int
+
+const (
+	WriteApplied    WriteOutcome = iota // Durable in the storage backend
+	WriteRolledBack                     // Discarded with its uncommitted transaction
+	WriteUnknown                        // Running when the crash happened
+)
+
+func (o WriteOutcome) String() string {
+	switch o {
+	case WriteApplied:
+		return "APPLIED"
+	case WriteRolledBack:
+		return "ROLLED_BACK"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// RecoveryStatus is the state of an interrupted execution.
+type RecoveryStatus int
+
+const (
+	RecoveryPending      RecoveryStatus = iota // Reported, awaiting attention
+	RecoveryReplayed                           // Re-run successfully
+	RecoveryReplayFailed                       // Re-run failed
+)
+
+func (s RecoveryStatus) String() string {
+	switch s {
+	case RecoveryPending:
+		return "PENDING"
+	case RecoveryReplayed:
+		return "REPLAYED"
+	case RecoveryReplayFailed:
+		return "REPLAY_FAILED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// JournalledWrite is a write recorded for an interrupted execution.
+// Writes that failed applied nothing and are not listed.
+type JournalledWrite struct {
+	Seq     int
+	Kind    string // INSERT, UPDATE, DELETE, ...
+	Target  string
+	Text    string
+	Rows    int64
+	Outcome WriteOutcome
+}
+
+// InterruptedExecution is an execution that was still running when the
+// server stopped without finishing it.
+type InterruptedExecution struct {
+	ID         int64
+	Procedure  string // Empty for an ad-hoc batch
+	SQL        string // Batch text, for an ad-hoc batch
+	SessionID  string
+	Database   string
+	Tenant     string
+	Parameters map[string]interface{}
+	StartedAt  time.Time
+	Writes     []JournalledWrite
+
+	Status       RecoveryStatus
+	StatusDetail string
+}
+
+// Count returns the number of writes with the given outcome.
+func (e *InterruptedExecution) Count(outcome WriteOutcome) int {
+	n := 0
+	for _, w := range e.Writes {
+		if w.Outcome == outcome {
+			n++
+		}
+	}
+	return n
+}
+
+// LastApplied returns the last write known to have persisted, or nil.
+func (e *InterruptedExecution) LastApplied() *JournalledWrite {
+	for i := len(e.Writes) - 1; i >= 0; i-- {
+		if e.Writes[i].Outcome == WriteApplied {
+			return &e.Writes[i]
+		}
+	}
+	return nil
+}
+
+// Name returns the procedure name, or "(batch)" for an ad-hoc batch.
+func (e *InterruptedExecution) Name() string {
+	if e.Procedure == "" {
+		return "(batch)"
+	}
+	return e.Procedure
+}
+
+// Journal is the statement journal.
+type Journal struct {
+	config JournalConfig
+	logger *log.Logger
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	nextID int64
+	active int  // Executions begun and not yet ended
+	failed bool // A write has failed; logged once
+
+	recovered []*InterruptedExecution
+}
+
+// OpenJournal opens the journal at cfg.Path and recovers the executions
+// it shows as interrupted. When there are any, the old journal is kept
+// as <path>.recovered for inspection; the journal itself starts empty.
+func OpenJournal(cfg JournalConfig, logger *log.Logger) (*Journal, error) {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultJournalMaxSize
+	}
+
+	recovered, err := recoverJournal(cfg.Path)
+	if err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid,
+			"failed to read statement journal").
+			WithOp("OpenJournal").
+			WithField("path", cfg.Path).
+			Err()
+	}
+	if len(recovered) > 0 {
+		if err := os.Rename(cfg.Path, cfg.Path+".recovered"); err != nil {
+			return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid,
+				"failed to preserve statement journal").
+				WithOp("OpenJournal").
+				WithField("path", cfg.Path).
+				Err()
+		}
+	}
+
+	f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid,
+			"failed to open statement journal").
+			WithOp("OpenJournal").
+			WithField("path", cfg.Path).
+			Err()
+	}
+
+	for _, e := range recovered {
+		fields := []interface{}{
+			"execution_id", e.ID,
+			"procedure", e.Name(),
+			"session_id", e.SessionID,
+			"started_at", e.StartedAt.Format(time.RFC3339),
+			"applied_writes", e.Count(WriteApplied),
+			"rolled_back_writes", e.Count(WriteRolledBack),
+			"unknown_writes", e.Count(WriteUnknown),
+		}
+		if last := e.LastApplied(); last != nil {
+			fields = append(fields, "last_applied", last.Text)
+		}
+		logger.System().Warn("execution interrupted by previous shutdown", fields...)
+	}
+	logger.System().Info("statement journal enabled",
+		"path", cfg.Path,
+		"sync", cfg.Sync,
+		"interrupted_executions", len(recovered),
+	)
+
+	return &Journal{
+		config:    cfg,
+		logger:    logger,
+		file:      f,
+		nextID:    1,
+		recovered: recovered,
+	}, nil
+}
+
+// recoverJournal reads the journal at path and returns the executions
+// that began but never ended. A missing file has none.
+func recoverJournal(path string) ([]*InterruptedExecution, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	type pending struct {
+		exec   *InterruptedExecution
+		writes map[int]int  // seq -> index in exec.Writes
+		failed map[int]bool // Indexes of writes that failed
+		inTxn  []int        // Writes in the open transaction
+	}
+	execs := make(map[int64]*pending)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec journalRecord
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&rec); err != nil {
+			// A torn final record from the crash itself
+			continue
+		}
+
+		if rec.Type == journalBegin {
+			execs[rec.ID] = &pending{
+				exec: &InterruptedExecution{
+					ID:         rec.ID,
+					Procedure:  rec.Procedure,
+					SQL:        rec.SQL,
+					SessionID:  rec.SessionID,
+					Database:   rec.Database,
+					Tenant:     rec.Tenant,
+					Parameters: decodeJournalParams(rec.Parameters),
+					StartedAt:  rec.Time,
+				},
+				writes: make(map[int]int),
+				failed: make(map[int]bool),
+			}
+			continue
+		}
+		p, ok := execs[rec.ID]
+		if !ok {
+			continue
+		}
+
+		switch rec.Type {
+		case journalWrite:
+			// A write in a transaction is discarded by the backend unless
+			// the transaction commits; one outside a transaction has an
+			// unknown outcome until it is seen to finish.
+			outcome := WriteUnknown
+			if rec.InTransaction {
+				outcome = WriteRolledBack
+				p.inTxn = append(p.inTxn, len(p.exec.Writes))
+			}
+			p.writes[rec.Seq] = len(p.exec.Writes)
+			p.exec.Writes = append(p.exec.Writes, JournalledWrite{
+				Seq:     rec.Seq,
+				Kind:    rec.Kind,
+				Target:  rec.Target,
+				Text:    rec.Text,
+				Outcome: outcome,
+			})
+		case journalWriteDone:
+			i, ok := p.writes[rec.Seq]
+			if !ok {
+				continue
+			}
+			if rec.Error != "" {
+				p.failed[i] = true
+				continue
+			}
+			p.exec.Writes[i].Rows = rec.Rows
+			if p.exec.Writes[i].Outcome == WriteUnknown {
+				p.exec.Writes[i].Outcome = WriteApplied
+			}
+		case journalTransaction:
+			if rec.Committed {
+				for _, i := range p.inTxn {
+					p.exec.Writes[i].Outcome = WriteApplied
+				}
+			}
+			p.inTxn = nil
+		case journalEnd:
+			delete(execs, rec.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var interrupted []*InterruptedExecution
+	for _, p := range execs {
+		// A failed statement applied nothing
+		var writes []JournalledWrite
+		for i, w := range p.exec.Writes {
+			if !p.failed[i] {
+				writes = append(writes, w)
+			}
+		}
+		p.exec.Writes = writes
+		interrupted = append(interrupted, p.exec)
+	}
+	sort.Slice(interrupted, func(i, j int) bool {
+		return interrupted[i].ID < interrupted[j].ID
+	})
+	return interrupted, nil
+}
+
+// decodeJournalParams turns JSON numbers back into int64 or float64.
+func decodeJournalParams(params map[string]interface{}) map[string]interface{} {
+	for name, v := range params {
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		if i, err := strconv.ParseInt(n.String(), 10, 64); err == nil {
+			params[name] = i
+		} else if f, err := n.Float64(); err == nil {
+			params[name] = f
+		}
+	}
+	return params
+}
+
+// journalParams returns params with any value that cannot be encoded as
+// JSON replaced by its printed form.
+func journalParams(params map[string]interface{}) map[string]interface{} {
+	if len(params) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(params))
+	for name, v := range params {
+		if _, err := json.Marshal(v); err != nil {
+			v = fmt.Sprint(v)
+		}
+		out[name] = v
+	}
+	return out
+}
+
+// Begin starts journalling an execution of procedure, or of the ad-hoc
+// batch sql when procedure is empty. Nothing is written until the
+// execution makes its first write.
+func (j *Journal) Begin(procedure, sql string, execCtx *ExecContext) *JournalEntry {
+	return &JournalEntry{
+		journal: j,
+		begin: journalRecord{
+			Type:       journalBegin,
+			Procedure:  procedure,
+			SQL:        sql,
+			SessionID:  execCtx.SessionID,
+			Database:   execCtx.Database,
+			Tenant:     execCtx.Tenant,
+			Parameters: journalParams(execCtx.Parameters),
+		},
+	}
+}
+
+// Interrupted returns the executions recovered at startup.
+func (j *Journal) Interrupted() []*InterruptedExecution {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	out := make([]*InterruptedExecution, len(j.recovered))
+	for i, e := range j.recovered {
+		copied := *e
+		out[i] = &copied
+	}
+	return out
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// write appends rec. Caller holds j.mu.
+func (j *Journal) write(rec journalRecord) error {
+	rec.Time = time.Now()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err == nil && j.config.Sync {
+		err = j.file.Sync()
+	}
+	return err
+}
+
+// record appends a record that follows work already done. A failure
+// cannot undo that work, so it is logged rather than returned.
+func (j *Journal) record(rec journalRecord) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.recordLocked(rec)
+}
+
+func (j *Journal) recordLocked(rec journalRecord) {
+	if err := j.write(rec); err != nil && !j.failed {
+		j.failed = true
+		j.logger.System().Error("statement journal write failed", err,
+			"path", j.config.Path,
+		)
+	}
+}
+
+// replay re-runs the pending interrupted executions and records the
+// outcome of each.
+func (j *Journal) replay(ctx context.Context, r *Runtime) {
+	j.mu.Lock()
+	pending := make([]*InterruptedExecution, 0, len(j.recovered))
+	for _, e := range j.recovered {
+		if e.Status == RecoveryPending {
+			pending = append(pending, e)
+		}
+	}
+	j.mu.Unlock()
+
+	for _, e := range pending {
+		execCtx := &ExecContext{
+			SessionID:  "recovery",
+			Database:   e.Database,
+			Tenant:     e.Tenant,
+			Parameters: e.Parameters,
+			Timeout:    r.config.ExecTimeout,
+		}
+		var err error
+		if e.Procedure == "" {
+			_, err = r.ExecuteSQL(ctx, e.SQL, execCtx)
+		} else if proc, lookupErr := r.registry.LookupForTenant(e.Procedure, e.Database, e.Tenant); lookupErr != nil {
+			err = lookupErr
+		} else {
+			_, err = r.Execute(ctx, proc, execCtx)
+		}
+
+		status, detail := RecoveryReplayed, ""
+		if err != nil {
+			status, detail = RecoveryReplayFailed, err.Error()
+			j.logger.System().Error("replay of interrupted execution failed", err,
+				"execution_id", e.ID,
+				"procedure", e.Name(),
+			)
+		} else {
+			j.logger.System().Info("replayed interrupted execution",
+				"execution_id", e.ID,
+				"procedure", e.Name(),
+			)
+		}
+
+		j.mu.Lock()
+		e.Status, e.StatusDetail = status, detail
+		j.mu.Unlock()
+	}
+}
+
+// JournalEntry journals one execution. It implements
+// tsqlruntime.StatementJournal.
+type JournalEntry struct {
+	journal *Journal
+	begin   journalRecord
+	id      int64 // Assigned when the begin record is written
+	seq     int
+}
+
+// BeginWrite records a write about to run, first recording the start of
+// the execution if this is its first write.
+func (e *JournalEntry) BeginWrite(kind, target, text string, inTransaction bool) error {
+	j := e.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if e.id == 0 {
+		e.begin.ID = j.nextID
+		if err := j.write(e.begin); err != nil {
+			return e.writeFailed(err)
+		}
+		e.id = j.nextID
+		j.nextID++
+		j.active++
+	}
+
+	e.seq++
+	err := j.write(journalRecord{
+		Type:          journalWrite,
+		ID:            e.id,
+		Seq:           e.seq,
+		Kind:          kind,
+		Target:        target,
+		Text:          text,
+		InTransaction: inTransaction,
+	})
+	if err != nil {
+		return e.writeFailed(err)
+	}
+	return nil
+}
+
+// EndWrite records the outcome of the write last begun.
+func (e *JournalEntry) EndWrite(rows int64, err error) {
+	rec := journalRecord{Type: journalWriteDone, ID: e.id, Seq: e.seq, Rows: rows}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	e.journal.record(rec)
+}
+
+// Transaction records the end of the outermost transaction.
+func (e *JournalEntry) Transaction(committed bool) {
+	if e.id == 0 {
+		return
+	}
+	e.journal.record(journalRecord{Type: journalTransaction, ID: e.id, Committed: committed})
+}
+
+// End records that the execution finished, successfully or not, and
+// truncates the journal once it is idle and has grown past its maximum
+// size.
+func (e *JournalEntry) End(err error) {
+	if e.id == 0 {
+		return
+	}
+	rec := journalRecord{Type: journalEnd, ID: e.id}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	j := e.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.recordLocked(rec)
+	j.active--
+	if j.active == 0 && j.size >= j.config.MaxSize {
+		if err := j.file.Truncate(0); err == nil {
+			j.size = 0
+		}
+	}
+}
+
+// writeFailed returns the error for a write that could not be journalled.
+// The write does not run, since a crash could then leave it unreported.
+func (e *JournalEntry) writeFailed(err error) error {
+	return aulerrors.Wrap(err, aulerrors.ErrCodeExecFailed,
+		"failed to write statement journal").
+		WithOp("JournalEntry.BeginWrite").
+		WithField("path", e.journal.config.Path).
+		Err()
+}
@@ -0,0 +1,143 @@
+package runtime
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pkglog "github.com/ha1tch/aul/pkg/log"
+)
+
+func testJournal(t *testing.T, path string) *Journal {
+	t.Helper()
+	logger := pkglog.New(pkglog.Config{
+		DefaultLevel: pkglog.LevelError,
+		Format:       pkglog.FormatText,
+	})
+	j, err := OpenJournal(JournalConfig{Path: path}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func TestJournal_RecoversInterruptedExecutions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aul.journal")
+	j := testJournal(t, path)
+
+	// Finished: not reported
+	done := j.Begin("dbo.done", "", &ExecContext{SessionID: "s1"})
+	if err := done.BeginWrite("INSERT", "t", "INSERT INTO t VALUES (1)", false); err != nil {
+		t.Fatal(err)
+	}
+	done.EndWrite(1, nil)
+	done.End(nil)
+
+	// Read-only: never written
+	j.Begin("dbo.read", "", &ExecContext{SessionID: "s1"}).End(nil)
+
+	// Interrupted part way through
+	e := j.Begin("dbo.load", "", &ExecContext{
+		SessionID:  "s2",
+		Database:   "master",
+		Parameters: map[string]interface{}{"n": int64(7), "name": "x"},
+	})
+	steps := []struct {
+		text  string
+		inTxn bool
+		err   error
+	}{
+		{"INSERT INTO t VALUES (1)", false, nil},          // applied
+		{"UPDATE t SET v = 2", false, errors.New("boom")}, // failed, applied nothing
+		{"INSERT INTO t VALUES (2)", true, nil},           // committed below
+		{"DELETE FROM t", true, nil},                      // never committed
+	}
+	for i, s := range steps {
+		if err := e.BeginWrite("DML", "t", s.text, s.inTxn); err != nil {
+			t.Fatal(err)
+		}
+		e.EndWrite(1, s.err)
+		if i == 2 {
+			e.Transaction(true)
+		}
+	}
+	if err := e.BeginWrite("UPDATE", "t", "UPDATE t SET v = 3", false); err != nil {
+		t.Fatal(err)
+	}
+	// Crash: the last write never finished and the execution never ended
+	j.Close()
+
+	r := testJournal(t, path)
+	got := r.Interrupted()
+	if len(got) != 1 {
+		t.Fatalf("got %d interrupted executions, want 1", len(got))
+	}
+	ie := got[0]
+	if ie.Procedure != "dbo.load" || ie.SessionID != "s2" || ie.Database != "master" {
+		t.Errorf("unexpected execution: %+v", ie)
+	}
+	if ie.Parameters["n"] != int64(7) || ie.Parameters["name"] != "x" {
+		t.Errorf("parameters = %v", ie.Parameters)
+	}
+
+	want := []WriteOutcome{WriteApplied, WriteApplied, WriteRolledBack, WriteUnknown}
+	if len(ie.Writes) != len(want) {
+		t.Fatalf("got %d writes, want %d: %+v", len(ie.Writes), len(want), ie.Writes)
+	}
+	for i, w := range want {
+		if ie.Writes[i].Outcome != w {
+			t.Errorf("write %d (%s) = %s, want %s", i, ie.Writes[i].Text, ie.Writes[i].Outcome, w)
+		}
+	}
+	if last := ie.LastApplied(); last == nil || last.Text != "INSERT INTO t VALUES (2)" {
+		t.Errorf("last applied = %+v", last)
+	}
+	if ie.Status != RecoveryPending {
+		t.Errorf("status = %s, want PENDING", ie.Status)
+	}
+
+	// The old journal is kept and the new one starts empty
+	if _, err := os.Stat(path + ".recovered"); err != nil {
+		t.Errorf("recovered journal not kept: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("journal not reset: %v", err)
+	}
+}
+
+func TestJournal_TornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aul.journal")
+	data := `{"type":"begin","id":1,"sql":"EXEC dbo.p"}
+{"type":"write","id":1,"seq":1,"kind":"INSERT","target":"t"}
+{"type":"write_done","id":1,"se`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := testJournal(t, path).Interrupted()
+	if len(got) != 1 || got[0].SQL != "EXEC dbo.p" || got[0].Name() != "(batch)" {
+		t.Fatalf("unexpected recovery: %+v", got)
+	}
+	if got[0].Count(WriteUnknown) != 1 {
+		t.Errorf("torn completion should leave the write unknown: %+v", got[0].Writes)
+	}
+}
+
+func TestJournal_TruncatesWhenIdle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aul.journal")
+	j := testJournal(t, path)
+	j.config.MaxSize = 1
+
+	e := j.Begin("dbo.p", "", &ExecContext{})
+	if err := e.BeginWrite("INSERT", "t", "INSERT INTO t VALUES (1)", false); err != nil {
+		t.Fatal(err)
+	}
+	e.EndWrite(1, nil)
+	e.End(nil)
+
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("idle journal was not truncated")
+	}
+}
@@ -0,0 +1,41 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/ha1tch/aul/pkg/mail"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// mailQueue queues sp_send_dbmail's email with a Mailer, recording the
+// user whose execution sent it.
+type mailQueue struct {
+	mailer *mail.Mailer
+	user   string
+}
+
+func (q mailQueue) HasProfile(name string) bool {
+	return q.mailer.HasProfile(name)
+}
+
+func (q mailQueue) QueueMail(ctx context.Context, msg tsqlruntime.MailMessage) (int64, error) {
+	m := mail.Message{
+		Profile:     msg.Profile,
+		From:        msg.From,
+		ReplyTo:     msg.ReplyTo,
+		To:          msg.To,
+		CC:          msg.CC,
+		BCC:         msg.BCC,
+		Subject:     msg.Subject,
+		Body:        msg.Body,
+		BodyFormat:  msg.BodyFormat,
+		Importance:  msg.Importance,
+		Sensitivity: msg.Sensitivity,
+		Query:       msg.Query,
+		User:        q.user,
+	}
+	for _, a := range msg.Attachments {
+		m.Attachments = append(m.Attachments, mail.Attachment{Name: a.Name, Data: a.Data})
+	}
+	return q.mailer.Send(m)
+}
@@ -0,0 +1,231 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// MemoryConfig bounds the memory executions may hold in buffered result
+// sets, temp tables, table variables and cursor snapshots.
+//
+// Sizes are estimates of the in-process representation. An execution that
+// would exceed either limit fails with error 701 rather than letting the
+// process grow until the operating system kills it. Spilling to disk is
+// not implemented; the statement is aborted instead.
+type MemoryConfig struct {
+	Limit        int64 // Server-wide bytes (0 = unlimited)
+	SessionLimit int64 // Bytes per session (0 = unlimited)
+}
+
+// MemoryErrorNumber is the SQL error number reported when a memory budget
+// is exhausted, as SQL Server reports insufficient system memory.
+const MemoryErrorNumber = 701
+
+// MemorySQLState is the PostgreSQL SQLSTATE for an exhausted memory budget
+// (out_of_memory).
+const MemorySQLState = "53200"
+
+// MemoryManager accounts for the memory held by running executions, per
+// session and server-wide. Usage is tracked even when no limit is set.
+type MemoryManager struct {
+	config   MemoryConfig
+	mu       sync.Mutex
+	used     int64
+	peak     int64
+	rejected int64
+	sessions map[string]*sessionMemory
+}
+
+type sessionMemory struct {
+	used       int64
+	peak       int64
+	executions int
+}
+
+// SessionMemory is the memory usage of one session with running
+// executions.
+type SessionMemory struct {
+	SessionID  string
+	Used       int64
+	Peak       int64
+	Executions int
+}
+
+// MemoryStats is a snapshot of the memory manager.
+type MemoryStats struct {
+	Limit        int64
+	SessionLimit int64
+	Used         int64
+	Peak         int64
+	Rejected     int64 // Reservations refused since startup
+	Sessions     []SessionMemory
+}
+
+// NewMemoryManager creates a memory manager. Negative limits are treated
+// as unlimited.
+func NewMemoryManager(cfg MemoryConfig) *MemoryManager {
+	if cfg.Limit < 0 {
+		cfg.Limit = 0
+	}
+	if cfg.SessionLimit < 0 {
+		cfg.SessionLimit = 0
+	}
+	return &MemoryManager{
+		config:   cfg,
+		sessions: make(map[string]*sessionMemory),
+	}
+}
+
+// Config returns the memory limits.
+func (m *MemoryManager) Config() MemoryConfig {
+	return m.config
+}
+
+// Begin starts accounting for an execution in sessionID. The returned
+// tracker must be ended once the execution finishes.
+func (m *MemoryManager) Begin(sessionID string) *MemoryTracker {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		s = &sessionMemory{}
+		m.sessions[sessionID] = s
+	}
+	s.executions++
+	return &MemoryTracker{manager: m, sessionID: sessionID, session: s}
+}
+
+// Stats returns a snapshot of memory usage, sessions ordered by usage.
+func (m *MemoryManager) Stats() MemoryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := MemoryStats{
+		Limit:        m.config.Limit,
+		SessionLimit: m.config.SessionLimit,
+		Used:         m.used,
+		Peak:         m.peak,
+		Rejected:     m.rejected,
+	}
+	for id, s := range m.sessions {
+		stats.Sessions = append(stats.Sessions, SessionMemory{
+			SessionID:  id,
+			Used:       s.used,
+			Peak:       s.peak,
+			Executions: s.executions,
+		})
+	}
+	sort.Slice(stats.Sessions, func(i, j int) bool {
+		if stats.Sessions[i].Used != stats.Sessions[j].Used {
+			return stats.Sessions[i].Used > stats.Sessions[j].Used
+		}
+		return stats.Sessions[i].SessionID < stats.Sessions[j].SessionID
+	})
+	return stats
+}
+
+// MemoryTracker is the memory account of one execution. It implements
+// tsqlruntime.MemoryBudget.
+type MemoryTracker struct {
+	manager   *MemoryManager
+	sessionID string
+	session   *sessionMemory
+	held      int64 // Guarded by manager.mu
+}
+
+// Reserve charges n bytes to the execution, failing with error 701 when
+// the session or server budget would be exceeded.
+func (t *MemoryTracker) Reserve(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	m := t.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if limit := m.config.SessionLimit; limit > 0 && t.session.used+n > limit {
+		m.rejected++
+		return t.exhausted(fmt.Sprintf("session %s holds %s of its %s budget",
+			t.sessionID, formatBytes(t.session.used), formatBytes(limit)))
+	}
+	if limit := m.config.Limit; limit > 0 && m.used+n > limit {
+		m.rejected++
+		return t.exhausted(fmt.Sprintf("the server holds %s of its %s budget",
+			formatBytes(m.used), formatBytes(limit)))
+	}
+
+	t.held += n
+	t.session.used += n
+	if t.session.used > t.session.peak {
+		t.session.peak = t.session.used
+	}
+	m.used += n
+	if m.used > m.peak {
+		m.peak = m.used
+	}
+	return nil
+}
+
+// Release returns n bytes no longer held by the execution.
+func (t *MemoryTracker) Release(n int64) {
+	m := t.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.release(n)
+}
+
+// End releases everything the execution still holds. Its result sets and
+// temp objects are no longer reachable once it returns.
+func (t *MemoryTracker) End() {
+	m := t.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.release(t.held)
+	t.session.executions--
+	if t.session.executions == 0 {
+		delete(m.sessions, t.sessionID)
+	}
+}
+
+// release returns up to n held bytes. Caller holds manager.mu.
+func (t *MemoryTracker) release(n int64) {
+	if n > t.held {
+		n = t.held
+	}
+	if n <= 0 {
+		return
+	}
+	t.held -= n
+	t.session.used -= n
+	t.manager.used -= n
+}
+
+func (t *MemoryTracker) exhausted(reason string) error {
+	return aulerrors.Newf(aulerrors.ErrCodeResourceExhausted,
+		"There is insufficient system memory in resource pool 'default' to run this query (%s).", reason).
+		WithOp("MemoryTracker.Reserve").
+		WithField("session_id", t.sessionID).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(MemoryErrorNumber)).
+		WithField(aulerrors.FieldSQLSeverity, uint8(17)).
+		WithField(aulerrors.FieldSQLState, MemorySQLState).
+		Err()
+}
+
+// formatBytes renders a byte count with a binary unit suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+func TestMemoryTracksUsage(t *testing.T) {
+	m := NewMemoryManager(MemoryConfig{})
+	a := m.Begin("s1")
+	b := m.Begin("s1")
+	c := m.Begin("s2")
+
+	for _, tr := range []*MemoryTracker{a, b, c} {
+		if err := tr.Reserve(1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Release(400)
+
+	st := m.Stats()
+	if st.Used != 2600 || st.Peak != 3000 {
+		t.Errorf("used = %d peak = %d, want 2600 and 3000", st.Used, st.Peak)
+	}
+	if len(st.Sessions) != 2 || st.Sessions[0].SessionID != "s1" || st.Sessions[0].Used != 1600 || st.Sessions[0].Executions != 2 {
+		t.Fatalf("unexpected sessions: %+v", st.Sessions)
+	}
+
+	// Ending an execution returns whatever it still holds
+	a.End()
+	b.End()
+	st = m.Stats()
+	if st.Used != 1000 || len(st.Sessions) != 1 || st.Sessions[0].SessionID != "s2" {
+		t.Errorf("unexpected stats after end: %+v", st)
+	}
+	c.End()
+	if st := m.Stats(); st.Used != 0 || len(st.Sessions) != 0 {
+		t.Errorf("memory leaked: %+v", st)
+	}
+}
+
+func TestMemorySessionLimit(t *testing.T) {
+	m := NewMemoryManager(MemoryConfig{SessionLimit: 1000})
+	a := m.Begin("s1")
+	defer a.End()
+	b := m.Begin("s1")
+	defer b.End()
+	other := m.Begin("s2")
+	defer other.End()
+
+	if err := a.Reserve(600); err != nil {
+		t.Fatal(err)
+	}
+	// The budget is shared by the session's executions
+	err := b.Reserve(600)
+	if !aulerrors.IsCode(err, aulerrors.ErrCodeResourceExhausted) {
+		t.Fatalf("err = %v, want resource exhausted", err)
+	}
+	if !strings.Contains(err.Error(), "insufficient system memory") || !strings.Contains(err.Error(), "session s1") {
+		t.Errorf("unexpected message: %v", err)
+	}
+	fields := aulerrors.GetFields(err)
+	if fields[aulerrors.FieldSQLErrorNumber] != int32(MemoryErrorNumber) || fields[aulerrors.FieldSQLState] != MemorySQLState {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	if err := other.Reserve(600); err != nil {
+		t.Errorf("other session refused: %v", err)
+	}
+	if st := m.Stats(); st.Rejected != 1 || st.Used != 1200 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestMemoryServerLimit(t *testing.T) {
+	m := NewMemoryManager(MemoryConfig{Limit: 1000})
+	a := m.Begin("s1")
+	defer a.End()
+	b := m.Begin("s2")
+	defer b.End()
+
+	if err := a.Reserve(800); err != nil {
+		t.Fatal(err)
+	}
+	err := b.Reserve(300)
+	if !aulerrors.IsCode(err, aulerrors.ErrCodeResourceExhausted) || !strings.Contains(err.Error(), "the server holds") {
+		t.Fatalf("err = %v, want server budget exhausted", err)
+	}
+	a.Release(800)
+	if err := b.Reserve(300); err != nil {
+		t.Errorf("reserve after release: %v", err)
+	}
+}
+
+func TestMemoryReleaseIsClamped(t *testing.T) {
+	m := NewMemoryManager(MemoryConfig{})
+	a := m.Begin("s1")
+	a.Reserve(100)
+	a.Release(500)
+	b := m.Begin("s1")
+	b.Reserve(50)
+	if st := m.Stats(); st.Used != 50 {
+		t.Errorf("used = %d, want 50", st.Used)
+	}
+	a.End()
+	b.End()
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		512:        "512 B",
+		2048:       "2.0 KiB",
+		64 << 20:   "64.0 MiB",
+		3 << 30:    "3.0 GiB",
+		1536 << 20: "1.5 GiB",
+	}
+	for n, want := range tests {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// PanicErrorNumber is the SQL error number reported when an execution
+// panicked: SQL Server's failed assertion check, raised at severity 20,
+// which clients treat as fatal to the connection.
+const PanicErrorNumber = 3624
+
+// PanicSeverity is the severity of the error reported for a panic.
+const PanicSeverity = 20
+
+// PanicSQLState is the PostgreSQL SQLSTATE for a panic (internal_error).
+const PanicSQLState = "XX000"
+
+// PanicError returns the error a client is sent when op panicked. The
+// panic value is kept for the logs; the client is told only that the
+// command failed and its results are not to be trusted.
+func PanicError(op string, value interface{}) error {
+	return aulerrors.New(aulerrors.ErrCodePanic,
+		"A severe error occurred on the current command. The results, if any, should be discarded.").
+		WithOp(op).
+		WithField("panic", fmt.Sprint(value)).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(PanicErrorNumber)).
+		WithField(aulerrors.FieldSQLSeverity, uint8(PanicSeverity)).
+		WithField(aulerrors.FieldSQLState, PanicSQLState).
+		Critical().
+		Err()
+}
+
+// RecordPanic counts a recovered panic and logs it with the stack it was
+// raised from.
+func (r *Runtime) RecordPanic(ctx context.Context, op string, value interface{}, stack []byte) {
+	atomic.AddInt64(&r.panics, 1)
+	r.logger.System().WithContext(ctx).Error("recovered from panic", fmt.Errorf("%v", value),
+		"op", op,
+		"stack", string(stack),
+	)
+}
+
+// recoverPanic is deferred by an execution to turn a panic into the error
+// it returns, so that a bug loses one statement rather than the server.
+func (r *Runtime) recoverPanic(ctx context.Context, op string, err *error) {
+	if v := recover(); v != nil {
+		r.RecordPanic(ctx, op, v, debug.Stack())
+		*err = PanicError(op, v)
+	}
+}
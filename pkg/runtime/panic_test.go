@@ -0,0 +1,56 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+// panickingStorage panics on every query.
+type panickingStorage struct {
+	*MemoryStorage
+}
+
+func (panickingStorage) Query(ctx context.Context, sql string, args ...interface{}) ([]ResultSet, error) {
+	panic("index out of range")
+}
+
+func TestExecutePanic(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := DefaultConfig()
+	cfg.JITEnabled = false
+	r := New(cfg, procedure.NewRegistry(), log.New(log.Config{Output: &buf}))
+	r.SetStorage(panickingStorage{NewMemoryStorage()})
+
+	ctx := log.WithRequestID(context.Background(), "req-1")
+	_, err := r.ExecuteSQL(ctx, "SELECT name FROM sys.tables", &ExecContext{SessionID: "s1"})
+	if !aulerrors.IsCode(err, aulerrors.ErrCodePanic) {
+		t.Fatalf("err = %v, want a panic error", err)
+	}
+	sqlErr := aulerrors.FindSQLError(err)
+	if sqlErr == nil || sqlErr.Fields[aulerrors.FieldSQLSeverity] != uint8(PanicSeverity) {
+		t.Errorf("err = %+v, want severity %d", sqlErr, PanicSeverity)
+	}
+	if strings.Contains(err.Error(), "index out of range") {
+		t.Errorf("client error %q shows the panic", err)
+	}
+
+	if n := r.Stats().Panics; n != 1 {
+		t.Errorf("panics = %d, want 1", n)
+	}
+	logged := buf.String()
+	if !strings.Contains(logged, "recovered from panic") || !strings.Contains(logged, "request_id=req-1") ||
+		!strings.Contains(logged, "panic_test.go") {
+		t.Errorf("log lacks the panic, request or stack:\n%s", logged)
+	}
+
+	// The runtime is still usable
+	if _, err := r.ExecuteSQL(ctx, "SELECT 1", &ExecContext{SessionID: "s1"}); err != nil {
+		t.Errorf("after panic: %v", err)
+	}
+}
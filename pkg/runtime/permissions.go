@@ -0,0 +1,156 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ha1tch/aul/pkg/auth"
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/sandbox"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// An execution with EnforcePermissions runs with the permissions of its
+// user, a SQL login: Execute checks EXECUTE on the procedure, and the
+// interpreter checks the statements of ad-hoc SQL and dynamic SQL (see
+// tsqlruntime/permissions.go). Other executions, and those of
+// administrators, are unrestricted, though GRANT, DENY and REVOKE still
+// change the permissions of logins.
+
+// deniedSQLState is the SQLSTATE of permission errors.
+const deniedSQLState = "42501"
+
+// sessionPermissions are the permissions of an execution's user.
+type sessionPermissions struct {
+	permissions *auth.Permissions
+	user        string
+	enforced    bool
+}
+
+func (p sessionPermissions) Administrator() bool {
+	return !p.enforced || p.user == "" || p.permissions.Administrator(p.user)
+}
+
+func (p sessionPermissions) Allowed(permission, object string) bool {
+	return p.permissions.Allowed(p.user, permission, object)
+}
+
+func (p sessionPermissions) Apply(ctx context.Context, db tsqlruntime.QueryExecutor, change tsqlruntime.PermissionChange) error {
+	return principalError(p.permissions.Apply(ctx, db, auth.Change{
+		State:       change.State,
+		Permissions: change.Permissions,
+		Scope:       change.Scope,
+		Name:        change.Name,
+		Principals:  change.Principals,
+	}))
+}
+
+// sessionSandbox is the sandbox as it applies to an execution's user.
+type sessionSandbox struct {
+	sandbox *sandbox.Sandbox
+	user    string
+}
+
+func (s sessionSandbox) Denied(capability, procedure string) (string, string, bool) {
+	return s.sandbox.Denied(capability, s.user, procedure)
+}
+
+func (p sessionPermissions) ChangeRole(ctx context.Context, db tsqlruntime.QueryExecutor, change tsqlruntime.RoleChange) error {
+	var err error
+	switch {
+	case change.Server && change.Action == "ADD MEMBER":
+		err = p.permissions.AddServerRoleMember(ctx, db, change.Role, change.Member)
+	case change.Server && change.Action == "DROP MEMBER":
+		err = p.permissions.DropServerRoleMember(ctx, db, change.Role, change.Member)
+	case change.Server:
+		err = fmt.Errorf("unknown server role change %q", change.Action)
+	case change.Action == "CREATE":
+		err = p.permissions.CreateRole(ctx, db, change.Role)
+	case change.Action == "DROP":
+		err = p.permissions.DropRole(ctx, db, change.Role)
+	case change.Action == "ADD MEMBER":
+		err = p.permissions.AddRoleMember(ctx, db, change.Role, change.Member)
+	case change.Action == "DROP MEMBER":
+		err = p.permissions.DropRoleMember(ctx, db, change.Role, change.Member)
+	default:
+		err = fmt.Errorf("unknown role change %q", change.Action)
+	}
+	return principalError(err)
+}
+
+// principalError returns SQL Server's error for a login or role that
+// does not exist, or a role that cannot be created or dropped.
+func principalError(err error) error {
+	var pe *auth.PrincipalError
+	if !errors.As(err, &pe) {
+		return err
+	}
+	switch pe.Err {
+	case auth.ErrPrincipalExists:
+		return tsqlruntime.NewSQLError(tsqlruntime.ErrPrincipalExists,
+			fmt.Sprintf("User, group, or role '%s' already exists in the current database.", pe.Name))
+	case auth.ErrRoleHasMembers:
+		return tsqlruntime.NewSQLError(tsqlruntime.ErrRoleHasMembers,
+			"The role has members. It must be empty before it can be dropped.")
+	case auth.ErrFixedRole:
+		return tsqlruntime.NewSQLError(tsqlruntime.ErrPrincipalNotFound,
+			fmt.Sprintf("Cannot drop the role '%s', because it does not exist or you do not have permission.", pe.Name))
+	}
+	return tsqlruntime.NewSQLError(tsqlruntime.ErrPrincipalNotFound,
+		fmt.Sprintf("Cannot find the user or role '%s', because it does not exist or you do not have permission.", pe.Name))
+}
+
+// checkExecute returns error 229 if the execution's user may not
+// EXECUTE proc.
+func (r *Runtime) checkExecute(proc *procedure.Procedure, execCtx *ExecContext) error {
+	p := r.Permissions()
+	if p == nil || !execCtx.EnforcePermissions || execCtx.User == "" {
+		return nil
+	}
+	if !p.Allowed(execCtx.User, "EXECUTE", proc.QualifiedName()) {
+		return deniedError(tsqlruntime.PermissionDenied("EXECUTE", proc.QualifiedName(), execCtx.Database))
+	}
+	return nil
+}
+
+// checkCatalogReads returns error 229 if the execution's user, its
+// permissions checked, may not SELECT one of tables, read beside the
+// system catalog by a query the storage layer answers.
+func (i *interpreter) checkCatalogReads(tables []string, execCtx *ExecContext) error {
+	if i.permissions == nil {
+		return nil
+	}
+	p := sessionPermissions{permissions: i.permissions, user: execCtx.User, enforced: execCtx.EnforcePermissions}
+	if p.Administrator() {
+		return nil
+	}
+	for _, table := range tables {
+		if !p.Allowed("SELECT", table) {
+			return deniedError(tsqlruntime.PermissionDenied("SELECT", table, execCtx.Database))
+		}
+	}
+	return nil
+}
+
+// deniedError returns a permission error of the interpreter with the SQL
+// error number and SQLSTATE clients are sent, as the firewall returns its
+// denials, or nil for other errors.
+func deniedError(err error) error {
+	var sqlErr *tsqlruntime.SQLError
+	if !errors.As(err, &sqlErr) {
+		return nil
+	}
+	switch sqlErr.Number {
+	case tsqlruntime.ErrPermissionDenied, tsqlruntime.ErrDatabasePermission, tsqlruntime.ErrNoPermission,
+		tsqlruntime.ErrSandboxDenied, tsqlruntime.ErrCommandDenied:
+	default:
+		return nil
+	}
+	return aulerrors.New(aulerrors.ErrCodeExecDenied, sqlErr.Message).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(sqlErr.Number)).
+		WithField(aulerrors.FieldSQLSeverity, uint8(sqlErr.Severity)).
+		WithField(aulerrors.FieldSQLState, deniedSQLState).
+		Err()
+}
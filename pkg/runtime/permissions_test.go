@@ -0,0 +1,293 @@
+package runtime_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/auth"
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	pkglog "github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/storage"
+)
+
+func TestPermissions(t *testing.T) {
+	ctx := context.Background()
+	backend, err := storage.NewSQLiteStorage(storage.DefaultSQLiteConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	db := backend.GetDB()
+	if _, err := db.Exec("CREATE TABLE orders (id INT, qty INT); INSERT INTO orders VALUES (1, 5)"); err != nil {
+		t.Fatal(err)
+	}
+	logins, err := auth.NewStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := logins.Provision(ctx, []auth.Login{{Name: "app", Password: "s3cret"}, {Name: "sa", Password: "s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+	permissions, err := auth.NewPermissions(ctx, logins, []string{"sa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := procedure.NewRegistry()
+	proc := &procedure.Procedure{
+		Name:   "GetOrders",
+		Schema: "dbo",
+		Source: "CREATE PROCEDURE dbo.GetOrders AS SELECT qty FROM orders",
+	}
+	registry.Register(proc)
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, registry, pkglog.New(pkglog.Config{DefaultLevel: pkglog.LevelError}))
+	rt.SetStorage(backend)
+	rt.SetPermissions(permissions)
+
+	app := &runtime.ExecContext{SessionID: "app", User: "app", EnforcePermissions: true}
+	admin := &runtime.ExecContext{SessionID: "sa", User: "sa", EnforcePermissions: true}
+	wantDenied := func(what string, err error, number int32) {
+		t.Helper()
+		sqlErr := aulerrors.FindSQLError(err)
+		if sqlErr == nil || sqlErr.Code != aulerrors.ErrCodeExecDenied ||
+			sqlErr.Fields[aulerrors.FieldSQLErrorNumber] != number || sqlErr.Fields[aulerrors.FieldSQLState] != "42501" {
+			t.Errorf("%s: got %v, want error %d", what, err, number)
+		}
+	}
+
+	_, err = rt.Execute(ctx, proc, app)
+	wantDenied("EXEC before GRANT", err, 229)
+	if err != nil && err.Error() != "E4012: The EXECUTE permission was denied on the object 'GetOrders', database 'master', schema 'dbo'." {
+		t.Errorf("message %q", err)
+	}
+	_, err = rt.ExecuteSQL(ctx, "GRANT EXECUTE ON dbo.GetOrders TO app", app)
+	wantDenied("GRANT by a login", err, 15247)
+
+	// GRANT in a transaction applies at once
+	if _, err := rt.ExecuteSQL(ctx, "BEGIN TRAN; GRANT EXECUTE ON dbo.GetOrders TO app; COMMIT", admin); err != nil {
+		t.Fatal(err)
+	}
+	result, err := rt.Execute(ctx, proc, app)
+	if err != nil || len(result.ResultSets) != 1 || len(result.ResultSets[0].Rows) != 1 {
+		t.Fatalf("EXEC after GRANT: %+v, %v", result, err)
+	}
+
+	// The procedure may read orders; the login may not
+	_, err = rt.ExecuteSQL(ctx, "SELECT qty FROM orders", app)
+	wantDenied("SELECT", err, 229)
+	_, err = rt.ExecuteSQL(ctx, "CREATE TABLE notes (id INT)", app)
+	wantDenied("CREATE TABLE", err, 262)
+
+	// Unless permissions are not enforced for the session
+	if _, err := rt.ExecuteSQL(ctx, "SELECT qty FROM orders", &runtime.ExecContext{SessionID: "app", User: "app"}); err != nil {
+		t.Errorf("not enforced: %v", err)
+	}
+
+	_, err = rt.ExecuteSQL(ctx, "GRANT SELECT ON orders TO nobody", admin)
+	if err == nil || !strings.Contains(err.Error(), "Msg 15151,") {
+		t.Errorf("GRANT to an unknown principal: %v", err)
+	}
+
+	// Fixed roles: db_datareader reads every table, sysadmin does anything
+	if _, err := rt.ExecuteSQL(ctx, "ALTER ROLE db_datareader ADD MEMBER app", admin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.ExecuteSQL(ctx, "SELECT qty FROM orders", app); err != nil {
+		t.Errorf("SELECT as db_datareader: %v", err)
+	}
+	_, err = rt.ExecuteSQL(ctx, "DELETE FROM orders", app)
+	wantDenied("DELETE as db_datareader", err, 229)
+	if _, err := rt.ExecuteSQL(ctx, "ALTER SERVER ROLE sysadmin ADD MEMBER app", admin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.ExecuteSQL(ctx, "CREATE TABLE notes (id INT)", app); err != nil {
+		t.Errorf("CREATE TABLE as sysadmin: %v", err)
+	}
+	_, err = rt.ExecuteSQL(ctx, "DROP ROLE db_datareader", app)
+	if err == nil || !strings.Contains(err.Error(), "Cannot drop the role 'db_datareader'") {
+		t.Errorf("DROP ROLE of a fixed role: %v", err)
+	}
+}
+
+// internalTablesRuntime returns a runtime over a fresh SQLite backend
+// enforcing the permissions of the logins app and sa, an administrator,
+// with procs registered.
+func internalTablesRuntime(t *testing.T, procs ...*procedure.Procedure) (*runtime.Runtime, *auth.Store) {
+	t.Helper()
+	ctx := context.Background()
+	backend, err := storage.NewSQLiteStorage(storage.DefaultSQLiteConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	db := backend.GetDB()
+	if _, err := db.Exec("CREATE TABLE orders (id INT, qty INT)"); err != nil {
+		t.Fatal(err)
+	}
+	logins, err := auth.NewStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := logins.Provision(ctx, []auth.Login{{Name: "app", Password: "s3cret"}, {Name: "sa", Password: "s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+	permissions, err := auth.NewPermissions(ctx, logins, []string{"sa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := procedure.NewRegistry()
+	for _, proc := range procs {
+		registry.Register(proc)
+	}
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, registry, pkglog.New(pkglog.Config{DefaultLevel: pkglog.LevelError}))
+	rt.SetStorage(backend)
+	rt.SetPermissions(permissions)
+	return rt, logins
+}
+
+// wantError229 checks err is error 229.
+func wantError229(t *testing.T, sql string, err error) {
+	t.Helper()
+	if sqlErr := aulerrors.FindSQLError(err); sqlErr == nil || sqlErr.Fields[aulerrors.FieldSQLErrorNumber] != int32(229) {
+		t.Errorf("%s: got %v, want error 229", sql, err)
+	}
+}
+
+func TestInternalTablesDenied(t *testing.T) {
+	ctx := context.Background()
+	rt, logins := internalTablesRuntime(t)
+	app := &runtime.ExecContext{SessionID: "app", User: "app", EnforcePermissions: true}
+	admin := &runtime.ExecContext{SessionID: "sa", User: "sa", EnforcePermissions: true}
+	if _, err := rt.ExecuteSQL(ctx, "GRANT INSERT, SELECT ON SCHEMA::dbo TO app; GRANT INSERT TO app", admin); err != nil {
+		t.Fatal(err)
+	}
+
+	// No grant on the schema or database reaches aul's own tables
+	for _, sql := range []string{
+		"INSERT INTO aul_role_members (role, member) VALUES ('sysadmin', 'app')",
+		"INSERT INTO dbo.[AUL_ROLE_MEMBERS] (role, member) VALUES ('sysadmin', 'app')",
+		"UPDATE aul_permissions SET state = 'GRANT' WHERE principal = 'app'",
+		"SELECT * FROM aul_roles",
+	} {
+		_, err := rt.ExecuteSQL(ctx, sql, app)
+		wantError229(t, sql, err)
+	}
+	reloaded, err := auth.NewPermissions(ctx, logins, []string{"sa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Administrator("app") {
+		t.Error("app made itself sysadmin")
+	}
+}
+
+func TestCredentialTableDenied(t *testing.T) {
+	ctx := context.Background()
+	rt, logins := internalTablesRuntime(t)
+	admin := &runtime.ExecContext{SessionID: "sa", User: "sa", EnforcePermissions: true}
+	if _, err := rt.ExecuteSQL(ctx, "ALTER ROLE db_datareader ADD MEMBER app; ALTER ROLE db_datawriter ADD MEMBER app", admin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody reads or writes the logins: not a reader or writer, not an
+	// administrator, not a session whose permissions are not checked
+	sessions := map[string]*runtime.ExecContext{
+		"db_datareader and db_datawriter": {SessionID: "app", User: "app", EnforcePermissions: true},
+		"administrator":                   admin,
+		"not enforced":                    {SessionID: "app", User: "app"},
+	}
+	for who, session := range sessions {
+		for _, sql := range []string{
+			"SELECT name, password_hash FROM aul_logins",
+			"SELECT o.id FROM orders o JOIN master.dbo.[AUL_LOGINS] l ON l.name = 'sa'",
+			"UPDATE aul_logins SET password_hash = 'x' WHERE name = 'sa'",
+			"DELETE FROM aul_logins",
+			"INSERT INTO orders (id) SELECT COUNT(*) FROM aul_logins",
+			"DROP TABLE aul_logins",
+			"EXEC ('SELECT * FROM aul_logins')",
+			"SELECT name, password_hash FROM aul_logins /* sys. */",
+			"SELECT l.password_hash FROM sys.tables t JOIN aul_logins l ON 1 = 1",
+		} {
+			_, err := rt.ExecuteSQL(ctx, sql, session)
+			wantError229(t, who+": "+sql, err)
+		}
+	}
+	if err := logins.Authenticate(ctx, "sa", "s3cret"); err != nil {
+		t.Errorf("sa's password no longer works: %v", err)
+	}
+
+	// The catalog does not list the table, nor the hashes
+	result, err := rt.ExecuteSQL(ctx, "SELECT name FROM sys.tables", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rs := range result.ResultSets {
+		for _, row := range rs.Rows {
+			if strings.EqualFold(fmt.Sprint(row[0]), auth.LoginTable) {
+				t.Errorf("sys.tables lists %s", auth.LoginTable)
+			}
+		}
+	}
+	result, err = rt.ExecuteSQL(ctx, "SELECT * FROM sys.server_principals", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := result.ResultSets[len(result.ResultSets)-1]
+	if len(rs.Rows) == 0 {
+		t.Error("sys.server_principals lists no logins")
+	}
+	for _, column := range rs.Columns {
+		if strings.Contains(strings.ToLower(column.Name), "password") {
+			t.Errorf("sys.server_principals has column %s", column.Name)
+		}
+	}
+}
+
+func TestCatalogQueriesChecked(t *testing.T) {
+	ctx := context.Background()
+	rt, logins := internalTablesRuntime(t)
+	app := &runtime.ExecContext{SessionID: "app", User: "app", EnforcePermissions: true}
+	admin := &runtime.ExecContext{SessionID: "sa", User: "sa", EnforcePermissions: true}
+
+	// Naming the catalog does not send a statement past the permission
+	// checks to the storage layer
+	for _, sql := range []string{
+		"DELETE FROM orders -- sys.",
+		"INSERT INTO aul_role_members (role, member) VALUES ('sysadmin', 'app') -- sys.tables",
+		"SELECT * FROM aul_roles, sys.tables",
+		"SELECT qty FROM orders WHERE id IN (SELECT object_id FROM sys.objects)",
+		"SELECT name INTO dbo.stolen FROM information_schema.tables",
+	} {
+		_, err := rt.ExecuteSQL(ctx, sql, app)
+		if sqlErr := aulerrors.FindSQLError(err); sqlErr == nil || sqlErr.Code != aulerrors.ErrCodeExecDenied {
+			t.Errorf("%s: got %v, want a permission error", sql, err)
+		}
+	}
+	reloaded, err := auth.NewPermissions(ctx, logins, []string{"sa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Administrator("app") {
+		t.Error("app made itself sysadmin")
+	}
+
+	// The catalog itself needs no grant, and tables read beside it their own
+	if _, err := rt.ExecuteSQL(ctx, "SELECT name FROM sys.tables", app); err != nil {
+		t.Errorf("sys.tables: %v", err)
+	}
+	if _, err := rt.ExecuteSQL(ctx, "GRANT SELECT ON dbo.orders TO app", admin); err != nil {
+		t.Fatal(err)
+	}
+	sql := "SELECT qty FROM orders WHERE id IN (SELECT object_id FROM sys.objects)"
+	if _, err := rt.ExecuteSQL(ctx, sql, app); aulerrors.FindSQLError(err) != nil {
+		t.Errorf("%s with SELECT granted: %v", sql, err)
+	}
+}
@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Each execution is a request while it runs, listed with the statement
+// it is running in sys.dm_exec_requests. Long-running statements, such
+// as a CREATE INDEX that rebuilds its table, report how far they have
+// got, which the view shows as percent_complete, with an estimate of
+// the time left drawn from the rate so far.
+
+// RequestTracker lists the running executions.
+type RequestTracker struct {
+	mu      sync.Mutex
+	next    int64
+	running map[int64]*RunningRequest
+}
+
+// NewRequestTracker creates an empty request tracker.
+func NewRequestTracker() *RequestTracker {
+	return &RequestTracker{running: make(map[int64]*RunningRequest)}
+}
+
+// Begin records an execution in sessionID against database, running
+// text, a procedure name or batch, as command. The request must be ended
+// once the execution finishes.
+func (t *RequestTracker) Begin(sessionID, database, command, text string) *RunningRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	r := &RunningRequest{
+		tracker:   t,
+		id:        t.next,
+		sessionID: sessionID,
+		database:  database,
+		text:      text,
+		start:     time.Now(),
+		execution: command,
+		command:   command,
+	}
+	t.running[r.id] = r
+	return r
+}
+
+// Requests returns the running executions, oldest first.
+func (t *RequestTracker) Requests() []Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	requests := make([]Request, 0, len(t.running))
+	for _, r := range t.running {
+		req := Request{
+			ID:              r.id,
+			SessionID:       r.sessionID,
+			Database:        r.database,
+			Command:         r.command,
+			Text:            r.text,
+			Start:           r.start,
+			PercentComplete: r.percent,
+		}
+		if r.percent > 0 && r.percent < 100 {
+			elapsed := now.Sub(r.progressStart)
+			req.EstimatedRemaining = time.Duration(float64(elapsed) * (100 - r.percent) / r.percent)
+		}
+		requests = append(requests, req)
+	}
+	slices.SortFunc(requests, func(a, b Request) int { return int(a.ID - b.ID) })
+	return requests
+}
+
+// Request is a snapshot of a running execution.
+type Request struct {
+	ID                 int64
+	SessionID          string
+	Database           string
+	Command            string // EXECUTE, SQL BATCH, or the statement reporting progress
+	Text               string
+	Start              time.Time
+	PercentComplete    float64       // 0 unless the statement reports progress
+	EstimatedRemaining time.Duration // 0 when not known
+}
+
+// RunningRequest is one running execution. It implements
+// tsqlruntime.ProgressReporter.
+type RunningRequest struct {
+	tracker   *RequestTracker
+	id        int64
+	sessionID string
+	database  string
+	text      string
+	start     time.Time
+	execution string // Command while no statement reports progress
+
+	// Guarded by tracker.mu
+	command       string
+	percent       float64
+	progressStart time.Time // When the statement reporting progress began
+}
+
+// ReportProgress records that the execution's statement, command, is
+// percent complete. At 100 the execution goes back to its own command.
+func (r *RunningRequest) ReportProgress(command string, percent float64) {
+	r.tracker.mu.Lock()
+	defer r.tracker.mu.Unlock()
+	switch {
+	case percent >= 100:
+		r.command, r.percent = r.execution, 0
+	case r.command != command:
+		r.command, r.percent, r.progressStart = command, percent, time.Now()
+	default:
+		r.percent = percent
+	}
+}
+
+// End removes the request once its execution finishes.
+func (r *RunningRequest) End() {
+	r.tracker.mu.Lock()
+	defer r.tracker.mu.Unlock()
+	delete(r.tracker.running, r.id)
+}
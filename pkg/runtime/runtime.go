@@ -10,15 +10,24 @@ package runtime
 
 import (
 	"context"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/ha1tch/aul/pkg/jit"
 	"github.com/ha1tch/aul/pkg/jit/abi"
+	"github.com/ha1tch/aul/pkg/archive"
+	"github.com/ha1tch/aul/pkg/auth"
+	"github.com/ha1tch/aul/pkg/cmdshell"
 	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/features"
 	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/mail"
+	"github.com/ha1tch/aul/pkg/notify"
 	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/sandbox"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
 )
 
 // Runtime manages procedure execution.
@@ -40,16 +49,67 @@ type Runtime struct {
 	activeExecs   int64 // Atomic counter
 	totalExecs    int64 // Atomic counter
 	totalTimeNs   int64 // Atomic counter
-	execSemaphore chan struct{}
+	panics        int64 // Atomic counter of recovered panics
+	admission     *Admission
 
 	// Interpreter instance (reused across executions)
 	interpreterPool sync.Pool
+
+	// Per-procedure circuit breakers (nil when disabled)
+	breakers *BreakerSet
+
+	// Deprecated procedures and who calls them
+	deprecations *Deprecations
+
+	// Running calls of procedures with a concurrency limit
+	limits *ProcedureLimits
+
+	// Memory held by buffered rows of running executions
+	memory *MemoryManager
+
+	// Statement journal for crash recovery (nil when disabled)
+	journal *Journal
+
+	// Candidates of changed procedures running in shadow (nil when disabled)
+	shadow *ShadowSet
+
+	// Feature flags read by FEATURE() (nil = all off)
+	features *features.Flags
+
+	// Mail queued by sp_send_dbmail (nil = Database Mail stopped)
+	mail *mail.Mailer
+
+	// Commands xp_cmdshell may run (nil = xp_cmdshell turned off)
+	shell *cmdshell.Shell
+
+	// Permissions of SQL logins (nil = no permission model)
+	permissions *auth.Permissions
+
+	// Capabilities denied to procedures' statements (nil = none)
+	sandbox *sandbox.Sandbox
+
+	// Archived partitions of archival tables (nil when none are archival)
+	archive *archive.Archiver
+
+	// Table locks of the transactions of running executions
+	locks *LockManager
+
+	// Missing index recommendations drawn from the workload (nil when off)
+	advisor *IndexAdvisor
+
+	// Pinned tables served from memory (nil when none are pinned)
+	tableCache *tsqlruntime.TableCache
+
+	// Running executions, for sys.dm_exec_requests
+	requests *RequestTracker
 }
 
 // Config holds runtime configuration.
 type Config struct {
 	// Dialect settings
-	DefaultDialect string
+	DefaultDialect      string
+	LegacySQLNormalizer bool // Also run the deprecated regex normaliser
+	AutoParameterize    bool // Send the literals queries compare with as parameters
 
 	// JIT compilation
 	JITEnabled   bool
@@ -57,12 +117,44 @@ type Config struct {
 
 	// Concurrency
 	MaxConcurrency int
+	Admission      AdmissionConfig // Queueing beyond MaxConcurrency
 
 	// Execution limits
 	ExecTimeout    time.Duration
 	MaxResultRows  int
 	MaxResultSets  int
-	MaxNestingLevel int
+	MaxNestingLevel int // Procedure call depth (0 = SQL Server's 32)
+	NumbersSize     int // Rows of the built-in numbers tables on SQLite (0 = tsqlruntime.DefaultNumbersSize)
+
+	// Circuit breakers
+	Breaker BreakerConfig
+
+	// Memory budgets for result sets and temp objects
+	Memory MemoryConfig
+
+	// What to do when results break a procedure's result contract
+	Contracts ContractMode
+
+	// Warn callers of deprecated procedures, besides logging the calls
+	DeprecationWarnings bool
+
+	// Shadow execution of changed procedures
+	Shadow ShadowConfig
+
+	// Endpoints sp_invoke_external_rest_endpoint may call
+	REST tsqlruntime.RESTPolicy
+
+	// Whether sp_execute_script may run scripts, and for how long
+	Scripts tsqlruntime.ScriptPolicy
+
+	// Missing index recommendations from the workload
+	IndexAdvisor IndexAdvisorConfig
+
+	// Small reference tables cached in memory
+	TableCache tsqlruntime.TableCacheConfig
+
+	// Name procedures see in @@SERVERNAME (empty = "aul")
+	ServerName string
 
 	// Logging
 	LogQueriesRewritten bool // Log queries after rewriting
@@ -75,22 +167,36 @@ func DefaultConfig() Config {
 		JITEnabled:      true,
 		JITThreshold:    100,
 		MaxConcurrency:  100,
+		Admission:       DefaultAdmissionConfig(),
 		ExecTimeout:     30 * time.Second,
 		MaxResultRows:   100000,
 		MaxResultSets:   100,
-		MaxNestingLevel: 32,
+		MaxNestingLevel: tsqlruntime.MaxNestingLevel,
+		Breaker:         DefaultBreakerConfig(),
+		Contracts:       ContractsWarn,
+		Shadow:          DefaultShadowConfig(),
+		IndexAdvisor:    DefaultIndexAdvisorConfig(),
 	}
 }
 
 // New creates a new runtime.
 func New(cfg Config, registry *procedure.Registry, logger *log.Logger) *Runtime {
+	if cfg.MaxNestingLevel <= 0 {
+		cfg.MaxNestingLevel = tsqlruntime.MaxNestingLevel
+	}
 	r := &Runtime{
 		config:        cfg,
 		logger:        logger,
 		registry:      registry,
-		execSemaphore: make(chan struct{}, cfg.MaxConcurrency),
+		admission:     NewAdmission(cfg.MaxConcurrency, cfg.Admission),
+		memory:        NewMemoryManager(cfg.Memory),
+		locks:         NewLockManager(logger),
+		requests:      NewRequestTracker(),
+		deprecations:  NewDeprecations(cfg.DeprecationWarnings, logger),
 	}
 
+	r.limits = NewProcedureLimits(r.admission.config.QueueTimeout)
+
 	// Initialise JIT manager if enabled
 	if cfg.JITEnabled {
 		r.jitManager = jit.NewManager(jit.Config{
@@ -105,10 +211,55 @@ func New(cfg Config, registry *procedure.Registry, logger *log.Logger) *Runtime
 		)
 	}
 
+	if cfg.Breaker.Enabled {
+		r.breakers = NewBreakerSet(cfg.Breaker, logger)
+		logger.System().Info("circuit breakers enabled",
+			"error_rate", r.breakers.Config().ErrorRate,
+			"slow_call", r.breakers.Config().SlowCall,
+			"cooldown", r.breakers.Config().Cooldown,
+		)
+	}
+
+	if cfg.Shadow.Enabled {
+		r.shadow = NewShadowSet(cfg.Shadow, registry, logger)
+		logger.System().Info("shadow execution enabled",
+			"sample_rate", r.shadow.Config().SampleRate,
+			"promote_after", r.shadow.Config().PromoteAfter,
+		)
+	}
+
+	if cfg.IndexAdvisor.Mode != "" && cfg.IndexAdvisor.Mode != IndexAdvisorOff {
+		r.advisor = NewIndexAdvisor(cfg.IndexAdvisor)
+		logger.System().Info("index advisor enabled",
+			"mode", string(r.advisor.Config().Mode),
+		)
+	}
+
+	if len(cfg.TableCache.Tables) > 0 {
+		r.tableCache = tsqlruntime.NewTableCache(cfg.TableCache)
+		logger.System().Info("pinned tables cached in memory",
+			"tables", cfg.TableCache.Tables,
+			"max_rows", r.tableCache.Config().MaxRows,
+		)
+	}
+
+	if cfg.Memory.Limit > 0 || cfg.Memory.SessionLimit > 0 {
+		logger.System().Info("memory budgets enabled",
+			"limit", formatBytes(cfg.Memory.Limit),
+			"session_limit", formatBytes(cfg.Memory.SessionLimit),
+		)
+	}
+
 	// Initialise interpreter pool
 	r.interpreterPool = sync.Pool{
 		New: func() interface{} {
-			return newInterpreter(cfg, logger, registry)
+			interp := newInterpreter(cfg, logger, registry)
+			interp.breakers = r.breakers
+			interp.deprecations = r.deprecations
+			interp.limits = r.limits
+			interp.advisor = r.advisor
+			interp.tableCache = r.tableCache
+			return interp
 		},
 	}
 
@@ -122,16 +273,188 @@ func (r *Runtime) SetStorage(storage StorageBackend) {
 	r.storage = storage
 }
 
+// SetJournal sets the statement journal that records procedure
+// executions.
+func (r *Runtime) SetJournal(journal *Journal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.journal = journal
+}
+
+// SetNotifier sets the notifier told when a procedure's circuit breaker
+// opens, when a shadow candidate diverges and when a deadlock is broken.
+func (r *Runtime) SetNotifier(n *notify.Notifier) {
+	if r.breakers != nil {
+		r.breakers.SetNotifier(n)
+	}
+	if r.shadow != nil {
+		r.shadow.SetNotifier(n)
+	}
+	r.locks.SetNotifier(n)
+}
+
+// SetFeatures sets the feature flags procedures read with FEATURE().
+func (r *Runtime) SetFeatures(flags *features.Flags) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.features = flags
+}
+
+// Features returns the feature flags, or nil when none are set.
+func (r *Runtime) Features() *features.Flags {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.features
+}
+
+// SetMail sets the mailer sp_send_dbmail queues email with.
+func (r *Runtime) SetMail(m *mail.Mailer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mail = m
+}
+
+// Mail returns the mailer, or nil when mail is not configured.
+func (r *Runtime) Mail() *mail.Mailer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.mail
+}
+
+// SetCommandShell sets the shell running the commands xp_cmdshell is
+// allowed.
+func (r *Runtime) SetCommandShell(s *cmdshell.Shell) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shell = s
+}
+
+// CommandShell returns the shell of xp_cmdshell, or nil when it is turned
+// off.
+func (r *Runtime) CommandShell() *cmdshell.Shell {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.shell
+}
+
+// SetPermissions sets the permissions of SQL logins, checked for
+// executions with EnforcePermissions and changed by GRANT, DENY and
+// REVOKE.
+func (r *Runtime) SetPermissions(p *auth.Permissions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.permissions = p
+}
+
+// Permissions returns the permissions of SQL logins, or nil when there
+// is no permission model.
+func (r *Runtime) Permissions() *auth.Permissions {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.permissions
+}
+
+// SetSandbox sets the policies denying capabilities, such as dynamic SQL
+// and DDL, to the statements executions run.
+func (r *Runtime) SetSandbox(s *sandbox.Sandbox) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sandbox = s
+}
+
+// Sandbox returns the sandbox policies, or nil when there are none.
+func (r *Runtime) Sandbox() *sandbox.Sandbox {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sandbox
+}
+
+// SetArchive sets the archiver of archival tables, whose archived
+// partitions queries over their range read.
+func (r *Runtime) SetArchive(a *archive.Archiver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.archive = a
+}
+
+// Archive returns the archiver of archival tables, or nil when none are
+// archival.
+func (r *Runtime) Archive() *archive.Archiver {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.archive
+}
+
+// Journal returns the statement journal, or nil when it is disabled.
+func (r *Runtime) Journal() *Journal {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.journal
+}
+
+// ReplayInterrupted re-runs the executions the journal recovered at
+// startup with their original parameters. Writes they applied before the
+// crash are applied again, so this suits idempotent procedures only.
+func (r *Runtime) ReplayInterrupted(ctx context.Context) {
+	if journal := r.Journal(); journal != nil {
+		journal.replay(ctx, r)
+	}
+}
+
+// Shadow returns the candidates running in shadow, or nil when shadow
+// execution is disabled.
+func (r *Runtime) Shadow() *ShadowSet {
+	return r.shadow
+}
+
+// Breakers returns the per-procedure circuit breakers, or nil when they
+// are disabled.
+func (r *Runtime) Breakers() *BreakerSet {
+	return r.breakers
+}
+
+// Deprecations returns the deprecated procedures and the calls made to
+// them.
+func (r *Runtime) Deprecations() *Deprecations {
+	return r.deprecations
+}
+
 // Execute runs a procedure.
-func (r *Runtime) Execute(ctx context.Context, proc *procedure.Procedure, execCtx *ExecContext) (*ExecResult, error) {
-	// Acquire semaphore for concurrency limiting
-	select {
-	case r.execSemaphore <- struct{}{}:
-		defer func() { <-r.execSemaphore }()
-	case <-ctx.Done():
-		return nil, ctx.Err()
+func (r *Runtime) Execute(ctx context.Context, proc *procedure.Procedure, execCtx *ExecContext) (result *ExecResult, err error) {
+	// A login not allowed to run the procedure fails before it is counted
+	if err := r.checkExecute(proc, execCtx); err != nil {
+		return nil, err
 	}
 
+	deprecation := r.deprecations.called(ctx, proc, execCtx)
+
+	// Quarantined procedures fail before taking an execution slot
+	if r.breakers != nil {
+		name := proc.QualifiedName()
+		if err := r.breakers.Allow(name); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		defer func() {
+			r.breakers.Record(name, time.Since(start), err)
+		}()
+	}
+
+	// A procedure with a concurrency limit waits for a slot of its own,
+	// or fails, before taking an execution slot
+	releaseProc, err := r.limits.Acquire(ctx, proc)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseProc()
+
+	// Wait for an execution slot
+	release, err := r.admission.Acquire(ctx, execCtx.Priority)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Track execution
 	atomic.AddInt64(&r.activeExecs, 1)
 	defer atomic.AddInt64(&r.activeExecs, -1)
@@ -153,37 +476,68 @@ func (r *Runtime) Execute(ctx context.Context, proc *procedure.Procedure, execCt
 		defer cancel()
 	}
 
-	// Choose execution strategy
-	if proc.JITCompiled && proc.JITCode != nil {
-		return r.executeJIT(ctx, proc, execCtx)
+	// Journal the execution so a crash part way through can be reported
+	var entry *JournalEntry
+	if journal := r.Journal(); journal != nil {
+		entry = journal.Begin(proc.QualifiedName(), "", execCtx)
+		defer func() { entry.End(err) }()
 	}
 
-	// Interpreted execution
-	result, err := r.executeInterpreted(ctx, proc, execCtx)
+	// A panic fails this execution only; deferred last so that the
+	// journal and breaker see the error it becomes
+	defer r.recoverPanic(ctx, "Runtime.Execute", &err)
+
+	// Choose execution strategy. JIT code does not check for writes, nor
+	// record them or its statements, so read-only executions, dry runs
+	// and summarised, traced and covered executions are interpreted.
+	if proc.JITCompiled && proc.JITCode != nil && !execCtx.ReadOnly && !execCtx.DryRun && !execCtx.Summary && execCtx.Trace == nil && execCtx.Coverage == nil {
+		result, err = r.executeJIT(ctx, proc, execCtx)
+	} else {
+		// Interpreted execution
+		result, err = r.executeInterpreted(ctx, proc, execCtx, entry)
+
+		// Check if we should trigger JIT compilation
+		if err == nil && r.config.JITEnabled && !proc.JITCompiled {
+			if int(atomic.LoadInt64(&proc.ExecCount)) >= r.config.JITThreshold {
+				// Trigger async JIT compilation
+				go r.triggerJIT(proc, log.RequestIDFromContext(ctx))
+			}
+		}
+	}
 	if err != nil {
-		return nil, err
+		// Cut short by a timeout or the client
+		if ierr := interrupted(ctx, "Runtime.Execute", execCtx.Timeout); ierr != nil {
+			err = ierr
+		}
 	}
 
-	// Check if we should trigger JIT compilation
-	if r.config.JITEnabled && !proc.JITCompiled {
-		if int(atomic.LoadInt64(&proc.ExecCount)) >= r.config.JITThreshold {
-			// Trigger async JIT compilation
-			go r.triggerJIT(proc)
+	// A sample of calls to a changed procedure run its candidate too
+	if r.shadow != nil {
+		if c := r.shadow.take(proc); c != nil {
+			go r.runShadow(ctx, c, execCtx, result, err, time.Since(startTime))
 		}
 	}
+	if err != nil {
+		return nil, err
+	}
 
+	if err := r.checkContract(ctx, proc, result); err != nil {
+		return nil, err
+	}
+	if deprecation != "" {
+		result.Warnings = append([]string{deprecation}, result.Warnings...)
+	}
 	return result, nil
 }
 
 // ExecuteSQL runs ad-hoc SQL.
-func (r *Runtime) ExecuteSQL(ctx context.Context, sql string, execCtx *ExecContext) (*ExecResult, error) {
-	// Acquire semaphore
-	select {
-	case r.execSemaphore <- struct{}{}:
-		defer func() { <-r.execSemaphore }()
-	case <-ctx.Done():
-		return nil, ctx.Err()
+func (r *Runtime) ExecuteSQL(ctx context.Context, sql string, execCtx *ExecContext) (result *ExecResult, err error) {
+	// Wait for an execution slot
+	release, err := r.admission.Acquire(ctx, execCtx.Priority)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
 	atomic.AddInt64(&r.activeExecs, 1)
 	defer atomic.AddInt64(&r.activeExecs, -1)
@@ -200,16 +554,72 @@ func (r *Runtime) ExecuteSQL(ctx context.Context, sql string, execCtx *ExecConte
 	interp := r.interpreterPool.Get().(*interpreter)
 	defer r.interpreterPool.Put(interp)
 
+	tracker := r.memory.Begin(execCtx.SessionID)
+	defer tracker.End()
+	interp.memory = tracker
+	locks := r.locks.Owner(execCtx.SessionID, execCtx.Database, sql)
+	defer locks.ReleaseAll()
+	interp.locks = locks
+	request := r.requests.Begin(execCtx.SessionID, execCtx.Database, "SQL BATCH", sql)
+	defer request.End()
+	interp.request = request
+	interp.features = r.Features()
+	interp.mail = r.Mail()
+	interp.shell = r.CommandShell()
+	interp.permissions = r.Permissions()
+	interp.sandbox = r.Sandbox()
+	interp.archive = r.Archive()
+	defer func() {
+		interp.memory, interp.journal, interp.locks, interp.request, interp.features, interp.mail = nil, nil, nil, nil, nil, nil
+		interp.shell, interp.permissions, interp.sandbox, interp.archive = nil, nil, nil, nil
+	}()
+
+	if journal := r.Journal(); journal != nil {
+		entry := journal.Begin("", sql, execCtx)
+		defer func() { entry.End(err) }()
+		interp.journal = entry
+	}
+
 	// Execute
-	return interp.ExecuteSQL(ctx, sql, execCtx, r.storage)
+	defer r.recoverPanic(ctx, "Runtime.ExecuteSQL", &err)
+	result, err = interp.ExecuteSQL(ctx, sql, execCtx, r.storage)
+	if err != nil {
+		// Cut short by a timeout or the client
+		if ierr := interrupted(ctx, "Runtime.ExecuteSQL", execCtx.Timeout); ierr != nil {
+			return nil, ierr
+		}
+		return nil, err
+	}
+	return result, nil
 }
 
 // executeInterpreted runs a procedure using the interpreter.
-func (r *Runtime) executeInterpreted(ctx context.Context, proc *procedure.Procedure, execCtx *ExecContext) (*ExecResult, error) {
+func (r *Runtime) executeInterpreted(ctx context.Context, proc *procedure.Procedure, execCtx *ExecContext, entry *JournalEntry) (*ExecResult, error) {
 	// Get interpreter from pool
 	interp := r.interpreterPool.Get().(*interpreter)
 	defer r.interpreterPool.Put(interp)
 
+	tracker := r.memory.Begin(execCtx.SessionID)
+	defer tracker.End()
+	interp.memory = tracker
+	interp.journal = entry
+	locks := r.locks.Owner(execCtx.SessionID, execCtx.Database, proc.QualifiedName())
+	defer locks.ReleaseAll()
+	interp.locks = locks
+	request := r.requests.Begin(execCtx.SessionID, execCtx.Database, "EXECUTE", proc.QualifiedName())
+	defer request.End()
+	interp.request = request
+	interp.features = r.Features()
+	interp.mail = r.Mail()
+	interp.shell = r.CommandShell()
+	interp.permissions = r.Permissions()
+	interp.sandbox = r.Sandbox()
+	interp.archive = r.Archive()
+	defer func() {
+		interp.memory, interp.journal, interp.locks, interp.request, interp.features, interp.mail = nil, nil, nil, nil, nil, nil
+		interp.shell, interp.permissions, interp.sandbox, interp.archive = nil, nil, nil, nil
+	}()
+
 	return interp.Execute(ctx, proc, execCtx, r.storage)
 }
 
@@ -351,14 +761,20 @@ func (t *jitTxnAdapter) Rollback() error {
 }
 
 // triggerJIT initiates JIT compilation for a procedure.
-func (r *Runtime) triggerJIT(proc *procedure.Procedure) {
+func (r *Runtime) triggerJIT(proc *procedure.Procedure, requestID string) {
 	if r.jitManager == nil {
 		return
 	}
+	defer func() {
+		if v := recover(); v != nil {
+			r.RecordPanic(log.WithRequestID(context.Background(), requestID), "Runtime.triggerJIT", v, debug.Stack())
+		}
+	}()
 
 	r.logger.Execution().Info("triggering JIT compilation",
 		"procedure", proc.QualifiedName(),
 		"exec_count", proc.ExecCount,
+		"request_id", requestID,
 	)
 
 	if err := r.jitManager.Compile(proc); err != nil {
@@ -383,7 +799,13 @@ func (r *Runtime) CommitTransaction(ctx context.Context, txn *TransactionContext
 			WithOp("Runtime.CommitTransaction").
 			Err()
 	}
-	return r.storage.Commit(ctx, txn)
+	if err := r.storage.Commit(ctx, txn); err != nil {
+		r.logger.Storage().WithContext(ctx).Error("commit failed", err,
+			"txn_id", txn.ID,
+		)
+		return err
+	}
+	return nil
 }
 
 // RollbackTransaction rolls back a transaction.
@@ -393,7 +815,13 @@ func (r *Runtime) RollbackTransaction(ctx context.Context, txn *TransactionConte
 			WithOp("Runtime.RollbackTransaction").
 			Err()
 	}
-	return r.storage.Rollback(ctx, txn)
+	if err := r.storage.Rollback(ctx, txn); err != nil {
+		r.logger.Storage().WithContext(ctx).Error("rollback failed", err,
+			"txn_id", txn.ID,
+		)
+		return err
+	}
+	return nil
 }
 
 // Stats returns runtime statistics.
@@ -402,10 +830,39 @@ func (r *Runtime) Stats() RuntimeStats {
 		ActiveExecutions: atomic.LoadInt64(&r.activeExecs),
 		TotalExecutions:  atomic.LoadInt64(&r.totalExecs),
 		TotalTimeNs:      atomic.LoadInt64(&r.totalTimeNs),
+		Panics:           atomic.LoadInt64(&r.panics),
 		JITStats:         r.JITStats(),
+		Admission:        r.admission.Stats(),
+		Memory:           r.memory.Stats(),
 	}
 }
 
+// Admission returns the admission controller.
+func (r *Runtime) Admission() *Admission {
+	return r.admission
+}
+
+// TableCache returns the cache of pinned tables, or nil if none are
+// pinned.
+func (r *Runtime) TableCache() *tsqlruntime.TableCache {
+	return r.tableCache
+}
+
+// Memory returns the memory manager.
+func (r *Runtime) Memory() *MemoryManager {
+	return r.memory
+}
+
+// Requests returns the tracker of running executions.
+func (r *Runtime) Requests() *RequestTracker {
+	return r.requests
+}
+
+// Locks returns the lock manager.
+func (r *Runtime) Locks() *LockManager {
+	return r.locks
+}
+
 // JITStats returns JIT compilation statistics.
 func (r *Runtime) JITStats() JITStats {
 	if r.jitManager == nil {
@@ -425,7 +882,10 @@ type RuntimeStats struct {
 	ActiveExecutions int64
 	TotalExecutions  int64
 	TotalTimeNs      int64
+	Panics           int64 // Panics recovered from, see RecordPanic
 	JITStats         JITStats
+	Admission        AdmissionStats
+	Memory           MemoryStats
 }
 
 // JITStats holds JIT compilation statistics.
@@ -451,10 +911,19 @@ type ExecContext struct {
 	Database  string
 	Tenant    string // Tenant ID for multi-tenant deployments
 	User      string
+	App       string // Application name the client gave
+	Protocol  string // Protocol the client connected with
+	Host      string // Host name the client gave, for HOST_NAME()
+	Address   string // Address the client connected from
+	SPID      int    // Session number, for @@SPID
 
 	// Parameters
 	Parameters map[string]interface{}
 
+	// Variables of an ad-hoc batch returned in ExecResult.OutputParams,
+	// as the OUTPUT parameters of sp_executesql are
+	Outputs []string
+
 	// Execution options
 	Timeout       time.Duration
 	NoCount       bool
@@ -465,9 +934,37 @@ type ExecContext struct {
 	InTxn      bool
 	TxnContext *TransactionContext
 
+	// Admission lane when the server is saturated
+	Priority Priority
+
+	// Statements that change the database fail, as in a read-only database
+	ReadOnly bool
+
+	// Statements are checked against the permissions of User, a SQL
+	// login the client signed in as (see permissions.go)
+	EnforcePermissions bool
+
+	// Run in a transaction that is rolled back, listing the writes made
+	// in a last result set
+	DryRun bool
+
+	// Summarise what each statement did in ExecResult.Statements
+	Summary bool
+
+	// Where each statement, assignment and branch is recorded, even
+	// when the execution fails (nil = not traced)
+	Trace *tsqlruntime.Trace
+
+	// Where the procedures run count what of them ran, for aul test
+	// (nil = not counted)
+	Coverage *tsqlruntime.Coverage
+
 	// Caller info (for nested EXEC)
 	CallerProc string
 	CallStack  []string
+
+	// Where a streamed query's rows go (see stream.go)
+	rowSink tsqlruntime.RowSink
 }
 
 // ExecResult holds the result of a procedure execution.
@@ -487,21 +984,25 @@ type ExecResult struct {
 	// Execution metadata
 	ExecTimeNs int64
 	Warnings   []string
+	Statements []tsqlruntime.StatementSummary // When ExecContext.Summary is set
+	Trace      *tsqlruntime.Trace             // ExecContext.Trace, or that of EXEC ... WITH TRACE
 }
 
 // ResultSet represents a tabular result.
 type ResultSet struct {
 	Columns []ColumnInfo
 	Rows    [][]interface{}
+	Stream  *RowStream // The rows, read as they are produced, in place of Rows (see stream.go)
 }
 
 // ColumnInfo describes a result column.
 type ColumnInfo struct {
-	Name     string
-	Type     string
-	Nullable bool
-	Length   int
-	Ordinal  int
+	Name        string
+	Type        string
+	Nullable    bool
+	Length      int
+	Ordinal     int
+	Sensitivity *tsqlruntime.Sensitivity // Classification of the column, if any
 }
 
 // TransactionContext holds transaction state.
@@ -0,0 +1,71 @@
+package runtime_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	pkglog "github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/sandbox"
+	"github.com/ha1tch/aul/pkg/storage"
+)
+
+func TestSandbox(t *testing.T) {
+	ctx := context.Background()
+	backend, err := storage.NewSQLiteStorage(storage.DefaultSQLiteConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	registry := procedure.NewRegistry()
+	proc := &procedure.Procedure{
+		Name:   "RunSQL",
+		Schema: "dbo",
+		Source: "CREATE PROCEDURE dbo.RunSQL\n    @sql NVARCHAR(200)\nAS\nBEGIN\n    EXEC sp_executesql @sql\nEND",
+	}
+	registry.Register(proc)
+	sb, err := sandbox.New(sandbox.Config{Policies: []sandbox.Policy{
+		{Name: "ops", Users: []string{"ops"}, Allow: []string{"dynamic_sql", "ddl"}},
+		{Name: "procedures", Procedures: []string{"dbo.*"}, Deny: []string{"dynamic_sql"}},
+		{Name: "schema", Deny: []string{"ddl"}, Message: "Migrations run at deploy."},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, registry, pkglog.New(pkglog.Config{DefaultLevel: pkglog.LevelError}))
+	rt.SetStorage(backend)
+	rt.SetSandbox(sb)
+
+	app := &runtime.ExecContext{SessionID: "app", User: "app", Parameters: map[string]interface{}{"sql": "SELECT 1"}}
+	ops := &runtime.ExecContext{SessionID: "ops", User: "ops", Parameters: map[string]interface{}{"sql": "SELECT 1"}}
+	wantDenied := func(what string, err error, want string) {
+		t.Helper()
+		sqlErr := aulerrors.FindSQLError(err)
+		if sqlErr == nil || sqlErr.Code != aulerrors.ErrCodeExecDenied ||
+			sqlErr.Fields[aulerrors.FieldSQLErrorNumber] != int32(50414) || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want error 50414 %q", what, err, want)
+		}
+	}
+
+	_, err = rt.Execute(ctx, proc, app)
+	wantDenied("procedure", err, "Dynamic SQL is denied to procedure 'dbo.RunSQL' by sandbox policy 'procedures'")
+	_, err = rt.ExecuteSQL(ctx, "CREATE TABLE notes (id INT)", app)
+	wantDenied("batch", err, "by sandbox policy 'schema' (ddl). Migrations run at deploy.")
+
+	// Dynamic SQL outside procedures, temporary tables and allowed users are not
+	if _, err := rt.ExecuteSQL(ctx, "EXEC(N'CREATE TABLE #notes (id INT)')", app); err != nil {
+		t.Errorf("temporary table: %v", err)
+	}
+	if _, err := rt.Execute(ctx, proc, ops); err != nil {
+		t.Errorf("procedure for ops: %v", err)
+	}
+	if _, err := rt.ExecuteSQL(ctx, "CREATE TABLE notes (id INT)", ops); err != nil {
+		t.Errorf("CREATE TABLE for ops: %v", err)
+	}
+}
@@ -0,0 +1,434 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/notify"
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+// ShadowConfig controls shadow execution of changed procedures.
+//
+// With shadow execution enabled, a changed procedure does not replace the
+// version serving calls straight away. It is staged as a candidate, and a
+// sample of the calls to the procedure run the candidate as well, once
+// the caller has the current version's result. The two outcomes and
+// latencies are compared, and the candidate replaces the current version
+// when promoted: by hand, or after PromoteAfter runs without a
+// divergence. Only procedures that do not write can be shadowed, as
+// running one twice would apply its writes twice; the others replace the
+// current version at once.
+type ShadowConfig struct {
+	Enabled bool
+
+	SampleRate    float64 // Share of calls that also run the candidate (0-1)
+	PromoteAfter  int     // Matching runs that promote a candidate with no divergences (0 = by hand)
+	MaxConcurrent int     // Shadow runs at once; calls beyond this are not sampled
+}
+
+// DefaultShadowConfig returns the shadow execution defaults. Shadow
+// execution is disabled unless Enabled is set.
+func DefaultShadowConfig() ShadowConfig {
+	return ShadowConfig{
+		SampleRate:    0.1,
+		MaxConcurrent: 4,
+	}
+}
+
+// shadowSessionSuffix marks the session ID of a shadow run, which keeps
+// its memory and locks apart from the call it shadows.
+const shadowSessionSuffix = "/shadow"
+
+// ShadowStatus is a snapshot of one procedure's candidate.
+type ShadowStatus struct {
+	Procedure        string
+	StagedAt         time.Time
+	Runs             int64 // Calls that ran the candidate too
+	Matches          int64
+	Divergences      int64
+	CurrentAvgMs     float64 // Average latency of the current version on those calls
+	CandidateAvgMs   float64
+	LastDivergence   string
+	LastDivergenceAt time.Time
+}
+
+// ShadowSet holds the candidates of all procedures.
+type ShadowSet struct {
+	config   ShadowConfig
+	registry *procedure.Registry
+	logger   *log.Logger
+	sample   func() float64
+	slots    chan struct{} // One per running shadow execution
+
+	mu         sync.Mutex
+	candidates map[string]*shadowCandidate
+	notifier   *notify.Notifier // Told of a candidate's first divergence (nil = nobody)
+}
+
+// shadowCandidate is a procedure version running in shadow.
+type shadowCandidate struct {
+	proc     *procedure.Procedure
+	stagedAt time.Time
+
+	runs, matches, divergences int64
+	currentNs, candidateNs     int64
+	lastDivergence             string
+	lastDivergenceAt           time.Time
+}
+
+// NewShadowSet creates a shadow set. Zero fields of cfg take their
+// defaults.
+func NewShadowSet(cfg ShadowConfig, registry *procedure.Registry, logger *log.Logger) *ShadowSet {
+	def := DefaultShadowConfig()
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		cfg.SampleRate = def.SampleRate
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = def.MaxConcurrent
+	}
+	return &ShadowSet{
+		config:     cfg,
+		registry:   registry,
+		logger:     logger,
+		sample:     rand.Float64,
+		slots:      make(chan struct{}, cfg.MaxConcurrent),
+		candidates: make(map[string]*shadowCandidate),
+	}
+}
+
+// SetNotifier sets the notifier told when a candidate first diverges.
+func (s *ShadowSet) SetNotifier(n *notify.Notifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifier = n
+}
+
+// Config returns the effective configuration.
+func (s *ShadowSet) Config() ShadowConfig {
+	return s.config
+}
+
+// shadowKey identifies a procedure across its versions.
+func shadowKey(proc *procedure.Procedure) string {
+	key := strings.ToLower(proc.QualifiedName())
+	if proc.Tenant != "" {
+		key = proc.Tenant + "/" + key
+	}
+	return key
+}
+
+// Stage makes proc the candidate to replace the registered version of
+// the same procedure, replacing any earlier candidate. It returns false,
+// leaving the caller to register proc, when proc cannot be shadowed:
+// when it may write or no version of it is registered.
+func (s *ShadowSet) Stage(proc *procedure.Procedure) bool {
+	if s.current(proc) == nil {
+		return false
+	}
+	readOnly, err := procedure.ReadOnly(proc)
+	if err != nil || !readOnly {
+		s.logger.Application().Info("procedure may write; replacing it without shadow execution",
+			"procedure", proc.QualifiedName(),
+		)
+		return false
+	}
+
+	s.mu.Lock()
+	s.candidates[shadowKey(proc)] = &shadowCandidate{proc: proc, stagedAt: time.Now()}
+	s.mu.Unlock()
+
+	s.logger.Application().Info("procedure staged for shadow execution",
+		"procedure", proc.QualifiedName(),
+		"sample_rate", s.config.SampleRate,
+		"promote_after", s.config.PromoteAfter,
+	)
+	return true
+}
+
+// current returns the registered version of the procedure proc is a
+// version of, or nil.
+func (s *ShadowSet) current(proc *procedure.Procedure) *procedure.Procedure {
+	if proc.SourceFile != "" {
+		if cur, err := s.registry.LookupByFile(proc.SourceFile); err == nil {
+			return cur
+		}
+	}
+	return nil
+}
+
+// find returns the candidate of the procedure with the given name.
+// Callers hold s.mu.
+func (s *ShadowSet) find(name string) (string, *shadowCandidate, error) {
+	key := strings.ToLower(name)
+	if c, ok := s.candidates[key]; ok {
+		return key, c, nil
+	}
+	// Also accept the name without its database or tenant
+	var matches []string
+	for k, c := range s.candidates {
+		if strings.EqualFold(c.proc.FullName, name) || strings.EqualFold(c.proc.Name, name) || strings.HasSuffix(k, "/"+key) {
+			matches = append(matches, k)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], s.candidates[matches[0]], nil
+	}
+	if len(matches) > 1 {
+		return "", nil, aulerrors.Newf(aulerrors.ErrCodeProcNotFound,
+			"%s names %d shadowed procedures; qualify it", name, len(matches)).Err()
+	}
+	return "", nil, aulerrors.Newf(aulerrors.ErrCodeProcNotFound,
+		"procedure %s has no shadow candidate", name).Err()
+}
+
+// Promote replaces a procedure with its candidate.
+func (s *ShadowSet) Promote(name string) error {
+	s.mu.Lock()
+	key, c, err := s.find(name)
+	if err == nil {
+		delete(s.candidates, key)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.promote(c, "by request")
+}
+
+func (s *ShadowSet) promote(c *shadowCandidate, reason string) error {
+	proc := c.proc
+	cur := s.current(proc)
+	if cur == nil {
+		return aulerrors.Newf(aulerrors.ErrCodeProcNotFound,
+			"procedure %s was removed while in shadow", proc.QualifiedName()).Err()
+	}
+	proc.ExecCount = cur.ExecCount
+	proc.TotalTimeNs = cur.TotalTimeNs
+	proc.LastExecAt = cur.LastExecAt
+	if err := s.registry.Register(proc); err != nil {
+		return err
+	}
+	s.logger.Application().Info("shadow candidate promoted",
+		"procedure", proc.QualifiedName(),
+		"reason", reason,
+		"runs", c.runs,
+		"divergences", c.divergences,
+	)
+	return nil
+}
+
+// Discard drops a procedure's candidate, keeping the current version.
+func (s *ShadowSet) Discard(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, c, err := s.find(name)
+	if err != nil {
+		return err
+	}
+	delete(s.candidates, key)
+	s.logger.Application().Info("shadow candidate discarded",
+		"procedure", c.proc.QualifiedName(),
+		"runs", c.runs,
+		"divergences", c.divergences,
+	)
+	return nil
+}
+
+// DiscardAll drops every candidate, as when the procedures they would
+// replace have been replaced wholesale, and returns how many there were.
+func (s *ShadowSet) DiscardAll() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.candidates)
+	clear(s.candidates)
+	if n > 0 {
+		s.logger.Application().Info("shadow candidates discarded", "count", n)
+	}
+	return n
+}
+
+// Status returns a snapshot of every candidate, ordered by procedure.
+func (s *ShadowSet) Status() []ShadowStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ShadowStatus, 0, len(s.candidates))
+	for _, c := range s.candidates {
+		st := ShadowStatus{
+			Procedure:        c.proc.QualifiedName(),
+			StagedAt:         c.stagedAt,
+			Runs:             c.runs,
+			Matches:          c.matches,
+			Divergences:      c.divergences,
+			LastDivergence:   c.lastDivergence,
+			LastDivergenceAt: c.lastDivergenceAt,
+		}
+		if c.runs > 0 {
+			st.CurrentAvgMs = float64(c.currentNs) / float64(c.runs) / 1e6
+			st.CandidateAvgMs = float64(c.candidateNs) / float64(c.runs) / 1e6
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Procedure < out[j].Procedure })
+	return out
+}
+
+// take returns the candidate to run alongside a call to proc, holding a
+// slot for it, or nil when the call is not sampled.
+func (s *ShadowSet) take(proc *procedure.Procedure) *shadowCandidate {
+	s.mu.Lock()
+	c := s.candidates[shadowKey(proc)]
+	s.mu.Unlock()
+	if c == nil || s.sample() >= s.config.SampleRate {
+		return nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return c
+	default:
+		return nil // Enough shadow work already
+	}
+}
+
+// record adds the outcome of a shadow run to its candidate, promoting it
+// once it has matched PromoteAfter times without a divergence.
+func (s *ShadowSet) record(ctx context.Context, c *shadowCandidate, current, candidate time.Duration, divergence string) {
+	s.mu.Lock()
+	if s.candidates[shadowKey(c.proc)] != c {
+		s.mu.Unlock()
+		return // Promoted, discarded or replaced meanwhile
+	}
+	c.runs++
+	c.currentNs += current.Nanoseconds()
+	c.candidateNs += candidate.Nanoseconds()
+	first := false
+	if divergence == "" {
+		c.matches++
+	} else {
+		first = c.divergences == 0
+		c.divergences++
+		c.lastDivergence = divergence
+		c.lastDivergenceAt = time.Now()
+	}
+	promote := divergence == "" && s.config.PromoteAfter > 0 &&
+		c.divergences == 0 && c.matches >= int64(s.config.PromoteAfter)
+	if promote {
+		delete(s.candidates, shadowKey(c.proc))
+	}
+	notifier := s.notifier
+	s.mu.Unlock()
+
+	name := c.proc.QualifiedName()
+	if divergence != "" {
+		s.logger.Execution().WithContext(ctx).Warn("shadow candidate diverged",
+			"procedure", name,
+			"divergence", divergence,
+			"current_ms", float64(current.Microseconds())/1000,
+			"candidate_ms", float64(candidate.Microseconds())/1000,
+		)
+		if first && notifier != nil {
+			notifier.Notify(notify.EventShadowDiverged, "shadow candidate diverged",
+				"procedure", name,
+				"divergence", divergence,
+			)
+		}
+	}
+	if promote {
+		if err := s.promote(c, fmt.Sprintf("%d matching runs", c.matches)); err != nil {
+			s.logger.Application().Error("failed to promote shadow candidate", err, "procedure", name)
+		}
+	}
+}
+
+// runShadow runs a candidate with a call's parameters and compares the
+// outcome with the current version's. It runs after the call returns, so
+// it has a context of its own.
+func (r *Runtime) runShadow(ctx context.Context, c *shadowCandidate, execCtx *ExecContext, result *ExecResult, callErr error, elapsed time.Duration) {
+	defer func() { <-r.shadow.slots }()
+
+	timeout := execCtx.Timeout
+	if timeout <= 0 {
+		timeout = r.config.ExecTimeout
+	}
+	ctx = context.WithoutCancel(ctx)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	shadowCtx := *execCtx
+	shadowCtx.SessionID = execCtx.SessionID + shadowSessionSuffix
+	start := time.Now()
+	shadowResult, shadowErr := func() (res *ExecResult, err error) {
+		defer r.recoverPanic(ctx, "Runtime.runShadow", &err)
+		return r.executeInterpreted(ctx, c.proc, &shadowCtx, nil)
+	}()
+	r.shadow.record(ctx, c, elapsed, time.Since(start), compareOutcomes(result, callErr, shadowResult, shadowErr))
+}
+
+// compareOutcomes describes the first difference between the current
+// version's outcome and the candidate's, or returns "" when they match.
+func compareOutcomes(cur *ExecResult, curErr error, cand *ExecResult, candErr error) string {
+	switch {
+	case curErr != nil && candErr != nil:
+		return ""
+	case curErr != nil:
+		return "current version failed, candidate succeeded: " + curErr.Error()
+	case candErr != nil:
+		return "candidate failed: " + candErr.Error()
+	}
+
+	if len(cur.ResultSets) != len(cand.ResultSets) {
+		return fmt.Sprintf("%d result sets, candidate returned %d", len(cur.ResultSets), len(cand.ResultSets))
+	}
+	for i := range cur.ResultSets {
+		a, b := cur.ResultSets[i], cand.ResultSets[i]
+		if names, candNames := columnNames(a), columnNames(b); names != candNames {
+			return fmt.Sprintf("result set %d: columns (%s), candidate returned (%s)", i+1, names, candNames)
+		}
+		if len(a.Rows) != len(b.Rows) {
+			return fmt.Sprintf("result set %d: %d rows, candidate returned %d", i+1, len(a.Rows), len(b.Rows))
+		}
+		for j := range a.Rows {
+			for k := range a.Rows[j] {
+				if k < len(b.Rows[j]) && !sameValue(a.Rows[j][k], b.Rows[j][k]) {
+					return fmt.Sprintf("result set %d row %d column %s: %v, candidate returned %v",
+						i+1, j+1, a.Columns[k].Name, a.Rows[j][k], b.Rows[j][k])
+				}
+			}
+		}
+	}
+	if !sameValue(cur.ReturnValue, cand.ReturnValue) {
+		return fmt.Sprintf("return value %v, candidate returned %v", cur.ReturnValue, cand.ReturnValue)
+	}
+	for name, v := range cur.OutputParams {
+		if !sameValue(v, cand.OutputParams[name]) {
+			return fmt.Sprintf("output parameter %s %v, candidate set %v", name, v, cand.OutputParams[name])
+		}
+	}
+	return ""
+}
+
+func columnNames(rs ResultSet) string {
+	names := make([]string, len(rs.Columns))
+	for i, c := range rs.Columns {
+		names[i] = c.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// sameValue compares values as a client would see them.
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
@@ -0,0 +1,141 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+)
+
+func shadowProc(body string) *procedure.Procedure {
+	return &procedure.Procedure{
+		Name:       "GetA",
+		Schema:     "dbo",
+		FullName:   "dbo.GetA",
+		Source:     "CREATE PROCEDURE dbo.GetA\nAS\nBEGIN\n    " + body + "\nEND",
+		SourceFile: "/procs/GetA.sql",
+		SourceHash: body,
+	}
+}
+
+// waitForRuns waits until the candidate of dbo.GetA has run n times, or
+// is gone.
+func waitForRuns(t *testing.T, s *ShadowSet, n int64) []ShadowStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := s.Status()
+		if len(st) == 0 || st[0].Runs >= n || time.Now().After(deadline) {
+			return st
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestShadowExecution(t *testing.T) {
+	registry := procedure.NewRegistry()
+	current := shadowProc("SELECT 1 AS A")
+	if err := registry.Register(current); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.JITEnabled = false
+	cfg.Shadow = ShadowConfig{Enabled: true, SampleRate: 1}
+	r := New(cfg, registry, log.New(log.Config{DefaultLevel: log.LevelError}))
+	r.SetStorage(NewMemoryStorage())
+	shadow := r.Shadow()
+
+	// A candidate that writes cannot be shadowed
+	if shadow.Stage(shadowProc("INSERT INTO Audit VALUES (1)")) {
+		t.Fatal("staged a procedure that writes")
+	}
+
+	// A diverging candidate keeps serving the current version
+	if !shadow.Stage(shadowProc("SELECT 2 AS A")) {
+		t.Fatal("Stage refused a read-only procedure")
+	}
+	result, err := r.Execute(context.Background(), current, &ExecContext{SessionID: "s1"})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if got := result.ResultSets[0].Rows[0][0]; got != int64(1) {
+		t.Errorf("caller got %v from the candidate", got)
+	}
+	st := waitForRuns(t, shadow, 1)
+	if len(st) != 1 || st[0].Runs != 1 || st[0].Divergences != 1 ||
+		!strings.Contains(st[0].LastDivergence, "column A: 1, candidate returned 2") {
+		t.Fatalf("status = %+v", st)
+	}
+	if err := shadow.Discard("GetA"); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+
+	// A matching candidate is promoted after PromoteAfter runs
+	shadow.config.PromoteAfter = 2
+	candidate := shadowProc("SELECT 1 AS A -- v2")
+	shadow.Stage(candidate)
+	for i := 1; i <= 2; i++ {
+		if _, err := r.Execute(context.Background(), current, &ExecContext{SessionID: "s1"}); err != nil {
+			t.Fatal(err)
+		}
+		waitForRuns(t, shadow, int64(i))
+	}
+	if st := shadow.Status(); len(st) != 0 {
+		t.Errorf("candidate not promoted: %+v", st)
+	}
+	if got, _ := registry.LookupByFile("/procs/GetA.sql"); got != candidate {
+		t.Errorf("registered %q, want the candidate", got.Source)
+	}
+
+	if err := shadow.Promote("GetA"); err == nil {
+		t.Error("Promote without a candidate succeeded")
+	}
+}
+
+func TestCompareOutcomes(t *testing.T) {
+	rs := func(cols []string, rows ...[]interface{}) ResultSet {
+		out := ResultSet{Rows: rows}
+		for i, c := range cols {
+			out.Columns = append(out.Columns, ColumnInfo{Name: c, Ordinal: i})
+		}
+		return out
+	}
+	base := &ExecResult{
+		ResultSets:   []ResultSet{rs([]string{"ID", "Name"}, []interface{}{int64(1), "a"})},
+		OutputParams: map[string]interface{}{"@Total": int64(5)},
+	}
+	same := &ExecResult{
+		ResultSets:   []ResultSet{rs([]string{"ID", "Name"}, []interface{}{int64(1), "a"})},
+		OutputParams: map[string]interface{}{"@Total": int64(5)},
+	}
+	if d := compareOutcomes(base, nil, same, nil); d != "" {
+		t.Errorf("identical outcomes: %s", d)
+	}
+	boom := errors.New("boom")
+	if d := compareOutcomes(nil, boom, nil, boom); d != "" {
+		t.Errorf("both failed: %s", d)
+	}
+
+	tests := []struct {
+		cand    *ExecResult
+		candErr error
+		want    string
+	}{
+		{nil, boom, "candidate failed: boom"},
+		{&ExecResult{}, nil, "1 result sets, candidate returned 0"},
+		{&ExecResult{ResultSets: []ResultSet{rs([]string{"ID", "FullName"}, []interface{}{int64(1), "a"})}}, nil, "candidate returned (ID, FullName)"},
+		{&ExecResult{ResultSets: []ResultSet{rs([]string{"ID", "Name"})}}, nil, "1 rows, candidate returned 0"},
+		{&ExecResult{ResultSets: []ResultSet{rs([]string{"ID", "Name"}, []interface{}{int64(1), nil})}}, nil, "column Name: a, candidate returned <nil>"},
+		{&ExecResult{ResultSets: same.ResultSets, OutputParams: map[string]interface{}{"@Total": int64(6)}}, nil, "output parameter @Total 5, candidate set 6"},
+	}
+	for _, tt := range tests {
+		if d := compareOutcomes(base, nil, tt.cand, tt.candErr); !strings.Contains(d, tt.want) {
+			t.Errorf("divergence %q, want %q", d, tt.want)
+		}
+	}
+}
@@ -4,35 +4,93 @@ import (
 	"context"
 	"database/sql"
 	"sync"
+	"time"
 )
 
-// StorageBackend provides data access for procedure execution.
+// StorageBackend provides data access for procedure execution. It is the
+// contract a backend meets to hold aul's data, whether built in (see
+// pkg/storage) or loaded from a plugin (see RegisterStorage); the
+// storagetest package checks a backend against it.
+//
+// Procedures do not go through Query and Exec: the interpreter translates
+// their T-SQL into the SQL of Dialect and runs it on the *sql.DB GetDB
+// returns, so a backend is chiefly a database/sql driver and a dialect.
+// Query and Exec serve the server's own statements (the system catalog,
+// init scripts' bookkeeping, the admin API) and take the same SQL.
+//
+// The optional interfaces below add hooks the server uses when a backend
+// has them: CatalogBackend and TypeMapper describe its tables and types,
+// StatisticsBackend and IndexBackend serve scheduled statistics and the
+// index advisor, TenantAwareStorageBackend serves multi-tenancy.
+//
+// Methods may be called from many goroutines at once.
 type StorageBackend interface {
-	// Query operations
+	// Query runs a statement returning rows, with args bound to its
+	// placeholders as the dialect writes them, and returns its result
+	// sets. Column types are T-SQL type names (see TypeMapper).
 	Query(ctx context.Context, sql string, args ...interface{}) ([]ResultSet, error)
+	// QueryRow returns the first row of the first result set of Query,
+	// or nil when there is none.
 	QueryRow(ctx context.Context, sql string, args ...interface{}) ([]interface{}, error)
+	// Exec runs a statement returning no rows and returns the number of
+	// rows it affected.
 	Exec(ctx context.Context, sql string, args ...interface{}) (int64, error)
 
-	// Transaction support
+	// Begin starts a transaction, identified by the returned context's
+	// ID. Commit and Rollback end it and set its State; both accept nil,
+	// which they ignore, and fail for a transaction that is not open.
 	Begin(ctx context.Context) (*TransactionContext, error)
 	Commit(ctx context.Context, txn *TransactionContext) error
 	Rollback(ctx context.Context, txn *TransactionContext) error
+	// Savepoint marks a point in an open transaction that RollbackTo
+	// returns it to, dropping the savepoints marked after it.
 	Savepoint(ctx context.Context, txn *TransactionContext, name string) error
 	RollbackTo(ctx context.Context, txn *TransactionContext, name string) error
 
-	// Temp table support
+	// Temp tables, with columns of T-SQL types
 	CreateTempTable(ctx context.Context, name string, columns []ColumnInfo) error
 	DropTempTable(ctx context.Context, name string) error
 	TempTableExists(ctx context.Context, name string) bool
 
-	// Metadata
+	// Dialect names the SQL the backend speaks: sqlite, postgres, mysql
+	// or sqlserver. Others get the interpreter's generic translation.
 	Dialect() string
+	// Close releases the backend's connections.
 	Close() error
 
-	// Database access for tsqlruntime integration
+	// GetDB returns the database procedures' statements run on, or nil
+	// for a backend that cannot run SQL (procedures then run without
+	// data access).
 	GetDB() *sql.DB
 }
 
+// CatalogBackend is a StorageBackend that can list its user tables and
+// their columns.
+type CatalogBackend interface {
+	StorageBackend
+
+	// Tables returns the names of the user tables, in name order, without
+	// the backend's own.
+	Tables(ctx context.Context) ([]string, error)
+
+	// TableColumns returns the columns of table in their order, typed by
+	// T-SQL type names. It fails if there is no such table.
+	TableColumns(ctx context.Context, table string) ([]ColumnInfo, error)
+}
+
+// TypeMapper is a StorageBackend that says how it stores T-SQL types.
+type TypeMapper interface {
+	StorageBackend
+
+	// BackendType returns the column type the backend stores a T-SQL type
+	// (such as NVARCHAR or DECIMAL, without length) as.
+	BackendType(sqlType string) string
+
+	// SQLType returns the T-SQL type name results report for a column of
+	// a backend type.
+	SQLType(backendType string) string
+}
+
 // TenantAwareStorageBackend extends StorageBackend with tenant-specific operations.
 // Use this interface when multi-tenancy is enabled.
 type TenantAwareStorageBackend interface {
@@ -52,6 +110,38 @@ type TenantAwareStorageBackend interface {
 	BeginForTenant(ctx context.Context, tenant, database string) (*TransactionContext, error)
 }
 
+// StatisticsBackend is a StorageBackend that keeps the statistics its
+// query planner uses, which the server refreshes on a schedule (see
+// StatisticsConfig).
+type StatisticsBackend interface {
+	StorageBackend
+
+	// RefreshStatistics updates the statistics of the tables whose rows
+	// have changed by more than threshold of their count since they were
+	// last gathered, and returns the tables it updated.
+	RefreshStatistics(ctx context.Context, threshold float64) ([]string, error)
+}
+
+// IndexBackend is a StorageBackend that can list and create the indexes
+// of its tables, for the index advisor (see IndexAdvisorConfig).
+type IndexBackend interface {
+	StorageBackend
+
+	// TableIndexes returns the key columns of each index of table. It
+	// fails if there is no such table.
+	TableIndexes(ctx context.Context, table string) ([][]string, error)
+
+	// CreateIndex creates an index on the columns of table, unless one
+	// named name exists.
+	CreateIndex(ctx context.Context, name, table string, columns []string) error
+}
+
+// StatisticsConfig schedules statistics refreshes.
+type StatisticsConfig struct {
+	Interval  time.Duration // Between refreshes (0 = never)
+	Threshold float64       // Share of a table's rows that must change (0 = tsqlruntime.DefaultStatisticsThreshold)
+}
+
 // StorageConfig holds storage backend configuration.
 type StorageConfig struct {
 	// Backend type: memory, postgres, mysql, sqlserver
@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"plugin"
+	"slices"
+	"sync"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+// StorageOpener opens a storage backend of a registered type.
+type StorageOpener func(cfg StorageConfig) (StorageBackend, error)
+
+var (
+	storageOpenersMu sync.Mutex
+	storageOpeners   = map[string]StorageOpener{}
+)
+
+// RegisterStorage makes a storage backend type available to
+// StorageConfig.Type, for builds of the server that link in a backend of
+// their own and for storage plugins, which call it from an init function.
+// The built-in sqlite and memory types cannot be replaced.
+func RegisterStorage(name string, open StorageOpener) {
+	storageOpenersMu.Lock()
+	defer storageOpenersMu.Unlock()
+	storageOpeners[name] = open
+}
+
+// StorageTypes returns the names of the registered storage backend types.
+func StorageTypes() []string {
+	storageOpenersMu.Lock()
+	defer storageOpenersMu.Unlock()
+	names := make([]string, 0, len(storageOpeners))
+	for name := range storageOpeners {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// OpenStorage opens a backend of the registered type cfg names.
+func OpenStorage(cfg StorageConfig) (StorageBackend, error) {
+	storageOpenersMu.Lock()
+	open := storageOpeners[cfg.Type]
+	storageOpenersMu.Unlock()
+	if open == nil {
+		return nil, aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+			"unsupported storage type: %s", cfg.Type).
+			WithOp("OpenStorage").
+			WithField("registered", StorageTypes()).
+			Err()
+	}
+	storage, err := open(cfg)
+	if err != nil {
+		return nil, aulerrors.Wrap(err, aulerrors.ErrCodeStorageConnect,
+			"failed to open storage backend").
+			WithOp("OpenStorage").
+			WithField("type", cfg.Type).
+			Err()
+	}
+	return storage, nil
+}
+
+// LoadStoragePlugin loads a Go plugin (built with go build
+// -buildmode=plugin, by the Go version and with the module versions the
+// server was built with) whose init functions register storage backend
+// types with RegisterStorage. It fails if the plugin registers none.
+func LoadStoragePlugin(path string) error {
+	before := len(StorageTypes())
+	if _, err := plugin.Open(path); err != nil {
+		return aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid,
+			"failed to load storage plugin").
+			WithOp("LoadStoragePlugin").
+			WithField("path", path).
+			Err()
+	}
+	if len(StorageTypes()) == before {
+		return aulerrors.New(aulerrors.ErrCodeConfigInvalid,
+			"storage plugin registered no storage backend").
+			WithOp("LoadStoragePlugin").
+			WithField("path", path).
+			Err()
+	}
+	return nil
+}
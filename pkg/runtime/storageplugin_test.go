@@ -0,0 +1,49 @@
+package runtime
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+)
+
+func TestOpenStorage(t *testing.T) {
+	var opened StorageConfig
+	RegisterStorage("test-backend", func(cfg StorageConfig) (StorageBackend, error) {
+		opened = cfg
+		if cfg.Host == "down" {
+			return nil, errors.New("connection refused")
+		}
+		return NewMemoryStorage(), nil
+	})
+	if !slices.Contains(StorageTypes(), "test-backend") {
+		t.Fatalf("StorageTypes = %v", StorageTypes())
+	}
+
+	cfg := StorageConfig{Type: "test-backend", Host: "db1", Options: map[string]string{"schema": "aul"}}
+	storage, err := OpenStorage(cfg)
+	if err != nil {
+		t.Fatalf("OpenStorage: %v", err)
+	}
+	storage.Close()
+	if opened.Host != "db1" || opened.Options["schema"] != "aul" {
+		t.Errorf("opener got %+v", opened)
+	}
+
+	cfg.Host = "down"
+	if _, err := OpenStorage(cfg); aulerrors.GetCode(err) != aulerrors.ErrCodeStorageConnect {
+		t.Errorf("failing opener: err = %v, want E%d", err, aulerrors.ErrCodeStorageConnect)
+	}
+	if _, err := OpenStorage(StorageConfig{Type: "no-such-backend"}); aulerrors.GetCode(err) != aulerrors.ErrCodeConfigInvalid {
+		t.Errorf("unknown type: err = %v, want E%d", err, aulerrors.ErrCodeConfigInvalid)
+	}
+}
+
+func TestLoadStoragePlugin_Missing(t *testing.T) {
+	err := LoadStoragePlugin(filepath.Join(t.TempDir(), "missing.so"))
+	if aulerrors.GetCode(err) != aulerrors.ErrCodeConfigInvalid {
+		t.Errorf("err = %v, want E%d", err, aulerrors.ErrCodeConfigInvalid)
+	}
+}
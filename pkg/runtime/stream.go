@@ -0,0 +1,195 @@
+package runtime
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// streamBatchRows is how many rows a stream hands over at a time, and
+// streamBatches how many batches may wait to be read: what a stream holds
+// in memory at most.
+const (
+	streamBatchRows = 256
+	streamBatches   = 4
+)
+
+// RowStream is a result set's rows, read one at a time as the query
+// produces them rather than held in memory, in the manner of sql.Rows.
+// The query runs on while the stream is read, holding its execution slot,
+// locks and transaction, so a stream must be read to the end or closed
+// before anything else runs in its session.
+type RowStream struct {
+	columns []ColumnInfo
+	started chan struct{}        // Closed when the columns are known
+	batches chan [][]interface{} // Closed when the query has ended
+	closed  chan struct{}        // Closed by Close, stopping the query
+	cancel  context.CancelFunc
+	once    sync.Once
+
+	// The producer's batch being filled
+	pending [][]interface{}
+
+	// The reader's
+	batch [][]interface{}
+	row   []interface{}
+	count int64
+	err   error // The query's error, once batches is closed
+}
+
+// Next advances to the next row, reporting false at the end of the rows
+// or when the query has failed, which Err then tells.
+func (s *RowStream) Next() bool {
+	for len(s.batch) == 0 {
+		batch, ok := <-s.batches
+		if !ok {
+			s.row = nil
+			return false
+		}
+		s.batch = batch
+	}
+	s.row, s.batch = s.batch[0], s.batch[1:]
+	s.count++
+	return true
+}
+
+// Row returns the current row.
+func (s *RowStream) Row() []interface{} {
+	return s.row
+}
+
+// RowCount returns the number of rows read so far.
+func (s *RowStream) RowCount() int64 {
+	return s.count
+}
+
+// Err returns the error the query failed with, once Next has reported
+// false.
+func (s *RowStream) Err() error {
+	return s.err
+}
+
+// Close stops the query, if it is still running, and waits for it to end.
+func (s *RowStream) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+		s.cancel()
+	})
+	for range s.batches {
+	}
+	return nil
+}
+
+// streamSink is the tsqlruntime.RowSink filling a RowStream.
+type streamSink struct {
+	s *RowStream
+}
+
+func (k streamSink) Columns(rs tsqlruntime.ResultSet, types []string) error {
+	s := k.s
+	s.columns = make([]ColumnInfo, len(rs.Columns))
+	for j, col := range rs.Columns {
+		s.columns[j] = ColumnInfo{
+			Name:        col,
+			Type:        streamColumnType(types[j]),
+			Ordinal:     j,
+			Sensitivity: columnSensitivity(rs, j),
+		}
+	}
+	close(s.started)
+	return nil
+}
+
+func (k streamSink) Row(row []tsqlruntime.Value) error {
+	s := k.s
+	out := make([]interface{}, len(row))
+	for j, val := range row {
+		out[j] = tsqlruntime.FromValue(val)
+	}
+	s.pending = append(s.pending, out)
+	if len(s.pending) < streamBatchRows {
+		return nil
+	}
+	return s.flush()
+}
+
+// flush hands the pending batch to the reader, waiting for room.
+func (s *RowStream) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	select {
+	case s.batches <- s.pending:
+		s.pending = make([][]interface{}, 0, streamBatchRows)
+		return nil
+	case <-s.closed:
+		return aulerrors.New(aulerrors.ErrCodeExecCancelled, "result stream closed").
+			WithOp("RowStream").
+			Err()
+	}
+}
+
+// streamColumnType names the type of a streamed column for the protocols,
+// as columnType does for a result set held in memory, from the
+// database's name for it.
+func streamColumnType(dbType string) string {
+	t := strings.ToUpper(dbType)
+	if strings.Contains(t, "BLOB") || strings.Contains(t, "BINARY") || t == "BYTEA" {
+		return "varbinary"
+	}
+	return "varchar"
+}
+
+// ExecuteSQLStream runs sql as ExecuteSQL does, except that the result
+// set of a batch of one SELECT that reads the database is returned as
+// ResultSets[0].Stream, without Rows, as soon as its columns are known.
+// The stream's reader must read it to the end or close it. Other batches,
+// and SELECTs served without the database, return their results as
+// ExecuteSQL does.
+func (r *Runtime) ExecuteSQLStream(ctx context.Context, sql string, execCtx *ExecContext) (*ExecResult, error) {
+	if !tsqlruntime.Streamable(sql) {
+		return r.ExecuteSQL(ctx, sql, execCtx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &RowStream{
+		started: make(chan struct{}),
+		batches: make(chan [][]interface{}, streamBatches),
+		closed:  make(chan struct{}),
+		cancel:  cancel,
+		pending: make([][]interface{}, 0, streamBatchRows),
+	}
+	streamCtx := *execCtx
+	streamCtx.rowSink = streamSink{s: s}
+
+	type outcome struct {
+		result *ExecResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := r.ExecuteSQL(ctx, sql, &streamCtx)
+		cancel()
+		if err == nil {
+			err = s.flush()
+		}
+		s.err = err
+		close(s.batches)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case <-s.started:
+	case o := <-done:
+		select {
+		case <-s.started:
+			// Every row is in the stream already
+		default:
+			return o.result, o.err
+		}
+	}
+	return &ExecResult{ResultSets: []ResultSet{{Columns: s.columns, Stream: s}}}, nil
+}
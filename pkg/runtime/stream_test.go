@@ -0,0 +1,84 @@
+package runtime_test
+
+import (
+	"context"
+	"testing"
+
+	pkglog "github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/storage"
+)
+
+func TestExecuteSQLStream(t *testing.T) {
+	ctx := context.Background()
+	backend, err := storage.NewSQLiteStorage(storage.DefaultSQLiteConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	if _, err := backend.GetDB().Exec("CREATE TABLE events (id INT, payload TEXT); " +
+		"WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 5000) " +
+		"INSERT INTO events SELECT i, 'event ' || i FROM n"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), pkglog.New(pkglog.Config{DefaultLevel: pkglog.LevelError}))
+	rt.SetStorage(backend)
+	execCtx := &runtime.ExecContext{SessionID: "s1"}
+
+	open := func(sql string) *runtime.RowStream {
+		t.Helper()
+		result, err := rt.ExecuteSQLStream(ctx, sql, execCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.ResultSets) != 1 || result.ResultSets[0].Stream == nil {
+			t.Fatalf("%s: not streamed: %+v", sql, result)
+		}
+		return result.ResultSets[0].Stream
+	}
+
+	// Read to the end
+	stream := open("SELECT id, payload FROM events ORDER BY id")
+	var last interface{}
+	for stream.Next() {
+		last = stream.Row()[0]
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if stream.RowCount() != 5000 || last != int64(5000) {
+		t.Errorf("read %d rows, last %v", stream.RowCount(), last)
+	}
+
+	// Closed early, leaving the session free for the next query
+	stream = open("WITH recent AS (SELECT id FROM events WHERE id > 100) SELECT id FROM recent")
+	for i := 0; i < 10 && stream.Next(); i++ {
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := rt.ExecuteSQL(ctx, "SELECT COUNT(*) FROM events", execCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := result.ResultSets[0].Rows[0][0]; n != int64(5000) {
+		t.Errorf("count after close: %v", n)
+	}
+
+	// Batches of more than one statement are returned whole
+	result, err = rt.ExecuteSQLStream(ctx, "SELECT id FROM events WHERE id = 1; SELECT id FROM events WHERE id = 2", execCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.ResultSets) != 2 || result.ResultSets[0].Stream != nil || len(result.ResultSets[1].Rows) != 1 {
+		t.Errorf("batch: %+v", result.ResultSets)
+	}
+
+	// A query failing before its columns are known fails as ExecuteSQL does
+	if _, err := rt.ExecuteSQLStream(ctx, "SELECT id FROM missing", execCtx); err == nil {
+		t.Error("query of a missing table succeeded")
+	}
+}
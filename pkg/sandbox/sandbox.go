@@ -0,0 +1,210 @@
+// Package sandbox limits what the statements of procedures may do as they
+// run.
+//
+// Policies, read from a JSON file at startup, allow or deny capabilities:
+// running dynamic SQL, calling out of the server, changing the schema and
+// reaching other databases (see tsqlruntime.Capabilities). A policy applies
+// to the procedures it names by pattern or schema, to the statements of the
+// users and roles it names, or to both; one naming no procedure or schema
+// applies to ad-hoc batches too. The first policy that applies
+// and lists a capability decides it; a capability no policy lists is
+// allowed. The interpreter checks each statement as it runs, so that a
+// denied statement fails whatever procedure, dynamic SQL or batch it
+// comes from.
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// Config is the sandbox's policies.
+type Config struct {
+	Roles    map[string][]string `json:"roles,omitempty"` // Users in each role, for policies' Roles
+	Policies []Policy            `json:"policies"`
+}
+
+// Policy allows or denies capabilities to the statements it applies to.
+// It applies when it meets every condition it gives; one with none
+// applies to every statement. Names are compared without regard to case.
+type Policy struct {
+	Name       string   `json:"name"`
+	Procedures []string `json:"procedures,omitempty"` // Patterns, as in path.Match, of procedures
+	Schemas    []string `json:"schemas,omitempty"`    // Schemas of procedures
+	Users      []string `json:"users,omitempty"`      // Users; with Roles, a statement run for either applies
+	Roles      []string `json:"roles,omitempty"`      // Roles of Config.Roles
+	Allow      []string `json:"allow,omitempty"`      // Capabilities allowed
+	Deny       []string `json:"deny,omitempty"`       // Capabilities denied
+	Message    string   `json:"message,omitempty"`    // Told to the clients it denies
+}
+
+// LoadConfig reads a policy file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigMissing,
+			"failed to read sandbox policies").
+			WithOp("sandbox.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, aulerrors.Wrap(err, aulerrors.ErrCodeConfigParse,
+			"failed to parse sandbox policies").
+			WithOp("sandbox.LoadConfig").
+			WithField("path", path).
+			Err()
+	}
+	return cfg, nil
+}
+
+// Sandbox decides on capabilities by its policies.
+type Sandbox struct {
+	roles    map[string]map[string]bool // Lower-cased users by lower-cased role
+	policies []Policy
+}
+
+// New returns the sandbox of cfg.
+func New(cfg Config) (*Sandbox, error) {
+	s := &Sandbox{roles: make(map[string]map[string]bool)}
+	for role, users := range cfg.Roles {
+		members := make(map[string]bool, len(users))
+		for _, user := range users {
+			members[strings.ToLower(user)] = true
+		}
+		s.roles[strings.ToLower(role)] = members
+	}
+	for _, p := range cfg.Policies {
+		if err := s.validate(p); err != nil {
+			return nil, aulerrors.Wrap(err, aulerrors.ErrCodeConfigInvalid, "invalid sandbox policies").
+				WithOp("sandbox.New").
+				Err()
+		}
+		s.policies = append(s.policies, p)
+	}
+	return s, nil
+}
+
+func (s *Sandbox) validate(p Policy) error {
+	if p.Name == "" {
+		return fmt.Errorf("a policy has no name")
+	}
+	if len(p.Allow) == 0 && len(p.Deny) == 0 {
+		return fmt.Errorf("policy %s allows and denies nothing", p.Name)
+	}
+	for _, capability := range append(slices.Clip(p.Allow), p.Deny...) {
+		if !containsFold(tsqlruntime.Capabilities, capability) {
+			return fmt.Errorf("policy %s: unknown capability %q", p.Name, capability)
+		}
+	}
+	for _, role := range p.Roles {
+		if _, ok := s.roles[strings.ToLower(role)]; !ok {
+			return fmt.Errorf("policy %s: role %q is not defined", p.Name, role)
+		}
+	}
+	for _, pattern := range p.Procedures {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("policy %s: procedure pattern %q: %w", p.Name, pattern, err)
+		}
+	}
+	return nil
+}
+
+// Denied returns the policy denying capability to the statements user
+// runs in procedure, or in an ad-hoc batch when procedure is "", and its
+// message; ok is false when the capability is allowed.
+func (s *Sandbox) Denied(capability, user, procedure string) (policy, message string, ok bool) {
+	proc := procedureName(procedure)
+	for _, p := range s.policies {
+		if !s.applies(p, user, proc) {
+			continue
+		}
+		switch {
+		case containsFold(p.Deny, capability):
+			return p.Name, p.Message, true
+		case containsFold(p.Allow, capability):
+			return "", "", false
+		}
+	}
+	return "", "", false
+}
+
+// applies reports whether p applies to the statements user runs in proc,
+// a lower-cased schema.name, or "" for an ad-hoc batch.
+func (s *Sandbox) applies(p Policy, user, proc string) bool {
+	if len(p.Users) > 0 || len(p.Roles) > 0 {
+		if user == "" || !(containsFold(p.Users, user) || s.inRole(p.Roles, user)) {
+			return false
+		}
+	}
+	if len(p.Procedures) > 0 && (proc == "" || !matchProcedure(p.Procedures, proc)) {
+		return false
+	}
+	if len(p.Schemas) > 0 {
+		schema, _, _ := strings.Cut(proc, ".")
+		if proc == "" || !containsFold(p.Schemas, schema) {
+			return false
+		}
+	}
+	return true
+}
+
+// inRole reports whether user is in one of roles.
+func (s *Sandbox) inRole(roles []string, user string) bool {
+	for _, role := range roles {
+		if s.roles[strings.ToLower(role)][strings.ToLower(user)] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchProcedure reports whether proc, a lower-cased schema.name, is
+// matched by one of patterns. A pattern without a schema matches the name
+// in any schema.
+func matchProcedure(patterns []string, proc string) bool {
+	_, name, _ := strings.Cut(proc, ".")
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		target := proc
+		if !strings.Contains(pattern, ".") {
+			target = name
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// procedureName lower-cases a procedure's name, takes out its brackets
+// and any database, and qualifies it with dbo if it has no schema.
+func procedureName(name string) string {
+	if name == "" {
+		return ""
+	}
+	name = strings.ToLower(strings.NewReplacer("[", "", "]", "", `"`, "").Replace(name))
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		parts = parts[len(parts)-2:]
+	}
+	if len(parts) == 1 || parts[0] == "" {
+		parts = []string{"dbo", parts[len(parts)-1]}
+	}
+	return parts[0] + "." + parts[1]
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(item string) bool { return strings.EqualFold(item, s) })
+}
@@ -0,0 +1,67 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+func TestDenied(t *testing.T) {
+	s, err := New(Config{
+		Roles: map[string][]string{"developers": {"Ada", "grace"}},
+		Policies: []Policy{
+			{Name: "developers", Roles: []string{"developers"}, Allow: []string{"ddl", "dynamic_sql"}},
+			{Name: "admin", Schemas: []string{"admin"}, Allow: []string{"ddl"}},
+			{Name: "reports", Procedures: []string{"reports.*", "usp_Report*"}, Deny: []string{"dynamic_sql", "external"},
+				Message: "reports only read"},
+			{Name: "everyone", Deny: []string{"ddl", "cross_database"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		capability, user, procedure string
+		policy                      string // Denying policy, "" when allowed
+	}{
+		{tsqlruntime.CapabilityDDL, "linus", "", "everyone"},
+		{tsqlruntime.CapabilityDDL, "linus", "[admin].[usp_Migrate]", ""},
+		{tsqlruntime.CapabilityDDL, "ADA", "dbo.usp_Anything", ""},
+		{tsqlruntime.CapabilityDDL, "linus", "dbo.usp_Anything", "everyone"},
+		{tsqlruntime.CapabilityDynamicSQL, "linus", "", ""},
+		{tsqlruntime.CapabilityDynamicSQL, "linus", "reports.Daily", "reports"},
+		{tsqlruntime.CapabilityDynamicSQL, "grace", "reports.Daily", ""},
+		{tsqlruntime.CapabilityExternal, "grace", "usp_ReportSales", "reports"},
+		{tsqlruntime.CapabilityExternal, "linus", "sales.db.dbo.usp_ReportSales", "reports"},
+		{tsqlruntime.CapabilityExternal, "linus", "dbo.usp_Sales", ""},
+		{tsqlruntime.CapabilityCrossDatabase, "ada", "admin.usp_Migrate", "everyone"},
+	} {
+		policy, message, ok := s.Denied(tc.capability, tc.user, tc.procedure)
+		if ok != (tc.policy != "") || policy != tc.policy {
+			t.Errorf("%s by %s in %q: got %q, %v, want %q", tc.capability, tc.user, tc.procedure, policy, ok, tc.policy)
+		}
+		if policy == "reports" && message != "reports only read" {
+			t.Errorf("message %q", message)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Policies: []Policy{{Deny: []string{"ddl"}}}}, "has no name"},
+		{Config{Policies: []Policy{{Name: "p"}}}, "allows and denies nothing"},
+		{Config{Policies: []Policy{{Name: "p", Deny: []string{"shell"}}}}, `unknown capability "shell"`},
+		{Config{Policies: []Policy{{Name: "p", Deny: []string{"ddl"}, Roles: []string{"ops"}}}}, `role "ops" is not defined`},
+		{Config{Policies: []Policy{{Name: "p", Deny: []string{"ddl"}, Procedures: []string{"usp_["}}}}, "procedure pattern"},
+	} {
+		_, err := New(tc.cfg)
+		if aulerrors.GetCode(err) != aulerrors.ErrCodeConfigInvalid || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: got %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+}
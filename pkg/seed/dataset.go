@@ -0,0 +1,376 @@
+package seed
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// maxAttempts bounds how often a row is regenerated when it collides with
+// a unique key before it is dropped.
+const maxAttempts = 20
+
+// Dataset is the generated data for a schema, in insertion order.
+type Dataset struct {
+	Seed   int64
+	Tables []*TableData
+}
+
+// Table returns the generated data for the named table, or nil.
+func (d *Dataset) Table(name string) *TableData {
+	key := tableKey(name)
+	for _, td := range d.Tables {
+		if tableKey(td.Table.Name) == key {
+			return td
+		}
+	}
+	return nil
+}
+
+// TableData is the generated rows of one table.
+type TableData struct {
+	Table   *Table
+	Columns []*Column  // Inserted columns, in schema order
+	Rows    [][]string // T-SQL literals, one per inserted column
+
+	// Dropped counts rows abandoned because they kept colliding with a
+	// unique key, e.g. when a link table asks for more rows than there are
+	// distinct pairs.
+	Dropped int
+}
+
+// values returns every generated literal of a column. Identity values are
+// those the server will assign to a freshly created table.
+func (td *TableData) values(column string) []string {
+	c := td.Table.Column(column)
+	if c == nil {
+		return nil
+	}
+	vals := make([]string, len(td.Rows))
+	if c.Identity {
+		for i := range td.Rows {
+			vals[i] = strconv.FormatInt(c.IdentitySeed+int64(i)*c.IdentityIncrement, 10)
+		}
+		return vals
+	}
+	idx := -1
+	for i, ic := range td.Columns {
+		if ic == c {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil
+	}
+	for i, row := range td.Rows {
+		vals[i] = row[idx]
+	}
+	return vals
+}
+
+// Generate produces data for every table in the schema.
+func Generate(schema *Schema, spec *Spec) (*Dataset, error) {
+	if spec == nil {
+		spec = NewSpec()
+	}
+	for name := range spec.Tables {
+		if schema.Table(name) == nil {
+			return nil, fmt.Errorf("spec names unknown table %s", name)
+		}
+	}
+
+	// Resolve a generator for every inserted column
+	gens := make(map[*Column]*generator)
+	for _, t := range schema.Tables {
+		for _, c := range t.Columns {
+			expr, explicit := spec.column(t.Name, c.Name)
+			if !c.Inserted() {
+				if explicit {
+					return nil, fmt.Errorf("%s.%s is an identity or computed column and cannot be generated", t.Name, c.Name)
+				}
+				continue
+			}
+			var g *generator
+			var err error
+			if explicit {
+				g, err = parseGenerator(expr, c)
+				if err == nil && g.refTable != "" && schema.Table(g.refTable) == nil {
+					err = fmt.Errorf("%q: unknown table %s", expr, g.refTable)
+				}
+			} else {
+				g, err = inferGenerator(t, c)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t.Name, c.Name, err)
+			}
+			gens[c] = g
+		}
+		if ts, ok := spec.Tables[tableKey(t.Name)]; ok {
+			for col := range ts.Columns {
+				if t.Column(col) == nil {
+					return nil, fmt.Errorf("spec names unknown column %s.%s", t.Name, col)
+				}
+			}
+		}
+	}
+
+	order, err := insertionOrder(schema, gens)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dataset{Seed: spec.Seed}
+	for _, t := range order {
+		td, err := generateTable(d, t, gens, spec.rows(t.Name), spec.Seed)
+		if err != nil {
+			return nil, err
+		}
+		d.Tables = append(d.Tables, td)
+	}
+	return d, nil
+}
+
+// insertionOrder sorts tables so that every table comes after those its
+// ref() generators read from. Ties keep schema order.
+func insertionOrder(schema *Schema, gens map[*Column]*generator) ([]*Table, error) {
+	deps := make(map[*Table][]*Table)
+	for _, t := range schema.Tables {
+		for _, c := range t.Columns {
+			if g := gens[c]; g != nil && g.refTable != "" {
+				if target := schema.Table(g.refTable); target != t {
+					deps[t] = append(deps[t], target)
+				}
+			}
+		}
+	}
+
+	var order []*Table
+	done := make(map[*Table]bool)
+	for len(order) < len(schema.Tables) {
+		progress := false
+		for _, t := range schema.Tables {
+			if done[t] {
+				continue
+			}
+			ready := true
+			for _, dep := range deps[t] {
+				if !done[dep] {
+					ready = false
+				}
+			}
+			if ready {
+				order = append(order, t)
+				done[t] = true
+				progress = true
+			}
+		}
+		if !progress {
+			var stuck []string
+			for _, t := range schema.Tables {
+				if !done[t] {
+					stuck = append(stuck, t.Name)
+				}
+			}
+			return nil, fmt.Errorf("foreign keys form a cycle between %s; set one of the columns to null in the spec",
+				strings.Join(stuck, ", "))
+		}
+	}
+	return order, nil
+}
+
+func generateTable(d *Dataset, t *Table, gens map[*Column]*generator, rows int, seed int64) (*TableData, error) {
+	td := &TableData{Table: t}
+	for _, c := range t.Columns {
+		if c.Inserted() {
+			td.Columns = append(td.Columns, c)
+		}
+	}
+
+	// Each table has its own source so its data does not depend on which
+	// other tables are generated
+	h := fnv.New64a()
+	h.Write([]byte(tableKey(t.Name)))
+	ctx := &genContext{
+		rng:   rand.New(rand.NewSource(seed ^ int64(h.Sum64()))),
+		data:  d,
+		table: td,
+	}
+
+	// Unique keys made only of inserted columns must be checked; identity
+	// columns are unique by construction
+	var keys [][]int
+	for _, key := range t.Keys {
+		var idx []int
+		for _, name := range key {
+			for i, c := range td.Columns {
+				if strings.EqualFold(c.Name, name) {
+					idx = append(idx, i)
+				}
+			}
+		}
+		if len(idx) == len(key) {
+			keys = append(keys, idx)
+		}
+	}
+	seen := make([]map[string]bool, len(keys))
+	for i := range seen {
+		seen[i] = make(map[string]bool)
+	}
+
+	for len(td.Rows)+td.Dropped < rows {
+		ctx.row = len(td.Rows)
+		var row []string
+		for attempt := 0; ; attempt++ {
+			if attempt == maxAttempts {
+				row = nil
+				break
+			}
+			row = make([]string, len(td.Columns))
+			for i, c := range td.Columns {
+				v, err := gens[c].fn(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("%s.%s: %w", t.Name, c.Name, err)
+				}
+				row[i] = toLiteral(c, v)
+			}
+			if !collides(row, keys, seen) {
+				break
+			}
+		}
+		if row == nil {
+			td.Dropped++
+			continue
+		}
+		for k, idx := range keys {
+			seen[k][keyOf(row, idx)] = true
+		}
+		td.Rows = append(td.Rows, row)
+	}
+	return td, nil
+}
+
+func collides(row []string, keys [][]int, seen []map[string]bool) bool {
+	for k, idx := range keys {
+		if seen[k][keyOf(row, idx)] {
+			return true
+		}
+	}
+	return false
+}
+
+func keyOf(row []string, idx []int) string {
+	parts := make([]string, len(idx))
+	for i, j := range idx {
+		parts[i] = row[j]
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// toLiteral renders a generated value as a T-SQL literal for column c.
+// Strings are truncated to the column length.
+func toLiteral(c *Column, v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case literal:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case string:
+		if c.Length > 0 && len([]rune(v)) > c.Length {
+			v = string([]rune(v)[:c.Length])
+		}
+		quoted := "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		// Only use N'' where the text needs it: aul's INSERT path does not
+		// accept the prefix yet, and Latin-1 text survives without it
+		if strings.HasPrefix(c.Type, "n") && !isLatin1(v) {
+			return "N" + quoted
+		}
+		return quoted
+	}
+	return fmt.Sprintf("'%v'", v)
+}
+
+func isLatin1(s string) bool {
+	for _, r := range s {
+		if r > 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultBatchSize is the number of rows per INSERT statement.
+const DefaultBatchSize = 100
+
+// Statements returns the INSERT statements that load the dataset, each
+// covering up to batchSize rows.
+func (d *Dataset) Statements(batchSize int) []string {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	var stmts []string
+	for _, td := range d.Tables {
+		if len(td.Rows) == 0 || len(td.Columns) == 0 {
+			continue
+		}
+		names := make([]string, len(td.Columns))
+		for i, c := range td.Columns {
+			names[i] = c.Name
+		}
+		head := fmt.Sprintf("INSERT INTO %s (%s) VALUES\n", td.Table.Name, strings.Join(names, ", "))
+
+		for start := 0; start < len(td.Rows); start += batchSize {
+			end := start + batchSize
+			if end > len(td.Rows) {
+				end = len(td.Rows)
+			}
+			var b strings.Builder
+			b.WriteString(head)
+			for i, row := range td.Rows[start:end] {
+				if i > 0 {
+					b.WriteString(",\n")
+				}
+				b.WriteString("  (" + strings.Join(row, ", ") + ")")
+			}
+			stmts = append(stmts, b.String())
+		}
+	}
+	return stmts
+}
+
+// WriteScript writes the dataset as a T-SQL script of INSERT batches
+// separated by GO, preceded by the schema batches when schema is non-nil.
+func (d *Dataset) WriteScript(w io.Writer, schema *Schema, batchSize int) error {
+	if _, err := fmt.Fprintf(w, "-- Generated by aul seed (seed %d)\n", d.Seed); err != nil {
+		return err
+	}
+	for _, td := range d.Tables {
+		fmt.Fprintf(w, "-- %s: %d rows", td.Table.Name, len(td.Rows))
+		if td.Dropped > 0 {
+			fmt.Fprintf(w, " (%d dropped as duplicates)", td.Dropped)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+
+	var batches []string
+	if schema != nil {
+		batches = append(batches, schema.Batches...)
+	}
+	batches = append(batches, d.Statements(batchSize)...)
+	for _, b := range batches {
+		if _, err := fmt.Fprintf(w, "%s\nGO\n\n", b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
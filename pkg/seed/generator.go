@@ -0,0 +1,482 @@
+package seed
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// genContext is the state a generator sees for one value.
+type genContext struct {
+	rng   randSource
+	row   int        // Index of the row being generated
+	data  *Dataset   // Tables generated so far, for references
+	table *TableData // Table being generated, for self-references
+}
+
+// randSource is the subset of *rand.Rand generators use.
+type randSource interface {
+	Intn(n int) int
+	Int63n(n int64) int64
+	Float64() float64
+}
+
+// generator produces one column value: nil for NULL, or an int64, bool,
+// string or literal.
+type generator struct {
+	expr string
+	fn   func(ctx *genContext) (interface{}, error)
+
+	// refTable is the table a ref() generator reads from.
+	refTable string
+}
+
+// literal is a value that is already a T-SQL literal.
+type literal string
+
+// Default date range for inferred date and time columns.
+var (
+	defaultFrom = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	defaultTo   = time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+)
+
+// parseGenerator parses a generator expression such as "int(1, 100)".
+func parseGenerator(expr string, col *Column) (*generator, error) {
+	expr = strings.TrimSpace(expr)
+	name, args := expr, []string(nil)
+	if open := strings.Index(expr, "("); open >= 0 {
+		if !strings.HasSuffix(expr, ")") {
+			return nil, fmt.Errorf("%q: missing )", expr)
+		}
+		name = strings.TrimSpace(expr[:open])
+		if inner := strings.TrimSpace(expr[open+1 : len(expr)-1]); inner != "" {
+			for _, a := range strings.Split(inner, ",") {
+				args = append(args, unquote(strings.TrimSpace(a)))
+			}
+		}
+	}
+	name = strings.ToLower(name)
+
+	g := &generator{expr: expr}
+	wantArgs := func(min, max int) error {
+		if len(args) < min || len(args) > max {
+			return fmt.Errorf("%q: %s takes %d to %d arguments", expr, name, min, max)
+		}
+		return nil
+	}
+
+	switch name {
+	case "first_name", "last_name", "name", "username", "email", "phone",
+		"city", "country", "address", "company", "word", "url", "slug", "uuid", "bool":
+		if err := wantArgs(0, 0); err != nil {
+			return nil, err
+		}
+		g.fn = simpleGenerators[name]
+
+	case "null":
+		if err := wantArgs(0, 0); err != nil {
+			return nil, err
+		}
+		g.fn = func(*genContext) (interface{}, error) { return nil, nil }
+
+	case "value":
+		if err := wantArgs(1, 1); err != nil {
+			return nil, err
+		}
+		v := args[0]
+		g.fn = func(*genContext) (interface{}, error) { return v, nil }
+
+	case "oneof":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%q: oneof needs at least one value", expr)
+		}
+		g.fn = func(ctx *genContext) (interface{}, error) { return args[ctx.rng.Intn(len(args))], nil }
+
+	case "sentence":
+		if err := wantArgs(0, 2); err != nil {
+			return nil, err
+		}
+		lo, hi, err := intRange(args, 6, 12)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", expr, err)
+		}
+		g.fn = func(ctx *genContext) (interface{}, error) {
+			return sentence(ctx, int(lo+ctx.rng.Int63n(hi-lo+1))), nil
+		}
+
+	case "hex":
+		if err := wantArgs(0, 1); err != nil {
+			return nil, err
+		}
+		n, _, err := intRange(args, 32, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", expr, err)
+		}
+		g.fn = func(ctx *genContext) (interface{}, error) {
+			return hex.EncodeToString(randomBytes(ctx, int(n+1)/2))[:n], nil
+		}
+
+	case "binary":
+		if err := wantArgs(0, 1); err != nil {
+			return nil, err
+		}
+		n, _, err := intRange(args, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", expr, err)
+		}
+		g.fn = func(ctx *genContext) (interface{}, error) {
+			return literal("0x" + strings.ToUpper(hex.EncodeToString(randomBytes(ctx, int(n))))), nil
+		}
+
+	case "int":
+		if err := wantArgs(0, 2); err != nil {
+			return nil, err
+		}
+		lo, hi, err := intRange(args, 1, 1000)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", expr, err)
+		}
+		g.fn = func(ctx *genContext) (interface{}, error) { return lo + ctx.rng.Int63n(hi-lo+1), nil }
+
+	case "seq":
+		if err := wantArgs(0, 2); err != nil {
+			return nil, err
+		}
+		start, step := int64(1), int64(1)
+		var err error
+		if len(args) >= 1 {
+			start, err = strconv.ParseInt(args[0], 10, 64)
+		}
+		if err == nil && len(args) == 2 {
+			step, err = strconv.ParseInt(args[1], 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%q: expected seq(start[, step])", expr)
+		}
+		g.fn = func(ctx *genContext) (interface{}, error) { return start + int64(ctx.row)*step, nil }
+
+	case "decimal":
+		if err := wantArgs(0, 3); err != nil {
+			return nil, err
+		}
+		lo, hi, scale := 0.0, 1000.0, 2
+		if col != nil && col.Scale > 0 {
+			scale = col.Scale
+		}
+		var err error
+		if len(args) >= 2 {
+			if lo, err = strconv.ParseFloat(args[0], 64); err == nil {
+				hi, err = strconv.ParseFloat(args[1], 64)
+			}
+		}
+		if err == nil && len(args) == 3 {
+			scale, err = strconv.Atoi(args[2])
+		}
+		if err != nil || len(args) == 1 || hi < lo {
+			return nil, fmt.Errorf("%q: expected decimal(min, max[, scale])", expr)
+		}
+		if col != nil && col.Precision > col.Scale {
+			if limit := math.Pow10(col.Precision-col.Scale) - 1; hi > limit {
+				hi = limit
+			}
+		}
+		g.fn = func(ctx *genContext) (interface{}, error) {
+			v := lo + ctx.rng.Float64()*(hi-lo)
+			return literal(strconv.FormatFloat(v, 'f', scale, 64)), nil
+		}
+
+	case "date", "datetime", "time":
+		if err := wantArgs(0, 2); err != nil {
+			return nil, err
+		}
+		from, to := defaultFrom, defaultTo
+		if len(args) == 1 {
+			return nil, fmt.Errorf("%q: expected %s(from, to)", expr, name)
+		}
+		if len(args) == 2 {
+			var err error
+			if from, err = parseDate(args[0]); err == nil {
+				to, err = parseDate(args[1])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", expr, err)
+			}
+			if to.Before(from) {
+				return nil, fmt.Errorf("%q: range ends before it starts", expr)
+			}
+		}
+		layout := map[string]string{
+			"date":     "2006-01-02",
+			"datetime": "2006-01-02 15:04:05",
+			"time":     "15:04:05",
+		}[name]
+		span := int64(to.Sub(from)/time.Second) + 1
+		g.fn = func(ctx *genContext) (interface{}, error) {
+			t := from.Add(time.Duration(ctx.rng.Int63n(span)) * time.Second)
+			return literal("'" + t.Format(layout) + "'"), nil
+		}
+
+	case "ref":
+		if err := wantArgs(1, 1); err != nil {
+			return nil, err
+		}
+		dot := strings.LastIndex(args[0], ".")
+		if dot <= 0 {
+			return nil, fmt.Errorf("%q: expected ref(table.column)", expr)
+		}
+		table, column := args[0][:dot], args[0][dot+1:]
+		nullable := col != nil && col.Nullable
+		g.refTable = table
+		g.fn = func(ctx *genContext) (interface{}, error) {
+			return ctx.reference(table, column, nullable)
+		}
+
+	default:
+		return nil, fmt.Errorf("%q: unknown generator %q", expr, name)
+	}
+	return g, nil
+}
+
+// reference picks an existing value of table.column. Self-references
+// choose among earlier rows and leave a share of rows NULL so the data
+// forms trees rather than a single chain.
+func (ctx *genContext) reference(table, column string, nullable bool) (interface{}, error) {
+	var values []string
+	self := tableKey(table) == tableKey(ctx.table.Table.Name)
+	if self {
+		values = ctx.table.values(column)
+		if nullable && (len(values) == 0 || ctx.rng.Intn(10) < 3) {
+			return nil, nil
+		}
+	} else if td := ctx.data.Table(table); td != nil {
+		values = td.values(column)
+	}
+	if len(values) == 0 {
+		if nullable {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s.%s has no rows to reference", table, column)
+	}
+	return literal(values[ctx.rng.Intn(len(values))]), nil
+}
+
+// inferGenerator picks a generator for a column the spec does not cover,
+// from its foreign key, name and type.
+func inferGenerator(t *Table, c *Column) (*generator, error) {
+	if c.References != nil {
+		return parseGenerator(fmt.Sprintf("ref(%s.%s)", c.References.Table, c.References.Column), c)
+	}
+
+	name := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(c.Name))
+	has := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(name, w) {
+				return true
+			}
+		}
+		return false
+	}
+	unique := false
+	for _, key := range t.Keys {
+		if len(key) == 1 && strings.EqualFold(key[0], c.Name) {
+			unique = true
+		}
+	}
+
+	var expr string
+	switch c.Type {
+	case "char", "nchar", "varchar", "nvarchar", "text", "ntext":
+		switch {
+		case has("email"):
+			expr = "email"
+		case has("firstname", "givenname", "forename"):
+			expr = "first_name"
+		case has("lastname", "surname", "familyname"):
+			expr = "last_name"
+		case has("username", "login"):
+			expr = "username"
+		case has("phone", "mobile", "fax"):
+			expr = "phone"
+		case has("city", "town"):
+			expr = "city"
+		case has("country"):
+			expr = "country"
+		case has("address", "street"):
+			expr = "address"
+		case has("company", "organisation", "organization", "employer"):
+			expr = "company"
+		case has("url", "website", "image", "avatar", "link"):
+			expr = "url"
+		case has("slug"):
+			expr = "slug"
+		case has("hash", "token", "secret"):
+			expr = "hex(32)"
+		case has("title", "subject", "headline"):
+			expr = "sentence(3, 6)"
+		case has("description", "content", "body", "bio", "notes", "comment", "excerpt", "summary", "text", "message"):
+			expr = "sentence(8, 20)"
+		case unique:
+			expr = "slug"
+		case has("name"):
+			expr = "name"
+		default:
+			expr = "word"
+		}
+	case "int", "bigint", "smallint", "tinyint":
+		switch {
+		case unique:
+			expr = "seq(1)"
+		case has("year"):
+			expr = "int(2000, 2025)"
+		case has("count", "qty", "quantity", "stock", "age"):
+			expr = "int(0, 100)"
+		case c.Type == "tinyint":
+			expr = "int(0, 255)"
+		default:
+			expr = "int(1, 1000)"
+		}
+	case "bit":
+		expr = "bool"
+	case "decimal", "numeric", "money", "smallmoney", "float", "real":
+		expr = "decimal(0, 1000)"
+	case "date":
+		expr = "date"
+	case "datetime", "datetime2", "smalldatetime", "datetimeoffset":
+		expr = "datetime"
+	case "time":
+		expr = "time"
+	case "uniqueidentifier":
+		expr = "uuid"
+	case "binary", "varbinary", "image":
+		expr = "binary(16)"
+	default:
+		if c.Nullable {
+			expr = "null"
+		} else {
+			return nil, fmt.Errorf("%s.%s: no generator for type %s; set one in the spec", t.Name, c.Name, c.Type)
+		}
+	}
+	return parseGenerator(expr, c)
+}
+
+var simpleGenerators = map[string]func(ctx *genContext) (interface{}, error){
+	"first_name": func(ctx *genContext) (interface{}, error) { return pick(ctx, firstNames), nil },
+	"last_name":  func(ctx *genContext) (interface{}, error) { return pick(ctx, lastNames), nil },
+	"name": func(ctx *genContext) (interface{}, error) {
+		return pick(ctx, firstNames) + " " + pick(ctx, lastNames), nil
+	},
+	// Usernames, emails and slugs carry the row number so that they are
+	// unique, as such columns usually are
+	"username": func(ctx *genContext) (interface{}, error) {
+		return fmt.Sprintf("%s%d", asciiLower(pick(ctx, firstNames)), ctx.row+1), nil
+	},
+	"email": func(ctx *genContext) (interface{}, error) {
+		return fmt.Sprintf("%s.%s%d@%s", asciiLower(pick(ctx, firstNames)), asciiLower(pick(ctx, lastNames)),
+			ctx.row+1, pick(ctx, domains)), nil
+	},
+	"slug": func(ctx *genContext) (interface{}, error) {
+		return fmt.Sprintf("%s-%s-%d", pick(ctx, loremWords), pick(ctx, loremWords), ctx.row+1), nil
+	},
+	"phone": func(ctx *genContext) (interface{}, error) {
+		return fmt.Sprintf("+1-555-%03d-%04d", ctx.rng.Intn(1000), ctx.rng.Intn(10000)), nil
+	},
+	"city":    func(ctx *genContext) (interface{}, error) { return pick(ctx, cities), nil },
+	"country": func(ctx *genContext) (interface{}, error) { return pick(ctx, countries), nil },
+	"address": func(ctx *genContext) (interface{}, error) {
+		return fmt.Sprintf("%d %s", 1+ctx.rng.Intn(250), pick(ctx, streets)), nil
+	},
+	"company": func(ctx *genContext) (interface{}, error) {
+		return pick(ctx, companyWords) + " " + pick(ctx, companySuffixes), nil
+	},
+	"word": func(ctx *genContext) (interface{}, error) { return pick(ctx, loremWords), nil },
+	"url": func(ctx *genContext) (interface{}, error) {
+		return fmt.Sprintf("https://%s/%s/%d", pick(ctx, domains), pick(ctx, loremWords), ctx.row+1), nil
+	},
+	"uuid": func(ctx *genContext) (interface{}, error) {
+		b := randomBytes(ctx, 16)
+		b[6] = b[6]&0x0f | 0x40 // Version 4
+		b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+		h := strings.ToUpper(hex.EncodeToString(b))
+		return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:]), nil
+	},
+	"bool": func(ctx *genContext) (interface{}, error) { return ctx.rng.Intn(2) == 1, nil },
+}
+
+func pick(ctx *genContext, words []string) string {
+	return words[ctx.rng.Intn(len(words))]
+}
+
+func sentence(ctx *genContext, n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = pick(ctx, loremWords)
+	}
+	s := strings.Join(words, " ")
+	return strings.ToUpper(s[:1]) + s[1:] + "."
+}
+
+func randomBytes(ctx *genContext, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(ctx.rng.Intn(256))
+	}
+	return b
+}
+
+// asciiLower lower-cases a name and drops anything that does not belong in
+// an email address.
+func asciiLower(s string) string {
+	s = strings.NewReplacer("á", "a", "é", "e", "è", "e", "í", "i", "ó", "o", "ú", "u",
+		"ñ", "n", "ç", "c", "ã", "a", "ö", "o", "ü", "u").Replace(strings.ToLower(s))
+	var b strings.Builder
+	for _, r := range s {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// intRange parses up to two integer arguments, using the defaults for
+// any that are absent.
+func intRange(args []string, lo, hi int64) (int64, int64, error) {
+	var err error
+	if len(args) >= 1 {
+		if lo, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("%q is not an integer", args[0])
+		}
+		if len(args) == 1 {
+			hi = lo
+		}
+	}
+	if len(args) >= 2 {
+		if hi, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("%q is not an integer", args[1])
+		}
+	}
+	if hi < lo {
+		return 0, 0, fmt.Errorf("range ends before it starts")
+	}
+	return lo, hi, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date (want YYYY-MM-DD)", s)
+}
+
+// unquote strips the quotes from a quoted generator argument.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,293 @@
+// Package seed generates reproducible demo and benchmark data for a T-SQL
+// schema.
+//
+// The schema is read from the CREATE TABLE statements of a script. Each
+// column is filled by a generator chosen from its name and type, or named
+// explicitly in a Spec. Foreign keys are resolved against rows generated
+// earlier, so referencing tables always point at rows that exist.
+//
+// Generation is deterministic: the same schema, spec and seed always
+// produce the same data. Each table draws from its own random source
+// derived from the seed and the table name, so adding a table to the spec
+// does not change the data of the others.
+package seed
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// Schema is the set of tables defined by a script.
+type Schema struct {
+	Tables []*Table
+
+	// Batches are the script's batches, split on GO lines, ready to be
+	// executed in order.
+	Batches []string
+}
+
+// Table returns the table with the given name, ignoring case and schema
+// qualification.
+func (s *Schema) Table(name string) *Table {
+	key := tableKey(name)
+	for _, t := range s.Tables {
+		if tableKey(t.Name) == key {
+			return t
+		}
+	}
+	return nil
+}
+
+// Table is a table definition.
+type Table struct {
+	Name    string
+	Columns []*Column
+
+	PrimaryKey []string
+
+	// Keys are the column sets that must be unique: the primary key and
+	// any UNIQUE constraints.
+	Keys [][]string
+}
+
+// Column returns the named column, ignoring case.
+func (t *Table) Column(name string) *Column {
+	for _, c := range t.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Column is a column definition.
+type Column struct {
+	Name      string
+	Type      string // Lower-case base type name, e.g. "nvarchar"
+	Length    int    // Declared length; 0 if none, -1 for MAX
+	Precision int
+	Scale     int
+	Nullable  bool
+
+	Identity          bool
+	IdentitySeed      int64
+	IdentityIncrement int64
+
+	// Computed columns and identity columns are never inserted.
+	Computed bool
+
+	References *Reference
+}
+
+// Reference is the target of a foreign key.
+type Reference struct {
+	Table  string
+	Column string
+}
+
+// Inserted reports whether the column appears in generated INSERTs.
+func (c *Column) Inserted() bool {
+	return !c.Identity && !c.Computed
+}
+
+var goLine = regexp.MustCompile(`(?im)^[ \t]*GO[ \t]*;?[ \t]*$`)
+
+// SplitBatches splits a script into batches on GO lines. Empty batches
+// are dropped.
+func SplitBatches(script string) []string {
+	var batches []string
+	for _, b := range goLine.Split(script, -1) {
+		if strings.TrimSpace(b) != "" {
+			batches = append(batches, strings.TrimSpace(b))
+		}
+	}
+	return batches
+}
+
+// ParseSchema reads the tables defined by a T-SQL script. Statements other
+// than CREATE TABLE are kept in Batches but otherwise ignored.
+func ParseSchema(script string) (*Schema, error) {
+	s := &Schema{Batches: SplitBatches(script)}
+
+	for _, batch := range s.Batches {
+		p := parser.New(lexer.New(batch))
+		program := p.ParseProgram()
+		if errs := p.Errors(); len(errs) > 0 {
+			return nil, fmt.Errorf("parsing schema: %s", errs[0])
+		}
+		for _, stmt := range program.Statements {
+			ct, ok := stmt.(*ast.CreateTableStatement)
+			if !ok || ct.IsTemporary {
+				continue
+			}
+			t, err := tableFromAST(ct)
+			if err != nil {
+				return nil, err
+			}
+			if s.Table(t.Name) != nil {
+				return nil, fmt.Errorf("table %s defined twice", t.Name)
+			}
+			s.Tables = append(s.Tables, t)
+		}
+	}
+
+	if len(s.Tables) == 0 {
+		return nil, fmt.Errorf("schema defines no tables")
+	}
+
+	// Check that every foreign key points at a known column
+	for _, t := range s.Tables {
+		for _, c := range t.Columns {
+			if c.References == nil {
+				continue
+			}
+			target := s.Table(c.References.Table)
+			if target == nil {
+				return nil, fmt.Errorf("%s.%s references unknown table %s", t.Name, c.Name, c.References.Table)
+			}
+			if c.References.Column == "" {
+				c.References.Column = primaryKeyColumn(target)
+			}
+			if target.Column(c.References.Column) == nil {
+				return nil, fmt.Errorf("%s.%s references unknown column %s.%s", t.Name, c.Name, target.Name, c.References.Column)
+			}
+		}
+	}
+	return s, nil
+}
+
+func tableFromAST(ct *ast.CreateTableStatement) (*Table, error) {
+	t := &Table{Name: identifierName(ct.Name)}
+
+	for _, cd := range ct.Columns {
+		c := &Column{
+			Name:     cd.Name.Value,
+			Nullable: cd.Nullable == nil || *cd.Nullable,
+			Computed: cd.Computed != nil,
+		}
+		if cd.DataType != nil {
+			c.Type = strings.ToLower(cd.DataType.Name)
+			if cd.DataType.Max {
+				c.Length = -1
+			} else if cd.DataType.Length != nil {
+				c.Length = *cd.DataType.Length
+			}
+			if cd.DataType.Precision != nil {
+				c.Precision = *cd.DataType.Precision
+			}
+			if cd.DataType.Scale != nil {
+				c.Scale = *cd.DataType.Scale
+			}
+			// The parser records a lone type argument as the precision
+			if c.Length == 0 && isStringType(c.Type) {
+				c.Length, c.Precision = c.Precision, 0
+			}
+		}
+		if cd.Identity != nil {
+			c.Identity = true
+			c.IdentitySeed = cd.Identity.Seed
+			c.IdentityIncrement = cd.Identity.Increment
+			if c.IdentityIncrement == 0 {
+				c.IdentitySeed, c.IdentityIncrement = 1, 1
+			}
+		}
+		for _, cc := range cd.Constraints {
+			switch {
+			case cc.IsPrimaryKey || cc.Type == ast.ConstraintPrimaryKey:
+				c.Nullable = false
+				t.PrimaryKey = []string{c.Name}
+				t.Keys = append(t.Keys, t.PrimaryKey)
+			case cc.Type == ast.ConstraintUnique:
+				t.Keys = append(t.Keys, []string{c.Name})
+			case cc.Type == ast.ConstraintForeignKey && cc.ReferencesTable != nil:
+				c.References = &Reference{Table: identifierName(cc.ReferencesTable)}
+				if len(cc.ReferencesColumns) > 0 {
+					c.References.Column = cc.ReferencesColumns[0].Value
+				}
+			}
+		}
+		t.Columns = append(t.Columns, c)
+	}
+
+	for _, tc := range ct.Constraints {
+		var cols []string
+		for _, ic := range tc.Columns {
+			if ic.Name != nil {
+				cols = append(cols, ic.Name.Value)
+			}
+		}
+		switch tc.Type {
+		case ast.ConstraintPrimaryKey, ast.ConstraintUnique:
+			if len(cols) > 0 {
+				t.Keys = append(t.Keys, cols)
+			}
+			if tc.Type == ast.ConstraintPrimaryKey {
+				t.PrimaryKey = cols
+				for _, name := range cols {
+					if c := t.Column(name); c != nil {
+						c.Nullable = false
+					}
+				}
+			}
+		case ast.ConstraintForeignKey:
+			// Composite foreign keys are seeded column by column, which
+			// only keeps single-column references consistent
+			if tc.ReferencesTable == nil || len(cols) != 1 {
+				continue
+			}
+			c := t.Column(cols[0])
+			if c == nil {
+				return nil, fmt.Errorf("%s: foreign key on unknown column %s", t.Name, cols[0])
+			}
+			c.References = &Reference{Table: identifierName(tc.ReferencesTable)}
+			if len(tc.ReferencesColumns) > 0 {
+				c.References.Column = tc.ReferencesColumns[0].Value
+			}
+		}
+	}
+	return t, nil
+}
+
+// primaryKeyColumn returns the column a foreign key without a column list
+// refers to: the single-column primary key, else the identity column.
+func primaryKeyColumn(t *Table) string {
+	if len(t.PrimaryKey) == 1 {
+		return t.PrimaryKey[0]
+	}
+	for _, c := range t.Columns {
+		if c.Identity {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+func identifierName(q *ast.QualifiedIdentifier) string {
+	parts := make([]string, len(q.Parts))
+	for i, p := range q.Parts {
+		parts[i] = p.Value
+	}
+	return strings.Join(parts, ".")
+}
+
+// tableKey normalises a table name for lookup: brackets, schema and case
+// are ignored.
+func tableKey(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(strings.Trim(name, "[]\""))
+}
+
+func isStringType(typ string) bool {
+	switch typ {
+	case "char", "nchar", "varchar", "nvarchar", "text", "ntext":
+		return true
+	}
+	return false
+}
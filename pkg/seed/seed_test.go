@@ -0,0 +1,384 @@
+package seed
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSchema = `
+CREATE TABLE dbo.customers (
+    id INT IDENTITY(100, 10) PRIMARY KEY,
+    email NVARCHAR(40) NOT NULL UNIQUE,
+    name NVARCHAR(8),
+    balance DECIMAL(6, 2)
+)
+GO
+
+CREATE TABLE orders (
+    order_no INT PRIMARY KEY,
+    customer_id INT NOT NULL REFERENCES customers(id),
+    placed DATE,
+    status VARCHAR(10)
+)
+GO
+
+CREATE TABLE order_tags (
+    order_no INT NOT NULL,
+    tag VARCHAR(10) NOT NULL,
+    PRIMARY KEY (order_no, tag),
+    FOREIGN KEY (order_no) REFERENCES orders(order_no)
+)
+GO
+
+CREATE INDEX ix_orders_customer ON orders(customer_id)
+GO
+`
+
+func mustSchema(t *testing.T, script string) *Schema {
+	t.Helper()
+	s, err := ParseSchema(script)
+	if err != nil {
+		t.Fatalf("ParseSchema: %v", err)
+	}
+	return s
+}
+
+func TestParseSchema(t *testing.T) {
+	s := mustSchema(t, testSchema)
+
+	if len(s.Tables) != 3 || len(s.Batches) != 4 {
+		t.Fatalf("got %d tables, %d batches; want 3, 4", len(s.Tables), len(s.Batches))
+	}
+
+	cust := s.Table("CUSTOMERS")
+	if cust == nil {
+		t.Fatal("customers not found by unqualified name")
+	}
+	id := cust.Column("id")
+	if !id.Identity || id.IdentitySeed != 100 || id.IdentityIncrement != 10 || id.Inserted() {
+		t.Errorf("id = %+v", id)
+	}
+	if email := cust.Column("email"); email.Type != "nvarchar" || email.Length != 40 || email.Nullable {
+		t.Errorf("email = %+v", email)
+	}
+	if bal := cust.Column("balance"); bal.Precision != 6 || bal.Scale != 2 {
+		t.Errorf("balance = %+v", bal)
+	}
+	if !reflect.DeepEqual(cust.Keys, [][]string{{"id"}, {"email"}}) {
+		t.Errorf("customer keys = %v", cust.Keys)
+	}
+
+	ref := s.Table("orders").Column("customer_id").References
+	if ref == nil || ref.Table != "customers" || ref.Column != "id" {
+		t.Errorf("customer_id references %+v", ref)
+	}
+
+	tags := s.Table("order_tags")
+	if !reflect.DeepEqual(tags.PrimaryKey, []string{"order_no", "tag"}) {
+		t.Errorf("order_tags primary key = %v", tags.PrimaryKey)
+	}
+	if tags.Column("order_no").References == nil {
+		t.Error("table-level foreign key not recorded")
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"no tables", "SELECT 1", "no tables"},
+		{"unknown table", "CREATE TABLE a (x INT REFERENCES b(id))", "unknown table b"},
+		{"unknown column", "CREATE TABLE b (id INT)\nGO\nCREATE TABLE a (x INT REFERENCES b(nope))", "unknown column"},
+		{"duplicate", "CREATE TABLE a (x INT)\nGO\nCREATE TABLE a (y INT)", "defined twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchema(tt.script)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSpecYAML(t *testing.T) {
+	spec, err := ParseSpec([]byte(`
+# Demo data
+seed: 42
+default_rows: 3
+tables:
+  customers:
+    rows: 50
+    columns:
+      Status: "oneof(a, b)"   # quoted
+      note: 'value(x # y)'
+  orders: 200
+`))
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+	if spec.Seed != 42 || spec.DefaultRows != 3 {
+		t.Errorf("seed = %d, default_rows = %d", spec.Seed, spec.DefaultRows)
+	}
+	if spec.rows("dbo.Customers") != 50 || spec.rows("orders") != 200 || spec.rows("other") != 3 {
+		t.Errorf("rows = %d, %d, %d", spec.rows("customers"), spec.rows("orders"), spec.rows("other"))
+	}
+	if expr, _ := spec.column("customers", "status"); expr != "oneof(a, b)" {
+		t.Errorf("status = %q", expr)
+	}
+	if expr, _ := spec.column("customers", "note"); expr != "value(x # y)" {
+		t.Errorf("note = %q", expr)
+	}
+}
+
+func TestParseSpecJSON(t *testing.T) {
+	spec, err := ParseSpec([]byte(`{"seed": 7, "tables": {"orders": {"rows": 5, "columns": {"status": "word"}}}}`))
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+	if spec.Seed != 7 || spec.rows("orders") != 5 || spec.DefaultRows != DefaultRows {
+		t.Errorf("spec = %+v", spec)
+	}
+}
+
+func TestParseSpecErrors(t *testing.T) {
+	for _, doc := range []string{
+		"seed: x",
+		"colour: red",
+		"tables:\n  - orders",
+		"tables:\n  orders:\n    rows: -1",
+		"tables:\n  orders:\n    size: 3",
+		"seed: 1\n  nested: 2",
+		"tables:\n\torders: 1",
+	} {
+		if _, err := ParseSpec([]byte(doc)); err == nil {
+			t.Errorf("ParseSpec(%q): expected error", doc)
+		}
+	}
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+	s := mustSchema(t, testSchema)
+	spec := NewSpec()
+	spec.Seed = 1
+
+	script := func(spec *Spec) string {
+		d, err := Generate(s, spec)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := d.WriteScript(&buf, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	first := script(spec)
+	if again := script(spec); again != first {
+		t.Error("same seed produced different data")
+	}
+	spec.Seed = 2
+	if other := script(spec); other == first {
+		t.Error("different seeds produced the same data")
+	}
+}
+
+func TestGenerateTableIndependence(t *testing.T) {
+	s := mustSchema(t, testSchema)
+
+	small := NewSpec()
+	small.Tables["order_tags"] = &TableSpec{Rows: 0}
+	big := NewSpec()
+	big.Tables["order_tags"] = &TableSpec{Rows: 5}
+
+	a, err := Generate(s, small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Generate(s, big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Table("orders").Rows, b.Table("orders").Rows) {
+		t.Error("changing one table's rows changed another table's data")
+	}
+}
+
+func TestGenerateForeignKeys(t *testing.T) {
+	s := mustSchema(t, testSchema)
+	spec := NewSpec()
+	spec.Tables["customers"] = &TableSpec{Rows: 4}
+	spec.Tables["orders"] = &TableSpec{Rows: 30}
+	spec.Tables["order_tags"] = &TableSpec{Rows: 500, Columns: map[string]string{"tag": "oneof(red, blue)"}}
+
+	d, err := Generate(s, spec)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	var names []string
+	for _, td := range d.Tables {
+		names = append(names, td.Table.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"dbo.customers", "orders", "order_tags"}) {
+		t.Errorf("insertion order = %v", names)
+	}
+
+	// Identity values are predicted from IDENTITY(100, 10)
+	valid := map[string]bool{"100": true, "110": true, "120": true, "130": true}
+	for _, v := range d.Table("orders").values("customer_id") {
+		if !valid[v] {
+			t.Errorf("customer_id %s does not reference a customer", v)
+		}
+	}
+
+	// 30 orders x 2 tags leaves at most 60 distinct rows
+	tags := d.Table("order_tags")
+	if len(tags.Rows) > 60 || len(tags.Rows)+tags.Dropped != 500 {
+		t.Errorf("order_tags: %d rows, %d dropped", len(tags.Rows), tags.Dropped)
+	}
+	seen := map[string]bool{}
+	for _, row := range tags.Rows {
+		key := strings.Join(row, ",")
+		if seen[key] {
+			t.Fatalf("duplicate primary key %s", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestGenerateInference(t *testing.T) {
+	s := mustSchema(t, testSchema)
+	spec := NewSpec()
+	spec.Tables["customers"] = &TableSpec{Rows: 20}
+
+	d, err := Generate(s, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cust := d.Table("customers")
+	for _, row := range cust.Rows {
+		email, name, balance := row[0], row[1], row[2]
+		if !strings.Contains(email, "@") {
+			t.Errorf("email = %s", email)
+		}
+		// NVARCHAR(8) plus quotes
+		if len([]rune(name)) > 10 {
+			t.Errorf("name %s exceeds the column length", name)
+		}
+		if len(balance) > len("9999.99") || !strings.Contains(balance, ".") {
+			t.Errorf("balance %s does not fit DECIMAL(6, 2)", balance)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	s := mustSchema(t, testSchema)
+	tests := []struct {
+		name string
+		spec *Spec
+		want string
+	}{
+		{"unknown table", &Spec{Tables: map[string]*TableSpec{"nope": {}}}, "unknown table"},
+		{"unknown column", &Spec{Tables: map[string]*TableSpec{"orders": {Columns: map[string]string{"nope": "word"}}}}, "unknown column"},
+		{"identity", &Spec{Tables: map[string]*TableSpec{"customers": {Columns: map[string]string{"id": "seq(1)"}}}}, "identity"},
+		{"bad generator", &Spec{Tables: map[string]*TableSpec{"orders": {Columns: map[string]string{"status": "colour"}}}}, "unknown generator"},
+		{"no parents", &Spec{DefaultRows: 5, Tables: map[string]*TableSpec{"customers": {Rows: 0}}}, "no rows to reference"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Generate(s, tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateCycle(t *testing.T) {
+	s := mustSchema(t, `
+CREATE TABLE a (id INT PRIMARY KEY, b_id INT REFERENCES b(id))
+GO
+CREATE TABLE b (id INT PRIMARY KEY, a_id INT REFERENCES a(id))
+`)
+	if _, err := Generate(s, NewSpec()); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("error = %v, want cycle", err)
+	}
+
+	// Breaking the cycle in the spec resolves it
+	spec := NewSpec()
+	spec.Tables["a"] = &TableSpec{Rows: 3, Columns: map[string]string{"b_id": "null"}}
+	if _, err := Generate(s, spec); err != nil {
+		t.Fatalf("Generate with cycle broken: %v", err)
+	}
+}
+
+func TestParseGenerator(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"int(1, 10)", true},
+		{"int(10, 1)", false},
+		{"decimal(1)", false},
+		{"date(2020-01-01, 2021-01-01)", true},
+		{"date(2021-01-01, 2020-01-01)", false},
+		{"date(yesterday, today)", false},
+		{"oneof()", false},
+		{"ref(customers)", false},
+		{"seq(10, 5)", true},
+		{"email(x)", false},
+		{"int(1, 10", false},
+	}
+	for _, tt := range tests {
+		_, err := parseGenerator(tt.expr, nil)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseGenerator(%q) error = %v, want ok=%v", tt.expr, err, tt.ok)
+		}
+	}
+}
+
+func TestToLiteral(t *testing.T) {
+	nv := &Column{Type: "nvarchar", Length: 5}
+	tests := []struct {
+		col  *Column
+		v    interface{}
+		want string
+	}{
+		{nv, nil, "NULL"},
+		{nv, int64(42), "42"},
+		{nv, true, "1"},
+		{nv, "O'Brien", "'O''Bri'"},
+		{nv, "東京", "N'東京'"},
+		{&Column{Type: "varchar"}, "plain", "'plain'"},
+		{nv, literal("'2020-01-01'"), "'2020-01-01'"},
+	}
+	for _, tt := range tests {
+		if got := toLiteral(tt.col, tt.v); got != tt.want {
+			t.Errorf("toLiteral(%v) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	s := mustSchema(t, "CREATE TABLE t (id INT IDENTITY(1,1), n INT)")
+	spec := NewSpec()
+	spec.Tables["t"] = &TableSpec{Rows: 5, Columns: map[string]string{"n": "seq(1)"}}
+	d, err := Generate(s, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := d.Statements(2)
+	if len(stmts) != 3 {
+		t.Fatalf("got %d statements, want 3", len(stmts))
+	}
+	want := "INSERT INTO t (n) VALUES\n  (1),\n  (2)"
+	if stmts[0] != want {
+		t.Errorf("statement = %q, want %q", stmts[0], want)
+	}
+}
@@ -0,0 +1,186 @@
+package seed
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRows is the number of rows generated for tables a spec does not
+// mention.
+const DefaultRows = 10
+
+// Spec says how many rows each table gets and, optionally, which
+// generator fills each column.
+//
+//	seed: 42
+//	default_rows: 10
+//	tables:
+//	  users:
+//	    rows: 100
+//	    columns:
+//	      status: oneof(active, suspended)
+//	      created_at: datetime(2022-01-01, 2024-12-31)
+//	  posts:
+//	    rows: 1000
+type Spec struct {
+	Seed        int64
+	DefaultRows int
+	Tables      map[string]*TableSpec // Keyed by lower-case table name
+}
+
+// TableSpec is the part of a Spec for one table.
+type TableSpec struct {
+	Rows    int
+	Columns map[string]string // Generator expressions keyed by lower-case column name
+}
+
+// NewSpec returns an empty spec: every table gets DefaultRows rows of
+// inferred data.
+func NewSpec() *Spec {
+	return &Spec{DefaultRows: DefaultRows, Tables: make(map[string]*TableSpec)}
+}
+
+// rows returns the number of rows to generate for a table.
+func (s *Spec) rows(table string) int {
+	if ts, ok := s.Tables[tableKey(table)]; ok {
+		return ts.Rows
+	}
+	return s.DefaultRows
+}
+
+// column returns the generator expression set for a column, if any.
+func (s *Spec) column(table, column string) (string, bool) {
+	ts, ok := s.Tables[tableKey(table)]
+	if !ok {
+		return "", false
+	}
+	expr, ok := ts.Columns[strings.ToLower(column)]
+	return expr, ok
+}
+
+// LoadSpec reads a spec from a YAML or JSON file.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ParseSpec(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// ParseSpec parses a spec, in YAML or JSON.
+func ParseSpec(data []byte) (*Spec, error) {
+	var doc map[string]interface{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	spec := NewSpec()
+	for _, key := range sortedKeys(doc) {
+		val := doc[key]
+		var err error
+		switch key {
+		case "seed":
+			var n int
+			n, err = toInt(val)
+			spec.Seed = int64(n)
+		case "default_rows":
+			spec.DefaultRows, err = toInt(val)
+		case "tables":
+			err = parseTables(spec, val)
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return spec, nil
+}
+
+func parseTables(spec *Spec, val interface{}) error {
+	tables, ok := val.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected a mapping of table names")
+	}
+	for _, name := range sortedKeys(tables) {
+		ts := &TableSpec{Rows: spec.DefaultRows, Columns: make(map[string]string)}
+		fields, ok := tables[name].(map[string]interface{})
+		if !ok {
+			// A bare number is shorthand for the row count
+			n, err := toInt(tables[name])
+			if err != nil {
+				return fmt.Errorf("%s: expected rows and columns", name)
+			}
+			ts.Rows = n
+			fields = nil
+		}
+		for _, key := range sortedKeys(fields) {
+			switch key {
+			case "rows":
+				n, err := toInt(fields[key])
+				if err != nil {
+					return fmt.Errorf("%s.rows: %w", name, err)
+				}
+				ts.Rows = n
+			case "columns":
+				cols, ok := fields[key].(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("%s.columns: expected a mapping of column names", name)
+				}
+				for col, expr := range cols {
+					s, ok := expr.(string)
+					if !ok {
+						return fmt.Errorf("%s.columns.%s: expected a generator expression", name, col)
+					}
+					ts.Columns[strings.ToLower(col)] = s
+				}
+			default:
+				return fmt.Errorf("%s: unknown key %q", name, key)
+			}
+		}
+		if ts.Rows < 0 {
+			return fmt.Errorf("%s: negative row count", name)
+		}
+		spec.Tables[tableKey(name)] = ts
+	}
+	return nil
+}
+
+func toInt(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("expected a number, got %q", v)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("expected a number")
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
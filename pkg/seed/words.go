@@ -0,0 +1,65 @@
+package seed
+
+// Word lists for the fake data generators. They are small on purpose:
+// the aim is plausible-looking data, not variety.
+
+var firstNames = []string{
+	"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda",
+	"William", "Elizabeth", "David", "Barbara", "Richard", "Susan", "Joseph", "Jessica",
+	"Thomas", "Sarah", "Charles", "Karen", "Daniel", "Nancy", "Matthew", "Lisa",
+	"Anthony", "Margaret", "Mark", "Sandra", "Paul", "Ashley", "Steven", "Emily",
+	"Andrew", "Donna", "Kenneth", "Michelle", "Joshua", "Carol", "Kevin", "Amanda",
+	"Lucía", "Mateo", "Sofía", "Hiroshi", "Yuki", "Priya", "Arjun", "Chloé",
+	"Lars", "Ingrid", "Kwame", "Amara", "Olumide", "Zanele", "Mei", "Wei",
+}
+
+var lastNames = []string{
+	"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis",
+	"Rodriguez", "Martinez", "Hernandez", "Lopez", "Gonzalez", "Wilson", "Anderson",
+	"Thomas", "Taylor", "Moore", "Jackson", "Martin", "Lee", "Perez", "Thompson",
+	"White", "Harris", "Sanchez", "Clark", "Ramirez", "Lewis", "Robinson", "Walker",
+	"Young", "Allen", "King", "Wright", "Scott", "Torres", "Nguyen", "Hill", "Flores",
+	"Tanaka", "Sato", "Patel", "Sharma", "Dubois", "Lefèvre", "Johansson", "Nilsson",
+	"Mensah", "Okafor", "Dlamini", "Chen", "Wang", "O'Brien",
+}
+
+var cities = []string{
+	"London", "Manchester", "Edinburgh", "Dublin", "Paris", "Lyon", "Berlin", "Munich",
+	"Madrid", "Barcelona", "Lisbon", "Rome", "Milan", "Amsterdam", "Stockholm", "Oslo",
+	"New York", "Chicago", "Toronto", "Vancouver", "Mexico City", "Buenos Aires",
+	"São Paulo", "Santiago", "Lagos", "Nairobi", "Cape Town", "Cairo", "Mumbai",
+	"Bangalore", "Singapore", "Tokyo", "Osaka", "Seoul", "Shanghai", "Sydney",
+	"Melbourne", "Auckland",
+}
+
+var countries = []string{
+	"United Kingdom", "Ireland", "France", "Germany", "Spain", "Portugal", "Italy",
+	"Netherlands", "Sweden", "Norway", "United States", "Canada", "Mexico",
+	"Argentina", "Brazil", "Chile", "Nigeria", "Kenya", "South Africa", "Egypt",
+	"India", "Singapore", "Japan", "South Korea", "China", "Australia", "New Zealand",
+}
+
+var streets = []string{
+	"High Street", "Station Road", "Church Lane", "Park Avenue", "Main Street",
+	"Mill Lane", "Victoria Road", "Queen Street", "King's Road", "Oak Avenue",
+	"Elm Street", "Maple Drive", "Cedar Close", "River Walk", "Market Square",
+}
+
+var companyWords = []string{
+	"Acme", "Northwind", "Contoso", "Globex", "Initech", "Umbrella", "Stark",
+	"Wayne", "Tyrell", "Cyberdyne", "Soylent", "Hooli", "Vandelay", "Wonka",
+	"Oceanic", "Aperture", "Gringotts", "Pied Piper", "Blue Sun", "Massive Dynamic",
+}
+
+var companySuffixes = []string{"Ltd", "Inc", "LLC", "GmbH", "S.A.", "plc", "Group", "Holdings"}
+
+var loremWords = []string{
+	"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit",
+	"sed", "do", "eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore",
+	"magna", "aliqua", "enim", "ad", "minim", "veniam", "quis", "nostrud",
+	"exercitation", "ullamco", "laboris", "nisi", "aliquip", "ex", "ea", "commodo",
+	"consequat", "duis", "aute", "irure", "in", "reprehenderit", "voluptate", "velit",
+	"esse", "cillum", "fugiat", "nulla", "pariatur", "excepteur", "sint", "occaecat",
+}
+
+var domains = []string{"example.com", "example.org", "example.net", "mail.test", "corp.test"}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/features"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// The server is the protocol.Admin of its listeners.
+var _ protocol.Admin = (*Server)(nil)
+
+// ShadowCandidates implements protocol.Admin.
+func (s *Server) ShadowCandidates() []protocol.ShadowCandidate {
+	shadow := s.runtime.Shadow()
+	if shadow == nil {
+		return nil
+	}
+	status := shadow.Status()
+	out := make([]protocol.ShadowCandidate, len(status))
+	for i, st := range status {
+		out[i] = protocol.ShadowCandidate{
+			Procedure:      st.Procedure,
+			StagedAt:       st.StagedAt,
+			Runs:           st.Runs,
+			Matches:        st.Matches,
+			Divergences:    st.Divergences,
+			CurrentAvgMs:   st.CurrentAvgMs,
+			CandidateAvgMs: st.CandidateAvgMs,
+			LastDivergence: st.LastDivergence,
+		}
+		if !st.LastDivergenceAt.IsZero() {
+			at := st.LastDivergenceAt
+			out[i].LastDivergenceAt = &at
+		}
+	}
+	return out
+}
+
+// PromoteShadow implements protocol.Admin.
+func (s *Server) PromoteShadow(procedure string) error {
+	shadow := s.runtime.Shadow()
+	if shadow == nil {
+		return errShadowDisabled("Server.PromoteShadow")
+	}
+	return shadow.Promote(procedure)
+}
+
+// DiscardShadow implements protocol.Admin.
+func (s *Server) DiscardShadow(procedure string) error {
+	shadow := s.runtime.Shadow()
+	if shadow == nil {
+		return errShadowDisabled("Server.DiscardShadow")
+	}
+	return shadow.Discard(procedure)
+}
+
+// FeatureFlags implements protocol.Admin.
+func (s *Server) FeatureFlags() []features.Flag {
+	return s.runtime.Features().List()
+}
+
+// SetFeatureFlag implements protocol.Admin. The change lasts until the
+// server restarts.
+func (s *Server) SetFeatureFlag(flag features.Flag) error {
+	if err := s.runtime.Features().Set(flag); err != nil {
+		return err
+	}
+	s.logger.Application().Info("feature flag set",
+		"flag", flag.Name,
+		"enabled", flag.Enabled,
+		"percent", flag.Percent,
+		"tenants", len(flag.Tenants),
+	)
+	return nil
+}
+
+// DeleteFeatureFlag implements protocol.Admin.
+func (s *Server) DeleteFeatureFlag(name string) error {
+	if err := s.runtime.Features().Delete(name); err != nil {
+		return err
+	}
+	s.logger.Application().Info("feature flag deleted", "flag", name)
+	return nil
+}
+
+// Listeners implements protocol.Admin, listing the listeners in the
+// order they are configured.
+func (s *Server) Listeners() []protocol.ListenerInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []protocol.ListenerInfo
+	for _, cfg := range s.config.Listeners {
+		listener, ok := s.listeners[cfg.Name]
+		if !ok {
+			continue
+		}
+		info := protocol.ListenerInfo{
+			Name:     cfg.Name,
+			Protocol: string(listener.Protocol()),
+			ReadOnly: cfg.ReadOnly || s.config.ReadOnly,
+		}
+		for _, addr := range listener.Addrs() {
+			info.Addresses = append(info.Addresses, addr.String())
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+func errShadowDisabled(op string) error {
+	return aulerrors.New(aulerrors.ErrCodeExecInvalidState, "shadow execution is disabled").
+		WithOp(op).
+		Err()
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/sqlcmd"
+)
+
+// Init scripts bring a fresh storage backend up with its schema and seed
+// data. The .sql files of Config.InitDir run in name order before the
+// listeners start, each once: a script that ran is recorded in the
+// bootstrap table and skipped on later starts. Scripts are sqlcmd
+// scripts, so GO separates batches and $(name) reads the environment.
+//
+// A script that fails stops the server starting, and is not recorded, so
+// it runs again from the top once fixed. A recorded script whose contents
+// changed since is logged, not run again.
+
+// BootstrapTable records the init scripts that have run. It is one of
+// tsqlruntime.InternalTables, left out of the catalog views.
+const BootstrapTable = "aul_bootstrap"
+
+// initSessionID is the session init scripts run in.
+const initSessionID = "bootstrap"
+
+// runInitScripts runs the init scripts the backend has not recorded.
+func (s *Server) runInitScripts() error {
+	if s.storage.GetDB() == nil {
+		return aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+			"init scripts need a SQL storage backend, not %s", s.config.StorageConfig.Type).
+			Err()
+	}
+
+	scripts, err := filepath.Glob(filepath.Join(s.config.InitDir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(scripts)
+
+	applied, err := s.appliedInitScripts()
+	if err != nil {
+		return err
+	}
+
+	ran := 0
+	for _, path := range scripts {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(path)
+		sum := sha256.Sum256(data)
+		checksum := hex.EncodeToString(sum[:])
+
+		if previous, ok := applied[name]; ok {
+			if previous != checksum {
+				s.logger.Storage().Warn("init script changed since it ran; not running it again",
+					"script", name,
+				)
+			}
+			continue
+		}
+
+		start := time.Now()
+		if err := s.runInitScript(name, string(data)); err != nil {
+			return aulerrors.Wrap(err, aulerrors.ErrCodeStorageExec,
+				"init script failed").
+				WithField("script", name).
+				Err()
+		}
+		elapsed := time.Since(start)
+		record := fmt.Sprintf("INSERT INTO %s (script, checksum, applied_at, duration_ms) VALUES (%s, %s, GETDATE(), %d)",
+			BootstrapTable, quoteLiteral(name), quoteLiteral(checksum), elapsed.Milliseconds())
+		if _, err := s.execInit(record); err != nil {
+			return err
+		}
+		ran++
+		s.logger.Storage().Info("init script ran",
+			"script", name,
+			"duration_ms", elapsed.Milliseconds(),
+		)
+	}
+
+	s.logger.Storage().Info("init scripts done",
+		"directory", s.config.InitDir,
+		"ran", ran,
+		"skipped", len(scripts)-ran,
+	)
+	return nil
+}
+
+// appliedInitScripts returns the checksum of each recorded script,
+// creating the bootstrap table on a fresh backend.
+func (s *Server) appliedInitScripts() (map[string]string, error) {
+	applied := make(map[string]string)
+	result, err := s.execInit("SELECT script, checksum FROM " + BootstrapTable)
+	if err != nil {
+		create := "CREATE TABLE " + BootstrapTable + ` (
+			script NVARCHAR(260) NOT NULL PRIMARY KEY,
+			checksum VARCHAR(64) NOT NULL,
+			applied_at DATETIME2 NOT NULL,
+			duration_ms INT NOT NULL
+		)`
+		if _, err := s.execInit(create); err != nil {
+			return nil, err
+		}
+		return applied, nil
+	}
+	for _, rs := range result.ResultSets {
+		for _, row := range rs.Rows {
+			applied[fmt.Sprint(row[0])] = fmt.Sprint(row[1])
+		}
+	}
+	return applied, nil
+}
+
+// runInitScript runs the batches of one script, stopping at the first
+// that fails.
+func (s *Server) runInitScript(name, script string) error {
+	proc := sqlcmd.New(sqlcmd.Options{
+		Lookup:          os.LookupEnv,
+		ExitOnError:     true,
+		IncludeDir:      s.config.InitDir,
+		ConfineIncludes: true,
+	})
+	return proc.Run(script, name, func(batch string) error {
+		_, err := s.execInit(batch)
+		return err
+	})
+}
+
+// execInit runs a batch in the init session. Loading seed data can take
+// a while, so there is no timeout.
+func (s *Server) execInit(batch string) (*runtime.ExecResult, error) {
+	return s.runtime.ExecuteSQL(s.ctx, batch, &runtime.ExecContext{SessionID: initSessionID})
+}
+
+// quoteLiteral renders s as a T-SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
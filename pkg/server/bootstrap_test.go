@@ -0,0 +1,95 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+func TestServer_InitScripts(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, script string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("01_schema.sql", "CREATE TABLE orders (id INT, qty INT)\nGO\nCREATE TABLE notes (id INT)\nGO\n")
+	write("02_seed.sql", "INSERT INTO orders VALUES (1, $(AUL_TEST_QTY))\n")
+	write("readme.txt", "not a script")
+	t.Setenv("AUL_TEST_QTY", "5")
+
+	cfg := DefaultConfig()
+	cfg.ProcedureDir = ""
+	cfg.InitDir = dir
+	cfg.JITEnabled = false
+	cfg.StorageConfig.Type = "sqlite"
+	cfg.StorageConfig.Options = map[string]string{"path": filepath.Join(t.TempDir(), "aul.db")}
+	cfg.Logger = log.New(log.Config{DefaultLevel: log.LevelError})
+
+	start := func() *Server {
+		t.Helper()
+		s, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	count := func(s *Server, query string) interface{} {
+		t.Helper()
+		result, err := s.execInit(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.ResultSets[0].Rows[0][0]
+	}
+
+	s := start()
+	if n := count(s, "SELECT SUM(qty) FROM orders"); n != int64(5) {
+		t.Errorf("qty %v (%T)", n, n)
+	}
+	if n := count(s, "SELECT COUNT(*) FROM "+BootstrapTable); n != int64(2) {
+		t.Errorf("recorded %v", n)
+	}
+	// The record is internal, not one of the database's tables
+	tables, err := s.execInit("SELECT name FROM sys.tables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range tables.ResultSets[0].Rows {
+		if row[0] == BootstrapTable {
+			t.Errorf("sys.tables lists %s", BootstrapTable)
+		}
+	}
+	s.Stop()
+
+	// A restart runs only the new script, even though the seed changed
+	write("02_seed.sql", "INSERT INTO orders VALUES (1, 100)\n")
+	write("03_more.sql", "INSERT INTO orders VALUES (2, 1)\n")
+	s = start()
+	if n := count(s, "SELECT SUM(qty) FROM orders"); n != int64(6) {
+		t.Errorf("qty after restart %v", n)
+	}
+	s.Stop()
+
+	// A failing script stops the start and is not recorded
+	write("04_bad.sql", "INSERT INTO orders VALUES (3, 1)\nGO\nINSERT INTO missing VALUES (1)\n")
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Start()
+	if err == nil || !strings.Contains(err.Error(), "init scripts") {
+		t.Fatalf("got %v", err)
+	}
+	if n := count(s, "SELECT COUNT(*) FROM "+BootstrapTable+" WHERE script = '04_bad.sql'"); n != int64(0) {
+		t.Errorf("failed script recorded")
+	}
+	s.Stop()
+}
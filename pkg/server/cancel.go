@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// timeout returns how long a request may run: the server's
+// --exec-timeout, or less if the client asked for less. When it runs out
+// the backend is interrupted.
+func (h *ConnectionHandler) timeout(req protocol.Request) time.Duration {
+	if t := req.Options.Timeout; t > 0 && (h.execTimeout <= 0 || t < h.execTimeout) {
+		return t
+	}
+	return h.execTimeout
+}
+
+// watchCancel returns the context a request runs in, cancelled if the
+// client cancels the request, so that the backend is interrupted in the
+// query it is running rather than left to finish it; and the func that
+// ends the watch once the request is done. Only requests that run SQL are
+// watched: a transaction begun by a request outlives it, and would be
+// rolled back with its context.
+func (h *ConnectionHandler) watchCancel(ctx context.Context, req protocol.Request) (context.Context, func()) {
+	watcher, ok := h.conn.(protocol.CancelWatcher)
+	if !ok {
+		return ctx, func() {}
+	}
+	switch req.Type {
+	case protocol.RequestExec, protocol.RequestQuery, protocol.RequestCall:
+	default:
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stop := watcher.WatchCancel(cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
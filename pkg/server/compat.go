@@ -0,0 +1,96 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// checkCompatibility refuses a procedure that uses T-SQL the interpreter
+// cannot run, or that it cannot parse. It is the procedure loaders' check
+// in strict mode, so such a procedure fails as it loads, at startup, on
+// hot reload or when a deployment is staged, not when execution reaches
+// the statement.
+func (s *Server) checkCompatibility(proc *procedure.Procedure) error {
+	diags, err := compatibilityDiagnostics(proc)
+	if err != nil {
+		return aulerrors.Wrap(err, aulerrors.ErrCodeProcParseError,
+			"strict mode: procedure does not parse").
+			WithOp("Server.checkCompatibility").
+			WithField("path", proc.SourceFile).
+			WithField("procedure", proc.QualifiedName()).
+			Err()
+	}
+	if len(diags) == 0 {
+		return nil
+	}
+
+	problems := make([]string, len(diags))
+	for i, d := range diags {
+		problems[i] = d.String()
+		s.logger.Application().Warn("unsupported construct",
+			"procedure", proc.QualifiedName(),
+			"code", d.Code,
+			"file", d.File,
+			"line", d.Line,
+			"column", d.Column,
+			"workaround", d.Workaround,
+		)
+	}
+	return aulerrors.Newf(aulerrors.ErrCodeProcValidationError,
+		"strict mode: %s uses unsupported constructs: %s", proc.QualifiedName(), strings.Join(problems, "; ")).
+		WithOp("Server.checkCompatibility").
+		WithField("path", proc.SourceFile).
+		WithField("procedure", proc.QualifiedName()).
+		WithField("unsupported", len(diags)).
+		Err()
+}
+
+// compatibilityDiagnostics returns the constructs in a T-SQL procedure
+// that the interpreter cannot run. Procedures in other dialects run on
+// the backend and have none.
+func compatibilityDiagnostics(proc *procedure.Procedure) ([]tsqlruntime.Diagnostic, error) {
+	if proc.Dialect != procedure.DialectTSQL {
+		return nil, nil
+	}
+	diags, err := tsqlruntime.CheckCompatibility(proc.Source)
+	if err != nil {
+		return nil, err
+	}
+	for i := range diags {
+		diags[i].File = proc.SourceFile
+	}
+	return diags, nil
+}
+
+// Compatibility implements protocol.Admin.
+func (s *Server) Compatibility(name string) ([]protocol.CompatibilityReport, error) {
+	procs := s.registry.List()
+	if name != "" {
+		proc, err := s.registry.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		procs = []*procedure.Procedure{proc}
+	}
+
+	reports := make([]protocol.CompatibilityReport, len(procs))
+	for i, proc := range procs {
+		diags, err := compatibilityDiagnostics(proc)
+		reports[i] = protocol.CompatibilityReport{
+			Procedure:   proc.QualifiedName(),
+			File:        proc.SourceFile,
+			Compatible:  err == nil && len(diags) == 0,
+			Diagnostics: diags,
+		}
+		if err != nil {
+			reports[i].Error = err.Error()
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Procedure < reports[j].Procedure })
+	return reports, nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+func TestServer_StrictCompatibility(t *testing.T) {
+	procs := map[string]string{
+		"Hello":  "SELECT 1",
+		"Upsert": "MERGE INTO t USING s ON t.id = s.id WHEN MATCHED THEN UPDATE SET v = s.v;",
+	}
+
+	for _, strict := range []bool{false, true} {
+		cfg := DefaultConfig()
+		cfg.ProcedureDir = writeProcs(t, procs)
+		cfg.StrictCompatibility = strict
+		cfg.JITEnabled = false
+		cfg.StorageConfig.Type = "memory"
+		cfg.Listeners = nil
+		cfg.Logger = log.New(log.Config{DefaultLevel: log.LevelError})
+		s, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Stop()
+
+		_, err = s.Registry().Lookup("dbo.Upsert")
+		if loaded := err == nil; loaded == strict {
+			t.Errorf("strict %v: dbo.Upsert loaded = %v", strict, loaded)
+		}
+		if strict {
+			if err := s.StageDeployment(writeProcs(t, procs)); err != nil {
+				t.Fatal(err)
+			}
+			staged := s.Deployment().Staged
+			if len(staged.Problems) != 1 || !strings.Contains(staged.Problems[0], "MERGE is not supported [MERGE]") {
+				t.Errorf("staged problems: %v", staged.Problems)
+			}
+			continue
+		}
+
+		reports, err := s.Compatibility("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 2 || reports[0].Procedure != "dbo.Hello" || !reports[0].Compatible ||
+			reports[1].Compatible || len(reports[1].Diagnostics) != 1 {
+			t.Fatalf("reports: %+v", reports)
+		}
+		d := reports[1].Diagnostics[0]
+		if d.Code != "MERGE" || d.Line != 1 || !strings.HasSuffix(d.File, "Upsert.sql") || d.Workaround == "" {
+			t.Errorf("diagnostic: %+v", d)
+		}
+		if _, err := s.Compatibility("dbo.Missing"); aulerrors.GetCode(err) != aulerrors.ErrCodeProcNotFound {
+			t.Errorf("unknown procedure: %v", err)
+		}
+	}
+}
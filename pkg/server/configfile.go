@@ -0,0 +1,472 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/archive"
+	"github.com/ha1tch/aul/pkg/auth"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig is a configuration file: YAML, or JSON when it starts with
+// "{". Sections and settings left out keep their defaults, which is why
+// scalar settings are pointers.
+//
+//	server:
+//	  name: my-aul-server
+//	  proc_dir: /var/lib/aul/procedures
+//	listeners:
+//	  - protocol: tds
+//	    port: 1433
+//	  - protocol: http
+//	    port: 8080
+//	    tls:
+//	      enabled: true
+//	      cert_file: /etc/aul/server.crt
+//	      key_file: /etc/aul/server.key
+//	runtime:
+//	  exec_timeout: 30s
+//	jit:
+//	  threshold: 100
+//	storage:
+//	  type: sqlite
+//	  path: /var/lib/aul/data.db
+//	archive:
+//	  store: s3://my-bucket/aul
+//	  tables:
+//	    - table: dbo.Orders
+//	      column: OrderDate
+//	      keep: 2160h
+//	logging:
+//	  level: info
+//	  levels:
+//	    protocol: debug
+//	auth:
+//	  token_file: /etc/aul/tokens
+//	  logins:
+//	    - name: app
+//	      password: ${AUL_APP_PASSWORD}
+//
+// ${NAME} anywhere in the file is replaced by the environment variable
+// NAME, so secrets need not be written in it.
+type FileConfig struct {
+	Server    *FileServerConfig    `json:"server,omitempty"`
+	Listeners []FileListenerConfig `json:"listeners,omitempty"`
+	Runtime   *FileRuntimeConfig   `json:"runtime,omitempty"`
+	JIT       *FileJITConfig       `json:"jit,omitempty"`
+	Storage   *FileStorageConfig   `json:"storage,omitempty"`
+	Archive   *FileArchiveConfig   `json:"archive,omitempty"`
+	Logging   *FileLoggingConfig   `json:"logging,omitempty"`
+	Auth      *FileAuthConfig      `json:"auth,omitempty"`
+	Tenants   *TenantConfig        `json:"tenants,omitempty"`
+}
+
+// FileServerConfig is the server section of a configuration file.
+type FileServerConfig struct {
+	Name            *string `json:"name,omitempty"`
+	ProcDir         *string `json:"proc_dir,omitempty"`
+	WatchChanges    *bool   `json:"watch_changes,omitempty"`
+	ProcLoadWorkers *int    `json:"proc_load_workers,omitempty"`
+	Strict          *bool   `json:"strict,omitempty"`
+	InitDir         *string `json:"init_dir,omitempty"`
+}
+
+// FileListenerConfig is one of the listeners of a configuration file.
+type FileListenerConfig struct {
+	Name              string         `json:"name,omitempty"` // Default: the protocol
+	Protocol          string         `json:"protocol"`
+	Host              string         `json:"host,omitempty"`
+	Hosts             []string       `json:"hosts,omitempty"`
+	Port              int            `json:"port,omitempty"`
+	Socket            string         `json:"socket,omitempty"`
+	TLS               *FileTLSConfig `json:"tls,omitempty"`
+	MaxConnections    int            `json:"max_connections,omitempty"`
+	ReadTimeout       Duration       `json:"read_timeout,omitempty"`
+	WriteTimeout      Duration       `json:"write_timeout,omitempty"`
+	IdleTimeout       Duration       `json:"idle_timeout,omitempty"`
+	ReadOnly          bool           `json:"read_only,omitempty"`
+	ParameterizedOnly bool           `json:"parameterized_only,omitempty"`
+	RequireLogin      bool           `json:"require_login,omitempty"`
+}
+
+// FileTLSConfig is the TLS settings of a listener.
+type FileTLSConfig struct {
+	Enabled  bool   `json:"enabled"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// FileRuntimeConfig is the runtime section of a configuration file.
+type FileRuntimeConfig struct {
+	Dialect          *string   `json:"dialect,omitempty"`
+	MaxConcurrency   *int      `json:"max_concurrency,omitempty"`
+	ExecTimeout      *Duration `json:"exec_timeout,omitempty"`
+	MaxNestingLevel  *int      `json:"max_nesting_level,omitempty"`
+	NumbersSize      *int      `json:"numbers_size,omitempty"`
+	AutoParameterize *bool     `json:"auto_parameterize,omitempty"`
+	ResultContracts  *string   `json:"result_contracts,omitempty"`
+	StatementSummary *bool     `json:"statement_summary,omitempty"`
+	StreamResults    *bool     `json:"stream_results,omitempty"`
+	ReadOnly         *bool     `json:"read_only,omitempty"`
+	PinTables        []string  `json:"pin_tables,omitempty"` // Cached in memory
+	PinMaxRows       *int      `json:"pin_max_rows,omitempty"`
+	PinRefresh       *Duration `json:"pin_refresh,omitempty"`
+}
+
+// FileJITConfig is the jit section of a configuration file.
+type FileJITConfig struct {
+	Enabled   *bool `json:"enabled,omitempty"`
+	Threshold *int  `json:"threshold,omitempty"`
+}
+
+// FileStorageConfig is the storage section of a configuration file. The
+// connection settings and options are for backends from storage plugins.
+type FileStorageConfig struct {
+	Type         *string           `json:"type,omitempty"`
+	Path         *string           `json:"path,omitempty"`
+	Plugins      []string          `json:"plugins,omitempty"` // Go plugins registering backends
+	JournalMode  string            `json:"journal_mode,omitempty"`
+	Synchronous  string            `json:"synchronous,omitempty"`
+	Host         string            `json:"host,omitempty"`
+	Port         int               `json:"port,omitempty"`
+	Database     string            `json:"database,omitempty"`
+	Username     string            `json:"username,omitempty"`
+	Password     string            `json:"password,omitempty"`
+	MaxOpenConns int               `json:"max_open_conns,omitempty"`
+	MaxIdleConns int               `json:"max_idle_conns,omitempty"`
+	Options      map[string]string `json:"options,omitempty"`
+}
+
+// FileArchiveConfig is the archive section of a configuration file.
+type FileArchiveConfig struct {
+	Store     *string            `json:"store,omitempty"` // s3://bucket/prefix, or a directory
+	Endpoint  *string            `json:"endpoint,omitempty"`
+	Region    *string            `json:"region,omitempty"`
+	Interval  *Duration          `json:"interval,omitempty"`
+	CacheRows *int               `json:"cache_rows,omitempty"`
+	Tables    []FileArchiveTable `json:"tables,omitempty"`
+}
+
+// FileArchiveTable is one of the archival tables of a configuration file.
+type FileArchiveTable struct {
+	Table     string   `json:"table"`
+	Column    string   `json:"column"`
+	Partition string   `json:"partition,omitempty"` // day, month or year (default: month)
+	Keep      Duration `json:"keep"`
+}
+
+// FileLoggingConfig is the logging section of a configuration file.
+type FileLoggingConfig struct {
+	Level            *string           `json:"level,omitempty"`
+	Format           *string           `json:"format,omitempty"`
+	Queries          *bool             `json:"queries,omitempty"`
+	QueriesRewritten *bool             `json:"queries_rewritten,omitempty"`
+	Levels           map[string]string `json:"levels,omitempty"` // Per category
+	File             *string           `json:"file,omitempty"`
+	FileMaxSize      *ByteSize         `json:"file_max_size,omitempty"`
+	FileMaxBackups   *int              `json:"file_max_backups,omitempty"`
+	Syslog           *string           `json:"syslog,omitempty"`
+	OTLPEndpoint     *string           `json:"otlp_endpoint,omitempty"`
+}
+
+// FileAuthConfig is the auth section of a configuration file.
+type FileAuthConfig struct {
+	TokenFile          *string      `json:"token_file,omitempty"`          // Bearer tokens the HTTP API accepts
+	PeerMap            *string      `json:"peer_map,omitempty"`            // OS users Unix socket clients connect as
+	Logins             []auth.Login `json:"logins,omitempty"`              // SQL logins of listeners with require_login
+	EnforcePermissions *bool        `json:"enforce_permissions,omitempty"` // Check what logins do against GRANT and DENY
+	Administrators     []string     `json:"administrators,omitempty"`      // Logins permissions are not checked for
+}
+
+// Duration is a time.Duration written as a string such as "30s".
+type Duration time.Duration
+
+// String formats the duration as time.Duration does.
+func (d Duration) String() string { return time.Duration(d).String() }
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+// UnmarshalJSON reads a duration string, or 0.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		if string(data) == "0" {
+			*d = 0
+			return nil
+		}
+		return fmt.Errorf("expected a duration such as \"30s\", got %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ByteSize is a size written either as a number of bytes or with a unit,
+// such as "100MB".
+type ByteSize string
+
+// UnmarshalJSON reads a size string or number.
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected a size such as \"100MB\", got %s", data)
+	}
+	*b = ByteSize(s)
+	return nil
+}
+
+// envReference matches the ${NAME} references a configuration file may
+// hold.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// LoadConfigFile reads a configuration file.
+func LoadConfigFile(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ParseConfigFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// ParseConfigFile parses a configuration file, after replacing its
+// environment variable references. Unknown settings are an error.
+func ParseConfigFile(data []byte) (*FileConfig, error) {
+	text := envReference.ReplaceAllStringFunc(string(data), func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+	if !strings.HasPrefix(strings.TrimSpace(text), "{") {
+		var doc interface{}
+		err := yaml.Unmarshal([]byte(text), &doc)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := doc.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("expected a mapping of sections")
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.DisallowUnknownFields()
+	var file FileConfig
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	for i, l := range file.Listeners {
+		switch protocol.ProtocolType(l.Protocol) {
+		case protocol.ProtocolTDS, protocol.ProtocolPostgres, protocol.ProtocolMySQL,
+			protocol.ProtocolHTTP, protocol.ProtocolGRPC:
+		default:
+			return nil, fmt.Errorf("listeners[%d]: unknown protocol %q", i, l.Protocol)
+		}
+		if l.Port <= 0 && l.Socket == "" {
+			return nil, fmt.Errorf("listeners[%d]: a port or a socket is required", i)
+		}
+	}
+	return &file, nil
+}
+
+// ListenerConfigs returns the listeners of a configuration file.
+func (f *FileConfig) ListenerConfigs() []protocol.ListenerConfig {
+	var listeners []protocol.ListenerConfig
+	for _, l := range f.Listeners {
+		cfg := protocol.ListenerConfig{
+			Name:              l.Name,
+			Protocol:          protocol.ProtocolType(l.Protocol),
+			Host:              l.Host,
+			Hosts:             l.Hosts,
+			Port:              l.Port,
+			Socket:            l.Socket,
+			MaxConnections:    l.MaxConnections,
+			ReadTimeout:       time.Duration(l.ReadTimeout),
+			WriteTimeout:      time.Duration(l.WriteTimeout),
+			IdleTimeout:       time.Duration(l.IdleTimeout),
+			ReadOnly:          l.ReadOnly,
+			ParameterizedOnly: l.ParameterizedOnly,
+			RequireLogin:      l.RequireLogin,
+		}
+		if cfg.Name == "" {
+			cfg.Name = l.Protocol
+		}
+		if l.TLS != nil {
+			cfg.TLSEnabled = l.TLS.Enabled
+			cfg.TLSCertFile = l.TLS.CertFile
+			cfg.TLSKeyFile = l.TLS.KeyFile
+		}
+		listeners = append(listeners, cfg)
+	}
+	return listeners
+}
+
+// ConfigFileOf returns the configuration file that gives cfg, as far as
+// the file's sections go. The auth section is left for the caller, since
+// cfg holds the tokens and peer map read rather than the files they came
+// from.
+func ConfigFileOf(cfg Config) *FileConfig {
+	execTimeout := Duration(cfg.ExecTimeout)
+	contracts := string(cfg.Contracts)
+	pinMaxRows := cfg.TableCache.MaxRows
+	if pinMaxRows <= 0 {
+		pinMaxRows = tsqlruntime.DefaultTableCacheMaxRows
+	}
+	pinRefresh := Duration(cfg.TableCache.Refresh)
+	fileMaxSize := ByteSize(strconv.FormatInt(cfg.LogSinks.FileMaxSize, 10))
+	file := &FileConfig{
+		Server: &FileServerConfig{
+			Name:            &cfg.Name,
+			ProcDir:         &cfg.ProcedureDir,
+			WatchChanges:    &cfg.WatchChanges,
+			ProcLoadWorkers: &cfg.ProcLoadWorkers,
+			Strict:          &cfg.StrictCompatibility,
+			InitDir:         &cfg.InitDir,
+		},
+		Runtime: &FileRuntimeConfig{
+			Dialect:          &cfg.DefaultDialect,
+			MaxConcurrency:   &cfg.MaxConcurrency,
+			ExecTimeout:      &execTimeout,
+			MaxNestingLevel:  &cfg.MaxNestingLevel,
+			NumbersSize:      &cfg.NumbersSize,
+			AutoParameterize: &cfg.AutoParameterize,
+			ResultContracts:  &contracts,
+			StatementSummary: &cfg.StatementSummary,
+			StreamResults:    &cfg.StreamResults,
+			ReadOnly:         &cfg.ReadOnly,
+			PinTables:        cfg.TableCache.Tables,
+			PinMaxRows:       &pinMaxRows,
+			PinRefresh:       &pinRefresh,
+		},
+		JIT: &FileJITConfig{
+			Enabled:   &cfg.JITEnabled,
+			Threshold: &cfg.JITThreshold,
+		},
+		Storage: &FileStorageConfig{
+			Type:         &cfg.StorageConfig.Type,
+			Host:         cfg.StorageConfig.Host,
+			Port:         cfg.StorageConfig.Port,
+			Database:     cfg.StorageConfig.Database,
+			Username:     cfg.StorageConfig.Username,
+			MaxOpenConns: cfg.StorageConfig.MaxOpenConns,
+			MaxIdleConns: cfg.StorageConfig.MaxIdleConns,
+		},
+		Logging: &FileLoggingConfig{
+			Level:            &cfg.LogLevel,
+			Format:           &cfg.LogFormat,
+			Queries:          &cfg.LogQueries,
+			QueriesRewritten: &cfg.LogQueriesRewritten,
+			Levels:           cfg.LogLevels,
+			File:             &cfg.LogSinks.File,
+			FileMaxSize:      &fileMaxSize,
+			FileMaxBackups:   &cfg.LogSinks.FileMaxBackups,
+			Syslog:           &cfg.LogSinks.Syslog,
+			OTLPEndpoint:     &cfg.LogSinks.OTLPEndpoint,
+		},
+	}
+	for name, value := range cfg.StorageConfig.Options {
+		switch name {
+		case "path":
+			path := value
+			file.Storage.Path = &path
+		case "journal_mode":
+			file.Storage.JournalMode = value
+		case "synchronous":
+			file.Storage.Synchronous = value
+		default:
+			if file.Storage.Options == nil {
+				file.Storage.Options = make(map[string]string)
+			}
+			file.Storage.Options[name] = value
+		}
+	}
+	if cfg.StorageConfig.Password != "" {
+		file.Storage.Password = "********" // Not printed
+	}
+	for _, l := range cfg.Listeners {
+		fl := FileListenerConfig{
+			Name:              l.Name,
+			Protocol:          string(l.Protocol),
+			Host:              l.Host,
+			Hosts:             l.Hosts,
+			Port:              l.Port,
+			Socket:            l.Socket,
+			MaxConnections:    l.MaxConnections,
+			ReadTimeout:       Duration(l.ReadTimeout),
+			WriteTimeout:      Duration(l.WriteTimeout),
+			IdleTimeout:       Duration(l.IdleTimeout),
+			ReadOnly:          l.ReadOnly,
+			ParameterizedOnly: l.ParameterizedOnly,
+			RequireLogin:      l.RequireLogin,
+		}
+		if l.TLSEnabled || l.TLSCertFile != "" {
+			fl.TLS = &FileTLSConfig{Enabled: l.TLSEnabled, CertFile: l.TLSCertFile, KeyFile: l.TLSKeyFile}
+		}
+		file.Listeners = append(file.Listeners, fl)
+	}
+	if len(cfg.Archive.Tables) > 0 {
+		interval := Duration(cfg.Archive.Interval)
+		file.Archive = &FileArchiveConfig{
+			Store:     &cfg.Archive.Store,
+			Endpoint:  &cfg.Archive.Endpoint,
+			Region:    &cfg.Archive.Region,
+			Interval:  &interval,
+			CacheRows: &cfg.Archive.CacheRows,
+		}
+		for _, t := range cfg.Archive.Tables {
+			file.Archive.Tables = append(file.Archive.Tables, FileArchiveTable{
+				Table:     t.Name,
+				Column:    t.Column,
+				Partition: t.Partition,
+				Keep:      Duration(t.Keep),
+			})
+		}
+	}
+	if cfg.TenantConfig.Enabled {
+		file.Tenants = &cfg.TenantConfig
+	}
+	return file
+}
+
+// TablesFlag formats the archival tables as the --archive-tables flag
+// takes them.
+func (a *FileArchiveConfig) TablesFlag() string {
+	var items []string
+	for _, t := range a.Tables {
+		items = append(items, archive.Table{Name: t.Table, Column: t.Column, Partition: t.Partition, Keep: time.Duration(t.Keep)}.String())
+	}
+	return strings.Join(items, ",")
+}
+
+// LogLevelsFlag formats per-category log levels as the --log-levels flag
+// takes them.
+func (l *FileLoggingConfig) LogLevelsFlag() string {
+	var items []string
+	for name, level := range l.Levels {
+		items = append(items, name+"="+level)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
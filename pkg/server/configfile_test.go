@@ -0,0 +1,173 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+func TestParseConfigFile_YAML(t *testing.T) {
+	t.Setenv("AUL_TEST_CERT", "/etc/aul/server.crt")
+	t.Setenv("AUL_TEST_PASSWORD", "s3cret")
+	file, err := ParseConfigFile([]byte(`
+# Server settings
+server:
+  name: "my-aul"   # quoted
+  watch_changes: true
+listeners:
+- protocol: tds
+  port: 1433
+  require_login: true
+- name: api
+  protocol: http
+  port: 8080
+  hosts: [127.0.0.1, '::1']
+  read_timeout: 5s
+  tls:
+    enabled: true
+    cert_file: ${AUL_TEST_CERT}
+runtime:
+  exec_timeout: 45s
+  pin_tables: [dbo.Currencies, dbo.Regions]
+jit:
+  enabled: false
+  threshold: 10
+archive:
+  store: s3://archive/aul
+  tables:
+  - table: dbo.Orders
+    column: OrderDate
+    keep: 2160h
+  - table: Events
+    column: At
+    partition: day
+    keep: 168h
+logging:
+  file_max_size: 10MB
+  levels:
+    protocol: debug
+    storage: warn
+auth:
+  logins:
+  - name: app
+    password: ${AUL_TEST_PASSWORD}
+  - name: reports
+    password_hash: $2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy
+    disabled: true
+  enforce_permissions: true
+  administrators: [app]
+tenants:
+  enabled: true
+  identification:
+    sources:
+      - type: header
+        name: X-Tenant
+`))
+	if err != nil {
+		t.Fatalf("ParseConfigFile: %v", err)
+	}
+
+	if *file.Server.Name != "my-aul" || !*file.Server.WatchChanges || file.Server.ProcDir != nil {
+		t.Errorf("server = %+v", file.Server)
+	}
+	if time.Duration(*file.Runtime.ExecTimeout) != 45*time.Second || len(file.Runtime.PinTables) != 2 {
+		t.Errorf("runtime = %+v", file.Runtime)
+	}
+	if *file.JIT.Enabled || *file.JIT.Threshold != 10 {
+		t.Errorf("jit = %+v", file.JIT)
+	}
+	if *file.Archive.Store != "s3://archive/aul" ||
+		file.Archive.TablesFlag() != "dbo.Orders:OrderDate::2160h0m0s,Events:At:day:168h0m0s" {
+		t.Errorf("archive = %+v", file.Archive)
+	}
+	if *file.Logging.FileMaxSize != "10MB" || file.Logging.LogLevelsFlag() != "protocol=debug,storage=warn" {
+		t.Errorf("logging = %+v", file.Logging)
+	}
+	if logins := file.Auth.Logins; len(logins) != 2 || logins[0].Password != "s3cret" ||
+		!strings.HasPrefix(logins[1].PasswordHash, "$2a$10$") || !logins[1].Disabled ||
+		!*file.Auth.EnforcePermissions || len(file.Auth.Administrators) != 1 {
+		t.Errorf("auth = %+v", file.Auth)
+	}
+	if !file.Tenants.Enabled || file.Tenants.Identification.Sources[0].Name != "X-Tenant" {
+		t.Errorf("tenants = %+v", file.Tenants)
+	}
+
+	listeners := file.ListenerConfigs()
+	if len(listeners) != 2 {
+		t.Fatalf("listeners = %+v", listeners)
+	}
+	if listeners[0].Name != "tds" || listeners[0].Protocol != protocol.ProtocolTDS || listeners[0].Port != 1433 || !listeners[0].RequireLogin {
+		t.Errorf("listeners[0] = %+v", listeners[0])
+	}
+	api := listeners[1]
+	if api.Name != "api" || api.Port != 8080 || strings.Join(api.Hosts, ",") != "127.0.0.1,::1" ||
+		api.ReadTimeout != 5*time.Second || !api.TLSEnabled || api.TLSCertFile != "/etc/aul/server.crt" {
+		t.Errorf("listeners[1] = %+v", api)
+	}
+}
+
+func TestParseConfigFile_JSON(t *testing.T) {
+	file, err := ParseConfigFile([]byte(`{
+		"runtime": {"exec_timeout": "1m", "max_concurrency": 20},
+		"listeners": [{"protocol": "postgres", "port": 5432}]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseConfigFile: %v", err)
+	}
+	if time.Duration(*file.Runtime.ExecTimeout) != time.Minute || *file.Runtime.MaxConcurrency != 20 {
+		t.Errorf("runtime = %+v", file.Runtime)
+	}
+	if file.Listeners[0].Port != 5432 {
+		t.Errorf("listeners = %+v", file.Listeners)
+	}
+}
+
+func TestParseConfigFile_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{"unknown section", "serverr:\n  name: x\n", "unknown field"},
+		{"unknown setting", "runtime:\n  timeout: 5s\n", "unknown field"},
+		{"bad duration", "runtime:\n  exec_timeout: soon\n", "duration"},
+		{"wrong type", "jit:\n  threshold: many\n", "jit.threshold"},
+		{"unknown protocol", "listeners:\n  - protocol: ftp\n    port: 21\n", "unknown protocol"},
+		{"no port", "listeners:\n  - protocol: tds\n", "port or a socket"},
+		{"bad indentation", "server:\n  name: x\n    proc_dir: y\n", "line 3"},
+		{"duplicate key", "jit:\n  threshold: 1\n  threshold: 2\n", "already defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfigFile([]byte(tt.file))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFileOf(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ExecTimeout = 10 * time.Second
+	cfg.StorageConfig.Type = "sqlite"
+	cfg.StorageConfig.Options = map[string]string{"path": "/data/aul.db", "sid": "ORCL"}
+	cfg.StorageConfig.Password = "secret"
+	cfg.Listeners = []protocol.ListenerConfig{{Name: "tds", Protocol: protocol.ProtocolTDS, Port: 1433}}
+
+	file := ConfigFileOf(cfg)
+	if time.Duration(*file.Runtime.ExecTimeout) != 10*time.Second || *file.Storage.Path != "/data/aul.db" {
+		t.Errorf("file = %+v", file)
+	}
+	if file.Storage.Options["sid"] != "ORCL" || file.Storage.Password == "secret" {
+		t.Errorf("storage = %+v, want the options and no password", file.Storage)
+	}
+	if got := file.ListenerConfigs(); len(got) != 1 || got[0].Port != 1433 {
+		t.Errorf("listeners = %+v", got)
+	}
+	if file.Tenants != nil {
+		t.Errorf("tenants = %+v, want none while disabled", file.Tenants)
+	}
+}
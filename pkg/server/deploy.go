@@ -0,0 +1,220 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/notify"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// deployment holds the procedure sets of a blue/green deployment. A new
+// set is loaded and validated beside the active one, then swapped in
+// with a single pointer store; the set it replaced is kept so that a
+// rollback is another swap.
+type deployment struct {
+	mu       sync.Mutex
+	active   deployedSet
+	staged   *procedure.ProcedureSet
+	previous *deployedSet
+}
+
+// deployedSet describes a procedure set that has been active.
+type deployedSet struct {
+	source      string
+	loadedAt    time.Time
+	activatedAt time.Time
+	registry    *procedure.Registry // The set's procedures while it is not active
+}
+
+// Deployment implements protocol.Admin.
+func (s *Server) Deployment() protocol.Deployment {
+	s.deploy.mu.Lock()
+	defer s.deploy.mu.Unlock()
+
+	activatedAt := s.deploy.active.activatedAt
+	d := protocol.Deployment{
+		Active: &protocol.ProcedureSet{
+			Source:      s.deploy.active.source,
+			Procedures:  s.registry.Count(),
+			LoadedAt:    s.deploy.active.loadedAt,
+			ActivatedAt: &activatedAt,
+		},
+	}
+	if staged := s.deploy.staged; staged != nil {
+		d.Staged = &protocol.ProcedureSet{
+			Source:     staged.Dir,
+			Procedures: staged.Registry.Count(),
+			LoadedAt:   staged.LoadedAt,
+			Problems:   staged.Problems,
+		}
+		d.Staged.Added, d.Staged.Changed, d.Staged.Removed = diffProcedures(s.registry, staged.Registry)
+	}
+	if prev := s.deploy.previous; prev != nil {
+		activatedAt := prev.activatedAt
+		d.Previous = &protocol.ProcedureSet{
+			Source:      prev.source,
+			Procedures:  prev.registry.Count(),
+			LoadedAt:    prev.loadedAt,
+			ActivatedAt: &activatedAt,
+		}
+	}
+	return d
+}
+
+// StageDeployment implements protocol.Admin. A set that fails validation
+// is staged all the same, so that its problems can be inspected, but
+// cannot be switched to.
+func (s *Server) StageDeployment(dir string) error {
+	if dir == "" {
+		dir = s.config.ProcedureDir
+	}
+	loader := procedure.NewLoader(s.config.DefaultDialect, s.logger)
+	loader.Workers = s.config.ProcLoadWorkers
+	if s.config.StrictCompatibility {
+		loader.Check = s.checkCompatibility
+	}
+	set, err := loader.LoadSet(dir)
+	if err != nil {
+		return err
+	}
+
+	s.deploy.mu.Lock()
+	s.deploy.staged = set
+	s.deploy.mu.Unlock()
+
+	s.logger.Application().Info("procedure set staged",
+		"directory", dir,
+		"procedures", set.Registry.Count(),
+		"problems", len(set.Problems),
+	)
+	return nil
+}
+
+// SwitchDeployment implements protocol.Admin. Executions already running
+// finish with the procedures they started with.
+func (s *Server) SwitchDeployment() error {
+	s.deploy.mu.Lock()
+	defer s.deploy.mu.Unlock()
+
+	staged := s.deploy.staged
+	if staged == nil {
+		return aulerrors.New(aulerrors.ErrCodeExecInvalidState, "no procedure set is staged").
+			WithOp("Server.SwitchDeployment").
+			Err()
+	}
+	if len(staged.Problems) > 0 {
+		return aulerrors.Newf(aulerrors.ErrCodeProcValidationError,
+			"staged procedure set has %d problems: %s", len(staged.Problems), staged.Problems[0]).
+			WithOp("Server.SwitchDeployment").
+			WithField("directory", staged.Dir).
+			Err()
+	}
+
+	// Procedures that carry over keep their execution stats
+	current := make(map[string]*procedure.Procedure)
+	for _, proc := range s.registry.List() {
+		current[strings.ToLower(proc.QualifiedName())] = proc
+	}
+	for _, proc := range staged.Registry.List() {
+		if cur, ok := current[strings.ToLower(proc.QualifiedName())]; ok {
+			proc.ExecCount = cur.ExecCount
+			proc.TotalTimeNs = cur.TotalTimeNs
+			proc.LastExecAt = cur.LastExecAt
+		}
+	}
+
+	prev := s.deploy.active
+	prev.registry = s.registry.Swap(staged.Registry)
+	s.deploy.previous = &prev
+	s.deploy.active = deployedSet{source: staged.Dir, loadedAt: staged.LoadedAt, activatedAt: time.Now()}
+	s.deploy.staged = nil
+	s.switched("deploy", prev.source)
+	return nil
+}
+
+// RollbackDeployment implements protocol.Admin. There is one level of
+// rollback: the set rolled back from is dropped.
+func (s *Server) RollbackDeployment() error {
+	s.deploy.mu.Lock()
+	defer s.deploy.mu.Unlock()
+
+	prev := s.deploy.previous
+	if prev == nil {
+		return aulerrors.New(aulerrors.ErrCodeExecInvalidState, "there is no previous procedure set to roll back to").
+			WithOp("Server.RollbackDeployment").
+			Err()
+	}
+	from := s.deploy.active.source
+	s.registry.Swap(prev.registry)
+	s.deploy.active = deployedSet{source: prev.source, loadedAt: prev.loadedAt, activatedAt: time.Now()}
+	s.deploy.previous = nil
+	s.switched("rollback", from)
+	return nil
+}
+
+// DiscardDeployment implements protocol.Admin.
+func (s *Server) DiscardDeployment() error {
+	s.deploy.mu.Lock()
+	defer s.deploy.mu.Unlock()
+
+	if s.deploy.staged == nil {
+		return aulerrors.New(aulerrors.ErrCodeExecInvalidState, "no procedure set is staged").
+			WithOp("Server.DiscardDeployment").
+			Err()
+	}
+	s.logger.Application().Info("staged procedure set discarded", "directory", s.deploy.staged.Dir)
+	s.deploy.staged = nil
+	return nil
+}
+
+// switched reports a change of active procedure set. Shadow candidates
+// were staged against procedures that are no longer active, so they go.
+// The caller holds s.deploy.mu.
+func (s *Server) switched(action, from string) {
+	if shadow := s.runtime.Shadow(); shadow != nil {
+		shadow.DiscardAll()
+	}
+	s.logger.Application().Info("procedure set switched",
+		"action", action,
+		"from", from,
+		"to", s.deploy.active.source,
+		"procedures", s.registry.Count(),
+	)
+	s.notifier.Notify(notify.EventDeploymentSwitched, "procedure set switched",
+		"action", action,
+		"from", from,
+		"to", s.deploy.active.source,
+	)
+}
+
+// diffProcedures lists the procedures staged adds to active, those whose
+// source differs and those it drops, by qualified name.
+func diffProcedures(active, staged *procedure.Registry) (added, changed, removed []string) {
+	current := make(map[string]*procedure.Procedure)
+	for _, proc := range active.List() {
+		current[strings.ToLower(proc.QualifiedName())] = proc
+	}
+	for _, proc := range staged.List() {
+		key := strings.ToLower(proc.QualifiedName())
+		cur, ok := current[key]
+		switch {
+		case !ok:
+			added = append(added, proc.QualifiedName())
+		case cur.SourceHash != proc.SourceHash:
+			changed = append(changed, proc.QualifiedName())
+		}
+		delete(current, key)
+	}
+	for _, proc := range current {
+		removed = append(removed, proc.QualifiedName())
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed
+}
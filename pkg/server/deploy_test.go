@@ -0,0 +1,111 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+func writeProcs(t *testing.T, procs map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range procs {
+		src := "CREATE PROCEDURE dbo." + name + " AS BEGIN " + body + " END"
+		if err := os.WriteFile(filepath.Join(dir, name+".sql"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestServer_Deployment(t *testing.T) {
+	blue := writeProcs(t, map[string]string{"Hello": "SELECT 1", "Bye": "SELECT 0"})
+	green := writeProcs(t, map[string]string{"Hello": "SELECT 2", "Added": "SELECT 3"})
+
+	cfg := DefaultConfig()
+	cfg.ProcedureDir = blue
+	cfg.JITEnabled = false
+	cfg.StorageConfig.Type = "memory"
+	cfg.Listeners = nil
+	cfg.Logger = log.New(log.Config{DefaultLevel: log.LevelError})
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := s.SwitchDeployment(); aulerrors.GetCode(err) != aulerrors.ErrCodeExecInvalidState {
+		t.Errorf("switch with nothing staged: %v", err)
+	}
+
+	if err := s.StageDeployment(green); err != nil {
+		t.Fatal(err)
+	}
+	staged := s.Deployment().Staged
+	if staged == nil || staged.Procedures != 2 || len(staged.Problems) != 0 {
+		t.Fatalf("staged = %+v", staged)
+	}
+	if !reflect.DeepEqual(staged.Added, []string{"dbo.Added"}) ||
+		!reflect.DeepEqual(staged.Changed, []string{"dbo.Hello"}) ||
+		!reflect.DeepEqual(staged.Removed, []string{"dbo.Bye"}) {
+		t.Errorf("diff: added %v, changed %v, removed %v", staged.Added, staged.Changed, staged.Removed)
+	}
+	if _, err := s.Registry().Lookup("dbo.Added"); err == nil {
+		t.Error("staged procedure visible before the switch")
+	}
+
+	if err := s.SwitchDeployment(); err != nil {
+		t.Fatal(err)
+	}
+	d := s.Deployment()
+	if d.Active.Source != green || d.Staged != nil || d.Previous == nil || d.Previous.Source != blue {
+		t.Errorf("after switch: %+v", d)
+	}
+	if _, err := s.Registry().Lookup("dbo.Added"); err != nil {
+		t.Errorf("after switch: %v", err)
+	}
+	if _, err := s.Registry().Lookup("dbo.Bye"); err == nil {
+		t.Error("removed procedure still registered after switch")
+	}
+
+	if err := s.RollbackDeployment(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Registry().Lookup("dbo.Bye"); err != nil {
+		t.Errorf("after rollback: %v", err)
+	}
+	if d := s.Deployment(); d.Active.Source != blue || d.Previous != nil {
+		t.Errorf("after rollback: %+v", d)
+	}
+	if err := s.RollbackDeployment(); aulerrors.GetCode(err) != aulerrors.ErrCodeExecInvalidState {
+		t.Errorf("second rollback: %v", err)
+	}
+
+	// A set that fails validation is staged but cannot go live
+	broken := writeProcs(t, map[string]string{"Hello": "SELECT 1"})
+	if err := os.WriteFile(filepath.Join(broken, "junk.sql"), []byte("SELECT 'no procedure here'"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StageDeployment(broken); err != nil {
+		t.Fatal(err)
+	}
+	if problems := s.Deployment().Staged.Problems; len(problems) != 1 {
+		t.Errorf("problems = %v", problems)
+	}
+	if err := s.SwitchDeployment(); aulerrors.GetCode(err) != aulerrors.ErrCodeProcValidationError {
+		t.Errorf("switch to an invalid set: %v", err)
+	}
+	if err := s.DiscardDeployment(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Deployment().Staged != nil {
+		t.Error("staged set not discarded")
+	}
+}
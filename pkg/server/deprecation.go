@@ -0,0 +1,62 @@
+package server
+
+import (
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/runtime"
+)
+
+// Deprecations implements protocol.Admin.
+func (s *Server) Deprecations() []protocol.Deprecation {
+	status := s.runtime.Deprecations().Status(s.registry.List())
+	out := make([]protocol.Deprecation, len(status))
+	for i, st := range status {
+		out[i] = protocol.Deprecation{
+			Procedure: st.Procedure,
+			Message:   st.Message,
+			Source:    st.Source,
+			Calls:     st.Calls,
+			Callers:   make([]protocol.DeprecatedCaller, len(st.Callers)),
+		}
+		if !st.LastCallAt.IsZero() {
+			at := st.LastCallAt
+			out[i].LastCallAt = &at
+		}
+		for j, c := range st.Callers {
+			out[i].Callers[j] = protocol.DeprecatedCaller(c)
+		}
+	}
+	return out
+}
+
+// SetDeprecated implements protocol.Admin. A deprecation by annotation
+// is taken back by removing the annotation, not here.
+func (s *Server) SetDeprecated(name, message string, on bool) error {
+	deprecations := s.runtime.Deprecations()
+	proc, err := s.registry.Lookup(name)
+	if on {
+		if err != nil {
+			return err
+		}
+		deprecations.Deprecate(proc, message)
+		name = proc.QualifiedName()
+	} else {
+		if err == nil {
+			if _, ok := proc.Annotations[runtime.DeprecationAnnotation]; ok {
+				return aulerrors.Newf(aulerrors.ErrCodeExecInvalidState,
+					"%s is deprecated by its -- @aul:%s annotation; remove the annotation instead",
+					proc.QualifiedName(), runtime.DeprecationAnnotation).
+					WithOp("Server.SetDeprecated").
+					Err()
+			}
+			name = proc.QualifiedName()
+		}
+		if !deprecations.Undeprecate(name) {
+			return aulerrors.NotFound("deprecation", name).
+				WithOp("Server.SetDeprecated").
+				Err()
+		}
+	}
+	s.logger.Application().Info("procedure deprecation changed", "procedure", name, "deprecated", on, "message", message)
+	return nil
+}
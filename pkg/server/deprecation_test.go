@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+func TestServer_Deprecations(t *testing.T) {
+	dir := writeProcs(t, map[string]string{
+		"Legacy": "SELECT 1 AS n",
+		"Caller": "EXEC dbo.Old",
+	})
+	src := "-- @aul:deprecated=use dbo.Legacy\nCREATE PROCEDURE dbo.Old AS BEGIN SELECT 2 AS n END"
+	if err := os.WriteFile(filepath.Join(dir, "Old.sql"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.ProcedureDir = dir
+	cfg.JITEnabled = false
+	cfg.StorageConfig.Type = "memory"
+	cfg.Listeners = nil
+	cfg.DeprecationWarnings = true
+	cfg.Logger = log.New(log.Config{DefaultLevel: log.LevelError})
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := s.SetDeprecated("dbo.legacy", "", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDeprecated("dbo.Missing", "", true); err == nil {
+		t.Error("deprecated a procedure that does not exist")
+	}
+	if err := s.SetDeprecated("dbo.Old", "", false); err == nil {
+		t.Error("undeprecated a procedure deprecated by annotation")
+	}
+
+	conn := &scriptedConn{requests: []protocol.Request{
+		{ID: "1", Type: protocol.RequestExec, ProcedureName: "dbo.Old"},
+		{ID: "2", Type: protocol.RequestExec, ProcedureName: "dbo.Caller"},
+		{ID: "3", Type: protocol.RequestExec, ProcedureName: "dbo.Legacy"},
+	}}
+	h := NewConnectionHandler(conn, s.runtime, s.registry, s.logger, false)
+	h.user, h.app, h.protocol = "alice", "reports", protocol.ProtocolPostgres
+	h.Serve(context.Background())
+
+	if len(conn.results) != 3 {
+		t.Fatalf("results = %+v", conn.results)
+	}
+	for i, want := range []string{
+		"Procedure dbo.Old is deprecated: use dbo.Legacy",
+		"Procedure dbo.Old is deprecated: use dbo.Legacy",
+		"Procedure dbo.Legacy is deprecated",
+	} {
+		if w := conn.results[i].Warnings; len(w) != 1 || w[0] != want {
+			t.Errorf("result %d warnings = %q, want %q", i, w, want)
+		}
+	}
+
+	got := s.Deprecations()
+	if len(got) != 2 || got[0].Procedure != "dbo.Legacy" || got[0].Source != "admin" || got[1].Source != "annotation" {
+		t.Fatalf("deprecations = %+v", got)
+	}
+	old := got[1]
+	if old.Calls != 2 || old.LastCallAt == nil || len(old.Callers) != 1 {
+		t.Fatalf("dbo.Old = %+v", old)
+	}
+	if c := old.Callers[0]; c.User != "alice" || c.App != "reports" || c.Protocol != "postgres" || c.Calls != 2 {
+		t.Errorf("caller = %+v", c)
+	}
+
+	if err := s.SetDeprecated("dbo.Legacy", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDeprecated("dbo.Legacy", "", false); err == nil {
+		t.Error("undeprecated a procedure twice")
+	}
+	if got := s.Deprecations(); len(got) != 1 || got[0].Procedure != "dbo.Old" {
+		t.Errorf("deprecations = %+v", got)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/erasure"
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/runtime"
+)
+
+// erasureSessionID is the session erasures run in.
+const erasureSessionID = "erasure"
+
+// EraseSubject implements protocol.Admin. The plan's statements run as
+// one batch in a transaction, the subject keys bound to its parameters, so that a subject is erased from every
+// table or from none; a dry run rolls them back once counted. Either way
+// the certificate goes to the audit log.
+func (s *Server) EraseSubject(req erasure.Request) (*erasure.Result, error) {
+	plan, err := s.config.Erasure.Plan(req.Keys)
+	if err != nil {
+		return nil, err
+	}
+	stmts := plan.Statements()
+
+	var counts []int64
+	if len(stmts) > 0 {
+		batch := strings.Join(stmts, ";\n")
+		if !req.DryRun {
+			batch = "BEGIN TRY\nBEGIN TRANSACTION;\n" + batch + ";\nCOMMIT TRANSACTION;\nEND TRY\n" +
+				"BEGIN CATCH\nIF @@TRANCOUNT > 0 ROLLBACK TRANSACTION;\nTHROW;\nEND CATCH"
+		}
+		execResult, err := s.runtime.ExecuteSQL(s.ctx, batch, &runtime.ExecContext{
+			SessionID:  erasureSessionID,
+			Database:   req.Database,
+			Tenant:     req.Tenant,
+			DryRun:     req.DryRun,
+			Summary:    true,
+			Parameters: plan.Parameters,
+		})
+		if err != nil {
+			s.logger.Audit().Error("data subject erasure failed", err,
+				"subject", plan.Subject,
+				"reference", req.Reference,
+				"dry_run", req.DryRun,
+			)
+			return nil, aulerrors.Wrap(err, aulerrors.ErrCodeExecFailed, "erasure failed; nothing was erased").
+				WithOp("Server.EraseSubject").
+				Err()
+		}
+		for _, st := range execResult.Statements {
+			if st.Type == "DELETE" || st.Type == "UPDATE" {
+				counts = append(counts, st.RowsAffected)
+			}
+		}
+	}
+
+	cert := erasure.Certificate{
+		ID:        protocol.NewRequestID(),
+		IssuedAt:  time.Now().UTC(),
+		Subject:   plan.Subject,
+		Reference: req.Reference,
+		Database:  req.Database,
+		Tenant:    req.Tenant,
+		DryRun:    req.DryRun,
+		Tables:    make([]erasure.TableResult, len(plan.Steps)),
+	}
+	var tables []string
+	for i, step := range plan.Steps {
+		cert.Tables[i] = erasure.TableResult{Table: step.Table, Action: step.Action, Skipped: step.Skipped}
+		if step.Skipped == "" && len(counts) > 0 {
+			cert.Tables[i].Rows = counts[0]
+			counts = counts[1:]
+		}
+		cert.TotalRows += cert.Tables[i].Rows
+		if step.Skipped != "" {
+			tables = append(tables, step.Table+"=skipped")
+		} else {
+			tables = append(tables, fmt.Sprintf("%s=%s %d", step.Table, step.Action, cert.Tables[i].Rows))
+		}
+	}
+	cert.Seal()
+
+	s.logger.Audit().Info("data subject erased",
+		"certificate", cert.ID,
+		"subject", cert.Subject,
+		"reference", cert.Reference,
+		"database", cert.Database,
+		"tenant", cert.Tenant,
+		"dry_run", cert.DryRun,
+		"tables", strings.Join(tables, ","),
+		"total_rows", cert.TotalRows,
+		"digest", cert.Digest,
+	)
+	return &erasure.Result{Certificate: cert, Statements: stmts}, nil
+}
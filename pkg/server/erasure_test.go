@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/erasure"
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/log"
+)
+
+func TestServer_EraseSubject(t *testing.T) {
+	var audit bytes.Buffer
+	cfg := DefaultConfig()
+	cfg.ProcedureDir = ""
+	cfg.JITEnabled = false
+	cfg.StorageConfig.Type = "sqlite"
+	cfg.StorageConfig.Options = map[string]string{"path": filepath.Join(t.TempDir(), "aul.db")}
+	cfg.Listeners = nil
+	cfg.Logger = log.New(log.Config{
+		DefaultLevel:   log.LevelError,
+		CategoryLevels: map[log.Category]log.Level{log.CategoryAudit: log.LevelInfo},
+		Output:         &audit,
+		Format:         log.FormatJSON,
+	})
+	cfg.Erasure = erasure.Config{Tables: []erasure.Table{
+		{Table: "dbo.Orders", Keys: map[string]string{"CustomerID": "customer_id"}, Action: erasure.ActionUpdate,
+			Set: map[string]interface{}{"ShipTo": "erased"}},
+		{Table: "dbo.Customers", Keys: map[string]string{"ID": "customer_id"}, Action: erasure.ActionDelete},
+		{Table: "dbo.Subscribers", Keys: map[string]string{"Email": "email"}, Action: erasure.ActionDelete},
+	}, Key: []byte("0123456789abcdef0123456789abcdef")}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if _, err := s.execInit("CREATE TABLE Customers (ID INT, Name NVARCHAR(50)); " +
+		"INSERT INTO Customers VALUES (1, 'Ada'), (2, 'Grace'); " +
+		"CREATE TABLE Orders (ID INT, CustomerID INT, ShipTo NVARCHAR(100)); " +
+		"INSERT INTO Orders VALUES (10, 1, '1 Analytical Way'), (11, 1, '1 Analytical Way'), (12, 2, '2 Compiler Road'); " +
+		"CREATE TABLE Subscribers (Email NVARCHAR(100))"); err != nil {
+		t.Fatal(err)
+	}
+	count := func(query string) interface{} {
+		t.Helper()
+		result, err := s.execInit(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.ResultSets[0].Rows[0][0]
+	}
+	keys := map[string]interface{}{"customer_id": json.Number("1")}
+
+	// A dry run counts the rows and changes nothing
+	result, err := s.EraseSubject(erasure.Request{Keys: keys, DryRun: true, Reference: "DSR-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := result.Certificate
+	if !cert.DryRun || cert.TotalRows != 3 || cert.Tables[0].Rows != 2 || cert.Tables[1].Rows != 1 || cert.Tables[2].Skipped == "" {
+		t.Errorf("dry run certificate %+v", cert)
+	}
+	if n := count("SELECT COUNT(*) FROM Customers"); n != int64(2) {
+		t.Errorf("%v customers after a dry run", n)
+	}
+
+	result, err = s.EraseSubject(erasure.Request{Keys: keys, Reference: "DSR-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert = result.Certificate
+	if cert.DryRun || cert.TotalRows != 3 || len(result.Statements) != 2 || cert.Digest == "" {
+		t.Errorf("certificate %+v, statements %q", cert, result.Statements)
+	}
+	if n := count("SELECT COUNT(*) FROM Customers WHERE ID = 1"); n != int64(0) {
+		t.Errorf("customer 1 left")
+	}
+	if n := count("SELECT COUNT(*) FROM Orders WHERE ShipTo = 'erased'"); n != int64(2) {
+		t.Errorf("%v orders overwritten, want 2", n)
+	}
+	if n := count("SELECT COUNT(*) FROM Customers"); n != int64(1) {
+		t.Errorf("%v customers left, want 1", n)
+	}
+
+	// The audit log has the certificate, and not the subject's keys
+	logged := audit.String()
+	if !strings.Contains(logged, cert.ID) || !strings.Contains(logged, cert.Digest) ||
+		!strings.Contains(logged, "dbo.Orders=update 2") || !strings.Contains(logged, "dbo.Subscribers=skipped") {
+		t.Errorf("audit log %s", logged)
+	}
+
+	// Keys are bound, so a quote in one neither breaks nor widens the
+	// statement
+	if _, err := s.execInit("INSERT INTO Subscribers VALUES ('o''brien@example.com'), ('x@example.com')"); err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"x' OR '1' = '1", "o'brien@example.com"} {
+		if _, err := s.EraseSubject(erasure.Request{Keys: map[string]interface{}{"email": email}}); err != nil {
+			t.Fatalf("%s: %v", email, err)
+		}
+	}
+	if n := count("SELECT COUNT(*) FROM Subscribers WHERE Email = 'x@example.com'"); n != int64(1) {
+		t.Errorf("%v subscribers x@example.com left, want 1", n)
+	}
+	if n := count("SELECT COUNT(*) FROM Subscribers"); n != int64(1) {
+		t.Errorf("%v subscribers left, want 1", n)
+	}
+
+	// A failing statement erases nothing
+	s.config.Erasure.Tables = append(s.config.Erasure.Tables,
+		erasure.Table{Table: "Missing", Keys: map[string]string{"ID": "customer_id"}, Action: erasure.ActionDelete})
+	_, err = s.EraseSubject(erasure.Request{Keys: map[string]interface{}{"customer_id": 2}})
+	if aulerrors.GetCode(err) != aulerrors.ErrCodeExecFailed {
+		t.Errorf("got %v", err)
+	}
+	if n := count("SELECT COUNT(*) FROM Customers WHERE ID = 2"); n != int64(1) {
+		t.Errorf("customer 2 erased by a failed erasure")
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+
+	"github.com/ha1tch/aul/pkg/firewall"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// checkFirewall returns the error req fails with when the firewall denies
+// it, or nil. Denials go to the audit log with the SQL's fingerprint and
+// its text without literals, which may hold personal data; in audit mode
+// they are logged and the request runs.
+func (h *ConnectionHandler) checkFirewall(ctx context.Context, req protocol.Request) error {
+	if h.firewall == nil {
+		return nil
+	}
+	var fwReq firewall.Request
+	switch req.Type {
+	case protocol.RequestExec, protocol.RequestCall:
+		fwReq.Procedure = req.ProcedureName
+	case protocol.RequestQuery:
+		fwReq.SQL = req.SQL
+	default:
+		return nil
+	}
+	fwReq.Listener = h.listener
+	fwReq.User = h.user
+
+	d := h.firewall.Check(fwReq)
+	if d.Allowed && !d.Audited {
+		return nil
+	}
+	msg := "request denied by firewall"
+	if d.Audited {
+		msg = "request would be denied by firewall"
+	}
+	h.logger.Audit().WithContext(ctx).Warn(msg,
+		"session_id", h.sessionID,
+		"listener", h.listener,
+		"user", h.user,
+		"app", h.app,
+		"address", h.address,
+		"tenant", h.tenant,
+		"rule", d.Rule,
+		"request_type", req.Type.String(),
+		"procedure", req.ProcedureName,
+		"fingerprint", d.Fingerprint,
+		"query", d.Query,
+	)
+	return d.Err()
+}
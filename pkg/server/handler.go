@@ -1,17 +1,44 @@
 package server
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"runtime/debug"
+	"strings"
 	"time"
 
 	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/firewall"
+	"github.com/ha1tch/aul/pkg/hooks"
 	"github.com/ha1tch/aul/pkg/log"
 	"github.com/ha1tch/aul/pkg/procedure"
 	"github.com/ha1tch/aul/pkg/protocol"
 	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/sqlcmd"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
 )
 
+// summaryMode is how a session reports what the statements of each
+// execution did.
+type summaryMode int
+
+const (
+	summaryOff       summaryMode = iota // Only when a request asks
+	summaryMetadata                     // In Result.Statements, which HTTP and gRPC return as metadata
+	summaryResultSet                    // In a last result set as well, for TDS and PostgreSQL clients
+)
+
+// summaryColumns are the columns of the result set a summary is sent as.
+var summaryColumns = []protocol.ColumnInfo{
+	{Name: "statement_type", Type: "nvarchar", Ordinal: 0},
+	{Name: "target", Type: "nvarchar", Nullable: true, Ordinal: 1},
+	{Name: "executions", Type: "bigint", Ordinal: 2},
+	{Name: "rows_affected", Type: "bigint", Ordinal: 3},
+	{Name: "duration_ms", Type: "float", Ordinal: 4},
+	{Name: "statement", Type: "nvarchar", Ordinal: 5},
+}
+
 // ConnectionHandler handles a single client connection.
 type ConnectionHandler struct {
 	conn       protocol.Connection
@@ -24,8 +51,29 @@ type ConnectionHandler struct {
 	sessionID   string
 	currentDB   string
 	tenant      string // Tenant ID (empty for single-tenant mode)
+	user        string // Principal the client connected as, when known
+	app         string // Application name the client gave
+	host        string // Host name the client gave, when it gave one
+	address     string // Address the client connected from
+	spid        int    // Session number, for @@SPID
+	protocol    protocol.ProtocolType
+	priority    runtime.Priority
 	inTxn       bool
 	txnCtx      *runtime.TransactionContext
+	broken      bool // A panic was recovered; the session is closed
+	readOnly    bool // Statements that change the database fail
+	enforcePermissions bool // Statements are checked against the permissions of user
+	execTimeout time.Duration // Longest a request may run (0 = no limit)
+	parameterizedOnly bool // Ad-hoc SQL with string literals fails unless sent with parameters
+	listener    string             // Name of the listener the client connected to
+	firewall    *firewall.Firewall // Rules requests must pass (nil = none)
+	hooks       *hooks.Chain       // Hooks run at login and around statements (nil = none)
+	hookLogin   *hooks.Login       // The session, as the hooks see it
+	refused     error              // Why the login hooks refused the session
+	streamResults bool // A single SELECT's rows are sent as they are read, when the listener can take them
+	summary     summaryMode // How every execution reports what its statements did
+	sqlcmd      *sqlcmd.Processor // Scripting variables in sqlcmd mode (nil = off)
+	traces      *traceStore       // Where traces are kept (nil = not traced)
 }
 
 // NewConnectionHandler creates a new connection handler.
@@ -47,26 +95,34 @@ func NewConnectionHandlerWithTenant(conn protocol.Connection, rt *runtime.Runtim
 	logger.Application().Debug("connection handler created", fields...)
 	
 	return &ConnectionHandler{
-		conn:       conn,
-		runtime:    rt,
-		registry:   reg,
-		logger:     logger,
-		logQueries: logQueries,
-		sessionID:  sessionID,
-		currentDB:  "master", // Default database
-		tenant:     tenant,
+		conn:        conn,
+		runtime:     rt,
+		registry:    reg,
+		logger:      logger,
+		logQueries:  logQueries,
+		sessionID:   sessionID,
+		currentDB:   "master", // Default database
+		tenant:      tenant,
+		execTimeout: 30 * time.Second,
 	}
 }
 
 // Serve handles requests from the connection until it closes.
 func (h *ConnectionHandler) Serve(ctx context.Context) {
-	execLog := h.logger.Execution().WithFields("session_id", h.sessionID)
+	ctx = log.WithSessionID(ctx, h.sessionID)
+	// Application locks the session owns outlive its transactions, but
+	// not the session
+	defer h.runtime.Locks().ReleaseSession(h.sessionID)
 
 	h.logger.Application().Info("session started",
 		"session_id", h.sessionID,
 		"database", h.currentDB,
 	)
 
+	if h.hooks != nil {
+		h.refused = h.loginHooks(ctx)
+	}
+
 	requestCount := 0
 	for {
 		select {
@@ -80,8 +136,12 @@ func (h *ConnectionHandler) Serve(ctx context.Context) {
 		}
 
 		// Read next request
-		req, err := h.conn.ReadRequest()
-		if err != nil {
+		var req protocol.Request
+		var err error
+		panicErr := h.guard(ctx, "ConnectionHandler.ReadRequest", func() {
+			req, err = h.conn.ReadRequest()
+		})
+		if panicErr == nil && err != nil {
 			// Connection closed or error
 			h.logger.Application().Debug("session ended",
 				"session_id", h.sessionID,
@@ -94,8 +154,38 @@ func (h *ConnectionHandler) Serve(ctx context.Context) {
 		requestCount++
 		startTime := time.Now()
 
+		// The protocol layer gives each request an ID that the runtime's
+		// logs carry and that is reported to the client with errors
+		if req.ID == "" {
+			req.ID = protocol.NewRequestID()
+		}
+		reqCtx := log.WithRequestID(ctx, req.ID)
+		execLog := h.logger.Execution().WithContext(reqCtx)
+
 		// Process request
-		result := h.processRequest(ctx, req)
+		// A cancel is watched for until the result is sent, since the rows
+		// of a stream are read as it is
+		var result protocol.Result
+		stopWatch := func() {}
+		if panicErr == nil {
+			var runCtx context.Context
+			runCtx, stopWatch = h.watchCancel(reqCtx, req)
+			panicErr = h.guard(reqCtx, "ConnectionHandler.processRequest", func() {
+				result = h.processRequest(runCtx, req)
+			})
+		}
+		if panicErr != nil {
+			result = protocol.Result{
+				Type:    protocol.ResultError,
+				Error:   panicErr,
+				Message: panicErr.Error(),
+			}
+		} else if aulerrors.IsCode(result.Error, aulerrors.ErrCodePanic) {
+			// The runtime recovered from a panic: what it was running may
+			// have left shared state half changed
+			h.broken = true
+		}
+		result.RequestID = req.ID
 
 		elapsed := time.Since(startTime)
 
@@ -115,18 +205,87 @@ func (h *ConnectionHandler) Serve(ctx context.Context) {
 			)
 		}
 
-		// Send result
-		if err := h.conn.SendResult(result); err != nil {
+		h.auditSensitivity(reqCtx, req, result)
+
+		// Send result, then stop the queries of any streams it did not
+		// read to the end
+		panicErr = h.guard(reqCtx, "ConnectionHandler.SendResult", func() { err = h.conn.SendResult(result) })
+		protocol.CloseStreams(result)
+		stopWatch()
+		if panicErr != nil {
+			return
+		}
+		if err != nil {
 			h.logger.Application().Error("failed to send result", err,
 				"session_id", h.sessionID,
+				"request_id", req.ID,
+			)
+			return
+		}
+
+		if h.refused != nil {
+			return
+		}
+		if h.broken {
+			h.logger.Application().Warn("session closed after a severe error",
+				"session_id", h.sessionID,
+				"request_id", req.ID,
+				"requests_handled", requestCount,
 			)
 			return
 		}
 	}
 }
 
-// processRequest handles a single request.
+// guard runs fn, recovering from a panic in it. A panic breaks the
+// session: the client is sent a severity 20 error and the connection is
+// closed, as SQL Server closes it after a fatal error, since the panic may
+// have left the session's state half changed.
+func (h *ConnectionHandler) guard(ctx context.Context, op string, fn func()) (panicErr error) {
+	defer func() {
+		if v := recover(); v != nil {
+			h.runtime.RecordPanic(ctx, op, v, debug.Stack())
+			h.broken = true
+			panicErr = runtime.PanicError(op, v)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// processRequest handles a single request, between the statement hooks
+// when it runs SQL or calls a procedure.
 func (h *ConnectionHandler) processRequest(ctx context.Context, req protocol.Request) protocol.Result {
+	if h.refused != nil {
+		return protocol.Result{
+			Type:    protocol.ResultError,
+			Error:   h.refused,
+			Message: h.refused.Error(),
+		}
+	}
+	if h.hooks != nil {
+		switch req.Type {
+		case protocol.RequestExec, protocol.RequestQuery, protocol.RequestCall:
+			return h.processHooked(ctx, req)
+		}
+	}
+	return h.process(ctx, req)
+}
+
+// process handles a single request.
+func (h *ConnectionHandler) process(ctx context.Context, req protocol.Request) protocol.Result {
+	err := h.checkFirewall(ctx, req)
+	if err == nil {
+		err = h.checkParameterized(ctx, req)
+	}
+	if err != nil {
+		return protocol.Result{
+			Type:    protocol.ResultError,
+			Error:   err,
+			Message: err.Error(),
+		}
+	}
+
 	switch req.Type {
 	case protocol.RequestExec:
 		return h.handleExec(ctx, req)
@@ -144,10 +303,14 @@ func (h *ConnectionHandler) processRequest(ctx context.Context, req protocol.Req
 		return h.handleRollback(ctx, req)
 	case protocol.RequestPing:
 		return protocol.Result{Type: protocol.ResultOK, Message: "pong"}
+	case protocol.RequestCancel:
+		// The request it cancels, if still running, was cancelled when the
+		// attention arrived; this acknowledges it
+		return protocol.Result{Type: protocol.ResultCancel}
 	default:
 		err := aulerrors.Newf(aulerrors.ErrCodeProtocolError,
 			"unknown request type: %d", req.Type).
-			WithOp("ConnectionHandler.processRequest").
+			WithOp("ConnectionHandler.process").
 			Err()
 		return protocol.Result{
 			Type:    protocol.ResultError,
@@ -178,13 +341,25 @@ func (h *ConnectionHandler) handleExec(ctx context.Context, req protocol.Request
 
 	// Build execution context
 	execCtx := &runtime.ExecContext{
-		SessionID:   h.sessionID,
-		Database:    h.currentDB,
-		Tenant:      h.tenant,
-		Parameters:  req.Parameters,
-		Timeout:     30 * time.Second,
-		InTxn:       h.inTxn,
-		TxnContext:  h.txnCtx,
+		SessionID:          h.sessionID,
+		Database:           h.currentDB,
+		Tenant:             h.tenant,
+		User:               h.user,
+		App:                h.app,
+		Protocol:           string(h.protocol),
+		Host:               h.host,
+		Address:            h.address,
+		SPID:               h.spid,
+		Priority:           h.priority,
+		ReadOnly:           h.readOnly,
+		EnforcePermissions: h.enforcePermissions,
+		DryRun:             req.Options.DryRun,
+		Summary:            h.summary != summaryOff || req.Options.Summary,
+		Trace:              h.newTrace(req, proc.QualifiedName()),
+		Parameters:         req.Parameters,
+		Timeout:            h.timeout(req),
+		InTxn:              h.inTxn,
+		TxnContext:         h.txnCtx,
 	}
 
 	// Execute
@@ -198,21 +373,28 @@ func (h *ConnectionHandler) handleExec(ctx context.Context, req protocol.Request
 				WithField("procedure", req.ProcedureName).
 				Err()
 		}
-		return protocol.Result{
+		return h.record(protocol.Result{
 			Type:    protocol.ResultError,
 			Error:   err,
 			Message: err.Error(),
-		}
+		}, req, execCtx.Trace)
 	}
 
-	return protocol.Result{
-		Type:         protocol.ResultOK,
+	// A procedure's result sets are sent ahead of its outputs
+	resultType := protocol.ResultOK
+	if len(execResult.ResultSets) > 0 {
+		resultType = protocol.ResultRows
+	}
+
+	return h.record(h.summarize(protocol.Result{
+		Type:         resultType,
 		RowsAffected: execResult.RowsAffected,
 		ResultSets:   convertResultSets(execResult.ResultSets),
 		ReturnValue:  execResult.ReturnValue,
 		OutputParams: execResult.OutputParams,
+		Warnings:     execResult.Warnings,
 		Message:      fmt.Sprintf("(%d rows affected)", execResult.RowsAffected),
-	}
+	}, execResult.Statements), req, execResult.Trace)
 }
 
 // handleQuery handles direct SQL queries.
@@ -221,29 +403,52 @@ func (h *ConnectionHandler) handleQuery(ctx context.Context, req protocol.Reques
 	if h.logQueries {
 		h.logger.Application().Info("SQL",
 			"session_id", h.sessionID,
+			"request_id", req.ID,
 			"query", req.SQL,
 		)
 	}
 
 	// Build execution context
 	execCtx := &runtime.ExecContext{
-		SessionID:  h.sessionID,
-		Database:   h.currentDB,
-		Tenant:     h.tenant,
-		Parameters: req.Parameters,
-		Timeout:    30 * time.Second,
-		InTxn:      h.inTxn,
-		TxnContext: h.txnCtx,
+		SessionID:          h.sessionID,
+		Database:           h.currentDB,
+		Tenant:             h.tenant,
+		User:               h.user,
+		App:                h.app,
+		Protocol:           string(h.protocol),
+		Host:               h.host,
+		Address:            h.address,
+		SPID:               h.spid,
+		Priority:           h.priority,
+		ReadOnly:           h.readOnly,
+		EnforcePermissions: h.enforcePermissions,
+		DryRun:             req.Options.DryRun,
+		Summary:            h.summary != summaryOff || req.Options.Summary,
+		Trace:              h.newTrace(req, ""),
+		Parameters:         req.Parameters,
+		Outputs:            req.Outputs,
+		Timeout:            h.timeout(req),
+		InTxn:              h.inTxn,
+		TxnContext:         h.txnCtx,
 	}
 
-	// Execute ad-hoc SQL
-	execResult, err := h.runtime.ExecuteSQL(ctx, req.SQL, execCtx)
+	if h.sqlcmd != nil && sqlcmd.IsScript(req.SQL) {
+		return h.handleScript(ctx, req, execCtx)
+	}
+
+	// Execute ad-hoc SQL, streaming the rows of a single SELECT when the
+	// result is sent as it is, with no output parameters to read after it
+	execute := h.runtime.ExecuteSQL
+	if h.streamResults && req.Options.Stream && h.hooks == nil && !execCtx.DryRun && !execCtx.Summary && execCtx.Trace == nil && len(req.Outputs) == 0 {
+		execute = h.runtime.ExecuteSQLStream
+	}
+	execResult, err := execute(ctx, req.SQL, execCtx)
 	if err != nil {
-		return protocol.Result{
+		return h.record(protocol.Result{
 			Type:    protocol.ResultError,
 			Error:   err,
 			Message: err.Error(),
-		}
+		}, req, execCtx.Trace)
 	}
 
 	// If there are result sets, return ResultRows
@@ -252,12 +457,62 @@ func (h *ConnectionHandler) handleQuery(ctx context.Context, req protocol.Reques
 		resultType = protocol.ResultRows
 	}
 
-	return protocol.Result{
+	return h.record(h.summarize(protocol.Result{
 		Type:         resultType,
 		RowsAffected: execResult.RowsAffected,
 		ResultSets:   convertResultSets(execResult.ResultSets),
+		OutputParams: execResult.OutputParams,
+		Warnings:     execResult.Warnings,
 		Message:      fmt.Sprintf("(%d rows affected)", execResult.RowsAffected),
+	}, execResult.Statements), req, execResult.Trace)
+}
+
+// handleScript runs a sqlcmd script batch by batch, returning the results
+// of all its batches together. Under :on error exit the first failure is
+// the result; otherwise each failure becomes a warning and the script
+// goes on, as sqlcmd prints the error and carries on.
+func (h *ConnectionHandler) handleScript(ctx context.Context, req protocol.Request, execCtx *runtime.ExecContext) protocol.Result {
+	var (
+		resultSets   []runtime.ResultSet
+		rowsAffected int64
+		warnings     []string
+		statements   []tsqlruntime.StatementSummary
+		trace        = execCtx.Trace
+	)
+	err := h.sqlcmd.Run(req.SQL, "", func(batch string) error {
+		execResult, err := h.runtime.ExecuteSQL(ctx, batch, execCtx)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			return err
+		}
+		resultSets = append(resultSets, execResult.ResultSets...)
+		rowsAffected += execResult.RowsAffected
+		warnings = append(warnings, execResult.Warnings...)
+		statements = append(statements, execResult.Statements...)
+		if trace == nil {
+			trace = execResult.Trace
+		}
+		return nil
+	})
+	if err != nil {
+		return h.record(protocol.Result{
+			Type:    protocol.ResultError,
+			Error:   err,
+			Message: err.Error(),
+		}, req, trace)
 	}
+
+	resultType := protocol.ResultOK
+	if len(resultSets) > 0 {
+		resultType = protocol.ResultRows
+	}
+	return h.record(h.summarize(protocol.Result{
+		Type:         resultType,
+		RowsAffected: rowsAffected,
+		ResultSets:   convertResultSets(resultSets),
+		Warnings:     warnings,
+		Message:      fmt.Sprintf("(%d rows affected)", rowsAffected),
+	}, statements), req, trace)
 }
 
 // handlePrepare handles prepared statement creation.
@@ -285,8 +540,7 @@ func (h *ConnectionHandler) handleBeginTxn(ctx context.Context, req protocol.Req
 		if h.txnCtx != nil {
 			h.txnCtx.NestingLevel++
 		}
-		h.logger.Execution().Debug("nested transaction started",
-			"session_id", h.sessionID,
+		h.logger.Execution().WithContext(ctx).Debug("nested transaction started",
 			"nesting_level", h.txnCtx.NestingLevel,
 		)
 		return protocol.Result{
@@ -302,8 +556,7 @@ func (h *ConnectionHandler) handleBeginTxn(ctx context.Context, req protocol.Req
 		NestingLevel: 1,
 	}
 
-	h.logger.Execution().Debug("transaction started",
-		"session_id", h.sessionID,
+	h.logger.Execution().WithContext(ctx).Debug("transaction started",
 		"txn_id", h.txnCtx.ID,
 	)
 
@@ -328,8 +581,7 @@ func (h *ConnectionHandler) handleCommit(ctx context.Context, req protocol.Reque
 
 	if h.txnCtx != nil && h.txnCtx.NestingLevel > 1 {
 		h.txnCtx.NestingLevel--
-		h.logger.Execution().Debug("nested transaction committed",
-			"session_id", h.sessionID,
+		h.logger.Execution().WithContext(ctx).Debug("nested transaction committed",
 			"txn_id", h.txnCtx.ID,
 			"nesting_level", h.txnCtx.NestingLevel,
 		)
@@ -352,8 +604,7 @@ func (h *ConnectionHandler) handleCommit(ctx context.Context, req protocol.Reque
 		}
 	}
 
-	h.logger.Execution().Debug("transaction committed",
-		"session_id", h.sessionID,
+	h.logger.Execution().WithContext(ctx).Debug("transaction committed",
 		"txn_id", h.txnCtx.ID,
 	)
 
@@ -394,8 +645,7 @@ func (h *ConnectionHandler) handleRollback(ctx context.Context, req protocol.Req
 		}
 	}
 
-	h.logger.Execution().Debug("transaction rolled back",
-		"session_id", h.sessionID,
+	h.logger.Execution().WithContext(ctx).Debug("transaction rolled back",
 		"txn_id", txnID,
 	)
 
@@ -418,6 +668,42 @@ func generateTxnID() string {
 	return fmt.Sprintf("txn_%d", time.Now().UnixNano())
 }
 
+// auditSensitivity records in the audit log the classified columns
+// result returns, so that compliance tooling can follow where sensitive
+// data goes.
+func (h *ConnectionHandler) auditSensitivity(ctx context.Context, req protocol.Request, result protocol.Result) {
+	var columns, labels []string
+	var rows int
+	var streamed bool // Rows not yet read, which are not counted
+	for _, rs := range result.ResultSets {
+		classified := false
+		for _, col := range rs.Columns {
+			if s := col.Sensitivity; s != nil {
+				columns = append(columns, s.Column)
+				labels = append(labels, cmp.Or(s.Label, s.InformationType, s.Rank))
+				classified = true
+			}
+		}
+		if classified {
+			rows += len(rs.Rows)
+			streamed = streamed || rs.Stream != nil
+		}
+	}
+	if len(columns) == 0 {
+		return
+	}
+	h.logger.Audit().WithContext(ctx).Info("sensitive columns returned",
+		"session_id", h.sessionID,
+		"user", h.user,
+		"tenant", h.tenant,
+		"procedure", req.ProcedureName,
+		"columns", strings.Join(columns, ","),
+		"labels", strings.Join(labels, ","),
+		"rows", rows,
+		"streamed", streamed,
+	)
+}
+
 // convertResultSets converts runtime.ResultSet to protocol.ResultSet.
 func convertResultSets(rsSets []runtime.ResultSet) []protocol.ResultSet {
 	result := make([]protocol.ResultSet, len(rsSets))
@@ -425,17 +711,60 @@ func convertResultSets(rsSets []runtime.ResultSet) []protocol.ResultSet {
 		cols := make([]protocol.ColumnInfo, len(rs.Columns))
 		for j, col := range rs.Columns {
 			cols[j] = protocol.ColumnInfo{
-				Name:     col.Name,
-				Type:     col.Type,
-				Nullable: col.Nullable,
-				Length:   col.Length,
-				Ordinal:  col.Ordinal,
+				Name:        col.Name,
+				Type:        col.Type,
+				Nullable:    col.Nullable,
+				Length:      col.Length,
+				Ordinal:     col.Ordinal,
+				Sensitivity: col.Sensitivity,
 			}
 		}
 		result[i] = protocol.ResultSet{
 			Columns: cols,
 			Rows:    rs.Rows,
 		}
+		if rs.Stream != nil {
+			result[i].Stream = rs.Stream
+		}
+	}
+	return result
+}
+
+// summarize adds the summary of an execution's statements to its result,
+// when it was asked for.
+func (h *ConnectionHandler) summarize(result protocol.Result, statements []tsqlruntime.StatementSummary) protocol.Result {
+	if statements == nil && h.summary != summaryResultSet {
+		return result
+	}
+	result.Statements = make([]protocol.StatementSummary, len(statements))
+	for i, s := range statements {
+		result.Statements[i] = protocol.StatementSummary{
+			Type:         s.Type,
+			Target:       s.Target,
+			Statement:    s.Text,
+			Executions:   s.Executions,
+			RowsAffected: s.RowsAffected,
+			Duration:     s.Duration,
+		}
+	}
+	if h.summary != summaryResultSet {
+		return result
+	}
+
+	rs := protocol.ResultSet{Columns: summaryColumns, Rows: make([][]interface{}, len(statements))}
+	for i, s := range result.Statements {
+		var target interface{}
+		if s.Target != "" {
+			target = s.Target
+		}
+		rs.Rows[i] = []interface{}{
+			s.Type, target, s.Executions, s.RowsAffected,
+			float64(s.Duration.Microseconds()) / 1000, s.Statement,
+		}
+	}
+	result.ResultSets = append(result.ResultSets, rs)
+	if result.Type == protocol.ResultOK {
+		result.Type = protocol.ResultRows
 	}
 	return result
 }
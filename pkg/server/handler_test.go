@@ -0,0 +1,455 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/firewall"
+	"github.com/ha1tch/aul/pkg/hooks"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/sqlcmd"
+	"github.com/ha1tch/aul/pkg/storage"
+)
+
+// scriptedConn replays requests, calling read before each one, and
+// records the results sent.
+type scriptedConn struct {
+	requests []protocol.Request
+	read     func(i int)
+	results  []protocol.Result
+}
+
+func (c *scriptedConn) ReadRequest() (protocol.Request, error) {
+	i := len(c.results)
+	if i >= len(c.requests) {
+		return protocol.Request{}, io.EOF
+	}
+	if c.read != nil {
+		c.read(i)
+	}
+	return c.requests[i], nil
+}
+
+func (c *scriptedConn) SendResult(result protocol.Result) error {
+	c.results = append(c.results, result)
+	return nil
+}
+
+func (c *scriptedConn) Close() error                  { return nil }
+func (c *scriptedConn) RemoteAddr() net.Addr          { return &net.TCPAddr{} }
+func (c *scriptedConn) SetDeadline(t time.Time) error { return nil }
+func (c *scriptedConn) Properties() map[string]string { return nil }
+
+func TestConnectionHandler_Panic(t *testing.T) {
+	logger := log.New(log.Config{Output: io.Discard})
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	rt.SetStorage(runtime.NewMemoryStorage())
+
+	ping := protocol.Request{Type: protocol.RequestPing}
+	conn := &scriptedConn{
+		requests: []protocol.Request{ping, ping, ping},
+		read: func(i int) {
+			if i == 1 {
+				panic("malformed packet")
+			}
+		},
+	}
+	NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false).Serve(context.Background())
+
+	// The panicking request is answered with a severity 20 error and the
+	// session ends without reading the third
+	if len(conn.results) != 2 || conn.results[0].Type != protocol.ResultOK {
+		t.Fatalf("results = %+v, want the first ping and an error", conn.results)
+	}
+	result := conn.results[1]
+	sqlErr := aulerrors.FindSQLError(result.Error)
+	if result.Type != protocol.ResultError || sqlErr == nil ||
+		sqlErr.Fields[aulerrors.FieldSQLSeverity] != uint8(runtime.PanicSeverity) || result.RequestID == "" {
+		t.Errorf("result = %+v, want a severity 20 error with a request ID", result)
+	}
+	if n := rt.Stats().Panics; n != 1 {
+		t.Errorf("panics = %d, want 1", n)
+	}
+}
+
+func TestConnectionHandler_SQLCmd(t *testing.T) {
+	logger := log.New(log.Config{Output: io.Discard})
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	rt.SetStorage(runtime.NewMemoryStorage())
+
+	query := func(sql string) protocol.Request {
+		return protocol.Request{Type: protocol.RequestQuery, SQL: sql}
+	}
+	conn := &scriptedConn{requests: []protocol.Request{
+		query(":setvar Table orders\nSELECT 1 AS n\nGO 2\nSELECT '$(Table)' AS t"),
+		query("SELECT '$(Table)' AS t\nGO\nRAISERROR('boom', 16, 1)\nGO\nSELECT 2 AS x"),
+		query(":on error exit\nRAISERROR('boom', 16, 1)\nGO\nSELECT 3 AS x"),
+		query("SELECT $(Undefined)"),
+	}}
+	h := NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false)
+	h.sqlcmd = sqlcmd.New(sqlcmd.Options{DisableIncludes: true})
+	h.Serve(context.Background())
+
+	if len(conn.results) != 4 {
+		t.Fatalf("got %d results, want 4", len(conn.results))
+	}
+	first := conn.results[0]
+	if first.Type != protocol.ResultRows || len(first.ResultSets) != 3 ||
+		fmt.Sprint(first.ResultSets[2].Rows[0][0]) != "orders" {
+		t.Errorf("script result = %+v, want the results of three batches", first)
+	}
+
+	// Variables last for the session; an ignored failure becomes a message
+	second := conn.results[1]
+	if second.Type != protocol.ResultRows || len(second.ResultSets) != 2 ||
+		second.ResultSets[0].Rows[0][0] != "orders" || len(second.Warnings) != 1 {
+		t.Errorf("script result = %+v, want two result sets and a warning", second)
+	}
+
+	for i, result := range conn.results[2:] {
+		if result.Type != protocol.ResultError {
+			t.Errorf("request %d: result = %+v, want an error", i+3, result)
+		}
+	}
+}
+
+func TestConnectionHandler_ReadOnly(t *testing.T) {
+	logger := log.New(log.Config{Output: io.Discard})
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	rt.SetStorage(runtime.NewMemoryStorage())
+
+	query := func(sql string) protocol.Request {
+		return protocol.Request{Type: protocol.RequestQuery, SQL: sql}
+	}
+	conn := &scriptedConn{requests: []protocol.Request{
+		query("DECLARE @n INT = 1\nPRINT @n"),
+		query("UPDATE orders SET qty = 0"),
+		query("CREATE TABLE notes (id INT)"),
+	}}
+	h := NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false)
+	h.readOnly = true
+	h.Serve(context.Background())
+
+	if len(conn.results) != 3 || conn.results[0].Type == protocol.ResultError {
+		t.Fatalf("results = %+v", conn.results)
+	}
+	for i, result := range conn.results[1:] {
+		if result.Type != protocol.ResultError || !strings.Contains(result.Message, "because the database is read-only") {
+			t.Errorf("request %d: result = %+v, want a read-only error", i+2, result)
+		}
+	}
+}
+
+func TestConnectionHandler_Firewall(t *testing.T) {
+	var audit strings.Builder
+	logger := log.New(log.Config{
+		DefaultLevel:   log.LevelError,
+		CategoryLevels: map[log.Category]log.Level{log.CategoryAudit: log.LevelInfo},
+		Output:         &audit,
+		Format:         log.FormatJSON,
+	})
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	rt.SetStorage(runtime.NewMemoryStorage())
+	fw, err := firewall.New(firewall.Config{Rules: []firewall.Rule{
+		{Name: "analysts-read", Action: firewall.ActionDeny, Listeners: []string{"postgres"},
+			Statements: []string{firewall.StatementDML}, Message: "read only"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(sql string) protocol.Request {
+		return protocol.Request{Type: protocol.RequestQuery, SQL: sql}
+	}
+	conn := &scriptedConn{requests: []protocol.Request{
+		query("SELECT 1 AS n"),
+		query("UPDATE orders SET note = 'secret' WHERE id = 7"),
+	}}
+	h := NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false)
+	h.listener = "postgres"
+	h.firewall = fw
+	h.Serve(context.Background())
+
+	if len(conn.results) != 2 || conn.results[0].Type == protocol.ResultError {
+		t.Fatalf("results = %+v", conn.results)
+	}
+	denied := conn.results[1]
+	if denied.Type != protocol.ResultError || !aulerrors.IsCode(denied.Error, aulerrors.ErrCodeExecDenied) {
+		t.Errorf("result = %+v, want a firewall error", denied)
+	}
+
+	// The audit log has the rule and the fingerprint, not the literals
+	logged := audit.String()
+	if !strings.Contains(logged, "request denied by firewall") || !strings.Contains(logged, "analysts-read") ||
+		!strings.Contains(logged, firewall.Fingerprint("UPDATE orders SET note = 'x' WHERE id = 1")) ||
+		strings.Contains(logged, "secret") {
+		t.Errorf("audit log %s", logged)
+	}
+}
+
+func TestConnectionHandler_ParameterizedOnly(t *testing.T) {
+	logger := log.New(log.Config{Output: io.Discard})
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	rt.SetStorage(runtime.NewMemoryStorage())
+
+	query := func(sql string) protocol.Request {
+		return protocol.Request{Type: protocol.RequestQuery, SQL: sql}
+	}
+	bound := query("SELECT @name AS name, 'literal' AS kind")
+	bound.Parameters = map[string]interface{}{"@name": "Ada"}
+	bound.Options.Parameterized = true
+	conn := &scriptedConn{requests: []protocol.Request{
+		query("SELECT 1 AS n -- 'commented out'"),
+		query("SELECT *\nFROM t WHERE name = N'x'' OR 1=1 --'"),
+		bound,
+	}}
+	h := NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false)
+	h.parameterizedOnly = true
+	h.Serve(context.Background())
+
+	if len(conn.results) != 3 {
+		t.Fatalf("results = %+v", conn.results)
+	}
+	if conn.results[0].Type == protocol.ResultError {
+		t.Errorf("literal-free SQL failed: %v", conn.results[0].Error)
+	}
+	rejected := conn.results[1]
+	sqlErr := aulerrors.FindSQLError(rejected.Error)
+	if sqlErr == nil || sqlErr.Fields[aulerrors.FieldSQLErrorNumber] != int32(ParameterizedErrorNumber) ||
+		!strings.Contains(rejected.Message, "line 2, column 21") {
+		t.Errorf("result = %+v, want error %d at line 2", rejected, ParameterizedErrorNumber)
+	}
+	if conn.results[2].Type == protocol.ResultError {
+		t.Errorf("parameterized SQL failed: %v", conn.results[2].Error)
+	}
+}
+
+func TestConnectionHandler_Summary(t *testing.T) {
+	logger := log.New(log.Config{Output: io.Discard})
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	rt.SetStorage(runtime.NewMemoryStorage())
+
+	query := func(sql string) protocol.Request {
+		return protocol.Request{Type: protocol.RequestQuery, SQL: sql}
+	}
+	conn := &scriptedConn{requests: []protocol.Request{
+		query("SELECT 1 AS n"),
+		query("DECLARE @n INT = 1"),
+	}}
+	h := NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false)
+	h.summary = summaryResultSet
+	h.Serve(context.Background())
+
+	if len(conn.results) != 2 {
+		t.Fatalf("results = %+v", conn.results)
+	}
+	first := conn.results[0]
+	if len(first.ResultSets) != 2 || len(first.Statements) != 1 {
+		t.Fatalf("result = %+v, want the query's and the summary's result sets", first)
+	}
+	summary := first.ResultSets[1]
+	if summary.Columns[0].Name != "statement_type" || len(summary.Rows) != 1 ||
+		summary.Rows[0][0] != "SELECT" || summary.Rows[0][1] != nil || summary.Rows[0][3] != int64(1) {
+		t.Errorf("summary = %+v", summary)
+	}
+	// A batch with no statements summarised still has the result set
+	second := conn.results[1]
+	if second.Type != protocol.ResultRows || len(second.ResultSets) != 1 || len(second.ResultSets[0].Rows) != 0 {
+		t.Errorf("result = %+v, want an empty summary", second)
+	}
+
+	// Asked for by one request, the summary is metadata only
+	summarized := query("SELECT 1 AS n")
+	summarized.Options.Summary = true
+	conn = &scriptedConn{requests: []protocol.Request{summarized, query("SELECT 1 AS n")}}
+	NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false).Serve(context.Background())
+	if len(conn.results) != 2 || len(conn.results[0].ResultSets) != 1 || len(conn.results[0].Statements) != 1 {
+		t.Fatalf("results = %+v", conn.results)
+	}
+	if conn.results[1].Statements != nil {
+		t.Errorf("result = %+v, want no summary", conn.results[1])
+	}
+}
+
+// cancellingConn is a scriptedConn whose client cancels each request it
+// is watched for after a moment.
+type cancellingConn struct {
+	scriptedConn
+	watched int
+}
+
+func (c *cancellingConn) WatchCancel(cancel func()) (stop func()) {
+	c.watched++
+	timer := time.AfterFunc(50*time.Millisecond, cancel)
+	return func() { timer.Stop() }
+}
+
+func TestConnectionHandler_Cancel(t *testing.T) {
+	logger := log.New(log.Config{Output: io.Discard})
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	backend, err := storage.NewSQLiteStorage(storage.DefaultSQLiteConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	rt.SetStorage(backend)
+
+	// Ten billion rows, which only an interrupted backend returns from
+	// in time
+	slow := protocol.Request{Type: protocol.RequestQuery,
+		SQL: "WITH d(n) AS (SELECT 0 UNION ALL SELECT n + 1 FROM d WHERE n < 99999) SELECT COUNT(*) FROM d a CROSS JOIN d b"}
+	timed := slow
+	timed.Options.Timeout = 20 * time.Millisecond
+	conn := &cancellingConn{scriptedConn: scriptedConn{requests: []protocol.Request{
+		slow,
+		{Type: protocol.RequestCancel}, // The attention, read once the request is done
+		timed,
+		{Type: protocol.RequestQuery, SQL: "SELECT 1 AS n"},
+	}}}
+	start := time.Now()
+	NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false).Serve(context.Background())
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("took %v", elapsed)
+	}
+
+	if len(conn.results) != 4 || conn.watched != 3 {
+		t.Fatalf("results = %+v, %d watched", conn.results, conn.watched)
+	}
+	if err := conn.results[0].Error; !aulerrors.IsCode(err, aulerrors.ErrCodeExecCancelled) {
+		t.Errorf("cancelled request: got %v", err)
+	}
+	if conn.results[1].Type != protocol.ResultCancel {
+		t.Errorf("attention: got %+v", conn.results[1])
+	}
+	if err := conn.results[2].Error; !aulerrors.IsCode(err, aulerrors.ErrCodeExecTimeout) || !strings.Contains(err.Error(), "after 20ms") {
+		t.Errorf("timed out request: got %v", err)
+	}
+	if conn.results[3].Type == protocol.ResultError {
+		t.Errorf("next request: got %v", conn.results[3].Error)
+	}
+}
+
+// testHook rewrites, masks and records statements, and refuses the
+// logins and statements it is told to.
+type testHook struct {
+	refuseUser string
+	outcomes   []string
+}
+
+func (th *testHook) OnLogin(ctx context.Context, login *hooks.Login) error {
+	if login.User == th.refuseUser {
+		return fmt.Errorf("user %s is suspended", login.User)
+	}
+	return nil
+}
+
+func (th *testHook) BeforeStatement(ctx context.Context, stmt *hooks.Statement) error {
+	if strings.Contains(stmt.SQL, "DROP") {
+		return fmt.Errorf("no DROP")
+	}
+	stmt.SQL = strings.ReplaceAll(stmt.SQL, "@@app", "'"+stmt.Login.App+"'")
+	return nil
+}
+
+func (th *testHook) OnResultSet(ctx context.Context, stmt *hooks.Statement, rs *protocol.ResultSet) error {
+	for _, row := range rs.Rows {
+		for i, col := range rs.Columns {
+			if strings.EqualFold(col.Name, "email") {
+				row[i] = "***"
+			}
+		}
+	}
+	return nil
+}
+
+func (th *testHook) AfterStatement(ctx context.Context, stmt *hooks.Statement, outcome *hooks.Outcome) error {
+	th.outcomes = append(th.outcomes, fmt.Sprintf("%s: %v", stmt.SQL, outcome.Err == nil))
+	return nil
+}
+
+func TestConnectionHandler_Hooks(t *testing.T) {
+	logger := log.New(log.Config{Output: io.Discard})
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	rt.SetStorage(runtime.NewMemoryStorage())
+
+	th := &testHook{refuseUser: "mallory"}
+	hooks.Register("server-test", func(options map[string]string) (interface{}, error) { return th, nil })
+	hooks.Register("server-test-failing", func(options map[string]string) (interface{}, error) {
+		return failingHook{}, nil
+	})
+	chain, err := hooks.New(hooks.Config{Hooks: []hooks.HookConfig{
+		{Name: "flaky", Type: "server-test-failing", Failure: hooks.FailureContinue},
+		{Name: "policy", Type: "server-test"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func(sql string) protocol.Request {
+		return protocol.Request{Type: protocol.RequestQuery, SQL: sql}
+	}
+
+	conn := &scriptedConn{requests: []protocol.Request{
+		query("SELECT @@app AS app, 'ada@example.com' AS Email"),
+		query("DROP TABLE orders"),
+	}}
+	h := NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false)
+	h.app = "reports"
+	h.hooks = chain
+	h.Serve(context.Background())
+
+	if len(conn.results) != 2 || conn.results[0].Type == protocol.ResultError {
+		t.Fatalf("results = %+v", conn.results)
+	}
+	if row := conn.results[0].ResultSets[0].Rows[0]; row[0] != "reports" || row[1] != "***" {
+		t.Errorf("row = %v, want the app name and a masked email", row)
+	}
+	if denied := conn.results[1]; denied.Type != protocol.ResultError || !strings.Contains(denied.Message, "rejected by hook policy: no DROP") {
+		t.Errorf("result = %+v, want the hook's refusal", denied)
+	}
+	if want := []string{"SELECT 'reports' AS app, 'ada@example.com' AS Email: true"}; fmt.Sprint(th.outcomes) != fmt.Sprint(want) {
+		t.Errorf("outcomes = %v, want %v", th.outcomes, want)
+	}
+
+	// A refused session gets the refusal for its first request, and is
+	// closed
+	conn = &scriptedConn{requests: []protocol.Request{query("SELECT 1"), query("SELECT 2")}}
+	h = NewConnectionHandler(conn, rt, procedure.NewRegistry(), logger, false)
+	h.user = "mallory"
+	h.hooks = chain
+	h.Serve(context.Background())
+	if len(conn.results) != 1 || !strings.Contains(conn.results[0].Message, "user mallory is suspended") {
+		t.Errorf("results = %+v, want one refusal", conn.results)
+	}
+}
+
+// failingHook fails at every point it runs.
+type failingHook struct{}
+
+func (failingHook) BeforeStatement(ctx context.Context, stmt *hooks.Statement) error {
+	return fmt.Errorf("unavailable")
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/hooks"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+// loginHooks runs the login hooks of a session that has just connected,
+// returning the error they refuse it with, or nil. A refused session is
+// sent the error in answer to its first request and closed.
+func (h *ConnectionHandler) loginHooks(ctx context.Context) error {
+	h.hookLogin = &hooks.Login{
+		SessionID: h.sessionID,
+		Listener:  h.listener,
+		Protocol:  h.protocol.String(),
+		User:      h.user,
+		App:       h.app,
+		Host:      h.host,
+		Address:   h.address,
+		Tenant:    h.tenant,
+		Database:  h.currentDB,
+	}
+	err := h.hooks.Login(ctx, h.hookLogin)
+	if err != nil {
+		h.logger.Audit().WithContext(ctx).Warn("session refused by hook",
+			"session_id", h.sessionID,
+			"listener", h.listener,
+			"user", h.user,
+			"app", h.app,
+			"address", h.address,
+			"tenant", h.tenant,
+			"error", err.Error(),
+		)
+	}
+	return err
+}
+
+// processHooked handles a request that runs SQL or calls a procedure
+// between the statement hooks. The before hooks run first, so that the
+// firewall and the other checks see the SQL they leave; the result set
+// hooks see each result set of a request that succeeded; the after hooks
+// see every outcome, and fail a request that succeeded when they fail.
+func (h *ConnectionHandler) processHooked(ctx context.Context, req protocol.Request) protocol.Result {
+	stmt := &hooks.Statement{
+		Login:      h.hookLogin,
+		RequestID:  req.ID,
+		Procedure:  req.ProcedureName,
+		SQL:        req.SQL,
+		Parameters: req.Parameters,
+	}
+	if err := h.hooks.BeforeStatement(ctx, stmt); err != nil {
+		return protocol.Result{
+			Type:    protocol.ResultError,
+			Error:   err,
+			Message: err.Error(),
+		}
+	}
+	req.SQL = stmt.SQL
+	req.Parameters = stmt.Parameters
+
+	start := time.Now()
+	result := h.process(ctx, req)
+	if result.Type != protocol.ResultError {
+		for i := range result.ResultSets {
+			if err := h.hooks.ResultSet(ctx, stmt, &result.ResultSets[i]); err != nil {
+				result = protocol.Result{
+					Type:    protocol.ResultError,
+					Error:   err,
+					Message: err.Error(),
+				}
+				break
+			}
+		}
+	}
+	outcome := &hooks.Outcome{
+		RowsAffected: result.RowsAffected,
+		Err:          result.Error,
+		Duration:     time.Since(start),
+	}
+	if err := h.hooks.AfterStatement(ctx, stmt, outcome); err != nil && result.Type != protocol.ResultError {
+		result = protocol.Result{
+			Type:    protocol.ResultError,
+			Error:   err,
+			Message: err.Error(),
+		}
+	}
+	return result
+}
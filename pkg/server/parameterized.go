@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/token"
+)
+
+// ParameterizedErrorNumber is the SQL error number reported when a
+// listener that takes only parameterized SQL is sent a string literal.
+const ParameterizedErrorNumber = 50413
+
+// ParameterizedSQLState is the PostgreSQL SQLSTATE for such a request
+// (insufficient_privilege).
+const ParameterizedSQLState = "42501"
+
+// checkParameterized returns the error an ad-hoc request fails with on a
+// listener that takes only parameterized SQL, when its SQL holds a string
+// literal and it was not sent through a parameterized API: values spliced
+// into SQL as strings are where injection comes from. Numbers, and
+// procedure calls, pass.
+func (h *ConnectionHandler) checkParameterized(ctx context.Context, req protocol.Request) error {
+	if !h.parameterizedOnly || req.Type != protocol.RequestQuery || req.Options.Parameterized {
+		return nil
+	}
+	tok, ok := stringLiteral(req.SQL)
+	if !ok {
+		return nil
+	}
+	h.logger.Audit().WithContext(ctx).Warn("ad-hoc SQL with a string literal rejected",
+		"session_id", h.sessionID,
+		"listener", h.listener,
+		"user", h.user,
+		"app", h.app,
+		"address", h.address,
+		"line", tok.Line,
+		"column", tok.Column,
+	)
+	return aulerrors.Newf(aulerrors.ErrCodeExecDenied,
+		"String literal at line %d, column %d: this listener takes values only as parameters; "+
+			"send them with sp_executesql, an RPC or bound parameters", tok.Line, tok.Column).
+		WithOp("ConnectionHandler.checkParameterized").
+		WithField("listener", h.listener).
+		WithField(aulerrors.FieldSQLErrorNumber, int32(ParameterizedErrorNumber)).
+		WithField(aulerrors.FieldSQLSeverity, uint8(16)).
+		WithField(aulerrors.FieldSQLState, ParameterizedSQLState).
+		Err()
+}
+
+// stringLiteral returns the first string literal in sql, outside comments.
+func stringLiteral(sql string) (token.Token, bool) {
+	l := lexer.New(sql)
+	for {
+		tok := l.NextToken()
+		switch tok.Type {
+		case token.EOF:
+			return tok, false
+		case token.STRING, token.NSTRING:
+			return tok, true
+		}
+	}
+}
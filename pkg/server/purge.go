@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// purgeSessionID is the session purges run in.
+const purgeSessionID = "purge"
+
+// defaultPurgeBatch is the number of rows a purge deletes per batch when
+// the request gives none.
+const defaultPurgeBatch = 1000
+
+// PurgeTable implements protocol.Admin. Each batch is a DELETE TOP (n),
+// committed on its own, in the batch admission lane; the purge ends with
+// the first batch that deletes fewer than n rows. A batch that fails
+// stops it; the batches before it stay committed.
+func (s *Server) PurgeTable(ctx context.Context, req protocol.PurgeRequest) (*protocol.PurgeResult, error) {
+	size := req.BatchSize
+	if size == 0 {
+		size = defaultPurgeBatch
+	}
+	if size < 0 || req.MaxBatches < 0 {
+		return nil, aulerrors.InvalidInput("purge", "batch_size and max_batches cannot be negative").Err()
+	}
+	var delay time.Duration
+	if req.Delay != "" {
+		d, err := time.ParseDuration(req.Delay)
+		if err != nil || d < 0 {
+			return nil, aulerrors.InvalidInput("delay", fmt.Sprintf("%q is not a duration", req.Delay)).Err()
+		}
+		delay = d
+	}
+	stmt, err := purgeStatement(req.Table, req.Where, size)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	result := &protocol.PurgeResult{Statement: stmt}
+	for req.MaxBatches == 0 || result.Batches < req.MaxBatches {
+		if result.Batches > 0 && delay > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		execResult, err := s.runtime.ExecuteSQL(ctx, stmt, &runtime.ExecContext{
+			SessionID: purgeSessionID,
+			Database:  req.Database,
+			Tenant:    req.Tenant,
+			Priority:  runtime.PriorityBatch,
+		})
+		if err != nil {
+			s.logger.Audit().Error("table purge failed", err,
+				"table", req.Table,
+				"batches", result.Batches,
+				"rows", result.Rows,
+			)
+			return nil, aulerrors.Wrap(err, aulerrors.ErrCodeExecFailed, fmt.Sprintf(
+				"purge failed after %d batches deleted %d rows", result.Batches, result.Rows)).
+				WithOp("Server.PurgeTable").
+				Err()
+		}
+		result.Batches++
+		result.Rows += execResult.RowsAffected
+		if execResult.RowsAffected < int64(size) {
+			result.Complete = true
+			break
+		}
+	}
+	result.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+
+	s.logger.Audit().Info("table purged",
+		"table", req.Table,
+		"where", req.Where,
+		"database", req.Database,
+		"tenant", req.Tenant,
+		"batches", result.Batches,
+		"rows", result.Rows,
+		"complete", result.Complete,
+	)
+	return result, nil
+}
+
+// purgeStatement returns the DELETE TOP (size) a purge of table where
+// runs, checking that it is that one statement and nothing more.
+func purgeStatement(table, where string, size int) (string, error) {
+	if table == "" {
+		return "", aulerrors.InvalidInput("table", "a table is required").Err()
+	}
+	if where == "" {
+		return "", aulerrors.InvalidInput("where", `a condition is required; give "1 = 1" to purge every row`).Err()
+	}
+	stmt := fmt.Sprintf("DELETE TOP (%d) FROM %s WHERE %s", size, table, where)
+	p := parser.New(lexer.New(stmt))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return "", aulerrors.InvalidInput("purge", errs[0]).Err()
+	}
+	if len(program.Statements) != 1 {
+		return "", aulerrors.InvalidInput("where", "the condition must not end the statement").Err()
+	}
+	del, ok := program.Statements[0].(*ast.DeleteStatement)
+	if !ok || del.Table == nil || del.From != nil {
+		return "", aulerrors.InvalidInput("table", fmt.Sprintf("%q is not a table", table)).Err()
+	}
+	return stmt, nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+)
+
+func TestServer_PurgeTable(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ProcedureDir = ""
+	cfg.JITEnabled = false
+	cfg.StorageConfig.Type = "sqlite"
+	cfg.StorageConfig.Options = map[string]string{"path": filepath.Join(t.TempDir(), "aul.db")}
+	cfg.Listeners = nil
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if _, err := s.execInit("CREATE TABLE AuditLog (ID INT, LoggedAt DATE); " +
+		"DECLARE @n INT = 0; WHILE @n < 25 BEGIN SET @n = @n + 1; " +
+		"INSERT INTO AuditLog VALUES (@n, CASE WHEN @n <= 22 THEN '2023-06-01' ELSE '2024-06-01' END) END"); err != nil {
+		t.Fatal(err)
+	}
+	count := func() interface{} {
+		t.Helper()
+		result, err := s.execInit("SELECT COUNT(*) FROM AuditLog")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.ResultSets[0].Rows[0][0]
+	}
+	ctx := context.Background()
+	req := protocol.PurgeRequest{Table: "dbo.AuditLog", Where: "LoggedAt < '2024-01-01'", BatchSize: 5, Delay: "1ms"}
+
+	// Stopped after two batches, with rows left to purge
+	req.MaxBatches = 2
+	result, err := s.PurgeTable(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Batches != 2 || result.Rows != 10 || result.Complete {
+		t.Errorf("limited purge: %+v", result)
+	}
+
+	// The rest, in batches until one finds fewer than five rows
+	req.MaxBatches = 0
+	result, err = s.PurgeTable(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Batches != 3 || result.Rows != 12 || !result.Complete || result.Statement != "DELETE TOP (5) FROM dbo.AuditLog WHERE LoggedAt < '2024-01-01'" {
+		t.Errorf("purge: %+v", result)
+	}
+	if n := count(); n != int64(3) {
+		t.Errorf("%v rows left, want 3", n)
+	}
+
+	for _, bad := range []protocol.PurgeRequest{
+		{Table: "AuditLog"},
+		{Where: "1 = 1"},
+		{Table: "AuditLog", Where: "1 = 1; DROP TABLE AuditLog"},
+		{Table: "AuditLog", Where: "1 = 1", Delay: "soon"},
+		{Table: "AuditLog", Where: "1 = 1", BatchSize: -1},
+	} {
+		if _, err := s.PurgeTable(ctx, bad); aulerrors.GetCode(err) != aulerrors.ErrCodeProcInvalidParam {
+			t.Errorf("%+v: got %v", bad, err)
+		}
+	}
+	if _, err := s.PurgeTable(ctx, protocol.PurgeRequest{Table: "Missing", Where: "1 = 1"}); aulerrors.GetCode(err) != aulerrors.ErrCodeExecFailed {
+		t.Errorf("missing table: got %v", err)
+	}
+	if n := count(); n != int64(3) {
+		t.Errorf("%v rows left after the rejected purges, want 3", n)
+	}
+}
@@ -6,17 +6,37 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"io"
+	"net"
+	"path"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ha1tch/aul/pkg/archive"
+	"github.com/ha1tch/aul/pkg/auth"
+	"github.com/ha1tch/aul/pkg/cmdshell"
+	"github.com/ha1tch/aul/pkg/erasure"
 	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/extensions"
+	"github.com/ha1tch/aul/pkg/features"
+	"github.com/ha1tch/aul/pkg/firewall"
+	"github.com/ha1tch/aul/pkg/hooks"
 	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/mail"
+	"github.com/ha1tch/aul/pkg/notify"
 	"github.com/ha1tch/aul/pkg/procedure"
 	"github.com/ha1tch/aul/pkg/protocol"
 	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/sandbox"
+	"github.com/ha1tch/aul/pkg/sqlcmd"
 	"github.com/ha1tch/aul/pkg/storage"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
 )
 
 // Server is the main aul database server.
@@ -34,6 +54,21 @@ type Server struct {
 	runtime          *runtime.Runtime
 	storage          runtime.StorageBackend
 	tenantIdentifier *TenantIdentifier
+	watcher          *procedure.Watcher // Hot reload (nil unless WatchChanges)
+	notifier         *notify.Notifier   // Webhooks (nil when none are configured)
+	mailer           *mail.Mailer       // sp_send_dbmail (nil when no profiles are configured)
+	firewall         *firewall.Firewall // Rules requests must pass (nil when none are configured)
+	hooks            *hooks.Chain       // Hooks sessions run (nil when none are configured)
+	cluster          *runtime.Cluster   // Servers sharing the storage backend (nil when not clustered)
+	logins           *auth.Store        // SQL logins (nil unless a listener requires them or some are provisioned)
+	permissions      *auth.Permissions  // What SQL logins may do (nil when logins are)
+	deploy           deployment         // Blue/green procedure sets
+	traces           *traceStore        // Recent traces and the procedures traced
+	sessions         atomic.Int32       // Connections accepted, numbering their sessions for @@SPID
+
+	// WebAssembly functions, registered until Stop (nil when no extensions
+	// directory is configured)
+	extensions *extensions.Extensions
 
 	// Protocol listeners
 	listeners map[string]protocol.Listener
@@ -84,14 +119,88 @@ type Config struct {
 
 	// Procedure storage
 	ProcedureDir string // Directory containing .sql files
+	InitDir      string // Scripts run once each against the storage backend
 	WatchChanges bool   // Hot-reload procedures on file changes
 
+	ProcLoadWorkers int // Procedure files loaded at once (0 = GOMAXPROCS)
+
+	// Refuse procedures using T-SQL the interpreter cannot run as they
+	// load, rather than failing when execution reaches the statement
+	StrictCompatibility bool
+
 	// Runtime configuration
-	DefaultDialect string        // Default SQL dialect (tsql, postgres, mysql)
-	JITThreshold   int           // Execution count before JIT compilation
-	JITEnabled     bool          // Enable JIT compilation
-	MaxConcurrency int           // Maximum concurrent executions
-	ExecTimeout    time.Duration // Default execution timeout
+	DefaultDialect  string        // Default SQL dialect (tsql, postgres, mysql)
+	JITThreshold    int           // Execution count before JIT compilation
+	JITEnabled      bool          // Enable JIT compilation
+	MaxConcurrency  int           // Maximum concurrent executions
+	ExecTimeout     time.Duration // Default execution timeout
+	MaxNestingLevel int           // Procedure call depth (0 = SQL Server's 32)
+	NumbersSize     int           // Rows of the built-in dbo.Numbers and dbo.Tally (0 = 100000)
+
+	// Also run the deprecated regex SQL normaliser after the rewriters
+	LegacySQLNormalizer bool
+
+	// Send the literals queries compare with to the backend as parameters
+	AutoParameterize bool
+
+	// Admission queue beyond MaxConcurrency
+	Admission runtime.AdmissionConfig
+	BatchApps []string // Application name patterns admitted in the batch lane
+
+	// Per-procedure circuit breakers
+	Breaker runtime.BreakerConfig
+
+	// Memory budgets for result sets and temp objects
+	Memory runtime.MemoryConfig
+
+	// What to do when results break a procedure's result contract
+	Contracts runtime.ContractMode
+
+	// Warn callers of deprecated procedures, besides logging the calls
+	DeprecationWarnings bool
+
+	// Shadow execution of procedures changed by hot reload
+	Shadow runtime.ShadowConfig
+
+	// Statement journal for crash recovery
+	Journal runtime.JournalConfig
+
+	// Webhooks told of server events
+	Notify notify.Config
+
+	// Feature flags read by FEATURE()
+	Features features.Config
+
+	// Where data subjects' personal data is, for the admin API to erase
+	Erasure erasure.Config
+
+	// Rules allowing or denying requests before they run
+	Firewall firewall.Config
+
+	// Plugins and hooks run at login and around statements
+	Hooks hooks.Config
+
+	// Policies denying procedures capabilities such as dynamic SQL and DDL
+	Sandbox sandbox.Config
+
+	// WebAssembly modules whose functions T-SQL may call
+	Extensions extensions.Config
+
+	// Mail profiles sp_send_dbmail sends through
+	Mail mail.Config
+
+	// Endpoints sp_invoke_external_rest_endpoint may call
+	REST tsqlruntime.RESTPolicy
+
+	// Whether sp_execute_script may run scripts, and for how long
+	Scripts tsqlruntime.ScriptPolicy
+
+	// Command templates xp_cmdshell may run (none = turned off)
+	CmdShell cmdshell.Config
+
+	// sqlcmd scripts (:setvar, :r, $(var), GO) sent as TDS batches
+	SQLCmdMode       bool   // Process sqlcmd commands and variables in TDS batches
+	SQLCmdIncludeDir string // Directory :r reads from ("" = :r disabled)
 
 	// Multi-tenancy
 	TenantConfig TenantConfig
@@ -99,14 +208,52 @@ type Config struct {
 	// Protocol listeners to enable
 	Listeners []protocol.ListenerConfig
 
+	// SQL logins created or updated at startup, for the listeners set
+	// RequireLogin
+	Logins []auth.Login
+
+	// Permissions GRANT, DENY and REVOKE give SQL logins, and whether
+	// listeners that require logins check them
+	Permissions auth.PermissionConfig
+
+	// Reject statements that change the database on every listener, not
+	// only those set ReadOnly
+	ReadOnly bool
+
+	// Report what each statement did with every execution: as metadata
+	// over HTTP, as a last result set over TDS and PostgreSQL
+	StatementSummary bool
+
+	// Send the rows of a single SELECT as they are read, rather than
+	// reading them all first, over TDS, PostgreSQL and streamed HTTP
+	StreamResults bool
+
 	// Storage backend configuration
 	StorageConfig runtime.StorageConfig
 
+	// Scheduled refresh of the backend's planner statistics
+	Statistics runtime.StatisticsConfig
+
+	// Missing index recommendations from the workload
+	IndexAdvisor runtime.IndexAdvisorConfig
+
+	// Small reference tables served from memory
+	TableCache tsqlruntime.TableCacheConfig
+
+	// Old partitions of archival tables moved to object storage
+	Archive archive.Config
+
+	// Servers sharing the storage backend, which share application locks
+	// and elect one of them to run scheduled jobs
+	Cluster runtime.ClusterConfig
+
 	// Logging
 	LogLevel            string
 	LogFormat           string      // "text" or "json"
 	LogQueries          bool        // Log all SQL queries
 	LogQueriesRewritten bool        // Log queries after rewriting
+	LogLevels           map[string]string // Per-category levels overriding LogLevel
+	LogSinks            log.SinkConfig    // File, syslog and OTLP sinks
 	Logger              *log.Logger // Optional pre-configured logger
 }
 
@@ -122,6 +269,11 @@ func DefaultConfig() Config {
 		JITEnabled:     true,
 		MaxConcurrency: 100,
 		ExecTimeout:    30 * time.Second,
+		Admission:      runtime.DefaultAdmissionConfig(),
+		Breaker:        runtime.DefaultBreakerConfig(),
+		Contracts:      runtime.ContractsWarn,
+		Shadow:         runtime.DefaultShadowConfig(),
+		IndexAdvisor:   runtime.DefaultIndexAdvisorConfig(),
 		LogLevel:       "info",
 		LogFormat:      "text",
 	}
@@ -141,10 +293,29 @@ func New(cfg Config) (*Server, error) {
 		if cfg.LogFormat == "json" {
 			format = log.FormatJSON
 		}
+		categoryLevels := make(map[log.Category]log.Level)
+		for name, value := range cfg.LogLevels {
+			cat, err := log.ParseCategory(name)
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			if categoryLevels[cat], err = log.ParseLevel(value); err != nil {
+				cancel()
+				return nil, err
+			}
+		}
+		sinks, err := log.OpenSinks(cfg.LogSinks, format)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
 		logger = log.New(log.Config{
-			DefaultLevel:  level,
-			Format:        format,
-			IncludeCaller: level == log.LevelDebug,
+			DefaultLevel:   level,
+			CategoryLevels: categoryLevels,
+			Format:         format,
+			IncludeCaller:  level == log.LevelDebug,
+			Sinks:          sinks,
 		})
 	}
 
@@ -153,6 +324,7 @@ func New(cfg Config) (*Server, error) {
 		logger:           logger,
 		listeners:        make(map[string]protocol.Listener),
 		tenantIdentifier: NewTenantIdentifier(cfg.TenantConfig),
+		traces:           newTraceStore(),
 		ctx:              ctx,
 		cancel:           cancel,
 		state:            StateNew,
@@ -164,14 +336,166 @@ func New(cfg Config) (*Server, error) {
 	// Initialise runtime with logger
 	rtCfg := runtime.Config{
 		DefaultDialect:      cfg.DefaultDialect,
+		LegacySQLNormalizer: cfg.LegacySQLNormalizer,
+		AutoParameterize:    cfg.AutoParameterize,
 		JITEnabled:          cfg.JITEnabled,
 		JITThreshold:        cfg.JITThreshold,
 		MaxConcurrency:      cfg.MaxConcurrency,
 		ExecTimeout:         cfg.ExecTimeout,
+		MaxNestingLevel:     cfg.MaxNestingLevel,
+		NumbersSize:         cfg.NumbersSize,
+		Admission:           cfg.Admission,
+		Breaker:             cfg.Breaker,
+		Memory:              cfg.Memory,
+		Contracts:           cfg.Contracts,
+		DeprecationWarnings: cfg.DeprecationWarnings,
+		Shadow:              cfg.Shadow,
+		REST:                cfg.REST,
+		Scripts:             cfg.Scripts,
+		IndexAdvisor:        cfg.IndexAdvisor,
+		TableCache:          cfg.TableCache,
+		ServerName:          cfg.Name,
 		LogQueriesRewritten: cfg.LogQueriesRewritten,
 	}
 	s.runtime = runtime.New(rtCfg, s.registry, logger)
 
+	// Open the statement journal, recovering interrupted executions
+	if cfg.Journal.Path != "" {
+		journal, err := runtime.OpenJournal(cfg.Journal, logger)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.runtime.SetJournal(journal)
+	}
+
+	// Start delivering events to webhooks
+	if len(cfg.Notify.Webhooks) > 0 {
+		notifier, err := notify.New(cfg.Notify, cfg.Name, logger)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.notifier = notifier
+		s.runtime.SetNotifier(notifier)
+		logger.System().Info("notifications enabled",
+			"webhooks", len(cfg.Notify.Webhooks),
+		)
+	}
+
+	// Feature flags, which the admin API can add to even when none are
+	// configured
+	flags, err := features.New(cfg.Features)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.runtime.SetFeatures(flags)
+	if len(cfg.Features.Flags) > 0 {
+		logger.System().Info("feature flags loaded",
+			"flags", len(cfg.Features.Flags),
+		)
+	}
+
+	if err := cfg.Erasure.Validate(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// The SQL firewall, when it has rules or denies by default
+	if len(cfg.Firewall.Rules) > 0 || cfg.Firewall.Default != "" {
+		fw, err := firewall.New(cfg.Firewall)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.firewall = fw
+		logger.System().Info("firewall enabled",
+			"rules", len(cfg.Firewall.Rules),
+			"mode", cmp.Or(cfg.Firewall.Mode, firewall.ModeEnforce),
+		)
+	}
+
+	// Sandbox policies, which the interpreter enforces statement by statement
+	if len(cfg.Sandbox.Policies) > 0 {
+		sb, err := sandbox.New(cfg.Sandbox)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.runtime.SetSandbox(sb)
+		logger.System().Info("sandbox enabled", "policies", len(cfg.Sandbox.Policies))
+	}
+
+	// Hooks, in the order they run
+	if len(cfg.Hooks.Hooks) > 0 || len(cfg.Hooks.Plugins) > 0 {
+		chain, err := hooks.New(cfg.Hooks)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		chain.OnIgnored = func(hook, point string, err error) {
+			logger.Application().Warn("hook failed; continuing",
+				"hook", hook,
+				"point", point,
+				"error", err.Error(),
+			)
+		}
+		if chain.Len() > 0 {
+			s.hooks = chain
+		}
+		logger.System().Info("hooks enabled",
+			"hooks", chain.Len(),
+			"plugins", len(cfg.Hooks.Plugins),
+		)
+	}
+
+	if len(cfg.Mail.Profiles) > 0 {
+		mailer, err := mail.New(cfg.Mail, logger)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.mailer = mailer
+		s.runtime.SetMail(mailer)
+		logger.System().Info("database mail enabled",
+			"profiles", len(cfg.Mail.Profiles),
+		)
+	}
+
+	// xp_cmdshell, off unless commands are allowed
+	if len(cfg.CmdShell.Commands) > 0 {
+		shell, err := cmdshell.New(cfg.CmdShell, logger)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.runtime.SetCommandShell(shell)
+		logger.System().Info("xp_cmdshell enabled", "commands", len(cfg.CmdShell.Commands))
+	}
+
+	// Extensions last, since their functions stay registered until Stop
+	if cfg.Extensions.Dir != "" {
+		ext, err := extensions.Load(ctx, cfg.Extensions)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.extensions = ext
+		for _, fn := range ext.Functions() {
+			logger.System().Debug("extension function registered",
+				"module", fn.Module,
+				"function", fn.Name,
+				"params", strings.Join(fn.Params, ", "),
+				"returns", fn.Returns,
+			)
+		}
+		logger.System().Info("extensions loaded",
+			"dir", cfg.Extensions.Dir,
+			"functions", len(ext.Functions()),
+		)
+	}
+
 	logger.System().Info("server initialised",
 		"name", cfg.Name,
 		"version", cfg.Version,
@@ -206,6 +530,15 @@ func (s *Server) Start() error {
 				WithField("directory", s.config.ProcedureDir).
 				Err()
 		}
+		if s.config.WatchChanges {
+			if err := s.startWatcher(); err != nil {
+				return aulerrors.Wrap(err, aulerrors.ErrCodeProcLoadError,
+					"failed to watch procedures").
+					WithOp("Server.Start").
+					WithField("directory", s.config.ProcedureDir).
+					Err()
+			}
+		}
 	}
 
 	// Initialise storage backend
@@ -216,6 +549,53 @@ func (s *Server) Start() error {
 			Err()
 	}
 
+	// Bring a fresh backend up with its schema and seed data
+	if s.config.InitDir != "" {
+		if err := s.runInitScripts(); err != nil {
+			return aulerrors.Wrap(err, aulerrors.ErrCodeStorageExec,
+				"failed to run init scripts").
+				WithOp("Server.Start").
+				WithField("directory", s.config.InitDir).
+				Err()
+		}
+	}
+
+	// Provision the logins listeners require
+	if err := s.startLogins(); err != nil {
+		return aulerrors.Wrap(err, aulerrors.ErrCodeStorageExec,
+			"failed to provision logins").
+			WithOp("Server.Start").
+			Err()
+	}
+
+	// Join the cluster of servers sharing the backend
+	if err := s.startCluster(); err != nil {
+		return aulerrors.Wrap(err, aulerrors.ErrCodeStorageConnect,
+			"failed to join cluster").
+			WithOp("Server.Start").
+			Err()
+	}
+
+	// Keep the backend's statistics current
+	s.startStatistics()
+
+	// Create the indexes the workload is missing, in auto mode
+	s.startIndexAdvisor()
+
+	// Move the old partitions of archival tables to object storage
+	if err := s.startArchive(); err != nil {
+		return aulerrors.Wrap(err, aulerrors.ErrCodeStorageConnect,
+			"failed to open archive store").
+			WithOp("Server.Start").
+			WithField("store", s.config.Archive.Store).
+			Err()
+	}
+
+	// Re-run executions interrupted by the previous shutdown
+	if s.config.Journal.Replay {
+		s.runtime.ReplayInterrupted(s.ctx)
+	}
+
 	// Start protocol listeners
 	for _, lcfg := range s.config.Listeners {
 		if err := s.startListener(lcfg); err != nil {
@@ -271,11 +651,44 @@ func (s *Server) Stop() error {
 	// Wait for all goroutines
 	s.wg.Wait()
 
+	// Stop hot reload
+	if s.watcher != nil {
+		if err := s.watcher.Stop(); err != nil {
+			s.logger.System().Error("failed to stop procedure watcher", err)
+		}
+		s.watcher = nil
+	}
+
+	// Leave the cluster, releasing the node's locks
+	if err := s.cluster.Close(); err != nil {
+		s.logger.System().Error("failed to release cluster locks", err)
+	}
+	s.cluster = nil
+
 	// Close storage
 	if s.storage != nil {
 		s.storage.Close()
 	}
 
+	// Close the statement journal
+	if journal := s.runtime.Journal(); journal != nil {
+		if err := journal.Close(); err != nil {
+			s.logger.System().Error("failed to close statement journal", err)
+		}
+	}
+
+	// Deliver pending notifications and mail
+	s.notifier.Close()
+	s.mailer.Close()
+
+	// Unregister the extensions' functions
+	if s.extensions != nil {
+		if err := s.extensions.Close(context.Background()); err != nil {
+			s.logger.System().Error("failed to close extensions", err)
+		}
+		s.extensions = nil
+	}
+
 	// Close logger
 	if s.logger != nil {
 		s.logger.Close()
@@ -329,6 +742,16 @@ func (s *Server) Stats() Stats {
 		Listeners:   len(s.listeners),
 		JITEnabled:  s.config.JITEnabled,
 		JITCompiled: s.runtime.JITStats().CompiledCount,
+		MemoryUsed:  s.runtime.Memory().Stats().Used,
+		Panics:      s.runtime.Stats().Panics,
+	}
+
+	if breakers := s.runtime.Breakers(); breakers != nil {
+		for _, b := range breakers.Status() {
+			if b.State != runtime.BreakerClosed {
+				stats.OpenBreakers++
+			}
+		}
 	}
 
 	// Collect listener stats
@@ -351,6 +774,9 @@ type Stats struct {
 	Listeners     int
 	JITEnabled    bool
 	JITCompiled   int
+	OpenBreakers  int   // Procedures quarantined by their circuit breaker
+	MemoryUsed    int64 // Bytes held in result sets and temp objects
+	Panics        int64 // Panics recovered from; each cost one connection or statement
 	ListenerStats []ListenerStats
 }
 
@@ -367,19 +793,24 @@ func (s *Server) loadProcedures() error {
 		"directory", s.config.ProcedureDir,
 	)
 
+	start := time.Now()
 	loader := procedure.NewLoader(s.config.DefaultDialect, s.logger)
+	loader.Workers = s.config.ProcLoadWorkers
+	if s.config.StrictCompatibility {
+		loader.Check = s.checkCompatibility
+	}
 	procs, err := loader.LoadDir(s.config.ProcedureDir)
 	if err != nil {
 		return err
 	}
 
+	if err := s.registry.RegisterAll(procs); err != nil {
+		return aulerrors.Wrap(err, aulerrors.ErrCodeProcAlreadyExists,
+			"failed to register procedures").
+			Err()
+	}
+	s.deploy.active = deployedSet{source: s.config.ProcedureDir, loadedAt: start, activatedAt: start}
 	for _, proc := range procs {
-		if err := s.registry.Register(proc); err != nil {
-			return aulerrors.Wrap(err, aulerrors.ErrCodeProcAlreadyExists,
-				"failed to register procedure").
-				WithField("procedure", proc.Name).
-				Err()
-		}
 		s.logger.Application().Debug("procedure loaded",
 			"name", proc.QualifiedName(),
 			"dialect", proc.Dialect,
@@ -389,11 +820,43 @@ func (s *Server) loadProcedures() error {
 
 	s.logger.Application().Info("procedures loaded",
 		"count", len(procs),
+		"duration_ms", time.Since(start).Milliseconds(),
 	)
 
 	return nil
 }
 
+// startWatcher hot-reloads procedures as their files change. A changed
+// file that fails to load keeps its previous version registered, and the
+// webhooks are told. With shadow execution enabled, changed procedures
+// run in shadow until promoted.
+func (s *Server) startWatcher() error {
+	opts := []procedure.WatcherOption{
+		procedure.WithOnError(func(err error) {
+			fields := []interface{}{"error", err.Error()}
+			if path, ok := aulerrors.GetFields(err)["path"]; ok {
+				fields = append(fields, "path", path)
+			}
+			s.notifier.Notify(notify.EventProcedureReloadFailed, "failed to reload procedure", fields...)
+		}),
+	}
+	if shadow := s.runtime.Shadow(); shadow != nil {
+		opts = append(opts, procedure.WithStage(shadow.Stage))
+	}
+	if s.config.StrictCompatibility {
+		opts = append(opts, procedure.WithReloadCheck(s.checkCompatibility))
+	}
+	watcher, err := procedure.NewWatcher(s.config.ProcedureDir, s.config.DefaultDialect, s.registry, s.logger, opts...)
+	if err != nil {
+		return err
+	}
+	if err := watcher.Start(); err != nil {
+		return err
+	}
+	s.watcher = watcher
+	return nil
+}
+
 // initStorage initialises the storage backend.
 func (s *Server) initStorage() error {
 	var err error
@@ -407,20 +870,26 @@ func (s *Server) initStorage() error {
 		// Wire up registry to storage for system catalog queries
 		if sqliteStorage, ok := s.storage.(*storage.SQLiteStorage); ok {
 			sqliteStorage.SetRegistry(s.registry)
+			sqliteStorage.SetRuntime(s.runtime)
 		}
-		s.logger.System().Info("SQLite storage initialised",
+		s.logger.Storage().Info("SQLite storage initialised",
 			"path", s.config.StorageConfig.Options["path"],
 		)
 
 	case "memory", "":
 		s.storage = runtime.NewMemoryStorage()
-		s.logger.System().Info("in-memory storage initialised")
+		s.logger.Storage().Info("in-memory storage initialised")
 
 	default:
-		return aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
-			"unsupported storage type: %s", s.config.StorageConfig.Type).
-			WithOp("Server.initStorage").
-			Err()
+		// A backend linked in or loaded from a plugin
+		s.storage, err = runtime.OpenStorage(s.config.StorageConfig)
+		if err != nil {
+			return err
+		}
+		s.logger.Storage().Info("storage initialised",
+			"type", s.config.StorageConfig.Type,
+			"dialect", s.storage.Dialect(),
+		)
 	}
 
 	s.runtime.SetStorage(s.storage)
@@ -446,6 +915,7 @@ func (s *Server) initSQLiteStorage() (runtime.StorageBackend, error) {
 		Synchronous:  "NORMAL",
 		CacheSize:    -2000,
 		BusyTimeout:  5000,
+		ArchiveCache: len(s.config.Archive.Tables) > 0,
 	}
 
 	// Override with options if provided
@@ -459,6 +929,215 @@ func (s *Server) initSQLiteStorage() (runtime.StorageBackend, error) {
 	return storage.NewSQLiteStorage(sqliteCfg)
 }
 
+// startLogins opens the SQL login store on the storage backend and
+// creates or updates Config.Logins in it, if a listener requires logins
+// or any are configured, and opens the permissions of the logins beside
+// it.
+func (s *Server) startLogins() error {
+	required := false
+	for _, l := range s.config.Listeners {
+		required = required || l.RequireLogin
+	}
+	if s.config.Permissions.Enforce && !required {
+		s.logger.System().Warn("permissions are enforced only on listeners that require logins, and none do")
+	}
+	if !required && len(s.config.Logins) == 0 {
+		return nil
+	}
+	store, err := auth.NewStore(s.ctx, s.storage.GetDB())
+	if err != nil {
+		return err
+	}
+	if err := store.Provision(s.ctx, s.config.Logins); err != nil {
+		return err
+	}
+	s.logins = store
+	s.logger.System().Info("SQL logins provisioned", "logins", len(s.config.Logins))
+
+	permissions, err := auth.NewPermissions(s.ctx, store, s.config.Permissions.Administrators)
+	if err != nil {
+		return err
+	}
+	s.permissions = permissions
+	s.runtime.SetPermissions(permissions)
+	return nil
+}
+
+// startCluster joins the cluster Config.Cluster names, if any, and keeps
+// the node's locks alive until the server stops.
+func (s *Server) startCluster() error {
+	cfg := s.config.Cluster
+	if cfg.Provider == "" {
+		return nil
+	}
+	cluster, err := runtime.OpenCluster(cfg, s.storage, s.logger)
+	if err != nil {
+		return err
+	}
+	s.cluster = cluster
+	s.runtime.Locks().SetCluster(cluster)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		cluster.Run(s.ctx)
+	}()
+
+	s.logger.System().Info("joined cluster",
+		"provider", cfg.Provider,
+		"node", cluster.Node(),
+		"lease", cluster.Lease().String(),
+		"leader", cluster.Lead(s.ctx),
+	)
+	return nil
+}
+
+// startStatistics refreshes the storage backend's statistics every
+// Statistics.Interval, when it keeps any, until the server stops. In a
+// cluster only the leader refreshes them.
+func (s *Server) startStatistics() {
+	cfg := s.config.Statistics
+	backend, ok := s.storage.(runtime.StatisticsBackend)
+	if cfg.Interval <= 0 || !ok {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !s.cluster.Lead(s.ctx) {
+				continue
+			}
+			tables, err := backend.RefreshStatistics(s.ctx, cfg.Threshold)
+			if err != nil && s.ctx.Err() == nil {
+				s.logger.Storage().Error("statistics refresh failed", err)
+			}
+			if len(tables) > 0 {
+				s.logger.Storage().Info("statistics updated",
+					"tables", strings.Join(tables, ", "),
+				)
+			}
+		}
+	}()
+
+	s.logger.Storage().Info("statistics refresh scheduled",
+		"interval", cfg.Interval.String(),
+	)
+}
+
+// startIndexAdvisor creates the indexes the index advisor recommends
+// every IndexAdvisor.Interval, in auto mode and when the storage backend
+// can create them, until the server stops. In a cluster only the leader
+// creates them.
+func (s *Server) startIndexAdvisor() {
+	advisor := s.runtime.IndexAdvisor()
+	if advisor == nil || advisor.Config().Mode != runtime.IndexAdvisorAuto {
+		return
+	}
+	if _, ok := s.storage.(runtime.IndexBackend); !ok {
+		s.logger.Storage().Warn("index advisor cannot create indexes on this storage backend")
+		return
+	}
+	cfg := advisor.Config()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !s.cluster.Lead(s.ctx) {
+				continue
+			}
+			created, err := s.runtime.CreateMissingIndexes(s.ctx)
+			if err != nil && s.ctx.Err() == nil {
+				s.logger.Storage().Error("creating missing indexes failed", err)
+			}
+			if len(created) > 0 {
+				s.logger.Storage().Info("missing indexes created",
+					"indexes", strings.Join(created, ", "),
+				)
+			}
+		}
+	}()
+
+	s.logger.Storage().Info("index advisor creating missing indexes",
+		"interval", cfg.Interval.String(),
+		"min_seeks", cfg.MinSeeks,
+	)
+}
+
+// startArchive opens the archive of Config.Archive's tables, if any, so
+// that queries read their archived partitions, and archives the
+// partitions past their Keep every Archive.Interval until the server
+// stops. In a cluster only the leader archives them.
+func (s *Server) startArchive() error {
+	cfg := s.config.Archive
+	if len(cfg.Tables) == 0 {
+		return nil
+	}
+	if _, ok := s.storage.(*storage.SQLiteStorage); !ok {
+		s.logger.Storage().Warn("archival tables are supported only on SQLite storage")
+		return nil
+	}
+	store, err := archive.OpenStore(cfg)
+	if err != nil {
+		return err
+	}
+	archiver, err := archive.New(s.ctx, s.storage.GetDB(), store, cfg)
+	if err != nil {
+		return err
+	}
+	s.runtime.SetArchive(archiver)
+	cfg = archiver.Config()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !s.cluster.Lead(s.ctx) {
+				continue
+			}
+			archived, err := archiver.Run(s.ctx)
+			if err != nil && s.ctx.Err() == nil {
+				s.logger.Storage().Error("archiving partitions failed", err)
+			}
+			if len(archived) > 0 {
+				s.logger.Storage().Info("partitions archived",
+					"partitions", strings.Join(archived, ", "),
+				)
+			}
+		}
+	}()
+
+	s.logger.Storage().Info("archiving old partitions",
+		"store", cfg.Store,
+		"tables", len(cfg.Tables),
+		"interval", cfg.Interval.String(),
+	)
+	return nil
+}
+
 // startListener starts a protocol listener.
 func (s *Server) startListener(cfg protocol.ListenerConfig) error {
 	s.logger.System().Info("starting listener",
@@ -467,6 +1146,18 @@ func (s *Server) startListener(cfg protocol.ListenerConfig) error {
 		"name", cfg.Name,
 	)
 
+	cfg.Admin = s
+	if cfg.RequireLogin {
+		switch cfg.Protocol {
+		case protocol.ProtocolTDS, protocol.ProtocolPostgres, protocol.ProtocolHTTP:
+		default:
+			return aulerrors.Newf(aulerrors.ErrCodeConfigInvalid,
+				"%s clients cannot sign in with SQL logins", cfg.Protocol).
+				WithField("listener", cfg.Name).
+				Err()
+		}
+		cfg.Logins = s.logins
+	}
 	listener, err := protocol.NewListener(cfg, s.logger)
 	if err != nil {
 		return err
@@ -477,25 +1168,31 @@ func (s *Server) startListener(cfg protocol.ListenerConfig) error {
 		return err
 	}
 
+	s.mu.Lock()
 	s.listeners[cfg.Name] = listener
+	s.mu.Unlock()
 
 	// Start accepting connections
+	cfg.ReadOnly = cfg.ReadOnly || s.config.ReadOnly
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		s.acceptLoop(listener)
+		s.acceptLoop(listener, cfg)
 	}()
 
 	s.logger.System().Info("listener started",
 		"protocol", cfg.Protocol,
-		"address", listener.Addr().String(),
+		"address", protocol.FormatAddrs(listener.Addrs()),
+		"read_only", cfg.ReadOnly,
+		"parameterized_only", cfg.ParameterizedOnly,
+		"require_login", cfg.RequireLogin,
 	)
 
 	return nil
 }
 
-// acceptLoop accepts connections from a listener.
-func (s *Server) acceptLoop(listener protocol.Listener) {
+// acceptLoop accepts connections from a listener configured by cfg.
+func (s *Server) acceptLoop(listener protocol.Listener, cfg protocol.ListenerConfig) {
 	for {
 		select {
 		case <-s.ctx.Done():
@@ -503,8 +1200,16 @@ func (s *Server) acceptLoop(listener protocol.Listener) {
 		default:
 		}
 
-		conn, err := listener.Accept()
+		conn, err := s.accept(listener)
 		if err != nil {
+			// A client whose handshake panicked the protocol parser loses
+			// only its own connection
+			var panicErr *protocol.PanicError
+			if errors.As(err, &panicErr) {
+				s.runtime.RecordPanic(s.ctx, "Listener.Accept", panicErr.Value, panicErr.Stack)
+				continue
+			}
+
 			// Check if we're shutting down
 			select {
 			case <-s.ctx.Done():
@@ -529,11 +1234,18 @@ func (s *Server) acceptLoop(listener protocol.Listener) {
 		s.wg.Add(1)
 		go func() {
 			defer s.wg.Done()
-			s.handleConnection(conn)
+			s.handleConnection(conn, cfg)
 		}()
 	}
 }
 
+// accept accepts the next connection, turning a panic in the listener into
+// a PanicError.
+func (s *Server) accept(listener protocol.Listener) (conn protocol.Connection, err error) {
+	defer protocol.Recover(&err)
+	return listener.Accept()
+}
+
 // isTemporaryError checks if an error is temporary and can be ignored.
 func isTemporaryError(err error) bool {
 	if err == nil {
@@ -545,11 +1257,19 @@ func isTemporaryError(err error) bool {
 		errStr == "use of closed network connection"
 }
 
-// handleConnection handles a single client connection.
-// handleConnection handles a single client connection.
-func (s *Server) handleConnection(conn protocol.Connection) {
+// handleConnection handles a single client connection to the listener
+// configured by cfg.
+func (s *Server) handleConnection(conn protocol.Connection, cfg protocol.ListenerConfig) {
 	defer conn.Close()
 
+	// Requests have a boundary of their own in the handler; this one keeps
+	// a panic setting the session up from taking the server down
+	defer func() {
+		if v := recover(); v != nil {
+			s.runtime.RecordPanic(s.ctx, "Server.handleConnection", v, debug.Stack())
+		}
+	}()
+
 	// Extract tenant from connection if multi-tenancy is enabled
 	var tenant string
 	if s.tenantIdentifier.IsEnabled() {
@@ -569,9 +1289,85 @@ func (s *Server) handleConnection(conn protocol.Connection) {
 	}
 
 	handler := NewConnectionHandlerWithTenant(conn, s.runtime, s.registry, s.logger, tenant, s.config.LogQueries)
+	handler.priority = s.priorityFor(conn.Properties())
+	handler.user = conn.Properties()["user"]
+	handler.app = appName(conn.Properties())
+	handler.address = clientAddress(conn.RemoteAddr())
+	handler.host = conn.Properties()["client_host"]
+	handler.spid = s.nextSPID()
+	handler.protocol = cfg.Protocol
+	handler.readOnly = cfg.ReadOnly
+	handler.enforcePermissions = s.config.Permissions.Enforce && cfg.RequireLogin && handler.user != ""
+	handler.execTimeout = s.config.ExecTimeout
+	handler.parameterizedOnly = cfg.ParameterizedOnly
+	handler.listener = cfg.Name
+	handler.firewall = s.firewall
+	handler.hooks = s.hooks
+	handler.streamResults = s.config.StreamResults
+	handler.traces = s.traces
+	if s.config.StatementSummary {
+		handler.summary = summaryResultSet
+		if cfg.Protocol == protocol.ProtocolHTTP || cfg.Protocol == protocol.ProtocolGRPC {
+			handler.summary = summaryMetadata
+		}
+	}
+	if s.config.SQLCmdMode && cfg.Protocol == protocol.ProtocolTDS {
+		handler.sqlcmd = sqlcmd.New(sqlcmd.Options{
+			IncludeDir:      s.config.SQLCmdIncludeDir,
+			ConfineIncludes: true,
+			DisableIncludes: s.config.SQLCmdIncludeDir == "",
+		})
+	}
 	handler.Serve(s.ctx)
 }
 
+// priorityFor picks the admission lane for a connection from its
+// application name (TDS app_name or PostgreSQL application_name).
+func (s *Server) priorityFor(props map[string]string) runtime.Priority {
+	app := appName(props)
+	if app == "" {
+		return runtime.PriorityInteractive
+	}
+	app = strings.ToLower(app)
+	for _, pattern := range s.config.BatchApps {
+		if ok, _ := path.Match(strings.ToLower(pattern), app); ok {
+			return runtime.PriorityBatch
+		}
+	}
+	return runtime.PriorityInteractive
+}
+
+// nextSPID numbers a new session for @@SPID. As in SQL Server, the
+// numbers of user sessions start at 51; they wrap round before leaving
+// smallint.
+func (s *Server) nextSPID() int {
+	const first, last = 51, 32767
+	n := int(s.sessions.Add(1)-1) % (last - first + 1)
+	return first + n
+}
+
+// clientAddress returns the address a client connected from, without its
+// port.
+func clientAddress(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// appName returns the application name a client gave: TDS app_name or
+// PostgreSQL application_name.
+func appName(props map[string]string) string {
+	if app := props["app_name"]; app != "" {
+		return app
+	}
+	return props["application_name"]
+}
+
 // Logger returns the server's logger.
 func (s *Server) Logger() *log.Logger {
 	return s.logger
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/notify"
+	"github.com/ha1tch/aul/pkg/runtime"
+)
+
+func TestServer_PriorityFor(t *testing.T) {
+	s := &Server{config: Config{BatchApps: []string{"sqlcmd", "ETL-*"}}}
+
+	tests := []struct {
+		props    map[string]string
+		expected runtime.Priority
+	}{
+		{map[string]string{}, runtime.PriorityInteractive},
+		{map[string]string{"app_name": "webapp"}, runtime.PriorityInteractive},
+		{map[string]string{"app_name": "SQLCMD"}, runtime.PriorityBatch},
+		{map[string]string{"app_name": "etl-nightly"}, runtime.PriorityBatch},
+		{map[string]string{"application_name": "etl-hourly"}, runtime.PriorityBatch},
+		{map[string]string{"app_name": "my-etl-job"}, runtime.PriorityInteractive},
+	}
+
+	for _, tt := range tests {
+		if got := s.priorityFor(tt.props); got != tt.expected {
+			t.Errorf("priorityFor(%v) = %v, want %v", tt.props, got, tt.expected)
+		}
+	}
+}
+
+func TestServer_SessionNumbers(t *testing.T) {
+	s := &Server{}
+	if a, b := s.nextSPID(), s.nextSPID(); a != 51 || b != 52 {
+		t.Errorf("spids = %d, %d, want 51, 52", a, b)
+	}
+	s.sessions.Store(32767 - 51)
+	if a, b := s.nextSPID(), s.nextSPID(); a != 32767 || b != 51 {
+		t.Errorf("spids = %d, %d, want 32767, 51", a, b)
+	}
+
+	for addr, want := range map[net.Addr]string{
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.9"), Port: 50123}: "10.0.0.9",
+		&net.TCPAddr{IP: net.ParseIP("::1"), Port: 1433}:       "::1",
+		&net.UnixAddr{Name: "/run/aul.sock", Net: "unix"}:      "/run/aul.sock",
+	} {
+		if got := clientAddress(addr); got != want {
+			t.Errorf("clientAddress(%v) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestServer_ReloadFailureNotifies(t *testing.T) {
+	events := make(chan notify.Event, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e notify.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer hook.Close()
+
+	dir := t.TempDir()
+	good := "CREATE PROCEDURE dbo.Hello AS BEGIN SELECT 1 END"
+	if err := os.WriteFile(filepath.Join(dir, "hello.sql"), []byte(good), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.ProcedureDir = dir
+	cfg.WatchChanges = true
+	cfg.JITEnabled = false
+	cfg.StorageConfig.Type = "memory"
+	cfg.Logger = log.New(log.Config{DefaultLevel: log.LevelError})
+	cfg.Notify = notify.Config{Webhooks: []notify.WebhookConfig{{
+		URL:    hook.URL,
+		Events: []string{notify.EventProcedureReloadFailed},
+	}}}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := os.WriteFile(filepath.Join(dir, "hello.sql"), []byte("SELECT 'no procedure here'"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-events:
+		if e.Type != notify.EventProcedureReloadFailed || e.Fields["path"] != filepath.Join(dir, "hello.sql") {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event delivered")
+	}
+	if _, err := s.Registry().Lookup("dbo.Hello"); err != nil {
+		t.Errorf("the previous version was unregistered: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	aulerrors "github.com/ha1tch/aul/pkg/errors"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// A request is traced when it asks to be, when it calls a procedure an
+// admin has turned tracing on for, or when its T-SQL runs EXEC ... WITH
+// TRACE. The server keeps the last keptTraces traces, each under the ID
+// of the request that made it, so that a trace can be found from the
+// request's log entries and errors as well as from the client, which is
+// told the ID.
+
+// keptTraces is how many traces the server keeps.
+const keptTraces = 100
+
+// traceStore holds the recent traces and the procedures traced on every
+// call.
+type traceStore struct {
+	mu         sync.Mutex
+	traces     []*protocol.Trace // Oldest first
+	procedures map[string]string // Lower-cased name -> qualified name
+}
+
+func newTraceStore() *traceStore {
+	return &traceStore{procedures: make(map[string]string)}
+}
+
+// traced reports whether every call of proc is traced.
+func (t *traceStore) traced(proc string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.procedures[strings.ToLower(proc)]
+	return ok
+}
+
+// add keeps a trace, dropping the oldest if there are too many.
+func (t *traceStore) add(trace *protocol.Trace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.traces) >= keptTraces {
+		t.traces = append(t.traces[:0], t.traces[1:]...)
+	}
+	t.traces = append(t.traces, trace)
+}
+
+// newTrace returns the trace to record a request calling proc, if any,
+// in, or nil if it is not traced.
+func (h *ConnectionHandler) newTrace(req protocol.Request, proc string) *tsqlruntime.Trace {
+	if h.traces == nil || !req.Options.Trace && !h.traces.traced(proc) {
+		return nil
+	}
+	return tsqlruntime.NewTrace()
+}
+
+// record keeps the trace of a request, if it was traced, and tells the
+// client its ID.
+func (h *ConnectionHandler) record(result protocol.Result, req protocol.Request, trace *tsqlruntime.Trace) protocol.Result {
+	if trace == nil || h.traces == nil {
+		return result
+	}
+	info := protocol.TraceInfo{
+		ID:         req.ID,
+		Procedure:  req.ProcedureName,
+		SessionID:  h.sessionID,
+		StartedAt:  trace.Started,
+		DurationMs: float64(time.Since(trace.Started).Microseconds()) / 1000,
+		Events:     len(trace.Events),
+		Dropped:    trace.Dropped,
+	}
+	if result.Error != nil {
+		info.Error = result.Error.Error()
+	}
+	h.traces.add(&protocol.Trace{TraceInfo: info, Steps: trace.Events})
+	result.TraceID = req.ID
+	result.Warnings = append(result.Warnings, "trace recorded: "+req.ID)
+	return result
+}
+
+// Traces implements protocol.Admin.
+func (s *Server) Traces() []protocol.TraceInfo {
+	s.traces.mu.Lock()
+	defer s.traces.mu.Unlock()
+	out := make([]protocol.TraceInfo, len(s.traces.traces))
+	for i, t := range s.traces.traces {
+		out[i] = t.TraceInfo
+	}
+	return out
+}
+
+// Trace implements protocol.Admin.
+func (s *Server) Trace(id string) (*protocol.Trace, error) {
+	s.traces.mu.Lock()
+	defer s.traces.mu.Unlock()
+	for _, t := range s.traces.traces {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return nil, aulerrors.NotFound("trace", id).
+		WithOp("Server.Trace").
+		Err()
+}
+
+// TracedProcedures implements protocol.Admin.
+func (s *Server) TracedProcedures() []string {
+	s.traces.mu.Lock()
+	defer s.traces.mu.Unlock()
+	names := make([]string, 0, len(s.traces.procedures))
+	for _, name := range s.traces.procedures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetProcedureTrace implements protocol.Admin. Tracing can be turned
+// off for a procedure that has since been removed.
+func (s *Server) SetProcedureTrace(name string, on bool) error {
+	proc, err := s.registry.Lookup(name)
+	if err == nil {
+		name = proc.QualifiedName()
+	} else if on {
+		return err
+	}
+
+	s.traces.mu.Lock()
+	defer s.traces.mu.Unlock()
+	if on {
+		s.traces.procedures[strings.ToLower(name)] = name
+	} else {
+		delete(s.traces.procedures, strings.ToLower(name))
+	}
+	s.logger.Application().Info("procedure tracing changed", "procedure", name, "on", on)
+	return nil
+}
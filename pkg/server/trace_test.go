@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/protocol"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+func TestServer_Trace(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ProcedureDir = writeProcs(t, map[string]string{
+		"Check": "DECLARE @n INT = 1 IF @n > 0 SELECT @n AS n",
+		"Other": "SELECT 2 AS n",
+	})
+	cfg.JITEnabled = false
+	cfg.StorageConfig.Type = "memory"
+	cfg.Listeners = nil
+	cfg.Logger = log.New(log.Config{DefaultLevel: log.LevelError})
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := s.SetProcedureTrace("dbo.Check", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetProcedureTrace("dbo.Missing", true); err == nil {
+		t.Error("traced a procedure that does not exist")
+	}
+	if got := s.TracedProcedures(); len(got) != 1 || got[0] != "dbo.Check" {
+		t.Fatalf("traced procedures = %v", got)
+	}
+
+	failing := protocol.Request{ID: "q1", Type: protocol.RequestQuery, SQL: "DECLARE @x INT = 1\nRAISERROR('boom', 16, 1)"}
+	failing.Options.Trace = true
+	conn := &scriptedConn{requests: []protocol.Request{
+		{ID: "p1", Type: protocol.RequestExec, ProcedureName: "dbo.Check"},
+		{ID: "p2", Type: protocol.RequestExec, ProcedureName: "dbo.Other"},
+		failing,
+	}}
+	h := NewConnectionHandler(conn, s.runtime, s.registry, s.logger, false)
+	h.traces = s.traces
+	h.Serve(context.Background())
+
+	if len(conn.results) != 3 {
+		t.Fatalf("results = %+v", conn.results)
+	}
+	if conn.results[0].TraceID != "p1" || conn.results[1].TraceID != "" || conn.results[2].TraceID != "q1" {
+		t.Errorf("trace IDs = %q, %q, %q", conn.results[0].TraceID, conn.results[1].TraceID, conn.results[2].TraceID)
+	}
+
+	infos := s.Traces()
+	if len(infos) != 2 || infos[0].Procedure != "dbo.Check" || infos[1].Error == "" {
+		t.Fatalf("traces = %+v", infos)
+	}
+	trace, err := s.Trace("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, e := range trace.Steps {
+		kinds = append(kinds, e.Kind)
+	}
+	want := []string{tsqlruntime.TraceStatement, tsqlruntime.TraceAssign, tsqlruntime.TraceBranch, tsqlruntime.TraceStatement}
+	if strings.Join(kinds, " ") != strings.Join(want, " ") || trace.Steps[2].Branch != "THEN" {
+		t.Errorf("steps = %+v", trace.Steps)
+	}
+
+	// The trace of a failed execution has the step that failed
+	failed, err := s.Trace("q1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := failed.Steps[len(failed.Steps)-1]; last.Error == "" {
+		t.Errorf("last step = %+v, want the RAISERROR failing", last)
+	}
+	if _, err := s.Trace("nope"); err == nil {
+		t.Error("found a trace that was never recorded")
+	}
+
+	if err := s.SetProcedureTrace("dbo.Check", false); err != nil || len(s.TracedProcedures()) != 0 {
+		t.Errorf("after disabling: %v, %v", err, s.TracedProcedures())
+	}
+}
@@ -0,0 +1,365 @@
+// Package sqlcmd runs scripts written for SQL Server's sqlcmd utility.
+//
+// sqlcmd reads a script line by line. Lines starting with a colon are
+// commands to sqlcmd itself rather than T-SQL, $(name) anywhere on a line
+// is replaced by the value of a scripting variable, and a line holding
+// only GO ends a batch, which is then sent to the server. This package
+// does the same, handing each batch to a function that executes it, so
+// that deployment scripts written for sqlcmd run unchanged.
+//
+// Supported commands:
+//
+//	:setvar name value    Set a scripting variable; without a value, unset it
+//	:r file               Read the lines of another script in place
+//	:on error exit        Stop at the first batch that fails
+//	:on error ignore      Report a failed batch and go on (the default)
+//	:exit                 Stop without executing the current batch
+//	:exit()               Execute the current batch, then stop
+//	:exit(statement)      Execute the current batch and statement, then stop
+//	:quit                 Stop without executing the current batch
+//	:reset                Discard the current batch
+//	GO [count]            Execute the current batch, count times
+//
+// Commands that only make sense in an interactive client (:connect, :out,
+// !! and the like) are errors.
+package sqlcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth bounds :r nesting, which is otherwise endless when a
+// script includes itself.
+const maxIncludeDepth = 16
+
+// Options configures a Processor.
+type Options struct {
+	// Variables are the scripting variables defined before the script
+	// starts, as with sqlcmd -v.
+	Variables map[string]string
+
+	// Lookup resolves a variable the script has not defined, as sqlcmd
+	// does from the environment; nil leaves such variables undefined.
+	Lookup func(name string) (string, bool)
+
+	// ExitOnError starts the script in :on error exit mode, as sqlcmd -b.
+	ExitOnError bool
+
+	// OnError is told of each failed batch the script goes on past.
+	OnError func(err error)
+
+	// IncludeDir is where relative :r paths are resolved from ("" = the
+	// working directory, as sqlcmd does).
+	IncludeDir string
+
+	// ConfineIncludes rejects :r paths outside IncludeDir.
+	ConfineIncludes bool
+
+	// DisableIncludes rejects :r altogether.
+	DisableIncludes bool
+}
+
+// Processor runs sqlcmd scripts. Scripting variables set by one script
+// remain set for the next.
+type Processor struct {
+	opts      Options
+	vars      map[string]string
+	exitOnErr bool
+}
+
+// New returns a processor.
+func New(opts Options) *Processor {
+	p := &Processor{opts: opts, vars: make(map[string]string), exitOnErr: opts.ExitOnError}
+	for name, value := range opts.Variables {
+		p.vars[strings.ToUpper(name)] = value
+	}
+	return p
+}
+
+// Variable returns the value of a scripting variable.
+func (p *Processor) Variable(name string) (string, bool) {
+	if v, ok := p.vars[strings.ToUpper(name)]; ok {
+		return v, true
+	}
+	if p.opts.Lookup != nil {
+		return p.opts.Lookup(name)
+	}
+	return "", false
+}
+
+// ErrExit is returned by Run when :exit or :quit stopped the script.
+var ErrExit = errors.New("sqlcmd: script exited")
+
+// BatchError is the failure of one batch of a script.
+type BatchError struct {
+	Source string // Script the batch ends in
+	Line   int    // Line the batch starts on
+	Err    error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%sbatch at line %d: %v", sourcePrefix(e.Source), e.Line, e.Err)
+}
+
+func (e *BatchError) Unwrap() error { return e.Err }
+
+// sourcePrefix introduces an error in the script named source.
+func sourcePrefix(source string) string {
+	if source == "" {
+		return ""
+	}
+	return source + ": "
+}
+
+// run is the state of one Run.
+type run struct {
+	*Processor
+	exec      func(batch string) error
+	batch     strings.Builder
+	batchFrom string // Source and line the current batch starts at
+	batchLine int
+	depth     int
+}
+
+// Run executes script, named source in errors ("" for none), handing
+// each batch to exec. It stops at the first batch that fails in :on error
+// exit mode, returning a *BatchError, and at the first script error, such
+// as an undefined variable or an unknown command. A script stopped by
+// :exit or :quit returns nil.
+func (p *Processor) Run(script, source string, exec func(batch string) error) error {
+	r := &run{Processor: p, exec: exec}
+	err := r.lines(script, source)
+	if err == nil {
+		err = r.flush(1)
+	}
+	if errors.Is(err, ErrExit) {
+		return nil
+	}
+	return err
+}
+
+// lines processes the lines of script.
+func (r *run) lines(script, source string) error {
+	for i, line := range strings.Split(strings.ReplaceAll(script, "\r\n", "\n"), "\n") {
+		if err := r.line(line, source, i+1); err != nil {
+			var be *BatchError
+			if errors.Is(err, ErrExit) || errors.As(err, &be) {
+				return err
+			}
+			return fmt.Errorf("%sline %d: %w", sourcePrefix(source), i+1, err)
+		}
+	}
+	return nil
+}
+
+var (
+	goLine      = regexp.MustCompile(`(?i)^\s*GO(?:\s+(\d+))?\s*;?\s*$`)
+	commandLine = regexp.MustCompile(`^\s*(:[A-Za-z]+|!!)(.*)$`)
+	variableRef = regexp.MustCompile(`\$\(([^)]*)\)`)
+	variableNam = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+)
+
+// line processes one line of a script.
+func (r *run) line(line, source string, n int) error {
+	line, err := r.substitute(line)
+	if err != nil {
+		return err
+	}
+
+	if m := goLine.FindStringSubmatch(line); m != nil {
+		count := 1
+		if m[1] != "" {
+			if count, err = strconv.Atoi(m[1]); err != nil || count < 1 {
+				return fmt.Errorf("invalid GO count %q", m[1])
+			}
+		}
+		return r.flush(count)
+	}
+
+	m := commandLine.FindStringSubmatch(line)
+	if m == nil {
+		if r.batch.Len() == 0 {
+			r.batchFrom, r.batchLine = source, n
+		}
+		r.batch.WriteString(line)
+		r.batch.WriteByte('\n')
+		return nil
+	}
+
+	command, arg := strings.ToLower(m[1]), strings.TrimSpace(m[2])
+	switch command {
+	case ":setvar":
+		return r.setvar(arg)
+	case ":r":
+		return r.include(arg)
+	case ":on":
+		switch strings.ToLower(strings.Join(strings.Fields(arg), " ")) {
+		case "error exit":
+			r.exitOnErr = true
+		case "error ignore":
+			r.exitOnErr = false
+		default:
+			return fmt.Errorf(":on error must be followed by exit or ignore")
+		}
+		return nil
+	case ":reset":
+		r.batch.Reset()
+		return nil
+	case ":quit":
+		return ErrExit
+	case ":exit":
+		if arg == "" {
+			return ErrExit
+		}
+		if !strings.HasPrefix(arg, "(") || !strings.HasSuffix(arg, ")") {
+			return fmt.Errorf("invalid :exit %q", arg)
+		}
+		if err := r.flush(1); err != nil {
+			return err
+		}
+		if stmt := strings.TrimSpace(arg[1 : len(arg)-1]); stmt != "" {
+			r.batchFrom, r.batchLine = source, n
+			r.batch.WriteString(stmt)
+			if err := r.flush(1); err != nil {
+				return err
+			}
+		}
+		return ErrExit
+	}
+	return fmt.Errorf("sqlcmd command %s is not supported", m[1])
+}
+
+// substitute replaces the $(name) references in line.
+func (r *run) substitute(line string) (string, error) {
+	if !strings.Contains(line, "$(") {
+		return line, nil
+	}
+	var err error
+	line = variableRef.ReplaceAllStringFunc(line, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		value, ok := r.Variable(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("'%s' scripting variable not defined", name)
+		}
+		return value
+	})
+	return line, err
+}
+
+// setvar handles :setvar name [value].
+func (r *run) setvar(arg string) error {
+	name, value, _ := strings.Cut(arg, " ")
+	if name == "" {
+		return fmt.Errorf(":setvar needs a variable name")
+	}
+	if !variableNam.MatchString(name) {
+		return fmt.Errorf("invalid scripting variable name %q", name)
+	}
+	name = strings.ToUpper(name)
+	value = strings.TrimSpace(value)
+	if value == "" {
+		delete(r.vars, name)
+		return nil
+	}
+	if strings.HasPrefix(value, `"`) {
+		unquoted, ok := unquote(value)
+		if !ok {
+			return fmt.Errorf("unterminated value for :setvar %s", name)
+		}
+		value = unquoted
+	}
+	r.vars[name] = value
+	return nil
+}
+
+// unquote removes the double quotes around s, in which "" stands for ".
+func unquote(s string) (string, bool) {
+	if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+		return "", false
+	}
+	inner := s[1 : len(s)-1]
+	if strings.Count(strings.ReplaceAll(inner, `""`, ""), `"`) > 0 {
+		return "", false
+	}
+	return strings.ReplaceAll(inner, `""`, `"`), true
+}
+
+// include handles :r path.
+func (r *run) include(arg string) error {
+	if r.opts.DisableIncludes {
+		return fmt.Errorf(":r is disabled")
+	}
+	path := arg
+	if strings.HasPrefix(path, `"`) {
+		unquoted, ok := unquote(path)
+		if !ok {
+			return fmt.Errorf("unterminated :r path")
+		}
+		path = unquoted
+	}
+	if path == "" {
+		return fmt.Errorf(":r needs a file name")
+	}
+	if !filepath.IsAbs(path) && r.opts.IncludeDir != "" {
+		path = filepath.Join(r.opts.IncludeDir, path)
+	}
+	if r.opts.ConfineIncludes {
+		rel, err := filepath.Rel(r.opts.IncludeDir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf(":r %s is outside the include directory", arg)
+		}
+	}
+	if r.depth >= maxIncludeDepth {
+		return fmt.Errorf(":r nested more than %d deep", maxIncludeDepth)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+	return r.lines(string(data), path)
+}
+
+// flush executes the current batch count times.
+func (r *run) flush(count int) error {
+	batch := r.batch.String()
+	r.batch.Reset()
+	if strings.TrimSpace(batch) == "" {
+		return nil
+	}
+	for i := 0; i < count; i++ {
+		if err := r.exec(batch); err != nil {
+			err = &BatchError{Source: r.batchFrom, Line: r.batchLine, Err: err}
+			if r.exitOnErr {
+				return err
+			}
+			if r.opts.OnError != nil {
+				r.opts.OnError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// IsScript reports whether sql uses anything of sqlcmd's: a command line,
+// a GO line or a $(name) variable reference.
+func IsScript(sql string) bool {
+	if strings.Contains(sql, "$(") {
+		return true
+	}
+	for _, line := range strings.Split(sql, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if goLine.MatchString(line) || commandLine.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
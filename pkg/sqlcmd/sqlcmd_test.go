@@ -0,0 +1,195 @@
+package sqlcmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// record runs script, returning the batches executed.
+func record(t *testing.T, p *Processor, script string) ([]string, error) {
+	t.Helper()
+	var batches []string
+	err := p.Run(script, "test.sql", func(batch string) error {
+		batches = append(batches, strings.TrimSpace(batch))
+		return nil
+	})
+	return batches, err
+}
+
+func TestProcessor_Batches(t *testing.T) {
+	p := New(Options{Variables: map[string]string{"Schema": "sales"}})
+	batches, err := record(t, p, `
+:setvar Table "Orders"
+CREATE TABLE $(schema).$(Table) (id INT)
+GO
+:setvar Table Lines
+INSERT INTO $(Schema).$(Table) VALUES (1)
+go 2
+SELECT '$(Table)'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"CREATE TABLE sales.Orders (id INT)",
+		"INSERT INTO sales.Lines VALUES (1)",
+		"INSERT INTO sales.Lines VALUES (1)",
+		"SELECT 'Lines'",
+	}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %q, want %q", batches, want)
+	}
+
+	// Variables outlast the script
+	if v, _ := p.Variable("TABLE"); v != "Lines" {
+		t.Errorf("Table = %q after the script", v)
+	}
+}
+
+func TestProcessor_Variables(t *testing.T) {
+	env := map[string]string{"HOME_DB": "master"}
+	p := New(Options{Lookup: func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}})
+
+	batches, err := record(t, p, `:setvar Quote "say ""hi"""
+SELECT '$(Quote)', '$(HOME_DB)'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`SELECT 'say "hi"', 'master'`}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %q, want %q", batches, want)
+	}
+
+	for name, script := range map[string]string{
+		"undefined":     "SELECT $(Missing)",
+		"unset":         ":setvar x 1\n:setvar x\nSELECT $(x)",
+		"bad name":      ":setvar 1x 1",
+		"unterminated":  `:setvar x "open`,
+		"unsupported":   ":connect otherhost",
+		"bad GO count":  "SELECT 1\nGO 0",
+		"bad on error":  ":on error stop",
+		"include off":   ":r other.sql",
+		"bad exit form": ":exit now",
+	} {
+		p := New(Options{DisableIncludes: true})
+		if _, err := record(t, p, script); err == nil {
+			t.Errorf("%s: Run succeeded", name)
+		} else if !strings.Contains(err.Error(), "test.sql: line ") {
+			t.Errorf("%s: error %q does not give the line", name, err)
+		}
+	}
+}
+
+func TestProcessor_OnError(t *testing.T) {
+	script := `SELECT 1
+GO
+RAISERROR('boom', 16, 1)
+GO
+SELECT 2
+:on error exit
+RAISERROR('boom', 16, 1)
+GO
+SELECT 3`
+	exec := func(ran *[]string) func(string) error {
+		return func(batch string) error {
+			batch = strings.TrimSpace(batch)
+			*ran = append(*ran, batch)
+			if strings.Contains(batch, "RAISERROR") {
+				return errors.New("boom")
+			}
+			return nil
+		}
+	}
+
+	var ran []string
+	var ignored []error
+	p := New(Options{OnError: func(err error) { ignored = append(ignored, err) }})
+	err := p.Run(script, "deploy.sql", exec(&ran))
+
+	var be *BatchError
+	if !errors.As(err, &be) || be.Line != 5 || be.Source != "deploy.sql" {
+		t.Fatalf("got %v, want the failure of the batch at line 5", err)
+	}
+	if len(ran) != 3 || len(ignored) != 1 {
+		t.Errorf("ran %q and ignored %d errors", ran, len(ignored))
+	}
+
+	// -b stops at the first failure
+	ran = nil
+	p = New(Options{ExitOnError: true})
+	if err := p.Run(script, "deploy.sql", exec(&ran)); err == nil || len(ran) != 2 {
+		t.Errorf("ran %q with -b, error %v", ran, err)
+	}
+}
+
+func TestProcessor_Exit(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []string
+	}{
+		{"SELECT 1\nGO\nSELECT 2\n:exit\nSELECT 3", []string{"SELECT 1"}},
+		{"SELECT 1\n:quit", nil},
+		{"SELECT 1\n:exit()\nSELECT 2", []string{"SELECT 1"}},
+		{"SELECT 1\n:EXIT(SELECT 9)", []string{"SELECT 1", "SELECT 9"}},
+		{"SELECT 1\n:reset\nSELECT 2", []string{"SELECT 2"}},
+	}
+	for _, tt := range tests {
+		batches, err := record(t, New(Options{}), tt.script)
+		if err != nil {
+			t.Errorf("%q: %v", tt.script, err)
+			continue
+		}
+		if !reflect.DeepEqual(batches, tt.want) {
+			t.Errorf("%q: batches = %q, want %q", tt.script, batches, tt.want)
+		}
+	}
+}
+
+func TestProcessor_Include(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "tables.sql"), []byte("CREATE TABLE $(Name) (id INT)\nGO\n:r views.sql\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "views.sql"), []byte("CREATE VIEW v AS SELECT 1 AS x\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "loop.sql"), []byte(":r loop.sql\n"), 0600)
+
+	p := New(Options{IncludeDir: dir, ConfineIncludes: true})
+	batches, err := record(t, p, ":setvar Name t1\n:r tables.sql\nGO\n:r \"views.sql\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"CREATE TABLE t1 (id INT)",
+		"CREATE VIEW v AS SELECT 1 AS x",
+		"CREATE VIEW v AS SELECT 1 AS x",
+	}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %q, want %q", batches, want)
+	}
+
+	for _, script := range []string{":r ../secret.sql", ":r /etc/passwd", ":r loop.sql", ":r missing.sql"} {
+		if _, err := record(t, p, script); err == nil {
+			t.Errorf("%q succeeded", script)
+		}
+	}
+}
+
+func TestIsScript(t *testing.T) {
+	tests := map[string]bool{
+		"SELECT 1":                         false,
+		"SELECT 'a:b' AS [x:y]":            false,
+		"CREATE TABLE good (id INT)":       false,
+		"SELECT 1\nGO":                     true,
+		"SELECT 1\r\n  go 3\r\n":           true,
+		":setvar x 1\nSELECT $(x)":         true,
+		"SELECT * FROM t WHERE a = '$(v)'": true,
+	}
+	for sql, want := range tests {
+		if got := IsScript(sql); got != want {
+			t.Errorf("IsScript(%q) = %v, want %v", sql, got, want)
+		}
+	}
+}
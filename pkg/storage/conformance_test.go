@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/storage/storagetest"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) runtime.StorageBackend {
+		store, err := NewInMemorySQLiteStorage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
@@ -10,8 +10,6 @@ import (
 	"strings"
 	"sync"
 
-	_ "github.com/mattn/go-sqlite3"
-
 	"github.com/ha1tch/aul/pkg/annotations"
 )
 
@@ -150,7 +148,7 @@ func (m *IsolatedTableManager) GetConnection(database, schema, table string) (*s
 	dbPath := m.tablePath(database, schema, table)
 	dsn := m.buildDSN(dbPath, meta.Annotations)
 
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := sql.Open(sqliteDriver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open isolated table database: %w", err)
 	}
@@ -231,7 +229,7 @@ func (m *IsolatedTableManager) CreateTable(ctx context.Context, database, schema
 
 	// Create and open the database
 	dsn := m.buildDSN(dbPath, ann)
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := sql.Open(sqliteDriver, dsn)
 	if err != nil {
 		return fmt.Errorf("failed to create isolated table database: %w", err)
 	}
@@ -9,10 +9,9 @@ import (
 	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
-
 	"github.com/ha1tch/aul/pkg/procedure"
 	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
 )
 
 // SQLiteStorage provides a SQLite storage backend.
@@ -44,6 +43,9 @@ type SQLiteConfig struct {
 	Synchronous string // OFF, NORMAL, FULL, EXTRA
 	CacheSize   int    // Number of pages (negative = KB)
 	BusyTimeout int    // Milliseconds
+
+	// Attach the cache database archived partitions are restored into
+	ArchiveCache bool
 }
 
 // DefaultSQLiteConfig returns sensible defaults for SQLite.
@@ -85,7 +87,11 @@ func NewSQLiteStorage(cfg SQLiteConfig) (*SQLiteStorage, error) {
 		dsn = dsn + "?" + strings.Join(opts, "&")
 	}
 
-	db, err := sql.Open("sqlite3", dsn)
+	driverName := sqliteDriver
+	if cfg.ArchiveCache {
+		driverName = sqliteArchiveDriver
+	}
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
 	}
@@ -366,6 +372,105 @@ func (s *SQLiteStorage) GetDB() *sql.DB {
 	return s.db
 }
 
+// RefreshStatistics analyzes the tables whose row counts have moved by
+// more than threshold since they were last analyzed, then lets SQLite's
+// PRAGMA optimize analyze any others its query planner would benefit
+// from. It returns the tables analyzed by row count.
+func (s *SQLiteStorage) RefreshStatistics(ctx context.Context, threshold float64) ([]string, error) {
+	tables, err := tsqlruntime.RefreshStatistics(ctx, s.db, threshold)
+	if err != nil {
+		return tables, err
+	}
+	if _, err := s.db.ExecContext(ctx, "PRAGMA optimize"); err != nil {
+		return tables, fmt.Errorf("PRAGMA optimize failed: %w", err)
+	}
+	return tables, nil
+}
+
+// TableIndexes returns the key columns of each index of table, its
+// primary key first, for the index advisor.
+func (s *SQLiteStorage) TableIndexes(ctx context.Context, table string) ([][]string, error) {
+	return tsqlruntime.TableIndexes(ctx, s.db, table)
+}
+
+// CreateIndex creates an index the index advisor recommends. SQLite has
+// no INCLUDE, so included columns follow the key columns, which also
+// makes the index covering.
+func (s *SQLiteStorage) CreateIndex(ctx context.Context, name, table string, columns []string) error {
+	quote := func(name string) string {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = quote(col)
+	}
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)",
+		quote(name), quote(table), strings.Join(quoted, ", ")))
+	return err
+}
+
+// Tables returns the names of the user tables, without aul's own.
+func (s *SQLiteStorage) Tables(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND `+tsqlruntime.UserTableFilter()+` ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// TableColumns returns the columns of table, typed by the T-SQL types
+// their SQLite types report as.
+func (s *SQLiteStorage) TableColumns(ctx context.Context, table string) ([]runtime.ColumnInfo, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info('%s')", strings.ReplaceAll(table, "'", "''")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []runtime.ColumnInfo
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, runtime.ColumnInfo{
+			Name:     name,
+			Type:     mapSQLiteType(typ),
+			Nullable: notNull == 0,
+			Ordinal:  cid,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table not found: %s", table)
+	}
+	return columns, nil
+}
+
+// BackendType returns the SQLite type a T-SQL type is stored as.
+func (s *SQLiteStorage) BackendType(sqlType string) string {
+	return mapTypeToSQLite(sqlType)
+}
+
+// SQLType returns the T-SQL type name of a SQLite column type.
+func (s *SQLiteStorage) SQLType(backendType string) string {
+	return mapSQLiteType(backendType)
+}
+
 // GetTx returns the transaction for a given context, if one exists.
 func (s *SQLiteStorage) GetTx(txnID string) *sql.Tx {
 	s.mu.RLock()
@@ -377,7 +482,16 @@ func (s *SQLiteStorage) GetTx(txnID string) *sql.Tx {
 func (s *SQLiteStorage) SetRegistry(registry *procedure.Registry) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	rt := s.sysCatalog.runtime
 	s.sysCatalog = NewSystemCatalog(registry)
+	s.sysCatalog.runtime = rt
+}
+
+// SetRuntime sets the runtime whose state the sys.dm_aul_* views report.
+func (s *SQLiteStorage) SetRuntime(rt *runtime.Runtime) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sysCatalog.runtime = rt
 }
 
 // scanResultSet scans rows into a ResultSet.
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/ha1tch/aul/pkg/archive"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+// sqliteDriver is the driver SQLite databases are opened with: go-sqlite3,
+// with the T-SQL functions SQLite lacks, and the external functions of
+// extensions, registered on every connection so that queries the backend
+// runs can call them.
+const sqliteDriver = "aul_sqlite3"
+
+// sqliteArchiveDriver is sqliteDriver, attaching to every connection the
+// cache database archived partitions are restored into (see pkg/archive):
+// the database's file with -archive appended, or an in-memory database.
+const sqliteArchiveDriver = "aul_sqlite3_archive"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{ConnectHook: registerFunctions})
+	sql.Register(sqliteArchiveDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := registerFunctions(conn); err != nil {
+				return err
+			}
+			return attachArchiveCache(conn)
+		},
+	})
+}
+
+func registerFunctions(conn *sqlite3.SQLiteConn) error {
+	for name, fn := range tsqlruntime.SQLiteFunctions {
+		if err := conn.RegisterFunc(name, fn, true); err != nil {
+			return err
+		}
+	}
+	// Extensions' functions, which may keep state between calls
+	for name, fn := range tsqlruntime.ExternalFunctions() {
+		if err := conn.RegisterFunc(name, tsqlruntime.SQLiteExternalFunction(fn), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachArchiveCache attaches the archive cache database beside the main
+// database of conn.
+func attachArchiveCache(conn *sqlite3.SQLiteConn) error {
+	rows, err := conn.Query("PRAGMA database_list", nil)
+	if err != nil {
+		return err
+	}
+	path := ""
+	values := make([]driver.Value, 3)
+	for {
+		if err := rows.Next(values); err != nil {
+			if err != io.EOF {
+				rows.Close()
+				return err
+			}
+			break
+		}
+		if driverString(values[1]) == "main" {
+			path = driverString(values[2])
+		}
+	}
+	rows.Close()
+	cache := ":memory:"
+	if path != "" {
+		cache = path + "-archive"
+	}
+	_, err = conn.Exec("ATTACH DATABASE ? AS "+archive.CacheSchema, []driver.Value{cache})
+	return err
+}
+
+func driverString(v driver.Value) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
+)
+
+func TestSQLiteDriver_Compress(t *testing.T) {
+	store, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	interp := tsqlruntime.NewInterpreter(store.GetDB(), tsqlruntime.DialectSQLite)
+	for _, sql := range []string{
+		"CREATE TABLE docs (id INT, body NVARCHAR(MAX))",
+		"CREATE TABLE archive (id INT, data VARBINARY(MAX))",
+		"INSERT INTO docs VALUES (1, 'first draft'), (2, NULL)",
+		// The backend compresses
+		"INSERT INTO archive SELECT id, COMPRESS(body) FROM docs",
+		// The evaluator compresses, the backend decompresses
+		"DECLARE @c VARBINARY(MAX) = COMPRESS('second draft'); INSERT INTO archive VALUES (3, @c)",
+	} {
+		if _, err := interp.Execute(ctx, sql, nil); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	result, err := interp.Execute(ctx, "SELECT id, CAST(DECOMPRESS(data) AS NVARCHAR(MAX)), DECOMPRESS(CAST('x' AS VARBINARY(10))) "+
+		"FROM archive ORDER BY id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := result.ResultSets[0].Rows
+	want := [][]string{{"1", "first draft", "NULL"}, {"2", "NULL", "NULL"}, {"3", "second draft", "NULL"}}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i, row := range rows {
+		for j, v := range row {
+			got := "NULL"
+			if !v.IsNull {
+				got = v.AsString()
+			}
+			if got != want[i][j] {
+				t.Errorf("row %d column %d: %q, want %q", i, j, got, want[i][j])
+			}
+		}
+	}
+}
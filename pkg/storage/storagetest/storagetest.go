@@ -0,0 +1,358 @@
+// Package storagetest checks storage backends against the contract of
+// runtime.StorageBackend, for the built-in backends and for the authors
+// of storage plugins. A backend's tests call Run:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) runtime.StorageBackend {
+//			store, err := mybackend.Open(testConfig)
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return store
+//		})
+//	}
+//
+// The checks use table names starting with storagetest_, which they drop
+// first, so they may run against a scratch database that persists.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/runtime"
+)
+
+// Opener opens a backend for one check. Run closes it.
+type Opener func(t *testing.T) runtime.StorageBackend
+
+// Run runs the conformance checks, each as a subtest with a backend of
+// its own. The checks of CatalogBackend and TypeMapper run only for
+// backends that implement them.
+func Run(t *testing.T, open Opener) {
+	checks := []struct {
+		name  string
+		check func(t *testing.T, s runtime.StorageBackend)
+	}{
+		{"Dialect", testDialect},
+		{"QueryExec", testQueryExec},
+		{"QueryErrors", testQueryErrors},
+		{"Cancelled", testCancelled},
+		{"Concurrent", testConcurrent},
+		{"Transactions", testTransactions},
+		{"Savepoints", testSavepoints},
+		{"TempTables", testTempTables},
+		{"Catalog", testCatalog},
+		{"TypeMapper", testTypeMapper},
+	}
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			s := open(t)
+			defer func() {
+				if err := s.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			}()
+			c.check(t, s)
+		})
+	}
+}
+
+// itemsTable is created by createItems.
+const itemsTable = "storagetest_items"
+
+// createItems creates itemsTable and inserts rows (1, 'one') to
+// (n, 'n'), using the placeholders of the backend's dialect.
+func createItems(t *testing.T, s runtime.StorageBackend, n int) {
+	t.Helper()
+	ctx := context.Background()
+	s.Exec(ctx, "DROP TABLE IF EXISTS "+itemsTable)
+	if _, err := s.Exec(ctx, "CREATE TABLE "+itemsTable+" (id INTEGER NOT NULL, name VARCHAR(50))"); err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (id, name) VALUES (%s, %s)",
+		itemsTable, placeholder(s.Dialect(), 1), placeholder(s.Dialect(), 2))
+	for i := 1; i <= n; i++ {
+		affected, err := s.Exec(ctx, insert, i, fmt.Sprintf("item %d", i))
+		if err != nil {
+			t.Fatalf("INSERT: %v", err)
+		}
+		if affected != 1 {
+			t.Fatalf("INSERT affected %d rows, want 1", affected)
+		}
+	}
+}
+
+// placeholder returns the nth placeholder of a dialect.
+func placeholder(dialect string, n int) string {
+	switch dialect {
+	case "postgres":
+		return fmt.Sprintf("$%d", n)
+	case "sqlserver", "tsql":
+		return fmt.Sprintf("@p%d", n)
+	}
+	return "?"
+}
+
+func testDialect(t *testing.T, s runtime.StorageBackend) {
+	if s.Dialect() == "" {
+		t.Error("Dialect is empty")
+	}
+	db := s.GetDB()
+	if db == nil {
+		t.Fatal("GetDB is nil: procedures could not reach the backend")
+	}
+	if err := db.PingContext(context.Background()); err != nil {
+		t.Errorf("GetDB().Ping: %v", err)
+	}
+}
+
+func testQueryExec(t *testing.T, s runtime.StorageBackend) {
+	ctx := context.Background()
+	createItems(t, s, 3)
+
+	results, err := s.Query(ctx, "SELECT id, name FROM "+itemsTable+" ORDER BY id")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Query returned %d result sets, want 1", len(results))
+	}
+	rs := results[0]
+	if len(rs.Columns) != 2 || !strings.EqualFold(rs.Columns[0].Name, "id") || !strings.EqualFold(rs.Columns[1].Name, "name") {
+		t.Errorf("columns = %+v, want id and name", rs.Columns)
+	}
+	for i, col := range rs.Columns {
+		if col.Ordinal != i {
+			t.Errorf("column %s has ordinal %d, want %d", col.Name, col.Ordinal, i)
+		}
+	}
+	if len(rs.Rows) != 3 {
+		t.Fatalf("Query returned %d rows, want 3", len(rs.Rows))
+	}
+	if got := fmt.Sprint(rs.Rows[2][0]); got != "3" {
+		t.Errorf("id = %s, want 3", got)
+	}
+	if got := text(rs.Rows[2][1]); got != "item 3" {
+		t.Errorf("name = %q, want %q", got, "item 3")
+	}
+
+	row, err := s.QueryRow(ctx, "SELECT name FROM "+itemsTable+" WHERE id = "+placeholder(s.Dialect(), 1), 2)
+	if err != nil {
+		t.Fatalf("QueryRow: %v", err)
+	}
+	if len(row) != 1 || text(row[0]) != "item 2" {
+		t.Errorf("QueryRow = %v, want [item 2]", row)
+	}
+	row, err = s.QueryRow(ctx, "SELECT name FROM "+itemsTable+" WHERE id = 99")
+	if err != nil || row != nil {
+		t.Errorf("QueryRow of no rows = %v, %v; want nil, nil", row, err)
+	}
+
+	affected, err := s.Exec(ctx, "UPDATE "+itemsTable+" SET name = 'changed' WHERE id > 1")
+	if err != nil {
+		t.Fatalf("UPDATE: %v", err)
+	}
+	if affected != 2 {
+		t.Errorf("UPDATE affected %d rows, want 2", affected)
+	}
+}
+
+func testQueryErrors(t *testing.T, s runtime.StorageBackend) {
+	ctx := context.Background()
+	if _, err := s.Query(ctx, "SELECT * FROM storagetest_missing"); err == nil {
+		t.Error("Query of a missing table succeeded")
+	}
+	if _, err := s.Exec(ctx, "INSERT INTO storagetest_missing VALUES (1)"); err == nil {
+		t.Error("Exec on a missing table succeeded")
+	}
+}
+
+func testCancelled(t *testing.T, s runtime.StorageBackend) {
+	createItems(t, s, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Query(ctx, "SELECT id FROM "+itemsTable); err == nil {
+		t.Error("Query with a cancelled context succeeded")
+	}
+	if _, err := s.Exec(ctx, "DELETE FROM "+itemsTable); err == nil {
+		t.Error("Exec with a cancelled context succeeded")
+	}
+}
+
+func testConcurrent(t *testing.T, s runtime.StorageBackend) {
+	createItems(t, s, 10)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				results, err := s.Query(context.Background(), "SELECT COUNT(*) FROM "+itemsTable)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if got := fmt.Sprint(results[0].Rows[0][0]); got != "10" {
+					errs <- fmt.Errorf("COUNT(*) = %s, want 10", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func testTransactions(t *testing.T, s runtime.StorageBackend) {
+	ctx := context.Background()
+	txn, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if txn.ID == "" || txn.State != runtime.TxnActive {
+		t.Errorf("Begin = %+v, want an active transaction with an ID", txn)
+	}
+	if err := s.Commit(ctx, txn); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if txn.State != runtime.TxnCommitted {
+		t.Errorf("state after Commit = %s", txn.State)
+	}
+	if err := s.Commit(ctx, txn); err == nil {
+		t.Error("Commit of a committed transaction succeeded")
+	}
+
+	txn, err = s.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if err := s.Rollback(ctx, txn); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if txn.State != runtime.TxnRolledBack {
+		t.Errorf("state after Rollback = %s", txn.State)
+	}
+	if err := s.Rollback(ctx, txn); err == nil {
+		t.Error("Rollback of a rolled back transaction succeeded")
+	}
+
+	if err := s.Commit(ctx, nil); err != nil {
+		t.Errorf("Commit(nil) = %v", err)
+	}
+	if err := s.Rollback(ctx, nil); err != nil {
+		t.Errorf("Rollback(nil) = %v", err)
+	}
+}
+
+func testSavepoints(t *testing.T, s runtime.StorageBackend) {
+	ctx := context.Background()
+	if err := s.Savepoint(ctx, nil, "sp"); err == nil {
+		t.Error("Savepoint outside a transaction succeeded")
+	}
+	txn, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer s.Rollback(ctx, txn)
+	for _, name := range []string{"sp1", "sp2", "sp3"} {
+		if err := s.Savepoint(ctx, txn, name); err != nil {
+			t.Fatalf("Savepoint %s: %v", name, err)
+		}
+	}
+	if err := s.RollbackTo(ctx, txn, "sp2"); err != nil {
+		t.Fatalf("RollbackTo: %v", err)
+	}
+	if got := strings.Join(txn.Savepoints, ","); got != "sp1,sp2" {
+		t.Errorf("savepoints after RollbackTo sp2 = %s, want sp1,sp2", got)
+	}
+}
+
+func testTempTables(t *testing.T, s runtime.StorageBackend) {
+	ctx := context.Background()
+	const name = "storagetest_temp"
+	columns := []runtime.ColumnInfo{
+		{Name: "id", Type: "INT", Ordinal: 0},
+		{Name: "label", Type: "NVARCHAR", Length: 20, Nullable: true, Ordinal: 1},
+	}
+	if err := s.CreateTempTable(ctx, name, columns); err != nil {
+		t.Fatalf("CreateTempTable: %v", err)
+	}
+	if !s.TempTableExists(ctx, name) {
+		t.Error("TempTableExists is false after CreateTempTable")
+	}
+	if err := s.DropTempTable(ctx, name); err != nil {
+		t.Fatalf("DropTempTable: %v", err)
+	}
+	if s.TempTableExists(ctx, name) {
+		t.Error("TempTableExists is true after DropTempTable")
+	}
+}
+
+func testCatalog(t *testing.T, s runtime.StorageBackend) {
+	catalog, ok := s.(runtime.CatalogBackend)
+	if !ok {
+		t.Skip("not a CatalogBackend")
+	}
+	ctx := context.Background()
+	createItems(t, s, 0)
+
+	tables, err := catalog.Tables(ctx)
+	if err != nil {
+		t.Fatalf("Tables: %v", err)
+	}
+	found := false
+	for _, table := range tables {
+		found = found || strings.EqualFold(table, itemsTable)
+	}
+	if !found {
+		t.Errorf("Tables = %v, want %s among them", tables, itemsTable)
+	}
+
+	columns, err := catalog.TableColumns(ctx, itemsTable)
+	if err != nil {
+		t.Fatalf("TableColumns: %v", err)
+	}
+	if len(columns) != 2 || !strings.EqualFold(columns[0].Name, "id") || !strings.EqualFold(columns[1].Name, "name") {
+		t.Fatalf("TableColumns = %+v, want id and name", columns)
+	}
+	if columns[0].Nullable || !columns[1].Nullable {
+		t.Errorf("nullability = %v, %v; want false, true", columns[0].Nullable, columns[1].Nullable)
+	}
+	if _, err := catalog.TableColumns(ctx, "storagetest_missing"); err == nil {
+		t.Error("TableColumns of a missing table succeeded")
+	}
+}
+
+func testTypeMapper(t *testing.T, s runtime.StorageBackend) {
+	mapper, ok := s.(runtime.TypeMapper)
+	if !ok {
+		t.Skip("not a TypeMapper")
+	}
+	for _, sqlType := range []string{"INT", "BIGINT", "BIT", "DECIMAL", "FLOAT", "VARCHAR", "NVARCHAR", "DATETIME2", "VARBINARY", "UNIQUEIDENTIFIER"} {
+		if mapper.BackendType(sqlType) == "" {
+			t.Errorf("BackendType(%s) is empty", sqlType)
+		}
+	}
+	// Integers and Unicode strings keep their types through the backend
+	for _, sqlType := range []string{"INT", "NVARCHAR"} {
+		if got := mapper.SQLType(mapper.BackendType(sqlType)); got != sqlType {
+			t.Errorf("SQLType(BackendType(%s)) = %s", sqlType, got)
+		}
+	}
+}
+
+// text returns a string column value, which drivers may scan as bytes.
+func text(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
@@ -6,12 +6,17 @@ package storage
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/ha1tch/aul/pkg/auth"
+	"github.com/ha1tch/aul/pkg/mail"
 	"github.com/ha1tch/aul/pkg/procedure"
 	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
 )
 
 // objectIDForName generates a consistent object_id for a given object name.
@@ -40,6 +45,9 @@ type SystemCatalog struct {
 
 	// Schema mappings (schema_id -> name)
 	schemas map[int]string
+
+	// Runtime for the sys.dm_aul_* views (nil when not wired)
+	runtime *runtime.Runtime
 }
 
 // NewSystemCatalog creates a new system catalog.
@@ -69,6 +77,7 @@ func (sc *SystemCatalog) IsSystemQuery(sql string) bool {
 		strings.Contains(normalized, "sys.databases") ||
 		strings.Contains(normalized, "sys.indexes") ||
 		strings.Contains(normalized, "sys.index_columns") ||
+		strings.Contains(normalized, "sys.stats") ||
 		strings.Contains(normalized, "sys.key_constraints") ||
 		strings.Contains(normalized, "sys.foreign_keys") ||
 		strings.Contains(normalized, "sys.foreign_key_columns") ||
@@ -77,6 +86,7 @@ func (sc *SystemCatalog) IsSystemQuery(sql string) bool {
 		strings.Contains(normalized, "sys.computed_columns") ||
 		strings.Contains(normalized, "sys.identity_columns") ||
 		strings.Contains(normalized, "sys.extended_properties") ||
+		strings.Contains(normalized, "sys.sensitivity_classifications") ||
 		strings.Contains(normalized, "sys.sql_modules") ||
 		strings.Contains(normalized, "sys.parameters") ||
 		strings.Contains(normalized, "sys.triggers") ||
@@ -85,6 +95,22 @@ func (sc *SystemCatalog) IsSystemQuery(sql string) bool {
 		strings.Contains(normalized, "sys.partitions") ||
 		strings.Contains(normalized, "sys.allocation_units") ||
 		strings.Contains(normalized, "sys.master_files") ||
+		strings.Contains(normalized, "sys.database_principals") ||
+		strings.Contains(normalized, "sys.database_role_members") ||
+		strings.Contains(normalized, "sys.server_principals") ||
+		strings.Contains(normalized, "sys.server_role_members") ||
+		strings.Contains(normalized, "sys.dm_aul_circuit_breakers") ||
+		strings.Contains(normalized, "sys.dm_aul_shadow_procedures") ||
+		strings.Contains(normalized, "sys.dm_aul_admission_queues") ||
+		strings.Contains(normalized, "sys.dm_aul_memory_usage") ||
+		strings.Contains(normalized, "sys.dm_aul_recovery") ||
+		strings.Contains(normalized, "sys.dm_aul_deadlocks") ||
+		strings.Contains(normalized, "sys.dm_aul_blocking") ||
+		strings.Contains(normalized, "sys.dm_aul_pinned_tables") ||
+		strings.Contains(normalized, "sys.dm_tran_locks") ||
+		strings.Contains(normalized, "sys.dm_exec_requests") ||
+		strings.Contains(normalized, "sys.dm_db_missing_index_") ||
+		strings.Contains(normalized, "sysmail_") ||
 		strings.Contains(normalized, "information_schema.")
 }
 
@@ -94,6 +120,44 @@ func (sc *SystemCatalog) ExecuteSystemQuery(ctx context.Context, db interface{ Q
 
 	// Route to appropriate handler - order matters for overlapping names
 	switch {
+	case strings.Contains(normalized, "sys.dm_aul_circuit_breakers"):
+		return sc.queryCircuitBreakers(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_aul_shadow_procedures"):
+		return sc.queryShadowProcedures(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_aul_admission_queues"):
+		return sc.queryAdmissionQueues(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_aul_memory_usage"):
+		return sc.queryMemoryUsage(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_aul_recovery"):
+		return sc.queryRecovery(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_aul_deadlocks"):
+		return sc.queryDeadlocks(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_aul_blocking"):
+		return sc.queryBlocking(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_aul_pinned_tables"):
+		return sc.queryPinnedTables(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_tran_locks"):
+		return sc.queryTranLocks(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_exec_requests"):
+		return sc.queryExecRequests(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_db_missing_index_group_stats"):
+		return sc.queryMissingIndexGroupStats(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_db_missing_index_groups"):
+		return sc.queryMissingIndexGroups(ctx, db, sql)
+	case strings.Contains(normalized, "sys.dm_db_missing_index_details"):
+		return sc.queryMissingIndexDetails(ctx, db, sql)
+	case strings.Contains(normalized, "sysmail_allitems"):
+		return sc.queryMailItems(ctx, db, sql, "")
+	case strings.Contains(normalized, "sysmail_sentitems"):
+		return sc.queryMailItems(ctx, db, sql, mail.StatusSent)
+	case strings.Contains(normalized, "sysmail_unsentitems"):
+		return sc.queryMailItems(ctx, db, sql, mail.StatusUnsent)
+	case strings.Contains(normalized, "sysmail_faileditems"):
+		return sc.queryMailItems(ctx, db, sql, mail.StatusFailed)
+	case strings.Contains(normalized, "sysmail_event_log"):
+		return sc.queryMailEvents(ctx, db, sql)
+	case strings.Contains(normalized, "sysmail_profile"):
+		return sc.queryMailProfiles(ctx, db, sql)
 	case strings.Contains(normalized, "sys.all_objects"):
 		return sc.queryAllObjects(ctx, db, sql)
 	case strings.Contains(normalized, "sys.all_columns"):
@@ -116,6 +180,8 @@ func (sc *SystemCatalog) ExecuteSystemQuery(ctx context.Context, db interface{ Q
 		return sc.queryIndexColumns(ctx, db, sql)
 	case strings.Contains(normalized, "sys.indexes"):
 		return sc.queryIndexes(ctx, db, sql)
+	case strings.Contains(normalized, "sys.stats"):
+		return sc.queryStats(ctx, db, sql)
 	case strings.Contains(normalized, "sys.key_constraints"):
 		return sc.queryKeyConstraints(ctx, db, sql)
 	case strings.Contains(normalized, "sys.foreign_key_columns"):
@@ -132,6 +198,8 @@ func (sc *SystemCatalog) ExecuteSystemQuery(ctx context.Context, db interface{ Q
 		return sc.queryIdentityColumns(ctx, db, sql)
 	case strings.Contains(normalized, "sys.extended_properties"):
 		return sc.queryExtendedProperties(ctx, db, sql)
+	case strings.Contains(normalized, "sys.sensitivity_classifications"):
+		return sc.querySensitivityClassifications(ctx, db, sql)
 	case strings.Contains(normalized, "sys.sql_modules"):
 		return sc.querySqlModules(ctx, db, sql)
 	case strings.Contains(normalized, "sys.parameters"):
@@ -148,6 +216,14 @@ func (sc *SystemCatalog) ExecuteSystemQuery(ctx context.Context, db interface{ Q
 		return sc.queryAllocationUnits(ctx, db, sql)
 	case strings.Contains(normalized, "sys.master_files"):
 		return sc.queryMasterFiles(ctx, db, sql)
+	case strings.Contains(normalized, "sys.database_role_members"):
+		return sc.queryDatabaseRoleMembers(ctx, db, sql)
+	case strings.Contains(normalized, "sys.database_principals"):
+		return sc.queryDatabasePrincipals(ctx, db, sql)
+	case strings.Contains(normalized, "sys.server_role_members"):
+		return sc.queryServerRoleMembers(ctx, db, sql)
+	case strings.Contains(normalized, "sys.server_principals"):
+		return sc.queryServerPrincipals(ctx, db, sql)
 	case strings.Contains(normalized, "information_schema.columns"):
 		return sc.queryInformationSchemaColumns(ctx, db, sql)
 	case strings.Contains(normalized, "information_schema.tables"):
@@ -178,7 +254,7 @@ func (sc *SystemCatalog) queryTables(ctx context.Context, db interface{ Query(co
 			CASE WHEN name LIKE '#%' THEN 2 ELSE 1 END as schema_id
 		FROM sqlite_master 
 		WHERE type = 'table' 
-		AND name NOT LIKE 'sqlite_%'
+		AND ` + tsqlruntime.UserTableFilter() + `
 		ORDER BY name
 	`
 
@@ -224,9 +300,33 @@ func (sc *SystemCatalog) queryTables(ctx context.Context, db interface{ Query(co
 		}
 	}
 
+	// Partitioned tables are views over their partitions' tables
+	for _, row := range sc.partitionedTables(ctx, db) {
+		tableName, _ := row[0].(string)
+		rs.Rows = append(rs.Rows, []interface{}{
+			tableName, objectIDForName(tableName), int64(1), "U ", "USER_TABLE", "2025-01-01", "2025-01-01", int64(0),
+		})
+	}
+	sort.SliceStable(rs.Rows, func(a, b int) bool {
+		return rs.Rows[a][0].(string) < rs.Rows[b][0].(string)
+	})
+
 	return []runtime.ResultSet{rs}, nil
 }
 
+// partitionedTables reads the name and number of partitions of each table
+// created on a partition scheme; the table listing them only exists once
+// one has been.
+func (sc *SystemCatalog) partitionedTables(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}) [][]interface{} {
+	results, err := db.Query(ctx, "SELECT table_name, partitions FROM "+tsqlruntime.PartitionedTablesTable+" ORDER BY table_name")
+	if err != nil || len(results) == 0 {
+		return nil
+	}
+	return results[0].Rows
+}
+
 // queryProcedures returns sys.procedures data from the procedure registry.
 func (sc *SystemCatalog) queryProcedures(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
 	rs := runtime.ResultSet{
@@ -317,7 +417,7 @@ func (sc *SystemCatalog) queryObjects(ctx context.Context, db interface{ Query(c
 func (sc *SystemCatalog) queryColumns(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
 	// Query SQLite for table info
 	// We need to iterate through tables and get pragma table_info for each
-	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`
+	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND ` + tsqlruntime.UserTableFilter()
 	tablesResult, err := db.Query(ctx, tablesQuery)
 	if err != nil {
 		return nil, err
@@ -549,6 +649,75 @@ func (sc *SystemCatalog) queryIndexes(ctx context.Context, db interface{ Query(c
 	return []runtime.ResultSet{rs}, nil
 }
 
+// queryStats returns sys.stats data: one statistics object per table,
+// stats_id 1 and named after it, which ANALYZE gathers. last_updated and
+// rows, from aul_stats, are aul's additions after
+// sys.dm_db_stats_properties: when UPDATE STATISTICS or the scheduled
+// refresh last analyzed the table, and its row count then.
+func (sc *SystemCatalog) queryStats(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND ` + tsqlruntime.UserTableFilter() + ` ORDER BY name`
+	tablesResult, err := db.Query(ctx, tablesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "object_id", Type: "INT", Ordinal: 0},
+			{Name: "name", Type: "NVARCHAR", Ordinal: 1},
+			{Name: "stats_id", Type: "INT", Ordinal: 2},
+			{Name: "auto_created", Type: "BIT", Ordinal: 3},
+			{Name: "user_created", Type: "BIT", Ordinal: 4},
+			{Name: "no_recompute", Type: "BIT", Ordinal: 5},
+			{Name: "has_filter", Type: "BIT", Ordinal: 6},
+			{Name: "filter_definition", Type: "NVARCHAR", Ordinal: 7, Nullable: true},
+			{Name: "is_temporary", Type: "BIT", Ordinal: 8},
+			{Name: "is_incremental", Type: "BIT", Ordinal: 9},
+			{Name: "last_updated", Type: "NVARCHAR", Ordinal: 10, Nullable: true},
+			{Name: "rows", Type: "BIGINT", Ordinal: 11, Nullable: true},
+		},
+	}
+	if len(tablesResult) == 0 {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	// aul_stats is created by the first ANALYZE, so may be missing
+	updated := make(map[string][]interface{})
+	if statsResult, err := db.Query(ctx, "SELECT table_name, last_updated, row_count FROM "+tsqlruntime.StatisticsTable); err == nil && len(statsResult) > 0 {
+		for _, row := range statsResult[0].Rows {
+			if name, ok := row[0].(string); ok {
+				updated[name] = row[1:]
+			}
+		}
+	}
+
+	for _, row := range tablesResult[0].Rows {
+		tableName := row[0].(string)
+		var lastUpdated, rows interface{}
+		if u, ok := updated[tableName]; ok {
+			lastUpdated, rows = u[0], u[1]
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			objectIDForName(tableName),      // object_id
+			tableName,                       // name
+			int64(tsqlruntime.TableStatsID), // stats_id
+			int64(1),                        // auto_created
+			int64(0),                        // user_created
+			int64(0),                        // no_recompute
+			int64(0),                        // has_filter
+			nil,                             // filter_definition
+			int64(0),                        // is_temporary
+			int64(0),                        // is_incremental
+			lastUpdated,                     // last_updated
+			rows,                            // rows
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
 // queryIndexColumns returns sys.index_columns data.
 func (sc *SystemCatalog) queryIndexColumns(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
 	rs := runtime.ResultSet{
@@ -685,7 +854,8 @@ func (sc *SystemCatalog) queryIdentityColumns(ctx context.Context, db interface{
 	return []runtime.ResultSet{rs}, nil
 }
 
-// queryExtendedProperties returns sys.extended_properties data.
+// queryExtendedProperties returns sys.extended_properties data from the
+// aul_extended_properties table that sp_addextendedproperty maintains.
 func (sc *SystemCatalog) queryExtendedProperties(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
 	rs := runtime.ResultSet{
 		Columns: []runtime.ColumnInfo{
@@ -697,9 +867,143 @@ func (sc *SystemCatalog) queryExtendedProperties(ctx context.Context, db interfa
 			{Name: "value", Type: "SQL_VARIANT", Ordinal: 5},
 		},
 	}
+
+	// The table only exists once a property has been added
+	propsQuery := `SELECT name, value, level0type, level0name, level1type, level1name, level2type, level2name
+		FROM aul_extended_properties ORDER BY level0name, level1name, level2name, name`
+	propsResult, err := db.Query(ctx, propsQuery)
+	if err != nil || len(propsResult) == 0 {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	for _, row := range propsResult[0].Rows {
+		level := make([]string, 6)
+		for j := range level {
+			if s, ok := row[j+2].(string); ok {
+				level[j] = s
+			}
+		}
+
+		var class int64
+		var classDesc string
+		var majorID, minorID int64
+		switch {
+		case level[0] == "":
+			class, classDesc = 0, "DATABASE"
+		case level[2] == "":
+			class, classDesc = 3, "SCHEMA"
+			majorID = int64(sc.schemaNameToID(level[1]))
+		default:
+			class, classDesc = 1, "OBJECT_OR_COLUMN"
+			majorID = sc.objectIDForLevel(level[2], level[3])
+			if level[4] == "COLUMN" {
+				minorID = sc.columnIDForName(ctx, db, level[3], level[5])
+			}
+		}
+
+		rs.Rows = append(rs.Rows, []interface{}{
+			class,     // class
+			classDesc, // class_desc
+			majorID,   // major_id
+			minorID,   // minor_id
+			row[0],    // name
+			row[1],    // value
+		})
+	}
+
 	return []runtime.ResultSet{rs}, nil
 }
 
+// querySensitivityClassifications returns sys.sensitivity_classifications
+// data from the aul_sensitivity_classifications table that ADD SENSITIVITY
+// CLASSIFICATION maintains.
+func (sc *SystemCatalog) querySensitivityClassifications(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "class", Type: "INT", Ordinal: 0},
+			{Name: "class_desc", Type: "NVARCHAR", Ordinal: 1},
+			{Name: "major_id", Type: "INT", Ordinal: 2},
+			{Name: "minor_id", Type: "INT", Ordinal: 3},
+			{Name: "label", Type: "NVARCHAR", Ordinal: 4},
+			{Name: "label_id", Type: "NVARCHAR", Ordinal: 5},
+			{Name: "information_type", Type: "NVARCHAR", Ordinal: 6},
+			{Name: "information_type_id", Type: "NVARCHAR", Ordinal: 7},
+			{Name: "rank", Type: "INT", Ordinal: 8},
+			{Name: "rank_desc", Type: "NVARCHAR", Ordinal: 9},
+		},
+	}
+
+	// The table only exists once a column has been classified
+	labelsQuery := `SELECT table_name, column_name, label, label_id, information_type, information_type_id, rank
+		FROM aul_sensitivity_classifications ORDER BY table_name, column_name`
+	labelsResult, err := db.Query(ctx, labelsQuery)
+	if err != nil || len(labelsResult) == 0 {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	for _, row := range labelsResult[0].Rows {
+		table, _ := row[0].(string)
+		column, _ := row[1].(string)
+		majorID := objectIDForName(table)
+		minorID := sc.columnIDForName(ctx, db, table, column)
+		var rankDesc interface{}
+		switch row[6] {
+		case int64(0):
+			rankDesc = "NONE"
+		case int64(10):
+			rankDesc = "LOW"
+		case int64(20):
+			rankDesc = "MEDIUM"
+		case int64(30):
+			rankDesc = "HIGH"
+		case int64(40):
+			rankDesc = "CRITICAL"
+		}
+
+		rs.Rows = append(rs.Rows, []interface{}{
+			int64(1),           // class
+			"OBJECT_OR_COLUMN", // class_desc
+			majorID,            // major_id
+			minorID,            // minor_id
+			row[2],             // label
+			row[3],             // label_id
+			row[4],             // information_type
+			row[5],             // information_type_id
+			row[6],             // rank
+			rankDesc,           // rank_desc
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// objectIDForLevel returns the object_id sys.tables or sys.procedures
+// reports for an extended property's level 1 object.
+func (sc *SystemCatalog) objectIDForLevel(levelType, name string) int64 {
+	if (levelType == "PROCEDURE" || levelType == "FUNCTION") && sc.registry != nil {
+		for i, proc := range sc.registry.List() {
+			if strings.EqualFold(proc.Name, name) {
+				return int64(10000 + i)
+			}
+		}
+	}
+	return objectIDForName(name)
+}
+
+// columnIDForName returns the 1-based column_id of column in table, or 0.
+func (sc *SystemCatalog) columnIDForName(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, table, column string) int64 {
+	colResult, err := db.Query(ctx, fmt.Sprintf("PRAGMA table_info('%s')", strings.ReplaceAll(table, "'", "''")))
+	if err != nil || len(colResult) == 0 {
+		return 0
+	}
+	for _, colRow := range colResult[0].Rows {
+		if name, ok := colRow[1].(string); ok && strings.EqualFold(name, column) {
+			return colRow[0].(int64) + 1
+		}
+	}
+	return 0
+}
+
 // querySqlModules returns sys.sql_modules data.
 func (sc *SystemCatalog) querySqlModules(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
 	rs := runtime.ResultSet{
@@ -784,7 +1088,8 @@ func (sc *SystemCatalog) queryTriggers(ctx context.Context, db interface{ Query(
 	return []runtime.ResultSet{rs}, nil
 }
 
-// queryViews returns sys.views data.
+// queryViews returns sys.views data: the views materialized by CREATE
+// VIEW ... WITH SCHEMABINDING, listed in aul_materialized_views.
 func (sc *SystemCatalog) queryViews(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
 	rs := runtime.ResultSet{
 		Columns: []runtime.ColumnInfo{
@@ -796,13 +1101,237 @@ func (sc *SystemCatalog) queryViews(ctx context.Context, db interface{ Query(con
 			{Name: "is_ms_shipped", Type: "BIT", Ordinal: 5},
 		},
 	}
+
+	// The table only exists once a view has been materialized
+	viewsResult, err := db.Query(ctx, "SELECT view_name FROM aul_materialized_views ORDER BY view_name")
+	if err != nil || len(viewsResult) == 0 {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	for _, row := range viewsResult[0].Rows {
+		viewName, _ := row[0].(string)
+		rs.Rows = append(rs.Rows, []interface{}{
+			viewName,                  // name
+			objectIDForName(viewName), // object_id
+			int64(1),                  // schema_id (dbo)
+			"V ",                      // type (view)
+			"VIEW",                    // type_desc
+			int64(0),                  // is_ms_shipped
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// Principal ids SQL Server gives the principals every database has, and
+// the fixed roles. Other principals, logins and the roles CREATE ROLE
+// creates, are given ids as objects are.
+var (
+	databasePrincipalIDs = map[string]int64{
+		auth.Public:           0,
+		"dbo":                 1,
+		"guest":               2,
+		"INFORMATION_SCHEMA":  3,
+		"sys":                 4,
+		auth.DBOwner:          16384,
+		auth.DBDataReader:     16390,
+		auth.DBDataWriter:     16391,
+		auth.DBDenyDataReader: 16392,
+		auth.DBDenyDataWriter: 16393,
+	}
+	serverPrincipalIDs = map[string]int64{
+		auth.Public:   2,
+		auth.Sysadmin: 3,
+	}
+)
+
+// principalID returns the principal id of name in ids, or the id it is
+// given as a login or a role.
+func principalID(ids map[string]int64, name string) int64 {
+	if id, ok := ids[name]; ok {
+		return id
+	}
+	return objectIDForName(strings.ToLower(name))
+}
+
+// principalRows holds the logins, roles and role memberships of the
+// permission model.
+type principalRows struct {
+	logins  [][]interface{} // name, disabled, created_at, modified_at
+	roles   [][]interface{} // name, created_at
+	members [][]interface{} // role, member
+}
+
+// principals reads the logins, roles and role memberships, none of which
+// exist until SQL logins are used.
+func (sc *SystemCatalog) principals(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}) principalRows {
+	var p principalRows
+	read := func(query string) [][]interface{} {
+		results, err := db.Query(ctx, query)
+		if err != nil || len(results) == 0 {
+			return nil
+		}
+		return results[0].Rows
+	}
+	p.logins = read("SELECT name, disabled, created_at, modified_at FROM " + auth.LoginTable + " ORDER BY name")
+	p.roles = read("SELECT name, created_at FROM " + auth.RoleTable + " ORDER BY name")
+	p.members = read("SELECT role, member FROM " + auth.RoleMemberTable + " ORDER BY role, member")
+	return p
+}
+
+// principalTime formats the milliseconds a login or role was created or
+// modified at.
+func principalTime(v interface{}) interface{} {
+	ms, ok := v.(int64)
+	if !ok {
+		return nil
+	}
+	return time.UnixMilli(ms).UTC().Format("2006-01-02 15:04:05")
+}
+
+// queryDatabasePrincipals returns sys.database_principals data: the
+// principals every database has, a user for each SQL login, and the fixed
+// roles and those CREATE ROLE created.
+func (sc *SystemCatalog) queryDatabasePrincipals(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "name", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "principal_id", Type: "INT", Ordinal: 1},
+			{Name: "type", Type: "CHAR", Ordinal: 2},
+			{Name: "type_desc", Type: "NVARCHAR", Ordinal: 3},
+			{Name: "default_schema_name", Type: "NVARCHAR", Ordinal: 4, Nullable: true},
+			{Name: "create_date", Type: "NVARCHAR", Ordinal: 5, Nullable: true},
+			{Name: "modify_date", Type: "NVARCHAR", Ordinal: 6, Nullable: true},
+			{Name: "owning_principal_id", Type: "INT", Ordinal: 7, Nullable: true},
+			{Name: "is_fixed_role", Type: "BIT", Ordinal: 8},
+			{Name: "authentication_type_desc", Type: "NVARCHAR", Ordinal: 9, Nullable: true},
+		},
+	}
+	role := func(name string, created interface{}, fixed int64) {
+		rs.Rows = append(rs.Rows, []interface{}{
+			name, principalID(databasePrincipalIDs, name), "R", "DATABASE_ROLE", nil, created, created, int64(1), fixed, "NONE",
+		})
+	}
+	user := func(name string, schema interface{}, created, modified interface{}, authentication string) {
+		rs.Rows = append(rs.Rows, []interface{}{
+			name, principalID(databasePrincipalIDs, name), "S", "SQL_USER", schema, created, modified, nil, int64(0), authentication,
+		})
+	}
+
+	p := sc.principals(ctx, db)
+	role(auth.Public, nil, 0)
+	user("dbo", "dbo", nil, nil, "INSTANCE")
+	user("guest", "guest", nil, nil, "NONE")
+	user("INFORMATION_SCHEMA", nil, nil, nil, "NONE")
+	user("sys", nil, nil, nil, "NONE")
+	for _, row := range p.logins {
+		name, _ := row[0].(string)
+		user(name, "dbo", principalTime(row[2]), principalTime(row[3]), "INSTANCE")
+	}
+	for _, row := range p.roles {
+		name, _ := row[0].(string)
+		role(name, principalTime(row[1]), 0)
+	}
+	for _, name := range auth.FixedDatabaseRoles {
+		role(name, nil, 1)
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryDatabaseRoleMembers returns sys.database_role_members data: one
+// row per member of a database role, dbo in db_owner among them.
+func (sc *SystemCatalog) queryDatabaseRoleMembers(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "role_principal_id", Type: "INT", Ordinal: 0},
+			{Name: "member_principal_id", Type: "INT", Ordinal: 1},
+		},
+	}
+	rs.Rows = append(rs.Rows, []interface{}{databasePrincipalIDs[auth.DBOwner], databasePrincipalIDs["dbo"]})
+	for _, row := range sc.principals(ctx, db).members {
+		role, _ := row[0].(string)
+		member, _ := row[1].(string)
+		if role == auth.Sysadmin {
+			continue
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			principalID(databasePrincipalIDs, role), principalID(databasePrincipalIDs, member),
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryServerPrincipals returns sys.server_principals data: the server
+// roles public and sysadmin, and the SQL logins.
+func (sc *SystemCatalog) queryServerPrincipals(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "name", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "principal_id", Type: "INT", Ordinal: 1},
+			{Name: "type", Type: "CHAR", Ordinal: 2},
+			{Name: "type_desc", Type: "NVARCHAR", Ordinal: 3},
+			{Name: "is_disabled", Type: "BIT", Ordinal: 4},
+			{Name: "create_date", Type: "NVARCHAR", Ordinal: 5, Nullable: true},
+			{Name: "modify_date", Type: "NVARCHAR", Ordinal: 6, Nullable: true},
+			{Name: "default_database_name", Type: "NVARCHAR", Ordinal: 7, Nullable: true},
+			{Name: "is_fixed_role", Type: "BIT", Ordinal: 8},
+		},
+	}
+	rs.Rows = append(rs.Rows,
+		[]interface{}{auth.Public, serverPrincipalIDs[auth.Public], "R", "SERVER_ROLE", int64(0), nil, nil, nil, int64(0)},
+		[]interface{}{auth.Sysadmin, serverPrincipalIDs[auth.Sysadmin], "R", "SERVER_ROLE", int64(0), nil, nil, nil, int64(1)},
+	)
+	for _, row := range sc.principals(ctx, db).logins {
+		name, _ := row[0].(string)
+		disabled, _ := row[1].(int64)
+		rs.Rows = append(rs.Rows, []interface{}{
+			name, principalID(serverPrincipalIDs, name), "S", "SQL_LOGIN", disabled,
+			principalTime(row[2]), principalTime(row[3]), "master", int64(0),
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryServerRoleMembers returns sys.server_role_members data: one row
+// per member of sysadmin.
+func (sc *SystemCatalog) queryServerRoleMembers(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "role_principal_id", Type: "INT", Ordinal: 0},
+			{Name: "member_principal_id", Type: "INT", Ordinal: 1},
+		},
+	}
+	for _, row := range sc.principals(ctx, db).members {
+		role, _ := row[0].(string)
+		member, _ := row[1].(string)
+		if role != auth.Sysadmin {
+			continue
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			serverPrincipalIDs[auth.Sysadmin], principalID(serverPrincipalIDs, member),
+		})
+	}
+
 	return []runtime.ResultSet{rs}, nil
 }
 
 // queryPartitions returns sys.partitions data.
 func (sc *SystemCatalog) queryPartitions(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
 	// Get table info to generate partition data
-	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`
+	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND ` + tsqlruntime.UserTableFilter()
 	tablesResult, err := db.Query(ctx, tablesQuery)
 	if err != nil {
 		return nil, err
@@ -834,6 +1363,28 @@ func (sc *SystemCatalog) queryPartitions(ctx context.Context, db interface{ Quer
 		}
 	}
 
+	// A partitioned table has a partition for each of its tables, whose
+	// rows are counted
+	for _, row := range sc.partitionedTables(ctx, db) {
+		tableName, _ := row[0].(string)
+		partitions, _ := row[1].(int64)
+		objectID := objectIDForName(tableName)
+		for n := 1; n <= int(partitions); n++ {
+			var count int64
+			if counted, err := db.Query(ctx, `SELECT COUNT(*) FROM "`+tsqlruntime.PartitionTable(tableName, n)+`"`); err == nil && len(counted) > 0 && len(counted[0].Rows) > 0 {
+				count, _ = counted[0].Rows[0][0].(int64)
+			}
+			rs.Rows = append(rs.Rows, []interface{}{
+				objectID*1000 + int64(n-1), // partition_id
+				objectID,                   // object_id
+				int64(0),                   // index_id (heap)
+				int64(n),                   // partition_number
+				objectID*1000 + int64(n-1), // hobt_id
+				count,                      // rows
+			})
+		}
+	}
+
 	return []runtime.ResultSet{rs}, nil
 }
 
@@ -857,7 +1408,7 @@ func (sc *SystemCatalog) queryAllocationUnits(ctx context.Context, db interface{
 // queryAllObjects returns sys.all_objects data (similar to sys.objects but includes system objects).
 func (sc *SystemCatalog) queryAllObjects(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
 	// Query SQLite for tables
-	sqliteQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+	sqliteQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND ` + tsqlruntime.UserTableFilter() + ` ORDER BY name`
 	results, err := db.Query(ctx, sqliteQuery)
 	if err != nil {
 		return nil, err
@@ -905,7 +1456,7 @@ func (sc *SystemCatalog) queryAllObjects(ctx context.Context, db interface{ Quer
 
 // queryAllColumns returns sys.all_columns data (similar to sys.columns but includes system objects).
 func (sc *SystemCatalog) queryAllColumns(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
-	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`
+	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND ` + tsqlruntime.UserTableFilter()
 	tablesResult, err := db.Query(ctx, tablesQuery)
 	if err != nil {
 		return nil, err
@@ -1072,6 +1623,615 @@ func (sc *SystemCatalog) queryMasterFiles(ctx context.Context, db interface{ Que
 	return []runtime.ResultSet{rs}, nil
 }
 
+// queryCircuitBreakers returns sys.dm_aul_circuit_breakers data: one row
+// per procedure that has been executed since the breakers were enabled.
+func (sc *SystemCatalog) queryCircuitBreakers(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "procedure_name", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "state", Type: "TINYINT", Ordinal: 1},
+			{Name: "state_desc", Type: "NVARCHAR", Ordinal: 2},
+			{Name: "window_calls", Type: "INT", Ordinal: 3},
+			{Name: "window_failures", Type: "INT", Ordinal: 4},
+			{Name: "window_slow_calls", Type: "INT", Ordinal: 5},
+			{Name: "trip_count", Type: "BIGINT", Ordinal: 6},
+			{Name: "rejected_count", Type: "BIGINT", Ordinal: 7},
+			{Name: "last_trip_time", Type: "NVARCHAR", Ordinal: 8},
+			{Name: "last_trip_reason", Type: "NVARCHAR", Ordinal: 9},
+			{Name: "retry_after", Type: "NVARCHAR", Ordinal: 10},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil || rt.Breakers() == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	timeOrNil := func(t time.Time) interface{} {
+		if t.IsZero() {
+			return nil
+		}
+		return t.Format("2006-01-02 15:04:05")
+	}
+	for _, b := range rt.Breakers().Status() {
+		var reason interface{}
+		if b.LastReason != "" {
+			reason = b.LastReason
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			b.Procedure,             // procedure_name
+			int64(b.State),          // state
+			b.State.String(),        // state_desc
+			int64(b.Calls),          // window_calls
+			int64(b.Failures),       // window_failures
+			int64(b.SlowCalls),      // window_slow_calls
+			b.Trips,                 // trip_count
+			b.Rejected,              // rejected_count
+			timeOrNil(b.LastTripAt), // last_trip_time
+			reason,                  // last_trip_reason
+			timeOrNil(b.RetryAfter), // retry_after
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryShadowProcedures returns sys.dm_aul_shadow_procedures data: one
+// row per changed procedure running in shadow.
+func (sc *SystemCatalog) queryShadowProcedures(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "procedure_name", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "staged_time", Type: "NVARCHAR", Ordinal: 1},
+			{Name: "shadow_runs", Type: "BIGINT", Ordinal: 2},
+			{Name: "matches", Type: "BIGINT", Ordinal: 3},
+			{Name: "divergences", Type: "BIGINT", Ordinal: 4},
+			{Name: "current_avg_ms", Type: "FLOAT", Ordinal: 5},
+			{Name: "candidate_avg_ms", Type: "FLOAT", Ordinal: 6},
+			{Name: "last_divergence", Type: "NVARCHAR", Ordinal: 7},
+			{Name: "last_divergence_time", Type: "NVARCHAR", Ordinal: 8},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil || rt.Shadow() == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	for _, c := range rt.Shadow().Status() {
+		var divergence, divergedAt interface{}
+		if c.LastDivergence != "" {
+			divergence = c.LastDivergence
+			divergedAt = c.LastDivergenceAt.Format("2006-01-02 15:04:05")
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			c.Procedure,                              // procedure_name
+			c.StagedAt.Format("2006-01-02 15:04:05"), // staged_time
+			c.Runs,                                   // shadow_runs
+			c.Matches,                                // matches
+			c.Divergences,                            // divergences
+			c.CurrentAvgMs,                           // current_avg_ms
+			c.CandidateAvgMs,                         // candidate_avg_ms
+			divergence,                               // last_divergence
+			divergedAt,                               // last_divergence_time
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryAdmissionQueues returns sys.dm_aul_admission_queues data: one row
+// per admission lane.
+func (sc *SystemCatalog) queryAdmissionQueues(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "lane", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "max_concurrency", Type: "INT", Ordinal: 1},
+			{Name: "running_count", Type: "INT", Ordinal: 2},
+			{Name: "waiting_count", Type: "INT", Ordinal: 3},
+			{Name: "admitted_count", Type: "BIGINT", Ordinal: 4},
+			{Name: "queued_count", Type: "BIGINT", Ordinal: 5},
+			{Name: "rejected_count", Type: "BIGINT", Ordinal: 6},
+			{Name: "timeout_count", Type: "BIGINT", Ordinal: 7},
+			{Name: "avg_wait_ms", Type: "FLOAT", Ordinal: 8},
+			{Name: "max_wait_ms", Type: "BIGINT", Ordinal: 9},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	stats := rt.Admission().Stats()
+	for _, l := range stats.Lanes {
+		rs.Rows = append(rs.Rows, []interface{}{
+			l.Priority.String(),          // lane
+			int64(stats.Limit),           // max_concurrency
+			int64(stats.Running),         // running_count (shared by all lanes)
+			int64(l.Waiting),             // waiting_count
+			l.Admitted,                   // admitted_count
+			l.Queued,                     // queued_count
+			l.Rejected,                   // rejected_count
+			l.TimedOut,                   // timeout_count
+			l.AvgWaitMs(),                // avg_wait_ms
+			l.MaxWaitNs / 1_000_000,      // max_wait_ms
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryMemoryUsage returns sys.dm_aul_memory_usage data: a SERVER row
+// followed by one SESSION row per session with running executions.
+func (sc *SystemCatalog) queryMemoryUsage(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "scope", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "session_id", Type: "NVARCHAR", Ordinal: 1, Nullable: true},
+			{Name: "used_bytes", Type: "BIGINT", Ordinal: 2},
+			{Name: "peak_bytes", Type: "BIGINT", Ordinal: 3},
+			{Name: "limit_bytes", Type: "BIGINT", Ordinal: 4, Nullable: true},
+			{Name: "execution_count", Type: "INT", Ordinal: 5},
+			{Name: "rejected_count", Type: "BIGINT", Ordinal: 6, Nullable: true},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	// A zero limit is unlimited
+	limit := func(n int64) interface{} {
+		if n <= 0 {
+			return nil
+		}
+		return n
+	}
+
+	stats := rt.Memory().Stats()
+	executions := 0
+	for _, s := range stats.Sessions {
+		executions += s.Executions
+	}
+	rs.Rows = append(rs.Rows, []interface{}{
+		"SERVER", nil, stats.Used, stats.Peak, limit(stats.Limit), int64(executions), stats.Rejected,
+	})
+	for _, s := range stats.Sessions {
+		rs.Rows = append(rs.Rows, []interface{}{
+			"SESSION", s.SessionID, s.Used, s.Peak, limit(stats.SessionLimit), int64(s.Executions), nil,
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryPinnedTables returns sys.dm_aul_pinned_tables data: one row per
+// table pinned in the in-memory cache.
+func (sc *SystemCatalog) queryPinnedTables(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "table_name", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "state_desc", Type: "NVARCHAR", Ordinal: 1},
+			{Name: "row_count", Type: "INT", Ordinal: 2},
+			{Name: "column_count", Type: "INT", Ordinal: 3},
+			{Name: "hit_count", Type: "BIGINT", Ordinal: 4},
+			{Name: "load_count", Type: "BIGINT", Ordinal: 5},
+			{Name: "invalidation_count", Type: "BIGINT", Ordinal: 6},
+			{Name: "last_load_time", Type: "NVARCHAR", Ordinal: 7, Nullable: true},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil || rt.TableCache() == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	for _, t := range rt.TableCache().Stats() {
+		var lastLoad interface{}
+		if !t.LastLoad.IsZero() {
+			lastLoad = t.LastLoad.Format("2006-01-02 15:04:05")
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			t.Table, t.State, int64(t.Rows), int64(t.Columns), t.Hits, t.Loads, t.Invalidations, lastLoad,
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryRecovery returns sys.dm_aul_recovery data: one row per procedure
+// execution or batch the statement journal found interrupted at startup.
+func (sc *SystemCatalog) queryRecovery(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "execution_id", Type: "BIGINT", Ordinal: 0},
+			{Name: "procedure_name", Type: "NVARCHAR", Ordinal: 1},
+			{Name: "session_id", Type: "NVARCHAR", Ordinal: 2},
+			{Name: "database_name", Type: "NVARCHAR", Ordinal: 3, Nullable: true},
+			{Name: "start_time", Type: "NVARCHAR", Ordinal: 4},
+			{Name: "applied_writes", Type: "INT", Ordinal: 5},
+			{Name: "rolled_back_writes", Type: "INT", Ordinal: 6},
+			{Name: "unknown_writes", Type: "INT", Ordinal: 7},
+			{Name: "last_applied_statement", Type: "NVARCHAR", Ordinal: 8, Nullable: true},
+			{Name: "batch_text", Type: "NVARCHAR", Ordinal: 9, Nullable: true},
+			{Name: "status", Type: "TINYINT", Ordinal: 10},
+			{Name: "status_desc", Type: "NVARCHAR", Ordinal: 11},
+			{Name: "status_detail", Type: "NVARCHAR", Ordinal: 12, Nullable: true},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil || rt.Journal() == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	stringOrNil := func(s string) interface{} {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	for _, e := range rt.Journal().Interrupted() {
+		var last interface{}
+		if w := e.LastApplied(); w != nil {
+			last = w.Text
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			e.ID,                    // execution_id
+			e.Name(),                // procedure_name
+			e.SessionID,             // session_id
+			stringOrNil(e.Database), // database_name
+			e.StartedAt.Format("2006-01-02 15:04:05"), // start_time
+			int64(e.Count(runtime.WriteApplied)),      // applied_writes
+			int64(e.Count(runtime.WriteRolledBack)),   // rolled_back_writes
+			int64(e.Count(runtime.WriteUnknown)),      // unknown_writes
+			last,                                      // last_applied_statement
+			stringOrNil(e.SQL),                        // batch_text
+			int64(e.Status),                           // status
+			e.Status.String(),                         // status_desc
+			stringOrNil(e.StatusDetail),               // status_detail
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryDeadlocks returns sys.dm_aul_deadlocks data: one row per deadlock
+// broken since startup, of the most recent the lock manager keeps.
+func (sc *SystemCatalog) queryDeadlocks(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "deadlock_id", Type: "BIGINT", Ordinal: 0},
+			{Name: "detected_time", Type: "NVARCHAR", Ordinal: 1},
+			{Name: "victim_session_id", Type: "NVARCHAR", Ordinal: 2},
+			{Name: "process_count", Type: "INT", Ordinal: 3},
+			{Name: "deadlock_graph", Type: "NVARCHAR", Ordinal: 4},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	for _, d := range rt.Locks().Deadlocks() {
+		rs.Rows = append(rs.Rows, []interface{}{
+			d.ID, // deadlock_id
+			d.DetectedAt.Format("2006-01-02 15:04:05"), // detected_time
+			d.VictimSessionID,                          // victim_session_id
+			int64(d.Processes),                         // process_count
+			d.Graph,                                    // deadlock_graph
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryTranLocks returns sys.dm_tran_locks data: one row per table or
+// application lock held or waited for by a transaction or session.
+func (sc *SystemCatalog) queryTranLocks(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "resource_type", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "resource_database_name", Type: "NVARCHAR", Ordinal: 1, Nullable: true},
+			{Name: "resource_description", Type: "NVARCHAR", Ordinal: 2},
+			{Name: "request_mode", Type: "NVARCHAR", Ordinal: 3},
+			{Name: "request_type", Type: "NVARCHAR", Ordinal: 4},
+			{Name: "request_status", Type: "NVARCHAR", Ordinal: 5},
+			{Name: "request_session_id", Type: "NVARCHAR", Ordinal: 6},
+			{Name: "request_owner_type", Type: "NVARCHAR", Ordinal: 7},
+			{Name: "request_owner_id", Type: "BIGINT", Ordinal: 8},
+			{Name: "wait_time_ms", Type: "BIGINT", Ordinal: 9, Nullable: true},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	now := time.Now()
+	for _, r := range rt.Locks().Requests() {
+		var database, waitMs interface{}
+		if r.Database != "" {
+			database = r.Database
+		}
+		if r.Status == "WAIT" {
+			waitMs = now.Sub(r.Since).Milliseconds()
+		}
+		description := r.Table
+		if r.ResourceType == "APPLICATION" {
+			description = r.Resource
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			r.ResourceType, // resource_type
+			database,       // resource_database_name
+			description,    // resource_description
+			r.Mode,         // request_mode
+			"LOCK",         // request_type
+			r.Status,       // request_status
+			r.SessionID,    // request_session_id
+			r.OwnerType,    // request_owner_type
+			r.Transaction,  // request_owner_id
+			waitMs,         // wait_time_ms
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryBlocking returns sys.dm_aul_blocking data: one row per transaction
+// in a blocking chain, with the head of its chain, in the manner of
+// sp_whoisactive's blocking columns.
+func (sc *SystemCatalog) queryBlocking(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "session_id", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "blocking_session_id", Type: "NVARCHAR", Ordinal: 1, Nullable: true},
+			{Name: "head_blocker_session_id", Type: "NVARCHAR", Ordinal: 2},
+			{Name: "wait_type", Type: "NVARCHAR", Ordinal: 3, Nullable: true},
+			{Name: "wait_resource", Type: "NVARCHAR", Ordinal: 4, Nullable: true},
+			{Name: "wait_time_ms", Type: "BIGINT", Ordinal: 5, Nullable: true},
+			{Name: "blocked_session_count", Type: "INT", Ordinal: 6},
+			{Name: "open_lock_count", Type: "INT", Ordinal: 7},
+			{Name: "transaction_time_ms", Type: "BIGINT", Ordinal: 8},
+			{Name: "input_buf", Type: "NVARCHAR", Ordinal: 9},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	for _, s := range rt.Locks().Blocking() {
+		var blocking, waitType, waitResource, waitMs interface{}
+		if s.BlockingSessionID != "" {
+			blocking = s.BlockingSessionID
+			waitType = s.WaitType
+			waitResource = "TABLE: " + s.WaitResource
+			if s.ResourceType == "APPLICATION" {
+				waitResource = "APPLICATION: " + s.WaitResource
+			}
+			waitMs = s.WaitTime.Milliseconds()
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			s.SessionID,                      // session_id
+			blocking,                         // blocking_session_id
+			s.HeadBlockerID,                  // head_blocker_session_id
+			waitType,                         // wait_type
+			waitResource,                     // wait_resource
+			waitMs,                           // wait_time_ms
+			int64(s.BlockedCount),            // blocked_session_count
+			int64(s.LockCount),               // open_lock_count
+			s.TransactionTime.Milliseconds(), // transaction_time_ms
+			s.InputBuf,                       // input_buf
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryExecRequests returns sys.dm_exec_requests data: one row per
+// running execution, with the progress of a long-running statement.
+func (sc *SystemCatalog) queryExecRequests(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "session_id", Type: "NVARCHAR", Ordinal: 0},
+			{Name: "request_id", Type: "BIGINT", Ordinal: 1},
+			{Name: "start_time", Type: "DATETIME", Ordinal: 2},
+			{Name: "status", Type: "NVARCHAR", Ordinal: 3},
+			{Name: "command", Type: "NVARCHAR", Ordinal: 4},
+			{Name: "database_name", Type: "NVARCHAR", Ordinal: 5, Nullable: true},
+			{Name: "percent_complete", Type: "FLOAT", Ordinal: 6},
+			{Name: "estimated_completion_time", Type: "BIGINT", Ordinal: 7},
+			{Name: "total_elapsed_time", Type: "BIGINT", Ordinal: 8},
+			{Name: "text", Type: "NVARCHAR", Ordinal: 9},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	now := time.Now()
+	for _, r := range rt.Requests().Requests() {
+		var database interface{}
+		if r.Database != "" {
+			database = r.Database
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			r.SessionID,                         // session_id
+			r.ID,                                // request_id
+			r.Start.Format(time.DateTime),       // start_time
+			"running",                           // status
+			r.Command,                           // command
+			database,                            // database_name
+			r.PercentComplete,                   // percent_complete
+			r.EstimatedRemaining.Milliseconds(), // estimated_completion_time
+			now.Sub(r.Start).Milliseconds(),     // total_elapsed_time
+			r.Text,                              // text
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// missingIndexes returns the index advisor's recommendations, or none
+// when no runtime is wired or the advisor is off.
+func (sc *SystemCatalog) missingIndexes(ctx context.Context) ([]runtime.MissingIndex, error) {
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil {
+		return nil, nil
+	}
+	return rt.MissingIndexes(ctx)
+}
+
+// missingIndexColumns renders columns as SQL Server's missing index views
+// do, or NULL when there are none.
+func missingIndexColumns(cols []string) interface{} {
+	if len(cols) == 0 {
+		return nil
+	}
+	return "[" + strings.Join(cols, "], [") + "]"
+}
+
+// queryMissingIndexDetails returns sys.dm_db_missing_index_details data:
+// one row per index the index advisor recommends.
+func (sc *SystemCatalog) queryMissingIndexDetails(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "index_handle", Type: "INT", Ordinal: 0},
+			{Name: "database_id", Type: "SMALLINT", Ordinal: 1},
+			{Name: "object_id", Type: "INT", Ordinal: 2},
+			{Name: "equality_columns", Type: "NVARCHAR", Ordinal: 3, Nullable: true},
+			{Name: "inequality_columns", Type: "NVARCHAR", Ordinal: 4, Nullable: true},
+			{Name: "included_columns", Type: "NVARCHAR", Ordinal: 5, Nullable: true},
+			{Name: "statement", Type: "NVARCHAR", Ordinal: 6},
+		},
+	}
+
+	recs, err := sc.missingIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range recs {
+		rs.Rows = append(rs.Rows, []interface{}{
+			m.Handle,                          // index_handle
+			int64(1),                          // database_id
+			objectIDForName(m.Table),          // object_id
+			missingIndexColumns(m.Equality),   // equality_columns
+			missingIndexColumns(m.Inequality), // inequality_columns
+			missingIndexColumns(m.Included),   // included_columns
+			"[dbo].[" + m.Table + "]",         // statement
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryMissingIndexGroups returns sys.dm_db_missing_index_groups data:
+// each recommended index is a group of its own.
+func (sc *SystemCatalog) queryMissingIndexGroups(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "index_group_handle", Type: "INT", Ordinal: 0},
+			{Name: "index_handle", Type: "INT", Ordinal: 1},
+		},
+	}
+
+	recs, err := sc.missingIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range recs {
+		rs.Rows = append(rs.Rows, []interface{}{m.Handle, m.Handle})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryMissingIndexGroupStats returns sys.dm_db_missing_index_group_stats
+// data. aul does not estimate plan costs: avg_total_user_cost is the
+// average run time of the queries in milliseconds, and avg_user_impact is
+// NULL.
+func (sc *SystemCatalog) queryMissingIndexGroupStats(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "group_handle", Type: "INT", Ordinal: 0},
+			{Name: "unique_compiles", Type: "BIGINT", Ordinal: 1},
+			{Name: "user_seeks", Type: "BIGINT", Ordinal: 2},
+			{Name: "user_scans", Type: "BIGINT", Ordinal: 3},
+			{Name: "last_user_seek", Type: "DATETIME", Ordinal: 4},
+			{Name: "last_user_scan", Type: "DATETIME", Ordinal: 5, Nullable: true},
+			{Name: "avg_total_user_cost", Type: "FLOAT", Ordinal: 6},
+			{Name: "avg_user_impact", Type: "FLOAT", Ordinal: 7, Nullable: true},
+			{Name: "system_seeks", Type: "BIGINT", Ordinal: 8},
+			{Name: "system_scans", Type: "BIGINT", Ordinal: 9},
+		},
+	}
+
+	recs, err := sc.missingIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range recs {
+		lastSeek := m.LastSeek.Format("2006-01-02 15:04:05.000")
+		cost := float64(m.AvgElapsed.Microseconds()) / 1000
+		rs.Rows = append(rs.Rows, []interface{}{
+			m.Handle,         // group_handle
+			m.UniqueCompiles, // unique_compiles
+			m.Seeks,          // user_seeks
+			int64(0),         // user_scans
+			lastSeek,         // last_user_seek
+			nil,              // last_user_scan
+			cost,             // avg_total_user_cost
+			nil,              // avg_user_impact
+			int64(0),         // system_seeks
+			int64(0),         // system_scans
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
 // queryTriggerEvents returns sys.trigger_events data.
 func (sc *SystemCatalog) queryTriggerEvents(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
 	rs := runtime.ResultSet{
@@ -1090,7 +2250,7 @@ func (sc *SystemCatalog) queryTriggerEvents(ctx context.Context, db interface{ Q
 
 // queryInformationSchemaColumns returns INFORMATION_SCHEMA.COLUMNS data.
 func (sc *SystemCatalog) queryInformationSchemaColumns(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
-	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`
+	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND ` + tsqlruntime.UserTableFilter()
 	tablesResult, err := db.Query(ctx, tablesQuery)
 	if err != nil {
 		return nil, err
@@ -1263,7 +2423,7 @@ func isStringType(t string) bool {
 
 // queryInformationSchemaTables returns INFORMATION_SCHEMA.TABLES data.
 func (sc *SystemCatalog) queryInformationSchemaTables(ctx context.Context, db interface{ Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error) }, sql string) ([]runtime.ResultSet, error) {
-	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+	tablesQuery := `SELECT name FROM sqlite_master WHERE type = 'table' AND ` + tsqlruntime.UserTableFilter() + ` ORDER BY name`
 	tablesResult, err := db.Query(ctx, tablesQuery)
 	if err != nil {
 		return nil, err
@@ -1418,3 +2578,156 @@ func (sc *SystemCatalog) queryInformationSchemaEmpty(ctx context.Context, db int
 	}
 	return []runtime.ResultSet{rs}, nil
 }
+
+// queryMailItems returns msdb.dbo.sysmail_allitems data: one row per
+// message queued by sp_send_dbmail, oldest first. With status it returns
+// only the messages in that state, as sysmail_sentitems,
+// sysmail_unsentitems (which includes those being retried) and
+// sysmail_faileditems do.
+func (sc *SystemCatalog) queryMailItems(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string, status string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "mailitem_id", Type: "INT", Ordinal: 0},
+			{Name: "profile_id", Type: "INT", Ordinal: 1},
+			{Name: "recipients", Type: "NVARCHAR", Ordinal: 2, Nullable: true},
+			{Name: "copy_recipients", Type: "NVARCHAR", Ordinal: 3, Nullable: true},
+			{Name: "blind_copy_recipients", Type: "NVARCHAR", Ordinal: 4, Nullable: true},
+			{Name: "subject", Type: "NVARCHAR", Ordinal: 5},
+			{Name: "body", Type: "NVARCHAR", Ordinal: 6, Nullable: true},
+			{Name: "body_format", Type: "NVARCHAR", Ordinal: 7},
+			{Name: "importance", Type: "NVARCHAR", Ordinal: 8},
+			{Name: "sensitivity", Type: "NVARCHAR", Ordinal: 9},
+			{Name: "query", Type: "NVARCHAR", Ordinal: 10, Nullable: true},
+			{Name: "send_request_date", Type: "NVARCHAR", Ordinal: 11},
+			{Name: "send_request_user", Type: "NVARCHAR", Ordinal: 12, Nullable: true},
+			{Name: "sent_status", Type: "NVARCHAR", Ordinal: 13},
+			{Name: "sent_date", Type: "NVARCHAR", Ordinal: 14, Nullable: true},
+			{Name: "last_mod_date", Type: "NVARCHAR", Ordinal: 15},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil || rt.Mail() == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	orNil := func(s string) interface{} {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	for _, item := range rt.Mail().Items() {
+		switch {
+		case status == "":
+		case status == mail.StatusUnsent && item.Status == mail.StatusRetrying:
+		case item.Status != status:
+			continue
+		}
+		var sentDate interface{}
+		if !item.SentAt.IsZero() {
+			sentDate = item.SentAt.Format("2006-01-02 15:04:05")
+		}
+		m := item.Message
+		rs.Rows = append(rs.Rows, []interface{}{
+			item.ID,                         // mailitem_id
+			int64(item.ProfileID),           // profile_id
+			orNil(strings.Join(m.To, ";")),  // recipients
+			orNil(strings.Join(m.CC, ";")),  // copy_recipients
+			orNil(strings.Join(m.BCC, ";")), // blind_copy_recipients
+			m.Subject,                       // subject
+			orNil(m.Body),                   // body
+			m.BodyFormat,                    // body_format
+			m.Importance,                    // importance
+			m.Sensitivity,                   // sensitivity
+			orNil(m.Query),                  // query
+			item.RequestedAt.Format("2006-01-02 15:04:05"), // send_request_date
+			orNil(m.User), // send_request_user
+			item.Status,   // sent_status
+			sentDate,      // sent_date
+			item.ModifiedAt.Format("2006-01-02 15:04:05"), // last_mod_date
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryMailEvents returns msdb.dbo.sysmail_event_log data: one row per
+// message sent, retried or given up on, oldest first.
+func (sc *SystemCatalog) queryMailEvents(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "log_id", Type: "INT", Ordinal: 0},
+			{Name: "event_type", Type: "NVARCHAR", Ordinal: 1},
+			{Name: "log_date", Type: "NVARCHAR", Ordinal: 2},
+			{Name: "description", Type: "NVARCHAR", Ordinal: 3},
+			{Name: "mailitem_id", Type: "INT", Ordinal: 4, Nullable: true},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil || rt.Mail() == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	for _, e := range rt.Mail().Events() {
+		var item interface{}
+		if e.MailItemID != 0 {
+			item = e.MailItemID
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			e.ID,                                 // log_id
+			e.Type,                               // event_type
+			e.Time.Format("2006-01-02 15:04:05"), // log_date
+			e.Description,                        // description
+			item,                                 // mailitem_id
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
+
+// queryMailProfiles returns msdb.dbo.sysmail_profile data: one row per
+// configured mail profile.
+func (sc *SystemCatalog) queryMailProfiles(ctx context.Context, db interface {
+	Query(context.Context, string, ...interface{}) ([]runtime.ResultSet, error)
+}, sql string) ([]runtime.ResultSet, error) {
+	rs := runtime.ResultSet{
+		Columns: []runtime.ColumnInfo{
+			{Name: "profile_id", Type: "INT", Ordinal: 0},
+			{Name: "name", Type: "NVARCHAR", Ordinal: 1},
+			{Name: "description", Type: "NVARCHAR", Ordinal: 2, Nullable: true},
+			{Name: "transport", Type: "NVARCHAR", Ordinal: 3},
+		},
+	}
+
+	sc.mu.RLock()
+	rt := sc.runtime
+	sc.mu.RUnlock()
+	if rt == nil || rt.Mail() == nil {
+		return []runtime.ResultSet{rs}, nil
+	}
+
+	for _, p := range rt.Mail().Profiles() {
+		var description interface{}
+		if p.Description != "" {
+			description = p.Description
+		}
+		rs.Rows = append(rs.Rows, []interface{}{
+			int64(p.ID), // profile_id
+			p.Name,      // name
+			description, // description
+			p.Kind,      // transport
+		})
+	}
+
+	return []runtime.ResultSet{rs}, nil
+}
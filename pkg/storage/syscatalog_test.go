@@ -2,10 +2,23 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ha1tch/aul/pkg/auth"
+	"github.com/ha1tch/aul/pkg/log"
+	"github.com/ha1tch/aul/pkg/mail"
 	"github.com/ha1tch/aul/pkg/procedure"
+	"github.com/ha1tch/aul/pkg/runtime"
+	"github.com/ha1tch/aul/pkg/tsqlruntime"
 )
 
 func TestSystemCatalog_IsSystemQuery(t *testing.T) {
@@ -23,6 +36,7 @@ func TestSystemCatalog_IsSystemQuery(t *testing.T) {
 		{"SELECT * FROM sys.types", true},
 		{"SELECT * FROM sys.databases", true},
 		{"SELECT * FROM INFORMATION_SCHEMA.TABLES", true},
+		{"SELECT * FROM msdb.dbo.sysmail_faileditems", true},
 		{"SELECT * FROM Customers", false},
 		{"INSERT INTO Orders VALUES (1)", false},
 		{"EXEC dbo.GetCustomer @ID = 1", false},
@@ -132,11 +146,15 @@ func TestSystemCatalog_QueryTables(t *testing.T) {
 
 	ctx := context.Background()
 
-	// Create a test table
+	// Create a test table, with a full-text index whose tables stay hidden
 	_, err = storage.Exec(ctx, "CREATE TABLE Customers (ID INTEGER PRIMARY KEY, Name TEXT)")
 	if err != nil {
 		t.Fatalf("failed to create table: %v", err)
 	}
+	_, err = storage.Exec(ctx, `CREATE VIRTUAL TABLE aul_fts_customers USING fts4(content="Customers", Name)`)
+	if err != nil {
+		t.Fatalf("failed to create full-text index: %v", err)
+	}
 
 	sc := NewSystemCatalog(nil)
 	results, err := sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.tables")
@@ -159,6 +177,249 @@ func TestSystemCatalog_QueryTables(t *testing.T) {
 	}
 }
 
+func TestSystemCatalog_QueryExtendedProperties(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	sc := NewSystemCatalog(nil)
+
+	// No properties yet: the view is empty rather than an error
+	results, err := sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.extended_properties")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(results[0].Rows) != 0 {
+		t.Fatalf("expected no properties, got %d", len(results[0].Rows))
+	}
+
+	for _, stmt := range []string{
+		"CREATE TABLE Orders (ID INTEGER, Qty INTEGER)",
+		"CREATE TABLE aul_extended_properties (name TEXT, value TEXT, level0type TEXT, level0name TEXT, " +
+			"level1type TEXT, level1name TEXT, level2type TEXT, level2name TEXT)",
+		"INSERT INTO aul_extended_properties VALUES ('MS_Description', 'Sales db', '', '', '', '', '', '')",
+		"INSERT INTO aul_extended_properties VALUES ('MS_Description', 'Orders', 'SCHEMA', 'dbo', 'TABLE', 'Orders', '', '')",
+		"INSERT INTO aul_extended_properties VALUES ('MS_Description', 'Quantity', 'SCHEMA', 'dbo', 'TABLE', 'Orders', 'COLUMN', 'Qty')",
+	} {
+		if _, err := storage.Exec(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	results, err = sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.extended_properties")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := results[0].Rows
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 properties, got %d", len(rows))
+	}
+
+	ordersID := objectIDForName("Orders")
+	want := [][]interface{}{
+		{int64(0), "DATABASE", int64(0), int64(0), "MS_Description", "Sales db"},
+		{int64(1), "OBJECT_OR_COLUMN", ordersID, int64(0), "MS_Description", "Orders"},
+		{int64(1), "OBJECT_OR_COLUMN", ordersID, int64(2), "MS_Description", "Quantity"},
+	}
+	for i, w := range want {
+		for j := range w {
+			if rows[i][j] != w[j] {
+				t.Errorf("row %d column %d = %v, want %v", i, j, rows[i][j], w[j])
+			}
+		}
+	}
+
+	// The store is not a user table
+	results, err = sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.tables")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(results[0].Rows) != 1 {
+		t.Errorf("expected only Orders in sys.tables, got %d tables", len(results[0].Rows))
+	}
+}
+
+func TestSystemCatalog_QuerySensitivityClassifications(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	sc := NewSystemCatalog(nil)
+
+	// Nothing classified yet: the view is empty rather than an error
+	results, err := sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.sensitivity_classifications")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(results[0].Rows) != 0 {
+		t.Fatalf("expected no classifications, got %d", len(results[0].Rows))
+	}
+
+	for _, stmt := range []string{
+		"CREATE TABLE Customers (ID INTEGER, Email TEXT, Phone TEXT)",
+		"CREATE TABLE aul_sensitivity_classifications (schema_name TEXT, table_name TEXT, column_name TEXT, " +
+			"label TEXT, label_id TEXT, information_type TEXT, information_type_id TEXT, rank INTEGER)",
+		"INSERT INTO aul_sensitivity_classifications VALUES ('dbo', 'Customers', 'Email', 'Confidential', NULL, 'Contact Info', NULL, 20)",
+		"INSERT INTO aul_sensitivity_classifications VALUES ('dbo', 'Customers', 'Phone', 'Highly Confidential', 'b7a3', NULL, NULL, NULL)",
+	} {
+		if _, err := storage.Exec(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	results, err = sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.sensitivity_classifications")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := results[0].Rows
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 classifications, got %d", len(rows))
+	}
+
+	customersID := objectIDForName("Customers")
+	want := [][]interface{}{
+		{int64(1), "OBJECT_OR_COLUMN", customersID, int64(2), "Confidential", nil, "Contact Info", nil, int64(20), "MEDIUM"},
+		{int64(1), "OBJECT_OR_COLUMN", customersID, int64(3), "Highly Confidential", "b7a3", nil, nil, nil, nil},
+	}
+	for i, w := range want {
+		for j := range w {
+			if rows[i][j] != w[j] {
+				t.Errorf("row %d column %d = %v, want %v", i, j, rows[i][j], w[j])
+			}
+		}
+	}
+
+	// The store is not a user table
+	results, err = sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.tables")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(results[0].Rows) != 1 {
+		t.Errorf("expected only Customers in sys.tables, got %d tables", len(results[0].Rows))
+	}
+}
+
+func TestSystemCatalog_QueryViews(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	sc := NewSystemCatalog(nil)
+
+	// Nothing materialized yet: the view is empty rather than an error
+	results, err := sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.views")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(results[0].Rows) != 0 {
+		t.Fatalf("expected no views, got %d", len(results[0].Rows))
+	}
+
+	for _, stmt := range []string{
+		"CREATE TABLE Orders (ID INTEGER, Total REAL)",
+		"CREATE TABLE OrderTotals AS SELECT SUM(Total) AS Total FROM Orders",
+		"CREATE TABLE aul_materialized_views (view_name TEXT, schema_name TEXT, definition TEXT, " +
+			"query TEXT, base_tables TEXT, on_write INTEGER, refreshed_at DATETIME)",
+		"INSERT INTO aul_materialized_views (view_name, schema_name, query, base_tables, on_write) " +
+			"VALUES ('OrderTotals', 'dbo', 'SELECT SUM(Total) AS Total FROM Orders', 'orders', 0)",
+	} {
+		if _, err := storage.Exec(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	results, err = sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.views")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := results[0].Rows
+	if len(rows) != 1 || rows[0][0] != "OrderTotals" || rows[0][1] != objectIDForName("OrderTotals") || rows[0][4] != "VIEW" {
+		t.Errorf("sys.views = %v, want OrderTotals", rows)
+	}
+
+	// The definitions are not a user table
+	results, err = sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.tables")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(results[0].Rows) != 2 {
+		t.Errorf("expected Orders and OrderTotals in sys.tables, got %d tables", len(results[0].Rows))
+	}
+}
+
+func TestSystemCatalog_QueryPartitions(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	sc := NewSystemCatalog(nil)
+
+	// Orders is partitioned in three, its rows held by one table each and
+	// read through a view of its name
+	for _, stmt := range []string{
+		"CREATE TABLE Customers (ID INTEGER)",
+		"CREATE TABLE aul_partition_1_Orders (ID INTEGER, OrderDate TEXT)",
+		"CREATE TABLE aul_partition_2_Orders (ID INTEGER, OrderDate TEXT)",
+		"CREATE TABLE aul_partition_3_Orders (ID INTEGER, OrderDate TEXT)",
+		"CREATE VIEW Orders AS SELECT * FROM aul_partition_1_Orders UNION ALL SELECT * FROM aul_partition_2_Orders " +
+			"UNION ALL SELECT * FROM aul_partition_3_Orders",
+		"CREATE TABLE aul_partitioned_tables (table_name TEXT, scheme_name TEXT, column_name TEXT, partitions INTEGER)",
+		"INSERT INTO aul_partitioned_tables VALUES ('Orders', 'ps', 'OrderDate', 3)",
+		"INSERT INTO aul_partition_2_Orders VALUES (1, '2024-03-01'), (2, '2024-04-01')",
+		"INSERT INTO aul_partition_3_Orders VALUES (3, '2024-09-01')",
+	} {
+		if _, err := storage.Exec(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	results, err := sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.tables")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	var tables []interface{}
+	for _, row := range results[0].Rows {
+		tables = append(tables, row[0])
+	}
+	if len(tables) != 2 || tables[0] != "Customers" || tables[1] != "Orders" {
+		t.Errorf("sys.tables = %v, want Customers and Orders", tables)
+	}
+
+	results, err = sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM sys.partitions")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	orders := objectIDForName("Orders")
+	var counts []int64
+	for _, row := range results[0].Rows {
+		if row[1] != orders {
+			continue
+		}
+		if row[3] != int64(len(counts)+1) || row[0] != orders*1000+int64(len(counts)) {
+			t.Errorf("partition %d = %v", len(counts)+1, row)
+		}
+		counts = append(counts, row[5].(int64))
+	}
+	if len(counts) != 3 || counts[0] != 0 || counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("Orders partition rows = %v, want [0 2 1]", counts)
+	}
+	if len(results[0].Rows) != 4 {
+		t.Errorf("expected a partition of Customers and three of Orders, got %d", len(results[0].Rows))
+	}
+}
+
 func TestSystemCatalog_QueryTypes(t *testing.T) {
 	sc := NewSystemCatalog(nil)
 
@@ -200,6 +461,429 @@ func TestSystemCatalog_QueryTypes(t *testing.T) {
 	}
 }
 
+func TestSystemCatalog_QueryCircuitBreakers(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	query := "SELECT * FROM sys.dm_aul_circuit_breakers"
+
+	// Without breakers the view exists but is empty
+	results, err := storage.Query(ctx, query)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Rows) != 0 {
+		t.Fatalf("expected an empty result set, got %+v", results)
+	}
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	cfg.Breaker = runtime.BreakerConfig{Enabled: true, Window: 1, MinCalls: 1}
+	rt := runtime.New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	rt.Breakers().Allow("dbo.Flaky")
+	rt.Breakers().Record("dbo.Flaky", 0, errors.New("boom"))
+	storage.SetRuntime(rt)
+
+	// The runtime survives the catalog being rebuilt for a new registry
+	storage.SetRegistry(procedure.NewRegistry())
+
+	results, err = storage.Query(ctx, query)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rs := results[0]
+	if len(rs.Rows) != 1 {
+		t.Fatalf("expected 1 breaker, got %d", len(rs.Rows))
+	}
+	row := rs.Rows[0]
+	if row[0] != "dbo.Flaky" || row[2] != "OPEN" || row[6] != int64(1) {
+		t.Errorf("unexpected row: %v", row)
+	}
+	if row[10] == nil {
+		t.Error("expected retry_after for an open breaker")
+	}
+}
+
+func TestSystemCatalog_QueryAdmissionQueues(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	cfg.MaxConcurrency = 8
+	storage.SetRuntime(runtime.New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError})))
+
+	results, err := storage.Query(context.Background(), "SELECT * FROM sys.dm_aul_admission_queues")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rs := results[0]
+	if len(rs.Rows) != 2 {
+		t.Fatalf("expected 2 lanes, got %d", len(rs.Rows))
+	}
+	if rs.Rows[0][0] != "interactive" || rs.Rows[1][0] != "batch" || rs.Rows[0][1] != int64(8) {
+		t.Errorf("unexpected rows: %v", rs.Rows)
+	}
+}
+
+func TestSystemCatalog_QueryMemoryUsage(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	cfg.Memory = runtime.MemoryConfig{SessionLimit: 1 << 20}
+	rt := runtime.New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	storage.SetRuntime(rt)
+
+	tracker := rt.Memory().Begin("s1")
+	defer tracker.End()
+	if err := tracker.Reserve(4096); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := storage.Query(context.Background(), "SELECT * FROM sys.dm_aul_memory_usage")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rs := results[0]
+	if len(rs.Rows) != 2 {
+		t.Fatalf("expected server and session rows, got %v", rs.Rows)
+	}
+	server, session := rs.Rows[0], rs.Rows[1]
+	if server[0] != "SERVER" || server[2] != int64(4096) || server[4] != nil {
+		t.Errorf("unexpected server row: %v", server)
+	}
+	if session[0] != "SESSION" || session[1] != "s1" || session[2] != int64(4096) || session[4] != int64(1<<20) {
+		t.Errorf("unexpected session row: %v", session)
+	}
+}
+
+func TestSystemCatalog_QueryPinnedTables(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	cfg.TableCache = tsqlruntime.TableCacheConfig{Tables: []string{"dbo.Regions"}}
+	rt := runtime.New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	rt.SetStorage(storage)
+	storage.SetRuntime(rt)
+
+	ctx := context.Background()
+	batch := "CREATE TABLE Regions (Code VARCHAR(2), Name VARCHAR(20)); " +
+		"INSERT INTO Regions VALUES ('EU', 'Europe'), ('NA', 'North America'); " +
+		"SELECT Name FROM Regions WHERE Code = 'EU'"
+	if _, err := rt.ExecuteSQL(ctx, batch, &runtime.ExecContext{SessionID: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := storage.Query(ctx, "SELECT * FROM sys.dm_aul_pinned_tables")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rs := results[0]
+	if len(rs.Rows) != 1 {
+		t.Fatalf("expected one pinned table, got %v", rs.Rows)
+	}
+	row := rs.Rows[0]
+	if row[0] != "dbo.Regions" || row[1] != "LOADED" || row[2] != int64(2) || row[4] != int64(1) || row[7] == nil {
+		t.Errorf("unexpected row: %v", row)
+	}
+}
+
+func TestSystemCatalog_QueryPrincipals(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	sc := NewSystemCatalog(nil)
+	names := func(view string, column int) []string {
+		t.Helper()
+		results, err := sc.ExecuteSystemQuery(ctx, storage, "SELECT * FROM "+view)
+		if err != nil {
+			t.Fatalf("%s: %v", view, err)
+		}
+		var names []string
+		for _, row := range results[0].Rows {
+			names = append(names, fmt.Sprint(row[column]))
+		}
+		return names
+	}
+
+	// Without SQL logins only the built-in principals are listed
+	if got := names("sys.database_principals", 0); len(got) != 10 || got[0] != "public" || got[1] != "dbo" {
+		t.Errorf("sys.database_principals = %v", got)
+	}
+	if got := names("sys.server_role_members", 0); len(got) != 0 {
+		t.Errorf("sys.server_role_members = %v", got)
+	}
+
+	store, err := auth.NewStore(ctx, storage.GetDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Provision(ctx, []auth.Login{{Name: "App", Password: "s3cret"}, {Name: "ops", Password: "s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := auth.NewPermissions(ctx, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := storage.GetDB()
+	for _, step := range []func() error{
+		func() error { return p.CreateRole(ctx, db, "analysts") },
+		func() error { return p.AddRoleMember(ctx, db, "analysts", "app") },
+		func() error { return p.AddRoleMember(ctx, db, auth.DBDataReader, "analysts") },
+		func() error { return p.AddServerRoleMember(ctx, db, auth.Sysadmin, "ops") },
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"public", "dbo", "guest", "INFORMATION_SCHEMA", "sys", "App", "ops", "analysts",
+		"db_owner", "db_datareader", "db_datawriter", "db_denydatareader", "db_denydatawriter"}
+	if got := names("sys.database_principals", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("sys.database_principals = %v, want %v", got, want)
+	}
+	if got := names("sys.server_principals", 0); !reflect.DeepEqual(got, []string{"public", "sysadmin", "App", "ops"}) {
+		t.Errorf("sys.server_principals = %v", got)
+	}
+
+	id := func(name string) string { return fmt.Sprint(objectIDForName(name)) }
+	roles, members := names("sys.database_role_members", 0), names("sys.database_role_members", 1)
+	if want := []string{"16384", id("analysts"), "16390"}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("role_principal_id = %v, want %v", roles, want)
+	}
+	if want := []string{"1", id("app"), id("analysts")}; !reflect.DeepEqual(members, want) {
+		t.Errorf("member_principal_id = %v, want %v", members, want)
+	}
+	if got := names("sys.server_role_members", 1); !reflect.DeepEqual(got, []string{id("ops")}) {
+		t.Errorf("sys.server_role_members = %v", got)
+	}
+}
+
+func TestSystemCatalog_QueryRecovery(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	logger := log.New(log.Config{DefaultLevel: log.LevelError})
+	path := filepath.Join(t.TempDir(), "aul.journal")
+	journal, err := runtime.OpenJournal(runtime.JournalConfig{Path: path}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := journal.Begin("dbo.load", "", &runtime.ExecContext{SessionID: "s1"})
+	if err := entry.BeginWrite("INSERT", "t", "INSERT INTO t VALUES (1)", false); err != nil {
+		t.Fatal(err)
+	}
+	entry.EndWrite(1, nil)
+	journal.Close()
+
+	// Reopening finds the execution that never ended
+	journal, err = runtime.OpenJournal(runtime.JournalConfig{Path: path}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), logger)
+	rt.SetJournal(journal)
+	storage.SetRuntime(rt)
+
+	results, err := storage.Query(context.Background(), "SELECT * FROM sys.dm_aul_recovery")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rs := results[0]
+	if len(rs.Rows) != 1 {
+		t.Fatalf("expected one interrupted execution, got %v", rs.Rows)
+	}
+	row := rs.Rows[0]
+	if row[1] != "dbo.load" || row[2] != "s1" || row[5] != int64(1) || row[8] != "INSERT INTO t VALUES (1)" || row[11] != "PENDING" {
+		t.Errorf("unexpected row: %v", row)
+	}
+}
+
+func TestSystemCatalog_QueryDeadlocks(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	storage.SetRuntime(rt)
+
+	// Whichever closes the cycle, s2 is the younger and so the victim
+	ctx := context.Background()
+	s1 := rt.Locks().Owner("s1", "master", "dbo.transfer")
+	s2 := rt.Locks().Owner("s2", "master", "dbo.transfer")
+	if err := s1.Lock(ctx, "dbo.a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.Lock(ctx, "dbo.b"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s1.Lock(ctx, "dbo.b") }()
+	if err := s2.Lock(ctx, "dbo.a"); err == nil {
+		t.Fatal("s2 was not chosen as the deadlock victim")
+	}
+	s2.ReleaseAll()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	s1.ReleaseAll()
+
+	results, err := storage.Query(ctx, "SELECT * FROM sys.dm_aul_deadlocks")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rs := results[0]
+	if len(rs.Rows) != 1 {
+		t.Fatalf("expected one deadlock, got %v", rs.Rows)
+	}
+	row := rs.Rows[0]
+	if row[0] != int64(1) || row[2] != "s2" || row[3] != int64(2) || !strings.Contains(row[4].(string), "<deadlock>") {
+		t.Errorf("unexpected row: %v", row)
+	}
+}
+
+func TestSystemCatalog_QueryLocksAndBlocking(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	storage.SetRuntime(rt)
+
+	// s2 waits for s1's lock on dbo.orders
+	ctx := context.Background()
+	s1 := rt.Locks().Owner("s1", "master", "dbo.close_day")
+	s2 := rt.Locks().Owner("s2", "master", "dbo.place_order")
+	if err := s1.Lock(ctx, "dbo.orders"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s2.Lock(ctx, "dbo.orders") }()
+	defer func() {
+		s1.ReleaseAll()
+		<-done
+		s2.ReleaseAll()
+	}()
+	for deadline := time.Now().Add(5 * time.Second); len(rt.Locks().Blocking()) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("s2 never waited")
+		}
+	}
+
+	results, err := storage.Query(ctx, "SELECT * FROM sys.dm_tran_locks")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := results[0].Rows
+	if len(rows) != 2 {
+		t.Fatalf("expected a granted and a waiting lock, got %v", rows)
+	}
+	if rows[0][2] != "dbo.orders" || rows[0][5] != "GRANT" || rows[0][6] != "s1" || rows[0][9] != nil {
+		t.Errorf("unexpected granted row: %v", rows[0])
+	}
+	if rows[1][5] != "WAIT" || rows[1][6] != "s2" || rows[1][9] == nil {
+		t.Errorf("unexpected waiting row: %v", rows[1])
+	}
+
+	results, err = storage.Query(ctx, "SELECT * FROM sys.dm_aul_blocking")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows = results[0].Rows
+	if len(rows) != 2 {
+		t.Fatalf("expected the blocker and the blocked, got %v", rows)
+	}
+	head, blocked := rows[0], rows[1]
+	if head[0] != "s1" || head[1] != nil || head[2] != "s1" || head[6] != int64(1) || head[9] != "dbo.close_day" {
+		t.Errorf("unexpected head blocker row: %v", head)
+	}
+	if blocked[0] != "s2" || blocked[1] != "s1" || blocked[2] != "s1" || blocked[3] != "LCK_M_X" || blocked[4] != "TABLE: master.dbo.orders" {
+		t.Errorf("unexpected blocked row: %v", blocked)
+	}
+}
+
+func TestSystemCatalog_QueryExecRequests(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	storage.SetRuntime(rt)
+
+	batch := rt.Requests().Begin("s1", "master", "SQL BATCH", "CREATE INDEX ix ON orders (qty)")
+	defer batch.End()
+	proc := rt.Requests().Begin("s2", "", "EXECUTE", "dbo.close_day")
+	proc.End()
+	batch.ReportProgress("CREATE INDEX", 10)
+	time.Sleep(5 * time.Millisecond)
+	batch.ReportProgress("CREATE INDEX", 40)
+
+	ctx := context.Background()
+	results, err := storage.Query(ctx, "SELECT * FROM sys.dm_exec_requests")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := results[0].Rows
+	if len(rows) != 1 {
+		t.Fatalf("expected the running batch only, got %v", rows)
+	}
+	row := rows[0]
+	if row[0] != "s1" || row[3] != "running" || row[4] != "CREATE INDEX" || row[5] != "master" ||
+		row[6] != 40.0 || row[7].(int64) <= 0 || row[9] != "CREATE INDEX ix ON orders (qty)" {
+		t.Errorf("unexpected row: %v", row)
+	}
+
+	// Once done, the request is the batch again
+	batch.ReportProgress("CREATE INDEX", 100)
+	results, err = storage.Query(ctx, "SELECT * FROM sys.dm_exec_requests")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if row := results[0].Rows[0]; row[4] != "SQL BATCH" || row[6] != 0.0 || row[7] != int64(0) {
+		t.Errorf("unexpected row after progress: %v", row)
+	}
+}
+
 func TestSQLiteStorage_SystemCatalogIntegration(t *testing.T) {
 	// Create storage
 	storage, err := NewInMemorySQLiteStorage()
@@ -257,3 +941,215 @@ func TestSQLiteStorage_SystemCatalogIntegration(t *testing.T) {
 		t.Errorf("expected 1 result set from regular query")
 	}
 }
+
+func TestSystemCatalog_QuerySysmail(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	// The webhook accepts only mail about the nightly load
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg mail.Message
+		json.NewDecoder(r.Body).Decode(&msg)
+		if msg.Subject != "nightly load" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer hook.Close()
+	mailer, err := mail.New(mail.Config{
+		Profiles: []mail.ProfileConfig{{
+			Name: "alerts", Description: "DBA alerts", From: "aul@example.com",
+			Webhook: &mail.WebhookConfig{URL: hook.URL},
+		}},
+		MaxRetries: -1,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mailer.Close()
+
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	rt := runtime.New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	rt.SetMail(mailer)
+	storage.SetRuntime(rt)
+
+	for _, subject := range []string{"nightly load", "disk space"} {
+		if _, err := mailer.Send(mail.Message{To: []string{"dba@example.com"}, Subject: subject, User: "etl"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(mailer.Events()) < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("mail was never delivered")
+		}
+	}
+
+	ctx := context.Background()
+	results, err := storage.Query(ctx, "SELECT * FROM msdb.dbo.sysmail_allitems")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := results[0].Rows
+	if len(rows) != 2 {
+		t.Fatalf("expected two mail items, got %v", rows)
+	}
+	if rows[0][0] != int64(1) || rows[0][2] != "dba@example.com" || rows[0][5] != "nightly load" ||
+		rows[0][12] != "etl" || rows[0][13] != "sent" || rows[0][14] == nil {
+		t.Errorf("unexpected sent row: %v", rows[0])
+	}
+	if rows[1][13] != "failed" || rows[1][14] != nil {
+		t.Errorf("unexpected failed row: %v", rows[1])
+	}
+
+	results, err = storage.Query(ctx, "SELECT * FROM msdb.dbo.sysmail_faileditems")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if rows = results[0].Rows; len(rows) != 1 || rows[0][5] != "disk space" {
+		t.Errorf("unexpected failed items: %v", rows)
+	}
+
+	results, err = storage.Query(ctx, "SELECT * FROM msdb.dbo.sysmail_event_log")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if rows = results[0].Rows; len(rows) != 2 || rows[1][1] != mail.EventError || rows[1][4] != int64(2) {
+		t.Errorf("unexpected events: %v", rows)
+	}
+
+	results, err = storage.Query(ctx, "SELECT * FROM msdb.dbo.sysmail_profile")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if rows = results[0].Rows; len(rows) != 1 || rows[0][1] != "alerts" || rows[0][2] != "DBA alerts" || rows[0][3] != "webhook" {
+		t.Errorf("unexpected profiles: %v", rows)
+	}
+}
+
+func TestSystemCatalog_QueryStats(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	if _, err := storage.Exec(ctx, "CREATE TABLE Customers (ID INTEGER); INSERT INTO Customers VALUES (1), (2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Listed before anything is analyzed, without a date
+	results, err := storage.Query(ctx, "SELECT * FROM sys.stats")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := results[0].Rows
+	if len(rows) != 1 || rows[0][0] != objectIDForName("Customers") || rows[0][1] != "Customers" || rows[0][2] != int64(1) || rows[0][10] != nil {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+
+	tables, err := storage.RefreshStatistics(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0] != "Customers" {
+		t.Errorf("refreshed %v", tables)
+	}
+	results, err = storage.Query(ctx, "SELECT * FROM sys.stats")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if rows = results[0].Rows; len(rows) != 1 || rows[0][10] == nil || rows[0][11] != int64(2) {
+		t.Errorf("unexpected rows after refresh: %v", rows)
+	}
+
+	// The table recording statistics is aul's own
+	results, err = storage.Query(ctx, "SELECT * FROM sys.tables")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if rows = results[0].Rows; len(rows) != 1 || rows[0][0] != "Customers" {
+		t.Errorf("sys.tables lists %v", rows)
+	}
+}
+
+func TestSystemCatalog_QueryMissingIndexes(t *testing.T) {
+	storage, err := NewInMemorySQLiteStorage()
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	if _, err := storage.Exec(ctx, "CREATE TABLE orders (id INTEGER PRIMARY KEY, customer INTEGER, placed TEXT, qty INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := runtime.DefaultConfig()
+	cfg.JITEnabled = false
+	cfg.DefaultDialect = "sqlite"
+	cfg.IndexAdvisor = runtime.IndexAdvisorConfig{Mode: runtime.IndexAdvisorAuto, MinSeeks: 2}
+	rt := runtime.New(cfg, procedure.NewRegistry(), log.New(log.Config{DefaultLevel: log.LevelError}))
+	rt.SetStorage(storage)
+	storage.SetRuntime(rt)
+
+	for _, sql := range []string{
+		"SELECT qty FROM dbo.orders WHERE customer = 1 AND placed > '2024-01-01'",
+		"SELECT qty FROM orders WHERE placed > '2024-06-01' AND customer = 2",
+		"SELECT customer FROM orders WHERE id = 3", // served by the primary key
+	} {
+		if _, err := rt.ExecuteSQL(ctx, sql, &runtime.ExecContext{SessionID: "s1"}); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	results, err := storage.Query(ctx, "SELECT * FROM sys.dm_db_missing_index_details")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := results[0].Rows
+	if len(rows) != 1 {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	handle := rows[0][0]
+	want := []interface{}{handle, int64(1), objectIDForName("orders"), "[customer]", "[placed]", "[qty]", "[dbo].[orders]"}
+	if !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("details %v, want %v", rows[0], want)
+	}
+	results, err = storage.Query(ctx, "SELECT * FROM sys.dm_db_missing_index_groups")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if rows = results[0].Rows; len(rows) != 1 || rows[0][0] != handle || rows[0][1] != handle {
+		t.Errorf("groups %v", rows)
+	}
+	results, err = storage.Query(ctx, "SELECT * FROM sys.dm_db_missing_index_group_stats")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if rows = results[0].Rows; len(rows) != 1 || rows[0][0] != handle || rows[0][1] != int64(2) || rows[0][2] != int64(2) {
+		t.Errorf("group stats %v", rows)
+	}
+
+	// Auto mode creates it, and it is no longer missing
+	created, err := rt.CreateMissingIndexes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 1 || created[0] != "IX_aul_orders_customer_placed" {
+		t.Errorf("created %v", created)
+	}
+	indexes, err := storage.TableIndexes(ctx, "orders")
+	if err != nil || !reflect.DeepEqual(indexes, [][]string{{"id"}, {"customer", "placed", "qty"}}) {
+		t.Errorf("indexes %v, %v", indexes, err)
+	}
+	results, err = storage.Query(ctx, "SELECT * FROM sys.dm_db_missing_index_details")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if rows = results[0].Rows; len(rows) != 0 {
+		t.Errorf("still missing: %v", rows)
+	}
+}
@@ -11,8 +11,6 @@ import (
 	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
-
 	"github.com/ha1tch/aul/pkg/runtime"
 )
 
@@ -179,7 +177,7 @@ func (s *TenantSQLiteStorage) getPool(ctx context.Context, tenant, database stri
 	dsn := s.buildDSN(dbPath)
 
 	// Open database
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := sql.Open(sqliteDriver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
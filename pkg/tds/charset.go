@@ -0,0 +1,259 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/transform"
+)
+
+// VARCHAR, CHAR and TEXT values travel in the code page of their
+// collation, not in Unicode. A client states the collation of each
+// character parameter it sends, and decodes each character column by the
+// collation in its metadata; at login it reports its locale, whose ANSI
+// code page is the one its non-Unicode strings are in. A Charset is one
+// such code page, and converts between it and Go's UTF-8 strings.
+
+// CodePageUTF8 is the code page of SQL Server's _UTF8 collations.
+const CodePageUTF8 = 65001
+
+// collationUTF8 is the collation flag marking a _UTF8 collation.
+const collationUTF8 = 1 << 26
+
+// collationFlags are the comparison flags of DefaultCollation: case
+// insensitive, width and kana insensitive.
+const collationFlags = 0x00D00000
+
+// Charset is a code page character data is encoded in.
+type Charset struct {
+	CodePage  int
+	collation []byte
+	enc       encoding.Encoding // nil for UTF-8
+}
+
+// newCharset returns the charset for a code page, described to clients
+// by the collation of lcid and sortID.
+func newCharset(codePage int, lcid uint32, sortID byte, enc encoding.Encoding) *Charset {
+	coll := make([]byte, 5)
+	binary.LittleEndian.PutUint32(coll, lcid)
+	coll[4] = sortID
+	return &Charset{CodePage: codePage, collation: coll, enc: enc}
+}
+
+// charsets are the supported code pages. The Windows code pages are
+// described by the Windows collation of a locale that uses them; 437 and
+// 850, which no locale does, by a SQL collation.
+var charsets = map[int]*Charset{
+	437:          newCharset(437, collationFlags|0x0409, 32, charmap.CodePage437),
+	850:          newCharset(850, collationFlags|0x0409, 42, charmap.CodePage850),
+	874:          newCharset(874, collationFlags|0x041E, 0, charmap.Windows874),
+	932:          newCharset(932, collationFlags|0x0411, 0, japanese.ShiftJIS),
+	936:          newCharset(936, collationFlags|0x0804, 0, simplifiedchinese.GBK),
+	949:          newCharset(949, collationFlags|0x0412, 0, korean.EUCKR),
+	950:          newCharset(950, collationFlags|0x0404, 0, traditionalchinese.Big5),
+	1250:         newCharset(1250, collationFlags|0x0405, 0, charmap.Windows1250),
+	1251:         newCharset(1251, collationFlags|0x0419, 0, charmap.Windows1251),
+	1252:         &Charset{CodePage: 1252, collation: DefaultCollation, enc: charmap.Windows1252},
+	1253:         newCharset(1253, collationFlags|0x0408, 0, charmap.Windows1253),
+	1254:         newCharset(1254, collationFlags|0x041F, 0, charmap.Windows1254),
+	1255:         newCharset(1255, collationFlags|0x040D, 0, charmap.Windows1255),
+	1256:         newCharset(1256, collationFlags|0x0401, 0, charmap.Windows1256),
+	1257:         newCharset(1257, collationFlags|0x0425, 0, charmap.Windows1257),
+	1258:         newCharset(1258, collationFlags|0x042A, 0, charmap.Windows1258),
+	CodePageUTF8: newCharset(CodePageUTF8, collationUTF8|collationFlags|0x0409, 0, nil),
+}
+
+// DefaultCharset is the code page of DefaultCollation, Windows-1252.
+var DefaultCharset = charsets[1252]
+
+// CharsetForCodePage returns the charset of a code page.
+func CharsetForCodePage(codePage int) (*Charset, error) {
+	if cs, ok := charsets[codePage]; ok {
+		return cs, nil
+	}
+	return nil, fmt.Errorf("unsupported code page %d", codePage)
+}
+
+// CharsetForLCID returns the charset of the ANSI code page of a Windows
+// locale, as a client reports it in LOGIN7, or nil when it reports none.
+func CharsetForLCID(lcid uint32) *Charset {
+	lcid &= 0xFFFFF
+	if lcid == 0 {
+		return nil
+	}
+	return charsets[lcidCodePage(lcid)]
+}
+
+// CharsetOf returns the charset of a 5-byte collation, or nil when the
+// collation names neither a locale nor a SQL sort order, as with the zero
+// collation some clients send on parameters.
+func CharsetOf(collation []byte) *Charset {
+	if len(collation) < 5 {
+		return nil
+	}
+	info := binary.LittleEndian.Uint32(collation)
+	if info&collationUTF8 != 0 {
+		return charsets[CodePageUTF8]
+	}
+	if cp := sortIDCodePage(collation[4]); cp != 0 {
+		return charsets[cp]
+	}
+	return CharsetForLCID(info)
+}
+
+// columnCharset returns the code page a character column's values are
+// sent in: that of its collation, which DefaultCollation stands in for
+// when it has none.
+func columnCharset(col Column) *Charset {
+	if cs := CharsetOf(col.Collation); cs != nil {
+		return cs
+	}
+	return DefaultCharset
+}
+
+// Collation returns the collation clients are told character data in
+// this code page has.
+func (c *Charset) Collation() []byte {
+	return c.collation
+}
+
+// Decode converts a value in the code page to a string.
+func (c *Charset) Decode(b []byte) string {
+	if c == nil || c.enc == nil || isASCII(b) {
+		return string(b)
+	}
+	s, err := c.enc.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(s)
+}
+
+// Encode converts a string to the code page. Characters the code page
+// lacks become '?', as SQL Server converts them.
+func (c *Charset) Encode(s string) []byte {
+	return c.AppendEncoded(nil, s)
+}
+
+// AppendEncoded appends s, converted to the code page, to b.
+func (c *Charset) AppendEncoded(b []byte, s string) []byte {
+	if c == nil || c.enc == nil || isASCII(s) {
+		return append(b, s...)
+	}
+	e := c.enc.NewEncoder()
+	src := []byte(s)
+	for len(src) > 0 {
+		var n int
+		var err error
+		b, n, err = transform.Append(e, b, src)
+		if err == nil {
+			break
+		}
+		b = append(b, '?')
+		_, size := utf8.DecodeRune(src[n:])
+		src = src[n+size:]
+	}
+	return b
+}
+
+func isASCII[T string | []byte](b T) bool {
+	for i := 0; i < len(b); i++ {
+		if b[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// sortIDCodePage returns the code page of a SQL collation's sort order,
+// or 0 for a Windows collation, whose sort ID is 0.
+func sortIDCodePage(sortID byte) int {
+	switch {
+	case sortID >= 30 && sortID <= 34:
+		return 437
+	case sortID >= 40 && sortID <= 49, sortID >= 55 && sortID <= 61:
+		return 850
+	case sortID >= 50 && sortID <= 54, sortID >= 71 && sortID <= 75,
+		sortID >= 183 && sortID <= 186, sortID >= 210 && sortID <= 217:
+		return 1252
+	case sortID >= 80 && sortID <= 96:
+		return 1250
+	case sortID >= 104 && sortID <= 108:
+		return 1251
+	case sortID >= 112 && sortID <= 124:
+		return 1253
+	case sortID >= 128 && sortID <= 130:
+		return 1254
+	case sortID >= 136 && sortID <= 138:
+		return 1255
+	case sortID >= 144 && sortID <= 146:
+		return 1256
+	case sortID >= 152 && sortID <= 160:
+		return 1257
+	case sortID == 192, sortID == 193, sortID == 200:
+		return 932
+	case sortID == 194, sortID == 195, sortID == 201:
+		return 949
+	case sortID == 196, sortID == 197, sortID == 202:
+		return 950
+	case sortID == 198, sortID == 199, sortID == 203:
+		return 936
+	case sortID >= 204 && sortID <= 206:
+		return 874
+	}
+	return 0
+}
+
+// lcidCodePage returns the ANSI code page of a Windows locale: that of
+// its language, or of its script where the language is written in
+// several. Locales of other languages use Windows-1252.
+func lcidCodePage(lcid uint32) int {
+	lang, sub := lcid&0x3FF, (lcid>>10)&0x3F
+	switch lang {
+	case 0x1E: // Thai
+		return 874
+	case 0x11: // Japanese
+		return 932
+	case 0x04: // Chinese: PRC and Singapore simplified, else traditional
+		if sub == 2 || sub == 4 {
+			return 936
+		}
+		return 950
+	case 0x12: // Korean
+		return 949
+	case 0x05, 0x0E, 0x15, 0x18, 0x1B, 0x1C, 0x24: // Czech, Hungarian, Polish, Romanian, Slovak, Albanian, Slovenian
+		return 1250
+	case 0x1A: // Croatian, Serbian and Bosnian: Cyrillic or Latin
+		if sub == 3 || sub == 7 || sub == 8 || sub == 10 || sub == 12 {
+			return 1251
+		}
+		return 1250
+	case 0x02, 0x19, 0x22, 0x23, 0x2F, 0x3F, 0x44, 0x50, 0x6D: // Bulgarian, Russian, Ukrainian, Belarusian, Macedonian, Kazakh, Tatar, Mongolian, Bashkir
+		return 1251
+	case 0x2C, 0x43: // Azerbaijani and Uzbek: Latin or Cyrillic
+		if sub == 2 {
+			return 1251
+		}
+		return 1254
+	case 0x08: // Greek
+		return 1253
+	case 0x1F: // Turkish
+		return 1254
+	case 0x0D: // Hebrew
+		return 1255
+	case 0x01, 0x20, 0x29, 0x80, 0x8C: // Arabic, Urdu, Persian, Uyghur, Dari
+		return 1256
+	case 0x25, 0x26, 0x27: // Estonian, Latvian, Lithuanian
+		return 1257
+	case 0x2A: // Vietnamese
+		return 1258
+	}
+	return 1252
+}
@@ -0,0 +1,134 @@
+package tds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestCharsetOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		collation []byte
+		want      int // 0 for none
+	}{
+		{"Latin1_General_CI_AS default", DefaultCollation, 1252},
+		{"SQL_Latin1_General_CP850", []byte{0x09, 0x04, 0xD0, 0x00, 42}, 850},
+		{"Cyrillic_General_CI_AS", []byte{0x19, 0x04, 0xD0, 0x00, 0}, 1251},
+		{"Chinese_Taiwan", []byte{0x04, 0x04, 0xD0, 0x00, 0}, 950},
+		{"Chinese_PRC", []byte{0x04, 0x08, 0xD0, 0x00, 0}, 936},
+		{"Serbian_Cyrillic", []byte{0x1A, 0x0C, 0xD0, 0x00, 0}, 1251},
+		{"Latin1_General_100_CI_AS_SC_UTF8", []byte{0x09, 0x04, 0xD0, 0x04, 0}, CodePageUTF8},
+		{"zero", []byte{0, 0, 0, 0, 0}, 0},
+		{"short", []byte{0x09, 0x04}, 0},
+	}
+	for _, tt := range tests {
+		cs := CharsetOf(tt.collation)
+		got := 0
+		if cs != nil {
+			got = cs.CodePage
+		}
+		if got != tt.want {
+			t.Errorf("%s: code page %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCharsetCollationRoundTrip(t *testing.T) {
+	for codePage := range charsets {
+		cs, err := CharsetForCodePage(codePage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := CharsetOf(cs.Collation()); got != cs {
+			t.Errorf("code page %d: collation % x reads back as %v", codePage, cs.Collation(), got)
+		}
+	}
+	if _, err := CharsetForCodePage(1); err == nil {
+		t.Error("code page 1 is supported")
+	}
+}
+
+func TestCharsetForLCID(t *testing.T) {
+	if cs := CharsetForLCID(0); cs != nil {
+		t.Errorf("LCID 0: %d, want none", cs.CodePage)
+	}
+	for lcid, want := range map[uint32]int{0x0409: 1252, 0x0419: 1251, 0x0411: 932, 0x0405: 1250, 0x042C: 1254, 0x082C: 1251} {
+		if cs := CharsetForLCID(lcid); cs == nil || cs.CodePage != want {
+			t.Errorf("LCID %#04x: %v, want %d", lcid, cs, want)
+		}
+	}
+}
+
+func TestCharsetEncodeDecode(t *testing.T) {
+	tests := []struct {
+		codePage int
+		s        string
+		encoded  []byte
+	}{
+		{1252, "café", []byte{'c', 'a', 'f', 0xE9}},
+		{1251, "Жук", []byte{0xC6, 0xF3, 0xEA}},
+		{932, "日本", []byte{0x93, 0xFA, 0x96, 0x7B}},
+		{CodePageUTF8, "Жук", []byte("Жук")},
+	}
+	for _, tt := range tests {
+		cs, _ := CharsetForCodePage(tt.codePage)
+		if got := cs.Encode(tt.s); !bytes.Equal(got, tt.encoded) {
+			t.Errorf("%d: Encode(%q) = % x, want % x", tt.codePage, tt.s, got, tt.encoded)
+		}
+		if got := cs.Decode(tt.encoded); got != tt.s {
+			t.Errorf("%d: Decode(% x) = %q, want %q", tt.codePage, tt.encoded, got, tt.s)
+		}
+	}
+
+	// Characters the code page lacks become '?'
+	if got := DefaultCharset.Encode("Жa日"); string(got) != "?a?" {
+		t.Errorf("Encode of unrepresentable characters = %q, want \"?a?\"", got)
+	}
+}
+
+func TestWriteRowVarCharCodePage(t *testing.T) {
+	cyrillic, _ := CharsetForCodePage(1251)
+	cols := []Column{{Name: "s", Type: TypeBigVarChar, Length: 2, Collation: cyrillic.Collation(), Nullable: true}}
+	tw := NewTokenWriter()
+	if err := NewResultSetWriter(tw, cols).WriteRow([]interface{}{"Жук"}); err != nil {
+		t.Fatal(err)
+	}
+	// Two bytes of the code page, not of UTF-8
+	if got, want := tw.Bytes()[1:], []byte{2, 0, 0xC6, 0xF3}; !bytes.Equal(got, want) {
+		t.Errorf("row = % x, want % x", got, want)
+	}
+}
+
+func TestParseRPCRequestVarCharCodePage(t *testing.T) {
+	varchar := func(buf *bytes.Buffer, collation, value []byte) {
+		buf.WriteByte(0) // positional
+		buf.WriteByte(0) // status
+		buf.WriteByte(byte(TypeBigVarChar))
+		binary.Write(buf, binary.LittleEndian, uint16(8000))
+		buf.Write(collation)
+		binary.Write(buf, binary.LittleEndian, uint16(len(value)))
+		buf.Write(value)
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(4))
+	name := encodeUTF16LE("Find")
+	binary.Write(&buf, binary.LittleEndian, uint16(len(name)/2))
+	buf.Write(name)
+	binary.Write(&buf, binary.LittleEndian, uint16(0))
+	varchar(&buf, []byte{0x19, 0x04, 0xD0, 0x00, 0}, []byte{0xC6, 0xF3, 0xEA}) // Cyrillic_General
+	varchar(&buf, []byte{0, 0, 0, 0, 0}, []byte{0xC6, 0xF3, 0xEA})             // no collation
+
+	greek, _ := CharsetForCodePage(1253)
+	req, err := ParseRPCRequestCharset(buf.Bytes(), VerTDS74, greek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Parameters[0].Value; got != "Жук" {
+		t.Errorf("parameter in its collation's code page = %q, want \"Жук\"", got)
+	}
+	if got := req.Parameters[1].Value; got != "Ζσκ" {
+		t.Errorf("parameter in the connection's code page = %q, want \"Ζσκ\"", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"slices"
 	"sync"
 	"time"
 )
@@ -13,6 +14,7 @@ import (
 // Conn represents a TDS connection from a client.
 type Conn struct {
 	mu         sync.Mutex
+	readMu     sync.Mutex // Held by reads, which can wait on the client while results are written
 	netConn    net.Conn
 	reader     *bufio.Reader
 	writer     *bufio.Writer
@@ -20,6 +22,12 @@ type Conn struct {
 	spid       uint16
 	packetSeq  uint8
 
+	// hdr holds packet headers being written, under mu, and readHdr those
+	// being read, under readMu. A local array would escape through the io
+	// interfaces and cost an allocation per packet.
+	hdr     [HeaderSize]byte
+	readHdr [HeaderSize]byte
+
 	// TLS connection (set after TLS handshake)
 	tlsConn *tls.Conn
 	
@@ -186,15 +194,15 @@ func (c *Conn) ReadPacket() (PacketType, []byte, error) {
 // ReadPacketWithStatus reads a complete TDS packet and returns the status byte.
 // This is needed to detect connection reset requests (StatusResetConnection).
 func (c *Conn) ReadPacketWithStatus() (PacketType, PacketStatus, []byte, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
 
 	if c.readTimeout > 0 {
 		c.netConn.SetReadDeadline(time.Now().Add(c.readTimeout))
 	}
 
 	// Read first header
-	hdr, err := ReadHeader(c.reader)
+	hdr, err := c.readHeader()
 	if err != nil {
 		return 0, 0, nil, fmt.Errorf("reading packet header: %w", err)
 	}
@@ -210,16 +218,10 @@ func (c *Conn) ReadPacketWithStatus() (PacketType, PacketStatus, []byte, error)
 		return 0, 0, nil, fmt.Errorf("packet too large: %d > %d", hdr.Length, c.packetSize)
 	}
 
-	// Allocate buffer for message
+	// Payloads are read straight into the message buffer
 	var data []byte
-	payloadLen := hdr.PayloadLength()
-	if payloadLen > 0 {
-		data = make([]byte, 0, payloadLen)
-		chunk := make([]byte, payloadLen)
-		if _, err := io.ReadFull(c.reader, chunk); err != nil {
-			return 0, 0, nil, fmt.Errorf("reading packet payload: %w", err)
-		}
-		data = append(data, chunk...)
+	if data, err = c.readPayload(data, hdr.PayloadLength()); err != nil {
+		return 0, 0, nil, fmt.Errorf("reading packet payload: %w", err)
 	}
 
 	// Read continuation packets if not EOM
@@ -228,24 +230,58 @@ func (c *Conn) ReadPacketWithStatus() (PacketType, PacketStatus, []byte, error)
 			c.netConn.SetReadDeadline(time.Now().Add(c.readTimeout))
 		}
 
-		hdr, err = ReadHeader(c.reader)
+		hdr, err = c.readHeader()
 		if err != nil {
 			return 0, 0, nil, fmt.Errorf("reading continuation header: %w", err)
 		}
 
-		payloadLen = hdr.PayloadLength()
-		if payloadLen > 0 {
-			chunk := make([]byte, payloadLen)
-			if _, err := io.ReadFull(c.reader, chunk); err != nil {
-				return 0, 0, nil, fmt.Errorf("reading continuation payload: %w", err)
-			}
-			data = append(data, chunk...)
+		if data, err = c.readPayload(data, hdr.PayloadLength()); err != nil {
+			return 0, 0, nil, fmt.Errorf("reading continuation payload: %w", err)
 		}
 	}
 
 	return hdr.Type, status, data, nil
 }
 
+// readHeader reads a packet header into the connection's header buffer.
+// The caller holds c.readMu.
+func (c *Conn) readHeader() (Header, error) {
+	if _, err := io.ReadFull(c.reader, c.readHdr[:]); err != nil {
+		return Header{}, err
+	}
+	return decodeHeader(c.readHdr[:]), nil
+}
+
+// PeekPacketType waits for the client's next packet and returns its type,
+// leaving the packet to be read. It is how an attention is noticed while a
+// request runs; a read deadline set on the network connection ends the
+// wait, with the deadline's error.
+func (c *Conn) PeekPacketType() (PacketType, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	b, err := c.reader.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return PacketType(b[0]), nil
+}
+
+// readPayload reads n payload bytes onto the end of data, growing it in
+// place rather than through an intermediate chunk.
+func (c *Conn) readPayload(data []byte, n int) ([]byte, error) {
+	if n <= 0 {
+		return data, nil
+	}
+	data = slices.Grow(data, n)
+	start := len(data)
+	data = data[:start+n]
+	if _, err := io.ReadFull(c.reader, data[start:]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // ResetConnection flag check
 func (s PacketStatus) IsResetConnection() bool {
 	return s&StatusResetConnection != 0
@@ -282,26 +318,8 @@ func (c *Conn) WritePacket(pktType PacketType, data []byte) error {
 		if isLast {
 			status = StatusEOM
 		}
-
-		hdr := Header{
-			Type:     pktType,
-			Status:   status,
-			Length:   uint16(HeaderSize + len(chunk)),
-			SPID:     c.spid,
-			PacketID: c.packetSeq,
-			Window:   0,
-		}
-
-		if err := hdr.Write(c.writer); err != nil {
-			return fmt.Errorf("writing packet header: %w", err)
-		}
-		if _, err := c.writer.Write(chunk); err != nil {
-			return fmt.Errorf("writing packet data: %w", err)
-		}
-
-		c.packetSeq++
-		if c.packetSeq == 0 {
-			c.packetSeq = 1
+		if err := c.writePacketLocked(pktType, status, chunk); err != nil {
+			return err
 		}
 
 		if isLast {
@@ -312,6 +330,54 @@ func (c *Conn) WritePacket(pktType PacketType, data []byte) error {
 	return c.writer.Flush()
 }
 
+// writePacketLocked writes one packet of a message; c.mu is held.
+func (c *Conn) writePacketLocked(pktType PacketType, status PacketStatus, chunk []byte) error {
+	hdr := Header{
+		Type:     pktType,
+		Status:   status,
+		Length:   uint16(HeaderSize + len(chunk)),
+		SPID:     c.spid,
+		PacketID: c.packetSeq,
+		Window:   0,
+	}
+
+	hdr.encode(c.hdr[:])
+	if _, err := c.writer.Write(c.hdr[:]); err != nil {
+		return fmt.Errorf("writing packet header: %w", err)
+	}
+	if _, err := c.writer.Write(chunk); err != nil {
+		return fmt.Errorf("writing packet data: %w", err)
+	}
+
+	c.packetSeq++
+	if c.packetSeq == 0 {
+		c.packetSeq = 1
+	}
+	return nil
+}
+
+// WritePartialTokens sends the full packets of a token stream still being
+// written as REPLY packets that do not end the message, leaving the rest
+// in tw; WriteTokens ends the message. A large result is so sent as it is
+// produced rather than held until it is complete.
+func (c *Conn) WritePartialTokens(tw *TokenWriter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.writeTimeout > 0 {
+		c.netConn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+
+	maxPayload := c.packetSize - HeaderSize
+	// The last packet is kept back: only WriteTokens may end the message
+	for tw.buf.Len() > maxPayload {
+		if err := c.writePacketLocked(PacketReply, StatusNormal, tw.buf.Next(maxPayload)); err != nil {
+			return err
+		}
+	}
+	return c.writer.Flush()
+}
+
 // WriteTokens writes a token stream as a REPLY packet.
 func (c *Conn) WriteTokens(tw *TokenWriter) error {
 	return c.WritePacket(PacketReply, tw.Bytes())
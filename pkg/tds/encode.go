@@ -0,0 +1,151 @@
+package tds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Little-endian writes into a token buffer. binary.Write boxes its value
+// and allocates a scratch slice on every call, once per column of every
+// row; appending to the buffer's spare capacity does neither.
+
+func putUint16(buf *bytes.Buffer, v uint16) {
+	buf.Write(binary.LittleEndian.AppendUint16(buf.AvailableBuffer(), v))
+}
+
+func putUint32(buf *bytes.Buffer, v uint32) {
+	buf.Write(binary.LittleEndian.AppendUint32(buf.AvailableBuffer(), v))
+}
+
+func putUint64(buf *bytes.Buffer, v uint64) {
+	buf.Write(binary.LittleEndian.AppendUint64(buf.AvailableBuffer(), v))
+}
+
+// appendUCS2 appends s to b as UTF-16LE. With max >= 0 it stops before
+// the character that would take the encoding past max bytes, so a
+// truncated value never ends in half a surrogate pair.
+func appendUCS2(b []byte, s string, max int) []byte {
+	n := 0
+	for _, r := range s {
+		size := 2
+		if r >= 0x10000 {
+			size = 4
+		}
+		if max >= 0 && n+size > max {
+			break
+		}
+		n += size
+		if size == 4 {
+			r1, r2 := utf16.EncodeRune(r)
+			b = binary.LittleEndian.AppendUint16(b, uint16(r1))
+			b = binary.LittleEndian.AppendUint16(b, uint16(r2))
+		} else {
+			b = binary.LittleEndian.AppendUint16(b, uint16(r))
+		}
+	}
+	return b
+}
+
+// putUSVarUCS2 writes s as a USHORT byte count followed by at most max
+// bytes of UTF-16LE, encoding straight into the buffer.
+func putUSVarUCS2(buf *bytes.Buffer, s string, max int) {
+	start := buf.Len()
+	putUint16(buf, 0)
+	b := appendUCS2(buf.AvailableBuffer(), s, max)
+	buf.Write(b)
+	binary.LittleEndian.PutUint16(buf.Bytes()[start:], uint16(len(b)))
+}
+
+// putPLP writes b as a PLP value of one chunk: its total length, the
+// chunk and the terminating chunk of length 0.
+func putPLP(buf *bytes.Buffer, b []byte) {
+	putUint64(buf, uint64(len(b)))
+	if len(b) > 0 {
+		putUint32(buf, uint32(len(b)))
+		buf.Write(b)
+	}
+	putUint32(buf, 0)
+}
+
+// putBVarUCS2 writes s as a B_VARCHAR: a BYTE count of UTF-16 code units
+// followed by the UTF-16LE text, at most 255 units of it.
+func putBVarUCS2(buf *bytes.Buffer, s string) {
+	start := buf.Len()
+	buf.WriteByte(0)
+	b := appendUCS2(buf.AvailableBuffer(), s, 255*2)
+	buf.Write(b)
+	buf.Bytes()[start] = byte(len(b) / 2)
+}
+
+// DecodeUCS2 converts UTF-16LE bytes to a string. A trailing odd byte is
+// ignored and an unpaired surrogate becomes U+FFFD, as with utf16.Decode.
+//
+// The UTF-8 length is measured first so that the string is built in a
+// single exactly sized allocation, without the []uint16 and []rune
+// intermediates. The ASCII prefix, usually the whole of a SQL batch, is
+// copied a byte at a time without rune decoding.
+func DecodeUCS2(b []byte) string {
+	units := len(b) / 2
+	if units == 0 {
+		return ""
+	}
+
+	ascii := 0
+	for ascii < units && b[2*ascii+1] == 0 && b[2*ascii] < utf8.RuneSelf {
+		ascii++
+	}
+	size := ascii
+	for i := ascii; i < units; i++ {
+		r, wide := decodeUnit(b, i, units)
+		if wide {
+			i++
+		}
+		size += utf8.RuneLen(r)
+	}
+
+	out := make([]byte, 0, size)
+	for i := 0; i < ascii; i++ {
+		out = append(out, b[2*i])
+	}
+	for i := ascii; i < units; i++ {
+		r, wide := decodeUnit(b, i, units)
+		if wide {
+			i++
+		}
+		out = utf8.AppendRune(out, r)
+	}
+	// out is never written again, so the string can share its memory.
+	return unsafe.String(unsafe.SliceData(out), len(out))
+}
+
+// decodeUnit decodes the character starting at code unit i of b, which
+// holds units code units. wide reports that it took a surrogate pair.
+func decodeUnit(b []byte, i, units int) (r rune, wide bool) {
+	r = rune(binary.LittleEndian.Uint16(b[2*i:]))
+	if !utf16.IsSurrogate(r) {
+		return r, false
+	}
+	if r < 0xDC00 && i+1 < units {
+		r2 := rune(binary.LittleEndian.Uint16(b[2*i+2:]))
+		if dec := utf16.DecodeRune(r, r2); dec != utf8.RuneError {
+			return dec, true
+		}
+	}
+	return utf8.RuneError, false
+}
+
+// putUSVarChars writes s as a USHORT byte count followed by at most max
+// bytes of it in the code page cs.
+func putUSVarChars(buf *bytes.Buffer, cs *Charset, s string, max int) {
+	start := buf.Len()
+	putUint16(buf, 0)
+	b := cs.AppendEncoded(buf.AvailableBuffer(), s)
+	if max >= 0 && len(b) > max {
+		b = b[:max]
+	}
+	buf.Write(b)
+	binary.LittleEndian.PutUint16(buf.Bytes()[start:], uint16(len(b)))
+}
@@ -0,0 +1,113 @@
+package tds
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// referenceDecodeUCS2 is the straightforward decoding DecodeUCS2 must
+// agree with.
+func referenceDecodeUCS2(b []byte) string {
+	u16 := make([]uint16, len(b)/2)
+	for i := range u16 {
+		u16[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u16))
+}
+
+func units(u ...uint16) []byte {
+	b := make([]byte, 0, len(u)*2)
+	for _, v := range u {
+		b = binary.LittleEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func TestDecodeUCS2(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty", nil},
+		{"ascii", stringToUCS2("SELECT * FROM dbo.Orders")},
+		{"latin", stringToUCS2("Crème brûlée")},
+		{"cjk", stringToUCS2("数据库")},
+		{"surrogate pair", stringToUCS2("ok \U0001F600")},
+		{"odd trailing byte", append(stringToUCS2("abc"), 'x')},
+		{"lone high surrogate", units('a', 0xD83D)},
+		{"lone low surrogate", units(0xDE00, 'a')},
+		{"high surrogate then ascii", units(0xD83D, 'a')},
+		{"nul", units('a', 0, 'b')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := referenceDecodeUCS2(tt.in)
+			if got := DecodeUCS2(tt.in); got != want {
+				t.Errorf("DecodeUCS2 = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestUCS2RoundTrip(t *testing.T) {
+	for _, s := range []string{"", "abc", "Crème", "数据库", "\U0001F600x", "a\x00b"} {
+		if got := DecodeUCS2(stringToUCS2(s)); got != s {
+			t.Errorf("round trip of %q = %q", s, got)
+		}
+	}
+}
+
+func TestDecodeUCS2Allocs(t *testing.T) {
+	for _, s := range []string{"SELECT 1", "Crème brûlée \U0001F600"} {
+		b := stringToUCS2(s)
+		if allocs := testing.AllocsPerRun(100, func() { DecodeUCS2(b) }); allocs != 1 {
+			t.Errorf("DecodeUCS2(%q) allocates %.0f times, want 1", s, allocs)
+		}
+	}
+}
+
+var (
+	benchASCII   = strings.Repeat("SELECT OrderID, CustomerName FROM dbo.Orders ", 20)
+	benchUnicode = strings.Repeat("Crème brûlée, 数据库 ", 20)
+)
+
+func BenchmarkDecodeUCS2(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		s    string
+	}{{"ascii", benchASCII}, {"unicode", benchUnicode}} {
+		in := stringToUCS2(bench.s)
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				DecodeUCS2(in)
+			}
+		})
+		b.Run(bench.name+"/reference", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				referenceDecodeUCS2(in)
+			}
+		})
+	}
+}
+
+func BenchmarkAppendUCS2(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		s    string
+	}{{"ascii", benchASCII}, {"unicode", benchUnicode}} {
+		buf := make([]byte, 0, len(bench.s)*2)
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(bench.s)))
+			for i := 0; i < b.N; i++ {
+				buf = appendUCS2(buf[:0], bench.s, -1)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package tds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// rpcSeed builds an RPC request with a single NVARCHAR parameter.
+func rpcSeed(procID uint16, value string) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(4)) // ALL_HEADERS
+	binary.Write(&buf, binary.LittleEndian, uint16(0xFFFF))
+	binary.Write(&buf, binary.LittleEndian, procID)
+	binary.Write(&buf, binary.LittleEndian, uint16(0)) // Option flags
+	buf.WriteByte(0)                                   // Name length
+	buf.WriteByte(0)                                   // Status
+	buf.WriteByte(byte(TypeNVarChar))
+	binary.Write(&buf, binary.LittleEndian, uint16(8000))
+	buf.Write([]byte{0x09, 0x04, 0xD0, 0x00, 0x34}) // Collation
+	text := encodeUTF16LE(value)
+	binary.Write(&buf, binary.LittleEndian, uint16(len(text)))
+	buf.Write(text)
+	return buf.Bytes()
+}
+
+// login7Seed builds a LOGIN7 payload with a user name and database.
+func login7Seed(user, database string) []byte {
+	userBytes := encodeUTF16LE(user)
+	dbBytes := encodeUTF16LE(database)
+	data := make([]byte, Login7HeaderSize+len(userBytes)+len(dbBytes))
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(data[4:8], VerTDS74)
+	binary.LittleEndian.PutUint32(data[8:12], 4096)
+	binary.LittleEndian.PutUint16(data[40:42], Login7HeaderSize)
+	binary.LittleEndian.PutUint16(data[42:44], uint16(len(user)))
+	binary.LittleEndian.PutUint16(data[68:70], uint16(Login7HeaderSize+len(userBytes)))
+	binary.LittleEndian.PutUint16(data[70:72], uint16(len(database)))
+	copy(data[Login7HeaderSize:], userBytes)
+	copy(data[Login7HeaderSize+len(userBytes):], dbBytes)
+	return data
+}
+
+// FuzzParseRPCRequest checks that malformed RPC payloads return an error
+// rather than panicking.
+func FuzzParseRPCRequest(f *testing.F) {
+	f.Add(rpcSeed(ProcIDExecuteSQL, "SELECT 1"), uint32(VerTDS74))
+	f.Add(rpcSeed(ProcIDPrepare, "SELECT @p1"), uint32(VerTDS74))
+	f.Add(rpcSeed(ProcIDExecute, ""), uint32(VerTDS71))
+	f.Add([]byte{0x04, 0, 0, 0, 0xFF, 0xFF}, uint32(VerTDS74))
+	f.Add([]byte{}, uint32(0))
+
+	f.Fuzz(func(t *testing.T, data []byte, version uint32) {
+		req, err := ParseRPCRequest(data, version)
+		if err == nil && req == nil {
+			t.Fatal("nil request without error")
+		}
+	})
+}
+
+// FuzzParseLogin7 checks that malformed LOGIN7 payloads return an error
+// rather than panicking.
+func FuzzParseLogin7(f *testing.F) {
+	f.Add(login7Seed("sa", "master"))
+	f.Add(login7Seed("", ""))
+	f.Add(make([]byte, Login7HeaderSize))
+	f.Add([]byte{0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		login, err := ParseLogin7(data)
+		if err == nil && login == nil {
+			t.Fatal("nil login without error")
+		}
+	})
+}
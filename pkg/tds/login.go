@@ -3,7 +3,6 @@ package tds
 import (
 	"encoding/binary"
 	"fmt"
-	"unicode/utf16"
 )
 
 // Login7 option flags.
@@ -275,7 +274,7 @@ func readUCS2String(data []byte, offset, length uint16) (string, error) {
 			byteOffset, byteLen, len(data))
 	}
 
-	return ucs2ToString(data[byteOffset : byteOffset+byteLen]), nil
+	return DecodeUCS2(data[byteOffset : byteOffset+byteLen]), nil
 }
 
 // readMangledPassword reads and demangles a password from the LOGIN7 packet.
@@ -302,32 +301,12 @@ func readMangledPassword(data []byte, offset, length uint16) (string, error) {
 		mangled[i] = (b >> 4) | (b << 4)
 	}
 
-	return ucs2ToString(mangled), nil
-}
-
-// ucs2ToString converts UCS-2 (UTF-16LE) bytes to a Go string.
-func ucs2ToString(b []byte) string {
-	if len(b) == 0 {
-		return ""
-	}
-	if len(b)%2 != 0 {
-		b = b[:len(b)-1]
-	}
-
-	u16 := make([]uint16, len(b)/2)
-	for i := 0; i < len(u16); i++ {
-		u16[i] = binary.LittleEndian.Uint16(b[i*2:])
-	}
-
-	return string(utf16.Decode(u16))
+	return DecodeUCS2(mangled), nil
 }
 
 // stringToUCS2 converts a Go string to UCS-2 (UTF-16LE) bytes.
 func stringToUCS2(s string) []byte {
-	u16 := utf16.Encode([]rune(s))
-	b := make([]byte, len(u16)*2)
-	for i, v := range u16 {
-		binary.LittleEndian.PutUint16(b[i*2:], v)
-	}
-	return b
+	// Two bytes per UTF-8 byte is always enough: a four-byte sequence
+	// becomes a surrogate pair.
+	return appendUCS2(make([]byte, 0, len(s)*2), s, -1)
 }
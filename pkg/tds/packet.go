@@ -130,7 +130,11 @@ func ReadHeader(r io.Reader) (Header, error) {
 	if _, err := io.ReadFull(r, buf[:]); err != nil {
 		return Header{}, err
 	}
+	return decodeHeader(buf[:]), nil
+}
 
+// decodeHeader parses a header from its HeaderSize bytes.
+func decodeHeader(buf []byte) Header {
 	return Header{
 		Type:     PacketType(buf[0]),
 		Status:   PacketStatus(buf[1]),
@@ -138,20 +142,25 @@ func ReadHeader(r io.Reader) (Header, error) {
 		SPID:     binary.BigEndian.Uint16(buf[4:6]),
 		PacketID: buf[6],
 		Window:   buf[7],
-	}, nil
+	}
 }
 
 // Write writes the header to the given writer.
 func (h Header) Write(w io.Writer) error {
 	var buf [HeaderSize]byte
+	h.encode(buf[:])
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// encode stores the header in buf, which must hold HeaderSize bytes.
+func (h Header) encode(buf []byte) {
 	buf[0] = byte(h.Type)
 	buf[1] = byte(h.Status)
 	binary.BigEndian.PutUint16(buf[2:4], h.Length)
 	binary.BigEndian.PutUint16(buf[4:6], h.SPID)
 	buf[6] = h.PacketID
 	buf[7] = h.Window
-	_, err := w.Write(buf[:])
-	return err
 }
 
 // PayloadLength returns the length of the packet payload (excluding header).
@@ -166,3 +175,35 @@ func (h Header) PayloadLength() int {
 func (h Header) IsLastPacket() bool {
 	return h.Status&StatusEOM != 0
 }
+
+// ALL_HEADERS header types, sent ahead of SQL batches and RPC requests.
+const (
+	HeaderQueryNotifications    uint16 = 0x0001
+	HeaderTransactionDescriptor uint16 = 0x0002
+	HeaderTraceActivity         uint16 = 0x0003
+)
+
+// TraceActivityID returns the ActivityId GUID of the trace activity header
+// in the ALL_HEADERS at the start of a request, which clients such as
+// SqlClient send to correlate their traces with the server's.
+func TraceActivityID(data []byte) ([]byte, bool) {
+	if len(data) < 4 {
+		return nil, false
+	}
+	total := int(binary.LittleEndian.Uint32(data[0:4]))
+	if total < 4 || total > len(data) {
+		return nil, false
+	}
+	for pos := 4; pos+6 <= total; {
+		length := int(binary.LittleEndian.Uint32(data[pos : pos+4]))
+		if length < 6 || pos+length > total {
+			return nil, false
+		}
+		// ActivityId (16 bytes) then ActivitySequence (4 bytes)
+		if binary.LittleEndian.Uint16(data[pos+4:pos+6]) == HeaderTraceActivity && length >= 6+20 {
+			return data[pos+6 : pos+6+16], true
+		}
+		pos += length
+	}
+	return nil, false
+}
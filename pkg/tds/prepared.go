@@ -2,19 +2,74 @@ package tds
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
 )
 
 // PreparedStatement holds a parsed statement ready for execution.
 type PreparedStatement struct {
 	Handle     int32
 	SQL        string
-	ParamDefs  string       // Parameter definitions: "@p1 int, @p2 nvarchar(100)"
-	ParamCount int          // Number of parameters
-	Columns    []Column     // Result column metadata (if known from prepare)
+	ParamDefs  string          // Parameter definitions: "@p1 int, @p2 nvarchar(100)"
+	ParamCount int             // Number of parameters
+	Params     []PreparedParam // Parameters declared in ParamDefs, in order
+	Columns    []Column        // Result column metadata (if known from prepare)
 	CreatedAt  time.Time
-	ExecCount  int64        // Execution count for statistics
+	ExecCount  int64 // Execution count for statistics
+}
+
+// PreparedParam is a parameter a prepared statement declares.
+type PreparedParam struct {
+	Name   string // Without its @
+	Output bool
+}
+
+// ParsePrepared parses a statement and its parameter definitions, as sent
+// to sp_prepare or sp_prepexec, into a PreparedStatement without a handle.
+// Either failing to parse is an error, so that a bad statement fails when
+// it is prepared rather than each time it is executed.
+func ParsePrepared(stmt string, paramDefs string) (*PreparedStatement, error) {
+	ps := &PreparedStatement{SQL: stmt, ParamDefs: paramDefs, CreatedAt: time.Now()}
+
+	if strings.TrimSpace(paramDefs) != "" {
+		// The definitions are those of a procedure's parameters
+		p := parser.New(lexer.New("CREATE PROCEDURE prepared " + paramDefs + " AS RETURN"))
+		program := p.ParseProgram()
+		proc, ok := firstStatement(program).(*ast.CreateProcedureStatement)
+		if len(p.Errors()) > 0 || !ok || len(proc.Parameters) == 0 {
+			return nil, fmt.Errorf("invalid parameter definitions %q", paramDefs)
+		}
+		for _, def := range proc.Parameters {
+			if !strings.HasPrefix(def.Name, "@") {
+				return nil, fmt.Errorf("invalid parameter definitions %q", paramDefs)
+			}
+			ps.Params = append(ps.Params, PreparedParam{Name: def.Name[1:], Output: def.Output})
+		}
+	}
+	ps.ParamCount = len(ps.Params)
+
+	p := parser.New(lexer.New(stmt))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, fmt.Errorf("%s", p.Errors()[0])
+	}
+	if len(program.Statements) == 0 {
+		return nil, fmt.Errorf("no statement to prepare")
+	}
+	return ps, nil
+}
+
+func firstStatement(program *ast.Program) ast.Statement {
+	if program == nil || len(program.Statements) == 0 {
+		return nil
+	}
+	return program.Statements[0]
 }
 
 // PreparedStatementStore manages prepared statements for a connection.
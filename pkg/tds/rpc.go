@@ -4,8 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"strings"
 	"time"
-	"unicode/utf16"
 
 	"github.com/shopspring/decimal"
 )
@@ -67,6 +67,20 @@ func ProcIDName(id uint16) string {
 	}
 }
 
+// ProcIDOf returns the ID of the system stored procedure a client names,
+// as in "sp_prepare" or "sys.sp_prepare", or 0 if it names none.
+func ProcIDOf(name string) uint16 {
+	name = strings.ToLower(strings.NewReplacer("[", "", "]", "").Replace(name))
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "master."), "sys.")
+	name = strings.TrimPrefix(name, ".") // master..sp_prepare
+	for id := ProcIDCursor; id <= ProcIDUnprepare; id++ {
+		if ProcIDName(id) == name {
+			return id
+		}
+	}
+	return 0
+}
+
 // RPC option flags.
 const (
 	RPCOptionWithRecomp   uint16 = 0x0001 // WITH RECOMPILE
@@ -111,11 +125,18 @@ type TypeInfo struct {
 // ParseRPCRequest parses an RPC_REQUEST packet payload.
 // The data should not include the TDS packet header.
 func ParseRPCRequest(data []byte, tdsVersion uint32) (*RPCRequest, error) {
+	return ParseRPCRequestCharset(data, tdsVersion, nil)
+}
+
+// ParseRPCRequestCharset parses an RPC_REQUEST packet payload, decoding
+// character parameters by the code page of their collation, or by
+// charset where the collation names none.
+func ParseRPCRequestCharset(data []byte, tdsVersion uint32, charset *Charset) (*RPCRequest, error) {
 	if len(data) < 4 {
 		return nil, fmt.Errorf("RPC request too short: %d bytes", len(data))
 	}
 
-	r := &rpcReader{data: data, pos: 0}
+	r := &rpcReader{data: data, pos: 0, charset: charset}
 	req := &RPCRequest{}
 
 	// Skip ALL_HEADERS if present (TDS 7.2+)
@@ -152,7 +173,7 @@ func ParseRPCRequest(data []byte, tdsVersion uint32) (*RPCRequest, error) {
 		if err != nil {
 			return nil, fmt.Errorf("reading proc name: %w", err)
 		}
-		req.ProcName = decodeUTF16(nameBytes)
+		req.ProcName = DecodeUCS2(nameBytes)
 	}
 
 	// Read option flags
@@ -176,8 +197,9 @@ func ParseRPCRequest(data []byte, tdsVersion uint32) (*RPCRequest, error) {
 
 // rpcReader helps parse RPC request data.
 type rpcReader struct {
-	data []byte
-	pos  int
+	data    []byte
+	pos     int
+	charset *Charset // for character data whose collation names no code page
 }
 
 func (r *rpcReader) readByte() (byte, error) {
@@ -276,7 +298,7 @@ func (r *rpcReader) readParameter() (RPCParam, error) {
 		if err != nil {
 			return param, fmt.Errorf("reading param name: %w", err)
 		}
-		param.Name = decodeUTF16(nameBytes)
+		param.Name = DecodeUCS2(nameBytes)
 		// Remove leading @ if present
 		if len(param.Name) > 0 && param.Name[0] == '@' {
 			param.Name = param.Name[1:]
@@ -661,22 +683,43 @@ func (r *rpcReader) readValue(ti TypeInfo) (interface{}, bool, error) {
 
 	// String types
 	case TypeChar, TypeVarChar:
-		return r.readShortVarChar()
+		return r.readShortVarChar(ti.Collation)
 
 	case TypeBigVarChar, TypeBigChar:
-		return r.readLongVarChar()
+		if ti.Size == MaxTypeLength {
+			b, isNull, err := r.readPLP()
+			if err != nil || isNull {
+				return nil, isNull, err
+			}
+			return r.decodeChars(b, ti.Collation), false, nil
+		}
+		return r.readLongVarChar(ti.Collation)
 
 	case TypeNVarChar, TypeNChar:
+		if ti.Size == MaxTypeLength {
+			b, isNull, err := r.readPLP()
+			if err != nil || isNull {
+				return nil, isNull, err
+			}
+			return DecodeUCS2(b), false, nil
+		}
 		return r.readNVarChar()
 
 	case TypeBinary, TypeVarBinary:
 		return r.readShortVarBinary()
 
 	case TypeBigVarBin, TypeBigBinary:
+		if ti.Size == MaxTypeLength {
+			b, isNull, err := r.readPLP()
+			if err != nil || isNull {
+				return nil, isNull, err
+			}
+			return b, false, nil
+		}
 		return r.readLongVarBinary()
 
 	case TypeText, TypeNText, TypeImage:
-		return r.readTextPointer(ti.TypeID)
+		return r.readTextPointer(ti)
 
 	case TypeXML:
 		return r.readXML()
@@ -905,7 +948,7 @@ func (r *rpcReader) readGUID() (interface{}, bool, error) {
 }
 
 // String reading
-func (r *rpcReader) readShortVarChar() (interface{}, bool, error) {
+func (r *rpcReader) readShortVarChar(collation []byte) (interface{}, bool, error) {
 	size, err := r.readByte()
 	if err != nil {
 		return nil, false, err
@@ -917,10 +960,10 @@ func (r *rpcReader) readShortVarChar() (interface{}, bool, error) {
 	if err != nil {
 		return nil, false, err
 	}
-	return string(b), false, nil
+	return r.decodeChars(b, collation), false, nil
 }
 
-func (r *rpcReader) readLongVarChar() (interface{}, bool, error) {
+func (r *rpcReader) readLongVarChar(collation []byte) (interface{}, bool, error) {
 	size, err := r.readUint16()
 	if err != nil {
 		return nil, false, err
@@ -932,7 +975,7 @@ func (r *rpcReader) readLongVarChar() (interface{}, bool, error) {
 	if err != nil {
 		return nil, false, err
 	}
-	return string(b), false, nil
+	return r.decodeChars(b, collation), false, nil
 }
 
 func (r *rpcReader) readNVarChar() (interface{}, bool, error) {
@@ -947,7 +990,7 @@ func (r *rpcReader) readNVarChar() (interface{}, bool, error) {
 	if err != nil {
 		return nil, false, err
 	}
-	return decodeUTF16(b), false, nil
+	return DecodeUCS2(b), false, nil
 }
 
 func (r *rpcReader) readShortVarBinary() (interface{}, bool, error) {
@@ -984,7 +1027,7 @@ func (r *rpcReader) readLongVarBinary() (interface{}, bool, error) {
 	return result, false, nil
 }
 
-func (r *rpcReader) readTextPointer(typeID SQLType) (interface{}, bool, error) {
+func (r *rpcReader) readTextPointer(ti TypeInfo) (interface{}, bool, error) {
 	// Text pointer length
 	tpLen, err := r.readByte()
 	if err != nil {
@@ -1006,81 +1049,72 @@ func (r *rpcReader) readTextPointer(typeID SQLType) (interface{}, bool, error) {
 	if err != nil {
 		return nil, false, err
 	}
-	if typeID == TypeNText {
-		return decodeUTF16(b), false, nil
+	if ti.TypeID == TypeNText {
+		return DecodeUCS2(b), false, nil
 	}
-	if typeID == TypeImage {
+	if ti.TypeID == TypeImage {
 		result := make([]byte, len(b))
 		copy(result, b)
 		return result, false, nil
 	}
-	return string(b), false, nil
+	return r.decodeChars(b, ti.Collation), false, nil
+}
+
+// decodeChars decodes VARCHAR, CHAR or TEXT data sent in collation.
+func (r *rpcReader) decodeChars(b, collation []byte) string {
+	if cs := CharsetOf(collation); cs != nil {
+		return cs.Decode(b)
+	}
+	return r.charset.Decode(b)
 }
 
 func (r *rpcReader) readXML() (interface{}, bool, error) {
 	// XML uses PLP (Partially Length-prefixed) format
+	b, isNull, err := r.readPLP()
+	if err != nil || isNull {
+		return nil, isNull, err
+	}
+	return DecodeUCS2(b), false, nil
+}
+
+// readPLP reads a PLP (Partially Length-prefixed) value, the encoding of
+// the MAX types and XML: its total length, or that it is unknown, then
+// chunks up to one of length 0.
+func (r *rpcReader) readPLP() ([]byte, bool, error) {
 	totalLen, err := r.readUint64()
 	if err != nil {
 		return nil, false, err
 	}
-	if totalLen == 0xFFFFFFFFFFFFFFFF {
+	if totalLen == plpNull {
 		return nil, true, nil
 	}
 
 	var result []byte
-	if totalLen == 0xFFFFFFFFFFFFFFFE {
-		// Unknown length, read chunks
-		for {
-			chunkLen, err := r.readUint32()
-			if err != nil {
-				return nil, false, err
-			}
-			if chunkLen == 0 {
-				break
-			}
-			chunk, err := r.readBytes(int(chunkLen))
-			if err != nil {
-				return nil, false, err
-			}
-			result = append(result, chunk...)
+	for {
+		chunkLen, err := r.readUint32()
+		if err != nil {
+			return nil, false, err
 		}
-	} else {
-		// Known length, but still chunked
-		for uint64(len(result)) < totalLen {
-			chunkLen, err := r.readUint32()
-			if err != nil {
-				return nil, false, err
-			}
-			if chunkLen == 0 {
-				break
-			}
-			chunk, err := r.readBytes(int(chunkLen))
-			if err != nil {
-				return nil, false, err
-			}
-			result = append(result, chunk...)
+		if chunkLen == 0 {
+			break
 		}
+		chunk, err := r.readBytes(int(chunkLen))
+		if err != nil {
+			return nil, false, err
+		}
+		result = append(result, chunk...)
 	}
-
-	return decodeUTF16(result), false, nil
-}
-
-// Helper functions for decoding values
-
-func decodeUTF16(b []byte) string {
-	if len(b) == 0 {
-		return ""
-	}
-	if len(b)%2 != 0 {
-		b = b[:len(b)-1]
+	if totalLen != plpUnknownLen && uint64(len(result)) != totalLen {
+		return nil, false, fmt.Errorf("PLP value of %d bytes, declared %d", len(result), totalLen)
 	}
-	u16 := make([]uint16, len(b)/2)
-	for i := 0; i < len(u16); i++ {
-		u16[i] = binary.LittleEndian.Uint16(b[i*2:])
+	if result == nil {
+		result = []byte{}
 	}
-	return string(utf16.Decode(u16))
+	return result, false, nil
 }
 
+// Helper functions for decoding values
+
 var baseDate1900 = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
 var baseDate0001 = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
 
@@ -31,6 +31,25 @@ func TestProcIDName(t *testing.T) {
 	}
 }
 
+func TestProcIDOf(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		id   uint16
+	}{
+		{"sp_prepare", ProcIDPrepare},
+		{"SP_PREPEXEC", ProcIDPrepExec},
+		{"sys.sp_execute", ProcIDExecute},
+		{"master..sp_unprepare", ProcIDUnprepare},
+		{"[sys].[sp_executesql]", ProcIDExecuteSQL},
+		{"dbo.sp_prepare_orders", 0},
+		{"dbo.MyProc", 0},
+	} {
+		if got := ProcIDOf(tt.name); got != tt.id {
+			t.Errorf("ProcIDOf(%q) = %d, want %d", tt.name, got, tt.id)
+		}
+	}
+}
+
 func TestParseRPCRequest_SpExecuteSQL(t *testing.T) {
 	// Build a minimal sp_executesql RPC request
 	var buf bytes.Buffer
@@ -416,6 +435,54 @@ func TestParseRPCRequest_VarBinaryParameter(t *testing.T) {
 	}
 }
 
+func TestParseRPCRequest_MaxParameter(t *testing.T) {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.LittleEndian, uint32(4))
+	binary.Write(&buf, binary.LittleEndian, uint16(0xFFFF))
+	binary.Write(&buf, binary.LittleEndian, uint16(ProcIDExecuteSQL))
+	binary.Write(&buf, binary.LittleEndian, uint16(0))
+
+	// @text NVARCHAR(MAX) = N'hello, world', sent in two chunks of a
+	// length not given ahead
+	paramName := "@text"
+	buf.WriteByte(byte(len(paramName)))
+	buf.Write(encodeUTF16LE(paramName))
+	buf.WriteByte(0)
+	buf.WriteByte(byte(TypeNVarChar))
+	binary.Write(&buf, binary.LittleEndian, uint16(MaxTypeLength))
+	buf.Write(DefaultCollation)
+	binary.Write(&buf, binary.LittleEndian, plpUnknownLen)
+	for _, chunk := range []string{"hello, ", "world"} {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(chunk)*2))
+		buf.Write(encodeUTF16LE(chunk))
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+
+	// @out VARBINARY(MAX) OUTPUT = NULL
+	paramName = "@out"
+	buf.WriteByte(byte(len(paramName)))
+	buf.Write(encodeUTF16LE(paramName))
+	buf.WriteByte(ParamByRefValue)
+	buf.WriteByte(byte(TypeBigVarBin))
+	binary.Write(&buf, binary.LittleEndian, uint16(MaxTypeLength))
+	binary.Write(&buf, binary.LittleEndian, plpNull)
+
+	req, err := ParseRPCRequest(buf.Bytes(), VerTDS74)
+	if err != nil {
+		t.Fatalf("ParseRPCRequest failed: %v", err)
+	}
+	if len(req.Parameters) != 2 {
+		t.Fatalf("len(Parameters) = %d, want 2", len(req.Parameters))
+	}
+	if v := req.Parameters[0].Value; v != "hello, world" {
+		t.Errorf("@text = %#v, want %q", v, "hello, world")
+	}
+	if out := req.Parameters[1]; !out.IsNull || !out.IsOutput || out.Type.Size != MaxTypeLength {
+		t.Errorf("@out = %+v, want a NULL VARBINARY(MAX) OUTPUT", out)
+	}
+}
+
 // Helper to encode string as UTF-16LE
 func encodeUTF16LE(s string) []byte {
 	b := make([]byte, len(s)*2)
@@ -594,3 +661,38 @@ func TestParseRPCRequest_SmallIntParameter(t *testing.T) {
 		t.Errorf("Value = %d, want -12345", v)
 	}
 }
+
+func TestTraceActivityID(t *testing.T) {
+	activity := bytes.Repeat([]byte{0xab}, 16)
+
+	var headers bytes.Buffer
+	// Transaction descriptor header, then the trace activity header
+	binary.Write(&headers, binary.LittleEndian, uint32(18))
+	binary.Write(&headers, binary.LittleEndian, HeaderTransactionDescriptor)
+	headers.Write(make([]byte, 12))
+	binary.Write(&headers, binary.LittleEndian, uint32(26))
+	binary.Write(&headers, binary.LittleEndian, HeaderTraceActivity)
+	headers.Write(activity)
+	binary.Write(&headers, binary.LittleEndian, uint32(1))
+
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, uint32(4+headers.Len()))
+	data.Write(headers.Bytes())
+	data.Write([]byte{'S', 0, 'E', 0})
+
+	id, ok := TraceActivityID(data.Bytes())
+	if !ok || !bytes.Equal(id, activity) {
+		t.Errorf("TraceActivityID = %x, %v", id, ok)
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		{4, 0, 0, 0},                     // No headers
+		{40, 0, 0, 0, 1, 2},              // Longer than the request
+		{10, 0, 0, 0, 99, 0, 0, 0, 3, 0}, // Header longer than ALL_HEADERS
+	} {
+		if id, ok := TraceActivityID(data); ok {
+			t.Errorf("TraceActivityID(%v) = %x", data, id)
+		}
+	}
+}
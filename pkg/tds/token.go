@@ -5,7 +5,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"strings"
+	"sync"
 
 	"github.com/shopspring/decimal"
 )
@@ -126,6 +128,30 @@ func NewTokenWriter() *TokenWriter {
 	return &TokenWriter{}
 }
 
+// maxPooledTokenBuffer caps the buffer a released writer may bring back
+// to the pool, so one very large result does not stay pinned in memory.
+const maxPooledTokenBuffer = 1 << 20
+
+var tokenWriterPool = sync.Pool{
+	New: func() interface{} { return new(TokenWriter) },
+}
+
+// AcquireTokenWriter returns an empty token writer from a pool, reusing
+// the buffer of an earlier response. Call Release once its bytes have
+// been written and are no longer referenced.
+func AcquireTokenWriter() *TokenWriter {
+	return tokenWriterPool.Get().(*TokenWriter)
+}
+
+// Release returns a writer obtained from AcquireTokenWriter to the pool.
+func (w *TokenWriter) Release() {
+	if w.buf.Cap() > maxPooledTokenBuffer {
+		return
+	}
+	w.buf.Reset()
+	tokenWriterPool.Put(w)
+}
+
 // Bytes returns the accumulated token stream bytes.
 func (w *TokenWriter) Bytes() []byte {
 	return w.buf.Bytes()
@@ -155,7 +181,7 @@ func (w *TokenWriter) WriteEnvChange(envType uint8, newValue, oldValue string) {
 	tokenLen := 1 + 1 + len(newBytes) + 1 + len(oldBytes)
 
 	w.buf.WriteByte(byte(TokenEnvChange))
-	binary.Write(&w.buf, binary.LittleEndian, uint16(tokenLen))
+	putUint16(&w.buf, uint16(tokenLen))
 	w.buf.WriteByte(envType)
 	w.buf.WriteByte(byte(newLen))
 	w.buf.Write(newBytes)
@@ -169,7 +195,7 @@ func (w *TokenWriter) WriteEnvChangeCollation(newCollation, oldCollation []byte)
 	tokenLen := 1 + 1 + len(newCollation) + 1 + len(oldCollation)
 
 	w.buf.WriteByte(byte(TokenEnvChange))
-	binary.Write(&w.buf, binary.LittleEndian, uint16(tokenLen))
+	putUint16(&w.buf, uint16(tokenLen))
 	w.buf.WriteByte(EnvSQLCollation)
 	w.buf.WriteByte(byte(len(newCollation)))
 	w.buf.Write(newCollation)
@@ -193,36 +219,36 @@ func (w *TokenWriter) WriteLoginAck(iface LoginAckInterface, tdsVersion uint32,
 	tokenLen := 1 + 4 + 1 + len(progNameBytes) + 4
 
 	w.buf.WriteByte(byte(TokenLoginAck))
-	binary.Write(&w.buf, binary.LittleEndian, uint16(tokenLen))
+	putUint16(&w.buf, uint16(tokenLen))
 	w.buf.WriteByte(byte(iface))
-	binary.Write(&w.buf, binary.BigEndian, tdsVersion) // TDS version is big-endian here
+	w.buf.Write(binary.BigEndian.AppendUint32(w.buf.AvailableBuffer(), tdsVersion)) // TDS version is big-endian here
 	w.buf.WriteByte(byte(len(progName)))
 	w.buf.Write(progNameBytes)
-	binary.Write(&w.buf, binary.BigEndian, progVersion)
+	w.buf.Write(binary.BigEndian.AppendUint32(w.buf.AvailableBuffer(), progVersion))
 }
 
 // WriteDone writes a DONE token.
 func (w *TokenWriter) WriteDone(status uint16, curCmd uint16, rowCount uint64) {
 	w.buf.WriteByte(byte(TokenDone))
-	binary.Write(&w.buf, binary.LittleEndian, status)
-	binary.Write(&w.buf, binary.LittleEndian, curCmd)
-	binary.Write(&w.buf, binary.LittleEndian, rowCount)
+	putUint16(&w.buf, status)
+	putUint16(&w.buf, curCmd)
+	putUint64(&w.buf, rowCount)
 }
 
 // WriteDoneProc writes a DONEPROC token.
 func (w *TokenWriter) WriteDoneProc(status uint16, curCmd uint16, rowCount uint64) {
 	w.buf.WriteByte(byte(TokenDoneProc))
-	binary.Write(&w.buf, binary.LittleEndian, status)
-	binary.Write(&w.buf, binary.LittleEndian, curCmd)
-	binary.Write(&w.buf, binary.LittleEndian, rowCount)
+	putUint16(&w.buf, status)
+	putUint16(&w.buf, curCmd)
+	putUint64(&w.buf, rowCount)
 }
 
 // WriteDoneInProc writes a DONEINPROC token.
 func (w *TokenWriter) WriteDoneInProc(status uint16, curCmd uint16, rowCount uint64) {
 	w.buf.WriteByte(byte(TokenDoneInProc))
-	binary.Write(&w.buf, binary.LittleEndian, status)
-	binary.Write(&w.buf, binary.LittleEndian, curCmd)
-	binary.Write(&w.buf, binary.LittleEndian, rowCount)
+	putUint16(&w.buf, status)
+	putUint16(&w.buf, curCmd)
+	putUint64(&w.buf, rowCount)
 }
 
 // WriteError writes an ERROR token.
@@ -248,17 +274,17 @@ func (w *TokenWriter) WriteError(number int32, state, class uint8, message, serv
 	tokenLen := 4 + 1 + 1 + 2 + len(msgBytes) + 1 + len(serverBytes) + 1 + len(procBytes) + 4
 
 	w.buf.WriteByte(byte(TokenError))
-	binary.Write(&w.buf, binary.LittleEndian, uint16(tokenLen))
-	binary.Write(&w.buf, binary.LittleEndian, number)
+	putUint16(&w.buf, uint16(tokenLen))
+	putUint32(&w.buf, uint32(number))
 	w.buf.WriteByte(state)
 	w.buf.WriteByte(class)
-	binary.Write(&w.buf, binary.LittleEndian, uint16(len(message)))
+	putUint16(&w.buf, uint16(len(message)))
 	w.buf.Write(msgBytes)
 	w.buf.WriteByte(byte(len(serverName)))
 	w.buf.Write(serverBytes)
 	w.buf.WriteByte(byte(len(procName)))
 	w.buf.Write(procBytes)
-	binary.Write(&w.buf, binary.LittleEndian, lineNumber)
+	putUint32(&w.buf, uint32(lineNumber))
 }
 
 // WriteInfo writes an INFO token (same format as ERROR but different token type).
@@ -270,23 +296,23 @@ func (w *TokenWriter) WriteInfo(number int32, state, class uint8, message, serve
 	tokenLen := 4 + 1 + 1 + 2 + len(msgBytes) + 1 + len(serverBytes) + 1 + len(procBytes) + 4
 
 	w.buf.WriteByte(byte(TokenInfo))
-	binary.Write(&w.buf, binary.LittleEndian, uint16(tokenLen))
-	binary.Write(&w.buf, binary.LittleEndian, number)
+	putUint16(&w.buf, uint16(tokenLen))
+	putUint32(&w.buf, uint32(number))
 	w.buf.WriteByte(state)
 	w.buf.WriteByte(class)
-	binary.Write(&w.buf, binary.LittleEndian, uint16(len(message)))
+	putUint16(&w.buf, uint16(len(message)))
 	w.buf.Write(msgBytes)
 	w.buf.WriteByte(byte(len(serverName)))
 	w.buf.Write(serverBytes)
 	w.buf.WriteByte(byte(len(procName)))
 	w.buf.Write(procBytes)
-	binary.Write(&w.buf, binary.LittleEndian, lineNumber)
+	putUint32(&w.buf, uint32(lineNumber))
 }
 
 // WriteReturnStatus writes a RETURNSTATUS token.
 func (w *TokenWriter) WriteReturnStatus(value int32) {
 	w.buf.WriteByte(byte(TokenReturnStatus))
-	binary.Write(&w.buf, binary.LittleEndian, value)
+	putUint32(&w.buf, uint32(value))
 }
 
 // WriteReturnValue writes a RETURNVALUE token for output parameters.
@@ -294,39 +320,34 @@ func (w *TokenWriter) WriteReturnStatus(value int32) {
 func (w *TokenWriter) WriteReturnValue(ordinal uint16, paramName string, status uint8, userType uint32, col Column, value interface{}) {
 	w.buf.WriteByte(byte(TokenReturnValue))
 
-	// Build the token payload first to calculate length
-	var payload bytes.Buffer
+	// The token has no length of its own: the client reads the value by
+	// its TYPE_INFO
+	payload := &w.buf
 
 	// ParamOrdinal (USHORT)
-	binary.Write(&payload, binary.LittleEndian, ordinal)
+	putUint16(payload, ordinal)
 
 	// ParamName (B_VARCHAR: 1-byte length in chars, UTF-16LE string)
-	paramNameBytes := stringToUCS2(paramName)
-	payload.WriteByte(byte(len(paramName)))
-	payload.Write(paramNameBytes)
+	putBVarUCS2(payload, paramName)
 
 	// Status (BYTE) - 0x01 if output param, 0x02 if user-defined type
 	payload.WriteByte(status)
 
 	// UserType (ULONG for TDS 7.2+)
-	binary.Write(&payload, binary.LittleEndian, userType)
+	putUint32(payload, userType)
 
 	// Flags (USHORT)
 	flags := col.Flags
 	if col.Nullable {
 		flags |= ColFlagNullable
 	}
-	binary.Write(&payload, binary.LittleEndian, flags)
+	putUint16(payload, flags)
 
 	// TYPE_INFO - write type and metadata
-	writeTypeInfo(&payload, col)
+	writeTypeInfo(payload, col)
 
 	// Value
-	writeValue(&payload, value, col)
-
-	// Write length and payload
-	binary.Write(&w.buf, binary.LittleEndian, uint16(payload.Len()))
-	w.buf.Write(payload.Bytes())
+	writeValue(payload, value, col)
 }
 
 // writeTypeInfo writes the TYPE_INFO portion for a return value.
@@ -382,7 +403,7 @@ func writeTypeInfo(buf *bytes.Buffer, col Column) {
 		}
 
 	case TypeBigVarChar, TypeBigChar, TypeBigVarBin, TypeBigBinary:
-		binary.Write(buf, binary.LittleEndian, uint16(col.Length))
+		putUint16(buf, uint16(col.Length))
 		if col.Type == TypeBigVarChar || col.Type == TypeBigChar {
 			if len(col.Collation) >= 5 {
 				buf.Write(col.Collation[:5])
@@ -392,7 +413,7 @@ func writeTypeInfo(buf *bytes.Buffer, col Column) {
 		}
 
 	case TypeNVarChar, TypeNChar:
-		binary.Write(buf, binary.LittleEndian, uint16(col.Length))
+		putUint16(buf, uint16(col.Length))
 		if len(col.Collation) >= 5 {
 			buf.Write(col.Collation[:5])
 		} else {
@@ -416,11 +437,11 @@ func writeValue(buf *bytes.Buffer, val interface{}, col Column) {
 		case 1:
 			buf.WriteByte(byte(v))
 		case 2:
-			binary.Write(buf, binary.LittleEndian, int16(v))
+			putUint16(buf, uint16(v))
 		case 4:
-			binary.Write(buf, binary.LittleEndian, int32(v))
+			putUint32(buf, uint32(v))
 		case 8:
-			binary.Write(buf, binary.LittleEndian, v)
+			putUint64(buf, uint64(v))
 		}
 
 	case TypeBitN:
@@ -436,26 +457,35 @@ func writeValue(buf *bytes.Buffer, val interface{}, col Column) {
 		v, _ := toFloat64(val)
 		buf.WriteByte(byte(col.Length))
 		if col.Length == 4 {
-			binary.Write(buf, binary.LittleEndian, float32(v))
+			putUint32(buf, math.Float32bits(float32(v)))
 		} else {
-			binary.Write(buf, binary.LittleEndian, v)
+			putUint64(buf, math.Float64bits(v))
 		}
 
 	case TypeNVarChar, TypeNChar:
-		s := toString(val)
-		data := stringToUCS2(s)
-		binary.Write(buf, binary.LittleEndian, uint16(len(data)))
-		buf.Write(data)
+		if col.Length == MaxTypeLength {
+			putPLP(buf, appendUCS2(nil, toString(val), -1))
+		} else {
+			putUSVarUCS2(buf, toString(val), int(col.Length))
+		}
 
 	case TypeBigVarChar, TypeBigChar:
-		s := toString(val)
-		data := []byte(s)
-		binary.Write(buf, binary.LittleEndian, uint16(len(data)))
-		buf.Write(data)
+		if col.Length == MaxTypeLength {
+			putPLP(buf, columnCharset(col).AppendEncoded(nil, toString(val)))
+		} else {
+			putUSVarChars(buf, columnCharset(col), toString(val), int(col.Length))
+		}
 
 	case TypeBigVarBin, TypeBigBinary:
 		data, _ := toBytes(val)
-		binary.Write(buf, binary.LittleEndian, uint16(len(data)))
+		if col.Length == MaxTypeLength {
+			putPLP(buf, data)
+			break
+		}
+		if len(data) > int(col.Length) {
+			data = data[:col.Length]
+		}
+		putUint16(buf, uint16(len(data)))
 		buf.Write(data)
 
 	case TypeGUID:
@@ -482,7 +512,11 @@ func writeNullValue(buf *bytes.Buffer, col Column) {
 
 	case TypeNVarChar, TypeNChar, TypeBigVarChar, TypeBigChar,
 		TypeBigVarBin, TypeBigBinary:
-		binary.Write(buf, binary.LittleEndian, uint16(0xFFFF)) // -1 = NULL
+		if col.Length == MaxTypeLength {
+			putUint64(buf, plpNull)
+		} else {
+			putUint16(buf, 0xFFFF) // -1 = NULL
+		}
 
 	default:
 		buf.WriteByte(0)
@@ -554,11 +588,11 @@ func writeDecimalValue(buf *bytes.Buffer, d decimal.Decimal, precision, scale ui
 
 	// Scale to integer representation
 	// e.g., 123.45 with scale 2 becomes 12345
-	scaled := d.Shift(int32(scale))
+	scaled := d.Round(int32(scale)).Shift(int32(scale))
 	
 	// Get the coefficient as a big.Int, then convert to uint64
 	// For values that fit in uint64
-	coef := scaled.Coefficient()
+	coef := scaled.BigInt()
 	var val uint64
 	if coef.IsUint64() {
 		val = coef.Uint64()
@@ -175,6 +175,17 @@ const (
 	ColFlagNullableUnknown uint16 = 0x8000
 )
 
+// MaxTypeLength is the length in the TYPE_INFO of a VARCHAR(MAX),
+// NVARCHAR(MAX) or VARBINARY(MAX), whose values are sent as PLP.
+const MaxTypeLength = 0xFFFF
+
+// Total lengths of a PLP value that is NULL, and of one whose length is
+// not given ahead of its chunks.
+const (
+	plpNull       uint64 = 0xFFFFFFFFFFFFFFFF
+	plpUnknownLen uint64 = 0xFFFFFFFFFFFFFFFE
+)
+
 // ResultSet represents a complete result set with columns and rows.
 type ResultSet struct {
 	Columns []Column
@@ -204,27 +215,25 @@ func (r *ResultSetWriter) WriteColMetadata() {
 	buf.WriteByte(byte(TokenColMetadata))
 
 	// Column count
-	binary.Write(buf, binary.LittleEndian, uint16(len(r.columns)))
+	putUint16(buf, uint16(len(r.columns)))
 
 	// Write each column
 	for _, col := range r.columns {
 		// UserType (4 bytes for TDS 7.2+)
-		binary.Write(buf, binary.LittleEndian, col.UserType)
+		putUint32(buf, col.UserType)
 
 		// Flags
 		flags := col.Flags
 		if col.Nullable {
 			flags |= ColFlagNullable
 		}
-		binary.Write(buf, binary.LittleEndian, flags)
+		putUint16(buf, flags)
 
 		// TYPE_INFO varies by type
 		r.writeTypeInfo(col)
 
 		// Column name (B_VARCHAR)
-		nameBytes := stringToUCS2(col.Name)
-		buf.WriteByte(byte(len(col.Name)))
-		buf.Write(nameBytes)
+		putBVarUCS2(buf, col.Name)
 	}
 }
 
@@ -286,7 +295,7 @@ func (r *ResultSetWriter) writeTypeInfo(col Column) {
 
 	case TypeBigVarChar, TypeBigChar, TypeBigVarBin, TypeBigBinary:
 		// 2-byte length prefix
-		binary.Write(buf, binary.LittleEndian, uint16(col.Length))
+		putUint16(buf, uint16(col.Length))
 		if col.Type == TypeBigVarChar || col.Type == TypeBigChar {
 			// Collation
 			if len(col.Collation) >= 5 {
@@ -298,7 +307,7 @@ func (r *ResultSetWriter) writeTypeInfo(col Column) {
 
 	case TypeNVarChar, TypeNChar:
 		// 2-byte length prefix (in bytes, not characters)
-		binary.Write(buf, binary.LittleEndian, uint16(col.Length))
+		putUint16(buf, uint16(col.Length))
 		// Collation
 		if len(col.Collation) >= 5 {
 			buf.Write(col.Collation[:5])
@@ -308,7 +317,7 @@ func (r *ResultSetWriter) writeTypeInfo(col Column) {
 
 	case TypeText, TypeNText, TypeImage:
 		// LOB types
-		binary.Write(buf, binary.LittleEndian, uint32(col.Length))
+		putUint32(buf, uint32(col.Length))
 		if col.Type != TypeImage {
 			// Collation for text types
 			if len(col.Collation) >= 5 {
@@ -362,21 +371,21 @@ func (r *ResultSetWriter) writeValue(val interface{}, col Column) error {
 		if !ok {
 			return fmt.Errorf("cannot convert %T to int", val)
 		}
-		binary.Write(buf, binary.LittleEndian, int16(v))
+		putUint16(buf, uint16(v))
 
 	case TypeInt4:
 		v, ok := toInt64(val)
 		if !ok {
 			return fmt.Errorf("cannot convert %T to int", val)
 		}
-		binary.Write(buf, binary.LittleEndian, int32(v))
+		putUint32(buf, uint32(v))
 
 	case TypeInt8:
 		v, ok := toInt64(val)
 		if !ok {
 			return fmt.Errorf("cannot convert %T to int", val)
 		}
-		binary.Write(buf, binary.LittleEndian, v)
+		putUint64(buf, uint64(v))
 
 	case TypeIntN:
 		v, ok := toInt64(val)
@@ -388,11 +397,11 @@ func (r *ResultSetWriter) writeValue(val interface{}, col Column) error {
 		case 1:
 			buf.WriteByte(byte(v))
 		case 2:
-			binary.Write(buf, binary.LittleEndian, int16(v))
+			putUint16(buf, uint16(v))
 		case 4:
-			binary.Write(buf, binary.LittleEndian, int32(v))
+			putUint32(buf, uint32(v))
 		case 8:
-			binary.Write(buf, binary.LittleEndian, v)
+			putUint64(buf, uint64(v))
 		}
 
 	case TypeBit:
@@ -423,14 +432,14 @@ func (r *ResultSetWriter) writeValue(val interface{}, col Column) error {
 		if !ok {
 			return fmt.Errorf("cannot convert %T to float", val)
 		}
-		binary.Write(buf, binary.LittleEndian, float32(v))
+		putUint32(buf, math.Float32bits(float32(v)))
 
 	case TypeFloat8:
 		v, ok := toFloat64(val)
 		if !ok {
 			return fmt.Errorf("cannot convert %T to float", val)
 		}
-		binary.Write(buf, binary.LittleEndian, v)
+		putUint64(buf, math.Float64bits(v))
 
 	case TypeFloatN:
 		v, ok := toFloat64(val)
@@ -439,28 +448,16 @@ func (r *ResultSetWriter) writeValue(val interface{}, col Column) error {
 		}
 		buf.WriteByte(byte(col.Length))
 		if col.Length == 4 {
-			binary.Write(buf, binary.LittleEndian, float32(v))
+			putUint32(buf, math.Float32bits(float32(v)))
 		} else {
-			binary.Write(buf, binary.LittleEndian, v)
+			putUint64(buf, math.Float64bits(v))
 		}
 
 	case TypeNVarChar, TypeNChar:
-		s := toString(val)
-		data := stringToUCS2(s)
-		if len(data) > int(col.Length) {
-			data = data[:col.Length]
-		}
-		binary.Write(buf, binary.LittleEndian, uint16(len(data)))
-		buf.Write(data)
+		putUSVarUCS2(buf, toString(val), int(col.Length))
 
 	case TypeBigVarChar, TypeBigChar:
-		s := toString(val)
-		data := []byte(s)
-		if len(data) > int(col.Length) {
-			data = data[:col.Length]
-		}
-		binary.Write(buf, binary.LittleEndian, uint16(len(data)))
-		buf.Write(data)
+		putUSVarChars(buf, columnCharset(col), toString(val), int(col.Length))
 
 	case TypeBigVarBin, TypeBigBinary:
 		data, ok := toBytes(val)
@@ -470,7 +467,7 @@ func (r *ResultSetWriter) writeValue(val interface{}, col Column) error {
 		if len(data) > int(col.Length) {
 			data = data[:col.Length]
 		}
-		binary.Write(buf, binary.LittleEndian, uint16(len(data)))
+		putUint16(buf, uint16(len(data)))
 		buf.Write(data)
 
 	default:
@@ -490,7 +487,7 @@ func (r *ResultSetWriter) writeNull(col Column) error {
 
 	case TypeNVarChar, TypeNChar, TypeBigVarChar, TypeBigChar,
 		TypeBigVarBin, TypeBigBinary:
-		binary.Write(buf, binary.LittleEndian, uint16(0xFFFF)) // -1 = NULL
+		putUint16(buf, 0xFFFF) // -1 = NULL
 
 	case TypeDecimalN, TypeNumericN:
 		buf.WriteByte(0) // 0 length = NULL
@@ -0,0 +1,135 @@
+package tds
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// benchColumns are the columns of a typical query result.
+var benchColumns = []Column{
+	{Name: "id", Type: TypeIntN, Length: 4, Nullable: true},
+	{Name: "total", Type: TypeIntN, Length: 8, Nullable: true},
+	{Name: "price", Type: TypeFloatN, Length: 8, Nullable: true},
+	{Name: "active", Type: TypeBitN, Length: 1, Nullable: true},
+	{Name: "name", Type: TypeNVarChar, Length: 200, Collation: DefaultCollation, Nullable: true},
+	{Name: "code", Type: TypeBigVarChar, Length: 20, Collation: DefaultCollation, Nullable: true},
+}
+
+func benchRow(i int) []interface{} {
+	return []interface{}{int32(i), int64(i) * 1000, float64(i) / 3, i%2 == 0, "Product name", "SKU-0001"}
+}
+
+// discardConn is a net.Conn that accepts and drops all writes.
+type discardConn struct{ net.Conn }
+
+func (discardConn) Write(b []byte) (int, error)      { return len(b), nil }
+func (discardConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestWriteRowAllocs(t *testing.T) {
+	tw := NewTokenWriter()
+	w := NewResultSetWriter(tw, benchColumns)
+	row := benchRow(1000)
+	w.WriteRow(row) // Grow the buffer
+
+	allocs := testing.AllocsPerRun(100, func() {
+		tw.Reset()
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("WriteRow allocates %.0f times per row, want 0", allocs)
+	}
+}
+
+func TestWriteRowNVarCharTruncation(t *testing.T) {
+	cols := []Column{{Name: "s", Type: TypeNVarChar, Length: 6, Nullable: true}}
+	tests := []struct {
+		value string
+		want  []byte
+	}{
+		{"ab", []byte{4, 0, 'a', 0, 'b', 0}},
+		{"abcd", []byte{6, 0, 'a', 0, 'b', 0, 'c', 0}},
+		// The surrogate pair would end past the limit, so it is dropped
+		// whole rather than split.
+		{"ab\U0001F600", []byte{4, 0, 'a', 0, 'b', 0}},
+		{"a\U0001F600", []byte{6, 0, 'a', 0, 0x3D, 0xD8, 0x00, 0xDE}},
+	}
+	for _, tt := range tests {
+		tw := NewTokenWriter()
+		if err := NewResultSetWriter(tw, cols).WriteRow([]interface{}{tt.value}); err != nil {
+			t.Fatal(err)
+		}
+		if got := tw.Bytes()[1:]; !bytes.Equal(got, tt.want) {
+			t.Errorf("WriteRow(%q) = % x, want % x", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWritePacketAllocs(t *testing.T) {
+	c := NewConn(discardConn{}, WithPacketSize(DefaultPacketSize))
+	data := bytes.Repeat([]byte{1}, 3*DefaultPacketSize)
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := c.WritePacket(PacketReply, data); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("WritePacket allocates %.0f times, want 0", allocs)
+	}
+}
+
+func BenchmarkWriteResultSet(b *testing.B) {
+	rows := make([][]interface{}, 1000)
+	for i := range rows {
+		rows[i] = benchRow(i)
+	}
+	c := NewConn(discardConn{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tw := AcquireTokenWriter()
+		w := NewResultSetWriter(tw, benchColumns)
+		w.WriteColMetadata()
+		for _, row := range rows {
+			if err := w.WriteRow(row); err != nil {
+				b.Fatal(err)
+			}
+		}
+		tw.WriteDone(DoneFinal|DoneCount, 0xC1, uint64(len(rows)))
+		if err := c.WriteTokens(tw); err != nil {
+			b.Fatal(err)
+		}
+		tw.Release()
+	}
+}
+
+func BenchmarkReadPacket(b *testing.B) {
+	var stream bytes.Buffer
+	payload := bytes.Repeat([]byte{1}, 3*(DefaultPacketSize-HeaderSize)+100)
+	writer := NewConn(&bufferConn{w: &stream})
+	writer.WritePacket(PacketSQLBatch, payload)
+	packet := stream.Bytes()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c := NewConn(&bufferConn{r: bytes.NewReader(packet)})
+		if _, _, err := c.ReadPacket(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// bufferConn is a net.Conn reading from r and writing to w.
+type bufferConn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+func (c *bufferConn) Read(b []byte) (int, error)       { return c.r.Read(b) }
+func (c *bufferConn) Write(b []byte) (int, error)      { return c.w.Write(b) }
+func (c *bufferConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *bufferConn) SetWriteDeadline(time.Time) error { return nil }
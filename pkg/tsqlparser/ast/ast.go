@@ -52,6 +52,8 @@ func (p *Program) String() string {
 // -----------------------------------------------------------------------------
 
 // Identifier represents an identifier (table name, column name, etc.).
+// Value is the name itself; a delimited identifier, [Order Details] or
+// "weird""name", is written back with the delimiter its token records.
 type Identifier struct {
 	Token token.Token
 	Value string
@@ -59,7 +61,23 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
-func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) String() string {
+	if i.Token.Quote != 0 {
+		return QuoteIdentifier(i.Value, i.Token.Quote)
+	}
+	return i.Value
+}
+
+// QuoteIdentifier delimits name with quote, which is [, " or `, doubling
+// the closing delimiter wherever name contains it.
+func QuoteIdentifier(name string, quote rune) string {
+	closing := quote
+	if quote == '[' {
+		closing = ']'
+	}
+	c := string(closing)
+	return string(quote) + strings.ReplaceAll(name, c, c+c) + c
+}
 
 // QualifiedIdentifier represents a multi-part identifier (schema.table, etc.).
 type QualifiedIdentifier struct {
@@ -76,7 +94,7 @@ func (q *QualifiedIdentifier) TokenLiteral() string {
 func (q *QualifiedIdentifier) String() string {
 	var parts []string
 	for _, p := range q.Parts {
-		parts = append(parts, p.Value)
+		parts = append(parts, p.String())
 	}
 	return strings.Join(parts, ".")
 }
@@ -131,10 +149,11 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string {
+	quoted := "'" + strings.ReplaceAll(sl.Value, "'", "''") + "'"
 	if sl.Unicode {
-		return "N'" + sl.Value + "'"
+		return "N" + quoted
 	}
-	return "'" + sl.Value + "'"
+	return quoted
 }
 
 // NullLiteral represents a NULL literal.
@@ -496,6 +515,7 @@ func (ce *ConvertExpression) String() string {
 type FunctionCall struct {
 	Token       token.Token
 	Function    Expression
+	Distinct    bool // For COUNT(DISTINCT x) and other aggregates
 	Arguments   []Expression
 	WithinGroup []*OrderByItem // For WITHIN GROUP (ORDER BY ...) - ordered-set aggregates
 	Over        *OverClause
@@ -508,7 +528,11 @@ func (fc *FunctionCall) String() string {
 	for _, a := range fc.Arguments {
 		args = append(args, a.String())
 	}
-	result := fc.Function.String() + "(" + strings.Join(args, ", ") + ")"
+	distinct := ""
+	if fc.Distinct {
+		distinct = "DISTINCT "
+	}
+	result := fc.Function.String() + "(" + distinct + strings.Join(args, ", ") + ")"
 	if len(fc.WithinGroup) > 0 {
 		result += " WITHIN GROUP (ORDER BY "
 		var orderParts []string
@@ -526,14 +550,17 @@ func (fc *FunctionCall) String() string {
 // MethodCallExpression represents a method call on an object (e.g., @xml.value('xpath', 'type'))
 type MethodCallExpression struct {
 	Token      token.Token
-	Object     Expression // The object being called on
-	MethodName string     // value, nodes, query, exist, modify
-	Arguments  []Expression
+	Object     Expression   // The object being called on
+	MethodName string       // value, nodes, query, exist, modify
+	Arguments  []Expression // nil for a property (e.g., @g.Lat)
 }
 
 func (mc *MethodCallExpression) expressionNode()      {}
 func (mc *MethodCallExpression) TokenLiteral() string { return mc.Token.Literal }
 func (mc *MethodCallExpression) String() string {
+	if mc.Arguments == nil {
+		return mc.Object.String() + "." + mc.MethodName
+	}
 	var args []string
 	for _, a := range mc.Arguments {
 		args = append(args, a.String())
@@ -563,6 +590,52 @@ func (sm *StaticMethodCall) String() string {
 	return sm.TypeName + "::" + sm.MethodName + "(" + strings.Join(args, ", ") + ")"
 }
 
+// GraphMatchExpression represents a MATCH predicate over node and edge
+// tables (e.g., MATCH(p1-(likes)->p2 AND p2<-(lives)-c)).
+type GraphMatchExpression struct {
+	Token    token.Token
+	Patterns []*GraphPattern // joined by AND
+}
+
+func (gm *GraphMatchExpression) expressionNode()      {}
+func (gm *GraphMatchExpression) TokenLiteral() string { return gm.Token.Literal }
+func (gm *GraphMatchExpression) String() string {
+	var patterns []string
+	for _, p := range gm.Patterns {
+		patterns = append(patterns, p.String())
+	}
+	return "MATCH(" + strings.Join(patterns, " AND ") + ")"
+}
+
+// GraphPattern is a chain of nodes in a MATCH predicate; Edges[i] links
+// Nodes[i] and Nodes[i+1].
+type GraphPattern struct {
+	Nodes []*Identifier
+	Edges []*GraphEdge
+}
+
+func (gp *GraphPattern) String() string {
+	var out strings.Builder
+	for i, node := range gp.Nodes {
+		if i > 0 {
+			edge := gp.Edges[i-1]
+			if edge.Reverse {
+				out.WriteString("<-(" + edge.Name.String() + ")-")
+			} else {
+				out.WriteString("-(" + edge.Name.String() + ")->")
+			}
+		}
+		out.WriteString(node.String())
+	}
+	return out.String()
+}
+
+// GraphEdge is an edge in a MATCH pattern.
+type GraphEdge struct {
+	Name    *Identifier
+	Reverse bool // <-(e)-: the edge runs from the right node to the left
+}
+
 // OverClause represents an OVER clause for window functions.
 type OverClause struct {
 	Token       token.Token
@@ -761,6 +834,7 @@ type SelectStatement struct {
 	Union         *UnionClause
 	Offset        Expression
 	Fetch         Expression
+	Limit         Expression // LIMIT n, set by dialect rewriters in place of TOP
 	ForClause     *ForClause
 	Options       []*QueryOption // OPTION (RECOMPILE, MAXDOP 4, etc.)
 }
@@ -799,7 +873,7 @@ func (ss *SelectStatement) String() string {
 		out.WriteString(ss.Into.String())
 		if ss.IntoFilegroup != nil {
 			out.WriteString(" ON ")
-			out.WriteString(ss.IntoFilegroup.Value)
+			out.WriteString(ss.IntoFilegroup.String())
 		}
 	}
 
@@ -836,6 +910,11 @@ func (ss *SelectStatement) String() string {
 		out.WriteString(strings.Join(orders, ", "))
 	}
 
+	if ss.Limit != nil {
+		out.WriteString(" LIMIT ")
+		out.WriteString(ss.Limit.String())
+	}
+
 	if ss.Offset != nil {
 		out.WriteString(" OFFSET ")
 		out.WriteString(ss.Offset.String())
@@ -853,16 +932,7 @@ func (ss *SelectStatement) String() string {
 		out.WriteString(ss.ForClause.String())
 	}
 
-	if len(ss.Options) > 0 {
-		out.WriteString(" OPTION (")
-		for i, opt := range ss.Options {
-			if i > 0 {
-				out.WriteString(", ")
-			}
-			out.WriteString(opt.String())
-		}
-		out.WriteString(")")
-	}
+	writeQueryOptions(&out, ss.Options)
 
 	if ss.Union != nil {
 		out.WriteString(" ")
@@ -872,6 +942,21 @@ func (ss *SelectStatement) String() string {
 	return out.String()
 }
 
+// writeQueryOptions appends an OPTION (...) clause when there are hints.
+func writeQueryOptions(out *strings.Builder, options []*QueryOption) {
+	if len(options) == 0 {
+		return
+	}
+	out.WriteString(" OPTION (")
+	for i, opt := range options {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(opt.String())
+	}
+	out.WriteString(")")
+}
+
 // QueryOption represents a query hint in OPTION clause
 type QueryOption struct {
 	Name        string       // RECOMPILE, MAXDOP, HASH JOIN, USE HINT, OPTIMIZE FOR, etc.
@@ -929,7 +1014,7 @@ func (sc SelectColumn) String() string {
 	}
 	result := sc.Expression.String()
 	if sc.Alias != nil {
-		result += " AS " + sc.Alias.Value
+		result += " AS " + sc.Alias.String()
 	}
 	return result
 }
@@ -1075,7 +1160,7 @@ func (tn *TableName) String() string {
 		result += " " + tn.TemporalClause.String()
 	}
 	if tn.Alias != nil {
-		result += " AS " + tn.Alias.Value
+		result += " AS " + tn.Alias.String()
 	}
 	return result
 }
@@ -1117,14 +1202,14 @@ func (dt *DerivedTable) TokenLiteral() string { return dt.Token.Literal }
 func (dt *DerivedTable) String() string {
 	result := "(" + dt.Subquery.String() + ")"
 	if dt.Alias != nil {
-		result += " AS " + dt.Alias.Value
+		result += " AS " + dt.Alias.String()
 		if len(dt.ColumnAliases) > 0 {
 			result += "("
 			for i, col := range dt.ColumnAliases {
 				if i > 0 {
 					result += ", "
 				}
-				result += col.Value
+				result += col.String()
 			}
 			result += ")"
 		}
@@ -1157,14 +1242,14 @@ func (ddt *DmlDerivedTable) TokenLiteral() string { return ddt.Token.Literal }
 func (ddt *DmlDerivedTable) String() string {
 	result := "(" + ddt.Statement.String() + ")"
 	if ddt.Alias != nil {
-		result += " AS " + ddt.Alias.Value
+		result += " AS " + ddt.Alias.String()
 		if len(ddt.ColumnAliases) > 0 {
 			result += "("
 			for i, col := range ddt.ColumnAliases {
 				if i > 0 {
 					result += ", "
 				}
-				result += col.Value
+				result += col.String()
 			}
 			result += ")"
 		}
@@ -1201,14 +1286,14 @@ func (vt *ValuesTable) String() string {
 	out.WriteString(")")
 	if vt.Alias != nil {
 		out.WriteString(" AS ")
-		out.WriteString(vt.Alias.Value)
+		out.WriteString(vt.Alias.String())
 		if len(vt.Columns) > 0 {
 			out.WriteString("(")
 			for i, col := range vt.Columns {
 				if i > 0 {
 					out.WriteString(", ")
 				}
-				out.WriteString(col.Value)
+				out.WriteString(col.String())
 			}
 			out.WriteString(")")
 		}
@@ -1251,14 +1336,14 @@ func (tvf *TableValuedFunction) String() string {
 	}
 	if tvf.Alias != nil {
 		out.WriteString(" AS ")
-		out.WriteString(tvf.Alias.Value)
+		out.WriteString(tvf.Alias.String())
 		if len(tvf.ColumnAliases) > 0 {
 			out.WriteString("(")
 			for i, col := range tvf.ColumnAliases {
 				if i > 0 {
 					out.WriteString(", ")
 				}
-				out.WriteString(col.Value)
+				out.WriteString(col.String())
 			}
 			out.WriteString(")")
 		}
@@ -1288,20 +1373,20 @@ func (pt *PivotTable) String() string {
 	out.WriteString("(")
 	out.WriteString(pt.ValueColumn.String())
 	out.WriteString(") FOR ")
-	out.WriteString(pt.PivotColumn.Value)
+	out.WriteString(pt.PivotColumn.String())
 	out.WriteString(" IN (")
 	for i, v := range pt.PivotValues {
 		if i > 0 {
 			out.WriteString(", ")
 		}
 		out.WriteString("[")
-		out.WriteString(v.Value)
+		out.WriteString(v.String())
 		out.WriteString("]")
 	}
 	out.WriteString("))")
 	if pt.Alias != nil {
 		out.WriteString(" AS ")
-		out.WriteString(pt.Alias.Value)
+		out.WriteString(pt.Alias.String())
 	}
 	return out.String()
 }
@@ -1323,22 +1408,22 @@ func (ut *UnpivotTable) String() string {
 	var out strings.Builder
 	out.WriteString(ut.Source.String())
 	out.WriteString(" UNPIVOT (")
-	out.WriteString(ut.ValueColumn.Value)
+	out.WriteString(ut.ValueColumn.String())
 	out.WriteString(" FOR ")
-	out.WriteString(ut.PivotColumn.Value)
+	out.WriteString(ut.PivotColumn.String())
 	out.WriteString(" IN (")
 	for i, c := range ut.SourceColumns {
 		if i > 0 {
 			out.WriteString(", ")
 		}
 		out.WriteString("[")
-		out.WriteString(c.Value)
+		out.WriteString(c.String())
 		out.WriteString("]")
 	}
 	out.WriteString("))")
 	if ut.Alias != nil {
 		out.WriteString(" AS ")
-		out.WriteString(ut.Alias.Value)
+		out.WriteString(ut.Alias.String())
 	}
 	return out.String()
 }
@@ -1425,6 +1510,7 @@ type InsertStatement struct {
 	Columns       []*Identifier
 	Values        [][]Expression
 	Select        *SelectStatement
+	Exec          *ExecStatement // INSERT ... EXEC proc
 	Output        *OutputClause
 	DefaultValues bool // INSERT ... DEFAULT VALUES
 }
@@ -1458,7 +1544,7 @@ func (is *InsertStatement) String() string {
 		out.WriteString(" (")
 		var cols []string
 		for _, c := range is.Columns {
-			cols = append(cols, c.Value)
+			cols = append(cols, c.String())
 		}
 		out.WriteString(strings.Join(cols, ", "))
 		out.WriteString(")")
@@ -1472,6 +1558,9 @@ func (is *InsertStatement) String() string {
 	if is.Select != nil {
 		out.WriteString(" ")
 		out.WriteString(is.Select.String())
+	} else if is.Exec != nil {
+		out.WriteString(" ")
+		out.WriteString(is.Exec.String())
 	} else if is.DefaultValues {
 		out.WriteString(" DEFAULT VALUES")
 	} else if len(is.Values) > 0 {
@@ -1503,6 +1592,7 @@ type UpdateStatement struct {
 	Where           Expression
 	CurrentOfCursor *Identifier // WHERE CURRENT OF cursor_name
 	Output          *OutputClause
+	Options         []*QueryOption // OPTION (RECOMPILE, MAXDOP 4, etc.)
 }
 
 type SetClause struct {
@@ -1534,12 +1624,20 @@ func (us *UpdateStatement) String() string {
 	}
 	if us.Alias != nil {
 		out.WriteString(" ")
-		out.WriteString(us.Alias.Value)
+		out.WriteString(us.Alias.String())
 	}
 	out.WriteString(" SET ")
 
 	var sets []string
 	for _, s := range us.SetClauses {
+		if s.IsMethodCall {
+			var args []string
+			for _, a := range s.MethodArgs {
+				args = append(args, a.String())
+			}
+			sets = append(sets, s.Column.String()+"("+strings.Join(args, ", ")+")")
+			continue
+		}
 		op := s.Operator
 		if op == "" {
 			op = "="
@@ -1566,6 +1664,8 @@ func (us *UpdateStatement) String() string {
 		out.WriteString(us.CurrentOfCursor.String())
 	}
 
+	writeQueryOptions(&out, us.Options)
+
 	return out.String()
 }
 
@@ -1581,6 +1681,7 @@ type DeleteStatement struct {
 	Where           Expression
 	CurrentOfCursor *Identifier // WHERE CURRENT OF cursor_name
 	Output          *OutputClause
+	Options         []*QueryOption // OPTION (RECOMPILE, MAXDOP 4, etc.)
 }
 
 func (ds *DeleteStatement) statementNode()       {}
@@ -1596,7 +1697,7 @@ func (ds *DeleteStatement) String() string {
 
 	if ds.Alias != nil {
 		out.WriteString(" ")
-		out.WriteString(ds.Alias.Value)
+		out.WriteString(ds.Alias.String())
 	} else if ds.TargetFunc != nil {
 		out.WriteString(" FROM ")
 		out.WriteString(ds.TargetFunc.String())
@@ -1629,6 +1730,8 @@ func (ds *DeleteStatement) String() string {
 		out.WriteString(ds.CurrentOfCursor.String())
 	}
 
+	writeQueryOptions(&out, ds.Options)
+
 	return out.String()
 }
 
@@ -1659,7 +1762,7 @@ func (oc *OutputClause) String() string {
 		out.WriteString("(")
 		var colNames []string
 		for _, c := range oc.IntoColumns {
-			colNames = append(colNames, c.Value)
+			colNames = append(colNames, c.String())
 		}
 		out.WriteString(strings.Join(colNames, ", "))
 		out.WriteString(")")
@@ -1699,6 +1802,7 @@ type MergeStatement struct {
 	OnCondition Expression
 	WhenClauses []*MergeWhenClause
 	Output      *OutputClause
+	Options     []*QueryOption // OPTION (LOOP JOIN, MAXDOP 1, etc.)
 }
 
 func (ms *MergeStatement) statementNode()       {}
@@ -1709,13 +1813,13 @@ func (ms *MergeStatement) String() string {
 	out.WriteString(ms.Target.String())
 	if ms.TargetAlias != nil {
 		out.WriteString(" AS ")
-		out.WriteString(ms.TargetAlias.Value)
+		out.WriteString(ms.TargetAlias.String())
 	}
 	out.WriteString(" USING ")
 	out.WriteString(ms.Source.String())
 	if ms.SourceAlias != nil {
 		out.WriteString(" AS ")
-		out.WriteString(ms.SourceAlias.Value)
+		out.WriteString(ms.SourceAlias.String())
 	}
 	out.WriteString(" ON ")
 	out.WriteString(ms.OnCondition.String())
@@ -1730,6 +1834,8 @@ func (ms *MergeStatement) String() string {
 		out.WriteString(ms.Output.String())
 	}
 
+	writeQueryOptions(&out, ms.Options)
+
 	return out.String()
 }
 
@@ -1778,7 +1884,7 @@ func (mw *MergeWhenClause) String() string {
 			out.WriteString(" (")
 			var cols []string
 			for _, c := range mw.Columns {
-				cols = append(cols, c.Value)
+				cols = append(cols, c.String())
 			}
 			out.WriteString(strings.Join(cols, ", "))
 			out.WriteString(")")
@@ -2086,6 +2192,8 @@ type ExecStatement struct {
 	DynamicSQL     Expression // For EXEC('dynamic sql')
 	AtServer       *Identifier // For EXEC(...) AT LinkedServer
 	Recompile      bool       // WITH RECOMPILE
+	DryRun         bool       // WITH DRYRUN (aul): run in a transaction that is rolled back
+	Trace          bool       // WITH TRACE (aul): record the steps of the call
 	ResultSets     []*ResultSetDefinition // WITH RESULT SETS ((...), (...))
 	ResultSetsMode string     // "UNDEFINED" or "NONE" for WITH RESULT SETS UNDEFINED/NONE
 }
@@ -2143,6 +2251,13 @@ func (es *ExecStatement) String() string {
 		out.WriteString(strings.Join(params, ", "))
 	}
 
+	if es.DryRun {
+		out.WriteString(" WITH DRYRUN")
+	}
+	if es.Trace {
+		out.WriteString(" WITH TRACE")
+	}
+
 	if len(es.ResultSets) > 0 {
 		out.WriteString(" WITH RESULT SETS (")
 		var sets []string
@@ -2225,7 +2340,7 @@ func (bt *BeginTransactionStatement) statementNode()       {}
 func (bt *BeginTransactionStatement) TokenLiteral() string { return bt.Token.Literal }
 func (bt *BeginTransactionStatement) String() string {
 	if bt.Name != nil {
-		return "BEGIN TRANSACTION " + bt.Name.Value
+		return "BEGIN TRANSACTION " + bt.Name.String()
 	}
 	return "BEGIN TRANSACTION"
 }
@@ -2240,7 +2355,7 @@ func (ct *CommitTransactionStatement) statementNode()       {}
 func (ct *CommitTransactionStatement) TokenLiteral() string { return ct.Token.Literal }
 func (ct *CommitTransactionStatement) String() string {
 	if ct.Name != nil {
-		return "COMMIT TRANSACTION " + ct.Name.Value
+		return "COMMIT TRANSACTION " + ct.Name.String()
 	}
 	return "COMMIT TRANSACTION"
 }
@@ -2255,7 +2370,7 @@ func (rt *RollbackTransactionStatement) statementNode()       {}
 func (rt *RollbackTransactionStatement) TokenLiteral() string { return rt.Token.Literal }
 func (rt *RollbackTransactionStatement) String() string {
 	if rt.Name != nil {
-		return "ROLLBACK TRANSACTION " + rt.Name.Value
+		return "ROLLBACK TRANSACTION " + rt.Name.String()
 	}
 	return "ROLLBACK TRANSACTION"
 }
@@ -2324,11 +2439,11 @@ func (ws *WithStatement) String() string {
 
 	var ctes []string
 	for _, cte := range ws.CTEs {
-		def := cte.Name.Value
+		def := cte.Name.String()
 		if len(cte.Columns) > 0 {
 			var cols []string
 			for _, c := range cte.Columns {
-				cols = append(cols, c.Value)
+				cols = append(cols, c.String())
 			}
 			def += " (" + strings.Join(cols, ", ") + ")"
 		}
@@ -2380,7 +2495,7 @@ func (s *EnableDisableTriggerStatement) String() string {
 	if s.AllTriggers {
 		out.WriteString("ALL")
 	} else if s.TriggerName != nil {
-		out.WriteString(s.TriggerName.Value)
+		out.WriteString(s.TriggerName.String())
 	}
 	out.WriteString(" ON ")
 	if s.OnDatabase {
@@ -2439,7 +2554,7 @@ type InlineIndex struct {
 
 func (cd *ColumnDefinition) String() string {
 	var out strings.Builder
-	out.WriteString(cd.Name.Value)
+	out.WriteString(cd.Name.String())
 	
 	if cd.Computed != nil {
 		out.WriteString(" AS (")
@@ -2541,6 +2656,7 @@ const (
 	ConstraintDefault
 	ConstraintPeriod // PERIOD FOR SYSTEM_TIME
 	ConstraintIndex  // INDEX ix_name (columns)
+	ConstraintConnection // CONNECTION (Node1 TO Node2) on an edge table
 )
 
 func (cc *ColumnConstraint) String() string {
@@ -2577,7 +2693,7 @@ func (cc *ColumnConstraint) String() string {
 				if i > 0 {
 					out.WriteString(", ")
 				}
-				out.WriteString(col.Value)
+				out.WriteString(col.String())
 			}
 			out.WriteString(")")
 		}
@@ -2609,6 +2725,14 @@ type TableConstraint struct {
 	ForColumn       *Identifier   // For DEFAULT ... FOR column
 	OnDelete       string
 	OnUpdate       string
+	Connections    []*EdgeConnection // For CONNECTION
+}
+
+// EdgeConnection is a pair of node tables an edge table's CONNECTION
+// constraint allows edges between.
+type EdgeConnection struct {
+	From *QualifiedIdentifier
+	To   *QualifiedIdentifier
 }
 
 func (tc *TableConstraint) String() string {
@@ -2673,7 +2797,7 @@ func (tc *TableConstraint) String() string {
 			if i > 0 {
 				out.WriteString(", ")
 			}
-			out.WriteString(col.Name.Value)
+			out.WriteString(col.Name.String())
 		}
 		out.WriteString(") REFERENCES ")
 		out.WriteString(tc.ReferencesTable.String())
@@ -2682,7 +2806,7 @@ func (tc *TableConstraint) String() string {
 			if i > 0 {
 				out.WriteString(", ")
 			}
-			out.WriteString(col.Value)
+			out.WriteString(col.String())
 		}
 		out.WriteString(")")
 		if tc.OnDelete != "" {
@@ -2708,7 +2832,7 @@ func (tc *TableConstraint) String() string {
 		}
 		if tc.ForColumn != nil {
 			out.WriteString(" FOR ")
-			out.WriteString(tc.ForColumn.Value)
+			out.WriteString(tc.ForColumn.String())
 		}
 
 	case ConstraintPeriod:
@@ -2717,7 +2841,7 @@ func (tc *TableConstraint) String() string {
 			if i > 0 {
 				out.WriteString(", ")
 			}
-			out.WriteString(col.Name.Value)
+			out.WriteString(col.Name.String())
 		}
 		out.WriteString(")")
 
@@ -2739,6 +2863,22 @@ func (tc *TableConstraint) String() string {
 			out.WriteString(col.String())
 		}
 		out.WriteString(")")
+
+	case ConstraintConnection:
+		out.WriteString("CONNECTION (")
+		for i, conn := range tc.Connections {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			out.WriteString(conn.From.String())
+			out.WriteString(" TO ")
+			out.WriteString(conn.To.String())
+		}
+		out.WriteString(")")
+		if tc.OnDelete != "" {
+			out.WriteString(" ON DELETE ")
+			out.WriteString(tc.OnDelete)
+		}
 	}
 	
 	return out.String()
@@ -2752,9 +2892,9 @@ type IndexColumn struct {
 
 func (ic *IndexColumn) String() string {
 	if ic.Descending {
-		return ic.Name.Value + " DESC"
+		return ic.Name.String() + " DESC"
 	}
-	return ic.Name.Value
+	return ic.Name.String()
 }
 
 // CreateTableStatement represents a CREATE TABLE statement.
@@ -2767,6 +2907,7 @@ type CreateTableStatement struct {
 	AsSelect        *SelectStatement // CREATE TABLE ... AS SELECT
 	FileGroup       string // ON [filegroup]
 	TextImageOn     string // TEXTIMAGE_ON [filegroup]
+	GraphType       string // NODE or EDGE for AS NODE / AS EDGE
 }
 
 func (ct *CreateTableStatement) statementNode()       {}
@@ -2775,6 +2916,9 @@ func (ct *CreateTableStatement) String() string {
 	var out strings.Builder
 	out.WriteString("CREATE TABLE ")
 	out.WriteString(ct.Name.String())
+	if ct.GraphType != "" && len(ct.Columns) == 0 && len(ct.Constraints) == 0 {
+		return out.String() + " AS " + ct.GraphType
+	}
 	out.WriteString(" (\n")
 	
 	for i, col := range ct.Columns {
@@ -2804,6 +2948,10 @@ func (ct *CreateTableStatement) String() string {
 		out.WriteString(" TEXTIMAGE_ON ")
 		out.WriteString(ct.TextImageOn)
 	}
+	if ct.GraphType != "" {
+		out.WriteString(" AS ")
+		out.WriteString(ct.GraphType)
+	}
 	return out.String()
 }
 
@@ -2927,15 +3075,15 @@ func (aa *AlterTableAction) String() string {
 	case AlterAddColumn:
 		return "ADD " + aa.Column.String()
 	case AlterDropColumn:
-		return "DROP COLUMN " + aa.ColumnName.Value
+		return "DROP COLUMN " + aa.ColumnName.String()
 	case AlterAlterColumn:
-		return "ALTER COLUMN " + aa.ColumnName.Value + " " + aa.NewDataType.String()
+		return "ALTER COLUMN " + aa.ColumnName.String() + " " + aa.NewDataType.String()
 	case AlterAddConstraint:
 		return "ADD " + aa.Constraint.String()
 	case AlterDropConstraint:
 		return "DROP CONSTRAINT " + aa.ConstraintName
 	case AlterRenameColumn:
-		return "RENAME COLUMN " + aa.ColumnName.Value + " TO " + aa.NewColumnName.Value
+		return "RENAME COLUMN " + aa.ColumnName.String() + " TO " + aa.NewColumnName.String()
 	case AlterEnableTrigger:
 		if aa.AllTriggers {
 			return "ENABLE TRIGGER ALL"
@@ -3019,7 +3167,7 @@ func (dc *DeclareCursorStatement) TokenLiteral() string { return dc.Token.Litera
 func (dc *DeclareCursorStatement) String() string {
 	var out strings.Builder
 	out.WriteString("DECLARE ")
-	out.WriteString(dc.Name.Value)
+	out.WriteString(dc.Name.String())
 	out.WriteString(" CURSOR")
 	if dc.Options != nil {
 		out.WriteString(dc.Options.String())
@@ -3100,7 +3248,7 @@ type OpenCursorStatement struct {
 func (oc *OpenCursorStatement) statementNode()       {}
 func (oc *OpenCursorStatement) TokenLiteral() string { return oc.Token.Literal }
 func (oc *OpenCursorStatement) String() string {
-	return "OPEN " + oc.CursorName.Value
+	return "OPEN " + oc.CursorName.String()
 }
 
 // FetchStatement represents FETCH [NEXT|PRIOR|FIRST|LAST|ABSOLUTE|RELATIVE] FROM cursor INTO vars.
@@ -3126,7 +3274,7 @@ func (fs *FetchStatement) String() string {
 		out.WriteString(" ")
 	}
 	out.WriteString("FROM ")
-	out.WriteString(fs.CursorName.Value)
+	out.WriteString(fs.CursorName.String())
 	if len(fs.IntoVars) > 0 {
 		out.WriteString(" INTO ")
 		for i, v := range fs.IntoVars {
@@ -3148,7 +3296,7 @@ type CloseCursorStatement struct {
 func (cc *CloseCursorStatement) statementNode()       {}
 func (cc *CloseCursorStatement) TokenLiteral() string { return cc.Token.Literal }
 func (cc *CloseCursorStatement) String() string {
-	return "CLOSE " + cc.CursorName.Value
+	return "CLOSE " + cc.CursorName.String()
 }
 
 // DeallocateCursorStatement represents DEALLOCATE cursor_name.
@@ -3160,7 +3308,7 @@ type DeallocateCursorStatement struct {
 func (dc *DeallocateCursorStatement) statementNode()       {}
 func (dc *DeallocateCursorStatement) TokenLiteral() string { return dc.Token.Literal }
 func (dc *DeallocateCursorStatement) String() string {
-	return "DEALLOCATE " + dc.CursorName.Value
+	return "DEALLOCATE " + dc.CursorName.String()
 }
 
 // -----------------------------------------------------------------------------
@@ -3189,7 +3337,7 @@ func (cv *CreateViewStatement) String() string {
 			if i > 0 {
 				out.WriteString(", ")
 			}
-			out.WriteString(col.Value)
+			out.WriteString(col.String())
 		}
 		out.WriteString(")")
 	}
@@ -3223,7 +3371,7 @@ func (av *AlterViewStatement) String() string {
 			if i > 0 {
 				out.WriteString(", ")
 			}
-			out.WriteString(col.Value)
+			out.WriteString(col.String())
 		}
 		out.WriteString(")")
 	}
@@ -3262,7 +3410,7 @@ func (ci *CreateIndexStatement) String() string {
 		}
 	}
 	out.WriteString("INDEX ")
-	out.WriteString(ci.Name.Value)
+	out.WriteString(ci.Name.String())
 	out.WriteString(" ON ")
 	out.WriteString(ci.Table.String())
 	out.WriteString(" (")
@@ -3279,7 +3427,7 @@ func (ci *CreateIndexStatement) String() string {
 			if i > 0 {
 				out.WriteString(", ")
 			}
-			out.WriteString(col.Value)
+			out.WriteString(col.String())
 		}
 		out.WriteString(")")
 	}
@@ -3289,7 +3437,7 @@ func (ci *CreateIndexStatement) String() string {
 	}
 	if ci.Filegroup != nil {
 		out.WriteString(" ON ")
-		out.WriteString(ci.Filegroup.Value)
+		out.WriteString(ci.Filegroup.String())
 	}
 	return out.String()
 }
@@ -3312,11 +3460,11 @@ func (xi *CreateXmlIndexStatement) String() string {
 		out.WriteString("PRIMARY ")
 	}
 	out.WriteString("XML INDEX ")
-	out.WriteString(xi.Name.Value)
+	out.WriteString(xi.Name.String())
 	out.WriteString(" ON ")
 	out.WriteString(xi.Table.String())
 	out.WriteString("(")
-	out.WriteString(xi.Column.Value)
+	out.WriteString(xi.Column.String())
 	out.WriteString(")")
 	return out.String()
 }
@@ -3337,7 +3485,7 @@ func (di *DropIndexStatement) String() string {
 	if di.IfExists {
 		out.WriteString("IF EXISTS ")
 	}
-	out.WriteString(di.Name.Value)
+	out.WriteString(di.Name.String())
 	out.WriteString(" ON ")
 	out.WriteString(di.Table.String())
 	return out.String()
@@ -3357,7 +3505,7 @@ func (ai *AlterIndexStatement) TokenLiteral() string { return ai.Token.Literal }
 func (ai *AlterIndexStatement) String() string {
 	var out strings.Builder
 	out.WriteString("ALTER INDEX ")
-	out.WriteString(ai.Name.Value)
+	out.WriteString(ai.Name.String())
 	out.WriteString(" ON ")
 	out.WriteString(ai.Table.String())
 	out.WriteString(" ")
@@ -3657,7 +3805,7 @@ func (do *DropObjectStatement) String() string {
 	}
 	if do.IndexName != nil {
 		out.WriteString(" ")
-		out.WriteString(do.IndexName.Value)
+		out.WriteString(do.IndexName.String())
 		out.WriteString(" ON ")
 		out.WriteString(do.TableName.String())
 	} else {
@@ -3691,7 +3839,7 @@ type UseStatement struct {
 func (us *UseStatement) statementNode()       {}
 func (us *UseStatement) TokenLiteral() string { return us.Token.Literal }
 func (us *UseStatement) String() string {
-	return "USE " + us.Database.Value
+	return "USE " + us.Database.String()
 }
 
 // WaitforStatement represents a WAITFOR DELAY/TIME statement.
@@ -3716,7 +3864,7 @@ type SaveTransactionStatement struct {
 func (st *SaveTransactionStatement) statementNode()       {}
 func (st *SaveTransactionStatement) TokenLiteral() string { return st.Token.Literal }
 func (st *SaveTransactionStatement) String() string {
-	return "SAVE TRANSACTION " + st.SavepointName.Value
+	return "SAVE TRANSACTION " + st.SavepointName.String()
 }
 
 // GotoStatement represents a GOTO label statement.
@@ -3728,7 +3876,7 @@ type GotoStatement struct {
 func (gs *GotoStatement) statementNode()       {}
 func (gs *GotoStatement) TokenLiteral() string { return gs.Token.Literal }
 func (gs *GotoStatement) String() string {
-	return "GOTO " + gs.Label.Value
+	return "GOTO " + gs.Label.String()
 }
 
 // LabelStatement represents a label definition (LabelName:).
@@ -3740,7 +3888,7 @@ type LabelStatement struct {
 func (ls *LabelStatement) statementNode()       {}
 func (ls *LabelStatement) TokenLiteral() string { return ls.Token.Literal }
 func (ls *LabelStatement) String() string {
-	return ls.Name.Value + ":"
+	return ls.Name.String() + ":"
 }
 
 // SetOptionStatement represents various SET option statements.
@@ -5063,6 +5211,67 @@ func (dfc *DropFulltextCatalogStatement) String() string {
 	return "DROP FULLTEXT CATALOG " + dfc.Name
 }
 
+// AddSensitivityClassificationStatement represents ADD SENSITIVITY
+// CLASSIFICATION TO columns WITH (LABEL = ..., INFORMATION_TYPE = ...,
+// RANK = ...).
+type AddSensitivityClassificationStatement struct {
+	Token             token.Token
+	Columns           []*QualifiedIdentifier // [schema.]table.column
+	Label             string
+	LabelID           string
+	InformationType   string
+	InformationTypeID string
+	Rank              string // NONE, LOW, MEDIUM, HIGH or CRITICAL; empty if not given
+}
+
+func (asc *AddSensitivityClassificationStatement) statementNode()       {}
+func (asc *AddSensitivityClassificationStatement) TokenLiteral() string { return asc.Token.Literal }
+func (asc *AddSensitivityClassificationStatement) String() string {
+	var out strings.Builder
+	out.WriteString("ADD SENSITIVITY CLASSIFICATION TO ")
+	for i, col := range asc.Columns {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(col.String())
+	}
+	var options []string
+	for _, opt := range []struct{ name, value string }{
+		{"LABEL", asc.Label}, {"LABEL_ID", asc.LabelID},
+		{"INFORMATION_TYPE", asc.InformationType}, {"INFORMATION_TYPE_ID", asc.InformationTypeID},
+	} {
+		if opt.value != "" {
+			options = append(options, opt.name+" = '"+strings.ReplaceAll(opt.value, "'", "''")+"'")
+		}
+	}
+	if asc.Rank != "" {
+		options = append(options, "RANK = "+asc.Rank)
+	}
+	out.WriteString(" WITH (" + strings.Join(options, ", ") + ")")
+	return out.String()
+}
+
+// DropSensitivityClassificationStatement represents DROP SENSITIVITY
+// CLASSIFICATION FROM columns.
+type DropSensitivityClassificationStatement struct {
+	Token   token.Token
+	Columns []*QualifiedIdentifier // [schema.]table.column
+}
+
+func (dsc *DropSensitivityClassificationStatement) statementNode()       {}
+func (dsc *DropSensitivityClassificationStatement) TokenLiteral() string { return dsc.Token.Literal }
+func (dsc *DropSensitivityClassificationStatement) String() string {
+	var out strings.Builder
+	out.WriteString("DROP SENSITIVITY CLASSIFICATION FROM ")
+	for i, col := range dsc.Columns {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(col.String())
+	}
+	return out.String()
+}
+
 // -----------------------------------------------------------------------------
 // Stage 11: Resource Governor & Availability Groups
 // -----------------------------------------------------------------------------
@@ -5757,7 +5966,7 @@ func (ad *AlterDatabaseStatement) TokenLiteral() string { return ad.Token.Litera
 func (ad *AlterDatabaseStatement) String() string {
 	var out strings.Builder
 	out.WriteString("ALTER DATABASE ")
-	out.WriteString(ad.Name.Value)
+	out.WriteString(ad.Name.String())
 	if ad.Options != "" {
 		out.WriteString(" ")
 		out.WriteString(ad.Options)
@@ -5862,7 +6071,7 @@ func (cs *CreateStatisticsStatement) String() string {
 		if i > 0 {
 			out.WriteString(", ")
 		}
-		out.WriteString(col.Value)
+		out.WriteString(col.String())
 	}
 	out.WriteString(")")
 	if len(cs.WithOptions) > 0 {
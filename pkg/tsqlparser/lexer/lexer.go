@@ -17,6 +17,8 @@ type Lexer struct {
 	ch           rune // current char under examination
 	line         int
 	column       int
+
+	quotedStrings bool // QUOTED_IDENTIFIER OFF: "..." is a string, not an identifier
 }
 
 // New creates a new Lexer for the given input.
@@ -30,6 +32,12 @@ func New(input string) *Lexer {
 	return l
 }
 
+// SetQuotedIdentifier sets whether text in double quotes is a delimited
+// identifier, as with SET QUOTED_IDENTIFIER ON, the default, or a string.
+func (l *Lexer) SetQuotedIdentifier(on bool) {
+	l.quotedStrings = !on
+}
+
 // readChar reads the next character and advances the position.
 func (l *Lexer) readChar() {
 	if l.readPosition >= len(l.input) {
@@ -182,7 +190,8 @@ func (l *Lexer) NextToken() token.Token {
 	case '[':
 		// Bracketed identifier
 		tok.Type = token.IDENT
-		tok.Literal = l.readBracketedIdentifier()
+		tok.Quote = '['
+		tok.Literal = l.readDelimited(']')
 		return tok
 	case ']':
 		tok = l.newToken(token.RBRACKET, string(l.ch))
@@ -208,9 +217,14 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Literal = l.readString()
 		return tok
 	case '"':
-		// Double-quoted identifier (ANSI SQL, T-SQL with QUOTED_IDENTIFIER ON)
+		// Double-quoted identifier (ANSI SQL, T-SQL with QUOTED_IDENTIFIER
+		// ON), or a string with QUOTED_IDENTIFIER OFF
 		tok.Type = token.IDENT
-		tok.Literal = l.readQuotedIdentifier()
+		if l.quotedStrings {
+			tok.Type = token.STRING
+		}
+		tok.Quote = '"'
+		tok.Literal = l.readDelimited('"')
 		return tok
 	case '@':
 		if l.peekChar() == '@' {
@@ -315,38 +329,26 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readBracketedIdentifier() string {
-	l.readChar() // consume opening [
-	position := l.position
-	for l.ch != ']' && l.ch != 0 {
-		// Handle escaped brackets ]]
-		if l.ch == ']' && l.peekChar() == ']' {
-			l.readChar()
-		}
-		l.readChar()
-	}
-	ident := l.input[position:l.position]
-	if l.ch == ']' {
-		l.readChar() // consume closing ]
-	}
-	return ident
-}
+// readDelimited reads a delimited identifier, or a string in double
+// quotes, up to closing, returning it without its delimiters and with
+// each doubled closing delimiter, as in [a]]b], made single.
+func (l *Lexer) readDelimited(closing rune) string {
+	var result strings.Builder
+	l.readChar() // consume opening delimiter
 
-func (l *Lexer) readQuotedIdentifier() string {
-	l.readChar() // consume opening "
-	position := l.position
-	for l.ch != '"' && l.ch != 0 {
-		// Handle escaped quotes ""
-		if l.ch == '"' && l.peekChar() == '"' {
-			l.readChar()
+	for l.ch != 0 {
+		if l.ch == closing {
+			if l.peekChar() != closing {
+				l.readChar() // consume closing delimiter
+				break
+			}
+			l.readChar() // escaped delimiter
 		}
+		result.WriteRune(l.ch)
 		l.readChar()
 	}
-	ident := l.input[position:l.position]
-	if l.ch == '"' {
-		l.readChar() // consume closing "
-	}
-	return ident
+
+	return result.String()
 }
 
 func (l *Lexer) readNumber() (string, token.Type) {
@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"fmt"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+)
+
+// parseTimeout bounds a single parse so that a hang is reported as a
+// failure instead of stalling the fuzzer.
+const parseTimeout = 2 * time.Second
+
+var fuzzSeeds = []string{
+	"SELECT 1",
+	"SELECT a, b FROM t WHERE x = 1 ORDER BY a DESC",
+	"DECLARE @x INT = 5; IF @x > 3 SELECT 'big' ELSE SELECT 'small'",
+	"WHILE @i < 10 BEGIN SET @i = @i + 1; IF @i % 2 = 0 CONTINUE END",
+	"BEGIN TRY THROW 50001, 'x', 1 END TRY BEGIN CATCH SELECT ERROR_MESSAGE() END CATCH",
+	"CREATE PROCEDURE dbo.p @a INT, @b VARCHAR(10) OUTPUT AS BEGIN SELECT @a END",
+	"INSERT INTO t (a, b) VALUES (1, N'x'), (2, 'y')",
+	"UPDATE t SET a = a + 1 OUTPUT inserted.a WHERE b IN (SELECT b FROM u)",
+	"WITH c AS (SELECT 1 AS n UNION ALL SELECT n + 1 FROM c WHERE n < 5) SELECT * FROM c",
+	"SELECT CASE WHEN a IS NULL THEN 0 ELSE CAST(a AS DECIMAL(10,2)) END FROM [dbo].[t]",
+	"MERGE t USING s ON t.id = s.id WHEN MATCHED THEN UPDATE SET t.v = s.v;",
+	"UPDATE t SET a = 1 WHERE b = 2 OPTION (MAXDOP 1, LOOP JOIN)",
+	"SELECT a FROM t OPTION (RECOMPILE, OPTIMIZE FOR (@p UNKNOWN), USE HINT('X'))",
+	"SELECT ROW_NUMBER() OVER (PARTITION BY a ORDER BY b) FROM t",
+	"/* unterminated",
+	"SELECT 'unterminated",
+	"SELECT (((",
+	"EXEC sp_executesql N'SELECT @p', N'@p INT', @p = 1",
+}
+
+// FuzzParseProgram checks that arbitrary input neither panics nor hangs
+// the lexer and parser.
+func FuzzParseProgram(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		// Parse in a goroutine so a hang can be reported; panics are
+		// recovered there and re-raised as test failures.
+		done := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					done <- fmt.Sprintf("%v\n%s", r, debug.Stack())
+					return
+				}
+				done <- nil
+			}()
+			p := New(lexer.New(input))
+			program := p.ParseProgram()
+			// Callers only render programs that parsed cleanly
+			if len(p.Errors()) == 0 && program != nil {
+				_ = program.String()
+			}
+		}()
+		select {
+		case r := <-done:
+			if r != nil {
+				t.Fatalf("panic parsing %q: %v", input, r)
+			}
+		case <-time.After(parseTimeout):
+			t.Fatalf("parse of %q did not finish within %v", input, parseTimeout)
+		}
+	})
+}
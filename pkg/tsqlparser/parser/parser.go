@@ -3,6 +3,7 @@ package parser
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -274,6 +275,22 @@ func (p *Parser) nextToken() {
 	}
 }
 
+// setQuotedIdentifier applies SET QUOTED_IDENTIFIER, which takes effect
+// as the batch is parsed: the text in double quotes that follows is an
+// identifier when on and a string when off, the look-ahead included.
+func (p *Parser) setQuotedIdentifier(on bool) {
+	p.l.SetQuotedIdentifier(on)
+	for _, tok := range []*token.Token{&p.peekToken, &p.peekPeekToken} {
+		if tok.Quote != '"' {
+			continue
+		}
+		tok.Type = token.STRING
+		if on {
+			tok.Type = token.IDENT
+		}
+	}
+}
+
 func (p *Parser) curTokenIs(t token.Type) bool {
 	return p.curToken.Type == t
 }
@@ -424,12 +441,12 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseReconfigureStatement()
 	case token.DBCC:
 		return p.parseDbccStatement()
-	case token.GRANT, token.REVOKE, token.DENY:
-		// Security statements - skip to end (not relevant for transpilation)
-		for !p.curTokenIs(token.SEMICOLON) && !p.curTokenIs(token.GO) && !p.curTokenIs(token.EOF) {
-			p.nextToken()
-		}
-		return nil
+	case token.GRANT:
+		return p.parseGrantStatement()
+	case token.REVOKE:
+		return p.parseRevokeStatement()
+	case token.DENY:
+		return p.parseDenyStatement()
 	case token.BACKUP:
 		return p.parseBackupStatement()
 	case token.RESTORE:
@@ -445,6 +462,9 @@ func (p *Parser) parseStatement() ast.Statement {
 	case token.GET:
 		// GET CONVERSATION GROUP
 		return p.parseGetConversationGroupStatement()
+	case token.ADD:
+		// ADD SENSITIVITY CLASSIFICATION
+		return p.parseAddSensitivityClassificationStatement()
 	case token.LPAREN:
 		// Parenthesized SELECT for set operations: (SELECT ...) UNION/INTERSECT/EXCEPT SELECT ...
 		return p.parseParenthesizedSelectStatement()
@@ -471,7 +491,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	}
 	leftExp := prefix()
 
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+	for leftExp != nil && !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return leftExp
@@ -484,6 +504,14 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
+// missingError records that a required part of a statement is absent at
+// the current token.
+func (p *Parser) missingError(what string) {
+	msg := fmt.Sprintf("line %d, col %d: expected %s, got %s",
+		p.curToken.Line, p.curToken.Column, what, p.curToken.Type)
+	p.errors = append(p.errors, msg)
+}
+
 func (p *Parser) noPrefixParseFnError(t token.Type) {
 	msg := fmt.Sprintf("line %d, col %d: no prefix parse function for %s found",
 		p.curToken.Line, p.curToken.Column, t)
@@ -491,9 +519,59 @@ func (p *Parser) noPrefixParseFnError(t token.Type) {
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
+	if strings.ToUpper(p.curToken.Literal) == "MATCH" && p.peekTokenIs(token.LPAREN) {
+		return p.parseGraphMatch()
+	}
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseGraphMatch parses a MATCH predicate: MATCH(a-(e)->b<-(f)-c AND ...).
+func (p *Parser) parseGraphMatch() ast.Expression {
+	match := &ast.GraphMatchExpression{Token: p.curToken}
+	p.nextToken() // consume (
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		pattern := &ast.GraphPattern{Nodes: []*ast.Identifier{{Token: p.curToken, Value: p.curToken.Literal}}}
+		for p.peekTokenIs(token.MINUS) || p.peekTokenIs(token.LT) {
+			p.nextToken()
+			edge := &ast.GraphEdge{Reverse: p.curTokenIs(token.LT)}
+			if edge.Reverse && !p.expectPeek(token.MINUS) {
+				return nil
+			}
+			if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			edge.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.MINUS) {
+				return nil
+			}
+			if !edge.Reverse && !p.expectPeek(token.GT) {
+				return nil
+			}
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Edges = append(pattern.Edges, edge)
+			pattern.Nodes = append(pattern.Nodes, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		}
+		if len(pattern.Edges) == 0 {
+			p.peekError(token.MINUS)
+			return nil
+		}
+		match.Patterns = append(match.Patterns, pattern)
+		if !p.peekTokenIs(token.AND) {
+			break
+		}
+		p.nextToken() // consume AND
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return match
+}
+
 func (p *Parser) parseVariable() ast.Expression {
 	return &ast.Variable{Token: p.curToken, Name: p.curToken.Literal}
 }
@@ -576,6 +654,9 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	}
 	p.nextToken()
 	expression.Right = p.parseExpression(PREFIX)
+	if expression.Right == nil {
+		return nil
+	}
 	return expression
 }
 
@@ -588,6 +669,9 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	precedence := p.curPrecedence()
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
+	if expression.Right == nil {
+		return nil
+	}
 	return expression
 }
 
@@ -638,6 +722,18 @@ func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
 	
 	// Not a method call, build qualified identifier as before
 	right := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// A property of a variable or call result, such as @g.Lat, has no
+	// name to qualify
+	switch left.(type) {
+	case *ast.QualifiedIdentifier, *ast.Identifier:
+	default:
+		return &ast.MethodCallExpression{
+			Token:      dotToken,
+			Object:     left,
+			MethodName: methodName,
+		}
+	}
 	
 	parts := []*ast.Identifier{}
 
@@ -696,6 +792,10 @@ func (p *Parser) parseScopeExpression(left ast.Expression) ast.Expression {
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.FunctionCall{Token: p.curToken, Function: function}
+	if p.peekTokenIs(token.DISTINCT) {
+		p.nextToken()
+		exp.Distinct = true
+	}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
 
 	// Check for WITHIN GROUP clause (for ordered-set aggregate functions)
@@ -2943,6 +3043,11 @@ func (p *Parser) parseQualifiedIdentifier() *ast.QualifiedIdentifier {
 
 	for p.peekTokenIs(token.DOT) {
 		p.nextToken()
+		if p.peekTokenIs(token.DOT) {
+			// database..table leaves out the schema
+			qi.Parts = append(qi.Parts, &ast.Identifier{Token: p.curToken})
+			continue
+		}
 		p.nextToken()
 		qi.Parts = append(qi.Parts, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
 	}
@@ -3207,6 +3312,11 @@ func (p *Parser) parseInsertStatement() ast.Statement {
 	} else if p.peekTokenIs(token.SELECT) {
 		p.nextToken()
 		stmt.Select = p.parseSelectStatement()
+	} else if p.peekTokenIs(token.EXEC) || p.peekTokenIs(token.EXECUTE) {
+		p.nextToken()
+		if exec, ok := p.parseExecStatement().(*ast.ExecStatement); ok {
+			stmt.Exec = exec
+		}
 	}
 
 	return stmt
@@ -3385,6 +3495,12 @@ func (p *Parser) parseUpdateStatement() ast.Statement {
 		}
 	}
 
+	// Parse OPTION clause
+	if p.peekTokenIs(token.OPTION) {
+		p.nextToken() // consume OPTION
+		stmt.Options = p.parseOptionClause()
+	}
+
 	return stmt
 }
 
@@ -3562,6 +3678,12 @@ func (p *Parser) parseDeleteStatement() ast.Statement {
 		}
 	}
 
+	// Parse OPTION clause
+	if p.peekTokenIs(token.OPTION) {
+		p.nextToken() // consume OPTION
+		stmt.Options = p.parseOptionClause()
+	}
+
 	return stmt
 }
 
@@ -3692,6 +3814,12 @@ func (p *Parser) parseMergeStatement() ast.Statement {
 		stmt.Output = p.parseOutputClause()
 	}
 
+	// Parse OPTION clause
+	if p.peekTokenIs(token.OPTION) {
+		p.nextToken() // consume OPTION
+		stmt.Options = p.parseOptionClause()
+	}
+
 	return stmt
 }
 
@@ -4048,6 +4176,9 @@ func (p *Parser) parseSetStatement() ast.Statement {
 		stmt.Option = strings.Join(options, ", ")
 		p.nextToken()
 		stmt.OnOff = strings.ToUpper(p.curToken.Literal)
+		if slices.Contains(options, "QUOTED_IDENTIFIER") {
+			p.setQuotedIdentifier(stmt.OnOff == "ON")
+		}
 		return stmt
 	}
 
@@ -4117,7 +4248,12 @@ func (p *Parser) parseSetDeadlockPriority(setToken token.Token) ast.Statement {
 	stmt.Option = "DEADLOCK_PRIORITY"
 	p.nextToken() // move past DEADLOCK_PRIORITY
 	// Can be LOW, NORMAL, HIGH, or a number -10 to 10
-	stmt.OnOff = strings.ToUpper(p.curToken.Literal)
+	sign := ""
+	if p.curTokenIs(token.MINUS) {
+		sign = "-"
+		p.nextToken()
+	}
+	stmt.OnOff = sign + strings.ToUpper(p.curToken.Literal)
 	return stmt
 }
 
@@ -4193,9 +4329,17 @@ func (p *Parser) parseIfStatement() ast.Statement {
 	p.nextToken()
 
 	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		p.missingError("IF condition")
+		return nil
+	}
 	p.nextToken()
 
 	stmt.Consequence = p.parseStatement()
+	if stmt.Consequence == nil {
+		p.missingError("statement after IF")
+		return nil
+	}
 
 	// After parsing consequence, advance past any semicolons to check for ELSE
 	// First, advance to peek position (the token after the last token of consequence)
@@ -4211,6 +4355,10 @@ func (p *Parser) parseIfStatement() ast.Statement {
 		p.nextToken() // move to ELSE
 		p.nextToken() // move past ELSE to the alternative statement
 		stmt.Alternative = p.parseStatement()
+		if stmt.Alternative == nil {
+			p.missingError("statement after ELSE")
+			return nil
+		}
 	}
 
 	return stmt
@@ -4221,9 +4369,17 @@ func (p *Parser) parseWhileStatement() ast.Statement {
 	p.nextToken()
 
 	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		p.missingError("WHILE condition")
+		return nil
+	}
 	p.nextToken()
 
 	stmt.Body = p.parseStatement()
+	if stmt.Body == nil {
+		p.missingError("statement after WHILE")
+		return nil
+	}
 
 	return stmt
 }
@@ -4459,6 +4615,12 @@ func (p *Parser) parseExecStatement() ast.Statement {
 		if p.peekTokenIs(token.RECOMPILE) {
 			p.nextToken()
 			stmt.Recompile = true
+		} else if p.peekTokenIs(token.IDENT) && strings.ToUpper(p.peekToken.Literal) == "DRYRUN" {
+			p.nextToken()
+			stmt.DryRun = true
+		} else if p.peekTokenIs(token.IDENT) && strings.ToUpper(p.peekToken.Literal) == "TRACE" {
+			p.nextToken()
+			stmt.Trace = true
 		} else if p.peekTokenIs(token.RESULT) {
 			// Handle WITH RESULT SETS here
 			p.nextToken() // move to RESULT
@@ -4487,12 +4649,18 @@ func (p *Parser) parseExecStatement() ast.Statement {
 		stmt.Parameters = p.parseExecParameters()
 	}
 
-	// Check for WITH RECOMPILE or WITH RESULT SETS after parameters
+	// Check for WITH RECOMPILE, WITH DRYRUN, WITH TRACE or WITH RESULT SETS after parameters
 	if p.peekTokenIs(token.WITH) {
 		p.nextToken()
 		if p.peekTokenIs(token.RECOMPILE) {
 			p.nextToken()
 			stmt.Recompile = true
+		} else if p.peekTokenIs(token.IDENT) && strings.ToUpper(p.peekToken.Literal) == "DRYRUN" {
+			p.nextToken()
+			stmt.DryRun = true
+		} else if p.peekTokenIs(token.IDENT) && strings.ToUpper(p.peekToken.Literal) == "TRACE" {
+			p.nextToken()
+			stmt.Trace = true
 		} else if p.peekTokenIs(token.RESULT) {
 			p.nextToken() // move to RESULT
 			if p.peekTokenIs(token.SETS) {
@@ -4914,7 +5082,11 @@ func (p *Parser) parseCreateTableStatement() ast.Statement {
 			}
 			return stmt
 		}
-		// Handle other AS cases (NODE, EDGE) - but those come after column defs
+		// A graph table may have no columns of its own: CREATE TABLE e AS EDGE
+		if graphType := strings.ToUpper(p.curToken.Literal); graphType == "NODE" || graphType == "EDGE" {
+			stmt.GraphType = graphType
+			return stmt
+		}
 	}
 
 	if !p.expectPeek(token.LPAREN) {
@@ -5000,7 +5172,7 @@ func (p *Parser) parseCreateTableStatement() ast.Statement {
 	if p.peekTokenIs(token.AS) {
 		p.nextToken() // consume AS
 		p.nextToken() // move to NODE/EDGE
-		// Just consume it - we're not storing it for now
+		stmt.GraphType = strings.ToUpper(p.curToken.Literal)
 	}
 
 	return stmt
@@ -5016,7 +5188,8 @@ func (p *Parser) isTableConstraintStart() bool {
 		p.curTokenIs(token.FOREIGN) ||
 		p.curTokenIs(token.UNIQUE) ||
 		p.curTokenIs(token.CHECK) ||
-		p.curTokenIs(token.INDEX)
+		p.curTokenIs(token.INDEX) ||
+		(strings.ToUpper(p.curToken.Literal) == "CONNECTION" && p.peekTokenIs(token.LPAREN))
 }
 
 func (p *Parser) parseColumnDefinition() *ast.ColumnDefinition {
@@ -5572,6 +5745,36 @@ func (p *Parser) parseTableConstraint() *ast.TableConstraint {
 		}
 		p.expectPeek(token.LPAREN)
 		constraint.Columns = p.parseIndexColumns()
+
+	default:
+		// CONNECTION (Node1 TO Node2, ...) [ON DELETE CASCADE] on an edge table
+		if strings.ToUpper(p.curToken.Literal) != "CONNECTION" {
+			break
+		}
+		constraint.Type = ast.ConstraintConnection
+		p.expectPeek(token.LPAREN)
+		for !p.curTokenIs(token.RPAREN) && !p.curTokenIs(token.EOF) {
+			p.nextToken()
+			conn := &ast.EdgeConnection{From: p.parseQualifiedIdentifier()}
+			if !p.expectPeek(token.TO) {
+				return nil
+			}
+			p.nextToken()
+			conn.To = p.parseQualifiedIdentifier()
+			constraint.Connections = append(constraint.Connections, conn)
+			p.nextToken() // move to , or )
+		}
+		if p.peekTokenIs(token.ON) {
+			p.nextToken() // consume ON
+			p.nextToken() // move to DELETE
+			p.nextToken() // move to the action
+			if p.curTokenIs(token.CASCADE) {
+				constraint.OnDelete = "CASCADE"
+			} else if strings.ToUpper(p.curToken.Literal) == "NO" {
+				p.nextToken()
+				constraint.OnDelete = "NO ACTION"
+			}
+		}
 	}
 
 	return constraint
@@ -5648,6 +5851,15 @@ func (p *Parser) parseDropStatement() ast.Statement {
 			return p.parseDropObjectStatement(dropToken)
 		}
 		return nil
+	case token.PARTITION:
+		// DROP PARTITION FUNCTION or DROP PARTITION SCHEME
+		if p.peekTokenIs(token.FUNCTION) || p.peekTokenIs(token.SCHEME) {
+			p.nextToken() // move to FUNCTION/SCHEME
+			stmt := p.parseDropObjectStatement(dropToken).(*ast.DropObjectStatement)
+			stmt.ObjectType = "PARTITION " + strings.ToUpper(stmt.ObjectType)
+			return stmt
+		}
+		return nil
 	case token.DATABASE:
 		// DROP DATABASE [IF EXISTS] name [, name, ...] or DROP DATABASE SCOPED CREDENTIAL
 		// Check for SCOPED first
@@ -5661,8 +5873,11 @@ func (p *Parser) parseDropStatement() ast.Statement {
 		// Regular DROP DATABASE
 		return p.parseDropObjectStatement(dropToken)
 	case token.IDENT:
-		// DROP APPLICATION ROLE, DROP CREDENTIAL, or DROP RULE
+		// DROP APPLICATION ROLE, DROP CREDENTIAL, DROP RULE or DROP SENSITIVITY CLASSIFICATION
 		upper := strings.ToUpper(p.curToken.Literal)
+		if upper == "SENSITIVITY" {
+			return p.parseDropSensitivityClassificationStatement(dropToken)
+		}
 		if upper == "APPLICATION" && p.peekTokenIs(token.ROLE) {
 			p.nextToken() // move to ROLE
 			return p.parseDropObjectStatement(dropToken)
@@ -7290,13 +7505,13 @@ func (p *Parser) parseGrantStatement() ast.Statement {
 	}
 
 	// Parse WITH GRANT OPTION
-	if p.curTokenIs(token.WITH) {
-		p.nextToken() // move past WITH
-		if p.curTokenIs(token.GRANT) {
-			p.nextToken() // move past GRANT
-			if p.curTokenIs(token.OPTION) {
+	if p.peekTokenIs(token.WITH) {
+		p.nextToken() // move to WITH
+		if p.peekTokenIs(token.GRANT) {
+			p.nextToken() // move to GRANT
+			if p.peekTokenIs(token.OPTION) {
+				p.nextToken() // move to OPTION
 				stmt.WithGrantOption = true
-				p.nextToken()
 			}
 		}
 	}
@@ -7365,9 +7580,9 @@ func (p *Parser) parseRevokeStatement() ast.Statement {
 	}
 
 	// Check for CASCADE
-	if p.curTokenIs(token.CASCADE) {
+	if p.peekTokenIs(token.CASCADE) {
+		p.nextToken() // move to CASCADE
 		stmt.Cascade = true
-		p.nextToken()
 	}
 
 	return stmt
@@ -7422,9 +7637,9 @@ func (p *Parser) parseDenyStatement() ast.Statement {
 	}
 
 	// Check for CASCADE
-	if p.curTokenIs(token.CASCADE) {
+	if p.peekTokenIs(token.CASCADE) {
+		p.nextToken() // move to CASCADE
 		stmt.Cascade = true
-		p.nextToken()
 	}
 
 	return stmt
@@ -7460,7 +7675,8 @@ func (p *Parser) parsePermissionList() []string {
 	return perms
 }
 
-// parsePrincipalList parses a comma-separated list of principals (users, roles)
+// parsePrincipalList parses a comma-separated list of principals (users,
+// roles), leaving the last as the current token
 func (p *Parser) parsePrincipalList() []string {
 	var principals []string
 
@@ -7468,13 +7684,12 @@ func (p *Parser) parsePrincipalList() []string {
 		// Principal name - can be [bracketed] or plain
 		name := p.curToken.Literal
 		principals = append(principals, name)
-		p.nextToken()
 
-		if p.curTokenIs(token.COMMA) {
-			p.nextToken() // consume comma
-		} else {
+		if !p.peekTokenIs(token.COMMA) {
 			break
 		}
+		p.nextToken() // move to comma
+		p.nextToken() // move to next principal
 	}
 
 	return principals
@@ -7718,12 +7933,11 @@ func (p *Parser) parseCreateRoleStatement(createToken token.Token) ast.Statement
 	p.nextToken() // move past ROLE
 
 	stmt.Name = p.curToken.Literal
-	p.nextToken()
 
-	if p.curTokenIs(token.AUTHORIZATION) {
-		p.nextToken() // move past AUTHORIZATION
+	if p.peekTokenIs(token.AUTHORIZATION) {
+		p.nextToken() // move to AUTHORIZATION
+		p.nextToken() // move to owner
 		stmt.Authorization = p.curToken.Literal
-		p.nextToken()
 	}
 
 	return stmt
@@ -7912,30 +8126,31 @@ func (p *Parser) parseAlterRoleStatement(alterToken token.Token) ast.Statement {
 	p.nextToken() // move past ROLE
 
 	stmt.Name = p.curToken.Literal
-	p.nextToken()
 
 	// Check for ADD MEMBER, DROP MEMBER, or WITH NAME
-	if p.curTokenIs(token.ADD) {
-		p.nextToken() // move past ADD
-		if p.curTokenIs(token.MEMBER) {
-			p.nextToken() // move past MEMBER
+	if p.peekTokenIs(token.ADD) {
+		p.nextToken() // move to ADD
+		if p.peekTokenIs(token.MEMBER) {
+			p.nextToken() // move to MEMBER
+			p.nextToken() // move to member
 			stmt.AddMember = p.curToken.Literal
-			p.nextToken()
 		}
-	} else if p.curTokenIs(token.DROP) {
-		p.nextToken() // move past DROP
-		if p.curTokenIs(token.MEMBER) {
-			p.nextToken() // move past MEMBER
+	} else if p.peekTokenIs(token.DROP) {
+		p.nextToken() // move to DROP
+		if p.peekTokenIs(token.MEMBER) {
+			p.nextToken() // move to MEMBER
+			p.nextToken() // move to member
 			stmt.DropMember = p.curToken.Literal
-			p.nextToken()
 		}
-	} else if p.curTokenIs(token.WITH) {
-		p.nextToken() // move past WITH
-		if strings.ToUpper(p.curToken.Literal) == "NAME" && p.peekTokenIs(token.EQ) {
-			p.nextToken() // consume =
-			p.nextToken() // move to value
-			stmt.NewName = p.curToken.Literal
-			p.nextToken()
+	} else if p.peekTokenIs(token.WITH) {
+		p.nextToken() // move to WITH
+		if strings.ToUpper(p.peekToken.Literal) == "NAME" {
+			p.nextToken() // move to NAME
+			if p.peekTokenIs(token.EQ) {
+				p.nextToken() // move to =
+				p.nextToken() // move to value
+				stmt.NewName = p.curToken.Literal
+			}
 		}
 	}
 
@@ -8798,8 +9013,7 @@ func (p *Parser) parseContainsExpression() ast.Expression {
 		// Multiple columns
 		p.nextToken() // move past (
 		for !p.curTokenIs(token.RPAREN) && !p.curTokenIs(token.EOF) {
-			expr.Columns = append(expr.Columns, p.curToken.Literal)
-			p.nextToken()
+			expr.Columns = append(expr.Columns, p.parseFulltextColumnName())
 			if p.curTokenIs(token.COMMA) {
 				p.nextToken()
 			}
@@ -8807,8 +9021,7 @@ func (p *Parser) parseContainsExpression() ast.Expression {
 		p.nextToken() // move past )
 	} else {
 		// Single column
-		expr.Columns = []string{p.curToken.Literal}
-		p.nextToken()
+		expr.Columns = []string{p.parseFulltextColumnName()}
 	}
 	
 	// Expect comma
@@ -8853,8 +9066,7 @@ func (p *Parser) parseFreetextExpression() ast.Expression {
 		// Multiple columns
 		p.nextToken() // move past (
 		for !p.curTokenIs(token.RPAREN) && !p.curTokenIs(token.EOF) {
-			expr.Columns = append(expr.Columns, p.curToken.Literal)
-			p.nextToken()
+			expr.Columns = append(expr.Columns, p.parseFulltextColumnName())
 			if p.curTokenIs(token.COMMA) {
 				p.nextToken()
 			}
@@ -8862,8 +9074,7 @@ func (p *Parser) parseFreetextExpression() ast.Expression {
 		p.nextToken() // move past )
 	} else {
 		// Single column
-		expr.Columns = []string{p.curToken.Literal}
-		p.nextToken()
+		expr.Columns = []string{p.parseFulltextColumnName()}
 	}
 	
 	// Expect comma
@@ -8891,6 +9102,20 @@ func (p *Parser) parseFreetextExpression() ast.Expression {
 	return expr
 }
 
+// parseFulltextColumnName parses a column in a CONTAINS or FREETEXT column
+// list, which may be qualified (p.description), leaving the current token
+// after it.
+func (p *Parser) parseFulltextColumnName() string {
+	name := p.curToken.Literal
+	p.nextToken()
+	for p.curTokenIs(token.DOT) {
+		p.nextToken() // move past .
+		name += "." + p.curToken.Literal
+		p.nextToken()
+	}
+	return name
+}
+
 // parseContainsTableExpression parses CONTAINSTABLE(table, column, 'search term')
 func (p *Parser) parseContainsTableExpression() ast.Expression {
 	expr := &ast.ContainsTableExpression{Token: p.curToken}
@@ -9088,33 +9313,33 @@ func (p *Parser) parseCreateFulltextIndexStatement(createToken token.Token) ast.
 				p.nextToken()
 			}
 		}
-		p.nextToken() // move past )
 	}
 	
 	// Parse KEY INDEX
-	if p.curTokenIs(token.KEY) {
-		p.nextToken() // move past KEY
-		if p.curTokenIs(token.INDEX) {
-			p.nextToken() // move past INDEX
+	if p.peekTokenIs(token.KEY) {
+		p.nextToken() // move to KEY
+		if p.peekTokenIs(token.INDEX) {
+			p.nextToken() // move to INDEX
 		}
-		stmt.KeyIndex = p.curToken.Literal
 		p.nextToken()
+		stmt.KeyIndex = p.curToken.Literal
 	}
 	
 	// Parse ON catalog
-	if p.curTokenIs(token.ON) {
-		p.nextToken() // move past ON
-		stmt.OnCatalog = p.curToken.Literal
+	if p.peekTokenIs(token.ON) {
+		p.nextToken() // move to ON
 		p.nextToken()
+		stmt.OnCatalog = p.curToken.Literal
 	}
 	
-	// Parse WITH options
-	if p.curTokenIs(token.WITH) {
-		p.nextToken() // move past WITH
-		if p.curTokenIs(token.LPAREN) {
+	// Parse WITH options, ending on their last token
+	if p.peekTokenIs(token.WITH) {
+		p.nextToken() // move to WITH
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken() // move to (
 			// Skip to matching )
 			depth := 1
-			for depth > 0 && !p.curTokenIs(token.EOF) {
+			for depth > 0 && !p.peekTokenIs(token.EOF) {
 				p.nextToken()
 				if p.curTokenIs(token.LPAREN) {
 					depth++
@@ -9122,7 +9347,18 @@ func (p *Parser) parseCreateFulltextIndexStatement(createToken token.Token) ast.
 					depth--
 				}
 			}
-			p.nextToken() // move past final )
+		} else if strings.ToUpper(p.peekToken.Literal) == "CHANGE_TRACKING" {
+			// WITH CHANGE_TRACKING [=] {MANUAL | AUTO | OFF [, NO POPULATION]}
+			p.nextToken() // move to CHANGE_TRACKING
+			if p.peekTokenIs(token.EQ) {
+				p.nextToken()
+			}
+			p.nextToken() // move to the tracking mode
+			if p.peekTokenIs(token.COMMA) {
+				p.nextToken() // move to ,
+				p.nextToken() // move to NO
+				p.nextToken() // move to POPULATION
+			}
 		}
 	}
 	
@@ -9157,11 +9393,12 @@ func (p *Parser) parseAlterFulltextIndexStatement(alterToken token.Token) ast.St
 	// Parse action: ADD, DROP, ENABLE, DISABLE, START, STOP, etc.
 	action := strings.ToUpper(p.curToken.Literal)
 	stmt.Action = action
-	p.nextToken()
 	
-	// For ADD/DROP, parse column list
-	if action == "ADD" || action == "DROP" {
-		if p.curTokenIs(token.LPAREN) {
+	switch action {
+	case "ADD", "DROP":
+		// For ADD/DROP, parse column list
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken() // move to (
 			p.nextToken() // move past (
 			
 			for !p.curTokenIs(token.RPAREN) && !p.curTokenIs(token.EOF) {
@@ -9182,8 +9419,30 @@ func (p *Parser) parseAlterFulltextIndexStatement(alterToken token.Token) ast.St
 					p.nextToken()
 				}
 			}
-			p.nextToken() // move past )
 		}
+		// WITH NO POPULATION
+		if p.peekTokenIs(token.WITH) {
+			p.nextToken() // move to WITH
+			p.nextToken() // move to NO
+			p.nextToken() // move to POPULATION
+		}
+	case "START", "STOP", "PAUSE", "RESUME":
+		// START {FULL | INCREMENTAL | UPDATE} POPULATION, STOP POPULATION, ...
+		for _, word := range []string{"FULL", "INCREMENTAL", "UPDATE", "POPULATION"} {
+			if strings.ToUpper(p.peekToken.Literal) == word {
+				p.nextToken()
+				stmt.Action += " " + word
+			}
+		}
+	case "SET":
+		// SET CHANGE_TRACKING [=] {MANUAL | AUTO | OFF}, SET STOPLIST ...
+		p.nextToken() // move to the option
+		stmt.Action += " " + strings.ToUpper(p.curToken.Literal)
+		if p.peekTokenIs(token.EQ) {
+			p.nextToken()
+		}
+		p.nextToken() // move to the value
+		stmt.Action += " = " + strings.ToUpper(p.curToken.Literal)
 	}
 	
 	return stmt
@@ -9229,6 +9488,95 @@ func (p *Parser) parseDropFulltextCatalogStatement(dropToken token.Token) ast.St
 	return stmt
 }
 
+// parseSensitivityColumns parses the columns of SENSITIVITY CLASSIFICATION
+// TO or FROM, from SENSITIVITY, ending on the last column.
+func (p *Parser) parseSensitivityColumns(preposition token.Type) []*ast.QualifiedIdentifier {
+	if !p.peekTokenIs(token.IDENT) || !strings.EqualFold(p.peekToken.Literal, "CLASSIFICATION") {
+		p.nextToken()
+		p.missingError("CLASSIFICATION")
+		return nil
+	}
+	p.nextToken() // move to CLASSIFICATION
+	if !p.expectPeek(preposition) {
+		return nil
+	}
+	var columns []*ast.QualifiedIdentifier
+	for {
+		p.nextToken()
+		col := p.parseQualifiedIdentifier()
+		if len(col.Parts) < 2 {
+			p.missingError("table.column")
+			return nil
+		}
+		columns = append(columns, col)
+		if !p.peekTokenIs(token.COMMA) {
+			return columns
+		}
+		p.nextToken() // move to ,
+	}
+}
+
+// parseAddSensitivityClassificationStatement parses ADD SENSITIVITY
+// CLASSIFICATION TO columns WITH (option = value, ...).
+func (p *Parser) parseAddSensitivityClassificationStatement() ast.Statement {
+	stmt := &ast.AddSensitivityClassificationStatement{Token: p.curToken}
+	if !p.peekTokenIs(token.IDENT) || !strings.EqualFold(p.peekToken.Literal, "SENSITIVITY") {
+		p.nextToken()
+		p.missingError("SENSITIVITY")
+		return nil
+	}
+	p.nextToken() // move to SENSITIVITY
+	if stmt.Columns = p.parseSensitivityColumns(token.TO); stmt.Columns == nil {
+		return nil
+	}
+	if !p.expectPeek(token.WITH) || !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	for {
+		p.nextToken()
+		name := strings.ToUpper(p.curToken.Literal)
+		if !p.expectPeek(token.EQ) {
+			return nil
+		}
+		p.nextToken()
+		value := p.curToken.Literal
+		switch name {
+		case "LABEL":
+			stmt.Label = value
+		case "LABEL_ID":
+			stmt.LabelID = value
+		case "INFORMATION_TYPE":
+			stmt.InformationType = value
+		case "INFORMATION_TYPE_ID":
+			stmt.InformationTypeID = value
+		case "RANK":
+			stmt.Rank = strings.ToUpper(value)
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("line %d, col %d: unknown sensitivity classification option %s",
+				p.curToken.Line, p.curToken.Column, name))
+			return nil
+		}
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken() // move to ,
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return stmt
+}
+
+// parseDropSensitivityClassificationStatement parses DROP SENSITIVITY
+// CLASSIFICATION FROM columns.
+func (p *Parser) parseDropSensitivityClassificationStatement(dropToken token.Token) ast.Statement {
+	stmt := &ast.DropSensitivityClassificationStatement{Token: dropToken}
+	if stmt.Columns = p.parseSensitivityColumns(token.FROM); stmt.Columns == nil {
+		return nil
+	}
+	return stmt
+}
+
 // -----------------------------------------------------------------------------
 // Stage 11: Resource Governor & Availability Groups
 // -----------------------------------------------------------------------------
@@ -10068,10 +10416,12 @@ func (p *Parser) parseGetConversationGroupStatement() ast.Statement {
 	p.nextToken()
 	
 	// FROM queue
-	if p.curTokenIs(token.FROM) {
-		p.nextToken() // move past FROM
-		stmt.FromQueue = p.parseQualifiedIdentifier()
+	if !p.curTokenIs(token.FROM) {
+		p.missingError("FROM")
+		return nil
 	}
+	p.nextToken() // move past FROM
+	stmt.FromQueue = p.parseQualifiedIdentifier()
 	
 	return stmt
 }
@@ -10417,18 +10767,28 @@ func (p *Parser) parseWithStatement() ast.Statement {
 
 	// Parse CTEs
 	cte := p.parseCTEDef()
+	if cte == nil {
+		return nil
+	}
 	stmt.CTEs = append(stmt.CTEs, cte)
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
 		cte = p.parseCTEDef()
+		if cte == nil {
+			return nil
+		}
 		stmt.CTEs = append(stmt.CTEs, cte)
 	}
 
 	// Parse main query
 	p.nextToken()
 	stmt.Query = p.parseStatement()
+	if stmt.Query == nil {
+		p.missingError("statement after WITH")
+		return nil
+	}
 
 	return stmt
 }
@@ -10503,8 +10863,14 @@ func (p *Parser) parseCTEDef() *ast.CTEDef {
 	p.nextToken()
 
 	cte.Query = p.parseSelectStatement()
+	if cte.Query == nil {
+		p.missingError("SELECT in CTE definition")
+		return nil
+	}
 
-	p.expectPeek(token.RPAREN)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
 
 	return cte
 }
@@ -1053,6 +1053,7 @@ type Token struct {
 	Literal string
 	Line    int
 	Column  int
+	Quote   rune // Opening delimiter of a delimited identifier, [ or ", or of a string in " (0 = none)
 }
 
 // Position represents a position in source code.
@@ -0,0 +1,168 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sp_getapplock and sp_releaseapplock take and release application locks:
+// named locks that procedures use to coordinate among themselves, with
+// nothing in the database behind them. They always run in the
+// interpreter, against the server's lock manager, whatever the backend.
+//
+// A lock is owned by the transaction that took it, and released when the
+// transaction ends, or by the session, and released by sp_releaseapplock
+// or when the session closes. A lock taken n times must be released n
+// times. Locks of the same session never block one another.
+
+// Return codes of sp_getapplock.
+const (
+	AppLockGranted          = 0    // Granted at once
+	AppLockGrantedAfterWait = 1    // Granted after waiting for other locks to be released
+	AppLockTimeout          = -1   // Not granted within @LockTimeout
+	AppLockCancelled        = -2   // The wait was cancelled
+	AppLockDeadlock         = -3   // Chosen as the victim of a deadlock
+	AppLockInvalid          = -999 // Invalid parameters or another error
+)
+
+// ErrAppLockNotHeld is raised by sp_releaseapplock for a lock the caller
+// does not hold.
+const ErrAppLockNotHeld = 1223
+
+// AppLockModes lists the lock modes of sp_getapplock, from weakest.
+var AppLockModes = []string{"IntentShared", "Shared", "Update", "IntentExclusive", "Exclusive"}
+
+// AppLock names an application lock and who owns it.
+type AppLock struct {
+	Resource  string // Compared in binary, so case-sensitive
+	Principal string // Database principal whose locks these are
+	Mode      string // One of AppLockModes; unused on release
+	Session   bool   // Owned by the session rather than the transaction
+}
+
+// AppLocker holds application locks. A TableLocker that implements it
+// serves sp_getapplock and sp_releaseapplock.
+type AppLocker interface {
+	// GetAppLock waits up to timeout, or without limit when timeout is
+	// negative, until lock is granted, and returns one of the AppLock
+	// return codes. It fails only with ctx's error if ctx ends first.
+	GetAppLock(ctx context.Context, lock AppLock, timeout time.Duration) (int, error)
+	// ReleaseAppLock releases lock once, reporting whether it was held.
+	ReleaseAppLock(lock AppLock) bool
+}
+
+// sp_getapplock and sp_releaseapplock are registered at init, beside
+// their handlers.
+func init() {
+	systemProcedures["SP_GETAPPLOCK"] = systemProcedure{
+		params: []string{"@resource", "@lockmode", "@lockowner", "@locktimeout", "@dbprincipal"},
+		run:    (*Interpreter).spGetAppLock,
+		local:  true,
+	}
+	systemProcedures["SP_RELEASEAPPLOCK"] = systemProcedure{
+		params: []string{"@resource", "@lockowner", "@dbprincipal"},
+		run:    (*Interpreter).spReleaseAppLock,
+		local:  true,
+	}
+}
+
+// spGetAppLock runs sp_getapplock.
+func (i *Interpreter) spGetAppLock(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	const proc = "sp_getapplock"
+	args[returnValueArg] = NewInt(AppLockInvalid)
+
+	lock, err := appLockArgs(proc, args)
+	if err != nil {
+		return err
+	}
+	mode := argString(args, "@lockmode")
+	for _, m := range AppLockModes {
+		if strings.EqualFold(mode, m) {
+			lock.Mode = m
+		}
+	}
+	if lock.Mode == "" {
+		return invalidAppLockParameter(proc)
+	}
+	timeout := time.Duration(-1)
+	if v, ok := args["@locktimeout"]; ok && !v.IsNull {
+		ms := v.AsInt()
+		if ms < -1 {
+			return invalidAppLockParameter(proc)
+		}
+		if ms >= 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if !lock.Session && i.ctx.TranCount == 0 {
+		return NewSQLError(ErrInvalidParameter,
+			"You attempted to acquire a transactional application lock without an active transaction.")
+	}
+
+	locker, err := i.appLocker(proc)
+	if err != nil {
+		return err
+	}
+	rc, err := locker.GetAppLock(ctx, lock, timeout)
+	args[returnValueArg] = NewInt(int64(rc))
+	return err
+}
+
+// spReleaseAppLock runs sp_releaseapplock.
+func (i *Interpreter) spReleaseAppLock(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	const proc = "sp_releaseapplock"
+	args[returnValueArg] = NewInt(AppLockInvalid)
+
+	lock, err := appLockArgs(proc, args)
+	if err != nil {
+		return err
+	}
+	locker, err := i.appLocker(proc)
+	if err != nil {
+		return err
+	}
+	if !locker.ReleaseAppLock(lock) {
+		return NewSQLError(ErrAppLockNotHeld, fmt.Sprintf(
+			"Cannot release the application lock (Database Principal: '%s', Resource: '%s') because it is not currently held.",
+			lock.Principal, lock.Resource))
+	}
+	args[returnValueArg] = NewInt(AppLockGranted)
+	return nil
+}
+
+// appLockArgs reads the @Resource, @LockOwner and @DbPrincipal arguments
+// that both procedures take.
+func appLockArgs(proc string, args map[string]Value) (AppLock, error) {
+	lock := AppLock{Resource: argString(args, "@resource"), Principal: argString(args, "@dbprincipal")}
+	if lock.Resource == "" || len([]rune(lock.Resource)) > 255 {
+		return lock, invalidAppLockParameter(proc)
+	}
+	if lock.Principal == "" {
+		lock.Principal = "public"
+	}
+	switch owner := argString(args, "@lockowner"); {
+	case owner == "" || strings.EqualFold(owner, "Transaction"):
+	case strings.EqualFold(owner, "Session"):
+		lock.Session = true
+	default:
+		return lock, invalidAppLockParameter(proc)
+	}
+	return lock, nil
+}
+
+// appLocker returns the execution's application locker.
+func (i *Interpreter) appLocker(proc string) (AppLocker, error) {
+	locker, ok := i.ctx.Locks.(AppLocker)
+	if !ok {
+		return nil, NewSQLError(ErrNotSupported, fmt.Sprintf(
+			"%s needs the server's lock manager, which this execution does not have.", proc))
+	}
+	return locker, nil
+}
+
+func invalidAppLockParameter(proc string) error {
+	return NewSQLError(ErrInvalidParameter, fmt.Sprintf(
+		"An invalid parameter or option was specified for procedure '%s'.", proc))
+}
@@ -0,0 +1,102 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeAppLocker adds application locks to fakeLocker, granting each with
+// rc and keeping those held.
+type fakeAppLocker struct {
+	fakeLocker
+	rc       int
+	timeouts []time.Duration
+	apps     []AppLock
+}
+
+func (l *fakeAppLocker) GetAppLock(ctx context.Context, lock AppLock, timeout time.Duration) (int, error) {
+	l.timeouts = append(l.timeouts, timeout)
+	if l.rc >= 0 {
+		l.apps = append(l.apps, lock)
+	}
+	return l.rc, nil
+}
+
+func (l *fakeAppLocker) ReleaseAppLock(lock AppLock) bool {
+	for i, held := range l.apps {
+		if held.Resource == lock.Resource && held.Principal == lock.Principal && held.Session == lock.Session {
+			l.apps = append(l.apps[:i], l.apps[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestAppLock(t *testing.T) {
+	ctx := context.Background()
+	locker := &fakeAppLocker{}
+	interp := scopeSetup(t, newMockResolver())
+	interp.SetLocker(locker)
+
+	result, err := interp.Execute(ctx, `
+		DECLARE @rc int
+		BEGIN TRAN
+		EXEC @rc = sp_getapplock @Resource = 'nightly', @LockMode = 'exclusive', @LockTimeout = 250
+		SELECT @rc
+		COMMIT`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"0"}) {
+		t.Errorf("return code = %q, want [0]", got)
+	}
+	want := AppLock{Resource: "nightly", Principal: "public", Mode: "Exclusive"}
+	if len(locker.apps) != 1 || locker.apps[0] != want || locker.timeouts[0] != 250*time.Millisecond {
+		t.Errorf("locks = %+v, timeouts = %v", locker.apps, locker.timeouts)
+	}
+
+	locker.rc = AppLockTimeout
+	result, err = interp.Execute(ctx, `
+		DECLARE @rc int
+		EXEC @rc = sp_getapplock 'nightly', 'Shared', 'Session'
+		SELECT @rc`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"-1"}) || locker.timeouts[1] != -1 {
+		t.Errorf("return code = %q, timeout %v, want [-1] without limit", got, locker.timeouts[1])
+	}
+
+	locker.rc = AppLockGranted
+	result, err = interp.Execute(ctx, `
+		DECLARE @rc int
+		EXEC sp_getapplock @Resource = 'import', @LockMode = 'Update', @LockOwner = 'Session', @DbPrincipal = 'etl'
+		EXEC @rc = sp_releaseapplock @Resource = 'import', @LockOwner = 'Session', @DbPrincipal = 'etl'
+		SELECT @rc`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"0"}) || len(locker.apps) != 1 {
+		t.Errorf("return code = %q, held %+v", got, locker.apps)
+	}
+
+	for sql, number := range map[string]int{
+		"EXEC sp_releaseapplock 'import', 'Session'":                  ErrAppLockNotHeld,
+		"EXEC sp_getapplock 'job', 'Exclusive'":                       ErrInvalidParameter,
+		"EXEC sp_getapplock 'job', 'Intent', 'Session'":               ErrInvalidParameter,
+		"EXEC sp_getapplock 'job', 'Shared', 'Connection'":            ErrInvalidParameter,
+		"EXEC sp_getapplock 'job', 'Shared', 'Session', -5":           ErrInvalidParameter,
+		"EXEC sp_getapplock @Resource = '', @LockMode = 'Shared'":     ErrInvalidParameter,
+		"EXEC sp_releaseapplock @Resource = 'job', @LockOwner = 'Tx'": ErrInvalidParameter,
+	} {
+		_, err := interp.Execute(ctx, sql, nil)
+		wantSQLError(t, sql, err, number)
+	}
+
+	interp.SetLocker(&locker.fakeLocker)
+	sql := "EXEC sp_getapplock 'job', 'Shared', 'Session'"
+	_, err = interp.Execute(ctx, sql, nil)
+	wantSQLError(t, sql, err, ErrNotSupported)
+}
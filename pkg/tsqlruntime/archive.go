@@ -0,0 +1,308 @@
+package tsqlruntime
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// Archival tables keep only their recent rows; older partitions are moved
+// to object storage (see pkg/archive). A SELECT whose WHERE clause bounds
+// the date column such a table is partitioned by, so that its range
+// reaches archived partitions, reads the table UNION ALL the archived
+// rows, which are restored first into a cache database attached beside
+// the primary one. The table's own rows are all a query reads without
+// such a bound, and archived rows are read-only: UPDATE and DELETE change
+// only the table's.
+//
+// The bounds are the comparisons of the column with values known before
+// the query runs (literals, variables, GETDATE() and the like) among the
+// conditions the WHERE clause ANDs together: =, <, <=, >, >= and BETWEEN.
+// The tables read are those of the FROM clause, its joins and derived
+// tables, and those of the queries UNION combines.
+
+// Archive holds the archived partitions of archival tables.
+type Archive interface {
+	// Column returns the date column table is partitioned by, if it is
+	// archival
+	Column(table string) (string, bool)
+	// Restore makes the archived rows of table whose column falls between
+	// from and to ("" for no bound) readable, through db, the session's
+	// transaction if it has one. It returns the table they are read from,
+	// or "" if none are archived in that range.
+	Restore(ctx context.Context, db QueryExecutor, table, from, to string) (string, error)
+}
+
+// SetArchive sets the archive of the archival tables queries read.
+func (i *Interpreter) SetArchive(a Archive) {
+	i.ctx.Archive = a
+}
+
+// rewriteArchive returns s with the archival tables it reads over the
+// range of archived partitions replaced by derived tables reading their
+// archived rows too. As with rewriteFullText, the nodes on the way are
+// copied, so that the procedure's AST is left as it was.
+func (i *Interpreter) rewriteArchive(s *ast.SelectStatement) (out *ast.SelectStatement, err error) {
+	if i.ctx.Archive == nil || i.ctx.Dialect != DialectSQLite {
+		return s, nil
+	}
+	if i.plan != nil {
+		defer func() {
+			if err == nil && out != s {
+				i.rewriteStep("archive", out.String())
+			}
+		}()
+	}
+	return i.archiveQuery(s)
+}
+
+// archiveQuery rewrites the tables of s and its UNION branches.
+func (i *Interpreter) archiveQuery(s *ast.SelectStatement) (*ast.SelectStatement, error) {
+	if s == nil {
+		return s, nil
+	}
+	c := *s
+	if s.From != nil {
+		var tables []ast.TableReference
+		for j, ref := range s.From.Tables {
+			t, err := i.archiveRef(ref, s.Where)
+			if err != nil {
+				return nil, err
+			}
+			if t != ref {
+				if tables == nil {
+					tables = append([]ast.TableReference(nil), s.From.Tables...)
+				}
+				tables[j] = t
+			}
+		}
+		if tables != nil {
+			from := *s.From
+			from.Tables = tables
+			c.From = &from
+		}
+	}
+	if s.Union != nil {
+		right, err := i.archiveQuery(s.Union.Right)
+		if err != nil {
+			return nil, err
+		}
+		if right != s.Union.Right {
+			u := *s.Union
+			u.Right = right
+			c.Union = &u
+		}
+	}
+	if c.From == s.From && c.Union == s.Union {
+		return s, nil
+	}
+	return &c, nil
+}
+
+// archiveRef rewrites the archival tables ref reads, bounded by where.
+func (i *Interpreter) archiveRef(ref ast.TableReference, where ast.Expression) (ast.TableReference, error) {
+	switch t := ref.(type) {
+	case *ast.TableName:
+		return i.archiveTable(t, where)
+	case *ast.JoinClause:
+		left, err := i.archiveRef(t.Left, where)
+		if err != nil {
+			return nil, err
+		}
+		right, err := i.archiveRef(t.Right, where)
+		if err != nil {
+			return nil, err
+		}
+		if left != t.Left || right != t.Right {
+			c := *t
+			c.Left, c.Right = left, right
+			return &c, nil
+		}
+	case *ast.ParenthesizedTableRef:
+		inner, err := i.archiveRef(t.Inner, where)
+		if err != nil {
+			return nil, err
+		}
+		if inner != t.Inner {
+			c := *t
+			c.Inner = inner
+			return &c, nil
+		}
+	case *ast.DerivedTable:
+		sub, err := i.archiveQuery(t.Subquery)
+		if err != nil {
+			return nil, err
+		}
+		if sub != t.Subquery {
+			c := *t
+			c.Subquery = sub
+			return &c, nil
+		}
+	}
+	return ref, nil
+}
+
+// archiveTable returns t, or if it is archival and where bounds its column
+// over archived partitions, a derived table of the same name reading its
+// rows and those archived.
+func (i *Interpreter) archiveTable(t *ast.TableName, where ast.Expression) (ast.TableReference, error) {
+	if t.Name == nil || len(t.Name.Parts) == 0 || t.TemporalClause != nil || t.TableSample != nil {
+		return t, nil
+	}
+	column, ok := i.ctx.Archive.Column(t.Name.String())
+	if !ok {
+		return t, nil
+	}
+	alias := t.Alias
+	if alias == nil {
+		alias = &ast.Identifier{Value: t.Name.Parts[len(t.Name.Parts)-1].Value}
+	}
+	from, to, bounded := i.archiveBounds(where, alias.Value, column)
+	if !bounded {
+		return t, nil
+	}
+	archived, err := i.ctx.Archive.Restore(context.Background(), i.ctx.GetExecutor(), t.Name.String(), from, to)
+	if err != nil || archived == "" {
+		return t, err
+	}
+
+	hot := *t
+	hot.Alias = nil
+	all := []ast.SelectColumn{{AllColumns: true}}
+	return &ast.DerivedTable{
+		Subquery: &ast.SelectStatement{
+			Columns: all,
+			From:    &ast.FromClause{Tables: []ast.TableReference{&hot}},
+			Union: &ast.UnionClause{Type: "UNION", All: true, Right: &ast.SelectStatement{
+				Columns: all,
+				From: &ast.FromClause{Tables: []ast.TableReference{&ast.TableName{
+					Name: &ast.QualifiedIdentifier{Parts: []*ast.Identifier{{Value: archived}}},
+				}}},
+			}},
+		},
+		Alias: alias,
+	}, nil
+}
+
+// archiveBounds returns the range the conditions where ANDs together
+// bound column of the table named name to, and whether there are any.
+func (i *Interpreter) archiveBounds(where ast.Expression, name, column string) (from, to string, bounded bool) {
+	lower := func(v string) {
+		if from == "" || v > from {
+			from = v
+		}
+		bounded = true
+	}
+	upper := func(v string) {
+		if to == "" || v < to {
+			to = v
+		}
+		bounded = true
+	}
+	var conjuncts func(e ast.Expression)
+	conjuncts = func(e ast.Expression) {
+		switch e := e.(type) {
+		case *ast.InfixExpression:
+			op := strings.ToUpper(e.Operator)
+			if op == "AND" {
+				conjuncts(e.Left)
+				conjuncts(e.Right)
+				return
+			}
+			value, left := e.Right, true
+			if !archiveColumn(e.Left, name, column) {
+				if !archiveColumn(e.Right, name, column) {
+					return
+				}
+				value, left = e.Left, false
+			}
+			v, ok := i.archiveValue(value)
+			if !ok {
+				return
+			}
+			switch op {
+			case "=":
+				lower(v)
+				upper(v)
+			case ">", ">=":
+				if left {
+					lower(v)
+				} else {
+					upper(v)
+				}
+			case "<", "<=":
+				if left {
+					upper(v)
+				} else {
+					lower(v)
+				}
+			}
+		case *ast.BetweenExpression:
+			if e.Not || !archiveColumn(e.Expr, name, column) {
+				return
+			}
+			if v, ok := i.archiveValue(e.Low); ok {
+				lower(v)
+			}
+			if v, ok := i.archiveValue(e.High); ok {
+				upper(v)
+			}
+		}
+	}
+	conjuncts(where)
+	return from, to, bounded
+}
+
+// archiveColumn reports whether e is column of the table named name.
+func archiveColumn(e ast.Expression, name, column string) bool {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		return strings.EqualFold(e.Value, column)
+	case *ast.QualifiedIdentifier:
+		n := len(e.Parts)
+		return n >= 2 && strings.EqualFold(e.Parts[n-1].Value, column) && strings.EqualFold(e.Parts[n-2].Value, name)
+	}
+	return false
+}
+
+// archiveValue evaluates e, if it is known before the query runs, as the
+// text a date column compares with.
+func (i *Interpreter) archiveValue(e ast.Expression) (string, bool) {
+	if !archiveConstant(e) {
+		return "", false
+	}
+	v, err := i.evaluator.Evaluate(e)
+	if err != nil || v.IsNull {
+		return "", false
+	}
+	return v.AsString(), true
+}
+
+// archiveConstant reports whether e reads no columns.
+func archiveConstant(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.StringLiteral, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.Variable:
+		return true
+	case *ast.PrefixExpression:
+		return archiveConstant(e.Right)
+	case *ast.InfixExpression:
+		return archiveConstant(e.Left) && archiveConstant(e.Right)
+	case *ast.CastExpression:
+		return archiveConstant(e.Expression)
+	case *ast.ConvertExpression:
+		return archiveConstant(e.Expression)
+	case *ast.FunctionCall:
+		if e.Over != nil {
+			return false
+		}
+		for _, arg := range e.Arguments {
+			// Date parts, as in DATEADD(month, -3, GETDATE())
+			if _, ok := arg.(*ast.Identifier); !ok && !archiveConstant(arg) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeArchive archives the OrderDate partitions of Orders before March
+// 2024 in the table ArchivedOrders, recording the ranges restored.
+type fakeArchive struct {
+	restored []string
+}
+
+func (a *fakeArchive) Column(table string) (string, bool) {
+	if strings.EqualFold(strings.TrimPrefix(table, "dbo."), "Orders") {
+		return "OrderDate", true
+	}
+	return "", false
+}
+
+func (a *fakeArchive) Restore(ctx context.Context, db QueryExecutor, table, from, to string) (string, error) {
+	a.restored = append(a.restored, table+" "+from+".."+to)
+	if from != "" && from >= "2024-03" {
+		return "", nil
+	}
+	return "ArchivedOrders", nil
+}
+
+func archiveDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range []string{
+		"CREATE TABLE Orders (ID INTEGER, OrderDate TEXT, Customer TEXT)",
+		"INSERT INTO Orders VALUES (3, '2024-03-05', 'C'), (4, '2024-04-10', 'A')",
+		"CREATE TABLE ArchivedOrders (ID INTEGER, OrderDate TEXT, Customer TEXT)",
+		"INSERT INTO ArchivedOrders VALUES (1, '2024-01-20', 'A'), (2, '2024-02-14', 'B')",
+		"CREATE TABLE Customers (Code TEXT, Name TEXT)",
+		"INSERT INTO Customers VALUES ('A', 'Acme'), ('B', 'Bolt'), ('C', 'Crux')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return db
+}
+
+func TestArchive_Queries(t *testing.T) {
+	db := archiveDB(t)
+
+	tests := []struct {
+		name     string
+		query    string
+		want     []string
+		restored []string
+	}{
+		{"unbounded",
+			"SELECT ID FROM Orders ORDER BY ID",
+			[]string{"3", "4"}, nil},
+		{"lower bound",
+			"SELECT ID FROM dbo.Orders WHERE OrderDate >= '2024-02-01' ORDER BY ID",
+			[]string{"2", "3", "4"}, []string{"dbo.Orders 2024-02-01.."}},
+		{"recent only",
+			"SELECT ID FROM Orders WHERE OrderDate > '2024-04-01'",
+			[]string{"4"}, []string{"Orders 2024-04-01.."}},
+		{"between and alias",
+			"SELECT o.ID, c.Name FROM Orders o JOIN Customers c ON c.Code = o.Customer WHERE o.OrderDate BETWEEN '2024-01-01' AND '2024-03-31' AND c.Code <> 'B' ORDER BY o.ID",
+			[]string{"1 Acme", "3 Crux"}, []string{"Orders 2024-01-01..2024-03-31"}},
+		{"tightest bounds, column on the right",
+			"SELECT ID FROM Orders WHERE '2024-03-01' > OrderDate AND OrderDate < '2024-06-01' AND OrderDate >= '2023-01-01' AND OrderDate > '2024-01-31'",
+			[]string{"2"}, []string{"Orders 2024-01-31..2024-03-01"}},
+		{"variable",
+			"DECLARE @d NVARCHAR(10) = '2024-02-14'; SELECT Customer FROM Orders WHERE OrderDate = @d",
+			[]string{"B"}, []string{"Orders 2024-02-14..2024-02-14"}},
+		{"OR is no bound",
+			"SELECT ID FROM Orders WHERE OrderDate < '2024-02-01' OR ID = 4",
+			[]string{"4"}, nil},
+		{"bounded by another column",
+			"SELECT ID FROM Orders WHERE ID < 4 ORDER BY ID",
+			[]string{"3"}, nil},
+		{"derived table and union",
+			"SELECT n FROM (SELECT COUNT(*) AS n FROM Orders WHERE OrderDate < '2024-03-01') d UNION ALL SELECT COUNT(*) FROM Orders WHERE OrderDate <= '2024-02-01'",
+			[]string{"2", "1"}, []string{"Orders ..2024-03-01", "Orders ..2024-02-01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := &fakeArchive{}
+			interp := NewInterpreter(db, DialectSQLite)
+			interp.SetArchive(archive)
+			result, err := interp.Execute(context.Background(), tt.query, nil)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.query, err)
+			}
+			var rows []string
+			for _, row := range result.ResultSets[len(result.ResultSets)-1].Rows {
+				parts := make([]string, len(row))
+				for i, v := range row {
+					parts[i] = valueString(v)
+				}
+				rows = append(rows, strings.Join(parts, " "))
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("rows = %q, want %q", rows, tt.want)
+			}
+			if !reflect.DeepEqual(archive.restored, tt.restored) {
+				t.Errorf("restored = %q, want %q", archive.restored, tt.restored)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package tsqlruntime
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// Auto-parameterization, like SQL Server's simple parameterization, sends
+// the backend the literals of a query as parameters, so that queries
+// differing only in their values send it the same text, and its plan cache
+// and statement statistics see one query rather than one per value. Only
+// the literals a predicate compares with (=, <>, <, >, IN, BETWEEN, LIKE),
+// and those an INSERT's VALUES or an UPDATE's SET give a column, are
+// parameterized. Literals in the select list, in function arguments, in
+// TOP, GROUP BY and ORDER BY stay in the text, where they can name a
+// result's columns, set its types or mean a column's position; so do
+// numbers with a decimal point, since a bound float is not an exact
+// decimal.
+
+// autoParamPrefix begins the names of the variables literals are replaced
+// by while a query's text is generated.
+const autoParamPrefix = "aul__p"
+
+// literalParams replaces the literals of a statement by variables, and
+// puts them back.
+type literalParams struct {
+	values   map[string]interface{} // By variable name, without @
+	restores []func()
+}
+
+// parameterizeLiterals replaces the parameterizable literals of stmt by
+// variables whose values substituteVariables binds as parameters,
+// returning the func that puts the literals back once the query's text is
+// generated. It does nothing unless AutoParameterize is set.
+func (i *Interpreter) parameterizeLiterals(stmt ast.Statement) (restore func()) {
+	i.autoParams = nil
+	if !i.AutoParameterize {
+		return func() {}
+	}
+	p := &literalParams{values: make(map[string]interface{})}
+	switch s := stmt.(type) {
+	case *ast.SelectStatement:
+		p.selectStatement(s)
+	case *ast.InsertStatement:
+		for _, row := range s.Values {
+			for j := range row {
+				p.value(&row[j])
+			}
+		}
+		p.selectStatement(s.Select)
+	case *ast.UpdateStatement:
+		for _, set := range s.SetClauses {
+			if !set.IsMethodCall && set.Operator == "=" {
+				p.value(&set.Value)
+			}
+		}
+		p.from(s.From)
+		p.predicate(&s.Where)
+	case *ast.DeleteStatement:
+		p.from(s.From)
+		p.predicate(&s.Where)
+	}
+	i.autoParams = p.values
+	return func() {
+		for _, restore := range p.restores {
+			restore()
+		}
+	}
+}
+
+func (p *literalParams) selectStatement(s *ast.SelectStatement) {
+	if s == nil {
+		return
+	}
+	p.from(s.From)
+	p.predicate(&s.Where)
+	p.predicate(&s.Having)
+	if s.Union != nil {
+		p.selectStatement(s.Union.Right)
+	}
+}
+
+func (p *literalParams) from(f *ast.FromClause) {
+	if f == nil {
+		return
+	}
+	for _, ref := range f.Tables {
+		p.tableRef(ref)
+	}
+}
+
+func (p *literalParams) tableRef(ref ast.TableReference) {
+	switch r := ref.(type) {
+	case *ast.JoinClause:
+		p.tableRef(r.Left)
+		p.tableRef(r.Right)
+		p.predicate(&r.Condition)
+	case *ast.ParenthesizedTableRef:
+		p.tableRef(r.Inner)
+	case *ast.DerivedTable:
+		p.selectStatement(r.Subquery)
+	}
+}
+
+// predicate parameterizes the literals expr compares with.
+func (p *literalParams) predicate(expr *ast.Expression) {
+	switch e := (*expr).(type) {
+	case *ast.InfixExpression:
+		switch strings.ToUpper(e.Operator) {
+		case "AND", "OR":
+			p.predicate(&e.Left)
+			p.predicate(&e.Right)
+		case "=", "<>", "!=", "<", ">", "<=", ">=", "!<", "!>":
+			if isLiteral(e.Left) && isLiteral(e.Right) {
+				return // 1 = 1: a backend cannot type two parameters
+			}
+			p.operand(&e.Left)
+			p.operand(&e.Right)
+		}
+	case *ast.PrefixExpression:
+		if strings.EqualFold(e.Operator, "NOT") {
+			p.predicate(&e.Right)
+		}
+	case *ast.InExpression:
+		if isLiteral(e.Expr) {
+			return
+		}
+		for j := range e.Values {
+			p.value(&e.Values[j])
+		}
+		p.selectStatement(e.Subquery)
+	case *ast.BetweenExpression:
+		if isLiteral(e.Expr) {
+			return
+		}
+		p.value(&e.Low)
+		p.value(&e.High)
+	case *ast.LikeExpression:
+		if isLiteral(e.Expr) {
+			return
+		}
+		p.value(&e.Pattern)
+	case *ast.ExistsExpression:
+		p.selectStatement(e.Subquery)
+	}
+}
+
+// operand parameterizes a compared literal, or the predicates of a
+// compared subquery.
+func (p *literalParams) operand(expr *ast.Expression) {
+	if sub, ok := (*expr).(*ast.SubqueryExpression); ok {
+		p.selectStatement(sub.Subquery)
+		return
+	}
+	p.value(expr)
+}
+
+// value replaces a string or integer literal with a variable.
+func (p *literalParams) value(expr *ast.Expression) {
+	var v interface{}
+	switch lit := (*expr).(type) {
+	case *ast.StringLiteral:
+		v = lit.Value
+	case *ast.IntegerLiteral:
+		v = lit.Value
+	default:
+		return
+	}
+	name := autoParamPrefix + strconv.Itoa(len(p.values)+1)
+	p.values[name] = v
+	orig := *expr
+	*expr = &ast.Variable{Name: "@" + name}
+	p.restores = append(p.restores, func() { *expr = orig })
+}
+
+func isLiteral(expr ast.Expression) bool {
+	switch expr.(type) {
+	case *ast.StringLiteral, *ast.IntegerLiteral, *ast.FloatLiteral:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAutoParameterize(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE people (id INTEGER, name TEXT, city TEXT); " +
+		"INSERT INTO people VALUES (1, 'Ada', 'London'), (2, 'Grace', 'New York'), (3, 'Alan', 'London')"); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.AutoParameterize = true
+	interp.LogRewritten = true
+	interp.LogFunc = func(format string, args ...interface{}) {
+		sent = append(sent, fmt.Sprintf(format, args...))
+	}
+
+	tests := []struct {
+		query string
+		sent  string // The SQL sent, up to its args
+		want  []string
+	}{
+		{"SELECT name FROM people WHERE city = 'London' AND id > 1",
+			"SELECT name FROM people WHERE ((city = ?) AND (id > ?)) args=[London 1]", []string{"Alan"}},
+		{"SELECT name FROM people WHERE city = N'London' AND id > 2",
+			"SELECT name FROM people WHERE ((city = ?) AND (id > ?)) args=[London 2]", []string{"Alan"}},
+		{"SELECT TOP 1 name, 'x' AS tag FROM people WHERE id IN (2, 3) AND name LIKE 'G%' ORDER BY 1",
+			"SELECT name, 'x' AS tag FROM people WHERE (id IN (?, ?) AND name LIKE ?) ORDER BY 1 ASC LIMIT 1 args=[2 3 G%]", []string{"Grace x"}},
+		{"SELECT name FROM people WHERE 1 = 1 AND id BETWEEN 1 AND 1.5",
+			"SELECT name FROM people WHERE ((1 = 1) AND id BETWEEN ? AND 1.5) args=[1]", []string{"Ada"}},
+		{"SELECT COUNT(*) FROM people WHERE id = (SELECT MAX(id) FROM people WHERE city = 'London')",
+			"SELECT COUNT(*) FROM people WHERE (id = (SELECT MAX(id) FROM people WHERE (city = ?))) args=[London]", []string{"1"}},
+		{"UPDATE people SET city = 'Paris' WHERE name = 'Ada'",
+			"UPDATE people SET city = ? WHERE (name = ?) args=[Paris Ada]", nil},
+		{"DELETE FROM people WHERE city = 'Paris'",
+			"DELETE FROM people WHERE (city = ?) args=[Paris]", nil},
+	}
+	for _, tc := range tests {
+		sent = nil
+		result, err := interp.Execute(context.Background(), tc.query, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		if len(sent) != 1 || !strings.HasSuffix(sent[0], "query="+tc.sent) {
+			t.Errorf("%s: sent %q, want %q", tc.query, sent, tc.sent)
+		}
+		if tc.want == nil {
+			continue
+		}
+		if got := lastRows(result); strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%s: got %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package tsqlruntime
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// valueBytes returns the bytes SQL Server would hash or encrypt for v:
+// binary values as they are, nvarchar as UTF-16LE, varchar in code page
+// 1252 and numbers big-endian at the width of their type.
+func valueBytes(v Value) []byte {
+	switch v.Type {
+	case TypeBinary, TypeVarBinary:
+		return v.bytesVal
+	case TypeNVarChar, TypeNChar, TypeNText:
+		return utf16LE(v.stringVal)
+	case TypeVarChar, TypeChar, TypeText:
+		return codePageBytes(v.stringVal)
+	case TypeBit, TypeTinyInt:
+		return []byte{byte(v.AsInt())}
+	case TypeSmallInt:
+		return binary.BigEndian.AppendUint16(nil, uint16(v.intVal))
+	case TypeInt:
+		return binary.BigEndian.AppendUint32(nil, uint32(v.intVal))
+	case TypeBigInt:
+		return binary.BigEndian.AppendUint64(nil, uint64(v.intVal))
+	}
+	return codePageBytes(v.AsString())
+}
+
+// utf16LE encodes s as nvarchar data is stored.
+func utf16LE(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	return b
+}
+
+// fromUTF16LE decodes nvarchar data; an odd last byte is dropped.
+func fromUTF16LE(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// codePageBytes encodes s as varchar data in code page 1252, the code
+// page of the default collation; characters it lacks become '?'.
+func codePageBytes(s string) []byte {
+	if isASCIIString(s) {
+		return []byte(s)
+	}
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if c, ok := charmap.Windows1252.EncodeRune(r); ok {
+			b = append(b, c)
+		} else {
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+// fromCodePage decodes varchar data in code page 1252.
+func fromCodePage(b []byte) string {
+	if isASCIIString(string(b)) {
+		return string(b)
+	}
+	var s strings.Builder
+	for _, c := range b {
+		s.WriteRune(charmap.Windows1252.DecodeByte(c))
+	}
+	return s.String()
+}
+
+func isASCIIString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBinaryLiteral evaluates 0x... An odd number of digits has a zero
+// put in front, as SQL Server does.
+func parseBinaryLiteral(literal string) (Value, error) {
+	digits := literal[min(2, len(literal)):]
+	if len(digits)%2 == 1 {
+		digits = "0" + digits
+	}
+	b, err := hex.DecodeString(digits)
+	if err != nil {
+		return Value{}, fmt.Errorf("invalid binary literal %s", literal)
+	}
+	return NewVarBinary(b, len(b)), nil
+}
+
+// binaryToString converts binary data to character data: with style 0
+// the bytes are the characters, nvarchar in UTF-16LE and varchar in code
+// page 1252; style 1 writes them as hexadecimal digits after 0x, style 2
+// without it.
+func binaryToString(b []byte, national bool, style int) (string, error) {
+	switch style {
+	case 0:
+		if national {
+			return fromUTF16LE(b), nil
+		}
+		return fromCodePage(b), nil
+	case 1:
+		return "0x" + strings.ToUpper(hex.EncodeToString(b)), nil
+	case 2:
+		return strings.ToUpper(hex.EncodeToString(b)), nil
+	}
+	return "", fmt.Errorf("%d is not a valid style number when converting from binary", style)
+}
+
+// stringToBinary converts character data to binary: with style 0 its
+// bytes, as binaryToString reads them; with style 1 or 2 hexadecimal
+// digits, after 0x for style 1.
+func stringToBinary(v Value, style int) ([]byte, error) {
+	switch style {
+	case 0:
+		return valueBytes(v), nil
+	case 1, 2:
+		digits := v.stringVal
+		if style == 1 {
+			if !strings.HasPrefix(digits, "0x") && !strings.HasPrefix(digits, "0X") {
+				return nil, fmt.Errorf("error converting data type %s to binary: %q does not start with 0x", v.Type, digits)
+			}
+			digits = digits[2:]
+		}
+		b, err := hex.DecodeString(digits)
+		if err != nil {
+			return nil, fmt.Errorf("error converting data type %s to binary: %q is not hexadecimal", v.Type, v.stringVal)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("%d is not a valid style number when converting to binary", style)
+}
@@ -0,0 +1,580 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// A SELECT is served from the pinned tables' copies in memory when every
+// table it reads is pinned and cached, they are joined by INNER, LEFT or
+// CROSS joins, and it uses only the expressions the evaluator supports: no
+// subqueries, windowed functions, UNION, OFFSET or FOR XML/JSON. Any other
+// SELECT, or one whose evaluation in memory fails, is sent to the backend
+// as before. Each table after the first is joined by looking up the rows
+// matching an equality of its ON condition in the copy's index of that
+// column, and the first table's rows are narrowed the same way by an
+// equality of WHERE with a value known before the query runs.
+
+// SetTableCache sets the cache of pinned tables queries may be served from.
+func (i *Interpreter) SetTableCache(cache *TableCache) {
+	i.ctx.TableCache = cache
+	if i.ctx.cacheWrites == nil {
+		i.ctx.cacheWrites = make(map[string]bool)
+	}
+}
+
+// cacheWritten records that stmt has written the pinned tables among its
+// targets, discarding their copies. Tables written in a transaction are
+// read from the backend by this session until it ends, and discarded
+// again then, since other sessions may have reloaded them meanwhile.
+func (i *Interpreter) cacheWritten(stmt ast.Statement) {
+	_, targets := statementWrite(stmt)
+	if alter, ok := stmt.(*ast.AlterTableStatement); ok && alter.Table != nil {
+		targets = append(targets, alter.Table.String())
+	}
+	for _, target := range targets {
+		i.cacheWrittenTable(pinnedKey(target))
+	}
+}
+
+// cacheWrittenTable records a write to the table key.
+func (i *Interpreter) cacheWrittenTable(key string) {
+	cache := i.ctx.TableCache
+	if !cache.Pinned(key) {
+		return
+	}
+	cache.Invalidate(key)
+	if i.ctx.Tx != nil {
+		i.ctx.cacheWrites[key] = true
+	}
+}
+
+// endCacheWrites discards the copies of the pinned tables written by the
+// transaction just ended.
+func (ec *ExecutionContext) endCacheWrites() {
+	for key := range ec.cacheWrites {
+		ec.TableCache.Invalidate(key)
+		delete(ec.cacheWrites, key)
+	}
+}
+
+// cacheSource is a pinned table read by a query served from memory.
+type cacheSource struct {
+	ref    *ast.TableName
+	key    string
+	name   string         // Alias, or the table's name, in lower case
+	join   string         // INNER, LEFT or CROSS, joining it to the tables before
+	on     ast.Expression // Join condition (nil = CROSS)
+	snap   *tableSnapshot
+	offset int // Of its columns in a joined row
+}
+
+// cacheQuery is a SELECT being evaluated over pinned tables.
+type cacheQuery struct {
+	sources []*cacheSource
+	columns []string   // Of a joined row, which * expands to
+	types   []DataType // Of each column, for NULLs
+
+	// Column references of the query, to their index in a joined row
+	refs map[ast.Expression]int
+
+	// The joined row being evaluated (nil = NULLs)
+	row []Value
+}
+
+// selectFromTableCache serves s from the pinned tables in memory if it
+// can, reporting whether it did. varNames are the variables assigned by a
+// SELECT @var = expr, which take their values from the last row.
+func (i *Interpreter) selectFromTableCache(ctx context.Context, s *ast.SelectStatement, varNames []string, result *ExecutionResult) (bool, error) {
+	cache := i.ctx.TableCache
+	if cache == nil || i.ctx.DB == nil {
+		return false, nil
+	}
+	q := i.planCacheQuery(s)
+	if q == nil {
+		return false, nil
+	}
+	for _, src := range q.sources {
+		snap, err := cache.snapshot(ctx, src.key, i.ctx.DB, i.pinnedLoader(src.ref))
+		if err != nil {
+			// The backend reports a missing table, and may stop a
+			// cancelled execution itself
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return true, ctxErr
+			}
+			return false, nil
+		}
+		src.snap = snap
+	}
+	if !q.resolve(s) {
+		return false, nil
+	}
+
+	i.evaluator.columns = q.value
+	defer func() { i.evaluator.columns = nil }()
+	rs, err := i.queryCache(q, s)
+	if err != nil {
+		return false, nil
+	}
+	for _, src := range q.sources {
+		cache.hit(src.key)
+	}
+
+	if varNames != nil {
+		if len(rs.Rows) > 0 {
+			row := rs.Rows[len(rs.Rows)-1]
+			for j, varName := range varNames {
+				if varName != "" && j < len(row) {
+					i.assign(varName, row[j])
+				}
+			}
+		}
+		i.ctx.UpdateRowCount(int64(len(rs.Rows)))
+		return true, nil
+	}
+
+	for _, row := range rs.Rows {
+		if err := i.ctx.reserveRow(row); err != nil {
+			return true, err
+		}
+	}
+	rs.Sensitivity = i.sensitivityOf(ctx, s).columns(rs.Columns)
+	result.ResultSets = append(result.ResultSets, rs)
+	i.ctx.UpdateRowCount(int64(len(rs.Rows)))
+	i.ctx.AddResultSet(rs)
+	return true, nil
+}
+
+// planCacheQuery returns the tables s reads if it may be served from the
+// cache, or nil.
+func (i *Interpreter) planCacheQuery(s *ast.SelectStatement) *cacheQuery {
+	if s.From == nil || len(s.From.Tables) == 0 || s.Into != nil || s.Union != nil ||
+		s.Offset != nil || s.Fetch != nil || s.Limit != nil || s.ForClause != nil ||
+		len(s.WindowDefs) > 0 || s.Top != nil && (s.Top.Percent || s.Top.WithTies) {
+		return nil
+	}
+	q := &cacheQuery{}
+	for n, ref := range s.From.Tables {
+		join := "CROSS"
+		if n == 0 {
+			join = ""
+		}
+		if !q.addSources(ref, join, nil) {
+			return nil
+		}
+	}
+	for _, src := range q.sources {
+		if !i.ctx.TableCache.Pinned(src.key) || i.ctx.cacheWrites[src.key] {
+			return nil
+		}
+	}
+	return q
+}
+
+// addSources adds the tables of ref, joined to those before it by join.
+func (q *cacheQuery) addSources(ref ast.TableReference, join string, on ast.Expression) bool {
+	switch r := ref.(type) {
+	case *ast.TableName:
+		if r.Name == nil || r.TemporalClause != nil || r.TableSample != nil {
+			return false
+		}
+		name := r.Name.Parts[len(r.Name.Parts)-1].Value
+		if r.Alias != nil {
+			name = r.Alias.Value
+		}
+		if IsTempTable(name) || IsTableVariable(name) {
+			return false
+		}
+		q.sources = append(q.sources, &cacheSource{
+			ref:  r,
+			key:  qualifiedKey(r.Name),
+			name: strings.ToLower(name),
+			join: join,
+			on:   on,
+		})
+		return true
+	case *ast.JoinClause:
+		switch r.Type {
+		case "INNER", "LEFT":
+			if r.Condition == nil {
+				return false
+			}
+		case "CROSS":
+		default:
+			return false
+		}
+		// Only the left side of a join may itself be a join
+		if _, ok := r.Right.(*ast.TableName); !ok {
+			return false
+		}
+		return q.addSources(r.Left, join, on) && q.addSources(r.Right, r.Type, r.Condition)
+	}
+	return false
+}
+
+// pinnedLoader returns the loader reading the pinned table ref names from
+// the backend. Within a transaction, whose reads may predate writes other
+// sessions have since made, tables are not loaded.
+func (i *Interpreter) pinnedLoader(ref *ast.TableName) tableLoader {
+	return func(ctx context.Context, maxRows int) ([]string, [][]Value, error) {
+		if i.ctx.Tx != nil {
+			return nil, nil, errors.New("pinned tables are not loaded within a transaction")
+		}
+		sel := &ast.SelectStatement{
+			Columns: []ast.SelectColumn{{AllColumns: true}},
+			From:    &ast.FromClause{Tables: []ast.TableReference{&ast.TableName{Name: ref.Name}}},
+		}
+		query, args, err := i.buildSelectQuery(sel)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows, err := i.ctx.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		if err != nil {
+			return nil, nil, err
+		}
+		var out [][]Value
+		scanner := newRowScanner(len(columns))
+		for rows.Next() {
+			if len(out) == maxRows {
+				return nil, nil, errTableTooLarge
+			}
+			row, err := scanner.scan(rows)
+			if err != nil {
+				return nil, nil, err
+			}
+			out = append(out, row)
+		}
+		return columns, out, rows.Err()
+	}
+}
+
+// resolve lays out the joined row of the loaded tables and finds the
+// column each reference of s reads. It fails if a reference names no
+// column, or one of several, or s uses an expression the evaluator does
+// not support.
+func (q *cacheQuery) resolve(s *ast.SelectStatement) bool {
+	for _, src := range q.sources {
+		src.offset = len(q.columns)
+		q.columns = append(q.columns, src.snap.columns...)
+		q.types = append(q.types, src.snap.types...)
+	}
+	q.refs = make(map[ast.Expression]int)
+
+	exprs := []ast.Expression{s.Where, s.Having}
+	for _, col := range s.Columns {
+		exprs = append(exprs, col.Expression)
+	}
+	exprs = append(exprs, s.GroupBy...)
+	for _, src := range q.sources {
+		exprs = append(exprs, src.on)
+	}
+	if s.Top != nil {
+		exprs = append(exprs, s.Top.Count)
+	}
+	for _, expr := range exprs {
+		if !q.resolveExpr(expr, nil) {
+			return false
+		}
+	}
+
+	// ORDER BY may name a result column by its alias, which comes first;
+	// an ordinal is not supported
+	aliases := make(map[string]bool)
+	for _, col := range s.Columns {
+		if col.Alias != nil {
+			aliases[strings.ToLower(col.Alias.Value)] = true
+		}
+	}
+	for _, item := range s.OrderBy {
+		if _, ok := item.Expression.(*ast.IntegerLiteral); ok {
+			return false
+		}
+		if !q.resolveExpr(item.Expression, aliases) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveExpr resolves the column references within expr. Identifiers in
+// aliases name result columns instead.
+func (q *cacheQuery) resolveExpr(expr ast.Expression, aliases map[string]bool) bool {
+	switch e := expr.(type) {
+	case nil:
+		return true
+	case *ast.Identifier:
+		if aliases[strings.ToLower(e.Value)] {
+			return true
+		}
+		return q.resolveColumn(e, "", e.Value)
+	case *ast.QualifiedIdentifier:
+		if len(e.Parts) < 2 {
+			return false
+		}
+		return q.resolveColumn(e, e.Parts[len(e.Parts)-2].Value, e.Parts[len(e.Parts)-1].Value)
+	case *ast.FunctionCall:
+		if e.Over != nil || len(e.WithinGroup) > 0 {
+			return false
+		}
+		for _, arg := range e.Arguments {
+			// COUNT(*)
+			if ident, ok := arg.(*ast.Identifier); ok && ident.Value == "*" {
+				continue
+			}
+			if !q.resolveExpr(arg, aliases) {
+				return false
+			}
+		}
+		return true
+	}
+	children, ok := exprOperands(expr)
+	if !ok {
+		return false
+	}
+	for _, child := range children {
+		if !q.resolveExpr(child, aliases) {
+			return false
+		}
+	}
+	return true
+}
+
+// exprOperands returns the operands of expr, or false for an expression
+// not evaluated over pinned tables.
+func exprOperands(expr ast.Expression) ([]ast.Expression, bool) {
+	switch e := expr.(type) {
+	case *ast.Variable, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral,
+		*ast.NullLiteral, *ast.BinaryLiteral:
+		return nil, true
+	case *ast.PrefixExpression:
+		return []ast.Expression{e.Right}, true
+	case *ast.InfixExpression:
+		return []ast.Expression{e.Left, e.Right}, true
+	case *ast.CaseExpression:
+		operands := []ast.Expression{e.Operand, e.ElseClause}
+		for _, w := range e.WhenClauses {
+			operands = append(operands, w.Condition, w.Result)
+		}
+		return operands, true
+	case *ast.CastExpression:
+		return []ast.Expression{e.Expression}, true
+	case *ast.ConvertExpression:
+		return []ast.Expression{e.Expression, e.Style}, true
+	case *ast.BetweenExpression:
+		return []ast.Expression{e.Expr, e.Low, e.High}, true
+	case *ast.InExpression:
+		if e.Subquery != nil {
+			return nil, false
+		}
+		return append([]ast.Expression{e.Expr}, e.Values...), true
+	case *ast.LikeExpression:
+		return []ast.Expression{e.Expr, e.Pattern, e.Escape}, true
+	case *ast.IsNullExpression:
+		return []ast.Expression{e.Expr}, true
+	case *ast.TupleExpression:
+		return e.Elements, len(e.Elements) == 1
+	}
+	return nil, false
+}
+
+// resolveColumn records the column ref reads: the column name of the
+// table qualifier names, or of the only table having one.
+func (q *cacheQuery) resolveColumn(ref ast.Expression, qualifier, name string) bool {
+	qualifier = strings.ToLower(qualifier)
+	found := -1
+	for _, src := range q.sources {
+		if qualifier != "" && qualifier != src.name {
+			continue
+		}
+		if j := src.snap.column(name); j >= 0 {
+			if found >= 0 {
+				return false
+			}
+			found = src.offset + j
+		}
+	}
+	if found < 0 {
+		return false
+	}
+	q.refs[ref] = found
+	return true
+}
+
+// value answers the evaluator's column references.
+func (q *cacheQuery) value(ref ast.Expression) (Value, bool) {
+	idx, ok := q.refs[ref]
+	if !ok {
+		return Value{}, false
+	}
+	if q.row == nil {
+		return Null(q.types[idx]), true
+	}
+	return q.row[idx], true
+}
+
+// columnsIn returns the lowest and highest index of the joined row's
+// columns expr reads, with -1 for both if it reads none.
+func (q *cacheQuery) columnsIn(expr ast.Expression) (lo, hi int) {
+	lo, hi = -1, -1
+	var walk func(ast.Expression)
+	walk = func(expr ast.Expression) {
+		if idx, ok := q.refs[expr]; ok {
+			if lo < 0 || idx < lo {
+				lo = idx
+			}
+			hi = max(hi, idx)
+			return
+		}
+		if fc, ok := expr.(*ast.FunctionCall); ok {
+			for _, arg := range fc.Arguments {
+				walk(arg)
+			}
+			return
+		}
+		children, _ := exprOperands(expr)
+		for _, child := range children {
+			walk(child)
+		}
+	}
+	walk(expr)
+	return lo, hi
+}
+
+// equality finds a conjunct of cond equating a column of src with an
+// expression of the columns before it (or none), returning the column and
+// the expression.
+func (q *cacheQuery) equality(cond ast.Expression, src *cacheSource) (int, ast.Expression, bool) {
+	infix, ok := cond.(*ast.InfixExpression)
+	if !ok {
+		return 0, nil, false
+	}
+	switch strings.ToUpper(infix.Operator) {
+	case "AND":
+		if col, expr, ok := q.equality(infix.Left, src); ok {
+			return col, expr, true
+		}
+		return q.equality(infix.Right, src)
+	case "=":
+	default:
+		return 0, nil, false
+	}
+	end := src.offset + len(src.snap.columns)
+	for _, side := range [][2]ast.Expression{{infix.Left, infix.Right}, {infix.Right, infix.Left}} {
+		idx, ok := q.refs[side[0]]
+		if !ok || idx < src.offset || idx >= end {
+			continue
+		}
+		if _, hi := q.columnsIn(side[1]); hi < src.offset {
+			return idx - src.offset, side[1], true
+		}
+	}
+	return 0, nil, false
+}
+
+// candidates returns the rows of src that may match cond for the row
+// being evaluated: those found by an equality in the column's index, or
+// all of them.
+func (i *Interpreter) candidates(q *cacheQuery, src *cacheSource, cond ast.Expression) ([]int, error) {
+	if col, expr, ok := q.equality(cond, src); ok {
+		v, err := i.evaluator.Evaluate(expr)
+		if err != nil {
+			return nil, err
+		}
+		if rows, ok := src.snap.lookup(col, v); ok {
+			return rows, nil
+		}
+	}
+	rows := make([]int, src.snap.rows)
+	for r := range rows {
+		rows[r] = r
+	}
+	return rows, nil
+}
+
+// queryCache evaluates s over the pinned tables of q.
+func (i *Interpreter) queryCache(q *cacheQuery, s *ast.SelectStatement) (ResultSet, error) {
+	width := len(q.columns)
+
+	// The first table's rows, narrowed by an equality of WHERE
+	q.row = nil
+	first := q.sources[0]
+	matches, err := i.candidates(q, first, s.Where)
+	if err != nil {
+		return ResultSet{}, err
+	}
+	rows := make([][]Value, 0, len(matches))
+	for _, r := range matches {
+		row := make([]Value, width)
+		first.snap.copyRow(row, r)
+		rows = append(rows, row)
+	}
+
+	// Each further table's rows matching the ON condition
+	for _, src := range q.sources[1:] {
+		var joined [][]Value
+		for _, left := range rows {
+			q.row = left
+			matches, err := i.candidates(q, src, src.on)
+			if err != nil {
+				return ResultSet{}, err
+			}
+			matched := false
+			for _, r := range matches {
+				row := make([]Value, width)
+				copy(row, left[:src.offset])
+				src.snap.copyRow(row[src.offset:], r)
+				if src.on != nil {
+					q.row = row
+					keep, err := i.evaluator.Evaluate(src.on)
+					if err != nil {
+						return ResultSet{}, err
+					}
+					if !keep.IsTruthy() {
+						continue
+					}
+				}
+				matched = true
+				joined = append(joined, row)
+			}
+			if !matched && src.join == "LEFT" {
+				row := make([]Value, width)
+				copy(row, left[:src.offset])
+				for j := range src.snap.columns {
+					row[src.offset+j] = Null(src.snap.types[j])
+				}
+				joined = append(joined, row)
+			}
+		}
+		rows = joined
+	}
+
+	if s.Where != nil {
+		kept := rows[:0]
+		for _, row := range rows {
+			q.row = row
+			keep, err := i.evaluator.Evaluate(s.Where)
+			if err != nil {
+				return ResultSet{}, err
+			}
+			if keep.IsTruthy() {
+				kept = append(kept, row)
+			}
+		}
+		rows = kept
+	}
+
+	return i.queryMemoryRows(s, memoryRows{
+		columns: q.columns,
+		rows:    rows,
+		bind:    func(row []Value) { q.row = row },
+	})
+}
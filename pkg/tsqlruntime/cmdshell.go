@@ -0,0 +1,102 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+)
+
+// xp_cmdshell runs an operating system command, for legacy procedures
+// that shell out to move or copy files:
+//
+//	DECLARE @rc int;
+//	EXEC @rc = master..xp_cmdshell 'move D:\inbox\orders.csv D:\done\', no_output;
+//
+// aul has no shell: the command is handed to the CommandShell set with
+// SetCommandShell, which runs only what it allows. The command's output
+// comes back as a result set of one NVARCHAR(255) column, output, a row per
+// line and a NULL row last, unless NO_OUTPUT is given; the return code is
+// 0 if the command succeeded and 1 if not. Without a CommandShell
+// xp_cmdshell fails, as when SQL Server has it turned off.
+
+// CommandResult is what a command xp_cmdshell ran printed and returned.
+type CommandResult struct {
+	Output   []string // Lines of standard output and standard error
+	ExitCode int
+}
+
+// CommandShell runs the commands of xp_cmdshell.
+type CommandShell interface {
+	// RunCommand runs command. An error means it did not run.
+	RunCommand(ctx context.Context, command string) (CommandResult, error)
+}
+
+// SetCommandShell sets what runs xp_cmdshell's commands. Without one
+// xp_cmdshell fails.
+func (i *Interpreter) SetCommandShell(shell CommandShell) {
+	i.ctx.Shell = shell
+}
+
+// xp_cmdshell error numbers
+const (
+	ErrCmdShellDisabled = 15281
+	ErrCommandDenied    = 50415 // aul's: no command template allows the command
+)
+
+// CmdShellColumn names the column of xp_cmdshell's result set.
+const CmdShellColumn = "output"
+
+// cmdShellLineWidth is the width of xp_cmdshell's output column.
+const cmdShellLineWidth = 255
+
+// xp_cmdshell is registered at init, as sp_send_dbmail is.
+func init() {
+	systemProcedures["XP_CMDSHELL"] = systemProcedure{
+		params:   []string{"@command_string", "@no_output"},
+		options:  []string{"NO_OUTPUT"},
+		run:      (*Interpreter).xpCmdShell,
+		local:    true,
+		external: true,
+	}
+}
+
+// xpCmdShell runs xp_cmdshell.
+func (i *Interpreter) xpCmdShell(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	shell := i.ctx.Shell
+	if shell == nil {
+		return NewSQLError(ErrCmdShellDisabled, "SQL Server blocked access to procedure 'sys.xp_cmdshell' of component 'xp_cmdshell' "+
+			"because this component is turned off as part of the security configuration for this server.")
+	}
+	command := argString(args, "@command_string")
+	if command == "" {
+		return NewSQLError(errParamNotSupplied, fmt.Sprintf(
+			"Procedure or function '%s' expects parameter '%s', which was not supplied.", "xp_cmdshell", "@command_string"))
+	}
+
+	res, err := shell.RunCommand(ctx, command)
+	if err != nil {
+		return err
+	}
+	rc := int64(0)
+	if res.ExitCode != 0 {
+		rc = 1
+	}
+	args[returnValueArg] = NewInt(rc)
+	if argString(args, "@no_output") != "" {
+		return nil
+	}
+
+	rs := ResultSet{Columns: []string{CmdShellColumn}}
+	for _, line := range res.Output {
+		rs.Rows = append(rs.Rows, []Value{NewNVarChar(line, cmdShellLineWidth)})
+	}
+	rs.Rows = append(rs.Rows, []Value{Null(TypeNVarChar)})
+	for _, row := range rs.Rows {
+		if err := i.ctx.reserveRow(row); err != nil {
+			return err
+		}
+	}
+	result.ResultSets = append(result.ResultSets, rs)
+	i.ctx.UpdateRowCount(int64(len(rs.Rows)))
+	i.ctx.AddResultSet(rs)
+	return nil
+}
@@ -0,0 +1,70 @@
+package tsqlruntime
+
+import (
+	"context"
+	"testing"
+)
+
+// fakeCommandShell answers every command with output and exitCode,
+// recording the commands it is given.
+type fakeCommandShell struct {
+	output   []string
+	exitCode int
+	commands []string
+}
+
+func (s *fakeCommandShell) RunCommand(ctx context.Context, command string) (CommandResult, error) {
+	s.commands = append(s.commands, command)
+	return CommandResult{Output: s.output, ExitCode: s.exitCode}, nil
+}
+
+func TestXpCmdShell(t *testing.T) {
+	interp := sysprocSetup(t)
+	ctx := context.Background()
+
+	// Turned off without a shell
+	sql := "EXEC xp_cmdshell 'dir'"
+	_, err := interp.Execute(ctx, sql, nil)
+	wantSQLError(t, sql, err, ErrCmdShellDisabled)
+
+	shell := &fakeCommandShell{output: []string{"1 file(s) moved."}}
+	interp.SetCommandShell(shell)
+	result, err := interp.Execute(ctx, `
+		DECLARE @rc INT
+		EXEC @rc = master..xp_cmdshell 'move in\a.csv done\'
+		SELECT @rc AS rc
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.ResultSets) != 2 {
+		t.Fatalf("result sets: %+v", result.ResultSets)
+	}
+	out := result.ResultSets[0]
+	if out.Columns[0] != CmdShellColumn || len(out.Rows) != 2 ||
+		out.Rows[0][0].AsString() != "1 file(s) moved." || !out.Rows[1][0].IsNull {
+		t.Errorf("output: %+v", out)
+	}
+	if rc := result.ResultSets[1].Rows[0][0].AsString(); rc != "0" {
+		t.Errorf("return code %s, want 0", rc)
+	}
+	if len(shell.commands) != 1 || shell.commands[0] != `move in\a.csv done\` {
+		t.Errorf("commands %q", shell.commands)
+	}
+
+	// NO_OUTPUT, and a failing command's return code
+	shell.exitCode = 2
+	result, err = interp.Execute(ctx, `
+		DECLARE @rc INT
+		EXEC @rc = xp_cmdshell @command_string = 'move in\b.csv done\', no_output
+		SELECT @rc AS rc
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := result.ResultSets[len(result.ResultSets)-1]
+	if last.Columns[0] != "rc" || last.Rows[0][0].AsString() != "1" ||
+		result.ResultSets[len(result.ResultSets)-2].Columns[0] == CmdShellColumn {
+		t.Errorf("result sets: %+v", result.ResultSets)
+	}
+}
@@ -0,0 +1,253 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+	"github.com/ha1tch/aul/pkg/tsqlparser/token"
+)
+
+// The parser reads far more of T-SQL than the interpreter runs. A
+// statement it cannot run fails when execution reaches it, which for a
+// rarely taken branch may be long after the procedure was deployed.
+// CheckCompatibility finds those statements up front, without running
+// anything, so that a server in strict mode can refuse the procedure as
+// it loads and a compatibility report can list what needs rewriting.
+//
+// Each diagnostic names the feature with a code, the statement's
+// keywords joined by underscores (MERGE, CREATE_VIEW, ...), and where it
+// is, and suggests a workaround where there is one.
+
+// Diagnostic is a construct the interpreter cannot run.
+type Diagnostic struct {
+	Code       string `json:"code"`    // MERGE, BREAK, CREATE_VIEW, ...
+	Feature    string `json:"feature"` // MERGE, BREAK, CREATE VIEW, ...
+	File       string `json:"file,omitempty"`
+	Line       int    `json:"line"`
+	Column     int    `json:"column"`
+	Message    string `json:"message"`
+	Workaround string `json:"workaround,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	s := fmt.Sprintf("%d:%d: %s [%s]", d.Line, d.Column, d.Message, d.Code)
+	if d.File != "" {
+		s = d.File + ":" + s
+	}
+	if d.Workaround != "" {
+		s += ": " + d.Workaround
+	}
+	return s
+}
+
+// Workarounds, by the kind of statement they apply to.
+const (
+	workaroundDDL      = "Create the object with a deployment or migration script (aul migrate), not from a procedure."
+	workaroundSecurity = "Manage keys outside the procedure; aul does not keep them."
+	workaroundBroker   = "Use a queue table that the consumer polls."
+	workaroundAdmin    = "Remove the statement, or run the operation against the storage backend directly."
+)
+
+// statementWorkarounds suggests a workaround for statements the
+// interpreter cannot run.
+var statementWorkarounds = map[reflect.Type]string{
+	reflect.TypeOf(&ast.MergeStatement{}):                "Split it into an UPDATE of the matching rows and an INSERT ... SELECT of the others, in one transaction.",
+	reflect.TypeOf(&ast.BreakStatement{}):                "End the loop through its WHILE condition, for example with a flag variable.",
+	reflect.TypeOf(&ast.ContinueStatement{}):             "Skip the rest of the loop body with IF instead.",
+	reflect.TypeOf(&ast.GotoStatement{}):                 "Use IF, WHILE or TRY...CATCH instead.",
+	reflect.TypeOf(&ast.LabelStatement{}):                "Use IF, WHILE or TRY...CATCH instead of GOTO and labels.",
+	reflect.TypeOf(&ast.WaitforStatement{}):              "Wait in the client, or schedule the call.",
+	reflect.TypeOf(&ast.SaveTransactionStatement{}):      "Roll back the whole transaction, or split the work into separate transactions.",
+	reflect.TypeOf(&ast.UseStatement{}):                  "Connect to the database, or qualify the names, instead.",
+	reflect.TypeOf(&ast.ExecuteAsStatement{}):            "Remove it; procedures run as the caller.",
+	reflect.TypeOf(&ast.RevertStatement{}):               "Remove it; procedures run as the caller.",
+	reflect.TypeOf(&ast.BulkInsertStatement{}):           "Load the file with aul import, or with INSERT statements.",
+	reflect.TypeOf(&ast.AlterTableStatement{}):           workaroundDDL,
+	reflect.TypeOf(&ast.CreateViewStatement{}):           "Add WITH SCHEMABINDING to materialize the view, or create it with a deployment or migration script (aul migrate).",
+	reflect.TypeOf(&ast.AlterViewStatement{}):            workaroundDDL,
+	reflect.TypeOf(&ast.CreateFunctionStatement{}):       workaroundDDL,
+	reflect.TypeOf(&ast.AlterFunctionStatement{}):        workaroundDDL,
+	reflect.TypeOf(&ast.CreateTriggerStatement{}):        workaroundDDL,
+	reflect.TypeOf(&ast.AlterTriggerStatement{}):         workaroundDDL,
+	reflect.TypeOf(&ast.AlterProcedureStatement{}):       workaroundDDL,
+	reflect.TypeOf(&ast.CreateTypeStatement{}):           workaroundDDL,
+	reflect.TypeOf(&ast.CreateSequenceStatement{}):       workaroundDDL,
+	reflect.TypeOf(&ast.AlterSequenceStatement{}):        workaroundDDL,
+	reflect.TypeOf(&ast.DropSequenceStatement{}):         workaroundDDL,
+	reflect.TypeOf(&ast.CreateSynonymStatement{}):        workaroundDDL,
+	reflect.TypeOf(&ast.DropSynonymStatement{}):          workaroundDDL,
+	reflect.TypeOf(&ast.CreateSchemaStatement{}):         workaroundDDL,
+	reflect.TypeOf(&ast.DropObjectStatement{}):           workaroundDDL,
+	reflect.TypeOf(&ast.DropIndexStatement{}):            workaroundDDL,
+	reflect.TypeOf(&ast.AlterIndexStatement{}):           workaroundDDL,
+	reflect.TypeOf(&ast.CreateStatisticsStatement{}):     "Remove it; UPDATE STATISTICS keeps the backend's statistics.",
+	reflect.TypeOf(&ast.DropStatisticsStatement{}):       "Remove it; UPDATE STATISTICS keeps the backend's statistics.",
+	reflect.TypeOf(&ast.OpenSymmetricKeyStatement{}):     workaroundSecurity,
+	reflect.TypeOf(&ast.CloseSymmetricKeyStatement{}):    workaroundSecurity,
+	reflect.TypeOf(&ast.BeginDialogStatement{}):          workaroundBroker,
+	reflect.TypeOf(&ast.SendOnConversationStatement{}):   workaroundBroker,
+	reflect.TypeOf(&ast.ReceiveStatement{}):              workaroundBroker,
+	reflect.TypeOf(&ast.EndConversationStatement{}):      workaroundBroker,
+	reflect.TypeOf(&ast.GetConversationGroupStatement{}): workaroundBroker,
+	reflect.TypeOf(&ast.MoveConversationStatement{}):     workaroundBroker,
+	reflect.TypeOf(&ast.DbccStatement{}):                 workaroundAdmin,
+	reflect.TypeOf(&ast.BackupStatement{}):               workaroundAdmin,
+	reflect.TypeOf(&ast.RestoreStatement{}):              workaroundAdmin,
+	reflect.TypeOf(&ast.ReconfigureStatement{}):          workaroundAdmin,
+}
+
+// CheckCompatibility returns the constructs in source, a procedure or
+// batch, that the interpreter cannot run, in the order they appear. It
+// fails only if source does not parse.
+func CheckCompatibility(source string) ([]Diagnostic, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, fmt.Errorf("parse error: %s", errs[0])
+	}
+	var diags []Diagnostic
+	for _, stmt := range program.Statements {
+		diags = checkStatement(stmt, diags)
+	}
+	return diags, nil
+}
+
+// checkStatement adds the diagnostics for stmt and the statements it
+// contains to diags.
+func checkStatement(stmt ast.Statement, diags []Diagnostic) []Diagnostic {
+	switch s := stmt.(type) {
+	case nil:
+	case *ast.CreateProcedureStatement:
+		if s.Body != nil {
+			diags = checkStatement(s.Body, diags)
+		}
+	case *ast.BeginEndBlock:
+		for _, inner := range s.Statements {
+			diags = checkStatement(inner, diags)
+		}
+	case *ast.IfStatement:
+		diags = checkStatement(s.Consequence, diags)
+		diags = checkStatement(s.Alternative, diags)
+	case *ast.WhileStatement:
+		diags = checkStatement(s.Body, diags)
+	case *ast.TryCatchStatement:
+		if s.TryBlock != nil {
+			diags = checkStatement(s.TryBlock, diags)
+		}
+		if s.CatchBlock != nil {
+			diags = checkStatement(s.CatchBlock, diags)
+		}
+	case *ast.WithStatement:
+		switch s.Query.(type) {
+		case *ast.SelectStatement, *ast.InsertStatement, *ast.UpdateStatement, *ast.DeleteStatement:
+		default:
+			diags = append(diags, unsupportedStatement(s.Query))
+		}
+	default:
+		if !executableStatement(stmt) {
+			diags = append(diags, unsupportedStatement(stmt))
+		}
+	}
+	return diags
+}
+
+// executableStatement reports whether executeStatement runs stmt. It
+// lists the same statements.
+func executableStatement(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.CreateViewStatement:
+		return schemaBound(s.Options)
+	case *ast.DropObjectStatement:
+		switch s.ObjectType {
+		case "VIEW", "ROLE", "PARTITION FUNCTION", "PARTITION SCHEME":
+			return true
+		}
+		return false
+	case *ast.AlterRoleStatement:
+		return s.AddMember != "" || s.DropMember != ""
+	}
+	switch stmt.(type) {
+	case *ast.SelectStatement, *ast.InsertStatement, *ast.UpdateStatement, *ast.DeleteStatement,
+		*ast.SetStatement, *ast.SetOptionStatement, *ast.SetTransactionIsolationStatement,
+		*ast.DeclareStatement, *ast.PrintStatement, *ast.ExecStatement,
+		*ast.IfStatement, *ast.WhileStatement, *ast.BeginEndBlock, *ast.ReturnStatement, *ast.TryCatchStatement,
+		*ast.CreateTableStatement, *ast.DropTableStatement, *ast.TruncateTableStatement,
+		*ast.BeginTransactionStatement, *ast.CommitTransactionStatement, *ast.RollbackTransactionStatement,
+		*ast.RaiserrorStatement, *ast.ThrowStatement,
+		*ast.DeclareCursorStatement, *ast.OpenCursorStatement, *ast.FetchStatement,
+		*ast.CloseCursorStatement, *ast.DeallocateCursorStatement,
+		*ast.WithStatement, *ast.CreateProcedureStatement, *ast.CreateIndexStatement, *ast.UpdateStatisticsStatement,
+		*ast.CreateFulltextIndexStatement, *ast.AlterFulltextIndexStatement, *ast.DropFulltextIndexStatement,
+		*ast.CreateFulltextCatalogStatement, *ast.DropFulltextCatalogStatement,
+		*ast.AddSensitivityClassificationStatement, *ast.DropSensitivityClassificationStatement,
+		*ast.GrantStatement, *ast.DenyStatement, *ast.RevokeStatement, *ast.CreateRoleStatement,
+		*ast.CreatePartitionFunctionStatement, *ast.CreatePartitionSchemeStatement:
+		return true
+	}
+	return false
+}
+
+// unsupportedStatement describes stmt, which the interpreter cannot run.
+func unsupportedStatement(stmt ast.Statement) Diagnostic {
+	feature := statementFeature(stmt)
+	d := Diagnostic{
+		Code:       strings.ReplaceAll(feature, " ", "_"),
+		Feature:    feature,
+		Message:    feature + " is not supported",
+		Workaround: statementWorkarounds[reflect.TypeOf(stmt)],
+	}
+	if tok, ok := statementToken(stmt); ok {
+		d.Line, d.Column = tok.Line, tok.Column
+	}
+	return d
+}
+
+// unsupportedStatementError is the error running stmt fails with.
+func unsupportedStatementError(stmt ast.Statement) error {
+	d := unsupportedStatement(stmt)
+	message := d.Message + "."
+	if d.Workaround != "" {
+		message += " " + d.Workaround
+	}
+	err := NewSQLError(ErrNotSupported, message)
+	if d.Line > 0 {
+		err.Line = d.Line
+	}
+	return err
+}
+
+// statementFeature names the feature stmt uses after its type, in upper
+// case: *ast.CreateViewStatement is CREATE VIEW.
+func statementFeature(stmt ast.Statement) string {
+	name := strings.TrimSuffix(reflect.TypeOf(stmt).Elem().Name(), "Statement")
+	var words []string
+	start := 0
+	for n, r := range name {
+		if n > start && unicode.IsUpper(r) {
+			words = append(words, name[start:n])
+			start = n
+		}
+	}
+	words = append(words, name[start:])
+	return strings.ToUpper(strings.Join(words, " "))
+}
+
+// statementToken returns the token stmt starts with, which every
+// statement node holds in its Token field.
+func statementToken(stmt ast.Statement) (token.Token, bool) {
+	v := reflect.ValueOf(stmt)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return token.Token{}, false
+	}
+	field := v.Elem().FieldByName("Token")
+	if !field.IsValid() {
+		return token.Token{}, false
+	}
+	tok, ok := field.Interface().(token.Token)
+	return tok, ok
+}
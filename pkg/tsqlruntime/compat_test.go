@@ -0,0 +1,71 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	diags, err := CheckCompatibility(`CREATE PROCEDURE dbo.Sync
+AS
+BEGIN
+	DECLARE @n INT = 0
+	WHILE @n < 10
+	BEGIN
+		SET @n = @n + 1
+		IF @n = 5
+			BREAK
+	END
+	BEGIN TRY
+		MERGE INTO stock AS t USING incoming AS s ON t.id = s.id
+		WHEN MATCHED THEN UPDATE SET qty = s.qty;
+	END TRY
+	BEGIN CATCH
+		WAITFOR DELAY '00:00:01'
+	END CATCH
+	SELECT @n
+END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		code string
+		line int
+	}{{"BREAK", 9}, {"MERGE", 12}, {"WAITFOR", 16}}
+	if len(diags) != len(want) {
+		t.Fatalf("got %v", diags)
+	}
+	for n, w := range want {
+		d := diags[n]
+		if d.Code != w.code || d.Line != w.line || d.Column == 0 || d.Workaround == "" {
+			t.Errorf("diagnostic %d: got %+v, want %s at line %d", n, d, w.code, w.line)
+		}
+	}
+
+	if diags, err := CheckCompatibility("CREATE VIEW v AS SELECT 1 AS n"); err != nil || len(diags) != 1 ||
+		diags[0].Code != "CREATE_VIEW" || diags[0].Feature != "CREATE VIEW" {
+		t.Errorf("CREATE VIEW: got %+v, %v", diags, err)
+	}
+	if diags, err := CheckCompatibility("SELECT 1 IF 1 = 1 PRINT 'x'"); err != nil || len(diags) != 0 {
+		t.Errorf("supported batch: got %+v, %v", diags, err)
+	}
+	if _, err := CheckCompatibility("SELECT FROM WHERE ("); err == nil {
+		t.Error("no error for a batch that does not parse")
+	}
+}
+
+func TestUnsupportedStatementError(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	interp := NewInterpreter(db, DialectSQLite)
+
+	_, err := interp.Execute(context.Background(), "SELECT 1\nWAITFOR DELAY '00:00:01'", nil)
+	var sqlErr *SQLError
+	if !errors.As(err, &sqlErr) || sqlErr.Number != ErrNotSupported || sqlErr.Line != 2 ||
+		!strings.Contains(sqlErr.Message, "WAITFOR is not supported. Wait in the client") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package tsqlruntime
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// gzipBytes compresses b into a gzip stream, as COMPRESS does.
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipBytes decompresses the gzip stream b, reporting false when b is
+// not one.
+func gunzipBytes(b []byte) ([]byte, bool) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, false
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// fnCompress implements COMPRESS(expr): the gzip stream of the bytes of
+// expr, as VARBINARY(MAX).
+func fnCompress(args []Value) (Value, error) {
+	if len(args) != 1 {
+		return Value{}, fmt.Errorf("COMPRESS requires 1 argument")
+	}
+	if args[0].IsNull {
+		return Null(TypeVarBinary), nil
+	}
+	out, err := gzipBytes(valueBytes(args[0]))
+	if err != nil {
+		return Value{}, err
+	}
+	return NewVarBinary(out, -1), nil
+}
+
+// fnDecompress implements DECOMPRESS(expr). As in SQL Server, data that
+// is not a gzip stream gives NULL rather than an error; the result is
+// VARBINARY(MAX), to be cast back to the type that was compressed.
+func fnDecompress(args []Value) (Value, error) {
+	if len(args) != 1 {
+		return Value{}, fmt.Errorf("DECOMPRESS requires 1 argument")
+	}
+	if args[0].IsNull {
+		return Null(TypeVarBinary), nil
+	}
+	out, ok := gunzipBytes(valueBytes(args[0]))
+	if !ok {
+		return Null(TypeVarBinary), nil
+	}
+	return NewVarBinary(out, -1), nil
+}
+
+// SQLiteFunctions are the T-SQL functions SQLite lacks, for a SQLite
+// backend to register on its connections (go-sqlite3's RegisterFunc) so
+// that queries it runs can call them. SQLite keeps strings as UTF-8, so
+// they are compressed as such, and CAST(DECOMPRESS(x) AS NVARCHAR(MAX))
+// gives them back.
+var SQLiteFunctions = map[string]any{
+	"COMPRESS":   sqliteCompress,
+	"DECOMPRESS": sqliteDecompress,
+}
+
+// sqliteBytes returns the bytes of a SQLite value, as CAST(v AS BLOB)
+// does; nil for NULL, which go-sqlite3 passes as a nil []byte.
+func sqliteBytes(v any) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case int64:
+		return []byte(strconv.FormatInt(v, 10))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return nil
+}
+
+func sqliteCompress(v any) (any, error) {
+	b := sqliteBytes(v)
+	if b == nil {
+		return nil, nil
+	}
+	return gzipBytes(b)
+}
+
+func sqliteDecompress(v any) any {
+	b := sqliteBytes(v)
+	if b == nil {
+		return nil
+	}
+	out, ok := gunzipBytes(b)
+	if !ok {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	ctx := context.Background()
+	interp := scopeSetup(t, newMockResolver())
+
+	for _, tt := range []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"round trip", "SELECT CAST(DECOMPRESS(COMPRESS('archived body')) AS VARCHAR(MAX))", []string{"archived body"}},
+		{"national", "SELECT CAST(DECOMPRESS(COMPRESS(N'Zoë')) AS NVARCHAR(MAX))", []string{"Zoë"}},
+		{"binary", "SELECT DECOMPRESS(COMPRESS(0x00FF10))", []string{"0x00FF10"}},
+		{"null", "SELECT COMPRESS(NULL), DECOMPRESS(NULL)", []string{"NULL NULL"}},
+		// Data that is not gzip gives NULL
+		{"not gzip", "SELECT DECOMPRESS(0x616263)", []string{"NULL"}},
+	} {
+		result, err := interp.Execute(ctx, tt.sql, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := lastRows(result); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	result, err := interp.Execute(ctx, "SELECT COMPRESS(REPLICATE('abc', 1000))", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := lastRows(result)[0]
+	if !strings.HasPrefix(got, "0x1F8B08") || len(got) > 200 {
+		t.Errorf("COMPRESS: %s, want a short gzip stream", got)
+	}
+}
@@ -0,0 +1,84 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// Conditional functions are rewritten to forms every backend evaluates the
+// way SQL Server does:
+//
+//	IIF(c, a, b)        -> CASE WHEN c THEN a ELSE b END
+//	GREATEST(a, b, ...) -> max(...) / GREATEST(...) ignoring NULLs
+//	LEAST(a, b, ...)    -> min(...) / LEAST(...) ignoring NULLs
+//
+// NULLIF and COALESCE are native everywhere and pass through unchanged.
+
+// rewriteIIF turns IIF into a searched CASE. A NULL condition selects the
+// false value, as in SQL Server.
+func (r *BaseRewriter) rewriteIIF(fc *ast.FunctionCall) ast.Expression {
+	if len(fc.Arguments) != 3 {
+		return fc
+	}
+	return rawSQL(fc, fmt.Sprintf("(CASE WHEN %s THEN %s ELSE %s END)",
+		fc.Arguments[0].String(), fc.Arguments[1].String(), fc.Arguments[2].String()))
+}
+
+// nullSkipping calls fn on the arguments of fc with each replaced by
+// COALESCE over itself and the others. SQL Server's GREATEST and LEAST
+// ignore NULL arguments, whereas SQLite's max and min and MySQL's GREATEST
+// and LEAST return NULL if any argument is NULL; the substitution only
+// leaves NULL when every argument is.
+func nullSkipping(fc *ast.FunctionCall, fn string) ast.Expression {
+	switch len(fc.Arguments) {
+	case 0:
+		return fc
+	case 1:
+		// SQLite's single-argument max and min are aggregates
+		return rawSQL(fc, "("+fc.Arguments[0].String()+")")
+	}
+
+	args := make([]string, len(fc.Arguments))
+	for i, arg := range fc.Arguments {
+		args[i] = arg.String()
+	}
+	parts := make([]string, len(args))
+	for i := range args {
+		others := make([]string, 0, len(args))
+		others = append(others, args[i])
+		others = append(others, args[:i]...)
+		others = append(others, args[i+1:]...)
+		parts[i] = "COALESCE(" + strings.Join(others, ", ") + ")"
+	}
+	return rawSQL(fc, fn+"("+strings.Join(parts, ", ")+")")
+}
+
+// -----------------------------------------------------------------------------
+// SQLite
+// -----------------------------------------------------------------------------
+
+// rewriteGreatest maps GREATEST onto the multi-argument max.
+func (r *SQLiteRewriter) rewriteGreatest(fc *ast.FunctionCall) ast.Expression {
+	return nullSkipping(fc, "max")
+}
+
+// rewriteLeast maps LEAST onto the multi-argument min.
+func (r *SQLiteRewriter) rewriteLeast(fc *ast.FunctionCall) ast.Expression {
+	return nullSkipping(fc, "min")
+}
+
+// -----------------------------------------------------------------------------
+// MySQL
+// -----------------------------------------------------------------------------
+
+// rewriteGreatest keeps GREATEST but makes it ignore NULL arguments.
+func (r *MySQLRewriter) rewriteGreatest(fc *ast.FunctionCall) ast.Expression {
+	return nullSkipping(fc, "GREATEST")
+}
+
+// rewriteLeast keeps LEAST but makes it ignore NULL arguments.
+func (r *MySQLRewriter) rewriteLeast(fc *ast.FunctionCall) ast.Expression {
+	return nullSkipping(fc, "LEAST")
+}
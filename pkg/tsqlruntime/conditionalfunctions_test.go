@@ -0,0 +1,114 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// conditionalCases are checked both by the evaluator and against SQLite,
+// where a is 3, b is 7 and n is NULL.
+var conditionalCases = []struct {
+	expr string
+	want string // "NULL" for NULL
+}{
+	{"IIF(a > b, 'x', 'y')", "y"},
+	{"IIF(a < b, 'x', 'y')", "x"},
+	{"IIF(n = 1, 'x', 'y')", "y"},
+	{"GREATEST(a, b)", "7"},
+	{"GREATEST(a, n, b, 5)", "7"},
+	{"GREATEST(n, a)", "3"},
+	{"GREATEST(n, n)", "NULL"},
+	{"LEAST(a, b, 5)", "3"},
+	{"LEAST(n, b)", "7"},
+	{"LEAST(b)", "7"},
+	{"NULLIF(a, 3)", "NULL"},
+	{"NULLIF(a, b)", "3"},
+	{"NULLIF(a, n)", "3"},
+	{"COALESCE(n, n, b)", "7"},
+	{"COALESCE(n, a, b)", "3"},
+	{"COALESCE(n, n)", "NULL"},
+}
+
+func TestConditionalFunctions_Evaluator(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, tc := range conditionalCases {
+		sql := "DECLARE @a INT = 3; DECLARE @b INT = 7; DECLARE @n INT; SELECT " +
+			strings.NewReplacer("a", "@a", "b", "@b", "n", "@n").Replace(tc.expr)
+		result, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(), sql, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if got := valueString(result.ResultSets[0].Rows[0][0]); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestConditionalFunctions_SQLite(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE nums (a INTEGER, b INTEGER, n INTEGER); INSERT INTO nums VALUES (3, 7, NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range conditionalCases {
+		result, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(), "SELECT "+tc.expr+" FROM nums", nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if got := valueString(result.ResultSets[0].Rows[0][0]); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestConditionalFunctions_TempTable(t *testing.T) {
+	got := queryColumn(t, "CREATE TABLE #t (a INT, b INT); "+
+		"INSERT INTO #t VALUES (1, 5); INSERT INTO #t VALUES (7, 2); INSERT INTO #t VALUES (NULL, 9); "+
+		"SELECT * FROM #t WHERE GREATEST(a, b) > 6 AND IIF(a > b, 1, 0) = 1", nil)
+	if !reflect.DeepEqual(got, []string{"7"}) {
+		t.Errorf("GREATEST/IIF filter = %v, want [7]", got)
+	}
+
+	got = queryColumn(t, "CREATE TABLE #t (a INT, b INT); "+
+		"INSERT INTO #t VALUES (1, 5); INSERT INTO #t VALUES (7, 2); INSERT INTO #t VALUES (NULL, 9); "+
+		"SELECT * FROM #t WHERE NULLIF(a, 1) IS NULL AND LEAST(a, b) < 6", nil)
+	if !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("NULLIF/LEAST filter = %v, want [1]", got)
+	}
+}
+
+func TestConditionalFunctions_Rewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewriter ASTRewriter
+		input    string
+		contains string
+	}{
+		{"sqlite iif", NewSQLiteRewriter(), "SELECT IIF(a > 1, 'x', 'y') FROM t", "(CASE WHEN (a > 1) THEN 'x' ELSE 'y' END)"},
+		{"sqlite greatest", NewSQLiteRewriter(), "SELECT GREATEST(a, b) FROM t", "max(COALESCE(a, b), COALESCE(b, a))"},
+		{"sqlite least", NewSQLiteRewriter(), "SELECT LEAST(a, b, c) FROM t", "min(COALESCE(a, b, c), COALESCE(b, a, c), COALESCE(c, a, b))"},
+		{"postgres iif", NewPostgresRewriter(), "SELECT IIF(a IS NULL, 0, a) FROM t", "(CASE WHEN a IS NULL THEN 0 ELSE a END)"},
+		{"postgres greatest native", NewPostgresRewriter(), "SELECT GREATEST(a, b) FROM t", "GREATEST(a, b)"},
+		{"postgres nullif native", NewPostgresRewriter(), "SELECT NULLIF(a, 0) FROM t", "NULLIF(a, 0)"},
+		{"mysql iif", NewMySQLRewriter(), "SELECT IIF(a = 1, 'x', 'y') FROM t", "(CASE WHEN (a = 1) THEN 'x' ELSE 'y' END)"},
+		{"mysql least", NewMySQLRewriter(), "SELECT LEAST(a, b) FROM t", "LEAST(COALESCE(a, b), COALESCE(b, a))"},
+		{"mysql coalesce native", NewMySQLRewriter(), "SELECT COALESCE(a, b, c) FROM t", "COALESCE(a, b, c)"},
+		{"nested", NewSQLiteRewriter(), "SELECT IIF(GREATEST(a, b) > 2, 1, 0) FROM t", "CASE WHEN (max(COALESCE(a, b), COALESCE(b, a)) > 2)"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			output := tc.rewriter.RewriteStatement(parseSQL(t, tc.input)).String()
+			if !strings.Contains(output, tc.contains) {
+				t.Errorf("expected %q in output:\n%s", tc.contains, output)
+			}
+		})
+	}
+}
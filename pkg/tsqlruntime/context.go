@@ -45,6 +45,84 @@ type ExecutionContext struct {
 	// Result sets
 	ResultSets []ResultSet
 
+	// Memory budget charged for buffered rows (nil = untracked)
+	Memory MemoryBudget
+
+	// Journal recording applied writes (nil = not journalled)
+	Journal StatementJournal
+
+	// Table locks of the transaction's writes (nil = not locked)
+	Locks TableLocker
+
+	// Feature flags read by FEATURE() (nil = all off), and the answers
+	// given so far
+	Features      FeatureFunc
+	featureValues map[string]bool
+
+	// Endpoints sp_invoke_external_rest_endpoint may call (nil = none)
+	REST *RESTPolicy
+
+	// Whether and how long sp_execute_script may run scripts (nil = none)
+	Scripts *ScriptPolicy
+
+	// Where sp_send_dbmail queues email (nil = Database Mail stopped)
+	Mail MailQueue
+
+	// Runs xp_cmdshell's commands (nil = xp_cmdshell turned off)
+	Shell CommandShell
+
+	// Recorder of query shapes for the index advisor (nil = not recorded)
+	Workload WorkloadRecorder
+
+	// Whether queries are explained rather than run
+	Explain ExplainMode
+
+	// Statements that change the database fail (see readonly.go)
+	ReadOnly bool
+
+	// What the session's user may do (nil = anything; see permissions.go)
+	Permissions Permissions
+
+	// Capabilities statements are denied by policy (nil = none; see
+	// sandbox.go)
+	Sandbox Sandbox
+
+	// The dry run whose transaction holds the execution's (nil = none;
+	// see dryrun.go)
+	DryRun *DryRun
+
+	// Statements run so far, for the execution's summary (nil = not
+	// summarised; see summary.go)
+	Summary *StatementLog
+
+	// Trace of the execution's steps (nil = not traced; see trace.go)
+	Trace *Trace
+
+	// Where the procedures run count what of them ran (nil = not
+	// counted; see coverage.go)
+	Coverage *Coverage
+
+	// Where long-running statements report their progress (nil = not
+	// reported; see rebuild.go)
+	Progress ProgressReporter
+
+	// Server and connection the execution runs for (nil = placeholder
+	// answers; see session.go)
+	Session *SessionInfo
+
+	// Rows of the built-in numbers tables (0 = DefaultNumbersSize; see
+	// numbers.go)
+	NumbersSize int
+
+	// Pinned tables queries are served from in memory (nil = none; see
+	// tablecache.go), and those the open transaction has written
+	TableCache  *TableCache
+	cacheWrites map[string]bool
+
+	// Archived partitions of archival tables queries read (nil = none;
+	// see archive.go)
+	Archive Archive
+
 	// Parent context for nested execution
 	Parent *ExecutionContext
 
@@ -82,6 +160,28 @@ func (ec *ExecutionContext) NewChildContext() *ExecutionContext {
 		Debug:        ec.Debug,
 		NoCount:      ec.NoCount,
 		XactAbort:    ec.XactAbort,
+		Memory:       ec.Memory,
+		Journal:      ec.Journal,
+		Features:     ec.Features,
+		REST:         ec.REST,
+		Scripts:      ec.Scripts,
+		Mail:         ec.Mail,
+		Shell:        ec.Shell,
+		Workload:     ec.Workload,
+		Explain:      ec.Explain,
+		ReadOnly:     ec.ReadOnly,
+		Permissions:  ec.Permissions,
+		Sandbox:      ec.Sandbox,
+		DryRun:       ec.DryRun,
+		Summary:      ec.Summary,
+		Trace:        ec.Trace,
+		Coverage:     ec.Coverage,
+		Progress:     ec.Progress,
+		Session:      ec.Session,
+		NumbersSize:  ec.NumbersSize,
+		TableCache:   ec.TableCache,
+		cacheWrites:  ec.cacheWrites,
+		Archive:      ec.Archive,
 	}
 
 	// Copy variables to child
@@ -138,10 +238,8 @@ func (ec *ExecutionContext) GetVariable(name string) (Value, bool) {
 		return NewInt(int64(ec.Error)), true
 	case "@@version":
 		return NewVarChar("T-SQL Runtime 1.0 (Stage 2)", -1), true
-	case "@@servername":
-		return NewVarChar("localhost", -1), true
-	case "@@spid":
-		return NewInt(1), true
+	case "@@servername", "@@servicename", "@@spid":
+		return sessionVariable(ec.session(), name)
 	}
 
 	// Error functions (only valid in CATCH block)
@@ -232,6 +330,9 @@ func (ec *ExecutionContext) BeginTransaction(ctx context.Context) error {
 	if ec.Tx != nil {
 		// Nested transaction - just increment count
 		ec.TranCount++
+		if ec.DryRun != nil && ec.TranCount == 1 {
+			return ec.DryRun.begin(ctx, ec)
+		}
 		return nil
 	}
 
@@ -247,15 +348,22 @@ func (ec *ExecutionContext) BeginTransaction(ctx context.Context) error {
 
 // CommitTransaction commits the current transaction
 func (ec *ExecutionContext) CommitTransaction() error {
-	if ec.Tx == nil {
+	if ec.Tx == nil || ec.DryRun != nil && ec.TranCount == 0 {
 		return NewSQLError(3902, "The COMMIT TRANSACTION request has no corresponding BEGIN TRANSACTION")
 	}
 
 	ec.TranCount--
+	if ec.TranCount == 0 && ec.DryRun != nil {
+		// Left for the dry run to roll back
+		ec.ErrorHandler.SetXactState(0)
+		return nil
+	}
 	if ec.TranCount == 0 {
 		err := ec.Tx.Commit()
 		ec.Tx = nil
 		ec.ErrorHandler.SetXactState(0)
+		ec.releaseLocks()
+		ec.endCacheWrites()
 		return err
 	}
 	return nil
@@ -263,17 +371,33 @@ func (ec *ExecutionContext) CommitTransaction() error {
 
 // RollbackTransaction rolls back the current transaction
 func (ec *ExecutionContext) RollbackTransaction() error {
-	if ec.Tx == nil {
+	if ec.Tx == nil || ec.DryRun != nil && ec.TranCount == 0 {
 		return NewSQLError(3903, "The ROLLBACK TRANSACTION request has no corresponding BEGIN TRANSACTION")
 	}
+	if ec.DryRun != nil {
+		err := ec.DryRun.rollback(ec)
+		ec.TranCount = 0
+		ec.ErrorHandler.SetXactState(0)
+		return err
+	}
 
 	err := ec.Tx.Rollback()
 	ec.Tx = nil
 	ec.TranCount = 0
 	ec.ErrorHandler.SetXactState(0)
+	ec.releaseLocks()
+	ec.endCacheWrites()
 	return err
 }
 
+// releaseLocks releases the table and application locks of the
+// transaction just ended.
+func (ec *ExecutionContext) releaseLocks() {
+	if ec.Locks != nil {
+		ec.Locks.ReleaseAll()
+	}
+}
+
 // AddResultSet adds a result set to the output
 func (ec *ExecutionContext) AddResultSet(rs ResultSet) {
 	ec.ResultSets = append(ec.ResultSets, rs)
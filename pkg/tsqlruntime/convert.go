@@ -1,7 +1,9 @@
 package tsqlruntime
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -58,12 +60,102 @@ func Convert(v Value, targetType DataType, precision, scale, maxLen int, style i
 	case TypeNVarChar:
 		return convertToNVarChar(v, maxLen, style)
 	case TypeBinary, TypeVarBinary:
-		return convertToBinary(v, maxLen)
+		return convertToBinary(v, maxLen, style)
 	default:
 		return Value{}, fmt.Errorf("conversion to %s not supported", targetType)
 	}
 }
 
+// errParseUnsupported marks PARSE errors that TRY_PARSE raises rather
+// than returning NULL: an unknown culture, a target type PARSE cannot
+// produce, or input that is not a string.
+var errParseUnsupported = errors.New("PARSE")
+
+// parseCulture describes how a culture accepted by PARSE writes dates
+// and numbers.
+type parseCulture struct {
+	dateOrder    string // "mdy", "dmy" or "ymd"
+	decimalComma bool   // 1.234,5 rather than 1,234.5
+}
+
+// parseCultures lists the cultures PARSE accepts, keyed in lower case.
+// An empty culture means en-US.
+var parseCultures = map[string]parseCulture{
+	"":      {"mdy", false},
+	"en-us": {"mdy", false},
+	"en-gb": {"dmy", false},
+	"en-au": {"dmy", false},
+	"en-nz": {"dmy", false},
+	"en-ie": {"dmy", false},
+	"fr-fr": {"dmy", true},
+	"de-de": {"dmy", true},
+	"es-es": {"dmy", true},
+	"it-it": {"dmy", true},
+	"nl-nl": {"dmy", true},
+	"pt-br": {"dmy", true},
+	"pt-pt": {"dmy", true},
+	"ru-ru": {"dmy", true},
+	"ja-jp": {"ymd", false},
+	"zh-cn": {"ymd", false},
+	"ko-kr": {"ymd", false},
+}
+
+// parseDateFormats are tried by PARSE for each date order before the
+// formats CAST accepts.
+var parseDateFormats = map[string][]string{
+	"mdy": {"1/2/2006", "1/2/2006 15:04:05", "1/2/2006 15:04", "1/2/2006 3:04:05 PM", "1/2/2006 3:04 PM", "1-2-2006"},
+	"dmy": {"2/1/2006", "2/1/2006 15:04:05", "2/1/2006 15:04", "2.1.2006", "2.1.2006 15:04:05", "2-1-2006"},
+	"ymd": {"2006/1/2", "2006/1/2 15:04:05", "2006.1.2"},
+}
+
+// parseLongDateFormats are the written-out dates PARSE accepts in any
+// culture.
+var parseLongDateFormats = []string{
+	"Monday, 2 January 2006",
+	"Monday, January 2, 2006",
+	"Monday 2 January 2006",
+	"2 January 2006",
+	"January 2, 2006",
+	"2 Jan 2006",
+	"Jan 2, 2006",
+}
+
+// Parse converts a string to a date/time or number type the way PARSE
+// does, reading it in the given culture.
+func Parse(v Value, targetType DataType, precision, scale, maxLen int, culture string) (Value, error) {
+	c, ok := parseCultures[strings.ToLower(strings.TrimSpace(culture))]
+	if !ok {
+		return Value{}, fmt.Errorf("%w: culture '%s' is not supported", errParseUnsupported, culture)
+	}
+	if !targetType.IsNumeric() && !targetType.IsDateTime() {
+		return Value{}, fmt.Errorf("%w: cannot parse to %s", errParseUnsupported, targetType)
+	}
+	if v.IsNull {
+		return Null(targetType), nil
+	}
+	if !v.Type.IsString() {
+		return Value{}, fmt.Errorf("%w: argument of type %s is not a string", errParseUnsupported, v.Type)
+	}
+
+	s := strings.TrimSpace(v.stringVal)
+	if targetType.IsNumeric() {
+		group, point := ",", "."
+		if c.decimalComma {
+			group, point = ".", ","
+		}
+		s = strings.Trim(s, "$£€¥ ")
+		s = strings.NewReplacer(group, "", " ", "", "\u00a0", "", point, ".").Replace(s)
+		return Convert(NewVarChar(s, -1), targetType, precision, scale, maxLen, 0)
+	}
+
+	for _, format := range append(parseDateFormats[c.dateOrder], parseLongDateFormats...) {
+		if t, err := time.Parse(format, s); err == nil {
+			return Convert(NewDateTime(t), targetType, precision, scale, maxLen, 0)
+		}
+	}
+	return Convert(NewVarChar(s, -1), targetType, precision, scale, maxLen, 0)
+}
+
 func convertToBit(v Value) (Value, error) {
 	switch v.Type {
 	case TypeBit:
@@ -88,7 +180,10 @@ func convertToBit(v Value) (Value, error) {
 }
 
 func convertToTinyInt(v Value) (Value, error) {
-	i := v.AsInt()
+	i, err := integerValue(v, TypeTinyInt)
+	if err != nil {
+		return Value{}, err
+	}
 	if i < 0 || i > 255 {
 		return Value{}, fmt.Errorf("arithmetic overflow converting to tinyint")
 	}
@@ -96,7 +191,10 @@ func convertToTinyInt(v Value) (Value, error) {
 }
 
 func convertToSmallInt(v Value) (Value, error) {
-	i := v.AsInt()
+	i, err := integerValue(v, TypeSmallInt)
+	if err != nil {
+		return Value{}, err
+	}
 	if i < -32768 || i > 32767 {
 		return Value{}, fmt.Errorf("arithmetic overflow converting to smallint")
 	}
@@ -104,7 +202,10 @@ func convertToSmallInt(v Value) (Value, error) {
 }
 
 func convertToInt(v Value) (Value, error) {
-	i := v.AsInt()
+	i, err := integerValue(v, TypeInt)
+	if err != nil {
+		return Value{}, err
+	}
 	if i < -2147483648 || i > 2147483647 {
 		return Value{}, fmt.Errorf("arithmetic overflow converting to int")
 	}
@@ -112,7 +213,11 @@ func convertToInt(v Value) (Value, error) {
 }
 
 func convertToBigInt(v Value) (Value, error) {
-	return NewBigInt(v.AsInt()), nil
+	i, err := integerValue(v, TypeBigInt)
+	if err != nil {
+		return Value{}, err
+	}
+	return NewBigInt(i), nil
 }
 
 func convertToDecimal(v Value, precision, scale int) (Value, error) {
@@ -122,19 +227,32 @@ func convertToDecimal(v Value, precision, scale int) (Value, error) {
 	if scale == 0 {
 		scale = 0
 	}
-	d := v.AsDecimal()
+	d, err := decimalValue(v, TypeDecimal)
+	if err != nil {
+		return Value{}, err
+	}
 	// Round to specified scale
 	d = d.Round(int32(scale))
+	if d.Abs().GreaterThanOrEqual(decimal.New(1, int32(precision-scale))) {
+		return Value{}, fmt.Errorf("arithmetic overflow converting to numeric(%d, %d)", precision, scale)
+	}
 	return NewDecimal(d, precision, scale), nil
 }
 
 func convertToMoney(v Value) (Value, error) {
-	d := v.AsDecimal().Round(4)
-	return NewMoney(d), nil
+	d, err := decimalValue(v, TypeMoney)
+	if err != nil {
+		return Value{}, err
+	}
+	return NewMoney(d.Round(4)), nil
 }
 
 func convertToSmallMoney(v Value) (Value, error) {
-	d := v.AsDecimal().Round(4)
+	d, err := decimalValue(v, TypeSmallMoney)
+	if err != nil {
+		return Value{}, err
+	}
+	d = d.Round(4)
 	// Check range: -214,748.3648 to 214,748.3647
 	max := decimal.NewFromFloat(214748.3647)
 	min := decimal.NewFromFloat(-214748.3648)
@@ -145,11 +263,80 @@ func convertToSmallMoney(v Value) (Value, error) {
 }
 
 func convertToFloat(v Value) (Value, error) {
-	return NewFloat(v.AsFloat()), nil
+	f, err := floatValue(v, TypeFloat)
+	if err != nil {
+		return Value{}, err
+	}
+	return NewFloat(f), nil
 }
 
 func convertToReal(v Value) (Value, error) {
-	return NewReal(float32(v.AsFloat())), nil
+	f, err := floatValue(v, TypeReal)
+	if err != nil {
+		return Value{}, err
+	}
+	return NewReal(float32(f)), nil
+}
+
+// conversionFailed returns the error for a string that does not hold a
+// value of the target type.
+func conversionFailed(v Value, target DataType) error {
+	return fmt.Errorf("conversion failed when converting the %s value '%s' to data type %s", v.Type, v.stringVal, target)
+}
+
+// integerValue returns v as an integer. Strings must hold a whole number,
+// as in SQL Server, except that the empty string converts to 0.
+func integerValue(v Value, target DataType) (int64, error) {
+	if !v.Type.IsString() {
+		return v.AsInt(), nil
+	}
+	s := strings.TrimSpace(v.stringVal)
+	if s == "" {
+		return 0, nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("arithmetic overflow converting to %s", target)
+		}
+		return 0, conversionFailed(v, target)
+	}
+	return i, nil
+}
+
+// decimalValue returns v as a decimal. Strings must hold a number; money
+// also accepts a leading currency symbol and thousands separators.
+func decimalValue(v Value, target DataType) (decimal.Decimal, error) {
+	if !v.Type.IsString() {
+		return v.AsDecimal(), nil
+	}
+	s := strings.TrimSpace(v.stringVal)
+	if target == TypeMoney || target == TypeSmallMoney {
+		s = strings.TrimLeft(s, "$£€¥")
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	d, err := decimal.NewFromString(s)
+	if err != nil || strings.ContainsAny(s, "eE") {
+		return decimal.Zero, conversionFailed(v, target)
+	}
+	return d, nil
+}
+
+// floatValue returns v as a float. Strings must hold a number, except that
+// the empty string converts to 0.
+func floatValue(v Value, target DataType) (float64, error) {
+	if !v.Type.IsString() {
+		return v.AsFloat(), nil
+	}
+	s := strings.TrimSpace(v.stringVal)
+	if s == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, conversionFailed(v, target)
+	}
+	return f, nil
 }
 
 func convertToDate(v Value, style int) (Value, error) {
@@ -175,6 +362,12 @@ func convertToTime(v Value, style int) (Value, error) {
 	case TypeDateTime, TypeDateTime2, TypeSmallDateTime:
 		return NewTime(v.timeVal), nil
 	case TypeVarChar, TypeNVarChar, TypeChar, TypeNChar:
+		// A time of day on its own
+		for _, format := range []string{"15:04:05.999999999", "15:04", "3:04PM", "3:04 PM", "3:04:05PM", "3:04:05 PM"} {
+			if t, err := time.Parse(format, strings.TrimSpace(v.stringVal)); err == nil {
+				return NewTime(t), nil
+			}
+		}
 		t, err := parseDateTimeWithStyle(v.stringVal, style)
 		if err != nil {
 			return Value{}, err
@@ -218,7 +411,7 @@ func convertToSmallDateTime(v Value, style int) (Value, error) {
 }
 
 func convertToChar(v Value, maxLen int, style int) (Value, error) {
-	s, err := formatValueAsString(v, style)
+	s, err := characterData(v, false, style)
 	if err != nil {
 		return Value{}, err
 	}
@@ -226,7 +419,7 @@ func convertToChar(v Value, maxLen int, style int) (Value, error) {
 }
 
 func convertToVarChar(v Value, maxLen int, style int) (Value, error) {
-	s, err := formatValueAsString(v, style)
+	s, err := characterData(v, false, style)
 	if err != nil {
 		return Value{}, err
 	}
@@ -234,7 +427,7 @@ func convertToVarChar(v Value, maxLen int, style int) (Value, error) {
 }
 
 func convertToNChar(v Value, maxLen int, style int) (Value, error) {
-	s, err := formatValueAsString(v, style)
+	s, err := characterData(v, true, style)
 	if err != nil {
 		return Value{}, err
 	}
@@ -242,14 +435,23 @@ func convertToNChar(v Value, maxLen int, style int) (Value, error) {
 }
 
 func convertToNVarChar(v Value, maxLen int, style int) (Value, error) {
-	s, err := formatValueAsString(v, style)
+	s, err := characterData(v, true, style)
 	if err != nil {
 		return Value{}, err
 	}
 	return NewNVarChar(s, maxLen), nil
 }
 
-func convertToBinary(v Value, maxLen int) (Value, error) {
+// characterData formats v as character data for CAST and CONVERT, with
+// binary data decoded or written in hexadecimal by style.
+func characterData(v Value, national bool, style int) (string, error) {
+	if v.Type == TypeBinary || v.Type == TypeVarBinary {
+		return binaryToString(v.bytesVal, national, style)
+	}
+	return formatValueAsString(v, style)
+}
+
+func convertToBinary(v Value, maxLen int, style int) (Value, error) {
 	switch v.Type {
 	case TypeBinary, TypeVarBinary:
 		b := v.bytesVal
@@ -257,20 +459,18 @@ func convertToBinary(v Value, maxLen int) (Value, error) {
 			b = b[:maxLen]
 		}
 		return NewBinary(b), nil
-	case TypeVarChar, TypeNVarChar, TypeChar, TypeNChar:
-		b := []byte(v.stringVal)
+	case TypeVarChar, TypeNVarChar, TypeChar, TypeNChar, TypeText, TypeNText:
+		b, err := stringToBinary(v, style)
+		if err != nil {
+			return Value{}, err
+		}
 		if maxLen > 0 && len(b) > maxLen {
 			b = b[:maxLen]
 		}
 		return NewBinary(b), nil
-	case TypeInt, TypeBigInt:
-		// Convert integer to binary representation
-		b := make([]byte, 8)
-		val := v.intVal
-		for i := 7; i >= 0; i-- {
-			b[i] = byte(val & 0xFF)
-			val >>= 8
-		}
+	case TypeBit, TypeTinyInt, TypeSmallInt, TypeInt, TypeBigInt:
+		// Big-endian, as wide as the type; truncation keeps the low bytes
+		b := valueBytes(v)
 		if maxLen > 0 && len(b) > maxLen {
 			b = b[len(b)-maxLen:]
 		}
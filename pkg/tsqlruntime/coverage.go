@@ -0,0 +1,215 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// Executions can record the coverage of the procedures they run, for aul
+// test: how many times each statement of a procedure ran, by the line it
+// begins on in the procedure's source, and how many times each way an IF
+// or WHILE can go was taken. Procedures called from others are covered
+// too, by the name they are called by, schema-qualified; the statements
+// of dynamic SQL, whose lines are not the procedure's, and of ad-hoc
+// batches are not. CoverablePoints lists what a procedure has to cover.
+//
+// Unlike a trace, coverage keeps counts rather than events, so that a
+// long loop does not outgrow it, and it may be shared by executions
+// running at once.
+
+// Branches of an IF and a WHILE, as traced.
+const (
+	BranchThen = "THEN" // IF's condition held
+	BranchElse = "ELSE" // It did not, and the IF has an ELSE
+	BranchNone = "NONE" // It did not, and the IF has no ELSE
+	BranchLoop = "LOOP" // WHILE's condition held, and its body ran
+	BranchExit = "EXIT" // It did not, and the loop ended
+)
+
+// BranchPoint is one way an IF or WHILE, beginning on Line, can go.
+type BranchPoint struct {
+	Line   int
+	Branch string
+}
+
+// ProcedureCoverage counts what ran of one procedure.
+type ProcedureCoverage struct {
+	Lines    map[int]int64         // Executions of the statements beginning on each line
+	Branches map[BranchPoint]int64 // Times each branch was taken
+}
+
+// Coverage counts what ran of each procedure, by CoverageName.
+type Coverage struct {
+	mu         sync.Mutex
+	procedures map[string]*ProcedureCoverage
+}
+
+// NewCoverage returns coverage in which nothing has run.
+func NewCoverage() *Coverage {
+	return &Coverage{procedures: make(map[string]*ProcedureCoverage)}
+}
+
+// SetCoverage sets the coverage the execution counts what it runs in;
+// nil turns counting off.
+func (i *Interpreter) SetCoverage(c *Coverage) {
+	i.ctx.Coverage = c
+}
+
+// Procedure returns a copy of what ran of the procedure name, which is
+// empty if none of it did.
+func (c *Coverage) Procedure(name string) ProcedureCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pc := ProcedureCoverage{Lines: make(map[int]int64), Branches: make(map[BranchPoint]int64)}
+	if p := c.procedures[CoverageName(name)]; p != nil {
+		for line, n := range p.Lines {
+			pc.Lines[line] = n
+		}
+		for b, n := range p.Branches {
+			pc.Branches[b] = n
+		}
+	}
+	return pc
+}
+
+// Procedures returns the names of the procedures some of which ran,
+// sorted.
+func (c *Coverage) Procedures() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.procedures))
+	for name := range c.procedures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// add counts a run of line, or of the branch taken there, in procedure.
+func (c *Coverage) add(procedure string, line int, branch string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.procedures[procedure]
+	if p == nil {
+		p = &ProcedureCoverage{Lines: make(map[int]int64), Branches: make(map[BranchPoint]int64)}
+		c.procedures[procedure] = p
+	}
+	if branch == "" {
+		p.Lines[line]++
+	} else {
+		p.Branches[BranchPoint{line, branch}]++
+	}
+}
+
+// CoverageName returns the name coverage keeps a procedure under: its
+// schema and name, lower-cased and without brackets, in dbo if name has
+// no schema. A database or server name is dropped.
+func CoverageName(name string) string {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		parts = parts[len(parts)-2:]
+	}
+	for j, part := range parts {
+		parts[j] = strings.ToLower(unbracket(strings.TrimSpace(part)))
+	}
+	if len(parts) == 1 || parts[0] == "" {
+		return "dbo." + parts[len(parts)-1]
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// cover counts stmt, or the branch it took, in the coverage of the
+// procedure i runs.
+func (i *Interpreter) cover(stmt ast.Statement, branch string) {
+	n := len(i.callChain)
+	if n == 0 || dynamicScopes[i.callChain[n-1]] || !coverable(stmt) && branch == "" {
+		return
+	}
+	if tok, ok := statementToken(stmt); ok && tok.Line > 0 {
+		i.ctx.Coverage.add(CoverageName(i.callChain[n-1]), tok.Line, branch)
+	}
+}
+
+// coverBranch counts the branch an IF or WHILE took, if coverage is
+// counted.
+func (i *Interpreter) coverBranch(stmt ast.Statement, branch string) {
+	if i.ctx.Coverage != nil {
+		i.cover(stmt, branch)
+	}
+}
+
+// coverable reports whether stmt counts as a statement of its own, rather
+// than only holding others.
+func coverable(stmt ast.Statement) bool {
+	switch stmt.(type) {
+	case *ast.BeginEndBlock, *ast.TryCatchStatement, *ast.CreateProcedureStatement:
+		return false
+	}
+	return true
+}
+
+// CoverablePoints returns the lines the statements of a procedure's source
+// begin on, and the branches of its IFs and WHILEs, each sorted.
+func CoverablePoints(source string) (lines []int, branches []BranchPoint, err error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, nil, fmt.Errorf("parse error: %s", p.Errors()[0])
+	}
+
+	seen := make(map[int]bool)
+	var visit func(stmt ast.Statement)
+	visit = func(stmt ast.Statement) {
+		if stmt == nil {
+			return
+		}
+		line := 0
+		if tok, ok := statementToken(stmt); ok {
+			line = tok.Line
+		}
+		if coverable(stmt) && line > 0 && !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+		switch s := stmt.(type) {
+		case *ast.CreateProcedureStatement:
+			if s.Body != nil {
+				visit(s.Body)
+			}
+		case *ast.BeginEndBlock:
+			for _, inner := range s.Statements {
+				visit(inner)
+			}
+		case *ast.TryCatchStatement:
+			if s.TryBlock != nil {
+				visit(s.TryBlock)
+			}
+			if s.CatchBlock != nil {
+				visit(s.CatchBlock)
+			}
+		case *ast.IfStatement:
+			otherwise := BranchNone
+			if s.Alternative != nil {
+				otherwise = BranchElse
+			}
+			branches = append(branches, BranchPoint{line, BranchThen}, BranchPoint{line, otherwise})
+			visit(s.Consequence)
+			visit(s.Alternative)
+		case *ast.WhileStatement:
+			branches = append(branches, BranchPoint{line, BranchLoop}, BranchPoint{line, BranchExit})
+			visit(s.Body)
+		}
+	}
+	for _, stmt := range program.Statements {
+		visit(stmt)
+	}
+	sort.Ints(lines)
+	sort.SliceStable(branches, func(a, b int) bool { return branches[a].Line < branches[b].Line })
+	return lines, branches, nil
+}
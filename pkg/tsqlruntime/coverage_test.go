@@ -0,0 +1,101 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// restockSource is a procedure whose statements begin on known lines.
+const restockSource = `CREATE PROCEDURE dbo.Restock @id INT, @qty INT = 1
+AS
+BEGIN
+	DECLARE @n INT = 0
+	WHILE @n < @qty
+		SET @n = @n + 1
+	IF @qty > 0
+		UPDATE orders SET qty = qty + @qty WHERE id = @id
+	ELSE
+		PRINT 'nothing to do'
+	BEGIN TRY
+		DELETE FROM missing
+	END TRY
+	BEGIN CATCH
+		EXEC ('SELECT 1')
+	END CATCH
+END`
+
+func TestCoverablePoints(t *testing.T) {
+	lines, branches, err := CoverablePoints(restockSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{4, 5, 6, 7, 8, 10, 12, 15}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %v, want %v", lines, want)
+	}
+	want := []BranchPoint{{5, BranchLoop}, {5, BranchExit}, {7, BranchThen}, {7, BranchElse}}
+	if !reflect.DeepEqual(branches, want) {
+		t.Errorf("branches %v, want %v", branches, want)
+	}
+
+	if _, _, err := CoverablePoints("CREATE PROCEDURE dbo.Broken AS SELECT FROM"); err == nil {
+		t.Error("no error for a procedure that does not parse")
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	interp := sysprocSetup(t)
+	resolver := interp.resolver.(*renamingResolver)
+	resolver.AddProcedure("dbo.Restock", restockSource, nil)
+	coverage := NewCoverage()
+	interp.SetCoverage(coverage)
+
+	// The ad-hoc batch is not covered, the procedure it calls is, under
+	// the name it is called by, schema-qualified
+	_, err := interp.Execute(context.Background(), `
+		DECLARE @n INT = 2
+		EXEC Restock @id = 1, @qty = @n
+		EXEC dbo.Restock 1, 0
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := coverage.Procedures(); !reflect.DeepEqual(got, []string{"dbo.restock"}) {
+		t.Fatalf("procedures %v", got)
+	}
+
+	pc := coverage.Procedure("master.dbo.Restock")
+	wantLines := map[int]int64{4: 2, 5: 2, 6: 2, 7: 2, 8: 1, 10: 1, 12: 2, 15: 2}
+	if !reflect.DeepEqual(pc.Lines, wantLines) {
+		t.Errorf("lines %v, want %v", pc.Lines, wantLines)
+	}
+	wantBranches := map[BranchPoint]int64{
+		{5, BranchLoop}: 2, {5, BranchExit}: 2,
+		{7, BranchThen}: 1, {7, BranchElse}: 1,
+	}
+	if !reflect.DeepEqual(pc.Branches, wantBranches) {
+		t.Errorf("branches %v, want %v", pc.Branches, wantBranches)
+	}
+
+	// The copy returned is the caller's
+	pc.Lines[4] = 100
+	if n := coverage.Procedure("dbo.restock").Lines[4]; n != 2 {
+		t.Errorf("line 4 ran %d times after the copy was changed", n)
+	}
+	if pc := coverage.Procedure("dbo.Other"); len(pc.Lines) != 0 || len(pc.Branches) != 0 {
+		t.Errorf("coverage of a procedure that did not run: %+v", pc)
+	}
+}
+
+func TestCoverageName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Restock":             "dbo.restock",
+		"[Sales].[Restock]":   "sales.restock",
+		"master.dbo.Restock":  "dbo.restock",
+		"srv.master..Restock": "dbo.restock",
+	} {
+		if got := CoverageName(name); got != want {
+			t.Errorf("CoverageName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package tsqlruntime
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// fnHashBytes implements HASHBYTES(algorithm, input).
+func fnHashBytes(args []Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("HASHBYTES requires 2 arguments")
+	}
+	if args[0].IsNull || args[1].IsNull {
+		return Null(TypeVarBinary), nil
+	}
+
+	data := valueBytes(args[1])
+	var hash []byte
+	switch algorithm := strings.ToUpper(args[0].AsString()); algorithm {
+	case "MD5":
+		h := md5.Sum(data)
+		hash = h[:]
+	case "SHA", "SHA1":
+		h := sha1.Sum(data)
+		hash = h[:]
+	case "SHA2_256":
+		h := sha256.Sum256(data)
+		hash = h[:]
+	case "SHA2_512":
+		h := sha512.Sum512(data)
+		hash = h[:]
+	case "MD2", "MD4":
+		return Value{}, NewSQLError(ErrNotSupported, fmt.Sprintf("HASHBYTES algorithm %s is not supported; use SHA2_256 or SHA2_512", algorithm))
+	default:
+		return Value{}, NewSQLError(ErrInvalidParameter, fmt.Sprintf("Invalid algorithm %s for HASHBYTES", args[0].AsString()))
+	}
+	return NewVarBinary(hash, 8000), nil
+}
+
+// checksumNull is what a NULL contributes to a checksum.
+const checksumNull = 0x7FFFFFFF
+
+// checksumOf folds b into a checksum a byte at a time, rotating by four
+// bits and XORing, as BINARY_CHECKSUM does.
+func checksumOf(h uint32, b []byte) uint32 {
+	for _, c := range b {
+		h = bits.RotateLeft32(h, 4) ^ uint32(c)
+	}
+	return h
+}
+
+// checksum combines the checksums of args. With fold set, strings are
+// compared as the default collation compares them, ignoring case, so
+// that values equal to each other have the same checksum; trailing
+// spaces are ignored either way.
+func checksum(args []Value, fold bool) Value {
+	var h uint32
+	for i, arg := range args {
+		v := uint32(checksumNull)
+		if !arg.IsNull {
+			switch arg.Type {
+			case TypeVarChar, TypeChar, TypeText, TypeNVarChar, TypeNChar, TypeNText:
+				s := strings.TrimRight(arg.stringVal, " ")
+				if fold {
+					s = strings.ToUpper(s)
+				}
+				v = checksumOf(0, valueBytes(Value{Type: arg.Type, stringVal: s}))
+			default:
+				v = checksumOf(0, valueBytes(arg))
+			}
+		}
+		if i == 0 {
+			h = v
+		} else {
+			h = bits.RotateLeft32(h, 4) ^ v
+		}
+	}
+	return NewInt(int64(int32(h)))
+}
+
+// fnChecksum implements CHECKSUM(expr [, ...]).
+func fnChecksum(args []Value) (Value, error) {
+	if len(args) == 0 {
+		return Value{}, fmt.Errorf("CHECKSUM requires at least 1 argument")
+	}
+	return checksum(args, true), nil
+}
+
+// fnBinaryChecksum implements BINARY_CHECKSUM(expr [, ...]), which tells
+// apart strings differing only in case.
+func fnBinaryChecksum(args []Value) (Value, error) {
+	if len(args) == 0 {
+		return Value{}, fmt.Errorf("BINARY_CHECKSUM requires at least 1 argument")
+	}
+	return checksum(args, false), nil
+}
+
+// Passphrase encryption follows SQL Server's layout. A blob is a 4-byte
+// version, the IV and the ciphertext, which decrypts to a header (magic
+// number, whether an authenticator's hash follows, the length of the
+// cleartext), the hash if any, then the cleartext. Version 2, written
+// since SQL Server 2017 and by aul, is AES-256 keyed by the SHA-256 of
+// the passphrase; version 1 is Triple DES keyed by its SHA-1, and is
+// only read.
+const (
+	passphraseMagic  = 0xBAADF00D
+	passphraseHeader = 8
+	maxCleartext     = 8000
+)
+
+// passphraseCipher returns the block cipher of a blob version for
+// passphrase.
+func passphraseCipher(version uint32, passphrase []byte) (cipher.Block, error) {
+	switch version {
+	case 1:
+		h := sha1.Sum(passphrase)
+		key := append(h[:16:16], h[:8]...) // Two keys: k1, k2, k1
+		return des.NewTripleDESCipher(key)
+	case 2:
+		h := sha256.Sum256(passphrase)
+		return aes.NewCipher(h[:])
+	}
+	return nil, fmt.Errorf("unknown version %d", version)
+}
+
+// passphraseArgs reads (passphrase, data [, add_authenticator,
+// authenticator]), returning false when the result is NULL.
+func passphraseArgs(name string, args []Value) (passphrase, data, authenticator []byte, ok bool, err error) {
+	if len(args) != 2 && len(args) != 4 {
+		return nil, nil, nil, false, fmt.Errorf("%s requires 2 or 4 arguments", name)
+	}
+	if args[0].IsNull || args[1].IsNull {
+		return nil, nil, nil, false, nil
+	}
+	if len(args) == 4 && !args[2].IsNull && args[2].AsInt() != 0 {
+		if args[3].IsNull {
+			return nil, nil, nil, false, nil
+		}
+		h := sha1.Sum(valueBytes(args[3]))
+		authenticator = h[:]
+	}
+	return valueBytes(args[0]), valueBytes(args[1]), authenticator, true, nil
+}
+
+// fnEncryptByPassphrase implements ENCRYPTBYPASSPHRASE(passphrase,
+// cleartext [, add_authenticator, authenticator]).
+func fnEncryptByPassphrase(args []Value) (Value, error) {
+	passphrase, clear, authenticator, ok, err := passphraseArgs("ENCRYPTBYPASSPHRASE", args)
+	if err != nil || !ok || len(clear) > maxCleartext {
+		return Null(TypeVarBinary), err
+	}
+
+	block, err := passphraseCipher(2, passphrase)
+	if err != nil {
+		return Value{}, err
+	}
+	plain := binary.LittleEndian.AppendUint32(nil, passphraseMagic)
+	plain = binary.LittleEndian.AppendUint16(plain, uint16(len(authenticator)/sha1.Size))
+	plain = binary.LittleEndian.AppendUint16(plain, uint16(len(clear)))
+	plain = append(plain, authenticator...)
+	plain = append(plain, clear...)
+	pad := block.BlockSize() - len(plain)%block.BlockSize()
+	plain = append(plain, bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+	blob := binary.LittleEndian.AppendUint32(nil, 2)
+	iv := make([]byte, block.BlockSize())
+	if _, err := rand.Read(iv); err != nil {
+		return Value{}, err
+	}
+	blob = append(blob, iv...)
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, plain)
+	return NewVarBinary(append(blob, out...), 8000), nil
+}
+
+// fnDecryptByPassphrase implements DECRYPTBYPASSPHRASE(passphrase,
+// ciphertext [, add_authenticator, authenticator]). As in SQL Server, a
+// wrong passphrase or authenticator, or data that is not such a blob,
+// gives NULL rather than an error.
+func fnDecryptByPassphrase(args []Value) (Value, error) {
+	passphrase, blob, authenticator, ok, err := passphraseArgs("DECRYPTBYPASSPHRASE", args)
+	if err != nil || !ok || len(blob) < 4 {
+		return Null(TypeVarBinary), err
+	}
+
+	block, err := passphraseCipher(binary.LittleEndian.Uint32(blob), passphrase)
+	if err != nil {
+		return Null(TypeVarBinary), nil
+	}
+	size := block.BlockSize()
+	blob = blob[4:]
+	if len(blob) < 2*size || len(blob)%size != 0 {
+		return Null(TypeVarBinary), nil
+	}
+	plain := make([]byte, len(blob)-size)
+	cipher.NewCBCDecrypter(block, blob[:size]).CryptBlocks(plain, blob[size:])
+
+	if len(plain) < passphraseHeader || binary.LittleEndian.Uint32(plain) != passphraseMagic {
+		return Null(TypeVarBinary), nil
+	}
+	hashes := int(binary.LittleEndian.Uint16(plain[4:]))
+	length := int(binary.LittleEndian.Uint16(plain[6:]))
+	body := plain[passphraseHeader:]
+	if hashes > 1 || len(body) < hashes*sha1.Size+length {
+		return Null(TypeVarBinary), nil
+	}
+	if hashes == 1 && !bytes.Equal(body[:sha1.Size], authenticator) {
+		return Null(TypeVarBinary), nil
+	}
+	if hashes == 0 && authenticator != nil {
+		return Null(TypeVarBinary), nil
+	}
+	body = body[hashes*sha1.Size:]
+	return NewVarBinary(bytes.Clone(body[:length]), 8000), nil
+}
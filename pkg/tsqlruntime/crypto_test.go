@@ -0,0 +1,111 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCrypto_Functions(t *testing.T) {
+	ctx := context.Background()
+	interp := scopeSetup(t, newMockResolver())
+
+	for _, tt := range []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"sha256", "SELECT HASHBYTES('SHA2_256', 'abc')",
+			[]string{"0xBA7816BF8F01CFEA414140DE5DAE2223B00361A396177A9CB410FF61F20015AD"}},
+		{"md5", "SELECT HASHBYTES('md5', '')", []string{"0xD41D8CD98F00B204E9800998ECF8427E"}},
+		// nvarchar hashes its UTF-16 bytes
+		{"national", "SELECT CASE WHEN HASHBYTES('SHA1', N'abc') = HASHBYTES('SHA1', 'abc') THEN 1 ELSE 0 END, " +
+			"DATALENGTH(HASHBYTES('SHA2_512', N'abc'))", []string{"0 64"}},
+		{"null", "SELECT HASHBYTES('SHA2_256', NULL)", []string{"NULL"}},
+		{"literal", "SELECT 0x616263, CAST(0x616263 AS VARCHAR(10)), CAST(CAST('abc' AS VARBINARY(10)) AS VARCHAR(10))",
+			[]string{"0x616263 abc abc"}},
+		{"styles", "SELECT CONVERT(VARCHAR(10), 0x0AFF, 1), CONVERT(VARCHAR(10), 0x0AFF, 2), CONVERT(VARBINARY(4), '0x0aff', 1)",
+			[]string{"0x0AFF 0AFF 0x0AFF"}},
+		{"checksum", "SELECT CASE WHEN CHECKSUM('abc') = CHECKSUM('ABC ') THEN 1 ELSE 0 END, " +
+			"CASE WHEN BINARY_CHECKSUM('abc') = BINARY_CHECKSUM('ABC') THEN 1 ELSE 0 END, " +
+			"CASE WHEN CHECKSUM(1, 'x') = CHECKSUM('x', 1) THEN 1 ELSE 0 END", []string{"1 0 0"}},
+		{"round trip", "DECLARE @e VARBINARY(256) = ENCRYPTBYPASSPHRASE('secret', 'card 4111'); " +
+			"SELECT CAST(DECRYPTBYPASSPHRASE('secret', @e) AS VARCHAR(20)), DECRYPTBYPASSPHRASE('wrong', @e)",
+			[]string{"card 4111 NULL"}},
+		{"authenticator", "DECLARE @e VARBINARY(256) = ENCRYPTBYPASSPHRASE('secret', N'pin', 1, 'user 7'); " +
+			"SELECT CAST(DECRYPTBYPASSPHRASE('secret', @e, 1, 'user 7') AS NVARCHAR(20)), " +
+			"DECRYPTBYPASSPHRASE('secret', @e, 1, 'user 8'), DECRYPTBYPASSPHRASE('secret', @e)",
+			[]string{"pin NULL NULL"}},
+		{"not a blob", "SELECT DECRYPTBYPASSPHRASE('secret', 0x0102)", []string{"NULL"}},
+	} {
+		result, err := interp.Execute(ctx, tt.sql, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := lastRows(result); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Two encryptions of the same text differ, by their IV
+	result, err := interp.Execute(ctx, "SELECT ENCRYPTBYPASSPHRASE('k', 'x'), ENCRYPTBYPASSPHRASE('k', 'x')", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := strings.Fields(lastRows(result)[0])
+	if row[0] == row[1] || !strings.HasPrefix(row[0], "0x02000000") {
+		t.Errorf("encryptions %q: want distinct version 2 blobs", row)
+	}
+
+	if _, err := interp.Execute(ctx, "SELECT HASHBYTES('MD4', 'abc')", nil); err == nil {
+		t.Error("MD4: want an error")
+	}
+}
+
+func TestCrypto_Backend(t *testing.T) {
+	ctx := context.Background()
+	interp := scopeSetup(t, newMockResolver())
+	for _, sql := range []string{
+		"CREATE TABLE users (name VARCHAR(20), pw VARBINARY(32))",
+		"DECLARE @h VARBINARY(32) = HASHBYTES('SHA2_256', 'hunter2'); INSERT INTO users VALUES ('ann', @h)",
+		"INSERT INTO users VALUES ('bob', 0x00FF)",
+	} {
+		if _, err := interp.Execute(ctx, sql, nil); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	result, err := interp.Execute(ctx, "DECLARE @h VARBINARY(32) = HASHBYTES('SHA2_256', 'hunter2'); "+
+		"SELECT name FROM users WHERE pw = @h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"ann"}) {
+		t.Errorf("login: %q, want [ann]", got)
+	}
+	result, err = interp.Execute(ctx, "SELECT pw FROM users WHERE pw = 0x00FF", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"0x00FF"}) {
+		t.Errorf("literal: %q, want [0x00FF]", got)
+	}
+}
+
+func TestCrypto_RewriteBinaryLiteral(t *testing.T) {
+	for _, tt := range []struct {
+		rewriter ASTRewriter
+		want     string
+	}{
+		{NewSQLiteRewriter(), "X'00FF'"},
+		{NewMySQLRewriter(), "X'00FF'"},
+		{NewPostgresRewriter(), `'\x00FF'::bytea`},
+	} {
+		stmt := parseSQL(t, "SELECT id FROM t WHERE data = 0x00FF")
+		if got := tt.rewriter.RewriteStatement(stmt).String(); !strings.Contains(got, tt.want) {
+			t.Errorf("%T: %s, want %s", tt.rewriter, got, tt.want)
+		}
+	}
+}
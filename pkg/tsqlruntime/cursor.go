@@ -53,6 +53,8 @@ type Cursor struct {
 	LockType    CursorLockType
 	IsGlobal    bool
 	mu          sync.RWMutex
+
+	bytes int64 // Snapshot size charged to the memory budget
 }
 
 // CursorManager manages cursors for a session
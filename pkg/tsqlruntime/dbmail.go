@@ -0,0 +1,314 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// sp_send_dbmail queues an email, as Database Mail does:
+//
+//	EXEC msdb.dbo.sp_send_dbmail
+//	    @profile_name = 'alerts',
+//	    @recipients = 'dba@example.com; ops@example.com',
+//	    @subject = 'Nightly load failed',
+//	    @body = @message,
+//	    @query = 'SELECT * FROM dbo.LoadErrors',
+//	    @attach_query_result_as_file = 1
+//
+// The message is handed to the MailQueue set with SetMailQueue, which
+// sends it in the background; @mailitem_id receives its ID. The procedure
+// runs in aul on every dialect.
+
+// MailMessage is an email queued by sp_send_dbmail.
+type MailMessage struct {
+	Profile     string // "" for the default profile
+	From        string // "" for the profile's address
+	ReplyTo     string
+	To          []string
+	CC          []string
+	BCC         []string
+	Subject     string
+	Body        string
+	BodyFormat  string // TEXT or HTML
+	Importance  string // Low, Normal or High
+	Sensitivity string // Normal, Personal, Private or Confidential
+	Attachments []MailAttachment
+	Query       string // @query, if any
+}
+
+// MailAttachment is a file attached to a MailMessage.
+type MailAttachment struct {
+	Name string
+	Data []byte
+}
+
+// MailQueue queues the email sp_send_dbmail sends.
+type MailQueue interface {
+	// HasProfile reports whether a mail profile exists; "" names the
+	// default profile.
+	HasProfile(name string) bool
+	// QueueMail queues msg and returns its mail item ID.
+	QueueMail(ctx context.Context, msg MailMessage) (int64, error)
+}
+
+// SetMailQueue sets where sp_send_dbmail queues email. Without one it
+// fails, as with Database Mail stopped.
+func (i *Interpreter) SetMailQueue(queue MailQueue) {
+	i.ctx.Mail = queue
+}
+
+// Database Mail error numbers
+const (
+	ErrMailProfileInvalid = 14607
+	ErrMailNoRecipients   = 14624
+	ErrMailStopped        = 14641
+	ErrMailQuery          = 22050
+)
+
+// Defaults of sp_send_dbmail's query options
+const (
+	defaultMailQueryWidth = 256
+	defaultMailQueryFile  = "query_results.txt"
+)
+
+var sendDBMailParams = []string{
+	"@profile_name", "@recipients", "@copy_recipients", "@blind_copy_recipients",
+	"@from_address", "@reply_to", "@subject", "@body", "@body_format",
+	"@importance", "@sensitivity", "@file_attachments", "@query",
+	"@execute_query_database", "@attach_query_result_as_file",
+	"@query_attachment_filename", "@query_result_header", "@query_result_width",
+	"@query_result_separator", "@exclude_query_output", "@append_query_error",
+	"@query_no_truncate", "@query_result_no_padding", "@mailitem_id",
+}
+
+// sp_send_dbmail is registered at init: its handler runs @query, which
+// leads back to systemProcedures through EXEC.
+func init() {
+	systemProcedures["SP_SEND_DBMAIL"] = systemProcedure{
+		params:   sendDBMailParams,
+		run:      (*Interpreter).spSendDBMail,
+		local:    true,
+		external: true,
+	}
+}
+
+// spSendDBMail runs sp_send_dbmail.
+func (i *Interpreter) spSendDBMail(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	const proc = "sp_send_dbmail"
+	invalid := func(param, value string) error {
+		return NewSQLError(ErrInvalidParameter, fmt.Sprintf(
+			"The specified %s value '%s' is not valid for procedure '%s'.", param, value, proc))
+	}
+
+	queue := i.ctx.Mail
+	if queue == nil {
+		return NewSQLError(ErrMailStopped, "Mail not queued. Database Mail is not configured.")
+	}
+	msg := MailMessage{
+		Profile:     argString(args, "@profile_name"),
+		From:        argString(args, "@from_address"),
+		ReplyTo:     argString(args, "@reply_to"),
+		To:          splitRecipients(argString(args, "@recipients")),
+		CC:          splitRecipients(argString(args, "@copy_recipients")),
+		BCC:         splitRecipients(argString(args, "@blind_copy_recipients")),
+		Subject:     argString(args, "@subject"),
+		Body:        argString(args, "@body"),
+		BodyFormat:  strings.ToUpper(argString(args, "@body_format")),
+		Importance:  argString(args, "@importance"),
+		Sensitivity: argString(args, "@sensitivity"),
+		Query:       argString(args, "@query"),
+	}
+	if !queue.HasProfile(msg.Profile) {
+		return NewSQLError(ErrMailProfileInvalid, "profile name is not valid")
+	}
+	if len(msg.To)+len(msg.CC)+len(msg.BCC) == 0 {
+		return NewSQLError(ErrMailNoRecipients, `At least one of the following parameters must be specified. "@recipients, @copy_recipients, @blind_copy_recipients".`)
+	}
+	if msg.Subject == "" {
+		msg.Subject = "SQL Server Message"
+	}
+	switch msg.BodyFormat {
+	case "":
+		msg.BodyFormat = "TEXT"
+	case "TEXT", "HTML":
+	default:
+		return invalid("@body_format", msg.BodyFormat)
+	}
+	if msg.Importance == "" {
+		msg.Importance = "Normal"
+	} else if !containsFold([]string{"Low", "Normal", "High"}, msg.Importance) {
+		return invalid("@importance", msg.Importance)
+	}
+	if msg.Sensitivity == "" {
+		msg.Sensitivity = "Normal"
+	} else if !containsFold([]string{"Normal", "Personal", "Private", "Confidential"}, msg.Sensitivity) {
+		return invalid("@sensitivity", msg.Sensitivity)
+	}
+	if files := argString(args, "@file_attachments"); files != "" {
+		return NewSQLError(ErrInvalidParameter, fmt.Sprintf(
+			"%s: @file_attachments is not supported; attach query results with @attach_query_result_as_file", proc))
+	}
+
+	if msg.Query != "" {
+		if err := i.mailQueryResults(ctx, args, &msg); err != nil {
+			return err
+		}
+	}
+
+	id, err := queue.QueueMail(ctx, msg)
+	if err != nil {
+		return NewSQLError(ErrMailStopped, fmt.Sprintf("Mail not queued: %v", err))
+	}
+	args["@mailitem_id"] = NewInt(id)
+	result.Warnings = append(result.Warnings, "Mail (Id: "+fmt.Sprint(id)+") queued.")
+	return nil
+}
+
+// mailQueryResults runs @query and adds its results to msg, in the body
+// or as an attached file. The query's result sets go into the mail, so
+// they are taken back off the shared execution context, as INSERT...EXEC
+// does.
+func (i *Interpreter) mailQueryResults(ctx context.Context, args map[string]Value, msg *MailMessage) error {
+	if err := i.checkNesting("sp_send_dbmail"); err != nil {
+		return err
+	}
+	mark := len(i.ctx.ResultSets)
+	_, err := i.runScope(ctx, i.newScope("sp_send_dbmail"), msg.Query)
+	captured := append([]ResultSet(nil), i.ctx.ResultSets[mark:]...)
+	i.ctx.ResultSets = i.ctx.ResultSets[:mark]
+	var text string
+	if err != nil {
+		if !argBool(args, "@append_query_error", false) {
+			return NewSQLError(ErrMailQuery, fmt.Sprintf(
+				"Error formatting query, probably invalid parameters: %v", err))
+		}
+		text = err.Error() + "\r\n"
+	} else {
+		width := defaultMailQueryWidth
+		if v, ok := args["@query_result_width"]; ok && !v.IsNull {
+			width = int(v.AsInt())
+			if width < 10 || width > 32767 {
+				return NewSQLError(ErrInvalidParameter, "The specified @query_result_width is not valid. It must be between 10 and 32767.")
+			}
+		}
+		sep := " "
+		if v, ok := args["@query_result_separator"]; ok && !v.IsNull {
+			if sep = v.AsString(); utf8.RuneCountInString(sep) != 1 {
+				return NewSQLError(ErrInvalidParameter, "The @query_result_separator must be a single character.")
+			}
+		}
+		text = formatMailResults(captured, mailResultFormat{
+			header:  argBool(args, "@query_result_header", true),
+			width:   width,
+			sep:     sep,
+			padding: !argBool(args, "@query_result_no_padding", false),
+		})
+	}
+
+	if argBool(args, "@attach_query_result_as_file", false) {
+		name := argString(args, "@query_attachment_filename")
+		if name == "" {
+			name = defaultMailQueryFile
+		}
+		msg.Attachments = append(msg.Attachments, MailAttachment{Name: name, Data: []byte(text)})
+		return nil
+	}
+	if msg.Body != "" {
+		msg.Body += "\r\n"
+	}
+	msg.Body += text
+	return nil
+}
+
+// mailResultFormat controls how query results are written into mail.
+type mailResultFormat struct {
+	header  bool   // Column names and a rule under them
+	width   int    // Longest line; longer lines are cut
+	sep     string // Between columns
+	padding bool   // Pad columns to their widest value
+}
+
+// formatMailResults writes result sets as text, like sqlcmd: each with
+// its column names, rows and a count of rows.
+func formatMailResults(sets []ResultSet, f mailResultFormat) string {
+	var b strings.Builder
+	for n, rs := range sets {
+		if n > 0 {
+			b.WriteString("\r\n")
+		}
+		cells := make([][]string, 0, len(rs.Rows)+2)
+		if f.header {
+			cells = append(cells, rs.Columns, nil)
+		}
+		for _, row := range rs.Rows {
+			line := make([]string, len(row))
+			for c, v := range row {
+				if v.IsNull {
+					line[c] = "NULL"
+				} else {
+					line[c] = v.AsString()
+				}
+			}
+			cells = append(cells, line)
+		}
+
+		widths := make([]int, len(rs.Columns))
+		for _, line := range cells {
+			for c, s := range line {
+				if c < len(widths) {
+					widths[c] = max(widths[c], utf8.RuneCountInString(s))
+				}
+			}
+		}
+		if f.header {
+			rule := make([]string, len(widths))
+			for c, w := range widths {
+				rule[c] = strings.Repeat("-", max(w, 1))
+			}
+			cells[1] = rule
+		}
+
+		for _, line := range cells {
+			var lb strings.Builder
+			for c, s := range line {
+				if c > 0 {
+					lb.WriteString(f.sep)
+				}
+				lb.WriteString(s)
+				if f.padding && c < len(line)-1 {
+					lb.WriteString(strings.Repeat(" ", widths[c]-utf8.RuneCountInString(s)))
+				}
+			}
+			out := lb.String()
+			if utf8.RuneCountInString(out) > f.width {
+				out = string([]rune(out)[:f.width])
+			}
+			b.WriteString(strings.TrimRight(out, " "))
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "\r\n(%d rows affected)\r\n", len(rs.Rows))
+	}
+	return b.String()
+}
+
+// splitRecipients splits a semicolon-separated list of addresses.
+func splitRecipients(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ";") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// argBool returns the bit value of args[name], or def if it is missing
+// or NULL.
+func argBool(args map[string]Value, name string, def bool) bool {
+	if v, ok := args[name]; ok && !v.IsNull {
+		return v.AsBool()
+	}
+	return def
+}
@@ -0,0 +1,140 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeMailQueue records queued mail; profiles lists the valid profiles.
+type fakeMailQueue struct {
+	profiles []string
+	queued   []MailMessage
+	err      error
+}
+
+func (q *fakeMailQueue) HasProfile(name string) bool {
+	return name == "" || containsFold(q.profiles, name)
+}
+
+func (q *fakeMailQueue) QueueMail(ctx context.Context, msg MailMessage) (int64, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	q.queued = append(q.queued, msg)
+	return int64(len(q.queued)), nil
+}
+
+func TestSpSendDBMail(t *testing.T) {
+	interp := sysprocSetup(t)
+	queue := &fakeMailQueue{profiles: []string{"alerts"}}
+	interp.SetMailQueue(queue)
+	ctx := context.Background()
+
+	result, err := interp.Execute(ctx, `
+		DECLARE @id INT
+		EXEC msdb.dbo.sp_send_dbmail
+			@profile_name = 'alerts',
+			@recipients = 'dba@example.com; ops@example.com;',
+			@blind_copy_recipients = 'audit@example.com',
+			@subject = 'Orders',
+			@body = 'Open orders:',
+			@importance = 'high',
+			@query = 'SELECT id, qty FROM orders',
+			@mailitem_id = @id OUTPUT
+		SELECT @id AS id
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The query's results are mailed, not returned
+	if len(result.ResultSets) != 1 || result.ResultSets[0].Rows[0][0].AsString() != "1" {
+		t.Errorf("result sets: %+v", result.ResultSets)
+	}
+	if len(result.Warnings) == 0 || result.Warnings[0] != "Mail (Id: 1) queued." {
+		t.Errorf("warnings: %v", result.Warnings)
+	}
+	msg := queue.queued[0]
+	if msg.Profile != "alerts" || strings.Join(msg.To, ",") != "dba@example.com,ops@example.com" ||
+		len(msg.BCC) != 1 || msg.BodyFormat != "TEXT" || msg.Importance != "high" || msg.Sensitivity != "Normal" {
+		t.Errorf("message: %+v", msg)
+	}
+	wantBody := "Open orders:\r\nid qty\r\n-- ---\r\n1  5\r\n\r\n(1 rows affected)\r\n"
+	if msg.Body != wantBody {
+		t.Errorf("body %q, want %q", msg.Body, wantBody)
+	}
+
+	// Query results as an attached file, without a header
+	_, err = interp.Execute(ctx, `
+		EXEC sp_send_dbmail @recipients = 'dba@example.com', @query = 'SELECT qty FROM orders',
+			@attach_query_result_as_file = 1, @query_attachment_filename = 'qty.csv',
+			@query_result_header = 0, @query_result_separator = ','
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg = queue.queued[1]
+	if msg.Subject != "SQL Server Message" || msg.Body != "" || len(msg.Attachments) != 1 ||
+		msg.Attachments[0].Name != "qty.csv" || string(msg.Attachments[0].Data) != "5\r\n\r\n(1 rows affected)\r\n" {
+		t.Errorf("message: %+v", msg)
+	}
+
+	// A failing query goes into the mail with @append_query_error
+	_, err = interp.Execute(ctx, `
+		EXEC sp_send_dbmail @recipients = 'dba@example.com', @query = 'SELECT * FROM missing', @append_query_error = 1
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg = queue.queued[2]; !strings.Contains(msg.Body, "missing") {
+		t.Errorf("body %q", msg.Body)
+	}
+
+	refused := []struct {
+		sql    string
+		number int
+	}{
+		{`EXEC sp_send_dbmail @profile_name = 'nope', @recipients = 'a@example.com'`, ErrMailProfileInvalid},
+		{`EXEC sp_send_dbmail @subject = 'no one'`, ErrMailNoRecipients},
+		{`EXEC sp_send_dbmail @recipients = 'a@example.com', @body_format = 'RTF'`, ErrInvalidParameter},
+		{`EXEC sp_send_dbmail @recipients = 'a@example.com', @importance = 'urgent'`, ErrInvalidParameter},
+		{`EXEC sp_send_dbmail @recipients = 'a@example.com', @file_attachments = 'C:\log.txt'`, ErrInvalidParameter},
+		{`EXEC sp_send_dbmail @recipients = 'a@example.com', @query = 'SELECT * FROM missing'`, ErrMailQuery},
+		{`EXEC sp_send_dbmail @recipients = 'a@example.com', @query = 'SELECT 1', @query_result_width = 5`, ErrInvalidParameter},
+		{`EXEC sp_send_dbmail @recipients = 'a@example.com', @query = 'SELECT 1', @query_result_separator = ', '`, ErrInvalidParameter},
+	}
+	for _, tc := range refused {
+		_, err := interp.Execute(ctx, tc.sql, nil)
+		wantSQLError(t, tc.sql, err, tc.number)
+	}
+
+	queue.err = fmt.Errorf("queue full")
+	sql := `EXEC sp_send_dbmail @recipients = 'a@example.com'`
+	_, err = interp.Execute(ctx, sql, nil)
+	wantSQLError(t, sql, err, ErrMailStopped)
+
+	bare := sysprocSetup(t)
+	_, err = bare.Execute(ctx, sql, nil)
+	wantSQLError(t, sql, err, ErrMailStopped)
+}
+
+func TestFormatMailResults(t *testing.T) {
+	sets := []ResultSet{{
+		Columns: []string{"name", "n"},
+		Rows: [][]Value{
+			{NewVarChar("widget", -1), NewInt(12)},
+			{Null(TypeVarChar), NewInt(3)},
+		},
+	}}
+	got := formatMailResults(sets, mailResultFormat{header: true, width: 256, sep: " ", padding: true})
+	want := "name   n\r\n------ --\r\nwidget 12\r\nNULL   3\r\n\r\n(2 rows affected)\r\n"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	got = formatMailResults(sets, mailResultFormat{width: 8, sep: "|"})
+	want = "widget|1\r\nNULL|3\r\n\r\n(2 rows affected)\r\n"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
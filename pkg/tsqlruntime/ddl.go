@@ -11,20 +11,18 @@ import (
 
 // DDLHandler handles DDL statements for temp tables and regular tables
 type DDLHandler struct {
-	ctx        *ExecutionContext
-	normalizer *SQLNormalizer
+	ctx *ExecutionContext
 }
 
 // NewDDLHandler creates a new DDL handler
 func NewDDLHandler(ctx *ExecutionContext) *DDLHandler {
 	return &DDLHandler{
-		ctx:        ctx,
-		normalizer: NewSQLNormalizer(ctx.Dialect),
+		ctx: ctx,
 	}
 }
 
 // ExecuteCreateTable handles CREATE TABLE for temp tables and regular tables
-func (h *DDLHandler) ExecuteCreateTable(stmt *ast.CreateTableStatement) error {
+func (h *DDLHandler) ExecuteCreateTable(ctx context.Context, stmt *ast.CreateTableStatement) error {
 	if stmt == nil || stmt.Name == nil {
 		return fmt.Errorf("invalid CREATE TABLE statement")
 	}
@@ -38,7 +36,7 @@ func (h *DDLHandler) ExecuteCreateTable(stmt *ast.CreateTableStatement) error {
 
 	// Handle regular tables via database backend
 	if h.ctx.DB != nil {
-		return h.executeCreateRegularTable(stmt)
+		return h.executeCreateRegularTable(ctx, stmt)
 	}
 
 	return fmt.Errorf("CREATE TABLE for regular tables requires a database backend")
@@ -52,11 +50,10 @@ func (h *DDLHandler) executeCreateTempTable(stmt *ast.CreateTableStatement, tabl
 }
 
 // executeCreateRegularTable creates a regular table via the database backend
-func (h *DDLHandler) executeCreateRegularTable(stmt *ast.CreateTableStatement) error {
+func (h *DDLHandler) executeCreateRegularTable(ctx context.Context, stmt *ast.CreateTableStatement) error {
 	// Generate SQLite-compatible DDL from AST
 	sql := h.generateSQLiteCreateTable(stmt)
 
-	ctx := context.Background()
 	var err error
 	if h.ctx.Tx != nil {
 		_, err = h.ctx.Tx.ExecContext(ctx, sql)
@@ -70,7 +67,7 @@ func (h *DDLHandler) executeCreateRegularTable(stmt *ast.CreateTableStatement) e
 func (h *DDLHandler) generateSQLiteCreateTable(stmt *ast.CreateTableStatement) string {
 	var sb strings.Builder
 	sb.WriteString("CREATE TABLE ")
-	sb.WriteString(stmt.Name.String())
+	sb.WriteString(h.backendObjectName(stmt.Name))
 	sb.WriteString(" (\n")
 
 	var columnDefs []string
@@ -81,6 +78,11 @@ func (h *DDLHandler) generateSQLiteCreateTable(stmt *ast.CreateTableStatement) s
 		columnDefs = append(columnDefs, "  "+colDef)
 	}
 
+	// An edge stores the ids of the nodes it joins (see graph.go)
+	if stmt.GraphType == "EDGE" {
+		columnDefs = append(columnDefs, "  \"$from_id\" TEXT", "  \"$to_id\" TEXT")
+	}
+
 	// Handle table-level constraints
 	for _, constraint := range stmt.Constraints {
 		constraintSQL := h.generateSQLiteConstraint(constraint)
@@ -102,7 +104,7 @@ func (h *DDLHandler) generateSQLiteColumn(col *ast.ColumnDefinition) string {
 	var parts []string
 
 	// Column name
-	parts = append(parts, col.Name.Value)
+	parts = append(parts, h.backendIdentifier(col.Name))
 
 	// Data type - convert to SQLite
 	if col.DataType != nil {
@@ -180,7 +182,7 @@ func (h *DDLHandler) convertTypeToSQLite(dt *ast.DataType) string {
 		return "BLOB"
 
 	// Other types
-	case "UNIQUEIDENTIFIER", "XML", "SQL_VARIANT":
+	case "UNIQUEIDENTIFIER", "XML", "SQL_VARIANT", "GEOGRAPHY", "GEOMETRY":
 		return "TEXT"
 
 	default:
@@ -201,7 +203,7 @@ func (h *DDLHandler) generateSQLiteConstraint(constraint *ast.TableConstraint) s
 		sb.WriteString("PRIMARY KEY (")
 		var cols []string
 		for _, col := range constraint.Columns {
-			cols = append(cols, col.Name.Value)
+			cols = append(cols, h.backendIdentifier(col.Name))
 		}
 		sb.WriteString(strings.Join(cols, ", "))
 		sb.WriteString(")")
@@ -210,7 +212,7 @@ func (h *DDLHandler) generateSQLiteConstraint(constraint *ast.TableConstraint) s
 		sb.WriteString("UNIQUE (")
 		var cols []string
 		for _, col := range constraint.Columns {
-			cols = append(cols, col.Name.Value)
+			cols = append(cols, h.backendIdentifier(col.Name))
 		}
 		sb.WriteString(strings.Join(cols, ", "))
 		sb.WriteString(")")
@@ -219,16 +221,16 @@ func (h *DDLHandler) generateSQLiteConstraint(constraint *ast.TableConstraint) s
 		sb.WriteString("FOREIGN KEY (")
 		var cols []string
 		for _, col := range constraint.Columns {
-			cols = append(cols, col.Name.Value)
+			cols = append(cols, h.backendIdentifier(col.Name))
 		}
 		sb.WriteString(strings.Join(cols, ", "))
 		sb.WriteString(") REFERENCES ")
 		if constraint.ReferencesTable != nil {
-			sb.WriteString(constraint.ReferencesTable.String())
+			sb.WriteString(h.backendObjectName(constraint.ReferencesTable))
 			sb.WriteString(" (")
 			var refCols []string
 			for _, col := range constraint.ReferencesColumns {
-				refCols = append(refCols, col.Value)
+				refCols = append(refCols, h.backendIdentifier(col))
 			}
 			sb.WriteString(strings.Join(refCols, ", "))
 			sb.WriteString(")")
@@ -304,6 +306,8 @@ func (h *DDLHandler) normalizeTypes(sql string) string {
 		{"UNIQUEIDENTIFIER", "TEXT"},
 		{"XML", "TEXT"},
 		{"SQL_VARIANT", "TEXT"},
+		{"GEOGRAPHY", "TEXT"},
+		{"GEOMETRY", "TEXT"},
 	}
 
 	for _, r := range replacements {
@@ -320,7 +324,7 @@ func (h *DDLHandler) normalizeTypes(sql string) string {
 }
 
 // ExecuteDropTable handles DROP TABLE for temp tables and regular tables
-func (h *DDLHandler) ExecuteDropTable(stmt *ast.DropTableStatement) error {
+func (h *DDLHandler) ExecuteDropTable(ctx context.Context, stmt *ast.DropTableStatement) error {
 	if stmt == nil {
 		return fmt.Errorf("invalid DROP TABLE statement")
 	}
@@ -342,9 +346,8 @@ func (h *DDLHandler) ExecuteDropTable(stmt *ast.DropTableStatement) error {
 			if stmt.IfExists {
 				sql += "IF EXISTS "
 			}
-			sql += tableName
+			sql += h.backendObjectName(table)
 
-			ctx := context.Background()
 			var err error
 			if h.ctx.Tx != nil {
 				_, err = h.ctx.Tx.ExecContext(ctx, sql)
@@ -354,6 +357,19 @@ func (h *DDLHandler) ExecuteDropTable(stmt *ast.DropTableStatement) error {
 			if err != nil {
 				return err
 			}
+
+			// The table's triggers go with it, but not its full-text index
+			if h.ctx.Dialect == DialectSQLite {
+				sql = "DROP TABLE IF EXISTS " + quoteSQLiteName(fullTextTableName(tableName))
+				if h.ctx.Tx != nil {
+					_, err = h.ctx.Tx.ExecContext(ctx, sql)
+				} else {
+					_, err = h.ctx.DB.ExecContext(ctx, sql)
+				}
+				if err != nil {
+					return err
+				}
+			}
 		} else {
 			return fmt.Errorf("DROP TABLE for regular tables requires a database backend")
 		}
@@ -363,7 +379,7 @@ func (h *DDLHandler) ExecuteDropTable(stmt *ast.DropTableStatement) error {
 }
 
 // ExecuteTruncateTable handles TRUNCATE TABLE for temp tables
-func (h *DDLHandler) ExecuteTruncateTable(stmt *ast.TruncateTableStatement) error {
+func (h *DDLHandler) ExecuteTruncateTable(ctx context.Context, stmt *ast.TruncateTableStatement) error {
 	if stmt == nil || stmt.Table == nil {
 		return fmt.Errorf("invalid TRUNCATE TABLE statement")
 	}
@@ -383,8 +399,7 @@ func (h *DDLHandler) ExecuteTruncateTable(stmt *ast.TruncateTableStatement) erro
 
 	// For regular tables, use DELETE (SQLite doesn't have TRUNCATE)
 	if h.ctx.DB != nil {
-		sql := "DELETE FROM " + tableName
-		ctx := context.Background()
+		sql := "DELETE FROM " + h.backendObjectName(stmt.Table)
 		var err error
 		if h.ctx.Tx != nil {
 			_, err = h.ctx.Tx.ExecContext(ctx, sql)
@@ -506,8 +521,12 @@ func (h *DDLHandler) parseColumnDef(def *ast.ColumnDefinition) TempTableColumn {
 	return col
 }
 
-// DeclareTableVariable handles DECLARE @t TABLE (...)
+// DeclareTableVariable handles DECLARE @t TABLE (...). Declaring it again,
+// as a loop body does, keeps its rows, as in SQL Server.
 func (h *DDLHandler) DeclareTableVariable(name string, columns []TempTableColumn) error {
+	if _, exists := h.ctx.TempTables.GetTableVariable(name); exists {
+		return nil
+	}
 	_, err := h.ctx.TempTables.CreateTableVariable(name, columns)
 	return err
 }
@@ -523,7 +542,7 @@ func IsTableVariable(name string) bool {
 }
 
 // ExecuteCreateIndex handles CREATE INDEX statements
-func (h *DDLHandler) ExecuteCreateIndex(stmt *ast.CreateIndexStatement) error {
+func (h *DDLHandler) ExecuteCreateIndex(ctx context.Context, stmt *ast.CreateIndexStatement) error {
 	if stmt == nil {
 		return fmt.Errorf("invalid CREATE INDEX statement")
 	}
@@ -536,7 +555,6 @@ func (h *DDLHandler) ExecuteCreateIndex(stmt *ast.CreateIndexStatement) error {
 	// Generate SQLite-compatible CREATE INDEX
 	sql := h.generateSQLiteCreateIndex(stmt)
 
-	ctx := context.Background()
 	var err error
 	if h.ctx.Tx != nil {
 		_, err = h.ctx.Tx.ExecContext(ctx, sql)
@@ -558,21 +576,21 @@ func (h *DDLHandler) generateSQLiteCreateIndex(stmt *ast.CreateIndexStatement) s
 
 	// Index name
 	if stmt.Name != nil {
-		sb.WriteString(stmt.Name.Value)
+		sb.WriteString(h.backendIdentifier(stmt.Name))
 	}
 
 	sb.WriteString(" ON ")
 
 	// Table name
 	if stmt.Table != nil {
-		sb.WriteString(stmt.Table.String())
+		sb.WriteString(h.backendObjectName(stmt.Table))
 	}
 
 	// Columns
 	sb.WriteString(" (")
 	var cols []string
 	for _, col := range stmt.Columns {
-		colStr := col.Name.Value
+		colStr := h.backendIdentifier(col.Name)
 		if col.Descending {
 			colStr += " DESC"
 		}
@@ -583,3 +601,28 @@ func (h *DDLHandler) generateSQLiteCreateIndex(stmt *ast.CreateIndexStatement) s
 
 	return sb.String()
 }
+
+// backendIdentifier writes id for the backend: a delimited identifier is
+// delimited as the dialect delimits them, so [a]]b] becomes "a]b" on
+// SQLite, which cannot escape ] between brackets.
+func (h *DDLHandler) backendIdentifier(id *ast.Identifier) string {
+	if quote := identifierQuote(h.ctx.Dialect); id.Token.Quote != 0 && quote != 0 {
+		return ast.QuoteIdentifier(id.Value, quote)
+	}
+	return id.String()
+}
+
+// backendObjectName writes a qualified name for the backend, each part as
+// backendIdentifier does. SQLite has no schemas, so dbo.Orders is Orders,
+// as the rewriter has it.
+func (h *DDLHandler) backendObjectName(name *ast.QualifiedIdentifier) string {
+	parts := name.Parts
+	if h.ctx.Dialect == DialectSQLite && len(parts) > 1 {
+		parts = parts[len(parts)-1:]
+	}
+	names := make([]string, len(parts))
+	for n, part := range parts {
+		names[n] = h.backendIdentifier(part)
+	}
+	return strings.Join(names, ".")
+}
@@ -6,6 +6,9 @@ import (
 )
 
 // SQLNormalizer translates T-SQL specific syntax to target dialect.
+//
+// Deprecated: the AST rewriters make all of these translations. The
+// interpreter only runs it when LegacyNormalizer is set.
 type SQLNormalizer struct {
 	dialect Dialect
 }
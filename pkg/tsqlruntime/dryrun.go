@@ -0,0 +1,187 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// A dry run executes a procedure, or a batch, inside a transaction that
+// is always rolled back. It returns the result sets the execution
+// produced, then one listing the writes it made, which are undone.
+//
+// The transaction is the dry run's own, beneath the execution's: the
+// execution sees @@TRANCOUNT 0 as it would otherwise, its COMMITs leave
+// its work for the dry run to roll back, and its ROLLBACKs roll back to a
+// savepoint taken at its outermost BEGIN TRANSACTION. System procedures
+// that write are listed with NULL rows_affected; those acting outside the
+// database, such as sp_send_dbmail, are not run, and are listed as skipped.
+
+// DryRunColumns name the columns of the result set listing a dry run's
+// writes.
+var DryRunColumns = []string{"operation", "target", "rows_affected", "statement", "skipped"}
+
+// dryRunSavepoint names the savepoint standing for the execution's own
+// transaction.
+const dryRunSavepoint = "aul_dry_run"
+
+// DryRun records the writes of a dry run. It is the execution's
+// StatementJournal while the dry run lasts.
+type DryRun struct {
+	tx      *sql.Tx
+	writes  []dryRunWrite
+	running []int // Writes begun and not yet ended; INSERT ... EXEC nests them
+	mark    int   // Writes made before the execution's transaction began
+}
+
+type dryRunWrite struct {
+	kind, target, text string
+	rows               int64 // -1 = not known
+	skipped, failed    bool
+}
+
+// BeginWrite records a write about to run.
+func (d *DryRun) BeginWrite(kind, target, text string, inTransaction bool) error {
+	d.running = append(d.running, len(d.writes))
+	d.writes = append(d.writes, dryRunWrite{kind: kind, target: target, text: text})
+	return nil
+}
+
+// EndWrite records the rows the write last begun affected.
+func (d *DryRun) EndWrite(rows int64, err error) {
+	n := len(d.running) - 1
+	if n < 0 {
+		return
+	}
+	idx := d.running[n]
+	d.running = d.running[:n]
+	if idx < len(d.writes) {
+		d.writes[idx].rows, d.writes[idx].failed = rows, err != nil
+	}
+}
+
+// Transaction is told of the end of the execution's transaction, which
+// begin and rollback have already dealt with.
+func (d *DryRun) Transaction(committed bool) {}
+
+// skip records a system procedure that was not run.
+func (d *DryRun) skip(name, text string) {
+	d.writes = append(d.writes, dryRunWrite{kind: "EXEC", target: name, text: text, rows: -1, skipped: true})
+}
+
+// skipRunning marks the write being run as skipped, when a system
+// procedure leaves out the part of its work acting outside the database.
+func (d *DryRun) skipRunning() {
+	if n := len(d.running); n > 0 && d.running[n-1] < len(d.writes) {
+		d.writes[d.running[n-1]].skipped = true
+	}
+}
+
+// begin takes the savepoint the execution's ROLLBACK returns to.
+func (d *DryRun) begin(ctx context.Context, ec *ExecutionContext) error {
+	query := "SAVEPOINT " + dryRunSavepoint
+	if ec.Dialect == DialectSQLServer {
+		query = "SAVE TRANSACTION " + dryRunSavepoint
+	}
+	if _, err := d.tx.ExecContext(ctx, query); err != nil {
+		ec.TranCount--
+		return err
+	}
+	d.mark = len(d.writes)
+	ec.ErrorHandler.SetXactState(1)
+	return nil
+}
+
+// rollback undoes the execution's transaction, and forgets its writes.
+func (d *DryRun) rollback(ec *ExecutionContext) error {
+	query := "ROLLBACK TO SAVEPOINT " + dryRunSavepoint
+	if ec.Dialect == DialectSQLServer {
+		query = "ROLLBACK TRANSACTION " + dryRunSavepoint
+	}
+	if _, err := d.tx.Exec(query); err != nil {
+		return err
+	}
+	d.writes = d.writes[:d.mark]
+	return nil
+}
+
+// resultSet lists the writes.
+func (d *DryRun) resultSet() ResultSet {
+	rs := ResultSet{Columns: DryRunColumns, Rows: make([][]Value, 0, len(d.writes))}
+	for _, w := range d.writes {
+		if w.failed {
+			continue
+		}
+		rows := Null(TypeBigInt)
+		if w.rows >= 0 {
+			rows = NewBigInt(w.rows)
+		}
+		rs.Rows = append(rs.Rows, []Value{
+			NewVarChar(w.kind, 0), NewNVarChar(w.target, 0), rows, NewNVarChar(w.text, 0), NewBit(w.skipped),
+		})
+	}
+	return rs
+}
+
+// ExecuteDryRun executes sql as Execute does, in a dry run. The last
+// result set lists the writes it made.
+func (i *Interpreter) ExecuteDryRun(ctx context.Context, sql string, params map[string]interface{}) (*ExecutionResult, error) {
+	var result *ExecutionResult
+	writes, err := i.dryRun(ctx, func() (err error) {
+		result, err = i.Execute(ctx, sql, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	i.ctx.AddResultSet(writes)
+	result.ResultSets = i.ctx.ResultSets
+	return result, nil
+}
+
+// executeDryRun runs EXEC ... WITH DRYRUN.
+func (i *Interpreter) executeDryRun(ctx context.Context, s *ast.ExecStatement, result *ExecutionResult) error {
+	exec := *s
+	exec.DryRun = false
+	writes, err := i.dryRun(ctx, func() error {
+		return i.executeExec(ctx, &exec, result)
+	})
+	if err != nil {
+		return err
+	}
+	result.ResultSets = append(result.ResultSets, writes)
+	i.ctx.AddResultSet(writes)
+	return nil
+}
+
+// dryRun calls run inside a dry run, returning the writes it made.
+func (i *Interpreter) dryRun(ctx context.Context, run func() error) (ResultSet, error) {
+	if i.ctx.Tx != nil {
+		return ResultSet{}, fmt.Errorf("a dry run cannot start inside a transaction")
+	}
+	if i.ctx.DB == nil {
+		return ResultSet{}, fmt.Errorf("a dry run needs a database connection")
+	}
+	tx, err := i.ctx.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return ResultSet{}, err
+	}
+
+	d := &DryRun{tx: tx}
+	journal := i.ctx.Journal
+	i.ctx.Tx, i.ctx.TranCount, i.ctx.DryRun, i.ctx.Journal = tx, 0, d, d
+	defer func() {
+		tx.Rollback()
+		i.ctx.Tx, i.ctx.TranCount, i.ctx.DryRun, i.ctx.Journal = nil, 0, nil, journal
+		i.ctx.ErrorHandler.SetXactState(0)
+		i.ctx.releaseLocks()
+		i.ctx.endCacheWrites()
+	}()
+
+	if err := run(); err != nil {
+		return ResultSet{}, err
+	}
+	return d.resultSet(), nil
+}
@@ -0,0 +1,99 @@
+package tsqlruntime
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	interp := sysprocSetup(t)
+	resolver := interp.resolver.(*renamingResolver)
+	resolver.AddProcedure("dbo.Purge", `
+		CREATE PROCEDURE dbo.Purge @qty INT
+		AS
+		BEGIN
+			INSERT INTO orders VALUES (2, @qty)
+			BEGIN TRANSACTION
+			UPDATE orders SET qty = 0
+			COMMIT
+			BEGIN TRANSACTION
+			DELETE FROM orders
+			ROLLBACK
+			SELECT @@TRANCOUNT AS trancount, SUM(qty) AS total FROM orders
+			EXEC sp_send_dbmail @recipients = 'ops@example.com', @body = 'purged'
+			EXEC sp_rename 'orders', 'old_orders'
+			EXEC sp_rename 'dbo.GetMessage', 'GetGreeting'
+		END
+	`, []ProcedureParam{{Name: "@qty"}})
+	ctx := context.Background()
+
+	result, err := interp.Execute(ctx, "EXEC dbo.Purge @qty = 7 WITH DRYRUN", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.ResultSets) != 2 {
+		t.Fatalf("got %d result sets", len(result.ResultSets))
+	}
+	// The procedure saw its own work, the DELETE rolled back
+	if row := result.ResultSets[0].Rows[0]; row[0].AsInt() != 0 || row[1].AsInt() != 0 || len(result.ResultSets[0].Rows) != 1 {
+		t.Errorf("procedure saw %v", result.ResultSets[0].Rows)
+	}
+
+	writes := result.ResultSets[1]
+	if len(writes.Columns) != 5 || writes.Columns[0] != "operation" {
+		t.Fatalf("columns %v", writes.Columns)
+	}
+	want := []struct {
+		op, target string
+		rows       int64
+		skipped    bool
+	}{
+		{"INSERT", "orders", 1, false},
+		{"UPDATE", "orders", 2, false},
+		{"EXEC", "sp_send_dbmail", -1, true},
+		{"EXEC", "sp_rename", -1, false},
+		// The registry is not rolled back
+		{"EXEC", "sp_rename", -1, true},
+	}
+	if len(writes.Rows) != len(want) {
+		t.Fatalf("writes %v", writes.Rows)
+	}
+	for n, w := range want {
+		row := writes.Rows[n]
+		if row[0].AsString() != w.op || row[1].AsString() != w.target || (w.rows < 0) != row[2].IsNull ||
+			w.rows >= 0 && row[2].AsInt() != w.rows || row[4].AsBool() != w.skipped {
+			t.Errorf("write %d: %v", n, row)
+		}
+	}
+	if _, _, err := resolver.Resolve(ctx, "dbo.GetMessage", ""); err != nil {
+		t.Errorf("procedure renamed: %v", err)
+	}
+
+	// Nothing stayed
+	var count, qty int
+	if err := interp.ctx.DB.QueryRow("SELECT COUNT(*), SUM(qty) FROM orders").Scan(&count, &qty); err != nil || count != 1 || qty != 5 {
+		t.Errorf("orders %d, %d: %v", count, qty, err)
+	}
+	if interp.ctx.Tx != nil || interp.ctx.DryRun != nil {
+		t.Error("dry run left its transaction open")
+	}
+
+	// A batch too
+	result, err = interp.ExecuteDryRun(ctx, "UPDATE orders SET qty = qty + 1 SELECT qty FROM orders", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := result.ResultSets[len(result.ResultSets)-1]
+	if len(last.Rows) != 1 || last.Rows[0][0].AsString() != "UPDATE" || result.ResultSets[len(result.ResultSets)-2].Rows[0][0].AsInt() != 6 {
+		t.Errorf("got %v", result.ResultSets)
+	}
+
+	// Not inside a transaction the caller would then lose
+	if _, err := interp.Execute(ctx, "BEGIN TRANSACTION EXEC dbo.Purge 1 WITH DRYRUN", nil); err == nil {
+		t.Error("dry run inside a transaction")
+	}
+	interp.ctx.RollbackTransaction()
+	if err := interp.ctx.DB.QueryRow("SELECT SUM(qty) FROM orders").Scan(&qty); err != nil || qty != 5 {
+		t.Errorf("qty %d: %v", qty, err)
+	}
+}
@@ -36,10 +36,41 @@ const (
 	ErrDeadlock            = 1205
 	ErrTimeout             = -2
 	ErrInvalidObject       = 208
+	ErrObjectNotFound      = 1088
 	ErrInvalidColumn       = 207
+	ErrColumnCountMismatch = 213
+	ErrNestingLimit        = 217
 	ErrSyntaxError         = 102
 	ErrPermissionDenied    = 229
 	ErrRaiseError          = 50000
+	ErrRenameAmbiguous     = 15248
+	ErrPropertyExists      = 15233
+	ErrPropertyMissing     = 15217
+	ErrPropertyInvalidObj  = 15135
+	ErrInvalidParameter    = 15600
+	ErrNotFullTextIndexed  = 7601
+	ErrFullTextSyntax      = 7630
+	ErrFullTextEmpty       = 7645
+	ErrFullTextExists      = 7652
+	ErrMethodNotFound      = 6506
+	ErrCLRRoutine          = 6522
+	ErrExternalEndpoint    = 31614
+	ErrDatabaseReadOnly    = 3906
+	ErrNotSupported        = 40517
+	ErrObjectExists        = 2714
+	ErrObjectReferenced    = 3729
+	ErrWrongDropType       = 3705
+	ErrCannotDrop          = 3701
+	ErrDatabasePermission  = 262
+	ErrNoPermission        = 15247
+	ErrPrincipalNotFound   = 15151
+	ErrPrincipalExists     = 15023
+	ErrRoleHasMembers      = 15144
+	ErrPartitionScheme     = 1921
+	ErrPartitionBoundary   = 7708
+	ErrPartitionInUse      = 7717
+	ErrExternalScript      = 39004
+	ErrSandboxDenied       = 50414 // aul's: a sandbox policy denied the statement
 )
 
 // NewSQLError creates a new SQL error
@@ -48,6 +79,9 @@ func NewSQLError(number int, message string) *SQLError {
 	if number == ErrDeadlock || number == ErrTimeout {
 		severity = 13
 	}
+	if number == ErrPermissionDenied || number == ErrDatabasePermission || number == ErrSandboxDenied {
+		severity = 14
+	}
 	return &SQLError{
 		Number:   number,
 		Severity: severity,
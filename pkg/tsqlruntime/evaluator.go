@@ -1,6 +1,8 @@
 package tsqlruntime
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -12,6 +14,36 @@ import (
 type ExpressionEvaluator struct {
 	variables map[string]Value
 	functions *FunctionRegistry
+
+	// aggregates holds the values of aggregate calls for the group being
+	// evaluated by an in-memory temp table query
+	aggregates map[*ast.FunctionCall]Value
+
+	// columns resolves the column references of the row being evaluated
+	// by an in-memory query over pinned tables (nil = columns are read as
+	// variables)
+	columns func(ref ast.Expression) (Value, bool)
+
+	// nestLevel is the procedure nesting level, read by @@NESTLEVEL
+	nestLevel int
+
+	// feature answers FEATURE('name') (nil = every flag off)
+	feature func(name string) bool
+
+	// statsDate answers STATS_DATE(object_id, stats_id) (nil = always NULL)
+	statsDate func(objectID, statsID int64) (Value, error)
+
+	// session answers the environment functions, such as HOST_NAME() and
+	// @@SPID (nil = placeholder answers)
+	session func() SessionInfo
+
+	// globals answers the @@ variables statements set, such as @@ROWCOUNT
+	// (nil = only those set on the evaluator)
+	globals func(name string) (Value, bool)
+
+	// ctx is the context of the statement being run, passed to the
+	// external functions it calls (nil = context.Background())
+	ctx context.Context
 }
 
 // NewExpressionEvaluator creates a new expression evaluator
@@ -57,8 +89,14 @@ func (e *ExpressionEvaluator) Evaluate(expr ast.Expression) (Value, error) {
 		return NewFloat(ex.Value), nil
 
 	case *ast.StringLiteral:
+		if ex.Unicode {
+			return NewNVarChar(ex.Value, -1), nil
+		}
 		return NewVarChar(ex.Value, -1), nil
 
+	case *ast.BinaryLiteral:
+		return parseBinaryLiteral(ex.Value)
+
 	case *ast.NullLiteral:
 		return Null(TypeUnknown), nil
 
@@ -69,19 +107,37 @@ func (e *ExpressionEvaluator) Evaluate(expr ast.Expression) (Value, error) {
 		// Could be a column reference or a function name without parens
 		// For now, treat as variable
 		name := ex.Value
+		if e.columns != nil {
+			if v, ok := e.columns(ex); ok {
+				return v, nil
+			}
+		}
 		if v, ok := e.GetVariable(name); ok {
 			return v, nil
 		}
+		// SYSTEM_USER, CURRENT_USER and SESSION_USER take no parentheses
+		if v, ok := e.evaluateSession(name, nil); ok {
+			return v, nil
+		}
 		return Null(TypeUnknown), nil
 
 	case *ast.QualifiedIdentifier:
 		// e.g., table.column - return the last part as identifier
+		if e.columns != nil {
+			if v, ok := e.columns(ex); ok {
+				return v, nil
+			}
+		}
 		if len(ex.Parts) > 0 {
 			name := ex.Parts[len(ex.Parts)-1].Value
 			if v, ok := e.GetVariable(name); ok {
 				return v, nil
 			}
 		}
+		// or column.property of a spatial column, e.g. loc.Lat
+		if v, ok, err := e.spatialProperty(ex); ok {
+			return v, err
+		}
 		return Null(TypeUnknown), nil
 
 	case *ast.PrefixExpression:
@@ -93,6 +149,9 @@ func (e *ExpressionEvaluator) Evaluate(expr ast.Expression) (Value, error) {
 	case *ast.FunctionCall:
 		return e.evaluateFunctionCall(ex)
 
+	case *ast.TrimExpression:
+		return e.evaluateTrimExpression(ex)
+
 	case *ast.CaseExpression:
 		return e.evaluateCaseExpression(ex)
 
@@ -102,6 +161,9 @@ func (e *ExpressionEvaluator) Evaluate(expr ast.Expression) (Value, error) {
 	case *ast.ConvertExpression:
 		return e.evaluateConvertExpression(ex)
 
+	case *ast.ParseExpression:
+		return e.evaluateParseExpression(ex)
+
 	case *ast.BetweenExpression:
 		return e.evaluateBetweenExpression(ex)
 
@@ -121,6 +183,12 @@ func (e *ExpressionEvaluator) Evaluate(expr ast.Expression) (Value, error) {
 	case *ast.SubqueryExpression:
 		return Value{}, fmt.Errorf("subqueries not supported in expression evaluation")
 
+	case *ast.StaticMethodCall:
+		return e.evaluateStaticMethodCall(ex)
+
+	case *ast.MethodCallExpression:
+		return e.evaluateMethodCall(ex)
+
 	case *ast.TupleExpression:
 		// Handle tuple/parenthesized expressions - evaluate first element
 		if len(ex.Elements) == 1 {
@@ -152,6 +220,15 @@ func (e *ExpressionEvaluator) evaluateVariable(v *ast.Variable) (Value, error) {
 func (e *ExpressionEvaluator) evaluateGlobalVariable(name string) (Value, error) {
 	upperName := strings.ToUpper(name)
 
+	switch upperName {
+	case "@@ROWCOUNT", "@@ERROR", "@@IDENTITY", "@@FETCH_STATUS", "@@TRANCOUNT":
+		if e.globals != nil {
+			if val, ok := e.globals(upperName); ok {
+				return val, nil
+			}
+		}
+	}
+
 	switch upperName {
 	case "@@ROWCOUNT":
 		if val, ok := e.GetVariable("@@ROWCOUNT"); ok {
@@ -183,16 +260,20 @@ func (e *ExpressionEvaluator) evaluateGlobalVariable(name string) (Value, error)
 		}
 		return NewInt(0), nil
 
-	case "@@SPID":
-		// Session ID - return a dummy value
-		return NewInt(1), nil
+	case "@@NESTLEVEL":
+		return NewInt(int64(e.nestLevel)), nil
+
+	case "@@SPID", "@@SERVERNAME", "@@SERVICENAME":
+		s := defaultSession
+		if e.session != nil {
+			s = e.session()
+		}
+		v, _ := sessionVariable(s, upperName)
+		return v, nil
 
 	case "@@VERSION":
 		return NewVarChar("Microsoft SQL Server 2019 (RTM-CU28) - 15.0.4415.2 (X64) \n\tDec 13 2024 18:00:00 \n\tCopyright (C) 2019 Microsoft Corporation\n\tDeveloper Edition (64-bit) on Linux (aul-server)", -1), nil
 
-	case "@@SERVERNAME":
-		return NewVarChar("aul", -1), nil
-
 	case "@@LANGUAGE":
 		return NewVarChar("us_english", -1), nil
 
@@ -308,10 +389,15 @@ func (e *ExpressionEvaluator) evaluateInfixExpression(ex *ast.InfixExpression) (
 }
 
 func (e *ExpressionEvaluator) evaluateFunctionCall(fc *ast.FunctionCall) (Value, error) {
+	if v, ok := e.aggregates[fc]; ok {
+		return v, nil
+	}
+
 	funcName := ""
 	if fc.Function != nil {
 		funcName = fc.Function.String()
 	}
+	isFeature := strings.EqualFold(funcName, "FEATURE")
 
 	// Evaluate arguments
 	args := make([]Value, len(fc.Arguments))
@@ -331,6 +417,47 @@ func (e *ExpressionEvaluator) evaluateFunctionCall(fc *ast.FunctionCall) (Value,
 		args[i] = val
 	}
 
+	if isFeature {
+		return e.evaluateFeature(args)
+	}
+	if strings.EqualFold(funcName, "STATS_DATE") {
+		return e.evaluateStatsDate(args)
+	}
+	if v, ok := e.evaluateSession(funcName, args); ok {
+		return v, nil
+	}
+	ctx := e.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return e.functions.CallContext(ctx, funcName, args)
+}
+
+// evaluateTrimExpression evaluates TRIM([LEADING|TRAILING|BOTH] [chars FROM] s)
+// as LTRIM, RTRIM or TRIM.
+func (e *ExpressionEvaluator) evaluateTrimExpression(ex *ast.TrimExpression) (Value, error) {
+	funcName := "TRIM"
+	switch strings.ToUpper(ex.TrimSpec) {
+	case "LEADING":
+		funcName = "LTRIM"
+	case "TRAILING":
+		funcName = "RTRIM"
+	}
+
+	val, err := e.Evaluate(ex.Expression)
+	if err != nil {
+		return Value{}, err
+	}
+	args := []Value{val}
+	if ex.Characters != nil {
+		chars, err := e.Evaluate(ex.Characters)
+		if err != nil {
+			return Value{}, err
+		}
+		args = append(args, chars)
+	} else if funcName != "TRIM" {
+		args = append(args, NewVarChar(" ", -1))
+	}
 	return e.functions.Call(funcName, args)
 }
 
@@ -390,7 +517,11 @@ func (e *ExpressionEvaluator) evaluateCastExpression(ex *ast.CastExpression) (Va
 	}
 
 	targetType, precision, scale, maxLen := ParseDataType(ex.TargetType.String())
-	return Cast(val, targetType, precision, scale, maxLen)
+	result, err := Cast(val, targetType, precision, scale, maxLen)
+	if err != nil && ex.IsTry {
+		return Null(targetType), nil
+	}
+	return result, err
 }
 
 func (e *ExpressionEvaluator) evaluateConvertExpression(ex *ast.ConvertExpression) (Value, error) {
@@ -414,7 +545,34 @@ func (e *ExpressionEvaluator) evaluateConvertExpression(ex *ast.ConvertExpressio
 		style = int(styleVal.AsInt())
 	}
 
-	return Convert(val, targetType, precision, scale, maxLen, style)
+	result, err := Convert(val, targetType, precision, scale, maxLen, style)
+	if err != nil && ex.IsTry {
+		return Null(targetType), nil
+	}
+	return result, err
+}
+
+func (e *ExpressionEvaluator) evaluateParseExpression(ex *ast.ParseExpression) (Value, error) {
+	val, err := e.Evaluate(ex.Expression)
+	if err != nil {
+		return Value{}, err
+	}
+
+	culture := ""
+	if ex.Culture != nil {
+		c, err := e.Evaluate(ex.Culture)
+		if err != nil {
+			return Value{}, err
+		}
+		culture = c.AsString()
+	}
+
+	targetType, precision, scale, maxLen := ParseDataType(ex.TargetType.String())
+	result, err := Parse(val, targetType, precision, scale, maxLen, culture)
+	if err != nil && ex.IsTry && !errors.Is(err, errParseUnsupported) {
+		return Null(targetType), nil
+	}
+	return result, err
 }
 
 func (e *ExpressionEvaluator) evaluateBetweenExpression(ex *ast.BetweenExpression) (Value, error) {
@@ -0,0 +1,335 @@
+package tsqlruntime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// A batch prefixed with EXPLAIN returns query plans instead of results.
+// Each statement the interpreter would send to the backend is rewritten as
+// usual, then the backend is asked for its plan: EXPLAIN QUERY PLAN on
+// SQLite, EXPLAIN (FORMAT JSON) on PostgreSQL and EXPLAIN elsewhere. The
+// statement itself is not run, unless EXPLAIN ANALYZE asks PostgreSQL or
+// MySQL to measure it, which they do by running it.
+//
+// Statements the interpreter runs itself (DECLARE, SET, control flow, temp
+// tables) run as usual, so that the queries after them see their
+// variables. DDL and UPDATE STATISTICS are skipped.
+
+// ExplainMode says whether queries are run or explained.
+type ExplainMode int
+
+const (
+	ExplainOff     ExplainMode = iota
+	ExplainPlan                // Plans are returned instead of results
+	ExplainAnalyze             // measured by running the query, where the backend can
+)
+
+// PlanColumn names the column of the result sets holding plans. Each row
+// holds one QueryPlan as JSON.
+const PlanColumn = "QUERY PLAN"
+
+// QueryPlan is the plan of one statement, with the steps that rewrote
+// its T-SQL into the backend's SQL.
+type QueryPlan struct {
+	Statement string        `json:"statement"`          // As parsed
+	Rewrites  []RewriteStep `json:"rewrites,omitempty"` // Those that changed it, in order
+	SQL       string        `json:"sql"`                // Sent to the backend
+	Args      []interface{} `json:"args,omitempty"`
+	Backend   string        `json:"backend"`
+	Analyzed  bool          `json:"analyzed,omitempty"`
+	Plan      []*PlanNode   `json:"plan"`
+}
+
+// RewriteStep is the SQL as one rewriting step left it.
+type RewriteStep struct {
+	Step string `json:"step"`
+	SQL  string `json:"sql"`
+}
+
+// PlanNode is a step of a backend's plan.
+type PlanNode struct {
+	Detail     string                 `json:"detail"`
+	Properties map[string]interface{} `json:"properties,omitempty"` // Costs, row counts and timings, as the backend names them
+	Children   []*PlanNode            `json:"children,omitempty"`
+}
+
+// ParseExplain strips an EXPLAIN or EXPLAIN ANALYZE prefix from batch,
+// returning the mode it asks for.
+func ParseExplain(batch string) (ExplainMode, string) {
+	rest, ok := cutKeyword(batch, "EXPLAIN")
+	if !ok {
+		return ExplainOff, batch
+	}
+	if analyzed, ok := cutKeyword(rest, "ANALYZE"); ok {
+		return ExplainAnalyze, analyzed
+	}
+	return ExplainPlan, rest
+}
+
+// cutKeyword returns s after keyword, when s starts with it as a word.
+func cutKeyword(s, keyword string) (string, bool) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	if len(s) < len(keyword) || !strings.EqualFold(s[:len(keyword)], keyword) {
+		return s, false
+	}
+	rest := s[len(keyword):]
+	if rest != "" && !unicode.IsSpace(rune(rest[0])) {
+		return s, false
+	}
+	return strings.TrimLeftFunc(rest, unicode.IsSpace), true
+}
+
+// SetExplain sets whether this execution's queries are explained rather
+// than run.
+func (i *Interpreter) SetExplain(mode ExplainMode) {
+	i.ctx.Explain = mode
+}
+
+// rewriteStep records the SQL a rewriting step produced, when a statement
+// is being explained and the step changed it.
+func (i *Interpreter) rewriteStep(step, query string) {
+	if i.plan == nil {
+		return
+	}
+	last := i.plan.Statement
+	if n := len(i.plan.Rewrites); n > 0 {
+		last = i.plan.Rewrites[n-1].SQL
+	}
+	if query != last {
+		i.plan.Rewrites = append(i.plan.Rewrites, RewriteStep{Step: step, SQL: query})
+	}
+}
+
+// explainStatement returns the plan of stmt as a result set, when stmt is
+// a query for the backend, and reports whether it handled stmt: DDL is
+// handled by skipping it.
+func (i *Interpreter) explainStatement(ctx context.Context, stmt ast.Statement, result *ExecutionResult) (bool, error) {
+	var build func() (string, []interface{}, error)
+	switch s := stmt.(type) {
+	case *ast.SelectStatement:
+		if s.Into != nil || i.hasVariableAssignments(s) || i.isSelectFromTempTable(s) || i.isScalarSelect(s) {
+			return false, nil
+		}
+		build = func() (string, []interface{}, error) { return i.buildSelectQuery(s) }
+	case *ast.InsertStatement:
+		if s.Exec != nil || s.Table != nil && isTempTarget(s.Table.String()) {
+			return false, nil
+		}
+		build = func() (string, []interface{}, error) { return i.buildInsertQuery(s) }
+	case *ast.UpdateStatement:
+		if s.Table != nil && isTempTarget(s.Table.String()) {
+			return false, nil
+		}
+		build = func() (string, []interface{}, error) { return i.buildUpdateQuery(s) }
+	case *ast.DeleteStatement:
+		if s.Table != nil && isTempTarget(s.Table.String()) {
+			return false, nil
+		}
+		build = func() (string, []interface{}, error) { return i.buildDeleteQuery(s) }
+	case *ast.WithStatement:
+		build = func() (string, []interface{}, error) { return i.buildWithQuery(s) }
+	case *ast.CreateTableStatement, *ast.DropTableStatement, *ast.TruncateTableStatement,
+		*ast.CreateIndexStatement, *ast.UpdateStatisticsStatement,
+		*ast.CreateFulltextIndexStatement, *ast.AlterFulltextIndexStatement, *ast.DropFulltextIndexStatement,
+		*ast.CreateFulltextCatalogStatement, *ast.DropFulltextCatalogStatement:
+		return true, nil
+	default:
+		return false, nil
+	}
+
+	analyze := i.ctx.Explain == ExplainAnalyze
+	plan := &QueryPlan{Statement: stmt.String(), Backend: dialectName(i.ctx.Dialect)}
+	i.plan = plan
+	query, args, err := build()
+	i.plan = nil
+	if err != nil {
+		return true, err
+	}
+	plan.SQL, plan.Args = query, args
+
+	switch i.ctx.Dialect {
+	case DialectSQLite:
+		plan.Plan, err = i.sqlitePlan(ctx, query, args)
+	case DialectPostgres:
+		plan.Plan, err = i.postgresPlan(ctx, query, args, analyze)
+		plan.Analyzed = analyze
+	default:
+		prefix := "EXPLAIN "
+		if analyze && i.ctx.Dialect == DialectMySQL {
+			prefix = "EXPLAIN ANALYZE "
+			plan.Analyzed = true
+		}
+		plan.Plan, err = i.genericPlan(ctx, prefix+query, args)
+	}
+	if err != nil {
+		return true, fmt.Errorf("explain error: %w", err)
+	}
+
+	doc, err := json.Marshal(plan)
+	if err != nil {
+		return true, err
+	}
+	rs := ResultSet{Columns: []string{PlanColumn}, Rows: [][]Value{{NewNVarChar(string(doc), 0)}}}
+	result.ResultSets = append(result.ResultSets, rs)
+	i.ctx.AddResultSet(rs)
+	return true, nil
+}
+
+// isTempTarget reports whether a statement's target is a temp table or a
+// table variable, which the interpreter holds itself.
+func isTempTarget(name string) bool {
+	return IsTempTable(name) || IsTableVariable(name)
+}
+
+// dialectName names the backend of dialect.
+func dialectName(d Dialect) string {
+	switch d {
+	case DialectPostgres:
+		return "postgres"
+	case DialectMySQL:
+		return "mysql"
+	case DialectSQLite:
+		return "sqlite"
+	case DialectSQLServer:
+		return "sqlserver"
+	}
+	return "generic"
+}
+
+// sqlitePlan returns SQLite's EXPLAIN QUERY PLAN of query as a tree: each
+// row names its parent's id, 0 for the top.
+func (i *Interpreter) sqlitePlan(ctx context.Context, query string, args []interface{}) ([]*PlanNode, error) {
+	rows, err := i.ctx.GetExecutor().QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roots []*PlanNode
+	nodes := make(map[int64]*PlanNode)
+	for rows.Next() {
+		var id, parent, unused int64
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return nil, err
+		}
+		node := &PlanNode{Detail: detail}
+		nodes[id] = node
+		if p, ok := nodes[parent]; ok {
+			p.Children = append(p.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots, rows.Err()
+}
+
+// postgresPlan returns PostgreSQL's EXPLAIN (FORMAT JSON) of query. The
+// top node also holds the planning and execution times.
+func (i *Interpreter) postgresPlan(ctx context.Context, query string, args []interface{}, analyze bool) ([]*PlanNode, error) {
+	options := "FORMAT JSON"
+	if analyze {
+		options = "ANALYZE, " + options
+	}
+	var doc string
+	if err := i.ctx.GetExecutor().QueryRowContext(ctx, "EXPLAIN ("+options+") "+query, args...).Scan(&doc); err != nil {
+		return nil, err
+	}
+	var explained []map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &explained); err != nil {
+		return nil, err
+	}
+
+	var roots []*PlanNode
+	for _, e := range explained {
+		top, _ := e["Plan"].(map[string]interface{})
+		node := postgresNode(top)
+		for key, v := range e {
+			if key != "Plan" {
+				if node.Properties == nil {
+					node.Properties = make(map[string]interface{})
+				}
+				node.Properties[key] = v
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots, nil
+}
+
+// postgresNode converts a node of PostgreSQL's JSON plan, such as
+// {"Node Type": "Index Scan", "Relation Name": "orders", "Plans": [...]}.
+func postgresNode(m map[string]interface{}) *PlanNode {
+	node := &PlanNode{Properties: make(map[string]interface{})}
+	detail, _ := m["Node Type"].(string)
+	if rel, ok := m["Relation Name"].(string); ok {
+		detail += " on " + rel
+	}
+	if index, ok := m["Index Name"].(string); ok {
+		detail += " using " + index
+	}
+	node.Detail = detail
+	for key, v := range m {
+		switch key {
+		case "Node Type":
+		case "Plans":
+			children, _ := v.([]interface{})
+			for _, c := range children {
+				if child, ok := c.(map[string]interface{}); ok {
+					node.Children = append(node.Children, postgresNode(child))
+				}
+			}
+		default:
+			node.Properties[key] = v
+		}
+	}
+	return node
+}
+
+// genericPlan runs an EXPLAIN whose rows are a flat list of steps, as
+// MySQL's are, making each row a node.
+func (i *Interpreter) genericPlan(ctx context.Context, query string, args []interface{}) ([]*PlanNode, error) {
+	rows, err := i.ctx.GetExecutor().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*PlanNode
+	scanner := newRowScanner(len(columns))
+	for rows.Next() {
+		row, err := scanner.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		node := &PlanNode{}
+		var detail []string
+		for j, v := range row {
+			if v.IsNull {
+				continue
+			}
+			if len(columns) == 1 {
+				detail = append(detail, v.AsString())
+				continue
+			}
+			detail = append(detail, columns[j]+"="+v.AsString())
+			if node.Properties == nil {
+				node.Properties = make(map[string]interface{})
+			}
+			node.Properties[columns[j]] = FromValue(v)
+		}
+		node.Detail = strings.Join(detail, " ")
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
@@ -0,0 +1,77 @@
+package tsqlruntime
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseExplain(t *testing.T) {
+	tests := []struct {
+		batch string
+		mode  ExplainMode
+		rest  string
+	}{
+		{"EXPLAIN SELECT 1", ExplainPlan, "SELECT 1"},
+		{"  explain\n\tanalyze UPDATE t SET a = 1", ExplainAnalyze, "UPDATE t SET a = 1"},
+		{"EXPLAIN ANALYZED", ExplainPlan, "ANALYZED"},
+		{"EXPLAINED", ExplainOff, "EXPLAINED"},
+		{"SELECT 'EXPLAIN'", ExplainOff, "SELECT 'EXPLAIN'"},
+	}
+	for _, tc := range tests {
+		if mode, rest := ParseExplain(tc.batch); mode != tc.mode || rest != tc.rest {
+			t.Errorf("ParseExplain(%q) = %v, %q", tc.batch, mode, rest)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	interp := sysprocSetup(t)
+	interp.SetExplain(ExplainPlan)
+	ctx := context.Background()
+
+	result, err := interp.Execute(ctx, `
+		DECLARE @id INT = 1
+		SELECT TOP 1 qty FROM orders WHERE id = @id
+		UPDATE orders SET qty = 0
+		CREATE TABLE notes (id INT)
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.ResultSets) != 2 {
+		t.Fatalf("got %d result sets", len(result.ResultSets))
+	}
+	rs := result.ResultSets[0]
+	if len(rs.Columns) != 1 || rs.Columns[0] != PlanColumn {
+		t.Fatalf("columns %v", rs.Columns)
+	}
+	var plan QueryPlan
+	if err := json.Unmarshal([]byte(rs.Rows[0][0].AsString()), &plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Backend != "sqlite" || len(plan.Args) != 1 || !strings.Contains(plan.SQL, "LIMIT") ||
+		!strings.Contains(plan.SQL, "?") {
+		t.Errorf("got %+v", plan)
+	}
+	var steps []string
+	for _, step := range plan.Rewrites {
+		steps = append(steps, step.Step)
+	}
+	if strings.Join(steps, ",") != "dialect,parameters" || plan.Rewrites[1].SQL != plan.SQL {
+		t.Errorf("rewrites %+v", plan.Rewrites)
+	}
+	if len(plan.Plan) == 0 || !strings.Contains(plan.Plan[0].Detail, "orders") {
+		t.Errorf("plan %+v", plan.Plan)
+	}
+
+	// Neither the UPDATE nor the CREATE TABLE ran
+	var qty, tables int
+	if err := interp.ctx.DB.QueryRow("SELECT qty FROM orders").Scan(&qty); err != nil || qty != 5 {
+		t.Errorf("qty %d: %v", qty, err)
+	}
+	if err := interp.ctx.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'notes'").Scan(&tables); err != nil || tables != 0 {
+		t.Errorf("notes created: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// External functions are scalar functions defined outside the server, by
+// extensions such as WebAssembly modules, callable from T-SQL as name(...)
+// or dbo.name(...). They are looked up after the built-in functions, whose
+// names they may not take, and are registered on SQLite connections beside
+// SQLiteFunctions, so that queries the backend runs can call them.
+var (
+	externalMu        sync.RWMutex
+	externalFunctions = map[string]ExternalFunction{}
+)
+
+// ExternalFunction is an external function. ctx is the context of the
+// statement calling it, cancelled when the statement is.
+type ExternalFunction func(ctx context.Context, args []Value) (Value, error)
+
+// RegisterExternalFunction makes fn callable as name. It fails if name is
+// taken by a built-in function or another external function. Connections
+// the SQLite backend opened before the call do not have it.
+func RegisterExternalFunction(name string, fn ExternalFunction) error {
+	upper := strings.ToUpper(name)
+	if _, ok := NewFunctionRegistry().functions[upper]; ok || upper == "FEATURE" || upper == "STATS_DATE" {
+		return fmt.Errorf("function %s is a built-in function", name)
+	}
+	externalMu.Lock()
+	defer externalMu.Unlock()
+	if _, ok := externalFunctions[upper]; ok {
+		return fmt.Errorf("function %s is already registered", name)
+	}
+	externalFunctions[upper] = fn
+	return nil
+}
+
+// UnregisterExternalFunction removes the external function name.
+func UnregisterExternalFunction(name string) {
+	externalMu.Lock()
+	defer externalMu.Unlock()
+	delete(externalFunctions, strings.ToUpper(name))
+}
+
+// ExternalFunctions returns the external functions, by upper-case name.
+func ExternalFunctions() map[string]ExternalFunction {
+	externalMu.RLock()
+	defer externalMu.RUnlock()
+	fns := make(map[string]ExternalFunction, len(externalFunctions))
+	for name, fn := range externalFunctions {
+		fns[name] = fn
+	}
+	return fns
+}
+
+// externalFunction returns the external function called as name, which
+// may be qualified by the dbo schema.
+func externalFunction(name string) (ExternalFunction, bool) {
+	upper := strings.ToUpper(name)
+	upper = strings.TrimPrefix(upper, "DBO.")
+	externalMu.RLock()
+	defer externalMu.RUnlock()
+	fn, ok := externalFunctions[upper]
+	return fn, ok
+}
+
+// SQLiteExternalFunction adapts an external function to go-sqlite3's
+// RegisterFunc, taking any number of arguments. go-sqlite3 gives functions
+// no context, so these calls are bounded only by the function's own
+// limits.
+func SQLiteExternalFunction(fn ExternalFunction) func(args ...any) (any, error) {
+	return func(args ...any) (any, error) {
+		values := make([]Value, len(args))
+		for i, arg := range args {
+			values[i] = ToValue(arg)
+		}
+		v, err := fn(context.Background(), values)
+		if err != nil {
+			return nil, err
+		}
+		return FromValue(v), nil
+	}
+}
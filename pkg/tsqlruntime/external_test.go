@@ -0,0 +1,60 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestExternalFunctions(t *testing.T) {
+	ctx := context.Background()
+	interp := scopeSetup(t, newMockResolver())
+
+	twice := func(ctx context.Context, args []Value) (Value, error) {
+		return NewBigInt(2 * args[0].AsInt()), nil
+	}
+	if err := RegisterExternalFunction("twice", twice); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterExternalFunction("twice")
+	if err := RegisterExternalFunction("TWICE", twice); err == nil {
+		t.Error("registered twice twice")
+	}
+	if err := RegisterExternalFunction("len", twice); err == nil {
+		t.Error("registered over LEN")
+	}
+
+	for _, sql := range []string{
+		"SELECT twice(21)",
+		"SELECT dbo.TWICE(21)",
+		"DECLARE @n INT = dbo.twice(20) + 2; SELECT @n",
+	} {
+		result, err := interp.Execute(ctx, sql, nil)
+		if err != nil {
+			t.Errorf("%s: %v", sql, err)
+			continue
+		}
+		if got := lastRows(result); !reflect.DeepEqual(got, []string{"42"}) {
+			t.Errorf("%s: %q", sql, got)
+		}
+	}
+
+	UnregisterExternalFunction("twice")
+	if _, err := interp.Execute(ctx, "SELECT twice(21)", nil); err == nil {
+		t.Error("twice called after it was unregistered")
+	}
+
+	// A function is passed the context of the statement calling it
+	type key struct{}
+	var got interface{}
+	if err := RegisterExternalFunction("session_of", func(ctx context.Context, args []Value) (Value, error) {
+		got = ctx.Value(key{})
+		return NewInt(1), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterExternalFunction("session_of")
+	if _, err := interp.Execute(context.WithValue(ctx, key{}, "s1"), "DECLARE @n INT = session_of(); SELECT @n", nil); err != nil || got != "s1" {
+		t.Errorf("session_of() saw %v, %v", got, err)
+	}
+}
@@ -0,0 +1,132 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FeatureFunc reports whether the named feature flag is on for the
+// session running the code (see FEATURE).
+type FeatureFunc func(name string) bool
+
+// SetFeatures sets how FEATURE('name') is answered for this execution.
+// Without it every flag is off.
+func (i *Interpreter) SetFeatures(fn FeatureFunc) {
+	i.ctx.Features = fn
+}
+
+// feature answers FEATURE('name'). The first answer for a name holds for
+// the rest of the execution, nested calls included, so that a flag
+// changed part way through cannot send the code down half of each path.
+func (ec *ExecutionContext) feature(name string) bool {
+	key := strings.ToLower(name)
+	if on, ok := ec.featureValues[key]; ok {
+		return on
+	}
+	on := ec.Features != nil && ec.Features(name)
+	if ec.featureValues == nil {
+		ec.featureValues = make(map[string]bool)
+	}
+	ec.featureValues[key] = on
+	return on
+}
+
+// evaluateFeature evaluates FEATURE('name'): 1 when the flag is on for
+// the session, 0 when it is off or not defined.
+func (e *ExpressionEvaluator) evaluateFeature(args []Value) (Value, error) {
+	if len(args) != 1 {
+		return Value{}, fmt.Errorf("FEATURE takes 1 argument, the flag's name")
+	}
+	if args[0].IsNull {
+		return Null(TypeBit), nil
+	}
+	on := e.feature != nil && e.feature(args[0].AsString())
+	return NewBit(on), nil
+}
+
+// substituteFeatures replaces FEATURE('name') calls in a query for the
+// backend with their value, 1 or 0, since the backend has no such
+// function.
+func (i *Interpreter) substituteFeatures(query string) string {
+	const fn = "FEATURE"
+	upper := strings.ToUpper(query)
+	if !strings.Contains(upper, fn) {
+		return query
+	}
+	var b strings.Builder
+	pos := 0
+	for {
+		at := strings.Index(upper[pos:], fn)
+		if at < 0 {
+			break
+		}
+		at += pos
+		end := at + len(fn)
+		if at > 0 && (isAlphaNum(query[at-1]) || query[at-1] == '_' || query[at-1] == '@' || query[at-1] == '.') {
+			b.WriteString(query[pos:end])
+			pos = end
+			continue
+		}
+		name, n, ok := featureCallArg(query[end:])
+		if !ok {
+			b.WriteString(query[pos:end])
+			pos = end
+			continue
+		}
+		b.WriteString(query[pos:at])
+		if i.ctx.feature(name) {
+			b.WriteString("1")
+		} else {
+			b.WriteString("0")
+		}
+		pos = end + n
+	}
+	b.WriteString(query[pos:])
+	return b.String()
+}
+
+// featureCallArg reads "('name')", with optional spaces and N prefix,
+// from the start of s, returning the name and the bytes read.
+func featureCallArg(s string) (string, int, bool) {
+	i := 0
+	skip := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+			i++
+		}
+	}
+	skip()
+	if i >= len(s) || s[i] != '(' {
+		return "", 0, false
+	}
+	i++
+	skip()
+	if i < len(s) && (s[i] == 'N' || s[i] == 'n') {
+		i++
+	}
+	if i >= len(s) || s[i] != '\'' {
+		return "", 0, false
+	}
+	i++
+	var name strings.Builder
+	for {
+		if i >= len(s) {
+			return "", 0, false
+		}
+		if s[i] == '\'' {
+			if i+1 < len(s) && s[i+1] == '\'' {
+				name.WriteByte('\'')
+				i += 2
+				continue
+			}
+			i++
+			break
+		}
+		name.WriteByte(s[i])
+		i++
+	}
+	skip()
+	if i >= len(s) || s[i] != ')' {
+		return "", 0, false
+	}
+	return name.String(), i + 1, true
+}
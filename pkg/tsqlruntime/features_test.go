@@ -0,0 +1,74 @@
+package tsqlruntime
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestFeature(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE Orders (ID INTEGER, Total INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO Orders VALUES (1, 10), (2, 20)`); err != nil {
+		t.Fatal(err)
+	}
+
+	asked := make(map[string]int)
+	on := map[string]bool{"new_pricing": true}
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetFeatures(func(name string) bool {
+		asked[strings.ToLower(name)]++
+		return on[strings.ToLower(name)]
+	})
+
+	result, err := interp.Execute(context.Background(), `
+		DECLARE @Path VARCHAR(10)
+		IF FEATURE('New_Pricing') = 1
+			SET @Path = 'new'
+		ELSE
+			SET @Path = 'old'
+		SELECT @Path AS Path, FEATURE('dark_mode') AS DarkMode, FEATURE(NULL) AS Unnamed
+		SELECT ID FROM Orders WHERE FEATURE('new_pricing') = 1 AND Total > 15
+		SELECT COUNT(*) AS N FROM Orders WHERE FEATURE ( N'dark_mode' ) = 1
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.ResultSets) != 3 {
+		t.Fatalf("got %d result sets", len(result.ResultSets))
+	}
+	row := result.ResultSets[0].Rows[0]
+	if row[0].AsString() != "new" || row[1].AsString() == "1" || !row[2].IsNull {
+		t.Errorf("scalar results: %v", row)
+	}
+	if rows := result.ResultSets[1].Rows; len(rows) != 1 || rows[0][0].AsString() != "2" {
+		t.Errorf("backend query with the flag on: %v", rows)
+	}
+	if rows := result.ResultSets[2].Rows; len(rows) != 1 || rows[0][0].AsString() != "0" {
+		t.Errorf("backend query with the flag off: %v", rows)
+	}
+	// Each flag is asked once per execution
+	if asked["new_pricing"] != 1 || asked["dark_mode"] != 1 {
+		t.Errorf("asked %v", asked)
+	}
+}
+
+func TestSubstituteFeatures(t *testing.T) {
+	interp := NewInterpreter(nil, DialectSQLite)
+	interp.SetFeatures(func(name string) bool { return name == "a" })
+
+	tests := []struct{ in, want string }{
+		{"SELECT * FROM t WHERE FEATURE('a') = 1", "SELECT * FROM t WHERE 1 = 1"},
+		{"SELECT feature('b'), Feature( 'a' )", "SELECT 0, 1"},
+		{"SELECT MYFEATURE('a'), t.FEATURE, FEATURES", "SELECT MYFEATURE('a'), t.FEATURE, FEATURES"},
+		{"SELECT FEATURE(@name)", "SELECT FEATURE(@name)"},
+	}
+	for _, tt := range tests {
+		if got := interp.substituteFeatures(tt.in); got != tt.want {
+			t.Errorf("substituteFeatures(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
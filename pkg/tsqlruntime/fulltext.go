@@ -0,0 +1,875 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// Full-text search is emulated on SQLite with FTS virtual tables. CREATE
+// FULLTEXT INDEX ON t (a, b) creates an external-content FTS table over
+// t's rows, keeps it current with triggers on t and fills it from the rows
+// already there. CONTAINS and FREETEXT predicates in a WHERE or HAVING
+// clause then become lookups of the matching rowids:
+//
+//	CONTAINS(notes, '"steel*" AND NOT brass')
+//	  -> t.rowid IN (SELECT rowid FROM aul_fts_t WHERE notes MATCH '("steel" * NOT "brass")')
+//
+// The FTS table is FTS5 when the SQLite build includes it and FTS4
+// otherwise. Full-text catalogs have nothing to emulate and are accepted
+// as no-ops. Against SQL Server the statements are sent to the backend
+// unchanged.
+
+// FullTextTablePrefix begins the name of the FTS table behind a table's
+// full-text index: the index on orders is kept in aul_fts_orders.
+const FullTextTablePrefix = "aul_fts_"
+
+// fullTextTriggerSuffixes name the triggers that maintain an FTS table,
+// after the table: aul_fts_orders_ai and so on. FTS5 tables use the
+// after triggers only.
+var fullTextTriggerSuffixes = []string{"_ai", "_ad", "_au", "_bu", "_bd"}
+
+// fullTextIndex describes the FTS table behind a full-text index.
+type fullTextIndex struct {
+	table   string   // the FTS table
+	fts5    bool     // FTS5 rather than FTS4
+	columns []string // the indexed columns
+}
+
+// fullTextTableName returns the FTS table for table's full-text index.
+func fullTextTableName(table string) string {
+	parts := splitObjectName(table)
+	return FullTextTablePrefix + strings.ToLower(parts[len(parts)-1])
+}
+
+// fullTextIndexOn returns the full-text index on table, or nil if it has
+// none.
+func (i *Interpreter) fullTextIndexOn(ctx context.Context, table string) (*fullTextIndex, error) {
+	name := fullTextTableName(table)
+	var def string
+	err := i.ctx.GetExecutor().QueryRowContext(ctx,
+		"SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&def)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns, err := i.tableColumns(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &fullTextIndex{
+		table:   name,
+		fts5:    strings.Contains(strings.ToUpper(def), "FTS5"),
+		columns: columns,
+	}, nil
+}
+
+// fts5Available reports whether the SQLite build includes FTS5.
+func (i *Interpreter) fts5Available(ctx context.Context) bool {
+	rows, err := i.ctx.GetExecutor().QueryContext(ctx, "PRAGMA compile_options")
+	if err != nil {
+		return false
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var option string
+		if rows.Scan(&option) == nil && option == "ENABLE_FTS5" {
+			return true
+		}
+	}
+	return false
+}
+
+// notFullTextIndexed returns SQL Server's error for a predicate on a table
+// without a full-text index.
+func notFullTextIndexed(table string) error {
+	return NewSQLError(ErrNotFullTextIndexed, fmt.Sprintf(
+		"Cannot use a CONTAINS or FREETEXT predicate on table or indexed view '%s' because it is not full-text indexed.", table))
+}
+
+// -----------------------------------------------------------------------------
+// Full-text DDL
+// -----------------------------------------------------------------------------
+
+// executeFulltextStatement runs full-text index and catalog DDL.
+func (i *Interpreter) executeFulltextStatement(ctx context.Context, stmt ast.Statement) error {
+	switch i.ctx.Dialect {
+	case DialectSQLServer:
+		_, err := i.exec(ctx, stmt.String())
+		return err
+	case DialectSQLite:
+	default:
+		return fmt.Errorf("full-text indexes are only emulated on SQLite")
+	}
+
+	switch s := stmt.(type) {
+	case *ast.CreateFulltextIndexStatement:
+		var columns []string
+		for _, col := range s.Columns {
+			columns = append(columns, col.Name)
+		}
+		return i.createFullTextIndex(ctx, s.TableName.String(), columns)
+	case *ast.AlterFulltextIndexStatement:
+		return i.alterFullTextIndex(ctx, s)
+	case *ast.DropFulltextIndexStatement:
+		return i.dropFullTextIndex(ctx, s.TableName.String())
+	}
+	// Catalogs only organise SQL Server's own index storage
+	return nil
+}
+
+// createFullTextIndex indexes columns of table.
+func (i *Interpreter) createFullTextIndex(ctx context.Context, table string, columns []string) error {
+	tableColumns, err := i.tableColumns(ctx, table)
+	if err != nil {
+		return NewSQLError(ErrInvalidObject, fmt.Sprintf("Invalid object name '%s'.", table))
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("a full-text index on '%s' needs at least one column", table)
+	}
+	for _, col := range columns {
+		if !containsFold(tableColumns, col) {
+			return NewSQLError(ErrInvalidColumn, fmt.Sprintf("Invalid column name '%s'.", col))
+		}
+	}
+
+	existing, err := i.fullTextIndexOn(ctx, table)
+	if err != nil {
+		return fmt.Errorf("full-text index error: %w", err)
+	}
+	if existing != nil {
+		return NewSQLError(ErrFullTextExists, fmt.Sprintf(
+			"A full-text index for table or indexed view '%s' has already been created.", table))
+	}
+
+	idx := &fullTextIndex{table: fullTextTableName(table), fts5: i.fts5Available(ctx), columns: columns}
+	return i.execFullTextDDL(ctx, idx.create(table))
+}
+
+// alterFullTextIndex adds or drops indexed columns, which rebuilds the FTS
+// table, or repopulates it. Enabling, disabling and change tracking have
+// no counterpart: the triggers always keep the index current.
+func (i *Interpreter) alterFullTextIndex(ctx context.Context, s *ast.AlterFulltextIndexStatement) error {
+	table := s.TableName.String()
+	idx, err := i.fullTextIndexOn(ctx, table)
+	if err != nil {
+		return fmt.Errorf("full-text index error: %w", err)
+	}
+	if idx == nil {
+		return notFullTextIndexed(table)
+	}
+
+	switch {
+	case s.Action == "ADD" || s.Action == "DROP":
+		columns := idx.columns
+		if s.Action == "ADD" {
+			for _, col := range s.Columns {
+				if !containsFold(columns, col.Name) {
+					columns = append(columns, col.Name)
+				}
+			}
+		} else {
+			columns = nil
+			for _, col := range idx.columns {
+				drop := false
+				for _, c := range s.Columns {
+					drop = drop || strings.EqualFold(c.Name, col)
+				}
+				if !drop {
+					columns = append(columns, col)
+				}
+			}
+		}
+		if err := i.dropFullTextIndex(ctx, table); err != nil {
+			return err
+		}
+		return i.createFullTextIndex(ctx, table, columns)
+
+	case strings.HasPrefix(s.Action, "START"):
+		return i.execFullTextDDL(ctx, []string{idx.rebuild()})
+	}
+	return nil
+}
+
+// dropFullTextIndex removes table's full-text index and its triggers.
+func (i *Interpreter) dropFullTextIndex(ctx context.Context, table string) error {
+	idx, err := i.fullTextIndexOn(ctx, table)
+	if err != nil {
+		return fmt.Errorf("full-text index error: %w", err)
+	}
+	if idx == nil {
+		return notFullTextIndexed(table)
+	}
+	var stmts []string
+	for _, suffix := range fullTextTriggerSuffixes {
+		stmts = append(stmts, "DROP TRIGGER IF EXISTS "+idx.table+suffix)
+	}
+	return i.execFullTextDDL(ctx, append(stmts, "DROP TABLE "+idx.table))
+}
+
+// execFullTextDDL runs stmts together, in the current transaction or one
+// of their own.
+func (i *Interpreter) execFullTextDDL(ctx context.Context, stmts []string) (err error) {
+	var exec QueryExecutor
+	if i.ctx.Tx != nil {
+		exec = i.ctx.Tx
+	} else {
+		var tx *sql.Tx
+		if tx, err = i.ctx.DB.BeginTx(ctx, nil); err != nil {
+			return fmt.Errorf("full-text index error: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			} else if cerr := tx.Commit(); cerr != nil {
+				err = fmt.Errorf("full-text index error: %w", cerr)
+			}
+		}()
+		exec = tx
+	}
+
+	for _, stmt := range stmts {
+		if i.LogRewritten && i.LogFunc != nil {
+			i.LogFunc("REWRITTEN query=%s", stmt)
+		}
+		if _, err = exec.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("full-text index error: %w", err)
+		}
+	}
+	return nil
+}
+
+// create returns the statements that create the FTS table over content,
+// the triggers that maintain it and its initial population.
+func (idx *fullTextIndex) create(content string) []string {
+	parts := splitObjectName(content)
+	content = parts[len(parts)-1]
+	columns := strings.Join(idx.columns, ", ")
+	values := func(row string) string {
+		vals := make([]string, len(idx.columns))
+		for j, col := range idx.columns {
+			vals[j] = row + "." + col
+		}
+		return strings.Join(vals, ", ")
+	}
+	trigger := func(suffix, event, body string) string {
+		return fmt.Sprintf("CREATE TRIGGER %s%s %s ON %s BEGIN %s END", idx.table, suffix, event, content, body)
+	}
+	insert := func(rowid string) string {
+		return fmt.Sprintf("INSERT INTO %s(%s, %s) VALUES (new.rowid, %s);", idx.table, rowid, columns, values("new"))
+	}
+
+	if idx.fts5 {
+		remove := fmt.Sprintf("INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s);",
+			idx.table, idx.table, columns, values("old"))
+		return []string{
+			fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, content='%s')", idx.table, columns, content),
+			trigger("_ai", "AFTER INSERT", insert("rowid")),
+			trigger("_ad", "AFTER DELETE", remove),
+			trigger("_au", "AFTER UPDATE", remove+" "+insert("rowid")),
+			idx.rebuild(),
+		}
+	}
+
+	// FTS4 reads the old values from the content table, so they are
+	// removed before it changes
+	remove := fmt.Sprintf("DELETE FROM %s WHERE docid = old.rowid;", idx.table)
+	return []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts4(content=\"%s\", %s)", idx.table, content, columns),
+		trigger("_bu", "BEFORE UPDATE", remove),
+		trigger("_bd", "BEFORE DELETE", remove),
+		trigger("_au", "AFTER UPDATE", insert("docid")),
+		trigger("_ai", "AFTER INSERT", insert("docid")),
+		idx.rebuild(),
+	}
+}
+
+// rebuild returns the statement that repopulates the FTS table from its
+// content table.
+func (idx *fullTextIndex) rebuild() string {
+	return fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", idx.table, idx.table)
+}
+
+// -----------------------------------------------------------------------------
+// CONTAINS and FREETEXT
+// -----------------------------------------------------------------------------
+
+// rewriteFullText returns stmt with its CONTAINS and FREETEXT predicates
+// replaced by lookups in FTS tables. Search terms are evaluated now, so
+// the nodes on the way to a predicate are copied rather than changed: a
+// loop executing the statement again sees the original predicate.
+func (i *Interpreter) rewriteFullText(stmt ast.Statement) (out ast.Statement, err error) {
+	if i.ctx.Dialect != DialectSQLite {
+		return stmt, nil
+	}
+	if i.plan != nil {
+		defer func() {
+			if err == nil && out != stmt {
+				i.rewriteStep("full-text", out.String())
+			}
+		}()
+	}
+	r := &fullTextRewriter{i: i, ctx: context.Background(), indexes: make(map[string]*fullTextIndex)}
+
+	switch s := stmt.(type) {
+	case *ast.SelectStatement:
+		return r.query(s)
+	case *ast.InsertStatement:
+		sel, err := r.query(s.Select)
+		if err != nil || sel == s.Select {
+			return s, err
+		}
+		c := *s
+		c.Select = sel
+		return &c, nil
+	case *ast.UpdateStatement:
+		return r.where(s, s.Table, s.Alias, s.From, s.Where, func(where ast.Expression) ast.Statement {
+			c := *s
+			c.Where = where
+			return &c
+		})
+	case *ast.DeleteStatement:
+		return r.where(s, s.Table, s.Alias, s.From, s.Where, func(where ast.Expression) ast.Statement {
+			c := *s
+			c.Where = where
+			return &c
+		})
+	}
+	return stmt, nil
+}
+
+// tableBinding is a table a query reads, under the name the query refers
+// to it by.
+type tableBinding struct {
+	name  string // alias, or the table's own name
+	table string
+}
+
+// fullTextRewriter replaces the full-text predicates of one statement.
+type fullTextRewriter struct {
+	i       *Interpreter
+	ctx     context.Context
+	scopes  [][]tableBinding          // the tables of each enclosing query, innermost last
+	indexes map[string]*fullTextIndex // looked-up indexes by table, nil for none
+}
+
+// query rewrites the WHERE and HAVING clauses of s and its UNION branches.
+func (r *fullTextRewriter) query(s *ast.SelectStatement) (*ast.SelectStatement, error) {
+	if s == nil {
+		return s, nil
+	}
+
+	var scope []tableBinding
+	if s.From != nil {
+		for _, ref := range s.From.Tables {
+			scope = bindTables(scope, ref)
+		}
+	}
+	r.scopes = append(r.scopes, scope)
+	where, err := r.expr(s.Where)
+	if err == nil {
+		var having ast.Expression
+		if having, err = r.expr(s.Having); err == nil && (where != s.Where || having != s.Having) {
+			c := *s
+			c.Where, c.Having = where, having
+			s = &c
+		}
+	}
+	r.scopes = r.scopes[:len(r.scopes)-1]
+	if err != nil || s.Union == nil {
+		return s, err
+	}
+
+	right, err := r.query(s.Union.Right)
+	if err != nil || right == s.Union.Right {
+		return s, err
+	}
+	c, u := *s, *s.Union
+	u.Right = right
+	c.Union = &u
+	return &c, nil
+}
+
+// where rewrites the WHERE clause of an UPDATE or DELETE, returning stmt
+// or the copy rebuild makes of it.
+func (r *fullTextRewriter) where(stmt ast.Statement, table *ast.QualifiedIdentifier, alias *ast.Identifier,
+	from *ast.FromClause, where ast.Expression, rebuild func(ast.Expression) ast.Statement) (ast.Statement, error) {
+	var scope []tableBinding
+	if table != nil {
+		scope = bindTables(scope, &ast.TableName{Name: table, Alias: alias})
+	}
+	if from != nil {
+		for _, ref := range from.Tables {
+			scope = bindTables(scope, ref)
+		}
+	}
+	r.scopes = append(r.scopes, scope)
+	rewritten, err := r.expr(where)
+	r.scopes = r.scopes[:len(r.scopes)-1]
+	if err != nil || rewritten == where {
+		return stmt, err
+	}
+	return rebuild(rewritten), nil
+}
+
+// bindTables adds the tables ref reads to scope.
+func bindTables(scope []tableBinding, ref ast.TableReference) []tableBinding {
+	switch t := ref.(type) {
+	case *ast.TableName:
+		if t.Name == nil {
+			break
+		}
+		parts := splitObjectName(t.Name.String())
+		name := parts[len(parts)-1]
+		if t.Alias != nil {
+			name = t.Alias.Value
+		}
+		scope = append(scope, tableBinding{name: name, table: t.Name.String()})
+	case *ast.JoinClause:
+		scope = bindTables(bindTables(scope, t.Left), t.Right)
+	case *ast.ParenthesizedTableRef:
+		scope = bindTables(scope, t.Inner)
+	}
+	return scope
+}
+
+// expr rewrites the predicates in e, copying the nodes above them.
+func (r *fullTextRewriter) expr(e ast.Expression) (ast.Expression, error) {
+	switch e := e.(type) {
+	case *ast.ContainsExpression:
+		return r.predicate(e.Columns, e.SearchTerm, false)
+	case *ast.FreetextExpression:
+		return r.predicate(e.Columns, e.SearchTerm, true)
+	case *ast.InfixExpression:
+		left, err := r.expr(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.expr(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		if left != e.Left || right != e.Right {
+			c := *e
+			c.Left, c.Right = left, right
+			return &c, nil
+		}
+	case *ast.PrefixExpression:
+		right, err := r.expr(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		if right != e.Right {
+			c := *e
+			c.Right = right
+			return &c, nil
+		}
+	case *ast.SubqueryExpression:
+		sel, err := r.query(e.Subquery)
+		if err != nil {
+			return nil, err
+		}
+		if sel != e.Subquery {
+			c := *e
+			c.Subquery = sel
+			return &c, nil
+		}
+	case *ast.ExistsExpression:
+		sel, err := r.query(e.Subquery)
+		if err != nil {
+			return nil, err
+		}
+		if sel != e.Subquery {
+			c := *e
+			c.Subquery = sel
+			return &c, nil
+		}
+	case *ast.InExpression:
+		sel, err := r.query(e.Subquery)
+		if err != nil {
+			return nil, err
+		}
+		if sel != e.Subquery {
+			c := *e
+			c.Subquery = sel
+			return &c, nil
+		}
+	case *ast.SelectStatement:
+		return r.query(e)
+	}
+	return e, nil
+}
+
+// index returns the full-text index on table, looking each table up once.
+func (r *fullTextRewriter) index(table string) (*fullTextIndex, error) {
+	key := strings.ToLower(table)
+	if idx, ok := r.indexes[key]; ok {
+		return idx, nil
+	}
+	idx, err := r.i.fullTextIndexOn(r.ctx, table)
+	if err != nil {
+		return nil, fmt.Errorf("full-text index error: %w", err)
+	}
+	r.indexes[key] = idx
+	return idx, nil
+}
+
+// resolve finds the table whose full-text index a predicate on columns
+// searches: the one qualifier names, or else the nearest with an index
+// covering every column.
+func (r *fullTextRewriter) resolve(qualifier string, columns []string) (tableBinding, *fullTextIndex, error) {
+	covers := func(idx *fullTextIndex) bool {
+		for _, col := range columns {
+			if col != "*" && !containsFold(idx.columns, col) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var first *tableBinding
+	indexed := false
+	for s := len(r.scopes) - 1; s >= 0; s-- {
+		for _, b := range r.scopes[s] {
+			if qualifier != "" && !strings.EqualFold(b.name, qualifier) {
+				continue
+			}
+			idx, err := r.index(b.table)
+			if err != nil {
+				return b, nil, err
+			}
+			if idx != nil && covers(idx) {
+				return b, idx, nil
+			}
+			if first == nil {
+				b := b
+				first = &b
+			}
+			indexed = indexed || idx != nil
+		}
+	}
+
+	switch {
+	case first == nil:
+		return tableBinding{}, nil, NewSQLError(ErrInvalidObject,
+			fmt.Sprintf("Invalid object name '%s'.", qualifier))
+	case indexed:
+		return *first, nil, NewSQLError(ErrNotFullTextIndexed, fmt.Sprintf(
+			"Cannot use a CONTAINS or FREETEXT predicate on column '%s' because it is not full-text indexed.",
+			strings.Join(columns, ", ")))
+	}
+	return *first, nil, notFullTextIndexed(first.table)
+}
+
+// predicate returns the rowid lookup for a CONTAINS or FREETEXT predicate
+// searching columns for term.
+func (r *fullTextRewriter) predicate(columns []string, term ast.Expression, freetext bool) (ast.Expression, error) {
+	qualifier := ""
+	names := make([]string, len(columns))
+	for j, col := range columns {
+		parts := splitObjectName(col)
+		names[j] = parts[len(parts)-1]
+		if len(parts) > 1 {
+			qualifier = parts[len(parts)-2]
+		}
+	}
+	b, idx, err := r.resolve(qualifier, names)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := r.i.evaluator.Evaluate(term)
+	if err != nil {
+		return nil, err
+	}
+	if val.IsNull || strings.TrimSpace(val.AsString()) == "" {
+		return nil, NewSQLError(ErrFullTextEmpty, "Null or empty full-text predicate.")
+	}
+
+	var query string
+	if freetext {
+		query = freetextQuery(val.AsString())
+	} else if query, err = containsQuery(val.AsString(), idx.fts5); err != nil {
+		return nil, err
+	}
+
+	// Nothing but punctuation matches nothing
+	lookup := "(1 = 0)"
+	if query != "" {
+		// The query holds only words, operators, double quotes and
+		// parentheses, so it needs no escaping
+		var selects []string
+		for _, name := range names {
+			if name == "*" {
+				name = idx.table
+			}
+			selects = append(selects, fmt.Sprintf("SELECT rowid FROM %s WHERE %s MATCH '%s'", idx.table, name, query))
+		}
+		lookup = fmt.Sprintf("%s.rowid IN (%s)", b.name, strings.Join(selects, " UNION "))
+	}
+	return &ast.Identifier{Value: lookup}, nil
+}
+
+// -----------------------------------------------------------------------------
+// Search conditions
+// -----------------------------------------------------------------------------
+
+// fullTextWords splits s into the words an FTS tokenizer would index.
+func fullTextWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// freetextQuery returns the FTS query for a FREETEXT search: rows with any
+// of text's words.
+func freetextQuery(text string) string {
+	words := fullTextWords(text)
+	for j, w := range words {
+		words[j] = `"` + w + `"`
+	}
+	return strings.Join(words, " OR ")
+}
+
+// containsQuery translates a CONTAINS search condition into an FTS query.
+// Words, "phrases", "prefix*" terms, AND (&), OR (|), AND NOT (&!), NEAR
+// (~), parentheses and FORMSOF are understood; a term's words are matched
+// as a phrase.
+func containsQuery(cond string, fts5 bool) (string, error) {
+	p := &containsParser{cond: cond}
+	if err := p.tokenize(); err != nil {
+		return "", err
+	}
+	node, err := p.or()
+	if err != nil {
+		return "", err
+	}
+	if p.pos < len(p.tokens) {
+		return "", p.syntaxError(p.tokens[p.pos].text)
+	}
+	return node.render(fts5), nil
+}
+
+// containsToken is a token of a CONTAINS search condition.
+type containsToken struct {
+	text   string
+	phrase bool // a double-quoted string
+}
+
+// containsNode is a term or an operator applied to two conditions.
+type containsNode struct {
+	op          string // AND, OR, NOT or NEAR; empty for a term
+	left, right *containsNode
+	words       []string
+	prefix      bool
+}
+
+// containsParser parses a CONTAINS search condition.
+type containsParser struct {
+	cond   string
+	tokens []containsToken
+	pos    int
+}
+
+func (p *containsParser) syntaxError(near string) error {
+	return NewSQLError(ErrFullTextSyntax, fmt.Sprintf(
+		"Syntax error near '%s' in the full-text search condition '%s'.", near, p.cond))
+}
+
+func (p *containsParser) tokenize() error {
+	runes := []rune(p.cond)
+	for j := 0; j < len(runes); {
+		ch := runes[j]
+		switch {
+		case unicode.IsSpace(ch):
+			j++
+		case ch == '"':
+			end := j + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return p.syntaxError(string(runes[j:]))
+			}
+			p.tokens = append(p.tokens, containsToken{text: string(runes[j+1 : end]), phrase: true})
+			j = end + 1
+		case ch == '&' && j+1 < len(runes) && runes[j+1] == '!':
+			p.tokens = append(p.tokens, containsToken{text: "&!"})
+			j += 2
+		case strings.ContainsRune("(),&|~", ch):
+			p.tokens = append(p.tokens, containsToken{text: string(ch)})
+			j++
+		default:
+			end := j
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`"(),&|~`, runes[end]) {
+				end++
+			}
+			p.tokens = append(p.tokens, containsToken{text: string(runes[j:end])})
+			j = end
+		}
+	}
+	return nil
+}
+
+// peek returns the next token as an upper-case operator, or "" for a
+// phrase or the end of the condition.
+func (p *containsParser) peek() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].phrase {
+		return ""
+	}
+	return strings.ToUpper(p.tokens[p.pos].text)
+}
+
+// expect consumes the operator op.
+func (p *containsParser) expect(op string) error {
+	if p.peek() != op {
+		if p.pos < len(p.tokens) {
+			return p.syntaxError(p.tokens[p.pos].text)
+		}
+		return p.syntaxError(op)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *containsParser) or() (*containsNode, error) {
+	left, err := p.and()
+	for err == nil && (p.peek() == "OR" || p.peek() == "|") {
+		p.pos++
+		var right *containsNode
+		if right, err = p.and(); err == nil {
+			left = &containsNode{op: "OR", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *containsParser) and() (*containsNode, error) {
+	left, err := p.near()
+	for err == nil {
+		op := "AND"
+		switch p.peek() {
+		case "AND", "&":
+			p.pos++
+			if p.peek() == "NOT" {
+				p.pos++
+				op = "NOT"
+			}
+		case "&!":
+			p.pos++
+			op = "NOT"
+		default:
+			return left, nil
+		}
+		var right *containsNode
+		if right, err = p.near(); err == nil {
+			left = &containsNode{op: op, left: left, right: right}
+		}
+	}
+	return nil, err
+}
+
+func (p *containsParser) near() (*containsNode, error) {
+	left, err := p.primary()
+	for err == nil && (p.peek() == "NEAR" || p.peek() == "~") {
+		p.pos++
+		var right *containsNode
+		if right, err = p.primary(); err == nil {
+			left = &containsNode{op: "NEAR", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *containsParser) primary() (*containsNode, error) {
+	if p.pos >= len(p.tokens) {
+		near := p.cond
+		if len(p.tokens) > 0 {
+			near = p.tokens[len(p.tokens)-1].text
+		}
+		return nil, p.syntaxError(near)
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	if tok.phrase {
+		return p.term(tok)
+	}
+
+	switch strings.ToUpper(tok.text) {
+	case "(":
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	case "FORMSOF":
+		// FORMSOF(INFLECTIONAL | THESAURUS, term, ...) matches any of the
+		// terms; there is no stemmer or thesaurus to add other forms
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		p.pos++ // the generation type
+		var node *containsNode
+		for p.peek() == "," {
+			p.pos++
+			term, err := p.primary()
+			if err != nil {
+				return nil, err
+			}
+			if node == nil {
+				node = term
+			} else {
+				node = &containsNode{op: "OR", left: node, right: term}
+			}
+		}
+		if node == nil {
+			return nil, p.syntaxError(tok.text)
+		}
+		return node, p.expect(")")
+	case ")", ",", "AND", "OR", "NOT", "NEAR", "&", "|", "&!", "~":
+		return nil, p.syntaxError(tok.text)
+	}
+	return p.term(tok)
+}
+
+// term returns the node matching tok's words as a phrase; a trailing *
+// makes the last word a prefix.
+func (p *containsParser) term(tok containsToken) (*containsNode, error) {
+	words := fullTextWords(tok.text)
+	if len(words) == 0 {
+		return nil, p.syntaxError(tok.text)
+	}
+	return &containsNode{words: words, prefix: strings.HasSuffix(strings.TrimSpace(tok.text), "*")}, nil
+}
+
+// render writes n in FTS5 or FTS4 query syntax.
+func (n *containsNode) render(fts5 bool) string {
+	switch n.op {
+	case "":
+		phrase := strings.Join(n.words, " ")
+		switch {
+		case !n.prefix:
+			return `"` + phrase + `"`
+		case fts5:
+			return `"` + phrase + `" *`
+		default:
+			return `"` + phrase + `*"`
+		}
+	case "NEAR":
+		// Both FTS versions apply NEAR to phrases only
+		if n.left.op == "" && n.right.op == "" {
+			if fts5 {
+				return "NEAR(" + n.left.render(fts5) + " " + n.right.render(fts5) + ")"
+			}
+			return n.left.render(fts5) + " NEAR " + n.right.render(fts5)
+		}
+		return "(" + n.left.render(fts5) + " AND " + n.right.render(fts5) + ")"
+	}
+	return "(" + n.left.render(fts5) + " " + n.op + " " + n.right.render(fts5) + ")"
+}
@@ -0,0 +1,250 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// fullTextSetup returns an interpreter over a database holding docs with a
+// full-text index on its title and body.
+func fullTextSetup(t *testing.T) *Interpreter {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE docs (id INTEGER, title TEXT, body TEXT); " +
+		"INSERT INTO docs VALUES (1, 'Steel bolts', 'Zinc plated steel bolt for timber'), " +
+		"(2, 'Brass hinge', 'Solid brass hinge with screws'), " +
+		"(3, 'Stainless washers', 'Stainless steel washers, pack of fifty'), " +
+		"(4, 'Oak shelf', 'Oiled oak shelf with brackets'); " +
+		"CREATE TABLE notes (id INTEGER, body TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	interp := NewInterpreter(db, DialectSQLite)
+	if _, err := interp.Execute(context.Background(),
+		"CREATE FULLTEXT CATALOG docs_catalog AS DEFAULT; "+
+			"CREATE FULLTEXT INDEX ON dbo.docs (title, body LANGUAGE 1033) KEY INDEX pk_docs "+
+			"ON docs_catalog WITH CHANGE_TRACKING AUTO", nil); err != nil {
+		t.Fatal(err)
+	}
+	return interp
+}
+
+// fullTextRows runs sql and returns the rows of its last result set.
+func fullTextRows(t *testing.T, interp *Interpreter, sql string) []string {
+	t.Helper()
+	result, err := interp.Execute(context.Background(), sql, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	return lastRows(result)
+}
+
+func TestFullTextPredicates(t *testing.T) {
+	tests := []struct {
+		name  string
+		where string
+		want  []string
+	}{
+		{"word", "CONTAINS(body, 'steel')", []string{"1", "3"}},
+		{"prefix", `CONTAINS(body, '"stain*"')`, []string{"3"}},
+		{"and not", "CONTAINS(body, 'steel AND NOT bolt')", []string{"3"}},
+		{"symbol operators", "CONTAINS(body, 'steel &! washers')", []string{"1"}},
+		{"column list", "CONTAINS((title, body), 'hinge | shelf')", []string{"2", "4"}},
+		{"phrase over every column", `CONTAINS(*, '"brass hinge"')`, []string{"2"}},
+		{"one column only", "CONTAINS(title, 'steel')", []string{"1"}},
+		{"near", "CONTAINS(body, 'oak NEAR brackets')", []string{"4"}},
+		{"formsof", "CONTAINS(body, 'FORMSOF(INFLECTIONAL, screw, screws)')", []string{"2"}},
+		{"parentheses", "CONTAINS(body, '(zinc OR oak) AND NOT shelf')", []string{"1"}},
+		{"freetext", "FREETEXT(body, 'brass fittings for timber')", []string{"1", "2"}},
+		{"freetext every column", "FREETEXT(*, 'washers')", []string{"3"}},
+		{"with other conditions", "CONTAINS(body, 'steel') AND id > 1", []string{"3"}},
+		{"negated", "NOT CONTAINS(body, 'steel')", []string{"2", "4"}},
+		{"subquery", "id IN (SELECT id FROM docs WHERE CONTAINS(body, 'brass'))", []string{"2"}},
+	}
+
+	interp := fullTextSetup(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fullTextRows(t, interp, "SELECT id FROM docs WHERE "+tt.where+" ORDER BY id")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFullTextVariablesAndAliases(t *testing.T) {
+	interp := fullTextSetup(t)
+
+	got := fullTextRows(t, interp, `DECLARE @q NVARCHAR(40) = N'"plated steel"'; `+
+		"SELECT d.id FROM docs AS d WHERE CONTAINS(d.body, @q)")
+	if want := []string{"1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("aliased search got %q, want %q", got, want)
+	}
+
+	// Each pass of the loop searches for the current term
+	result, err := interp.Execute(context.Background(),
+		"DECLARE @i INT = 0; DECLARE @q NVARCHAR(20); "+
+			"WHILE @i < 2 BEGIN "+
+			"SET @q = CASE WHEN @i = 0 THEN 'brass' ELSE 'oak' END; "+
+			"SELECT id FROM docs WHERE CONTAINS(body, @q); "+
+			"SET @i = @i + 1 END", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := result.ResultSets
+	if len(sets) < 2 {
+		t.Fatalf("got %d result sets, want 2", len(sets))
+	}
+	var got2 []string
+	for _, rs := range sets[len(sets)-2:] {
+		got2 = append(got2, valueString(rs.Rows[0][0]))
+	}
+	if want := []string{"2", "4"}; !reflect.DeepEqual(got2, want) {
+		t.Errorf("loop got %q, want %q", got2, want)
+	}
+}
+
+func TestFullTextIndexMaintenance(t *testing.T) {
+	interp := fullTextSetup(t)
+
+	if _, err := interp.Execute(context.Background(),
+		"INSERT INTO docs VALUES (5, 'Steel hook', 'Galvanised steel hook'); "+
+			"UPDATE docs SET body = 'Oak shelf with steel brackets' WHERE id = 4; "+
+			"DELETE FROM docs WHERE CONTAINS(title, 'bolts'); "+
+			"UPDATE docs SET title = 'Stainless steel washers' WHERE CONTAINS(body, 'washers')", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got := fullTextRows(t, interp, "SELECT id FROM docs WHERE CONTAINS(body, 'steel') ORDER BY id")
+	if want := []string{"3", "4", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("body search got %q, want %q", got, want)
+	}
+	got = fullTextRows(t, interp, "SELECT id FROM docs WHERE CONTAINS(title, 'steel') ORDER BY id")
+	if want := []string{"3", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("title search got %q, want %q", got, want)
+	}
+}
+
+func TestFullTextDDL(t *testing.T) {
+	interp := fullTextSetup(t)
+	ctx := context.Background()
+
+	// Dropping title from the index leaves body searchable
+	if _, err := interp.Execute(ctx, "ALTER FULLTEXT INDEX ON docs DROP (title)", nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := interp.Execute(ctx, "SELECT id FROM docs WHERE CONTAINS(title, 'steel')", nil)
+	wantSQLError(t, "search of a dropped column", err, ErrNotFullTextIndexed)
+
+	if _, err := interp.Execute(ctx, "ALTER FULLTEXT INDEX ON docs ADD (title) WITH NO POPULATION; "+
+		"ALTER FULLTEXT INDEX ON docs START FULL POPULATION; "+
+		"ALTER FULLTEXT INDEX ON docs SET CHANGE_TRACKING = MANUAL", nil); err != nil {
+		t.Fatal(err)
+	}
+	got := fullTextRows(t, interp, "SELECT id FROM docs WHERE CONTAINS(title, 'oak')")
+	if want := []string{"4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after re-adding title got %q, want %q", got, want)
+	}
+
+	if _, err := interp.Execute(ctx, "DROP FULLTEXT INDEX ON docs; DROP FULLTEXT CATALOG docs_catalog", nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err = interp.Execute(ctx, "SELECT id FROM docs WHERE CONTAINS(body, 'oak')", nil)
+	wantSQLError(t, "search after DROP FULLTEXT INDEX", err, ErrNotFullTextIndexed)
+
+	// Dropping the table takes its index with it
+	if _, err := interp.Execute(ctx, "CREATE FULLTEXT INDEX ON docs (body) KEY INDEX pk_docs; DROP TABLE docs", nil); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := interp.ctx.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'aul_fts_docs%'").Scan(&n); err != nil || n != 0 {
+		t.Errorf("%d full-text objects left after DROP TABLE (%v), want none", n, err)
+	}
+}
+
+func TestFullTextErrors(t *testing.T) {
+	interp := fullTextSetup(t)
+
+	for _, tt := range []struct {
+		sql  string
+		want int
+	}{
+		{"SELECT id FROM notes WHERE CONTAINS(body, 'x')", ErrNotFullTextIndexed},
+		{"SELECT id FROM docs WHERE CONTAINS(id, 'x')", ErrNotFullTextIndexed},
+		{"SELECT id FROM docs WHERE CONTAINS(body, '')", ErrFullTextEmpty},
+		{"DECLARE @q NVARCHAR(10); SELECT id FROM docs WHERE FREETEXT(body, @q)", ErrFullTextEmpty},
+		{"SELECT id FROM docs WHERE CONTAINS(body, 'steel AND')", ErrFullTextSyntax},
+		{"CREATE FULLTEXT INDEX ON docs (body) KEY INDEX pk_docs", ErrFullTextExists},
+		{"CREATE FULLTEXT INDEX ON notes (missing) KEY INDEX pk_notes", ErrInvalidColumn},
+		{"CREATE FULLTEXT INDEX ON nosuchtable (body) KEY INDEX pk", ErrInvalidObject},
+		{"DROP FULLTEXT INDEX ON notes", ErrNotFullTextIndexed},
+	} {
+		_, err := interp.Execute(context.Background(), tt.sql, nil)
+		wantSQLError(t, tt.sql, err, tt.want)
+	}
+}
+
+func TestContainsQuery(t *testing.T) {
+	tests := []struct {
+		cond       string
+		fts5, fts4 string
+	}{
+		{"steel", `"steel"`, `"steel"`},
+		{`"stain*" AND NOT bolt`, `("stain" * NOT "bolt")`, `("stain*" NOT "bolt")`},
+		{"oak NEAR brackets", `NEAR("oak" "brackets")`, `"oak" NEAR "brackets"`},
+		{"oak ~ (a | b)", `("oak" AND ("a" OR "b"))`, `("oak" AND ("a" OR "b"))`},
+		{"a | b & c", `("a" OR ("b" AND "c"))`, `("a" OR ("b" AND "c"))`},
+		{`"steel-bolt" OR 'x'`, `("steel bolt" OR "x")`, `("steel bolt" OR "x")`},
+	}
+	for _, tt := range tests {
+		for fts5, want := range map[bool]string{true: tt.fts5, false: tt.fts4} {
+			got, err := containsQuery(tt.cond, fts5)
+			if err != nil {
+				t.Errorf("%s: %v", tt.cond, err)
+			} else if got != want {
+				t.Errorf("%s (fts5 %v): got %s, want %s", tt.cond, fts5, got, want)
+			}
+		}
+	}
+
+	for _, cond := range []string{"steel AND", "(steel", `"open`, "AND steel", "steel )", "!!", "FORMSOF(INFLECTIONAL)"} {
+		_, err := containsQuery(cond, true)
+		wantSQLError(t, cond, err, ErrFullTextSyntax)
+	}
+
+	if got := freetextQuery("brass, fittings!"); got != `"brass" OR "fittings"` {
+		t.Errorf("freetext query = %s", got)
+	}
+}
+
+func TestFullText_Parse(t *testing.T) {
+	for _, sql := range []string{
+		"SELECT * FROM t AS p WHERE CONTAINS((p.a, b), 'x')",
+		"CREATE FULLTEXT INDEX ON dbo.t(a, b LANGUAGE 1033) KEY INDEX pk_t ON cat",
+		"ALTER FULLTEXT INDEX ON t START FULL POPULATION",
+		"ALTER FULLTEXT INDEX ON t SET CHANGE_TRACKING = AUTO",
+	} {
+		if got := parseSQL(t, sql).String(); got != sql {
+			t.Errorf("round trip of %q gave %q", sql, got)
+		}
+	}
+
+	// Options without a semicolon leave the next statement alone
+	for _, sql := range []string{
+		"CREATE FULLTEXT INDEX ON t (a) KEY INDEX pk WITH CHANGE_TRACKING OFF, NO POPULATION\nSELECT 1",
+		"CREATE FULLTEXT INDEX ON t (a) KEY INDEX pk WITH (STOPLIST = SYSTEM)\nSELECT 1",
+		"ALTER FULLTEXT INDEX ON t ADD (b) WITH NO POPULATION\nSELECT 1",
+	} {
+		p := parser.New(lexer.New(sql))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 || len(program.Statements) != 2 {
+			t.Errorf("%q parsed to %d statements (%v), want 2", sql, len(program.Statements), p.Errors())
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package tsqlruntime
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"regexp"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -32,18 +34,29 @@ func (r *FunctionRegistry) Register(name string, fn Function) {
 	r.functions[strings.ToUpper(name)] = fn
 }
 
-// Call invokes a function by name
+// Call invokes a function by name, built-in or external
 func (r *FunctionRegistry) Call(name string, args []Value) (Value, error) {
-	fn, ok := r.functions[strings.ToUpper(name)]
-	if !ok {
-		return Value{}, fmt.Errorf("unknown function: %s", name)
+	return r.CallContext(context.Background(), name, args)
+}
+
+// CallContext invokes a function by name, passing ctx to an external
+// function
+func (r *FunctionRegistry) CallContext(ctx context.Context, name string, args []Value) (Value, error) {
+	if fn, ok := r.functions[strings.ToUpper(name)]; ok {
+		return fn(args)
 	}
-	return fn(args)
+	if fn, ok := externalFunction(name); ok {
+		return fn(ctx, args)
+	}
+	return Value{}, fmt.Errorf("unknown function: %s", name)
 }
 
 // Has returns true if the function exists
 func (r *FunctionRegistry) Has(name string) bool {
-	_, ok := r.functions[strings.ToUpper(name)]
+	if _, ok := r.functions[strings.ToUpper(name)]; ok {
+		return true
+	}
+	_, ok := externalFunction(name)
 	return ok
 }
 
@@ -74,6 +87,8 @@ func (r *FunctionRegistry) registerBuiltins() {
 	r.Register("UNICODE", fnUnicode)
 	r.Register("NCHAR", fnNChar)
 	r.Register("QUOTENAME", fnQuoteName)
+	r.Register("PARSENAME", fnParseName)
+	r.Register("STRING_ESCAPE", fnStringEscape)
 	r.Register("FORMAT", fnFormat)
 
 	// NULL handling functions
@@ -82,6 +97,8 @@ func (r *FunctionRegistry) registerBuiltins() {
 	r.Register("NULLIF", fnNullIf)
 	r.Register("IIF", fnIIF)
 	r.Register("CHOOSE", fnChoose)
+	r.Register("GREATEST", fnGreatest)
+	r.Register("LEAST", fnLeast)
 
 	// Date/time functions
 	r.Register("GETDATE", fnGetDate)
@@ -189,6 +206,17 @@ func (r *FunctionRegistry) registerBuiltins() {
 	r.Register("ORIGINAL_LOGIN", fnOriginalLogin)
 	r.Register("APP_NAME", fnAppName)
 	r.Register("HOST_NAME", fnHostName)
+
+	// Hashing and encryption (see crypto.go)
+	r.Register("HASHBYTES", fnHashBytes)
+	r.Register("CHECKSUM", fnChecksum)
+	r.Register("BINARY_CHECKSUM", fnBinaryChecksum)
+	r.Register("ENCRYPTBYPASSPHRASE", fnEncryptByPassphrase)
+	r.Register("DECRYPTBYPASSPHRASE", fnDecryptByPassphrase)
+
+	// Compression (see compress.go)
+	r.Register("COMPRESS", fnCompress)
+	r.Register("DECOMPRESS", fnDecompress)
 }
 
 // ============ String functions ============
@@ -213,6 +241,9 @@ func fnDataLength(args []Value) (Value, error) {
 		return Null(TypeInt), nil
 	}
 	// DATALENGTH returns byte count
+	if args[0].Type == TypeBinary || args[0].Type == TypeVarBinary {
+		return NewInt(int64(len(args[0].bytesVal))), nil
+	}
 	s := args[0].AsString()
 	return NewInt(int64(len(s))), nil
 }
@@ -284,33 +315,61 @@ func fnRight(args []Value) (Value, error) {
 }
 
 func fnLTrim(args []Value) (Value, error) {
-	if len(args) != 1 {
-		return Value{}, fmt.Errorf("LTRIM requires 1 argument")
+	if len(args) < 1 || len(args) > 2 {
+		return Value{}, fmt.Errorf("LTRIM requires 1 or 2 arguments")
 	}
 	if args[0].IsNull {
 		return Null(TypeVarChar), nil
 	}
-	return NewVarChar(strings.TrimLeft(args[0].AsString(), " \t"), -1), nil
+	cutset, ok := trimCharacters(args, " \t")
+	if !ok {
+		return Null(TypeVarChar), nil
+	}
+	return NewVarChar(strings.TrimLeft(args[0].AsString(), cutset), -1), nil
 }
 
 func fnRTrim(args []Value) (Value, error) {
-	if len(args) != 1 {
-		return Value{}, fmt.Errorf("RTRIM requires 1 argument")
+	if len(args) < 1 || len(args) > 2 {
+		return Value{}, fmt.Errorf("RTRIM requires 1 or 2 arguments")
 	}
 	if args[0].IsNull {
 		return Null(TypeVarChar), nil
 	}
-	return NewVarChar(strings.TrimRight(args[0].AsString(), " \t"), -1), nil
+	cutset, ok := trimCharacters(args, " \t")
+	if !ok {
+		return Null(TypeVarChar), nil
+	}
+	return NewVarChar(strings.TrimRight(args[0].AsString(), cutset), -1), nil
 }
 
 func fnTrim(args []Value) (Value, error) {
-	if len(args) != 1 {
-		return Value{}, fmt.Errorf("TRIM requires 1 argument")
+	if len(args) < 1 || len(args) > 2 {
+		return Value{}, fmt.Errorf("TRIM requires 1 or 2 arguments")
 	}
 	if args[0].IsNull {
 		return Null(TypeVarChar), nil
 	}
-	return NewVarChar(strings.TrimSpace(args[0].AsString()), -1), nil
+	if len(args) == 1 {
+		return NewVarChar(strings.TrimSpace(args[0].AsString()), -1), nil
+	}
+	cutset, ok := trimCharacters(args, "")
+	if !ok {
+		return Null(TypeVarChar), nil
+	}
+	return NewVarChar(strings.Trim(args[0].AsString(), cutset), -1), nil
+}
+
+// trimCharacters returns the characters to trim given as the optional
+// second argument of LTRIM, RTRIM or TRIM, or def without one. It returns
+// false if they are NULL.
+func trimCharacters(args []Value, def string) (string, bool) {
+	if len(args) < 2 {
+		return def, true
+	}
+	if args[1].IsNull {
+		return "", false
+	}
+	return args[1].AsString(), true
 }
 
 func fnUpper(args []Value) (Value, error) {
@@ -376,7 +435,6 @@ func fnCharIndex(args []Value) (Value, error) {
 }
 
 func fnPatIndex(args []Value) (Value, error) {
-	// Simplified PATINDEX - only handles basic patterns
 	if len(args) != 2 {
 		return Value{}, fmt.Errorf("PATINDEX requires 2 arguments")
 	}
@@ -384,16 +442,16 @@ func fnPatIndex(args []Value) (Value, error) {
 		return Null(TypeInt), nil
 	}
 
-	pattern := args[0].AsString()
+	re, err := regexp.Compile("(?is)" + patIndexRegexp(args[0].AsString()))
+	if err != nil {
+		return Value{}, fmt.Errorf("PATINDEX: invalid pattern: %v", err)
+	}
 	str := args[1].AsString()
-
-	// Remove % from pattern for simple matching
-	pattern = strings.Trim(pattern, "%")
-	idx := strings.Index(str, pattern)
-	if idx < 0 {
+	loc := re.FindStringIndex(str)
+	if loc == nil {
 		return NewInt(0), nil
 	}
-	return NewInt(int64(idx + 1)), nil // 1-based
+	return NewInt(int64(utf8.RuneCountInString(str[:loc[0]]) + 1)), nil // 1-based
 }
 
 func fnConcat(args []Value) (Value, error) {
@@ -569,7 +627,10 @@ func fnNChar(args []Value) (Value, error) {
 	if args[0].IsNull {
 		return Null(TypeNChar), nil
 	}
-	n := int(args[0].AsInt())
+	n := args[0].AsInt()
+	if n < 0 || n > utf8.MaxRune {
+		return Null(TypeNChar), nil
+	}
 	return NewNVarChar(string(rune(n)), 1), nil
 }
 
@@ -582,27 +643,132 @@ func fnQuoteName(args []Value) (Value, error) {
 	}
 
 	s := args[0].AsString()
-	quote := "["
-	closeQuote := "]"
+	if utf8.RuneCountInString(s) > 128 {
+		return Null(TypeNVarChar), nil
+	}
 
-	if len(args) >= 2 && !args[1].IsNull {
-		q := args[1].AsString()
-		if len(q) > 0 {
-			quote = string(q[0])
-			switch quote {
-			case "[":
-				closeQuote = "]"
-			case "'", "\"":
-				closeQuote = quote
-			default:
-				closeQuote = quote
-			}
+	quote := "["
+	if len(args) >= 2 {
+		if args[1].IsNull {
+			return Null(TypeNVarChar), nil
 		}
+		quote = args[1].AsString()
+	}
+	closeQuote, ok := quoteNameDelimiters[quote]
+	if !ok {
+		return Null(TypeNVarChar), nil
 	}
 
 	// Escape the close quote within the string
 	s = strings.ReplaceAll(s, closeQuote, closeQuote+closeQuote)
-	return NewVarChar(quote+s+closeQuote, -1), nil
+	return NewNVarChar(quote+s+closeQuote, -1), nil
+}
+
+// quoteNameDelimiters maps the delimiters QUOTENAME accepts to their
+// closing counterparts.
+var quoteNameDelimiters = map[string]string{
+	"[": "]", "]": "]",
+	"(": ")", ")": ")",
+	"<": ">", ">": ">",
+	"{": "}", "}": "}",
+	"'": "'", "\"": "\"", "`": "`",
+}
+
+func fnParseName(args []Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("PARSENAME requires 2 arguments")
+	}
+	if args[0].IsNull || args[1].IsNull {
+		return Null(TypeNVarChar), nil
+	}
+
+	parts := parseNameParts(args[0].AsString())
+	n := int(args[1].AsInt())
+	if n < 1 || n > len(parts) || parts[len(parts)-n] == "" {
+		return Null(TypeNVarChar), nil
+	}
+	return NewNVarChar(parts[len(parts)-n], -1), nil
+}
+
+// parseNameParts splits a multi-part object name such as
+// server.db.[my schema].obj into its parts, removing bracket and double
+// quote delimiters. It returns nil if the name is malformed or has more
+// than four parts.
+func parseNameParts(name string) []string {
+	var parts []string
+	var part strings.Builder
+	runes := []rune(name)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '[', '"':
+			closeQuote := c
+			if c == '[' {
+				closeQuote = ']'
+			}
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil
+				}
+				if runes[i] == closeQuote {
+					if i+1 < len(runes) && runes[i+1] == closeQuote {
+						part.WriteRune(closeQuote)
+						i++
+						continue
+					}
+					break
+				}
+				part.WriteRune(runes[i])
+			}
+		case '.':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(c)
+		}
+	}
+	parts = append(parts, part.String())
+	if len(parts) > 4 {
+		return nil
+	}
+	return parts
+}
+
+func fnStringEscape(args []Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("STRING_ESCAPE requires 2 arguments")
+	}
+	if !args[1].IsNull && !strings.EqualFold(args[1].AsString(), "json") {
+		return Value{}, fmt.Errorf("STRING_ESCAPE: escape type %q is not supported", args[1].AsString())
+	}
+	if args[0].IsNull {
+		return Null(TypeNVarChar), nil
+	}
+
+	var out strings.Builder
+	for _, r := range args[0].AsString() {
+		switch r {
+		case '"', '\\', '/':
+			out.WriteRune('\\')
+			out.WriteRune(r)
+		case '\b':
+			out.WriteString(`\b`)
+		case '\f':
+			out.WriteString(`\f`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&out, `\u%04x`, r)
+			} else {
+				out.WriteRune(r)
+			}
+		}
+	}
+	return NewNVarChar(out.String(), -1), nil
 }
 
 func fnFormat(args []Value) (Value, error) {
@@ -1245,12 +1411,18 @@ func fnObjectID(args []Value) (Value, error) {
 	// Remove brackets if present
 	tableName = strings.Trim(tableName, "[]")
 	
-	// Hash the table name only (must match objectIDForName in syscatalog.go)
+	return NewInt(objectID(tableName)), nil
+}
+
+// objectID returns the object_id of the object called name, without its
+// schema: a hash of the name that must match objectIDForName in
+// syscatalog.go.
+func objectID(name string) int64 {
 	hash := int64(0)
-	for _, c := range tableName {
+	for _, c := range name {
 		hash = hash*31 + int64(c)
 	}
-	return NewInt(hash & 0x7FFFFFFF), nil
+	return hash & 0x7FFFFFFF
 }
 
 func fnObjectName(args []Value) (Value, error) {
@@ -1563,16 +1735,24 @@ func fnTranslate(args []Value) (Value, error) {
 	from := args[1].AsString()
 	to := args[2].AsString()
 
-	if len(from) != len(to) {
+	fromRunes := []rune(from)
+	toRunes := []rune(to)
+	if len(fromRunes) != len(toRunes) {
 		return Value{}, fmt.Errorf("TRANSLATE: second and third arguments must have same length")
 	}
 
-	result := input
-	fromRunes := []rune(from)
-	toRunes := []rune(to)
-	for i, r := range fromRunes {
-		result = strings.ReplaceAll(result, string(r), string(toRunes[i]))
+	// Each character is translated once, so TRANSLATE(s, 'ab', 'ba')
+	// swaps a and b. The first mapping of a repeated character wins.
+	mapping := make(map[rune]rune, len(fromRunes))
+	for i := len(fromRunes) - 1; i >= 0; i-- {
+		mapping[fromRunes[i]] = toRunes[i]
 	}
+	result := strings.Map(func(r rune) rune {
+		if t, ok := mapping[r]; ok {
+			return t
+		}
+		return r
+	}, input)
 
 	return NewVarChar(result, -1), nil
 }
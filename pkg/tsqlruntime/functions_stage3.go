@@ -1,18 +1,14 @@
 package tsqlruntime
 
 import (
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
+	"errors"
 	"fmt"
-	"strings"
 )
 
 // RegisterStage3Functions registers additional functions for Stage 3
 // Note: Many functions already exist in functions.go, so only truly new ones are added here
 func RegisterStage3Functions(registry *FunctionRegistry) {
-	// Hash functions
+	// Hash functions (see crypto.go)
 	registry.Register("HASHBYTES", fnHashBytes)
 	registry.Register("CHECKSUM", fnChecksum)
 	registry.Register("BINARY_CHECKSUM", fnBinaryChecksum)
@@ -57,60 +53,6 @@ func RegisterStage3Functions(registry *FunctionRegistry) {
 	registry.Register("TYPE_NAME", fnTypeName)
 }
 
-// Hash functions
-
-func fnHashBytes(args []Value) (Value, error) {
-	if len(args) < 2 || args[0].IsNull || args[1].IsNull {
-		return Null(TypeVarBinary), nil
-	}
-
-	algorithm := strings.ToUpper(args[0].AsString())
-	data := []byte(args[1].AsString())
-
-	var hash []byte
-	switch algorithm {
-	case "MD5":
-		h := md5.Sum(data)
-		hash = h[:]
-	case "SHA", "SHA1":
-		h := sha1.Sum(data)
-		hash = h[:]
-	case "SHA2_256", "SHA256":
-		h := sha256.Sum256(data)
-		hash = h[:]
-	case "SHA2_512", "SHA512":
-		h := sha512.Sum512(data)
-		hash = h[:]
-	default:
-		return Null(TypeVarBinary), fmt.Errorf("unknown hash algorithm: %s", algorithm)
-	}
-
-	return NewVarBinary(hash, len(hash)), nil
-}
-
-func fnChecksum(args []Value) (Value, error) {
-	if len(args) < 1 {
-		return NewInt(0), nil
-	}
-
-	var checksum int64
-	for _, arg := range args {
-		if arg.IsNull {
-			continue
-		}
-		s := arg.AsString()
-		for _, c := range s {
-			checksum = (checksum*31 + int64(c)) & 0x7FFFFFFF
-		}
-	}
-
-	return NewInt(checksum), nil
-}
-
-func fnBinaryChecksum(args []Value) (Value, error) {
-	return fnChecksum(args)
-}
-
 // Logical functions
 
 func fnGreatest(args []Value) (Value, error) {
@@ -118,12 +60,13 @@ func fnGreatest(args []Value) (Value, error) {
 		return Null(TypeUnknown), nil
 	}
 
-	var greatest Value
+	// NULL arguments are ignored; the result is NULL only if all are
+	greatest := Null(args[0].Type)
 	for _, arg := range args {
 		if arg.IsNull {
 			continue
 		}
-		if greatest.Type == TypeUnknown || greatest.IsNull || arg.Compare(greatest) > 0 {
+		if greatest.IsNull || arg.Compare(greatest) > 0 {
 			greatest = arg
 		}
 	}
@@ -136,12 +79,13 @@ func fnLeast(args []Value) (Value, error) {
 		return Null(TypeUnknown), nil
 	}
 
-	var least Value
+	// NULL arguments are ignored; the result is NULL only if all are
+	least := Null(args[0].Type)
 	for _, arg := range args {
 		if arg.IsNull {
 			continue
 		}
-		if least.Type == TypeUnknown || least.IsNull || arg.Compare(least) < 0 {
+		if least.IsNull || arg.Compare(least) < 0 {
 			least = arg
 		}
 	}
@@ -200,29 +144,30 @@ func fnTryConvert(args []Value) (Value, error) {
 }
 
 func fnTryParse(args []Value) (Value, error) {
-	if len(args) < 2 || args[0].IsNull {
-		return Null(TypeUnknown), nil
-	}
-
-	targetTypeStr := args[1].AsString()
-	targetType, prec, scale, maxLen := ParseDataType(targetTypeStr)
-
-	result, err := Cast(args[0], targetType, prec, scale, maxLen)
-	if err != nil {
-		return Null(targetType), nil
+	result, err := fnParse(args)
+	if err != nil && !errors.Is(err, errParseUnsupported) {
+		return Null(result.Type), nil
 	}
-	return result, nil
+	return result, err
 }
 
 func fnParse(args []Value) (Value, error) {
-	if len(args) < 2 || args[0].IsNull {
+	if len(args) < 2 {
 		return Null(TypeUnknown), nil
 	}
 
 	targetTypeStr := args[1].AsString()
 	targetType, prec, scale, maxLen := ParseDataType(targetTypeStr)
 
-	return Cast(args[0], targetType, prec, scale, maxLen)
+	culture := ""
+	if len(args) >= 3 && !args[2].IsNull {
+		culture = args[2].AsString()
+	}
+	result, err := Parse(args[0], targetType, prec, scale, maxLen, culture)
+	if err != nil {
+		return Null(targetType), err
+	}
+	return result, nil
 }
 
 // Metadata functions
@@ -0,0 +1,95 @@
+package tsqlruntime
+
+import (
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// SQL graph tables are emulated with plain tables. CREATE TABLE ... AS EDGE
+// adds "$from_id" and "$to_id" columns holding the ids of the nodes an
+// edge joins. The $node_id of a node and the $edge_id of an edge are not
+// stored: they are derived from the row's rowid in the form SQL Server
+// shows them, {"type":"node","schema":"dbo","table":"person","id":0}, so
+// that node tables keep the columns they were declared with. MATCH is
+// rewritten to the equalities between these columns it stands for, which
+// turns a graph query into the join it is.
+
+// rewriteGraphColumn transforms a graph pseudo-column, qualified by the
+// table it belongs to or not; any other identifier is returned unchanged.
+func (r *BaseRewriter) rewriteGraphColumn(qualifier, column *ast.Identifier) ast.Expression {
+	name := strings.ToLower(column.Value)
+	var original ast.Expression = column
+	if qualifier != nil {
+		original = &ast.QualifiedIdentifier{Parts: []*ast.Identifier{qualifier, column}}
+	}
+	switch name {
+	case "$from_id", "$to_id":
+		if qualifier == nil {
+			return &ast.Identifier{Token: quotedToken(column.Token, '"'), Value: name}
+		}
+		if _, ok := r.lookupTable(qualifier.Value); !ok {
+			return original
+		}
+		return r.qualifiedColumn(column.Token, qualifier.Value, name)
+	case "$node_id", "$edge_id":
+		b, ok := r.graphTable(qualifier)
+		if !ok {
+			return original
+		}
+		rowid := "rowid"
+		if qualifier != nil {
+			rowid = r.qualifiedColumn(column.Token, b.name, "rowid").String()
+		}
+		return &ast.Identifier{Token: quotedToken(column.Token, 0), Value: graphIDSQL(name[1:len(name)-3], b.table, rowid)}
+	}
+	return original
+}
+
+// graphTable finds the table a pseudo-column reads: the one its qualifier
+// names, or else the only table of the innermost query.
+func (r *BaseRewriter) graphTable(qualifier *ast.Identifier) (tableBinding, bool) {
+	if qualifier != nil {
+		return r.lookupTable(qualifier.Value)
+	}
+	if len(r.tableScopes) == 0 {
+		return tableBinding{}, false
+	}
+	if scope := r.tableScopes[len(r.tableScopes)-1]; len(scope) == 1 {
+		return scope[0], true
+	}
+	return tableBinding{}, false
+}
+
+// graphIDSQL returns the SQL computing the id of a node or edge of table
+// from its rowid.
+func graphIDSQL(kind, table, rowid string) string {
+	parts := splitObjectName(strings.ToLower(table))
+	schema := "dbo"
+	if len(parts) > 1 {
+		schema = parts[len(parts)-2]
+	}
+	return "('{\"type\":\"" + kind + "\",\"schema\":\"" + schema + "\",\"table\":\"" +
+		parts[len(parts)-1] + "\",\"id\":' || (" + rowid + " - 1) || '}')"
+}
+
+// rewriteGraphMatch transforms MATCH(a-(e)->b) into the join condition
+// e.$from_id = a.$node_id AND e.$to_id = b.$node_id.
+func (r *BaseRewriter) rewriteGraphMatch(m *ast.GraphMatchExpression) ast.Expression {
+	var conditions []string
+	column := func(table *ast.Identifier, name string) string {
+		return r.rewriteGraphColumn(table, &ast.Identifier{Token: table.Token, Value: name}).String()
+	}
+	for _, p := range m.Patterns {
+		for i, edge := range p.Edges {
+			from, to := p.Nodes[i], p.Nodes[i+1]
+			if edge.Reverse {
+				from, to = to, from
+			}
+			conditions = append(conditions,
+				column(edge.Name, "$from_id")+" = "+column(from, "$node_id"),
+				column(edge.Name, "$to_id")+" = "+column(to, "$node_id"))
+		}
+	}
+	return &ast.Identifier{Token: m.Token, Value: "(" + strings.Join(conditions, " AND ") + ")"}
+}
@@ -0,0 +1,100 @@
+package tsqlruntime
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const graphSchema = `
+CREATE TABLE Person (id INT PRIMARY KEY, name NVARCHAR(100)) AS NODE;
+CREATE TABLE City (id INT, name NVARCHAR(100)) AS NODE;
+CREATE TABLE friendOf AS EDGE;
+CREATE TABLE livesIn (since INT, CONSTRAINT ec_livesIn CONNECTION (Person TO City)) AS EDGE;
+INSERT INTO Person VALUES (1, 'Alice'), (2, 'Bob'), (3, 'Carol');
+INSERT INTO City VALUES (1, 'Lisbon');
+INSERT INTO friendOf ($from_id, $to_id) VALUES
+	((SELECT $node_id FROM Person WHERE id = 1), (SELECT $node_id FROM Person WHERE id = 2));
+INSERT INTO friendOf ($from_id, $to_id)
+	SELECT a.$node_id, b.$node_id FROM Person a, Person b WHERE a.id = 2 AND b.id = 3;
+INSERT INTO livesIn ($from_id, $to_id, since)
+	SELECT p.$node_id, c.$node_id, 2019 FROM Person AS p, City AS c WHERE p.name = 'Carol';
+`
+
+func TestGraphQueries(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if _, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(), graphSchema, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"node id", "SELECT $node_id FROM Person WHERE id = 2",
+			[]string{`{"type":"node","schema":"dbo","table":"person","id":1}`}},
+		{"edge ends", "SELECT e.$from_id, e.$to_id FROM friendOf AS e WHERE e.$edge_id LIKE '%\"id\":0}'",
+			[]string{`{"type":"node","schema":"dbo","table":"person","id":0} {"type":"node","schema":"dbo","table":"person","id":1}`}},
+		{"friends", "SELECT p2.name FROM Person p1, friendOf, Person p2 " +
+			"WHERE MATCH(p1-(friendOf)->p2) AND p1.name = 'Alice'", []string{"Bob"}},
+		{"friends of friends", "SELECT p3.name FROM Person p1, friendOf f1, Person p2, friendOf f2, Person p3 " +
+			"WHERE MATCH(p1-(f1)->p2-(f2)->p3) AND p1.name = 'Alice'", []string{"Carol"}},
+		{"reverse edge", "SELECT p1.name FROM Person p1, friendOf, Person p2 " +
+			"WHERE MATCH(p2<-(friendOf)-p1) AND p2.name = 'Carol'", []string{"Bob"}},
+		{"two patterns", "SELECT p1.name, c.name, l.since FROM Person p1, friendOf f, Person p2, livesIn l, City c " +
+			"WHERE MATCH(p1-(f)->p2 AND p2-(l)->c)", []string{"Bob Lisbon 2019"}},
+		{"node tables keep their columns", "SELECT * FROM Person WHERE id = 3", []string{"3 Carol"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := spatialRows(t, db, tc.query)
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGraphRewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		contains string
+	}{
+		{"match", "SELECT 1 FROM Person AS a, likes AS e, Person AS b WHERE MATCH(a-(e)->b)",
+			`("e"."$from_id" = ('{"type":"node","schema":"dbo","table":"person","id":' || ("a"."rowid" - 1) || '}') AND "e"."$to_id" = `},
+		{"reverse", "SELECT 1 FROM Person AS a, likes AS e, Person AS b WHERE MATCH(a<-(e)-b)",
+			`"e"."$from_id" = ('{"type":"node","schema":"dbo","table":"person","id":' || ("b"."rowid" - 1)`},
+		{"edge id of a schema's table", "SELECT $edge_id FROM Sales.bought",
+			`('{"type":"edge","schema":"sales","table":"bought","id":' || (rowid - 1) || '}')`},
+		{"insert columns", "INSERT INTO likes ($from_id, $to_id) VALUES (@a, @b)", `INSERT INTO likes ("$from_id", "$to_id")`},
+		{"qualified column", "SELECT p.name FROM Person AS p JOIN City AS c ON p.city = c.id", `ON ("p"."city" = "c"."id")`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			output := NewSQLiteRewriter().RewriteStatement(parseSQL(t, tc.input)).String()
+			if !strings.Contains(output, tc.contains) {
+				t.Errorf("%s\ngot:  %s\nwant it to contain %q", tc.input, output, tc.contains)
+			}
+		})
+	}
+}
+
+func TestGraph_Parse(t *testing.T) {
+	for _, sql := range []string{
+		"CREATE TABLE likes AS EDGE",
+		"SELECT 1 FROM a, e, b, f, c WHERE MATCH(a-(e)->b<-(f)-c AND c-(e)->a)",
+	} {
+		if got := parseSQL(t, sql).String(); got != sql {
+			t.Errorf("round trip of %q gave %q", sql, got)
+		}
+	}
+	got := parseSQL(t, "CREATE TABLE livesIn (since INT, CONSTRAINT ec CONNECTION (Person TO City, Person TO dbo.Town) ON DELETE CASCADE) AS EDGE").String()
+	for _, want := range []string{"CONNECTION (Person TO City, Person TO dbo.Town) ON DELETE CASCADE", ") AS EDGE"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// Query hints (OPTION clause)
+//
+// Procedures copied from SQL Server often carry OPTION (...) hints. Against
+// a SQL Server backend they are passed through unchanged. For other
+// backends they are removed before the statement is translated: hints
+// whose intent the interpreter already satisfies are dropped silently, and
+// the rest are dropped with a warning so that nothing is ignored unseen.
+
+// satisfiedHints are hints whose intent holds without any action.
+var satisfiedHints = map[string]bool{
+	// Statements are translated and planned afresh on every execution;
+	// there is no plan cache to bypass.
+	"RECOMPILE": true,
+	// Each statement runs on a single thread, so any parallelism limit
+	// is already met.
+	"MAXDOP": true,
+}
+
+// plannerHints steer SQL Server's optimiser. Plan choices belong to the
+// storage backend, so these are ignored.
+var plannerHints = map[string]bool{
+	"LOOP JOIN": true, "HASH JOIN": true, "MERGE JOIN": true,
+	"FORCE ORDER": true, "HASH GROUP": true, "ORDER GROUP": true,
+	"CONCAT UNION": true, "HASH UNION": true, "MERGE UNION": true,
+	"FAST": true, "KEEP PLAN": true, "KEEPFIXED PLAN": true,
+	"ROBUST PLAN": true, "EXPAND VIEWS": true, "USE HINT": true,
+	"USE PLAN": true, "OPTIMIZE FOR": true, "OPTIMIZE FOR UNKNOWN": true,
+	"PARAMETERIZATION": true, "QUERYTRACEON": true,
+	"MAX_GRANT_PERCENT": true, "MIN_GRANT_PERCENT": true,
+	"NO_PERFORMANCE_SPOOL": true, "IGNORE_NONCLUSTERED_COLUMNSTORE_INDEX": true,
+	"DISABLE_OPTIMIZED_PLAN_FORCING": true, "LABEL": true,
+}
+
+// applyQueryHints checks the OPTION clause of stmt and, unless the backend
+// is SQL Server, strips it so the translated statement stays valid.
+// Warnings for ignored hints are added to result.
+func (i *Interpreter) applyQueryHints(stmt ast.Statement, result *ExecutionResult) {
+	if i.rewriter.Dialect() == DialectSQLServer {
+		return
+	}
+	for _, opts := range queryOptionLists(stmt) {
+		for _, opt := range *opts {
+			if w := queryHintWarning(opt); w != "" {
+				result.Warnings = append(result.Warnings, w)
+			}
+		}
+		*opts = nil
+	}
+}
+
+// queryOptionLists returns the OPTION clauses carried by stmt.
+func queryOptionLists(stmt ast.Statement) []*[]*ast.QueryOption {
+	var lists []*[]*ast.QueryOption
+	addSelect := func(s *ast.SelectStatement) {
+		for ; s != nil; s = unionRight(s) {
+			lists = append(lists, &s.Options)
+		}
+	}
+
+	switch s := stmt.(type) {
+	case *ast.SelectStatement:
+		addSelect(s)
+	case *ast.InsertStatement:
+		addSelect(s.Select)
+	case *ast.UpdateStatement:
+		lists = append(lists, &s.Options)
+	case *ast.DeleteStatement:
+		lists = append(lists, &s.Options)
+	case *ast.MergeStatement:
+		lists = append(lists, &s.Options)
+	case *ast.DeclareCursorStatement:
+		addSelect(s.ForSelect)
+	case *ast.WithStatement:
+		if s.Query != nil {
+			lists = append(lists, queryOptionLists(s.Query)...)
+		}
+	}
+	return lists
+}
+
+func unionRight(s *ast.SelectStatement) *ast.SelectStatement {
+	if s.Union == nil {
+		return nil
+	}
+	return s.Union.Right
+}
+
+// queryHintWarning returns the warning for an ignored hint, or "" when the
+// hint is satisfied.
+func queryHintWarning(opt *ast.QueryOption) string {
+	name := strings.ToUpper(opt.Name)
+	text := name
+	if opt.Value != nil {
+		text += " " + opt.Value.String()
+	}
+
+	switch {
+	case satisfiedHints[name]:
+		return ""
+	case name == "MAXRECURSION":
+		// The backends impose no recursion limit, which is what 0 asks for
+		if opt.Value != nil && opt.Value.String() == "0" {
+			return ""
+		}
+		return fmt.Sprintf("Query hint %s ignored: recursive queries are not depth-limited.", text)
+	case plannerHints[name]:
+		return fmt.Sprintf("Query hint %s ignored: plan choices are left to the storage backend.", text)
+	default:
+		return fmt.Sprintf("Unknown query hint %s ignored.", text)
+	}
+}
@@ -0,0 +1,91 @@
+package tsqlruntime
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestQueryHints_StrippedForBackend(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (a INT, b TEXT); INSERT INTO t VALUES (1, 'x'), (2, 'y')"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql      string
+		warnings []string
+	}{
+		{"SELECT a FROM t OPTION (MAXDOP 1)", nil},
+		{"SELECT a FROM t ORDER BY a OPTION (RECOMPILE, FORCE ORDER)",
+			[]string{"Query hint FORCE ORDER ignored"}},
+		{"SELECT a FROM t WHERE a IN (SELECT a FROM t) OPTION (HASH JOIN)",
+			[]string{"Query hint HASH JOIN ignored"}},
+		{"SELECT a FROM t UNION SELECT a FROM t OPTION (MERGE UNION)",
+			[]string{"Query hint MERGE UNION ignored"}},
+		{"SELECT a FROM t WHERE a = @p OPTION (OPTIMIZE FOR (@p = 1))",
+			[]string{"Query hint OPTIMIZE FOR ignored"}},
+		{"SELECT a FROM t OPTION (USE HINT('DISABLE_OPTIMIZED_NESTED_LOOP'))",
+			[]string{"Query hint USE HINT ignored"}},
+		{"WITH c AS (SELECT a FROM t) SELECT a FROM c OPTION (MAXRECURSION 0)", nil},
+		{"WITH c AS (SELECT a FROM t) SELECT a FROM c OPTION (MAXRECURSION 50)",
+			[]string{"Query hint MAXRECURSION 50 ignored"}},
+		{"UPDATE t SET b = 'z' WHERE a = 1 OPTION (MAXDOP 1, LOOP JOIN)",
+			[]string{"Query hint LOOP JOIN ignored"}},
+		{"DELETE FROM t WHERE a = 5 OPTION (RECOMPILE)", nil},
+		{"INSERT INTO t (a, b) SELECT a, b FROM t WHERE a = 1 OPTION (KEEP PLAN)",
+			[]string{"Query hint KEEP PLAN ignored"}},
+		{"SELECT a FROM t OPTION (NO_SUCH_HINT)",
+			[]string{"Unknown query hint NO_SUCH_HINT ignored"}},
+	}
+
+	for _, tt := range tests {
+		interp := NewInterpreter(db, DialectSQLite)
+		result, err := interp.Execute(context.Background(), tt.sql, map[string]interface{}{"@p": 1})
+		if err != nil {
+			t.Errorf("%s: %v", tt.sql, err)
+			continue
+		}
+		if len(result.Warnings) != len(tt.warnings) {
+			t.Errorf("%s: warnings = %q, want %q", tt.sql, result.Warnings, tt.warnings)
+			continue
+		}
+		for j, w := range tt.warnings {
+			if !strings.HasPrefix(result.Warnings[j], w) {
+				t.Errorf("%s: warning %q, want prefix %q", tt.sql, result.Warnings[j], w)
+			}
+		}
+	}
+}
+
+func TestQueryHints_PassedThroughForSQLServer(t *testing.T) {
+	interp := NewInterpreter(nil, DialectSQLServer)
+	result := &ExecutionResult{}
+
+	stmt := parseSQL(t, "SELECT a FROM t OPTION (HASH JOIN)")
+	interp.applyQueryHints(stmt, result)
+	if len(result.Warnings) != 0 || !strings.Contains(stmt.String(), "OPTION (HASH JOIN)") {
+		t.Errorf("hints not passed through: %s %q", stmt.String(), result.Warnings)
+	}
+}
+
+func TestQueryHints_WarnOncePerStatement(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	interp := NewInterpreter(db, DialectSQLite)
+	result, err := interp.Execute(context.Background(), `
+		DECLARE @i INT = 0
+		WHILE @i < 3
+		BEGIN
+			SELECT @i AS i FROM (SELECT 1 AS x) s OPTION (FAST 1)
+			SET @i = @i + 1
+		END`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 {
+		t.Errorf("warnings = %q, want one", result.Warnings)
+	}
+}
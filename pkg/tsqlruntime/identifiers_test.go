@@ -0,0 +1,68 @@
+package tsqlruntime
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDelimitedIdentifiers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	interp := NewInterpreter(db, DialectSQLite)
+
+	for _, stmt := range []string{
+		`CREATE TABLE [Order Details] ([Order ID] INT PRIMARY KEY, [Unit Price] DECIMAL(10,2), "weird""name" NVARCHAR(10), [a]]b] INT, Straße INT)`,
+		`CREATE INDEX [IX Unit Price] ON dbo.[Order Details] ([Unit Price] DESC)`,
+		`INSERT INTO [Order Details] ([Order ID], [Unit Price], "weird""name", [a]]b], Straße) VALUES (1, 2.5, 'x', 3, 4), (2, 1, 'y', 5, 6)`,
+		`UPDATE [Order Details] SET [a]]b] = [a]]b] + 1 WHERE "weird""name" = 'x'`,
+		`DELETE FROM [Order Details] WHERE [Order ID] = 2`,
+	} {
+		if _, err := interp.Execute(context.Background(), stmt, nil); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	result, err := interp.Execute(context.Background(), `
+		SELECT od.[Order ID], od.[Unit Price] AS [Price Each], "weird""name", [a]]b], Straße
+		FROM dbo.[Order Details] od
+		WHERE od.[Order ID] = 1`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := result.ResultSets[len(result.ResultSets)-1]
+	want := []string{"Order ID", "Price Each", `weird"name`, "a]b", "Straße"}
+	if len(rs.Columns) != len(want) || len(rs.Rows) != 1 {
+		t.Fatalf("got %v %v", rs.Columns, rs.Rows)
+	}
+	for n, column := range want {
+		if rs.Columns[n] != column {
+			t.Errorf("column %d: got %q, want %q", n, rs.Columns[n], column)
+		}
+	}
+	if got := rs.Rows[0][3].AsString(); got != "4" {
+		t.Errorf("a]b: got %s, want 4", got)
+	}
+
+	for _, stmt := range []string{"TRUNCATE TABLE [Order Details]", "DROP TABLE [Order Details]"} {
+		if _, err := interp.Execute(context.Background(), stmt, nil); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+func TestQuotedIdentifierOff(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	interp := NewInterpreter(db, DialectSQLite)
+
+	result, err := interp.Execute(context.Background(), `
+		SET QUOTED_IDENTIFIER OFF
+		SELECT "it's a string" AS s`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := result.ResultSets[0]
+	if len(rs.Rows) != 1 || rs.Rows[0][0].AsString() != "it's a string" {
+		t.Errorf("got %v", rs.Rows)
+	}
+}
@@ -0,0 +1,156 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// A WHILE loop that only sets variables and inserts a row into a table,
+// the usual shape of a data-generation procedure, would make a backend
+// round trip per iteration. Such a loop runs with its insert batched:
+// each iteration evaluates the row and adds it to the batch, and the
+// batch is written as one multi-row INSERT when it is full and when the
+// loop ends. @@ROWCOUNT reads 1 after each batched insert, as it would
+// have.
+//
+// A row the backend rejects fails the batch it is in, so outside a
+// transaction the rows batched before it are not inserted either. Loops
+// are not batched under a statement journal, which records each write as
+// it is made.
+
+const (
+	insertBatchRows   = 500 // Rows per multi-row INSERT
+	insertBatchParams = 999 // Parameters per INSERT, SQLite's lowest limit
+)
+
+// insertBatch holds the rows of a loop's insert not yet written.
+type insertBatch struct {
+	stmt *ast.InsertStatement
+	args []interface{} // Row values, len(stmt.Values[0]) per row
+	rows int
+}
+
+// batchableInsert returns the insert of a loop body that can be batched,
+// or nil. Apart from the insert the body may only declare, set and print
+// variables, none of which touch the backend, and must not read
+// @@IDENTITY or SCOPE_IDENTITY(), which are only known once a batch is
+// written.
+func batchableInsert(body ast.Statement) *ast.InsertStatement {
+	stmts := []ast.Statement{body}
+	if block, ok := body.(*ast.BeginEndBlock); ok {
+		stmts = block.Statements
+	}
+
+	var insert *ast.InsertStatement
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.SetStatement, *ast.DeclareStatement, *ast.PrintStatement:
+		case *ast.InsertStatement:
+			if insert != nil || !isBatchableInsert(s) {
+				return nil
+			}
+			insert = s
+		default:
+			return nil
+		}
+		if strings.Contains(strings.ToUpper(stmt.String()), "IDENTITY") {
+			return nil
+		}
+	}
+	return insert
+}
+
+// isBatchableInsert reports whether s inserts a single row of values into
+// a database table. DEFAULT parses as an identifier, which would evaluate
+// to NULL, so a row naming one is not batched.
+func isBatchableInsert(s *ast.InsertStatement) bool {
+	if s.Table == nil || s.Select != nil || s.Exec != nil || s.Output != nil ||
+		s.Top != nil || s.DefaultValues || len(s.Values) != 1 {
+		return false
+	}
+	for _, expr := range s.Values[0] {
+		switch expr.(type) {
+		case *ast.Identifier, *ast.QualifiedIdentifier:
+			return false
+		}
+	}
+	name := s.Table.String()
+	width := len(s.Values[0])
+	return !IsTempTable(name) && !IsTableVariable(name) &&
+		width > 0 && width <= insertBatchParams
+}
+
+// batchInsert adds the row of the loop's insert to the batch. A row with
+// a value that cannot be evaluated here, such as a subquery, is inserted
+// on its own once the rows before it are written.
+func (i *Interpreter) batchInsert(ctx context.Context, b *insertBatch) error {
+	values := b.stmt.Values[0]
+	row := make([]interface{}, len(values))
+	for j, expr := range values {
+		v, err := i.evaluator.Evaluate(expr)
+		if err != nil {
+			if err := i.flushInserts(ctx, b); err != nil {
+				return err
+			}
+			return i.executeInsertRow(ctx, b.stmt)
+		}
+		row[j] = FromValue(v)
+	}
+
+	if b.rows == insertBatchRows || len(b.args)+len(row) > insertBatchParams {
+		if err := i.flushInserts(ctx, b); err != nil {
+			return err
+		}
+	}
+	b.args = append(b.args, row...)
+	b.rows++
+	i.ctx.UpdateRowCount(1)
+	return nil
+}
+
+// flushInserts writes the batched rows as one INSERT.
+func (i *Interpreter) flushInserts(ctx context.Context, b *insertBatch) error {
+	if b.rows == 0 {
+		return nil
+	}
+
+	width := len(b.args) / b.rows
+	ins := &ast.InsertStatement{Token: b.stmt.Token, Table: b.stmt.Table, Hints: b.stmt.Hints, Columns: b.stmt.Columns}
+	ins.Values = make([][]ast.Expression, b.rows)
+	for r := range ins.Values {
+		row := make([]ast.Expression, width)
+		for j := range row {
+			row[j] = &ast.Identifier{Value: i.getPlaceholder(r*width + j)}
+		}
+		ins.Values[r] = row
+	}
+	query := i.normalize(i.rewriter.RewriteStatement(ins).String())
+
+	if i.LogRewritten && i.LogFunc != nil {
+		i.LogFunc("REWRITTEN query=%s rows=%d", query, b.rows)
+	}
+
+	var res sql.Result
+	var err error
+	if i.ctx.Tx != nil {
+		res, err = i.ctx.Tx.ExecContext(ctx, query, b.args...)
+	} else {
+		res, err = i.ctx.DB.ExecContext(ctx, query, b.args...)
+	}
+	b.args = b.args[:0]
+	b.rows = 0
+	if i.ctx.TableCache != nil {
+		i.cacheWrittenTable(pinnedKey(b.stmt.Table.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("insert error: %w", err)
+	}
+
+	lastInsertID, _ := res.LastInsertId()
+	i.ctx.UpdateLastInsertID(lastInsertID)
+	return nil
+}
@@ -0,0 +1,107 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// batchSetup returns an interpreter over a database holding an empty
+// table and a count of the INSERT statements it sends to the backend.
+func batchSetup(t *testing.T) (*Interpreter, *int) {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE gen (id INTEGER NOT NULL, label TEXT, seq INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+
+	inserts := 0
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.LogRewritten = true
+	interp.LogFunc = func(format string, args ...interface{}) {
+		if query, ok := args[0].(string); ok && strings.HasPrefix(query, "INSERT") {
+			inserts++
+		}
+	}
+	return interp, &inserts
+}
+
+func TestInsertBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		want    []string
+		inserts int
+	}{
+		{"batched",
+			"DECLARE @i INT = 0; " +
+				"WHILE @i < 1200 BEGIN SET @i = @i + 1; INSERT INTO gen (id, label) VALUES (@i, 'row ' + CAST(@i AS VARCHAR(10))) END; " +
+				"SELECT COUNT(*), SUM(id), MAX(label) FROM gen",
+			[]string{"1200 720600 row 999"}, 3},
+		{"parameter limit",
+			"DECLARE @i INT = 0; " +
+				"WHILE @i < 600 BEGIN INSERT INTO gen (id, label) VALUES (@i, NULL); SET @i = @i + 1 END; " +
+				"SELECT COUNT(*) FROM gen",
+			[]string{"600"}, 2},
+		{"row not evaluable",
+			"DECLARE @i INT = 0; " +
+				"WHILE @i < 3 BEGIN SET @i = @i + 1; INSERT INTO gen (id) VALUES ((SELECT COUNT(*) FROM gen) + @i) END; " +
+				"SELECT SUM(id) FROM gen",
+			[]string{"9"}, 3},
+		{"identity read",
+			"DECLARE @i INT = 0, @last INT; " +
+				"WHILE @i < 3 BEGIN SET @i = @i + 1; INSERT INTO gen (id) VALUES (@i); SET @last = SCOPE_IDENTITY() END; " +
+				"SELECT COUNT(*) FROM gen",
+			[]string{"3"}, 3},
+		{"other statements",
+			"DECLARE @i INT = 0; " +
+				"WHILE @i < 3 BEGIN SET @i = @i + 1; INSERT INTO gen (id) VALUES (@i); UPDATE gen SET label = 'x' END; " +
+				"SELECT COUNT(*) FROM gen WHERE label = 'x'",
+			[]string{"3"}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interp, inserts := batchSetup(t)
+			result, err := interp.Execute(context.Background(), tt.sql, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := lastRows(result); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if *inserts != tt.inserts {
+				t.Errorf("sent %d inserts, want %d", *inserts, tt.inserts)
+			}
+		})
+	}
+}
+
+func TestInsertBatch_Error(t *testing.T) {
+	interp, _ := batchSetup(t)
+	_, err := interp.Execute(context.Background(),
+		"DECLARE @i INT = 0; "+
+			"WHILE @i < 3 BEGIN SET @i = @i + 1; INSERT INTO gen (id) VALUES (NULLIF(@i, 2)) END", nil)
+	if err == nil || !strings.Contains(err.Error(), "NOT NULL") {
+		t.Fatalf("got %v, want a NOT NULL constraint error", err)
+	}
+}
+
+func TestInsertBatch_Transaction(t *testing.T) {
+	interp, inserts := batchSetup(t)
+	result, err := interp.Execute(context.Background(),
+		"BEGIN TRANSACTION; DECLARE @i INT = 0; "+
+			"WHILE @i < 10 BEGIN SET @i = @i + 1; INSERT INTO gen (id) VALUES (@i) END; "+
+			"ROLLBACK; SELECT COUNT(*) FROM gen", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"0"}) {
+		t.Errorf("got %q after rollback, want [0]", got)
+	}
+	if *inserts != 1 {
+		t.Errorf("sent %d inserts, want 1", *inserts)
+	}
+}
@@ -0,0 +1,175 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// INSERT ... EXEC runs the procedure (or dynamic SQL) with its result sets
+// captured instead of returned to the client. Every result set must have
+// as many columns as the insert targets; the shape is checked before any
+// row is written, so a mismatch inserts nothing. Rows go into temp tables
+// and table variables in memory, and into other tables through one
+// prepared INSERT inside the current transaction, or a transaction of
+// their own when none is open.
+
+// columnCountMismatch returns SQL Server's error for rows that do not fit
+// the insert's target columns.
+func columnCountMismatch() error {
+	return NewSQLError(ErrColumnCountMismatch,
+		"Column name or number of supplied values does not match table definition.")
+}
+
+// captureInsertExec executes s.Exec and returns its result sets, each
+// checked to have width columns. Nested statements add their result sets
+// to the shared execution context, so those added by s.Exec are taken
+// back off it.
+func (i *Interpreter) captureInsertExec(ctx context.Context, s *ast.InsertStatement, width int) ([]ResultSet, error) {
+	mark := len(i.ctx.ResultSets)
+	err := i.executeExec(ctx, s.Exec, &ExecutionResult{})
+	captured := append([]ResultSet(nil), i.ctx.ResultSets[mark:]...)
+	i.ctx.ResultSets = i.ctx.ResultSets[:mark]
+	if err != nil {
+		return nil, err
+	}
+	for _, rs := range captured {
+		if len(rs.Columns) != width {
+			return nil, columnCountMismatch()
+		}
+	}
+	return captured, nil
+}
+
+// executeInsertExecTempTable inserts the result sets of s.Exec into a temp
+// table or table variable.
+func (i *Interpreter) executeInsertExecTempTable(ctx context.Context, s *ast.InsertStatement, table *TempTable) error {
+	// Without a column list the rows fill the non-identity columns in order
+	var columns []string
+	if len(s.Columns) > 0 {
+		for _, c := range s.Columns {
+			if table.GetColumnIndex(c.Value) < 0 {
+				return fmt.Errorf("invalid column name '%s'", c.Value)
+			}
+			columns = append(columns, c.Value)
+		}
+	} else {
+		for _, col := range table.Columns {
+			if !col.Identity {
+				columns = append(columns, col.Name)
+			}
+		}
+	}
+
+	resultSets, err := i.captureInsertExec(ctx, s, len(columns))
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, rs := range resultSets {
+		for _, row := range rs.Rows {
+			values := make(map[string]Value, len(columns))
+			for j, name := range columns {
+				values[strings.ToLower(name)] = row[j]
+			}
+			if _, err := table.Insert(values); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+	i.ctx.UpdateRowCount(int64(count))
+	return nil
+}
+
+// executeInsertExec inserts the result sets of s.Exec into a database
+// table.
+func (i *Interpreter) executeInsertExec(ctx context.Context, s *ast.InsertStatement) (err error) {
+	// Rewrite the target once; the column probe and the insert share it
+	target := &ast.InsertStatement{Token: s.Token, Table: s.Table, Columns: s.Columns}
+	ins := i.rewriter.RewriteStatement(target).(*ast.InsertStatement)
+
+	width := len(ins.Columns)
+	if width == 0 {
+		columns, err := i.tableColumns(ctx, ins.Table.String())
+		if err != nil {
+			return fmt.Errorf("insert error: %w", err)
+		}
+		width = len(columns)
+	}
+
+	resultSets, err := i.captureInsertExec(ctx, s, width)
+	if err != nil {
+		return err
+	}
+
+	row := make([]ast.Expression, width)
+	for j := range row {
+		row[j] = &ast.Identifier{Value: i.getPlaceholder(j)}
+	}
+	ins.Values = [][]ast.Expression{row}
+	query := i.normalize(i.rewriter.RewriteStatement(ins).String())
+
+	if i.LogRewritten && i.LogFunc != nil {
+		i.LogFunc("REWRITTEN query=%s", query)
+	}
+
+	// The rows go in together or not at all
+	tx := i.ctx.Tx
+	if tx == nil {
+		if tx, err = i.ctx.DB.BeginTx(ctx, nil); err != nil {
+			return fmt.Errorf("insert error: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			} else if cerr := tx.Commit(); cerr != nil {
+				err = fmt.Errorf("insert error: %w", cerr)
+			}
+		}()
+	}
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("insert error: %w", err)
+	}
+	defer stmt.Close()
+
+	var count int64
+	var res sql.Result
+	for _, rs := range resultSets {
+		for _, values := range rs.Rows {
+			args := make([]interface{}, len(values))
+			for j, v := range values {
+				args[j] = FromValue(v)
+			}
+			if res, err = stmt.ExecContext(ctx, args...); err != nil {
+				return fmt.Errorf("insert error: %w", err)
+			}
+			n, _ := res.RowsAffected()
+			count += n
+		}
+	}
+
+	i.ctx.UpdateRowCount(count)
+	if res != nil {
+		lastInsertID, _ := res.LastInsertId()
+		i.ctx.UpdateLastInsertID(lastInsertID)
+	}
+	return nil
+}
+
+// tableColumns returns the column names of the database table name.
+func (i *Interpreter) tableColumns(ctx context.Context, name string) ([]string, error) {
+	query := i.normalize("SELECT * FROM " + i.backendName(name) + " WHERE 1 = 0")
+	rows, err := i.ctx.GetExecutor().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
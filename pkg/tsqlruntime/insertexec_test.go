@@ -0,0 +1,191 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// insertExecSetup returns an interpreter over a database holding products
+// and a resolver with procedures that read them.
+func insertExecSetup(t *testing.T) (*sql.DB, *Interpreter) {
+	t.Helper()
+	db := setupTestDB(t)
+	if _, err := db.Exec("CREATE TABLE products (id INTEGER, name TEXT); " +
+		"INSERT INTO products VALUES (1, 'bolt'), (2, 'nut'), (3, 'washer'); " +
+		"CREATE TABLE picked (id INTEGER, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.ListProducts", `
+		CREATE PROCEDURE dbo.ListProducts @MinID INT = 0
+		AS
+		BEGIN
+			SELECT id, name FROM products WHERE id > @MinID ORDER BY id
+		END
+	`, []ProcedureParam{{Name: "MinID", SQLType: "INT", HasDefault: true, Default: 0}})
+	resolver.AddProcedure("dbo.TwoSets", `
+		CREATE PROCEDURE dbo.TwoSets
+		AS
+		BEGIN
+			SELECT 10, 'first'
+			SELECT 20, 'second'
+		END
+	`, nil)
+	resolver.AddProcedure("dbo.Names", `
+		CREATE PROCEDURE dbo.Names
+		AS
+		BEGIN
+			SELECT name FROM products ORDER BY id
+		END
+	`, nil)
+
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetResolver(resolver)
+	return db, interp
+}
+
+// lastRows renders the rows of the last result set of result.
+func lastRows(result *ExecutionResult) []string {
+	var rows []string
+	if len(result.ResultSets) == 0 {
+		return rows
+	}
+	for _, row := range result.ResultSets[len(result.ResultSets)-1].Rows {
+		parts := make([]string, len(row))
+		for i, v := range row {
+			parts[i] = valueString(v)
+		}
+		rows = append(rows, strings.Join(parts, " "))
+	}
+	return rows
+}
+
+func TestInsertExec_TempTable(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"parameter",
+			"CREATE TABLE #t (id INT, name VARCHAR(20)); " +
+				"INSERT INTO #t EXEC dbo.ListProducts @MinID = 1; SELECT * FROM #t",
+			[]string{"2 nut", "3 washer"}},
+		{"identity column skipped",
+			"CREATE TABLE #t (n INT IDENTITY(1, 1), id INT, name VARCHAR(20)); " +
+				"INSERT INTO #t EXEC dbo.ListProducts; SELECT n, name FROM #t",
+			[]string{"1 bolt", "2 nut", "3 washer"}},
+		{"column list",
+			"CREATE TABLE #t (name VARCHAR(20), id INT, note VARCHAR(10)); " +
+				"INSERT INTO #t (id, name) EXEC dbo.ListProducts 2; SELECT * FROM #t",
+			[]string{"washer 3 NULL"}},
+		{"every result set",
+			"DECLARE @t TABLE (id INT, label VARCHAR(10)); " +
+				"INSERT INTO @t EXEC dbo.TwoSets; SELECT * FROM @t",
+			[]string{"10 first", "20 second"}},
+		{"dynamic sql",
+			"CREATE TABLE #t (id INT, name VARCHAR(20)); " +
+				"INSERT INTO #t EXEC('SELECT 7, ''seven'''); SELECT * FROM #t",
+			[]string{"7 seven"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, interp := insertExecSetup(t)
+			defer db.Close()
+
+			result, err := interp.Execute(context.Background(), tt.sql, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The procedure's result sets are captured, not returned
+			if len(result.ResultSets) != 1 {
+				t.Errorf("got %d result sets, want 1", len(result.ResultSets))
+			}
+			if got := lastRows(result); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInsertExec_Table(t *testing.T) {
+	db, interp := insertExecSetup(t)
+	defer db.Close()
+
+	if _, err := interp.Execute(context.Background(), "INSERT INTO picked EXEC dbo.ListProducts @MinID = 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := interp.Execute(context.Background(), "INSERT INTO dbo.picked (id, name) EXEC dbo.TwoSets", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT id, name FROM picked ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id+" "+name)
+	}
+	if want := []string{"2 nut", "3 washer", "10 first", "20 second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestInsertExec_Transaction(t *testing.T) {
+	db, interp := insertExecSetup(t)
+	defer db.Close()
+
+	result, err := interp.Execute(context.Background(),
+		"BEGIN TRANSACTION; INSERT INTO picked EXEC dbo.ListProducts; ROLLBACK; SELECT COUNT(*) FROM picked", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"0"}) {
+		t.Errorf("after rollback got %q, want [0]", got)
+	}
+}
+
+func TestInsertExec_ShapeMismatch(t *testing.T) {
+	for _, sql := range []string{
+		"CREATE TABLE #t (id INT, name VARCHAR(20)); INSERT INTO #t EXEC dbo.Names",
+		"CREATE TABLE #t (id INT); INSERT INTO #t (id) EXEC dbo.ListProducts",
+		"INSERT INTO picked EXEC dbo.Names",
+		"INSERT INTO picked (id) EXEC dbo.TwoSets",
+	} {
+		db, interp := insertExecSetup(t)
+
+		_, err := interp.Execute(context.Background(), sql, nil)
+		var sqlErr *SQLError
+		if !errors.As(err, &sqlErr) || sqlErr.Number != ErrColumnCountMismatch {
+			t.Errorf("%s: got %v, want error %d", sql, err, ErrColumnCountMismatch)
+		}
+
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM picked").Scan(&n); err != nil || n != 0 {
+			t.Errorf("%s: picked has %d rows (%v), want none", sql, n, err)
+		}
+		db.Close()
+	}
+}
+
+func TestInsertExec_Parse(t *testing.T) {
+	for _, sql := range []string{
+		"INSERT INTO #t (a, b) EXEC dbo.P @x = 1, 'y'",
+		"INSERT INTO t EXEC(@sql)",
+	} {
+		if got := parseSQL(t, sql).String(); got != sql {
+			t.Errorf("round trip of %q gave %q", sql, got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package tsqlruntime
+
+import "strings"
+
+// aul keeps its own state, such as statistics, extended properties,
+// logins and permissions, in tables of the database it serves, and holds
+// full-text indexes and partitions of user tables in tables of their own.
+// These internal tables are left out of the catalog views and of
+// statistics. A package that creates one adds it to InternalTables, so
+// that every filter built from the list leaves it out.
+
+// ReservedPrefix begins the name of every table aul creates. A session
+// whose permissions are checked may not name such a table, whatever it is
+// granted (see permissions.go).
+const ReservedPrefix = "aul_"
+
+// CredentialTable is the table pkg/auth keeps logins and their password
+// hashes in. No statement may name it, whoever runs it (see
+// permissions.go).
+const CredentialTable = "aul_logins"
+
+// InternalTables names the tables aul keeps its own state in.
+var InternalTables = []string{
+	ExtendedPropertiesTable,
+	StatisticsTable,
+	NumbersTable,
+	SensitivityTable,
+	MaterializedViewsTable,
+	CredentialTable, "aul_roles", "aul_role_members", "aul_permissions", // pkg/auth
+	"aul_archive_partitions", // pkg/archive
+	"aul_bootstrap",          // pkg/server, init scripts run
+	"aul_cluster_locks",      // pkg/runtime, locks shared by a cluster
+}
+
+// InternalTablePrefixes begin the names of the tables holding full-text
+// indexes, and partitions and the partition catalog.
+var InternalTablePrefixes = []string{FullTextTablePrefix, "aul_partition"}
+
+// IsInternalTable reports whether the unqualified table name is one of
+// aul's internal tables.
+func IsInternalTable(name string) bool {
+	name = strings.ToLower(name)
+	for _, table := range InternalTables {
+		if name == table {
+			return true
+		}
+	}
+	for _, prefix := range InternalTablePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedObject reports whether object, a possibly qualified name, names
+// a table with ReservedPrefix.
+func reservedObject(object string) bool {
+	if idx := strings.LastIndex(object, "."); idx >= 0 {
+		object = object[idx+1:]
+	}
+	return strings.HasPrefix(strings.ToLower(unbracket(object)), ReservedPrefix)
+}
+
+// UserTableFilter returns a SQLite condition on the name column of
+// sqlite_master that leaves out SQLite's tables and aul's internal ones.
+func UserTableFilter() string {
+	var b strings.Builder
+	b.WriteString("name NOT LIKE 'sqlite_%' AND name NOT IN (")
+	for j, table := range InternalTables {
+		if j > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("'" + table + "'")
+	}
+	b.WriteString(")")
+	for _, prefix := range InternalTablePrefixes {
+		b.WriteString(` AND name NOT LIKE '` + strings.ReplaceAll(prefix, "_", `\_`) + `%' ESCAPE '\'`)
+	}
+	return b.String()
+}
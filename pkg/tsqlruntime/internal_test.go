@@ -0,0 +1,38 @@
+package tsqlruntime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUserTableFilter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	for _, table := range []string{"orders", "aulorders", "aul_stats", "aul_logins", "aul_fts_orders", "aul_partition_1_sales", "aul_partitioned_tables", "sales"} {
+		if _, err := db.Exec("CREATE TABLE " + table + " (id INTEGER)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND " + UserTableFilter() + " ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+		if IsInternalTable(name) {
+			t.Errorf("IsInternalTable(%q) = true for a user table", name)
+		}
+	}
+	if got := strings.Join(tables, ","); got != "aulorders,orders,sales" {
+		t.Errorf("user tables %s", got)
+	}
+	if !IsInternalTable("AUL_Logins") || !IsInternalTable("aul_fts_orders") {
+		t.Error("IsInternalTable misses internal tables")
+	}
+}
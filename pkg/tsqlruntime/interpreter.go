@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
 	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
@@ -29,12 +30,19 @@ type ExecutionResult struct {
 	LastInsertID int64
 	ReturnValue  *int64
 	Error        *SQLError
+	Warnings     []string           // Informational messages, e.g. ignored query hints
+	Statements   []StatementSummary // What each statement did, when summarised
+	Trace        *Trace             // How the execution ran, when traced
 }
 
 // ResultSet represents a single result set from a query
 type ResultSet struct {
 	Columns []string
 	Rows    [][]Value
+
+	// Sensitivity classifications of the columns, nil when none is
+	// classified (see sensitivity.go)
+	Sensitivity []*Sensitivity
 }
 
 // ProcedureResolver resolves stored procedure names to their source code.
@@ -46,7 +54,24 @@ type ProcedureResolver interface {
 	Resolve(ctx context.Context, name string, database string) (source string, params []ProcedureParam, err error)
 }
 
+// ProcedureGuard may be implemented by a ProcedureResolver to admit or
+// refuse nested EXEC calls and observe their outcome. Admit is called after
+// the procedure resolves; a non-nil error fails the EXEC without running
+// it. Otherwise done is called with the procedure's error, if any.
+type ProcedureGuard interface {
+	Admit(ctx context.Context, name string, database string) (done func(err error), err error)
+}
+
+// ProcedureRenamer may be implemented by a ProcedureResolver to let
+// sp_rename rename procedures. The new name is unqualified and keeps the
+// procedure in its schema.
+type ProcedureRenamer interface {
+	RenameProcedure(ctx context.Context, name string, database string, newName string) error
+}
+
 // ProcedureParam describes a procedure parameter for nested EXEC calls.
+// An EXEC's arguments are bound to the parameters the procedure's source
+// declares (see params.go), so these only describe them.
 type ProcedureParam struct {
 	Name       string
 	SQLType    string
@@ -55,7 +80,8 @@ type ProcedureParam struct {
 	Default    interface{}
 }
 
-// MaxNestingLevel is the maximum depth of nested procedure calls.
+// MaxNestingLevel is the default maximum depth of nested procedure calls,
+// SQL Server's fixed limit.
 const MaxNestingLevel = 32
 
 // Interpreter executes T-SQL dynamically
@@ -70,10 +96,46 @@ type Interpreter struct {
 	resolver     ProcedureResolver
 	database     string // Current database context
 	nestingLevel int    // Current nesting depth
+	maxNesting   int    // Nesting limit, MaxNestingLevel when not positive
+	callChain    []string // Procedures being run, outermost first
+	ownerChain   bool     // Runs a procedure's statements, unchecked (see permissions.go)
+
+	// Arguments of the EXEC that made this scope, until they are bound to
+	// the procedure's parameters (see params.go)
+	arguments []*procedureArgument
+
+	// Rows of the running loop's insert not yet written (see insertbatch.go)
+	insertBatch *insertBatch
+
+	// The statement being explained, collecting its rewriting steps (see
+	// explain.go)
+	plan *QueryPlan
+
+	// The database's sensitivity classifications, read on first use (see
+	// sensitivity.go)
+	classified map[string]map[string]*Sensitivity
+
+	// The database's materialized views, read on first use (see
+	// materialized.go)
+	matViews map[string]*materializedView
+
+	// The database's partitioned tables, read on first use (see
+	// partition.go)
+	partitioned map[string]*partitionedTable
+
+	// Values of the literals the query being built sends as parameters
+	// (see autoparam.go)
+	autoParams map[string]interface{}
+
+	// Where the rows of the next query go as they are read, instead of
+	// into a result set (see stream.go)
+	rowSink RowSink
 
 	// Options
-	Debug        bool
-	LogRewritten bool                      // Log queries after rewriting
+	Debug            bool
+	LogRewritten     bool // Log queries after rewriting
+	LegacyNormalizer bool // Also run the deprecated string normaliser
+	AutoParameterize bool // Send compared literals as parameters (see autoparam.go)
 	LogFunc      func(format string, args ...interface{}) // Logging callback
 }
 
@@ -86,6 +148,13 @@ func NewInterpreter(db *sql.DB, dialect Dialect) *Interpreter {
 		normalizer: NewSQLNormalizer(dialect),
 		rewriter:   NewASTRewriterForDialect(dialect),
 	}
+	i.evaluator.feature = ctx.feature
+	i.evaluator.statsDate = ctx.statsDate
+	i.evaluator.session = ctx.session
+	i.evaluator.globals = ctx.GetVariable
+	if r, ok := i.rewriter.(*SQLiteRewriter); ok {
+		r.tableSources = i.numbersSources
+	}
 	i.ddl = NewDDLHandler(ctx)
 	return i
 }
@@ -98,6 +167,13 @@ func NewInterpreterWithContext(ctx *ExecutionContext) *Interpreter {
 		normalizer: NewSQLNormalizer(ctx.Dialect),
 		rewriter:   NewASTRewriterForDialect(ctx.Dialect),
 	}
+	i.evaluator.feature = ctx.feature
+	i.evaluator.statsDate = ctx.statsDate
+	i.evaluator.session = ctx.session
+	i.evaluator.globals = ctx.GetVariable
+	if r, ok := i.rewriter.(*SQLiteRewriter); ok {
+		r.tableSources = i.numbersSources
+	}
 	i.ddl = NewDDLHandler(ctx)
 	return i
 }
@@ -120,6 +196,7 @@ func (i *Interpreter) SetDatabase(database string) {
 // SetNestingLevel sets the current nesting level for recursive procedure calls.
 func (i *Interpreter) SetNestingLevel(level int) {
 	i.nestingLevel = level
+	i.evaluator.nestLevel = level
 }
 
 // NestingLevel returns the current nesting level.
@@ -127,18 +204,22 @@ func (i *Interpreter) NestingLevel() int {
 	return i.nestingLevel
 }
 
-// SetVariable sets a variable value
+// SetVariable sets a variable value. Local variables belong to the
+// interpreter's scope; @@ variables to the session.
 func (i *Interpreter) SetVariable(name string, value interface{}) {
 	v := ToValue(value)
+	if isSystemVariable(name) {
+		i.ctx.SetVariable(name, v)
+		return
+	}
 	i.evaluator.SetVariable(name, v)
-	i.ctx.SetVariable(name, v)
 }
 
 // GetVariable gets a variable value
 func (i *Interpreter) GetVariable(name string) (interface{}, bool) {
-	v, ok := i.ctx.GetVariable(name)
-	if !ok {
-		v, ok = i.evaluator.GetVariable(name)
+	v, ok := i.evaluator.GetVariable(name)
+	if !ok || isSystemVariable(name) {
+		v, ok = i.ctx.GetVariable(name)
 		if !ok {
 			return nil, false
 		}
@@ -150,9 +231,10 @@ func (i *Interpreter) GetVariable(name string) (interface{}, bool) {
 func (i *Interpreter) Execute(ctx context.Context, sqlStr string, params map[string]interface{}) (*ExecutionResult, error) {
 	// Set parameters as variables
 	for name, val := range params {
-		v := ToValue(val)
-		i.evaluator.SetVariable(name, v)
-		i.ctx.SetVariable(name, v)
+		i.evaluator.SetVariable(name, ToValue(val))
+	}
+	if i.ctx.Trace != nil {
+		i.traceParameters(params)
 	}
 
 	// Parse SQL
@@ -164,6 +246,9 @@ func (i *Interpreter) Execute(ctx context.Context, sqlStr string, params map[str
 	}
 
 	result := &ExecutionResult{}
+	i.classified = nil
+	i.matViews = nil
+	i.partitioned = nil
 
 	// Execute each statement
 	for _, stmt := range program.Statements {
@@ -189,6 +274,12 @@ func (i *Interpreter) Execute(ctx context.Context, sqlStr string, params map[str
 	result.RowsAffected = i.ctx.RowCount
 	result.LastInsertID = i.ctx.LastInsertID
 	result.ResultSets = i.ctx.ResultSets
+	if i.ctx.Summary != nil {
+		result.Statements = i.ctx.Summary.Statements
+	}
+	if i.ctx.Trace != nil {
+		result.Trace = i.ctx.Trace
+	}
 
 	return result, nil
 }
@@ -226,11 +317,85 @@ func (i *Interpreter) ExecuteNonQuery(ctx context.Context, sql string, params ma
 	return result.RowsAffected, nil
 }
 
-func (i *Interpreter) executeStatement(ctx context.Context, stmt ast.Statement, result *ExecutionResult) error {
+func (i *Interpreter) executeStatement(ctx context.Context, stmt ast.Statement, result *ExecutionResult) (err error) {
 	if i.Debug {
 		fmt.Printf("Executing: %T\n", stmt)
 	}
 
+	// A cancelled or timed out execution stops before its next
+	// statement; the backend is interrupted in the one it is running
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The statement's expressions pass its context to the external
+	// functions they call
+	defer func(outer context.Context) { i.evaluator.ctx = outer }(i.evaluator.ctx)
+	i.evaluator.ctx = ctx
+
+	i.applyQueryHints(stmt, result)
+
+	if err := i.checkPermissions(stmt); err != nil {
+		return err
+	}
+	if i.ctx.ReadOnly && writesDatabase(stmt) {
+		return i.checkReadOnly()
+	}
+	if err := i.checkSandboxStatement(stmt); err != nil {
+		return err
+	}
+	if i.ctx.Explain != ExplainOff {
+		if explained, err := i.explainStatement(ctx, stmt, result); explained {
+			return err
+		}
+	}
+	if i.ctx.Journal != nil {
+		done, jerr := i.journalStatement(stmt)
+		if jerr != nil {
+			return jerr
+		}
+		defer func() { done(err) }()
+	}
+	if err := i.lockWrites(ctx, stmt); err != nil {
+		return err
+	}
+	if i.ctx.Workload != nil {
+		start := time.Now()
+		defer func() {
+			if err == nil {
+				i.recordWorkload(stmt, time.Since(start))
+			}
+		}()
+	}
+	if i.ctx.Summary != nil {
+		start := time.Now()
+		defer func() {
+			if err == nil {
+				i.summarize(stmt, time.Since(start))
+			}
+		}()
+	}
+	if i.ctx.Trace != nil {
+		done := i.traceStatement(stmt)
+		defer func() { done(err) }()
+	}
+	if i.ctx.Coverage != nil {
+		i.cover(stmt, "")
+	}
+	if i.ctx.TableCache != nil {
+		defer i.cacheWritten(stmt)
+	}
+	if i.ctx.Dialect == DialectSQLite {
+		if err := i.checkViewWrite(ctx, stmt); err != nil {
+			return err
+		}
+		defer func() {
+			if err == nil {
+				err = i.refreshWrittenViews(ctx, stmt)
+			}
+		}()
+	}
+
 	switch s := stmt.(type) {
 	case *ast.SelectStatement:
 		return i.executeSelect(ctx, s, result)
@@ -294,13 +459,23 @@ func (i *Interpreter) executeStatement(ctx context.Context, stmt ast.Statement,
 		return i.executeTryCatch(ctx, s, result)
 
 	case *ast.CreateTableStatement:
-		return i.ddl.ExecuteCreateTable(s)
+		if scheme, column, ok := partitionClause(s); ok && i.ctx.Dialect == DialectSQLite && !IsTempTable(s.Name.String()) {
+			return i.executeCreatePartitionedTable(ctx, s, scheme, column)
+		}
+		return i.ddl.ExecuteCreateTable(ctx, s)
 
 	case *ast.DropTableStatement:
-		return i.ddl.ExecuteDropTable(s)
+		drop, err := i.dropPartitioned(ctx, s)
+		if err != nil || drop == nil {
+			return err
+		}
+		return i.ddl.ExecuteDropTable(ctx, drop)
 
 	case *ast.TruncateTableStatement:
-		return i.ddl.ExecuteTruncateTable(s)
+		if p := i.partitionedTable(ctx, s.Table); p != nil {
+			return i.truncatePartitioned(ctx, p)
+		}
+		return i.ddl.ExecuteTruncateTable(ctx, s)
 
 	case *ast.BeginTransactionStatement:
 		return i.ctx.BeginTransaction(ctx)
@@ -342,10 +517,65 @@ func (i *Interpreter) executeStatement(ctx context.Context, stmt ast.Statement,
 		return i.executeCreateProcedure(ctx, s, result)
 
 	case *ast.CreateIndexStatement:
-		return i.ddl.ExecuteCreateIndex(s)
+		if p := i.partitionedTable(ctx, s.Table); p != nil {
+			return i.indexPartitions(ctx, s, p)
+		}
+		if err := i.executeCreateIndex(ctx, s, result); err != nil {
+			return err
+		}
+		return i.indexView(ctx, s)
+
+	case *ast.CreateViewStatement:
+		return i.executeCreateView(ctx, s)
+
+	case *ast.DropObjectStatement:
+		switch s.ObjectType {
+		case "VIEW":
+			return i.executeDropView(ctx, s)
+		case "ROLE":
+			return i.executeDropRole(ctx, s)
+		case "PARTITION FUNCTION", "PARTITION SCHEME":
+			return i.executeDropPartitionObject(ctx, s)
+		}
+		return unsupportedStatementError(s)
+
+	case *ast.CreatePartitionFunctionStatement:
+		return i.executeCreatePartitionFunction(ctx, s)
+
+	case *ast.CreatePartitionSchemeStatement:
+		return i.executeCreatePartitionScheme(ctx, s)
+
+	case *ast.GrantStatement:
+		return i.executePermissionChange(ctx, "GRANT", s.Permissions, s.OnType, s.OnObject, s.Columns, s.ToPrincipals)
+
+	case *ast.DenyStatement:
+		return i.executePermissionChange(ctx, "DENY", s.Permissions, s.OnType, s.OnObject, s.Columns, s.ToPrincipals)
+
+	case *ast.RevokeStatement:
+		return i.executePermissionChange(ctx, "REVOKE", s.Permissions, s.OnType, s.OnObject, s.Columns, s.FromPrincipals)
+
+	case *ast.CreateRoleStatement:
+		return i.executeRoleChange(ctx, RoleChange{Action: "CREATE", Role: s.Name})
+
+	case *ast.AlterRoleStatement:
+		return i.executeAlterRole(ctx, s)
+
+	case *ast.AlterServerRoleStatement:
+		return i.executeAlterServerRole(ctx, s)
+
+	case *ast.UpdateStatisticsStatement:
+		return i.executeUpdateStatistics(ctx, s)
+
+	case *ast.CreateFulltextIndexStatement, *ast.AlterFulltextIndexStatement, *ast.DropFulltextIndexStatement,
+		*ast.CreateFulltextCatalogStatement, *ast.DropFulltextCatalogStatement:
+		return i.executeFulltextStatement(ctx, s)
+
+	case *ast.AddSensitivityClassificationStatement, *ast.DropSensitivityClassificationStatement:
+		return i.executeSensitivityStatement(ctx, s)
 
 	default:
-		return fmt.Errorf("unsupported statement type: %T", stmt)
+		// Statements run here are listed in executableStatement
+		return unsupportedStatementError(stmt)
 	}
 }
 
@@ -365,12 +595,20 @@ func (i *Interpreter) executeSelect(ctx context.Context, s *ast.SelectStatement,
 		return i.executeSelectFromTempTable(ctx, s, result)
 	}
 
+	// Lookups and joins over pinned tables are served from memory
+	if served, err := i.selectFromTableCache(ctx, s, nil, result); served {
+		return err
+	}
+
 	// Check for scalar SELECT (no FROM clause) - evaluate using function registry
 	// This handles queries like SELECT db_name(), SELECT @@VERSION, etc.
 	if i.isScalarSelect(s) {
 		return i.executeScalarSelect(ctx, s, result)
 	}
 
+	// Read before the query is rewritten
+	sensitivity := i.sensitivityOf(ctx, s)
+
 	// Build the query
 	query, args, err := i.buildSelectQuery(s)
 	if err != nil {
@@ -404,23 +642,20 @@ func (i *Interpreter) executeSelect(ctx context.Context, s *ast.SelectStatement,
 		return err
 	}
 
-	rs := ResultSet{Columns: columns}
+	rs := ResultSet{Columns: columns, Sensitivity: sensitivity.columns(columns)}
+	if sink := i.takeRowSink(); sink != nil {
+		return i.streamRows(sink, rows, rs)
+	}
 
 	// Scan rows
+	scanner := newRowScanner(len(columns))
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for j := range values {
-			valuePtrs[j] = &values[j]
-		}
-
-		if err := rows.Scan(valuePtrs...); err != nil {
+		row, err := scanner.scan(rows)
+		if err != nil {
 			return err
 		}
-
-		row := make([]Value, len(columns))
-		for j, v := range values {
-			row[j] = ToValue(v)
+		if err := i.ctx.reserveRow(row); err != nil {
+			return err
 		}
 		rs.Rows = append(rs.Rows, row)
 	}
@@ -445,12 +680,14 @@ func (i *Interpreter) executeWithStatement(ctx context.Context, ws *ast.WithStat
 	case *ast.DeleteStatement:
 		return i.executeWithDelete(ctx, ws, inner)
 	default:
-		return fmt.Errorf("unsupported query type in WITH statement: %T", ws.Query)
+		return unsupportedStatementError(ws.Query)
 	}
 }
 
 // executeWithSelect executes a WITH ... SELECT statement
 func (i *Interpreter) executeWithSelect(ctx context.Context, ws *ast.WithStatement, sel *ast.SelectStatement, result *ExecutionResult) error {
+	sensitivity := i.sensitivityOf(ctx, sel)
+
 	// Build the full CTE query
 	query, args, err := i.buildWithQuery(ws)
 	if err != nil {
@@ -479,23 +716,20 @@ func (i *Interpreter) executeWithSelect(ctx context.Context, ws *ast.WithStateme
 		return err
 	}
 
-	rs := ResultSet{Columns: columns}
+	rs := ResultSet{Columns: columns, Sensitivity: sensitivity.columns(columns)}
+	if sink := i.takeRowSink(); sink != nil {
+		return i.streamRows(sink, rows, rs)
+	}
 
 	// Scan rows
+	scanner := newRowScanner(len(columns))
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for j := range values {
-			valuePtrs[j] = &values[j]
-		}
-
-		if err := rows.Scan(valuePtrs...); err != nil {
+		row, err := scanner.scan(rows)
+		if err != nil {
 			return err
 		}
-
-		row := make([]Value, len(columns))
-		for j, v := range values {
-			row[j] = ToValue(v)
+		if err := i.ctx.reserveRow(row); err != nil {
+			return err
 		}
 		rs.Rows = append(rs.Rows, row)
 	}
@@ -593,12 +827,11 @@ func (i *Interpreter) buildWithQuery(ws *ast.WithStatement) (string, []interface
 	var args []interface{}
 	paramIndex := 0
 
-	// Use the AST's String() method and substitute variables
-	query := ws.String()
+	// AST-level dialect transformation of the CTEs and the main query
+	query := i.rewriter.RewriteStatement(ws).String()
+	i.rewriteStep("dialect", query)
 	query, args, paramIndex = i.substituteVariables(query, args, paramIndex)
-
-	// Normalize for target dialect
-	query = i.normalizer.Normalize(query)
+	query = i.normalize(query)
 
 	return query, args, nil
 }
@@ -614,6 +847,22 @@ func (i *Interpreter) executeInsert(ctx context.Context, s *ast.InsertStatement)
 		return i.executeInsertIntoTempTable(ctx, s)
 	}
 
+	if s.Exec != nil {
+		return i.executeInsertExec(ctx, s)
+	}
+
+	if p := i.partitionedTable(ctx, s.Table); p != nil {
+		return i.insertPartitioned(ctx, s, p)
+	}
+
+	if b := i.insertBatch; b != nil && b.stmt == s {
+		return i.batchInsert(ctx, b)
+	}
+	return i.executeInsertRow(ctx, s)
+}
+
+// executeInsertRow runs an INSERT into a database table on the backend.
+func (i *Interpreter) executeInsertRow(ctx context.Context, s *ast.InsertStatement) error {
 	query, args, err := i.buildInsertQuery(s)
 	if err != nil {
 		return err
@@ -656,6 +905,9 @@ func (i *Interpreter) executeUpdate(ctx context.Context, s *ast.UpdateStatement)
 	if IsTempTable(tableName) || IsTableVariable(tableName) {
 		return i.executeUpdateTempTable(ctx, s)
 	}
+	if p := i.partitionedTable(ctx, s.Table); p != nil {
+		return i.updatePartitioned(ctx, s, p)
+	}
 
 	query, args, err := i.buildUpdateQuery(s)
 	if err != nil {
@@ -697,6 +949,9 @@ func (i *Interpreter) executeDelete(ctx context.Context, s *ast.DeleteStatement)
 	if IsTempTable(tableName) || IsTableVariable(tableName) {
 		return i.executeDeleteFromTempTable(ctx, s)
 	}
+	if p := i.partitionedTable(ctx, s.Table); p != nil {
+		return i.deletePartitioned(ctx, s, p)
+	}
 
 	query, args, err := i.buildDeleteQuery(s)
 	if err != nil {
@@ -731,6 +986,16 @@ func (i *Interpreter) executeDelete(ctx context.Context, s *ast.DeleteStatement)
 func (i *Interpreter) executeSet(s *ast.SetStatement) error {
 	if s.Variable == nil || s.Value == nil {
 		// Handle SET options like SET NOCOUNT ON
+		if s.Option == "DEADLOCK_PRIORITY" {
+			priority, ok := deadlockPriority(s.OnOff)
+			if !ok {
+				return fmt.Errorf("invalid DEADLOCK_PRIORITY %q: must be LOW, NORMAL, HIGH or an integer from -10 to 10", s.OnOff)
+			}
+			if i.ctx.Locks != nil {
+				i.ctx.Locks.SetPriority(priority)
+			}
+			return nil
+		}
 		if s.Option != "" {
 			// Could track options but for now just acknowledge
 			return nil
@@ -754,7 +1019,7 @@ func (i *Interpreter) executeSet(s *ast.SetStatement) error {
 		return fmt.Errorf("unsupported variable type in SET: %T", s.Variable)
 	}
 	
-	i.evaluator.SetVariable(name, value)
+	i.assign(name, value)
 	return nil
 }
 
@@ -848,6 +1113,14 @@ func (i *Interpreter) executeSetOption(s *ast.SetOptionStatement) error {
 
 func (i *Interpreter) executeDeclare(s *ast.DeclareStatement) error {
 	for _, v := range s.Variables {
+		// DECLARE @t TABLE (...) creates an in-memory table variable
+		if v.TableType != nil {
+			if err := i.ddl.DeclareTableVariable(v.Name, i.ddl.parseColumnDefinitions(v.TableType.Columns)); err != nil {
+				return err
+			}
+			continue
+		}
+
 		// Initialize with NULL or default value
 		var value Value
 		if v.Value != nil {
@@ -864,7 +1137,7 @@ func (i *Interpreter) executeDeclare(s *ast.DeclareStatement) error {
 			}
 			value = Null(dt)
 		}
-		i.evaluator.SetVariable(v.Name, value)
+		i.assign(v.Name, value)
 	}
 	return nil
 }
@@ -884,15 +1157,25 @@ func (i *Interpreter) executePrint(s *ast.PrintStatement) error {
 }
 
 func (i *Interpreter) executeExec(ctx context.Context, s *ast.ExecStatement, result *ExecutionResult) error {
+	if s.DryRun {
+		return i.executeDryRun(ctx, s, result)
+	}
+	if s.Trace {
+		return i.executeTraced(ctx, s, result)
+	}
+
 	// Handle EXEC(@sql) - dynamic SQL from variable
 	if s.DynamicSQL != nil {
+		if err := i.checkSandbox(CapabilityDynamicSQL, "Dynamic SQL"); err != nil {
+			return err
+		}
 		sqlVal, err := i.evaluator.Evaluate(s.DynamicSQL)
 		if err != nil {
 			return err
 		}
 
-		// Recursively execute the dynamic SQL
-		return i.executeNestedSQL(ctx, sqlVal.AsString(), result)
+		// Dynamic SQL runs in a scope of its own
+		return i.executeNestedSQL(ctx, sqlVal.AsString(), i.newScope("EXEC()"), result)
 	}
 
 	// Handle procedure calls
@@ -902,21 +1185,42 @@ func (i *Interpreter) executeExec(ctx context.Context, s *ast.ExecStatement, res
 
 		// Handle sp_executesql specially
 		if procNameUpper == "SP_EXECUTESQL" || strings.HasSuffix(procNameUpper, ".SP_EXECUTESQL") {
+			if err := i.checkSandbox(CapabilityDynamicSQL, "Dynamic SQL"); err != nil {
+				return err
+			}
 			return i.executeSpExecuteSQL(ctx, s.Parameters, result)
 		}
+		if name := systemProcedureName(procNameUpper); externalProcedure(name) {
+			if err := i.checkSandbox(CapabilityExternal, strings.ToLower(name)); err != nil {
+				return err
+			}
+		}
+		if systemProcedureName(procNameUpper) == "SP_EXECUTE_SCRIPT" {
+			return i.executeSpExecuteScript(ctx, s.Parameters, s.ReturnVariable)
+		}
+
+		// System procedures that maintain the catalog
+		if handler, ok := systemProcedures[systemProcedureName(procNameUpper)]; ok {
+			return i.executeSystemProcedure(ctx, s, handler, result)
+		}
 
 		// Handle other stored procedures via resolver
-		return i.executeProcedure(ctx, procName, s.Parameters, result)
+		return i.executeProcedure(ctx, procName, s.Parameters, s.ReturnVariable, result)
 	}
 
 	return fmt.Errorf("EXEC statement requires procedure name or dynamic SQL")
 }
 
-// executeProcedure executes a stored procedure by name.
-func (i *Interpreter) executeProcedure(ctx context.Context, procName string, params []*ast.ExecParameter, result *ExecutionResult) error {
+// executeProcedure executes a stored procedure by name in a scope of its
+// own. If returnVar is not nil it is set to the procedure's return code.
+func (i *Interpreter) executeProcedure(ctx context.Context, procName string, params []*ast.ExecParameter, returnVar *ast.Identifier, result *ExecutionResult) (retErr error) {
 	// Check nesting level
-	if i.nestingLevel >= MaxNestingLevel {
-		return fmt.Errorf("maximum procedure nesting level (%d) exceeded", MaxNestingLevel)
+	if err := i.checkNesting(procName); err != nil {
+		return err
+	}
+
+	if err := i.CheckPermission("EXECUTE", procName); err != nil {
+		return err
 	}
 
 	// Check if resolver is available
@@ -925,108 +1229,87 @@ func (i *Interpreter) executeProcedure(ctx context.Context, procName string, par
 	}
 
 	// Resolve the procedure
-	source, procParams, err := i.resolver.Resolve(ctx, procName, i.database)
+	source, _, err := i.resolver.Resolve(ctx, procName, i.database)
 	if err != nil {
 		return fmt.Errorf("failed to resolve procedure %s: %w", procName, err)
 	}
 
-	// Create a child interpreter for nested execution
-	child := NewInterpreterWithContext(i.ctx)
-	child.resolver = i.resolver
-	child.database = i.database
-	child.nestingLevel = i.nestingLevel + 1
-	child.Debug = i.Debug
-
-	// Map parameters by position and name
-	// Build a map of parameter values from the EXEC call
-	paramValues := make(map[string]Value)
-	outputParams := make(map[string]string) // maps proc param name to caller variable name
-
-	for idx, p := range params {
-		var paramName string
-		if p.Name != "" {
-			// Named parameter: EXEC proc @param = value
-			paramName = strings.TrimPrefix(p.Name, "@")
-		} else if idx < len(procParams) {
-			// Positional parameter
-			paramName = strings.TrimPrefix(procParams[idx].Name, "@")
-		} else {
-			return fmt.Errorf("too many parameters for procedure %s", procName)
-		}
-
-		// Evaluate the parameter value
-		val, err := i.evaluator.Evaluate(p.Value)
+	if guard, ok := i.resolver.(ProcedureGuard); ok {
+		done, err := guard.Admit(ctx, procName, i.database)
 		if err != nil {
-			return fmt.Errorf("failed to evaluate parameter %s: %w", paramName, err)
-		}
-
-		paramValues["@"+paramName] = val
-
-		// Track OUTPUT parameters
-		if p.Output {
-			// The value should be a variable reference
-			if varRef, ok := p.Value.(*ast.Identifier); ok {
-				outputParams["@"+paramName] = varRef.Value
-			}
-		}
-	}
-
-	// Set default values for parameters not provided
-	for _, pp := range procParams {
-		pname := pp.Name
-		if !strings.HasPrefix(pname, "@") {
-			pname = "@" + pname
-		}
-		if _, exists := paramValues[pname]; !exists {
-			if pp.HasDefault {
-				paramValues[pname] = ToValue(pp.Default)
-			} else if !pp.IsOutput {
-				// Non-output parameter without default and not provided
-				return fmt.Errorf("missing required parameter %s for procedure %s", pname, procName)
-			}
+			return err
 		}
+		defer func() { done(retErr) }()
 	}
 
-	// Set parameters as variables in the child interpreter
-	for name, val := range paramValues {
-		child.SetVariable(name, FromValue(val))
+	args, err := i.evaluateArguments(procName, params)
+	if err != nil {
+		return err
 	}
+	child := i.newScope(procName)
+	child.arguments = args
+	child.ownerChain = true
 
 	// Execute the procedure source
-	childResult, err := child.Execute(ctx, source, nil) // params already set as variables
+	childResult, err := i.runScope(ctx, child, source)
 	if err != nil {
+		if isNestingLimit(err) {
+			return err // already names every procedure in the chain
+		}
 		return fmt.Errorf("procedure %s execution failed: %w", procName, err)
 	}
 
-	// Copy results to parent result
+	// Copy results to parent result. The return code is the caller's to
+	// assign, not the caller's own return value.
 	result.ResultSets = append(result.ResultSets, childResult.ResultSets...)
+	result.Warnings = append(result.Warnings, childResult.Warnings...)
 	result.RowsAffected += childResult.RowsAffected
-	if childResult.ReturnValue != nil {
-		result.ReturnValue = childResult.ReturnValue
+	if childResult.Trace != nil {
+		result.Trace = childResult.Trace
+	}
+	if returnVar != nil {
+		var rc int64
+		if childResult.ReturnValue != nil {
+			rc = *childResult.ReturnValue
+		}
+		i.assign(returnVar.Value, NewInt(rc))
 	}
 
 	// Copy OUTPUT parameter values back to caller variables
-	for procParam, callerVar := range outputParams {
-		if val, ok := child.GetVariable(procParam); ok {
-			i.SetVariable(callerVar, val)
+	outputParams := make(map[string]string) // maps proc param name to caller variable name
+	for _, arg := range args {
+		if arg.output != "" && arg.param != "" {
+			outputParams[arg.param] = arg.output
 		}
 	}
+	i.copyOutputs(child, outputParams)
 
 	return nil
 }
 
-func (i *Interpreter) executeNestedSQL(ctx context.Context, sql string, result *ExecutionResult) error {
-	l := lexer.New(sql)
-	p := parser.New(l)
-	program := p.ParseProgram()
-	if len(p.Errors()) > 0 {
-		return fmt.Errorf("nested SQL parse error: %s", p.Errors()[0])
+// copyOutputs copies the OUTPUT parameters of a call made in child back
+// to the caller's variables they were passed from.
+func (i *Interpreter) copyOutputs(child *Interpreter, outputs map[string]string) {
+	for param, callerVar := range outputs {
+		if val, ok := child.evaluator.GetVariable(param); ok {
+			i.assign(callerVar, val)
+		}
+	}
+}
+
+// executeNestedSQL executes dynamic SQL in child, a scope made by newScope.
+func (i *Interpreter) executeNestedSQL(ctx context.Context, sql string, child *Interpreter, result *ExecutionResult) error {
+	if err := i.checkNesting(child.callChain[len(child.callChain)-1]); err != nil {
+		return err
 	}
 
-	for _, stmt := range program.Statements {
-		if err := i.executeStatement(ctx, stmt, result); err != nil {
-			return err
-		}
+	childResult, err := i.runScope(ctx, child, sql)
+	if err != nil {
+		return err
+	}
+	result.Warnings = append(result.Warnings, childResult.Warnings...)
+	if childResult.Trace != nil {
+		result.Trace = childResult.Trace
 	}
 	return nil
 }
@@ -1043,52 +1326,78 @@ func (i *Interpreter) executeSpExecuteSQL(ctx context.Context, params []*ast.Exe
 	}
 	sql := sqlVal.AsString()
 
+	// The statement sees only the parameters it declares
+	child := i.newScope("sp_executesql")
+	outputParams := make(map[string]string) // maps statement param name to caller variable name
+
 	// Second parameter is parameter definitions (optional)
 	// Third+ parameters are the actual values
-	// For now, assume parameters are already set as variables
-
-	// Parse and map parameters if provided
 	if len(params) >= 3 {
-		// params[1] is the parameter definition string like N'@p1 int, @p2 varchar(50)'
-		// params[2+] are the actual values
+		// params[1] is the parameter definition string like N'@p1 int, @p2 varchar(50) OUTPUT'
+		// params[2+] are the actual values, by position or by name
 		paramDef, err := i.evaluator.Evaluate(params[1].Value)
 		if err != nil {
 			return err
 		}
 
 		// Parse parameter names from definition
-		paramNames := parseParamDef(paramDef.AsString())
+		paramNames, paramOutputs := parseParamDef(paramDef.AsString())
 
 		// Set parameter values
-		for j := 2; j < len(params) && j-2 < len(paramNames); j++ {
-			val, err := i.evaluator.Evaluate(params[j].Value)
+		for j := 2; j < len(params); j++ {
+			p := params[j]
+			name := strings.TrimPrefix(p.Name, "@")
+			if name == "" {
+				if j-2 >= len(paramNames) {
+					break
+				}
+				name = paramNames[j-2]
+			}
+			val, err := i.evaluator.Evaluate(p.Value)
 			if err != nil {
 				return err
 			}
-			i.evaluator.SetVariable(paramNames[j-2], val)
+			child.assign(name, val)
+
+			if p.Output && paramOutputs[strings.ToLower(name)] {
+				if callerVar := outputTarget(p.Value); callerVar != "" {
+					outputParams[name] = callerVar
+				}
+			}
 		}
 	}
 
-	return i.executeNestedSQL(ctx, sql, result)
+	if err := i.executeNestedSQL(ctx, sql, child, result); err != nil {
+		return err
+	}
+	i.copyOutputs(child, outputParams)
+	return nil
 }
 
-// parseParamDef parses a sp_executesql parameter definition string
-func parseParamDef(def string) []string {
+// parseParamDef parses a sp_executesql parameter definition string,
+// returning the parameter names and, by lower-cased name, which of them
+// are declared OUTPUT.
+func parseParamDef(def string) ([]string, map[string]bool) {
 	var names []string
+	outputs := make(map[string]bool)
 	parts := strings.Split(def, ",")
 	for _, part := range parts {
 		part = strings.TrimSpace(part)
 		if part == "" {
 			continue
 		}
-		// Format: @name type
+		// Format: @name type [OUTPUT]
 		tokens := strings.Fields(part)
 		if len(tokens) >= 1 {
 			name := strings.TrimPrefix(tokens[0], "@")
 			names = append(names, name)
+			last := strings.ToUpper(tokens[len(tokens)-1])
+			if len(tokens) > 2 && (last == "OUTPUT" || last == "OUT") {
+				outputs[strings.ToLower(name)] = true
+			}
 		}
 	}
-	return names
+	return names, outputs
 }
 
 func (i *Interpreter) executeIf(ctx context.Context, s *ast.IfStatement, result *ExecutionResult) error {
@@ -1098,14 +1407,37 @@ func (i *Interpreter) executeIf(ctx context.Context, s *ast.IfStatement, result
 	}
 
 	if cond.IsTruthy() {
+		i.traceBranch(s, "THEN")
+		i.coverBranch(s, BranchThen)
 		return i.executeStatement(ctx, s.Consequence, result)
 	} else if s.Alternative != nil {
+		i.traceBranch(s, "ELSE")
+		i.coverBranch(s, BranchElse)
 		return i.executeStatement(ctx, s.Alternative, result)
 	}
+	i.traceBranch(s, "NONE")
+	i.coverBranch(s, BranchNone)
 	return nil
 }
 
-func (i *Interpreter) executeWhile(ctx context.Context, s *ast.WhileStatement, result *ExecutionResult) error {
+func (i *Interpreter) executeWhile(ctx context.Context, s *ast.WhileStatement, result *ExecutionResult) (err error) {
+	// A loop that only inserts rows writes them in batches
+	if i.insertBatch == nil && i.ctx.Journal == nil {
+		if ins := batchableInsert(s.Body); ins != nil {
+			b := &insertBatch{stmt: ins}
+			i.insertBatch = b
+			defer func() {
+				i.insertBatch = nil
+				if ferr := i.flushInserts(ctx, b); err == nil {
+					err = ferr
+				}
+				if err == nil {
+					err = i.refreshWrittenViews(ctx, b.stmt)
+				}
+			}()
+		}
+	}
+
 	maxIterations := 10000 // Safety limit
 	for iter := 0; iter < maxIterations; iter++ {
 		cond, err := i.evaluator.Evaluate(s.Condition)
@@ -1114,8 +1446,12 @@ func (i *Interpreter) executeWhile(ctx context.Context, s *ast.WhileStatement, r
 		}
 
 		if !cond.IsTruthy() {
+			i.traceLoop(s, iter+1, false)
+			i.coverBranch(s, BranchExit)
 			break
 		}
+		i.traceLoop(s, iter+1, true)
+		i.coverBranch(s, BranchLoop)
 
 		if err := i.executeStatement(ctx, s.Body, result); err != nil {
 			return err
@@ -1130,32 +1466,31 @@ func (i *Interpreter) buildSelectQuery(s *ast.SelectStatement) (string, []interf
 	var args []interface{}
 	paramIndex := 0
 
+	// CONTAINS and FREETEXT become lookups in full-text index tables
+	stmt, err := i.rewriteFullText(s)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Archival tables read over archived ranges read those rows too
+	stmt, err = i.rewriteArchive(stmt.(*ast.SelectStatement))
+	if err != nil {
+		return "", nil, err
+	}
+
 	// AST-level dialect transformation (functions, TOP->LIMIT, types)
-	rewritten := i.rewriter.RewriteStatement(s)
+	rewritten := i.rewriter.RewriteStatement(stmt)
 	sel := rewritten.(*ast.SelectStatement)
 
 	// Generate SQL from transformed AST
+	restore := i.parameterizeLiterals(sel)
 	query := sel.String()
+	restore()
+	i.rewriteStep("dialect", query)
 
-	// Handle LIMIT for dialects that moved TOP to Fetch
-	// The rewriter sets Fetch when converting TOP to LIMIT
-	if sel.Fetch != nil && sel.Top == nil {
-		// Append LIMIT clause (AST String() emits FETCH FIRST...ROWS which isn't SQLite)
-		// We need to manually append LIMIT
-		if !strings.Contains(strings.ToUpper(query), "LIMIT") {
-			query = strings.TrimRight(query, "; \t\n")
-			// Remove any FETCH clause that was added
-			if idx := strings.Index(strings.ToUpper(query), "FETCH "); idx > 0 {
-				query = query[:idx]
-			}
-			query = strings.TrimRight(query, " \t\n") + " LIMIT " + sel.Fetch.String()
-		}
-	}
-
-	// String-based normalization for anything not yet handled at AST level
-	// NOTE: Must happen BEFORE variable substitution so patterns can match @variables
-	// TODO: Remove this once all transformations are at AST level
-	query = i.normalizer.Normalize(query)
+	// The legacy normaliser's patterns match @variables, so it runs
+	// before they are substituted
+	query = i.normalize(query)
 
 	// Substitute variables with placeholders
 	query, args, paramIndex = i.substituteVariables(query, args, paramIndex)
@@ -1167,15 +1502,22 @@ func (i *Interpreter) buildInsertQuery(s *ast.InsertStatement) (string, []interf
 	var args []interface{}
 	paramIndex := 0
 
+	// CONTAINS and FREETEXT become lookups in full-text index tables
+	stmt, err := i.rewriteFullText(s)
+	if err != nil {
+		return "", nil, err
+	}
+
 	// AST-level dialect transformation
-	rewritten := i.rewriter.RewriteStatement(s)
+	rewritten := i.rewriter.RewriteStatement(stmt)
 	ins := rewritten.(*ast.InsertStatement)
 
+	restore := i.parameterizeLiterals(ins)
 	query := ins.String()
+	restore()
+	i.rewriteStep("dialect", query)
 	query, args, paramIndex = i.substituteVariables(query, args, paramIndex)
-
-	// String-based normalization for remaining cases
-	query = i.normalizer.Normalize(query)
+	query = i.normalize(query)
 
 	return query, args, nil
 }
@@ -1184,15 +1526,22 @@ func (i *Interpreter) buildUpdateQuery(s *ast.UpdateStatement) (string, []interf
 	var args []interface{}
 	paramIndex := 0
 
+	// CONTAINS and FREETEXT become lookups in full-text index tables
+	stmt, err := i.rewriteFullText(s)
+	if err != nil {
+		return "", nil, err
+	}
+
 	// AST-level dialect transformation
-	rewritten := i.rewriter.RewriteStatement(s)
+	rewritten := i.rewriter.RewriteStatement(stmt)
 	upd := rewritten.(*ast.UpdateStatement)
 
+	restore := i.parameterizeLiterals(upd)
 	query := upd.String()
+	restore()
+	i.rewriteStep("dialect", query)
 	query, args, paramIndex = i.substituteVariables(query, args, paramIndex)
-
-	// String-based normalization for remaining cases
-	query = i.normalizer.Normalize(query)
+	query = i.normalize(query)
 
 	return query, args, nil
 }
@@ -1201,21 +1550,43 @@ func (i *Interpreter) buildDeleteQuery(s *ast.DeleteStatement) (string, []interf
 	var args []interface{}
 	paramIndex := 0
 
+	// CONTAINS and FREETEXT become lookups in full-text index tables
+	stmt, err := i.rewriteFullText(s)
+	if err != nil {
+		return "", nil, err
+	}
+
 	// AST-level dialect transformation
-	rewritten := i.rewriter.RewriteStatement(s)
+	rewritten := i.rewriter.RewriteStatement(stmt)
 	del := rewritten.(*ast.DeleteStatement)
 
+	restore := i.parameterizeLiterals(del)
 	query := del.String()
+	restore()
+	i.rewriteStep("dialect", query)
 	query, args, paramIndex = i.substituteVariables(query, args, paramIndex)
-
-	// String-based normalization for remaining cases
-	query = i.normalizer.Normalize(query)
+	query = i.normalize(query)
 
 	return query, args, nil
 }
 
+// normalize runs the deprecated string normaliser over query when
+// LegacyNormalizer is set. The AST rewriter now makes every translation
+// it did; the option remains for a release in case one was missed.
+func (i *Interpreter) normalize(query string) string {
+	if !i.LegacyNormalizer {
+		return query
+	}
+	query = i.normalizer.Normalize(query)
+	i.rewriteStep("legacy normalizer", query)
+	return query
+}
+
 // substituteVariables replaces @variable references with parameter placeholders
 func (i *Interpreter) substituteVariables(query string, args []interface{}, startIndex int) (string, []interface{}, int) {
+	query = i.substituteFeatures(query)
+	query = i.substituteSession(query)
+
 	// Find all @variable references and replace with placeholders
 	var result strings.Builder
 	idx := startIndex
@@ -1254,7 +1625,11 @@ func (i *Interpreter) substituteVariables(query string, args []interface{}, star
 			}
 
 			varName := query[pos+1 : end]
-			if val, ok := i.evaluator.GetVariable(varName); ok {
+			if val, ok := i.autoParams[varName]; ok {
+				result.WriteString(i.getPlaceholder(idx))
+				args = append(args, val)
+				idx++
+			} else if val, ok := i.evaluator.GetVariable(varName); ok {
 				// Replace with placeholder
 				placeholder := i.getPlaceholder(idx)
 				result.WriteString(placeholder)
@@ -1271,6 +1646,7 @@ func (i *Interpreter) substituteVariables(query string, args []interface{}, star
 		}
 	}
 
+	i.rewriteStep("parameters", result.String())
 	return result.String(), args, idx
 }
 
@@ -1315,12 +1691,19 @@ func (i *Interpreter) executeTryCatch(ctx context.Context, s *ast.TryCatchStatem
 	// Exit TRY block
 	i.ctx.ErrorHandler.ExitTry()
 
+	// An attention or a timeout ends the batch, as in SQL Server: CATCH
+	// does not see it
+	if tryErr != nil && ctx.Err() != nil {
+		return tryErr
+	}
+
 	// If there was an error, execute CATCH block
 	if tryErr != nil {
 		// Record the error
 		sqlErr := WrapError(tryErr)
 		i.ctx.ErrorHandler.HandleError(sqlErr)
 		i.ctx.UpdateError(sqlErr.Number)
+		i.traceCatch(s, tryErr)
 
 		// Execute CATCH block
 		i.ctx.ErrorHandler.EnterCatch()
@@ -1509,68 +1892,54 @@ func (i *Interpreter) isSelectFromTempTable(s *ast.SelectStatement) bool {
 }
 
 func (i *Interpreter) executeSelectFromTempTable(ctx context.Context, s *ast.SelectStatement, result *ExecutionResult) error {
-	// For now, handle simple SELECT * FROM #temp
+	table, err := i.tempTableForSelect(s)
+	if err != nil {
+		return err
+	}
+
+	rs, err := i.queryTempTable(s, table)
+	if err != nil {
+		return err
+	}
+	for _, row := range rs.Rows {
+		if err := i.ctx.reserveRow(row); err != nil {
+			return err
+		}
+	}
+
+	result.ResultSets = append(result.ResultSets, rs)
+	i.ctx.UpdateRowCount(int64(len(rs.Rows)))
+	i.ctx.AddResultSet(rs)
+
+	return nil
+}
+
+// tempTableForSelect returns the temp table or table variable s selects from.
+func (i *Interpreter) tempTableForSelect(s *ast.SelectStatement) (*TempTable, error) {
+	// Joins and multiple tables are not supported in memory
 	if s.From == nil || len(s.From.Tables) != 1 {
-		return fmt.Errorf("complex temp table queries not yet supported")
+		return nil, fmt.Errorf("complex temp table queries not yet supported")
 	}
 
 	tableName, ok := s.From.Tables[0].(*ast.TableName)
 	if !ok || tableName.Name == nil {
-		return fmt.Errorf("complex temp table queries not yet supported")
+		return nil, fmt.Errorf("complex temp table queries not yet supported")
 	}
 
 	name := tableName.Name.String()
 
-	var table *TempTable
 	if IsTempTable(name) {
 		t, ok := i.ctx.TempTables.GetTempTable(name)
 		if !ok {
-			return fmt.Errorf("temp table %s does not exist", name)
+			return nil, fmt.Errorf("temp table %s does not exist", name)
 		}
-		table = t
-	} else {
-		tv, ok := i.ctx.TempTables.GetTableVariable(name)
-		if !ok {
-			return fmt.Errorf("table variable %s does not exist", name)
-		}
-		table = tv.TempTable
+		return t, nil
 	}
-
-	// Build predicate from WHERE clause
-	var predicate func([]Value) bool
-	if s.Where != nil {
-		predicate = func(row []Value) bool {
-			// Set up row values as variables for evaluation
-			for j, col := range table.Columns {
-				i.evaluator.SetVariable(col.Name, row[j])
-			}
-			result, err := i.evaluator.Evaluate(s.Where)
-			if err != nil {
-				return false
-			}
-			return result.IsTruthy()
-		}
-	}
-
-	// Get column names
-	columns := make([]string, len(table.Columns))
-	for j, col := range table.Columns {
-		columns[j] = col.Name
-	}
-
-	// Select rows
-	rows := table.Select(predicate)
-
-	rs := ResultSet{
-		Columns: columns,
-		Rows:    rows,
+	tv, ok := i.ctx.TempTables.GetTableVariable(name)
+	if !ok {
+		return nil, fmt.Errorf("table variable %s does not exist", name)
 	}
-
-	result.ResultSets = append(result.ResultSets, rs)
-	i.ctx.UpdateRowCount(int64(len(rows)))
-	i.ctx.AddResultSet(rs)
-
-	return nil
+	return tv.TempTable, nil
 }
 
 func (i *Interpreter) executeSelectInto(ctx context.Context, s *ast.SelectStatement, result *ExecutionResult) error {
@@ -1603,19 +1972,12 @@ func (i *Interpreter) executeSelectInto(ctx context.Context, s *ast.SelectStatem
 	}
 
 	var resultRows [][]Value
+	scanner := newRowScanner(len(columns))
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for j := range values {
-			valuePtrs[j] = &values[j]
-		}
-		if err := rows.Scan(valuePtrs...); err != nil {
+		row, err := scanner.scan(rows)
+		if err != nil {
 			return err
 		}
-		row := make([]Value, len(columns))
-		for j, v := range values {
-			row[j] = ToValue(v)
-		}
 		resultRows = append(resultRows, row)
 	}
 
@@ -1666,6 +2028,11 @@ func (i *Interpreter) executeInsertIntoTempTable(ctx context.Context, s *ast.Ins
 		return nil
 	}
 
+	// Handle INSERT ... EXEC
+	if s.Exec != nil {
+		return i.executeInsertExecTempTable(ctx, s, table)
+	}
+
 	// Handle INSERT ... SELECT
 	if s.Select != nil {
 		// Execute the SELECT
@@ -1870,22 +2237,19 @@ func (i *Interpreter) executeOpenCursor(ctx context.Context, s *ast.OpenCursorSt
 		return err
 	}
 
+	// The snapshot is charged to the memory budget until CLOSE
 	var resultRows [][]Value
+	var snapshot int64
+	scanner := newRowScanner(len(columns))
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for j := range values {
-			valuePtrs[j] = &values[j]
-		}
-
-		if err := rows.Scan(valuePtrs...); err != nil {
+		row, err := scanner.scan(rows)
+		if err != nil {
 			return err
 		}
-
-		row := make([]Value, len(columns))
-		for j, v := range values {
-			row[j] = ToValue(v)
+		if err := i.ctx.reserveRow(row); err != nil {
+			return err
 		}
+		snapshot += RowSize(row)
 		resultRows = append(resultRows, row)
 	}
 
@@ -1893,7 +2257,12 @@ func (i *Interpreter) executeOpenCursor(ctx context.Context, s *ast.OpenCursorSt
 		return err
 	}
 
-	return cursor.Open(columns, resultRows)
+	if err := cursor.Open(columns, resultRows); err != nil {
+		i.ctx.releaseBytes(snapshot)
+		return err
+	}
+	cursor.bytes = snapshot
+	return nil
 }
 
 func (i *Interpreter) executeFetch(ctx context.Context, s *ast.FetchStatement) error {
@@ -1958,8 +2327,7 @@ func (i *Interpreter) executeFetch(ctx context.Context, s *ast.FetchStatement) e
 		for j, v := range s.IntoVars {
 			if j < len(row) {
 				varName := v.Name
-				i.evaluator.SetVariable(varName, row[j])
-				i.ctx.SetVariable(varName, row[j])
+				i.assign(varName, row[j])
 			}
 		}
 	}
@@ -1979,7 +2347,13 @@ func (i *Interpreter) executeCloseCursor(s *ast.CloseCursorStatement) error {
 		return fmt.Errorf("cursor %s does not exist", name)
 	}
 
-	return cursor.Close()
+	snapshot := cursor.bytes
+	if err := cursor.Close(); err != nil {
+		return err
+	}
+	cursor.bytes = 0
+	i.ctx.releaseBytes(snapshot)
+	return nil
 }
 
 func (i *Interpreter) executeDeallocateCursor(s *ast.DeallocateCursorStatement) error {
@@ -2003,26 +2377,22 @@ func (i *Interpreter) executeCreateProcedure(ctx context.Context, s *ast.CreateP
 		return fmt.Errorf("nil CreateProcedureStatement")
 	}
 
-	// Set up procedure parameters as variables with their default values
-	for _, param := range s.Parameters {
-		paramName := param.Name
-		if !strings.HasPrefix(paramName, "@") {
-			paramName = "@" + paramName
+	// Bind the EXEC's arguments to the parameters, or, for a procedure a
+	// host runs, give those it did not set their defaults (see params.go)
+	procName := s.Name.String()
+	if i.arguments != nil {
+		args := i.arguments
+		i.arguments = nil
+		if err := i.bindArguments(procName, s.Parameters, args); err != nil {
+			return err
 		}
-
-		// Check if parameter was provided in execution context
-		// (these would have been set before Execute was called)
-		if _, exists := i.evaluator.GetVariable(paramName); !exists {
-			// Parameter not provided, use default if available
-			if param.Default != nil {
-				val, err := i.evaluator.Evaluate(param.Default)
-				if err != nil {
-					return fmt.Errorf("failed to evaluate default for parameter %s: %w", paramName, err)
-				}
-				i.evaluator.SetVariable(paramName, val)
-			} else {
-				// No default, set to NULL
-				i.evaluator.SetVariable(paramName, Null(TypeUnknown))
+	} else {
+		for _, param := range s.Parameters {
+			if _, exists := i.evaluator.GetVariable(param.Name); exists {
+				continue
+			}
+			if err := i.setParameterDefault(procName, param, false); err != nil {
+				return err
 			}
 		}
 	}
@@ -2091,6 +2461,9 @@ func (i *Interpreter) executeSelectWithVariableAssignment(ctx context.Context, s
 		// Handle temp table SELECT with variable assignment
 		return i.executeSelectFromTempTableWithVars(ctx, &cleanSelect, varNames, result)
 	}
+	if served, err := i.selectFromTableCache(ctx, &cleanSelect, varNames, result); served {
+		return err
+	}
 	
 	// Build and execute the clean query
 	query, args, err := i.buildSelectQuery(&cleanSelect)
@@ -2137,8 +2510,7 @@ func (i *Interpreter) executeSelectWithVariableAssignment(ctx context.Context, s
 		for j, varName := range varNames {
 			if varName != "" && j < len(values) {
 				v := ToValue(values[j])
-				i.evaluator.SetVariable(varName, v)
-				i.ctx.SetVariable(varName, v)
+				i.assign(varName, v)
 			}
 		}
 		
@@ -2150,38 +2522,29 @@ func (i *Interpreter) executeSelectWithVariableAssignment(ctx context.Context, s
 	return rows.Err()
 }
 
-// executeSelectFromTempTableWithVars handles SELECT @var = col FROM #temp
+// executeSelectFromTempTableWithVars handles SELECT @var = col FROM #temp.
+// As in SQL Server, the variables take their values from the last row.
 func (i *Interpreter) executeSelectFromTempTableWithVars(ctx context.Context, s *ast.SelectStatement, varNames []string, result *ExecutionResult) error {
-	// Get the temp table
-	var tableName string
-	if s.From != nil && len(s.From.Tables) > 0 {
-		if tn, ok := s.From.Tables[0].(*ast.TableName); ok && tn.Name != nil {
-			tableName = tn.Name.String()
-		}
+	table, err := i.tempTableForSelect(s)
+	if err != nil {
+		return err
 	}
-	
-	table, ok := i.ctx.TempTables.GetTempTable(tableName)
-	if !ok {
-		return fmt.Errorf("temp table not found: %s", tableName)
+
+	rs, err := i.queryTempTable(s, table)
+	if err != nil {
+		return err
 	}
-	
-	// Get all rows from temp table
-	rows := table.SelectAll()
-	
-	// Apply WHERE filter if present (simplified - just handle basic case)
-	// For full implementation, would need to evaluate WHERE expression against each row
-	
-	// Assign first row values to variables
-	if len(rows) > 0 {
-		row := rows[0]
+
+	// With no rows the variables keep their previous values
+	if len(rs.Rows) > 0 {
+		row := rs.Rows[len(rs.Rows)-1]
 		for j, varName := range varNames {
 			if varName != "" && j < len(row) {
-				i.evaluator.SetVariable(varName, row[j])
-				i.ctx.SetVariable(varName, row[j])
+				i.assign(varName, row[j])
 			}
 		}
-		i.ctx.UpdateRowCount(1)
 	}
-	
+	i.ctx.UpdateRowCount(int64(len(rs.Rows)))
+
 	return nil
 }
@@ -0,0 +1,42 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInterruptedQuery(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Ten billion rows: the backend is interrupted, not waited for
+	query := "WITH d(n) AS (SELECT 0 UNION ALL SELECT n + 1 FROM d WHERE n < 99999) " +
+		"SELECT COUNT(*) FROM d a CROSS JOIN d b"
+	for _, sql := range []string{
+		query,
+		"BEGIN TRY " + query + " END TRY BEGIN CATCH SELECT 'caught' END CATCH",
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		_, err := NewInterpreter(db, DialectSQLite).Execute(ctx, sql, nil)
+		cancel()
+		if err == nil {
+			t.Fatalf("%s: no error", sql)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: took %v to stop", sql, elapsed)
+		}
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			t.Errorf("%s: failed before its timeout: %v", sql, err)
+		}
+	}
+
+	// Nor does an execution go on to its next statement
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewInterpreter(db, DialectSQLite).Execute(ctx, "SELECT 1", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled execution: got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package tsqlruntime
+
+import (
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// StatementJournal records the writes an execution applies, so that work
+// left partially applied by a crash can be reported after a restart.
+//
+// Each write is recorded before it runs and again once it has finished.
+// Writes made outside a transaction are durable as soon as they finish;
+// writes made inside one only become durable when the outermost
+// transaction commits, so its outcome is recorded as well.
+type StatementJournal interface {
+	// BeginWrite records a write about to run against target. If it
+	// fails the statement is not run.
+	BeginWrite(kind, target, text string, inTransaction bool) error
+	// EndWrite records the outcome of the write last begun.
+	EndWrite(rows int64, err error)
+	// Transaction records the end of the outermost transaction.
+	Transaction(committed bool)
+}
+
+// maxJournalText bounds the statement text kept in journal records.
+const maxJournalText = 200
+
+// SetJournal sets the journal that records the writes of this execution.
+func (i *Interpreter) SetJournal(journal StatementJournal) {
+	i.ctx.Journal = journal
+}
+
+// journalWrite returns the kind and target of a statement that changes
+// persistent state. Writes to temp tables and table variables are not
+// journalled since they do not survive a restart.
+func journalWrite(stmt ast.Statement) (kind, target string, ok bool) {
+	kind, targets := statementWrite(stmt)
+	var names []string
+	for _, t := range targets {
+		if !isTransientTable(t) {
+			names = append(names, t)
+		}
+	}
+	if len(names) == 0 {
+		return "", "", false
+	}
+	return kind, strings.Join(names, ", "), true
+}
+
+// statementWrite returns the kind of write stmt makes and the tables it
+// writes, or no tables if it makes none.
+func statementWrite(stmt ast.Statement) (kind string, targets []string) {
+	name := func(q *ast.QualifiedIdentifier) []string {
+		if q == nil {
+			return []string{""}
+		}
+		return []string{q.String()}
+	}
+
+	switch s := stmt.(type) {
+	case *ast.InsertStatement:
+		return "INSERT", name(s.Table)
+	case *ast.UpdateStatement:
+		return "UPDATE", name(s.Table)
+	case *ast.DeleteStatement:
+		return "DELETE", name(s.Table)
+	case *ast.MergeStatement:
+		return "MERGE", name(s.Target)
+	case *ast.SelectStatement:
+		if s.Into != nil {
+			return "SELECT INTO", name(s.Into)
+		}
+	case *ast.CreateTableStatement:
+		return "CREATE TABLE", name(s.Name)
+	case *ast.DropTableStatement:
+		for _, t := range s.Tables {
+			targets = append(targets, name(t)...)
+		}
+		return "DROP TABLE", targets
+	case *ast.TruncateTableStatement:
+		return "TRUNCATE TABLE", name(s.Table)
+	case *ast.CreateIndexStatement:
+		return "CREATE INDEX", name(s.Table)
+	case *ast.CreateViewStatement:
+		return "CREATE VIEW", name(s.Name)
+	case *ast.DropObjectStatement:
+		if s.ObjectType == "VIEW" {
+			for _, v := range s.Names {
+				targets = append(targets, name(v)...)
+			}
+			return "DROP VIEW", targets
+		}
+	}
+	return "", nil
+}
+
+// isTransientTable reports whether name is a temp table or table variable.
+func isTransientTable(name string) bool {
+	return strings.HasPrefix(name, "#") || strings.HasPrefix(name, "@")
+}
+
+// journalText renders stmt for a journal record.
+func journalText(stmt ast.Statement) string {
+	text := strings.Join(strings.Fields(stmt.String()), " ")
+	if r := []rune(text); len(r) > maxJournalText {
+		text = string(r[:maxJournalText]) + "..."
+	}
+	return text
+}
+
+// journalStatement records stmt in the journal ahead of running it. The
+// returned function records the outcome and must be called once it has.
+func (i *Interpreter) journalStatement(stmt ast.Statement) (func(err error), error) {
+	journal := i.ctx.Journal
+	inTransaction := i.ctx.Tx != nil
+
+	switch stmt.(type) {
+	case *ast.CommitTransactionStatement, *ast.RollbackTransactionStatement:
+		return func(err error) {
+			if inTransaction && i.ctx.Tx == nil {
+				_, commit := stmt.(*ast.CommitTransactionStatement)
+				journal.Transaction(commit && err == nil)
+			}
+		}, nil
+	}
+
+	kind, target, ok := journalWrite(stmt)
+	if !ok {
+		return func(error) {}, nil
+	}
+	if err := journal.BeginWrite(kind, target, journalText(stmt), inTransaction); err != nil {
+		return nil, err
+	}
+	return func(err error) {
+		journal.EndWrite(i.ctx.RowCount, err)
+	}, nil
+}
@@ -0,0 +1,94 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// testJournal records journal calls as strings.
+type testJournal struct {
+	calls []string
+}
+
+func (j *testJournal) BeginWrite(kind, target, text string, inTransaction bool) error {
+	call := "begin " + kind + " " + target
+	if inTransaction {
+		call += " (txn)"
+	}
+	j.calls = append(j.calls, call)
+	return nil
+}
+
+func (j *testJournal) EndWrite(rows int64, err error) {
+	if err != nil {
+		j.calls = append(j.calls, "failed")
+		return
+	}
+	j.calls = append(j.calls, "done")
+}
+
+func (j *testJournal) Transaction(committed bool) {
+	if committed {
+		j.calls = append(j.calls, "commit")
+	} else {
+		j.calls = append(j.calls, "rollback")
+	}
+}
+
+func TestJournal_RecordsWrites(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	journal := &testJournal{}
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetJournal(journal)
+
+	_, err := interp.Execute(context.Background(), `
+		CREATE TABLE t (v INT);
+		CREATE TABLE #scratch (v INT);
+		INSERT INTO #scratch (v) VALUES (1);
+		SELECT v FROM t;
+		INSERT INTO t (v) VALUES (1);
+		BEGIN TRANSACTION;
+		UPDATE t SET v = 2;
+		IF 1 = 1
+		BEGIN
+			COMMIT TRANSACTION;
+		END
+		BEGIN TRANSACTION;
+		DELETE FROM t;
+		ROLLBACK TRANSACTION;`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"begin CREATE TABLE t", "done",
+		"begin INSERT t", "done",
+		"begin UPDATE t (txn)", "done",
+		"commit",
+		"begin DELETE t (txn)", "done",
+		"rollback",
+	}
+	if !reflect.DeepEqual(journal.calls, want) {
+		t.Errorf("journal calls:\n got %q\nwant %q", journal.calls, want)
+	}
+}
+
+func TestJournal_RecordsFailedWrite(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	journal := &testJournal{}
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetJournal(journal)
+
+	if _, err := interp.Execute(context.Background(), "INSERT INTO missing (v) VALUES (1)", nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	want := []string{"begin INSERT missing", "failed"}
+	if !reflect.DeepEqual(journal.calls, want) {
+		t.Errorf("journal calls = %q, want %q", journal.calls, want)
+	}
+}
@@ -0,0 +1,86 @@
+package tsqlruntime
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// TableLocker serialises the transactions of concurrent sessions on the
+// tables they write. A transaction locks each table it writes before the
+// write reaches the backend and holds the lock until it commits or rolls
+// back, so two transactions that each wait for a table the other holds
+// are a deadlock the locker can see and break. Writes outside an explicit
+// transaction, and writes to temp tables and table variables, which no
+// other session can see, take no locks.
+type TableLocker interface {
+	// Lock waits until the transaction holds an exclusive lock on table.
+	// It fails with error 1205 if the transaction is chosen as the
+	// victim of a deadlock, and with ctx's error if ctx ends first.
+	Lock(ctx context.Context, table string) error
+	// SetPriority sets the DEADLOCK_PRIORITY, from -10 to 10. Of the
+	// transactions in a deadlock, the one with the lowest is the victim.
+	SetPriority(priority int)
+	// ReleaseAll releases every lock held, as the transaction ends.
+	ReleaseAll()
+}
+
+// SetLocker sets the table locker of this execution's transactions.
+func (i *Interpreter) SetLocker(locker TableLocker) {
+	i.ctx.Locks = locker
+}
+
+// lockWrites locks the tables stmt writes when it runs in a transaction.
+// A deadlock victim's transaction is rolled back at once, so that the
+// transactions it blocked can go on even if the error is caught.
+func (i *Interpreter) lockWrites(ctx context.Context, stmt ast.Statement) error {
+	if i.ctx.Locks == nil || i.ctx.Tx == nil {
+		return nil
+	}
+	_, target, ok := journalWrite(stmt)
+	if !ok {
+		return nil
+	}
+	for _, table := range strings.Split(target, ", ") {
+		if isTransientTable(table) {
+			continue
+		}
+		if err := i.ctx.Locks.Lock(ctx, lockResource(table)); err != nil {
+			if WrapError(err).Number == ErrDeadlock {
+				i.ctx.RollbackTransaction()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// lockResource returns the name table is locked under: lower case,
+// unbracketed and schema-qualified, so that every spelling of a table
+// locks the same resource.
+func lockResource(table string) string {
+	name := strings.ToLower(strings.NewReplacer("[", "", "]", "").Replace(table))
+	if !strings.Contains(name, ".") {
+		name = "dbo." + name
+	}
+	return name
+}
+
+// deadlockPriority parses the value of SET DEADLOCK_PRIORITY.
+func deadlockPriority(value string) (int, bool) {
+	switch strings.ToUpper(value) {
+	case "LOW":
+		return -5, true
+	case "NORMAL":
+		return 0, true
+	case "HIGH":
+		return 5, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < -10 || n > 10 {
+		return 0, false
+	}
+	return n, true
+}
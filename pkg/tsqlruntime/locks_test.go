@@ -0,0 +1,146 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakeLocker records the locks an interpreter takes, failing the lock of
+// deadlockOn as a deadlock victim.
+type fakeLocker struct {
+	locked     []string
+	held       int
+	released   int
+	priority   int
+	deadlockOn string
+}
+
+func (l *fakeLocker) Lock(ctx context.Context, table string) error {
+	if table == l.deadlockOn {
+		return errors.New("Transaction was deadlocked on lock resources with another process and has been chosen as the deadlock victim")
+	}
+	l.locked = append(l.locked, table)
+	l.held++
+	return nil
+}
+
+func (l *fakeLocker) SetPriority(priority int) { l.priority = priority }
+
+func (l *fakeLocker) ReleaseAll() {
+	l.held = 0
+	l.released++
+}
+
+func lockSetup(t *testing.T, locker *fakeLocker) *Interpreter {
+	t.Helper()
+	interp := scopeSetup(t, newMockResolver())
+	interp.SetLocker(locker)
+	if _, err := interp.Execute(context.Background(), "CREATE TABLE accounts (id INT, balance INT); CREATE TABLE audit_log (id INT); INSERT INTO accounts VALUES (1, 100)", nil); err != nil {
+		t.Fatal(err)
+	}
+	return interp
+}
+
+func TestLocks_Transaction(t *testing.T) {
+	locker := &fakeLocker{}
+	interp := lockSetup(t, locker)
+	if len(locker.locked) != 0 {
+		t.Fatalf("writes outside a transaction locked %q", locker.locked)
+	}
+
+	_, err := interp.Execute(context.Background(), `
+		CREATE TABLE #work (id INT)
+		BEGIN TRANSACTION
+			UPDATE [dbo].[Accounts] SET balance = balance - 10 WHERE id = 1
+			INSERT INTO #work VALUES (1)
+			SELECT balance FROM accounts
+			BEGIN TRANSACTION
+				DELETE FROM accounts WHERE id = 2
+			COMMIT
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dbo.accounts", "dbo.accounts"}; !reflect.DeepEqual(locker.locked, want) {
+		t.Errorf("locked %q, want %q", locker.locked, want)
+	}
+	if locker.released != 0 {
+		t.Errorf("inner COMMIT released the locks")
+	}
+
+	if _, err := interp.Execute(context.Background(), "COMMIT", nil); err != nil {
+		t.Fatal(err)
+	}
+	if locker.released != 1 || locker.held != 0 {
+		t.Errorf("COMMIT left the locks held")
+	}
+}
+
+func TestLocks_DeadlockVictim(t *testing.T) {
+	locker := &fakeLocker{deadlockOn: "dbo.accounts"}
+	interp := lockSetup(t, locker)
+
+	_, err := interp.Execute(context.Background(), `
+		BEGIN TRANSACTION
+		INSERT INTO audit_log VALUES (1)
+		UPDATE accounts SET balance = 0
+		COMMIT
+	`, nil)
+	if err == nil || WrapError(err).Number != ErrDeadlock {
+		t.Fatalf("got %v, want error %d", err, ErrDeadlock)
+	}
+	if locker.released != 1 || locker.held != 0 {
+		t.Errorf("the victim's transaction kept its locks")
+	}
+	if _, err := interp.Execute(context.Background(), "COMMIT", nil); err == nil {
+		t.Error("the victim's transaction was not rolled back")
+	}
+	result, err := interp.Execute(context.Background(), "SELECT COUNT(*) FROM audit_log", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lastRows(result), []string{"0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("audit_log rows = %q, want %q", got, want)
+	}
+}
+
+func TestLocks_DeadlockPriority(t *testing.T) {
+	locker := &fakeLocker{}
+	interp := lockSetup(t, locker)
+
+	for _, tt := range []struct {
+		sql  string
+		want int
+	}{
+		{"SET DEADLOCK_PRIORITY LOW", -5},
+		{"SET DEADLOCK_PRIORITY HIGH", 5},
+		{"SET DEADLOCK_PRIORITY -7", -7},
+		{"SET DEADLOCK_PRIORITY NORMAL", 0},
+	} {
+		if _, err := interp.Execute(context.Background(), tt.sql, nil); err != nil {
+			t.Fatalf("%s: %v", tt.sql, err)
+		}
+		if locker.priority != tt.want {
+			t.Errorf("%s: priority %d, want %d", tt.sql, locker.priority, tt.want)
+		}
+	}
+
+	if _, err := interp.Execute(context.Background(), "SET DEADLOCK_PRIORITY 11", nil); err == nil {
+		t.Error("SET DEADLOCK_PRIORITY 11 succeeded")
+	}
+}
+
+func TestLockResource(t *testing.T) {
+	tests := map[string]string{
+		"Accounts":         "dbo.accounts",
+		"[dbo].[Accounts]": "dbo.accounts",
+		"sales.Orders":     "sales.orders",
+	}
+	for table, want := range tests {
+		if got := lockResource(table); got != want {
+			t.Errorf("lockResource(%q) = %q, want %q", table, got, want)
+		}
+	}
+}
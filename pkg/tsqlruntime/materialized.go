@@ -0,0 +1,456 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// CREATE VIEW ... WITH SCHEMABINDING materializes the view on SQLite: its
+// rows are computed once into a table of the view's name, so queries
+// reading the view read the table, and its definition is kept in
+// MaterializedViewsTable. EXEC sp_refreshview recomputes the rows. A view
+// given a unique clustered index, which makes it an indexed view in SQL
+// Server, is also refreshed after every INSERT, UPDATE, DELETE, MERGE or
+// TRUNCATE TABLE the interpreter runs against a table its FROM clauses
+// read, in the same transaction; other views keep their rows until
+// refreshed. Writes made outside aul are not seen until a refresh.
+//
+// As SCHEMABINDING promises, the base tables of a view cannot be dropped
+// while it exists. Its own table cannot be written directly, as the next
+// refresh would lose the change; DROP VIEW drops it.
+//
+// Against SQL Server the statements are sent to the backend unchanged;
+// other dialects do not support them. Views without SCHEMABINDING are not
+// supported on any dialect.
+
+// MaterializedViewsTable holds the definitions of a database's
+// materialized views.
+const MaterializedViewsTable = "aul_materialized_views"
+
+// materializedView is a view whose rows are kept in a table.
+type materializedView struct {
+	name    string   // Of the view and its table, as the backend knows it
+	query   string   // Computing its rows, in the backend's dialect
+	tables  []string // Read by its FROM clauses, as pinnedKey keys
+	onWrite bool     // Refreshed after each write to one of tables
+}
+
+// schemaBound reports whether a view's options include SCHEMABINDING.
+func schemaBound(options []string) bool {
+	for _, opt := range options {
+		if strings.EqualFold(opt, "SCHEMABINDING") {
+			return true
+		}
+	}
+	return false
+}
+
+// executeCreateView runs CREATE VIEW ... WITH SCHEMABINDING.
+func (i *Interpreter) executeCreateView(ctx context.Context, s *ast.CreateViewStatement) error {
+	if !schemaBound(s.Options) {
+		return unsupportedStatementError(s)
+	}
+	switch i.ctx.Dialect {
+	case DialectSQLServer:
+		_, err := i.exec(ctx, s.String())
+		return err
+	case DialectSQLite:
+	default:
+		return fmt.Errorf("materialized views are only emulated on SQLite")
+	}
+
+	name := i.backendName(s.Name.String())
+	if i.userTable(ctx, name) {
+		return NewSQLError(ErrObjectExists, fmt.Sprintf("There is already an object named '%s' in the database.", name))
+	}
+	query, err := i.viewQuery(s)
+	if err != nil {
+		return err
+	}
+	schema := "dbo"
+	if parts := s.Name.Parts; len(parts) > 1 && parts[len(parts)-2].Value != "" {
+		schema = parts[len(parts)-2].Value
+	}
+
+	i.matViews = nil
+	err = i.viewExec(ctx, func(exec QueryExecutor) error {
+		if _, err := exec.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+MaterializedViewsTable+
+			" (view_name VARCHAR(128) PRIMARY KEY COLLATE NOCASE, schema_name VARCHAR(128), definition TEXT,"+
+			" query TEXT, base_tables TEXT, on_write INTEGER, refreshed_at DATETIME)"); err != nil {
+			return err
+		}
+		if _, err := exec.ExecContext(ctx, "CREATE TABLE "+quoteSQLiteName(name)+" AS "+query); err != nil {
+			return err
+		}
+		_, err := exec.ExecContext(ctx, "INSERT INTO "+MaterializedViewsTable+
+			" (view_name, schema_name, definition, query, base_tables, on_write, refreshed_at)"+
+			" VALUES (?, ?, ?, ?, ?, 0, CURRENT_TIMESTAMP)",
+			name, schema, s.String(), query, strings.Join(viewTables(s.AsSelect), ","))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("CREATE VIEW failed: %w", err)
+	}
+	return nil
+}
+
+// viewQuery translates the query of s for the backend, its columns
+// renamed after the view's column list if it has one.
+func (i *Interpreter) viewQuery(s *ast.CreateViewStatement) (string, error) {
+	// The query is run again on each refresh, so its literals stay in it
+	auto := i.AutoParameterize
+	i.AutoParameterize = false
+	defer func() { i.AutoParameterize = auto }()
+
+	var query string
+	var args []interface{}
+	var err error
+	switch q := s.AsSelect.(type) {
+	case *ast.SelectStatement:
+		query, args, err = i.buildSelectQuery(q)
+	case *ast.WithStatement:
+		query, args, err = i.buildWithQuery(q)
+	default:
+		return "", unsupportedStatementError(s.AsSelect)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(args) > 0 {
+		return "", NewSQLError(ErrNotSupported, "A view cannot reference variables or parameters.")
+	}
+
+	if len(s.Columns) > 0 {
+		names := make([]string, len(s.Columns))
+		for j, col := range s.Columns {
+			names[j] = quoteSQLiteName(col.Value)
+		}
+		query = "WITH aul_view (" + strings.Join(names, ", ") + ") AS (" + query + ") SELECT * FROM aul_view"
+	}
+	return query, nil
+}
+
+// viewTables returns the tables the FROM clauses of a view's query read,
+// leaving out its common table expressions.
+func viewTables(stmt ast.Statement) []string {
+	ctes := map[string]bool{}
+	var queries []*ast.SelectStatement
+	switch s := stmt.(type) {
+	case *ast.SelectStatement:
+		queries = append(queries, s)
+	case *ast.WithStatement:
+		for _, cte := range s.CTEs {
+			ctes[strings.ToLower(cte.Name.Value)] = true
+			queries = append(queries, cte.Query)
+		}
+		if sel, ok := s.Query.(*ast.SelectStatement); ok {
+			queries = append(queries, sel)
+		}
+	}
+
+	var tables []string
+	var addQuery func(*ast.SelectStatement)
+	var addRef func(ast.TableReference)
+	addQuery = func(s *ast.SelectStatement) {
+		if s == nil {
+			return
+		}
+		if s.From != nil {
+			for _, ref := range s.From.Tables {
+				addRef(ref)
+			}
+		}
+		if s.Union != nil {
+			addQuery(s.Union.Right)
+		}
+	}
+	addRef = func(ref ast.TableReference) {
+		switch t := ref.(type) {
+		case *ast.TableName:
+			if t.Name == nil {
+				return
+			}
+			if key := qualifiedKey(t.Name); !ctes[key] && !containsString(tables, key) {
+				tables = append(tables, key)
+			}
+		case *ast.JoinClause:
+			addRef(t.Left)
+			addRef(t.Right)
+		case *ast.ParenthesizedTableRef:
+			addRef(t.Inner)
+		case *ast.DerivedTable:
+			addQuery(t.Subquery)
+		}
+	}
+	for _, q := range queries {
+		addQuery(q)
+	}
+	return tables
+}
+
+// executeDropView runs DROP VIEW, dropping a materialized view's table
+// with its definition. Views created on the backend directly, such as by
+// a migration, are dropped there.
+func (i *Interpreter) executeDropView(ctx context.Context, s *ast.DropObjectStatement) error {
+	switch i.ctx.Dialect {
+	case DialectSQLServer:
+		_, err := i.exec(ctx, s.String())
+		return err
+	case DialectSQLite:
+	default:
+		return fmt.Errorf("materialized views are only emulated on SQLite")
+	}
+
+	views := i.materializedViews(ctx)
+	i.matViews = nil
+	for _, name := range s.Names {
+		v := views[qualifiedKey(name)]
+		if p := i.partitionedTable(ctx, name); p != nil {
+			// Its view reads its partitions (see partition.go)
+			return NewSQLError(ErrWrongDropType, fmt.Sprintf(
+				"Cannot use DROP VIEW with '%s' because '%s' is a table. Use DROP TABLE.", p.name, p.name))
+		}
+		if v == nil {
+			drop := "DROP VIEW "
+			if s.IfExists {
+				drop += "IF EXISTS "
+			}
+			if _, err := i.exec(ctx, drop+quoteSQLiteName(i.backendName(name.String()))); err != nil {
+				if strings.Contains(err.Error(), "no such view") {
+					return NewSQLError(ErrCannotDrop, fmt.Sprintf(
+						"Cannot drop the view '%s', because it does not exist or you do not have permission.", name.String()))
+				}
+				return fmt.Errorf("DROP VIEW failed: %w", err)
+			}
+			continue
+		}
+		if dependent := i.dependentView(views, qualifiedKey(name)); dependent != nil {
+			return NewSQLError(ErrObjectReferenced, fmt.Sprintf(
+				"Cannot DROP VIEW '%s' because it is being referenced by object '%s'.", v.name, dependent.name))
+		}
+		err := i.viewExec(ctx, func(exec QueryExecutor) error {
+			if _, err := exec.ExecContext(ctx, "DROP TABLE "+quoteSQLiteName(v.name)); err != nil {
+				return err
+			}
+			_, err := exec.ExecContext(ctx, "DELETE FROM "+MaterializedViewsTable+" WHERE view_name = ?", v.name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("DROP VIEW failed: %w", err)
+		}
+		delete(views, qualifiedKey(name))
+		if i.ctx.TableCache != nil {
+			i.cacheWrittenTable(pinnedKey(v.name))
+		}
+	}
+	return nil
+}
+
+// indexView makes the view a unique clustered index is created on, if it
+// is materialized, one that is refreshed on write.
+func (i *Interpreter) indexView(ctx context.Context, s *ast.CreateIndexStatement) error {
+	if !s.IsUnique || s.IsClustered == nil || !*s.IsClustered || s.Table == nil {
+		return nil
+	}
+	v := i.materializedViews(ctx)[qualifiedKey(s.Table)]
+	if v == nil || v.onWrite {
+		return nil
+	}
+	if _, err := i.exec(ctx, "UPDATE "+MaterializedViewsTable+" SET on_write = 1 WHERE view_name = ?", v.name); err != nil {
+		return fmt.Errorf("CREATE INDEX failed: %w", err)
+	}
+	v.onWrite = true
+	return nil
+}
+
+// materializedViews returns the database's materialized views by key
+// (see pinnedKey). They are read once per execution.
+func (i *Interpreter) materializedViews(ctx context.Context) map[string]*materializedView {
+	if i.matViews != nil {
+		return i.matViews
+	}
+	i.matViews = map[string]*materializedView{}
+	if i.ctx.Dialect != DialectSQLite {
+		return i.matViews
+	}
+	rows, err := i.ctx.GetExecutor().QueryContext(ctx,
+		"SELECT view_name, query, base_tables, on_write FROM "+MaterializedViewsTable)
+	if err != nil {
+		// No view is materialized
+		return i.matViews
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v := &materializedView{}
+		var tables string
+		if err := rows.Scan(&v.name, &v.query, &tables, &v.onWrite); err != nil {
+			continue
+		}
+		if tables != "" {
+			v.tables = strings.Split(tables, ",")
+		}
+		i.matViews[pinnedKey(v.name)] = v
+	}
+	return i.matViews
+}
+
+// dependentView returns a view of views that reads the table key, or nil.
+func (i *Interpreter) dependentView(views map[string]*materializedView, key string) *materializedView {
+	for _, name := range sortedViews(views) {
+		if containsString(views[name].tables, key) {
+			return views[name]
+		}
+	}
+	return nil
+}
+
+// sortedViews returns the keys of views in order, so that views are
+// always checked and refreshed in the same order.
+func sortedViews(views map[string]*materializedView) []string {
+	keys := make([]string, 0, len(views))
+	for key := range views {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkViewWrite refuses a statement that would break a materialized
+// view: a write to the view's table, or a DROP TABLE of the view or one
+// of its base tables.
+func (i *Interpreter) checkViewWrite(ctx context.Context, stmt ast.Statement) error {
+	kind, targets := statementWrite(stmt)
+	switch kind {
+	case "INSERT", "UPDATE", "DELETE", "MERGE", "TRUNCATE TABLE", "DROP TABLE":
+	default:
+		return nil
+	}
+	views := i.materializedViews(ctx)
+	if len(views) == 0 {
+		return nil
+	}
+	for _, target := range targets {
+		key := pinnedKey(target)
+		if v := views[key]; v != nil {
+			if kind == "DROP TABLE" {
+				return NewSQLError(ErrWrongDropType, fmt.Sprintf(
+					"Cannot use DROP TABLE with '%s' because '%s' is a view. Use DROP VIEW.", v.name, v.name))
+			}
+			return NewSQLError(ErrNotSupported, fmt.Sprintf(
+				"View '%s' is materialized and cannot be modified. Modify its base tables instead.", v.name))
+		}
+		if kind == "DROP TABLE" {
+			if v := i.dependentView(views, key); v != nil {
+				return NewSQLError(ErrObjectReferenced, fmt.Sprintf(
+					"Cannot DROP TABLE '%s' because it is being referenced by object '%s'.", target, v.name))
+			}
+		}
+	}
+	return nil
+}
+
+// refreshWrittenViews refreshes the views maintained on write that read a
+// table stmt wrote, then those reading the views refreshed.
+func (i *Interpreter) refreshWrittenViews(ctx context.Context, stmt ast.Statement) error {
+	kind, targets := statementWrite(stmt)
+	switch kind {
+	case "INSERT", "UPDATE", "DELETE", "MERGE", "TRUNCATE TABLE":
+	default:
+		return nil
+	}
+	if b := i.insertBatch; b != nil && b.stmt == stmt {
+		// The row is written with its batch; the loop refreshes the views
+		// when it ends
+		return nil
+	}
+	views := i.materializedViews(ctx)
+	if len(views) == 0 {
+		return nil
+	}
+
+	written := make([]string, len(targets))
+	for j, target := range targets {
+		written[j] = pinnedKey(target)
+	}
+	// A view cannot read itself, so refreshing ends
+	for len(written) > 0 {
+		key := written[0]
+		written = written[1:]
+		for _, name := range sortedViews(views) {
+			v := views[name]
+			if !v.onWrite || !containsString(v.tables, key) {
+				continue
+			}
+			if err := i.refreshView(ctx, v); err != nil {
+				return err
+			}
+			written = append(written, name)
+		}
+	}
+	return nil
+}
+
+// refreshView recomputes the rows of v.
+func (i *Interpreter) refreshView(ctx context.Context, v *materializedView) error {
+	table := quoteSQLiteName(v.name)
+	err := i.viewExec(ctx, func(exec QueryExecutor) error {
+		if _, err := exec.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return err
+		}
+		if _, err := exec.ExecContext(ctx, "INSERT INTO "+table+" "+v.query); err != nil {
+			return err
+		}
+		_, err := exec.ExecContext(ctx, "UPDATE "+MaterializedViewsTable+
+			" SET refreshed_at = CURRENT_TIMESTAMP WHERE view_name = ?", v.name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("refreshing view %s failed: %w", v.name, err)
+	}
+	if i.ctx.TableCache != nil {
+		i.cacheWrittenTable(pinnedKey(v.name))
+	}
+	return nil
+}
+
+// viewExec runs fn in the current transaction or one of its own, so that
+// a view's table and definition change together.
+func (i *Interpreter) viewExec(ctx context.Context, fn func(exec QueryExecutor) error) error {
+	if i.ctx.Tx != nil || i.ctx.DB == nil {
+		return fn(i.ctx.GetExecutor())
+	}
+	tx, err := i.ctx.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func init() {
+	systemProcedures["SP_REFRESHVIEW"] = systemProcedure{
+		params: []string{"@viewname"},
+		run:    (*Interpreter).spRefreshView,
+		writes: true,
+	}
+}
+
+// spRefreshView recomputes the rows of a materialized view. Views the
+// backend keeps itself need no refreshing.
+func (i *Interpreter) spRefreshView(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	name := argString(args, "@viewname")
+	if v := i.materializedViews(ctx)[pinnedKey(name)]; v != nil {
+		return i.refreshView(ctx, v)
+	}
+	if i.ctx.Dialect == DialectSQLite && name != "" && i.userTable(ctx, i.backendName(name)) {
+		return nil
+	}
+	return NewSQLError(ErrInvalidObject, fmt.Sprintf("Invalid object name '%s'.", name))
+}
@@ -0,0 +1,134 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// materializedSetup returns an interpreter over a database of orders,
+// with CustomerTotals summing them by customer.
+func materializedSetup(t *testing.T) *Interpreter {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE Orders (ID INTEGER PRIMARY KEY, CustomerID INTEGER, Total REAL); " +
+		"INSERT INTO Orders VALUES (1, 1, 10), (2, 1, 5), (3, 2, 7)"); err != nil {
+		t.Fatal(err)
+	}
+
+	interp := NewInterpreter(db, DialectSQLite)
+	if _, err := interp.Execute(context.Background(),
+		"CREATE VIEW dbo.CustomerTotals WITH SCHEMABINDING AS "+
+			"SELECT CustomerID, SUM(Total) AS Total, COUNT(*) AS Orders FROM dbo.Orders GROUP BY CustomerID", nil); err != nil {
+		t.Fatal(err)
+	}
+	return interp
+}
+
+// viewRows returns the rows of CustomerTotals.
+func viewRows(t *testing.T, interp *Interpreter) []string {
+	t.Helper()
+	return fullTextRows(t, interp, "SELECT CustomerID, Total, Orders FROM dbo.CustomerTotals ORDER BY CustomerID")
+}
+
+func TestMaterializedViewRefresh(t *testing.T) {
+	interp := materializedSetup(t)
+	ctx := context.Background()
+	exec := func(sql string) {
+		t.Helper()
+		if _, err := interp.Execute(ctx, sql, nil); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	if got, want := viewRows(t, interp), []string{"1 15 2", "2 7 1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("view = %v, want %v", got, want)
+	}
+
+	// Without an index the view keeps its rows until refreshed
+	exec("INSERT INTO Orders VALUES (4, 2, 3)")
+	if got, want := viewRows(t, interp), []string{"1 15 2", "2 7 1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("before refresh: view = %v, want %v", got, want)
+	}
+	exec("EXEC sp_refreshview 'dbo.CustomerTotals'")
+	if got, want := viewRows(t, interp), []string{"1 15 2", "2 10 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after refresh: view = %v, want %v", got, want)
+	}
+
+	// A unique clustered index makes it maintained on write
+	exec("CREATE UNIQUE CLUSTERED INDEX IX_CustomerTotals ON dbo.CustomerTotals (CustomerID)")
+	exec("UPDATE Orders SET Total = 20 WHERE ID = 1")
+	if got, want := viewRows(t, interp), []string{"1 25 2", "2 10 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after update: view = %v, want %v", got, want)
+	}
+
+	// Rows a loop inserts in batches are in the view once it ends
+	exec("DECLARE @n INT = 0; WHILE @n < 3 BEGIN SET @n = @n + 1; INSERT INTO Orders (CustomerID, Total) VALUES (3, @n) END")
+	if got, want := viewRows(t, interp), []string{"1 25 2", "2 10 2", "3 6 3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after loop: view = %v, want %v", got, want)
+	}
+
+	// The refresh is part of the write's transaction
+	exec("BEGIN TRANSACTION; DELETE FROM Orders WHERE CustomerID = 3; ROLLBACK")
+	if got, want := viewRows(t, interp), []string{"1 25 2", "2 10 2", "3 6 3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after rollback: view = %v, want %v", got, want)
+	}
+
+	exec("TRUNCATE TABLE Orders")
+	if got := viewRows(t, interp); len(got) != 0 {
+		t.Errorf("after truncate: view = %v, want no rows", got)
+	}
+}
+
+func TestMaterializedViewDDL(t *testing.T) {
+	interp := materializedSetup(t)
+	ctx := context.Background()
+	exec := func(sql string) {
+		t.Helper()
+		if _, err := interp.Execute(ctx, sql, nil); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	// Column lists and CTEs, and views reading views, refreshed in turn
+	exec("CREATE VIEW BigCustomers (ID, Amount) WITH SCHEMABINDING AS " +
+		"WITH t AS (SELECT CustomerID, Total FROM dbo.CustomerTotals) SELECT CustomerID, Total FROM t WHERE Total > 10")
+	if got, want := fullTextRows(t, interp, "SELECT ID, Amount FROM BigCustomers"), []string{"1 15"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BigCustomers = %v, want %v", got, want)
+	}
+	exec("CREATE UNIQUE CLUSTERED INDEX IX_CustomerTotals ON dbo.CustomerTotals (CustomerID); " +
+		"CREATE UNIQUE CLUSTERED INDEX IX_BigCustomers ON BigCustomers (ID)")
+	exec("INSERT INTO Orders VALUES (4, 2, 30)")
+	if got, want := fullTextRows(t, interp, "SELECT ID, Amount FROM BigCustomers ORDER BY ID"), []string{"1 15", "2 37"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BigCustomers after insert = %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		sql    string
+		number int
+	}{
+		{"DROP TABLE Orders", ErrObjectReferenced},
+		{"DROP TABLE CustomerTotals", ErrWrongDropType},
+		{"DROP VIEW CustomerTotals", ErrObjectReferenced},
+		{"DELETE FROM CustomerTotals", ErrNotSupported},
+		{"CREATE VIEW CustomerTotals WITH SCHEMABINDING AS SELECT ID FROM Orders", ErrObjectExists},
+		{"CREATE VIEW OrderIDs AS SELECT ID FROM Orders", ErrNotSupported},
+		{"DROP VIEW Missing", ErrCannotDrop},
+		{"EXEC sp_refreshview 'Missing'", ErrInvalidObject},
+	} {
+		_, err := interp.Execute(ctx, tc.sql, nil)
+		wantSQLError(t, tc.sql, err, tc.number)
+	}
+
+	exec("DROP VIEW IF EXISTS Missing")
+	exec("DROP VIEW BigCustomers, dbo.CustomerTotals")
+	exec("DROP TABLE Orders")
+	if got := fullTextRows(t, interp, "SELECT COUNT(*) FROM aul_materialized_views"); !reflect.DeepEqual(got, []string{"0"}) {
+		t.Errorf("definitions left after DROP VIEW: %v", got)
+	}
+
+	if diags, err := CheckCompatibility("CREATE VIEW v WITH SCHEMABINDING AS SELECT ID FROM dbo.Orders; DROP VIEW v"); err != nil || len(diags) != 0 {
+		t.Errorf("schema-bound views: got %+v, %v", diags, err)
+	}
+}
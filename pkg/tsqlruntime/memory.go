@@ -0,0 +1,50 @@
+package tsqlruntime
+
+// MemoryBudget accounts for memory held by an execution: buffered result
+// sets, temp table and table variable rows, and cursor snapshots.
+//
+// Reserve is called before the memory is retained and fails when the
+// budget is exhausted; the statement then fails with that error instead of
+// growing further. Release returns memory that is no longer held.
+type MemoryBudget interface {
+	Reserve(bytes int64) error
+	Release(bytes int64)
+}
+
+// Approximate per-item overheads used by RowSize. They track the Go
+// representation, not the wire size, since it is the process footprint
+// that the budget protects.
+const (
+	valueOverhead = 144 // sizeof(Value)
+	rowOverhead   = 24  // slice header
+)
+
+// RowSize estimates the memory held by a buffered row.
+func RowSize(row []Value) int64 {
+	n := int64(rowOverhead)
+	for _, v := range row {
+		n += valueOverhead + int64(len(v.stringVal)) + int64(len(v.bytesVal))
+	}
+	return n
+}
+
+// reserveRow charges a buffered row to the execution's memory budget.
+func (ec *ExecutionContext) reserveRow(row []Value) error {
+	if ec.Memory == nil {
+		return nil
+	}
+	return ec.Memory.Reserve(RowSize(row))
+}
+
+// releaseBytes returns memory charged by reserveRow.
+func (ec *ExecutionContext) releaseBytes(n int64) {
+	if ec.Memory != nil && n > 0 {
+		ec.Memory.Release(n)
+	}
+}
+
+// SetMemoryBudget sets the budget charged for rows this execution buffers.
+func (i *Interpreter) SetMemoryBudget(budget MemoryBudget) {
+	i.ctx.Memory = budget
+	i.ctx.TempTables.SetMemoryBudget(budget)
+}
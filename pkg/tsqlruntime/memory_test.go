@@ -0,0 +1,106 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errBudget = errors.New("budget exhausted")
+
+// testBudget is a MemoryBudget with a fixed limit.
+type testBudget struct {
+	limit int64
+	used  int64
+}
+
+func (b *testBudget) Reserve(n int64) error {
+	if b.limit > 0 && b.used+n > b.limit {
+		return errBudget
+	}
+	b.used += n
+	return nil
+}
+
+func (b *testBudget) Release(n int64) {
+	b.used -= n
+}
+
+func TestMemoryBudget_ResultSet(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE words (w TEXT); INSERT INTO words VALUES ('abc'), ('defg')"); err != nil {
+		t.Fatal(err)
+	}
+
+	budget := &testBudget{}
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetMemoryBudget(budget)
+
+	if _, err := interp.Execute(context.Background(), "SELECT w FROM words", nil); err != nil {
+		t.Fatal(err)
+	}
+	want := RowSize([]Value{NewVarChar("abc", -1)}) + RowSize([]Value{NewVarChar("defg", -1)})
+	if budget.used != want {
+		t.Errorf("used = %d, want %d", budget.used, want)
+	}
+}
+
+func TestMemoryBudget_AbortsLargeResultSet(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	budget := &testBudget{limit: 64 << 10}
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetMemoryBudget(budget)
+
+	_, err := interp.Execute(context.Background(), `
+		WITH n AS (SELECT 1 AS i UNION ALL SELECT i + 1 FROM n WHERE i < 100000)
+		SELECT i FROM n`, nil)
+	if !errors.Is(err, errBudget) {
+		t.Fatalf("err = %v, want budget error", err)
+	}
+	if budget.used > budget.limit {
+		t.Errorf("used %d exceeds limit %d", budget.used, budget.limit)
+	}
+}
+
+func TestMemoryBudget_TempTable(t *testing.T) {
+	budget := &testBudget{}
+	m := NewTempTableManager()
+	m.SetMemoryBudget(budget)
+
+	table, err := m.CreateTempTable("#t", []TempTableColumn{{Name: "v", Type: TypeVarChar, Nullable: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a", "bb", "ccc"} {
+		if _, err := table.InsertRow([]Value{NewVarChar(s, -1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total := budget.used
+
+	table.Delete(func(row []Value) bool { return row[0].AsString() == "bb" })
+	if want := total - RowSize([]Value{NewVarChar("bb", -1)}); budget.used != want {
+		t.Errorf("used after delete = %d, want %d", budget.used, want)
+	}
+
+	if err := m.DropTempTable("#t"); err != nil {
+		t.Fatal(err)
+	}
+	if budget.used != 0 {
+		t.Errorf("used after drop = %d, want 0", budget.used)
+	}
+
+	// Inserts beyond the budget fail and leave the table unchanged
+	budget.limit = 1
+	tv, _ := m.CreateTableVariable("@t", []TempTableColumn{{Name: "v", Type: TypeVarChar, Nullable: true}})
+	if _, err := tv.InsertRow([]Value{NewVarChar("x", -1)}); !errors.Is(err, errBudget) {
+		t.Fatalf("err = %v, want budget error", err)
+	}
+	if tv.RowCount() != 0 {
+		t.Errorf("row count = %d, want 0", tv.RowCount())
+	}
+}
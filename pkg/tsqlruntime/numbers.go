@@ -0,0 +1,297 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// Procedures written for SQL Server often assume a table of numbers, to
+// split strings and make series of dates: a dbo.Numbers or dbo.Tally
+// created once, or a tally made on the fly from system tables:
+//
+//	SELECT TOP (@n) ROW_NUMBER() OVER (ORDER BY (SELECT NULL)) AS n
+//	FROM sys.all_columns a CROSS JOIN sys.all_columns b
+//
+//	SELECT number FROM master..spt_values WHERE type = 'P'
+//
+// SQLite has none of these. A database without its own Numbers or Tally
+// table reads NumbersTable in their place, as dbo.Numbers (Number) and
+// dbo.Tally (N): the integers from 1 to the configured size, written on
+// first use and kept, so that lookups by number use its primary key. A
+// query numbering rows of nothing but system tables for its TOP reads a
+// series of just that many rows instead, and spt_values reads the
+// numbers 0 to 2047, its type P. Other dialects are left alone.
+
+// NumbersTable holds the rows of the built-in numbers tables on SQLite.
+const NumbersTable = "aul_numbers"
+
+// DefaultNumbersSize is how many rows the built-in numbers tables have
+// unless configured otherwise.
+const DefaultNumbersSize = 100000
+
+// numbersColumns maps the built-in numbers tables to their one column.
+var numbersColumns = map[string]string{
+	"numbers": "Number",
+	"tally":   "N",
+}
+
+// tallySources are the system tables a tally numbers the rows of.
+var tallySources = map[string]bool{
+	"all_columns":    true,
+	"all_objects":    true,
+	"columns":        true,
+	"objects":        true,
+	"system_columns": true,
+	"system_objects": true,
+	"syscolumns":     true,
+	"sysobjects":     true,
+	"spt_values":     true,
+}
+
+// SetNumbersSize sets how many rows the built-in numbers tables have. A
+// size that is not positive restores DefaultNumbersSize.
+func (i *Interpreter) SetNumbersSize(size int) {
+	i.ctx.NumbersSize = size
+}
+
+// numbersSize returns how many rows the built-in numbers tables have.
+func (ec *ExecutionContext) numbersSize() int {
+	if ec.NumbersSize <= 0 {
+		return DefaultNumbersSize
+	}
+	return ec.NumbersSize
+}
+
+// numbersSources replaces the numbers tables and tallies s reads from
+// with what SQLite can run, for the dialect rewriter.
+func (i *Interpreter) numbersSources(s *ast.SelectStatement) {
+	if isTally(s) {
+		ref := firstTableName(s.From.Tables[0])
+		tvf := &ast.TableValuedFunction{Token: ref.Token, Alias: ref.Alias}
+		s.From.Tables = []ast.TableReference{recursiveSeries(tvf, []string{"1", s.Top.Count.String(), "1"}, "")}
+		return
+	}
+	for k, ref := range s.From.Tables {
+		s.From.Tables[k] = i.numbersRef(ref)
+	}
+}
+
+// numbersRef returns ref with the numbers tables it reads replaced.
+func (i *Interpreter) numbersRef(ref ast.TableReference) ast.TableReference {
+	switch t := ref.(type) {
+	case *ast.TableName:
+		if src := i.numbersTable(t); src != nil {
+			return src
+		}
+	case *ast.JoinClause:
+		t.Left = i.numbersRef(t.Left)
+		t.Right = i.numbersRef(t.Right)
+	case *ast.ParenthesizedTableRef:
+		t.Inner = i.numbersRef(t.Inner)
+	}
+	return ref
+}
+
+// numbersTable returns what reads the built-in table t names, or nil if
+// t is not one or the database has its own.
+func (i *Interpreter) numbersTable(t *ast.TableName) ast.TableReference {
+	if t.Name == nil {
+		return nil
+	}
+	parts := t.Name.Parts
+	name := parts[len(parts)-1].Value
+	column, numbers := numbersColumns[strings.ToLower(name)]
+	spt := strings.EqualFold(name, "spt_values")
+	switch {
+	case !numbers && !spt:
+		return nil
+	case numbers && len(parts) > 1 && parts[len(parts)-2].Value != "" && !strings.EqualFold(parts[len(parts)-2].Value, "dbo"):
+		// Only the dbo schema's
+		return nil
+	}
+
+	ctx := context.Background()
+	if i.userTable(ctx, name) {
+		return nil
+	}
+	var sql string
+	if spt {
+		sql = "WITH RECURSIVE aul_spt_values(number) AS (SELECT 0 UNION ALL " +
+			"SELECT number + 1 FROM aul_spt_values WHERE number < 2047) " +
+			"SELECT number, 'P' AS type, NULL AS name, NULL AS low, NULL AS high, 0 AS status FROM aul_spt_values"
+	} else {
+		size := i.ctx.numbersSize()
+		if err := i.ensureNumbers(ctx, size); err != nil {
+			// The backend reports the table missing
+			return nil
+		}
+		sql = fmt.Sprintf("SELECT n AS %s FROM %s WHERE n <= %d", column, NumbersTable, size)
+	}
+	return derivedTable(t.Token, t.Alias, sql, name)
+}
+
+// userTable reports whether the database has a table or view called name.
+func (i *Interpreter) userTable(ctx context.Context, name string) bool {
+	var found int
+	err := i.ctx.GetExecutor().QueryRowContext(ctx,
+		"SELECT 1 FROM sqlite_master WHERE type IN ('table', 'view') AND name = ? COLLATE NOCASE", name).Scan(&found)
+	return err == nil
+}
+
+// ensureNumbers creates NumbersTable if it is missing and fills it up to
+// size. Rows already there are kept, so sessions may race to fill it.
+func (i *Interpreter) ensureNumbers(ctx context.Context, size int) error {
+	db := i.ctx.GetExecutor()
+	var have int
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(n), 0) FROM "+NumbersTable).Scan(&have); err != nil {
+		if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+NumbersTable+" (n INTEGER PRIMARY KEY)"); err != nil {
+			return err
+		}
+		have = 0
+	}
+	if have >= size {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, "WITH RECURSIVE aul_fill(n) AS (SELECT ? UNION ALL SELECT n + 1 FROM aul_fill WHERE n < ?) "+
+		"INSERT OR IGNORE INTO "+NumbersTable+" (n) SELECT n FROM aul_fill", have+1, size)
+	return err
+}
+
+// IsTallyQuery reports whether sql is a query, or a WITH statement with a
+// common table expression, that numbers rows of nothing but system tables
+// (see isTally). Such a query reads no catalog, for the interpreter to
+// run rather than the storage layer's system catalog.
+func IsTallyQuery(sql string) bool {
+	p := parser.New(lexer.New(sql))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 || len(program.Statements) != 1 {
+		return false
+	}
+	switch s := program.Statements[0].(type) {
+	case *ast.SelectStatement:
+		return isTally(s)
+	case *ast.WithStatement:
+		for _, cte := range s.CTEs {
+			if cte.Query != nil && isTally(cte.Query) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTally reports whether s numbers the rows of system tables for its
+// TOP and reads nothing else from them, such as
+//
+//	SELECT TOP (@n) ROW_NUMBER() OVER (ORDER BY (SELECT NULL)) FROM sys.all_columns a, sys.all_columns b
+func isTally(s *ast.SelectStatement) bool {
+	if s.Top == nil || s.Top.Count == nil || s.Top.Percent || s.Top.WithTies ||
+		s.Where != nil || len(s.GroupBy) > 0 || s.Having != nil || s.From == nil || len(s.From.Tables) == 0 {
+		return false
+	}
+	for _, ref := range s.From.Tables {
+		if !tallyRef(ref) {
+			return false
+		}
+	}
+	numbered := false
+	for _, col := range s.Columns {
+		if col.AllColumns || col.Variable != nil {
+			return false
+		}
+		if !tallyExpr(col.Expression, &numbered) {
+			return false
+		}
+	}
+	return numbered
+}
+
+// tallyRef reports whether ref reads nothing but system tables, joined
+// without conditions.
+func tallyRef(ref ast.TableReference) bool {
+	switch t := ref.(type) {
+	case *ast.TableName:
+		if t.Name == nil {
+			return false
+		}
+		parts := t.Name.Parts
+		name := strings.ToLower(parts[len(parts)-1].Value)
+		if !tallySources[name] {
+			return false
+		}
+		switch name {
+		case "syscolumns", "sysobjects", "spt_values":
+			return true
+		}
+		return len(parts) > 1 && isCatalogSchema(parts[len(parts)-2].Value)
+	case *ast.JoinClause:
+		return t.Condition == nil && tallyRef(t.Left) && tallyRef(t.Right)
+	case *ast.ParenthesizedTableRef:
+		return tallyRef(t.Inner)
+	}
+	return false
+}
+
+// firstTableName returns the first table ref reads.
+func firstTableName(ref ast.TableReference) *ast.TableName {
+	switch t := ref.(type) {
+	case *ast.JoinClause:
+		return firstTableName(t.Left)
+	case *ast.ParenthesizedTableRef:
+		return firstTableName(t.Inner)
+	}
+	return ref.(*ast.TableName)
+}
+
+// tallyExpr reports whether e is computed from constants and the row's
+// number alone, noting in numbered whether it uses ROW_NUMBER().
+func tallyExpr(e ast.Expression, numbered *bool) bool {
+	switch e := e.(type) {
+	case *ast.FunctionCall:
+		if e.Over == nil || !strings.EqualFold(e.Function.String(), "ROW_NUMBER") ||
+			len(e.Over.PartitionBy) > 0 || e.Over.WindowRef != "" {
+			return false
+		}
+		for _, ob := range e.Over.OrderBy {
+			if !constantExpr(ob.Expression) {
+				return false
+			}
+		}
+		*numbered = true
+		return true
+	case *ast.InfixExpression:
+		return tallyExpr(e.Left, numbered) && tallyExpr(e.Right, numbered)
+	case *ast.PrefixExpression:
+		return tallyExpr(e.Right, numbered)
+	case *ast.CastExpression:
+		return tallyExpr(e.Expression, numbered)
+	}
+	return constantExpr(e)
+}
+
+// constantExpr reports whether e reads no column: a literal, a variable,
+// or a query of them without FROM, as in ORDER BY (SELECT NULL).
+func constantExpr(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.NullLiteral, *ast.Variable:
+		return true
+	case *ast.PrefixExpression:
+		return constantExpr(e.Right)
+	case *ast.SubqueryExpression:
+		if e.Subquery == nil || e.Subquery.From != nil {
+			return false
+		}
+		for _, col := range e.Subquery.Columns {
+			if col.AllColumns || !constantExpr(col.Expression) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestNumbers(t *testing.T) {
+	ctx := context.Background()
+	interp := scopeSetup(t, newMockResolver())
+	interp.SetNumbersSize(500)
+
+	for _, tt := range []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"numbers", "SELECT COUNT(*), MIN(Number), MAX(Number) FROM dbo.Numbers", []string{"500 1 500"}},
+		{"tally", "SELECT t.N FROM Tally t WHERE t.N <= 3 ORDER BY t.N", []string{"1", "2", "3"}},
+		{"split", "DECLARE @s VARCHAR(100) = 'a,bb,ccc'; " +
+			"SELECT SUBSTRING(@s, Number, CHARINDEX(',', @s + ',', Number) - Number) FROM dbo.Numbers " +
+			"WHERE Number <= LEN(@s) AND SUBSTRING(',' + @s, Number, 1) = ',' ORDER BY Number",
+			[]string{"a", "bb", "ccc"}},
+		{"dates", "SELECT COUNT(*) FROM dbo.Numbers n WHERE n.Number <= 3 AND DATEADD(day, n.Number - 1, '2024-02-28') >= '2024-03-01'",
+			[]string{"1"}},
+		{"system tally", "DECLARE @n INT = 4; SELECT TOP (@n) ROW_NUMBER() OVER (ORDER BY (SELECT NULL)) AS n " +
+			"FROM sys.all_columns a CROSS JOIN sys.all_columns b", []string{"1", "2", "3", "4"}},
+		{"tally cte", "WITH t AS (SELECT TOP 3 ROW_NUMBER() OVER (ORDER BY (SELECT 1)) - 1 AS n " +
+			"FROM master.sys.all_columns a, master.sys.all_columns b) SELECT n FROM t", []string{"0", "1", "2"}},
+		{"spt_values", "SELECT COUNT(*), MIN(number), MAX(number) FROM master..spt_values WHERE type = 'P'",
+			[]string{"2048 0 2047"}},
+	} {
+		result, err := interp.Execute(ctx, tt.sql, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := lastRows(result); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A larger size extends the table
+	interp.SetNumbersSize(800)
+	result, err := interp.Execute(ctx, "SELECT MAX(Number) FROM Numbers", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"800"}) {
+		t.Errorf("resized: %q, want [800]", got)
+	}
+
+	// The database's own table is read rather than the built-in one
+	for _, sql := range []string{
+		"CREATE TABLE Tally (N INT PRIMARY KEY)",
+		"INSERT INTO Tally VALUES (7)",
+	} {
+		if _, err := interp.Execute(ctx, sql, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err = interp.Execute(ctx, "SELECT N FROM dbo.Tally", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"7"}) {
+		t.Errorf("own table: %q, want [7]", got)
+	}
+}
+
+func TestIsTallyQuery(t *testing.T) {
+	for sql, want := range map[string]bool{
+		"SELECT TOP (100) ROW_NUMBER() OVER (ORDER BY (SELECT NULL)) FROM sys.all_columns a CROSS JOIN sys.all_columns b": true,
+		"WITH t(n) AS (SELECT TOP 10 ROW_NUMBER() OVER (ORDER BY (SELECT 0)) FROM sys.objects) SELECT n FROM t":           true,
+		// These read the catalog
+		"SELECT TOP 10 name FROM sys.all_columns":                                                        false,
+		"SELECT TOP 10 ROW_NUMBER() OVER (ORDER BY name) FROM sys.all_objects":                           false,
+		"SELECT TOP 10 ROW_NUMBER() OVER (ORDER BY (SELECT NULL)) FROM sys.all_objects WHERE type = 'U'": false,
+		"SELECT ROW_NUMBER() OVER (ORDER BY (SELECT NULL)) FROM sys.all_columns":                         false,
+		"SELECT TOP 10 ROW_NUMBER() OVER (ORDER BY (SELECT NULL)) FROM dbo.orders":                       false,
+	} {
+		if got := IsTallyQuery(sql); got != want {
+			t.Errorf("IsTallyQuery(%q) = %v, want %v", sql, got, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/token"
+)
+
+// An EXEC's arguments are bound to the procedure's parameters as SQL
+// Server binds them. They are evaluated in the caller's scope, left to
+// right, before the procedure starts. Arguments passed by position come
+// first and take the parameters in the order they are declared; once one
+// is passed by name, as @name = value, the rest must be. A parameter left
+// out, or passed as DEFAULT, takes its default, evaluated in the
+// procedure's scope as it starts, in declaration order, so
+// @d DATETIME = GETDATE() is the time of the call. A parameter with no
+// default must be passed, unless it is OUTPUT, which then starts NULL.
+// NULL is a value like any other: passing it does not take the default.
+//
+// A host running a procedure directly, over the HTTP API or an RPC, sets
+// its parameters by name; those it leaves out take their defaults, or
+// NULL.
+
+// SQL Server's numbers for the errors binding arguments reports.
+const (
+	errParamNotSupplied = 201
+	errNamedParamsOnly  = 119
+	errTooManyArguments = 8144
+	errNotAParameter    = 8145
+	errParamTwice       = 8143
+)
+
+// procedureArgument is one argument of an EXEC of a procedure.
+type procedureArgument struct {
+	name      string // Parameter named, with its @; "" when passed by position
+	value     Value
+	isDefault bool   // Passed as DEFAULT
+	output    string // Caller's variable an OUTPUT argument writes back to
+	param     string // Parameter bound to, once bound
+}
+
+// evaluateArguments evaluates the arguments of an EXEC of procName.
+func (i *Interpreter) evaluateArguments(procName string, params []*ast.ExecParameter) ([]*procedureArgument, error) {
+	args := make([]*procedureArgument, 0, len(params))
+	named := false
+	for n, p := range params {
+		arg := &procedureArgument{name: p.Name}
+		if p.Name == "" && named {
+			return nil, NewSQLError(errNamedParamsOnly, fmt.Sprintf(
+				"Must pass parameter number %d and subsequent parameters as '@name = value'. "+
+					"After the form '@name = value' has been used, all subsequent parameters must be passed in the form '@name = value'.",
+				n+1))
+		}
+		named = named || p.Name != ""
+
+		if isDefaultArgument(p.Value) {
+			arg.isDefault = true
+		} else {
+			val, err := i.evaluator.Evaluate(p.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate parameter %s of procedure %s: %w", argumentName(p.Name, n), procName, err)
+			}
+			arg.value = val
+			if p.Output {
+				arg.output = outputTarget(p.Value)
+			}
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// isDefaultArgument reports whether an argument is the keyword DEFAULT.
+func isDefaultArgument(expr ast.Expression) bool {
+	id, ok := expr.(*ast.Identifier)
+	return ok && id.Token.Type == token.DEFAULT_KW && id.Token.Quote == 0
+}
+
+// argumentName names the argument at position n for errors.
+func argumentName(name string, n int) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprintf("number %d", n+1)
+}
+
+// bindArguments binds args to the parameters of procName, setting each
+// parameter, or its default, in the procedure's scope.
+func (i *Interpreter) bindArguments(procName string, params []*ast.ParameterDef, args []*procedureArgument) error {
+	byName := make(map[string]int, len(params))
+	for n, param := range params {
+		byName[parameterKey(param.Name)] = n
+	}
+
+	bound := make([]*procedureArgument, len(params))
+	for n, arg := range args {
+		p := n
+		if arg.name != "" {
+			var ok bool
+			if p, ok = byName[parameterKey(arg.name)]; !ok {
+				return NewSQLError(errNotAParameter, fmt.Sprintf(
+					"%s is not a parameter for procedure %s.", arg.name, procName))
+			}
+			if bound[p] != nil {
+				return NewSQLError(errParamTwice, fmt.Sprintf(
+					"Procedure or function %s has parameter %s specified more than once.", procName, arg.name))
+			}
+		} else if p >= len(params) {
+			return NewSQLError(errTooManyArguments, fmt.Sprintf(
+				"Procedure or function %s has too many arguments specified.", procName))
+		}
+		arg.param = "@" + parameterKey(params[p].Name)
+		bound[p] = arg
+	}
+
+	for n, param := range params {
+		if arg := bound[n]; arg != nil && !arg.isDefault {
+			i.assign(param.Name, arg.value)
+			continue
+		}
+		if err := i.setParameterDefault(procName, param, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setParameterDefault sets param, which was not passed, to its default.
+// Without one it is NULL if it is OUTPUT or not required, and otherwise
+// an error.
+func (i *Interpreter) setParameterDefault(procName string, param *ast.ParameterDef, required bool) error {
+	if param.Default != nil {
+		val, err := i.evaluator.Evaluate(param.Default)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate default for parameter %s: %w", param.Name, err)
+		}
+		i.assign(param.Name, val)
+		return nil
+	}
+	if required && !param.Output {
+		return NewSQLError(errParamNotSupplied, fmt.Sprintf(
+			"Procedure or function '%s' expects parameter '%s', which was not supplied.", procName, param.Name))
+	}
+	dt := TypeUnknown
+	if param.DataType != nil {
+		dt, _, _, _ = ParseDataType(param.DataType.Name)
+	}
+	i.assign(param.Name, Null(dt))
+	return nil
+}
+
+// parameterKey returns a parameter's name as variables are keyed: lower
+// case, without its @.
+func parameterKey(name string) string {
+	return strings.TrimPrefix(strings.ToLower(name), "@")
+}
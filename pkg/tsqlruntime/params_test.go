@@ -0,0 +1,102 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// paramsSetup returns an interpreter with dbo.Show, which selects its
+// parameters as strings, "null" for NULL.
+func paramsSetup(t *testing.T) *Interpreter {
+	t.Helper()
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.Show", `
+		CREATE PROCEDURE dbo.Show
+			@a INT,
+			@b VARCHAR(10) = 'b',
+			@c INT = NULL,
+			@d DATETIME = GETDATE(),
+			@out INT OUTPUT
+		AS
+		BEGIN
+			SET @out = @a * 2
+			SELECT ISNULL(CAST(@a AS VARCHAR(10)), 'null'), ISNULL(@b, 'null'),
+				ISNULL(CAST(@c AS VARCHAR(10)), 'null'),
+				CASE WHEN @d IS NULL THEN 'null' ELSE 'now' END
+		END
+	`, nil)
+	return scopeSetup(t, resolver)
+}
+
+func TestProcedureArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		exec string
+		want string
+	}{
+		{"defaults", "EXEC dbo.Show 1", "1 b null now"},
+		{"positional", "EXEC dbo.Show 1, 'x', 3", "1 x 3 now"},
+		{"named", "EXEC dbo.Show @c = 3, @A = 1", "1 b 3 now"},
+		{"positional then named", "EXEC dbo.Show 1, @c = 3", "1 b 3 now"},
+		{"DEFAULT by position", "EXEC dbo.Show 1, DEFAULT, 3", "1 b 3 now"},
+		{"DEFAULT by name", "EXEC dbo.Show @a = 1, @b = DEFAULT", "1 b null now"},
+		{"NULL is not left out", "EXEC dbo.Show 1, NULL, NULL, NULL", "1 null null null"},
+		{"NULL required", "EXEC dbo.Show NULL", "null b null now"},
+		{"OUTPUT left out", "EXEC dbo.Show @a = 1", "1 b null now"},
+		{"OUTPUT passed", "DECLARE @o INT EXEC dbo.Show 4, @out = @o OUTPUT SELECT CAST(@o AS VARCHAR(10)), '', '', ''", "8   "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interp := paramsSetup(t)
+			result, err := interp.Execute(context.Background(), tt.exec, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := lastRows(result); !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcedureArgumentErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		exec   string
+		number int
+	}{
+		{"required left out", "EXEC dbo.Show @b = 'x'", errParamNotSupplied},
+		{"required as DEFAULT", "EXEC dbo.Show DEFAULT", errParamNotSupplied},
+		{"positional after named", "EXEC dbo.Show @a = 1, 'x'", errNamedParamsOnly},
+		{"too many", "EXEC dbo.Show 1, 'x', 3, NULL, NULL, 6", errTooManyArguments},
+		{"unknown name", "EXEC dbo.Show 1, @e = 5", errNotAParameter},
+		{"twice", "EXEC dbo.Show 1, @a = 2", errParamTwice},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interp := paramsSetup(t)
+			_, err := interp.Execute(context.Background(), tt.exec, nil)
+			var sqlErr *SQLError
+			if !errors.As(err, &sqlErr) || sqlErr.Number != tt.number {
+				t.Errorf("got %v, want error %d", err, tt.number)
+			}
+		})
+	}
+}
+
+func TestProcedureArgumentsFromHost(t *testing.T) {
+	interp := paramsSetup(t)
+	result, err := interp.Execute(context.Background(), `
+		CREATE PROCEDURE dbo.Show @a INT, @b VARCHAR(10) = 'b', @c INT = 5
+		AS
+		SELECT ISNULL(CAST(@a AS VARCHAR(10)), 'null'), ISNULL(@b, 'null'), CAST(@c AS VARCHAR(10))
+	`, map[string]interface{}{"@b": nil, "@c": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lastRows(result), []string{"null null 7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,661 @@
+package tsqlruntime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/token"
+)
+
+// Table partitioning is emulated on SQLite. CREATE PARTITION FUNCTION and
+// CREATE PARTITION SCHEME are kept in PartitionFunctionsTable and
+// PartitionSchemesTable, and a table created ON a scheme is made of one
+// table per partition (see PartitionTable), each indexed on the
+// partitioning column, under a view of the table's name reading them all
+// with UNION ALL. Queries read the view, so they see every partition.
+//
+// INSERT writes the first partition, which assigns identity values, and
+// the rows that belong to others are then moved to them; UPDATE and
+// DELETE run against each partition in turn, and an UPDATE that sets the
+// partitioning column moves the rows it changed. Each statement and its
+// moves make one transaction. @@ROWCOUNT and SCOPE_IDENTITY() read as
+// they would for a table. UPDATE and DELETE must name the table as their
+// target, not an alias of it in a FROM clause; TOP is not supported.
+// TRUNCATE TABLE, CREATE INDEX and DROP TABLE apply to every partition.
+// Unique indexes and keys hold within a partition, so, as SQL Server
+// requires of aligned indexes, they should include the partitioning
+// column.
+//
+// Against SQL Server the statements are sent to the backend unchanged;
+// other dialects do not support them. ALTER PARTITION FUNCTION is not
+// supported on any dialect.
+
+const (
+	// PartitionFunctionsTable holds the partition functions of a database.
+	PartitionFunctionsTable = "aul_partition_functions"
+	// PartitionSchemesTable holds the partition schemes of a database.
+	PartitionSchemesTable = "aul_partition_schemes"
+	// PartitionedTablesTable holds the tables created on a partition
+	// scheme, with the column they are partitioned by.
+	PartitionedTablesTable = "aul_partitioned_tables"
+)
+
+// PartitionTable returns the name of the table holding partition n,
+// counting from 1, of the partitioned table named table.
+func PartitionTable(table string, n int) string {
+	return fmt.Sprintf("aul_partition_%d_%s", n, table)
+}
+
+// partitionedTable is a table made of one table per partition.
+type partitionedTable struct {
+	name   string   // Of the table's view, as the backend knows it
+	column string   // Partitioning column
+	right  bool     // RANGE RIGHT: boundary values belong to the partition on their right
+	bounds []string // Boundary values in ascending order, as SQL literals
+}
+
+// partitions returns the number of partitions of p.
+func (p *partitionedTable) partitions() int {
+	return len(p.bounds) + 1
+}
+
+// table returns the quoted name of the table of partition n.
+func (p *partitionedTable) table(n int) string {
+	return quoteSQLiteName(PartitionTable(p.name, n))
+}
+
+// condition returns the condition the rows of partition n meet. NULL
+// sorts first, so it belongs to the first partition; the condition is
+// never NULL, so that NOT condition holds for the rows of the others.
+func (p *partitionedTable) condition(n int) string {
+	column := quoteSQLiteName(p.column)
+	above, below := " > ", " <= "
+	if p.right {
+		above, below = " >= ", " < "
+	}
+	var conds []string
+	if n > 1 {
+		conds = append(conds, column+" IS NOT NULL", column+above+p.bounds[n-2])
+	}
+	if n <= len(p.bounds) {
+		conds = append(conds, column+below+p.bounds[n-1])
+	}
+	switch {
+	case n == 1 && len(conds) == 0:
+		return "1"
+	case n == 1:
+		return "(" + column + " IS NULL OR " + conds[0] + ")"
+	}
+	return "(" + strings.Join(conds, " AND ") + ")"
+}
+
+// moves returns the statements moving the rows of partition from that
+// belong to other partitions to them.
+func (p *partitionedTable) moves(from int) []string {
+	if len(p.bounds) == 0 {
+		return nil
+	}
+	var stmts []string
+	for n := 1; n <= p.partitions(); n++ {
+		if n != from {
+			stmts = append(stmts, "INSERT INTO "+p.table(n)+" SELECT * FROM "+p.table(from)+" WHERE "+p.condition(n))
+		}
+	}
+	return append(stmts, "DELETE FROM "+p.table(from)+" WHERE NOT "+p.condition(from))
+}
+
+// partitionClause returns the scheme and column of a CREATE TABLE ... ON
+// scheme(column), which the parser keeps as the table's filegroup.
+func partitionClause(s *ast.CreateTableStatement) (scheme, column string, ok bool) {
+	open := strings.IndexByte(s.FileGroup, '(')
+	if open < 0 || !strings.HasSuffix(s.FileGroup, ")") {
+		return "", "", false
+	}
+	trim := func(name string) string { return strings.Trim(strings.TrimSpace(name), `[]"`) }
+	return trim(s.FileGroup[:open]), trim(s.FileGroup[open+1 : len(s.FileGroup)-1]), true
+}
+
+// partitionObjectName returns the name of a partition function or scheme
+// a DROP statement names.
+func partitionObjectName(name *ast.QualifiedIdentifier) string {
+	if name == nil || len(name.Parts) == 0 {
+		return ""
+	}
+	return name.Parts[len(name.Parts)-1].Value
+}
+
+// createPartitionCatalog creates the tables partitioning is kept in.
+func createPartitionCatalog(ctx context.Context, exec QueryExecutor) error {
+	for _, ddl := range []string{
+		"CREATE TABLE IF NOT EXISTS " + PartitionFunctionsTable +
+			" (function_name VARCHAR(128) PRIMARY KEY COLLATE NOCASE, input_type VARCHAR(128), range_right INTEGER," +
+			" boundary_values TEXT, definition TEXT, created_at DATETIME)",
+		"CREATE TABLE IF NOT EXISTS " + PartitionSchemesTable +
+			" (scheme_name VARCHAR(128) PRIMARY KEY COLLATE NOCASE, function_name VARCHAR(128) COLLATE NOCASE," +
+			" definition TEXT, created_at DATETIME)",
+		"CREATE TABLE IF NOT EXISTS " + PartitionedTablesTable +
+			" (table_name VARCHAR(128) PRIMARY KEY COLLATE NOCASE, scheme_name VARCHAR(128) COLLATE NOCASE," +
+			" column_name VARCHAR(128), partitions INTEGER)",
+	} {
+		if _, err := exec.ExecContext(ctx, ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partitionCatalogName returns the name a partition function or scheme is
+// kept under in table, keyed by column, or "" if there is none.
+func (i *Interpreter) partitionCatalogName(ctx context.Context, table, column, name string) string {
+	var found string
+	err := i.ctx.GetExecutor().QueryRowContext(ctx,
+		"SELECT "+column+" FROM "+table+" WHERE "+column+" = ?", name).Scan(&found)
+	if err != nil {
+		return ""
+	}
+	return found
+}
+
+// executeCreatePartitionFunction runs CREATE PARTITION FUNCTION.
+func (i *Interpreter) executeCreatePartitionFunction(ctx context.Context, s *ast.CreatePartitionFunctionStatement) error {
+	switch i.ctx.Dialect {
+	case DialectSQLServer:
+		_, err := i.exec(ctx, s.String())
+		return err
+	case DialectSQLite:
+	default:
+		return fmt.Errorf("table partitioning is only emulated on SQLite")
+	}
+
+	if i.partitionCatalogName(ctx, PartitionFunctionsTable, "function_name", s.Name) != "" {
+		return NewSQLError(ErrObjectExists, fmt.Sprintf("There is already an object named '%s' in the database.", s.Name))
+	}
+	bounds, err := i.partitionBounds(s)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(bounds)
+	if err != nil {
+		return err
+	}
+	inputType := ""
+	if s.InputType != nil {
+		inputType = s.InputType.String()
+	}
+
+	err = i.viewExec(ctx, func(exec QueryExecutor) error {
+		if err := createPartitionCatalog(ctx, exec); err != nil {
+			return err
+		}
+		_, err := exec.ExecContext(ctx, "INSERT INTO "+PartitionFunctionsTable+
+			" (function_name, input_type, range_right, boundary_values, definition, created_at)"+
+			" VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)",
+			s.Name, inputType, strings.EqualFold(s.RangeType, "RIGHT"), string(encoded), s.String())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("CREATE PARTITION FUNCTION failed: %w", err)
+	}
+	return nil
+}
+
+// partitionBounds evaluates the boundary values of s, returning them in
+// ascending order as SQL literals.
+func (i *Interpreter) partitionBounds(s *ast.CreatePartitionFunctionStatement) ([]string, error) {
+	dt, precision, scale := TypeVarChar, 0, 0
+	if s.InputType != nil {
+		dt, precision, scale, _ = ParseDataType(s.InputType.String())
+	}
+	if precision == 0 {
+		precision = 38
+	}
+	type bound struct {
+		value   Value // As the input type, to order them
+		literal string
+	}
+	bounds := make([]bound, 0, len(s.BoundaryValues))
+	for _, expr := range s.BoundaryValues {
+		v, err := i.evaluator.Evaluate(expr)
+		if err != nil {
+			return nil, err
+		}
+		if v.IsNull {
+			return nil, NewSQLError(ErrNotSupported, "A partition function cannot have NULL as a boundary value.")
+		}
+		typed, err := Cast(v, dt, precision, scale, -1)
+		if err != nil {
+			return nil, err
+		}
+		// Compared with the column as written, as the rows' values are
+		literal := "'" + strings.ReplaceAll(v.AsString(), "'", "''") + "'"
+		if dt.IsNumeric() {
+			literal = typed.AsString()
+		}
+		bounds = append(bounds, bound{typed, literal})
+	}
+	sort.SliceStable(bounds, func(a, b int) bool { return bounds[a].value.Compare(bounds[b].value) < 0 })
+
+	literals := make([]string, len(bounds))
+	for n, b := range bounds {
+		if n > 0 && b.value.Compare(bounds[n-1].value) == 0 {
+			return nil, NewSQLError(ErrPartitionBoundary, fmt.Sprintf(
+				"Duplicate range boundary values are not allowed in partition function boundary values list. "+
+					"The boundary value being added is already present at ordinal %d of the boundary value list.", n))
+		}
+		literals[n] = b.literal
+	}
+	return literals, nil
+}
+
+// executeCreatePartitionScheme runs CREATE PARTITION SCHEME. All the
+// partitions of the emulation are in the database's file, so the
+// filegroups named are not kept.
+func (i *Interpreter) executeCreatePartitionScheme(ctx context.Context, s *ast.CreatePartitionSchemeStatement) error {
+	switch i.ctx.Dialect {
+	case DialectSQLServer:
+		_, err := i.exec(ctx, s.String())
+		return err
+	case DialectSQLite:
+	default:
+		return fmt.Errorf("table partitioning is only emulated on SQLite")
+	}
+
+	if i.partitionCatalogName(ctx, PartitionSchemesTable, "scheme_name", s.Name) != "" {
+		return NewSQLError(ErrObjectExists, fmt.Sprintf("There is already an object named '%s' in the database.", s.Name))
+	}
+	function := i.partitionCatalogName(ctx, PartitionFunctionsTable, "function_name", s.FunctionName)
+	if function == "" {
+		return NewSQLError(ErrInvalidObject, fmt.Sprintf("Invalid partition function name '%s'.", s.FunctionName))
+	}
+
+	err := i.viewExec(ctx, func(exec QueryExecutor) error {
+		_, err := exec.ExecContext(ctx, "INSERT INTO "+PartitionSchemesTable+
+			" (scheme_name, function_name, definition, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+			s.Name, function, s.String())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("CREATE PARTITION SCHEME failed: %w", err)
+	}
+	return nil
+}
+
+// executeDropPartitionObject runs DROP PARTITION FUNCTION and DROP
+// PARTITION SCHEME, which fail while a scheme or table uses the object.
+func (i *Interpreter) executeDropPartitionObject(ctx context.Context, s *ast.DropObjectStatement) error {
+	switch i.ctx.Dialect {
+	case DialectSQLServer:
+		_, err := i.exec(ctx, s.String())
+		return err
+	case DialectSQLite:
+	default:
+		return fmt.Errorf("table partitioning is only emulated on SQLite")
+	}
+
+	// The catalog table of the object, and of the objects using it
+	kind, table, column := "function", PartitionFunctionsTable, "function_name"
+	usedBy, inUse := PartitionSchemesTable, "The partition function \"%s\" is being used by one or more partition schemes."
+	if s.ObjectType == "PARTITION SCHEME" {
+		kind, table, column = "scheme", PartitionSchemesTable, "scheme_name"
+		usedBy, inUse = PartitionedTablesTable, "The partition scheme \"%s\" is currently being used to partition one or more tables."
+	}
+	for _, qualified := range s.Names {
+		name := i.partitionCatalogName(ctx, table, column, partitionObjectName(qualified))
+		if name == "" {
+			if s.IfExists {
+				continue
+			}
+			return NewSQLError(ErrCannotDrop, fmt.Sprintf(
+				"Cannot drop the partition %s '%s', because it does not exist or you do not have permission.",
+				kind, partitionObjectName(qualified)))
+		}
+		if i.partitionCatalogName(ctx, usedBy, column, name) != "" {
+			return NewSQLError(ErrPartitionInUse, fmt.Sprintf(inUse, name))
+		}
+		if _, err := i.exec(ctx, "DELETE FROM "+table+" WHERE "+column+" = ?", name); err != nil {
+			return fmt.Errorf("DROP PARTITION %s failed: %w", strings.ToUpper(kind), err)
+		}
+	}
+	return nil
+}
+
+// executeCreatePartitionedTable runs CREATE TABLE ... ON scheme(column),
+// creating the table of each partition and the view reading them.
+func (i *Interpreter) executeCreatePartitionedTable(ctx context.Context, s *ast.CreateTableStatement, scheme, column string) error {
+	var partitions int
+	err := i.ctx.GetExecutor().QueryRowContext(ctx, "SELECT s.scheme_name, json_array_length(f.boundary_values) + 1"+
+		" FROM "+PartitionSchemesTable+" s JOIN "+PartitionFunctionsTable+" f ON f.function_name = s.function_name"+
+		" WHERE s.scheme_name = ?", scheme).Scan(&scheme, &partitions)
+	if err != nil {
+		return NewSQLError(ErrPartitionScheme, fmt.Sprintf("Invalid partition scheme '%s' specified.", scheme))
+	}
+
+	var identity []*ast.Identifier
+	found := false
+	for _, col := range s.Columns {
+		if strings.EqualFold(col.Name.Value, column) {
+			column, found = col.Name.Value, true
+		}
+		if col.Identity != nil {
+			identity = append(identity, col.Name)
+		}
+	}
+	if !found {
+		return NewSQLError(ErrInvalidColumn, fmt.Sprintf("Invalid column name '%s'.", column))
+	}
+	name := i.backendName(s.Name.String())
+	if i.userTable(ctx, name) {
+		return NewSQLError(ErrObjectExists, fmt.Sprintf("There is already an object named '%s' in the database.", name))
+	}
+
+	p := &partitionedTable{name: name, column: column}
+	i.partitioned = nil
+	err = i.viewExec(ctx, func(exec QueryExecutor) error {
+		reads := make([]string, partitions)
+		for n := 1; n <= partitions; n++ {
+			table := *s
+			table.Name = &ast.QualifiedIdentifier{Parts: []*ast.Identifier{
+				{Token: token.Token{Quote: '"'}, Value: PartitionTable(name, n)}}}
+			table.FileGroup = ""
+			ddl := i.ddl.generateSQLiteCreateTable(&table)
+			if n == 1 {
+				// Rows move out of the partition identity values are
+				// assigned in, so none may be assigned again
+				for _, col := range identity {
+					column := i.ddl.backendIdentifier(col) + " INTEGER PRIMARY KEY"
+					ddl = strings.Replace(ddl, column, column+" AUTOINCREMENT", 1)
+				}
+			}
+			if _, err := exec.ExecContext(ctx, ddl); err != nil {
+				return err
+			}
+			if _, err := exec.ExecContext(ctx, "CREATE INDEX "+quoteSQLiteName(PartitionTable(name, n)+"_"+column)+
+				" ON "+p.table(n)+" ("+quoteSQLiteName(column)+")"); err != nil {
+				return err
+			}
+			reads[n-1] = "SELECT * FROM " + p.table(n)
+		}
+		if _, err := exec.ExecContext(ctx, "CREATE VIEW "+quoteSQLiteName(name)+" AS "+strings.Join(reads, " UNION ALL ")); err != nil {
+			return err
+		}
+		_, err := exec.ExecContext(ctx, "INSERT INTO "+PartitionedTablesTable+
+			" (table_name, scheme_name, column_name, partitions) VALUES (?, ?, ?, ?)",
+			name, scheme, column, partitions)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("CREATE TABLE failed: %w", err)
+	}
+	return nil
+}
+
+// partitionedTables returns the database's partitioned tables by key
+// (see pinnedKey). They are read once per execution.
+func (i *Interpreter) partitionedTables(ctx context.Context) map[string]*partitionedTable {
+	if i.partitioned != nil {
+		return i.partitioned
+	}
+	i.partitioned = map[string]*partitionedTable{}
+	if i.ctx.Dialect != DialectSQLite {
+		return i.partitioned
+	}
+	rows, err := i.ctx.GetExecutor().QueryContext(ctx, "SELECT t.table_name, t.column_name, f.range_right, f.boundary_values"+
+		" FROM "+PartitionedTablesTable+" t JOIN "+PartitionSchemesTable+" s ON s.scheme_name = t.scheme_name"+
+		" JOIN "+PartitionFunctionsTable+" f ON f.function_name = s.function_name")
+	if err != nil {
+		// No table is partitioned
+		return i.partitioned
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := &partitionedTable{}
+		var bounds string
+		if err := rows.Scan(&p.name, &p.column, &p.right, &bounds); err != nil {
+			continue
+		}
+		if err := json.Unmarshal([]byte(bounds), &p.bounds); err != nil {
+			continue
+		}
+		i.partitioned[pinnedKey(p.name)] = p
+	}
+	return i.partitioned
+}
+
+// partitionedTable returns the partitioned table name names, or nil.
+func (i *Interpreter) partitionedTable(ctx context.Context, name *ast.QualifiedIdentifier) *partitionedTable {
+	if name == nil || i.ctx.Dialect != DialectSQLite {
+		return nil
+	}
+	tables := i.partitionedTables(ctx)
+	if len(tables) == 0 {
+		return nil
+	}
+	return tables[qualifiedKey(name)]
+}
+
+// partitionExec runs fn, which writes a partitioned table through the
+// session's executor, in the current transaction or one of its own, so
+// that a statement and the moves of the rows it wrote are made together.
+func (i *Interpreter) partitionExec(ctx context.Context, fn func() error) error {
+	if i.ctx.Tx != nil || i.ctx.DB == nil {
+		return fn()
+	}
+	tx, err := i.ctx.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	i.ctx.Tx = tx
+	err = fn()
+	i.ctx.Tx = nil
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// execMoves runs the moves of rows out of partition from.
+func (i *Interpreter) execMoves(ctx context.Context, p *partitionedTable, from int) error {
+	for _, move := range p.moves(from) {
+		if _, err := i.exec(ctx, move); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertPartitioned runs an INSERT into a partitioned table: into its
+// first partition, from which the rows are then moved.
+func (i *Interpreter) insertPartitioned(ctx context.Context, s *ast.InsertStatement, p *partitionedTable) error {
+	ins := *s
+	ins.Table = &ast.QualifiedIdentifier{Parts: []*ast.Identifier{
+		{Token: token.Token{Quote: '"'}, Value: PartitionTable(p.name, 1)}}}
+	return i.partitionExec(ctx, func() error {
+		var err error
+		if ins.Exec != nil {
+			err = i.executeInsertExec(ctx, &ins)
+		} else {
+			err = i.executeInsertRow(ctx, &ins)
+		}
+		if err != nil {
+			return err
+		}
+		return i.execMoves(ctx, p, 1)
+	})
+}
+
+// updatePartitioned runs an UPDATE of a partitioned table against each of
+// its partitions, moving the rows it changed if it sets the partitioning
+// column.
+func (i *Interpreter) updatePartitioned(ctx context.Context, s *ast.UpdateStatement, p *partitionedTable) error {
+	query, args, err := i.buildUpdateQuery(s)
+	if err != nil {
+		return err
+	}
+	moves := false
+	for _, set := range s.SetClauses {
+		if set.Column != nil && len(set.Column.Parts) > 0 &&
+			strings.EqualFold(set.Column.Parts[len(set.Column.Parts)-1].Value, p.column) {
+			moves = true
+		}
+	}
+	return i.writePartitions(ctx, s, p, "UPDATE ", query, args, moves)
+}
+
+// deletePartitioned runs a DELETE from a partitioned table against each
+// of its partitions.
+func (i *Interpreter) deletePartitioned(ctx context.Context, s *ast.DeleteStatement, p *partitionedTable) error {
+	if s.Top != nil {
+		// Each partition would delete up to n rows
+		return NewSQLError(ErrNotSupported, fmt.Sprintf(
+			"This form of DELETE is not supported on partitioned table '%s'.", p.name))
+	}
+	query, args, err := i.buildDeleteQuery(s)
+	if err != nil {
+		return err
+	}
+	return i.writePartitions(ctx, s, p, "DELETE FROM ", query, args, false)
+}
+
+// writePartitions runs query, built for the view of p and starting with
+// verb and the view's name, against the table of each partition under the
+// view's name, so that the rest of the query reads as it did. @@ROWCOUNT
+// is the number of rows written in all of them.
+func (i *Interpreter) writePartitions(ctx context.Context, stmt ast.Statement, p *partitionedTable, verb, query string, args []interface{}, moves bool) error {
+	target, rest, ok := partitionTarget(query, verb)
+	if !ok {
+		return NewSQLError(ErrNotSupported, fmt.Sprintf(
+			"This form of %s is not supported on partitioned table '%s'.", strings.TrimSpace(verb), p.name))
+	}
+	if i.LogRewritten && i.LogFunc != nil {
+		i.LogFunc("REWRITTEN query=%s args=%v", query, args)
+	}
+	var total int64
+	err := i.partitionExec(ctx, func() error {
+		for n := 1; n <= p.partitions(); n++ {
+			res, err := i.ctx.GetExecutor().ExecContext(ctx, verb+p.table(n)+" AS "+target+rest, args...)
+			if err != nil {
+				return fmt.Errorf("%s error: %w", strings.ToLower(strings.Fields(verb)[0]), err)
+			}
+			rows, _ := res.RowsAffected()
+			total += rows
+		}
+		if moves {
+			for n := 1; n <= p.partitions(); n++ {
+				if err := i.execMoves(ctx, p, n); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	i.ctx.UpdateRowCount(total)
+	return nil
+}
+
+// partitionTarget splits query after verb into the name of the table it
+// writes, unqualified, and the rest of it.
+func partitionTarget(query, verb string) (target, rest string, ok bool) {
+	if !strings.HasPrefix(query, verb) {
+		return "", "", false
+	}
+	query = query[len(verb):]
+	start, end, quoted := 0, 0, false
+	for ; end < len(query); end++ {
+		c := query[end]
+		if c == '"' {
+			quoted = !quoted
+		} else if !quoted && c == '.' {
+			start = end + 1
+		} else if !quoted && c == ' ' {
+			break
+		}
+	}
+	if start == end {
+		return "", "", false
+	}
+	return query[start:end], query[end:], true
+}
+
+// truncatePartitioned runs TRUNCATE TABLE on a partitioned table,
+// emptying each partition and restarting its identity values.
+func (i *Interpreter) truncatePartitioned(ctx context.Context, p *partitionedTable) error {
+	return i.partitionExec(ctx, func() error {
+		for n := 1; n <= p.partitions(); n++ {
+			if _, err := i.exec(ctx, "DELETE FROM "+p.table(n)); err != nil {
+				return err
+			}
+		}
+		if !i.userTable(ctx, "sqlite_sequence") {
+			return nil
+		}
+		_, err := i.exec(ctx, "DELETE FROM sqlite_sequence WHERE name = ?", PartitionTable(p.name, 1))
+		return err
+	})
+}
+
+// dropPartitioned drops the partitioned tables s names, returning the
+// statement dropping the others, or nil if there are none.
+func (i *Interpreter) dropPartitioned(ctx context.Context, s *ast.DropTableStatement) (*ast.DropTableStatement, error) {
+	if i.ctx.Dialect != DialectSQLite {
+		return s, nil
+	}
+	var others []*ast.QualifiedIdentifier
+	for _, name := range s.Tables {
+		p := i.partitionedTable(ctx, name)
+		if p == nil {
+			others = append(others, name)
+			continue
+		}
+		err := i.viewExec(ctx, func(exec QueryExecutor) error {
+			if _, err := exec.ExecContext(ctx, "DROP VIEW "+quoteSQLiteName(p.name)); err != nil {
+				return err
+			}
+			for n := 1; n <= p.partitions(); n++ {
+				if _, err := exec.ExecContext(ctx, "DROP TABLE "+p.table(n)); err != nil {
+					return err
+				}
+			}
+			_, err := exec.ExecContext(ctx, "DELETE FROM "+PartitionedTablesTable+" WHERE table_name = ?", p.name)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("DROP TABLE failed: %w", err)
+		}
+		delete(i.partitioned, qualifiedKey(name))
+	}
+	if len(others) == 0 {
+		return nil, nil
+	}
+	drop := *s
+	drop.Tables = others
+	return &drop, nil
+}
+
+// indexPartitions runs CREATE INDEX on a partitioned table, creating the
+// index on each partition.
+func (i *Interpreter) indexPartitions(ctx context.Context, s *ast.CreateIndexStatement, p *partitionedTable) error {
+	err := i.viewExec(ctx, func(exec QueryExecutor) error {
+		for n := 1; n <= p.partitions(); n++ {
+			index := *s
+			index.Table = &ast.QualifiedIdentifier{Parts: []*ast.Identifier{
+				{Token: token.Token{Quote: '"'}, Value: PartitionTable(p.name, n)}}}
+			index.Name = &ast.Identifier{Token: token.Token{Quote: '"'}, Value: PartitionTable(s.Name.Value, n)}
+			if _, err := exec.ExecContext(ctx, i.ddl.generateSQLiteCreateIndex(&index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("CREATE INDEX failed: %w", err)
+	}
+	return nil
+}
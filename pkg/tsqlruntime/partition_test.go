@@ -0,0 +1,134 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// partitionSetup returns an interpreter over a database with Orders
+// partitioned by date in three: before 2024, the first half of 2024, and
+// after.
+func partitionSetup(t *testing.T) *Interpreter {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+
+	interp := NewInterpreter(db, DialectSQLite)
+	if _, err := interp.Execute(context.Background(),
+		"CREATE PARTITION FUNCTION pfOrderDate (DATE) AS RANGE RIGHT FOR VALUES ('2024-07-01', '2024-01-01'); "+
+			"CREATE PARTITION SCHEME psOrderDate AS PARTITION pfOrderDate ALL TO ([PRIMARY]); "+
+			"CREATE TABLE dbo.Orders (ID INT IDENTITY(1,1) NOT NULL, OrderDate DATE NOT NULL, Total DECIMAL(10,2)) "+
+			"ON psOrderDate(OrderDate)", nil); err != nil {
+		t.Fatal(err)
+	}
+	return interp
+}
+
+// partitionRows returns the number of rows in each partition of Orders.
+func partitionRows(t *testing.T, interp *Interpreter) []string {
+	t.Helper()
+	var rows []string
+	for n := 1; n <= 3; n++ {
+		rows = append(rows, fullTextRows(t, interp, "SELECT COUNT(*) FROM "+PartitionTable("Orders", n))...)
+	}
+	return rows
+}
+
+func TestPartitionedTableDML(t *testing.T) {
+	interp := partitionSetup(t)
+	ctx := context.Background()
+	exec := func(sql string) {
+		t.Helper()
+		if _, err := interp.Execute(ctx, sql, nil); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	// Rows go to the partition their date falls in; a boundary belongs to
+	// the partition to its right
+	exec("INSERT INTO Orders (OrderDate, Total) VALUES ('2023-05-01', 10), ('2024-01-01', 20), ('2024-09-01', 30)")
+	if got, want := partitionRows(t, interp), []string{"1", "1", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after insert: partitions = %v, want %v", got, want)
+	}
+	if got, want := fullTextRows(t, interp, "SELECT ID, Total FROM Orders ORDER BY ID"), []string{"1 10", "2 20", "3 30"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Orders = %v, want %v", got, want)
+	}
+
+	// Changing the date moves the row
+	exec("UPDATE Orders SET OrderDate = '2024-08-01' WHERE ID = 2")
+	if got, want := partitionRows(t, interp), []string{"1", "0", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after update: partitions = %v, want %v", got, want)
+	}
+	exec("UPDATE dbo.Orders SET Total = Total + 1 WHERE Orders.OrderDate > '2024-01-01'")
+	if got, want := fullTextRows(t, interp, "SELECT ID, Total FROM Orders ORDER BY ID"), []string{"1 10", "2 21", "3 31"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after update: Orders = %v, want %v", got, want)
+	}
+
+	// Writes are atomic across partitions
+	exec("BEGIN TRANSACTION; DELETE FROM Orders WHERE Total > 20; ROLLBACK")
+	if got, want := partitionRows(t, interp), []string{"1", "0", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after rollback: partitions = %v, want %v", got, want)
+	}
+	exec("DELETE FROM Orders WHERE Total > 20")
+	if got, want := partitionRows(t, interp), []string{"1", "0", "0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after delete: partitions = %v, want %v", got, want)
+	}
+
+	// Identity values are not reused, until the table is truncated
+	exec("INSERT INTO Orders (OrderDate, Total) VALUES ('2024-02-01', 40)")
+	if got, want := fullTextRows(t, interp, "SELECT ID FROM Orders WHERE Total = 40"), []string{"4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("identity = %v, want %v", got, want)
+	}
+	exec("TRUNCATE TABLE Orders")
+	exec("INSERT INTO Orders (OrderDate, Total) VALUES ('2024-02-01', 50)")
+	if got, want := fullTextRows(t, interp, "SELECT ID FROM Orders"), []string{"1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("identity after truncate = %v, want %v", got, want)
+	}
+}
+
+func TestPartitionDDL(t *testing.T) {
+	interp := partitionSetup(t)
+	ctx := context.Background()
+	exec := func(sql string) {
+		t.Helper()
+		if _, err := interp.Execute(ctx, sql, nil); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	exec("CREATE INDEX IX_Orders_Total ON Orders (Total)")
+	if got, want := fullTextRows(t, interp, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE '%IX_Orders_Total'"), []string{"3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("indexes = %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		sql    string
+		number int
+	}{
+		{"CREATE PARTITION FUNCTION pfOrderDate (INT) AS RANGE LEFT FOR VALUES (1)", ErrObjectExists},
+		{"CREATE PARTITION FUNCTION pfDup (INT) AS RANGE LEFT FOR VALUES (1, 2, 1)", ErrPartitionBoundary},
+		{"CREATE PARTITION SCHEME psMissing AS PARTITION pfMissing ALL TO ([PRIMARY])", ErrInvalidObject},
+		{"CREATE TABLE Lines (ID INT, OrderDate DATE) ON psMissing(OrderDate)", ErrPartitionScheme},
+		{"CREATE TABLE Lines (ID INT, OrderDate DATE) ON psOrderDate(ShipDate)", ErrInvalidColumn},
+		{"DROP PARTITION SCHEME psOrderDate", ErrPartitionInUse},
+		{"DROP PARTITION FUNCTION pfOrderDate", ErrPartitionInUse},
+		{"DROP PARTITION FUNCTION pfMissing", ErrCannotDrop},
+		{"DROP VIEW Orders", ErrWrongDropType},
+	} {
+		_, err := interp.Execute(ctx, tc.sql, nil)
+		wantSQLError(t, tc.sql, err, tc.number)
+	}
+
+	exec("DROP TABLE Orders")
+	exec("DROP PARTITION SCHEME psOrderDate")
+	exec("DROP PARTITION FUNCTION pfOrderDate")
+	if got := fullTextRows(t, interp, "SELECT COUNT(*) FROM sqlite_master WHERE name LIKE '%Orders%'"); !reflect.DeepEqual(got, []string{"0"}) {
+		t.Errorf("tables left after DROP TABLE: %v", got)
+	}
+
+	if diags, err := CheckCompatibility("CREATE PARTITION FUNCTION pf (INT) AS RANGE LEFT FOR VALUES (10); " +
+		"CREATE PARTITION SCHEME ps AS PARTITION pf ALL TO ([PRIMARY]); DROP PARTITION SCHEME ps; DROP PARTITION FUNCTION pf"); err != nil || len(diags) != 0 {
+		t.Errorf("partitioning: got %+v, %v", diags, err)
+	}
+}
@@ -0,0 +1,483 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+	"github.com/ha1tch/aul/pkg/tsqlparser/token"
+)
+
+// A session whose permissions are checked may run a statement of its own
+// only if it holds the permissions the statement takes on the objects it
+// names: SELECT on the tables and views it reads, INSERT, UPDATE or
+// DELETE on those it writes, and EXECUTE on the procedures it calls.
+// Otherwise the statement fails with error 229, as in SQL Server. DDL
+// fails with error 262, and GRANT, DENY, REVOKE and the role statements
+// with error 15247, unless the session's user is an administrator. A
+// statement naming one of aul's own tables, named with ReservedPrefix,
+// fails with error 229 whatever the session is granted: logins, roles and
+// permissions are kept in such tables. One naming CredentialTable fails so
+// for every session, an administrator's or one whose permissions are not
+// checked, so that no client reads or replaces a password hash.
+//
+// The statements of a procedure the session may EXECUTE are not checked,
+// as SQL Server's ownership chaining leaves those of a procedure owned,
+// like its tables, by dbo: granting EXECUTE on a procedure grants what it
+// does, and no more. Dynamic SQL breaks the chain, so that the statements
+// of EXEC() and sp_executesql are checked, even in a procedure.
+//
+// Without a permission model every statement runs, and GRANT, DENY,
+// REVOKE and the role statements change nothing.
+
+// Permissions decides what the session's user may do, and keeps the
+// permissions GRANT, DENY and REVOKE change.
+type Permissions interface {
+	// Administrator reports whether the user may do anything
+	Administrator() bool
+	// Allowed reports whether the user holds permission (SELECT, INSERT,
+	// UPDATE, DELETE or EXECUTE) on object, a schema-qualified name
+	Allowed(permission, object string) bool
+	// Apply grants, denies or revokes permissions, through db, the
+	// session's transaction if it has one
+	Apply(ctx context.Context, db QueryExecutor, change PermissionChange) error
+	// ChangeRole creates or drops a role, or adds or drops its member,
+	// through db
+	ChangeRole(ctx context.Context, db QueryExecutor, change RoleChange) error
+}
+
+// PermissionChange is a GRANT, DENY or REVOKE.
+type PermissionChange struct {
+	State       string   // GRANT, DENY or REVOKE
+	Permissions []string // SELECT, INSERT, UPDATE, DELETE or EXECUTE
+	Scope       string   // OBJECT, SCHEMA or DATABASE
+	Name        string   // The object, as schema.name, or the schema; "" for the database
+	Principals  []string // Logins and roles
+}
+
+// RoleChange is a CREATE ROLE, DROP ROLE, ALTER ROLE or ALTER SERVER
+// ROLE.
+type RoleChange struct {
+	Action string // CREATE, DROP, ADD MEMBER or DROP MEMBER
+	Role   string
+	Member string // The login or role added or dropped
+	Server bool   // Of a server role, which only ADD MEMBER and DROP MEMBER change
+}
+
+// grantablePermissions are the permissions GRANT, DENY and REVOKE take,
+// and those ALL stands for.
+var grantablePermissions = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "EXECUTE"}
+
+// SetPermissions sets the permission model the execution's statements are
+// checked against.
+func (i *Interpreter) SetPermissions(p Permissions) {
+	i.ctx.Permissions = p
+}
+
+// SetOwnershipChain sets whether the interpreter runs a procedure the
+// session was allowed to EXECUTE, whose statements are not checked.
+func (i *Interpreter) SetOwnershipChain(chained bool) {
+	i.ownerChain = chained
+}
+
+// restricted reports whether the statements i runs are checked against
+// the session's permissions.
+func (i *Interpreter) restricted() bool {
+	p := i.ctx.Permissions
+	return p != nil && !i.ownerChain && !p.Administrator()
+}
+
+// CheckPermission returns error 229 unless the session may take
+// permission on object, or runs unchecked. No grant lets it take one on
+// aul's own tables.
+func (i *Interpreter) CheckPermission(permission, object string) error {
+	if !i.restricted() {
+		return nil
+	}
+	if reservedObject(object) || !i.ctx.Permissions.Allowed(permission, object) {
+		return PermissionDenied(permission, object, i.database)
+	}
+	return nil
+}
+
+// PermissionDenied returns error 229 for permission on object, a
+// schema-qualified name, in database.
+func PermissionDenied(permission, object, database string) *SQLError {
+	schema, name := "dbo", object
+	if idx := strings.LastIndex(object, "."); idx >= 0 {
+		schema, name = object[:idx], object[idx+1:]
+		if idx := strings.LastIndex(schema, "."); idx >= 0 {
+			schema = schema[idx+1:]
+		}
+	}
+	if database == "" {
+		database = "master"
+	}
+	return NewSQLError(ErrPermissionDenied, fmt.Sprintf(
+		"The %s permission was denied on the object '%s', database '%s', schema '%s'.",
+		strings.ToUpper(permission), unbracket(name), database, unbracket(schema)))
+}
+
+// checkPermissions returns the error of a statement the session may not
+// run.
+func (i *Interpreter) checkPermissions(stmt ast.Statement) error {
+	if namesCredentialTable(stmt) {
+		permission := "CONTROL"
+		for _, access := range statementAccesses(stmt) {
+			if strings.HasSuffix(strings.ToLower(access.object), "."+CredentialTable) {
+				permission = access.permission
+				break
+			}
+		}
+		return PermissionDenied(permission, CredentialTable, i.database)
+	}
+	if !i.restricted() {
+		return nil
+	}
+	if securityStatement(stmt) {
+		return errNoPermission()
+	}
+
+	target := stmt
+	if w, ok := stmt.(*ast.WithStatement); ok {
+		target = w.Query
+	}
+	if _, ok := target.(*ast.CreateProcedureStatement); ok {
+		return i.databasePermissionDenied("CREATE PROCEDURE")
+	}
+	if kind, _, ok := journalWrite(target); ok {
+		switch kind {
+		case "INSERT", "UPDATE", "DELETE", "MERGE":
+		case "SELECT INTO":
+			return i.databasePermissionDenied("CREATE TABLE")
+		default:
+			return i.databasePermissionDenied(kind)
+		}
+	} else if writesDatabase(target) {
+		return i.databasePermissionDenied(statementFeature(target))
+	}
+
+	for _, access := range statementAccesses(stmt) {
+		if err := i.CheckPermission(access.permission, access.object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namesCredentialTable reports whether stmt names CredentialTable
+// anywhere, as a table read or written, or the object of DDL.
+func namesCredentialTable(stmt ast.Statement) bool {
+	found := false
+	walkNodes(reflect.ValueOf(stmt), func(node interface{}) {
+		if q, ok := node.(*ast.QualifiedIdentifier); ok && len(q.Parts) > 0 &&
+			strings.EqualFold(unbracket(q.Parts[len(q.Parts)-1].Value), CredentialTable) {
+			found = true
+		}
+	})
+	return found
+}
+
+// databasePermissionDenied returns error 262 for a statement of kind.
+func (i *Interpreter) databasePermissionDenied(kind string) error {
+	database := i.database
+	if database == "" {
+		database = "master"
+	}
+	return NewSQLError(ErrDatabasePermission, fmt.Sprintf("%s permission denied in database '%s'.", kind, database))
+}
+
+// errNoPermission returns error 15247, for statements only administrators
+// may run.
+func errNoPermission() error {
+	return NewSQLError(ErrNoPermission, "User does not have permission to perform this action.")
+}
+
+// securityStatement reports whether stmt changes permissions or roles.
+func securityStatement(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.GrantStatement, *ast.DenyStatement, *ast.RevokeStatement,
+		*ast.CreateRoleStatement, *ast.AlterRoleStatement,
+		*ast.CreateServerRoleStatement, *ast.AlterServerRoleStatement:
+		return true
+	case *ast.DropObjectStatement:
+		return s.ObjectType == "ROLE"
+	}
+	return false
+}
+
+// objectAccess is a permission a statement takes on an object.
+type objectAccess struct {
+	permission string
+	object     string // schema.name
+}
+
+// statementAccesses returns the permissions stmt takes on the tables and
+// views it names, leaving out temp tables, table variables, common table
+// expressions and the system catalog. The statements of blocks, and of
+// IF and WHILE, are left to be checked as they run; only the conditions
+// of IF and WHILE are read here.
+func statementAccesses(stmt ast.Statement) []objectAccess {
+	var root interface{} = stmt
+	switch s := stmt.(type) {
+	case *ast.IfStatement:
+		root = s.Condition
+	case *ast.WhileStatement:
+		root = s.Condition
+	case *ast.BeginEndBlock, *ast.TryCatchStatement, *ast.CreateProcedureStatement, *ast.ExecStatement:
+		return nil
+	}
+
+	ctes := map[string]bool{}
+	aliases := map[string]*ast.QualifiedIdentifier{}
+	var reads []*ast.QualifiedIdentifier
+	type write struct {
+		permission string
+		table      *ast.QualifiedIdentifier
+	}
+	var writes []write
+	walkNodes(reflect.ValueOf(root), func(node interface{}) {
+		switch n := node.(type) {
+		case *ast.CTEDef:
+			if n.Name != nil {
+				ctes[strings.ToLower(n.Name.Value)] = true
+			}
+		case *ast.TableName:
+			if n.Name != nil {
+				reads = append(reads, n.Name)
+				if n.Alias != nil {
+					aliases[strings.ToLower(n.Alias.Value)] = n.Name
+				}
+			}
+		case *ast.InsertStatement:
+			writes = append(writes, write{"INSERT", n.Table})
+		case *ast.UpdateStatement:
+			writes = append(writes, write{"UPDATE", n.Table})
+		case *ast.DeleteStatement:
+			writes = append(writes, write{"DELETE", n.Table})
+		case *ast.MergeStatement:
+			for _, clause := range n.WhenClauses {
+				switch clause.Action {
+				case ast.MergeActionInsert:
+					writes = append(writes, write{"INSERT", n.Target})
+				case ast.MergeActionUpdate:
+					writes = append(writes, write{"UPDATE", n.Target})
+				case ast.MergeActionDelete:
+					writes = append(writes, write{"DELETE", n.Target})
+				}
+			}
+		case *ast.OutputClause:
+			if n.Into != nil {
+				writes = append(writes, write{"INSERT", n.Into})
+			}
+		}
+	})
+
+	var accesses []objectAccess
+	add := func(permission string, table *ast.QualifiedIdentifier) {
+		if table == nil || len(table.Parts) == 0 {
+			return
+		}
+		parts := make([]string, len(table.Parts))
+		for j, p := range table.Parts {
+			parts[j] = unbracket(p.Value)
+		}
+		if len(parts) > 2 {
+			parts = parts[len(parts)-2:]
+		}
+		name := parts[len(parts)-1]
+		if isTransientTable(name) || len(parts) == 1 && ctes[strings.ToLower(name)] {
+			return
+		}
+		if len(parts) == 1 || parts[0] == "" {
+			parts = []string{"dbo", name}
+		}
+		switch strings.ToLower(parts[0]) {
+		case "sys", "information_schema":
+			return
+		}
+		if strings.EqualFold(name, "spt_values") {
+			return
+		}
+		access := objectAccess{permission, parts[0] + "." + name}
+		for _, a := range accesses {
+			if a.permission == access.permission && strings.EqualFold(a.object, access.object) {
+				return
+			}
+		}
+		accesses = append(accesses, access)
+	}
+	for _, w := range writes {
+		table := w.table
+		if table != nil && len(table.Parts) == 1 {
+			if aliased, ok := aliases[strings.ToLower(table.Parts[0].Value)]; ok {
+				table = aliased
+			}
+		}
+		add(w.permission, table)
+	}
+	for _, table := range reads {
+		add("SELECT", table)
+	}
+	return accesses
+}
+
+// CatalogReads returns the tables and views outside the system catalog
+// that sql, a batch of queries the storage layer may answer, reads, as
+// schema-qualified names. ok is false unless every statement of sql is a
+// SELECT, with or without common table expressions, and none is SELECT
+// INTO: others must run in the interpreter, whose permission checks they
+// would otherwise escape.
+func CatalogReads(sql string) (tables []string, ok bool) {
+	p := parser.New(lexer.New(sql))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 || len(program.Statements) == 0 {
+		return nil, false
+	}
+	for _, stmt := range program.Statements {
+		query := stmt
+		if with, isWith := stmt.(*ast.WithStatement); isWith {
+			query = with.Query
+		}
+		if sel, isSelect := query.(*ast.SelectStatement); !isSelect || sel.Into != nil {
+			return nil, false
+		}
+		for _, access := range statementAccesses(stmt) {
+			tables = append(tables, access.object)
+		}
+	}
+	return tables, true
+}
+
+// tokenType is skipped by walkNodes, having no nodes in it.
+var tokenType = reflect.TypeOf(token.Token{})
+
+// walkNodes calls fn with every non-nil pointer reachable from v through
+// exported fields, slices and interfaces.
+func walkNodes(v reflect.Value, fn func(node interface{})) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			walkNodes(v.Elem(), fn)
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		fn(v.Interface())
+		walkNodes(v.Elem(), fn)
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			return
+		}
+		for j := 0; j < v.NumField(); j++ {
+			if v.Type().Field(j).IsExported() {
+				walkNodes(v.Field(j), fn)
+			}
+		}
+	case reflect.Slice:
+		for j := 0; j < v.Len(); j++ {
+			walkNodes(v.Index(j), fn)
+		}
+	}
+}
+
+// unbracket strips the brackets or quotes from a name.
+func unbracket(name string) string {
+	if len(name) >= 2 && (name[0] == '[' && name[len(name)-1] == ']' || name[0] == '"' && name[len(name)-1] == '"') {
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// executePermissionChange runs GRANT, DENY or REVOKE.
+func (i *Interpreter) executePermissionChange(ctx context.Context, state string, permissions []string, onType string, on *ast.QualifiedIdentifier, columns, principals []string) error {
+	p := i.ctx.Permissions
+	if p == nil {
+		return nil
+	}
+
+	change := PermissionChange{State: state, Principals: principals}
+	for _, permission := range permissions {
+		switch {
+		case len(columns) > 0 || strings.Contains(permission, "("):
+			return NewSQLError(ErrNotSupported, "Column permissions are not supported; grant permissions on the whole table or a view.")
+		case permission == "ALL" || permission == "ALL PRIVILEGES":
+			change.Permissions = append(change.Permissions, grantablePermissions...)
+		case containsString(grantablePermissions, permission):
+			change.Permissions = append(change.Permissions, permission)
+		default:
+			return NewSQLError(ErrNotSupported, fmt.Sprintf(
+				"The %s permission is not supported; only %s can be granted.", permission, strings.Join(grantablePermissions, ", ")))
+		}
+	}
+
+	switch {
+	case on == nil || onType == "DATABASE":
+		change.Scope = "DATABASE"
+	case onType == "" || onType == "OBJECT":
+		change.Scope = "OBJECT"
+		change.Name = on.String()
+	case onType == "SCHEMA":
+		change.Scope = "SCHEMA"
+		change.Name = on.String()
+	default:
+		return NewSQLError(ErrNotSupported, fmt.Sprintf("Permissions on a %s are not supported.", onType))
+	}
+	return p.Apply(ctx, i.ctx.GetExecutor(), change)
+}
+
+// executeRoleChange runs CREATE ROLE, DROP ROLE or ALTER ROLE.
+func (i *Interpreter) executeRoleChange(ctx context.Context, change RoleChange) error {
+	p := i.ctx.Permissions
+	if p == nil {
+		return nil
+	}
+	return p.ChangeRole(ctx, i.ctx.GetExecutor(), change)
+}
+
+// executeAlterRole runs ALTER ROLE ... ADD MEMBER or DROP MEMBER.
+func (i *Interpreter) executeAlterRole(ctx context.Context, s *ast.AlterRoleStatement) error {
+	switch {
+	case s.AddMember != "":
+		return i.executeRoleChange(ctx, RoleChange{Action: "ADD MEMBER", Role: s.Name, Member: s.AddMember})
+	case s.DropMember != "":
+		return i.executeRoleChange(ctx, RoleChange{Action: "DROP MEMBER", Role: s.Name, Member: s.DropMember})
+	}
+	return unsupportedStatementError(s)
+}
+
+// executeAlterServerRole runs ALTER SERVER ROLE ... ADD MEMBER or DROP
+// MEMBER.
+func (i *Interpreter) executeAlterServerRole(ctx context.Context, s *ast.AlterServerRoleStatement) error {
+	switch {
+	case s.AddMember != "":
+		return i.executeRoleChange(ctx, RoleChange{Action: "ADD MEMBER", Role: s.Name, Member: s.AddMember, Server: true})
+	case s.DropMember != "":
+		return i.executeRoleChange(ctx, RoleChange{Action: "DROP MEMBER", Role: s.Name, Member: s.DropMember, Server: true})
+	}
+	return unsupportedStatementError(s)
+}
+
+// executeDropRole runs DROP ROLE, which IF EXISTS makes succeed for roles
+// that do not exist.
+func (i *Interpreter) executeDropRole(ctx context.Context, s *ast.DropObjectStatement) error {
+	for _, name := range s.Names {
+		err := i.executeRoleChange(ctx, RoleChange{Action: "DROP", Role: name.String()})
+		if err != nil && !(s.IfExists && isSQLError(err, ErrPrincipalNotFound)) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSQLError reports whether err is SQL error number.
+func isSQLError(err error, number int) bool {
+	var sqlErr *SQLError
+	return errors.As(err, &sqlErr) && sqlErr.Number == number
+}
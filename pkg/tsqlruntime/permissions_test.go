@@ -0,0 +1,207 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakePermissions allows the permissions in allowed, keyed by permission
+// and lower-cased schema.name, and records the changes made.
+type fakePermissions struct {
+	admin   bool
+	allowed map[string]bool
+	changes []PermissionChange
+	roles   []RoleChange
+}
+
+func (p *fakePermissions) Administrator() bool { return p.admin }
+
+func (p *fakePermissions) Allowed(permission, object string) bool {
+	object = strings.ToLower(object)
+	if !strings.Contains(object, ".") {
+		object = "dbo." + object
+	}
+	return p.allowed[permission+" "+object]
+}
+
+func (p *fakePermissions) Apply(ctx context.Context, db QueryExecutor, change PermissionChange) error {
+	p.changes = append(p.changes, change)
+	return nil
+}
+
+func (p *fakePermissions) ChangeRole(ctx context.Context, db QueryExecutor, change RoleChange) error {
+	if change.Action == "DROP" && change.Role == "missing" {
+		return NewSQLError(ErrPrincipalNotFound, "Cannot find the user or role 'missing'.")
+	}
+	p.roles = append(p.roles, change)
+	return nil
+}
+
+// permissionsSetup returns an interpreter over the orders table, with
+// procedures that read it directly and through dynamic SQL.
+func permissionsSetup(t *testing.T, p Permissions) *Interpreter {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE orders (id INTEGER, qty INTEGER); INSERT INTO orders VALUES (1, 5)"); err != nil {
+		t.Fatal(err)
+	}
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.GetOrders", `
+		CREATE PROCEDURE dbo.GetOrders
+		AS
+		BEGIN
+			SELECT qty FROM orders
+		END
+	`, nil)
+	resolver.AddProcedure("dbo.GetOrdersDynamic", `
+		CREATE PROCEDURE dbo.GetOrdersDynamic
+		AS
+		BEGIN
+			EXEC('SELECT qty FROM orders')
+		END
+	`, nil)
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetResolver(resolver)
+	interp.SetDatabase("sales")
+	interp.SetPermissions(p)
+	return interp
+}
+
+func TestPermissionsChecked(t *testing.T) {
+	p := &fakePermissions{allowed: map[string]bool{
+		"EXECUTE dbo.getorders":        true,
+		"EXECUTE dbo.getordersdynamic": true,
+	}}
+	interp := permissionsSetup(t, p)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		sql    string
+		number int
+	}{
+		{"SELECT qty FROM orders", ErrPermissionDenied},
+		{"SELECT qty FROM dbo.orders o WHERE EXISTS (SELECT 1 FROM sys.tables)", ErrPermissionDenied},
+		{"WITH o AS (SELECT id FROM orders) SELECT * FROM o", ErrPermissionDenied},
+		{"INSERT INTO orders VALUES (2, 1)", ErrPermissionDenied},
+		{"IF EXISTS (SELECT 1 FROM orders) PRINT 'yes'", ErrPermissionDenied},
+		{"EXEC dbo.GetMessage", ErrPermissionDenied},
+		// Dynamic SQL runs with the session's permissions, even in a
+		// procedure it may execute
+		{"EXEC dbo.GetOrdersDynamic", ErrPermissionDenied},
+		{"EXEC sp_executesql N'SELECT qty FROM orders'", ErrPermissionDenied},
+		{"CREATE TABLE notes (id INT)", ErrDatabasePermission},
+		{"SELECT * INTO copy FROM orders", ErrDatabasePermission},
+		{"CREATE PROCEDURE dbo.Mine AS SELECT 1", ErrDatabasePermission},
+		{"GRANT SELECT ON orders TO app", ErrNoPermission},
+		{"CREATE ROLE readers", ErrNoPermission},
+		{"EXEC sp_rename 'orders', 'sales'", ErrNoPermission},
+	} {
+		_, err := interp.Execute(ctx, tc.sql, nil)
+		wantSQLError(t, tc.sql, err, tc.number)
+	}
+	_, err := interp.Execute(ctx, "DELETE FROM orders", nil)
+	if err == nil || err.Error() != "Msg 229, Level 14, State 1, Line 1: "+
+		"The DELETE permission was denied on the object 'orders', database 'sales', schema 'dbo'." {
+		t.Errorf("got %v", err)
+	}
+
+	// Procedures the session may execute read what their owner may
+	result, err := interp.Execute(ctx, "EXEC dbo.GetOrders", nil)
+	if err != nil || result.ResultSets[0].Rows[0][0].AsInt() != 5 {
+		t.Errorf("EXEC dbo.GetOrders: %v, %v", result, err)
+	}
+
+	// Temp tables and variables need no permissions
+	if _, err := interp.Execute(ctx, "DECLARE @n INT = 1; CREATE TABLE #work (id INT); INSERT INTO #work VALUES (@n); SELECT id FROM #work", nil); err != nil {
+		t.Errorf("temp table: %v", err)
+	}
+
+	p.allowed["SELECT dbo.orders"] = true
+	for _, sql := range []string{
+		"SELECT qty FROM orders",
+		"WITH o AS (SELECT id FROM orders) SELECT * FROM o",
+		"EXEC dbo.GetOrdersDynamic",
+	} {
+		if _, err := interp.Execute(ctx, sql, nil); err != nil {
+			t.Errorf("%s: %v", sql, err)
+		}
+	}
+	_, err = interp.Execute(ctx, "UPDATE orders SET qty = 0", nil)
+	wantSQLError(t, "UPDATE", err, ErrPermissionDenied)
+
+	// The error can be caught
+	result, err = interp.Execute(ctx, "BEGIN TRY DELETE FROM orders END TRY BEGIN CATCH SELECT 'caught' AS n END CATCH", nil)
+	if err != nil || result.ResultSets[len(result.ResultSets)-1].Rows[0][0].AsString() != "caught" {
+		t.Errorf("caught %v: %v", result, err)
+	}
+
+	// Administrators are not checked
+	p.admin = true
+	if _, err := interp.Execute(ctx, "UPDATE orders SET qty = 0", nil); err != nil {
+		t.Errorf("administrator: %v", err)
+	}
+}
+
+func TestPermissionStatements(t *testing.T) {
+	p := &fakePermissions{admin: true}
+	interp := permissionsSetup(t, p)
+	ctx := context.Background()
+
+	if _, err := interp.Execute(ctx, `
+		GRANT SELECT, INSERT ON dbo.orders TO app, [reports]
+		DENY EXECUTE ON SCHEMA::sales TO reports
+		REVOKE ALL ON orders FROM app
+		GRANT SELECT TO public
+		CREATE ROLE readers
+		ALTER ROLE readers ADD MEMBER app
+		ALTER ROLE readers DROP MEMBER app
+		DROP ROLE readers
+		DROP ROLE IF EXISTS missing
+		SELECT qty FROM orders
+	`, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.changes) != 4 {
+		t.Fatalf("changes = %+v", p.changes)
+	}
+	for j, want := range []string{
+		"GRANT [SELECT INSERT] OBJECT dbo.orders [app reports]",
+		"DENY [EXECUTE] SCHEMA sales [reports]",
+		"REVOKE [SELECT INSERT UPDATE DELETE EXECUTE] OBJECT orders [app]",
+		"GRANT [SELECT] DATABASE  [public]",
+	} {
+		c := p.changes[j]
+		if got := fmt.Sprintf("%s %v %s %s %v", c.State, c.Permissions, c.Scope, c.Name, c.Principals); got != want {
+			t.Errorf("change %d = %q, want %q", j, got, want)
+		}
+	}
+	if len(p.roles) != 4 || p.roles[1].Action != "ADD MEMBER" || p.roles[1].Member != "app" || p.roles[3].Action != "DROP" {
+		t.Errorf("roles = %+v", p.roles)
+	}
+
+	for _, sql := range []string{
+		"GRANT ALTER ON orders TO app",
+		"GRANT SELECT (qty) ON orders TO app",
+	} {
+		_, err := interp.Execute(ctx, sql, nil)
+		wantSQLError(t, sql, err, ErrNotSupported)
+	}
+	_, err := interp.Execute(ctx, "DROP ROLE missing", nil)
+	wantSQLError(t, "DROP ROLE missing", err, ErrPrincipalNotFound)
+}
+
+func TestPermissionsUnset(t *testing.T) {
+	// Without a permission model, security statements do nothing
+	interp := permissionsSetup(t, nil)
+	if _, err := interp.Execute(context.Background(), `
+		CREATE ROLE readers
+		GRANT SELECT ON orders TO readers
+		DENY DELETE ON orders TO readers
+		SELECT qty FROM orders
+	`, nil); err != nil {
+		t.Fatal(err)
+	}
+}
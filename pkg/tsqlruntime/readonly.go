@@ -0,0 +1,57 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// In a read-only execution, statements that change the database fail with
+// error 3906, as they do in a SQL Server database set READ_ONLY: DML and
+// SELECT INTO on database tables, DDL, UPDATE STATISTICS, full-text DDL,
+// GRANT, DENY, REVOKE and the role statements, and the system procedures
+// that write. Temp tables and table variables may still be written, and
+// procedures still run, failing at their first write.
+
+// SetReadOnly sets whether this execution rejects statements that change
+// the database.
+func (i *Interpreter) SetReadOnly(readOnly bool) {
+	i.ctx.ReadOnly = readOnly
+}
+
+// checkReadOnly returns error 3906 when the execution is read-only.
+func (i *Interpreter) checkReadOnly() error {
+	if !i.ctx.ReadOnly {
+		return nil
+	}
+	database := i.database
+	if database == "" {
+		database = "master"
+	}
+	return NewSQLError(ErrDatabaseReadOnly,
+		fmt.Sprintf("Failed to update database \"%s\" because the database is read-only.", database))
+}
+
+// writesDatabase reports whether stmt changes the database.
+func writesDatabase(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.WithStatement:
+		return writesDatabase(s.Query)
+	case *ast.UpdateStatisticsStatement,
+		*ast.CreateFulltextIndexStatement, *ast.AlterFulltextIndexStatement, *ast.DropFulltextIndexStatement,
+		*ast.CreateFulltextCatalogStatement, *ast.DropFulltextCatalogStatement,
+		*ast.AddSensitivityClassificationStatement, *ast.DropSensitivityClassificationStatement,
+		*ast.CreatePartitionFunctionStatement, *ast.CreatePartitionSchemeStatement:
+		return true
+	case *ast.DropObjectStatement:
+		if strings.HasPrefix(s.ObjectType, "PARTITION ") {
+			return true
+		}
+	}
+	if securityStatement(stmt) {
+		return true
+	}
+	_, _, ok := journalWrite(stmt)
+	return ok
+}
@@ -0,0 +1,60 @@
+package tsqlruntime
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	interp := sysprocSetup(t)
+	interp.SetDatabase("sales")
+	interp.SetReadOnly(true)
+	ctx := context.Background()
+
+	// Reads, temp tables and variables are allowed
+	result, err := interp.Execute(ctx, `
+		DECLARE @n INT
+		CREATE TABLE #work (id INT)
+		INSERT INTO #work SELECT id FROM orders
+		SELECT @n = COUNT(*) FROM #work
+		SELECT qty FROM orders WHERE id = @n
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs := result.ResultSets[len(result.ResultSets)-1]; len(rs.Rows) != 1 || rs.Rows[0][0].AsInt() != 5 {
+		t.Errorf("got %v", rs.Rows)
+	}
+
+	for _, sql := range []string{
+		"UPDATE orders SET qty = 0",
+		"INSERT INTO orders VALUES (2, 1)",
+		"DELETE FROM orders",
+		"WITH o AS (SELECT id FROM orders) DELETE FROM orders WHERE id IN (SELECT id FROM o)",
+		"SELECT * INTO copy FROM orders",
+		"CREATE TABLE notes (id INT)",
+		"DROP TABLE orders",
+		"TRUNCATE TABLE orders",
+		"CREATE INDEX ix_qty ON orders (qty)",
+		"UPDATE STATISTICS orders",
+		"EXEC sp_rename 'orders', 'sales'",
+	} {
+		_, err := interp.Execute(ctx, sql, nil)
+		wantSQLError(t, sql, err, ErrDatabaseReadOnly)
+	}
+	if _, err := interp.Execute(ctx, "UPDATE orders SET qty = 0", nil); err == nil ||
+		err.Error() != `Msg 3906, Level 16, State 1, Line 1: Failed to update database "sales" because the database is read-only.` {
+		t.Errorf("got %v", err)
+	}
+
+	// The error can be caught
+	result, err = interp.Execute(ctx, "BEGIN TRY UPDATE orders SET qty = 0 END TRY BEGIN CATCH SELECT 'caught' AS n END CATCH", nil)
+	if err != nil || result.ResultSets[len(result.ResultSets)-1].Rows[0][0].AsString() != "caught" {
+		t.Errorf("caught %v: %v", result, err)
+	}
+
+	var qty int
+	if err := interp.ctx.DB.QueryRow("SELECT qty FROM orders").Scan(&qty); err != nil || qty != 5 {
+		t.Errorf("qty %d: %v", qty, err)
+	}
+}
@@ -0,0 +1,271 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/token"
+)
+
+// SQLite cannot change most of a table in place, so DDL that needs to
+// rebuilds it: the rows are copied into a new table with the change made,
+// which then replaces the original, as SQLite's documentation sets out
+// for ALTER TABLE. CREATE INDEX on a large table is built this way, the
+// index kept up as rows are copied in batches, so that the statement
+// reports its progress and can be cancelled between batches; ALTER TABLE
+// changes SQLite lacks are to rebuild the same way.
+//
+// A rebuild runs in a transaction of its own, on a connection with
+// foreign key enforcement off until it commits, so one that fails or is
+// cancelled, by a timeout or the client going away, leaves the original
+// table, its indexes and triggers as they were. Progress goes to the
+// ProgressReporter set with SetProgress, which sys.dm_exec_requests reads
+// percent_complete from. Smaller tables, other backends and statements
+// inside a transaction are changed directly; cancelling those still
+// rolls the statement back, but reports no progress.
+
+// ProgressReporter is told how far a long-running statement has got.
+type ProgressReporter interface {
+	// ReportProgress records that the statement running, command, is
+	// percent complete. It reports 100 once done, whether or not it
+	// succeeded.
+	ReportProgress(command string, percent float64)
+}
+
+// rebuildMinRows is the fewest rows a table must have for CREATE INDEX
+// to rebuild it rather than index it in one statement.
+var rebuildMinRows int64 = 100000
+
+// rebuildBatchRows is how many rows of the table each batch copies, by
+// rowid.
+const rebuildBatchRows = 10000
+
+// rebuildPrefix starts the name of the table a rebuild copies into.
+const rebuildPrefix = "aul_rebuild_"
+
+// SetProgress sets where long-running statements report their progress.
+func (i *Interpreter) SetProgress(progress ProgressReporter) {
+	i.ctx.Progress = progress
+}
+
+// executeCreateIndex runs CREATE INDEX, rebuilding a large SQLite table.
+func (i *Interpreter) executeCreateIndex(ctx context.Context, s *ast.CreateIndexStatement, result *ExecutionResult) error {
+	if i.ctx.DB == nil || i.ctx.Tx != nil || i.ctx.Dialect != DialectSQLite ||
+		s.Table == nil || isTransientTable(s.Table.String()) {
+		return i.ddl.ExecuteCreateIndex(ctx, s)
+	}
+
+	table, rows, err := i.rebuildSize(ctx, s.Table.String())
+	if err != nil || rows < rebuildMinRows {
+		return i.ddl.ExecuteCreateIndex(ctx, s)
+	}
+
+	start := time.Now()
+	err = i.rebuildTable(ctx, "CREATE INDEX", table, rows, func(newTable string) []string {
+		index := *s
+		index.Table = &ast.QualifiedIdentifier{Parts: []*ast.Identifier{
+			{Token: token.Token{Quote: '"'}, Value: newTable}}}
+		return []string{i.ddl.generateSQLiteCreateIndex(&index)}
+	})
+	if err != nil {
+		return err
+	}
+	result.Warnings = append(result.Warnings, fmt.Sprintf(
+		"Table %s was rebuilt to create index %s: %d rows in %s.",
+		table, s.Name.Value, rows, time.Since(start).Round(time.Millisecond)))
+	return nil
+}
+
+// rebuildSize returns the SQLite name of table and its row count.
+func (i *Interpreter) rebuildSize(ctx context.Context, name string) (string, int64, error) {
+	var table string
+	err := i.ctx.DB.QueryRowContext(ctx,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name = ? COLLATE NOCASE",
+		i.backendName(name)).Scan(&table)
+	if err != nil {
+		return "", 0, err
+	}
+	var rows int64
+	err = i.ctx.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoteSQLiteName(table)).Scan(&rows)
+	return table, rows, err
+}
+
+// rebuildTable rebuilds table, which has rows rows, running the
+// statements change returns against the new table before the rows are
+// copied into it. command names the statement in progress reports.
+func (i *Interpreter) rebuildTable(ctx context.Context, command, table string, rows int64, change func(newTable string) []string) (err error) {
+	report := func(percent float64) {
+		if i.ctx.Progress != nil {
+			i.ctx.Progress.ReportProgress(command, percent)
+		}
+	}
+	report(0)
+	defer report(100) // Done, whether or not it succeeded
+
+	conn, err := i.ctx.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Neither pragma can change inside a transaction. Dropping the
+	// original must not cascade to the tables referencing it, and
+	// renaming the copy must not check views that refer to the table
+	// while it is missing.
+	restore := context.Background() // Even once ctx is cancelled
+	for _, pragma := range []string{"foreign_keys", "legacy_alter_table"} {
+		var on bool
+		if err := conn.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(&on); err != nil {
+			return err
+		}
+		want := pragma == "legacy_alter_table"
+		if on == want {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %t", pragma, want)); err != nil {
+			return err
+		}
+		defer conn.ExecContext(restore, fmt.Sprintf("PRAGMA %s = %t", pragma, on))
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			if ctx.Err() != nil {
+				err = fmt.Errorf("%s was cancelled and table %s left as it was: %w", command, table, ctx.Err())
+			}
+		}
+	}()
+
+	create, indexes, triggers, err := tableSchema(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+
+	newTable := rebuildPrefix + table
+	quoted, newQuoted := quoteSQLiteName(table), quoteSQLiteName(newTable)
+	statements := []string{"CREATE TABLE " + newQuoted + " " + create[strings.Index(create, "("):]}
+	for _, index := range indexes {
+		// The copy's indexes take the names of the original's
+		statements = append(statements, "DROP INDEX "+quoteSQLiteName(index.name), retargetIndex(index.sql, newQuoted))
+	}
+	statements = append(statements, change(newTable)...)
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if err := i.copyRows(ctx, tx, quoted, newQuoted, rows, report); err != nil {
+		return err
+	}
+
+	statements = []string{"DROP TABLE " + quoted, "ALTER TABLE " + newQuoted + " RENAME TO " + quoted}
+	statements = append(statements, triggers...)
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	var violations int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_foreign_key_check("+quoteLiteral(table)+")").Scan(&violations); err != nil {
+		return err
+	}
+	if violations > 0 {
+		return fmt.Errorf("%s would leave %d rows of table %s breaking foreign keys", command, violations, table)
+	}
+	return tx.Commit()
+}
+
+// copyRows copies the rows of table into newTable in batches of rowids,
+// reporting progress after each.
+func (i *Interpreter) copyRows(ctx context.Context, tx *sql.Tx, table, newTable string, rows int64, report func(float64)) error {
+	var low, high sql.NullInt64
+	err := tx.QueryRowContext(ctx, "SELECT MIN(rowid), MAX(rowid) FROM "+table).Scan(&low, &high)
+	if err != nil {
+		// A WITHOUT ROWID table is copied in one go
+		_, err := tx.ExecContext(ctx, "INSERT INTO "+newTable+" SELECT * FROM "+table)
+		return err
+	}
+
+	var copied int64
+	for from := low.Int64; low.Valid && from <= high.Int64; from += rebuildBatchRows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "INSERT INTO "+newTable+" SELECT * FROM "+table+
+			" WHERE rowid BETWEEN ? AND ? ORDER BY rowid", from, from+rebuildBatchRows-1)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		copied += n
+		if rows > 0 {
+			report(min(99, float64(copied)*100/float64(rows)))
+		}
+	}
+	return nil
+}
+
+// schemaObject is an index or trigger as sqlite_master records it.
+type schemaObject struct {
+	name, sql string
+}
+
+// tableSchema returns the CREATE TABLE statement of table, and the
+// indexes and trigger statements it has. Indexes made for constraints
+// are left out, since the CREATE TABLE statement makes them again.
+func tableSchema(ctx context.Context, tx *sql.Tx, table string) (create string, indexes []schemaObject, triggers []string, err error) {
+	rows, err := tx.QueryContext(ctx, "SELECT type, name, sql FROM sqlite_master"+
+		" WHERE tbl_name = ? AND sql IS NOT NULL ORDER BY type, name", table)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		var object schemaObject
+		if err := rows.Scan(&kind, &object.name, &object.sql); err != nil {
+			return "", nil, nil, err
+		}
+		switch kind {
+		case "table":
+			create = object.sql
+		case "index":
+			indexes = append(indexes, object)
+		case "trigger":
+			triggers = append(triggers, object.sql)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, nil, err
+	}
+	if !strings.Contains(create, "(") {
+		return "", nil, nil, fmt.Errorf("table %s cannot be rebuilt: %q", table, create)
+	}
+	return create, indexes, triggers, nil
+}
+
+// retargetIndex rewrites a CREATE INDEX statement to index table.
+func retargetIndex(stmt, table string) string {
+	on := strings.Index(strings.ToUpper(stmt), " ON ")
+	columns := strings.Index(stmt[on+1:], "(")
+	if on < 0 || columns < 0 {
+		return stmt
+	}
+	return stmt[:on] + " ON " + table + " " + stmt[on+1+columns:]
+}
+
+// quoteLiteral renders s as a SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,152 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// progressLog records the progress reported to it, calling cancel, when
+// set, at the first report past 0%.
+type progressLog struct {
+	commands []string
+	percents []float64
+	cancel   func()
+}
+
+func (p *progressLog) ReportProgress(command string, percent float64) {
+	p.commands = append(p.commands, command)
+	p.percents = append(p.percents, percent)
+	if p.cancel != nil && percent > 0 {
+		p.cancel()
+	}
+}
+
+func rebuildSetup(t *testing.T) (*Interpreter, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "rebuild.db")+"?_foreign_keys=ON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		CREATE TABLE customers (id INTEGER PRIMARY KEY);
+		INSERT INTO customers VALUES (1);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, customer INTEGER REFERENCES customers (id), qty INTEGER);
+		CREATE INDEX ix_orders_customer ON orders (customer);
+		CREATE TABLE audit (n INTEGER);
+		CREATE TRIGGER orders_audit AFTER INSERT ON orders BEGIN INSERT INTO audit VALUES (NEW.id); END;
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 25000)
+		INSERT INTO orders SELECT i, 1, i % 7 FROM n;
+		DELETE FROM audit;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	min := rebuildMinRows
+	rebuildMinRows = 1000
+	t.Cleanup(func() { rebuildMinRows = min })
+	return NewInterpreter(db, DialectSQLite), db
+}
+
+// indexes returns the names of the indexes and triggers on orders.
+func indexes(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE tbl_name = 'orders' AND type IN ('index', 'trigger') AND sql IS NOT NULL ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestCreateIndexRebuild(t *testing.T) {
+	interp, db := rebuildSetup(t)
+	progress := &progressLog{}
+	interp.SetProgress(progress)
+
+	result, err := interp.Execute(context.Background(), "CREATE INDEX ix_orders_qty ON orders (qty)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "25000 rows") {
+		t.Errorf("warnings: %v", result.Warnings)
+	}
+	if len(progress.percents) < 4 || progress.percents[0] != 0 || progress.percents[len(progress.percents)-1] != 100 {
+		t.Errorf("progress: %v", progress.percents)
+	}
+	for n, percent := range progress.percents[1:] {
+		if percent < progress.percents[n] || progress.commands[n] != "CREATE INDEX" {
+			t.Errorf("progress: %v %v", progress.commands, progress.percents)
+			break
+		}
+	}
+
+	if got, want := indexes(t, db), "ix_orders_customer, ix_orders_qty, orders_audit"; got != want {
+		t.Errorf("indexes: got %s, want %s", got, want)
+	}
+	var count, sum int
+	if err := db.QueryRow("SELECT COUNT(*), SUM(qty) FROM orders INDEXED BY ix_orders_qty WHERE qty >= 0").Scan(&count, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if count != 25000 || sum != 74997 {
+		t.Errorf("rows: got %d summing %d", count, sum)
+	}
+	if _, err := db.Exec("INSERT INTO orders VALUES (25001, 2, 1)"); err == nil {
+		t.Error("foreign key not enforced after the rebuild")
+	}
+	if _, err := db.Exec("INSERT INTO orders VALUES (25001, 1, 1)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit").Scan(&count); err != nil || count != 1 {
+		t.Errorf("trigger: %d rows audited, %v", count, err)
+	}
+
+	// A small table is indexed directly
+	progress.percents = nil
+	if _, err := interp.Execute(context.Background(), "CREATE INDEX ix_audit ON audit (n)", nil); err != nil {
+		t.Fatal(err)
+	}
+	if progress.percents != nil {
+		t.Errorf("small table rebuilt: %v", progress.percents)
+	}
+}
+
+func TestCreateIndexRebuildCancelled(t *testing.T) {
+	interp, db := rebuildSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interp.SetProgress(&progressLog{cancel: cancel})
+
+	_, err := interp.Execute(ctx, "CREATE INDEX ix_orders_qty ON orders (qty)", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want cancelled", err)
+	}
+
+	if got, want := indexes(t, db), "ix_orders_customer, orders_audit"; got != want {
+		t.Errorf("indexes: got %s, want %s", got, want)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&count); err != nil || count != 25000 {
+		t.Errorf("rows: got %d, %v", count, err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'aul_rebuild_%'").Scan(&count); err != nil || count != 0 {
+		t.Errorf("rebuild table left behind: %d, %v", count, err)
+	}
+	var on bool
+	conn, _ := db.Conn(context.Background())
+	defer conn.Close()
+	if err := conn.QueryRowContext(context.Background(), "PRAGMA foreign_keys").Scan(&on); err != nil || !on {
+		t.Errorf("foreign keys left off: %v", err)
+	}
+}
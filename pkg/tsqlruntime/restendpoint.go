@@ -0,0 +1,262 @@
+package tsqlruntime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sp_invoke_external_rest_endpoint calls an HTTP endpoint from T-SQL, as
+// on Azure SQL Database:
+//
+//	DECLARE @response nvarchar(max), @rc int;
+//	EXEC @rc = sp_invoke_external_rest_endpoint
+//	    @url = N'https://api.example.com/orders',
+//	    @method = 'POST',
+//	    @headers = N'{"Authorization": "Bearer ..."}',
+//	    @payload = N'{"id": 42}',
+//	    @timeout = 10,
+//	    @response = @response OUTPUT;
+//
+// The return code is 0 for a 2xx status and the status otherwise. The
+// response is a JSON document with the status and headers under
+// "response" and the body under "result": as JSON when it parses, as a
+// string when not. Only hosts the administrator allows may be called
+// (see RESTPolicy); the call runs in aul on every dialect.
+
+// Defaults and limits of sp_invoke_external_rest_endpoint.
+const (
+	DefaultRESTTimeout          = 30 * time.Second
+	DefaultRESTMaxResponseBytes = 1 << 20
+	maxRESTTimeoutSeconds       = 230 // Azure's limit on @timeout
+)
+
+// RESTPolicy says which endpoints sp_invoke_external_rest_endpoint may
+// call. The zero value allows none.
+type RESTPolicy struct {
+	// AllowedHosts lists the hosts that may be called: a name or address,
+	// optionally with a port ("api.example.com", "10.0.0.5:8443"), or
+	// "*.example.com" for every subdomain. Without a port any port is
+	// allowed.
+	AllowedHosts []string
+
+	// Timeout bounds each call; a longer @timeout is cut to it
+	// (0 = DefaultRESTTimeout).
+	Timeout time.Duration
+
+	// MaxResponseBytes is the largest response body accepted
+	// (0 = DefaultRESTMaxResponseBytes).
+	MaxResponseBytes int64
+
+	// Client makes the calls (nil = a client that follows redirects only
+	// to allowed hosts).
+	Client *http.Client
+}
+
+// Allows reports whether the policy allows calls to u.
+func (p *RESTPolicy) Allows(u *url.URL) bool {
+	if p == nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	for _, entry := range p.AllowedHosts {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		pattern, wantPort := entry, ""
+		if h, pt, err := net.SplitHostPort(entry); err == nil {
+			pattern, wantPort = h, pt
+		}
+		if wantPort != "" && wantPort != port {
+			continue
+		}
+		if pattern == host ||
+			(strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeout returns the limit of a call asking for seconds (0 = as long as
+// the policy allows).
+func (p *RESTPolicy) timeout(seconds int64) time.Duration {
+	limit := p.Timeout
+	if limit <= 0 {
+		limit = DefaultRESTTimeout
+	}
+	if seconds > 0 {
+		if d := time.Duration(seconds) * time.Second; d < limit {
+			return d
+		}
+	}
+	return limit
+}
+
+// client returns the HTTP client for calls under the policy.
+func (p *RESTPolicy) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !p.Allows(req.URL) {
+				return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// SetRESTPolicy sets the endpoints sp_invoke_external_rest_endpoint may
+// call. Without a policy it fails.
+func (i *Interpreter) SetRESTPolicy(policy *RESTPolicy) {
+	i.ctx.REST = policy
+}
+
+// restResponse is the document returned in @response.
+type restResponse struct {
+	Response struct {
+		Status struct {
+			HTTP struct {
+				Code        int    `json:"code"`
+				Description string `json:"description"`
+			} `json:"http"`
+		} `json:"status"`
+		Headers map[string]string `json:"headers"`
+	} `json:"response"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// restMethods are the methods the procedure accepts.
+var restMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"}
+
+// spInvokeExternalRESTEndpoint runs sp_invoke_external_rest_endpoint.
+func (i *Interpreter) spInvokeExternalRESTEndpoint(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	const proc = "sp_invoke_external_rest_endpoint"
+	invalid := func(format string, a ...interface{}) error {
+		return NewSQLError(ErrInvalidParameter, fmt.Sprintf(proc+": "+format, a...))
+	}
+
+	rawURL := argString(args, "@url")
+	if rawURL == "" {
+		return invalid("@url is required")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return invalid("'%s' is not a valid URL", rawURL)
+	}
+	if !i.ctx.REST.Allows(u) {
+		return NewSQLError(ErrPermissionDenied, fmt.Sprintf(
+			"%s: calls to '%s' are not allowed", proc, u.Host))
+	}
+
+	method := strings.ToUpper(argString(args, "@method"))
+	if method == "" {
+		method = "POST"
+	}
+	if !containsString(restMethods, method) {
+		return invalid("method '%s' is not supported", method)
+	}
+
+	var seconds int64
+	if v, ok := args["@timeout"]; ok && !v.IsNull {
+		seconds = v.AsInt()
+		if seconds < 1 || seconds > maxRESTTimeoutSeconds {
+			return invalid("@timeout must be between 1 and %d seconds", maxRESTTimeoutSeconds)
+		}
+	}
+
+	var headers map[string]string
+	if h := argString(args, "@headers"); h != "" {
+		if err := json.Unmarshal([]byte(h), &headers); err != nil {
+			return invalid("@headers must be a JSON object of strings: %v", err)
+		}
+	}
+
+	var body io.Reader
+	payload := argString(args, "@payload")
+	if payload != "" {
+		body = strings.NewReader(payload)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, i.ctx.REST.timeout(seconds))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return invalid("%v", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if payload != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := i.ctx.REST.client().Do(req)
+	if err != nil {
+		return NewSQLError(ErrExternalEndpoint, fmt.Sprintf("%s: call to '%s' failed: %v", proc, u.Host, err))
+	}
+	defer resp.Body.Close()
+
+	limit := i.ctx.REST.MaxResponseBytes
+	if limit <= 0 {
+		limit = DefaultRESTMaxResponseBytes
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return NewSQLError(ErrExternalEndpoint, fmt.Sprintf("%s: reading the response from '%s' failed: %v", proc, u.Host, err))
+	}
+	if int64(len(data)) > limit {
+		return NewSQLError(ErrExternalEndpoint, fmt.Sprintf(
+			"%s: the response from '%s' is larger than %d bytes", proc, u.Host, limit))
+	}
+
+	doc, err := restResponseDocument(resp, data)
+	if err != nil {
+		return err
+	}
+	args["@response"] = NewNVarChar(doc, -1)
+
+	rc := int64(0)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		rc = int64(resp.StatusCode)
+	}
+	args[returnValueArg] = NewInt(rc)
+	return nil
+}
+
+// restResponseDocument builds the @response document for a response with
+// body data.
+func restResponseDocument(resp *http.Response, data []byte) (string, error) {
+	var doc restResponse
+	doc.Response.Status.HTTP.Code = resp.StatusCode
+	doc.Response.Status.HTTP.Description = http.StatusText(resp.StatusCode)
+	doc.Response.Headers = make(map[string]string, len(resp.Header))
+	for name, values := range resp.Header {
+		doc.Response.Headers[name] = strings.Join(values, ", ")
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 {
+		if json.Valid(trimmed) {
+			doc.Result = trimmed
+		} else {
+			s, _ := json.Marshal(string(data))
+			doc.Result = s
+		}
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
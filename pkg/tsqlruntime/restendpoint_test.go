@@ -0,0 +1,152 @@
+package tsqlruntime
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSpInvokeExternalRESTEndpoint(t *testing.T) {
+	var gotMethod, gotBody, gotAuth, gotType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		gotMethod, gotBody = r.Method, string(data)
+		gotAuth, gotType = r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+		switch r.URL.Path {
+		case "/orders":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 42, "status": "placed"}`))
+		case "/text":
+			w.Write([]byte("plain text"))
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host, _ := url.Parse(srv.URL)
+
+	db := setupTestDB(t)
+	defer db.Close()
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetRESTPolicy(&RESTPolicy{AllowedHosts: []string{host.Host}, MaxResponseBytes: 50})
+
+	result, err := interp.Execute(context.Background(), `
+		DECLARE @response NVARCHAR(MAX), @rc INT
+		EXEC @rc = sp_invoke_external_rest_endpoint
+			@url = '`+srv.URL+`/orders',
+			@headers = N'{"Authorization": "Bearer t0k"}',
+			@payload = N'{"qty": 2}',
+			@response = @response OUTPUT
+		SELECT @rc AS rc, @response AS response
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := result.ResultSets[0].Rows[0]
+	var doc struct {
+		Response struct {
+			Status struct {
+				HTTP struct{ Code int }
+			}
+			Headers map[string]string
+		}
+		Result json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(row[1].AsString()), &doc); err != nil {
+		t.Fatalf("@response %s: %v", row[1].AsString(), err)
+	}
+	if row[0].AsString() != "0" || doc.Response.Status.HTTP.Code != 200 ||
+		doc.Response.Headers["Content-Type"] != "application/json" ||
+		string(doc.Result) != `{"id":42,"status":"placed"}` {
+		t.Errorf("got rc %s, response %s", row[0].AsString(), row[1].AsString())
+	}
+	if gotMethod != "POST" || gotBody != `{"qty": 2}` || gotAuth != "Bearer t0k" || gotType != "application/json" {
+		t.Errorf("request: %s %q auth %q type %q", gotMethod, gotBody, gotAuth, gotType)
+	}
+
+	// A status outside 2xx is the return code; text bodies are strings
+	result, err = interp.Execute(context.Background(), `
+		DECLARE @response NVARCHAR(MAX), @rc INT, @rc2 INT
+		EXEC @rc = sp_invoke_external_rest_endpoint '`+srv.URL+`/missing', NULL, NULL, 'GET', 5, NULL, @response OUTPUT
+		EXEC @rc2 = sp_invoke_external_rest_endpoint @url = '`+srv.URL+`/text', @method = 'GET', @response = @response OUTPUT
+		SELECT @rc AS rc, @rc2 AS rc2, @response AS response
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row = result.ResultSets[len(result.ResultSets)-1].Rows[0]
+	if err := json.Unmarshal([]byte(row[2].AsString()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if row[0].AsString() != "404" || row[1].AsString() != "0" || string(doc.Result) != `"plain text"` {
+		t.Errorf("got %v", row)
+	}
+
+	refused := []struct {
+		sql    string
+		number int
+	}{
+		{`EXEC sp_invoke_external_rest_endpoint @url = 'http://example.com/', @response = @r OUTPUT`, ErrPermissionDenied},
+		{`EXEC sp_invoke_external_rest_endpoint @url = 'ftp://` + host.Host + `/', @response = @r OUTPUT`, ErrPermissionDenied},
+		{`EXEC sp_invoke_external_rest_endpoint @url = '` + srv.URL + `/', @method = 'TRACE', @response = @r OUTPUT`, ErrInvalidParameter},
+		{`EXEC sp_invoke_external_rest_endpoint @url = '` + srv.URL + `/', @timeout = 0, @response = @r OUTPUT`, ErrInvalidParameter},
+		{`EXEC sp_invoke_external_rest_endpoint @url = '` + srv.URL + `/', @headers = '[1]', @response = @r OUTPUT`, ErrInvalidParameter},
+		{`EXEC sp_invoke_external_rest_endpoint @url = '` + srv.URL + `/big', @response = @r OUTPUT`, ErrExternalEndpoint},
+	}
+	for _, tc := range refused {
+		_, err := interp.Execute(context.Background(), "DECLARE @r NVARCHAR(MAX)\n"+tc.sql, nil)
+		wantSQLError(t, tc.sql, err, tc.number)
+	}
+
+	// Without a policy nothing may be called
+	bare := NewInterpreter(db, DialectSQLite)
+	sql := `EXEC sp_invoke_external_rest_endpoint @url = '` + srv.URL + `/orders'`
+	_, err = bare.Execute(context.Background(), sql, nil)
+	wantSQLError(t, sql, err, ErrPermissionDenied)
+}
+
+func TestRESTPolicy_Allows(t *testing.T) {
+	policy := &RESTPolicy{AllowedHosts: []string{"api.example.com", "*.internal.example", "10.0.0.5:8443"}}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://api.example.com/v1", true},
+		{"https://API.example.com:444/v1", true},
+		{"https://evil.com/?api.example.com", false},
+		{"https://api.example.com.evil.com/", false},
+		{"https://orders.internal.example/", true},
+		{"https://internal.example/", false},
+		{"https://10.0.0.5:8443/", true},
+		{"https://10.0.0.5/", false},
+		{"file://api.example.com/etc/passwd", false},
+	}
+	for _, tc := range tests {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := policy.Allows(u); got != tc.want {
+			t.Errorf("Allows(%s) = %v, want %v", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestRESTResponseDocument(t *testing.T) {
+	resp := &http.Response{StatusCode: 201, Header: http.Header{"X-Id": {"1", "2"}}}
+	doc, err := restResponseDocument(resp, []byte(" [1,2] "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"response":{"status":{"http":{"code":201,"description":"Created"}},"headers":{"X-Id":"1, 2"}},"result":[1,2]}`
+	if doc != want {
+		t.Errorf("got  %s\nwant %s", doc, want)
+	}
+}
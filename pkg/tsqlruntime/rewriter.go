@@ -75,6 +75,53 @@ type BaseRewriter struct {
 
 	// Type mappings for DDL
 	typeMappings map[string]string
+
+	// Table-valued functions expanded inline: GENERATE_SERIES -> recursive CTE
+	tableFunctions map[string]func(*ast.TableValuedFunction) ast.TableReference
+
+	// TRY_CAST wrapper, given the cast and its T-SQL type name:
+	// TRY_CAST(x AS INT) -> CASE WHEN <x is valid> THEN CAST(x AS INT) END
+	tryCast func(e *ast.CastExpression, typeName string) ast.Expression
+
+	// Static methods of types, by TYPE::METHOD:
+	// GEOGRAPHY::POINT(lat, long, srid) -> the instance's text
+	staticMethods map[string]func(*ast.StaticMethodCall) ast.Expression
+
+	// Instance methods and properties, by name:
+	// loc.STDistance(@p) -> a distance computed from the instances' text.
+	// A property reads like a column (loc.Lat), so a qualifier naming a
+	// table in scope keeps that meaning.
+	methodCalls map[string]func(*ast.MethodCallExpression) ast.Expression
+	properties  map[string]func(*ast.MethodCallExpression) ast.Expression
+
+	// Replaces the tables of a query the backend lacks, such as the
+	// built-in numbers tables (nil = none; see numbers.go)
+	tableSources func(*ast.SelectStatement)
+
+	// The tables of the enclosing queries, innermost last
+	tableScopes [][]tableBinding
+
+	// Quote columns qualified by a table in scope ("s"."id"), which keeps
+	// them distinct in joins once other qualifiers are dropped
+	quoteQualified bool
+
+	// Delimiter of the dialect's delimited identifiers: [Order Details]
+	// -> "Order Details" (0 = keep them as written)
+	identifierQuote rune
+
+	// Drop database and schema qualifiers from names, for backends without
+	// schemas: dbo.Orders -> Orders, dbo.Orders.id -> "Orders"."id"
+	stripSchemas bool
+
+	// Operator that replaces + when an operand is a string: 'a' + @b -> 'a' || @b
+	concatOperator string
+
+	// Convert TOP n to LIMIT n
+	topToLimit bool
+
+	// Column identifying a table's rows, through which DELETE TOP (n)
+	// deletes the rows a LIMIT n subquery selects ("" = leave TOP as is)
+	rowID string
 }
 
 func (r *BaseRewriter) Dialect() Dialect { return r.dialect }
@@ -106,6 +153,8 @@ func (r *BaseRewriter) RewriteStatement(stmt ast.Statement) ast.Statement {
 		return r.rewriteWhile(s)
 	case *ast.BeginEndBlock:
 		return r.rewriteBeginEnd(s)
+	case *ast.WithStatement:
+		return r.rewriteWith(s)
 	default:
 		return stmt
 	}
@@ -120,6 +169,8 @@ func (r *BaseRewriter) RewriteExpression(expr ast.Expression) ast.Expression {
 	switch e := expr.(type) {
 	case *ast.FunctionCall:
 		return r.rewriteFunctionCall(e)
+	case *ast.TrimExpression:
+		return r.rewriteTrim(e)
 	case *ast.InfixExpression:
 		return r.rewriteInfix(e)
 	case *ast.PrefixExpression:
@@ -128,33 +179,98 @@ func (r *BaseRewriter) RewriteExpression(expr ast.Expression) ast.Expression {
 		return r.rewriteCast(e)
 	case *ast.ConvertExpression:
 		return r.rewriteConvert(e)
+	case *ast.ParseExpression:
+		return r.rewriteParse(e)
 	case *ast.CaseExpression:
 		return r.rewriteCase(e)
 	case *ast.BetweenExpression:
 		return r.rewriteBetween(e)
+	case *ast.LikeExpression:
+		return r.rewriteLike(e)
 	case *ast.InExpression:
 		return r.rewriteIn(e)
 	case *ast.IsNullExpression:
 		return r.rewriteIsNull(e)
+	case *ast.IsDistinctFromExpression:
+		e.Left = r.RewriteExpression(e.Left)
+		e.Right = r.RewriteExpression(e.Right)
+		return e
+	case *ast.CollateExpression:
+		e.Expr = r.RewriteExpression(e.Expr)
+		return e
+	case *ast.TupleExpression:
+		for i, el := range e.Elements {
+			e.Elements[i] = r.RewriteExpression(el)
+		}
+		return e
 	case *ast.SubqueryExpression:
 		return r.rewriteSubquery(e)
+	case *ast.ExistsExpression:
+		e.Subquery = r.rewriteSelect(e.Subquery)
+		return e
 	case *ast.SelectStatement:
 		// SELECT can appear as expression (subquery)
 		return r.rewriteSelect(e)
+	case *ast.StaticMethodCall:
+		return r.rewriteStaticMethodCall(e)
+	case *ast.MethodCallExpression:
+		return r.rewriteMethodCall(e)
+	case *ast.QualifiedIdentifier:
+		return r.rewriteQualifiedIdentifier(e)
+	case *ast.Identifier:
+		r.requote(e)
+		return r.rewriteGraphColumn(nil, e)
+	case *ast.GraphMatchExpression:
+		return r.rewriteGraphMatch(e)
+	case *ast.BinaryLiteral:
+		return r.rewriteBinaryLiteral(e)
 	default:
 		return expr
 	}
 }
 
+// rewriteBinaryLiteral writes 0x... as the dialect writes blobs: SQLite
+// and MySQL would read it as a number, PostgreSQL would not read it.
+func (r *BaseRewriter) rewriteBinaryLiteral(e *ast.BinaryLiteral) ast.Expression {
+	if !strings.HasPrefix(e.Value, "0x") && !strings.HasPrefix(e.Value, "0X") {
+		return e // already rewritten
+	}
+	digits := e.Value[2:]
+	if len(digits)%2 == 1 {
+		digits = "0" + digits
+	}
+	switch r.dialect {
+	case DialectPostgres:
+		return &ast.BinaryLiteral{Token: e.Token, Value: `'\x` + digits + `'::bytea`}
+	case DialectSQLite, DialectMySQL:
+		return &ast.BinaryLiteral{Token: e.Token, Value: "X'" + digits + "'"}
+	}
+	return e
+}
+
 // rewriteSelect transforms a SELECT statement.
 func (r *BaseRewriter) rewriteSelect(s *ast.SelectStatement) *ast.SelectStatement {
 	if s == nil {
 		return nil
 	}
+	if s.From != nil && r.tableSources != nil {
+		r.tableSources(s)
+	}
+	if s.From != nil {
+		defer r.enterScope(nil, nil, s.From)()
+	}
 
 	// Rewrite columns
 	for i, col := range s.Columns {
 		s.Columns[i].Expression = r.RewriteExpression(col.Expression)
+		r.requote(col.Alias)
+	}
+
+	// Rewrite FROM
+	if s.From != nil {
+		for i, ref := range s.From.Tables {
+			s.From.Tables[i] = r.rewriteTableRef(ref)
+		}
 	}
 
 	// Rewrite WHERE
@@ -173,17 +289,120 @@ func (r *BaseRewriter) rewriteSelect(s *ast.SelectStatement) *ast.SelectStatemen
 		ob.Expression = r.RewriteExpression(ob.Expression)
 	}
 
-	// Handle TOP -> LIMIT conversion (dialect-specific, called by subclass)
-	// This is a no-op in BaseRewriter; SQLiteRewriter overrides
+	if r.topToLimit {
+		convertTopToLimit(s)
+	}
+
+	// The other queries of a UNION, INTERSECT or EXCEPT
+	if s.Union != nil {
+		s.Union.Right = r.rewriteSelect(s.Union.Right)
+	}
 
 	return s
 }
 
+// convertTopToLimit moves a simple TOP n to LIMIT n. TOP PERCENT and
+// WITH TIES have no LIMIT equivalent and are left to fail with a clear
+// error from the backend.
+func convertTopToLimit(s *ast.SelectStatement) {
+	if s.Top == nil || s.Top.Percent || s.Top.WithTies {
+		return
+	}
+	s.Limit = s.Top.Count
+	s.Top = nil
+}
+
+// rewriteWith transforms the common table expressions of a WITH statement
+// and the query that uses them.
+func (r *BaseRewriter) rewriteWith(s *ast.WithStatement) *ast.WithStatement {
+	if s == nil {
+		return nil
+	}
+	for _, cte := range s.CTEs {
+		cte.Query = r.rewriteSelect(cte.Query)
+	}
+	s.Query = r.RewriteStatement(s.Query)
+	return s
+}
+
+// tableName drops the database and schema from a table name when the
+// dialect has no schemas, and requotes its delimited parts.
+func (r *BaseRewriter) tableName(name *ast.QualifiedIdentifier) *ast.QualifiedIdentifier {
+	if name != nil {
+		r.requote(name.Parts...)
+	}
+	if !r.stripSchemas || name == nil || len(name.Parts) < 2 || isCatalogName(name.Parts) {
+		return name
+	}
+	return &ast.QualifiedIdentifier{Parts: name.Parts[len(name.Parts)-1:]}
+}
+
+// isCatalogName reports whether a qualified name is under sys or
+// INFORMATION_SCHEMA, whose views the storage layer provides by those
+// names, so that their qualifiers must stay.
+func isCatalogName(parts []*ast.Identifier) bool {
+	for _, p := range parts[:len(parts)-1] {
+		if isCatalogSchema(p.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCatalogSchema reports whether name, possibly bracketed, is sys or
+// INFORMATION_SCHEMA.
+func isCatalogSchema(name string) bool {
+	switch strings.ToLower(strings.Trim(name, "[]")) {
+	case "sys", "information_schema":
+		return true
+	}
+	return false
+}
+
+// rewriteTableRef transforms a table reference, expanding table-valued
+// functions the target dialect lacks.
+func (r *BaseRewriter) rewriteTableRef(ref ast.TableReference) ast.TableReference {
+	switch t := ref.(type) {
+	case *ast.TableName:
+		t.Name = r.tableName(t.Name)
+		r.requote(t.Alias)
+		// Table hints only mean something to SQL Server
+		t.Hints = nil
+	case *ast.TableValuedFunction:
+		if t.Function != nil {
+			if handler, ok := r.tableFunctions[strings.ToUpper(t.Function.String())]; ok {
+				return handler(t)
+			}
+		}
+	case *ast.JoinClause:
+		t.Left = r.rewriteTableRef(t.Left)
+		t.Right = r.rewriteTableRef(t.Right)
+		t.Condition = r.RewriteExpression(t.Condition)
+	case *ast.ParenthesizedTableRef:
+		t.Inner = r.rewriteTableRef(t.Inner)
+	case *ast.DerivedTable:
+		t.Subquery = r.rewriteSelect(t.Subquery)
+		r.requote(t.Alias)
+		r.requote(t.ColumnAliases...)
+	}
+	return ref
+}
+
 // rewriteInsert transforms an INSERT statement.
 func (r *BaseRewriter) rewriteInsert(s *ast.InsertStatement) *ast.InsertStatement {
 	if s == nil {
 		return nil
 	}
+	s.Table = r.tableName(s.Table)
+	s.Hints = nil
+
+	// Graph pseudo-columns name the columns that store them
+	r.requote(s.Columns...)
+	for i, col := range s.Columns {
+		if id, ok := r.rewriteGraphColumn(nil, col).(*ast.Identifier); ok {
+			s.Columns[i] = id
+		}
+	}
 
 	// Rewrite VALUES expressions
 	for i, row := range s.Values {
@@ -205,9 +424,20 @@ func (r *BaseRewriter) rewriteUpdate(s *ast.UpdateStatement) *ast.UpdateStatemen
 	if s == nil {
 		return nil
 	}
+	s.Table = r.tableName(s.Table)
+	s.Hints = nil
+	defer r.enterScope(s.Table, s.Alias, s.From)()
+	if s.From != nil {
+		for i, ref := range s.From.Tables {
+			s.From.Tables[i] = r.rewriteTableRef(ref)
+		}
+	}
 
 	// Rewrite SET clauses
 	for _, set := range s.SetClauses {
+		if set.Column != nil {
+			r.requote(set.Column.Parts...)
+		}
 		set.Value = r.RewriteExpression(set.Value)
 	}
 
@@ -222,13 +452,46 @@ func (r *BaseRewriter) rewriteDelete(s *ast.DeleteStatement) *ast.DeleteStatemen
 	if s == nil {
 		return nil
 	}
+	s.Table = r.tableName(s.Table)
+	s.Hints = nil
+	defer r.enterScope(s.Table, s.Alias, s.From)()
+	if s.From != nil {
+		for i, ref := range s.From.Tables {
+			s.From.Tables[i] = r.rewriteTableRef(ref)
+		}
+	}
 
 	// Rewrite WHERE
 	s.Where = r.RewriteExpression(s.Where)
 
+	if r.rowID != "" {
+		r.convertDeleteTop(s)
+	}
+
 	return s
 }
 
+// convertDeleteTop turns DELETE TOP (n) FROM t WHERE ... into DELETE FROM
+// t WHERE rowid IN (SELECT rowid FROM t WHERE ... LIMIT n), as the
+// backends without TOP delete a limited number of rows. As in SQL Server,
+// which rows is unspecified. TOP PERCENT and deletes joining other tables
+// are left to fail.
+func (r *BaseRewriter) convertDeleteTop(s *ast.DeleteStatement) {
+	if s.Top == nil || s.Top.Percent || s.Top.WithTies || s.From != nil || s.Table == nil {
+		return
+	}
+	s.Where = &ast.InExpression{
+		Expr: &ast.Identifier{Value: r.rowID},
+		Subquery: &ast.SelectStatement{
+			Columns: []ast.SelectColumn{{Expression: &ast.Identifier{Value: r.rowID}}},
+			From:    &ast.FromClause{Tables: []ast.TableReference{&ast.TableName{Name: s.Table}}},
+			Where:   s.Where,
+			Limit:   s.Top.Count,
+		},
+	}
+	s.Top = nil
+}
+
 // rewriteCreateTable transforms a CREATE TABLE statement.
 func (r *BaseRewriter) rewriteCreateTable(s *ast.CreateTableStatement) *ast.CreateTableStatement {
 	if s == nil {
@@ -254,6 +517,8 @@ func (r *BaseRewriter) rewriteDataType(dt *ast.DataType) {
 	upperName := strings.ToUpper(dt.Name)
 	if mapped, ok := r.typeMappings[upperName]; ok {
 		dt.Name = mapped
+		// (MAX) is SQL Server's; the mapped types take no length for it
+		dt.Max = false
 	}
 }
 
@@ -320,6 +585,22 @@ func (r *BaseRewriter) rewriteFunctionCall(fc *ast.FunctionCall) ast.Expression
 		return nil
 	}
 
+	// dbo.fn(...) -> fn(...)
+	if q, ok := fc.Function.(*ast.QualifiedIdentifier); ok && r.stripSchemas &&
+		len(q.Parts) > 1 && !isCatalogName(q.Parts) {
+		fc.Function = q.Parts[len(q.Parts)-1]
+	}
+
+	// Window specification
+	if fc.Over != nil {
+		for i, expr := range fc.Over.PartitionBy {
+			fc.Over.PartitionBy[i] = r.RewriteExpression(expr)
+		}
+		for _, ob := range fc.Over.OrderBy {
+			ob.Expression = r.RewriteExpression(ob.Expression)
+		}
+	}
+
 	// Get function name
 	funcName := ""
 	if ident, ok := fc.Function.(*ast.Identifier); ok {
@@ -372,9 +653,26 @@ func (r *BaseRewriter) rewriteInfix(e *ast.InfixExpression) ast.Expression {
 	}
 	e.Left = r.RewriteExpression(e.Left)
 	e.Right = r.RewriteExpression(e.Right)
+	if r.concatOperator != "" && e.Operator == "+" &&
+		(r.isString(e.Left) || r.isString(e.Right)) {
+		e.Operator = r.concatOperator
+	}
 	return e
 }
 
+// isString reports whether an operand of + is known to be a string: a
+// literal, or a concatenation already rewritten. Without types, other
+// operands may be numbers and keep +.
+func (r *BaseRewriter) isString(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.StringLiteral:
+		return true
+	case *ast.InfixExpression:
+		return e.Operator == r.concatOperator
+	}
+	return false
+}
+
 // rewritePrefix transforms a prefix expression.
 func (r *BaseRewriter) rewritePrefix(e *ast.PrefixExpression) ast.Expression {
 	if e == nil {
@@ -390,8 +688,13 @@ func (r *BaseRewriter) rewriteCast(e *ast.CastExpression) ast.Expression {
 		return nil
 	}
 	e.Expression = r.RewriteExpression(e.Expression)
-	if e.TargetType != nil {
-		r.rewriteDataType(e.TargetType)
+	if e.TargetType == nil {
+		return e
+	}
+	typeName := e.TargetType.String()
+	r.rewriteDataType(e.TargetType)
+	if e.IsTry && r.tryCast != nil {
+		return r.tryCast(e, typeName)
 	}
 	return e
 }
@@ -402,22 +705,28 @@ func (r *BaseRewriter) rewriteConvert(e *ast.ConvertExpression) ast.Expression {
 		return nil
 	}
 
-	// Rewrite the value expression
-	e.Expression = r.RewriteExpression(e.Expression)
-
-	// Rewrite the data type
-	if e.TargetType != nil {
-		r.rewriteDataType(e.TargetType)
-	}
-
 	// Convert CONVERT(type, expr) to CAST(expr AS type)
 	// Note: This loses the style parameter, which is T-SQL specific
-	return &ast.CastExpression{
+	return r.rewriteCast(&ast.CastExpression{
 		Token:      e.Token,
 		Expression: e.Expression,
 		TargetType: e.TargetType,
 		IsTry:      e.IsTry,
+	})
+}
+
+// rewriteParse transforms a PARSE expression to CAST. The culture is
+// dropped: backends read values in their own format.
+func (r *BaseRewriter) rewriteParse(e *ast.ParseExpression) ast.Expression {
+	if e == nil {
+		return nil
 	}
+	return r.rewriteCast(&ast.CastExpression{
+		Token:      e.Token,
+		Expression: e.Expression,
+		TargetType: e.TargetType,
+		IsTry:      e.IsTry,
+	})
 }
 
 // rewriteCase transforms a CASE expression.
@@ -445,6 +754,17 @@ func (r *BaseRewriter) rewriteBetween(e *ast.BetweenExpression) ast.Expression {
 	return e
 }
 
+// rewriteLike transforms a LIKE expression.
+func (r *BaseRewriter) rewriteLike(e *ast.LikeExpression) ast.Expression {
+	if e == nil {
+		return nil
+	}
+	e.Expr = r.RewriteExpression(e.Expr)
+	e.Pattern = r.RewriteExpression(e.Pattern)
+	e.Escape = r.RewriteExpression(e.Escape)
+	return e
+}
+
 // rewriteIn transforms an IN expression.
 func (r *BaseRewriter) rewriteIn(e *ast.InExpression) ast.Expression {
 	if e == nil {
@@ -480,6 +800,147 @@ func (r *BaseRewriter) rewriteSubquery(e *ast.SubqueryExpression) ast.Expression
 	return e
 }
 
+// enterScope adds a statement's tables to the scope, returning the
+// function that removes them again.
+func (r *BaseRewriter) enterScope(table *ast.QualifiedIdentifier, alias *ast.Identifier, from *ast.FromClause) func() {
+	var bindings []tableBinding
+	if table != nil {
+		bindings = bindTables(bindings, &ast.TableName{Name: table, Alias: alias})
+	}
+	if from != nil {
+		for _, ref := range from.Tables {
+			bindings = bindTables(bindings, ref)
+		}
+	}
+	r.tableScopes = append(r.tableScopes, bindings)
+	return func() { r.tableScopes = r.tableScopes[:len(r.tableScopes)-1] }
+}
+
+// lookupTable finds the table a query refers to by name, searching the
+// innermost query first.
+func (r *BaseRewriter) lookupTable(name string) (tableBinding, bool) {
+	for s := len(r.tableScopes) - 1; s >= 0; s-- {
+		for _, b := range r.tableScopes[s] {
+			if strings.EqualFold(b.name, name) {
+				return b, true
+			}
+		}
+	}
+	return tableBinding{}, false
+}
+
+// qualifiedColumn returns the column of a table in scope, quoted when the
+// dialect needs it.
+func (r *BaseRewriter) qualifiedColumn(tok token.Token, table, column string) ast.Expression {
+	if r.quoteQualified {
+		tok = quotedToken(tok, '"')
+	}
+	return &ast.QualifiedIdentifier{Parts: []*ast.Identifier{
+		{Token: tok, Value: table}, {Token: tok, Value: column}}}
+}
+
+// requote writes the delimited identifiers among ids with the dialect's
+// delimiter; those written without one are left as they are.
+func (r *BaseRewriter) requote(ids ...*ast.Identifier) {
+	for _, id := range ids {
+		if id != nil && id.Token.Quote != 0 && r.identifierQuote != 0 {
+			id.Token.Quote = r.identifierQuote
+		}
+	}
+}
+
+// quotedToken returns tok delimited with quote, or, with quote 0, not
+// delimited, as for the SQL text a rewrite puts in an identifier's place.
+func quotedToken(tok token.Token, quote rune) token.Token {
+	tok.Quote = quote
+	return tok
+}
+
+// identifierQuote returns the delimiter of dialect's delimited
+// identifiers, as its rewriter writes them (0 = as written).
+func identifierQuote(dialect Dialect) rune {
+	switch dialect {
+	case DialectSQLite, DialectPostgres:
+		return '"'
+	case DialectMySQL:
+		return '`'
+	}
+	return 0
+}
+
+// rewriteStaticMethodCall transforms a static method call such as
+// geography::Point(...).
+func (r *BaseRewriter) rewriteStaticMethodCall(e *ast.StaticMethodCall) ast.Expression {
+	for i, arg := range e.Arguments {
+		e.Arguments[i] = r.RewriteExpression(arg)
+	}
+	if handler, ok := r.staticMethods[strings.ToUpper(e.TypeName+"::"+e.MethodName)]; ok {
+		return handler(e)
+	}
+	return e
+}
+
+// rewriteMethodCall transforms a method call or property of an instance.
+func (r *BaseRewriter) rewriteMethodCall(e *ast.MethodCallExpression) ast.Expression {
+	// dbo.fn(...) parses as a method of dbo; call fn(...) instead
+	if obj, ok := e.Object.(*ast.Identifier); ok && r.stripSchemas && e.Arguments != nil &&
+		strings.EqualFold(strings.Trim(obj.Value, "[]"), "dbo") {
+		return r.rewriteFunctionCall(&ast.FunctionCall{
+			Token:     e.Token,
+			Function:  &ast.Identifier{Token: e.Token, Value: e.MethodName},
+			Arguments: e.Arguments,
+		})
+	}
+	e.Object = r.RewriteExpression(e.Object)
+	for i, arg := range e.Arguments {
+		e.Arguments[i] = r.RewriteExpression(arg)
+	}
+	handlers := r.methodCalls
+	if e.Arguments == nil {
+		handlers = r.properties
+	}
+	if handler, ok := handlers[strings.ToUpper(e.MethodName)]; ok {
+		return handler(e)
+	}
+	return e
+}
+
+// rewriteQualifiedIdentifier transforms a qualified name: a column of a
+// table in scope, a graph pseudo-column such as p.$node_id, or a property
+// of a column such as loc.Lat or s.loc.Lat.
+func (r *BaseRewriter) rewriteQualifiedIdentifier(e *ast.QualifiedIdentifier) ast.Expression {
+	r.requote(e.Parts...)
+	if len(e.Parts) < 2 || len(e.Parts) > 3 {
+		return e
+	}
+	last := e.Parts[len(e.Parts)-1]
+	_, isTable := r.lookupTable(e.Parts[len(e.Parts)-2].Value)
+	if len(e.Parts) == 2 && strings.HasPrefix(last.Value, "$") {
+		return r.rewriteGraphColumn(e.Parts[0], last)
+	}
+	handler, ok := r.properties[strings.ToUpper(last.Value)]
+	// The part before a property must name a column, not a table:
+	// loc.Lat and s.loc.Lat read properties, t.Lat and dbo.t.Lat columns
+	if !ok || isTable {
+		if isTable {
+			table := e.Parts[len(e.Parts)-2]
+			if len(e.Parts) == 2 || r.stripSchemas {
+				return r.qualifiedColumn(table.Token, table.Value, last.Value)
+			}
+		}
+		// Any other qualifier names a schema the backend does not have
+		if r.stripSchemas && !isCatalogName(e.Parts) {
+			return last
+		}
+		return e
+	}
+	var object ast.Expression = e.Parts[0]
+	if len(e.Parts) == 3 {
+		object = &ast.QualifiedIdentifier{Parts: e.Parts[:2]}
+	}
+	return handler(&ast.MethodCallExpression{Token: last.Token, Object: object, MethodName: last.Value})
+}
+
 // -----------------------------------------------------------------------------
 // SQLiteRewriter - SQLite-specific transformations
 // -----------------------------------------------------------------------------
@@ -493,6 +954,12 @@ type SQLiteRewriter struct {
 func NewSQLiteRewriter() *SQLiteRewriter {
 	r := &SQLiteRewriter{}
 	r.dialect = DialectSQLite
+	r.quoteQualified = true
+	r.identifierQuote = '"'
+	r.stripSchemas = true
+	r.concatOperator = "||"
+	r.topToLimit = true
+	r.rowID = "rowid"
 
 	// Simple function renames (same arguments)
 	r.functionRenames = map[string]string{
@@ -500,6 +967,7 @@ func NewSQLiteRewriter() *SQLiteRewriter {
 		"LEN":        "LENGTH",
 		"DATALENGTH": "LENGTH",
 		"SUBSTRING":  "SUBSTR",
+		"NCHAR":      "CHAR",
 	}
 
 	// Parameterless function replacements
@@ -541,6 +1009,56 @@ func NewSQLiteRewriter() *SQLiteRewriter {
 		// Other functions
 		"ISNUMERIC": r.rewriteIsNumeric,
 		"CHOOSE":    r.rewriteChoose,
+		// Conditional functions
+		"IIF":      r.rewriteIIF,
+		"GREATEST": r.rewriteGreatest,
+		"LEAST":    r.rewriteLeast,
+		// String functions without a SQLite equivalent
+		"TRANSLATE":     r.rewriteTranslate,
+		"PATINDEX":      r.rewritePatIndex,
+		"PARSENAME":     r.rewriteParseName,
+		"QUOTENAME":     r.rewriteQuoteName,
+		"STRING_ESCAPE": r.rewriteStringEscape,
+	}
+
+	// Table-valued functions expanded inline
+	r.tableFunctions = map[string]func(*ast.TableValuedFunction) ast.TableReference{
+		"GENERATE_SERIES": r.rewriteGenerateSeries,
+		"AUL.CALENDAR":    r.rewriteCalendar,
+	}
+
+	// TRY_CAST, TRY_CONVERT and TRY_PARSE validate before casting
+	r.tryCast = r.rewriteTryCast
+
+	// geography and geometry instances are WKT text (see spatial.go)
+	r.staticMethods = map[string]func(*ast.StaticMethodCall) ast.Expression{
+		"GEOGRAPHY::POINT":           r.rewriteSpatialPoint,
+		"GEOMETRY::POINT":            r.rewriteSpatialPoint,
+		"GEOGRAPHY::STGEOMFROMTEXT":  r.rewriteSpatialFromText,
+		"GEOGRAPHY::STPOINTFROMTEXT": r.rewriteSpatialFromText,
+		"GEOGRAPHY::STLINEFROMTEXT":  r.rewriteSpatialFromText,
+		"GEOGRAPHY::STPOLYFROMTEXT":  r.rewriteSpatialFromText,
+		"GEOGRAPHY::PARSE":           r.rewriteSpatialFromText,
+		"GEOMETRY::STGEOMFROMTEXT":   r.rewriteSpatialFromText,
+		"GEOMETRY::STPOINTFROMTEXT":  r.rewriteSpatialFromText,
+		"GEOMETRY::STLINEFROMTEXT":   r.rewriteSpatialFromText,
+		"GEOMETRY::STPOLYFROMTEXT":   r.rewriteSpatialFromText,
+		"GEOMETRY::PARSE":            r.rewriteSpatialFromText,
+	}
+	r.methodCalls = map[string]func(*ast.MethodCallExpression) ast.Expression{
+		"STDISTANCE":   r.rewriteSTDistance,
+		"STWITHIN":     r.rewriteSTWithin,
+		"STCONTAINS":   r.rewriteSTWithin,
+		"STINTERSECTS": r.rewriteSTWithin,
+		"STASTEXT":     r.rewriteSTAsText,
+		"TOSTRING":     r.rewriteSTAsText,
+	}
+	r.properties = map[string]func(*ast.MethodCallExpression) ast.Expression{
+		"LAT":    r.rewriteSpatialCoordinate,
+		"LONG":   r.rewriteSpatialCoordinate,
+		"STX":    r.rewriteSpatialCoordinate,
+		"STY":    r.rewriteSpatialCoordinate,
+		"STSRID": r.rewriteSTSrid,
 	}
 
 	// Type mappings for DDL
@@ -576,6 +1094,8 @@ func NewSQLiteRewriter() *SQLiteRewriter {
 		"UNIQUEIDENTIFIER": "TEXT",
 		"XML":              "TEXT",
 		"SQL_VARIANT":      "TEXT",
+		"GEOGRAPHY":        "TEXT",
+		"GEOMETRY":         "TEXT",
 	}
 
 	return r
@@ -588,6 +1108,18 @@ func (r *SQLiteRewriter) rewriteCharIndex(fc *ast.FunctionCall) ast.Expression {
 		return fc
 	}
 
+	// CHARINDEX(a, b, start) searches the rest of b from start, a start
+	// below 1 counting as 1
+	if len(fc.Arguments) == 3 {
+		find, str := fc.Arguments[0].String(), fc.Arguments[1].String()
+		start := "MAX(" + fc.Arguments[2].String() + ", 1)"
+		found := "INSTR(SUBSTR(" + str + ", " + start + "), " + find + ")"
+		return &ast.Identifier{
+			Token: fc.Token,
+			Value: "(CASE " + found + " WHEN 0 THEN 0 ELSE " + found + " + " + start + " - 1 END)",
+		}
+	}
+
 	// Swap arguments: CHARINDEX(a, b) -> INSTR(b, a)
 	fc.Arguments[0], fc.Arguments[1] = fc.Arguments[1], fc.Arguments[0]
 
@@ -596,10 +1128,6 @@ func (r *SQLiteRewriter) rewriteCharIndex(fc *ast.FunctionCall) ast.Expression {
 		ident.Value = "INSTR"
 	}
 
-	// Note: CHARINDEX with 3 arguments (start position) is not directly
-	// translatable to SQLite INSTR. Would need SUBSTR + INSTR + offset math.
-	// For now, we handle the 2-argument case only.
-
 	return fc
 }
 
@@ -1174,70 +1702,11 @@ func (r *SQLiteRewriter) rewriteChoose(fc *ast.FunctionCall) ast.Expression {
 	}
 }
 
-// RewriteStatement for SQLite with TOP -> LIMIT handling.
-func (r *SQLiteRewriter) RewriteStatement(stmt ast.Statement) ast.Statement {
-	if stmt == nil {
-		return nil
-	}
-
-	// First do base rewriting
-	stmt = r.BaseRewriter.RewriteStatement(stmt)
-
-	// Then handle SQLite-specific TOP -> LIMIT conversion
-	if sel, ok := stmt.(*ast.SelectStatement); ok {
-		r.convertTopToLimit(sel)
-	}
-
-	return stmt
-}
-
 // RewriteExpression for SQLite.
 func (r *SQLiteRewriter) RewriteExpression(expr ast.Expression) ast.Expression {
 	return r.BaseRewriter.RewriteExpression(expr)
 }
 
-// convertTopToLimit converts TOP clause to LIMIT for SQLite.
-func (r *SQLiteRewriter) convertTopToLimit(s *ast.SelectStatement) {
-	if s == nil || s.Top == nil {
-		return
-	}
-
-	// Only convert simple TOP (not PERCENT, not WITH TIES)
-	if s.Top.Percent || s.Top.WithTies {
-		// Can't convert TOP PERCENT or WITH TIES to SQLite
-		// Leave as-is; will fail at execution time with clear error
-		return
-	}
-
-	// Move TOP count to Fetch (which becomes LIMIT in output)
-	// Note: SelectStatement.String() handles Fetch as "FETCH FIRST n ROWS ONLY"
-	// but SQLite expects "LIMIT n". We'll store in Fetch and handle in String().
-	//
-	// Actually, looking at the AST, there's no Limit field - there's Offset and Fetch.
-	// We need to store this information somewhere. For now, we'll leave Top
-	// and handle it in a custom String() method, OR we can nil out Top and
-	// set a special marker.
-	//
-	// The cleanest approach: keep Top but have the SQLite-aware serializer
-	// emit "LIMIT n" instead of "TOP n". But since we don't control String(),
-	// we'll use a workaround: convert to a LimitHint stored in a way the
-	// existing String() can output.
-	//
-	// Alternative: Create a wrapper that overrides String() behavior.
-	// For now, let's use the existing Fetch field which outputs OFFSET...FETCH
-	// syntax. But that's not quite right for SQLite either.
-	//
-	// DECISION: For v1, we'll mark this transformation was needed and handle
-	// it in the interpreter's SQL generation. The AST rewriter prepares
-	// the transformation by storing metadata, and the final SQL generation
-	// applies it.
-
-	// Store the limit value and clear Top
-	// The interpreter will check for this pattern
-	s.Fetch = s.Top.Count
-	s.Top = nil
-}
-
 // Dialect returns SQLite.
 func (r *SQLiteRewriter) Dialect() Dialect { return DialectSQLite }
 
@@ -1254,12 +1723,17 @@ type PostgresRewriter struct {
 func NewPostgresRewriter() *PostgresRewriter {
 	r := &PostgresRewriter{}
 	r.dialect = DialectPostgres
+	r.identifierQuote = '"'
+	r.topToLimit = true
+	r.rowID = "ctid"
 
 	// Simple function renames
 	r.functionRenames = map[string]string{
 		"ISNULL":     "COALESCE",
 		"LEN":        "LENGTH",
 		"DATALENGTH": "OCTET_LENGTH",
+		"UNICODE":    "ASCII", // code point in a UTF-8 database
+		"NCHAR":      "CHR",
 	}
 
 	// Parameterless function replacements
@@ -1273,9 +1747,23 @@ func NewPostgresRewriter() *PostgresRewriter {
 
 	// Special function handlers
 	r.specialFunctions = map[string]func(*ast.FunctionCall) ast.Expression{
-		"CHARINDEX": r.rewriteCharIndex,
+		"IIF":           r.rewriteIIF,
+		"CHARINDEX":     r.rewriteCharIndex,
+		"PATINDEX":      r.rewritePatIndex,
+		"PARSENAME":     r.rewriteParseName,
+		"QUOTENAME":     r.rewriteQuoteName,
+		"STRING_ESCAPE": r.rewriteStringEscape,
+	}
+
+	// Table-valued functions expanded inline
+	r.tableFunctions = map[string]func(*ast.TableValuedFunction) ast.TableReference{
+		"GENERATE_SERIES": r.rewriteGenerateSeries,
+		"AUL.CALENDAR":    r.rewriteCalendar,
 	}
 
+	// TRY_CAST, TRY_CONVERT and TRY_PARSE validate before casting
+	r.tryCast = r.rewriteTryCast
+
 	// Type mappings
 	r.typeMappings = map[string]string{
 		"DATETIME":       "TIMESTAMP",
@@ -1317,41 +1805,11 @@ func (r *PostgresRewriter) rewriteCharIndex(fc *ast.FunctionCall) ast.Expression
 	}
 }
 
-// RewriteStatement for PostgreSQL.
-func (r *PostgresRewriter) RewriteStatement(stmt ast.Statement) ast.Statement {
-	if stmt == nil {
-		return nil
-	}
-
-	stmt = r.BaseRewriter.RewriteStatement(stmt)
-
-	// PostgreSQL also uses LIMIT, not TOP
-	if sel, ok := stmt.(*ast.SelectStatement); ok {
-		r.convertTopToLimit(sel)
-	}
-
-	return stmt
-}
-
 // RewriteExpression for PostgreSQL.
 func (r *PostgresRewriter) RewriteExpression(expr ast.Expression) ast.Expression {
 	return r.BaseRewriter.RewriteExpression(expr)
 }
 
-// convertTopToLimit for PostgreSQL (same as SQLite).
-func (r *PostgresRewriter) convertTopToLimit(s *ast.SelectStatement) {
-	if s == nil || s.Top == nil {
-		return
-	}
-
-	if s.Top.Percent || s.Top.WithTies {
-		return
-	}
-
-	s.Fetch = s.Top.Count
-	s.Top = nil
-}
-
 // Dialect returns PostgreSQL.
 func (r *PostgresRewriter) Dialect() Dialect { return DialectPostgres }
 
@@ -1368,6 +1826,8 @@ type MySQLRewriter struct {
 func NewMySQLRewriter() *MySQLRewriter {
 	r := &MySQLRewriter{}
 	r.dialect = DialectMySQL
+	r.identifierQuote = '`'
+	r.topToLimit = true
 
 	// Simple function renames
 	r.functionRenames = map[string]string{
@@ -1386,6 +1846,31 @@ func NewMySQLRewriter() *MySQLRewriter {
 		"NEWID":          "UUID()",
 	}
 
+	// Special function handlers
+	r.specialFunctions = map[string]func(*ast.FunctionCall) ast.Expression{
+		"IIF":           r.rewriteIIF,
+		"GREATEST":      r.rewriteGreatest,
+		"LEAST":         r.rewriteLeast,
+		"TRANSLATE":     r.rewriteTranslate,
+		"PATINDEX":      r.rewritePatIndex,
+		"PARSENAME":     r.rewriteParseName,
+		"QUOTENAME":     r.rewriteQuoteName,
+		"STRING_ESCAPE": r.rewriteStringEscape,
+		"UNICODE":       r.rewriteUnicode,
+		"NCHAR":         r.rewriteNChar,
+		"LTRIM":         r.rewriteTrimChars("LEADING"),
+		"RTRIM":         r.rewriteTrimChars("TRAILING"),
+	}
+
+	// Table-valued functions expanded inline
+	r.tableFunctions = map[string]func(*ast.TableValuedFunction) ast.TableReference{
+		"GENERATE_SERIES": r.rewriteGenerateSeries,
+		"AUL.CALENDAR":    r.rewriteCalendar,
+	}
+
+	// TRY_CAST, TRY_CONVERT and TRY_PARSE validate before casting
+	r.tryCast = r.rewriteTryCast
+
 	// Type mappings
 	r.typeMappings = map[string]string{
 		"DATETIME2":        "DATETIME(6)",
@@ -1403,41 +1888,11 @@ func NewMySQLRewriter() *MySQLRewriter {
 	return r
 }
 
-// RewriteStatement for MySQL.
-func (r *MySQLRewriter) RewriteStatement(stmt ast.Statement) ast.Statement {
-	if stmt == nil {
-		return nil
-	}
-
-	stmt = r.BaseRewriter.RewriteStatement(stmt)
-
-	// MySQL also uses LIMIT, not TOP
-	if sel, ok := stmt.(*ast.SelectStatement); ok {
-		r.convertTopToLimit(sel)
-	}
-
-	return stmt
-}
-
 // RewriteExpression for MySQL.
 func (r *MySQLRewriter) RewriteExpression(expr ast.Expression) ast.Expression {
 	return r.BaseRewriter.RewriteExpression(expr)
 }
 
-// convertTopToLimit for MySQL.
-func (r *MySQLRewriter) convertTopToLimit(s *ast.SelectStatement) {
-	if s == nil || s.Top == nil {
-		return
-	}
-
-	if s.Top.Percent || s.Top.WithTies {
-		return
-	}
-
-	s.Fetch = s.Top.Count
-	s.Top = nil
-}
-
 // Dialect returns MySQL.
 func (r *MySQLRewriter) Dialect() Dialect { return DialectMySQL }
 
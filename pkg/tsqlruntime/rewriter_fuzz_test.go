@@ -0,0 +1,76 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// rewriteTimeout bounds a single rewrite so that a hang is reported as a
+// failure instead of stalling the fuzzer.
+const rewriteTimeout = 2 * time.Second
+
+var fuzzDialects = []Dialect{DialectSQLite, DialectPostgres, DialectMySQL, DialectSQLServer}
+
+// FuzzRewriters checks that the AST rewriter and string normaliser for
+// every dialect accept anything the parser accepts without panicking.
+func FuzzRewriters(f *testing.F) {
+	for _, s := range []string{
+		"SELECT ISNULL(a, 'x'), LEN(b), GETDATE() FROM dbo.t WITH (NOLOCK)",
+		"SELECT TOP 5 CHARINDEX('a', s), LEFT(s, 2), RIGHT(s, 2) FROM t ORDER BY 1",
+		"SELECT CAST(a AS NVARCHAR(MAX)), CONVERT(VARCHAR(10), d, 120) FROM t",
+		"SELECT DATEPART(year, d), DATEADD(day, 1, d), DATEDIFF(day, a, b) FROM t",
+		"SELECT CEILING(x), FLOOR(x), POWER(x, 2), x + 'y' FROM t WHERE a BETWEEN 1 AND 2",
+		"INSERT INTO t (a) SELECT a FROM u WHERE b IN (1, 2) AND c IS NOT NULL",
+		"UPDATE t SET a = IIF(b > 0, 1, 0) WHERE EXISTS (SELECT 1 FROM u)",
+		"DELETE FROM t WHERE a = (SELECT MAX(a) FROM t)",
+		"CREATE TABLE #t (id INT IDENTITY(1,1) PRIMARY KEY, v NVARCHAR(50), d DATETIME2)",
+		"DECLARE @x INT = 1; IF @x = 1 BEGIN SET @x = @x + 1 END ELSE WHILE @x < 5 SET @x += 1",
+		"SELECT CASE WHEN a = 1 THEN 'x' ELSE NEWID() END FROM t",
+	} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		done := make(chan string, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					done <- fmt.Sprintf("%v\n%s", r, debug.Stack())
+					return
+				}
+				done <- ""
+			}()
+
+			for _, d := range fuzzDialects {
+				NewSQLNormalizer(d).Normalize(input)
+			}
+
+			p := parser.New(lexer.New(input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				return
+			}
+			for _, d := range fuzzDialects {
+				rw := NewASTRewriterForDialect(d)
+				for _, stmt := range program.Statements {
+					if out := rw.RewriteStatement(stmt); out != nil {
+						_ = out.String()
+					}
+				}
+			}
+		}()
+		select {
+		case msg := <-done:
+			if msg != "" {
+				t.Fatalf("panic rewriting %q: %s", input, msg)
+			}
+		case <-time.After(rewriteTimeout):
+			t.Fatalf("rewrite of %q did not finish within %v", input, rewriteTimeout)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package tsqlruntime
 
 import (
+	"context"
 	"strings"
 	"testing"
 
@@ -121,6 +122,13 @@ func TestSQLiteRewriter_CHARINDEX(t *testing.T) {
 	if !strings.Contains(output, "INSTR(name, 'x')") {
 		t.Errorf("Expected swapped arguments INSTR(name, 'x'), got: %s", output)
 	}
+
+	// A start position searches from there, counting from the start
+	stmt = parseSQL(t, "SELECT CHARINDEX(',', list, 3) FROM t")
+	output = rewriter.RewriteStatement(stmt).String()
+	if !strings.Contains(output, "INSTR(SUBSTR(list, MAX(3, 1)), ',')") || !strings.Contains(output, "+ MAX(3, 1) - 1") {
+		t.Errorf("Expected INSTR over SUBSTR from the start position, got: %s", output)
+	}
 }
 
 func TestSQLiteRewriter_TopToLimit(t *testing.T) {
@@ -138,12 +146,15 @@ func TestSQLiteRewriter_TopToLimit(t *testing.T) {
 	rewritten := rewriter.RewriteStatement(stmt)
 	selOut := rewritten.(*ast.SelectStatement)
 
-	// After rewriting, Top should be nil and Fetch should have the count
+	// After rewriting, Top should be nil and Limit should have the count
 	if selOut.Top != nil {
 		t.Error("TOP should be removed after rewriting")
 	}
-	if selOut.Fetch == nil {
-		t.Error("Fetch should be set after TOP->LIMIT conversion")
+	if selOut.Limit == nil {
+		t.Error("Limit should be set after TOP->LIMIT conversion")
+	}
+	if output := selOut.String(); !strings.HasSuffix(output, " LIMIT 10") {
+		t.Errorf("got %s, want it to end with LIMIT 10", output)
 	}
 }
 
@@ -186,6 +197,11 @@ func TestSQLiteRewriter_TypeMappings(t *testing.T) {
 			input:    "CREATE TABLE #t (b BIT)",
 			contains: "INTEGER",
 		},
+		{
+			name:     "NVARCHAR(MAX) cast to TEXT",
+			input:    "SELECT CAST(data AS NVARCHAR(MAX)) FROM t",
+			contains: "AS TEXT)",
+		},
 	}
 
 	for _, tc := range tests {
@@ -624,3 +640,93 @@ func TestSQLiteRewriter_OtherFunctions(t *testing.T) {
 		})
 	}
 }
+
+// The translations the string normaliser used to make, now on the AST.
+func TestSQLiteRewriter_SchemasHintsConcat(t *testing.T) {
+	rewriter := NewSQLiteRewriter()
+
+	tests := []struct {
+		name     string
+		input    string
+		contains string
+		excludes string
+	}{
+		{"two-part table", "SELECT id FROM dbo.orders", "FROM orders", "dbo"},
+		{"three-part table", "SELECT id FROM shop.dbo.orders", "FROM orders", "dbo"},
+		{"bracketed table", "SELECT id FROM [dbo].[orders]", `FROM "orders"`, "dbo"},
+		{"joined table", "SELECT o.id FROM dbo.orders o JOIN dbo.lines l ON l.order_id = o.id", "JOIN lines", "dbo"},
+		{"catalog view kept", "SELECT name FROM sys.tables", "sys.tables", ""},
+		{"column through the schema", "SELECT dbo.orders.id FROM dbo.orders", `"orders"."id"`, "dbo"},
+		{"insert target", "INSERT INTO dbo.orders (id) VALUES (1)", "INTO orders", "dbo"},
+		{"update target", "UPDATE dbo.orders SET qty = 1", "UPDATE orders", "dbo"},
+		{"delete target", "DELETE FROM dbo.orders WHERE id = 1", "orders", "dbo"},
+		{"function schema", "SELECT dbo.total(id) FROM orders", "total(id)", "dbo"},
+		{"table hint", "SELECT id FROM orders WITH (NOLOCK)", "FROM orders", "NOLOCK"},
+		{"string concatenation", "SELECT 'a' + name FROM t", "('a' || name)", "+"},
+		{"chained concatenation", "SELECT name + ', ' + city FROM t", "((name || ', ') || city)", "+"},
+		{"numeric addition kept", "SELECT qty + 1 FROM t", "(qty + 1)", "||"},
+		{"top in insert select", "INSERT INTO t SELECT TOP 5 id FROM u", "LIMIT 5", "TOP"},
+		{"top in subquery", "SELECT * FROM t WHERE id IN (SELECT TOP 1 id FROM u)", "LIMIT 1)", "TOP"},
+		{"delete top", "DELETE TOP (10) FROM dbo.logs WHERE id < 5", "WHERE rowid IN (SELECT rowid FROM logs WHERE (id < 5) LIMIT 10)", "TOP"},
+		{"cte", "WITH c AS (SELECT LEN(name) AS n FROM dbo.t) SELECT TOP 3 n FROM c", "LENGTH(name)", "dbo"},
+		{"union arm", "SELECT LEN(a) FROM t UNION SELECT LEN(b) FROM u", "LENGTH(b)", "LEN("},
+		{"window", "SELECT ROW_NUMBER() OVER (PARTITION BY dbo.t.g ORDER BY id) FROM dbo.t", `PARTITION BY "t"."g"`, "dbo"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			output := rewriter.RewriteStatement(parseSQL(t, tc.input)).String()
+			if !strings.Contains(output, tc.contains) {
+				t.Errorf("Expected output to contain %q, got: %s", tc.contains, output)
+			}
+			if tc.excludes != "" && strings.Contains(output, tc.excludes) {
+				t.Errorf("Expected output to NOT contain %q, got: %s", tc.excludes, output)
+			}
+		})
+	}
+}
+
+func TestSQLiteRewriter_Execute(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	const setup = "CREATE TABLE people (id INT, name VARCHAR(20)); " +
+		"INSERT INTO dbo.people VALUES (1, 'Ada'), (2, 'Grace'), (3, 'Edsger'); "
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT TOP 2 'Dr ' + name FROM dbo.people WITH (NOLOCK) ORDER BY id", []string{"Dr Ada", "Dr Grace"}},
+		{"WITH p AS (SELECT TOP 1 id, name FROM dbo.people ORDER BY id DESC) SELECT name FROM p", []string{"Edsger"}},
+		{"SELECT dbo.people.name FROM dbo.people WHERE id IN (SELECT TOP 1 id FROM people ORDER BY id)", []string{"Ada"}},
+		// Deleting in batches until one finds no rows
+		{"DECLARE @more INT = 1; WHILE @more = 1 BEGIN DELETE TOP (1) FROM dbo.people WHERE id > 1; " +
+			"IF @@ROWCOUNT = 0 SET @more = 0 END; SELECT name FROM people", []string{"Ada"}},
+	}
+	for _, tc := range tests {
+		result, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(), setup+tc.query, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		if got := lastRows(result); strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%s: got %v, want %v", tc.query, got, tc.want)
+		}
+		db.Exec("DROP TABLE people")
+	}
+}
+
+func TestLegacyNormalizer(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.LegacyNormalizer = true
+	result, err := interp.Execute(context.Background(),
+		"CREATE TABLE t (name VARCHAR(10)); INSERT INTO dbo.t VALUES ('x'); SELECT 'a' + name FROM dbo.t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); len(got) != 1 || got[0] != "ax" {
+		t.Errorf("got %v, want [ax]", got)
+	}
+}
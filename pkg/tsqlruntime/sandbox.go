@@ -0,0 +1,183 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// A sandbox denies statements capabilities by policy: running dynamic
+// SQL, calling out of the server, changing the schema and reaching other
+// databases. A statement is checked against the policies of the procedure
+// running it, the innermost of the call chain, with dynamic SQL counted
+// as its caller's; the statements of an ad-hoc batch are checked against
+// those that apply to any batch. A denied statement fails with error
+// 50414, which TRY...CATCH can catch, before it does anything.
+
+// Capabilities a sandbox may deny.
+const (
+	CapabilityDynamicSQL    = "dynamic_sql"    // EXEC() and sp_executesql
+	CapabilityExternal      = "external"       // sp_execute_script, sp_invoke_external_rest_endpoint, sp_send_dbmail and xp_ procedures
+	CapabilityDDL           = "ddl"            // CREATE, ALTER, DROP, TRUNCATE, GRANT, DENY and REVOKE, except of temp tables
+	CapabilityCrossDatabase = "cross_database" // Names qualified by a database other than the current one
+)
+
+// Capabilities lists the capabilities a sandbox may deny.
+var Capabilities = []string{CapabilityDynamicSQL, CapabilityExternal, CapabilityDDL, CapabilityCrossDatabase}
+
+// Sandbox decides which capabilities statements are denied.
+type Sandbox interface {
+	// Denied returns the name of the policy denying capability to the
+	// statements of procedure, as it was called, or of an ad-hoc batch
+	// when procedure is "", and the message it gives; ok is false when
+	// none does.
+	Denied(capability, procedure string) (policy, message string, ok bool)
+}
+
+// SetSandbox sets the policies the execution's statements are checked
+// against.
+func (i *Interpreter) SetSandbox(s Sandbox) {
+	i.ctx.Sandbox = s
+}
+
+// dynamicScopes are the scopes of newScope that run SQL for the caller,
+// rather than a procedure of their own.
+var dynamicScopes = map[string]bool{"EXEC()": true, "sp_executesql": true, "sp_send_dbmail": true}
+
+// sandboxProcedure returns the procedure whose policies the statements i
+// runs are checked against, or "" for an ad-hoc batch.
+func (i *Interpreter) sandboxProcedure() string {
+	for j := len(i.callChain) - 1; j >= 0; j-- {
+		if !dynamicScopes[i.callChain[j]] {
+			return i.callChain[j]
+		}
+	}
+	return ""
+}
+
+// checkSandbox returns error 50414 if the sandbox denies capability to
+// the statements i runs. what names the statement, or the part of it,
+// that takes the capability.
+func (i *Interpreter) checkSandbox(capability, what string) error {
+	if i.ctx.Sandbox == nil {
+		return nil
+	}
+	proc := i.sandboxProcedure()
+	policy, message, denied := i.ctx.Sandbox.Denied(capability, proc)
+	if !denied {
+		return nil
+	}
+	by := "the batch"
+	if proc != "" {
+		by = fmt.Sprintf("procedure '%s'", proc)
+	}
+	msg := fmt.Sprintf("%s is denied to %s by sandbox policy '%s' (%s).", what, by, policy, capability)
+	if message != "" {
+		msg += " " + message
+	}
+	return NewSQLError(ErrSandboxDenied, msg)
+}
+
+// checkSandboxStatement returns error 50414 if stmt is DDL, or names
+// another database, and the sandbox denies it that.
+func (i *Interpreter) checkSandboxStatement(stmt ast.Statement) error {
+	if i.ctx.Sandbox == nil {
+		return nil
+	}
+	if kind := ddlStatement(stmt); kind != "" {
+		if err := i.checkSandbox(CapabilityDDL, kind); err != nil {
+			return err
+		}
+	}
+	if database := i.otherDatabase(stmt); database != "" {
+		return i.checkSandbox(CapabilityCrossDatabase, fmt.Sprintf("Access to database '%s'", database))
+	}
+	return nil
+}
+
+// ddlPrefixes begin the names of the parser's DDL statement types.
+var ddlPrefixes = []string{"Create", "Alter", "Drop", "Truncate", "Grant", "Revoke", "Deny", "AddSensitivity", "UpdateStatistics"}
+
+// ddlStatement returns the kind of DDL stmt is, such as "CREATE TABLE",
+// or "" if it is not DDL or only creates, alters or drops temp tables.
+// CREATE PROCEDURE is not: the interpreter runs the procedure it is the
+// source of.
+func ddlStatement(stmt ast.Statement) string {
+	if _, ok := stmt.(*ast.CreateProcedureStatement); ok {
+		return ""
+	}
+	name := strings.TrimPrefix(reflect.TypeOf(stmt).String(), "*ast.")
+	ddl := false
+	for _, prefix := range ddlPrefixes {
+		ddl = ddl || strings.HasPrefix(name, prefix)
+	}
+	if !ddl {
+		return ""
+	}
+	if kind, targets := statementWrite(stmt); len(targets) > 0 {
+		for _, t := range targets {
+			if !isTransientTable(t) {
+				return kind
+			}
+		}
+		return ""
+	}
+	return statementFeature(stmt)
+}
+
+// otherDatabase returns the first database other than the current one
+// that stmt names a table, view or procedure in, or "". The statements of
+// blocks, and of IF and WHILE, are left to be checked as they run.
+func (i *Interpreter) otherDatabase(stmt ast.Statement) string {
+	var names []*ast.QualifiedIdentifier
+	var root interface{} = stmt
+	switch s := stmt.(type) {
+	case *ast.IfStatement:
+		root = s.Condition
+	case *ast.WhileStatement:
+		root = s.Condition
+	case *ast.BeginEndBlock, *ast.TryCatchStatement, *ast.CreateProcedureStatement:
+		return ""
+	case *ast.ExecStatement:
+		names = append(names, s.Procedure)
+		root = s.Parameters
+	}
+	walkNodes(reflect.ValueOf(root), func(node interface{}) {
+		switch n := node.(type) {
+		case *ast.TableName:
+			names = append(names, n.Name)
+		case *ast.InsertStatement:
+			names = append(names, n.Table)
+		case *ast.UpdateStatement:
+			names = append(names, n.Table)
+		case *ast.DeleteStatement:
+			names = append(names, n.Table)
+		case *ast.MergeStatement:
+			names = append(names, n.Target)
+		}
+	})
+	for _, name := range names {
+		if name == nil || len(name.Parts) < 3 {
+			continue
+		}
+		parts := name.Parts[len(name.Parts)-3:]
+		database := unbracket(parts[0].Value)
+		if database == "" || isTransientTable(unbracket(parts[2].Value)) || strings.EqualFold(database, i.database) {
+			continue
+		}
+		return database
+	}
+	return ""
+}
+
+// externalProcedure reports whether name, an upper-case system procedure
+// name, calls out of the server.
+func externalProcedure(name string) bool {
+	switch name {
+	case "SP_EXECUTE_SCRIPT", "SP_INVOKE_EXTERNAL_REST_ENDPOINT", "SP_SEND_DBMAIL":
+		return true
+	}
+	return strings.HasPrefix(name, "XP_")
+}
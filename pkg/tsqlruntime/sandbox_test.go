@@ -0,0 +1,112 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testSandbox denies the capabilities it lists for each procedure, or for
+// ad-hoc batches under "".
+type testSandbox map[string][]string
+
+func (s testSandbox) Denied(capability, procedure string) (string, string, bool) {
+	for _, c := range s[strings.ToLower(procedure)] {
+		if c == capability {
+			return "test", "Ask the DBA.", true
+		}
+	}
+	return "", "", false
+}
+
+func TestSandbox(t *testing.T) {
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.Locked", `
+		CREATE PROCEDURE dbo.Locked
+		AS
+		BEGIN
+			CREATE TABLE #work (id INT)
+			INSERT INTO #work VALUES (1)
+			SELECT COUNT(*) AS n FROM #work
+		END
+	`, nil)
+	resolver.AddProcedure("dbo.Dynamic", `
+		CREATE PROCEDURE dbo.Dynamic
+		AS
+		BEGIN
+			EXEC('SELECT 1 AS n')
+		END
+	`, nil)
+	resolver.AddProcedure("dbo.Open", `
+		CREATE PROCEDURE dbo.Open
+		AS
+		BEGIN
+			EXEC('EXEC dbo.Dynamic')
+		END
+	`, nil)
+	interp := scopeSetup(t, resolver)
+	interp.SetSandbox(testSandbox{
+		"dbo.locked":  {CapabilityDDL, CapabilityDynamicSQL, CapabilityExternal, CapabilityCrossDatabase},
+		"dbo.dynamic": {CapabilityDynamicSQL},
+		"":            {CapabilityCrossDatabase},
+	})
+	ctx := context.Background()
+
+	// Temp tables are not DDL a sandbox denies, and the batch has no
+	// policy against dynamic SQL
+	for _, sql := range []string{"EXEC dbo.Locked", "EXEC('SELECT 1 AS n')", "SELECT 1 AS n FROM testdb.dbo.sqlite_master WHERE 1 = 0"} {
+		if _, err := interp.Execute(ctx, sql, nil); err != nil {
+			t.Errorf("%s: %v", sql, err)
+		}
+	}
+
+	// A procedure's policies cover the dynamic SQL it runs, but not the
+	// procedures that runs
+	_, err := interp.Execute(ctx, "EXEC dbo.Open", nil)
+	wantSQLError(t, "EXEC dbo.Open", err, ErrSandboxDenied)
+	if err == nil || !strings.Contains(err.Error(), "Dynamic SQL is denied to procedure 'dbo.Dynamic' by sandbox policy 'test' (dynamic_sql). Ask the DBA.") {
+		t.Errorf("got %v", err)
+	}
+
+	for _, tc := range []struct {
+		source string
+		want   string
+	}{
+		{"CREATE TABLE notes (id INT)", "CREATE TABLE is denied"},
+		{"DROP VIEW v", "DROP VIEW is denied"},
+		{"EXEC sp_executesql N'SELECT 1'", "Dynamic SQL is denied"},
+		{"EXEC sp_execute_script N'lua', N'return 0'", "sp_execute_script is denied"},
+		{"EXEC master..xp_cmdshell 'dir'", "Access to database 'master' is denied"},
+		{"IF EXISTS (SELECT 1 FROM [other].dbo.orders) PRINT 'x'", "Access to database 'other' is denied"},
+	} {
+		resolver.AddProcedure("dbo.Locked", "CREATE PROCEDURE dbo.Locked AS BEGIN "+tc.source+" END", nil)
+		_, err := interp.Execute(ctx, "EXEC dbo.Locked", nil)
+		wantSQLError(t, tc.source, err, ErrSandboxDenied)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want %q", tc.source, err, tc.want)
+		}
+	}
+
+	// Ad-hoc batches have policies of their own
+	_, err = interp.Execute(ctx, "SELECT * FROM other.dbo.orders", nil)
+	wantSQLError(t, "cross-database batch", err, ErrSandboxDenied)
+
+	// The error can be caught, before the statement has done anything
+	resolver.AddProcedure("dbo.Locked", `
+		CREATE PROCEDURE dbo.Locked AS
+		BEGIN TRY
+			CREATE TABLE notes (id INT)
+		END TRY
+		BEGIN CATCH
+			SELECT 'caught' AS n
+		END CATCH
+	`, nil)
+	result, err := interp.Execute(ctx, "EXEC dbo.Locked", nil)
+	if err != nil || !reflect.DeepEqual(lastRows(result), []string{"caught"}) {
+		t.Errorf("caught: %v, %v", result, err)
+	}
+	if _, err := interp.Execute(ctx, "SELECT * FROM notes", nil); err == nil {
+		t.Error("the denied CREATE TABLE created notes")
+	}
+}
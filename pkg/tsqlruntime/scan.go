@@ -0,0 +1,47 @@
+package tsqlruntime
+
+import "database/sql"
+
+// scanBlockRows is how many rows' worth of values a rowScanner allocates
+// at a time.
+const scanBlockRows = 64
+
+// rowScanner converts the rows of a query into Values. One set of scan
+// destinations serves the whole result, and rows are carved out of
+// shared blocks rather than allocated one at a time.
+type rowScanner struct {
+	values []interface{}
+	ptrs   []interface{}
+	block  []Value
+}
+
+func newRowScanner(columns int) *rowScanner {
+	s := &rowScanner{
+		values: make([]interface{}, columns),
+		ptrs:   make([]interface{}, columns),
+	}
+	for j := range s.values {
+		s.ptrs[j] = &s.values[j]
+	}
+	return s
+}
+
+// scan reads the current row. The row returned is the caller's to keep:
+// database/sql copies driver bytes into an interface{} destination, so
+// reusing the destinations is safe.
+func (s *rowScanner) scan(rows *sql.Rows) ([]Value, error) {
+	if err := rows.Scan(s.ptrs...); err != nil {
+		return nil, err
+	}
+	n := len(s.values)
+	if len(s.block) < n {
+		s.block = make([]Value, n*scanBlockRows)
+	}
+	row := s.block[:n:n]
+	s.block = s.block[n:]
+	for j, v := range s.values {
+		row[j] = ToValue(v)
+		s.values[j] = nil
+	}
+	return row, nil
+}
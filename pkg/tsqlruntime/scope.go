@@ -0,0 +1,111 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// Variables belong to the batch or procedure that declares them, as in
+// SQL Server. A procedure sees its parameters and its own DECLAREs but
+// none of its caller's variables, dynamic SQL sees none of the variables
+// of the code that runs it, and BEGIN...END opens no new scope.
+//
+// Each procedure call and each piece of dynamic SQL runs in a scope of
+// its own: a child interpreter whose evaluator holds the local variables.
+// The ExecutionContext stays shared, since it holds the session's state
+// (transactions, temp tables, cursors, result sets and the @@ variables),
+// except for the RETURN state, which belongs to the call and is restored
+// for the caller when the call ends. OUTPUT parameters are copied back to
+// the caller's variables after the call returns.
+
+// newScope returns an interpreter for a call to name one level deeper
+// than i, sharing i's session but none of its variables.
+func (i *Interpreter) newScope(name string) *Interpreter {
+	child := NewInterpreterWithContext(i.ctx)
+	child.resolver = i.resolver
+	child.database = i.database
+	child.SetNestingLevel(i.nestingLevel + 1)
+	child.maxNesting = i.maxNesting
+	child.callChain = append(slices.Clip(i.callChain), name)
+	child.Debug = i.Debug
+	child.LogRewritten = i.LogRewritten
+	child.LegacyNormalizer = i.LegacyNormalizer
+	child.AutoParameterize = i.AutoParameterize
+	child.LogFunc = i.LogFunc
+	return child
+}
+
+// runScope executes source in child, a scope made by newScope. A RETURN
+// in the call ends the call, not the caller.
+func (i *Interpreter) runScope(ctx context.Context, child *Interpreter, source string) (*ExecutionResult, error) {
+	returned, returnValue := i.ctx.HasReturned, i.ctx.ReturnValue
+	i.ctx.HasReturned, i.ctx.ReturnValue = false, nil
+	defer func() {
+		i.ctx.HasReturned, i.ctx.ReturnValue = returned, returnValue
+	}()
+	return child.Execute(ctx, source, nil)
+}
+
+// isSystemVariable reports whether name is an @@ variable, which belongs
+// to the session rather than to a scope.
+func isSystemVariable(name string) bool {
+	return strings.HasPrefix(name, "@@")
+}
+
+// outputTarget returns the name of the caller's variable an OUTPUT
+// argument writes back to, or "" if the argument is not a variable.
+func outputTarget(expr ast.Expression) string {
+	switch v := expr.(type) {
+	case *ast.Variable:
+		return v.Name
+	case *ast.Identifier:
+		if strings.HasPrefix(v.Value, "@") {
+			return v.Value
+		}
+	}
+	return ""
+}
+
+// SetMaxNestingLevel sets how deeply procedure calls and dynamic SQL may
+// nest. A limit that is not positive restores MaxNestingLevel.
+func (i *Interpreter) SetMaxNestingLevel(limit int) {
+	i.maxNesting = limit
+}
+
+// SetCallChain sets the procedures the interpreter is running inside,
+// outermost first, for the error reporting an exceeded nesting limit.
+func (i *Interpreter) SetCallChain(names ...string) {
+	i.callChain = names
+}
+
+func (i *Interpreter) maxNestingLevel() int {
+	if i.maxNesting <= 0 {
+		return MaxNestingLevel
+	}
+	return i.maxNesting
+}
+
+// checkNesting returns error 217 if calling name from i would nest too
+// deeply. The message lists the whole call chain, so that runaway
+// recursion can be traced to the procedures taking part in it.
+func (i *Interpreter) checkNesting(name string) error {
+	limit := i.maxNestingLevel()
+	if i.nestingLevel < limit {
+		return nil
+	}
+	chain := append(slices.Clip(i.callChain), name)
+	return NewSQLError(ErrNestingLimit, fmt.Sprintf(
+		"maximum procedure nesting level (%d) exceeded; call chain: %s",
+		limit, strings.Join(chain, " -> ")))
+}
+
+// isNestingLimit reports whether err is the error of checkNesting.
+func isNestingLimit(err error) bool {
+	var sqlErr *SQLError
+	return errors.As(err, &sqlErr) && sqlErr.Number == ErrNestingLimit
+}
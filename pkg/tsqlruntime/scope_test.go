@@ -0,0 +1,237 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scopeSetup returns an interpreter that resolves the procedures of
+// resolver.
+func scopeSetup(t *testing.T, resolver *mockResolver) *Interpreter {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetResolver(resolver)
+	interp.SetDatabase("testdb")
+	return interp
+}
+
+func TestScope_ProcedureVariables(t *testing.T) {
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.Inner", `
+		CREATE PROCEDURE dbo.Inner @x INT, @out INT OUTPUT
+		AS
+		BEGIN
+			DECLARE @local INT = 100
+			SET @x = @x + 1
+			SET @out = @x * 10
+			SELECT @caller AS caller
+			RETURN 7
+		END
+	`, []ProcedureParam{{Name: "x"}, {Name: "out", IsOutput: true}})
+
+	interp := scopeSetup(t, resolver)
+	result, err := interp.Execute(context.Background(), `
+		DECLARE @x INT = 1, @local INT = 5, @caller INT = 9, @out INT, @rc INT
+		EXEC @rc = dbo.Inner @x, @out OUTPUT
+		SELECT @x, @local, @out, @rc
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.ResultSets) != 2 {
+		t.Fatalf("got %d result sets, want 2: a RETURN in the procedure must not end the caller", len(result.ResultSets))
+	}
+	if got := result.ResultSets[0].Rows[0][0]; !got.IsNull {
+		t.Errorf("procedure saw caller's @caller = %v, want NULL", got)
+	}
+	if got, want := lastRows(result), []string{"1 5 20 7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if result.ReturnValue != nil {
+		t.Errorf("batch return value = %d, want none", *result.ReturnValue)
+	}
+}
+
+func TestScope_GetVariableAfterOutput(t *testing.T) {
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.SetOut", `
+		CREATE PROCEDURE dbo.SetOut @out INT OUTPUT
+		AS
+		BEGIN
+			SET @out = 42
+		END
+	`, []ProcedureParam{{Name: "out", IsOutput: true}})
+
+	interp := scopeSetup(t, resolver)
+	interp.SetVariable("@total", 1)
+	if _, err := interp.Execute(context.Background(), "SET @total = @total + 1; EXEC dbo.SetOut @result OUTPUT", map[string]interface{}{"@result": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]int64{"@total": 2, "@result": 42} {
+		if got, ok := interp.GetVariable(name); !ok || got != want {
+			t.Errorf("%s = %v, want %d", name, got, want)
+		}
+	}
+}
+
+func TestScope_ReturnCodeDefault(t *testing.T) {
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.NoReturn", "CREATE PROCEDURE dbo.NoReturn AS BEGIN PRINT 'x' END", nil)
+
+	interp := scopeSetup(t, resolver)
+	result, err := interp.Execute(context.Background(), "DECLARE @rc INT = -1; EXEC @rc = dbo.NoReturn; SELECT @rc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lastRows(result), []string{"0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestScope_Recursion recurses to the nesting limit, each level checking
+// that its own variables survive the call beneath it.
+func TestScope_Recursion(t *testing.T) {
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.Countdown", `
+		CREATE PROCEDURE dbo.Countdown @n INT, @depth INT OUTPUT
+		AS
+		BEGIN
+			DECLARE @mine INT = @n
+			DECLARE @rc INT
+			SET @depth = @depth + 1
+			IF @n > 1
+			BEGIN
+				DECLARE @next INT = @n - 1
+				EXEC @rc = dbo.Countdown @next, @depth OUTPUT
+				IF @rc <> @next OR @mine <> @n
+					RETURN -1
+			END
+			RETURN @mine
+		END
+	`, []ProcedureParam{{Name: "n"}, {Name: "depth", IsOutput: true}})
+
+	tests := []struct {
+		levels  int
+		wantErr bool
+	}{
+		{1, false},
+		{MaxNestingLevel, false},
+		{MaxNestingLevel + 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.levels), func(t *testing.T) {
+			interp := scopeSetup(t, resolver)
+			result, err := interp.Execute(context.Background(), fmt.Sprintf(
+				"DECLARE @depth INT = 0, @rc INT; EXEC @rc = dbo.Countdown %d, @depth OUTPUT; SELECT @depth, @rc", tt.levels), nil)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "nesting level") {
+					t.Fatalf("got %v, want a nesting level error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := lastRows(result), []string{fmt.Sprintf("%d %d", tt.levels, tt.levels)}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestScope_DynamicSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"exec isolated",
+			"DECLARE @x INT = 1, @sql VARCHAR(100) = 'DECLARE @x INT = 2; SELECT @x'; EXEC(@sql); SELECT @x",
+			[]string{"1"}},
+		{"exec sees no caller variables",
+			"DECLARE @x INT = 1; EXEC('SELECT @x')",
+			[]string{"NULL"}},
+		{"sp_executesql parameters",
+			"DECLARE @x INT = 1; EXEC sp_executesql N'SELECT @a + @b', N'@a INT, @b INT', 2, @b = 3; SELECT @x",
+			[]string{"1"}},
+		{"sp_executesql output",
+			"DECLARE @total INT; EXEC sp_executesql N'SET @t = @a * 2', N'@a INT, @t INT OUTPUT', 21, @total OUTPUT; SELECT @total",
+			[]string{"42"}},
+		{"sp_executesql return",
+			"DECLARE @x INT = 1; EXEC sp_executesql N'RETURN'; SELECT @x",
+			[]string{"1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interp := scopeSetup(t, newMockResolver())
+			result, err := interp.Execute(context.Background(), tt.sql, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := lastRows(result); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseParamDef(t *testing.T) {
+	names, outputs := parseParamDef("@a INT, @Total INT OUTPUT, @c VARCHAR(10) OUT")
+	if want := []string{"a", "Total", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if want := map[string]bool{"total": true, "c": true}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("outputs = %v, want %v", outputs, want)
+	}
+}
+
+func TestScope_NestLevel(t *testing.T) {
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.Outer", "CREATE PROCEDURE dbo.Outer AS BEGIN SELECT @@NESTLEVEL; EXEC dbo.Inner; EXEC('SELECT @@NESTLEVEL') END", nil)
+	resolver.AddProcedure("dbo.Inner", "CREATE PROCEDURE dbo.Inner AS BEGIN SELECT @@NESTLEVEL END", nil)
+
+	interp := scopeSetup(t, resolver)
+	result, err := interp.Execute(context.Background(), "SELECT @@NESTLEVEL; EXEC dbo.Outer; SELECT @@NESTLEVEL", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, rs := range result.ResultSets {
+		got = append(got, valueString(rs.Rows[0][0]))
+	}
+	if want := []string{"0", "1", "2", "2", "0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("@@NESTLEVEL = %q, want %q", got, want)
+	}
+}
+
+func TestScope_MaxNestingLevel(t *testing.T) {
+	resolver := newMockResolver()
+	resolver.AddProcedure("dbo.A", "CREATE PROCEDURE dbo.A AS BEGIN EXEC dbo.B END", nil)
+	resolver.AddProcedure("dbo.B", "CREATE PROCEDURE dbo.B AS BEGIN EXEC('EXEC dbo.A') END", nil)
+
+	interp := scopeSetup(t, resolver)
+	interp.SetMaxNestingLevel(4)
+	interp.SetNestingLevel(1)
+	interp.SetCallChain("dbo.Main")
+	_, err := interp.Execute(context.Background(), "EXEC dbo.A", nil)
+
+	var sqlErr *SQLError
+	if !errors.As(err, &sqlErr) || sqlErr.Number != ErrNestingLimit {
+		t.Fatalf("got %v, want error %d", err, ErrNestingLimit)
+	}
+	want := "maximum procedure nesting level (4) exceeded; call chain: dbo.Main -> dbo.A -> dbo.B -> EXEC() -> dbo.A"
+	if sqlErr.Message != want {
+		t.Errorf("got message %q, want %q", sqlErr.Message, want)
+	}
+}
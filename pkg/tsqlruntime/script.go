@@ -0,0 +1,533 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// sp_execute_script runs a script in an embedded interpreter, for logic
+// that is awkward in T-SQL such as parsing strings or heavy arithmetic:
+//
+//	DECLARE @total float, @rc int;
+//	EXEC @rc = sp_execute_script N'lua', N'
+//	    total = 0
+//	    for n in string.gmatch(csv, "[^,]+") do total = total + tonumber(n) end
+//	    return 0',
+//	    N'@csv nvarchar(max), @total float OUTPUT',
+//	    @csv = N'1.5,2,3', @total = @total OUTPUT;
+//
+// The arguments are those of SQL Server's sp_execute_external_script:
+// @language, @script and @params, then the parameters @params declares,
+// by position or by name. Each parameter is a global of the script, named
+// without the @; OUTPUT parameters are read back from their globals when
+// the script ends, cast to their declared types. A number the script
+// returns is the return code.
+//
+// Lua is the only language. Scripts run sandboxed, each in an interpreter
+// of its own with the base, string, table and math libraries but nothing
+// that reaches files, the OS or other code, for no longer and with no more
+// memory than the ScriptPolicy allows.
+
+// Defaults and limits of sp_execute_script.
+const (
+	DefaultScriptTimeout = 5 * time.Second
+	DefaultScriptMemory  = 16 << 20
+	maxScriptString      = 16 << 20         // Longest string string.rep may build
+	maxScriptRegistry    = 1 << 20          // Most values on a script's stack
+	scriptMemoryInterval = time.Millisecond // How often a script's memory is measured
+	scriptMemoryOverhead = 10               // Script time allowed per unit of time spent measuring
+)
+
+// ScriptPolicy says whether, for how long and in how much memory
+// sp_execute_script may run scripts. The zero value runs none.
+type ScriptPolicy struct {
+	// Enabled allows scripts to run.
+	Enabled bool
+
+	// Timeout bounds each script (0 = DefaultScriptTimeout).
+	Timeout time.Duration
+
+	// MemoryLimit bounds the memory each script may hold, in bytes
+	// (0 = DefaultScriptMemory).
+	MemoryLimit int64
+}
+
+// SetScriptPolicy sets the scripts sp_execute_script may run. Without a
+// policy it fails.
+func (i *Interpreter) SetScriptPolicy(policy *ScriptPolicy) {
+	i.ctx.Scripts = policy
+}
+
+// scriptLanguages are the languages sp_execute_script runs.
+var scriptLanguages = []string{"lua"}
+
+// scriptParam is a parameter declared in sp_execute_script's @params.
+type scriptParam struct {
+	name     string // Without the @
+	dataType string
+	output   bool
+}
+
+// executeSpExecuteScript runs sp_execute_script with params, setting
+// returnVar, when not nil, to the script's return code.
+func (i *Interpreter) executeSpExecuteScript(ctx context.Context, params []*ast.ExecParameter, returnVar *ast.Identifier) error {
+	const proc = "sp_execute_script"
+	invalid := func(format string, a ...interface{}) error {
+		return NewSQLError(ErrInvalidParameter, fmt.Sprintf(proc+": "+format, a...))
+	}
+
+	if i.ctx.Scripts == nil || !i.ctx.Scripts.Enabled {
+		return NewSQLError(ErrNotSupported, proc+": scripts are disabled on this server")
+	}
+
+	// @language, @script and @params come first, by position or by name;
+	// the script's own parameters follow
+	fixed := [3]Value{Null(TypeNVarChar), Null(TypeNVarChar), Null(TypeNVarChar)}
+	var rest []*ast.ExecParameter
+	for j, p := range params {
+		switch strings.ToLower(p.Name) {
+		case "@language":
+			j = 0
+		case "@script":
+			j = 1
+		case "@params":
+			j = 2
+		default:
+			if p.Name != "" || j > 2 {
+				rest = append(rest, p)
+				continue
+			}
+		}
+		v, err := i.evaluator.Evaluate(p.Value)
+		if err != nil {
+			return err
+		}
+		fixed[j] = v
+	}
+
+	language := strings.ToLower(strings.TrimSpace(fixed[0].AsString()))
+	if fixed[0].IsNull || language == "" {
+		return invalid("@language is required")
+	}
+	if !containsString(scriptLanguages, language) {
+		return invalid("language '%s' is not supported (supported: %s)", language, strings.Join(scriptLanguages, ", "))
+	}
+	if fixed[1].IsNull {
+		return invalid("@script is required")
+	}
+	var declared []scriptParam
+	if !fixed[2].IsNull {
+		var err error
+		if declared, err = parseScriptParams(fixed[2].AsString()); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	if len(rest) > 0 && len(declared) == 0 {
+		return invalid("parameters were passed, but @params declares none")
+	}
+
+	// Bind the arguments to the declared parameters
+	args := make(map[string]Value, len(declared))
+	outputs := make(map[string]string) // maps script param name to caller variable name
+	for j, p := range rest {
+		var param *scriptParam
+		if p.Name == "" {
+			if j >= len(declared) {
+				return invalid("too many parameters were passed")
+			}
+			param = &declared[j]
+		} else {
+			name := strings.TrimPrefix(p.Name, "@")
+			for k := range declared {
+				if strings.EqualFold(declared[k].name, name) {
+					param = &declared[k]
+				}
+			}
+			if param == nil {
+				return invalid("@%s is not declared in @params", name)
+			}
+		}
+		val, err := i.evaluator.Evaluate(p.Value)
+		if err != nil {
+			return err
+		}
+		if val, err = castScriptValue(val, param.dataType); err != nil {
+			return invalid("@%s: %v", param.name, err)
+		}
+		args[param.name] = val
+		if p.Output && param.output {
+			if callerVar := outputTarget(p.Value); callerVar != "" {
+				outputs[param.name] = callerVar
+			}
+		}
+	}
+
+	timeout := i.ctx.Scripts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultScriptTimeout
+	}
+	memoryLimit := i.ctx.Scripts.MemoryLimit
+	if memoryLimit <= 0 {
+		memoryLimit = DefaultScriptMemory
+	}
+	rc, results, err := runLuaScript(ctx, fixed[1].AsString(), declared, args, timeout, memoryLimit)
+	if err != nil {
+		return err
+	}
+
+	for name, callerVar := range outputs {
+		i.assign(callerVar, results[name])
+	}
+	if returnVar != nil {
+		i.assign(returnVar.Value, NewInt(rc))
+	}
+	return nil
+}
+
+// parseScriptParams parses the parameter definitions of @params, such as
+// N'@n int, @amount decimal(10, 2), @out nvarchar(max) OUTPUT'.
+func parseScriptParams(def string) ([]scriptParam, error) {
+	var params []scriptParam
+	depth, start := 0, 0
+	for j := 0; j <= len(def); j++ {
+		if j < len(def) {
+			switch def[j] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		part := strings.TrimSpace(def[start:j])
+		start = j + 1
+		if part == "" {
+			continue
+		}
+		tokens := strings.Fields(part)
+		if !strings.HasPrefix(tokens[0], "@") || len(tokens) < 2 {
+			return nil, fmt.Errorf("'%s' is not a parameter definition", part)
+		}
+		p := scriptParam{name: tokens[0][1:]}
+		if last := strings.ToUpper(tokens[len(tokens)-1]); len(tokens) > 2 && (last == "OUTPUT" || last == "OUT") {
+			p.output = true
+			tokens = tokens[:len(tokens)-1]
+		}
+		p.dataType = strings.Join(tokens[1:], "")
+		if dt, _, _, _ := ParseDataType(p.dataType); dt == TypeUnknown {
+			return nil, fmt.Errorf("@%s has unknown type '%s'", p.name, p.dataType)
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// castScriptValue casts v to the T-SQL type named dataType.
+func castScriptValue(v Value, dataType string) (Value, error) {
+	dt, precision, scale, maxLen := ParseDataType(dataType)
+	if v.IsNull {
+		return Null(dt), nil
+	}
+	return Cast(v, dt, precision, scale, maxLen)
+}
+
+// runLuaScript runs code with the declared parameters as globals, set to
+// args (NULL when missing), returning its return code and the values of
+// the OUTPUT parameters after it has run, by name. The script is stopped
+// once it has run for timeout or holds more than memoryLimit bytes.
+func runLuaScript(ctx context.Context, code string, declared []scriptParam, args map[string]Value, timeout time.Duration, memoryLimit int64) (int64, map[string]Value, error) {
+	const proc = "sp_execute_script"
+	L := newLuaSandbox()
+	defer L.Close()
+
+	scriptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	memory := newScriptMemory(scriptCtx, L, memoryLimit)
+	defer memory.stop()
+	L.SetContext(memory)
+
+	for _, p := range declared {
+		if v, ok := args[p.name]; ok {
+			L.SetGlobal(p.name, toLuaValue(v))
+		}
+	}
+
+	fn, err := L.Load(strings.NewReader(code), "script")
+	if err != nil {
+		return 0, nil, NewSQLError(ErrExternalScript, fmt.Sprintf("%s: %v", proc, luaErrorMessage(err)))
+	}
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		if ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+		if memory.exceeded {
+			return 0, nil, NewSQLError(ErrExternalScript, fmt.Sprintf("%s: the script used more than its %d bytes of memory", proc, memoryLimit))
+		}
+		if errors.Is(scriptCtx.Err(), context.DeadlineExceeded) {
+			return 0, nil, NewSQLError(ErrTimeout, fmt.Sprintf("%s: the script ran past its %s limit", proc, timeout))
+		}
+		return 0, nil, NewSQLError(ErrExternalScript, fmt.Sprintf("%s: %v", proc, luaErrorMessage(err)))
+	}
+
+	var rc int64
+	switch ret := L.Get(-1).(type) {
+	case lua.LNumber:
+		rc = int64(ret)
+	case *lua.LNilType:
+	default:
+		return 0, nil, NewSQLError(ErrExternalScript, fmt.Sprintf(
+			"%s: the script returned a %s, not a number", proc, ret.Type()))
+	}
+
+	results := make(map[string]Value)
+	for _, p := range declared {
+		if !p.output {
+			continue
+		}
+		v, err := fromLuaValue(L.GetGlobal(p.name))
+		if err == nil {
+			v, err = castScriptValue(v, p.dataType)
+		}
+		if err != nil {
+			return 0, nil, NewSQLError(ErrExternalScript, fmt.Sprintf("%s: @%s: %v", proc, p.name, err))
+		}
+		results[p.name] = v
+	}
+	return rc, results, nil
+}
+
+// newLuaSandbox returns a Lua interpreter with the base, string, table and
+// math libraries, less the functions that load code, reach files or write
+// to the server's output.
+func newLuaSandbox() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, RegistryMaxSize: maxScriptRegistry})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.StringLibName, lua.OpenString},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "getfenv", "setfenv", "print"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	if str, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		str.RawSetString("rep", L.NewFunction(luaStringRep))
+	}
+	return L
+}
+
+// scriptMemory is the context a script runs under, stopping it once it
+// holds more than limit bytes. The interpreter asks for Done before each
+// instruction; when a measurement is due, at most every
+// scriptMemoryInterval, Done measures what the script can reach from its
+// globals and its stack, so it runs on the script's own goroutine.
+type scriptMemory struct {
+	context.Context
+	L        *lua.LState
+	limit    int64
+	cancel   context.CancelFunc
+	due      atomic.Bool
+	next     time.Time // No measurement before this
+	exceeded bool
+}
+
+// newScriptMemory returns the context for a script run by L under ctx.
+// Its stop must be called when the script has run.
+func newScriptMemory(ctx context.Context, L *lua.LState, limit int64) *scriptMemory {
+	m := &scriptMemory{L: L, limit: limit}
+	m.Context, m.cancel = context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(scriptMemoryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.Context.Done():
+				return
+			case <-ticker.C:
+				m.due.Store(true)
+			}
+		}
+	}()
+	return m
+}
+
+// Done measures the script's memory when that is due, cancelling the
+// script if it holds too much.
+func (m *scriptMemory) Done() <-chan struct{} {
+	if m.due.Load() {
+		m.due.Store(false)
+		if now := time.Now(); now.After(m.next) {
+			if m.size() > m.limit {
+				m.exceeded = true
+				m.cancel()
+			}
+			// Measuring a large script takes a while; keep it to a
+			// fraction of the script's time
+			m.next = now.Add(time.Since(now) * scriptMemoryOverhead)
+		}
+	}
+	return m.Context.Done()
+}
+
+// stop ends the measurements.
+func (m *scriptMemory) stop() {
+	m.cancel()
+}
+
+// size estimates the bytes the script holds in strings, tables and
+// functions reachable from its globals, registry and stack, stopping
+// once that is over the limit.
+func (m *scriptMemory) size() int64 {
+	var (
+		total int64
+		seen  = make(map[lua.LValue]bool)
+		queue = []lua.LValue{m.L.G.Global, m.L.G.Registry}
+	)
+	for level := 0; ; level++ {
+		dbg, ok := m.L.GetStack(level)
+		if !ok {
+			break
+		}
+		if fn, err := m.L.GetInfo("f", dbg, lua.LNil); err == nil {
+			queue = append(queue, fn)
+		}
+		for n := 1; ; n++ {
+			name, lv := m.L.GetLocal(dbg, n)
+			if name == "" {
+				break
+			}
+			queue = append(queue, lv)
+		}
+	}
+
+	for len(queue) > 0 && total <= m.limit {
+		lv := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		switch v := lv.(type) {
+		case lua.LString:
+			total += int64(len(v)) + 16
+		case *lua.LTable:
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			total += 64
+			v.ForEach(func(key, value lua.LValue) {
+				total += 32
+				queue = append(queue, key, value)
+			})
+			queue = append(queue, v.Metatable)
+		case *lua.LFunction:
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			total += 64
+			for _, up := range v.Upvalues {
+				total += 16
+				queue = append(queue, up.Value())
+			}
+			queue = append(queue, v.Env)
+		case *lua.LUserData:
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			total += 32
+			queue = append(queue, v.Metatable)
+		}
+	}
+	return total
+}
+
+// luaStringRep is string.rep, refusing to build strings longer than
+// maxScriptString.
+func luaStringRep(L *lua.LState) int {
+	s := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if len(s) > 0 && n > maxScriptString/len(s) {
+		L.RaiseError("string.rep: result longer than %d bytes", maxScriptString)
+	}
+	L.Push(lua.LString(strings.Repeat(s, n)))
+	return 1
+}
+
+// luaErrorMessage returns the message of a Lua error, without the stack
+// trace.
+func luaErrorMessage(err error) string {
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		if apiErr.Cause != nil {
+			return apiErr.Cause.Error()
+		}
+		return apiErr.Object.String()
+	}
+	return err.Error()
+}
+
+// toLuaValue converts a T-SQL value for a script: NULL to nil, bit to a
+// boolean, numbers to numbers, binary to a string of its bytes and
+// everything else to its string form.
+func toLuaValue(v Value) lua.LValue {
+	switch {
+	case v.IsNull:
+		return lua.LNil
+	case v.Type == TypeBit:
+		return lua.LBool(v.AsBool())
+	case v.Type.IsInteger():
+		return lua.LNumber(v.AsInt())
+	case v.Type.IsNumeric():
+		return lua.LNumber(v.AsFloat())
+	case v.Type == TypeBinary || v.Type == TypeVarBinary:
+		return lua.LString(v.bytesVal)
+	}
+	return lua.LString(v.AsString())
+}
+
+// fromLuaValue converts a script's value to T-SQL: nil to NULL, a boolean
+// to bit, an integral number to bigint and any other to float, and a
+// string to nvarchar.
+func fromLuaValue(lv lua.LValue) (Value, error) {
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return Null(TypeUnknown), nil
+	case lua.LBool:
+		return NewBit(bool(v)), nil
+	case lua.LNumber:
+		f := float64(v)
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return NewBigInt(int64(f)), nil
+		}
+		return NewFloat(f), nil
+	case lua.LString:
+		return NewNVarChar(string(v), -1), nil
+	}
+	return Value{}, fmt.Errorf("a %s has no T-SQL value", lv.Type())
+}
@@ -0,0 +1,102 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScripts(t *testing.T) {
+	ctx := context.Background()
+	interp := scopeSetup(t, newMockResolver())
+	interp.SetScriptPolicy(&ScriptPolicy{Enabled: true, Timeout: 200 * time.Millisecond, MemoryLimit: 4 << 20})
+
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"outputs and return code", `
+			DECLARE @total FLOAT, @count INT, @rc INT;
+			EXEC @rc = sp_execute_script N'lua', N'
+				total, count = 0, 0
+				for n in string.gmatch(csv, "[^,]+") do
+					total, count = total + tonumber(n), count + 1
+				end
+				return 3',
+				N'@csv NVARCHAR(MAX), @total FLOAT OUTPUT, @count INT OUTPUT',
+				@csv = N'1.5,2,3', @total = @total OUTPUT, @count = @count OUTPUT;
+			SELECT @total, @count, @rc`,
+			[]string{"6.5 3 3"}},
+		{"positional and named", `
+			DECLARE @out NVARCHAR(100);
+			EXEC dbo.sp_execute_script @language = N'Lua', @params = N'@a INT, @b DECIMAL(10, 2), @out NVARCHAR(100) OUTPUT',
+				@script = N'out = string.upper(tostring(a * b))', @a = 2, @b = 1.25, @out = @out OUTPUT;
+			SELECT @out`,
+			[]string{"2.5"}},
+		{"NULL and bit", `
+			DECLARE @isnil BIT, @flag BIT, @back INT = 5;
+			EXEC sp_execute_script N'lua', N'isnil = (x == nil); flag = not f; back = nil',
+				N'@x INT, @f BIT, @isnil BIT OUTPUT, @flag BIT OUTPUT, @back INT OUTPUT',
+				NULL, 1, @isnil OUTPUT, @flag OUTPUT, @back OUTPUT;
+			SELECT @isnil, @flag, ISNULL(@back, -1)`,
+			[]string{"1 0 -1"}},
+		{"sandbox", `
+			DECLARE @missing NVARCHAR(200);
+			EXEC sp_execute_script N'lua', N'missing = tostring(os) .. tostring(io) .. tostring(require) .. tostring(load) .. tostring(print)',
+				N'@missing NVARCHAR(200) OUTPUT', @missing = @missing OUTPUT;
+			SELECT @missing`,
+			[]string{"nilnilnilnilnil"}},
+	}
+	for _, tc := range tests {
+		result, err := interp.Execute(ctx, tc.sql, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := lastRows(result); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		sql    string
+		number int
+		want   string
+	}{
+		{"language", "EXEC sp_execute_script N'python', N'x = 1'", ErrInvalidParameter, "not supported"},
+		{"undeclared", "EXEC sp_execute_script N'lua', N'x = 1', N'@a INT', @b = 1", ErrInvalidParameter, "@b is not declared"},
+		{"syntax", "EXEC sp_execute_script N'lua', N'x = '", ErrExternalScript, "script"},
+		{"runtime", "EXEC sp_execute_script N'lua', N'error(\"no good\")'", ErrExternalScript, "no good"},
+		{"table output", "DECLARE @t INT; EXEC sp_execute_script N'lua', N't = {}', N'@t INT OUTPUT', @t OUTPUT", ErrExternalScript, "table"},
+		{"rep", "EXEC sp_execute_script N'lua', N'return #string.rep(\"x\", 1e9)'", ErrExternalScript, "longer than"},
+		{"timeout", "EXEC sp_execute_script N'lua', N'while true do end'", ErrTimeout, "ran past its 200ms limit"},
+		{"memory in globals", "EXEC sp_execute_script N'lua', N't = {} for i = 1, 1e8 do t[i] = {string.rep(\"x\", 1024) .. i} end'", ErrExternalScript, "more than its 4194304 bytes of memory"},
+		{"memory in locals", "EXEC sp_execute_script N'lua', N'local s = \"x\" while true do s = s .. s end'", ErrExternalScript, "memory"},
+		{"memory in upvalues", "EXEC sp_execute_script N'lua', N'local t = {} local function add(i) t[#t + 1] = string.rep(\"x\", 1024) .. i end for i = 1, 1e8 do add(i) end'", ErrExternalScript, "memory"},
+	} {
+		start := time.Now()
+		_, err := interp.Execute(ctx, tc.sql, nil)
+		var sqlErr *SQLError
+		if !errors.As(err, &sqlErr) || sqlErr.Number != tc.number || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want %d %q", tc.name, err, tc.number, tc.want)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%s: failed after %s", tc.name, d)
+		}
+	}
+
+	// Without a policy, or with one not enabled, nothing runs
+	for _, policy := range []*ScriptPolicy{nil, {Timeout: time.Second}} {
+		interp.SetScriptPolicy(policy)
+		_, err := interp.Execute(ctx, "EXEC sp_execute_script N'lua', N'return 0'", nil)
+		var sqlErr *SQLError
+		if !errors.As(err, &sqlErr) || sqlErr.Number != ErrNotSupported {
+			t.Errorf("policy %+v: %v", policy, err)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// ADD SENSITIVITY CLASSIFICATION labels columns holding personal or
+// otherwise sensitive data. On SQLite the labels are kept in
+// SensitivityTable in the database, as extended properties are, and
+// sys.sensitivity_classifications reads them from there. Against SQL
+// Server the statements are sent to the backend unchanged; other dialects
+// do not support them.
+//
+// A query's result columns carry the classifications of the classified
+// columns they are computed from, read directly from the tables of its
+// FROM clause: SELECT UPPER(c.email) FROM customers c returns a column
+// labelled as customers.email is. Columns reaching a result through temp
+// tables, variables, views or derived tables are not labelled.
+
+// SensitivityTable holds the sensitivity classifications of a database's
+// columns.
+const SensitivityTable = "aul_sensitivity_classifications"
+
+// Sensitivity is the classification of a column.
+type Sensitivity struct {
+	Column            string `json:"column"` // The classified column, as schema.table.column
+	Label             string `json:"label,omitempty"`
+	LabelID           string `json:"label_id,omitempty"`
+	InformationType   string `json:"information_type,omitempty"`
+	InformationTypeID string `json:"information_type_id,omitempty"`
+	Rank              string `json:"rank,omitempty"` // NONE, LOW, MEDIUM, HIGH or CRITICAL
+}
+
+// sensitivityRanks are the values of sys.sensitivity_classifications.rank.
+var sensitivityRanks = map[string]int{
+	"NONE":     0,
+	"LOW":      10,
+	"MEDIUM":   20,
+	"HIGH":     30,
+	"CRITICAL": 40,
+}
+
+// rank returns the rank of s as a number, -1 if it has none.
+func (s *Sensitivity) rank() int {
+	if r, ok := sensitivityRanks[s.Rank]; ok {
+		return r
+	}
+	return -1
+}
+
+// executeSensitivityStatement runs ADD and DROP SENSITIVITY CLASSIFICATION.
+func (i *Interpreter) executeSensitivityStatement(ctx context.Context, stmt ast.Statement) error {
+	switch i.ctx.Dialect {
+	case DialectSQLServer:
+		_, err := i.exec(ctx, stmt.String())
+		return err
+	case DialectSQLite:
+	default:
+		return fmt.Errorf("sensitivity classifications are only emulated on SQLite")
+	}
+	i.classified = nil
+
+	switch s := stmt.(type) {
+	case *ast.AddSensitivityClassificationStatement:
+		return i.addSensitivity(ctx, s)
+	case *ast.DropSensitivityClassificationStatement:
+		for _, col := range s.Columns {
+			schema, table, column := sensitivityColumn(col)
+			if _, err := i.exec(ctx, "DELETE FROM "+SensitivityTable+" WHERE "+i.sensitivityWhere(), schema, table, column); err != nil && !isMissingTable(err) {
+				return fmt.Errorf("DROP SENSITIVITY CLASSIFICATION failed: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// addSensitivity classifies the columns of s, replacing any classification
+// they had.
+func (i *Interpreter) addSensitivity(ctx context.Context, s *ast.AddSensitivityClassificationStatement) error {
+	if s.Label == "" && s.InformationType == "" && s.Rank == "" {
+		return NewSQLError(ErrInvalidParameter, "ADD SENSITIVITY CLASSIFICATION requires LABEL, INFORMATION_TYPE or RANK.")
+	}
+	var rank interface{}
+	if s.Rank != "" {
+		r, ok := sensitivityRanks[s.Rank]
+		if !ok {
+			return NewSQLError(ErrInvalidParameter, fmt.Sprintf("Invalid sensitivity rank '%s'.", s.Rank))
+		}
+		rank = r
+	}
+	for _, col := range s.Columns {
+		_, table, column := sensitivityColumn(col)
+		columns, err := i.tableColumns(ctx, table)
+		if err != nil {
+			return NewSQLError(ErrInvalidObject, fmt.Sprintf("Invalid object name '%s'.", table))
+		}
+		if !containsFold(columns, column) {
+			return NewSQLError(ErrInvalidColumn, fmt.Sprintf("Invalid column name '%s'.", column))
+		}
+	}
+
+	if _, err := i.exec(ctx, "CREATE TABLE IF NOT EXISTS "+SensitivityTable+
+		" (schema_name VARCHAR(128), table_name VARCHAR(128), column_name VARCHAR(128),"+
+		" label VARCHAR(128), label_id VARCHAR(128), information_type VARCHAR(128), information_type_id VARCHAR(128), rank INTEGER)"); err != nil {
+		return fmt.Errorf("sensitivity classifications unavailable: %w", err)
+	}
+	placeholders := make([]string, 8)
+	for j := range placeholders {
+		placeholders[j] = i.getPlaceholder(j)
+	}
+	for _, col := range s.Columns {
+		schema, table, column := sensitivityColumn(col)
+		if _, err := i.exec(ctx, "DELETE FROM "+SensitivityTable+" WHERE "+i.sensitivityWhere(), schema, table, column); err != nil {
+			return fmt.Errorf("ADD SENSITIVITY CLASSIFICATION failed: %w", err)
+		}
+		if _, err := i.exec(ctx, "INSERT INTO "+SensitivityTable+
+			" (schema_name, table_name, column_name, label, label_id, information_type, information_type_id, rank)"+
+			" VALUES ("+strings.Join(placeholders, ", ")+")",
+			schema, table, column, nullIfEmpty(s.Label), nullIfEmpty(s.LabelID),
+			nullIfEmpty(s.InformationType), nullIfEmpty(s.InformationTypeID), rank); err != nil {
+			return fmt.Errorf("ADD SENSITIVITY CLASSIFICATION failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// sensitivityWhere matches the classification of one column, by schema,
+// table and column name.
+func (i *Interpreter) sensitivityWhere() string {
+	return fmt.Sprintf("LOWER(schema_name) = LOWER(%s) AND LOWER(table_name) = LOWER(%s) AND LOWER(column_name) = LOWER(%s)",
+		i.getPlaceholder(0), i.getPlaceholder(1), i.getPlaceholder(2))
+}
+
+// sensitivityColumn splits [schema.]table.column, the schema dbo if not
+// given.
+func sensitivityColumn(col *ast.QualifiedIdentifier) (schema, table, column string) {
+	parts := col.Parts
+	schema = "dbo"
+	if len(parts) > 2 && parts[len(parts)-3].Value != "" {
+		schema = parts[len(parts)-3].Value
+	}
+	return schema, parts[len(parts)-2].Value, parts[len(parts)-1].Value
+}
+
+// nullIfEmpty returns nil for an option that was not given.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// isMissingTable reports whether err says a table does not exist.
+func isMissingTable(err error) bool {
+	return strings.Contains(err.Error(), "no such table")
+}
+
+// classifications returns the classified columns of the database by table
+// and column, lower-cased. They are read once per execution.
+func (i *Interpreter) classifications(ctx context.Context) map[string]map[string]*Sensitivity {
+	if i.classified != nil {
+		return i.classified
+	}
+	i.classified = map[string]map[string]*Sensitivity{}
+	if i.ctx.Dialect != DialectSQLite {
+		return i.classified
+	}
+	rows, err := i.ctx.GetExecutor().QueryContext(ctx, "SELECT schema_name, table_name, column_name,"+
+		" COALESCE(label, ''), COALESCE(label_id, ''), COALESCE(information_type, ''), COALESCE(information_type_id, ''), rank"+
+		" FROM "+SensitivityTable)
+	if err != nil {
+		// No column is classified
+		return i.classified
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schema, table, column string
+		var rank *int
+		s := &Sensitivity{}
+		if err := rows.Scan(&schema, &table, &column, &s.Label, &s.LabelID, &s.InformationType, &s.InformationTypeID, &rank); err != nil {
+			continue
+		}
+		s.Column = schema + "." + table + "." + column
+		if rank != nil {
+			for name, r := range sensitivityRanks {
+				if r == *rank {
+					s.Rank = name
+				}
+			}
+		}
+		key := strings.ToLower(table)
+		if i.classified[key] == nil {
+			i.classified[key] = map[string]*Sensitivity{}
+		}
+		i.classified[key][strings.ToLower(column)] = s
+	}
+	return i.classified
+}
+
+// selectSensitivity is what a query's result columns are computed from.
+type selectSensitivity struct {
+	items []*Sensitivity          // Of each select item, when there is no *
+	named map[string]*Sensitivity // Of the columns a * returns, by name
+}
+
+// sensitivityOf returns the classifications of the columns s returns, or
+// nil if none is classified. It reads s before it is rewritten for the
+// backend.
+func (i *Interpreter) sensitivityOf(ctx context.Context, s *ast.SelectStatement) *selectSensitivity {
+	if s.From == nil {
+		return nil
+	}
+	classified := i.classifications(ctx)
+	if len(classified) == 0 {
+		return nil
+	}
+
+	// The classified tables the query reads, by alias and name
+	tables := map[string]map[string]*Sensitivity{}
+	var scope []map[string]*Sensitivity
+	for _, ref := range s.From.Tables {
+		sensitivityTables(ref, classified, tables, &scope)
+	}
+	if len(scope) == 0 {
+		return nil
+	}
+
+	ss := &selectSensitivity{named: map[string]*Sensitivity{}}
+	found, star := false, false
+	for _, col := range s.Columns {
+		if col.Variable != nil {
+			return nil
+		}
+		var sources []map[string]*Sensitivity
+		if col.AllColumns {
+			sources = scope
+		} else if qi, ok := col.Expression.(*ast.QualifiedIdentifier); ok && qi.Parts[len(qi.Parts)-1].Value == "*" {
+			if t := tables[strings.ToLower(qi.Parts[len(qi.Parts)-2].Value)]; t != nil {
+				sources = append(sources, t)
+			}
+		} else {
+			item := columnSensitivity(col.Expression, tables, scope, nil)
+			ss.items = append(ss.items, item)
+			if item != nil {
+				found = true
+				name := col.Expression.String()
+				if col.Alias != nil {
+					name = col.Alias.Value
+				} else if id, ok := col.Expression.(*ast.Identifier); ok {
+					name = id.Value
+				} else if qi, ok := col.Expression.(*ast.QualifiedIdentifier); ok {
+					name = qi.Parts[len(qi.Parts)-1].Value
+				}
+				ss.named[strings.ToLower(name)] = item
+			}
+			continue
+		}
+		star = true
+		for _, t := range sources {
+			for column, s := range t {
+				if _, ok := ss.named[column]; !ok {
+					ss.named[column] = s
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	if star {
+		ss.items = nil
+	}
+	return ss
+}
+
+// sensitivityTables adds the classified tables ref reads to tables, by
+// alias and name, and to scope.
+func sensitivityTables(ref ast.TableReference, classified, tables map[string]map[string]*Sensitivity, scope *[]map[string]*Sensitivity) {
+	switch t := ref.(type) {
+	case *ast.TableName:
+		if t.Name == nil {
+			return
+		}
+		name := t.Name.Parts[len(t.Name.Parts)-1].Value
+		columns := classified[strings.ToLower(name)]
+		if columns == nil {
+			return
+		}
+		tables[strings.ToLower(name)] = columns
+		if t.Alias != nil {
+			tables[strings.ToLower(t.Alias.Value)] = columns
+		}
+		*scope = append(*scope, columns)
+	case *ast.JoinClause:
+		sensitivityTables(t.Left, classified, tables, scope)
+		sensitivityTables(t.Right, classified, tables, scope)
+	case *ast.ParenthesizedTableRef:
+		sensitivityTables(t.Inner, classified, tables, scope)
+	}
+}
+
+// columnSensitivity returns the highest ranked classification of the
+// columns e reads, given best, the highest found so far.
+func columnSensitivity(e ast.Expression, tables map[string]map[string]*Sensitivity, scope []map[string]*Sensitivity, best *Sensitivity) *Sensitivity {
+	higher := func(s *Sensitivity) {
+		if s != nil && (best == nil || s.rank() > best.rank()) {
+			best = s
+		}
+	}
+	switch e := e.(type) {
+	case *ast.Identifier:
+		for _, t := range scope {
+			higher(t[strings.ToLower(e.Value)])
+		}
+	case *ast.QualifiedIdentifier:
+		if len(e.Parts) >= 2 {
+			if t := tables[strings.ToLower(e.Parts[len(e.Parts)-2].Value)]; t != nil {
+				higher(t[strings.ToLower(e.Parts[len(e.Parts)-1].Value)])
+			}
+		}
+	case *ast.FunctionCall:
+		for _, arg := range e.Arguments {
+			best = columnSensitivity(arg, tables, scope, best)
+		}
+	case *ast.InfixExpression:
+		best = columnSensitivity(e.Left, tables, scope, best)
+		best = columnSensitivity(e.Right, tables, scope, best)
+	case *ast.PrefixExpression:
+		best = columnSensitivity(e.Right, tables, scope, best)
+	case *ast.CaseExpression:
+		best = columnSensitivity(e.Operand, tables, scope, best)
+		for _, w := range e.WhenClauses {
+			best = columnSensitivity(w.Condition, tables, scope, best)
+			best = columnSensitivity(w.Result, tables, scope, best)
+		}
+		best = columnSensitivity(e.ElseClause, tables, scope, best)
+	case *ast.CastExpression:
+		best = columnSensitivity(e.Expression, tables, scope, best)
+	case *ast.ConvertExpression:
+		best = columnSensitivity(e.Expression, tables, scope, best)
+	}
+	return best
+}
+
+// columns returns the classifications of the result columns called
+// names, or nil if none is classified.
+func (ss *selectSensitivity) columns(names []string) []*Sensitivity {
+	if ss == nil {
+		return nil
+	}
+	labels := make([]*Sensitivity, len(names))
+	found := false
+	for j, name := range names {
+		if ss.items != nil && len(ss.items) == len(names) {
+			labels[j] = ss.items[j]
+		} else {
+			labels[j] = ss.named[strings.ToLower(name)]
+		}
+		found = found || labels[j] != nil
+	}
+	if !found {
+		return nil
+	}
+	return labels
+}
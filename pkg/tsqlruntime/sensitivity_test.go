@@ -0,0 +1,141 @@
+package tsqlruntime
+
+import (
+	"context"
+	"testing"
+)
+
+// sensitivitySetup returns an interpreter over a database of customers
+// and orders, the customers' email and phone classified.
+func sensitivitySetup(t *testing.T) *Interpreter {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE Customers (ID INTEGER, Name TEXT, Email TEXT, Phone TEXT); " +
+		"INSERT INTO Customers VALUES (1, 'Ada', 'ada@example.com', '555-0100'); " +
+		"CREATE TABLE Orders (ID INTEGER, CustomerID INTEGER, Total REAL); " +
+		"INSERT INTO Orders VALUES (10, 1, 25.5)"); err != nil {
+		t.Fatal(err)
+	}
+
+	interp := NewInterpreter(db, DialectSQLite)
+	if _, err := interp.Execute(context.Background(),
+		"ADD SENSITIVITY CLASSIFICATION TO dbo.Customers.Email "+
+			"WITH (LABEL = 'Confidential', INFORMATION_TYPE = 'Contact Info', RANK = MEDIUM); "+
+			"ADD SENSITIVITY CLASSIFICATION TO [dbo].[Customers].[Phone] "+
+			"WITH (LABEL = 'Highly Confidential', LABEL_ID = 'b7a3', INFORMATION_TYPE = 'Contact Info', RANK = HIGH)", nil); err != nil {
+		t.Fatal(err)
+	}
+	return interp
+}
+
+// resultLabels returns the label of each column of the last result set of
+// sql, "" for those not classified.
+func resultLabels(t *testing.T, interp *Interpreter, sql string) []string {
+	t.Helper()
+	result, err := interp.Execute(context.Background(), sql, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	rs := result.ResultSets[len(result.ResultSets)-1]
+	labels := make([]string, len(rs.Columns))
+	for j := range labels {
+		if j < len(rs.Sensitivity) && rs.Sensitivity[j] != nil {
+			labels[j] = rs.Sensitivity[j].Label
+		}
+	}
+	return labels
+}
+
+// lastSensitivity returns the classifications of the columns of the last
+// result set of sql.
+func lastSensitivity(t *testing.T, interp *Interpreter, sql string) []*Sensitivity {
+	t.Helper()
+	result, err := interp.Execute(context.Background(), sql, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	return result.ResultSets[len(result.ResultSets)-1].Sensitivity
+}
+
+func TestSensitivityLabels(t *testing.T) {
+	interp := sensitivitySetup(t)
+
+	for _, tt := range []struct {
+		sql  string
+		want []string
+	}{
+		{"SELECT ID, Name FROM Customers", []string{"", ""}},
+		{"SELECT Name, Email FROM Customers", []string{"", "Confidential"}},
+		{"SELECT * FROM Customers", []string{"", "", "Confidential", "Highly Confidential"}},
+		{"SELECT c.Email AS Contact, UPPER(c.Phone) FROM dbo.Customers c", []string{"Confidential", "Highly Confidential"}},
+		// The highest rank of the columns an expression reads
+		{"SELECT c.Email + ' ' + c.Phone AS Contact FROM Customers c", []string{"Highly Confidential"}},
+		{"SELECT o.ID, c.Phone FROM Orders o JOIN Customers c ON c.ID = o.CustomerID", []string{"", "Highly Confidential"}},
+		{"SELECT o.Total, CASE WHEN o.Total > 10 THEN c.Email END FROM Orders o JOIN Customers c ON c.ID = o.CustomerID", []string{"", "Confidential"}},
+		{"WITH big AS (SELECT CustomerID FROM Orders) SELECT c.Phone FROM Customers c JOIN big ON big.CustomerID = c.ID", []string{"Highly Confidential"}},
+		{"SELECT COUNT(*) FROM Customers", []string{""}},
+	} {
+		got := resultLabels(t, interp, tt.sql)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: labels %q, want %q", tt.sql, got, tt.want)
+			continue
+		}
+		for j := range got {
+			if got[j] != tt.want[j] {
+				t.Errorf("%s: labels %q, want %q", tt.sql, got, tt.want)
+				break
+			}
+		}
+	}
+
+	// A result without classified columns has no labels at all
+	if got := lastSensitivity(t, interp, "SELECT Total FROM Orders"); got != nil {
+		t.Errorf("Sensitivity = %v, want nil", got)
+	}
+
+	// The whole classification goes with the label
+	want := Sensitivity{Column: "dbo.Customers.Phone", Label: "Highly Confidential", LabelID: "b7a3",
+		InformationType: "Contact Info", Rank: "HIGH"}
+	if got := lastSensitivity(t, interp, "SELECT Phone FROM Customers")[0]; got == nil || *got != want {
+		t.Errorf("Sensitivity = %+v, want %+v", got, want)
+	}
+}
+
+func TestSensitivityDDL(t *testing.T) {
+	interp := sensitivitySetup(t)
+
+	// Classifying again replaces the classification
+	if _, err := interp.Execute(context.Background(),
+		"ADD SENSITIVITY CLASSIFICATION TO Customers.Email WITH (LABEL = 'Public')", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := resultLabels(t, interp, "SELECT Email FROM Customers"); got[0] != "Public" {
+		t.Errorf("label %q after reclassifying, want Public", got[0])
+	}
+
+	if _, err := interp.Execute(context.Background(),
+		"DROP SENSITIVITY CLASSIFICATION FROM dbo.Customers.Email, dbo.Customers.Phone", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastSensitivity(t, interp, "SELECT Email, Phone FROM Customers"); got != nil {
+		t.Errorf("Sensitivity = %v after DROP, want nil", got)
+	}
+	var n int
+	if err := interp.ctx.DB.QueryRow("SELECT COUNT(*) FROM " + SensitivityTable).Scan(&n); err != nil || n != 0 {
+		t.Errorf("%d classifications left (%v), want none", n, err)
+	}
+
+	for _, tt := range []struct {
+		sql  string
+		want int
+	}{
+		{"ADD SENSITIVITY CLASSIFICATION TO NoSuchTable.Email WITH (LABEL = 'x')", ErrInvalidObject},
+		{"ADD SENSITIVITY CLASSIFICATION TO Customers.Missing WITH (LABEL = 'x')", ErrInvalidColumn},
+		{"ADD SENSITIVITY CLASSIFICATION TO Customers.Email WITH (RANK = SEVERE)", ErrInvalidParameter},
+		{"ADD SENSITIVITY CLASSIFICATION TO Customers.Email WITH (LABEL_ID = 'x')", ErrInvalidParameter},
+	} {
+		_, err := interp.Execute(context.Background(), tt.sql, nil)
+		wantSQLError(t, tt.sql, err, tt.want)
+	}
+}
@@ -0,0 +1,260 @@
+package tsqlruntime
+
+import (
+	"cmp"
+	"strings"
+)
+
+// SessionInfo describes the server and the connection an execution runs
+// for. It answers @@SERVERNAME, @@SPID, HOST_NAME(), APP_NAME(),
+// SUSER_SNAME() and the other environment functions; empty fields keep
+// their placeholder answers.
+type SessionInfo struct {
+	ServerName    string // @@SERVERNAME and SERVERPROPERTY('ServerName')
+	MachineName   string // SERVERPROPERTY('MachineName')
+	ProcessID     int    // SERVERPROPERTY('ProcessID')
+	SPID          int    // @@SPID
+	Login         string // SUSER_SNAME(), SYSTEM_USER and ORIGINAL_LOGIN()
+	HostName      string // HOST_NAME()
+	ClientAddress string // CONNECTIONPROPERTY('client_net_address')
+	AppName       string // APP_NAME()
+	Protocol      string // Protocol the client connected with: tds, postgres or http
+	Database      string // DB_NAME()
+}
+
+// defaultSession answers for an execution without a session, as aul
+// always has.
+var defaultSession = SessionInfo{
+	ServerName:  "aul",
+	MachineName: "aul-server",
+	ProcessID:   1,
+	SPID:        1,
+	Login:       "sa",
+	HostName:    "localhost",
+	AppName:     "aul-client",
+	Database:    "master",
+}
+
+// SetSession sets the server and connection the execution runs for.
+// Without it the environment functions give placeholder answers.
+func (i *Interpreter) SetSession(info *SessionInfo) {
+	i.ctx.Session = info
+}
+
+// session returns the execution's session, its empty fields filled in
+// from defaultSession.
+func (ec *ExecutionContext) session() SessionInfo {
+	if ec.Session == nil {
+		return defaultSession
+	}
+	s := *ec.Session
+	s.ServerName = cmp.Or(s.ServerName, defaultSession.ServerName)
+	s.MachineName = cmp.Or(s.MachineName, defaultSession.MachineName)
+	s.ProcessID = cmp.Or(s.ProcessID, defaultSession.ProcessID)
+	s.SPID = cmp.Or(s.SPID, defaultSession.SPID)
+	s.Login = cmp.Or(s.Login, defaultSession.Login)
+	s.HostName = cmp.Or(s.HostName, s.ClientAddress, defaultSession.HostName)
+	s.AppName = cmp.Or(s.AppName, defaultSession.AppName)
+	s.Database = cmp.Or(s.Database, defaultSession.Database)
+	return s
+}
+
+// sessionNames are the functions answered from the session when called
+// without arguments. SYSTEM_USER, CURRENT_USER and SESSION_USER are
+// written without parentheses, and parse as names.
+var sessionNames = map[string]bool{
+	"HOST_NAME":      true,
+	"APP_NAME":       true,
+	"SUSER_SNAME":    true,
+	"SUSER_NAME":     true,
+	"ORIGINAL_LOGIN": true,
+	"SYSTEM_USER":    true,
+	"USER_NAME":      true,
+	"CURRENT_USER":   true,
+	"SESSION_USER":   true,
+	"DB_NAME":        true,
+}
+
+// sessionValue answers the niladic session function name (upper case)
+// for s.
+func sessionValue(s SessionInfo, name string) Value {
+	switch name {
+	case "HOST_NAME":
+		return NewNVarChar(s.HostName, 128)
+	case "APP_NAME":
+		return NewNVarChar(s.AppName, 128)
+	case "SUSER_SNAME", "SUSER_NAME", "ORIGINAL_LOGIN", "SYSTEM_USER":
+		return NewNVarChar(s.Login, 128)
+	case "USER_NAME", "CURRENT_USER", "SESSION_USER":
+		// Logins are not mapped to database users: everyone is dbo
+		return NewNVarChar("dbo", 128)
+	case "DB_NAME":
+		return NewNVarChar(s.Database, 128)
+	}
+	return Null(TypeNVarChar)
+}
+
+// evaluateSession evaluates the environment functions that depend on the
+// session, returning false for a call that does not.
+func (e *ExpressionEvaluator) evaluateSession(name string, args []Value) (Value, bool) {
+	s := defaultSession
+	if e.session != nil {
+		s = e.session()
+	}
+	name = strings.ToUpper(name)
+	if len(args) == 0 && sessionNames[name] {
+		return sessionValue(s, name), true
+	}
+	if len(args) != 1 || args[0].IsNull {
+		return Value{}, false
+	}
+	switch name {
+	case "SERVERPROPERTY":
+		switch strings.ToUpper(args[0].AsString()) {
+		case "SERVERNAME":
+			return NewNVarChar(s.ServerName, 128), true
+		case "MACHINENAME", "COMPUTERNAMEPHYSICALNETBIOS":
+			return NewNVarChar(s.MachineName, 128), true
+		case "PROCESSID":
+			return NewInt(int64(s.ProcessID)), true
+		}
+	case "CONNECTIONPROPERTY":
+		return connectionProperty(s, args[0].AsString()), true
+	}
+	return Value{}, false
+}
+
+// connectionProperty answers CONNECTIONPROPERTY(property) for s, NULL for
+// a property it does not know.
+func connectionProperty(s SessionInfo, property string) Value {
+	switch strings.ToLower(property) {
+	case "net_transport":
+		if s.Protocol == "http" {
+			return NewNVarChar("HTTP", 40)
+		}
+		return NewNVarChar("TCP", 40)
+	case "protocol_type":
+		switch s.Protocol {
+		case "tds", "":
+			return NewNVarChar("TSQL", 40)
+		case "postgres":
+			return NewNVarChar("PostgreSQL", 40)
+		}
+		return NewNVarChar(strings.ToUpper(s.Protocol), 40)
+	case "auth_scheme":
+		return NewNVarChar("SQL", 40)
+	case "client_net_address":
+		if s.ClientAddress == "" {
+			return Null(TypeNVarChar)
+		}
+		return NewNVarChar(s.ClientAddress, 48)
+	}
+	return Null(TypeNVarChar)
+}
+
+// substituteSession replaces calls of the niladic session functions in a
+// query for the backend with their value, since the backend has no such
+// functions or would answer for its own connection. String literals and
+// quoted names are left alone.
+func (i *Interpreter) substituteSession(query string) string {
+	upper := strings.ToUpper(query)
+	found := false
+	for name := range sessionNames {
+		if strings.Contains(upper, name) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return query
+	}
+
+	var b strings.Builder
+	pos := 0
+	for pos < len(query) {
+		c := query[pos]
+		switch {
+		case c == '\'' || c == '"' || c == '[' || c == '`':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := pos + 1
+			for end < len(query) && query[end] != closing {
+				end++
+			}
+			end = min(end+1, len(query))
+			b.WriteString(query[pos:end])
+			pos = end
+		case isAlpha(c) && (pos == 0 || !isSessionNamePrefix(query[pos-1])):
+			end := pos + 1
+			for end < len(query) && isAlphaNum(query[end]) {
+				end++
+			}
+			name := upper[pos:end]
+			n, ok := sessionCall(query[end:], name)
+			if !ok {
+				b.WriteString(query[pos:end])
+				pos = end
+				continue
+			}
+			v := sessionValue(i.ctx.session(), name)
+			b.WriteString("'" + strings.ReplaceAll(v.AsString(), "'", "''") + "'")
+			pos = end + n
+		default:
+			b.WriteByte(c)
+			pos++
+		}
+	}
+	return b.String()
+}
+
+// isSessionNamePrefix reports whether c before a name makes it something
+// other than a function: part of a longer name, a variable, a temp table
+// or a column of a table.
+func isSessionNamePrefix(c byte) bool {
+	return isAlphaNum(c) || c == '@' || c == '#' || c == '.' || c == '$'
+}
+
+// sessionCall reports whether name, followed by s, is a call of a niladic
+// session function, returning the bytes of s it takes: "()" for the
+// functions, none for the names written without parentheses.
+func sessionCall(s, name string) (int, bool) {
+	if !sessionNames[name] {
+		return 0, false
+	}
+	i := 0
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i++
+	}
+	paren := i < len(s) && s[i] == '('
+	switch name {
+	case "SYSTEM_USER", "CURRENT_USER", "SESSION_USER":
+		return 0, !paren && !(i < len(s) && s[i] == '.')
+	}
+	if !paren {
+		return 0, false
+	}
+	i++
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i++
+	}
+	if i >= len(s) || s[i] != ')' {
+		return 0, false
+	}
+	return i + 1, true
+}
+
+// sessionVariable answers the global variables that depend on the
+// session, @@SERVERNAME, @@SERVICENAME and @@SPID.
+func sessionVariable(s SessionInfo, name string) (Value, bool) {
+	switch strings.ToUpper(name) {
+	case "@@SERVERNAME":
+		return NewNVarChar(s.ServerName, 128), true
+	case "@@SERVICENAME":
+		return NewNVarChar("MSSQLSERVER", 128), true
+	case "@@SPID":
+		return NewSmallInt(int16(s.SPID)), true
+	}
+	return Value{}, false
+}
@@ -0,0 +1,64 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	interp := scopeSetup(t, newMockResolver())
+	if _, err := interp.Execute(ctx, "CREATE TABLE audit (id INT)", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := interp.Execute(ctx, "INSERT INTO audit VALUES (1)", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a session, the placeholder answers
+	result, err := interp.Execute(ctx, "SELECT @@SERVERNAME, @@SPID, HOST_NAME(), APP_NAME(), SYSTEM_USER", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lastRows(result), []string{"aul 1 localhost aul-client sa"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("no session: %q, want %q", got, want)
+	}
+
+	interp.SetSession(&SessionInfo{
+		ServerName:    "prod1",
+		MachineName:   "db-host",
+		SPID:          57,
+		Login:         "alice",
+		ClientAddress: "10.0.0.9",
+		AppName:       "O'Brien Reports",
+		Protocol:      "tds",
+		Database:      "sales",
+	})
+	for _, tt := range []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"variables", "SELECT @@SERVERNAME, @@SPID", []string{"prod1 57"}},
+		{"functions", "SELECT HOST_NAME(), APP_NAME(), SUSER_SNAME(), ORIGINAL_LOGIN(), DB_NAME()",
+			[]string{"10.0.0.9 O'Brien Reports alice alice sales"}},
+		{"names", "SELECT SYSTEM_USER, CURRENT_USER, SESSION_USER", []string{"alice dbo dbo"}},
+		{"properties", "SELECT SERVERPROPERTY('ServerName'), SERVERPROPERTY('MachineName'), " +
+			"CONNECTIONPROPERTY('net_transport'), CONNECTIONPROPERTY('client_net_address')",
+			[]string{"prod1 db-host TCP 10.0.0.9"}},
+		{"variable", "DECLARE @app NVARCHAR(128) = APP_NAME(); SELECT @app", []string{"O'Brien Reports"}},
+		// Queries the backend runs get the values as literals
+		{"backend", "SELECT id, APP_NAME(), SYSTEM_USER, @@SERVERNAME, 'HOST_NAME()' FROM audit WHERE HOST_NAME ( ) = '10.0.0.9'",
+			[]string{"1 O'Brien Reports alice prod1 HOST_NAME()"}},
+	} {
+		result, err := interp.Execute(ctx, tt.sql, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := lastRows(result); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,884 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// geography and geometry instances are emulated as well-known text (WKT).
+// A geometry instance is its WKT, 'POINT (3 4)'; a geography instance
+// carries its SRID in front, 'SRID=4326;POINT (-122.35 47.65)', which is
+// how the two are told apart. WKT without an SRID used alongside a
+// geography instance is read as geography with that instance's SRID, as
+// SQL Server converts a string to the type it is compared with.
+//
+// Points, line strings and polygons are understood. Distances between
+// geography points are great-circle distances in metres on a sphere of the
+// earth's mean radius; everything else is planar, geography using
+// longitude and latitude as x and y. That is enough for the "find nearby"
+// queries spatial types are mostly used for, not a replacement for a
+// spatial library.
+//
+// Variables, scalar SELECTs and temp tables are evaluated here. Queries
+// against SQLite tables have the methods rewritten into SQL over the text
+// (see rewriteSTDistance), which needs SQLite's math functions.
+
+// earthRadius is the earth's mean radius in metres, used for geography
+// distances.
+const earthRadius = 6371008.8
+
+// spatialBufferSegments is the number of sides of the polygon STBuffer
+// approximates a circle with.
+const spatialBufferSegments = 32
+
+// spatialPoint is a coordinate: longitude and latitude for geography.
+type spatialPoint struct {
+	x, y float64
+}
+
+// spatialShape is a parsed geography or geometry instance.
+type spatialShape struct {
+	kind      string           // POINT, LINESTRING or POLYGON
+	rings     [][]spatialPoint // the point or line, or a polygon's exterior ring then its holes; nil if EMPTY
+	geography bool
+	srid      int
+}
+
+// typeName returns the instance's T-SQL type.
+func (s *spatialShape) typeName() string {
+	if s.geography {
+		return "geography"
+	}
+	return "geometry"
+}
+
+// points returns every vertex of the instance.
+func (s *spatialShape) points() []spatialPoint {
+	var pts []spatialPoint
+	for _, ring := range s.rings {
+		pts = append(pts, ring...)
+	}
+	return pts
+}
+
+// isPoint reports whether the instance is a non-empty point.
+func (s *spatialShape) isPoint() bool {
+	return s.kind == "POINT" && len(s.rings) == 1
+}
+
+// WKT returns the instance's well-known text, in SQL Server's format.
+func (s *spatialShape) WKT() string {
+	if len(s.rings) == 0 {
+		return s.kind + " EMPTY"
+	}
+	ring := func(pts []spatialPoint) string {
+		coords := make([]string, len(pts))
+		for i, p := range pts {
+			coords[i] = formatSpatialNumber(p.x) + " " + formatSpatialNumber(p.y)
+		}
+		return "(" + strings.Join(coords, ", ") + ")"
+	}
+	if s.kind != "POLYGON" {
+		return s.kind + " " + ring(s.rings[0])
+	}
+	rings := make([]string, len(s.rings))
+	for i, r := range s.rings {
+		rings[i] = ring(r)
+	}
+	return s.kind + " (" + strings.Join(rings, ", ") + ")"
+}
+
+// String returns the text the instance is stored as.
+func (s *spatialShape) String() string {
+	if s.geography {
+		return "SRID=" + strconv.Itoa(s.srid) + ";" + s.WKT()
+	}
+	return s.WKT()
+}
+
+func formatSpatialNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// spatialValue returns s as a runtime value.
+func spatialValue(s *spatialShape) Value {
+	return NewVarChar(s.String(), -1)
+}
+
+// -----------------------------------------------------------------------------
+// Errors
+// -----------------------------------------------------------------------------
+
+// spatialTypeName returns the .NET type behind a T-SQL spatial type.
+func spatialTypeName(typeName string) string {
+	if strings.EqualFold(typeName, "geography") {
+		return "Microsoft.SqlServer.Types.SqlGeography"
+	}
+	return "Microsoft.SqlServer.Types.SqlGeometry"
+}
+
+// spatialError returns the error SQL Server raises when a spatial type's
+// CLR code throws, such as for malformed WKT.
+func spatialError(typeName, exception string, code int, msg string) error {
+	return NewSQLError(ErrCLRRoutine, fmt.Sprintf(
+		"A .NET Framework error occurred during execution of user-defined routine or aggregate \"%s\": System.%s: %d: %s",
+		strings.ToLower(typeName), exception, code, msg))
+}
+
+// spatialMethodNotFound returns the error for a method the type lacks.
+func spatialMethodNotFound(typeName, method string) error {
+	return NewSQLError(ErrMethodNotFound, fmt.Sprintf(
+		"Could not find method '%s' for type '%s' in assembly 'Microsoft.SqlServer.Types'",
+		method, spatialTypeName(typeName)))
+}
+
+// -----------------------------------------------------------------------------
+// Well-known text
+// -----------------------------------------------------------------------------
+
+// parseSpatial parses an instance's stored text. WKT without an SRID is a
+// geometry instance.
+func parseSpatial(text string) (*spatialShape, error) {
+	text = strings.TrimSpace(text)
+	if len(text) > 5 && strings.EqualFold(text[:5], "SRID=") {
+		semi := strings.IndexByte(text, ';')
+		if semi < 0 {
+			return nil, spatialError("geography", "FormatException", 24114,
+				fmt.Sprintf("The label %s in the input well-known text (WKT) is not valid. Valid labels are POINT, LINESTRING and POLYGON.", text))
+		}
+		srid, err := strconv.Atoi(strings.TrimSpace(text[5:semi]))
+		if err != nil {
+			return nil, spatialError("geography", "FormatException", 24114,
+				fmt.Sprintf("The label %s in the input well-known text (WKT) is not valid. Valid labels are POINT, LINESTRING and POLYGON.", text[:semi]))
+		}
+		return parseWKT(text[semi+1:], "geography", srid)
+	}
+	return parseWKT(text, "geometry", 0)
+}
+
+// parseSpatialLike parses text as an argument to a method of other: WKT
+// without an SRID takes other's type and SRID.
+func parseSpatialLike(text string, other *spatialShape) (*spatialShape, error) {
+	s, err := parseSpatial(text)
+	if err == nil && other.geography && !s.geography {
+		s.geography, s.srid = true, other.srid
+	}
+	return s, err
+}
+
+// parseWKT parses well-known text as an instance of typeName.
+func parseWKT(text, typeName string, srid int) (*spatialShape, error) {
+	p := &wktParser{text: text, typeName: typeName}
+	s := &spatialShape{kind: strings.ToUpper(p.word()), geography: typeName == "geography", srid: srid}
+	switch s.kind {
+	case "POINT", "LINESTRING", "POLYGON":
+	default:
+		return nil, spatialError(typeName, "FormatException", 24114,
+			fmt.Sprintf("The label %s in the input well-known text (WKT) is not valid. Valid labels are POINT, LINESTRING and POLYGON.", strings.TrimSpace(text)))
+	}
+
+	if strings.EqualFold(p.peekWord(), "EMPTY") {
+		p.word()
+	} else {
+		var err error
+		switch s.kind {
+		case "POINT":
+			var ring []spatialPoint
+			if ring, err = p.ring(); err == nil && len(ring) != 1 {
+				err = spatialError(typeName, "FormatException", 24117,
+					"The Point input is not valid because it does not have exactly one point.")
+			}
+			s.rings = [][]spatialPoint{ring}
+		case "LINESTRING":
+			var ring []spatialPoint
+			if ring, err = p.ring(); err == nil && len(ring) < 2 {
+				err = spatialError(typeName, "FormatException", 24117,
+					"The LineString input is not valid because it does not have enough distinct points. A LineString must have at least two distinct points.")
+			}
+			s.rings = [][]spatialPoint{ring}
+		case "POLYGON":
+			s.rings, err = p.polygon()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.space()
+	if p.pos < len(p.text) {
+		return nil, p.expected("end of input")
+	}
+	if s.geography {
+		for _, pt := range s.points() {
+			if pt.y < -90 || pt.y > 90 {
+				return nil, spatialError(typeName, "FormatException", 24201,
+					"Latitude values must be between -90 and 90 degrees.")
+			}
+		}
+	}
+	return s, nil
+}
+
+// wktParser reads well-known text.
+type wktParser struct {
+	text     string
+	pos      int
+	typeName string
+}
+
+func (p *wktParser) space() {
+	for p.pos < len(p.text) && strings.IndexByte(" \t\r\n", p.text[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// peekWord returns the letters at the current position.
+func (p *wktParser) peekWord() string {
+	p.space()
+	end := p.pos
+	for end < len(p.text) && (p.text[end]|0x20 >= 'a' && p.text[end]|0x20 <= 'z') {
+		end++
+	}
+	return p.text[p.pos:end]
+}
+
+// word consumes the letters at the current position.
+func (p *wktParser) word() string {
+	w := p.peekWord()
+	p.pos += len(w)
+	return w
+}
+
+// expected returns the error for input other than want at the current
+// position.
+func (p *wktParser) expected(want string) error {
+	return spatialError(p.typeName, "FormatException", 24142, fmt.Sprintf(
+		"Expected \"%s\" at position %d. The input has \"%s\".", want, p.pos+1, p.rest()))
+}
+
+// rest returns the start of the unread input, for error messages.
+func (p *wktParser) rest() string {
+	rest := p.text[p.pos:]
+	if len(rest) > 10 {
+		rest = rest[:10]
+	}
+	return rest
+}
+
+// consume reads the punctuation c, or fails.
+func (p *wktParser) consume(c byte) error {
+	p.space()
+	if p.pos >= len(p.text) || p.text[p.pos] != c {
+		return p.expected(string(c))
+	}
+	p.pos++
+	return nil
+}
+
+// next reports whether the punctuation c follows, consuming it if so.
+func (p *wktParser) next(c byte) bool {
+	p.space()
+	if p.pos < len(p.text) && p.text[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// number reads a coordinate.
+func (p *wktParser) number() (float64, error) {
+	p.space()
+	end := p.pos
+	for end < len(p.text) && strings.IndexByte("+-.0123456789eE", p.text[end]) >= 0 {
+		end++
+	}
+	f, err := strconv.ParseFloat(p.text[p.pos:end], 64)
+	if err != nil {
+		return 0, spatialError(p.typeName, "FormatException", 24141, fmt.Sprintf(
+			"A number is expected at position %d of the input. The input has %s.", p.pos+1, p.rest()))
+	}
+	p.pos = end
+	return f, nil
+}
+
+// ring reads a parenthesised list of points. Z and M values are read and
+// dropped.
+func (p *wktParser) ring() ([]spatialPoint, error) {
+	if err := p.consume('('); err != nil {
+		return nil, err
+	}
+	var pts []spatialPoint
+	for {
+		x, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		y, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		pts = append(pts, spatialPoint{x, y})
+		for extra := 0; extra < 2; extra++ {
+			p.space()
+			if strings.EqualFold(p.peekWord(), "NULL") {
+				p.word()
+			} else if p.pos < len(p.text) && strings.IndexByte("+-.0123456789", p.text[p.pos]) >= 0 {
+				if _, err := p.number(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if !p.next(',') {
+			break
+		}
+	}
+	return pts, p.consume(')')
+}
+
+// polygon reads a polygon's rings, each of which must be closed.
+func (p *wktParser) polygon() ([][]spatialPoint, error) {
+	if err := p.consume('('); err != nil {
+		return nil, err
+	}
+	var rings [][]spatialPoint
+	for {
+		ring, err := p.ring()
+		if err != nil {
+			return nil, err
+		}
+		if len(ring) < 4 {
+			return nil, spatialError(p.typeName, "FormatException", 24305,
+				"The Polygon input is not valid because the ring does not have enough points. Each ring of a polygon must contain at least four points.")
+		}
+		if ring[0] != ring[len(ring)-1] {
+			return nil, spatialError(p.typeName, "FormatException", 24306,
+				"The Polygon input is not valid because the start and end points of the ring are not the same. Each ring of a polygon must have the same start and end points.")
+		}
+		rings = append(rings, ring)
+		if !p.next(',') {
+			break
+		}
+	}
+	return rings, p.consume(')')
+}
+
+// -----------------------------------------------------------------------------
+// Static and instance methods
+// -----------------------------------------------------------------------------
+
+// evaluateStaticMethodCall evaluates geography::Point(...) and the other
+// constructors of the spatial types.
+func (e *ExpressionEvaluator) evaluateStaticMethodCall(sm *ast.StaticMethodCall) (Value, error) {
+	typeName := strings.ToLower(sm.TypeName)
+	if typeName != "geography" && typeName != "geometry" {
+		return Value{}, fmt.Errorf("unsupported static method call: %s", sm.String())
+	}
+	want, err := spatialStaticArity(typeName, sm.MethodName)
+	if err != nil {
+		return Value{}, err
+	}
+	if len(sm.Arguments) != want {
+		return Value{}, NewSQLError(ErrInvalidParameter, fmt.Sprintf(
+			"%s::%s expects %d arguments.", typeName, sm.MethodName, want))
+	}
+	args := make([]Value, len(sm.Arguments))
+	for i, arg := range sm.Arguments {
+		v, err := e.Evaluate(arg)
+		if err != nil {
+			return Value{}, err
+		}
+		args[i] = v
+	}
+	return spatialStatic(typeName, sm.MethodName, args)
+}
+
+// spatialStaticArity returns the number of arguments the static method of
+// typeName takes.
+func spatialStaticArity(typeName, method string) (int, error) {
+	switch strings.ToUpper(method) {
+	case "POINT":
+		return 3, nil
+	case "PARSE":
+		return 1, nil
+	case "STGEOMFROMTEXT", "STPOINTFROMTEXT", "STLINEFROMTEXT", "STPOLYFROMTEXT":
+		return 2, nil
+	}
+	return 0, spatialMethodNotFound(typeName, method)
+}
+
+// spatialStatic calls the static method of typeName with the arguments
+// spatialStaticArity asks for.
+func spatialStatic(typeName, method string, args []Value) (Value, error) {
+	for _, a := range args {
+		if a.IsNull {
+			return Null(TypeVarChar), nil
+		}
+	}
+
+	if strings.EqualFold(method, "POINT") {
+		s := &spatialShape{kind: "POINT", geography: typeName == "geography", srid: int(args[2].AsInt())}
+		if s.geography {
+			// geography::Point takes latitude first
+			lat, long := args[0].AsFloat(), args[1].AsFloat()
+			if lat < -90 || lat > 90 {
+				return Value{}, spatialError(typeName, "ArgumentException", 24201,
+					"Latitude values must be between -90 and 90 degrees.")
+			}
+			s.rings = [][]spatialPoint{{{long, lat}}}
+		} else {
+			s.rings = [][]spatialPoint{{{args[0].AsFloat(), args[1].AsFloat()}}}
+		}
+		return spatialValue(s), nil
+	}
+
+	srid := 0
+	if typeName == "geography" {
+		srid = 4326
+	}
+	if len(args) == 2 {
+		srid = int(args[1].AsInt())
+	}
+	s, err := parseWKT(args[0].AsString(), typeName, srid)
+	if err != nil {
+		return Value{}, err
+	}
+	if kind := strings.TrimSuffix(strings.TrimPrefix(strings.ToUpper(method), "ST"), "FROMTEXT"); kind != "GEOM" && kind != "PARSE" {
+		if !strings.HasPrefix(s.kind, kind) {
+			return Value{}, spatialError(typeName, "FormatException", 24142, fmt.Sprintf(
+				"Expected \"%s\" at position 1. The input has \"%s\".", kind, s.kind))
+		}
+	}
+	return spatialValue(s), nil
+}
+
+// spatialMembers are the instance methods and properties emulated, with
+// the number of arguments each method takes; properties take none and
+// are called without parentheses.
+var spatialMembers = map[string]struct {
+	args     int
+	property bool
+}{
+	"STDISTANCE":     {args: 1},
+	"STWITHIN":       {args: 1},
+	"STCONTAINS":     {args: 1},
+	"STINTERSECTS":   {args: 1},
+	"STBUFFER":       {args: 1},
+	"STASTEXT":       {},
+	"TOSTRING":       {},
+	"STGEOMETRYTYPE": {},
+	"STNUMPOINTS":    {},
+	"LAT":            {property: true},
+	"LONG":           {property: true},
+	"STX":            {property: true},
+	"STY":            {property: true},
+	"STSRID":         {property: true},
+}
+
+// evaluateMethodCall evaluates a method or property of a spatial instance.
+func (e *ExpressionEvaluator) evaluateMethodCall(mc *ast.MethodCallExpression) (Value, error) {
+	// dbo.fn(...) parses as a method of dbo; call fn(...) instead
+	if obj, ok := mc.Object.(*ast.Identifier); ok && mc.Arguments != nil &&
+		strings.EqualFold(strings.Trim(obj.Value, "[]"), "dbo") {
+		return e.evaluateFunctionCall(&ast.FunctionCall{
+			Token:     mc.Token,
+			Function:  &ast.Identifier{Token: mc.Token, Value: mc.MethodName},
+			Arguments: mc.Arguments,
+		})
+	}
+	obj, err := e.Evaluate(mc.Object)
+	if err != nil {
+		return Value{}, err
+	}
+	if _, ok := spatialMembers[strings.ToUpper(mc.MethodName)]; !ok {
+		if s, err := parseSpatial(obj.AsString()); err == nil && !obj.IsNull {
+			return Value{}, spatialMethodNotFound(s.typeName(), mc.MethodName)
+		}
+		return Value{}, fmt.Errorf("unsupported method call: %s", mc.String())
+	}
+	args := make([]Value, len(mc.Arguments))
+	for i, arg := range mc.Arguments {
+		v, err := e.Evaluate(arg)
+		if err != nil {
+			return Value{}, err
+		}
+		args[i] = v
+	}
+	return spatialMethod(obj, mc.MethodName, args, mc.Arguments == nil)
+}
+
+// spatialMethod calls method on the instance obj. A NULL instance or
+// argument gives NULL, as do instances with different SRIDs.
+func spatialMethod(obj Value, method string, args []Value, property bool) (Value, error) {
+	name := strings.ToUpper(method)
+	member := spatialMembers[name]
+	if obj.IsNull {
+		return Null(TypeUnknown), nil
+	}
+	s, err := parseSpatial(obj.AsString())
+	if err != nil {
+		return Value{}, err
+	}
+	if member.property != property || len(args) != member.args {
+		return Value{}, spatialMethodNotFound(s.typeName(), method)
+	}
+
+	switch name {
+	case "STASTEXT", "TOSTRING":
+		return NewNVarChar(s.WKT(), -1), nil
+	case "STGEOMETRYTYPE":
+		return NewNVarChar(map[string]string{"POINT": "Point", "LINESTRING": "LineString", "POLYGON": "Polygon"}[s.kind], -1), nil
+	case "STNUMPOINTS":
+		return NewInt(int64(len(s.points()))), nil
+	case "STSRID":
+		return NewInt(int64(s.srid)), nil
+	case "LAT", "LONG", "STX", "STY":
+		if !s.isPoint() {
+			return Null(TypeFloat), nil
+		}
+		if name == "LONG" || name == "STX" {
+			return NewFloat(s.rings[0][0].x), nil
+		}
+		return NewFloat(s.rings[0][0].y), nil
+	}
+
+	if args[0].IsNull {
+		return Null(TypeUnknown), nil
+	}
+	if name == "STBUFFER" {
+		return spatialBuffer(s, args[0].AsFloat())
+	}
+
+	other, err := parseSpatialLike(args[0].AsString(), s)
+	if err != nil {
+		return Value{}, err
+	}
+	if !s.geography && other.geography {
+		s.geography, s.srid = true, other.srid
+	}
+	if s.srid != other.srid {
+		return Null(TypeUnknown), nil
+	}
+	switch name {
+	case "STDISTANCE":
+		if len(s.rings) == 0 || len(other.rings) == 0 {
+			return Null(TypeFloat), nil
+		}
+		if !s.geography {
+			return NewFloat(planarDistance(s, other)), nil
+		}
+		if !s.isPoint() || !other.isPoint() {
+			return Value{}, fmt.Errorf("STDistance between geography %s and %s instances is not supported",
+				strings.ToLower(s.kind), strings.ToLower(other.kind))
+		}
+		return NewFloat(haversine(s.rings[0][0], other.rings[0][0])), nil
+	case "STWITHIN":
+		return NewBit(covers(other, s)), nil
+	case "STCONTAINS":
+		return NewBit(covers(s, other)), nil
+	case "STINTERSECTS":
+		return NewBit(len(s.rings) > 0 && len(other.rings) > 0 && planarDistance(s, other) == 0), nil
+	}
+	return Value{}, spatialMethodNotFound(s.typeName(), method)
+}
+
+// spatialProperty evaluates a property read like a column, such as
+// loc.Lat, where loc holds a spatial instance.
+func (e *ExpressionEvaluator) spatialProperty(qi *ast.QualifiedIdentifier) (Value, bool, error) {
+	n := len(qi.Parts)
+	if n < 2 {
+		return Value{}, false, nil
+	}
+	name := qi.Parts[n-1].Value
+	if !spatialMembers[strings.ToUpper(name)].property {
+		return Value{}, false, nil
+	}
+	obj, ok := e.GetVariable(qi.Parts[n-2].Value)
+	if !ok {
+		return Value{}, false, nil
+	}
+	v, err := spatialMethod(obj, name, nil, true)
+	return v, true, err
+}
+
+// -----------------------------------------------------------------------------
+// Geometry
+// -----------------------------------------------------------------------------
+
+// haversine returns the great-circle distance in metres between two
+// longitude/latitude points.
+func haversine(a, b spatialPoint) float64 {
+	rad := math.Pi / 180
+	dLat := (b.y - a.y) * rad
+	dLong := (b.x - a.x) * rad
+	h := math.Pow(math.Sin(dLat/2), 2) +
+		math.Cos(a.y*rad)*math.Cos(b.y*rad)*math.Pow(math.Sin(dLong/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// spatialBuffer returns the polygon around a point at distance d: metres
+// for geography, the instance's units for geometry.
+func spatialBuffer(s *spatialShape, d float64) (Value, error) {
+	if len(s.rings) == 0 || d <= 0 {
+		return spatialValue(s), nil
+	}
+	if !s.isPoint() {
+		return Value{}, fmt.Errorf("STBuffer of a %s instance is not supported", strings.ToLower(s.kind))
+	}
+	centre := s.rings[0][0]
+	ring := make([]spatialPoint, spatialBufferSegments+1)
+	for i := 0; i < spatialBufferSegments; i++ {
+		theta := 2 * math.Pi * float64(i) / spatialBufferSegments
+		if !s.geography {
+			ring[i] = spatialPoint{centre.x + d*math.Cos(theta), centre.y + d*math.Sin(theta)}
+			continue
+		}
+		// The point d metres from the centre on bearing theta, walking the
+		// bearings anticlockwise so the polygon's interior is on the left
+		rad := math.Pi / 180
+		bearing := -theta
+		lat1, long1 := centre.y*rad, centre.x*rad
+		delta := d / earthRadius
+		lat2 := math.Asin(math.Sin(lat1)*math.Cos(delta) + math.Cos(lat1)*math.Sin(delta)*math.Cos(bearing))
+		long2 := long1 + math.Atan2(math.Sin(bearing)*math.Sin(delta)*math.Cos(lat1),
+			math.Cos(delta)-math.Sin(lat1)*math.Sin(lat2))
+		ring[i] = spatialPoint{long2 / rad, lat2 / rad}
+	}
+	ring[spatialBufferSegments] = ring[0]
+	return spatialValue(&spatialShape{kind: "POLYGON", rings: [][]spatialPoint{ring}, geography: s.geography, srid: s.srid}), nil
+}
+
+// covers reports whether every vertex of b lies in or on a.
+func covers(a, b *spatialShape) bool {
+	if len(a.rings) == 0 || len(b.rings) == 0 {
+		return false
+	}
+	for _, p := range b.points() {
+		if !coversPoint(a, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// coversPoint reports whether p lies in or on a.
+func coversPoint(a *spatialShape, p spatialPoint) bool {
+	for _, seg := range a.segments() {
+		if pointSegmentDistance(p, seg[0], seg[1]) == 0 {
+			return true
+		}
+	}
+	if a.kind != "POLYGON" || !inRing(p, a.rings[0]) {
+		return false
+	}
+	for _, hole := range a.rings[1:] {
+		if inRing(p, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+// inRing reports whether p is inside ring, by counting the ring's edges a
+// ray from p crosses.
+func inRing(p spatialPoint, ring []spatialPoint) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.y > p.y) != (b.y > p.y) && p.x < (b.x-a.x)*(p.y-a.y)/(b.y-a.y)+a.x {
+			in = !in
+		}
+	}
+	return in
+}
+
+// segments returns the instance's edges; a point is an edge of no length.
+func (s *spatialShape) segments() [][2]spatialPoint {
+	var segs [][2]spatialPoint
+	for _, ring := range s.rings {
+		if len(ring) == 1 {
+			segs = append(segs, [2]spatialPoint{ring[0], ring[0]})
+		}
+		for i := 1; i < len(ring); i++ {
+			segs = append(segs, [2]spatialPoint{ring[i-1], ring[i]})
+		}
+	}
+	return segs
+}
+
+// planarDistance returns the shortest planar distance between a and b,
+// zero where they touch or one contains part of the other.
+func planarDistance(a, b *spatialShape) float64 {
+	if (a.kind == "POLYGON" && coversPoint(a, b.rings[0][0])) ||
+		(b.kind == "POLYGON" && coversPoint(b, a.rings[0][0])) {
+		return 0
+	}
+	d := math.Inf(1)
+	for _, s := range a.segments() {
+		for _, t := range b.segments() {
+			if segmentsCross(s[0], s[1], t[0], t[1]) {
+				return 0
+			}
+			d = math.Min(d, math.Min(
+				math.Min(pointSegmentDistance(s[0], t[0], t[1]), pointSegmentDistance(s[1], t[0], t[1])),
+				math.Min(pointSegmentDistance(t[0], s[0], s[1]), pointSegmentDistance(t[1], s[0], s[1]))))
+		}
+	}
+	return d
+}
+
+// pointSegmentDistance returns the distance from p to the segment ab.
+func pointSegmentDistance(p, a, b spatialPoint) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	if dx == 0 && dy == 0 {
+		return math.Hypot(p.x-a.x, p.y-a.y)
+	}
+	t := math.Max(0, math.Min(1, ((p.x-a.x)*dx+(p.y-a.y)*dy)/(dx*dx+dy*dy)))
+	return math.Hypot(p.x-(a.x+t*dx), p.y-(a.y+t*dy))
+}
+
+// segmentsCross reports whether the segments ab and cd cross.
+func segmentsCross(a, b, c, d spatialPoint) bool {
+	side := func(p, q, r spatialPoint) float64 {
+		return (q.x-p.x)*(r.y-p.y) - (q.y-p.y)*(r.x-p.x)
+	}
+	d1, d2 := side(c, d, a), side(c, d, b)
+	d3, d4 := side(a, b, c), side(a, b, d)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+// -----------------------------------------------------------------------------
+// SQLite translation
+// -----------------------------------------------------------------------------
+
+// In queries against SQLite an instance is the text in its column, so the
+// static methods build that text and the instance methods take points'
+// coordinates back out of it. Only points are understood there: STWithin,
+// STContains and STIntersects are translated when the other side is a
+// point's STBuffer, the usual way of asking for "within d of here", and
+// become a distance comparison.
+
+// spatialSQLX returns SQL for the x coordinate (longitude) of the point
+// whose text wkt computes.
+func spatialSQLX(wkt string) string {
+	return "CAST(ltrim(substr(" + wkt + ", instr(" + wkt + ", '(') + 1)) AS REAL)"
+}
+
+// spatialSQLY returns SQL for the y coordinate (latitude) of a point.
+func spatialSQLY(wkt string) string {
+	coords := "ltrim(substr(" + wkt + ", instr(" + wkt + ", '(') + 1))"
+	return "CAST(ltrim(substr(" + coords + ", instr(" + coords + ", ' ') + 1)) AS REAL)"
+}
+
+// spatialSQLIsGeography returns SQL testing whether wkt carries an SRID.
+func spatialSQLIsGeography(wkt string) string {
+	return wkt + " LIKE 'SRID=%'"
+}
+
+// spatialSQLDistance returns SQL for the distance between two points: in
+// metres if either is geography, else planar.
+func spatialSQLDistance(a, b string) string {
+	x1, y1, x2, y2 := spatialSQLX(a), spatialSQLY(a), spatialSQLX(b), spatialSQLY(b)
+	return fmt.Sprintf("(CASE WHEN %s OR %s THEN "+
+		"2 * %s * asin(min(1, sqrt(power(sin(radians(%s - %s) / 2), 2) + cos(radians(%s)) * cos(radians(%s)) * power(sin(radians(%s - %s) / 2), 2)))) "+
+		"ELSE sqrt(power(%s - %s, 2) + power(%s - %s, 2)) END)",
+		spatialSQLIsGeography(a), spatialSQLIsGeography(b),
+		formatSpatialNumber(earthRadius), y2, y1, y1, y2, x2, x1,
+		x2, x1, y2, y1)
+}
+
+// rewriteSpatialPoint converts geography::Point(lat, long, srid) and
+// geometry::Point(x, y, srid) to the point's text.
+func (r *SQLiteRewriter) rewriteSpatialPoint(sm *ast.StaticMethodCall) ast.Expression {
+	if len(sm.Arguments) != 3 {
+		return sm
+	}
+	a, b, srid := sm.Arguments[0].String(), sm.Arguments[1].String(), sm.Arguments[2].String()
+	if strings.EqualFold(sm.TypeName, "geography") {
+		return &ast.Identifier{Token: sm.Token,
+			Value: "('SRID=' || " + srid + " || ';POINT (' || " + b + " || ' ' || " + a + " || ')')"}
+	}
+	return &ast.Identifier{Token: sm.Token, Value: "('POINT (' || " + a + " || ' ' || " + b + " || ')')"}
+}
+
+// rewriteSpatialFromText converts STGeomFromText(wkt, srid) and its
+// relatives to the instance's text. The WKT is stored as given.
+func (r *SQLiteRewriter) rewriteSpatialFromText(sm *ast.StaticMethodCall) ast.Expression {
+	if len(sm.Arguments) == 0 || len(sm.Arguments) > 2 {
+		return sm
+	}
+	wkt := sm.Arguments[0].String()
+	if !strings.EqualFold(sm.TypeName, "geography") {
+		return &ast.Identifier{Token: sm.Token, Value: "(" + wkt + ")"}
+	}
+	srid := "4326"
+	if len(sm.Arguments) == 2 {
+		srid = sm.Arguments[1].String()
+	}
+	return &ast.Identifier{Token: sm.Token, Value: "('SRID=' || " + srid + " || ';' || " + wkt + ")"}
+}
+
+// rewriteSTDistance converts a.STDistance(b) to the distance between the
+// points.
+func (r *SQLiteRewriter) rewriteSTDistance(mc *ast.MethodCallExpression) ast.Expression {
+	if len(mc.Arguments) != 1 {
+		return mc
+	}
+	return &ast.Identifier{Token: mc.Token, Value: spatialSQLDistance(mc.Object.String(), mc.Arguments[0].String())}
+}
+
+// rewriteSTWithin converts a.STWithin(b.STBuffer(d)), and STContains and
+// STIntersects written either way round, to a.STDistance(b) <= d.
+func (r *SQLiteRewriter) rewriteSTWithin(mc *ast.MethodCallExpression) ast.Expression {
+	if len(mc.Arguments) != 1 {
+		return mc
+	}
+	buffer := func(e ast.Expression) *ast.MethodCallExpression {
+		if b, ok := e.(*ast.MethodCallExpression); ok && strings.EqualFold(b.MethodName, "STBuffer") && len(b.Arguments) == 1 {
+			return b
+		}
+		return nil
+	}
+	point, buf := mc.Object, buffer(mc.Arguments[0])
+	name := strings.ToUpper(mc.MethodName)
+	if buf == nil || name == "STCONTAINS" {
+		point, buf = mc.Arguments[0], buffer(mc.Object)
+		if buf == nil || name == "STWITHIN" {
+			return mc
+		}
+	}
+	return &ast.Identifier{Token: mc.Token, Value: "(" +
+		spatialSQLDistance(point.String(), buf.Object.String()) + " <= " + buf.Arguments[0].String() + ")"}
+}
+
+// rewriteSTAsText converts STAsText() and ToString() to the text without
+// its SRID.
+func (r *SQLiteRewriter) rewriteSTAsText(mc *ast.MethodCallExpression) ast.Expression {
+	if len(mc.Arguments) != 0 {
+		return mc
+	}
+	wkt := mc.Object.String()
+	return &ast.Identifier{Token: mc.Token, Value: "(CASE WHEN " + spatialSQLIsGeography(wkt) +
+		" THEN substr(" + wkt + ", instr(" + wkt + ", ';') + 1) ELSE " + wkt + " END)"}
+}
+
+// rewriteSpatialCoordinate converts the Lat, Long, STX and STY properties
+// of a point.
+func (r *SQLiteRewriter) rewriteSpatialCoordinate(mc *ast.MethodCallExpression) ast.Expression {
+	switch strings.ToUpper(mc.MethodName) {
+	case "LONG", "STX":
+		return &ast.Identifier{Token: mc.Token, Value: spatialSQLX(mc.Object.String())}
+	default:
+		return &ast.Identifier{Token: mc.Token, Value: spatialSQLY(mc.Object.String())}
+	}
+}
+
+// rewriteSTSrid converts the STSrid property: geometry's is 0.
+func (r *SQLiteRewriter) rewriteSTSrid(mc *ast.MethodCallExpression) ast.Expression {
+	wkt := mc.Object.String()
+	return &ast.Identifier{Token: mc.Token, Value: "(CASE WHEN " + spatialSQLIsGeography(wkt) +
+		" THEN CAST(substr(" + wkt + ", 6) AS INTEGER) ELSE 0 END)"}
+}
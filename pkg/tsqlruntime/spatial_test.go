@@ -0,0 +1,204 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// spatialRows runs sql on a fresh interpreter and returns the rows of its
+// last result set.
+func spatialRows(t *testing.T, db *sql.DB, sql string) []string {
+	t.Helper()
+	result, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(), sql, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	return lastRows(result)
+}
+
+func TestSpatialScalars(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	const declare = "DECLARE @pike geography = geography::Point(47.6097, -122.3422, 4326); " +
+		"DECLARE @needle geography = geography::Point(47.6205, -122.3493, 4326); " +
+		"DECLARE @square geometry = geometry::STGeomFromText('POLYGON((0 0, 10 0, 10 10, 0 10, 0 0), (4 4, 6 4, 6 6, 4 6, 4 4))', 0); "
+
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"point text", "@pike", "SRID=4326;POINT (-122.3422 47.6097)"},
+		{"geometry point", "geometry::Point(3, 4, 0)", "POINT (3 4)"},
+		{"from text normalised", "geography::STGeomFromText('point(-0.1275   51.50722)', 4326).STAsText()", "POINT (-0.1275 51.50722)"},
+		{"parse", "geography::Parse('POINT(1 2)').STSrid", "4326"},
+		{"typed constructor", "geometry::STLineFromText('LINESTRING(0 0, 3 4)', 0).STGeometryType()", "LineString"},
+		{"z and m dropped", "geometry::Parse('POINT(1 2 3 NULL)').ToString()", "POINT (1 2)"},
+		{"lat", "@pike.Lat", "47.6097"},
+		{"long", "@pike.Long", "-122.3422"},
+		{"stx", "geometry::Point(3, 4, 0).STX", "3"},
+		{"lat of a polygon", "@square.STY", "NULL"},
+		{"null argument", "geography::Point(NULL, 1, 4326)", "NULL"},
+		{"method of null", "geography::Point(NULL, 1, 4326).STDistance(@pike)", "NULL"},
+		{"geography distance", "ROUND(@pike.STDistance(@needle), 0)", "1314"},
+		{"distance to itself", "@pike.STDistance(@pike)", "0"},
+		{"geometry distance", "geometry::Point(3, 4, 0).STDistance(geometry::Point(0, 0, 0))", "5"},
+		{"distance to polygon", "@square.STDistance(geometry::Point(13, 14, 0))", "5"},
+		{"distance inside polygon", "@square.STDistance(geometry::Point(1, 1, 0))", "0"},
+		{"wkt argument", "@pike.STDistance('POINT(-122.3422 47.6097)')", "0"},
+		{"srid mismatch", "@pike.STDistance(geography::Point(47.6, -122.3, 4269))", "NULL"},
+		{"within buffer", "@needle.STWithin(@pike.STBuffer(1500))", "1"},
+		{"outside buffer", "@needle.STWithin(@pike.STBuffer(1000))", "0"},
+		{"buffer points", "@pike.STBuffer(100).STNumPoints()", "33"},
+		{"contains", "@square.STContains(geometry::Point(2, 8, 0))", "1"},
+		{"hole", "@square.STContains(geometry::Point(5, 5, 0))", "0"},
+		{"outside", "geometry::Point(11, 5, 0).STWithin(@square)", "0"},
+		{"intersects", "@square.STIntersects(geometry::STGeomFromText('LINESTRING(-5 5, 5 -5)', 0))", "1"},
+		{"disjoint", "@square.STIntersects(geometry::STGeomFromText('LINESTRING(-5 5, -1 1)', 0))", "0"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := spatialRows(t, db, declare+"SELECT "+tc.expr)
+			if len(got) != 1 || got[0] != tc.want {
+				t.Errorf("%s = %v, want %s", tc.expr, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSpatialErrors(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	interp := NewInterpreter(db, DialectSQLite)
+
+	tests := []struct {
+		sql    string
+		number int
+	}{
+		{"SELECT geography::Point(91, 0, 4326)", ErrCLRRoutine},
+		{"SELECT geography::STGeomFromText('POINT(0 95)', 4326)", ErrCLRRoutine},
+		{"SELECT geometry::STGeomFromText('CIRCLE(1 2)', 0)", ErrCLRRoutine},
+		{"SELECT geometry::STGeomFromText('POINT(1 x)', 0)", ErrCLRRoutine},
+		{"SELECT geometry::STGeomFromText('POLYGON((0 0, 1 0, 1 1, 0 0.5))', 0)", ErrCLRRoutine},
+		{"SELECT geometry::STPointFromText('LINESTRING(0 0, 1 1)', 0)", ErrCLRRoutine},
+		{"SELECT geometry::STGeomFromWKB(0x00, 0)", ErrMethodNotFound},
+		{"DECLARE @g geometry = geometry::Point(1, 2, 0); SELECT @g.STArea()", ErrMethodNotFound},
+		{"DECLARE @g geometry = geometry::Point(1, 2, 0); SELECT @g.STAsText", ErrMethodNotFound},
+	}
+	for _, tc := range tests {
+		_, err := interp.Execute(context.Background(), tc.sql, nil)
+		wantSQLError(t, tc.sql, err, tc.number)
+	}
+}
+
+func TestSpatialTempTable(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	got := spatialRows(t, db, "CREATE TABLE #stops (id INT, loc GEOGRAPHY); "+
+		"INSERT INTO #stops VALUES (1, geography::Point(47.6097, -122.3422, 4326)), "+
+		"(2, geography::Point(47.2529, -122.4443, 4326)); "+
+		"DECLARE @here geography = geography::Point(47.6101, -122.3421, 4326); "+
+		"SELECT id, loc.Lat FROM #stops WHERE loc.STDistance(@here) < 1000")
+	if want := []string{"1 47.6097"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSpatialRewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		contains string
+		excludes string
+	}{
+		{"point", "INSERT INTO t VALUES (geography::Point(@lat, @long, 4326))",
+			"('SRID=' || 4326 || ';POINT (' || @long || ' ' || @lat || ')')", "::"},
+		{"geometry from text", "INSERT INTO t VALUES (geometry::STGeomFromText(@wkt, 0))", "(@wkt)", "::"},
+		{"distance", "SELECT loc.STDistance(@p) FROM t", "2 * 6371008.8 * asin(", "STDistance"},
+		{"within buffer", "SELECT * FROM t WHERE loc.STWithin(@p.STBuffer(@d)) = 1", " <= @d)", "STWithin"},
+		{"contains written the other way", "SELECT * FROM t WHERE @p.STBuffer(@d).STContains(loc) = 1", " <= @d)", "STContains"},
+		{"within a polygon is left alone", "SELECT * FROM t WHERE loc.STWithin(@area) = 1", "loc.STWithin(@area)", ""},
+		{"property of a column", "SELECT loc.Lat FROM t", "CAST(ltrim(substr(", "loc.Lat"},
+		{"property of a qualified column", "SELECT s.loc.Long FROM t AS s", "CAST(ltrim(substr(s.loc, ", "s.loc.Long"},
+		{"column named like a property", "SELECT s.Lat FROM t AS s", `"s"."Lat"`, "CAST"},
+		{"table named like a column", "SELECT loc.Lat FROM loc", `"loc"."Lat"`, "CAST"},
+		{"column of an outer query", "SELECT * FROM t WHERE EXISTS (SELECT 1 FROM u WHERE t.Lat = u.id)", `"t"."Lat"`, "CAST"},
+		{"srid", "UPDATE t SET srid = loc.STSrid", "CAST(substr(loc, 6) AS INTEGER)", "STSrid"},
+		{"as text", "SELECT loc.STAsText() FROM t", "substr(loc, instr(loc, ';') + 1)", "STAsText"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			output := NewSQLiteRewriter().RewriteStatement(parseSQL(t, tc.input)).String()
+			if !strings.Contains(output, tc.contains) {
+				t.Errorf("%s\ngot:  %s\nwant it to contain %q", tc.input, output, tc.contains)
+			}
+			if tc.excludes != "" && strings.Contains(output, tc.excludes) {
+				t.Errorf("%s\ngot:  %s\nwant it not to contain %q", tc.input, output, tc.excludes)
+			}
+		})
+	}
+}
+
+// TestSpatialQueries runs the translated methods against SQLite, which
+// needs a build with its math functions (see the Makefile).
+func TestSpatialQueries(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if _, err := db.Exec("SELECT asin(sqrt(radians(1)))"); err != nil {
+		t.Skip("SQLite built without math functions")
+	}
+	if _, err := db.Exec("CREATE TABLE stores (id INTEGER, name TEXT, location TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	interp := NewInterpreter(db, DialectSQLite)
+	if _, err := interp.Execute(context.Background(),
+		"INSERT INTO stores VALUES (1, 'Pike Place', geography::Point(47.6097, -122.3422, 4326)), "+
+			"(2, 'Space Needle', geography::Point(47.6205, -122.3493, 4326)), "+
+			"(3, 'Tacoma', geography::STGeomFromText('POINT(-122.4443 47.2529)', 4326)), "+
+			"(4, 'Pioneer Square', 'POINT(-122.3343 47.6015)')", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const here = "DECLARE @lat FLOAT = 47.6101, @long FLOAT = -122.3421, @radius FLOAT = 2000; " +
+		"DECLARE @here geography = geography::Point(@lat, @long, 4326); "
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"nearby by distance",
+			"SELECT name, ROUND(location.STDistance(@here), 0) FROM stores " +
+				"WHERE location.STDistance(@here) <= @radius ORDER BY location.STDistance(@here)",
+			[]string{"Pike Place 45", "Pioneer Square 1121", "Space Needle 1276"}},
+		{"nearby by buffer",
+			"SELECT s.id FROM stores AS s WHERE s.location.STWithin(@here.STBuffer(@radius)) = 1 ORDER BY s.id",
+			[]string{"1", "2", "4"}},
+		{"coordinates",
+			"SELECT location.Lat, location.Long, location.STSrid FROM stores WHERE id = 3",
+			[]string{"47.2529 -122.4443 4326"}},
+		{"text", "SELECT location.ToString() FROM stores WHERE id = 1", []string{"POINT (-122.3422 47.6097)"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := spatialRows(t, db, here+tc.query)
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSpatial_Parse(t *testing.T) {
+	for _, sql := range []string{
+		"SELECT @g.Lat, @g.STBuffer(5).STX",
+		"SELECT geography::Point(1, 2, 4326).STDistance(@h)",
+	} {
+		if got := parseSQL(t, sql).String(); got != sql {
+			t.Errorf("round trip of %q gave %q", sql, got)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// Statistics are emulated on SQLite with ANALYZE, which gathers what the
+// query planner uses into sqlite_stat1 but records no date. UPDATE
+// STATISTICS t analyzes t and records when, with t's row count, in
+// StatisticsTable; sp_updatestats does so for every table. Each table has
+// one statistics object, stats_id 1, named after the table: STATS_DATE
+// reads its date back and the storage layer's sys.stats lists it.
+// RefreshStatistics analyzes the tables whose row counts have moved, for
+// the server to run on a schedule. Against SQL Server the statements are
+// sent to the backend unchanged and STATS_DATE is answered by it.
+
+// StatisticsTable records when each table's statistics were last
+// gathered on SQLite, and how many rows the table had then.
+const StatisticsTable = "aul_stats"
+
+// TableStatsID is the stats_id of a table's statistics on SQLite.
+const TableStatsID = 1
+
+// statsTimeLayout is how StatisticsTable stores dates.
+const statsTimeLayout = "2006-01-02 15:04:05.000"
+
+// DefaultStatisticsThreshold is the share of a table's rows that must be
+// added or removed before RefreshStatistics analyzes it again.
+const DefaultStatisticsThreshold = 0.2
+
+// ensureStatisticsTable creates StatisticsTable if it is missing.
+func ensureStatisticsTable(ctx context.Context, db QueryExecutor) error {
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+StatisticsTable+
+		" (table_name VARCHAR(128) PRIMARY KEY, object_id INTEGER,"+
+		" last_updated TEXT, row_count INTEGER)")
+	if err != nil {
+		return fmt.Errorf("statistics unavailable: %w", err)
+	}
+	return nil
+}
+
+// UpdateStatistics analyzes table, a table of the SQLite database db, and
+// records the time and its row count in StatisticsTable.
+func UpdateStatistics(ctx context.Context, db QueryExecutor, table string) error {
+	if err := ensureStatisticsTable(ctx, db); err != nil {
+		return err
+	}
+	quoted := quoteSQLiteName(table)
+	if _, err := db.ExecContext(ctx, "ANALYZE "+quoted); err != nil {
+		return fmt.Errorf("ANALYZE %s failed: %w", table, err)
+	}
+	var rows int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoted).Scan(&rows); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "INSERT OR REPLACE INTO "+StatisticsTable+
+		" (table_name, object_id, last_updated, row_count) VALUES (?, ?, ?, ?)",
+		table, objectID(table), time.Now().Format(statsTimeLayout), rows)
+	return err
+}
+
+// RefreshStatistics analyzes the tables of the SQLite database db that
+// have never been analyzed, or whose row count has moved by more than
+// threshold of what it was when they last were, and returns their names.
+// Rows that are updated in place do not count. A threshold that is not
+// positive is DefaultStatisticsThreshold.
+func RefreshStatistics(ctx context.Context, db QueryExecutor, threshold float64) ([]string, error) {
+	if threshold <= 0 {
+		threshold = DefaultStatisticsThreshold
+	}
+	if err := ensureStatisticsTable(ctx, db); err != nil {
+		return nil, err
+	}
+	tables, err := userTables(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var refreshed []string
+	for _, table := range tables {
+		var then sql.NullInt64
+		err := db.QueryRowContext(ctx,
+			"SELECT row_count FROM "+StatisticsTable+" WHERE table_name = ?", table).Scan(&then)
+		if err != nil && err != sql.ErrNoRows {
+			return refreshed, err
+		}
+		if then.Valid {
+			var now int64
+			if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoteSQLiteName(table)).Scan(&now); err != nil {
+				return refreshed, err
+			}
+			if math.Abs(float64(now-then.Int64)) <= threshold*float64(then.Int64) {
+				continue
+			}
+		}
+		if err := UpdateStatistics(ctx, db, table); err != nil {
+			return refreshed, err
+		}
+		refreshed = append(refreshed, table)
+	}
+	return refreshed, nil
+}
+
+// quoteSQLiteName quotes a table name for SQLite.
+func quoteSQLiteName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// userTables returns the tables of the SQLite database db, leaving out
+// SQLite's and aul's internal ones (see internal.go).
+func userTables(ctx context.Context, db QueryExecutor) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND "+
+		UserTableFilter()+" ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// executeUpdateStatistics runs UPDATE STATISTICS. On SQLite the whole
+// table is analyzed, whatever statistics and options are named.
+func (i *Interpreter) executeUpdateStatistics(ctx context.Context, s *ast.UpdateStatisticsStatement) error {
+	switch i.ctx.Dialect {
+	case DialectSQLServer:
+		_, err := i.exec(ctx, s.String())
+		return err
+	case DialectSQLite:
+	default:
+		return nil // the backend keeps its own statistics
+	}
+
+	name := s.Table.String()
+	var table string
+	err := i.ctx.GetExecutor().QueryRowContext(ctx,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name = ? COLLATE NOCASE",
+		i.backendName(name)).Scan(&table)
+	if err == sql.ErrNoRows {
+		return NewSQLError(ErrObjectNotFound, fmt.Sprintf(
+			`Cannot find the object "%s" because it does not exist or you do not have permissions.`, name))
+	}
+	if err != nil {
+		return err
+	}
+	return UpdateStatistics(ctx, i.ctx.GetExecutor(), table)
+}
+
+// spUpdateStats runs sp_updatestats, analyzing every table.
+func (i *Interpreter) spUpdateStats(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	if i.ctx.Dialect != DialectSQLite {
+		return nil
+	}
+	db := i.ctx.GetExecutor()
+	tables, err := userTables(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err := UpdateStatistics(ctx, db, table); err != nil {
+			return err
+		}
+		result.Warnings = append(result.Warnings, "Updating [dbo].["+table+"]")
+	}
+	result.Warnings = append(result.Warnings, "Statistics for all tables have been updated.")
+	return nil
+}
+
+// statsDate answers STATS_DATE(object_id, stats_id): when the statistics
+// were last updated, or NULL if they never were or do not exist.
+func (ec *ExecutionContext) statsDate(objectID, statsID int64) (Value, error) {
+	ctx := context.Background()
+	switch ec.Dialect {
+	case DialectSQLServer:
+		var t sql.NullTime
+		err := ec.GetExecutor().QueryRowContext(ctx,
+			fmt.Sprintf("SELECT STATS_DATE(%d, %d)", objectID, statsID)).Scan(&t)
+		if err != nil {
+			return Value{}, err
+		}
+		if !t.Valid {
+			return Null(TypeDateTime), nil
+		}
+		return NewDateTime(t.Time), nil
+	case DialectSQLite:
+	default:
+		return Null(TypeDateTime), nil
+	}
+
+	if statsID != TableStatsID {
+		return Null(TypeDateTime), nil
+	}
+	var updated sql.NullString
+	err := ec.GetExecutor().QueryRowContext(ctx,
+		"SELECT last_updated FROM "+StatisticsTable+" WHERE object_id = ?", objectID).Scan(&updated)
+	if err != nil || !updated.Valid {
+		// No row, or no table because nothing was ever analyzed
+		return Null(TypeDateTime), nil
+	}
+	t, err := time.ParseInLocation(statsTimeLayout, updated.String, time.Local)
+	if err != nil {
+		return Value{}, err
+	}
+	return NewDateTime(t), nil
+}
+
+// evaluateStatsDate evaluates STATS_DATE(object_id, stats_id).
+func (e *ExpressionEvaluator) evaluateStatsDate(args []Value) (Value, error) {
+	if len(args) != 2 {
+		return Value{}, fmt.Errorf("STATS_DATE takes 2 arguments, object_id and stats_id")
+	}
+	if args[0].IsNull || args[1].IsNull || e.statsDate == nil {
+		return Null(TypeDateTime), nil
+	}
+	return e.statsDate(args[0].AsInt(), args[1].AsInt())
+}
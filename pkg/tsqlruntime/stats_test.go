@@ -0,0 +1,109 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUpdateStatistics(t *testing.T) {
+	interp := sysprocSetup(t)
+	ctx := context.Background()
+
+	// Nothing has been analyzed yet
+	result, err := interp.Execute(ctx, "SELECT STATS_DATE(OBJECT_ID('dbo.orders'), 1) AS d", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := result.ResultSets[0].Rows[0][0]; !d.IsNull {
+		t.Errorf("STATS_DATE before ANALYZE = %v", d)
+	}
+
+	before := time.Now().Add(-time.Second)
+	result, err = interp.Execute(ctx, `
+		UPDATE STATISTICS dbo.Orders WITH FULLSCAN
+		SELECT STATS_DATE(OBJECT_ID('orders'), 1) AS d, STATS_DATE(OBJECT_ID('orders'), 2) AS other
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := result.ResultSets[len(result.ResultSets)-1].Rows[0]
+	if row[0].IsNull || row[0].AsTime().Before(before) || !row[1].IsNull {
+		t.Errorf("got %v", row)
+	}
+	var rows int64
+	if err := interp.ctx.DB.QueryRow("SELECT row_count FROM " + StatisticsTable + " WHERE table_name = 'orders'").Scan(&rows); err != nil || rows != 1 {
+		t.Errorf("recorded %d rows: %v", rows, err)
+	}
+	var stat1 int
+	if err := interp.ctx.DB.QueryRow("SELECT COUNT(*) FROM sqlite_stat1 WHERE tbl = 'orders'").Scan(&stat1); err != nil || stat1 == 0 {
+		t.Errorf("ANALYZE gathered nothing: %v", err)
+	}
+
+	sql := "UPDATE STATISTICS dbo.missing"
+	_, err = interp.Execute(ctx, sql, nil)
+	wantSQLError(t, sql, err, ErrObjectNotFound)
+}
+
+func TestSpUpdateStats(t *testing.T) {
+	interp := sysprocSetup(t)
+	ctx := context.Background()
+	if _, err := interp.ctx.DB.Exec("CREATE TABLE customers (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := interp.Execute(ctx, "EXEC sp_updatestats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Updating [dbo].[customers]", "Updating [dbo].[orders]", "Statistics for all tables have been updated."}
+	if !reflect.DeepEqual(result.Warnings, want) {
+		t.Errorf("messages %q", result.Warnings)
+	}
+	result, err = interp.Execute(ctx, "SELECT STATS_DATE(OBJECT_ID('customers'), 1) AS d", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ResultSets[len(result.ResultSets)-1].Rows[0][0].IsNull {
+		t.Error("customers was not analyzed")
+	}
+}
+
+func TestRefreshStatistics(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.Exec(`CREATE TABLE orders (id INTEGER);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 10)
+		INSERT INTO orders SELECT i FROM n;
+		CREATE TABLE notes (id INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every table is analyzed the first time
+	got, err := RefreshStatistics(ctx, db, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"notes", "orders"}) {
+		t.Errorf("first refresh: %v", got)
+	}
+
+	// Then only those whose row count has moved past the threshold
+	if _, err := db.Exec("INSERT INTO orders VALUES (11), (12); INSERT INTO notes VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = RefreshStatistics(ctx, db, 0.2); err != nil || !reflect.DeepEqual(got, []string{"notes"}) {
+		t.Errorf("second refresh: %v, %v", got, err)
+	}
+	if _, err := db.Exec("INSERT INTO orders VALUES (13)"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = RefreshStatistics(ctx, db, 0.2); err != nil || !reflect.DeepEqual(got, []string{"orders"}) {
+		t.Errorf("third refresh: %v, %v", got, err)
+	}
+	if got, err = RefreshStatistics(ctx, db, 0.2); err != nil || len(got) != 0 {
+		t.Errorf("refresh with nothing changed: %v, %v", got, err)
+	}
+}
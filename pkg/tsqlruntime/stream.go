@@ -0,0 +1,83 @@
+package tsqlruntime
+
+import (
+	"database/sql"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/lexer"
+	"github.com/ha1tch/aul/pkg/tsqlparser/parser"
+)
+
+// RowSink takes the rows of a query as they are read from the database,
+// in place of its result set, so that a result larger than memory can be
+// sent on while it is read.
+type RowSink interface {
+	// Columns is called once, before the rows, with the result set's
+	// columns and classifications, and the database's names for the
+	// columns' types.
+	Columns(rs ResultSet, types []string) error
+
+	// Row is called with each row; an error stops the query.
+	Row(row []Value) error
+}
+
+// SetRowSink sends the rows of the next query that reads the database to
+// sink, instead of into a result set. Rows streamed are not charged to the
+// memory budget, since none is held; queries served without the database
+// (scalar SELECTs, temporary tables and cached tables) still return a
+// result set. Only the first query streams.
+func (i *Interpreter) SetRowSink(sink RowSink) {
+	i.rowSink = sink
+}
+
+// takeRowSink returns the sink the next query's rows go to, if any,
+// clearing it.
+func (i *Interpreter) takeRowSink() RowSink {
+	sink := i.rowSink
+	i.rowSink = nil
+	return sink
+}
+
+// streamRows sends the rows of rs, read from rows, to sink.
+func (i *Interpreter) streamRows(sink RowSink, rows *sql.Rows, rs ResultSet) error {
+	types := make([]string, len(rs.Columns))
+	if colTypes, err := rows.ColumnTypes(); err == nil {
+		for j, ct := range colTypes {
+			types[j] = ct.DatabaseTypeName()
+		}
+	}
+	if err := sink.Columns(rs, types); err != nil {
+		return err
+	}
+
+	var n int64
+	scanner := newRowScanner(len(rs.Columns))
+	for rows.Next() {
+		row, err := scanner.scan(rows)
+		if err != nil {
+			return err
+		}
+		if err := sink.Row(row); err != nil {
+			return err
+		}
+		n++
+	}
+	i.ctx.UpdateRowCount(n)
+	return rows.Err()
+}
+
+// Streamable reports whether sql is a batch of one SELECT, or WITH ...
+// SELECT, without INTO: one whose rows a RowSink can take.
+func Streamable(sql string) bool {
+	p := parser.New(lexer.New(sql))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 || len(program.Statements) != 1 {
+		return false
+	}
+	stmt := program.Statements[0]
+	if ws, ok := stmt.(*ast.WithStatement); ok {
+		stmt = ws.Query
+	}
+	sel, ok := stmt.(*ast.SelectStatement)
+	return ok && sel.Into == nil
+}
@@ -0,0 +1,438 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// String functions without a direct equivalent in the translated backends
+// are expanded into expressions over the functions each one does have.
+// Expansions that depend on a pattern or character list need it as a
+// literal; other calls are left unchanged.
+
+// -----------------------------------------------------------------------------
+// Patterns
+// -----------------------------------------------------------------------------
+
+// likeRegexp converts a LIKE pattern to a regular expression: % and _
+// become .* and ., [...] and [^...] character classes are kept, and
+// everything else matches literally.
+func likeRegexp(pattern string) string {
+	var out strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '%':
+			out.WriteString(".*")
+		case '_':
+			out.WriteString(".")
+		case '[':
+			end := likeClassEnd(runes, i)
+			if end < 0 {
+				out.WriteString(`\[`)
+				continue
+			}
+			out.WriteString("[")
+			class := runes[i+1 : end]
+			if class[0] == '^' {
+				out.WriteString("^")
+				class = class[1:]
+			}
+			for _, r := range class {
+				if r == '\\' || r == '[' || r == ']' || r == '^' {
+					out.WriteRune('\\')
+				}
+				out.WriteRune(r)
+			}
+			out.WriteString("]")
+			i = end
+		default:
+			out.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return out.String()
+}
+
+// likeGlob converts a LIKE pattern to a SQLite GLOB pattern.
+func likeGlob(pattern string) string {
+	var out strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '%':
+			out.WriteString("*")
+		case '_':
+			out.WriteString("?")
+		case '*', '?':
+			out.WriteString("[" + string(c) + "]")
+		case '[':
+			end := likeClassEnd(runes, i)
+			if end < 0 {
+				out.WriteString("[[]")
+				continue
+			}
+			out.WriteString(string(runes[i : end+1]))
+			i = end
+		default:
+			out.WriteRune(c)
+		}
+	}
+	return out.String()
+}
+
+// likeClassEnd returns the index of the ] closing the character class
+// opened at start, or -1 if there is none or the class is empty.
+func likeClassEnd(runes []rune, start int) int {
+	for i := start + 1; i < len(runes); i++ {
+		if runes[i] == ']' {
+			if i == start+1 || (i == start+2 && runes[start+1] == '^') {
+				return -1
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// patIndexRegexp returns a regular expression whose leftmost match starts
+// where PATINDEX finds pattern. Without a leading % the pattern can only
+// match from the start of the string.
+func patIndexRegexp(pattern string) string {
+	body := strings.TrimLeft(pattern, "%")
+	re := likeRegexp(body) + "$"
+	if len(body) == len(pattern) {
+		re = "^" + re
+	}
+	return re
+}
+
+// -----------------------------------------------------------------------------
+// Helpers
+// -----------------------------------------------------------------------------
+
+// stringLiteralArg returns the value of argument i of fc if it is a string
+// literal.
+func stringLiteralArg(fc *ast.FunctionCall, i int) (string, bool) {
+	if i >= len(fc.Arguments) {
+		return "", false
+	}
+	lit, ok := fc.Arguments[i].(*ast.StringLiteral)
+	if !ok {
+		return "", false
+	}
+	return lit.Value, true
+}
+
+// sqlString quotes s as a standard SQL string literal.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// mysqlString quotes s as a MySQL string literal, where backslash is an
+// escape character.
+func mysqlString(s string) string {
+	return sqlString(strings.ReplaceAll(s, `\`, `\\`))
+}
+
+// rawSQL returns sql as an expression emitted as-is in place of fc.
+func rawSQL(fc *ast.FunctionCall, sql string) ast.Expression {
+	return &ast.Identifier{Token: fc.Token, Value: sql}
+}
+
+// nestedReplace translates each character of from in expr to the matching
+// character of to using replace, the way TRANSLATE does. Characters are
+// first moved to the Unicode private use area so a later replacement
+// cannot undo an earlier one, which lets TRANSLATE(s, 'ab', 'ba') swap.
+func nestedReplace(replace, expr, from, to string, quote func(string) string) (string, bool) {
+	fromRunes, toRunes := []rune(from), []rune(to)
+	if len(fromRunes) != len(toRunes) {
+		return "", false
+	}
+	for i, r := range fromRunes {
+		expr = fmt.Sprintf("%s(%s, %s, %s)", replace, expr, quote(string(r)), quote(string(rune(0xE000+i))))
+	}
+	for i, r := range toRunes {
+		expr = fmt.Sprintf("%s(%s, %s, %s)", replace, expr, quote(string(rune(0xE000+i))), quote(string(r)))
+	}
+	return expr, true
+}
+
+// quoteNameArgs returns the string and delimiters of a QUOTENAME call with
+// a literal or default delimiter. close is empty if the delimiter is not
+// one QUOTENAME accepts, in which case it returns NULL.
+func quoteNameArgs(fc *ast.FunctionCall) (str, open, close string, ok bool) {
+	if len(fc.Arguments) < 1 || len(fc.Arguments) > 2 {
+		return "", "", "", false
+	}
+	open = "["
+	if len(fc.Arguments) == 2 {
+		if open, ok = stringLiteralArg(fc, 1); !ok {
+			return "", "", "", false
+		}
+	}
+	return fc.Arguments[0].String(), open, quoteNameDelimiters[open], true
+}
+
+// isJSONEscape reports whether fc is STRING_ESCAPE(s, 'json').
+func isJSONEscape(fc *ast.FunctionCall) bool {
+	kind, ok := stringLiteralArg(fc, 1)
+	return ok && len(fc.Arguments) == 2 && strings.EqualFold(kind, "json")
+}
+
+// rewriteTrim transforms TRIM([LEADING|TRAILING|BOTH] [chars FROM] s).
+// SQLite lacks the standard syntax and gets trim, ltrim or rtrim instead.
+func (r *BaseRewriter) rewriteTrim(te *ast.TrimExpression) ast.Expression {
+	te.Expression = r.RewriteExpression(te.Expression)
+	te.Characters = r.RewriteExpression(te.Characters)
+	if r.dialect != DialectSQLite {
+		return te
+	}
+
+	name := "trim"
+	switch strings.ToUpper(te.TrimSpec) {
+	case "LEADING":
+		name = "ltrim"
+	case "TRAILING":
+		name = "rtrim"
+	}
+	args := []ast.Expression{te.Expression}
+	if te.Characters != nil {
+		args = append(args, te.Characters)
+	}
+	return &ast.FunctionCall{
+		Token:     te.Token,
+		Function:  &ast.Identifier{Token: te.Token, Value: name},
+		Arguments: args,
+	}
+}
+
+// -----------------------------------------------------------------------------
+// SQLite
+// -----------------------------------------------------------------------------
+
+// rewriteTranslate expands TRANSLATE into nested replace calls.
+func (r *SQLiteRewriter) rewriteTranslate(fc *ast.FunctionCall) ast.Expression {
+	from, ok1 := stringLiteralArg(fc, 1)
+	to, ok2 := stringLiteralArg(fc, 2)
+	if len(fc.Arguments) != 3 || !ok1 || !ok2 {
+		return fc
+	}
+	sql, ok := nestedReplace("replace", fc.Arguments[0].String(), from, to, sqlString)
+	if !ok {
+		return fc
+	}
+	return rawSQL(fc, sql)
+}
+
+// rewritePatIndex finds the first position at which the rest of the string
+// matches the pattern as a GLOB, folding case as SQL Server's default
+// collation does.
+func (r *SQLiteRewriter) rewritePatIndex(fc *ast.FunctionCall) ast.Expression {
+	pattern, ok := stringLiteralArg(fc, 0)
+	if len(fc.Arguments) != 2 || !ok {
+		return fc
+	}
+	str := fc.Arguments[1].String()
+	body := strings.TrimLeft(pattern, "%")
+	glob := sqlString(strings.ToLower(likeGlob(body)))
+
+	if len(body) == len(pattern) {
+		return rawSQL(fc, fmt.Sprintf("(CASE WHEN %[1]s IS NULL THEN NULL WHEN lower(%[1]s) GLOB %[2]s THEN 1 ELSE 0 END)", str, glob))
+	}
+	return rawSQL(fc, fmt.Sprintf("(CASE WHEN %[1]s IS NULL THEN NULL ELSE ("+
+		"SELECT COALESCE(MIN(aul_p), 0) FROM (WITH RECURSIVE aul_pos(aul_p) AS ("+
+		"SELECT 1 UNION ALL SELECT aul_p + 1 FROM aul_pos WHERE aul_p < length(%[1]s)"+
+		") SELECT aul_p FROM aul_pos) WHERE lower(substr(%[1]s, aul_p)) GLOB %[2]s) END)", str, glob))
+}
+
+// rewriteParseName splits the name with a recursive CTE. Brackets are
+// removed but dots inside them are not supported.
+func (r *SQLiteRewriter) rewriteParseName(fc *ast.FunctionCall) ast.Expression {
+	if len(fc.Arguments) != 2 {
+		return fc
+	}
+	name, part := fc.Arguments[0].String(), fc.Arguments[1].String()
+	return rawSQL(fc, fmt.Sprintf("(WITH RECURSIVE aul_parts(aul_i, aul_part, aul_rest) AS ("+
+		"SELECT 0, NULL, replace(replace(%s, '[', ''), ']', '') || '.' "+
+		"UNION ALL SELECT aul_i + 1, substr(aul_rest, 1, instr(aul_rest, '.') - 1), substr(aul_rest, instr(aul_rest, '.') + 1) "+
+		"FROM aul_parts WHERE aul_rest <> ''"+
+		") SELECT NULLIF(aul_part, '') FROM aul_parts WHERE aul_i > 0 "+
+		"AND aul_i = (SELECT MAX(aul_i) FROM aul_parts) + 1 - (%s) AND (SELECT MAX(aul_i) FROM aul_parts) <= 4)", name, part))
+}
+
+// rewriteQuoteName builds the delimited name by concatenation.
+func (r *SQLiteRewriter) rewriteQuoteName(fc *ast.FunctionCall) ast.Expression {
+	str, open, close, ok := quoteNameArgs(fc)
+	if !ok {
+		return fc
+	}
+	if close == "" {
+		return rawSQL(fc, "NULL")
+	}
+	return rawSQL(fc, fmt.Sprintf("(CASE WHEN length(%[1]s) <= 128 THEN %[2]s || replace(%[1]s, %[3]s, %[4]s) || %[3]s END)",
+		str, sqlString(open), sqlString(close), sqlString(close+close)))
+}
+
+// rewriteStringEscape uses json_quote without the surrounding quotes.
+// json_quote turns NULL into null, so NULL is checked for first.
+func (r *SQLiteRewriter) rewriteStringEscape(fc *ast.FunctionCall) ast.Expression {
+	if !isJSONEscape(fc) {
+		return fc
+	}
+	str := fc.Arguments[0].String()
+	quoted := "json_quote(CAST(" + str + " AS TEXT))"
+	return rawSQL(fc, fmt.Sprintf(`(CASE WHEN %[1]s IS NULL THEN NULL ELSE replace(substr(%[2]s, 2, length(%[2]s) - 2), '/', '\/') END)`, str, quoted))
+}
+
+// -----------------------------------------------------------------------------
+// PostgreSQL
+// -----------------------------------------------------------------------------
+
+// rewritePatIndex finds the first position at which the rest of the string
+// matches the pattern as a case-insensitive regular expression.
+func (r *PostgresRewriter) rewritePatIndex(fc *ast.FunctionCall) ast.Expression {
+	pattern, ok := stringLiteralArg(fc, 0)
+	if len(fc.Arguments) != 2 || !ok {
+		return fc
+	}
+	str := fc.Arguments[1].String()
+	body := strings.TrimLeft(pattern, "%")
+	re := sqlString("^" + likeRegexp(body) + "$")
+
+	if len(body) == len(pattern) {
+		return rawSQL(fc, fmt.Sprintf("(CASE WHEN %[1]s IS NULL THEN NULL WHEN %[1]s ~* %[2]s THEN 1 ELSE 0 END)", str, re))
+	}
+	return rawSQL(fc, fmt.Sprintf("(CASE WHEN %[1]s IS NULL THEN NULL ELSE ("+
+		"SELECT COALESCE(MIN(aul_p), 0) FROM generate_series(1, length(%[1]s)) AS aul_p "+
+		"WHERE substr(%[1]s, aul_p) ~* %[2]s) END)", str, re))
+}
+
+// rewriteParseName indexes the dot-separated parts from the right.
+// Brackets are removed but dots inside them are not supported.
+func (r *PostgresRewriter) rewriteParseName(fc *ast.FunctionCall) ast.Expression {
+	if len(fc.Arguments) != 2 {
+		return fc
+	}
+	parts := fmt.Sprintf("string_to_array(replace(replace(%s, '[', ''), ']', ''), '.')", fc.Arguments[0].String())
+	return rawSQL(fc, fmt.Sprintf("(CASE WHEN array_length(%[1]s, 1) <= 4 THEN NULLIF((%[1]s)[array_length(%[1]s, 1) + 1 - (%[2]s)], '') END)",
+		parts, fc.Arguments[1].String()))
+}
+
+// rewriteQuoteName builds the delimited name by concatenation.
+func (r *PostgresRewriter) rewriteQuoteName(fc *ast.FunctionCall) ast.Expression {
+	str, open, close, ok := quoteNameArgs(fc)
+	if !ok {
+		return fc
+	}
+	if close == "" {
+		return rawSQL(fc, "NULL")
+	}
+	return rawSQL(fc, fmt.Sprintf("(CASE WHEN length(%[1]s) <= 128 THEN %[2]s || replace(%[1]s, %[3]s, %[4]s) || %[3]s END)",
+		str, sqlString(open), sqlString(close), sqlString(close+close)))
+}
+
+// rewriteStringEscape uses to_json without the surrounding quotes.
+func (r *PostgresRewriter) rewriteStringEscape(fc *ast.FunctionCall) ast.Expression {
+	if !isJSONEscape(fc) {
+		return fc
+	}
+	quoted := "CAST(to_json(CAST(" + fc.Arguments[0].String() + " AS TEXT)) AS TEXT)"
+	return rawSQL(fc, fmt.Sprintf(`replace(substr(%[1]s, 2, length(%[1]s) - 2), '/', '\/')`, quoted))
+}
+
+// -----------------------------------------------------------------------------
+// MySQL
+// -----------------------------------------------------------------------------
+
+// rewriteTranslate expands TRANSLATE into nested REPLACE calls.
+func (r *MySQLRewriter) rewriteTranslate(fc *ast.FunctionCall) ast.Expression {
+	from, ok1 := stringLiteralArg(fc, 1)
+	to, ok2 := stringLiteralArg(fc, 2)
+	if len(fc.Arguments) != 3 || !ok1 || !ok2 {
+		return fc
+	}
+	sql, ok := nestedReplace("REPLACE", fc.Arguments[0].String(), from, to, mysqlString)
+	if !ok {
+		return fc
+	}
+	return rawSQL(fc, sql)
+}
+
+// rewritePatIndex maps PATINDEX onto REGEXP_INSTR.
+func (r *MySQLRewriter) rewritePatIndex(fc *ast.FunctionCall) ast.Expression {
+	pattern, ok := stringLiteralArg(fc, 0)
+	if len(fc.Arguments) != 2 || !ok {
+		return fc
+	}
+	return rawSQL(fc, fmt.Sprintf("REGEXP_INSTR(%s, %s)", fc.Arguments[1].String(), mysqlString(patIndexRegexp(pattern))))
+}
+
+// rewriteParseName indexes the dot-separated parts from the right.
+// Brackets are removed but dots inside them are not supported.
+func (r *MySQLRewriter) rewriteParseName(fc *ast.FunctionCall) ast.Expression {
+	if len(fc.Arguments) != 2 {
+		return fc
+	}
+	name := fmt.Sprintf("REPLACE(REPLACE(%s, '[', ''), ']', '')", fc.Arguments[0].String())
+	count := fmt.Sprintf("(CHAR_LENGTH(%[1]s) - CHAR_LENGTH(REPLACE(%[1]s, '.', '')) + 1)", name)
+	return rawSQL(fc, fmt.Sprintf("(CASE WHEN %[2]s <= 4 AND (%[3]s) BETWEEN 1 AND %[2]s "+
+		"THEN NULLIF(SUBSTRING_INDEX(SUBSTRING_INDEX(%[1]s, '.', -(%[3]s)), '.', 1), '') END)",
+		name, count, fc.Arguments[1].String()))
+}
+
+// rewriteQuoteName builds the delimited name with CONCAT.
+func (r *MySQLRewriter) rewriteQuoteName(fc *ast.FunctionCall) ast.Expression {
+	str, open, close, ok := quoteNameArgs(fc)
+	if !ok {
+		return fc
+	}
+	if close == "" {
+		return rawSQL(fc, "NULL")
+	}
+	return rawSQL(fc, fmt.Sprintf("(CASE WHEN CHAR_LENGTH(%[1]s) <= 128 THEN CONCAT(%[2]s, REPLACE(%[1]s, %[3]s, %[4]s), %[3]s) END)",
+		str, mysqlString(open), mysqlString(close), mysqlString(close+close)))
+}
+
+// rewriteStringEscape uses JSON_QUOTE without the surrounding quotes.
+func (r *MySQLRewriter) rewriteStringEscape(fc *ast.FunctionCall) ast.Expression {
+	if !isJSONEscape(fc) {
+		return fc
+	}
+	quoted := "JSON_QUOTE(CAST(" + fc.Arguments[0].String() + " AS CHAR))"
+	return rawSQL(fc, fmt.Sprintf(`REPLACE(SUBSTRING(%[1]s, 2, CHAR_LENGTH(%[1]s) - 2), '/', '\\/')`, quoted))
+}
+
+// rewriteUnicode returns the code point of the first character.
+func (r *MySQLRewriter) rewriteUnicode(fc *ast.FunctionCall) ast.Expression {
+	if len(fc.Arguments) != 1 {
+		return fc
+	}
+	return rawSQL(fc, "ORD(CONVERT("+fc.Arguments[0].String()+" USING utf32))")
+}
+
+// rewriteNChar returns the character with the given code point.
+func (r *MySQLRewriter) rewriteNChar(fc *ast.FunctionCall) ast.Expression {
+	if len(fc.Arguments) != 1 {
+		return fc
+	}
+	return rawSQL(fc, "CONVERT(CHAR("+fc.Arguments[0].String()+" USING utf32) USING utf8mb4)")
+}
+
+// rewriteTrimChars maps the two-argument LTRIM and RTRIM of SQL Server
+// 2022 onto TRIM(LEADING|TRAILING chars FROM s).
+func (r *MySQLRewriter) rewriteTrimChars(spec string) func(*ast.FunctionCall) ast.Expression {
+	return func(fc *ast.FunctionCall) ast.Expression {
+		if len(fc.Arguments) != 2 {
+			return fc
+		}
+		return &ast.TrimExpression{
+			Token:      fc.Token,
+			TrimSpec:   spec,
+			Characters: fc.Arguments[1],
+			Expression: fc.Arguments[0],
+		}
+	}
+}
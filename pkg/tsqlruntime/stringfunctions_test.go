@@ -0,0 +1,113 @@
+package tsqlruntime
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// stringCases are checked both by the evaluator and against SQLite, where
+// s is 'abC12' and n is 'srv.db.[dbo].tbl'.
+var stringCases = []struct {
+	expr string
+	want string // "NULL" for NULL
+}{
+	{"TRIM(CONCAT('  ', s, '  '))", "abC12"},
+	{"TRIM('a' FROM s)", "bC12"},
+	{"TRIM(TRAILING '12' FROM s)", "abC"},
+	{"LTRIM(s, 'ba')", "C12"},
+	{"CONCAT_WS('-', s, NULL, 'x')", "abC12-x"},
+	{"TRANSLATE(s, 'ab', 'ba')", "baC12"},
+	{"QUOTENAME('a]b')", "[a]]b]"},
+	{"QUOTENAME(s, '''')", "'abC12'"},
+	{"QUOTENAME(s, 'x')", "NULL"},
+	{"PARSENAME(n, 1)", "tbl"},
+	{"PARSENAME(n, 2)", "dbo"},
+	{"PARSENAME(n, 4)", "srv"},
+	{"PARSENAME('a.b', 3)", "NULL"},
+	{"PARSENAME('a..b', 2)", "NULL"},
+	{"STRING_ESCAPE('a\"b/c', 'json')", `a\"b\/c`},
+	{"UNICODE(s)", "97"},
+	{"NCHAR(233)", "é"},
+	{"PATINDEX('%[0-9]%', s)", "4"},
+	{"PATINDEX('%c_2%', s)", "3"},
+	{"PATINDEX('%[^a-z]%', s)", "4"},
+	{"PATINDEX('ab%', s)", "1"},
+	{"PATINDEX('b%', s)", "0"},
+	{"PATINDEX('%1', s)", "0"},
+	{"PATINDEX('%[0-9]%', NULL)", "NULL"},
+}
+
+func valueString(v Value) string {
+	if v.IsNull {
+		return "NULL"
+	}
+	return v.AsString()
+}
+
+func TestStringFunctions_Evaluator(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, tc := range stringCases {
+		sql := "DECLARE @s VARCHAR(20) = 'abC12'; DECLARE @n VARCHAR(30) = 'srv.db.[dbo].tbl'; SELECT " +
+			strings.NewReplacer("(s", "(@s", " s,", " @s,", "(n", "(@n").Replace(tc.expr)
+		result, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(), sql, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if got := valueString(result.ResultSets[0].Rows[0][0]); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestStringFunctions_SQLite(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE strs (s TEXT, n TEXT); INSERT INTO strs VALUES ('abC12', 'srv.db.[dbo].tbl')"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range stringCases {
+		result, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(), "SELECT "+tc.expr+" FROM strs", nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if got := valueString(result.ResultSets[0].Rows[0][0]); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestStringFunctions_Rewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewriter ASTRewriter
+		input    string
+		contains string
+	}{
+		{"postgres unicode", NewPostgresRewriter(), "SELECT UNICODE(s) FROM t", "ASCII(s)"},
+		{"postgres nchar", NewPostgresRewriter(), "SELECT NCHAR(233) FROM t", "CHR(233)"},
+		{"postgres translate", NewPostgresRewriter(), "SELECT TRANSLATE(s, 'ab', 'ba') FROM t", "TRANSLATE(s, 'ab', 'ba')"},
+		{"postgres patindex", NewPostgresRewriter(), "SELECT PATINDEX('%[0-9]%', s) FROM t", "substr(s, aul_p) ~* '^[0-9].*$'"},
+		{"postgres parsename", NewPostgresRewriter(), "SELECT PARSENAME(n, 2) FROM t", "string_to_array(replace(replace(n, '[', ''), ']', ''), '.')"},
+		{"postgres trim", NewPostgresRewriter(), "SELECT TRIM('x' FROM s) FROM t", "TRIM('x' FROM s)"},
+		{"mysql patindex", NewMySQLRewriter(), "SELECT PATINDEX('%a.b%', s) FROM t", `REGEXP_INSTR(s, 'a\\.b.*$')`},
+		{"mysql translate", NewMySQLRewriter(), "SELECT TRANSLATE(s, 'a', 'b') FROM t", "REPLACE(REPLACE(s, 'a', ''), '', 'b')"},
+		{"mysql ltrim", NewMySQLRewriter(), "SELECT LTRIM(s, 'x') FROM t", "TRIM(LEADING 'x' FROM s)"},
+		{"mysql string escape", NewMySQLRewriter(), "SELECT STRING_ESCAPE(s, 'json') FROM t", "JSON_QUOTE(CAST(s AS CHAR))"},
+		{"sqlite trim", NewSQLiteRewriter(), "SELECT TRIM(TRAILING 'x' FROM s) FROM t", "rtrim(s, 'x')"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			output := tc.rewriter.RewriteStatement(parseSQL(t, tc.input)).String()
+			if !strings.Contains(output, tc.contains) {
+				t.Errorf("expected %q in output:\n%s", tc.contains, output)
+			}
+		})
+	}
+}
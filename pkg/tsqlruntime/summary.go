@@ -0,0 +1,88 @@
+package tsqlruntime
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// An execution can summarise what its statements did, for clients that
+// log or chart it: for each query and write, its kind, the table it
+// targets, the rows it affected and the time it took. Statements run more
+// than once, in a loop or a procedure called again, are summarised once,
+// with their totals, so a summary is no longer than the code that ran.
+// Statements nested procedures run are summarised in the caller's.
+// Control flow, variables and EXEC itself are left out, as are statements
+// that failed.
+
+// StatementSummary is what one statement did.
+type StatementSummary struct {
+	Type         string // SELECT, INSERT, UPDATE, ...
+	Target       string // Table read or written ("" = none)
+	Text         string // The statement, shortened as journalled
+	Executions   int64
+	RowsAffected int64 // Rows returned by a SELECT; 0 for DDL
+	Duration     time.Duration
+}
+
+// StatementLog collects the summaries of an execution's statements.
+type StatementLog struct {
+	Statements []StatementSummary
+	index      map[ast.Statement]int
+}
+
+// SetSummary sets whether the execution summarises its statements in
+// ExecutionResult.Statements.
+func (i *Interpreter) SetSummary(on bool) {
+	i.ctx.Summary = nil
+	if on {
+		i.ctx.Summary = &StatementLog{}
+	}
+}
+
+// summarize adds a run of stmt, which took elapsed, to the summary.
+func (i *Interpreter) summarize(stmt ast.Statement, elapsed time.Duration) {
+	kind, target, ok := summarizedStatement(stmt)
+	if !ok {
+		return
+	}
+	var rows int64
+	switch kind {
+	case "SELECT", "SELECT INTO", "INSERT", "UPDATE", "DELETE", "MERGE":
+		rows = i.ctx.RowCount
+	}
+
+	log := i.ctx.Summary
+	if log.index == nil {
+		log.index = make(map[ast.Statement]int)
+	}
+	n, seen := log.index[stmt]
+	if !seen {
+		n = len(log.Statements)
+		log.index[stmt] = n
+		log.Statements = append(log.Statements, StatementSummary{Type: kind, Target: target, Text: journalText(stmt)})
+	}
+	s := &log.Statements[n]
+	s.Executions++
+	s.RowsAffected += rows
+	s.Duration += elapsed
+}
+
+// summarizedStatement returns the kind and target of a statement the
+// summary includes.
+func summarizedStatement(stmt ast.Statement) (kind, target string, ok bool) {
+	if s, isSelect := stmt.(*ast.SelectStatement); isSelect && s.Into == nil {
+		if s.From != nil && len(s.From.Tables) > 0 {
+			if tables := bindTables(nil, s.From.Tables[0]); len(tables) > 0 {
+				target = tables[0].table
+			}
+		}
+		return "SELECT", target, true
+	}
+	kind, targets := statementWrite(stmt)
+	if kind == "" {
+		return "", "", false
+	}
+	return kind, strings.Join(targets, ", "), true
+}
@@ -0,0 +1,66 @@
+package tsqlruntime
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStatementSummary(t *testing.T) {
+	interp := sysprocSetup(t)
+	resolver := interp.resolver.(*renamingResolver)
+	resolver.AddProcedure("dbo.Restock", `
+		CREATE PROCEDURE dbo.Restock
+		AS
+		BEGIN
+			UPDATE orders SET qty = qty + 1 WHERE id = 1
+		END
+	`, nil)
+	interp.SetSummary(true)
+
+	result, err := interp.Execute(context.Background(), `
+		DECLARE @n INT = 0
+		WHILE @n < 3
+		BEGIN
+			INSERT INTO orders VALUES (@n + 10, 1)
+			SET @n = @n + 1
+		END
+		CREATE TABLE #big (id INT)
+		EXEC dbo.Restock;
+		BEGIN TRY
+			DELETE FROM missing
+		END TRY
+		BEGIN CATCH
+			PRINT ERROR_MESSAGE()
+		END CATCH
+		SELECT o.id FROM orders o WHERE o.qty > 0
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []StatementSummary{
+		{Type: "INSERT", Target: "orders", Executions: 3, RowsAffected: 3},
+		{Type: "CREATE TABLE", Target: "#big", Executions: 1},
+		{Type: "UPDATE", Target: "orders", Executions: 1, RowsAffected: 1},
+		{Type: "SELECT", Target: "orders", Executions: 1, RowsAffected: 4},
+	}
+	if len(result.Statements) != len(want) {
+		t.Fatalf("got %+v", result.Statements)
+	}
+	for n, w := range want {
+		got := result.Statements[n]
+		if got.Type != w.Type || got.Target != w.Target || got.Executions != w.Executions ||
+			got.RowsAffected != w.RowsAffected || got.Text == "" || got.Duration <= 0 {
+			t.Errorf("statement %d: got %+v, want %+v", n, got, w)
+		}
+	}
+
+	interp.SetSummary(false)
+	result, err = interp.Execute(context.Background(), "SELECT id FROM orders", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Statements != nil {
+		t.Errorf("summarised when off: %+v", result.Statements)
+	}
+}
@@ -0,0 +1,587 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// The catalog-maintaining system procedures run in the interpreter rather
+// than through the resolver:
+//
+//	sp_rename                  renames a table, column or procedure
+//	sp_addextendedproperty     adds an extended property
+//	sp_updateextendedproperty  changes an extended property's value
+//	sp_dropextendedproperty    removes an extended property
+//	sp_updatestats             updates every table's statistics (see stats.go)
+//
+// sp_invoke_external_rest_endpoint, which calls out over HTTP,
+// sp_send_dbmail, which queues email, and sp_getapplock and
+// sp_releaseapplock, which take and release application locks, run in the
+// interpreter too (see restendpoint.go, dbmail.go and applock.go), as does
+// sp_refreshview, which recomputes a materialized view (see
+// materialized.go), and xp_cmdshell, which runs allowed operating system
+// commands (see cmdshell.go).
+//
+// Extended properties are kept in ExtendedPropertiesTable in the database
+// they describe, so they persist with it; the storage layer's
+// sys.extended_properties reads them from there. Against SQL Server the
+// procedures are sent to the backend unchanged.
+
+// ExtendedPropertiesTable holds the extended properties of a database's
+// objects, one row per property with its level types and names.
+const ExtendedPropertiesTable = "aul_extended_properties"
+
+// systemProcedure runs a system procedure with its bound arguments. A
+// handler sets OUTPUT parameters by storing their values in args, and its
+// return code under returnValueArg. Local procedures run in the
+// interpreter on every dialect rather than being sent to SQL Server.
+// Procedures that write fail in a read-only execution, and those acting
+// outside the database are skipped in a dry run. A session whose
+// permissions are checked needs to be an administrator to run one that
+// writes, and EXECUTE on one acting outside the database. Options are the words a
+// procedure takes unquoted as arguments, as xp_cmdshell's NO_OUTPUT.
+type systemProcedure struct {
+	params   []string
+	options  []string
+	run      func(i *Interpreter, ctx context.Context, args map[string]Value, result *ExecutionResult) error
+	local    bool
+	writes   bool
+	external bool
+}
+
+// returnValueArg holds a system procedure's return code in its args.
+const returnValueArg = "@RETURN_VALUE"
+
+var propertyLevelParams = []string{
+	"@level0type", "@level0name", "@level1type", "@level1name", "@level2type", "@level2name",
+}
+
+// systemProcedures maps upper-case names to their handlers.
+var systemProcedures = map[string]systemProcedure{
+	"SP_RENAME": {
+		params: []string{"@objname", "@newname", "@objtype"},
+		run:    (*Interpreter).spRename,
+		writes: true,
+	},
+	"SP_ADDEXTENDEDPROPERTY": {
+		params: append([]string{"@name", "@value"}, propertyLevelParams...),
+		run:    (*Interpreter).spAddExtendedProperty,
+		writes: true,
+	},
+	"SP_UPDATEEXTENDEDPROPERTY": {
+		params: append([]string{"@name", "@value"}, propertyLevelParams...),
+		run:    (*Interpreter).spUpdateExtendedProperty,
+		writes: true,
+	},
+	"SP_DROPEXTENDEDPROPERTY": {
+		params: append([]string{"@name"}, propertyLevelParams...),
+		run:    (*Interpreter).spDropExtendedProperty,
+		writes: true,
+	},
+	"SP_UPDATESTATS": {
+		params: []string{"@resample"},
+		run:    (*Interpreter).spUpdateStats,
+		writes: true,
+	},
+	"SP_INVOKE_EXTERNAL_REST_ENDPOINT": {
+		params:   []string{"@url", "@payload", "@headers", "@method", "@timeout", "@credential", "@response"},
+		run:      (*Interpreter).spInvokeExternalRESTEndpoint,
+		local:    true,
+		external: true,
+	},
+}
+
+// systemProcedureName strips any database and schema from an upper-case
+// procedure name.
+func systemProcedureName(name string) string {
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return strings.Trim(name, "[]")
+}
+
+// executeSystemProcedure binds the EXEC's parameters to proc's, by name or
+// position, runs it and copies its OUTPUT parameters and return code back
+// to the caller's variables.
+func (i *Interpreter) executeSystemProcedure(ctx context.Context, s *ast.ExecStatement, proc systemProcedure, result *ExecutionResult) (err error) {
+	name := strings.ToLower(systemProcedureName(strings.ToUpper(s.Procedure.String())))
+
+	args := make(map[string]Value, len(proc.params))
+	outputs := make(map[string]string) // maps procedure param name to caller variable name
+	for idx, p := range s.Parameters {
+		param := strings.ToLower(p.Name)
+		if param == "" {
+			if idx >= len(proc.params) {
+				return fmt.Errorf("procedure or function %s has too many arguments specified", name)
+			}
+			param = proc.params[idx]
+		} else if !containsString(proc.params, param) {
+			return fmt.Errorf("'%s' is not a parameter for procedure '%s'", p.Name, name)
+		}
+		if isDefaultArgument(p.Value) {
+			continue
+		}
+		if word, ok := optionArgument(p.Value, proc.options); ok {
+			args[param] = NewVarChar(word, len(word))
+			continue
+		}
+		val, err := i.evaluator.Evaluate(p.Value)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate parameter %s: %w", param, err)
+		}
+		args[param] = val
+		if p.Output {
+			if callerVar := outputTarget(p.Value); callerVar != "" {
+				outputs[param] = callerVar
+			}
+		}
+	}
+
+	if proc.external {
+		// No fixed role acts outside the database: it takes EXECUTE granted
+		// on the procedure, on sys or on the database
+		if err := i.CheckPermission("EXECUTE", "sys."+name); err != nil {
+			return err
+		}
+	}
+	if proc.writes {
+		if i.restricted() {
+			return errNoPermission()
+		}
+		if err := i.checkReadOnly(); err != nil {
+			return err
+		}
+	}
+	if dryRun := i.ctx.DryRun; dryRun != nil {
+		if proc.external {
+			dryRun.skip(name, journalText(s))
+			return nil
+		}
+		if proc.writes {
+			dryRun.BeginWrite("EXEC", name, journalText(s), true)
+			defer func() { dryRun.EndWrite(-1, err) }()
+		}
+	}
+	if i.ctx.Dialect == DialectSQLServer && !proc.local {
+		return i.forwardSystemProcedure(ctx, name, proc, args)
+	}
+	if err := proc.run(i, ctx, args, result); err != nil {
+		return err
+	}
+	for param, callerVar := range outputs {
+		i.assign(callerVar, args[param])
+	}
+	if s.ReturnVariable != nil {
+		rc, ok := args[returnValueArg]
+		if !ok {
+			rc = NewInt(0)
+		}
+		i.assign(s.ReturnVariable.Value, rc)
+	}
+	return nil
+}
+
+// forwardSystemProcedure runs a system procedure on a SQL Server backend.
+func (i *Interpreter) forwardSystemProcedure(ctx context.Context, name string, proc systemProcedure, args map[string]Value) error {
+	var assigns []string
+	var values []interface{}
+	for _, param := range proc.params {
+		if v, ok := args[param]; ok {
+			assigns = append(assigns, param+" = "+i.getPlaceholder(len(values)))
+			values = append(values, FromValue(v))
+		}
+	}
+	query := "EXEC " + name
+	if len(assigns) > 0 {
+		query += " " + strings.Join(assigns, ", ")
+	}
+	if _, err := i.ctx.GetExecutor().ExecContext(ctx, query, values...); err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
+
+// optionArgument returns the upper-cased word an argument is, if it is one
+// of options written unquoted.
+func optionArgument(expr ast.Expression, options []string) (string, bool) {
+	id, ok := expr.(*ast.Identifier)
+	if !ok || id.Token.Quote != 0 {
+		return "", false
+	}
+	word := strings.ToUpper(id.Value)
+	return word, containsString(options, word)
+}
+
+// containsString reports whether list holds s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// argString returns the string value of args[name], or "" if it is
+// missing or NULL.
+func argString(args map[string]Value, name string) string {
+	if v, ok := args[name]; ok && !v.IsNull {
+		return v.AsString()
+	}
+	return ""
+}
+
+// splitObjectName splits a possibly qualified, possibly bracketed name.
+func splitObjectName(name string) []string {
+	parts := strings.Split(name, ".")
+	for j, part := range parts {
+		parts[j] = strings.Trim(strings.TrimSpace(part), "[]")
+	}
+	return parts
+}
+
+// backendName returns a table name as the backend knows it: without its
+// database and schema when the dialect has none.
+func (i *Interpreter) backendName(name string) string {
+	if i.ctx.Dialect != DialectSQLite {
+		return name
+	}
+	parts := splitObjectName(name)
+	for _, part := range parts[:len(parts)-1] {
+		if isCatalogSchema(part) {
+			return name
+		}
+	}
+	return parts[len(parts)-1]
+}
+
+// exec runs a statement on the current transaction, if any.
+func (i *Interpreter) exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if i.LogRewritten && i.LogFunc != nil {
+		i.LogFunc("REWRITTEN query=%s args=%v", query, args)
+	}
+	res, err := i.ctx.GetExecutor().ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// -----------------------------------------------------------------------------
+// sp_rename
+// -----------------------------------------------------------------------------
+
+// spRename renames a table or procedure (@objtype OBJECT, the default) or
+// a column (@objtype COLUMN, with @objname as table.column), and moves the
+// object's extended properties to the new name.
+func (i *Interpreter) spRename(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	objName := argString(args, "@objname")
+	newName := argString(args, "@newname")
+	objType := strings.ToUpper(argString(args, "@objtype"))
+	ambiguous := NewSQLError(ErrRenameAmbiguous, fmt.Sprintf(
+		"Either the parameter @objname is ambiguous or the claimed @objtype (%s) is wrong.", objType))
+	if objName == "" || newName == "" {
+		return ambiguous
+	}
+	parts := splitObjectName(objName)
+
+	switch objType {
+	case "", "OBJECT":
+		table := strings.Join(parts, ".")
+		if _, err := i.tableColumns(ctx, table); err == nil {
+			if _, err := i.exec(ctx, i.normalize("ALTER TABLE "+i.backendName(table)+" RENAME TO "+newName)); err != nil {
+				return fmt.Errorf("sp_rename failed: %w", err)
+			}
+		} else if err := i.renameProcedure(ctx, objName, newName); err != nil {
+			return ambiguous
+		}
+		if err := i.renamePropertyObject(ctx, parts[len(parts)-1], newName); err != nil {
+			return err
+		}
+
+	case "COLUMN":
+		if len(parts) < 2 {
+			return ambiguous
+		}
+		table := strings.Join(parts[:len(parts)-1], ".")
+		column := parts[len(parts)-1]
+		columns, err := i.tableColumns(ctx, table)
+		if err != nil || !containsFold(columns, column) {
+			return ambiguous
+		}
+		query := "ALTER TABLE " + i.backendName(table) + " RENAME COLUMN " + column + " TO " + newName
+		if _, err := i.exec(ctx, i.normalize(query)); err != nil {
+			return fmt.Errorf("sp_rename failed: %w", err)
+		}
+		if err := i.renamePropertyColumn(ctx, parts[len(parts)-2], column, newName); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("sp_rename: @objtype '%s' is not supported", objType)
+	}
+
+	result.Warnings = append(result.Warnings,
+		"Caution: Changing any part of an object name could break scripts and stored procedures.")
+	return nil
+}
+
+// renameProcedure renames a procedure through the resolver.
+func (i *Interpreter) renameProcedure(ctx context.Context, name, newName string) error {
+	renamer, ok := i.resolver.(ProcedureRenamer)
+	if !ok {
+		return fmt.Errorf("procedures cannot be renamed: no resolver configured")
+	}
+	if i.ctx.DryRun != nil {
+		// The registry is not in the dry run's transaction
+		i.ctx.DryRun.skipRunning()
+		return nil
+	}
+	return renamer.RenameProcedure(ctx, name, i.database, newName)
+}
+
+// containsFold reports whether list holds s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------
+// Extended properties
+// -----------------------------------------------------------------------------
+
+// propertyTarget is the object an extended property describes: up to three
+// levels of type and name, such as SCHEMA dbo, TABLE Orders, COLUMN Qty.
+type propertyTarget struct {
+	types [3]string
+	names [3]string
+}
+
+// propertyTargetFrom reads and checks the level arguments. Each level
+// needs the one above it, and a type needs a name.
+func propertyTargetFrom(args map[string]Value, proc string) (propertyTarget, error) {
+	var t propertyTarget
+	for level := 0; level < 3; level++ {
+		t.types[level] = strings.ToUpper(argString(args, fmt.Sprintf("@level%dtype", level)))
+		t.names[level] = argString(args, fmt.Sprintf("@level%dname", level))
+		invalid := (t.types[level] == "") != (t.names[level] == "") ||
+			(level > 0 && t.types[level] != "" && t.types[level-1] == "")
+		if invalid {
+			return t, NewSQLError(ErrInvalidParameter, fmt.Sprintf(
+				"An invalid parameter or option was specified for procedure '%s'.", proc))
+		}
+	}
+	return t, nil
+}
+
+// String names the target as SQL Server's messages do.
+func (t propertyTarget) String() string {
+	var parts []string
+	for _, name := range t.names {
+		if name != "" {
+			parts = append(parts, name)
+		}
+	}
+	if len(parts) == 0 {
+		return "database"
+	}
+	return strings.Join(parts, ".")
+}
+
+// where returns a predicate matching the property called name on t, with
+// its arguments.
+func (t propertyTarget) where(i *Interpreter, name string, argIdx int) (string, []interface{}) {
+	conds := []string{"LOWER(name) = LOWER(" + i.getPlaceholder(argIdx) + ")"}
+	args := []interface{}{name}
+	for level := 0; level < 3; level++ {
+		conds = append(conds,
+			fmt.Sprintf("level%dtype = %s", level, i.getPlaceholder(argIdx+len(args))),
+			fmt.Sprintf("LOWER(level%dname) = LOWER(%s)", level, i.getPlaceholder(argIdx+len(args)+1)))
+		args = append(args, t.types[level], t.names[level])
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// checkExists fails unless the object t describes exists. Schemas are not
+// checked; tables, views and their columns are looked up in the database
+// and procedures and functions through the resolver.
+func (i *Interpreter) checkExists(ctx context.Context, t propertyTarget) error {
+	invalid := NewSQLError(ErrPropertyInvalidObj, fmt.Sprintf(
+		"Object is invalid. Extended properties are not permitted on '%s', or the object does not exist.", t))
+
+	object := t.names[1]
+	if t.names[0] != "" {
+		object = t.names[0] + "." + object
+	}
+	switch t.types[1] {
+	case "":
+		return nil
+	case "TABLE", "VIEW":
+		columns, err := i.tableColumns(ctx, object)
+		if err != nil {
+			return invalid
+		}
+		if t.types[2] == "COLUMN" && !containsFold(columns, t.names[2]) {
+			return invalid
+		}
+	case "PROCEDURE", "FUNCTION":
+		if i.resolver == nil {
+			return invalid
+		}
+		if _, _, err := i.resolver.Resolve(ctx, object, i.database); err != nil {
+			return invalid
+		}
+	}
+	return nil
+}
+
+// ensurePropertyTable creates ExtendedPropertiesTable if it is missing.
+func (i *Interpreter) ensurePropertyTable(ctx context.Context) error {
+	_, err := i.exec(ctx, "CREATE TABLE IF NOT EXISTS "+ExtendedPropertiesTable+
+		" (name VARCHAR(128), value TEXT,"+
+		" level0type VARCHAR(128), level0name VARCHAR(128),"+
+		" level1type VARCHAR(128), level1name VARCHAR(128),"+
+		" level2type VARCHAR(128), level2name VARCHAR(128))")
+	if err != nil {
+		return fmt.Errorf("extended properties unavailable: %w", err)
+	}
+	return nil
+}
+
+// propertyCount returns how many properties called name t has.
+func (i *Interpreter) propertyCount(ctx context.Context, t propertyTarget, name string) (int, error) {
+	where, args := t.where(i, name, 0)
+	var n int
+	err := i.ctx.GetExecutor().QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM "+ExtendedPropertiesTable+" WHERE "+where, args...).Scan(&n)
+	return n, err
+}
+
+// prepareProperty checks a property procedure's arguments and object.
+func (i *Interpreter) prepareProperty(ctx context.Context, args map[string]Value, proc string) (propertyTarget, string, error) {
+	t, err := propertyTargetFrom(args, proc)
+	if err != nil {
+		return t, "", err
+	}
+	name := argString(args, "@name")
+	if name == "" {
+		return t, "", NewSQLError(ErrInvalidParameter, fmt.Sprintf(
+			"An invalid parameter or option was specified for procedure '%s'.", proc))
+	}
+	if err := i.checkExists(ctx, t); err != nil {
+		return t, "", err
+	}
+	return t, name, i.ensurePropertyTable(ctx)
+}
+
+// propertyValue returns the stored form of @value.
+func propertyValue(args map[string]Value) interface{} {
+	if v, ok := args["@value"]; ok && !v.IsNull {
+		return v.AsString()
+	}
+	return nil
+}
+
+// spAddExtendedProperty adds a property, failing if it already exists.
+func (i *Interpreter) spAddExtendedProperty(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	t, name, err := i.prepareProperty(ctx, args, "sp_addextendedproperty")
+	if err != nil {
+		return err
+	}
+	n, err := i.propertyCount(ctx, t, name)
+	if err != nil {
+		return fmt.Errorf("sp_addextendedproperty failed: %w", err)
+	}
+	if n > 0 {
+		return NewSQLError(ErrPropertyExists, fmt.Sprintf(
+			"Property cannot be added. Property '%s' already exists for '%s'.", name, t))
+	}
+
+	placeholders := make([]string, 8)
+	for j := range placeholders {
+		placeholders[j] = i.getPlaceholder(j)
+	}
+	values := []interface{}{name, propertyValue(args)}
+	for level := 0; level < 3; level++ {
+		values = append(values, t.types[level], t.names[level])
+	}
+	if _, err := i.exec(ctx, "INSERT INTO "+ExtendedPropertiesTable+
+		" (name, value, level0type, level0name, level1type, level1name, level2type, level2name)"+
+		" VALUES ("+strings.Join(placeholders, ", ")+")", values...); err != nil {
+		return fmt.Errorf("sp_addextendedproperty failed: %w", err)
+	}
+	return nil
+}
+
+// spUpdateExtendedProperty changes the value of an existing property.
+func (i *Interpreter) spUpdateExtendedProperty(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	t, name, err := i.prepareProperty(ctx, args, "sp_updateextendedproperty")
+	if err != nil {
+		return err
+	}
+	where, whereArgs := t.where(i, name, 1)
+	n, err := i.exec(ctx, "UPDATE "+ExtendedPropertiesTable+" SET value = "+i.getPlaceholder(0)+" WHERE "+where,
+		append([]interface{}{propertyValue(args)}, whereArgs...)...)
+	if err != nil {
+		return fmt.Errorf("sp_updateextendedproperty failed: %w", err)
+	}
+	if n == 0 {
+		return NewSQLError(ErrPropertyMissing, fmt.Sprintf(
+			"Property cannot be updated or deleted. Property '%s' does not exist for '%s'.", name, t))
+	}
+	return nil
+}
+
+// spDropExtendedProperty removes an existing property.
+func (i *Interpreter) spDropExtendedProperty(ctx context.Context, args map[string]Value, result *ExecutionResult) error {
+	t, name, err := i.prepareProperty(ctx, args, "sp_dropextendedproperty")
+	if err != nil {
+		return err
+	}
+	where, whereArgs := t.where(i, name, 0)
+	n, err := i.exec(ctx, "DELETE FROM "+ExtendedPropertiesTable+" WHERE "+where, whereArgs...)
+	if err != nil {
+		return fmt.Errorf("sp_dropextendedproperty failed: %w", err)
+	}
+	if n == 0 {
+		return NewSQLError(ErrPropertyMissing, fmt.Sprintf(
+			"Property cannot be updated or deleted. Property '%s' does not exist for '%s'.", name, t))
+	}
+	return nil
+}
+
+// renamePropertyObject moves the properties of the object called name, and
+// of its columns, to newName.
+func (i *Interpreter) renamePropertyObject(ctx context.Context, name, newName string) error {
+	if err := i.ensurePropertyTable(ctx); err != nil {
+		return err
+	}
+	_, err := i.exec(ctx, "UPDATE "+ExtendedPropertiesTable+" SET level1name = "+i.getPlaceholder(0)+
+		" WHERE LOWER(level1name) = LOWER("+i.getPlaceholder(1)+")", newName, name)
+	if err != nil {
+		return fmt.Errorf("sp_rename failed: %w", err)
+	}
+	return nil
+}
+
+// renamePropertyColumn moves the properties of column in table to newName.
+func (i *Interpreter) renamePropertyColumn(ctx context.Context, table, column, newName string) error {
+	if err := i.ensurePropertyTable(ctx); err != nil {
+		return err
+	}
+	_, err := i.exec(ctx, "UPDATE "+ExtendedPropertiesTable+" SET level2name = "+i.getPlaceholder(0)+
+		" WHERE level2type = 'COLUMN' AND LOWER(level1name) = LOWER("+i.getPlaceholder(1)+")"+
+		" AND LOWER(level2name) = LOWER("+i.getPlaceholder(2)+")", newName, table, column)
+	if err != nil {
+		return fmt.Errorf("sp_rename failed: %w", err)
+	}
+	return nil
+}
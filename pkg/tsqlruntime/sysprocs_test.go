@@ -0,0 +1,169 @@
+package tsqlruntime
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// renamingResolver adds ProcedureRenamer to mockResolver.
+type renamingResolver struct {
+	*mockResolver
+}
+
+func (r *renamingResolver) RenameProcedure(ctx context.Context, name string, database string, newName string) error {
+	for _, key := range []string{name, "dbo." + name} {
+		if proc, ok := r.procedures[key]; ok {
+			delete(r.procedures, key)
+			r.procedures["dbo."+newName] = proc
+			return nil
+		}
+	}
+	return &SQLError{Message: "procedure not found: " + name}
+}
+
+// sysprocSetup returns an interpreter over a database holding orders.
+func sysprocSetup(t *testing.T) *Interpreter {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE orders (id INTEGER, qty INTEGER); INSERT INTO orders VALUES (1, 5)"); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &renamingResolver{newMockResolver()}
+	resolver.AddProcedure("dbo.GetMessage", `
+		CREATE PROCEDURE dbo.GetMessage
+		AS
+		BEGIN
+			SELECT 'hello' AS Message
+		END
+	`, nil)
+
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetResolver(resolver)
+	return interp
+}
+
+// wantSQLError fails unless err is a SQLError numbered number.
+func wantSQLError(t *testing.T, sql string, err error, number int) {
+	t.Helper()
+	var sqlErr *SQLError
+	if !errors.As(err, &sqlErr) || sqlErr.Number != number {
+		t.Errorf("%s: got %v, want error %d", sql, err, number)
+	}
+}
+
+func TestSpRename(t *testing.T) {
+	interp := sysprocSetup(t)
+	ctx := context.Background()
+
+	result, err := interp.Execute(ctx, "EXEC sp_rename 'dbo.orders', 'sales'", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 || !strings.HasPrefix(result.Warnings[0], "Caution:") {
+		t.Errorf("warnings = %q, want the caution message", result.Warnings)
+	}
+
+	result, err = interp.Execute(ctx,
+		"EXEC sp_rename @objname = N'sales.qty', @newname = N'quantity', @objtype = N'COLUMN'; "+
+			"SELECT quantity FROM sales", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"5"}) {
+		t.Errorf("renamed column = %q, want [5]", got)
+	}
+
+	result, err = interp.Execute(ctx, "EXEC sys.sp_rename 'GetMessage', 'GetGreeting'; EXEC GetGreeting", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastRows(result); !reflect.DeepEqual(got, []string{"hello"}) {
+		t.Errorf("renamed procedure returned %q, want [hello]", got)
+	}
+
+	for _, sql := range []string{
+		"EXEC sp_rename 'orders', 'other'",
+		"EXEC sp_rename 'sales.missing', 'other', 'COLUMN'",
+		"EXEC sp_rename 'sales', NULL",
+	} {
+		_, err := interp.Execute(ctx, sql, nil)
+		wantSQLError(t, sql, err, ErrRenameAmbiguous)
+	}
+
+	if _, err := interp.Execute(ctx, "EXEC sp_rename @objname = 'sales', @name = 'x'", nil); err == nil {
+		t.Error("expected an error for an unknown parameter")
+	}
+}
+
+func TestExtendedProperties(t *testing.T) {
+	interp := sysprocSetup(t)
+	ctx := context.Background()
+
+	steps := []struct {
+		sql  string
+		want int // expected SQLError number, 0 for success
+	}{
+		{"EXEC sp_addextendedproperty N'MS_Description', N'Customer orders', " +
+			"N'SCHEMA', N'dbo', N'TABLE', N'orders'", 0},
+		{"EXEC sp_addextendedproperty @name = N'MS_Description', @value = N'Units', " +
+			"@level0type = N'SCHEMA', @level0name = N'dbo', @level1type = N'TABLE', @level1name = N'orders', " +
+			"@level2type = N'COLUMN', @level2name = N'qty'", 0},
+		{"EXEC sp_addextendedproperty N'Owner', N'sales team'", 0},
+		{"EXEC sp_addextendedproperty N'ms_description', N'again', " +
+			"N'SCHEMA', N'dbo', N'TABLE', N'orders'", ErrPropertyExists},
+		{"EXEC sp_updateextendedproperty N'MS_Description', N'All orders', " +
+			"N'SCHEMA', N'dbo', N'TABLE', N'orders'", 0},
+		{"EXEC sp_updateextendedproperty N'Missing', N'x', " +
+			"N'SCHEMA', N'dbo', N'TABLE', N'orders'", ErrPropertyMissing},
+		{"EXEC sp_addextendedproperty N'MS_Description', N'x', " +
+			"N'SCHEMA', N'dbo', N'TABLE', N'nosuchtable'", ErrPropertyInvalidObj},
+		{"EXEC sp_addextendedproperty N'MS_Description', N'x', " +
+			"N'SCHEMA', N'dbo', N'TABLE', N'orders', N'COLUMN', N'nosuchcolumn'", ErrPropertyInvalidObj},
+		{"EXEC sp_addextendedproperty N'MS_Description', N'x', " +
+			"N'SCHEMA', N'dbo', N'PROCEDURE', N'GetMessage'", 0},
+		{"EXEC sp_addextendedproperty N'MS_Description', N'x', N'SCHEMA'", ErrInvalidParameter},
+		{"EXEC sp_addextendedproperty N'MS_Description', N'x', NULL, NULL, N'TABLE', N'orders'", ErrInvalidParameter},
+		{"EXEC sp_dropextendedproperty N'Owner'", 0},
+		{"EXEC sp_dropextendedproperty N'Owner'", ErrPropertyMissing},
+		{"EXEC sp_rename 'orders', 'sales'", 0},
+		{"EXEC sp_rename 'sales.qty', 'quantity', 'COLUMN'", 0},
+	}
+	for _, step := range steps {
+		_, err := interp.Execute(ctx, step.sql, nil)
+		if step.want == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", step.sql, err)
+			}
+			continue
+		}
+		wantSQLError(t, step.sql, err, step.want)
+	}
+
+	rows, err := interp.ctx.DB.Query("SELECT name, value, level1name, level2name FROM " +
+		ExtendedPropertiesTable + " ORDER BY level1name, level2name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name, value, level1, level2 string
+		if err := rows.Scan(&name, &value, &level1, &level2); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Join([]string{name, value, level1, level2}, "|"))
+	}
+	want := []string{
+		"MS_Description|x|GetMessage|",
+		"MS_Description|All orders|sales|",
+		"MS_Description|Units|sales|quantity",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stored properties:\n got %q\nwant %q", got, want)
+	}
+}
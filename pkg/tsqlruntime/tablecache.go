@@ -0,0 +1,351 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// Small reference tables that procedures look values up in, such as
+// currencies, regions or status codes, can be pinned into a cache held in
+// memory. A SELECT reading only pinned tables, a lookup or joins among
+// them, is then evaluated in memory (see cachequery.go) instead of making
+// a backend round trip.
+//
+// A pinned table is loaded from the backend the first time it is read,
+// and kept column by column, with a hash index on each column an equality
+// looks up. A write to it through the server discards the copy, which is
+// loaded again by the next read. The writing session reads the table from
+// the backend until its transaction ends, so it sees its own writes.
+// Writes made other than through the server are only seen once the copy
+// reaches the refresh age, if one is set.
+
+// DefaultTableCacheMaxRows bounds the rows of a pinned table held in
+// memory; a larger table is read from the backend.
+const DefaultTableCacheMaxRows = 10000
+
+// TableCacheConfig configures the cache of pinned tables.
+type TableCacheConfig struct {
+	Tables  []string      // Tables pinned, e.g. dbo.Currencies
+	MaxRows int           // Rows beyond which a table is not cached (0 = DefaultTableCacheMaxRows)
+	Refresh time.Duration // Age at which a copy is loaded again (0 = only after writes)
+}
+
+// errTableTooLarge reports a pinned table with more rows than the cache
+// holds.
+var errTableTooLarge = errors.New("pinned table exceeds the cached rows limit")
+
+// TableCache holds the pinned tables of a server, shared by its
+// executions.
+type TableCache struct {
+	config TableCacheConfig
+
+	mu     sync.Mutex
+	tables map[string]*pinnedTable
+}
+
+// pinnedTable is the cache's state for one pinned table.
+type pinnedTable struct {
+	name string // As pinned
+
+	// Copies by database, since each tenant has its own
+	snapshots map[*sql.DB]*tableSnapshot
+
+	// Bumped by every write, so that a load racing one is discarded
+	version uint64
+
+	// Set by a load finding more than MaxRows rows, until the next write
+	tooLarge bool
+
+	hits          int64
+	loads         int64
+	invalidations int64
+	lastLoad      time.Time
+}
+
+// NewTableCache creates a cache of the tables config pins.
+func NewTableCache(config TableCacheConfig) *TableCache {
+	if config.MaxRows <= 0 {
+		config.MaxRows = DefaultTableCacheMaxRows
+	}
+	c := &TableCache{config: config, tables: make(map[string]*pinnedTable)}
+	for _, name := range config.Tables {
+		c.tables[pinnedKey(name)] = &pinnedTable{name: name, snapshots: make(map[*sql.DB]*tableSnapshot)}
+	}
+	return c
+}
+
+// Config returns the cache's configuration.
+func (c *TableCache) Config() TableCacheConfig {
+	return c.config
+}
+
+// pinnedKey returns the name a table is cached under: lower case, without
+// brackets or a database, and without the schema if it is dbo.
+func pinnedKey(name string) string {
+	parts := strings.Split(name, ".")
+	for j, p := range parts {
+		parts[j] = strings.ToLower(strings.Trim(strings.TrimSpace(p), `[]"`))
+	}
+	if len(parts) > 2 {
+		parts = parts[len(parts)-2:]
+	}
+	if len(parts) == 2 && (parts[0] == "dbo" || parts[0] == "") {
+		parts = parts[1:]
+	}
+	return strings.Join(parts, ".")
+}
+
+// qualifiedKey returns the cache key of a table named in a statement.
+func qualifiedKey(name *ast.QualifiedIdentifier) string {
+	parts := make([]string, len(name.Parts))
+	for j, p := range name.Parts {
+		parts[j] = p.Value
+	}
+	return pinnedKey(strings.Join(parts, "."))
+}
+
+// Pinned reports whether key names a pinned table.
+func (c *TableCache) Pinned(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tables[key] != nil
+}
+
+// Invalidate discards the copies of the pinned table key, which has been
+// written.
+func (c *TableCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.tables[key]
+	if t == nil {
+		return
+	}
+	t.version++
+	t.tooLarge = false
+	if len(t.snapshots) > 0 {
+		t.invalidations++
+		t.snapshots = make(map[*sql.DB]*tableSnapshot)
+	}
+}
+
+// hit counts a query served from the copy of the pinned table key.
+func (c *TableCache) hit(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t := c.tables[key]; t != nil {
+		t.hits++
+	}
+}
+
+// tableLoader reads a pinned table from the backend, failing with
+// errTableTooLarge once it has read more than maxRows rows.
+type tableLoader func(ctx context.Context, maxRows int) (columns []string, rows [][]Value, err error)
+
+// snapshot returns the copy of the pinned table key in db, loading it if
+// there is none or it is older than the refresh age.
+func (c *TableCache) snapshot(ctx context.Context, key string, db *sql.DB, load tableLoader) (*tableSnapshot, error) {
+	c.mu.Lock()
+	t := c.tables[key]
+	if t == nil {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("table %s is not pinned", key)
+	}
+	if t.tooLarge {
+		c.mu.Unlock()
+		return nil, errTableTooLarge
+	}
+	if s := t.snapshots[db]; s != nil && (c.config.Refresh <= 0 || time.Since(s.loaded) < c.config.Refresh) {
+		c.mu.Unlock()
+		return s, nil
+	}
+	version := t.version
+	c.mu.Unlock()
+
+	columns, rows, err := load(ctx, c.config.MaxRows)
+	if errors.Is(err, errTableTooLarge) {
+		c.mu.Lock()
+		if t.version == version {
+			t.tooLarge = true
+		}
+		c.mu.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := newTableSnapshot(columns, rows)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.loads++
+	t.lastLoad = s.loaded
+	// A write made while loading may not be in the rows read
+	if t.version == version {
+		t.snapshots[db] = s
+	}
+	return s, nil
+}
+
+// PinnedTableStats describes a pinned table, for sys.dm_aul_pinned_tables.
+type PinnedTableStats struct {
+	Table         string
+	State         string // LOADED, NOT_LOADED or TOO_LARGE
+	Rows          int    // Of the copy last loaded
+	Columns       int
+	Hits          int64 // Queries served from memory
+	Loads         int64
+	Invalidations int64 // Copies discarded by writes
+	LastLoad      time.Time
+}
+
+// Stats returns the state of each pinned table, by name.
+func (c *TableCache) Stats() []PinnedTableStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make([]PinnedTableStats, 0, len(c.tables))
+	for _, t := range c.tables {
+		st := PinnedTableStats{
+			Table:         t.name,
+			State:         "NOT_LOADED",
+			Hits:          t.hits,
+			Loads:         t.loads,
+			Invalidations: t.invalidations,
+			LastLoad:      t.lastLoad,
+		}
+		var latest *tableSnapshot
+		for _, s := range t.snapshots {
+			if latest == nil || s.loaded.After(latest.loaded) {
+				latest = s
+			}
+		}
+		if latest != nil {
+			st.State, st.Rows, st.Columns = "LOADED", latest.rows, len(latest.columns)
+		}
+		if t.tooLarge {
+			st.State = "TOO_LARGE"
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(a, b int) bool { return stats[a].Table < stats[b].Table })
+	return stats
+}
+
+// tableSnapshot is a copy of a pinned table, stored by column.
+type tableSnapshot struct {
+	columns []string
+	types   []DataType // Of each column's first non-NULL value
+	data    [][]Value  // data[column][row]
+	rows    int
+	loaded  time.Time
+
+	mu      sync.Mutex
+	indexes map[int]*columnIndex
+}
+
+func newTableSnapshot(columns []string, rows [][]Value) *tableSnapshot {
+	s := &tableSnapshot{
+		columns: columns,
+		types:   make([]DataType, len(columns)),
+		data:    make([][]Value, len(columns)),
+		rows:    len(rows),
+		loaded:  time.Now(),
+		indexes: make(map[int]*columnIndex),
+	}
+	for j := range columns {
+		s.types[j] = TypeUnknown
+		col := make([]Value, len(rows))
+		for r, row := range rows {
+			col[r] = row[j]
+			if s.types[j] == TypeUnknown && !row[j].IsNull {
+				s.types[j] = row[j].Type
+			}
+		}
+		s.data[j] = col
+	}
+	return s
+}
+
+// column returns the index of the column named name, or -1.
+func (s *tableSnapshot) column(name string) int {
+	for j, col := range s.columns {
+		if strings.EqualFold(col, name) {
+			return j
+		}
+	}
+	return -1
+}
+
+// copyRow copies row r of s into dst.
+func (s *tableSnapshot) copyRow(dst []Value, r int) {
+	for j := range s.data {
+		dst[j] = s.data[j][r]
+	}
+}
+
+// columnIndex maps the values of a column to the rows holding them.
+type columnIndex struct {
+	family byte // Of every non-NULL value, or 0 if they mix families
+	rows   map[string][]int
+}
+
+// indexKey returns the family of v and a key equal for values that
+// compare equal within the family, following Value.Compare: numbers by
+// value, strings exactly, dates and times by instant, and anything else
+// by its text.
+func indexKey(v Value) (byte, string) {
+	switch {
+	case v.Type.IsNumeric():
+		return 'n', v.AsDecimal().String()
+	case v.Type.IsString():
+		return 's', v.AsString()
+	case v.Type.IsDateTime():
+		return 'd', fmt.Sprint(v.AsTime().UnixNano())
+	}
+	return 'o', v.AsString()
+}
+
+// lookup returns the rows whose column col equals v, building the
+// column's index on first use. ok is false if the index cannot tell,
+// because the column mixes kinds of values or v is of another kind, which
+// compare by their text.
+func (s *tableSnapshot) lookup(col int, v Value) (rows []int, ok bool) {
+	if v.IsNull {
+		return nil, true
+	}
+	s.mu.Lock()
+	idx := s.indexes[col]
+	if idx == nil {
+		idx = &columnIndex{rows: make(map[string][]int)}
+		mixed := false
+		for r, cv := range s.data[col] {
+			if cv.IsNull {
+				continue
+			}
+			family, key := indexKey(cv)
+			if idx.family == 0 && !mixed {
+				idx.family = family
+			} else if family != idx.family {
+				idx.family, mixed = 0, true
+			}
+			idx.rows[key] = append(idx.rows[key], r)
+		}
+		s.indexes[col] = idx
+	}
+	s.mu.Unlock()
+
+	family, key := indexKey(v)
+	if len(idx.rows) == 0 {
+		return nil, true
+	}
+	if idx.family == 0 || family != idx.family {
+		return nil, false
+	}
+	return idx.rows[key], true
+}
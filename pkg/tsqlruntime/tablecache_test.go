@@ -0,0 +1,195 @@
+package tsqlruntime
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pinnedDB creates currencies, countries and orders tables, pinning the
+// first two in the cache returned.
+func pinnedDB(t *testing.T, maxRows int) (*sql.DB, *TableCache) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range []string{
+		"CREATE TABLE Currencies (Code TEXT, Name TEXT, Rate REAL)",
+		"INSERT INTO Currencies VALUES ('EUR', 'Euro', 1.0), ('GBP', 'Pound', 0.85), ('USD', 'Dollar', 1.1)",
+		"CREATE TABLE Countries (Code TEXT, Name TEXT, CurrencyCode TEXT)",
+		"INSERT INTO Countries VALUES ('FR', 'France', 'EUR'), ('UK', 'United Kingdom', 'GBP'), ('XX', 'Nowhere', NULL)",
+		"CREATE TABLE Orders (ID INTEGER, Country TEXT)",
+		"INSERT INTO Orders VALUES (1, 'FR'), (2, 'UK')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return db, NewTableCache(TableCacheConfig{Tables: []string{"dbo.Currencies", "[Countries]"}, MaxRows: maxRows})
+}
+
+// cachedRows runs sql with the cache and returns the rows of its last
+// result set as in queryRows.
+func cachedRows(t *testing.T, db *sql.DB, cache *TableCache, sql string) []string {
+	t.Helper()
+	interp := NewInterpreter(db, DialectSQLite)
+	interp.SetTableCache(cache)
+	result, err := interp.Execute(context.Background(), sql, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	if len(result.ResultSets) == 0 {
+		return nil
+	}
+	var rows []string
+	for _, row := range result.ResultSets[len(result.ResultSets)-1].Rows {
+		parts := make([]string, len(row))
+		for i, v := range row {
+			parts[i] = valueString(v)
+		}
+		rows = append(rows, strings.Join(parts, " "))
+	}
+	return rows
+}
+
+// pinnedStats returns the stats of the pinned table named.
+func pinnedStats(cache *TableCache, name string) PinnedTableStats {
+	for _, st := range cache.Stats() {
+		if st.Table == name {
+			return st
+		}
+	}
+	return PinnedTableStats{}
+}
+
+func TestTableCache_Queries(t *testing.T) {
+	db, cache := pinnedDB(t, 0)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"lookup",
+			"SELECT Name, Rate FROM dbo.Currencies WHERE Code = 'GBP'",
+			[]string{"Pound 0.85"}},
+		{"no match",
+			"SELECT Name FROM Currencies WHERE Code = 'JPY'",
+			nil},
+		{"variable assignment",
+			"DECLARE @code VARCHAR(3) = 'USD', @rate FLOAT; SELECT @rate = Rate FROM Currencies WHERE Code = @code; SELECT @rate",
+			[]string{"1.1"}},
+		{"join",
+			"SELECT c.Name, cu.Name FROM Countries c JOIN Currencies cu ON c.CurrencyCode = cu.Code ORDER BY c.Name",
+			[]string{"France Euro", "United Kingdom Pound"}},
+		{"left join",
+			"SELECT c.Code, cu.Code FROM Countries AS c LEFT JOIN Currencies AS cu ON cu.Code = c.CurrencyCode ORDER BY c.Code DESC",
+			[]string{"XX NULL", "UK GBP", "FR EUR"}},
+		{"aggregate",
+			"SELECT COUNT(*), MAX(Rate) FROM Currencies WHERE Rate < 1.05",
+			[]string{"2 1"}},
+		{"order by alias",
+			"SELECT TOP 1 Code AS c, Rate * 2 AS doubled FROM Currencies ORDER BY doubled DESC",
+			[]string{"USD 2.2"}},
+		{"unpinned table joined from the backend",
+			"SELECT o.ID, c.Name FROM Orders o JOIN Countries c ON c.Code = o.Country ORDER BY o.ID",
+			[]string{"1 France", "2 United Kingdom"}},
+		{"filtered join",
+			"SELECT c.Name FROM Countries c JOIN Currencies cu ON c.CurrencyCode = cu.Code WHERE cu.Code = 'EUR'",
+			[]string{"France"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cachedRows(t, db, cache, tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if st := pinnedStats(cache, "dbo.Currencies"); st.State != "LOADED" || st.Rows != 3 || st.Loads != 1 || st.Hits == 0 {
+		t.Errorf("stats = %+v, want loaded once and hit", st)
+	}
+	// The join with Orders was not served from memory
+	if st := pinnedStats(cache, "[Countries]"); st.Hits != 3 {
+		t.Errorf("stats = %+v, want the three joins among pinned tables", st)
+	}
+}
+
+func TestTableCache_Writes(t *testing.T) {
+	db, cache := pinnedDB(t, 0)
+	lookup := "SELECT Name FROM Currencies WHERE Code = 'EUR'"
+
+	if got := cachedRows(t, db, cache, lookup); !reflect.DeepEqual(got, []string{"Euro"}) {
+		t.Fatalf("got %q", got)
+	}
+
+	// A write made other than through the server is not seen
+	if _, err := db.Exec("UPDATE Currencies SET Name = 'Écu' WHERE Code = 'EUR'"); err != nil {
+		t.Fatal(err)
+	}
+	if got := cachedRows(t, db, cache, lookup); !reflect.DeepEqual(got, []string{"Euro"}) {
+		t.Errorf("after outside write got %q, want the cached row", got)
+	}
+
+	// One through it discards the copy
+	cachedRows(t, db, cache, "UPDATE Currencies SET Rate = 1.0 WHERE Code = 'EUR'")
+	if got := cachedRows(t, db, cache, lookup); !reflect.DeepEqual(got, []string{"Écu"}) {
+		t.Errorf("after write got %q, want the reloaded row", got)
+	}
+
+	// A transaction reads its own writes, and its commit discards the
+	// copy other sessions may have loaded meanwhile
+	got := cachedRows(t, db, cache, "BEGIN TRANSACTION; "+
+		"UPDATE Currencies SET Name = 'Euro' WHERE Code = 'EUR'; "+
+		lookup+"; COMMIT")
+	if !reflect.DeepEqual(got, []string{"Euro"}) {
+		t.Errorf("in transaction got %q, want its own write", got)
+	}
+	if got := cachedRows(t, db, cache, lookup); !reflect.DeepEqual(got, []string{"Euro"}) {
+		t.Errorf("after commit got %q", got)
+	}
+	if st := pinnedStats(cache, "dbo.Currencies"); st.Invalidations < 2 {
+		t.Errorf("stats = %+v, want the writes' invalidations", st)
+	}
+}
+
+func TestTableCache_TooLarge(t *testing.T) {
+	db, cache := pinnedDB(t, 2)
+
+	got := cachedRows(t, db, cache, "SELECT Name FROM Currencies WHERE Code = 'USD'")
+	if !reflect.DeepEqual(got, []string{"Dollar"}) {
+		t.Errorf("got %q", got)
+	}
+	if st := pinnedStats(cache, "dbo.Currencies"); st.State != "TOO_LARGE" || st.Hits != 0 {
+		t.Errorf("stats = %+v, want too large and read from the backend", st)
+	}
+}
+
+func TestTableSnapshotLookup(t *testing.T) {
+	s := newTableSnapshot([]string{"n", "mixed"}, [][]Value{
+		{NewInt(1), NewInt(1)},
+		{NewFloat(2.0), NewVarChar("2", -1)},
+		{Null(TypeInt), NewInt(3)},
+	})
+
+	// Numbers match by value, whatever their type
+	if rows, ok := s.lookup(0, NewBigInt(2)); !ok || !reflect.DeepEqual(rows, []int{1}) {
+		t.Errorf("lookup(2) = %v, %v", rows, ok)
+	}
+	// Strings compare with numbers by their text, which the index cannot
+	// answer
+	if _, ok := s.lookup(0, NewVarChar("1", -1)); ok {
+		t.Error("lookup('1') answered from a numeric index")
+	}
+	if _, ok := s.lookup(1, NewInt(3)); ok {
+		t.Error("lookup answered from an index of mixed values")
+	}
+	if rows, ok := s.lookup(0, Null(TypeInt)); !ok || len(rows) != 0 {
+		t.Errorf("lookup(NULL) = %v, %v, want no rows", rows, ok)
+	}
+}
@@ -0,0 +1,246 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+	"github.com/ha1tch/aul/pkg/tsqlparser/token"
+)
+
+// Table-valued functions the translated backends lack are expanded inline
+// into derived tables:
+//
+//	GENERATE_SERIES(start, stop [, step])  -- SQL Server 2022
+//	aul.CALENDAR(start_date, end_date)     -- one row per day
+//
+// SQL Server runs GENERATE_SERIES natively and is passed through unchanged.
+
+// calendarColumns lists the columns of aul.CALENDAR in order.
+var calendarColumns = []string{
+	"calendar_date", "year", "quarter", "month", "month_name", "day",
+	"day_of_year", "day_of_week", "day_name", "week_of_year", "is_weekend",
+}
+
+// tableFunctionArgs rewrites and renders the arguments of tvf, or returns
+// false if it was not called with between min and max of them.
+func (r *BaseRewriter) tableFunctionArgs(tvf *ast.TableValuedFunction, min, max int) ([]string, bool) {
+	if len(tvf.Arguments) < min || len(tvf.Arguments) > max {
+		return nil, false
+	}
+	args := make([]string, len(tvf.Arguments))
+	for i, arg := range tvf.Arguments {
+		args[i] = r.RewriteExpression(arg).String()
+	}
+	return args, true
+}
+
+// seriesStep returns the step of a GENERATE_SERIES call. Without one the
+// series counts down when start is greater than stop.
+func seriesStep(args []string) string {
+	if len(args) == 3 {
+		return args[2]
+	}
+	return fmt.Sprintf("(CASE WHEN %s <= %s THEN 1 ELSE -1 END)", args[0], args[1])
+}
+
+// tableFunctionColumn returns the name of column i of tvf, honouring any
+// column aliases given as AS t(c1, c2, ...).
+func tableFunctionColumn(tvf *ast.TableValuedFunction, i int, name string) string {
+	if i < len(tvf.ColumnAliases) && tvf.ColumnAliases[i] != nil {
+		return tvf.ColumnAliases[i].Value
+	}
+	return name
+}
+
+// derivedTableRef wraps the query sql as a table reference aliased as tvf
+// was, or as defaultAlias.
+func derivedTableRef(tvf *ast.TableValuedFunction, sql, defaultAlias string) ast.TableReference {
+	return derivedTable(tvf.Token, tvf.Alias, sql, defaultAlias)
+}
+
+// derivedTable wraps the query sql as a table reference aliased as alias,
+// or as defaultAlias.
+func derivedTable(tok token.Token, alias *ast.Identifier, sql, defaultAlias string) ast.TableReference {
+	if alias == nil {
+		alias = &ast.Identifier{Token: tok, Value: defaultAlias}
+	}
+	// The query is emitted as-is in place of the table name
+	return &ast.TableName{
+		Token: tok,
+		Name: &ast.QualifiedIdentifier{
+			Parts: []*ast.Identifier{{Token: quotedToken(tok, 0), Value: "(" + sql + ")"}},
+		},
+		Alias: alias,
+	}
+}
+
+// recursiveSeries builds GENERATE_SERIES as a recursive CTE. from is
+// appended to the anchor SELECT for dialects that need a FROM clause.
+func recursiveSeries(tvf *ast.TableValuedFunction, args []string, from string) ast.TableReference {
+	start, stop, step := args[0], args[1], seriesStep(args)
+	sql := fmt.Sprintf("WITH RECURSIVE aul_series(value) AS ("+
+		"SELECT %[1]s%[4]s WHERE (%[3]s > 0 AND %[1]s <= %[2]s) OR (%[3]s < 0 AND %[1]s >= %[2]s) "+
+		"UNION ALL SELECT value + %[3]s FROM aul_series "+
+		"WHERE (%[3]s > 0 AND value + %[3]s <= %[2]s) OR (%[3]s < 0 AND value + %[3]s >= %[2]s)"+
+		") SELECT value AS %[5]s FROM aul_series",
+		start, stop, step, from, tableFunctionColumn(tvf, 0, "value"))
+	return derivedTableRef(tvf, sql, "generate_series")
+}
+
+// calendarSelect renders the select list of aul.CALENDAR from one
+// expression per column.
+func calendarSelect(tvf *ast.TableValuedFunction, exprs []string) string {
+	cols := make([]string, len(calendarColumns))
+	for i, name := range calendarColumns {
+		cols[i] = exprs[i] + " AS " + tableFunctionColumn(tvf, i, name)
+	}
+	return strings.Join(cols, ", ")
+}
+
+// nameCase maps each of keys taken by expr to the matching name, for
+// dialects without month and day names.
+func nameCase(expr string, keys, names []string) string {
+	var out strings.Builder
+	out.WriteString("CASE " + expr)
+	for i, name := range names {
+		fmt.Fprintf(&out, " WHEN '%s' THEN '%s'", keys[i], name)
+	}
+	out.WriteString(" END")
+	return out.String()
+}
+
+// -----------------------------------------------------------------------------
+// SQLite
+// -----------------------------------------------------------------------------
+
+// rewriteGenerateSeries expands GENERATE_SERIES into a recursive CTE.
+func (r *SQLiteRewriter) rewriteGenerateSeries(tvf *ast.TableValuedFunction) ast.TableReference {
+	args, ok := r.tableFunctionArgs(tvf, 2, 3)
+	if !ok {
+		return tvf
+	}
+	return recursiveSeries(tvf, args, "")
+}
+
+// rewriteCalendar expands aul.CALENDAR into a recursive CTE over dates.
+func (r *SQLiteRewriter) rewriteCalendar(tvf *ast.TableValuedFunction) ast.TableReference {
+	args, ok := r.tableFunctionArgs(tvf, 2, 2)
+	if !ok {
+		return tvf
+	}
+	start, end := "date("+args[0]+")", "date("+args[1]+")"
+	num := func(format string) string {
+		return "CAST(strftime('" + format + "', d) AS INTEGER)"
+	}
+	months := []string{"January", "February", "March", "April", "May", "June",
+		"July", "August", "September", "October", "November", "December"}
+	monthKeys := make([]string, len(months))
+	for i := range months {
+		monthKeys[i] = fmt.Sprintf("%02d", i+1)
+	}
+	days := []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+	dayKeys := []string{"0", "1", "2", "3", "4", "5", "6"}
+
+	sql := fmt.Sprintf("WITH RECURSIVE aul_calendar(d) AS ("+
+		"SELECT %[1]s WHERE %[1]s <= %[2]s "+
+		"UNION ALL SELECT date(d, '+1 day') FROM aul_calendar WHERE d < %[2]s"+
+		") SELECT %[3]s FROM aul_calendar",
+		start, end, calendarSelect(tvf, []string{
+			"d",
+			num("%Y"),
+			"(" + num("%m") + " + 2) / 3",
+			num("%m"),
+			nameCase("strftime('%m', d)", monthKeys, months),
+			num("%d"),
+			num("%j"),
+			num("%w") + " + 1",
+			nameCase("strftime('%w', d)", dayKeys, days),
+			"(" + num("%j") + " + CAST(strftime('%w', date(d, 'start of year')) AS INTEGER) - 1) / 7 + 1",
+			"CASE WHEN strftime('%w', d) IN ('0', '6') THEN 1 ELSE 0 END",
+		}))
+	return derivedTableRef(tvf, sql, "calendar")
+}
+
+// -----------------------------------------------------------------------------
+// PostgreSQL
+// -----------------------------------------------------------------------------
+
+// rewriteGenerateSeries maps GENERATE_SERIES onto generate_series, naming
+// its column as SQL Server does.
+func (r *PostgresRewriter) rewriteGenerateSeries(tvf *ast.TableValuedFunction) ast.TableReference {
+	args, ok := r.tableFunctionArgs(tvf, 2, 3)
+	if !ok {
+		return tvf
+	}
+	sql := fmt.Sprintf("SELECT value AS %s FROM generate_series(%s, %s, %s) AS aul_series(value)",
+		tableFunctionColumn(tvf, 0, "value"), args[0], args[1], seriesStep(args))
+	return derivedTableRef(tvf, sql, "generate_series")
+}
+
+// rewriteCalendar expands aul.CALENDAR over generate_series of dates.
+func (r *PostgresRewriter) rewriteCalendar(tvf *ast.TableValuedFunction) ast.TableReference {
+	args, ok := r.tableFunctionArgs(tvf, 2, 2)
+	if !ok {
+		return tvf
+	}
+	field := func(name string) string {
+		return "CAST(EXTRACT(" + name + " FROM d) AS INTEGER)"
+	}
+	sql := fmt.Sprintf("SELECT %s FROM generate_series(CAST(%s AS DATE), CAST(%s AS DATE), INTERVAL '1 day') AS aul_calendar(d)",
+		calendarSelect(tvf, []string{
+			"CAST(d AS DATE)",
+			field("YEAR"),
+			field("QUARTER"),
+			field("MONTH"),
+			"to_char(d, 'FMMonth')",
+			field("DAY"),
+			field("DOY"),
+			field("DOW") + " + 1",
+			"to_char(d, 'FMDay')",
+			"(" + field("DOY") + " + CAST(EXTRACT(DOW FROM date_trunc('year', d)) AS INTEGER) - 1) / 7 + 1",
+			"CASE WHEN EXTRACT(DOW FROM d) IN (0, 6) THEN 1 ELSE 0 END",
+		}), args[0], args[1])
+	return derivedTableRef(tvf, sql, "calendar")
+}
+
+// -----------------------------------------------------------------------------
+// MySQL
+// -----------------------------------------------------------------------------
+
+// rewriteGenerateSeries expands GENERATE_SERIES into a recursive CTE. MySQL
+// limits recursion to cte_max_recursion_depth rows (1000 by default).
+func (r *MySQLRewriter) rewriteGenerateSeries(tvf *ast.TableValuedFunction) ast.TableReference {
+	args, ok := r.tableFunctionArgs(tvf, 2, 3)
+	if !ok {
+		return tvf
+	}
+	return recursiveSeries(tvf, args, " FROM DUAL")
+}
+
+// rewriteCalendar expands aul.CALENDAR into a recursive CTE over dates.
+func (r *MySQLRewriter) rewriteCalendar(tvf *ast.TableValuedFunction) ast.TableReference {
+	args, ok := r.tableFunctionArgs(tvf, 2, 2)
+	if !ok {
+		return tvf
+	}
+	start, end := "CAST("+args[0]+" AS DATE)", "CAST("+args[1]+" AS DATE)"
+	sql := fmt.Sprintf("WITH RECURSIVE aul_calendar(d) AS ("+
+		"SELECT %[1]s FROM DUAL WHERE %[1]s <= %[2]s "+
+		"UNION ALL SELECT d + INTERVAL 1 DAY FROM aul_calendar WHERE d < %[2]s"+
+		") SELECT %[3]s FROM aul_calendar",
+		start, end, calendarSelect(tvf, []string{
+			"d",
+			"YEAR(d)",
+			"QUARTER(d)",
+			"MONTH(d)",
+			"MONTHNAME(d)",
+			"DAY(d)",
+			"DAYOFYEAR(d)",
+			"DAYOFWEEK(d)",
+			"DAYNAME(d)",
+			"(DAYOFYEAR(d) + DAYOFWEEK(MAKEDATE(YEAR(d), 1)) - 2) DIV 7 + 1",
+			"CASE WHEN DAYOFWEEK(d) IN (1, 7) THEN 1 ELSE 0 END",
+		}))
+	return derivedTableRef(tvf, sql, "calendar")
+}
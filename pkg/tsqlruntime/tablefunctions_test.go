@@ -0,0 +1,132 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// queryColumn runs sql against SQLite and returns the first column of each
+// row as strings.
+func queryColumn(t *testing.T, sql string, params map[string]interface{}) []string {
+	t.Helper()
+	db := setupTestDB(t)
+	defer db.Close()
+
+	result, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(), sql, params)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	if len(result.ResultSets) != 1 {
+		t.Fatalf("%s: got %d result sets, want 1", sql, len(result.ResultSets))
+	}
+	var got []string
+	for _, row := range result.ResultSets[0].Rows {
+		got = append(got, row[0].AsString())
+	}
+	return got
+}
+
+func TestGenerateSeries_SQLite(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []string
+	}{
+		{"SELECT value FROM GENERATE_SERIES(1, 5)", []string{"1", "2", "3", "4", "5"}},
+		{"SELECT value FROM GENERATE_SERIES(1, 10, 4)", []string{"1", "5", "9"}},
+		{"SELECT value FROM GENERATE_SERIES(3, 1)", []string{"3", "2", "1"}},
+		{"SELECT value FROM GENERATE_SERIES(10, 0, -5)", []string{"10", "5", "0"}},
+		{"SELECT value FROM GENERATE_SERIES(1, 5, -1)", nil},
+		{"SELECT n FROM GENERATE_SERIES(1, 2) AS s(n)", []string{"1", "2"}},
+		{"SELECT s.value * 2 FROM GENERATE_SERIES(@lo, @hi) s", []string{"4", "6"}},
+		{"SELECT x * 10 + y FROM GENERATE_SERIES(1, 2) AS a(x) CROSS JOIN GENERATE_SERIES(1, 2) AS b(y) ORDER BY 1",
+			[]string{"11", "12", "21", "22"}},
+		{"SELECT COUNT(*) FROM (SELECT value FROM GENERATE_SERIES(1, 100)) x", []string{"100"}},
+	}
+
+	for _, tt := range tests {
+		got := queryColumn(t, tt.sql, map[string]interface{}{"@lo": 2, "@hi": 3})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestCalendar_SQLite(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// 2023-12-30 is a Saturday; 2024-01-01 a Monday
+	result, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(),
+		"SELECT * FROM aul.CALENDAR('2023-12-30', '2024-01-07')", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := result.ResultSets[0]
+	if !reflect.DeepEqual(rs.Columns, calendarColumns) {
+		t.Errorf("columns = %v", rs.Columns)
+	}
+	if len(rs.Rows) != 9 {
+		t.Fatalf("got %d rows, want 9", len(rs.Rows))
+	}
+
+	row := func(i int) string {
+		var parts []string
+		for _, v := range rs.Rows[i] {
+			parts = append(parts, v.AsString())
+		}
+		return strings.Join(parts, " ")
+	}
+	want := map[int]string{
+		0: "2023-12-30 2023 4 12 December 30 364 7 Saturday 52 1",
+		2: "2024-01-01 2024 1 1 January 1 1 2 Monday 1 0",
+		8: "2024-01-07 2024 1 1 January 7 7 1 Sunday 2 1",
+	}
+	for i, w := range want {
+		if got := row(i); got != w {
+			t.Errorf("row %d = %q, want %q", i, got, w)
+		}
+	}
+
+	if got := queryColumn(t, "SELECT d FROM aul.CALENDAR('2024-02-28', '2024-03-01') AS c(d)", nil); !reflect.DeepEqual(got, []string{"2024-02-28", "2024-02-29", "2024-03-01"}) {
+		t.Errorf("leap year dates = %v", got)
+	}
+}
+
+func TestTableFunctions_Rewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewriter ASTRewriter
+		input    string
+		contains string
+	}{
+		{"postgres series", NewPostgresRewriter(),
+			"SELECT value FROM GENERATE_SERIES(1, 10, 2)",
+			"FROM (SELECT value AS value FROM generate_series(1, 10, 2) AS aul_series(value)) AS generate_series"},
+		{"postgres calendar", NewPostgresRewriter(),
+			"SELECT calendar_date FROM aul.CALENDAR('2024-01-01', '2024-12-31') c",
+			"generate_series(CAST('2024-01-01' AS DATE), CAST('2024-12-31' AS DATE), INTERVAL '1 day')"},
+		{"mysql series", NewMySQLRewriter(),
+			"SELECT value FROM GENERATE_SERIES(1, 10)",
+			"WITH RECURSIVE aul_series(value) AS (SELECT 1 FROM DUAL WHERE"},
+		{"mysql calendar", NewMySQLRewriter(),
+			"SELECT day_name FROM aul.CALENDAR('2024-01-01', '2024-01-31')",
+			"DAYNAME(d) AS day_name"},
+		{"nested in join", NewSQLiteRewriter(),
+			"SELECT o.id FROM orders o JOIN aul.CALENDAR('2024-01-01', '2024-01-31') c ON o.d = c.calendar_date",
+			"WITH RECURSIVE aul_calendar(d)"},
+		{"sql server unchanged", &PassthroughRewriter{},
+			"SELECT value FROM GENERATE_SERIES(1, 10)",
+			"GENERATE_SERIES(1, 10)"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			output := tc.rewriter.RewriteStatement(parseSQL(t, tc.input)).String()
+			if !strings.Contains(output, tc.contains) {
+				t.Errorf("expected %q in output:\n%s", tc.contains, output)
+			}
+		})
+	}
+}
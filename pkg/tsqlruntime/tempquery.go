@@ -0,0 +1,399 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// SELECTs over temp tables and table variables run in memory, as do those
+// over pinned tables (see cachequery.go). WHERE filters the rows; if the
+// query aggregates, the rows are hashed into groups by
+// their GROUP BY values and each aggregate call is computed once per group,
+// after which the select list, HAVING and ORDER BY are evaluated for the
+// group with the aggregates' values in place of the calls. Temp table
+// column values are bound as evaluator variables named after the columns.
+
+// aggregateFunctions are the aggregates computed over temp table rows.
+var aggregateFunctions = map[string]bool{
+	"COUNT": true, "COUNT_BIG": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true,
+}
+
+// isAggregateCall reports whether expr is an aggregate call rather than a
+// windowed one.
+func isAggregateCall(expr ast.Expression) (*ast.FunctionCall, bool) {
+	fc, ok := expr.(*ast.FunctionCall)
+	if !ok || fc.Over != nil || fc.Function == nil {
+		return nil, false
+	}
+	return fc, aggregateFunctions[strings.ToUpper(fc.Function.String())]
+}
+
+// collectAggregates appends the aggregate calls within expr to calls.
+// Subqueries are not searched.
+func collectAggregates(expr ast.Expression, calls []*ast.FunctionCall) []*ast.FunctionCall {
+	if fc, ok := isAggregateCall(expr); ok {
+		return append(calls, fc)
+	}
+	switch e := expr.(type) {
+	case *ast.FunctionCall:
+		for _, arg := range e.Arguments {
+			calls = collectAggregates(arg, calls)
+		}
+	case *ast.InfixExpression:
+		calls = collectAggregates(e.Left, calls)
+		calls = collectAggregates(e.Right, calls)
+	case *ast.PrefixExpression:
+		calls = collectAggregates(e.Right, calls)
+	case *ast.CaseExpression:
+		calls = collectAggregates(e.Operand, calls)
+		for _, w := range e.WhenClauses {
+			calls = collectAggregates(w.Condition, calls)
+			calls = collectAggregates(w.Result, calls)
+		}
+		calls = collectAggregates(e.ElseClause, calls)
+	case *ast.CastExpression:
+		calls = collectAggregates(e.Expression, calls)
+	case *ast.ConvertExpression:
+		calls = collectAggregates(e.Expression, calls)
+	case *ast.BetweenExpression:
+		calls = collectAggregates(e.Expr, calls)
+		calls = collectAggregates(e.Low, calls)
+		calls = collectAggregates(e.High, calls)
+	case *ast.InExpression:
+		calls = collectAggregates(e.Expr, calls)
+		for _, v := range e.Values {
+			calls = collectAggregates(v, calls)
+		}
+	case *ast.IsNullExpression:
+		calls = collectAggregates(e.Expr, calls)
+	}
+	return calls
+}
+
+// tempGroup is one GROUP BY group of temp table rows.
+type tempGroup struct {
+	rows [][]Value
+}
+
+// groupKey renders values as a hash key; NULLs group together.
+func groupKey(values []Value) string {
+	var key strings.Builder
+	for _, v := range values {
+		if v.IsNull {
+			key.WriteString("\x00N")
+		} else {
+			key.WriteString("\x00V" + v.AsString())
+		}
+	}
+	return key.String()
+}
+
+// bindTempRow sets each column of table as a variable holding its value in
+// row, or NULL if row is nil.
+func (i *Interpreter) bindTempRow(table *TempTable, row []Value) {
+	for j, col := range table.Columns {
+		if row == nil {
+			i.evaluator.SetVariable(col.Name, Null(col.Type))
+		} else {
+			i.evaluator.SetVariable(col.Name, row[j])
+		}
+	}
+}
+
+// computeAggregate evaluates the aggregate call fc over rows, each bound
+// for the evaluator by bind.
+func (i *Interpreter) computeAggregate(fc *ast.FunctionCall, bind func(row []Value), rows [][]Value) (Value, error) {
+	name := strings.ToUpper(fc.Function.String())
+	if len(fc.Arguments) != 1 {
+		return Value{}, fmt.Errorf("%s requires 1 argument", name)
+	}
+
+	// COUNT(*) counts rows, NULLs included
+	if ident, ok := fc.Arguments[0].(*ast.Identifier); ok && ident.Value == "*" {
+		if name == "COUNT_BIG" {
+			return NewBigInt(int64(len(rows))), nil
+		}
+		return NewInt(int64(len(rows))), nil
+	}
+
+	var values []Value
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		bind(row)
+		v, err := i.evaluator.Evaluate(fc.Arguments[0])
+		if err != nil {
+			return Value{}, err
+		}
+		if v.IsNull {
+			continue
+		}
+		if fc.Distinct {
+			key := groupKey([]Value{v})
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		values = append(values, v)
+	}
+
+	switch name {
+	case "COUNT":
+		return NewInt(int64(len(values))), nil
+	case "COUNT_BIG":
+		return NewBigInt(int64(len(values))), nil
+	}
+	if len(values) == 0 {
+		return Null(TypeUnknown), nil
+	}
+
+	result := values[0]
+	for _, v := range values[1:] {
+		switch name {
+		case "SUM", "AVG":
+			result = result.Add(v)
+		case "MIN":
+			if v.Compare(result) < 0 {
+				result = v
+			}
+		case "MAX":
+			if v.Compare(result) > 0 {
+				result = v
+			}
+		}
+	}
+	if name == "AVG" {
+		// Integer averages truncate, as in SQL Server
+		result = result.Div(NewBigInt(int64(len(values))))
+	}
+	return result, nil
+}
+
+// selectColumnName returns the result column name of col.
+func selectColumnName(col ast.SelectColumn, idx int) string {
+	switch {
+	case col.Alias != nil && col.Alias.Value != "":
+		return col.Alias.Value
+	case col.Expression != nil:
+		switch e := col.Expression.(type) {
+		case *ast.Identifier:
+			return e.Value
+		case *ast.QualifiedIdentifier:
+			// t.Name is named Name
+			return e.Parts[len(e.Parts)-1].Value
+		}
+		return col.Expression.String()
+	}
+	return fmt.Sprintf("column%d", idx)
+}
+
+// queryTempTable evaluates s over the rows of table.
+func (i *Interpreter) queryTempTable(s *ast.SelectStatement, table *TempTable) (ResultSet, error) {
+	// Filter rows
+	var filterErr error
+	var predicate func([]Value) bool
+	if s.Where != nil {
+		predicate = func(row []Value) bool {
+			if filterErr != nil {
+				return false
+			}
+			i.bindTempRow(table, row)
+			result, err := i.evaluator.Evaluate(s.Where)
+			if err != nil {
+				filterErr = err
+				return false
+			}
+			return result.IsTruthy()
+		}
+	}
+	rows := table.Select(predicate)
+	if filterErr != nil {
+		return ResultSet{}, filterErr
+	}
+
+	src := memoryRows{rows: rows, bind: func(row []Value) { i.bindTempRow(table, row) }}
+	for _, tc := range table.Columns {
+		src.columns = append(src.columns, tc.Name)
+	}
+	return i.queryMemoryRows(s, src)
+}
+
+// memoryRows is the input of a SELECT evaluated in memory: the rows WHERE
+// kept and the columns * expands to. bind makes a row's values, or NULLs
+// for nil, those the evaluator reads for its columns.
+type memoryRows struct {
+	columns []string
+	rows    [][]Value
+	bind    func(row []Value)
+}
+
+// queryMemoryRows evaluates the select list, grouping, HAVING, ORDER BY,
+// DISTINCT and TOP of s over the rows of src.
+func (i *Interpreter) queryMemoryRows(s *ast.SelectStatement, src memoryRows) (ResultSet, error) {
+	rows := src.rows
+
+	// Result columns; * expands to the source's columns
+	var columns []string
+	for idx, col := range s.Columns {
+		if col.AllColumns {
+			columns = append(columns, src.columns...)
+			continue
+		}
+		columns = append(columns, selectColumnName(col, idx))
+	}
+
+	var aggregates []*ast.FunctionCall
+	for _, col := range s.Columns {
+		aggregates = collectAggregates(col.Expression, aggregates)
+	}
+	aggregates = collectAggregates(s.Having, aggregates)
+	for _, item := range s.OrderBy {
+		aggregates = collectAggregates(item.Expression, aggregates)
+	}
+
+	// Without aggregation each row is its own group
+	var groups []*tempGroup
+	if len(aggregates) == 0 && len(s.GroupBy) == 0 {
+		for _, row := range rows {
+			groups = append(groups, &tempGroup{rows: [][]Value{row}})
+		}
+	} else {
+		byKey := make(map[string]*tempGroup)
+		for _, row := range rows {
+			src.bind(row)
+			keyValues := make([]Value, len(s.GroupBy))
+			for j, expr := range s.GroupBy {
+				v, err := i.evaluator.Evaluate(expr)
+				if err != nil {
+					return ResultSet{}, err
+				}
+				keyValues[j] = v
+			}
+			key := groupKey(keyValues)
+			g, ok := byKey[key]
+			if !ok {
+				g = &tempGroup{}
+				byKey[key] = g
+				groups = append(groups, g)
+			}
+			g.rows = append(g.rows, row)
+		}
+		// An aggregate without GROUP BY returns one row even for no input
+		if len(groups) == 0 && len(s.GroupBy) == 0 {
+			groups = append(groups, &tempGroup{})
+		}
+	}
+
+	type outputRow struct {
+		values []Value
+		keys   []Value
+	}
+	var output []outputRow
+	defer func() { i.evaluator.aggregates = nil }()
+	for _, g := range groups {
+		i.evaluator.aggregates = make(map[*ast.FunctionCall]Value, len(aggregates))
+		for _, fc := range aggregates {
+			v, err := i.computeAggregate(fc, src.bind, g.rows)
+			if err != nil {
+				return ResultSet{}, err
+			}
+			i.evaluator.aggregates[fc] = v
+		}
+
+		// Non-aggregated columns take their values from the group's first row
+		var first []Value
+		if len(g.rows) > 0 {
+			first = g.rows[0]
+		}
+		src.bind(first)
+
+		if s.Having != nil {
+			keep, err := i.evaluator.Evaluate(s.Having)
+			if err != nil {
+				return ResultSet{}, err
+			}
+			if !keep.IsTruthy() {
+				continue
+			}
+		}
+
+		var values []Value
+		for idx, col := range s.Columns {
+			if col.AllColumns {
+				values = append(values, first...)
+				continue
+			}
+			v, err := i.evaluator.Evaluate(col.Expression)
+			if err != nil {
+				return ResultSet{}, err
+			}
+			values = append(values, v)
+			// ORDER BY may refer to the column by its alias
+			if col.Alias != nil {
+				i.evaluator.SetVariable(selectColumnName(col, idx), v)
+			}
+		}
+
+		keys := make([]Value, len(s.OrderBy))
+		for j, item := range s.OrderBy {
+			v, err := i.evaluator.Evaluate(item.Expression)
+			if err != nil {
+				return ResultSet{}, err
+			}
+			keys[j] = v
+		}
+		output = append(output, outputRow{values: values, keys: keys})
+	}
+
+	// NULLs sort first, as in SQL Server
+	sort.SliceStable(output, func(a, b int) bool {
+		for j, item := range s.OrderBy {
+			ka, kb := output[a].keys[j], output[b].keys[j]
+			var c int
+			switch {
+			case ka.IsNull && kb.IsNull:
+				continue
+			case ka.IsNull:
+				c = -1
+			case kb.IsNull:
+				c = 1
+			default:
+				c = ka.Compare(kb)
+			}
+			if item.Descending {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	rs := ResultSet{Columns: columns}
+	seen := make(map[string]bool)
+	for _, row := range output {
+		if s.Distinct {
+			key := groupKey(row.values)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		rs.Rows = append(rs.Rows, row.values)
+	}
+
+	if s.Top != nil && s.Top.Count != nil && !s.Top.Percent {
+		n, err := i.evaluator.Evaluate(s.Top.Count)
+		if err != nil {
+			return ResultSet{}, err
+		}
+		if limit := int(n.AsInt()); limit >= 0 && limit < len(rs.Rows) {
+			rs.Rows = rs.Rows[:limit]
+		}
+	}
+	return rs, nil
+}
@@ -0,0 +1,117 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// salesSetup creates #sales with rows for two regions and one with none.
+const salesSetup = "CREATE TABLE #sales (region VARCHAR(10), qty INT, price DECIMAL(10, 2)); " +
+	"INSERT INTO #sales VALUES ('north', 3, 2.50); " +
+	"INSERT INTO #sales VALUES ('north', 4, 1.00); " +
+	"INSERT INTO #sales VALUES ('south', 5, 3.00); " +
+	"INSERT INTO #sales VALUES ('south', NULL, 3.00); " +
+	"INSERT INTO #sales VALUES (NULL, 1, 9.99); "
+
+// queryRows runs sql against SQLite and returns each row of the last
+// result set as its values joined by spaces.
+func queryRows(t *testing.T, sql string) []string {
+	t.Helper()
+	db := setupTestDB(t)
+	defer db.Close()
+
+	result, err := NewInterpreter(db, DialectSQLite).Execute(context.Background(), sql, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	if len(result.ResultSets) == 0 {
+		t.Fatalf("%s: no result sets", sql)
+	}
+	var rows []string
+	for _, row := range result.ResultSets[len(result.ResultSets)-1].Rows {
+		parts := make([]string, len(row))
+		for i, v := range row {
+			parts[i] = valueString(v)
+		}
+		rows = append(rows, strings.Join(parts, " "))
+	}
+	return rows
+}
+
+func TestTempTableAggregates(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"whole table",
+			"SELECT COUNT(*), COUNT(qty), SUM(qty), MIN(price), MAX(price) FROM #sales",
+			[]string{"5 4 13 1 9.99"}},
+		{"integer average truncates",
+			"SELECT AVG(qty) FROM #sales",
+			[]string{"3"}},
+		{"count distinct",
+			"SELECT COUNT(DISTINCT region), COUNT(DISTINCT price) FROM #sales",
+			[]string{"2 4"}},
+		{"filtered",
+			"SELECT COUNT(*), SUM(qty * price) FROM #sales WHERE region = 'north'",
+			[]string{"2 11.5"}},
+		{"no matching rows",
+			"SELECT COUNT(*), SUM(qty) FROM #sales WHERE qty > 100",
+			[]string{"0 NULL"}},
+		{"group by",
+			"SELECT region, COUNT(*) AS n, SUM(qty) AS total FROM #sales GROUP BY region ORDER BY region",
+			[]string{"NULL 1 1", "north 2 7", "south 2 5"}},
+		{"having and order by aggregate",
+			"SELECT region, SUM(qty) FROM #sales GROUP BY region HAVING COUNT(*) > 1 ORDER BY SUM(qty) DESC",
+			[]string{"north 7", "south 5"}},
+		{"order by alias",
+			"SELECT region, MAX(price) AS top_price FROM #sales WHERE region IS NOT NULL GROUP BY region ORDER BY top_price",
+			[]string{"north 2.5", "south 3"}},
+		{"group by without aggregates",
+			"SELECT region FROM #sales WHERE region IS NOT NULL GROUP BY region ORDER BY region DESC",
+			[]string{"south", "north"}},
+		{"expression over aggregates",
+			"SELECT IIF(COUNT(*) > 4, 'many', 'few'), SUM(qty) + 1 FROM #sales",
+			[]string{"many 14"}},
+		{"projection and top",
+			"SELECT TOP 2 qty, region FROM #sales WHERE qty IS NOT NULL ORDER BY qty DESC",
+			[]string{"5 south", "4 north"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryRows(t, salesSetup+tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableVariableAggregates(t *testing.T) {
+	got := queryRows(t, "DECLARE @t TABLE (k INT, v INT); "+
+		"INSERT INTO @t VALUES (1, 10); INSERT INTO @t VALUES (1, 20); INSERT INTO @t VALUES (2, 5); "+
+		"SELECT k, SUM(v), AVG(v) FROM @t GROUP BY k ORDER BY k")
+	if want := []string{"1 30 15", "2 5 5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTempTableAggregateIntoVariable(t *testing.T) {
+	got := queryRows(t, salesSetup+
+		"DECLARE @n INT; DECLARE @total INT; "+
+		"SELECT @n = COUNT(*), @total = SUM(qty) FROM #sales WHERE region = 'south'; "+
+		"SELECT @n, @total")
+	if want := []string{"2 5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCountDistinct_Rewrite(t *testing.T) {
+	output := NewSQLiteRewriter().RewriteStatement(parseSQL(t, "SELECT COUNT(DISTINCT a) FROM t")).String()
+	if !strings.Contains(output, "COUNT(DISTINCT a)") {
+		t.Errorf("expected COUNT(DISTINCT a) in output:\n%s", output)
+	}
+}
@@ -15,6 +15,9 @@ type TempTable struct {
 	PrimaryKey []string
 	Indexes    map[string]*TempTableIndex
 	mu         sync.RWMutex
+
+	memory MemoryBudget // Charged for inserted rows (nil = untracked)
+	bytes  int64        // Estimated size of Rows
 }
 
 // TempTableColumn represents a column in a temp table
@@ -50,6 +53,7 @@ type TempTableManager struct {
 	globalTables map[string]*TempTable  // ##tables - global (simplified)
 	tableVars    map[string]*TableVariable
 	mu           sync.RWMutex
+	memory       MemoryBudget
 }
 
 // NewTempTableManager creates a new temp table manager
@@ -86,6 +90,7 @@ func (m *TempTableManager) CreateTempTable(name string, columns []TempTableColum
 		Columns: columns,
 		Rows:    make([][]Value, 0),
 		Indexes: make(map[string]*TempTableIndex),
+		memory:  m.memory,
 	}
 
 	if isGlobal {
@@ -125,15 +130,19 @@ func (m *TempTableManager) DropTempTable(name string) error {
 	name = strings.ToLower(name)
 
 	if strings.HasPrefix(name, "##") {
-		if _, exists := m.globalTables[name]; !exists {
+		table, exists := m.globalTables[name]
+		if !exists {
 			return fmt.Errorf("temp table %s does not exist", name)
 		}
 		delete(m.globalTables, name)
+		table.Truncate()
 	} else {
-		if _, exists := m.localTables[name]; !exists {
+		table, exists := m.localTables[name]
+		if !exists {
 			return fmt.Errorf("temp table %s does not exist", name)
 		}
 		delete(m.localTables, name)
+		table.Truncate()
 	}
 
 	return nil
@@ -162,6 +171,7 @@ func (m *TempTableManager) CreateTableVariable(name string, columns []TempTableC
 			Columns: columns,
 			Rows:    make([][]Value, 0),
 			Indexes: make(map[string]*TempTableIndex),
+			memory:  m.memory,
 		},
 	}
 
@@ -184,10 +194,24 @@ func (m *TempTableManager) ClearSession() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	for _, table := range m.localTables {
+		table.Truncate()
+	}
+	for _, tv := range m.tableVars {
+		tv.Truncate()
+	}
 	m.localTables = make(map[string]*TempTable)
 	m.tableVars = make(map[string]*TableVariable)
 }
 
+// SetMemoryBudget sets the budget charged for rows inserted into tables
+// created from now on.
+func (m *TempTableManager) SetMemoryBudget(budget MemoryBudget) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memory = budget
+}
+
 // TempTable methods
 
 // GetColumnIndex returns the index of a column by name
@@ -243,6 +267,9 @@ func (t *TempTable) Insert(values map[string]Value) (int64, error) {
 		}
 	}
 
+	if err := t.reserve(row); err != nil {
+		return 0, err
+	}
 	t.Rows = append(t.Rows, row)
 	return identityValue, nil
 }
@@ -279,6 +306,9 @@ func (t *TempTable) InsertRow(values []Value) (int64, error) {
 		}
 	}
 
+	if err := t.reserve(row); err != nil {
+		return 0, err
+	}
 	t.Rows = append(t.Rows, row)
 	return identityValue, nil
 }
@@ -367,19 +397,23 @@ func (t *TempTable) Delete(predicate func(row []Value) bool) int {
 	if predicate == nil {
 		count := len(t.Rows)
 		t.Rows = t.Rows[:0]
+		t.release(t.bytes)
 		return count
 	}
 
 	count := 0
+	var freed int64
 	newRows := make([][]Value, 0, len(t.Rows))
 	for _, row := range t.Rows {
 		if !predicate(row) {
 			newRows = append(newRows, row)
 		} else {
 			count++
+			freed += RowSize(row)
 		}
 	}
 	t.Rows = newRows
+	t.release(freed)
 	return count
 }
 
@@ -388,6 +422,32 @@ func (t *TempTable) Truncate() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.Rows = t.Rows[:0]
+	t.release(t.bytes)
+}
+
+// reserve charges a new row to the memory budget. Caller holds t.mu.
+func (t *TempTable) reserve(row []Value) error {
+	if t.memory == nil {
+		return nil
+	}
+	n := RowSize(row)
+	if err := t.memory.Reserve(n); err != nil {
+		return err
+	}
+	t.bytes += n
+	return nil
+}
+
+// release returns the memory of removed rows. Caller holds t.mu.
+func (t *TempTable) release(n int64) {
+	if t.memory == nil || n == 0 {
+		return
+	}
+	if n > t.bytes {
+		n = t.bytes
+	}
+	t.bytes -= n
+	t.memory.Release(n)
 }
 
 // RowCount returns the number of rows
@@ -0,0 +1,210 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// An execution can be traced, to debug a procedure's logic after it has
+// run: the trace records each statement executed, with where it is, the
+// time it took, the rows it affected and the error it failed with; each
+// value assigned to a variable; and each decision taken by IF, WHILE and
+// TRY...CATCH. Statements of nested procedures and dynamic SQL are traced
+// in the caller's trace, with their nesting level and the procedure they
+// belong to, so that the trace reads as the execution ran.
+//
+// A trace holds at most MaxTraceEvents events. Those past the limit are
+// counted, not kept, so that a long loop cannot exhaust memory.
+
+// MaxTraceEvents is the most events a trace holds.
+const MaxTraceEvents = 10000
+
+// Kinds of trace event.
+const (
+	TraceStatement = "statement" // A statement executed
+	TraceAssign    = "assign"    // A value assigned to a variable
+	TraceBranch    = "branch"    // The branch an IF took
+	TraceLoop      = "loop"      // A test of a WHILE condition
+	TraceCatch     = "catch"     // An error a CATCH block handled
+)
+
+// TraceEvent is one step of a traced execution.
+type TraceEvent struct {
+	Seq       int           `json:"seq"`
+	Offset    time.Duration `json:"offset_ns"` // Since the trace began
+	Kind      string        `json:"kind"`
+	Depth     int           `json:"depth"` // Nesting level of the code: 0 at the top
+	Procedure string        `json:"procedure,omitempty"`
+	Line      int           `json:"line,omitempty"`
+	Statement string        `json:"statement,omitempty"` // Shortened as journalled
+	Variable  string        `json:"variable,omitempty"`
+	Value     string        `json:"value,omitempty"`     // As a literal: NULL, 42, 'text'
+	Branch    string        `json:"branch,omitempty"`    // THEN, ELSE or NONE; for WHILE, LOOP or EXIT
+	Iteration int           `json:"iteration,omitempty"` // Of the WHILE loop, from 1
+	Rows      *int64        `json:"rows,omitempty"`      // Rows a query or write affected
+	Duration  time.Duration `json:"duration_ns,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Trace is the record of a traced execution.
+type Trace struct {
+	Started time.Time    `json:"started_at"`
+	Events  []TraceEvent `json:"events"`
+	Dropped int          `json:"dropped,omitempty"` // Events past MaxTraceEvents
+}
+
+// NewTrace returns an empty trace beginning now.
+func NewTrace() *Trace {
+	return &Trace{Started: time.Now(), Events: []TraceEvent{}}
+}
+
+// add appends e, returning its index in Events, or -1 if the trace is
+// full.
+func (t *Trace) add(e TraceEvent) int {
+	if len(t.Events) >= MaxTraceEvents {
+		t.Dropped++
+		return -1
+	}
+	e.Seq = len(t.Events) + 1
+	e.Offset = time.Since(t.Started)
+	t.Events = append(t.Events, e)
+	return len(t.Events) - 1
+}
+
+// SetTrace sets the trace the execution records its steps in, which is
+// also ExecutionResult.Trace; nil turns tracing off. The trace is kept
+// when the execution fails, which is when it is most wanted.
+func (i *Interpreter) SetTrace(trace *Trace) {
+	i.ctx.Trace = trace
+}
+
+// trace adds e to the trace, placing it in stmt, which may be nil, and
+// the code running it.
+func (i *Interpreter) trace(stmt ast.Statement, e TraceEvent) int {
+	e.Depth = i.nestingLevel
+	if n := len(i.callChain); n > 0 {
+		e.Procedure = i.callChain[n-1]
+	}
+	if stmt != nil {
+		if tok, ok := statementToken(stmt); ok {
+			e.Line = tok.Line
+		}
+	}
+	return i.ctx.Trace.add(e)
+}
+
+// traceStatement records stmt as it begins. The returned function
+// records the outcome and must be called once it has.
+func (i *Interpreter) traceStatement(stmt ast.Statement) func(err error) {
+	switch stmt.(type) {
+	case *ast.BeginEndBlock, *ast.CreateProcedureStatement,
+		*ast.IfStatement, *ast.WhileStatement, *ast.TryCatchStatement:
+		// Traced by what they contain and the decisions they take
+		return func(error) {}
+	}
+	trace := i.ctx.Trace
+	n := i.trace(stmt, TraceEvent{Kind: TraceStatement, Statement: journalText(stmt)})
+	start := time.Now()
+	return func(err error) {
+		if n < 0 {
+			return
+		}
+		e := &trace.Events[n]
+		e.Duration = time.Since(start)
+		if err != nil {
+			e.Error = err.Error()
+			return
+		}
+		kind, _, _ := summarizedStatement(stmt)
+		switch kind {
+		case "SELECT", "SELECT INTO", "INSERT", "UPDATE", "DELETE", "MERGE":
+			rows := i.ctx.RowCount
+			e.Rows = &rows
+		}
+	}
+}
+
+// assign sets a variable, tracing the value assigned.
+func (i *Interpreter) assign(name string, v Value) {
+	i.evaluator.SetVariable(name, v)
+	if i.ctx.Trace != nil {
+		i.trace(nil, TraceEvent{Kind: TraceAssign, Variable: name, Value: traceValue(v)})
+	}
+}
+
+// traceBranch records the branch an IF took.
+func (i *Interpreter) traceBranch(s *ast.IfStatement, branch string) {
+	if i.ctx.Trace != nil {
+		i.trace(s, TraceEvent{Kind: TraceBranch, Statement: "IF " + s.Condition.String(), Branch: branch})
+	}
+}
+
+// traceLoop records a test of a WHILE condition before the iteration'th
+// run of its body, which the loop makes or not.
+func (i *Interpreter) traceLoop(s *ast.WhileStatement, iteration int, loops bool) {
+	if i.ctx.Trace == nil {
+		return
+	}
+	e := TraceEvent{Kind: TraceLoop, Statement: "WHILE " + s.Condition.String(), Branch: "EXIT"}
+	if loops {
+		e.Branch, e.Iteration = "LOOP", iteration
+	}
+	i.trace(s, e)
+}
+
+// traceCatch records the error a CATCH block handles.
+func (i *Interpreter) traceCatch(s *ast.TryCatchStatement, err error) {
+	if i.ctx.Trace != nil {
+		i.trace(s, TraceEvent{Kind: TraceCatch, Statement: "BEGIN CATCH", Error: err.Error()})
+	}
+}
+
+// traceParameters records the parameters an execution was given.
+func (i *Interpreter) traceParameters(params map[string]interface{}) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		i.trace(nil, TraceEvent{Kind: TraceAssign, Variable: name, Value: traceValue(ToValue(params[name]))})
+	}
+}
+
+// traceValue formats a value as a literal.
+func traceValue(v Value) string {
+	switch {
+	case v.IsNull:
+		return "NULL"
+	case v.Type == TypeBinary || v.Type == TypeVarBinary:
+		return fmt.Sprintf("0x%X", v.bytesVal)
+	case v.Type.IsNumeric():
+		return v.AsString()
+	}
+	return "'" + strings.ReplaceAll(v.AsString(), "'", "''") + "'"
+}
+
+// executeTraced runs EXEC ... WITH TRACE, tracing the call into
+// result.Trace. In an execution already traced it is traced as any
+// other call.
+func (i *Interpreter) executeTraced(ctx context.Context, s *ast.ExecStatement, result *ExecutionResult) error {
+	exec := *s
+	exec.Trace = false
+	if i.ctx.Trace != nil {
+		return i.executeExec(ctx, &exec, result)
+	}
+	if result.Trace == nil {
+		result.Trace = NewTrace()
+	}
+	i.ctx.Trace = result.Trace
+	defer func() { i.ctx.Trace = nil }()
+	done := i.traceStatement(&exec)
+	err := i.executeExec(ctx, &exec, result)
+	done(err)
+	return err
+}
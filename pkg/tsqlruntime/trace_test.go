@@ -0,0 +1,160 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// traceLines formats a trace's events for comparison, leaving out their
+// timings.
+func traceLines(trace *Trace) []string {
+	var lines []string
+	for _, e := range trace.Events {
+		line := fmt.Sprintf("%d %s %s", e.Depth, e.Procedure, e.Kind)
+		switch e.Kind {
+		case TraceStatement:
+			line += " " + e.Statement
+			if e.Rows != nil {
+				line += fmt.Sprintf(" rows=%d", *e.Rows)
+			}
+		case TraceAssign:
+			line += " " + e.Variable + "=" + e.Value
+		case TraceBranch, TraceLoop:
+			line += fmt.Sprintf(" %s %d", e.Branch, e.Iteration)
+		}
+		if e.Error != "" {
+			line += " error"
+		}
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	return lines
+}
+
+func TestTrace(t *testing.T) {
+	interp := sysprocSetup(t)
+	resolver := interp.resolver.(*renamingResolver)
+	resolver.AddProcedure("dbo.Restock", `
+		CREATE PROCEDURE dbo.Restock @id INT, @qty INT = 1
+		AS
+		BEGIN
+			IF @qty > 0
+				UPDATE orders SET qty = qty + @qty WHERE id = @id
+			ELSE
+				PRINT 'nothing to do'
+		END
+	`, nil)
+	interp.SetCallChain("batch")
+	interp.SetTrace(NewTrace())
+
+	result, err := interp.Execute(context.Background(), `
+		DECLARE @n INT = 0
+		WHILE @n < 2
+			SET @n = @n + 1
+		EXEC dbo.Restock @id = 1;
+		BEGIN TRY
+			DELETE FROM missing
+		END TRY
+		BEGIN CATCH
+			SET @n = NULL
+		END CATCH
+	`, map[string]interface{}{"@name": "o'brien"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Trace == nil {
+		t.Fatal("no trace")
+	}
+
+	want := []string{
+		"0 batch assign @name='o''brien'",
+		"0 batch statement DECLARE @n INT = 0",
+		"0 batch assign @n=0",
+		"0 batch loop LOOP 1",
+		"0 batch statement SET @n = (@n + 1)",
+		"0 batch assign @n=1",
+		"0 batch loop LOOP 2",
+		"0 batch statement SET @n = (@n + 1)",
+		"0 batch assign @n=2",
+		"0 batch loop EXIT 0",
+		"0 batch statement EXEC dbo.Restock @id = 1",
+		"1 dbo.Restock assign @id=1",
+		"1 dbo.Restock assign @qty=1",
+		"1 dbo.Restock branch THEN 0",
+		"1 dbo.Restock statement UPDATE orders SET qty = (qty + @qty) WHERE (id = @id) rows=1",
+		"0 batch statement DELETE FROM missing error",
+		"0 batch catch error",
+		"0 batch statement SET @n = NULL",
+		"0 batch assign @n=NULL",
+	}
+	got := traceLines(result.Trace)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("trace:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, e := range result.Trace.Events {
+		if e.Kind == TraceStatement && (e.Line == 0 || e.Duration <= 0) {
+			t.Errorf("statement %q has line %d, duration %v", e.Statement, e.Line, e.Duration)
+		}
+	}
+
+	interp.SetTrace(nil)
+	result, err = interp.Execute(context.Background(), "SELECT id FROM orders", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Trace != nil {
+		t.Errorf("traced when off: %+v", result.Trace)
+	}
+}
+
+func TestExecWithTrace(t *testing.T) {
+	interp := sysprocSetup(t)
+	resolver := interp.resolver.(*renamingResolver)
+	resolver.AddProcedure("dbo.Count", `
+		CREATE PROCEDURE dbo.Count @total INT OUTPUT
+		AS
+		BEGIN
+			SELECT @total = COUNT(*) FROM orders
+		END
+	`, nil)
+
+	result, err := interp.Execute(context.Background(), `
+		DECLARE @total INT
+		EXEC dbo.Count @total OUTPUT WITH TRACE
+		SET @total = 0
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Trace == nil {
+		t.Fatal("no trace")
+	}
+	want := []string{
+		"0 statement EXEC dbo.Count @total OUTPUT",
+		"1 dbo.Count assign @total=NULL",
+		"1 dbo.Count statement SELECT @total = COUNT(*) FROM orders rows=1",
+		"1 dbo.Count assign @total=1",
+		"0 assign @total=1",
+	}
+	got := traceLines(result.Trace)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("trace:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTraceLimit(t *testing.T) {
+	interp := sysprocSetup(t)
+	interp.SetTrace(NewTrace())
+	result, err := interp.Execute(context.Background(), `
+		DECLARE @n INT = 0
+		WHILE @n < 5000
+			SET @n = @n + 1
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Trace.Events) != MaxTraceEvents || result.Trace.Dropped == 0 {
+		t.Errorf("%d events, %d dropped", len(result.Trace.Events), result.Trace.Dropped)
+	}
+}
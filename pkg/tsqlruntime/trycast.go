@@ -0,0 +1,178 @@
+package tsqlruntime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// TRY_CAST, TRY_CONVERT and TRY_PARSE return NULL where the conversion
+// would fail. None of the translated backends has an equivalent, and their
+// own CAST either raises (PostgreSQL) or silently yields 0 (SQLite, MySQL),
+// so each dialect wraps the cast in a CASE that validates the value first:
+//
+//	TRY_CAST(x AS INT) -> CASE WHEN <x is a valid INT> THEN CAST(x AS INT) END
+//
+// String literals are converted at rewrite time with the evaluator's own
+// rules instead. Target types that cannot fail, such as strings, become a
+// plain CAST. SQL Server runs the TRY_ forms natively.
+
+// integerRanges gives the bounds of the integer types narrower than BIGINT.
+var integerRanges = map[DataType][2]int64{
+	TypeTinyInt:  {0, 255},
+	TypeSmallInt: {-32768, 32767},
+	TypeInt:      {-2147483648, 2147483647},
+}
+
+// plainCast returns e as a CAST that raises on failure, for conversions
+// the dialect cannot fail or does not validate.
+func plainCast(e *ast.CastExpression) ast.Expression {
+	e.IsTry = false
+	return e
+}
+
+// foldTryCast converts a string literal argument of e to typeName at
+// rewrite time, returning CAST(NULL AS type) if the conversion fails. It
+// returns false if the argument is not a literal.
+func foldTryCast(e *ast.CastExpression, typeName string) (ast.Expression, bool) {
+	lit, ok := e.Expression.(*ast.StringLiteral)
+	if !ok {
+		return nil, false
+	}
+	targetType, precision, scale, maxLen := ParseDataType(typeName)
+	if _, err := Cast(NewVarChar(lit.Value, -1), targetType, precision, scale, maxLen); err != nil {
+		e.Expression = &ast.Identifier{Token: e.Token, Value: "NULL"}
+	}
+	return plainCast(e), true
+}
+
+// castSQL renders CAST(expr AS typ).
+func castSQL(expr, typ string) string {
+	return "CAST(" + expr + " AS " + typ + ")"
+}
+
+// -----------------------------------------------------------------------------
+// SQLite
+// -----------------------------------------------------------------------------
+
+// rewriteTryCast validates text with GLOB patterns before casting it.
+// Integer and real values are cast directly, as SQL Server truncates them.
+func (r *SQLiteRewriter) rewriteTryCast(e *ast.CastExpression, typeName string) ast.Expression {
+	if folded, ok := foldTryCast(e, typeName); ok {
+		return folded
+	}
+	targetType, _, _, _ := ParseDataType(typeName)
+	x := e.Expression.String()
+	typ := e.TargetType.String()
+
+	switch {
+	case targetType.IsDateTime():
+		// date() rolls 2024-02-30 over to March and reads numbers as
+		// Julian days, so the date must also survive unchanged
+		valid := fmt.Sprintf("typeof(%[1]s) = 'text' AND date(%[1]s) = substr(trim(%[1]s), 1, 10)", x)
+		if targetType == TypeTime {
+			valid = fmt.Sprintf("typeof(%[1]s) = 'text' AND time(%[1]s) IS NOT NULL AND %[1]s GLOB '*:*'", x)
+		}
+		return &ast.Identifier{Token: e.Token, Value: fmt.Sprintf(
+			"(CASE WHEN %s THEN %s END)", valid, castSQL(x, typ))}
+	case !targetType.IsNumeric() || targetType == TypeBit:
+		return plainCast(e)
+	}
+
+	// text is the trimmed value, with currency symbols and thousands
+	// separators removed for money; digits is text without its sign
+	text := "trim(CAST(" + x + " AS TEXT))"
+	if targetType == TypeMoney || targetType == TypeSmallMoney {
+		text = "replace(ltrim(" + text + ", '$£€¥'), ',', '')"
+	}
+	digits := fmt.Sprintf("substr(%[1]s, 1 + (substr(%[1]s, 1, 1) IN ('+', '-')))", text)
+
+	var valid string
+	switch {
+	case targetType.IsInteger():
+		valid = fmt.Sprintf("(%[1]s = '' OR (%[2]s <> '' AND %[2]s NOT GLOB '*[^0-9]*'))", text, digits)
+	case targetType == TypeFloat || targetType == TypeReal:
+		valid = fmt.Sprintf("(%[1]s GLOB '*[0-9]*' AND %[1]s NOT GLOB '*[^0-9.eE+-]*' AND %[1]s NOT GLOB '*.*.*' "+
+			"AND %[1]s NOT GLOB '*[eE]*[eE]*' AND %[1]s NOT GLOB '*[eE]' AND %[1]s NOT GLOB '[eE]*')", digits)
+	default:
+		valid = fmt.Sprintf("(%[1]s GLOB '*[0-9]*' AND %[1]s NOT GLOB '*[^0-9.]*' AND %[1]s NOT GLOB '*.*.*')", digits)
+	}
+
+	fromNumber, fromText := castSQL(x, typ), castSQL(text, typ)
+	numberOK, textOK := "typeof("+x+") IN ('integer', 'real')", valid
+	if bounds, ok := integerRanges[targetType]; ok {
+		numberOK += fmt.Sprintf(" AND %s BETWEEN %d AND %d", fromNumber, bounds[0], bounds[1])
+		textOK += fmt.Sprintf(" AND %s BETWEEN %d AND %d", fromText, bounds[0], bounds[1])
+	}
+	return &ast.Identifier{Token: e.Token, Value: fmt.Sprintf(
+		"(CASE WHEN %s THEN %s WHEN %s THEN %s END)", numberOK, fromNumber, textOK, fromText)}
+}
+
+// -----------------------------------------------------------------------------
+// PostgreSQL
+// -----------------------------------------------------------------------------
+
+// postgresNumericTypes are the pg_typeof names of values PostgreSQL casts
+// to a number without parsing text.
+const postgresNumericTypes = "'smallint', 'integer', 'bigint', 'numeric', 'real', 'double precision'"
+
+// rewriteTryCast validates the value's text form with pg_input_is_valid,
+// which needs PostgreSQL 16 or later.
+func (r *PostgresRewriter) rewriteTryCast(e *ast.CastExpression, typeName string) ast.Expression {
+	if folded, ok := foldTryCast(e, typeName); ok {
+		return folded
+	}
+	targetType, _, _, _ := ParseDataType(typeName)
+	x := e.Expression.String()
+	typ := e.TargetType.String()
+
+	var sql strings.Builder
+	sql.WriteString("(CASE")
+	if targetType.IsNumeric() {
+		fmt.Fprintf(&sql, " WHEN CAST(pg_typeof(%s) AS TEXT) IN (%s) THEN %s", x, postgresNumericTypes, castSQL(x, typ))
+	}
+	fmt.Fprintf(&sql, " WHEN pg_input_is_valid(CAST(%s AS TEXT), %s) THEN %s END)", x, sqlString(typ), castSQL(x, typ))
+	return &ast.Identifier{Token: e.Token, Value: sql.String()}
+}
+
+// -----------------------------------------------------------------------------
+// MySQL
+// -----------------------------------------------------------------------------
+
+// rewriteTryCast validates text with REGEXP before casting it. MySQL's
+// CAST already returns NULL for invalid dates.
+func (r *MySQLRewriter) rewriteTryCast(e *ast.CastExpression, typeName string) ast.Expression {
+	if folded, ok := foldTryCast(e, typeName); ok {
+		return folded
+	}
+	targetType, precision, scale, _ := ParseDataType(typeName)
+	x := e.Expression.String()
+
+	var pattern, typ string
+	switch {
+	case !targetType.IsNumeric() || targetType == TypeBit:
+		return plainCast(e)
+	case targetType.IsInteger():
+		pattern, typ = `^[[:space:]]*[-+]?[0-9]+[[:space:]]*$`, "SIGNED"
+	case targetType == TypeFloat || targetType == TypeReal:
+		pattern, typ = `^[[:space:]]*[-+]?([0-9]+[.]?[0-9]*|[.][0-9]+)([eE][-+]?[0-9]+)?[[:space:]]*$`, "DOUBLE"
+	default:
+		switch {
+		case targetType == TypeMoney:
+			precision, scale = 19, 4
+		case targetType == TypeSmallMoney:
+			precision, scale = 10, 4
+		case precision == 0:
+			precision = 18
+		}
+		pattern, typ = `^[[:space:]]*[-+]?([0-9]+[.]?[0-9]*|[.][0-9]+)[[:space:]]*$`, fmt.Sprintf("DECIMAL(%d, %d)", precision, scale)
+	}
+
+	cond := fmt.Sprintf("CAST(%s AS CHAR) REGEXP %s", x, mysqlString(pattern))
+	if bounds, ok := integerRanges[targetType]; ok {
+		cond += fmt.Sprintf(" AND CAST(%s AS SIGNED) BETWEEN %d AND %d", x, bounds[0], bounds[1])
+	}
+	return &ast.Identifier{Token: e.Token, Value: fmt.Sprintf(
+		"(CASE WHEN %s THEN %s END)", cond, castSQL(x, typ))}
+}
@@ -350,6 +350,8 @@ func (v Value) AsString() string {
 		return v.timeVal.Format("15:04:05")
 	case TypeUniqueIdentifier:
 		return v.stringVal
+	case TypeBinary, TypeVarBinary:
+		return fmt.Sprintf("0x%X", v.bytesVal)
 	}
 	return fmt.Sprintf("%v", v)
 }
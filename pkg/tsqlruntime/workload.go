@@ -0,0 +1,388 @@
+package tsqlruntime
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/aul/pkg/tsqlparser/ast"
+)
+
+// The index advisor learns from the workload. For each table a SELECT,
+// UPDATE or DELETE reads, the interpreter works out the query's shape:
+// the columns its predicates compare with =, those they compare with a
+// range, and the other columns it reads. Shapes go to the
+// WorkloadRecorder set with SetWorkload, which recommends indexes for the
+// shapes that recur. Predicates inside subqueries, and queries on temp
+// tables, table variables and catalog views, are not recorded.
+
+// QueryShape is what one query asks of one table. Names are lower case
+// and the table's has no schema.
+type QueryShape struct {
+	Table      string
+	Equality   []string // Compared with =, IN or IS NULL
+	Inequality []string // Compared with <, >, <>, BETWEEN, IS NOT NULL or LIKE 'prefix%'
+	Included   []string // Otherwise read: selected, grouped or sorted on
+}
+
+// Fingerprint identifies the shapes one index would serve: the same table
+// and key columns, in any order.
+func (s QueryShape) Fingerprint() string {
+	eq := append([]string(nil), s.Equality...)
+	ineq := append([]string(nil), s.Inequality...)
+	sort.Strings(eq)
+	sort.Strings(ineq)
+	return s.Table + "|" + strings.Join(eq, ",") + "|" + strings.Join(ineq, ",")
+}
+
+// CoveredBy reports whether an index with the key columns index serves s:
+// it starts with s's equality columns, in any order, followed by one of
+// its inequality columns if it has any.
+func (s QueryShape) CoveredBy(index []string) bool {
+	n := len(s.Equality)
+	if len(index) < n || n+len(s.Inequality) == 0 {
+		return false
+	}
+	for _, col := range index[:n] {
+		if !containsFold(s.Equality, col) {
+			return false
+		}
+	}
+	return len(s.Inequality) == 0 || (len(index) > n && containsFold(s.Inequality, index[n]))
+}
+
+// WorkloadRecorder records the shapes of executed queries.
+type WorkloadRecorder interface {
+	// RecordQuery records that statement, which ran in elapsed, asked
+	// shape of a table.
+	RecordQuery(shape QueryShape, statement string, elapsed time.Duration)
+}
+
+// SetWorkload sets the recorder of this execution's query shapes.
+func (i *Interpreter) SetWorkload(w WorkloadRecorder) {
+	i.ctx.Workload = w
+}
+
+// recordWorkload records the shapes of stmt, which ran in elapsed.
+func (i *Interpreter) recordWorkload(stmt ast.Statement, elapsed time.Duration) {
+	shapes := queryShapes(stmt)
+	if len(shapes) == 0 {
+		return
+	}
+	text := journalText(stmt)
+	for _, shape := range shapes {
+		i.ctx.Workload.RecordQuery(shape, text, elapsed)
+	}
+}
+
+// queryShapes returns the shapes of the queries stmt makes of tables,
+// leaving out those that compare no columns.
+func queryShapes(stmt ast.Statement) []QueryShape {
+	var b shapeBuilder
+	switch s := stmt.(type) {
+	case *ast.SelectStatement:
+		if s.From == nil {
+			return nil
+		}
+		for _, ref := range s.From.Tables {
+			b.bind(ref)
+		}
+		b.predicate(s.Where)
+		for _, col := range s.Columns {
+			if col.Expression != nil {
+				b.columns(col.Expression, b.include)
+			}
+		}
+		for _, e := range s.GroupBy {
+			b.columns(e, b.include)
+		}
+		for _, item := range s.OrderBy {
+			b.columns(item.Expression, b.include)
+		}
+	case *ast.UpdateStatement:
+		b.bindTarget(s.Table, s.Alias, s.From)
+		b.predicate(s.Where)
+	case *ast.DeleteStatement:
+		b.bindTarget(s.Table, s.Alias, s.From)
+		b.predicate(s.Where)
+	default:
+		return nil
+	}
+
+	var shapes []QueryShape
+	for n, shape := range b.shapes {
+		if b.scope[n].table == "" || len(shape.Equality)+len(shape.Inequality) == 0 {
+			continue
+		}
+		var included []string
+		for _, col := range shape.Included {
+			if !containsFold(shape.Equality, col) && !containsFold(shape.Inequality, col) {
+				included = append(included, col)
+			}
+		}
+		shape.Included = included
+		shapes = append(shapes, shape)
+	}
+	return shapes
+}
+
+// shapeBuilder builds the shape of each table a query reads.
+type shapeBuilder struct {
+	scope  []tableBinding
+	shapes []QueryShape // by position in scope
+}
+
+// bind adds the tables ref reads. Joins' conditions are predicates too.
+func (b *shapeBuilder) bind(ref ast.TableReference) {
+	b.scope = bindTables(b.scope, ref)
+	for len(b.shapes) < len(b.scope) {
+		n := len(b.shapes)
+		parts := splitObjectName(b.scope[n].table)
+		table := strings.ToLower(parts[len(parts)-1])
+		for _, part := range parts {
+			if isTransientTable(part) || isCatalogSchema(part) {
+				// Not recorded: remembered only so columns resolve
+				table = ""
+			}
+		}
+		b.scope[n].table = table
+		b.shapes = append(b.shapes, QueryShape{Table: table})
+	}
+	b.joins(ref)
+}
+
+// bindTarget adds the target of an UPDATE or DELETE and the tables of its
+// FROM clause, where the target may appear again under an alias.
+func (b *shapeBuilder) bindTarget(table *ast.QualifiedIdentifier, alias *ast.Identifier, from *ast.FromClause) {
+	if table == nil {
+		return
+	}
+	if from != nil {
+		for _, ref := range from.Tables {
+			b.bind(ref)
+		}
+		for _, binding := range b.scope {
+			if strings.EqualFold(binding.name, table.String()) || strings.EqualFold(binding.name, lastPart(table.String())) {
+				return
+			}
+		}
+	}
+	b.bind(&ast.TableName{Name: table, Alias: alias})
+}
+
+// joins adds the join conditions in ref as predicates.
+func (b *shapeBuilder) joins(ref ast.TableReference) {
+	switch t := ref.(type) {
+	case *ast.JoinClause:
+		b.joins(t.Left)
+		b.joins(t.Right)
+		b.predicate(t.Condition)
+	case *ast.ParenthesizedTableRef:
+		b.joins(t.Inner)
+	}
+}
+
+// predicate adds the columns e compares, looking into ANDs; a column
+// compared with an expression over its own table does not count.
+func (b *shapeBuilder) predicate(e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		switch op := strings.ToUpper(e.Operator); op {
+		case "AND":
+			b.predicate(e.Left)
+			b.predicate(e.Right)
+		case "=", "<", ">", "<=", ">=", "<>", "!=":
+			add := b.inequality
+			if op == "=" {
+				add = b.equality
+			}
+			b.compare(e.Left, e.Right, add)
+			b.compare(e.Right, e.Left, add)
+		}
+	case *ast.BetweenExpression:
+		if !e.Not {
+			b.compare(e.Expr, &ast.InfixExpression{Left: e.Low, Right: e.High}, b.inequality)
+		}
+	case *ast.InExpression:
+		if !e.Not {
+			b.compare(e.Expr, nil, b.equality)
+		}
+	case *ast.IsNullExpression:
+		if e.Not {
+			b.compare(e.Expr, nil, b.inequality)
+		} else {
+			b.compare(e.Expr, nil, b.equality)
+		}
+	case *ast.LikeExpression:
+		if p, ok := e.Pattern.(*ast.StringLiteral); ok && !e.Not && p.Value != "" && !strings.ContainsAny(p.Value[:1], "%_[") {
+			b.compare(e.Expr, nil, b.inequality)
+		}
+	}
+}
+
+// compare adds column, if it is one, with add unless other reads its
+// table.
+func (b *shapeBuilder) compare(column, other ast.Expression, add func(int, string)) {
+	n, col, ok := b.column(column)
+	if !ok {
+		return
+	}
+	own := false
+	if other != nil {
+		b.columns(other, func(m int, _ string) { own = own || m == n })
+	}
+	if !own {
+		add(n, col)
+	}
+}
+
+func (b *shapeBuilder) equality(n int, col string) {
+	b.shapes[n].Equality = appendColumn(b.shapes[n].Equality, col)
+}
+
+func (b *shapeBuilder) inequality(n int, col string) {
+	b.shapes[n].Inequality = appendColumn(b.shapes[n].Inequality, col)
+}
+
+func (b *shapeBuilder) include(n int, col string) {
+	b.shapes[n].Included = appendColumn(b.shapes[n].Included, col)
+}
+
+// column resolves a column reference to the table it belongs to: the one
+// its qualifier names, or the only table when it has none.
+func (b *shapeBuilder) column(e ast.Expression) (int, string, bool) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		if len(b.scope) == 1 && !strings.HasPrefix(e.Value, "@") {
+			return 0, strings.ToLower(strings.Trim(e.Value, "[]")), true
+		}
+	case *ast.QualifiedIdentifier:
+		parts := splitObjectName(e.String())
+		if len(parts) < 2 {
+			break
+		}
+		qualifier := parts[len(parts)-2]
+		for n, binding := range b.scope {
+			if strings.EqualFold(binding.name, qualifier) {
+				return n, strings.ToLower(parts[len(parts)-1]), true
+			}
+		}
+	}
+	return 0, "", false
+}
+
+// columns calls fn with each column e reads, outside subqueries.
+func (b *shapeBuilder) columns(e ast.Expression, fn func(int, string)) {
+	if n, col, ok := b.column(e); ok {
+		fn(n, col)
+		return
+	}
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		b.columns(e.Left, fn)
+		b.columns(e.Right, fn)
+	case *ast.PrefixExpression:
+		b.columns(e.Right, fn)
+	case *ast.FunctionCall:
+		for _, arg := range e.Arguments {
+			b.columns(arg, fn)
+		}
+	case *ast.CastExpression:
+		b.columns(e.Expression, fn)
+	case *ast.CaseExpression:
+		b.columns(e.Operand, fn)
+		for _, w := range e.WhenClauses {
+			b.columns(w.Condition, fn)
+			b.columns(w.Result, fn)
+		}
+		b.columns(e.ElseClause, fn)
+	case *ast.BetweenExpression:
+		b.columns(e.Expr, fn)
+		b.columns(e.Low, fn)
+		b.columns(e.High, fn)
+	case *ast.InExpression:
+		b.columns(e.Expr, fn)
+		for _, v := range e.Values {
+			b.columns(v, fn)
+		}
+	case *ast.LikeExpression:
+		b.columns(e.Expr, fn)
+		b.columns(e.Pattern, fn)
+	case *ast.IsNullExpression:
+		b.columns(e.Expr, fn)
+	}
+}
+
+// appendColumn adds col to cols unless it is there already.
+func appendColumn(cols []string, col string) []string {
+	if containsFold(cols, col) {
+		return cols
+	}
+	return append(cols, col)
+}
+
+// lastPart returns the last part of a multi-part name, unbracketed.
+func lastPart(name string) string {
+	parts := splitObjectName(name)
+	return parts[len(parts)-1]
+}
+
+// TableIndexes returns the key columns of each index of table, a table of
+// the SQLite database db, its primary key first. Columns of expression
+// indexes are left out. It fails if there is no such table.
+func TableIndexes(ctx context.Context, db QueryExecutor, table string) ([][]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, pk FROM pragma_table_info(?) ORDER BY pk", table)
+	if err != nil {
+		return nil, err
+	}
+	var pk []string
+	found := false
+	for rows.Next() {
+		var name string
+		var key int
+		if err := rows.Scan(&name, &key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found = true
+		if key > 0 {
+			pk = append(pk, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no such table: %s", table)
+	}
+
+	var indexes [][]string
+	if len(pk) > 0 {
+		indexes = append(indexes, pk)
+	}
+	rows, err = db.QueryContext(ctx, "SELECT il.name, ii.name FROM pragma_index_list(?) AS il"+
+		" JOIN pragma_index_info(il.name) AS ii ORDER BY il.seq, ii.seqno", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	last := ""
+	for rows.Next() {
+		var index string
+		var col *string
+		if err := rows.Scan(&index, &col); err != nil {
+			return nil, err
+		}
+		if index != last {
+			indexes = append(indexes, nil)
+			last = index
+		}
+		if col != nil {
+			indexes[len(indexes)-1] = append(indexes[len(indexes)-1], *col)
+		}
+	}
+	return indexes, rows.Err()
+}
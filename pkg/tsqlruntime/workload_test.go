@@ -0,0 +1,133 @@
+package tsqlruntime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryShapes(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []QueryShape
+	}{
+		{
+			"SELECT id, qty FROM dbo.Orders WHERE CustomerID = @c AND OrderDate >= '2024-01-01' ORDER BY Placed",
+			[]QueryShape{{Table: "orders", Equality: []string{"customerid"}, Inequality: []string{"orderdate"}, Included: []string{"id", "qty", "placed"}}},
+		},
+		{
+			"SELECT o.id, c.name FROM orders o JOIN customers c ON c.id = o.customer_id WHERE o.status IN ('open', 'held') AND c.region LIKE 'EU%'",
+			[]QueryShape{
+				{Table: "orders", Equality: []string{"customer_id", "status"}, Included: []string{"id"}},
+				{Table: "customers", Equality: []string{"id"}, Inequality: []string{"region"}, Included: []string{"name"}},
+			},
+		},
+		{
+			"UPDATE orders SET qty = 0 WHERE shipped IS NULL AND qty BETWEEN 1 AND 5",
+			[]QueryShape{{Table: "orders", Equality: []string{"shipped"}, Inequality: []string{"qty"}}},
+		},
+		{
+			"DELETE FROM orders WHERE id <> 3 AND note LIKE '%x'",
+			[]QueryShape{{Table: "orders", Inequality: []string{"id"}}},
+		},
+		// Nothing a seek could use
+		{"SELECT * FROM orders", nil},
+		{"SELECT id FROM orders WHERE qty = qty + 1 OR id = 2", nil},
+		{"SELECT id FROM #work WHERE id = 1", nil},
+		{"SELECT name FROM sys.tables WHERE name = 'x'", nil},
+	}
+	for _, tc := range tests {
+		if got := queryShapes(parseSQL(t, tc.sql)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s\n got  %+v\n want %+v", tc.sql, got, tc.want)
+		}
+	}
+}
+
+func TestQueryShapeCoveredBy(t *testing.T) {
+	shape := QueryShape{Table: "orders", Equality: []string{"a", "b"}, Inequality: []string{"d"}}
+	tests := []struct {
+		index []string
+		want  bool
+	}{
+		{[]string{"b", "a", "d"}, true},
+		{[]string{"a", "b", "d", "e"}, true},
+		{[]string{"a", "b"}, false},
+		{[]string{"a", "d", "b"}, false},
+		{[]string{"a"}, false},
+	}
+	for _, tc := range tests {
+		if got := shape.CoveredBy(tc.index); got != tc.want {
+			t.Errorf("CoveredBy(%v) = %v", tc.index, got)
+		}
+	}
+	if !(QueryShape{Equality: []string{"a"}}).CoveredBy([]string{"A", "b"}) {
+		t.Error("equality-only shape not covered by a longer index")
+	}
+	if shape.Fingerprint() != (QueryShape{Table: "orders", Equality: []string{"b", "a"}, Inequality: []string{"d"}}).Fingerprint() {
+		t.Error("fingerprint depends on column order")
+	}
+}
+
+// fakeWorkload records query shapes.
+type fakeWorkload struct {
+	shapes     []QueryShape
+	statements []string
+}
+
+func (w *fakeWorkload) RecordQuery(shape QueryShape, statement string, elapsed time.Duration) {
+	w.shapes = append(w.shapes, shape)
+	w.statements = append(w.statements, statement)
+}
+
+func TestRecordWorkload(t *testing.T) {
+	interp := sysprocSetup(t)
+	workload := &fakeWorkload{}
+	interp.SetWorkload(workload)
+
+	_, err := interp.Execute(context.Background(), `
+		DECLARE @n INT = 0
+		WHILE @n < 2
+		BEGIN
+			SELECT qty FROM orders WHERE id = @n
+			SET @n = @n + 1
+		END
+		SELECT qty FROM missing WHERE id = 1
+	`, nil)
+	if err == nil {
+		t.Fatal("query of a missing table succeeded")
+	}
+	// Failed queries are not recorded
+	if len(workload.shapes) != 2 {
+		t.Fatalf("recorded %+v", workload.shapes)
+	}
+	want := QueryShape{Table: "orders", Equality: []string{"id"}, Included: []string{"qty"}}
+	if !reflect.DeepEqual(workload.shapes[0], want) || workload.statements[0] == "" {
+		t.Errorf("recorded %+v for %q", workload.shapes[0], workload.statements[0])
+	}
+}
+
+func TestTableIndexes(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.Exec(`CREATE TABLE orders (id INTEGER PRIMARY KEY, customer INTEGER, placed TEXT);
+		CREATE INDEX ix_customer ON orders (customer, placed);
+		CREATE INDEX ix_expr ON orders (lower(placed))`); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := TableIndexes(ctx, db, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The primary key, then the newest index first as SQLite lists them;
+	// the expression index has no columns
+	want := [][]string{{"id"}, nil, {"customer", "placed"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q", got)
+	}
+	if _, err := TableIndexes(ctx, db, "missing"); err == nil {
+		t.Error("no error for a missing table")
+	}
+}